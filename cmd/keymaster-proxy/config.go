@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+const defaultCacheRefreshSecs = 300
+
+var defaultCachedPaths = []string{"/public/x509ca", "/public/clientConfig"}
+
+type configurationType struct {
+	HttpAddress          string   `yaml:"http_address"`
+	TLSCertFilename      string   `yaml:"tls_cert_filename"`
+	TLSKeyFilename       string   `yaml:"tls_key_filename"`
+	CentralURLs          []string `yaml:"central_urls"`
+	CentralRootCAFile    string   `yaml:"central_root_ca_filename"`
+	ProxyID              string   `yaml:"proxy_id"`
+	SharedSecretFilename string   `yaml:"shared_secret_filename"`
+	CachedPaths          []string `yaml:"cached_paths"`
+	CacheRefreshSecs     int      `yaml:"cache_refresh_secs"`
+	sharedSecret         []byte
+}
+
+func loadConfig(filename string) (*configurationType, error) {
+	rawConfig, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	config := &configurationType{
+		HttpAddress: ":443",
+	}
+	if err := yaml.Unmarshal(rawConfig, config); err != nil {
+		return nil, err
+	}
+	if len(config.CentralURLs) < 1 {
+		return nil, errors.New("no central_urls configured")
+	}
+	if config.ProxyID == "" {
+		return nil, errors.New("no proxy_id configured")
+	}
+	secret, err := ioutil.ReadFile(config.SharedSecretFilename)
+	if err != nil {
+		return nil, err
+	}
+	config.sharedSecret = bytes.TrimSpace(secret)
+	if len(config.sharedSecret) < 16 {
+		return nil, errors.New("shared secret is too short")
+	}
+	if config.CachedPaths == nil {
+		config.CachedPaths = defaultCachedPaths
+	}
+	if config.CacheRefreshSecs < 1 {
+		config.CacheRefreshSecs = defaultCacheRefreshSecs
+	}
+	return config, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log/serverlogger"
+	"github.com/Symantec/tricorder/go/tricorder"
+)
+
+var (
+	Version    = "No version provided"
+	configFile = flag.String("configFile", "/etc/keymaster-proxy/config.yml",
+		"Configuration file")
+)
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of %s (version %s):\n", os.Args[0], Version)
+	flag.PrintDefaults()
+}
+
+func getCentralClient(rootCAFilename string) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if rootCAFilename != "" {
+		caData, err := ioutil.ReadFile(rootCAFilename)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caData) {
+			return nil, errors.New("cannot append central root CA data")
+		}
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			Proxy:           http.ProxyFromEnvironment,
+		},
+		// Do not follow redirects, the client needs to see them.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: 30 * time.Second,
+	}, nil
+}
+
+func main() {
+	flag.Usage = Usage
+	flag.Parse()
+	tricorder.RegisterFlags()
+	logger := serverlogger.NewWithFlags("", log.LstdFlags)
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		logger.Fatalf("Cannot load configuration: %s\n", err)
+	}
+	client, err := getCentralClient(config.CentralRootCAFile)
+	if err != nil {
+		logger.Fatalf("Cannot setup central client: %s\n", err)
+	}
+	proxy := newProxy(config, client, logger)
+	go proxy.refreshCacheLoop(
+		time.Duration(config.CacheRefreshSecs) * time.Second)
+	server := &http.Server{
+		Addr:    config.HttpAddress,
+		Handler: proxy,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 35 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+	err = server.ListenAndServeTLS(config.TLSCertFilename,
+		config.TLSKeyFilename)
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/proxysign"
+)
+
+// Headers that only apply to a single connection and must not be forwarded.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type cachedResponse struct {
+	header    http.Header
+	body      []byte
+	fetchedAt time.Time
+}
+
+type proxyType struct {
+	config *configurationType
+	client *http.Client
+	logger log.DebugLogger
+	mutex  sync.Mutex
+	// Protected by lock.
+	nextCentral int
+	cache       map[string]cachedResponse
+}
+
+func newProxy(config *configurationType, client *http.Client,
+	logger log.DebugLogger) *proxyType {
+	return &proxyType{
+		config: config,
+		client: client,
+		logger: logger,
+		cache:  make(map[string]cachedResponse),
+	}
+}
+
+func copyHeader(dst, src http.Header) {
+	for key, values := range src {
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+	for _, key := range hopByHopHeaders {
+		dst.Del(key)
+	}
+}
+
+func (p *proxyType) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		p.mutex.Lock()
+		cached, ok := p.cache[r.URL.Path]
+		p.mutex.Unlock()
+		if ok {
+			copyHeader(w.Header(), cached.header)
+			w.WriteHeader(http.StatusOK)
+			w.Write(cached.body)
+			return
+		}
+	}
+	p.forward(w, r)
+}
+
+func (p *proxyType) forward(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body,
+		proxysign.MaxBodySize))
+	if err != nil {
+		http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	resp, err := p.doCentralRequest(r.Method, r.URL.RequestURI(), r.Header,
+		body, r.RemoteAddr)
+	if err != nil {
+		p.logger.Printf("Cannot forward %s %s: %s", r.Method, r.URL.Path, err)
+		http.Error(w, "Cannot reach keymaster", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	copyHeader(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// doCentralRequest sends a signed request to the central keymaster servers,
+// trying each of them in turn until one answers.
+func (p *proxyType) doCentralRequest(method, requestURI string,
+	header http.Header, body []byte, remoteAddr string) (*http.Response, error) {
+	p.mutex.Lock()
+	start := p.nextCentral
+	p.nextCentral = (p.nextCentral + 1) % len(p.config.CentralURLs)
+	p.mutex.Unlock()
+	var lastErr error
+	for i := range p.config.CentralURLs {
+		baseURL := p.config.CentralURLs[(start+i)%len(p.config.CentralURLs)]
+		req, err := http.NewRequest(method,
+			strings.TrimRight(baseURL, "/")+requestURI, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		copyHeader(req.Header, header)
+		if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+			if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+				host = prior + ", " + host
+			}
+			req.Header.Set("X-Forwarded-For", host)
+		}
+		err = proxysign.SignRequest(req, p.config.ProxyID,
+			p.config.sharedSecret, body)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			p.logger.Debugf(1, "central %s failed: %s", baseURL, err)
+			lastErr = err
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+func (p *proxyType) refreshCachedPath(path string) error {
+	resp, err := p.doCentralRequest("GET", path, http.Header{}, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	header := make(http.Header)
+	copyHeader(header, resp.Header)
+	header.Del("Set-Cookie")
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.cache[path] = cachedResponse{
+		header:    header,
+		body:      body,
+		fetchedAt: time.Now(),
+	}
+	return nil
+}
+
+// refreshCache refreshes the cached trust bundles. On failure the previously
+// cached copy keeps being served.
+func (p *proxyType) refreshCache() {
+	for _, path := range p.config.CachedPaths {
+		if err := p.refreshCachedPath(path); err != nil {
+			p.logger.Printf("Cannot refresh cached %s: %s", path, err)
+		}
+	}
+}
+
+func (p *proxyType) refreshCacheLoop(interval time.Duration) {
+	for {
+		p.refreshCache()
+		time.Sleep(interval)
+	}
+}
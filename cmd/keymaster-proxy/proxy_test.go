@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+	"github.com/Symantec/keymaster/lib/proxysign"
+)
+
+const testSecret = "0123456789abcdef"
+
+func TestProxyForwardAndCache(t *testing.T) {
+	caRequests := 0
+	verifier := proxysign.NewVerifier(
+		map[string][]byte{"site1": []byte(testSecret)}, time.Minute)
+	central := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			identity, err := verifier.Verify(r)
+			if err != nil || identity.ProxyID != "site1" {
+				http.Error(w, "bad signature", http.StatusUnauthorized)
+				return
+			}
+			switch r.URL.Path {
+			case "/public/x509ca":
+				caRequests++
+				w.Write([]byte("CA"))
+			default:
+				body, _ := ioutil.ReadAll(r.Body)
+				w.Write([]byte(r.URL.RequestURI() + " " + string(body)))
+			}
+		}))
+	defer central.Close()
+	config := &configurationType{
+		CentralURLs:  []string{"https://127.0.0.1:1", central.URL},
+		ProxyID:      "site1",
+		sharedSecret: []byte(testSecret),
+		CachedPaths:  []string{"/public/x509ca"},
+	}
+	proxy := newProxy(config, central.Client(), testlogger.New(t))
+	proxy.refreshCache()
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/public/x509ca", nil)
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || rr.Body.String() != "CA" {
+			t.Fatalf("unexpected response %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	if caRequests != 1 {
+		t.Fatalf("CA should have been served from cache, got %d requests",
+			caRequests)
+	}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/certgen/user?type=ssh",
+			strings.NewReader("key"))
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK ||
+			rr.Body.String() != "/certgen/user?type=ssh key" {
+			t.Fatalf("unexpected response %d %s", rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/Symantec/keymaster/lib/cafingerprint"
+	"golang.org/x/crypto/ssh"
+)
+
+var lookupCAFingerprints = cafingerprint.Lookup
+
+// verifySSHCertIssuer checks that the CA which signed sshCert, an
+// authorized_keys line, is published in the TXT records of dnsName, so that
+// certificates from a rogue CA are not installed.
+func verifySSHCertIssuer(dnsName string, sshCert []byte) error {
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(sshCert)
+	if err != nil {
+		return err
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		return errors.New("not an SSH certificate")
+	}
+	records, err := lookupCAFingerprints(dnsName)
+	if err != nil {
+		return err
+	}
+	return cafingerprint.Verify(records, []ssh.PublicKey{cert.SignatureKey},
+		nil)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/cafingerprint"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCADNS(t *testing.T) {
+	env := newDoctorTestEnv(t)
+	defer env.close()
+	env.caDNSName = "_keymaster-ca.example.com"
+	record, err := cafingerprint.SSHRecord(env.caSigner.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []string
+	lookupCAFingerprints = func(name string) ([]string, error) {
+		if name != env.caDNSName {
+			t.Errorf("looked up %s", name)
+		}
+		return records, nil
+	}
+	defer func() { lookupCAFingerprints = cafingerprint.Lookup }()
+	_, output := runTestDoctor(env)
+	if !strings.Contains(output, "FAIL CA DNS:") {
+		t.Errorf("missing fingerprint not reported:\n%s", output)
+	}
+	records = []string{record}
+	_, output = runTestDoctor(env)
+	if !strings.Contains(output, "OK   CA DNS:") {
+		t.Errorf("fingerprint not matched:\n%s", output)
+	}
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := ssh.MarshalAuthorizedKey(env.signCert(t, sshPublic, ssh.UserCert,
+		"alice", 1, time.Hour))
+	if err := verifySSHCertIssuer(env.caDNSName, cert); err != nil {
+		t.Error(err)
+	}
+	records = nil
+	if err := verifySSHCertIssuer(env.caDNSName, cert); err == nil {
+		t.Error("certificate of unpublished CA accepted")
+	}
+}
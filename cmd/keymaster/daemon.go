@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/client/config"
+	"github.com/Symantec/keymaster/lib/client/desktopnotify"
+	"github.com/Symantec/keymaster/lib/client/twofa"
+	"github.com/Symantec/keymaster/lib/client/util"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Certificates are renewed once this fraction of their lifetime is used.
+	renewalLifetimeFraction = 0.8
+	renewalRetryInterval    = 5 * time.Minute
+	// Sleep in short steps and compare wall clock time so that renewals
+	// are not delayed after the machine wakes up from suspend.
+	daemonPollInterval = time.Minute
+	// Users are warned when a certificate which could not be renewed
+	// expires within this time.
+	expiryWarningTime = 15 * time.Minute
+)
+
+// certGetter obtains certificates from the keymaster servers. Once logged in
+// it renews using the existing session, logging in again when the session
+// has expired. If cachePassword is set the password is kept in memory so
+// that logging in again does not prompt for it.
+type certGetter struct {
+	userName      string
+	targetURLs    []string
+	addGroups     bool
+	client        *http.Client
+	cachePassword bool
+	logger        log.DebugLogger
+	password      []byte
+	haveSession   bool
+	// If set, beforeLogin is called when the session has expired, before
+	// logging in again, which may need the second factor of the user.
+	beforeLogin func()
+}
+
+func newCertGetter(userName string, configContents config.AppConfigFile,
+	client *http.Client, cachePassword bool,
+	logger log.DebugLogger) *certGetter {
+	return &certGetter{
+		userName:      userName,
+		targetURLs:    strings.Split(configContents.Base.Gen_Cert_URLS, ","),
+		addGroups:     configContents.Base.AddGroups,
+		client:        client,
+		cachePassword: cachePassword,
+		logger:        logger,
+	}
+}
+
+func (g *certGetter) getCerts(signer crypto.Signer) (
+	sshCert []byte, x509Cert []byte, kubernetesCert []byte, err error) {
+	if g.haveSession {
+		sshCert, x509Cert, kubernetesCert, err = twofa.GetCertsUsingSession(
+			signer, g.userName, g.targetURLs, g.addGroups, g.client,
+			userAgentString, g.logger)
+		if err == nil {
+			return sshCert, x509Cert, kubernetesCert, nil
+		}
+		g.logger.Printf("Cannot renew using session, logging in again: %s",
+			err)
+		if g.beforeLogin != nil {
+			g.beforeLogin()
+		}
+	}
+	password := g.password
+	if password == nil {
+		password, err = util.GetUserCreds(g.userName)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if g.cachePassword {
+			g.password = password
+		}
+	}
+	sshCert, x509Cert, kubernetesCert, err = twofa.GetCertFromTargetUrls(
+		signer,
+		g.userName,
+		password,
+		g.targetURLs,
+		false,
+		g.addGroups,
+		g.client,
+		userAgentString,
+		g.logger)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	g.haveSession = true
+	return sshCert, x509Cert, kubernetesCert, nil
+}
+
+// getCertValidity returns the validity period of the SSH certificate
+// sshCert.
+func getCertValidity(sshCert []byte) (time.Time, time.Time, error) {
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(sshCert)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		return time.Time{}, time.Time{}, errors.New("not an SSH certificate")
+	}
+	if cert.ValidBefore == ssh.CertTimeInfinity ||
+		cert.ValidBefore <= cert.ValidAfter {
+		return time.Time{}, time.Time{},
+			errors.New("certificate has no usable validity")
+	}
+	return time.Unix(int64(cert.ValidAfter), 0),
+		time.Unix(int64(cert.ValidBefore), 0), nil
+}
+
+// getRenewalTime returns when the SSH certificate sshCert should be renewed.
+func getRenewalTime(sshCert []byte) (time.Time, error) {
+	validAfter, validBefore, err := getCertValidity(sshCert)
+	if err != nil {
+		return time.Time{}, err
+	}
+	lifetime := validBefore.Sub(validAfter)
+	return validAfter.Add(
+		time.Duration(float64(lifetime) * renewalLifetimeFraction)), nil
+}
+
+// daemonNotifier tells the user about renewals which need them. Clicking the
+// notifications opens the web UI of the keymaster server, where
+// certificates can be requested from the browser.
+type daemonNotifier struct {
+	disabled bool
+	link     string
+	notify   func(desktopnotify.Notification) error
+	logger   log.DebugLogger
+	failed   bool
+}
+
+func newDaemonNotifier(configContents config.AppConfigFile,
+	logger log.DebugLogger) *daemonNotifier {
+	baseURL := strings.Split(configContents.Base.Gen_Cert_URLS, ",")[0]
+	return &daemonNotifier{
+		disabled: configContents.Base.DisableDesktopNotifications,
+		link:     strings.TrimSuffix(baseURL, "/") + "/",
+		notify:   desktopnotify.Notify,
+		logger:   logger,
+	}
+}
+
+func (n *daemonNotifier) show(title, message string) {
+	if n.disabled {
+		return
+	}
+	err := n.notify(desktopnotify.Notification{Title: title,
+		Message: message, Link: n.link})
+	// Keep the log readable where notifications never work, e.g. without
+	// a desktop session.
+	if err != nil && !n.failed {
+		n.logger.Printf("Cannot show desktop notification: %s", err)
+		n.failed = true
+	}
+}
+
+func (n *daemonNotifier) loginNeeded() {
+	n.show("Keymaster needs you to sign in again",
+		"Your session has expired. Renewing your certificates needs your "+
+			"second factor in the terminal running keymaster, or sign in "+
+			"from the browser.")
+}
+
+func (n *daemonNotifier) expiring(expiry time.Time, err error) {
+	n.show("Keymaster certificate expires at "+expiry.Format("15:04"),
+		"Renewal failed: "+err.Error()+". SSH connections will fail once "+
+			"the certificate has expired.")
+}
+
+func sleepUntil(deadline time.Time) {
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return
+		}
+		if remaining > daemonPollInterval {
+			remaining = daemonPollInterval
+		}
+		time.Sleep(remaining)
+	}
+}
+
+// runDaemon installs certificates and keeps renewing them before they
+// expire. Renewals that fail are retried until they succeed.
+func runDaemon(userName string, homeDir string,
+	configContents config.AppConfigFile, client *http.Client,
+	logger log.DebugLogger) {
+	getter := newCertGetter(userName, configContents, client, true, logger)
+	sshCert, err := installCerts(homeDir, configContents, getter, client,
+		logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	notifier := newDaemonNotifier(configContents, logger)
+	// Only renewals happen in the background, the initial login prompts in
+	// the terminal.
+	getter.beforeLogin = notifier.loginNeeded
+	for {
+		renewalTime, err := getRenewalTime(sshCert)
+		if err != nil {
+			logger.Fatalf("Cannot determine certificate expiry: %s", err)
+		}
+		logger.Printf("Renewing certificates at %s",
+			renewalTime.Format(time.RFC3339))
+		_, expiry, _ := getCertValidity(sshCert)
+		sleepUntil(renewalTime)
+		warned := false
+		for {
+			newCert, err := installCerts(homeDir, configContents, getter,
+				client, logger)
+			if err == nil {
+				sshCert = newCert
+				break
+			}
+			if !warned && time.Until(expiry) < expiryWarningTime {
+				notifier.expiring(expiry, err)
+				warned = true
+			}
+			logger.Printf("Renewal failed, retrying in %s: %s",
+				renewalRetryInterval, err)
+			sleepUntil(time.Now().Add(renewalRetryInterval))
+		}
+	}
+}
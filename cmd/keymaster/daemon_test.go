@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+	"github.com/Symantec/keymaster/lib/client/config"
+	"github.com/Symantec/keymaster/lib/client/desktopnotify"
+	"github.com/Symantec/keymaster/lib/client/util"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGetRenewalTime(t *testing.T) {
+	key, err := util.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validAfter := time.Now().Truncate(time.Second)
+	cert := &ssh.Certificate{
+		Key:         signer.PublicKey(),
+		CertType:    ssh.UserCert,
+		ValidAfter:  uint64(validAfter.Unix()),
+		ValidBefore: uint64(validAfter.Add(10 * time.Hour).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		t.Fatal(err)
+	}
+	renewalTime, err := getRenewalTime(ssh.MarshalAuthorizedKey(cert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !renewalTime.Equal(validAfter.Add(8 * time.Hour)) {
+		t.Fatalf("unexpected renewal time %s", renewalTime)
+	}
+	if _, err := getRenewalTime(ssh.MarshalAuthorizedKey(signer.PublicKey())); err == nil {
+		t.Fatal("plain public key should have been rejected")
+	}
+}
+
+func TestDaemonNotifier(t *testing.T) {
+	var configContents config.AppConfigFile
+	configContents.Base.Gen_Cert_URLS = "https://km1.example.com:443,https://km2.example.com:443"
+	notifier := newDaemonNotifier(configContents, testlogger.New(t))
+	var shown []desktopnotify.Notification
+	notifier.notify = func(notification desktopnotify.Notification) error {
+		shown = append(shown, notification)
+		return desktopnotify.ErrUnsupported
+	}
+	notifier.loginNeeded()
+	notifier.expiring(time.Now(), errors.New("connection refused"))
+	if len(shown) != 2 || shown[0].Link != "https://km1.example.com:443/" {
+		t.Fatalf("unexpected notifications: %+v", shown)
+	}
+	if !notifier.failed {
+		t.Error("failure not remembered")
+	}
+	notifier.disabled = true
+	notifier.loginNeeded()
+	if len(shown) != 2 {
+		t.Error("notification shown while disabled")
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/cafingerprint"
+	"github.com/Symantec/keymaster/lib/client/sshagent"
+	"github.com/Symantec/keymaster/lib/revocationcheck"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const doctorCommand = "doctor"
+
+const (
+	doctorWarnClockSkew = 30 * time.Second
+	doctorFailClockSkew = 2 * time.Minute
+	doctorSSHTimeout    = 10 * time.Second
+	doctorMaxCAKeysSize = 1 << 20
+)
+
+const (
+	doctorOK   = "OK"
+	doctorWarn = "WARN"
+	doctorFail = "FAIL"
+)
+
+type doctorResult struct {
+	status      string
+	name        string
+	message     string
+	remediation string
+}
+
+// doctor diagnoses the usual reasons for "keymaster does not work": the
+// clock, the installed certificate, and hosts which do not trust the CA.
+// It only reads local files and the public endpoints of the server.
+type doctor struct {
+	userName   string
+	baseURL    string
+	sshKeyPath string
+	knownHosts []string
+	caDNSName  string
+	client     *http.Client
+	now        func() time.Time
+	results    []doctorResult
+	caKeys     []ssh.PublicKey
+	cert       *ssh.Certificate
+}
+
+func (d *doctor) add(status, name, remediation, format string,
+	args ...interface{}) {
+	d.results = append(d.results, doctorResult{
+		status:      status,
+		name:        name,
+		message:     fmt.Sprintf(format, args...),
+		remediation: remediation,
+	})
+}
+
+func (d *doctor) failed() bool {
+	for _, result := range d.results {
+		if result.status == doctorFail {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *doctor) write(w io.Writer) {
+	for _, result := range d.results {
+		fmt.Fprintf(w, "%-4s %s: %s\n", result.status, result.name,
+			result.message)
+		if result.remediation != "" {
+			fmt.Fprintf(w, "     fix: %s\n", result.remediation)
+		}
+	}
+}
+
+// checkServer fetches the CA keys and compares the Date of the response
+// with the local clock.
+func (d *doctor) checkServer() {
+	caURL := d.baseURL + "/public/ca.pub"
+	sent := d.now()
+	resp, err := d.client.Get(caURL)
+	if err != nil {
+		d.add(doctorFail, "server", "Check the network connection (and VPN) "+
+			"and gen_cert_urls in the configuration.", "%s", err)
+		return
+	}
+	defer resp.Body.Close()
+	received := d.now()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, doctorMaxCAKeysSize))
+	if err != nil {
+		d.add(doctorFail, "server", "", "%s: %s", caURL, err)
+		return
+	}
+	d.add(doctorOK, "server", "", "%s reachable", d.baseURL)
+	if serverTime, err := http.ParseTime(resp.Header.Get("Date")); err != nil {
+		d.add(doctorWarn, "clock", "", "server sent no usable Date")
+	} else {
+		skew := sent.Add(received.Sub(sent) / 2).Sub(serverTime)
+		if skew < 0 {
+			skew = -skew
+		}
+		// Date has a resolution of a second.
+		skew = skew.Truncate(time.Second)
+		status := doctorOK
+		remediation := ""
+		if skew >= doctorWarnClockSkew {
+			status = doctorWarn
+			remediation = "Enable time synchronisation (NTP) on this " +
+				"machine; certificates are not valid yet or expire early " +
+				"on a wrong clock."
+		}
+		if skew >= doctorFailClockSkew {
+			status = doctorFail
+		}
+		d.add(status, "clock", remediation, "%s off the server", skew)
+	}
+	if resp.StatusCode != http.StatusOK {
+		d.add(doctorWarn, "CA keys", "Upgrade keymasterd to publish "+
+			"/public/ca.pub.", "%s: %s", caURL, resp.Status)
+		return
+	}
+	for rest := body; len(bytes.TrimSpace(rest)) > 0; {
+		var key ssh.PublicKey
+		key, _, _, rest, err = ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			break
+		}
+		d.caKeys = append(d.caKeys, key)
+	}
+	if len(d.caKeys) < 1 {
+		d.add(doctorFail, "CA keys", "", "%s has no keys", caURL)
+		return
+	}
+	d.add(doctorOK, "CA keys", "", "%d published", len(d.caKeys))
+}
+
+// checkCADNS cross-checks the CA keys fetched over HTTP against the
+// fingerprints published in DNS.
+func (d *doctor) checkCADNS() {
+	if d.caDNSName == "" || len(d.caKeys) < 1 {
+		return
+	}
+	records, err := lookupCAFingerprints(d.caDNSName)
+	if err != nil {
+		d.add(doctorFail, "CA DNS", "Check ca_fingerprint_dns_name in the "+
+			"configuration.", "%s", err)
+		return
+	}
+	if err := cafingerprint.Verify(records, d.caKeys, nil); err != nil {
+		d.add(doctorFail, "CA DNS", "The CA keys served by "+d.baseURL+
+			" are not the published ones: do not trust them and contact "+
+			"the keymaster administrators.", "%s", err)
+		return
+	}
+	d.add(doctorOK, "CA DNS", "", "CA keys match %s", d.caDNSName)
+}
+
+// checkCert checks the installed SSH certificate against the clock, the
+// user name and the published CA keys.
+func (d *doctor) checkCert() {
+	certFilename := d.sshKeyPath + "-cert.pub"
+	data, err := ioutil.ReadFile(certFilename)
+	if err != nil {
+		d.add(doctorFail, "certificate", "Run keymaster to get one.", "%s",
+			err)
+		return
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		d.add(doctorFail, "certificate", "Run keymaster to replace it.",
+			"%s: %s", certFilename, err)
+		return
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		d.add(doctorFail, "certificate", "Run keymaster to replace it.",
+			"%s is not a certificate", certFilename)
+		return
+	}
+	d.cert = cert
+	now := d.now()
+	validAfter := time.Unix(int64(cert.ValidAfter), 0)
+	validBefore := time.Unix(int64(cert.ValidBefore), 0)
+	switch {
+	case now.Before(validAfter):
+		d.add(doctorFail, "certificate", "Check the clock of this machine.",
+			"not valid until %s", validAfter.Format(time.RFC3339))
+	case !now.Before(validBefore):
+		d.add(doctorFail, "certificate", "Run keymaster to renew it.",
+			"expired at %s", validBefore.Format(time.RFC3339))
+	default:
+		d.add(doctorOK, "certificate", "", "valid until %s",
+			validBefore.Format(time.RFC3339))
+	}
+	principalFound := false
+	for _, principal := range cert.ValidPrincipals {
+		if principal == d.userName {
+			principalFound = true
+		}
+	}
+	if !principalFound {
+		d.add(doctorWarn, "certificate principals", "Run keymaster with "+
+			"-username to get a certificate for the account you log in as.",
+			"%s not in %s", d.userName, strings.Join(cert.ValidPrincipals, ","))
+	}
+	if len(d.caKeys) < 1 {
+		return
+	}
+	signedByCA := false
+	for _, caKey := range d.caKeys {
+		if bytes.Equal(caKey.Marshal(), cert.SignatureKey.Marshal()) {
+			signedByCA = true
+		}
+	}
+	if !signedByCA {
+		d.add(doctorFail, "certificate CA", "The certificate is from another "+
+			"keymaster or a retired CA key; run keymaster to renew it.",
+			"signed by %s, not a published CA key",
+			ssh.FingerprintSHA256(cert.SignatureKey))
+		d.cert = nil
+		return
+	}
+	d.add(doctorOK, "certificate CA", "", "signed by %s",
+		ssh.FingerprintSHA256(cert.SignatureKey))
+	checker, err := revocationcheck.New(revocationcheck.Config{
+		KRLURL:     d.baseURL + "/public/revoked.krl",
+		SSHCAKeys:  d.caKeys,
+		HTTPClient: d.client,
+	})
+	if err == nil {
+		var revoked bool
+		revoked, err = checker.IsSSHCertRevoked(cert)
+		if err == nil && revoked {
+			d.add(doctorFail, "revocation", "Run keymaster to get a new "+
+				"certificate; contact your administrators if it is "+
+				"revoked again.", "serial %d is revoked", cert.Serial)
+			return
+		}
+	}
+	if err != nil {
+		d.add(doctorWarn, "revocation", "", "cannot check: %s", err)
+		return
+	}
+	d.add(doctorOK, "revocation", "", "serial %d is not revoked",
+		cert.Serial)
+}
+
+// certSigner returns a signer presenting the certificate, using the
+// private key file or the key in ssh-agent.
+func (d *doctor) certSigner() (ssh.Signer, io.Closer, error) {
+	if privateKey, err := ioutil.ReadFile(d.sshKeyPath); err == nil {
+		keySigner, err := ssh.ParsePrivateKey(privateKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, err := ssh.NewCertSigner(d.cert, keySigner)
+		return signer, nil, err
+	}
+	sshAgent, agentConn, err := sshagent.Connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	signers, err := sshAgent.Signers()
+	if err != nil {
+		agentConn.Close()
+		return nil, nil, err
+	}
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), d.cert.Marshal()) {
+			return signer, agentConn, nil
+		}
+	}
+	agentConn.Close()
+	return nil, nil, errors.New("certificate is neither in " + d.sshKeyPath +
+		" nor in ssh-agent")
+}
+
+// hostKeyCallback accepts host certificates from the CA and host keys in
+// the known_hosts files.
+func (d *doctor) hostKeyCallback() ssh.HostKeyCallback {
+	var existing []string
+	for _, filename := range d.knownHosts {
+		if _, err := os.Stat(filename); err == nil {
+			existing = append(existing, filename)
+		}
+	}
+	fallback := func(string, net.Addr, ssh.PublicKey) error {
+		return errors.New("host key is unknown")
+	}
+	if len(existing) > 0 {
+		if callback, err := knownhosts.New(existing...); err == nil {
+			fallback = callback
+		}
+	}
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			for _, caKey := range d.caKeys {
+				if bytes.Equal(caKey.Marshal(), auth.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		HostKeyFallback: fallback,
+	}
+	return checker.CheckHostKey
+}
+
+// checkSSHHost logs into target ([user@]host[:port]) with the certificate
+// and disconnects before running anything.
+func (d *doctor) checkSSHHost(target string) {
+	name := "ssh " + target
+	if d.cert == nil {
+		d.add(doctorFail, name, "Fix the certificate first.",
+			"no usable certificate")
+		return
+	}
+	userName := d.userName
+	if index := strings.LastIndex(target, "@"); index >= 0 {
+		userName = target[:index]
+		target = target[index+1:]
+	}
+	address := target
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+	signer, closer, err := d.certSigner()
+	if err != nil {
+		d.add(doctorFail, name, "Run keymaster to install the key "+
+			"and certificate again.", "%s", err)
+		return
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	conn, err := net.DialTimeout("tcp", address, doctorSSHTimeout)
+	if err != nil {
+		d.add(doctorFail, name, "Check that the host is up and "+
+			"reachable from here (firewall, VPN, bastion).", "%s", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(d.now().Add(doctorSSHTimeout))
+	sshConn, _, _, err := ssh.NewClientConn(conn, address, &ssh.ClientConfig{
+		User:            userName,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: d.hostKeyCallback(),
+		Timeout:         doctorSSHTimeout,
+	})
+	if err == nil {
+		sshConn.Close()
+		d.add(doctorOK, name, "", "logged in as %s", userName)
+		return
+	}
+	remediation := ""
+	switch {
+	case strings.Contains(err.Error(), "host key"):
+		remediation = fmt.Sprintf("Trust the host CA with: curl -s "+
+			"'%s/public/known_hosts?hosts=%s' >> ~/.ssh/known_hosts, or "+
+			"check the host key with its administrators.", d.baseURL,
+			strings.Split(target, ":")[0])
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		remediation = fmt.Sprintf("The host does not accept the certificate "+
+			"for %s: its sshd needs TrustedUserCAKeys with the keys of %s/"+
+			"public/ca.pub and %s must be a principal of the account.",
+			userName, d.baseURL, strings.Join(d.cert.ValidPrincipals, ","))
+	}
+	d.add(doctorFail, name, remediation, "%s", err)
+}
+
+// runDoctor implements "keymaster doctor [[user@]host[:port]]" and returns
+// the exit status.
+func runDoctor(userName, homeDir, baseURL, caDNSName string,
+	client *http.Client, args []string, w io.Writer) int {
+	if len(args) > 1 {
+		fmt.Fprintf(w, "Usage: keymaster %s [[user@]host[:port]]\n",
+			doctorCommand)
+		return 2
+	}
+	d := &doctor{
+		userName:   userName,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		sshKeyPath: filepath.Join(homeDir, DefaultSSHKeysLocation, FilePrefix),
+		knownHosts: []string{
+			filepath.Join(homeDir, ".ssh", "known_hosts"),
+			"/etc/ssh/ssh_known_hosts",
+		},
+		caDNSName: caDNSName,
+		client:    client,
+		now:       time.Now,
+	}
+	d.checkServer()
+	d.checkCADNS()
+	d.checkCert()
+	if len(args) > 0 {
+		d.checkSSHHost(args[0])
+	}
+	d.write(w)
+	if d.failed() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/krl"
+	"golang.org/x/crypto/ssh"
+)
+
+type doctorTestEnv struct {
+	caKey     ed25519.PrivateKey
+	caSigner  ssh.Signer
+	homeDir   string
+	server    *httptest.Server
+	revoked   []uint64
+	caDNSName string
+}
+
+func newDoctorTestEnv(t *testing.T) *doctorTestEnv {
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	homeDir, err := ioutil.TempDir("", "doctor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &doctorTestEnv{caKey: caKey, caSigner: caSigner, homeDir: homeDir}
+	env.server = httptest.NewServer(http.HandlerFunc(env.serveHTTP))
+	return env
+}
+
+func (env *doctorTestEnv) close() {
+	env.server.Close()
+	os.RemoveAll(env.homeDir)
+}
+
+func (env *doctorTestEnv) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	revocations := &krl.KRL{Version: 1, Sections: []krl.CertificateSection{
+		{CAKey: env.caSigner.PublicKey(), Serials: env.revoked}}}
+	data := revocations.Marshal()
+	switch r.URL.Path {
+	case "/public/ca.pub":
+		w.Write(ssh.MarshalAuthorizedKey(env.caSigner.PublicKey()))
+	case "/public/revoked.krl":
+		w.Write(data)
+	case "/public/revoked.krl.sig":
+		signature, err := krl.Sign(env.caKey, data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(signature)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (env *doctorTestEnv) signCert(t *testing.T, key ssh.PublicKey,
+	certType uint32, principal string, serial uint64,
+	validity time.Duration) *ssh.Certificate {
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          serial,
+		CertType:        certType,
+		ValidPrincipals: []string{principal},
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(validity).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, env.caSigner); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// installUserCert writes a key and certificate as keymaster does.
+func (env *doctorTestEnv) installUserCert(t *testing.T, serial uint64,
+	validity time.Duration) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := env.signCert(t, sshPublic, ssh.UserCert, "alice", serial,
+		validity)
+	keyPath := filepath.Join(env.homeDir, DefaultSSHKeysLocation, FilePrefix)
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(block),
+		0600); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyPath+"-cert.pub", ssh.MarshalAuthorizedKey(cert),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startSSHServer accepts user certificates from the CA for alice and
+// presents a host certificate from the CA.
+func (env *doctorTestEnv) startSSHServer(t *testing.T) net.Listener {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostCert := env.signCert(t, hostSigner.PublicKey(), ssh.HostCert,
+		"127.0.0.1", 1, time.Hour)
+	hostCertSigner, err := ssh.NewCertSigner(hostCert, hostSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(),
+				env.caSigner.PublicKey().Marshal())
+		},
+	}
+	config := &ssh.ServerConfig{PublicKeyCallback: checker.Authenticate}
+	config.AddHostKey(hostCertSigner)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				sshConn, _, _, err := ssh.NewServerConn(conn, config)
+				if err == nil {
+					sshConn.Close()
+				}
+			}()
+		}
+	}()
+	return listener
+}
+
+func runTestDoctor(env *doctorTestEnv, args ...string) (int, string) {
+	output := &bytes.Buffer{}
+	status := runDoctor("alice", env.homeDir, env.server.URL, env.caDNSName,
+		env.server.Client(), args, output)
+	return status, output.String()
+}
+
+func TestDoctor(t *testing.T) {
+	env := newDoctorTestEnv(t)
+	defer env.close()
+	status, output := runTestDoctor(env)
+	if status != 1 || !strings.Contains(output, "FAIL certificate:") ||
+		!strings.Contains(output, "Run keymaster to get one.") {
+		t.Fatalf("missing certificate not reported: %d\n%s", status, output)
+	}
+
+	env.installUserCert(t, 7, time.Hour)
+	listener := env.startSSHServer(t)
+	defer listener.Close()
+	status, output = runTestDoctor(env, "alice@"+listener.Addr().String())
+	if status != 0 {
+		t.Fatalf("unexpected failure:\n%s", output)
+	}
+	for _, expected := range []string{"OK   clock:", "OK   revocation:",
+		"OK   ssh alice@", "logged in as alice"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("missing %q in:\n%s", expected, output)
+		}
+	}
+
+	status, output = runTestDoctor(env, "bob@"+listener.Addr().String())
+	if status != 1 || !strings.Contains(output, "TrustedUserCAKeys") {
+		t.Errorf("refused login not explained:\n%s", output)
+	}
+
+	env.revoked = []uint64{7}
+	status, output = runTestDoctor(env)
+	if status != 1 || !strings.Contains(output, "serial 7 is revoked") {
+		t.Errorf("revocation not reported:\n%s", output)
+	}
+
+	env.installUserCert(t, 8, -time.Second)
+	status, output = runTestDoctor(env)
+	if status != 1 || !strings.Contains(output, "Run keymaster to renew it.") {
+		t.Errorf("expiry not reported:\n%s", output)
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"flag"
 	"fmt"
@@ -11,7 +15,6 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/user"
 	"path/filepath"
 	"runtime"
@@ -23,7 +26,7 @@ import (
 	"github.com/Symantec/Dominator/lib/net/rrdialer"
 	"github.com/Symantec/keymaster/lib/client/config"
 	libnet "github.com/Symantec/keymaster/lib/client/net"
-	"github.com/Symantec/keymaster/lib/client/twofa"
+	"github.com/Symantec/keymaster/lib/client/sshagent"
 	"github.com/Symantec/keymaster/lib/client/twofa/u2f"
 	"github.com/Symantec/keymaster/lib/client/util"
 )
@@ -51,8 +54,16 @@ var (
 	cliFilePrefix    = flag.String("fileprefix", "", "Prefix for the output files")
 	roundRobinDialer = flag.Bool("roundRobinDialer", false,
 		"If true, use the smart round-robin dialer")
-
-	FilePrefix = "keymaster"
+	cliKeyType = flag.String("keyType", "",
+		"Type of the ephemeral key to generate: rsa or ed25519 (default rsa)")
+	daemon = flag.Bool("daemon", false,
+		"If true, keep running and renew the certificates before they expire")
+	cliSSHAgentOnly = flag.Bool("sshAgentOnly", false,
+		"If true, add the SSH key and certificate to the running ssh-agent only and do not write private keys to disk")
+
+	FilePrefix   = "keymaster"
+	KeyType      = "rsa"
+	SSHAgentOnly = false
 )
 
 func getUserHomeDir() (homeDir string) {
@@ -189,11 +200,29 @@ func setupCerts(
 	configContents config.AppConfigFile,
 	client *http.Client,
 	logger log.DebugLogger) {
+	getter := newCertGetter(userName, configContents, client, false, logger)
+	_, err := installCerts(homeDir, configContents, getter, client, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// installCerts obtains new certificates using getter and installs them
+// together with a freshly generated key. It returns the SSH certificate.
+// Only errors contacting the keymaster servers are returned, other failures
+// are fatal.
+func installCerts(
+	homeDir string,
+	configContents config.AppConfigFile,
+	getter *certGetter,
+	client *http.Client,
+	logger log.DebugLogger) ([]byte, error) {
+	userName := getter.userName
 	//initialize the client connection
 	targetURLs := strings.Split(configContents.Base.Gen_Cert_URLS, ",")
 	err := backgroundConnectToAnyKeymasterServer(targetURLs, client, logger)
 	if err != nil {
-		logger.Fatal(err)
+		return nil, err
 	}
 
 	// create dirs
@@ -211,63 +240,111 @@ func setupCerts(
 	}
 
 	// get signer
+	var signer crypto.Signer
 	tempPrivateKeyPath := filepath.Join(homeDir, DefaultSSHKeysLocation, "keymaster-temp")
-	signer, tempPublicKeyPath, err := util.GenKeyPair(
-		tempPrivateKeyPath, userName+"@keymaster", logger)
-	if err != nil {
-		logger.Fatal(err)
+	if SSHAgentOnly {
+		// Fail before prompting for credentials if there is no agent.
+		_, agentConn, err := sshagent.Connect()
+		if err != nil {
+			logger.Fatalf("Cannot connect to ssh-agent: %s", err)
+		}
+		agentConn.Close()
+		signer, err = genInMemoryKey(KeyType)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	} else {
+		genKeyPair := util.GenKeyPair
+		if KeyType == "ed25519" {
+			genKeyPair = util.GenEd25519KeyPair
+		}
+		var tempPublicKeyPath string
+		signer, tempPublicKeyPath, err = genKeyPair(
+			tempPrivateKeyPath, userName+"@keymaster", logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer os.Remove(tempPrivateKeyPath)
+		defer os.Remove(tempPublicKeyPath)
 	}
-	defer os.Remove(tempPrivateKeyPath)
-	defer os.Remove(tempPublicKeyPath)
-	// Get user creds
-	password, err := util.GetUserCreds(userName)
+	// Get the certs
+	sshCert, x509Cert, kubernetesCert, err := getter.getCerts(signer)
 	if err != nil {
-		logger.Fatal(err)
+		return nil, err
+	}
+	if sshCert == nil || x509Cert == nil {
+		return nil, errors.New("Could not get cert from any url")
+	}
+	logger.Debugf(0, "Got Certs from server")
+	if name := configContents.Base.CAFingerprintDNSName; name != "" {
+		if err := verifySSHCertIssuer(name, sshCert); err != nil {
+			logger.Fatalf("Not installing certificate: %s", err)
+		}
+	}
+	tlsPrivateKeyName := filepath.Join(homeDir, DefaultTLSKeysLocation, FilePrefix+".key")
+	os.Remove(tlsPrivateKeyName)
+	if SSHAgentOnly {
+		err = addCertToAgent(sshCert, signer, sshKeyPath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	} else {
+		writeKeyFiles(tempPrivateKeyPath, sshKeyPath, tlsPrivateKeyName,
+			sshCert, signer, logger)
 	}
 
-	// Get the certs
-	sshCert, x509Cert, kubernetesCert, err := twofa.GetCertFromTargetUrls(
-		signer,
-		userName,
-		password,
-		strings.Split(configContents.Base.Gen_Cert_URLS, ","),
-		false,
-		configContents.Base.AddGroups,
-		client,
-		userAgentString,
-		logger)
+	// now we write the cert file...
+	x509CertPath := tlsKeyPath + ".cert"
+	err = ioutil.WriteFile(x509CertPath, x509Cert, 0644)
 	if err != nil {
+		err := errors.New("Could not write ssh cert")
 		logger.Fatal(err)
 	}
-	if sshCert == nil || x509Cert == nil {
-		err := errors.New("Could not get cert from any url")
-		logger.Fatal(err)
+	if kubernetesCert != nil {
+		kubernetesCertPath := tlsKeyPath + "-kubernetes.cert"
+		err = ioutil.WriteFile(kubernetesCertPath, kubernetesCert, 0644)
+		if err != nil {
+			err := errors.New("Could not write ssh cert")
+			logger.Fatal(err)
+		}
 	}
-	logger.Debugf(0, "Got Certs from server")
-	//..
-	if _, ok := os.LookupEnv("SSH_AUTH_SOCK"); ok {
-		// TODO(rgooch): Parse certificate to get actual lifetime.
-		cmd := exec.Command("ssh-add", "-d", sshKeyPath)
-		cmd.Run()
+
+	logger.Printf("Success")
+	if !SSHAgentOnly {
+		err = addCertToAgent(sshCert, signer, sshKeyPath)
+		if err == sshagent.ErrNoAgent {
+			logger.Debugf(1, "No ssh-agent running, not adding key")
+		} else if err != nil {
+			logger.Printf("Could not add key to ssh-agent: %s", err)
+		}
 	}
+	return sshCert, nil
+}
 
+// writeKeyFiles moves the temporary key pair into place and writes the keys
+// and SSH certificate where ssh and TLS tools look for them.
+func writeKeyFiles(tempPrivateKeyPath, sshKeyPath, tlsPrivateKeyName string,
+	sshCert []byte, signer crypto.Signer, logger log.Logger) {
 	//rename files to expected paths
-	err = os.Rename(tempPrivateKeyPath, sshKeyPath)
+	err := os.Rename(tempPrivateKeyPath, sshKeyPath)
 	if err != nil {
 		err := errors.New("Could not rename private Key")
 		logger.Fatal(err)
 	}
 
-	err = os.Rename(tempPublicKeyPath, sshKeyPath+".pub")
+	err = os.Rename(tempPrivateKeyPath+".pub", sshKeyPath+".pub")
 	if err != nil {
 		err := errors.New("Could not rename public Key")
 		logger.Fatal(err)
 	}
 	// Now handle the key in the tls directory
-	tlsPrivateKeyName := filepath.Join(homeDir, DefaultTLSKeysLocation, FilePrefix+".key")
-	os.Remove(tlsPrivateKeyName)
-	err = os.Symlink(sshKeyPath, tlsPrivateKeyName)
-	if err != nil {
+	if KeyType == "ed25519" {
+		// TLS tools do not understand the OpenSSH private key format.
+		err = writePKCS8PrivateKey(tlsPrivateKeyName, signer)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	} else if err = os.Symlink(sshKeyPath, tlsPrivateKeyName); err != nil {
 		// Try to copy instead (windows symlink does not work)
 		from, err := os.Open(sshKeyPath)
 		if err != nil {
@@ -286,35 +363,43 @@ func setupCerts(
 		}
 	}
 
-	// now we write the cert file...
 	sshCertPath := sshKeyPath + "-cert.pub"
 	err = ioutil.WriteFile(sshCertPath, sshCert, 0644)
 	if err != nil {
 		err := errors.New("Could not write ssh cert")
 		logger.Fatal(err)
 	}
-	x509CertPath := tlsKeyPath + ".cert"
-	err = ioutil.WriteFile(x509CertPath, x509Cert, 0644)
+}
+
+// addCertToAgent adds the key and its certificate to the running ssh-agent,
+// replacing the key previously added under the same comment. The agent
+// forgets the key when the certificate expires.
+func addCertToAgent(sshCert []byte, signer crypto.Signer, comment string) error {
+	sshAgent, agentConn, err := sshagent.Connect()
 	if err != nil {
-		err := errors.New("Could not write ssh cert")
-		logger.Fatal(err)
+		return err
 	}
-	if kubernetesCert != nil {
-		kubernetesCertPath := tlsKeyPath + "-kubernetes.cert"
-		err = ioutil.WriteFile(kubernetesCertPath, kubernetesCert, 0644)
-		if err != nil {
-			err := errors.New("Could not write ssh cert")
-			logger.Fatal(err)
-		}
+	defer agentConn.Close()
+	return sshagent.UpsertCertIntoAgent(sshAgent, sshCert, signer, comment)
+}
+
+// genInMemoryKey generates a key which is never written to disk.
+func genInMemoryKey(keyType string) (crypto.Signer, error) {
+	if keyType == "ed25519" {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
 	}
+	return util.GenerateKey()
+}
 
-	logger.Printf("Success")
-	if _, ok := os.LookupEnv("SSH_AUTH_SOCK"); ok {
-		// TODO(rgooch): Parse certificate to get actual lifetime.
-		lifetime := fmt.Sprintf("%ds", uint64((*twofa.Duration).Seconds()))
-		cmd := exec.Command("ssh-add", "-t", lifetime, sshKeyPath)
-		cmd.Run()
+func writePKCS8PrivateKey(filename string, signer crypto.Signer) error {
+	derKey, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return err
 	}
+	return ioutil.WriteFile(filename,
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: derKey}),
+		0600)
 }
 
 func computeUserAgent() {
@@ -350,6 +435,8 @@ func getHttpClient(rootCAs *x509.CertPool, logger log.DebugLogger) (*http.Client
 func Usage() {
 	fmt.Fprintf(
 		os.Stderr, "Usage of %s (version %s):\n", os.Args[0], Version)
+	fmt.Fprintf(os.Stderr, "       %s [flags] %s [[user@]host[:port]]\n",
+		os.Args[0], doctorCommand)
 	flag.PrintDefaults()
 }
 
@@ -394,5 +481,27 @@ func main() {
 		FilePrefix = *cliFilePrefix
 	}
 
+	if len(config.Base.KeyType) > 0 {
+		KeyType = config.Base.KeyType
+	}
+	if *cliKeyType != "" {
+		KeyType = *cliKeyType
+	}
+	if KeyType != "rsa" && KeyType != "ed25519" {
+		logger.Fatalf("Unsupported key type: %s", KeyType)
+	}
+	SSHAgentOnly = config.Base.SSHAgentOnly || *cliSSHAgentOnly
+
+	if flag.Arg(0) == doctorCommand {
+		baseURL := strings.Split(config.Base.Gen_Cert_URLS, ",")[0]
+		os.Exit(runDoctor(userName, homeDir, baseURL,
+			config.Base.CAFingerprintDNSName, client, flag.Args()[1:],
+			os.Stdout))
+	}
+
+	if *daemon {
+		runDaemon(userName, homeDir, config, client, logger)
+		return
+	}
 	setupCerts(userName, homeDir, config, client, logger)
 }
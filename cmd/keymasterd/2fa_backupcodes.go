@@ -0,0 +1,428 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+// Backup codes are one time codes generated by users for when their U2F
+// token or TOTP device is not at hand. They are a second factor for the web
+// UI; they allow certificates only if an auth requirement lists BackupCode.
+const (
+	backupCodesPath         = "/api/v0/backupCodes"
+	backupCodeAuthPath      = "/api/v0/backupCodeAuth"
+	factorsPath             = "/api/v0/factors"
+	defaultBackupCodesCount = 10
+	maxBackupCodesCount     = 20
+	backupCodeLength        = 5 // Random bytes, 8 base32 characters.
+)
+
+var backupCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type BackupCodesConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// The number of codes generated at a time. Default: 10, at most 20.
+	Count uint `yaml:"count"`
+}
+
+// backupCodeData is kept in the user profile. Codes have 40 random bits
+// and their guesses are throttled, so their SHA-256 hashes are kept rather
+// than slower password hashes. Used codes are removed.
+type backupCodeData struct {
+	CreatedAt   time.Time
+	CreatorAddr string
+	Hashes      [][]byte
+}
+
+// The enrolled factors of a user, as reported by factorsHandler.
+type enrolledFactor struct {
+	Index     int64     `json:"index"`
+	Name      string    `json:"name"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+type backupCodesInfo struct {
+	Remaining int        `json:"remaining"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+type enrolledFactors struct {
+	U2F         []enrolledFactor `json:"u2f"`
+	TOTP        []enrolledFactor `json:"totp"`
+	PendingTOTP bool             `json:"pending_totp,omitempty"`
+	BackupCodes *backupCodesInfo `json:"backup_codes,omitempty"`
+}
+
+type newBackupCodesResponse struct {
+	Codes     []string  `json:"codes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (config BackupCodesConfig) check() error {
+	if config.Count > maxBackupCodesCount {
+		return fmt.Errorf("backup_codes: count above %d", maxBackupCodesCount)
+	}
+	return nil
+}
+
+func (config BackupCodesConfig) count() int {
+	if config.Count == 0 {
+		return defaultBackupCodesCount
+	}
+	return int(config.Count)
+}
+
+// normalizeBackupCode removes the separators and case of a code as typed.
+func normalizeBackupCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func hashBackupCode(code string) []byte {
+	sum := sha256.Sum256([]byte(normalizeBackupCode(code)))
+	return sum[:]
+}
+
+// generateBackupCodes returns count new codes, formatted as XXXX-XXXX, and
+// their data for the profile.
+func generateBackupCodes(count int, creatorAddr string,
+	now time.Time) ([]string, *backupCodeData, error) {
+	codes := make([]string, 0, count)
+	data := &backupCodeData{CreatedAt: now, CreatorAddr: creatorAddr}
+	for len(codes) < count {
+		buf := make([]byte, backupCodeLength)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		encoded := backupCodeEncoding.EncodeToString(buf)
+		code := encoded[:4] + "-" + encoded[4:]
+		codes = append(codes, code)
+		data.Hashes = append(data.Hashes, hashBackupCode(code))
+	}
+	return codes, data, nil
+}
+
+// useBackupCode removes code from data and returns true if it is one of its
+// unused codes.
+func (data *backupCodeData) useBackupCode(code string) bool {
+	if data == nil {
+		return false
+	}
+	hash := hashBackupCode(code)
+	for i, candidate := range data.Hashes {
+		if subtle.ConstantTimeCompare(candidate, hash) == 1 {
+			data.Hashes = append(data.Hashes[:i], data.Hashes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (data *backupCodeData) info() *backupCodesInfo {
+	if data == nil {
+		return &backupCodesInfo{}
+	}
+	createdAt := data.CreatedAt
+	return &backupCodesInfo{Remaining: len(data.Hashes), CreatedAt: &createdAt}
+}
+
+func sortEnrolledFactors(factors []enrolledFactor) {
+	sort.Slice(factors, func(i, j int) bool {
+		if factors[i].Name != factors[j].Name {
+			return factors[i].Name < factors[j].Name
+		}
+		return factors[i].Index < factors[j].Index
+	})
+}
+
+func (state *RuntimeState) getEnrolledFactors(
+	profile *userProfile) enrolledFactors {
+	factors := enrolledFactors{
+		U2F:         []enrolledFactor{},
+		TOTP:        []enrolledFactor{},
+		PendingTOTP: profile.PendingTOTPSecret != nil,
+	}
+	for index, data := range profile.U2fAuthData {
+		factors.U2F = append(factors.U2F, enrolledFactor{
+			Index:     index,
+			Name:      data.Name,
+			Enabled:   data.Enabled,
+			CreatedAt: data.CreatedAt,
+		})
+	}
+	for index, data := range profile.TOTPAuthData {
+		factors.TOTP = append(factors.TOTP, enrolledFactor{
+			Index:     index,
+			Name:      data.Name,
+			Enabled:   data.Enabled,
+			CreatedAt: data.CreatedAt,
+		})
+	}
+	sortEnrolledFactors(factors.U2F)
+	sortEnrolledFactors(factors.TOTP)
+	if state.Config.Base.BackupCodes.Enabled {
+		factors.BackupCodes = profile.BackupCodes.info()
+	}
+	return factors
+}
+
+// factorsHandler returns the second factors enrolled by the user.
+func (state *RuntimeState) factorsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	authUser, _, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		requestLogger(r).Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	profile, _, _, err := state.LoadUserProfileContext(r.Context(), authUser)
+	if err != nil {
+		requestLogger(r).Errorf("loading profile error: %v", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(state.getEnrolledFactors(profile))
+}
+
+// backupCodesHandler replaces the backup codes of the user with new ones,
+// which are shown only once. Since backup codes are a second factor,
+// generating them requires a session authenticated with another one.
+func (state *RuntimeState) backupCodesHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if !state.Config.Base.BackupCodes.Enabled {
+		state.writeFailureResponse(w, r, http.StatusNotFound,
+			"Backup codes are not enabled")
+		return
+	}
+	authUser, authLevel, err := state.checkAuth(w, r,
+		state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		requestLogger(r).Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	if authLevel&secondFactorAuthLevels == 0 {
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Authenticate with a second factor to generate backup codes")
+		return
+	}
+	unlock := state.backupCodeLocks.lock(authUser)
+	defer unlock()
+	profile, _, fromCache, err := state.LoadUserProfileContext(r.Context(), authUser)
+	if err != nil {
+		requestLogger(r).Errorf("loading profile error: %v", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if fromCache {
+		state.writeFailureResponse(w, r, http.StatusServiceUnavailable,
+			"DB in cached state, cannot generate backup codes now")
+		return
+	}
+	codes, data, err := generateBackupCodes(
+		state.Config.Base.BackupCodes.count(), r.RemoteAddr, time.Now())
+	if err != nil {
+		requestLogger(r).Errorf("Cannot generate backup codes: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	profile.BackupCodes = data
+	if err := state.SaveUserProfileContext(r.Context(), authUser, profile); err != nil {
+		requestLogger(r).Errorf("Saving profile error: %v", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	requestLogger(r).Printf("Generated %d backup codes for %s", len(codes), authUser)
+	w.Header().Set("Cache-Control", "no-store")
+	switch getPreferredAcceptType(r) {
+	case "text/html":
+		displayData := backupCodesPageTemplateData{
+			Title:        "Keymaster Backup Codes",
+			AuthUsername: authUser,
+			Codes:        codes,
+		}
+		err := state.htmlTemplate.ExecuteTemplate(w, "backupCodesPage",
+			displayData)
+		if err != nil {
+			requestLogger(r).Errorf("Failed to execute %v", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+		}
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newBackupCodesResponse{
+			Codes:     codes,
+			CreatedAt: data.CreatedAt,
+		})
+	}
+}
+
+// checkBackupCode uses code if it is one of the backup codes of username.
+// Guesses are throttled like passwords.
+func (state *RuntimeState) checkBackupCode(r *http.Request,
+	username, code string) (bool, error) {
+	throttle := state.loginThrottle
+	address := loginThrottleAddress(r)
+	if throttle != nil {
+		if delay := throttle.Delay(username, address); delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return false, r.Context().Err()
+			}
+		}
+	}
+	// Concurrent requests with the same code must not both load the profile
+	// before either saves it without the code.
+	unlock := state.backupCodeLocks.lock(username)
+	defer unlock()
+	profile, _, fromCache, err := state.LoadUserProfileContext(r.Context(), username)
+	if err != nil {
+		return false, err
+	}
+	// A used code must be removed for good.
+	if fromCache {
+		return false, fmt.Errorf("DB in cached state")
+	}
+	if !profile.BackupCodes.useBackupCode(code) {
+		if throttle != nil {
+			throttle.RecordFailure(username, address, []byte(code))
+		}
+		state.recordAuthFailure(r, username, proto.AuthTypeBackupCode)
+		return false, nil
+	}
+	if err := state.SaveUserProfileContext(r.Context(), username, profile); err != nil {
+		return false, err
+	}
+	requestLogger(r).Printf("%s used a backup code, %d left", username,
+		len(profile.BackupCodes.Hashes))
+	return true, nil
+}
+
+// backupCodeAuthHandler adds a backup code to the authentication of the
+// session, like TOTPAuthHandler does with a TOTP value.
+func (state *RuntimeState) backupCodeAuthHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if !state.Config.Base.BackupCodes.Enabled {
+		state.writeFailureResponse(w, r, http.StatusNotFound,
+			"Backup codes are not enabled")
+		return
+	}
+	authUser, currentAuthLevel, err := state.checkAuth(w, r, AuthTypeAny)
+	if err != nil {
+		requestLogger(r).Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	if err := r.ParseForm(); err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Error parsing form")
+		return
+	}
+	code := r.Form.Get("code")
+	if code == "" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Missing code")
+		return
+	}
+	valid, err := state.checkBackupCode(r, authUser, code)
+	if err != nil {
+		requestLogger(r).Errorf("Error checking backup code of %s: %s", authUser, err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError,
+			"Failure when checking backup code")
+		return
+	}
+	if !valid {
+		requestLogger(r).Warnf("Invalid backup code login for %s", authUser)
+		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+		return
+	}
+	_, err = state.updateAuthCookieAuthlevel(w, r,
+		currentAuthLevel|AuthTypeBackupCode)
+	if err != nil {
+		requestLogger(r).Printf("Auth Cookie NOT found ? %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError,
+			"Failure when checking backup code")
+		return
+	}
+	switch getPreferredAcceptType(r) {
+	case "text/html":
+		http.Redirect(w, r, getLoginDestination(r), 302)
+	default:
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(proto.LoginResponse{Message: "success"})
+	}
+}
+
+// userLocks serializes operations per user. The zero value is ready to use.
+type userLocks struct {
+	mutex sync.Mutex
+	users map[string]*userLock // Protected by mutex.
+}
+
+type userLock struct {
+	sync.Mutex
+	waiters int // Protected by userLocks.mutex.
+}
+
+// lock locks username and returns the function which unlocks it.
+func (l *userLocks) lock(username string) func() {
+	l.mutex.Lock()
+	if l.users == nil {
+		l.users = make(map[string]*userLock)
+	}
+	user := l.users[username]
+	if user == nil {
+		user = &userLock{}
+		l.users[username] = user
+	}
+	user.waiters++
+	l.mutex.Unlock()
+	user.Lock()
+	return func() {
+		user.Unlock()
+		l.mutex.Lock()
+		user.waiters--
+		if user.waiters == 0 {
+			delete(l.users, username)
+		}
+		l.mutex.Unlock()
+	}
+}
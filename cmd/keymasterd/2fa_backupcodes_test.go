@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackupCodesConfigCheck(t *testing.T) {
+	if err := (BackupCodesConfig{Enabled: true, Count: 5}).check(); err != nil {
+		t.Error(err)
+	}
+	config := BackupCodesConfig{Count: maxBackupCodesCount + 1}
+	if err := config.check(); err == nil {
+		t.Error("count above the maximum accepted")
+	}
+	if count := (BackupCodesConfig{}).count(); count != defaultBackupCodesCount {
+		t.Errorf("default count: %d", count)
+	}
+}
+
+func TestUseBackupCode(t *testing.T) {
+	codes, data, err := generateBackupCodes(3, "192.0.2.1", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 3 || len(data.Hashes) != 3 {
+		t.Fatalf("unexpected codes: %v", codes)
+	}
+	if len(codes[0]) != 9 || codes[0][4] != '-' {
+		t.Errorf("unexpected code format: %s", codes[0])
+	}
+	if data.useBackupCode("AAAA-AAAA") {
+		t.Error("unknown code accepted")
+	}
+	// Codes are accepted regardless of case and separators.
+	typed := strings.ToLower(strings.Replace(codes[1], "-", " ", 1))
+	if !data.useBackupCode(typed) {
+		t.Errorf("%q refused", typed)
+	}
+	if data.useBackupCode(codes[1]) {
+		t.Error("used code accepted again")
+	}
+	if info := data.info(); info.Remaining != 2 {
+		t.Errorf("remaining: %d", info.Remaining)
+	}
+	var none *backupCodeData
+	if none.useBackupCode(codes[0]) || none.info().Remaining != 0 {
+		t.Error("codes accepted without codes")
+	}
+}
+
+func TestBackupCodes(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "backupcodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.Config.Base.DataDirectory = dir
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{"password"}
+	state.Config.Base.BackupCodes = BackupCodesConfig{Enabled: true, Count: 4}
+	newRequest := func(method, path string, authLevel int,
+		form url.Values) *http.Request {
+		t.Helper()
+		req, err := http.NewRequest(method, path,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		cookieVal, err := state.setNewAuthCookie(nil, nil, "username",
+			authLevel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		return req
+	}
+	getFactors := func() enrolledFactors {
+		t.Helper()
+		rr, err := checkRequestHandlerCode(
+			newRequest("GET", factorsPath, AuthTypePassword, nil),
+			state.factorsHandler, http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var factors enrolledFactors
+		if err := json.NewDecoder(rr.Body).Decode(&factors); err != nil {
+			t.Fatal(err)
+		}
+		return factors
+	}
+	if factors := getFactors(); factors.BackupCodes == nil ||
+		factors.BackupCodes.Remaining != 0 || len(factors.U2F) != 0 {
+		t.Errorf("unexpected factors: %+v", factors)
+	}
+
+	// Backup codes are a second factor, so they cannot be generated with a
+	// password alone.
+	_, err = checkRequestHandlerCode(
+		newRequest("POST", backupCodesPath, AuthTypePassword, nil),
+		state.backupCodesHandler, http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(
+		newRequest("POST", backupCodesPath, AuthTypePassword|AuthTypeU2F, nil),
+		state.backupCodesHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response newBackupCodesResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Codes) != 4 {
+		t.Fatalf("unexpected codes: %v", response.Codes)
+	}
+	if factors := getFactors(); factors.BackupCodes.Remaining != 4 ||
+		factors.BackupCodes.CreatedAt == nil {
+		t.Errorf("unexpected factors: %+v", factors.BackupCodes)
+	}
+
+	authenticate := func(code string, expectedStatus int) *http.Response {
+		t.Helper()
+		rr, err := checkRequestHandlerCode(
+			newRequest("POST", backupCodeAuthPath, AuthTypePassword,
+				url.Values{"code": {code}}),
+			state.backupCodeAuthHandler, expectedStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr.Result()
+	}
+	result := authenticate(response.Codes[2], http.StatusOK)
+	var upgraded bool
+	for _, cookie := range result.Cookies() {
+		if cookie.Name != authCookieName {
+			continue
+		}
+		info, err := state.getAuthInfoFromAuthJWT(cookie.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		upgraded = info.AuthType&AuthTypeBackupCode != 0
+	}
+	if !upgraded {
+		t.Error("auth cookie not upgraded")
+	}
+	authenticate(response.Codes[2], http.StatusUnauthorized)
+	authenticate("not-a-code", http.StatusUnauthorized)
+
+	// Concurrent requests cannot both redeem the same code.
+	var wg sync.WaitGroup
+	var accepted int32
+	for i := 0; i < 8; i++ {
+		req := newRequest("POST", backupCodeAuthPath, AuthTypePassword, nil)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := state.checkBackupCode(req, "username",
+				response.Codes[1])
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				atomic.AddInt32(&accepted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("code accepted %d times", accepted)
+	}
+	if factors := getFactors(); factors.BackupCodes.Remaining != 2 {
+		t.Errorf("remaining: %d", factors.BackupCodes.Remaining)
+	}
+
+	// New codes replace the unused ones.
+	_, err = checkRequestHandlerCode(
+		newRequest("POST", backupCodesPath, AuthTypePassword|AuthTypeU2F, nil),
+		state.backupCodesHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate(response.Codes[0], http.StatusUnauthorized)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/duo"
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+const duoPushStartPath = "/api/v0/duoPushStart"
+const duoPollCheckPath = "/api/v0/duoPollCheck"
+
+const maxAgeSecondsDuoPush = 120
+
+// Duo push transactions are keyed by the auth cookie of the session that
+// started them.
+func (state *RuntimeState) setDuoPushTransaction(sessionID string,
+	transaction pushPollTransaction) {
+	state.Mutex.Lock()
+	defer state.Mutex.Unlock()
+	if state.duoPushTransactions == nil {
+		state.duoPushTransactions = make(map[string]pushPollTransaction)
+	}
+	state.duoPushTransactions[sessionID] = transaction
+}
+
+func (state *RuntimeState) getDuoPushTransaction(sessionID string) (
+	pushPollTransaction, bool) {
+	state.Mutex.Lock()
+	defer state.Mutex.Unlock()
+	transaction, ok := state.duoPushTransactions[sessionID]
+	if ok && transaction.ExpiresAt.Before(time.Now()) {
+		delete(state.duoPushTransactions, sessionID)
+		return transaction, false
+	}
+	return transaction, ok
+}
+
+func (state *RuntimeState) deleteDuoPushTransaction(sessionID string) {
+	state.Mutex.Lock()
+	defer state.Mutex.Unlock()
+	delete(state.duoPushTransactions, sessionID)
+}
+
+func (state *RuntimeState) setDuoAuthenticated(w http.ResponseWriter,
+	r *http.Request, authUser string, currentAuthLevel int) {
+	_, err := state.updateAuthCookieAuthlevel(w, r, currentAuthLevel|AuthTypeDuo)
+	if err != nil {
+		requestLogger(r).Errorf("Failure to update AuthCookie for Duo auth %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure when validating Duo push")
+		return
+	}
+	metricLogAuthOperation(getClientType(r), proto.AuthTypeDuo, true)
+	requestLogger(r).Debugf(1, "Successful Duo auth for user: %s", authUser)
+	w.WriteHeader(http.StatusOK)
+}
+
+// duoPushStartHandler sends a Duo push to the authenticated user. Users that
+// Duo allows without a second factor are upgraded immediately.
+func (state *RuntimeState) duoPushStartHandler(w http.ResponseWriter, r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if !state.activeIssuancePolicy().DuoEnabled {
+		requestLogger(r).Printf("asked for Duo push but Duo is not enabled")
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Duo not enabled")
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	authUser, currentAuthLevel, err := state.checkAuth(w, r, AuthTypeAny)
+	if err != nil {
+		requestLogger(r).Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	sessionID := getSessionCookieValue(r)
+	if _, ok := state.getDuoPushTransaction(sessionID); ok {
+		state.writeFailureResponse(w, r, http.StatusPreconditionFailed, "Push already sent")
+		return
+	}
+	client := state.Config.Duo.Client
+	start := time.Now()
+	result, err := client.Preauth(authUser)
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure when contacting Duo")
+		return
+	}
+	switch result {
+	case duo.PreauthAllow:
+		metricLogExternalServiceDuration("duo", time.Since(start))
+		state.setDuoAuthenticated(w, r, authUser, currentAuthLevel)
+		return
+	case duo.PreauthAuth:
+	case duo.PreauthEnroll:
+		metricLogAuthOperation(getClientType(r), proto.AuthTypeDuo, false)
+		state.writeFailureResponse(w, r, http.StatusForbidden, "Duo enrollment required")
+		return
+	default:
+		metricLogAuthOperation(getClientType(r), proto.AuthTypeDuo, false)
+		requestLogger(r).Printf("Duo preauth for %s returned %s", authUser, result)
+		state.writeFailureResponse(w, r, http.StatusForbidden, "Denied by Duo")
+		return
+	}
+	transactionID, err := client.StartPush(authUser)
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure when sending Duo push")
+		return
+	}
+	metricLogExternalServiceDuration("duo", time.Since(start))
+	state.setDuoPushTransaction(sessionID, pushPollTransaction{
+		Username:      authUser,
+		TransactionID: transactionID,
+		ExpiresAt:     time.Now().Add(maxAgeSecondsDuoPush * time.Second),
+	})
+	w.WriteHeader(http.StatusOK)
+}
+
+// duoPollCheckHandler returns 200 and upgrades the session once the push
+// started with duoPushStartHandler is approved and 412 while it is pending.
+func (state *RuntimeState) duoPollCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if !state.activeIssuancePolicy().DuoEnabled {
+		requestLogger(r).Printf("asked for Duo push status but Duo is not enabled")
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Duo not enabled")
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	authUser, currentAuthLevel, err := state.checkAuth(w, r, AuthTypeAny)
+	if err != nil {
+		requestLogger(r).Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	if currentAuthLevel&AuthTypeDuo != 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	sessionID := getSessionCookieValue(r)
+	transaction, ok := state.getDuoPushTransaction(sessionID)
+	if !ok || transaction.Username != authUser {
+		state.writeFailureResponse(w, r, http.StatusPreconditionFailed, "No Duo push in progress")
+		return
+	}
+	status, err := state.Config.Duo.Client.AuthStatus(transaction.TransactionID)
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Error checking Duo push")
+		return
+	}
+	switch status {
+	case duo.AuthWaiting:
+		state.writeFailureResponse(w, r, http.StatusPreconditionFailed, "Duo push not yet approved")
+		return
+	case duo.AuthAllow:
+		state.deleteDuoPushTransaction(sessionID)
+		state.setDuoAuthenticated(w, r, authUser, currentAuthLevel)
+		return
+	default:
+		state.deleteDuoPushTransaction(sessionID)
+		metricLogAuthOperation(getClientType(r), proto.AuthTypeDuo, false)
+		requestLogger(r).Printf("Duo push for %s was denied", authUser)
+		state.recordAuthFailure(r, authUser, proto.AuthTypeDuo)
+		state.writeFailureResponse(w, r, http.StatusUnauthorized, "Duo push denied")
+		return
+	}
+}
+
+// checkDuoEnforcement returns an error if username is a member of one of the
+// groups which must approve a Duo push before any certificate is issued and
+// the session has not done so. IP restricted (automation) certificates are
+// exempt.
+func (state *RuntimeState) checkDuoEnforcement(username string,
+	authLevel int) error {
+	policy := state.activeIssuancePolicy()
+	if !policy.DuoEnabled || len(policy.DuoEnforceGroups) < 1 {
+		return nil
+	}
+	if authLevel&(AuthTypeDuo|AuthTypeIPCertificate|AuthTypeBreakGlass) != 0 {
+		return nil
+	}
+	groups, err := state.getUserGroups(username)
+	if err != nil {
+		return fmt.Errorf("cannot check Duo enforcement: %s", err)
+	}
+	for _, group := range groups {
+		for _, enforcedGroup := range policy.DuoEnforceGroups {
+			if group == enforcedGroup {
+				return fmt.Errorf("Duo approval required for members of %s",
+					group)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestDuoPollCheckHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.Config.Duo.Enabled = true
+
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", duoPollCheckPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	_, err = checkRequestHandlerCode(req, state.duoPollCheckHandler,
+		http.StatusPreconditionFailed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A transaction started by another user must not be usable.
+	state.setDuoPushTransaction(cookieVal, pushPollTransaction{
+		Username: "other", TransactionID: "tx"})
+	_, err = checkRequestHandlerCode(req, state.duoPollCheckHandler,
+		http.StatusPreconditionFailed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookieVal, err = state.setNewAuthCookie(nil, nil, "username",
+		AuthTypePassword|AuthTypeDuo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest("GET", duoPollCheckPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	_, err = checkRequestHandlerCode(req, state.duoPollCheckHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckDuoEnforcement(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.Config.Duo.EnforceGroups = []string{"admins"}
+	if err := state.checkDuoEnforcement("username", AuthTypePassword); err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Duo.Enabled = true
+	// Group lookup fails, so enforcement must fail closed.
+	state.Config.UserInfo.Ldap.LDAPTargetURLs = "ldaps://127.0.0.1:1"
+	if err := state.checkDuoEnforcement("username", AuthTypePassword); err == nil {
+		t.Fatal("enforcement should fail when groups cannot be looked up")
+	}
+	if err := state.checkDuoEnforcement("username", AuthTypeDuo); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.checkDuoEnforcement("username", AuthTypeIPCertificate); err != nil {
+		t.Fatal(err)
+	}
+	// No group source: nobody is a member of an enforced group.
+	state.Config.UserInfo.Ldap.LDAPTargetURLs = ""
+	if err := state.checkDuoEnforcement("username", AuthTypePassword); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -28,7 +28,7 @@ const totpValidateNewPath = "/totp/ValidateNew/"
 
 func (state *RuntimeState) encryptWithPublicKeys(clearTextMessage []byte) ([][]byte, error) {
 	var cipherTexts [][]byte
-	for _, key := range state.KeymasterPublicKeys {
+	for _, key := range state.currentCA().publicKeys {
 		logger.Debugf(3, "encryptWithPublicKeys: On internal loop with type %T", key)
 		// TODO: do Handle ECC keys
 		rsaPubKey, ok := key.(*rsa.PublicKey)
@@ -37,7 +37,7 @@ func (state *RuntimeState) encryptWithPublicKeys(clearTextMessage []byte) ([][]b
 			rng := rand.Reader
 			ciphertext, err := rsa.EncryptOAEP(sha256.New(), rng, rsaPubKey, clearTextMessage, label)
 			if err != nil {
-				logger.Printf("Error from encryption: %s\n", err)
+				logger.Errorf("Error from encryption: %s\n", err)
 				return nil, err
 			}
 			cipherTexts = append(cipherTexts, ciphertext)
@@ -51,15 +51,16 @@ func (state *RuntimeState) encryptWithPublicKeys(clearTextMessage []byte) ([][]b
 }
 
 func (state *RuntimeState) decryptWithPublicKeys(cipherTexts [][]byte) ([]byte, error) {
-	logger.Debugf(5, "signer type=%T", state.Signer)
+	signer := state.currentCA().signer
+	logger.Debugf(5, "signer type=%T", signer)
 	for _, cipherText := range cipherTexts {
-		rsaPrivateKey, ok := state.Signer.(*rsa.PrivateKey)
+		rsaPrivateKey, ok := signer.(*rsa.PrivateKey)
 		if ok {
 			label := []byte(labelRSA)
 			rng := rand.Reader
 			plaintext, err := rsa.DecryptOAEP(sha256.New(), rng, rsaPrivateKey, cipherText, label)
 			if err != nil {
-				logger.Printf("Error from decryption: %s\n", err)
+				logger.Errorf("Error from decryption: %s\n", err)
 				continue
 			}
 			return plaintext, nil
@@ -76,7 +77,7 @@ func (state *RuntimeState) GenerateNewTOTP(w http.ResponseWriter, r *http.Reques
 	// TODO: think if we are going to allow admins to register these tokens
 	authUser, _, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		return
 	}
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
@@ -85,42 +86,42 @@ func (state *RuntimeState) GenerateNewTOTP(w http.ResponseWriter, r *http.Reques
 
 	// TODO: check for method, we should only allow POST requests
 
-	profile, _, fromCache, err := state.LoadUserProfile(authUser)
+	profile, _, fromCache, err := state.LoadUserProfileContext(r.Context(), authUser)
 	if err != nil {
-		logger.Printf("loading profile error: %v", err)
+		requestLogger(r).Errorf("loading profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
 	if fromCache {
-		logger.Printf("DB is being cached and requesting registration aborting it")
+		requestLogger(r).Printf("DB is being cached and requesting registration aborting it")
 		state.writeFailureResponse(w, r, http.StatusServiceUnavailable, "DB in cached state, cannot create new TOTP now")
 		return
 	}
-	logger.Debugf(2, "%v", profile)
+	requestLogger(r).Debugf(2, "%v", profile)
 
 	key, err := totp.Generate(totp.GenerateOpts{
 		Issuer:      state.HostIdentity,
 		AccountName: authUser,
 	})
 	if err != nil {
-		logger.Printf("generating new key error: %v", err)
+		requestLogger(r).Errorf("generating new key error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
 	encryptedKeys, err := state.encryptWithPublicKeys([]byte(key.Secret()))
 	if err != nil {
-		logger.Printf("Encrypting key error: %v", err)
+		requestLogger(r).Errorf("Encrypting key error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
 	profile.PendingTOTPSecret = &encryptedKeys
-	err = state.SaveUserProfile(authUser, profile)
+	err = state.SaveUserProfileContext(r.Context(), authUser, profile)
 	if err != nil {
-		logger.Printf("Saving profile error: %v", err)
+		requestLogger(r).Errorf("Saving profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
-	logger.Debugf(3, "Generate TOTP: profile=%+v", profile)
+	requestLogger(r).Debugf(3, "Generate TOTP: profile=%+v", profile)
 	// Convert TOTP key into a PNG
 	var buf bytes.Buffer
 	img, err := key.Image(200, 200)
@@ -129,21 +130,22 @@ func (state *RuntimeState) GenerateNewTOTP(w http.ResponseWriter, r *http.Reques
 	}
 	png.Encode(&buf, img)
 	base64Image := base64.StdEncoding.EncodeToString(buf.Bytes())
-	logger.Debugf(10, "base64image=%s", base64Image)
+	requestLogger(r).Debugf(10, "base64image=%s", base64Image)
 	// We need custom CSP policy to allow embedded images
 	w.Header().Set("Content-Security-Policy", "default-src 'self' ;img-src 'self'  data: ;style-src 'self' fonts.googleapis.com 'unsafe-inline'; font-src fonts.gstatic.com fonts.googleapis.com")
 	displayData := newTOTPPageTemplateData{
-		AuthUsername:    authUser,
-		Title:           "New TOTP Generation", //TODO: maybe include username?
-		TOTPSecret:      key.Secret(),
-		TOTPBase64Image: template.HTML("<img src=\"data:image/png;base64," + base64Image + "\" alt=\"beastie.png\" scale=\"0\" />"),
+		AuthUsername:        authUser,
+		Title:               "New TOTP Generation", //TODO: maybe include username?
+		TOTPSecret:          key.Secret(),
+		TOTPProvisioningURI: key.URL(),
+		TOTPBase64Image:     template.HTML("<img src=\"data:image/png;base64," + base64Image + "\" alt=\"beastie.png\" scale=\"0\" />"),
 	}
 	returnAcceptType := getPreferredAcceptType(r)
 	switch returnAcceptType {
 	case "text/html":
 		err = state.htmlTemplate.ExecuteTemplate(w, "newTOTPage", displayData)
 		if err != nil {
-			logger.Printf("Failed to execute %v", err)
+			requestLogger(r).Errorf("Failed to execute %v", err)
 			http.Error(w, "error", http.StatusInternalServerError)
 			return
 		}
@@ -156,24 +158,24 @@ func (state *RuntimeState) GenerateNewTOTP(w http.ResponseWriter, r *http.Reques
 func (state *RuntimeState) validateNewTOTP(w http.ResponseWriter, r *http.Request) {
 	authUser, _, otpValue, err := state.commonTOTPPostHandler(w, r, state.getRequiredWebUIAuthLevel())
 	if err != nil {
-		logger.Printf("Error in common Handler")
+		requestLogger(r).Errorf("Error in common Handler")
 		return
 	}
 	OTPString := fmt.Sprintf("%06d", otpValue)
-	profile, _, fromCache, err := state.LoadUserProfile(authUser)
+	profile, _, fromCache, err := state.LoadUserProfileContext(r.Context(), authUser)
 	if err != nil {
-		logger.Printf("loading profile error: %v", err)
+		requestLogger(r).Errorf("loading profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 
 	}
 	if fromCache {
-		logger.Printf("DB is being cached and requesting registration aborting it")
+		requestLogger(r).Printf("DB is being cached and requesting registration aborting it")
 		http.Error(w, "db backend is offline for writes", http.StatusServiceUnavailable)
 		return
 	}
 	if profile.PendingTOTPSecret == nil {
-		logger.Printf("No pending Secrets")
+		requestLogger(r).Printf("No pending Secrets")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "No pending Secrets")
 		return
 	}
@@ -181,14 +183,14 @@ func (state *RuntimeState) validateNewTOTP(w http.ResponseWriter, r *http.Reques
 	encryptedKeys := profile.PendingTOTPSecret
 	clearTextKey, err := state.decryptWithPublicKeys(*encryptedKeys)
 	if err != nil {
-		logger.Printf("Decrypting secret error: %v", err)
+		requestLogger(r).Errorf("Decrypting secret error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
 	valid := totp.Validate(OTPString, string(clearTextKey))
 	if !valid {
 		//render try again vailidate page, with an error message
-		logger.Printf("Invalid Entry")
+		requestLogger(r).Warnf("Invalid Entry")
 		w.WriteHeader(http.StatusBadRequest)
 		displayData := newTOTPPageTemplateData{
 			AuthUsername: authUser,
@@ -200,7 +202,7 @@ func (state *RuntimeState) validateNewTOTP(w http.ResponseWriter, r *http.Reques
 		case "text/html":
 			err = state.htmlTemplate.ExecuteTemplate(w, "newTOTPage", displayData)
 			if err != nil {
-				logger.Printf("Failed to execute %v", err)
+				requestLogger(r).Errorf("Failed to execute %v", err)
 				//http.Error(w, "error", http.StatusInternalServerError)
 				return
 			}
@@ -220,9 +222,9 @@ func (state *RuntimeState) validateNewTOTP(w http.ResponseWriter, r *http.Reques
 	newIndex := newTOTPAuthData.CreatedAt.Unix()
 	profile.TOTPAuthData[newIndex] = &newTOTPAuthData
 	profile.PendingTOTPSecret = nil
-	err = state.SaveUserProfile(authUser, profile)
+	err = state.SaveUserProfileContext(r.Context(), authUser, profile)
 	if err != nil {
-		logger.Printf("Saving profile error: %v", err)
+		requestLogger(r).Errorf("Saving profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -240,24 +242,24 @@ func (state *RuntimeState) totpTokenManagerHandler(w http.ResponseWriter, r *htt
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
 	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
 	// TODO: ensure is a valid method (POST)
 	if r.Method != "POST" {
-		logger.Printf("Wanted Post got='%s'", r.Method)
+		requestLogger(r).Printf("Wanted Post got='%s'", r.Method)
 		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
 		return
 	}
 	err = r.ParseForm()
 	if err != nil {
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
 		return
 	}
-	logger.Debugf(3, "Form: %+v", r.Form)
+	requestLogger(r).Debugf(3, "Form: %+v", r.Form)
 
 	assumedUser := r.Form.Get("username")
 
@@ -266,28 +268,28 @@ func (state *RuntimeState) totpTokenManagerHandler(w http.ResponseWriter, r *htt
 
 	// Check params
 	if !hasAdminRights && assumedUser != authUser {
-		logger.Printf("bad username authUser=%s requested=%s", authUser, r.Form.Get("username"))
+		requestLogger(r).Warnf("bad username authUser=%s requested=%s", authUser, r.Form.Get("username"))
 		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 		return
 	}
 
 	tokenIndex, err := strconv.ParseInt(r.Form.Get("index"), 10, 64)
 	if err != nil {
-		logger.Printf("tokenindex is not a number")
+		requestLogger(r).Printf("tokenindex is not a number")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "tokenindex is not a number")
 		return
 	}
 
 	//Do a redirect
-	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
+	profile, _, fromCache, err := state.LoadUserProfileContext(r.Context(), assumedUser)
 	if err != nil {
-		logger.Printf("loading profile error: %v", err)
+		requestLogger(r).Errorf("loading profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 
 	}
 	if fromCache {
-		logger.Printf("DB is being cached and requesting registration aborting it")
+		requestLogger(r).Printf("DB is being cached and requesting registration aborting it")
 		http.Error(w, "db backend is offline for writes", http.StatusServiceUnavailable)
 		return
 	}
@@ -295,7 +297,7 @@ func (state *RuntimeState) totpTokenManagerHandler(w http.ResponseWriter, r *htt
 	// Todo: check for negative values
 	_, ok := profile.TOTPAuthData[tokenIndex]
 	if !ok {
-		logger.Printf("bad index number")
+		requestLogger(r).Warnf("bad index number")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "bad index Value")
 		return
 	}
@@ -304,7 +306,7 @@ func (state *RuntimeState) totpTokenManagerHandler(w http.ResponseWriter, r *htt
 	case "Update":
 		tokenName := r.Form.Get("name")
 		if m, _ := regexp.MatchString("^[-/.a-zA-Z0-9_ ]+$", tokenName); !m {
-			logger.Printf("%s", tokenName)
+			requestLogger(r).Printf("%s", tokenName)
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "invalidtokenName")
 			return
 		}
@@ -319,9 +321,9 @@ func (state *RuntimeState) totpTokenManagerHandler(w http.ResponseWriter, r *htt
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Operation")
 		return
 	}
-	err = state.SaveUserProfile(assumedUser, profile)
+	err = state.SaveUserProfileContext(r.Context(), assumedUser, profile)
 	if err != nil {
-		logger.Printf("Saving profile error: %v", err)
+		requestLogger(r).Errorf("Saving profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -351,7 +353,7 @@ func (state *RuntimeState) validateUserTOTP(username string, OTPValue int, t tim
 	//Do a redirect
 	profile, _, fromCache, err := state.LoadUserProfile(username)
 	if err != nil {
-		logger.Printf("validateUserTOTP: loading profile error: %v", err)
+		logger.Errorf("validateUserTOTP: loading profile error: %v", err)
 		return false, err
 	}
 
@@ -399,7 +401,7 @@ func (state *RuntimeState) validateUserTOTP(username string, OTPValue int, t tim
 		}
 		clearTextKey, err := state.decryptWithPublicKeys(deviceInfo.EncryptedSecret)
 		if err != nil {
-			logger.Printf("Decrypting secret error: %v", err)
+			logger.Errorf("Decrypting secret error: %v", err)
 			return false, err
 		}
 
@@ -411,7 +413,7 @@ func (state *RuntimeState) validateUserTOTP(username string, OTPValue int, t tim
 			profile.LastSuccessfullTOTPCounter = counter
 			err = state.SaveUserProfile(username, profile)
 			if err != nil {
-				logger.Printf("Saving profile error: %v", err)
+				logger.Errorf("Saving profile error: %v", err)
 				return false, err
 			}
 		}
@@ -443,36 +445,36 @@ func (state *RuntimeState) commonTOTPPostHandler(w http.ResponseWriter, r *http.
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
 	authUser, loginLevel, err := state.checkAuth(w, r, requiredAuthLevel)
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return "", 0, 0, err
 	}
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
 	// TODO: ensure is a valid method (POST)
 	if r.Method != "POST" {
-		logger.Printf("Wanted Post got='%s'", r.Method)
+		requestLogger(r).Printf("Wanted Post got='%s'", r.Method)
 		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
 		return "", 0, 0, errors.New("Invalid Method requeted")
 	}
 	err = r.ParseForm()
 	if err != nil {
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
 		return "", 0, 0, err
 	}
-	logger.Debugf(3, "Form: %+v", r.Form)
+	requestLogger(r).Debugf(3, "Form: %+v", r.Form)
 	var OTPString string
 	if val, ok := r.Form["OTP"]; ok {
 		if len(val) > 1 {
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "Just one OTP Value allowed")
-			logger.Printf("Login with multiple OTP Values")
+			requestLogger(r).Printf("Login with multiple OTP Values")
 			return "", 0, 0, errors.New("multiple OTP values")
 		}
 		OTPString = val[0]
 	}
 	otpValue, err := strconv.Atoi(OTPString)
 	if err != nil {
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing OTP value")
 		return "", 0, 0, err
 	}
@@ -485,12 +487,12 @@ const totpVerifyHandlerPath = "/api/v0/VerifyTOTP"
 func (state *RuntimeState) verifyTOTPHandler(w http.ResponseWriter, r *http.Request) {
 	authUser, _, otpValue, err := state.commonTOTPPostHandler(w, r, state.getRequiredWebUIAuthLevel())
 	if err != nil {
-		logger.Printf("Error in common Handler")
+		requestLogger(r).Errorf("Error in common Handler")
 		return
 	}
 	valid, err := state.validateUserTOTP(authUser, otpValue, time.Now())
 	if err != nil {
-		logger.Printf("Error validating UserTOTP. Err: %s", err)
+		requestLogger(r).Errorf("Error validating UserTOTP. Err: %s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		return
 	}
@@ -507,25 +509,26 @@ func (state *RuntimeState) verifyTOTPHandler(w http.ResponseWriter, r *http.Requ
 const totpAuthPath = "/api/v0/TOTPAuth"
 
 func (state *RuntimeState) TOTPAuthHandler(w http.ResponseWriter, r *http.Request) {
-	logger.Debugf(1, "Top of TOTPAuthHandler")
+	requestLogger(r).Debugf(1, "Top of TOTPAuthHandler")
 	authUser, currentAuthLevel, otpValue, err := state.commonTOTPPostHandler(w, r, AuthTypeAny)
 	if err != nil {
-		logger.Printf("Error in common Handler err:%s", err)
+		requestLogger(r).Errorf("Error in common Handler err:%s", err)
 		return
 	}
-	logger.Debugf(1, "TOTPAuthHandler, After commonPostHandler, currentAuthLevel=%x", currentAuthLevel)
+	requestLogger(r).Debugf(1, "TOTPAuthHandler, After commonPostHandler, currentAuthLevel=%x", currentAuthLevel)
 	state.internalTOTPAuthHandler(w, r, authUser, currentAuthLevel, otpValue)
 	return
 }
 func (state *RuntimeState) internalTOTPAuthHandler(w http.ResponseWriter, r *http.Request, authUser string, currentAuthLevel int, otpValue int) {
 	valid, err := state.validateUserTOTP(authUser, otpValue, time.Now())
 	if err != nil {
-		logger.Printf("Error validating TOTP %s", err)
+		requestLogger(r).Errorf("Error validating TOTP %s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure when validating OTP token")
 		return
 	}
 	if !valid {
-		logger.Printf("Invalid OTP value login for %s", authUser)
+		requestLogger(r).Warnf("Invalid OTP value login for %s", authUser)
+		state.recordAuthFailure(r, authUser, proto.AuthTypeTOTP)
 		// TODO if client is html then do a redirect back to vipLoginPage
 		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 		return
@@ -533,7 +536,7 @@ func (state *RuntimeState) internalTOTPAuthHandler(w http.ResponseWriter, r *htt
 
 	_, err = state.updateAuthCookieAuthlevel(w, r, currentAuthLevel|AuthTypeTOTP)
 	if err != nil {
-		logger.Printf("Auth Cookie NOT found ? %s", err)
+		requestLogger(r).Printf("Auth Cookie NOT found ? %s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure when validating OTP token")
 		return
 	}
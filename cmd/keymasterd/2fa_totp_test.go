@@ -68,7 +68,7 @@ func TestGenerateNewTOTPSuccess(t *testing.T) {
 		t.Fatal(err)
 		//return nil, err
 	}
-	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -86,6 +86,9 @@ func TestGenerateNewTOTPSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 	t.Logf("totpDataToken='%+v'", resultAccessToken)
+	if !strings.HasPrefix(resultAccessToken.TOTPProvisioningURI, "otpauth://totp/") {
+		t.Fatalf("bad provisioning URI: %s", resultAccessToken.TOTPProvisioningURI)
+	}
 
 	// now we validate
 	otpValue, err := totp.GenerateCode(resultAccessToken.TOTPSecret, time.Now())
@@ -161,7 +164,7 @@ func setupTestStateWithTOTPSecret(t *testing.T, state *RuntimeState, cookieAuth
 	if err != nil {
 		t.Fatal(err)
 	}
-	cookieVal, err := state.setNewAuthCookie(nil, "username", cookieAuth)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", cookieAuth)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -283,7 +286,7 @@ func TestTOTPTokenManagerHandlerUpdateSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 	//
-	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeAny)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeAny)
 	if err != nil {
 		t.Fatal(err)
 	}
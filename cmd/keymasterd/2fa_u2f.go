@@ -48,7 +48,7 @@ func (state *RuntimeState) u2fRegisterRequest(w http.ResponseWriter, r *http.Req
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
 	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		return
 	}
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
@@ -59,22 +59,22 @@ func (state *RuntimeState) u2fRegisterRequest(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
+	profile, _, fromCache, err := state.LoadUserProfileContext(r.Context(), assumedUser)
 	if err != nil {
-		logger.Printf("loading profile error: %v", err)
+		requestLogger(r).Errorf("loading profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 
 	}
 	if fromCache {
-		logger.Printf("DB is being cached and requesting registration aborting it")
+		requestLogger(r).Printf("DB is being cached and requesting registration aborting it")
 		http.Error(w, "db backend is offline for writes", http.StatusServiceUnavailable)
 		return
 	}
 
-	c, err := u2f.NewChallenge(u2fAppID, u2fTrustedFacets)
+	c, err := u2f.NewChallenge(state.u2fAppID, state.u2fTrustedFacets)
 	if err != nil {
-		logger.Printf("u2f.NewChallenge error: %v", err)
+		requestLogger(r).Errorf("u2f.NewChallenge error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -82,10 +82,10 @@ func (state *RuntimeState) u2fRegisterRequest(w http.ResponseWriter, r *http.Req
 	registrations := getRegistrationArray(profile.U2fAuthData)
 	req := u2f.NewWebRegisterRequest(c, registrations)
 
-	logger.Printf("registerRequest: %+v", req)
-	err = state.SaveUserProfile(assumedUser, profile)
+	requestLogger(r).Printf("registerRequest: %+v", req)
+	err = state.SaveUserProfileContext(r.Context(), assumedUser, profile)
 	if err != nil {
-		logger.Printf("Saving profile error: %v", err)
+		requestLogger(r).Errorf("Saving profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -116,7 +116,7 @@ func (state *RuntimeState) u2fRegisterResponse(w http.ResponseWriter, r *http.Re
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
 	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		return
 	}
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
@@ -133,14 +133,14 @@ func (state *RuntimeState) u2fRegisterResponse(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
+	profile, _, fromCache, err := state.LoadUserProfileContext(r.Context(), assumedUser)
 	if err != nil {
-		logger.Printf("loading profile error: %v", err)
+		requestLogger(r).Errorf("loading profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
 	if fromCache {
-		logger.Printf("DB is being cached and requesting registration aborting it")
+		requestLogger(r).Printf("DB is being cached and requesting registration aborting it")
 		http.Error(w, "db backend is offline for writes", http.StatusServiceUnavailable)
 		return
 	}
@@ -155,7 +155,7 @@ func (state *RuntimeState) u2fRegisterResponse(w http.ResponseWriter, r *http.Re
 
 	reg, err := u2f.Register(regResp, *profile.RegistrationChallenge, &u2fConfig)
 	if err != nil {
-		logger.Printf("u2f.Register error: %v", err)
+		requestLogger(r).Errorf("u2f.Register error: %v", err)
 		http.Error(w, "error verifying response", http.StatusInternalServerError)
 		return
 	}
@@ -171,12 +171,12 @@ func (state *RuntimeState) u2fRegisterResponse(w http.ResponseWriter, r *http.Re
 	//registrations = append(registrations, *reg)
 	//counter = 0
 
-	logger.Printf("Registration success: %+v", reg)
+	requestLogger(r).Printf("Registration success: %+v", reg)
 
 	profile.RegistrationChallenge = nil
-	err = state.SaveUserProfile(assumedUser, profile)
+	err = state.SaveUserProfileContext(r.Context(), assumedUser, profile)
 	if err != nil {
-		logger.Printf("Saving profile error: %v", err)
+		requestLogger(r).Errorf("Saving profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -195,15 +195,15 @@ func (state *RuntimeState) u2fSignRequest(w http.ResponseWriter, r *http.Request
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
 	authUser, _, err := state.checkAuth(w, r, AuthTypeAny)
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		return
 	}
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
 
 	//////////
-	profile, ok, _, err := state.LoadUserProfile(authUser)
+	profile, ok, _, err := state.LoadUserProfileContext(r.Context(), authUser)
 	if err != nil {
-		logger.Printf("loading profile error: %v", err)
+		requestLogger(r).Errorf("loading profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -219,9 +219,9 @@ func (state *RuntimeState) u2fSignRequest(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	c, err := u2f.NewChallenge(u2fAppID, u2fTrustedFacets)
+	c, err := u2f.NewChallenge(state.u2fAppID, state.u2fTrustedFacets)
 	if err != nil {
-		logger.Printf("u2f.NewChallenge error: %v", err)
+		requestLogger(r).Errorf("u2f.NewChallenge error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -235,10 +235,10 @@ func (state *RuntimeState) u2fSignRequest(w http.ResponseWriter, r *http.Request
 	state.Mutex.Unlock()
 
 	req := c.SignRequest(registrations)
-	logger.Debugf(3, "Sign request: %+v", req)
+	requestLogger(r).Debugf(3, "Sign request: %+v", req)
 
 	if err := json.NewEncoder(w).Encode(req); err != nil {
-		logger.Printf("json encofing error: %v", err)
+		requestLogger(r).Errorf("json encofing error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -256,7 +256,7 @@ func (state *RuntimeState) u2fSignResponse(w http.ResponseWriter, r *http.Reques
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
 	authUser, currentAuthLevel, err := state.checkAuth(w, r, AuthTypeAny)
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		return
 	}
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
@@ -268,11 +268,11 @@ func (state *RuntimeState) u2fSignResponse(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	logger.Debugf(1, "signResponse: %+v", signResp)
+	requestLogger(r).Debugf(1, "signResponse: %+v", signResp)
 
-	profile, ok, _, err := state.LoadUserProfile(authUser)
+	profile, ok, _, err := state.LoadUserProfileContext(r.Context(), authUser)
 	if err != nil {
-		logger.Printf("loading profile error: %v", err)
+		requestLogger(r).Errorf("loading profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 
@@ -308,7 +308,7 @@ func (state *RuntimeState) u2fSignResponse(w http.ResponseWriter, r *http.Reques
 		if authErr == nil {
 			metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, true)
 
-			logger.Debugf(0, "newCounter: %d", newCounter)
+			requestLogger(r).Debugf(0, "newCounter: %d", newCounter)
 			//counter = newCounter
 			u2fReg.Counter = newCounter
 			//profile.U2fAuthData[i].Counter = newCounter
@@ -324,7 +324,7 @@ func (state *RuntimeState) u2fSignResponse(w http.ResponseWriter, r *http.Reques
 			}
 			_, err = state.updateAuthCookieAuthlevel(w, r, currentAuthLevel|AuthTypeU2F)
 			if err != nil {
-				logger.Printf("Auth Cookie NOT found ? %s", err)
+				requestLogger(r).Printf("Auth Cookie NOT found ? %s", err)
 				state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure updating vip token")
 				return
 			}
@@ -335,7 +335,8 @@ func (state *RuntimeState) u2fSignResponse(w http.ResponseWriter, r *http.Reques
 		}
 	}
 	metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, false)
+	state.recordAuthFailure(r, authUser, proto.AuthTypeU2F)
 
-	logger.Printf("VerifySignResponse error: %v", err)
+	requestLogger(r).Errorf("VerifySignResponse error: %v", err)
 	http.Error(w, "error verifying response", http.StatusInternalServerError)
 }
@@ -15,7 +15,7 @@ import (
 func (state *RuntimeState) startVIPPush(cookieVal string, username string) error {
 	transactionId, err := state.Config.SymantecVIP.Client.StartUserVIPPush(username)
 	if err != nil {
-		logger.Println(err)
+		logger.Errorf("%s", err)
 		return err
 	}
 	newLocalData := pushPollTransaction{Username: username, TransactionID: transactionId, ExpiresAt: time.Now().Add(maxAgeSecondsVIPCookie * time.Second)}
@@ -37,18 +37,18 @@ func (state *RuntimeState) VIPAuthHandler(w http.ResponseWriter, r *http.Request
 	//Check for valid method here?
 	switch r.Method {
 	case "GET":
-		logger.Debugf(3, "Got client GET connection")
+		requestLogger(r).Debugf(3, "Got client GET connection")
 		err := r.ParseForm()
 		if err != nil {
-			logger.Println(err)
+			requestLogger(r).Errorf("%s", err)
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
 			return
 		}
 	case "POST":
-		logger.Debugf(3, "Got client POST connection")
+		requestLogger(r).Debugf(3, "Got client POST connection")
 		err := r.ParseForm()
 		if err != nil {
-			logger.Println(err)
+			requestLogger(r).Errorf("%s", err)
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
 			return
 		}
@@ -59,7 +59,7 @@ func (state *RuntimeState) VIPAuthHandler(w http.ResponseWriter, r *http.Request
 	//authUser, authType, err := state.checkAuth(w, r, AuthTypeAny)
 	authUser, currentAuthLevel, err := state.checkAuth(w, r, AuthTypeAny)
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		return
 	}
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
@@ -68,19 +68,19 @@ func (state *RuntimeState) VIPAuthHandler(w http.ResponseWriter, r *http.Request
 	if val, ok := r.Form["OTP"]; ok {
 		if len(val) > 1 {
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "Just one OTP Value allowed")
-			logger.Printf("Login with multiple OTP Values")
+			requestLogger(r).Printf("Login with multiple OTP Values")
 			return
 		}
 		OTPString = val[0]
 	}
 	otpValue, err := strconv.Atoi(OTPString)
 	if err != nil {
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing OTP value")
 		return
 	}
-	if !state.Config.SymantecVIP.Enabled {
-		logger.Printf("request for VIP auth, but VIP not enabled")
+	if !state.activeIssuancePolicy().SymantecVIPEnabled {
+		requestLogger(r).Printf("request for VIP auth, but VIP not enabled")
 		state.writeFailureResponse(w, r, http.StatusPreconditionFailed, "VIP not enabled")
 		return
 	}
@@ -88,7 +88,7 @@ func (state *RuntimeState) VIPAuthHandler(w http.ResponseWriter, r *http.Request
 	start := time.Now()
 	valid, err := state.Config.SymantecVIP.Client.ValidateUserOTP(authUser, otpValue)
 	if err != nil {
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure when validating VIP token")
 		return
 	}
@@ -98,7 +98,8 @@ func (state *RuntimeState) VIPAuthHandler(w http.ResponseWriter, r *http.Request
 	//
 	metricLogAuthOperation(getClientType(r), proto.AuthTypeSymantecVIP, valid)
 	if !valid {
-		logger.Printf("Invalid VIP OTP value login for %s", authUser)
+		requestLogger(r).Warnf("Invalid VIP OTP value login for %s", authUser)
+		state.recordAuthFailure(r, authUser, proto.AuthTypeSymantecVIP)
 		// TODO if client is html then do a redirect back to vipLoginPage
 		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 		return
@@ -106,11 +107,11 @@ func (state *RuntimeState) VIPAuthHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// OTP check was  successful
-	logger.Debugf(1, "Successful vipOTP auth for user: %s", authUser)
+	requestLogger(r).Debugf(1, "Successful vipOTP auth for user: %s", authUser)
 	eventNotifier.PublishVIPAuthEvent(eventmon.VIPAuthTypeOTP, authUser)
 	_, err = state.updateAuthCookieAuthlevel(w, r, currentAuthLevel|AuthTypeSymantecVIP)
 	if err != nil {
-		logger.Printf("Auth Cookie NOT found ? %s", err)
+		requestLogger(r).Printf("Auth Cookie NOT found ? %s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure when validating VIP token")
 		return
 	}
@@ -147,40 +148,40 @@ func (state *RuntimeState) vipPushStartHandler(w http.ResponseWriter, r *http.Re
 	if state.sendFailureToClientIfLocked(w, r) {
 		return
 	}
-	if !state.Config.SymantecVIP.Enabled {
-		logger.Printf("asked for push status but VIP is not enabled")
+	if !state.activeIssuancePolicy().SymantecVIPEnabled {
+		requestLogger(r).Printf("asked for push status but VIP is not enabled")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "")
 		return
 	}
 	authUser, _, err := state.checkAuth(w, r, AuthTypeAny)
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		return
 	}
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
-	logger.Debugf(0, "Vip push start authuser=%s", authUser)
+	requestLogger(r).Debugf(0, "Vip push start authuser=%s", authUser)
 	vipPushCookie, err := r.Cookie(vipTransactionCookieName)
 	if err != nil {
-		logger.Printf("%v", err)
+		requestLogger(r).Printf("%v", err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Missing Cookie")
 		return
 	}
 	pushTransaction, ok := state.getPushPollTransaction(vipPushCookie.Value)
 	if ok {
 		err := errors.New("push transaction found will not start another one")
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Push already sent")
 		return
 	}
 	if len(pushTransaction.TransactionID) > 0 {
 		err := errors.New("VIP push transaction already initiated")
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		state.writeFailureResponse(w, r, http.StatusPreconditionFailed, "Push already sent")
 		return
 	}
 	err = state.startVIPPush(vipPushCookie.Value, authUser)
 	if err != nil {
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Cookie not setup ")
 		return
 	}
@@ -196,8 +197,8 @@ func (state *RuntimeState) VIPPollCheckHandler(w http.ResponseWriter, r *http.Re
 	if state.sendFailureToClientIfLocked(w, r) {
 		return
 	}
-	if !state.Config.SymantecVIP.Enabled {
-		logger.Printf("asked for push status but VIP is not enabled")
+	if !state.activeIssuancePolicy().SymantecVIPEnabled {
+		requestLogger(r).Printf("asked for push status but VIP is not enabled")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "")
 		return
 	}
@@ -205,18 +206,18 @@ func (state *RuntimeState) VIPPollCheckHandler(w http.ResponseWriter, r *http.Re
 	//Check for valid method here?
 	switch r.Method {
 	case "GET":
-		logger.Debugf(3, "Got client GET connection")
+		requestLogger(r).Debugf(3, "Got client GET connection")
 		err := r.ParseForm()
 		if err != nil {
-			logger.Println(err)
+			requestLogger(r).Errorf("%s", err)
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
 			return
 		}
 	case "POST":
-		logger.Debugf(3, "Got client POST connection")
+		requestLogger(r).Debugf(3, "Got client POST connection")
 		err := r.ParseForm()
 		if err != nil {
-			logger.Println(err)
+			requestLogger(r).Errorf("%s", err)
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
 			return
 		}
@@ -226,34 +227,34 @@ func (state *RuntimeState) VIPPollCheckHandler(w http.ResponseWriter, r *http.Re
 	}
 	authUser, currentAuthLevel, err := state.checkAuth(w, r, AuthTypeAny)
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		return
 	}
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
-	logger.Debugf(1, "VIPPollCheckHandler: authuser=%s", authUser)
+	requestLogger(r).Debugf(1, "VIPPollCheckHandler: authuser=%s", authUser)
 	vipPollCookie, err := r.Cookie(vipTransactionCookieName)
 	if err != nil {
-		logger.Printf("VIPPollCheckHandler: error getting poll cookie %v", err)
+		requestLogger(r).Errorf("VIPPollCheckHandler: error getting poll cookie %v", err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Missing Cookie")
 		return
 	}
 	pushTransaction, ok := state.getPushPollTransaction(vipPollCookie.Value)
 	if !ok {
 		err := errors.New("VIPPollCheckHandler: push transaction not found for user")
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		state.writeFailureResponse(w, r, http.StatusPreconditionFailed, "Error parsing form")
 		return
 	}
 	//TODO: check username
 	valid, err := state.Config.SymantecVIP.Client.VipPushHasBeenApproved(pushTransaction.TransactionID)
 	if err != nil {
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Error checking push transaction")
 		return
 	}
 	if !valid {
 		err := errors.New("Not yet") // usually it is not valid, no need to spam the log
-		logger.Debugf(1, "%s", err)
+		requestLogger(r).Debugf(1, "%s", err)
 		state.writeFailureResponse(w, r, http.StatusPreconditionFailed, "VIP Push Poller unsuccessful")
 		return
 	}
@@ -261,7 +262,7 @@ func (state *RuntimeState) VIPPollCheckHandler(w http.ResponseWriter, r *http.Re
 	// VIP Push check was  successful
 	_, err = state.updateAuthCookieAuthlevel(w, r, currentAuthLevel|AuthTypeSymantecVIP)
 	if err != nil {
-		logger.Printf("VIPPollCheckHandler:  Failure to update AuthCookie %s", err)
+		requestLogger(r).Printf("VIPPollCheckHandler:  Failure to update AuthCookie %s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure when validating VIP token")
 		return
 	}
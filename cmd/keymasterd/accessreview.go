@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/accessreview"
+	"github.com/Symantec/keymaster/keymasterd/lifecycle"
+)
+
+const accessReviewPath = "/accessReview"
+
+const (
+	accessReviewComponentName        = "access_review"
+	accessReviewsDirectory           = "access_reviews"
+	defaultAccessReviewLookbackDays  = 90
+	accessReviewGroupsLookupTimeout  = 10 * time.Second
+	defaultDelegationMaxDurationSecs = uint64(maxCertDuration / time.Second)
+)
+
+// AccessReviewConfig adds users to the access review report and schedules
+// exports of it.
+type AccessReviewConfig struct {
+	// Users to review on top of those known to keymasterd.
+	Users []string `yaml:"users"`
+	// Users who got certificates within this many days are reviewed too.
+	// Default: 90.
+	IssuanceLookbackDays uint `yaml:"issuance_lookback_days"`
+	// Export the report every this many hours. Default: only on demand.
+	ExportIntervalHours uint `yaml:"export_interval_hours"`
+	// Default: access_reviews in the data directory.
+	ExportDirectory string `yaml:"export_directory"`
+	// csv (the default) or json.
+	ExportFormat string `yaml:"export_format"`
+}
+
+func (config *AccessReviewConfig) check() error {
+	switch config.ExportFormat {
+	case "", "csv", "json":
+		return nil
+	}
+	return fmt.Errorf("access_review: unknown export_format: %s",
+		config.ExportFormat)
+}
+
+func (config *AccessReviewConfig) lookback() time.Duration {
+	days := config.IssuanceLookbackDays
+	if days < 1 {
+		days = defaultAccessReviewLookbackDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// htpasswdUsernames returns the users of the htpasswd file, if any.
+func (state *RuntimeState) htpasswdUsernames() ([]string, error) {
+	if state.Config.Base.HtpasswdFilename == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(state.Config.Base.HtpasswdFilename)
+	if err != nil {
+		return nil, err
+	}
+	var usernames []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		usernames = append(usernames, strings.SplitN(line, ":", 2)[0])
+	}
+	return usernames, scanner.Err()
+}
+
+// accessReviewUsernames returns the users known to keymasterd: those with a
+// profile, in the htpasswd file, named in the configuration or who got
+// certificates recently. Roles are not users.
+func (state *RuntimeState) accessReviewUsernames(now time.Time) ([]string,
+	error) {
+	state.Mutex.Lock()
+	config := state.Config
+	state.Mutex.Unlock()
+	usernames := append([]string(nil), config.AccessReview.Users...)
+	usernames = append(usernames, config.Base.AdminUsers...)
+	usernames = append(usernames, config.Base.AutomationUsers...)
+	usernames = append(usernames, config.BreakGlass.Users...)
+	usernames = append(usernames, config.PolicyAudit.RepresentativeUsers...)
+	for _, role := range config.Roles {
+		usernames = append(usernames, role.AllowedUsers...)
+	}
+	for _, rule := range config.Delegation.Rules {
+		usernames = append(usernames, rule.Requesters...)
+	}
+	if state.profileStorage != nil {
+		profileUsers, _, err := state.GetUsers()
+		if err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, profileUsers...)
+	}
+	htpasswdUsers, err := state.htpasswdUsernames()
+	if err != nil {
+		return nil, err
+	}
+	usernames = append(usernames, htpasswdUsers...)
+	events, err := state.attestationLog.Events(
+		now.Add(-config.AccessReview.lookback()), now)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		usernames = append(usernames, event.Username, event.RequestedBy)
+	}
+	seen := make(map[string]struct{}, len(usernames))
+	var unique []string
+	for _, username := range usernames {
+		if username == "" {
+			continue
+		}
+		if _, ok := state.lookupRole(username); ok {
+			continue
+		}
+		if _, ok := seen[username]; ok {
+			continue
+		}
+		seen[username] = struct{}{}
+		unique = append(unique, username)
+	}
+	sort.Strings(unique)
+	return unique, nil
+}
+
+// accessReviewGrants returns what username in groups can get certificates
+// for under the current configuration.
+func (state *RuntimeState) accessReviewGrants(username string,
+	groups []string) []accessreview.Grant {
+	grants := []accessreview.Grant{{
+		Kind:            accessreview.GrantSelf,
+		Principals:      []string{username},
+		CertTypes:       []string{"ssh", "x509"},
+		MaxDurationSecs: uint64(maxCertDuration / time.Second),
+	}}
+	for _, role := range state.Config.Roles {
+		if !stringsIntersect([]string{username}, role.AllowedUsers) &&
+			!stringsIntersect(groups, role.AllowedGroups) {
+			continue
+		}
+		rule := role.delegationRule()
+		grants = append(grants, accessreview.Grant{
+			Kind:            accessreview.GrantRole,
+			Source:          "role " + role.Name,
+			Principals:      role.principals(),
+			CertTypes:       rule.CertTypes,
+			MaxDurationSecs: uint64(rule.MaxDurationSecs),
+		})
+	}
+	for index, rule := range state.Config.Delegation.Rules {
+		if !stringsIntersect([]string{username}, rule.Requesters) &&
+			!stringsIntersect(groups, rule.RequesterGroups) {
+			continue
+		}
+		certTypes := rule.CertTypes
+		if len(certTypes) < 1 {
+			certTypes = []string{"ssh"}
+		}
+		maxDurationSecs := uint64(rule.MaxDurationSecs)
+		if maxDurationSecs == 0 {
+			maxDurationSecs = defaultDelegationMaxDurationSecs
+		}
+		grants = append(grants, accessreview.Grant{
+			Kind:            accessreview.GrantDelegation,
+			Source:          fmt.Sprintf("delegation rule %d", index),
+			Principals:      rule.Targets,
+			CertTypes:       certTypes,
+			MaxDurationSecs: maxDurationSecs,
+		})
+	}
+	if stringsIntersect([]string{username}, state.Config.BreakGlass.Users) {
+		grants = append(grants, accessreview.Grant{
+			Kind:       accessreview.GrantBreakGlass,
+			Principals: []string{username},
+			CertTypes:  []string{"ssh"},
+			MaxDurationSecs: uint64(
+				state.Config.BreakGlass.maxDuration() / time.Second),
+		})
+	}
+	return grants
+}
+
+// generateAccessReview expands the policy in force and the directory groups
+// of every known user into the report.
+func (state *RuntimeState) generateAccessReview(ctx context.Context,
+	now time.Time) (*accessreview.Report, error) {
+	usernames, err := state.accessReviewUsernames(now)
+	if err != nil {
+		return nil, err
+	}
+	state.Mutex.Lock()
+	policy := state.Config.issuancePolicy()
+	state.Mutex.Unlock()
+	report := &accessreview.Report{
+		Generated:     now.UTC(),
+		PolicyVersion: state.activePolicyVersion().ID,
+		Users:         []accessreview.User{},
+	}
+	for _, username := range usernames {
+		user := accessreview.User{Username: username, Groups: []string{}}
+		lookupCtx, cancel := context.WithTimeout(ctx,
+			accessReviewGroupsLookupTimeout)
+		groups, err := state.getUserGroupsContext(lookupCtx, username)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			user.Error = err.Error()
+			report.Users = append(report.Users, user)
+			continue
+		}
+		if groups != nil {
+			user.Groups = append(user.Groups, groups...)
+			sort.Strings(user.Groups)
+		}
+		outcome := policy.evaluate(username, groups)
+		user.AuthMethods = outcome.CertAuthBackends
+		user.DuoRequired = outcome.DuoRequired
+		user.Automation = outcome.Automation
+		user.Grants = state.accessReviewGrants(username, groups)
+		report.Users = append(report.Users, user)
+	}
+	return report, nil
+}
+
+// accessReviewHandler is served on the admin port and returns the access
+// review report as JSON or, with format=csv, as CSV.
+func (state *RuntimeState) accessReviewHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"format must be json or csv")
+		return
+	}
+	report, err := state.generateAccessReview(r.Context(), time.Now())
+	if err != nil {
+		requestLogger(r).Errorf("Cannot generate access review: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition",
+			`attachment; filename="access-review.csv"`)
+		err = report.WriteCSV(w)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		err = report.WriteJSON(w)
+	}
+	if err != nil {
+		requestLogger(r).Errorf("Cannot write access review: %s", err)
+	}
+}
+
+func (state *RuntimeState) exportAccessReview(ctx context.Context,
+	now time.Time) (string, error) {
+	config := state.Config.AccessReview
+	directory := config.ExportDirectory
+	if directory == "" {
+		directory = filepath.Join(state.Config.Base.DataDirectory,
+			accessReviewsDirectory)
+	}
+	report, err := state.generateAccessReview(ctx, now)
+	if err != nil {
+		return "", err
+	}
+	return report.Save(directory, config.ExportFormat)
+}
+
+// accessReviewComponent exports the report every export_interval_hours, or
+// is nil if exports are not scheduled.
+func (state *RuntimeState) accessReviewComponent() lifecycle.Component {
+	hours := state.Config.AccessReview.ExportIntervalHours
+	if hours < 1 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return lifecycle.Funcs{
+		StartFunc: func() error {
+			go func() {
+				ticker := time.NewTicker(time.Duration(hours) * time.Hour)
+				defer ticker.Stop()
+				for {
+					select {
+					case t := <-ticker.C:
+						filename, err := state.exportAccessReview(ctx, t)
+						if err != nil {
+							if !errors.Is(err, context.Canceled) {
+								logger.Errorf("Cannot export access review: %s",
+									err)
+							}
+							continue
+						}
+						logger.Printf("Exported access review to %s", filename)
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		StopFunc: func() error {
+			cancel()
+			return nil
+		},
+	}
+}
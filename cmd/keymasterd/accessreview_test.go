@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Symantec/keymaster/keymasterd/accessreview"
+	"github.com/Symantec/keymaster/keymasterd/policyversions"
+)
+
+func TestAccessReviewHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "accessreview")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.policyVersions, err = policyversions.Open(
+		filepath.Join(dir, policyVersionsDirectory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Roles = []RoleConfig{{Name: "ansible",
+		AllowedUsers: []string{"alice"}, Principals: []string{"deploy"}}}
+	state.Config.Delegation.Rules = []DelegationRuleConfig{{
+		Requesters: []string{"alice"}, Targets: []string{"svc-*"}}}
+	state.Config.BreakGlass.Users = []string{"bob"}
+	state.Config.AccessReview.Users = []string{"ansible", "carol"}
+
+	req, err := http.NewRequest("GET", accessReviewPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req, state.accessReviewHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report accessreview.Report
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	var usernames []string
+	grants := make(map[string][]accessreview.Grant)
+	for _, user := range report.Users {
+		usernames = append(usernames, user.Username)
+		grants[user.Username] = user.Grants
+	}
+	if len(usernames) != 4 || usernames[0] != "alice" ||
+		usernames[1] != "bob" || usernames[2] != "carol" ||
+		usernames[3] != "username" {
+		t.Fatalf("unexpected users: %v", usernames)
+	}
+	if len(grants["alice"]) != 3 {
+		t.Fatalf("unexpected grants of alice: %+v", grants["alice"])
+	}
+	if role := grants["alice"][1]; role.Kind != accessreview.GrantRole ||
+		role.Principals[0] != "deploy" ||
+		role.MaxDurationSecs != defaultRoleMaxDurationSecs {
+		t.Errorf("unexpected role grant: %+v", role)
+	}
+	if delegation := grants["alice"][2]; delegation.Principals[0] != "svc-*" ||
+		delegation.CertTypes[0] != "ssh" ||
+		delegation.MaxDurationSecs != defaultDelegationMaxDurationSecs {
+		t.Errorf("unexpected delegation grant: %+v", delegation)
+	}
+	if len(grants["bob"]) != 2 ||
+		grants["bob"][1].Kind != accessreview.GrantBreakGlass ||
+		grants["bob"][1].MaxDurationSecs != defaultBreakGlassMaxDurationSecs {
+		t.Errorf("unexpected grants of bob: %+v", grants["bob"])
+	}
+	if len(grants["carol"]) != 1 {
+		t.Errorf("unexpected grants of carol: %+v", grants["carol"])
+	}
+
+	req, err = http.NewRequest("GET", accessReviewPath+"?format=csv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(req, state.accessReviewHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType !=
+		"text/csv; charset=utf-8" {
+		t.Errorf("unexpected Content-Type: %s", contentType)
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 8 {
+		t.Errorf("%d records: %q", len(records), records)
+	}
+
+	req, err = http.NewRequest("GET", accessReviewPath+"?format=xml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.accessReviewHandler,
+		http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/acmecert"
+	"github.com/Symantec/keymaster/keymasterd/dnspublish"
+)
+
+const (
+	defaultACMEHTTPAddress        = ":80"
+	defaultACMEDNSPropagationSecs = 60
+	acmeAccountKeyFilename        = "acme_account.key"
+	acmeCertFilename              = "acme_cert.pem"
+	acmeKeyFilename               = "acme_key.pem"
+	acmeObtainTimeout             = 5 * time.Minute
+	acmeDNSLeaseTTL               = 30 * time.Minute
+	secsBetweenACMERenewalChecks  = 3600
+)
+
+// ACMEConfig obtains the server TLS certificate from an ACME CA such as
+// Let's Encrypt, and renews it. It is written to tls_cert_filename and
+// tls_key_filename, which default to files in the data directory.
+type ACMEConfig struct {
+	// The names of the certificate, the first one being its subject.
+	// Disabled if empty.
+	Domains []string `yaml:"domains"`
+	Email   string   `yaml:"email"`
+	// Default: the production directory of Let's Encrypt.
+	DirectoryURL string `yaml:"directory_url"`
+	// "http-01" (default) or "dns-01".
+	Challenge string `yaml:"challenge"`
+	// Where HTTP-01 challenges are answered. Default: ":80".
+	HTTPAddress     string `yaml:"http_address"`
+	RenewBeforeDays uint   `yaml:"renew_before_days"` // Default: 30.
+	// For dns-01: "route53" or "etcd", configured as in dns_publication.
+	DNSBackend         string                      `yaml:"dns_backend"`
+	Route53            DNSPublicationRoute53Config `yaml:"route53"`
+	Etcd               DNSPublicationEtcdConfig    `yaml:"etcd"`
+	DNSPropagationSecs uint                        `yaml:"dns_propagation_secs"` // Default: 60.
+}
+
+func (config ACMEConfig) enabled() bool {
+	return len(config.Domains) > 0
+}
+
+func (config ACMEConfig) challenge() string {
+	if config.Challenge == "" {
+		return acmecert.ChallengeHTTP01
+	}
+	return config.Challenge
+}
+
+func (config ACMEConfig) httpAddress() string {
+	if config.HTTPAddress == "" {
+		return defaultACMEHTTPAddress
+	}
+	return config.HTTPAddress
+}
+
+func (config ACMEConfig) dnsPropagationDelay() time.Duration {
+	if config.DNSPropagationSecs < 1 {
+		return defaultACMEDNSPropagationSecs * time.Second
+	}
+	return time.Duration(config.DNSPropagationSecs) * time.Second
+}
+
+func (config ACMEConfig) check(base baseConfig) error {
+	if !config.enabled() {
+		return nil
+	}
+	if base.TLSKeyPKCS11.ModulePath != "" {
+		return errors.New("acme: cannot be used with tls_key_pkcs11")
+	}
+	switch config.challenge() {
+	case acmecert.ChallengeHTTP01:
+		for _, domain := range config.Domains {
+			if strings.HasPrefix(domain, "*.") {
+				return fmt.Errorf("acme: wildcard %s requires dns-01", domain)
+			}
+		}
+		if base.HTTPRedirectAddress != "" {
+			same, overlap := compareListenAddresses(config.httpAddress(),
+				base.HTTPRedirectAddress)
+			if overlap && !same {
+				return fmt.Errorf(
+					"acme: http_address %s overlaps http_redirect_address %s",
+					config.httpAddress(), base.HTTPRedirectAddress)
+			}
+		}
+	case acmecert.ChallengeDNS01:
+		if config.DNSBackend != "route53" && config.DNSBackend != "etcd" {
+			return errors.New("acme: dns-01 requires dns_backend route53 or etcd")
+		}
+	default:
+		return fmt.Errorf("acme: unknown challenge: %s", config.Challenge)
+	}
+	return nil
+}
+
+// setDefaultFilenames puts the certificate in the data directory unless
+// tls_cert_filename and tls_key_filename are set.
+func (config ACMEConfig) setDefaultFilenames(base *baseConfig) {
+	if !config.enabled() {
+		return
+	}
+	directory := base.DataDirectory
+	if directory == "" {
+		directory = defaultDataDirectory
+	}
+	if base.TLSCertFilename == "" {
+		base.TLSCertFilename = filepath.Join(directory, acmeCertFilename)
+	}
+	if base.TLSKeyFilename == "" {
+		base.TLSKeyFilename = filepath.Join(directory, acmeKeyFilename)
+	}
+}
+
+func (state *RuntimeState) newACMEManager() (*acmecert.Manager, error) {
+	config := state.Config.ACME
+	params := acmecert.Params{
+		DirectoryURL: config.DirectoryURL,
+		Email:        config.Email,
+		Domains:      config.Domains,
+		AccountKeyFilename: filepath.Join(state.Config.Base.DataDirectory,
+			acmeAccountKeyFilename),
+		CertFilename:        state.Config.Base.TLSCertFilename,
+		KeyFilename:         state.Config.Base.TLSKeyFilename,
+		Challenge:           config.challenge(),
+		DNSPropagationDelay: config.dnsPropagationDelay(),
+		RenewBefore:         time.Duration(config.RenewBeforeDays) * 24 * time.Hour,
+		Logger:              logger,
+	}
+	if params.Challenge == acmecert.ChallengeDNS01 {
+		backend, err := newDNSBackend(config.DNSBackend, config.Route53,
+			config.Etcd, acmeDNSLeaseTTL)
+		if err != nil {
+			return nil, err
+		}
+		txtBackend, ok := backend.(dnspublish.TXTBackend)
+		if !ok {
+			return nil, fmt.Errorf("%s cannot publish TXT records",
+				config.DNSBackend)
+		}
+		params.DNSProvider = txtBackend
+	}
+	return acmecert.New(params)
+}
+
+func obtainACMECertificate(manager *acmecert.Manager) error {
+	ctx, cancel := context.WithTimeout(context.Background(), acmeObtainTimeout)
+	defer cancel()
+	return manager.Obtain(ctx)
+}
+
+// setupACME obtains the server TLS certificate if it is missing or due for
+// renewal and renews it in the background from then on. Failing to renew a
+// certificate which has not expired is only logged. The new files are
+// picked up by the reloading of the server TLS certificate. It must be
+// called before the certificate is loaded.
+func (state *RuntimeState) setupACME() error {
+	config := state.Config.ACME
+	if !config.enabled() {
+		return nil
+	}
+	manager, err := state.newACMEManager()
+	if err != nil {
+		return fmt.Errorf("acme: %s", err)
+	}
+	state.acmeManager = manager
+	if config.challenge() == acmecert.ChallengeHTTP01 {
+		listener, err := net.Listen("tcp", config.httpAddress())
+		if err != nil {
+			return fmt.Errorf("acme: %s", err)
+		}
+		srv := &http.Server{
+			Handler:      manager.HTTPHandler(nil),
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		if state.acmeServesHTTPRedirect() {
+			srv = state.newHTTPRedirectServer(config.httpAddress())
+		}
+		go func() {
+			if err := srv.Serve(listener); err != nil {
+				logger.Errorf("Serving ACME challenges: %s", err)
+			}
+		}()
+	}
+	if manager.RenewalDue(time.Now()) {
+		logger.Printf("Obtaining TLS certificate for %s with ACME",
+			strings.Join(config.Domains, ","))
+		if err := obtainACMECertificate(manager); err != nil {
+			// A certificate which has not expired yet is served while the
+			// renewal loop retries.
+			if !manager.Valid(time.Now()) {
+				return fmt.Errorf("acme: %s", err)
+			}
+			logger.Errorf("Cannot renew TLS certificate with ACME: %s", err)
+		}
+	}
+	go state.renewACMECertificateLoop(manager)
+	return nil
+}
+
+func (state *RuntimeState) renewACMECertificateLoop(
+	manager *acmecert.Manager) {
+	for {
+		time.Sleep(secsBetweenACMERenewalChecks * time.Second)
+		if !manager.RenewalDue(time.Now()) {
+			continue
+		}
+		if err := obtainACMECertificate(manager); err != nil {
+			logger.Errorf("Cannot renew TLS certificate with ACME: %s", err)
+		}
+	}
+}
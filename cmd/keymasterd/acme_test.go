@@ -0,0 +1,86 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestACMEConfigCheck(t *testing.T) {
+	var base baseConfig
+	for _, test := range []struct {
+		config ACMEConfig
+		ok     bool
+	}{
+		{ACMEConfig{}, true},
+		{ACMEConfig{Domains: []string{"keymaster.example.com"}}, true},
+		{ACMEConfig{Domains: []string{"*.example.com"}}, false},
+		{ACMEConfig{Domains: []string{"*.example.com"}, Challenge: "dns-01",
+			DNSBackend: "route53"}, true},
+		{ACMEConfig{Domains: []string{"keymaster.example.com"},
+			Challenge: "dns-01"}, false},
+		{ACMEConfig{Domains: []string{"keymaster.example.com"},
+			Challenge: "tls-alpn-01"}, false},
+	} {
+		if err := test.config.check(base); (err == nil) != test.ok {
+			t.Errorf("%+v: %v", test.config, err)
+		}
+	}
+	config := ACMEConfig{Domains: []string{"keymaster.example.com"}}
+	for address, ok := range map[string]bool{
+		"0.0.0.0:80":   true,
+		"[::]:80":      true,
+		"127.0.0.1:80": false,
+		":8080":        true,
+	} {
+		base.HTTPRedirectAddress = address
+		if err := config.check(base); (err == nil) != ok {
+			t.Errorf("http_redirect_address %s: %v", address, err)
+		}
+	}
+	base.HTTPRedirectAddress = ""
+	base.TLSKeyPKCS11.ModulePath = "/usr/lib/softhsm/libsofthsm2.so"
+	if err := config.check(base); err == nil {
+		t.Error("accepted with tls_key_pkcs11")
+	}
+}
+
+func TestACMEDefaultFilenames(t *testing.T) {
+	base := baseConfig{DataDirectory: "/var/lib/keymaster"}
+	ACMEConfig{}.setDefaultFilenames(&base)
+	if base.TLSCertFilename != "" || base.TLSKeyFilename != "" {
+		t.Fatalf("filenames set without ACME: %+v", base)
+	}
+	config := ACMEConfig{Domains: []string{"keymaster.example.com"}}
+	config.setDefaultFilenames(&base)
+	if base.TLSCertFilename != filepath.Join("/var/lib/keymaster",
+		acmeCertFilename) || base.TLSKeyFilename !=
+		filepath.Join("/var/lib/keymaster", acmeKeyFilename) {
+		t.Fatalf("unexpected default filenames: %+v", base)
+	}
+	base = baseConfig{TLSCertFilename: "/etc/keymaster/server.pem",
+		TLSKeyFilename: "/etc/keymaster/server.key"}
+	config.setDefaultFilenames(&base)
+	if base.TLSCertFilename != "/etc/keymaster/server.pem" ||
+		base.TLSKeyFilename != "/etc/keymaster/server.key" {
+		t.Fatalf("configured filenames replaced: %+v", base)
+	}
+}
+
+func TestNewACMEManager(t *testing.T) {
+	var state RuntimeState
+	state.Config.Base.DataDirectory = "/var/lib/keymaster"
+	state.Config.ACME = ACMEConfig{
+		Domains:    []string{"*.example.com"},
+		Challenge:  "dns-01",
+		DNSBackend: "etcd",
+		Etcd:       DNSPublicationEtcdConfig{Endpoints: []string{"http://127.0.0.1:2379"}},
+	}
+	state.Config.ACME.setDefaultFilenames(&state.Config.Base)
+	if _, err := state.newACMEManager(); err != nil {
+		t.Fatal(err)
+	}
+	state.Config.ACME.DNSBackend = "bind"
+	if _, err := state.newACMEManager(); err == nil {
+		t.Fatal("unknown DNS backend accepted")
+	}
+}
@@ -3,15 +3,20 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"html/template"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/Symantec/Dominator/lib/html"
+	"github.com/Symantec/keymaster/keymasterd/metricshistory"
 )
 
 type adminDashboardType struct {
-	htmlWriter html.HtmlWriter
-	ready      bool
-	publicLogs bool
+	htmlWriter     html.HtmlWriter
+	ready          bool
+	publicLogs     bool
+	metricsHistory *metricshistory.History
 }
 
 func newAdminDashboard(htmlWriter html.HtmlWriter, publicLogs bool) *adminDashboardType {
@@ -47,6 +52,9 @@ func (dashboard *adminDashboardType) ServeHTTP(w http.ResponseWriter,
 		dashboard.htmlWriter.WriteHtml(writer)
 	}
 	fmt.Fprintln(writer, "</h3>")
+	if dashboard.metricsHistory != nil {
+		dashboard.writeMetricsHistory(writer)
+	}
 	fmt.Fprintln(writer, "<hr>")
 	if Version != "" {
 		fmt.Fprintf(writer, "Keymasterd version: %s <br>", Version)
@@ -58,3 +66,25 @@ func (dashboard *adminDashboardType) ServeHTTP(w http.ResponseWriter,
 func (dashboard *adminDashboardType) setReady() {
 	dashboard.ready = true
 }
+
+// writeMetricsHistory draws a sparkline of every metric in the history, of
+// the increase per sample for counters.
+func (dashboard *adminDashboardType) writeMetricsHistory(writer io.Writer) {
+	fmt.Fprintf(writer, "<a href=\"%s\">Metrics history</a>:<br>\n",
+		metricsHistoryPath)
+	fmt.Fprintln(writer, "<table>")
+	for _, series := range dashboard.metricsHistory.Series(time.Time{}) {
+		points := series.Points
+		if len(points) == 0 {
+			continue
+		}
+		last := points[len(points)-1].Value
+		if series.Counter {
+			points = metricshistory.Increases(points)
+		}
+		fmt.Fprintf(writer, "<tr><td>%s</td><td>%s</td><td>%g</td></tr>\n",
+			template.HTMLEscapeString(series.Name),
+			metricshistory.Sparkline(points, 300, 30), last)
+	}
+	fmt.Fprintln(writer, "</table>")
+}
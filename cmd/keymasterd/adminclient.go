@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+)
+
+var errNotAdminClientCert = errors.New(
+	"a client certificate of the admin CA is required")
+
+// adminClientName returns the common name of the client certificate of
+// tlsState if it was verified against the client CAs and not issued by the
+// keymaster CA. The IP restricted certificates which keymaster issues are
+// verified against the same pool, but are not admin credentials.
+func (state *RuntimeState) adminClientName(
+	tlsState *tls.ConnectionState) (string, error) {
+	if tlsState == nil {
+		return "", errNotAdminClientCert
+	}
+	caPublicKeys := state.currentCA().publicKeys
+	for _, chain := range tlsState.VerifiedChains {
+		if len(chain) < 1 {
+			continue
+		}
+		issuedByKeymaster := false
+		if len(chain) > 1 {
+			for _, publicKey := range caPublicKeys {
+				if publicKeysEqual(chain[1].PublicKey, publicKey) {
+					issuedByKeymaster = true
+					break
+				}
+			}
+		}
+		if !issuedByKeymaster {
+			return chain[0].Subject.CommonName, nil
+		}
+	}
+	return "", errNotAdminClientCert
+}
+
+// requireAdminClientCert returns the common name of the admin client
+// certificate of r, or writes a failure response and returns false.
+func (state *RuntimeState) requireAdminClientCert(w http.ResponseWriter,
+	r *http.Request) (string, bool) {
+	clientName, err := state.adminClientName(r.TLS)
+	if err != nil {
+		requestLogger(r).Printf("%s refused: %s", r.URL.Path, err)
+		state.writeFailureResponse(w, r, http.StatusForbidden, err.Error())
+		return "", false
+	}
+	return clientName, true
+}
+
+// requireAdmin is requireAdminClientCert for the certificates of users in
+// admin_users or admin_groups only.
+func (state *RuntimeState) requireAdmin(w http.ResponseWriter,
+	r *http.Request) (string, bool) {
+	clientName, ok := state.requireAdminClientCert(w, r)
+	if !ok {
+		return "", false
+	}
+	if !state.IsAdminUser(clientName) {
+		requestLogger(r).Printf("%s refused to %s, not an admin", r.URL.Path,
+			clientName)
+		state.writeFailureResponse(w, r, http.StatusForbidden, "not an admin")
+		return "", false
+	}
+	return clientName, true
+}
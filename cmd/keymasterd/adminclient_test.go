@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"os"
+	"testing"
+)
+
+func TestAdminClientName(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	adminCAKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminCA := &x509.Certificate{PublicKey: adminCAKey}
+	keymasterCA := &x509.Certificate{
+		PublicKey: state.currentCA().signer.Public()}
+	leaf := &x509.Certificate{Subject: pkix.Name{CommonName: "ops"}}
+	for _, test := range []struct {
+		tlsState *tls.ConnectionState
+		name     string
+	}{
+		{nil, ""},
+		{&tls.ConnectionState{}, ""},
+		{&tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{leaf, keymasterCA}}}, ""},
+		{&tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{leaf, adminCA}}}, "ops"},
+		{&tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{leaf}}}, "ops"},
+	} {
+		name, err := state.adminClientName(test.tlsState)
+		if name != test.name || (err == nil) != (test.name != "") {
+			t.Errorf("%+v: got %q, %v", test.tlsState, name, err)
+		}
+	}
+}
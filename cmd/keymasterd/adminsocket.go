@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+	"github.com/Symantec/keymaster/keymasterd/lifecycle"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v2"
+)
+
+const adminCommand = "admin"
+
+const (
+	adminSocketRevokeCertPath    = "/revokeCert"
+	adminSocketClearLockoutPath  = "/clearLockout"
+	adminSocketReloadConfigPath  = "/reloadConfig"
+	adminSocketCurrentPolicyPath = "/currentPolicy"
+	adminSocketCARotationPath    = "/caRotationDryRun"
+	adminSocketInjectFaultPath   = "/injectFault"
+	adminSocketClearFaultsPath   = "/clearFaults"
+	adminSocketListFaultsPath    = "/listFaults"
+)
+
+const revokedCertsFilename = "revoked_certs"
+
+const adminSocketClientTimeout = 30 * time.Second
+
+// Removal dates simulated by ca-rotation-dry-run when none are given.
+var defaultCARotationOffsets = []time.Duration{0, 24 * time.Hour,
+	7 * 24 * time.Hour, 30 * 24 * time.Hour, 90 * 24 * time.Hour}
+
+// adminSocketComponent serves handler on a Unix socket which only the
+// owner of keymasterd can connect to. Connecting is the authentication.
+func adminSocketComponent(filename string,
+	handler http.Handler) lifecycle.Component {
+	srv := &http.Server{
+		Handler:      handler,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	return lifecycle.Funcs{
+		StartFunc: func() error {
+			// A socket left behind by an earlier instance blocks Listen.
+			if fi, err := os.Lstat(filename); err == nil &&
+				fi.Mode()&os.ModeSocket != 0 {
+				os.Remove(filename)
+			}
+			// Not even briefly connectable by others.
+			oldMask := syscall.Umask(0177)
+			listener, err := net.Listen("unix", filename)
+			syscall.Umask(oldMask)
+			if err != nil {
+				return err
+			}
+			if err := os.Chmod(filename, 0600); err != nil {
+				listener.Close()
+				return err
+			}
+			go func() {
+				err := srv.Serve(listener)
+				if err != nil && err != http.ErrServerClosed {
+					logger.Fatalf("Serving %s: %s", filename, err)
+				}
+			}()
+			return nil
+		},
+		StopFunc: func() error {
+			ctx, cancel := context.WithTimeout(context.Background(),
+				serverShutdownTimeout)
+			defer cancel()
+			err := srv.Shutdown(ctx)
+			os.Remove(filename)
+			return err
+		},
+	}
+}
+
+func (state *RuntimeState) newAdminSocketMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminSocketRevokeCertPath, state.adminRevokeCertHandler)
+	mux.HandleFunc(adminSocketClearLockoutPath, state.adminClearLockoutHandler)
+	mux.HandleFunc(adminSocketRevokeSessionsPath,
+		state.adminRevokeSessionsHandler)
+	mux.HandleFunc(adminSocketReloadConfigPath, state.adminReloadConfigHandler)
+	mux.HandleFunc(adminSocketCurrentPolicyPath,
+		state.adminCurrentPolicyHandler)
+	mux.HandleFunc(adminSocketCARotationPath, state.adminCARotationHandler)
+	mux.HandleFunc(adminSocketInjectFaultPath, state.adminInjectFaultHandler)
+	mux.HandleFunc(adminSocketClearFaultsPath, state.adminClearFaultsHandler)
+	mux.HandleFunc(adminSocketListFaultsPath, state.adminListFaultsHandler)
+	mux.HandleFunc(configDiffPath, state.configDiffHandler)
+	mux.HandleFunc(adminSocketBreakGlassPath, state.adminBreakGlassHandler)
+	state.registerDebugHandlers(mux)
+	return mux
+}
+
+// revokeCert adds serial to the revocation list, which is shared with the
+// other instances of a cluster.
+func (state *RuntimeState) revokeCert(serial, reason string) (bool, error) {
+	requestedAt := time.Now()
+	entry, added, err := state.revokedCerts.Revoke(serial, reason)
+	if err != nil || !added {
+		return added, err
+	}
+	logger.Printf("Revoked certificate with serial %s: %s", entry.Serial,
+		reason)
+	if err := state.shareRevocation(entry); err != nil {
+		// Shared again by the next sync.
+		logger.Errorf("Cannot share revocation with the cluster: %s", err)
+	}
+	err = state.recordAuditEvent(attestation.Event{
+		Type:        attestation.EventRevoked,
+		Time:        entry.Time,
+		Serial:      entry.Serial,
+		Reason:      entry.Reason,
+		RequestedAt: &requestedAt,
+	})
+	if err != nil {
+		logger.Errorf("Cannot record revocation attestation: %s", err)
+	}
+	return true, nil
+}
+
+// clearLockout forgets the failed password and TOTP checks of username.
+func (state *RuntimeState) clearLockout(username string) {
+	state.loginThrottle.Clear(username)
+	state.totpLocalTateLimitMutex.Lock()
+	delete(state.totpLocalRateLimit, username)
+	state.totpLocalTateLimitMutex.Unlock()
+	logger.Printf("Cleared lockout of %s", username)
+}
+
+// reloadConfig rereads the issuance policy from the configuration file and
+// returns the fields which changed. Other settings need a restart.
+func (state *RuntimeState) reloadConfig() ([]policyFieldChange, error) {
+	config, err := readAppConfigFile(state.configFilename)
+	if err != nil {
+		return nil, err
+	}
+	policy := config.issuancePolicy()
+	if err := state.checkIssuancePolicy(policy); err != nil {
+		return nil, fmt.Errorf("%s, restart keymasterd to change this", err)
+	}
+	state.Mutex.Lock()
+	defer state.Mutex.Unlock()
+	before := state.Config.issuancePolicy()
+	state.Config.setIssuancePolicy(policy)
+	state.Config.PolicyAudit = config.PolicyAudit
+	if err := state.recordConfigPolicyVersion(); err != nil {
+		state.Config.setIssuancePolicy(before)
+		return nil, err
+	}
+	if err := state.applyApprovedPolicyChange(); err != nil {
+		state.Config.setIssuancePolicy(before)
+		return nil, err
+	}
+	state.recordActivePolicy()
+	changes := policyFieldChanges(before, state.Config.issuancePolicy())
+	logger.Printf("Reloaded issuance policy, %d fields changed", len(changes))
+	return changes, nil
+}
+
+func (state *RuntimeState) adminRevokeCertHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	serial := r.FormValue("serial")
+	if serial == "" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Missing serial")
+		return
+	}
+	added, err := state.revokeCert(serial, r.FormValue("reason"))
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !added {
+		fmt.Fprintf(w, "Certificate %s was already revoked\n", serial)
+		return
+	}
+	fmt.Fprintf(w, "Revoked certificate %s\n", serial)
+}
+
+func (state *RuntimeState) adminClearLockoutHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	username := r.FormValue("user")
+	if username == "" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Missing user")
+		return
+	}
+	if !state.Config.Base.DisableUsernameNormalization {
+		username = strings.ToLower(username)
+	}
+	state.clearLockout(username)
+	fmt.Fprintf(w, "Cleared lockout of %s\n", username)
+}
+
+func (state *RuntimeState) adminReloadConfigHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	changes, err := state.reloadConfig()
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError,
+			err.Error())
+		return
+	}
+	if len(changes) < 1 {
+		fmt.Fprintln(w, "Issuance policy unchanged")
+		return
+	}
+	for _, change := range changes {
+		fmt.Fprintf(w, "%s: %v -> %v\n", change.Field, change.Before,
+			change.After)
+	}
+}
+
+func (state *RuntimeState) adminCurrentPolicyHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	state.Mutex.Lock()
+	policy := state.Config.issuancePolicy()
+	version := state.activePolicyVersion()
+	state.Mutex.Unlock()
+	data, err := yaml.Marshal(policy)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError,
+			err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/yaml")
+	fmt.Fprintf(w, "# Issuance policy version %d\n", version.ID)
+	w.Write(data)
+}
+
+// parseCARotationDate parses dates given to ca-rotation-dry-run as RFC 3339
+// times or as days (midnight UTC).
+func parseCARotationDate(value string) (time.Time, error) {
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad date: %s", value)
+	}
+	return date, nil
+}
+
+// adminCARotationHandler simulates removing all CA keys but the candidate
+// on each date, using the issuance attestation log.
+func (state *RuntimeState) adminCARotationHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	candidate := r.FormValue("candidate")
+	if candidate == "" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Missing candidate")
+		return
+	}
+	now := time.Now()
+	var dates []time.Time
+	for _, value := range r.Form["date"] {
+		date, err := parseCARotationDate(value)
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		dates = append(dates, date)
+	}
+	if len(dates) < 1 {
+		for _, offset := range defaultCARotationOffsets {
+			dates = append(dates, now.Add(offset))
+		}
+	}
+	events, err := state.attestationLog.Events(time.Time{}, now)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError,
+			err.Error())
+		return
+	}
+	report := attestation.SimulateRotation(events, candidate, dates)
+	fmt.Fprintf(w, "# Current CA key: %s\n", state.caKeyFingerprint())
+	for _, line := range report.Lines() {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// caRotationCandidate returns the fingerprint of the candidate CA key given
+// to ca-rotation-dry-run, either as a fingerprint or as a public key file.
+func caRotationCandidate(value string) (string, error) {
+	if strings.HasPrefix(value, "SHA256:") {
+		return value, nil
+	}
+	data, err := ioutil.ReadFile(value)
+	if err != nil {
+		return "", err
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", value, err)
+	}
+	return ssh.FingerprintSHA256(publicKey), nil
+}
+
+// adminSocketRequest maps an admin command line to a request.
+func adminSocketRequest(args []string) (string, string, url.Values, error) {
+	if len(args) < 1 {
+		return "", "", nil, errors.New("missing admin command")
+	}
+	needArgs := func(min, max int) error {
+		if len(args)-1 < min || len(args)-1 > max {
+			return fmt.Errorf("wrong number of arguments for %s", args[0])
+		}
+		return nil
+	}
+	switch args[0] {
+	case "revoke-cert":
+		if err := needArgs(1, 2); err != nil {
+			return "", "", nil, err
+		}
+		values := url.Values{"serial": {args[1]}}
+		if len(args) > 2 {
+			values.Set("reason", args[2])
+		}
+		return "POST", adminSocketRevokeCertPath, values, nil
+	case "clear-lockout":
+		if err := needArgs(1, 1); err != nil {
+			return "", "", nil, err
+		}
+		return "POST", adminSocketClearLockoutPath,
+			url.Values{"user": {args[1]}}, nil
+	case "revoke-sessions":
+		if err := needArgs(1, 1); err != nil {
+			return "", "", nil, err
+		}
+		return "POST", adminSocketRevokeSessionsPath,
+			url.Values{"user": {args[1]}}, nil
+	case "reload-config":
+		if err := needArgs(0, 0); err != nil {
+			return "", "", nil, err
+		}
+		return "POST", adminSocketReloadConfigPath, nil, nil
+	case "config-diff":
+		if err := needArgs(0, 0); err != nil {
+			return "", "", nil, err
+		}
+		return "GET", configDiffPath, nil, nil
+	case "dump-current-policy":
+		if err := needArgs(0, 0); err != nil {
+			return "", "", nil, err
+		}
+		return "GET", adminSocketCurrentPolicyPath, nil, nil
+	case "ca-rotation-dry-run":
+		if len(args) < 2 {
+			return "", "", nil, fmt.Errorf("wrong number of arguments for %s",
+				args[0])
+		}
+		candidate, err := caRotationCandidate(args[1])
+		if err != nil {
+			return "", "", nil, err
+		}
+		return "GET", adminSocketCARotationPath, url.Values{
+			"candidate": {candidate}, "date": args[2:]}, nil
+	case "inject-fault":
+		if err := needArgs(3, 4); err != nil {
+			return "", "", nil, err
+		}
+		values := url.Values{"target": {args[1]}, "percent": {args[2]},
+			"action": {args[3]}}
+		if len(args) > 4 {
+			values.Set("duration", args[4])
+		}
+		return "POST", adminSocketInjectFaultPath, values, nil
+	case "clear-faults":
+		if err := needArgs(0, 1); err != nil {
+			return "", "", nil, err
+		}
+		values := url.Values{}
+		if len(args) > 1 {
+			values.Set("target", args[1])
+		}
+		return "POST", adminSocketClearFaultsPath, values, nil
+	case "list-faults":
+		if err := needArgs(0, 0); err != nil {
+			return "", "", nil, err
+		}
+		return "GET", adminSocketListFaultsPath, nil, nil
+	case "break-glass":
+		if err := needArgs(1, 2); err != nil {
+			return "", "", nil, err
+		}
+		switch args[1] {
+		case "status":
+			return "GET", adminSocketBreakGlassPath, nil, nil
+		case "enable":
+			if len(args) < 3 {
+				return "", "", nil, errors.New("break-glass enable needs a reason")
+			}
+			return "POST", adminSocketBreakGlassPath, url.Values{
+				"action": {"enable"}, "reason": {args[2]}}, nil
+		case "disable":
+			return "POST", adminSocketBreakGlassPath,
+				url.Values{"action": {"disable"}}, nil
+		}
+		return "", "", nil, fmt.Errorf("unknown break-glass action: %s",
+			args[1])
+	}
+	return "", "", nil, fmt.Errorf("unknown admin command: %s", args[0])
+}
+
+// adminClient implements "keymasterd admin", which sends a command to the
+// admin socket of a running keymasterd.
+func adminClient(configFilename string, args []string) error {
+	flagSet := flag.NewFlagSet(adminCommand, flag.ContinueOnError)
+	socketFilename := flagSet.String("socket", "",
+		"Admin socket (default: admin_socket_filename from the configuration)")
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: keymasterd %s [-socket path] command\n\n"+
+			"Commands:\n"+
+			"  revoke-cert serial [reason]\n"+
+			"  clear-lockout username\n"+
+			"  reload-config\n"+
+			"  config-diff\n"+
+			"  dump-current-policy\n"+
+			"  ca-rotation-dry-run candidate.pub|SHA256:fingerprint [date...]\n"+
+			"  inject-fault ldap|signing|storage percent fail|delay [duration]\n"+
+			"  clear-faults [target]\n"+
+			"  list-faults\n"+
+			"  break-glass status|enable reason|disable\n",
+			adminCommand)
+		flagSet.PrintDefaults()
+	}
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if *socketFilename == "" {
+		config, err := readAppConfigFile(configFilename)
+		if err != nil {
+			return err
+		}
+		*socketFilename = config.Base.AdminSocketFilename
+		if *socketFilename == "" {
+			return errors.New("admin_socket_filename is not configured")
+		}
+	}
+	method, path, values, err := adminSocketRequest(flagSet.Args())
+	if err != nil {
+		flagSet.Usage()
+		return err
+	}
+	client := &http.Client{
+		Timeout: adminSocketClientTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn,
+				error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", *socketFilename)
+			},
+		},
+	}
+	var resp *http.Response
+	if method == "POST" {
+		resp, err = client.PostForm("http://keymasterd"+path, values)
+	} else {
+		resp, err = client.Get("http://keymasterd" + path + "?" +
+			values.Encode())
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	_, err = os.Stdout.Write(body)
+	return err
+}
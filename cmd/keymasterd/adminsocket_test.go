@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+	"github.com/Symantec/keymaster/keymasterd/loginthrottle"
+	"github.com/Symantec/keymaster/keymasterd/policyversions"
+	"github.com/Symantec/keymaster/keymasterd/revocationlist"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+func TestAdminSocket(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "adminsocket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.revokedCerts, err = revocationlist.Open(filepath.Join(dir,
+		revokedCertsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.attestationLog, err = attestation.Open(filepath.Join(dir,
+		attestationLogFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.policyVersions, err = policyversions.Open(filepath.Join(dir,
+		policyVersionsDirectory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.recordConfigPolicyVersion(); err != nil {
+		t.Fatal(err)
+	}
+	socketFilename := filepath.Join(dir, "admin.sock")
+	component := adminSocketComponent(socketFilename, state.newAdminSocketMux())
+	if err := component.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer component.Stop()
+	fi, err := os.Stat(socketFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("socket mode %o", fi.Mode().Perm())
+	}
+
+	run := func(args ...string) error {
+		return adminClient("", append([]string{"-socket", socketFilename},
+			args...))
+	}
+	if err := run("revoke-cert", "0x10", "lost laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if !state.revokedCerts.IsRevoked("16") {
+		t.Fatal("certificate not revoked")
+	}
+	events, err := state.attestationLog.Events(time.Time{},
+		time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != attestation.EventRevoked ||
+		events[0].Serial != "16" || events[0].Reason != "lost laptop" {
+		t.Fatalf("unexpected revocation events: %+v", events)
+	}
+	if err := run("revoke-cert", "bogus"); err == nil {
+		t.Fatal("bad serial should fail")
+	}
+	if err := run("frobnicate"); err == nil {
+		t.Fatal("unknown command should fail")
+	}
+
+	state.loginThrottle = loginthrottle.New(loginthrottle.Params{})
+	for i := 0; i < 5; i++ {
+		state.loginThrottle.RecordFailure("alice", "192.0.2.1", nil)
+	}
+	if err := run("clear-lockout", "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if state.loginThrottle.IsKnownFailure("alice", nil) {
+		t.Fatal("lockout not cleared")
+	}
+
+	configFilename := filepath.Join(dir, "config.yml")
+	state.configFilename = configFilename
+	err = ioutil.WriteFile(configFilename, []byte(
+		"base:\n  allowed_auth_backends_for_certs: [U2F]\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run("reload-config"); err != nil {
+		t.Fatal(err)
+	}
+	backends := state.Config.Base.AllowedAuthBackendsForCerts
+	if len(backends) != 1 || backends[0] != proto.AuthTypeU2F {
+		t.Fatalf("policy not reloaded: %v", backends)
+	}
+	err = ioutil.WriteFile(configFilename, []byte("duo:\n  enabled: true\n"),
+		0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run("reload-config"); err == nil {
+		t.Fatal("enabling unconfigured Duo should fail")
+	}
+
+	req, err := http.NewRequest("GET", adminSocketCurrentPolicyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req, state.adminCurrentPolicyHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rr.Body.String(), "# Issuance policy version 2\n") {
+		t.Fatalf("unexpected policy dump: %s", rr.Body.String())
+	}
+
+	state.recordIssuedCert("alice", "ssh", "Ed25519", AuthTypeU2F, time.Hour)
+	if err := run("ca-rotation-dry-run", "SHA256:candidate"); err != nil {
+		t.Fatal(err)
+	}
+	if err := run("ca-rotation-dry-run", "SHA256:candidate", "soon"); err == nil {
+		t.Fatal("bad date should fail")
+	}
+	req, err = http.NewRequest("GET", adminSocketCARotationPath+
+		"?candidate=SHA256:candidate&date="+
+		time.Now().Add(time.Minute).Format(time.RFC3339), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(req, state.adminCARotationHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rr.Body.String(), ": 1 certificates stop validating") ||
+		!strings.Contains(rr.Body.String(), "by "+state.caKeyFingerprint()) {
+		t.Fatalf("unexpected dry run: %s", rr.Body.String())
+	}
+}
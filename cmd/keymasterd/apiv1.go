@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	v1proto "github.com/Symantec/keymaster/lib/webapi/v1/proto"
+)
+
+// Version 1 of the REST API (see lib/webapi/v1/proto) takes and returns
+// JSON only, errors included, and is described by an OpenAPI document. Like
+// the gRPC API, it runs the handlers of the older endpoints and translates
+// their responses, so that authentication and issuance policy stay the
+// same.
+const (
+	apiV1Path           = "/api/v1/"
+	maxAPIV1RequestSize = 1 << 20
+)
+
+// bufferedResponseWriter keeps the response of a handler, for the APIs
+// which translate it.
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{header: make(http.Header)}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// errorMessage returns the message of a failed response, without the
+// status writeFailureResponse starts it with.
+func (w *bufferedResponseWriter) errorMessage() string {
+	message := strings.TrimSpace(w.body.String())
+	message = strings.TrimSpace(strings.TrimPrefix(message,
+		fmt.Sprintf("%d %s", w.status, http.StatusText(w.status))))
+	if message == "" {
+		return http.StatusText(w.status)
+	}
+	return message
+}
+
+func writeAPIV1Response(w http.ResponseWriter, status int,
+	response interface{}) {
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+func writeAPIV1Error(w http.ResponseWriter, status int, message string) {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	writeAPIV1Response(w, status,
+		v1proto.Error{Status: status, Error: message})
+}
+
+// writeAPIV1Failure writes the failure kept in buffered as a v1 error.
+func writeAPIV1Failure(w http.ResponseWriter,
+	buffered *bufferedResponseWriter) {
+	status := buffered.status
+	if status < 400 {
+		status = http.StatusInternalServerError
+	}
+	for _, name := range []string{"WWW-Authenticate", "Retry-After"} {
+		if value := buffered.header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	writeAPIV1Error(w, status, buffered.errorMessage())
+}
+
+// serveBufferedAPIV1 runs handler for r, keeping its response. The username
+// the handler logs with is logged for w.
+func serveBufferedAPIV1(w http.ResponseWriter, r *http.Request,
+	handler http.HandlerFunc) *bufferedResponseWriter {
+	buffered := newBufferedResponseWriter()
+	loggingWriter := &instrumentedwriter.LoggingWriter{
+		ResponseWriter: buffered}
+	handler(loggingWriter, r)
+	if username := loggingWriter.Username(); username != "" {
+		if w, ok := w.(*instrumentedwriter.LoggingWriter); ok {
+			w.SetUsername(username)
+		}
+	}
+	return buffered
+}
+
+// apiV1Authenticate returns the authenticated user of r and their auth
+// level, or writes the error and returns false.
+func (state *RuntimeState) apiV1Authenticate(w http.ResponseWriter,
+	r *http.Request) (string, int, bool) {
+	var authUser string
+	var authLevel int
+	buffered := serveBufferedAPIV1(w, r,
+		func(w http.ResponseWriter, r *http.Request) {
+			if state.sendFailureToClientIfLocked(w, r) {
+				return
+			}
+			var err error
+			authUser, authLevel, err = state.checkAuth(w, r, AuthTypeAny)
+			if err != nil {
+				requestLogger(r).Debugf(1, "%v", err)
+				authUser = ""
+				return
+			}
+			w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+		})
+	if authUser == "" {
+		writeAPIV1Failure(w, buffered)
+		return "", 0, false
+	}
+	return authUser, authLevel, true
+}
+
+// apiV1AuthenticateAdmin returns the admin user of r, who must present a
+// client certificate of the admin CA: sessions and the IP restricted
+// certificates keymaster issues are not enough for admin operations.
+func (state *RuntimeState) apiV1AuthenticateAdmin(w http.ResponseWriter,
+	r *http.Request) (string, bool) {
+	authUser, err := state.adminClientName(r.TLS)
+	if err != nil {
+		requestLogger(r).Printf("%s %s refused: %s", r.Method, r.URL.Path,
+			err)
+		writeAPIV1Error(w, http.StatusForbidden, err.Error())
+		return "", false
+	}
+	setRequestLogUser(r, authUser)
+	if w, ok := w.(*instrumentedwriter.LoggingWriter); ok {
+		w.SetUsername(authUser)
+	}
+	if !state.IsAdminUser(authUser) {
+		requestLogger(r).Printf("%s %s refused to %s, not an admin", r.Method,
+			r.URL.Path, authUser)
+		writeAPIV1Error(w, http.StatusForbidden, "Not an admin")
+		return "", false
+	}
+	return authUser, true
+}
+
+// decodeAPIV1Request decodes the JSON body of r into request, or writes the
+// error and returns false.
+func decodeAPIV1Request(w http.ResponseWriter, r *http.Request,
+	request interface{}) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		writeAPIV1Error(w, http.StatusUnsupportedMediaType,
+			"Content-Type must be application/json")
+		return false
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body,
+		maxAPIV1RequestSize)).Decode(request)
+	if err != nil {
+		writeAPIV1Error(w, http.StatusBadRequest,
+			fmt.Sprintf("Invalid request: %s", err))
+		return false
+	}
+	return true
+}
+
+func writeAPIV1MethodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeAPIV1Error(w, http.StatusMethodNotAllowed, "")
+}
+
+func (state *RuntimeState) apiV1NotFoundHandler(w http.ResponseWriter,
+	r *http.Request) {
+	writeAPIV1Error(w, http.StatusNotFound, "")
+}
+
+// newCertgenRequest returns the /certgen/ request for request, sent with
+// the credentials of r.
+func newCertgenRequest(r *http.Request,
+	request v1proto.IssueCertificateRequest) (*http.Request, error) {
+	body, err := json.Marshal(proto.CertRequest{
+		PublicKeys:  request.PublicKeys,
+		Duration:    request.Duration,
+		Type:        request.Type,
+		AddGroups:   request.AddGroups,
+		Hostnames:   request.Hostnames,
+		IPAddresses: request.IPAddresses,
+	})
+	if err != nil {
+		return nil, err
+	}
+	query := make(url.Values)
+	for name, value := range request.Parameters {
+		query.Set(name, value)
+	}
+	certgenRequest := r.WithContext(r.Context())
+	certgenRequest.URL = &url.URL{
+		Path:     certgenPath + request.Username,
+		RawQuery: query.Encode(),
+	}
+	certgenRequest.RequestURI = certgenRequest.URL.RequestURI()
+	certgenRequest.Header = make(http.Header, len(r.Header))
+	for name, values := range r.Header {
+		certgenRequest.Header[name] = values
+	}
+	certgenRequest.Header.Set("Content-Type", "application/json")
+	certgenRequest.Header.Set("Accept", "application/json")
+	certgenRequest.Body = ioutil.NopCloser(bytes.NewReader(body))
+	certgenRequest.ContentLength = int64(len(body))
+	certgenRequest.Form = nil
+	certgenRequest.PostForm = nil
+	certgenRequest.MultipartForm = nil
+	return certgenRequest, nil
+}
+
+// apiV1CertificatesHandler issues certificates like /certgen/.
+func (state *RuntimeState) apiV1CertificatesHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "POST" {
+		writeAPIV1MethodNotAllowed(w, "POST")
+		return
+	}
+	var request v1proto.IssueCertificateRequest
+	if !decodeAPIV1Request(w, r, &request) {
+		return
+	}
+	if request.Username == "" || strings.Contains(request.Username, "/") {
+		writeAPIV1Error(w, http.StatusBadRequest, "Invalid username")
+		return
+	}
+	if len(request.PublicKeys) < 1 {
+		writeAPIV1Error(w, http.StatusBadRequest, "Missing public_keys")
+		return
+	}
+	certgenRequest, err := newCertgenRequest(r, request)
+	if err != nil {
+		writeAPIV1Error(w, http.StatusInternalServerError, "")
+		return
+	}
+	buffered := serveBufferedAPIV1(w, certgenRequest, state.certGenHandler)
+	switch buffered.status {
+	case http.StatusOK:
+		response := v1proto.IssueCertificateResponse{
+			Certificate: buffered.body.String(),
+			ContentType: buffered.header.Get("Content-Type"),
+		}
+		_, params, err := mime.ParseMediaType(
+			buffered.header.Get("Content-Disposition"))
+		if err == nil {
+			response.Filename = params["filename"]
+		}
+		writeAPIV1Response(w, http.StatusOK, response)
+	case http.StatusAccepted:
+		approvalURL := buffered.header.Get("Location")
+		w.Header().Set("Location", approvalURL)
+		writeAPIV1Response(w, http.StatusAccepted,
+			v1proto.IssueCertificateResponse{ApprovalURL: approvalURL})
+	default:
+		writeAPIV1Failure(w, buffered)
+	}
+}
+
+// apiV1RevokedCertificatesHandler lists (GET) or adds to (POST) the
+// revoked certificates.
+func (state *RuntimeState) apiV1RevokedCertificatesHandler(
+	w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		writeAPIV1MethodNotAllowed(w, "GET", "POST")
+		return
+	}
+	authUser, ok := state.apiV1AuthenticateAdmin(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == "GET" {
+		response := v1proto.ListRevokedCertificatesResponse{
+			Certificates: []v1proto.RevokedCertificate{},
+		}
+		for _, entry := range state.revokedCerts.List() {
+			response.Certificates = append(response.Certificates,
+				v1proto.RevokedCertificate{
+					Serial: entry.Serial,
+					Time:   entry.Time,
+					Reason: entry.Reason,
+				})
+		}
+		writeAPIV1Response(w, http.StatusOK, response)
+		return
+	}
+	var request v1proto.RevokeCertificateRequest
+	if !decodeAPIV1Request(w, r, &request) {
+		return
+	}
+	if request.Serial == "" {
+		writeAPIV1Error(w, http.StatusBadRequest, "Missing serial")
+		return
+	}
+	added, err := state.revokeCert(request.Serial, request.Reason)
+	if err != nil {
+		writeAPIV1Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if added {
+		requestLogger(r).Printf("Certificate %s revoked by %s", request.Serial,
+			authUser)
+	}
+	writeAPIV1Response(w, http.StatusOK,
+		v1proto.RevokeCertificateResponse{Revoked: added})
+}
+
+// apiV1LockoutsHandler clears the lockout of the user in the path.
+func (state *RuntimeState) apiV1LockoutsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "DELETE" {
+		writeAPIV1MethodNotAllowed(w, "DELETE")
+		return
+	}
+	username := strings.TrimPrefix(r.URL.Path, v1proto.LockoutsPath)
+	if username == "" || strings.Contains(username, "/") {
+		writeAPIV1Error(w, http.StatusNotFound, "")
+		return
+	}
+	if _, ok := state.apiV1AuthenticateAdmin(w, r); !ok {
+		return
+	}
+	if !state.Config.Base.DisableUsernameNormalization {
+		username = strings.ToLower(username)
+	}
+	state.clearLockout(username)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiV1OpenAPIHandler serves the OpenAPI document, with the service port of
+// this server as its server.
+func (state *RuntimeState) apiV1OpenAPIHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" {
+		writeAPIV1MethodNotAllowed(w, "GET")
+		return
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(apiV1OpenAPIDocument),
+		&document); err != nil {
+		requestLogger(r).Errorf("Cannot parse OpenAPI document: %s", err)
+		writeAPIV1Error(w, http.StatusInternalServerError, "")
+		return
+	}
+	document["servers"] = []map[string]string{{
+		"url": "https://" + state.HostIdentity + state.publicPortSuffix(),
+	}}
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(document)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/keymaster/keymasterd/revocationlist"
+	v1proto "github.com/Symantec/keymaster/lib/webapi/v1/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+func apiV1Request(t *testing.T, state *RuntimeState, method, path string,
+	cookieVal string, request interface{}, handler http.HandlerFunc,
+	expectedStatus int, response interface{}) http.Header {
+	return apiV1TLSRequest(t, state, method, path, cookieVal, nil, request,
+		handler, expectedStatus, response)
+}
+
+// apiV1TLSRequest is apiV1Request over a connection in tlsState.
+func apiV1TLSRequest(t *testing.T, state *RuntimeState, method, path string,
+	cookieVal string, tlsState *tls.ConnectionState, request interface{},
+	handler http.HandlerFunc, expectedStatus int,
+	response interface{}) http.Header {
+	var body []byte
+	if request != nil {
+		var err error
+		body, err = json.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if cookieVal != "" {
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	}
+	req.TLS = tlsState
+	rr, err := checkRequestHandlerCode(req, handler, expectedStatus)
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, path, err)
+	}
+	if response != nil {
+		if contentType := rr.Header().Get("Content-Type"); contentType !=
+			"application/json" {
+			t.Fatalf("%s %s: Content-Type %s", method, path, contentType)
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), response); err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+	}
+	return rr.Header()
+}
+
+func TestAPIV1Certificates(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response v1proto.IssueCertificateResponse
+	apiV1Request(t, state, "POST", v1proto.CertificatesPath, cookieVal,
+		v1proto.IssueCertificateRequest{
+			Username:   "username",
+			PublicKeys: []string{testUserSSHPublicKey},
+			Duration:   "1h",
+		}, state.apiV1CertificatesHandler, http.StatusOK, &response)
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(
+		[]byte(response.Certificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pubKey.(*ssh.Certificate); !ok {
+		t.Fatal("not an SSH certificate")
+	}
+	apiV1Request(t, state, "POST", v1proto.CertificatesPath, cookieVal,
+		v1proto.IssueCertificateRequest{
+			Username:   "username",
+			Type:       "x509",
+			PublicKeys: []string{testUserPEMPublicKey},
+		}, state.apiV1CertificatesHandler, http.StatusOK, &response)
+	if !strings.HasPrefix(response.Certificate, "-----BEGIN CERTIFICATE") ||
+		response.Filename != "userCert.pem" {
+		t.Errorf("unexpected X.509 response: %+v", response)
+	}
+
+	var apiError v1proto.Error
+	apiV1Request(t, state, "POST", v1proto.CertificatesPath, cookieVal,
+		v1proto.IssueCertificateRequest{
+			Username:   "other",
+			PublicKeys: []string{testUserSSHPublicKey},
+		}, state.apiV1CertificatesHandler, http.StatusForbidden, &apiError)
+	if apiError.Status != http.StatusForbidden || apiError.Error == "" {
+		t.Errorf("unexpected error: %+v", apiError)
+	}
+	apiV1Request(t, state, "POST", v1proto.CertificatesPath, cookieVal,
+		v1proto.IssueCertificateRequest{
+			Username:   "username",
+			Duration:   "forever",
+			PublicKeys: []string{testUserSSHPublicKey},
+		}, state.apiV1CertificatesHandler, http.StatusBadRequest, &apiError)
+	apiV1Request(t, state, "POST", v1proto.CertificatesPath, cookieVal,
+		v1proto.IssueCertificateRequest{Username: "username"},
+		state.apiV1CertificatesHandler, http.StatusBadRequest, &apiError)
+	header := apiV1Request(t, state, "POST", v1proto.CertificatesPath, "",
+		v1proto.IssueCertificateRequest{
+			Username:   "username",
+			PublicKeys: []string{testUserSSHPublicKey},
+		}, state.apiV1CertificatesHandler, http.StatusUnauthorized, &apiError)
+	if header.Get("WWW-Authenticate") == "" {
+		t.Error("no WWW-Authenticate header")
+	}
+	header = apiV1Request(t, state, "GET", v1proto.CertificatesPath,
+		cookieVal, nil, state.apiV1CertificatesHandler,
+		http.StatusMethodNotAllowed, nil)
+	if header.Get("Allow") != "POST" {
+		t.Errorf("unexpected Allow: %s", header.Get("Allow"))
+	}
+	req, err := http.NewRequest("POST", v1proto.CertificatesPath,
+		strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, err = checkRequestHandlerCode(req, state.apiV1CertificatesHandler,
+		http.StatusUnsupportedMediaType)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPIV1Admin(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "apiv1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.revokedCerts, err = revocationlist.Open(filepath.Join(dir,
+		revokedCertsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.AdminUsers = []string{"admin"}
+	adminCookie, err := state.setNewAuthCookie(nil, nil, "admin", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminCAKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminCA := &x509.Certificate{PublicKey: adminCAKey}
+	clientTLS := func(username string) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+			&x509.Certificate{Subject: pkix.Name{CommonName: username}},
+			adminCA}}}
+	}
+	adminTLS := clientTLS("admin")
+	keymasterCA := &x509.Certificate{
+		PublicKey: state.currentCA().signer.Public()}
+	ipCertTLS := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		&x509.Certificate{Subject: pkix.Name{CommonName: "admin"}},
+		keymasterCA}}}
+
+	var apiError v1proto.Error
+	for _, tlsState := range []*tls.ConnectionState{
+		nil, clientTLS("username"), ipCertTLS} {
+		apiV1TLSRequest(t, state, "GET", v1proto.RevokedCertificatesPath,
+			adminCookie, tlsState, nil, state.apiV1RevokedCertificatesHandler,
+			http.StatusForbidden, &apiError)
+		apiV1TLSRequest(t, state, "DELETE", v1proto.LockoutsPath+"username",
+			adminCookie, tlsState, nil, state.apiV1LockoutsHandler,
+			http.StatusForbidden, &apiError)
+	}
+
+	var revokeResponse v1proto.RevokeCertificateResponse
+	apiV1TLSRequest(t, state, "POST", v1proto.RevokedCertificatesPath, "",
+		adminTLS, v1proto.RevokeCertificateRequest{
+			Serial: "1234", Reason: "lost"},
+		state.apiV1RevokedCertificatesHandler, http.StatusOK,
+		&revokeResponse)
+	if !revokeResponse.Revoked {
+		t.Error("certificate not revoked")
+	}
+	apiV1TLSRequest(t, state, "POST", v1proto.RevokedCertificatesPath, "",
+		adminTLS, v1proto.RevokeCertificateRequest{Serial: "1234"},
+		state.apiV1RevokedCertificatesHandler, http.StatusOK,
+		&revokeResponse)
+	if revokeResponse.Revoked {
+		t.Error("certificate revoked twice")
+	}
+	apiV1TLSRequest(t, state, "POST", v1proto.RevokedCertificatesPath, "",
+		adminTLS, v1proto.RevokeCertificateRequest{},
+		state.apiV1RevokedCertificatesHandler, http.StatusBadRequest,
+		&apiError)
+	var listResponse v1proto.ListRevokedCertificatesResponse
+	apiV1TLSRequest(t, state, "GET", v1proto.RevokedCertificatesPath, "",
+		adminTLS, nil, state.apiV1RevokedCertificatesHandler,
+		http.StatusOK, &listResponse)
+	if len(listResponse.Certificates) != 1 ||
+		listResponse.Certificates[0].Serial != "1234" ||
+		listResponse.Certificates[0].Reason != "lost" {
+		t.Errorf("unexpected revoked certificates: %+v",
+			listResponse.Certificates)
+	}
+	apiV1TLSRequest(t, state, "DELETE", v1proto.LockoutsPath+"username", "",
+		adminTLS, nil, state.apiV1LockoutsHandler, http.StatusNoContent,
+		nil)
+	apiV1TLSRequest(t, state, "DELETE", v1proto.LockoutsPath, "", adminTLS,
+		nil, state.apiV1LockoutsHandler, http.StatusNotFound, &apiError)
+}
+
+// jsonFieldNames returns the JSON names of the fields of the struct v.
+func jsonFieldNames(v interface{}) []string {
+	var names []string
+	structType := reflect.TypeOf(v)
+	for i := 0; i < structType.NumField(); i++ {
+		name := strings.Split(structType.Field(i).Tag.Get("json"), ",")[0]
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestAPIV1OpenAPIDocument(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.HostIdentity = "keymaster.example.com"
+	state.Config.Base.HttpAddress = ":6920"
+	var document struct {
+		OpenAPI string `json:"openapi"`
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	apiV1Request(t, state, "GET", v1proto.OpenAPIPath, "", nil,
+		state.apiV1OpenAPIHandler, http.StatusOK, &document)
+	if len(document.Servers) != 1 ||
+		document.Servers[0].URL != "https://keymaster.example.com:6920" {
+		t.Errorf("unexpected servers: %+v", document.Servers)
+	}
+	for _, path := range []string{
+		v1proto.OpenAPIPath,
+		v1proto.CertificatesPath,
+		v1proto.RevokedCertificatesPath,
+		v1proto.LockoutsPath + "{username}",
+	} {
+		if _, ok := document.Paths[path]; !ok {
+			t.Errorf("%s not documented", path)
+		}
+	}
+	if len(document.Paths) != 4 {
+		t.Errorf("unexpected paths: %d", len(document.Paths))
+	}
+	schemas := map[string]interface{}{
+		"Error":                           v1proto.Error{},
+		"IssueCertificateRequest":         v1proto.IssueCertificateRequest{},
+		"IssueCertificateResponse":        v1proto.IssueCertificateResponse{},
+		"RevokeCertificateRequest":        v1proto.RevokeCertificateRequest{},
+		"RevokeCertificateResponse":       v1proto.RevokeCertificateResponse{},
+		"RevokedCertificate":              v1proto.RevokedCertificate{},
+		"ListRevokedCertificatesResponse": v1proto.ListRevokedCertificatesResponse{},
+	}
+	if len(document.Components.Schemas) != len(schemas) {
+		t.Errorf("unexpected schemas: %d", len(document.Components.Schemas))
+	}
+	for name, message := range schemas {
+		var properties []string
+		for property := range document.Components.Schemas[name].Properties {
+			properties = append(properties, property)
+		}
+		sort.Strings(properties)
+		if expected := jsonFieldNames(message); !reflect.DeepEqual(properties,
+			expected) {
+			t.Errorf("%s: properties %v, fields %v", name, properties,
+				expected)
+		}
+	}
+}
@@ -1,12 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"crypto"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
-	"database/sql"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
@@ -27,17 +25,45 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Symantec/Dominator/lib/html"
 	"github.com/Symantec/Dominator/lib/log"
 	"github.com/Symantec/Dominator/lib/log/serverlogger"
 	"github.com/Symantec/Dominator/lib/logbuf"
 	"github.com/Symantec/Dominator/lib/srpc"
+	"github.com/Symantec/keymaster/keymasterd/acmecert"
 	"github.com/Symantec/keymaster/keymasterd/admincache"
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+	"github.com/Symantec/keymaster/keymasterd/authzhistory"
+	"github.com/Symantec/keymaster/keymasterd/certapprovals"
+	"github.com/Symantec/keymaster/keymasterd/changerequests"
+	"github.com/Symantec/keymaster/keymasterd/chatops"
+	"github.com/Symantec/keymaster/keymasterd/cluster"
+	"github.com/Symantec/keymaster/keymasterd/deliveryqueue"
 	"github.com/Symantec/keymaster/keymasterd/eventnotifier"
+	"github.com/Symantec/keymaster/keymasterd/faultinjection"
+	"github.com/Symantec/keymaster/keymasterd/hostinventory"
+	"github.com/Symantec/keymaster/keymasterd/issuedcerts"
+	"github.com/Symantec/keymaster/keymasterd/levellog"
+	"github.com/Symantec/keymaster/keymasterd/lifecycle"
+	"github.com/Symantec/keymaster/keymasterd/loginthrottle"
+	"github.com/Symantec/keymaster/keymasterd/metricshistory"
+	"github.com/Symantec/keymaster/keymasterd/policyversions"
+	"github.com/Symantec/keymaster/keymasterd/profilestorage"
+	"github.com/Symantec/keymaster/keymasterd/revocationlist"
+	"github.com/Symantec/keymaster/keymasterd/sessions"
+	"github.com/Symantec/keymaster/keymasterd/statickeys"
+	"github.com/Symantec/keymaster/keymasterd/syslog"
+	"github.com/Symantec/keymaster/keymasterd/tracing"
+	"github.com/Symantec/keymaster/keymasterd/trustcoverage"
 	"github.com/Symantec/keymaster/lib/authutil"
 	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/Symantec/keymaster/lib/certlint"
 	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/Symantec/keymaster/lib/plugin"
+	"github.com/Symantec/keymaster/lib/pubkeysource"
 	"github.com/Symantec/keymaster/lib/pwauth"
 	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	v1proto "github.com/Symantec/keymaster/lib/webapi/v1/proto"
 	"github.com/Symantec/keymaster/proto/eventmon"
 	"github.com/Symantec/tricorder/go/healthserver"
 	"github.com/Symantec/tricorder/go/tricorder"
@@ -46,8 +72,6 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/tstranex/u2f"
-	"golang.org/x/crypto/openpgp"
-	"golang.org/x/crypto/openpgp/armor"
 	"golang.org/x/net/context"
 )
 
@@ -59,14 +83,19 @@ const (
 	AuthTypeSymantecVIP
 	AuthTypeIPCertificate
 	AuthTypeTOTP
+	AuthTypeDuo
+	AuthTypeBreakGlass
+	AuthTypeBackupCode
 )
 
 const AuthTypeAny = 0xFFFF
 
 type authInfo struct {
-	ExpiresAt time.Time
-	Username  string
-	AuthType  int
+	ExpiresAt      time.Time
+	Username       string
+	AuthType       int
+	SessionBinding string
+	SessionID      string
 }
 
 type authInfoJWT struct {
@@ -78,6 +107,10 @@ type authInfoJWT struct {
 	IssuedAt   int64    `json:"iat,omitempty"`
 	TokenType  string   `json:"token_type"`
 	AuthType   int      `json:"auth_type"`
+	// Hash of the value the client must present, see sessionbinding.go.
+	SessionBinding string `json:"session_binding,omitempty"`
+	// The server side record of the session, see sessions.go.
+	SessionID string `json:"sid,omitempty"`
 }
 
 type storageStringDataJWT struct {
@@ -92,6 +125,17 @@ type storageStringDataJWT struct {
 	Data       string   `json:"data"`
 }
 
+type csrfTokenJWT struct {
+	Issuer      string   `json:"iss,omitempty"`
+	Subject     string   `json:"sub,omitempty"`
+	Audience    []string `json:"aud,omitempty"`
+	Expiration  int64    `json:"exp,omitempty"`
+	NotBefore   int64    `json:"nbf,omitempty"`
+	IssuedAt    int64    `json:"iat,omitempty"`
+	TokenType   string   `json:"token_type"`
+	SessionHash string   `json:"session_hash"`
+}
+
 type u2fAuthData struct {
 	Enabled      bool
 	CreatedAt    time.Time
@@ -117,6 +161,7 @@ type userProfile struct {
 	PendingTOTPSecret          *[][]byte
 	LastSuccessfullTOTPCounter int64
 	TOTPAuthData               map[int64]*totpAuthData
+	BackupCodes                *backupCodeData
 }
 
 type localUserData struct {
@@ -150,7 +195,11 @@ type RuntimeState struct {
 	ClientCAPool        *x509.CertPool
 	HostIdentity        string
 	KerberosRealm       *string
+	u2fAppID            string
+	u2fTrustedFacets    []string
+	realms              []*realm
 	caCertDer           []byte
+	caChainDer          [][]byte
 	//authCookie          map[string]authInfo
 	vipPushCookie map[string]pushPollTransaction
 	localAuthData map[string]localUserData
@@ -159,17 +208,71 @@ type RuntimeState struct {
 	//userProfile         map[string]userProfile
 	pendingOauth2        map[string]pendingAuth2Request
 	storageRWMutex       sync.RWMutex
-	db                   *sql.DB
-	dbType               string
-	cacheDB              *sql.DB
+	profileStorage       profilestorage.Backend
+	profileCache         profilestorage.Backend
 	remoteDBQueryTimeout time.Duration
 	htmlTemplate         *template.Template
 	passwordChecker      pwauth.PasswordAuthenticator
 	KeymasterPublicKeys  []crypto.PublicKey
+	caRollover           *caRollover
 	isAdminCache         *admincache.Cache
+	// Protects Signer, caCertDer, caChainDer and KeymasterPublicKeys, see
+	// currentCA.
+	caMutex sync.RWMutex
 
 	totpLocalRateLimit      map[string]totpRateLimitInfo
 	totpLocalTateLimitMutex sync.Mutex
+
+	issuedCerts           map[string][]issuedCertInfo
+	trustCoverage         *trustcoverage.Tracker
+	satelliteProxySecrets map[string][]byte
+	notificationQueue     *deliveryqueue.Queue
+	authFailureEvents     authFailureEventLimiter
+	authFailureLog        *authFailureLog
+	backupCodeLocks       userLocks
+	issuanceEmailQueue    *deliveryqueue.Queue
+	syslogWriter          *syslog.Writer
+	tracer                *tracing.Tracer
+	serverTLSCertificate  *serverTLSCertificate
+	acmeManager           *acmecert.Manager
+	logBuffer             html.HtmlWriter
+	syslogFacility        syslog.Facility
+	syslogAuditFacility   syslog.Facility
+	auditStream           *auditStream
+	hostInventory         *hostinventory.Inventory
+	plugins               []*plugin.Client
+	duoPushTransactions   map[string]pushPollTransaction
+	attestationLog        *attestation.Log
+	sshKeyIDTemplate      *sshKeyIDTemplate
+	policyVersions        *policyversions.Store
+	loginThrottle         *loginthrottle.Throttle
+	changeRequests        *changerequests.Store
+	certApprovals         *certapprovals.Store
+	staticKeys            *statickeys.Store
+	issuedX509Certs       *issuedcerts.Database
+	breakGlass            *breakGlass
+	configPolicyVersion   uint64
+	configFilename        string
+	loadedConfig          *AppConfigFile
+	revokedCerts          *revocationlist.List
+	sessions              *sessions.Store
+	authzHistory          *authzhistory.Log
+	ldapPasswordPolicy    ldapPasswordPolicyCache
+	sshGroupClaims        *sshGroupClaimPolicy
+	revocationFeedCache   revocationFeedCache
+	x509CRLCache          x509CRLCache
+	ocspResponseCache     ocspResponseCache
+	auditEventTail        auditEventTail
+	sshPublicKeySources   map[string]pubkeysource.Source
+	metricsHistory        *metricshistory.History
+	faultInjector         *faultinjection.Injector
+	caFingerprintsLimiter *addressRateLimiter
+	ciIssuers             map[string]*ciIssuer
+	kubernetesClusters    []*kubernetesCluster
+	approvalIntegrations  map[string]chatops.Integration
+	clientBinaries        *clientBinaryIndex
+	cluster               *cluster.Cluster
+	x509Linter            *certlint.X509Linter
 }
 
 const redirectPath = "/auth/oauth2/callback"
@@ -182,8 +285,10 @@ var (
 		"The filename of the configuration")
 	generateConfig = flag.Bool("generateConfig", false,
 		"Generate new valid configuration")
-	u2fAppID         = "https://www.example.com:33443"
-	u2fTrustedFacets = []string{}
+	demoMode = flag.Bool("demo", false,
+		"Run a throwaway all-in-one demo instance")
+	checkConfig = flag.Bool("checkConfig", false,
+		"Validate the configuration, print a report and exit")
 
 	metricsMutex   = &sync.Mutex{}
 	certGenCounter = prometheus.NewCounterVec(
@@ -221,8 +326,43 @@ var (
 		},
 		[]string{"cert_type", "stage"},
 	)
+	certLintFindingsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keymaster_cert_lint_findings_counter",
+			Help: "Lint findings for issued certificates.",
+		},
+		[]string{"cert_type", "lint"},
+	)
+	breakGlassCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keymaster_break_glass_counter",
+			Help: "Break-glass mode changes and authentication attempts.",
+		},
+		[]string{"event"},
+	)
+	rejectedUploadCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keymaster_rejected_upload_counter",
+			Help: "Uploads rejected for exceeding a size limit.",
+		},
+		[]string{"reason"},
+	)
+	loginThrottleCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keymaster_login_throttle_counter",
+			Help: "Password checks delayed or rejected by the login throttle.",
+		},
+		[]string{"action"},
+	)
+	ldapReferralCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keymaster_ldap_referral_counter",
+			Help: "LDAP referrals by backend and outcome.",
+		},
+		[]string{"backend", "outcome"},
+	)
 
-	logger log.DebugLogger
+	logger *levellog.Logger
 	// TODO(rgooch): Pass this in rather than use a global variable.
 	eventNotifier *eventnotifier.EventNotifier
 )
@@ -277,13 +417,22 @@ func getSignerFromPEMBytes(privateKey []byte) (crypto.Signer, error) {
 	return certgen.GetSignerFromPEMBytes(privateKey)
 }
 
-// Assumes the runtime state signer has been loaded!
-func generateCADer(state *RuntimeState, keySigner crypto.Signer) ([]byte, error) {
+// generateCAChainDer returns the X.509 CA certificate of keySigner and, if it
+// is an intermediate CA, the chain from it up to the root, without the root.
+// Callers publish both together with keySigner through setCAKeys.
+func generateCAChainDer(state *RuntimeState, keySigner crypto.Signer) ([]byte,
+	[][]byte, error) {
+	if state.Config.Base.X509CACertFilename != "" {
+		return loadX509CAChain(state.Config.Base.X509CACertFilename,
+			keySigner)
+	}
 	organizationName := state.HostIdentity
 	if state.KerberosRealm != nil {
 		organizationName = *state.KerberosRealm
 	}
-	return certgen.GenSelfSignedCACert(state.HostIdentity, organizationName, keySigner)
+	caCertDer, err := certgen.GenSelfSignedCACert(state.HostIdentity,
+		organizationName, keySigner)
+	return caCertDer, nil, err
 }
 
 func (state *RuntimeState) performStateCleanup(secsBetweenCleanup int) {
@@ -313,6 +462,11 @@ func (state *RuntimeState) performStateCleanup(secsBetweenCleanup int) {
 			}
 
 		}
+		for key, transaction := range state.duoPushTransactions {
+			if transaction.ExpiresAt.Before(time.Now()) {
+				delete(state.duoPushTransactions, key)
+			}
+		}
 
 		state.Mutex.Unlock()
 		logger.Debugf(3, "Pending Cookie sizes: before(%d) after(%d)",
@@ -330,34 +484,44 @@ func convertToBindDN(username string, bind_pattern string) string {
 
 func checkUserPassword(username string, password string, config AppConfigFile, passwordChecker pwauth.PasswordAuthenticator, r *http.Request) (bool, error) {
 	clientType := getClientType(r)
+	ctx, cancel := backendContext(r)
+	defer cancel()
 	if passwordChecker != nil {
-		logger.Debugf(3, "checking auth with passwordChecker")
+		requestLogger(r).Debugf(3, "checking auth with passwordChecker")
 		isLDAP := false
 		if len(config.Ldap.LDAPTargetURLs) > 0 {
 			isLDAP = true
 		}
 
+		spanName := spanNamePasswordAuth
+		if isLDAP {
+			spanName = spanNameLDAPBind
+		}
+		_, span := tracing.StartChild(ctx, spanName, tracing.SpanKindClient)
 		start := time.Now()
-		valid, err := passwordChecker.PasswordAuthenticate(username, []byte(password))
+		valid, err := pwauth.PasswordAuthenticateContext(ctx, passwordChecker,
+			username, []byte(password))
+		endSpan(span, err)
 		if err != nil {
 			return false, err
 		}
 		if isLDAP {
 			metricLogExternalServiceDuration("ldap", time.Since(start))
 		}
-		logger.Debugf(3, "pwdChaker output = %d", valid)
+		requestLogger(r).Debugf(3, "pwdChaker output = %d", valid)
 		metricLogAuthOperation(clientType, "password", valid)
 		return valid, nil
 	}
 
 	if config.Base.HtpasswdFilename != "" {
-		logger.Debugf(3, "I have htpasswed filename")
+		requestLogger(r).Debugf(3, "I have htpasswed filename")
 		buffer, err := ioutil.ReadFile(config.Base.HtpasswdFilename)
 		if err != nil {
 			return false, err
 		}
-		valid, err := authutil.CheckHtpasswdUserPassword(username, password, buffer)
-		if err != nil {
+		valid, err := authutil.CheckHtpasswdUserPasswordContext(ctx, username,
+			password, buffer)
+		if err != nil && !authutil.IsPasswordRejected(err) {
 			return false, err
 		}
 		metricLogAuthOperation(clientType, "password", valid)
@@ -374,7 +538,7 @@ func getPreferredAcceptType(r *http.Request) string {
 	if ok {
 		for _, acceptValue := range acceptHeader {
 			if strings.Contains(acceptValue, "text/html") {
-				logger.Debugf(1, "Got it  %+v", acceptValue)
+				requestLogger(r).Debugf(1, "Got it  %+v", acceptValue)
 				preferredAcceptType = "text/html"
 			}
 		}
@@ -428,19 +592,25 @@ func (state *RuntimeState) writeHTML2FAAuthPage(w http.ResponseWriter, r *http.R
 
 		JSSources = append(JSSources, "/static/webui-2fa-u2f.js")
 	}
-	if state.Config.SymantecVIP.Enabled {
+	policy := state.activeIssuancePolicy()
+	if policy.SymantecVIPEnabled {
 		JSSources = append(JSSources, "/static/webui-2fa-symc-vip.js")
 	}
+	if policy.DuoEnabled {
+		JSSources = append(JSSources, "/static/webui-2fa-duo.js")
+	}
 	displayData := secondFactorAuthTemplateData{
 		Title:            "Keymaster 2FA Auth",
 		JSSources:        JSSources,
-		ShowVIP:          state.Config.SymantecVIP.Enabled,
+		ShowVIP:          policy.SymantecVIPEnabled,
 		ShowU2F:          showU2F,
-		ShowTOTP:         state.Config.Base.EnableLocalTOTP,
+		ShowTOTP:         policy.LocalTOTPEnabled,
+		ShowDuo:          policy.DuoEnabled,
+		ShowBackupCode:   state.Config.Base.BackupCodes.Enabled,
 		LoginDestination: loginDestination}
 	err := state.htmlTemplate.ExecuteTemplate(w, "secondFactorLoginPage", displayData)
 	if err != nil {
-		logger.Printf("Failed to execute %v", err)
+		requestLogger(r).Errorf("Failed to execute %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return err
 	}
@@ -458,7 +628,7 @@ func (state *RuntimeState) writeHTMLLoginPage(w http.ResponseWriter, r *http.Req
 		ErrorMessage:     errorMessage}
 	err := state.htmlTemplate.ExecuteTemplate(w, "loginPage", displayData)
 	if err != nil {
-		logger.Printf("Failed to execute %v", err)
+		requestLogger(r).Errorf("Failed to execute %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return err
 	}
@@ -489,6 +659,9 @@ func (state *RuntimeState) writeFailureResponse(w http.ResponseWriter, r *http.R
 			if r.URL.Path == idpOpenIDCAuthorizationPath {
 				loginDestnation = r.URL.String()
 			}
+			if r.URL.Path == certRequestPath {
+				loginDestnation = certRequestPath
+			}
 			if r.Method == "POST" {
 				/// assume it has been parsed... otherwise why are we here?
 				if r.Form.Get("login_destination") != "" {
@@ -502,11 +675,13 @@ func (state *RuntimeState) writeFailureResponse(w http.ResponseWriter, r *http.R
 			}
 			info, err := state.getAuthInfoFromAuthJWT(authCookie.Value)
 			if err != nil {
-				logger.Debugf(3, "write failure state, error from getinfo authInfoJWT")
+				requestLogger(r).Debugf(3, "write failure state, error from getinfo authInfoJWT")
 				state.writeHTMLLoginPage(w, r, loginDestnation, "")
 				return
 			}
-			if info.ExpiresAt.Before(time.Now()) {
+			if info.ExpiresAt.Before(time.Now()) ||
+				state.checkSessionBinding(r, info.Username, info.SessionBinding) != nil ||
+				state.checkSession(info) != nil {
 				state.writeHTMLLoginPage(w, r, loginDestnation, "")
 				return
 			}
@@ -545,20 +720,30 @@ func (state *RuntimeState) sendFailureToClientIfLocked(w http.ResponseWriter, r
 
 	if signerIsNull {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-		logger.Printf("Signer has not been unlocked")
+		requestLogger(r).Printf("Signer has not been unlocked")
 		return true
 	}
 	return false
 }
 
-func (state *RuntimeState) setNewAuthCookie(w http.ResponseWriter, username string, authlevel int) (string, error) {
-	cookieVal, err := state.genNewSerializedAuthJWT(username, authlevel)
+func (state *RuntimeState) setNewAuthCookie(w http.ResponseWriter, r *http.Request, username string, authlevel int) (string, error) {
+	binding, err := state.newSessionBinding(w, r)
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		return "", err
+	}
+	session, err := state.newSession(r, username)
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		return "", err
+	}
+	cookieVal, err := state.genNewSerializedAuthJWT(username, authlevel, binding,
+		session.ID, session.Expires)
 	if err != nil {
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		return "", err
 	}
-	expiration := time.Now().Add(time.Duration(maxAgeSecondsAuthCookie) * time.Second)
-	authCookie := http.Cookie{Name: authCookieName, Value: cookieVal, Expires: expiration, Path: "/", HttpOnly: true, Secure: true}
+	authCookie := state.newAuthCookie(cookieVal, session.Expires)
 
 	//use handler with original request.
 	if w != nil {
@@ -586,13 +771,14 @@ func (state *RuntimeState) updateAuthCookieAuthlevel(w http.ResponseWriter, r *h
 		return "", err
 	}
 
-	updatedAuthCookie := http.Cookie{Name: authCookieName, Value: cookieVal, Expires: authCookie.Expires, Path: "/", HttpOnly: true, Secure: true}
-	logger.Debugf(3, "about to update authCookie")
+	updatedAuthCookie := state.newAuthCookie(cookieVal, authCookie.Expires)
+	requestLogger(r).Debugf(3, "about to update authCookie")
 	http.SetCookie(w, &updatedAuthCookie)
 	return authCookie.Value, nil
 }
 func (state *RuntimeState) isAutomationUser(username string) (bool, error) {
-	for _, automationUsername := range state.Config.Base.AutomationUsers {
+	policy := state.activeIssuancePolicy()
+	for _, automationUsername := range policy.AutomationUsers {
 		if automationUsername == username {
 			return true, nil
 		}
@@ -601,7 +787,7 @@ func (state *RuntimeState) isAutomationUser(username string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	for _, automationGroup := range state.Config.Base.AutomationUserGroups {
+	for _, automationGroup := range policy.AutomationUserGroups {
 		for _, groupName := range userGroups {
 			if groupName == automationGroup {
 				return true, nil
@@ -611,20 +797,45 @@ func (state *RuntimeState) isAutomationUser(username string) (bool, error) {
 	return false, nil
 }
 
+// checkAuthCookie returns the authentication of a valid, unexpired session
+// cookie bound to the client of r.
+func (state *RuntimeState) checkAuthCookie(r *http.Request,
+	authCookie *http.Cookie) (authInfo, error) {
+	info, err := state.getAuthInfoFromAuthJWT(authCookie.Value)
+	if err != nil {
+		//TODO check between internal and bad cookie error
+		return info, errors.New("Invalid Cookie")
+	}
+	//check for expiration...
+	if info.ExpiresAt.Before(time.Now()) {
+		return info, errors.New("Expired Cookie")
+	}
+	if err := state.checkSessionBinding(r, info.Username, info.SessionBinding); err != nil {
+		return info, err
+	}
+	if err := state.checkSession(info); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
 // Inspired by http://stackoverflow.com/questions/21936332/idiomatic-way-of-requiring-http-basic-auth-in-go
 func (state *RuntimeState) checkAuth(w http.ResponseWriter, r *http.Request, requiredAuthType int) (string, int, error) {
+	if auth, ok := checkedAuthOf(r, requiredAuthType); ok {
+		return auth.username, auth.authLevel, nil
+	}
 	// Check csrf
 	if r.Method != "GET" {
 		referer := r.Referer()
 		if len(referer) > 0 && len(r.Host) > 0 {
-			logger.Debugf(3, "ref =%s, host=%s", referer, r.Host)
+			requestLogger(r).Debugf(3, "ref =%s, host=%s", referer, r.Host)
 			refererURL, err := url.Parse(referer)
 			if err != nil {
 				return "", AuthTypeNone, err
 			}
-			logger.Debugf(3, "refHost =%s, host=%s", refererURL.Host, r.Host)
+			requestLogger(r).Debugf(3, "refHost =%s, host=%s", refererURL.Host, r.Host)
 			if refererURL.Host != r.Host {
-				logger.Printf("CSRF detected.... rejecting with a 400")
+				requestLogger(r).Printf("CSRF detected.... rejecting with a 400")
 				state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 				err := errors.New("CSRF detected... rejecting")
 				return "", AuthTypeNone, err
@@ -635,20 +846,20 @@ func (state *RuntimeState) checkAuth(w http.ResponseWriter, r *http.Request, req
 	// We first check for certs if this auth is allowed
 	if ((requiredAuthType & AuthTypeIPCertificate) == AuthTypeIPCertificate) &&
 		r.TLS != nil {
-		logger.Debugf(3, "looks like authtype ip cert, r.tls=%+v", r.TLS)
+		requestLogger(r).Debugf(3, "looks like authtype ip cert, r.tls=%+v", r.TLS)
 		if len(r.TLS.VerifiedChains) > 0 {
-			logger.Debugf(3, "looks like authtype ip cert, has verifiedChains")
+			requestLogger(r).Debugf(3, "looks like authtype ip cert, has verifiedChains")
 			clientName := r.TLS.VerifiedChains[0][0].Subject.CommonName
 			userCert := r.TLS.VerifiedChains[0][0]
 
 			validIP, err := certgen.VerifyIPRestrictedX509CertIP(userCert, r.RemoteAddr)
 			if err != nil {
-				logger.Printf("Error verifying up restricted cert: %s", err)
+				requestLogger(r).Errorf("Error verifying up restricted cert: %s", err)
 				state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 				return "", AuthTypeNone, fmt.Errorf("checkAuth: Error verifying IP restricted cert: %s", err)
 			}
 			if !validIP {
-				logger.Printf("Invalid IP for cert: %s is not valid for incoming connection", r.RemoteAddr)
+				requestLogger(r).Warnf("Invalid IP for cert: %s is not valid for incoming connection", r.RemoteAddr)
 				state.writeFailureResponse(w, r, http.StatusUnauthorized, "Bad incoming ip address")
 				return "", AuthTypeNone, fmt.Errorf("checkAuth: Error verifying IP restricted cert. Invalid incoming address: %s", r.RemoteAddr)
 			}
@@ -663,17 +874,36 @@ func (state *RuntimeState) checkAuth(w http.ResponseWriter, r *http.Request, req
 				return "", AuthTypeNone, fmt.Errorf("checkAuth: User %s is not a service account.", clientName)
 			}
 
+			if state.revokedCerts.IsRevoked(userCert.SerialNumber.String()) {
+				requestLogger(r).Printf("Cert %s is on the revocation list",
+					userCert.SerialNumber)
+				state.writeFailureResponse(w, r, http.StatusUnauthorized, "revoked Cert")
+				return "", AuthTypeNone, fmt.Errorf("checkAuth: IP cert is revoked")
+			}
 			revoked, ok, err := revoke.VerifyCertificateError(userCert)
 			if err != nil {
-				logger.Printf("Error checking revocation of IP  restricted cert: %s", err)
+				requestLogger(r).Errorf("Error checking revocation of IP  restricted cert: %s", err)
 			}
 			// Soft Fail: we only fail if the revocation check was successful and the cert is revoked
 			if revoked == true && ok {
-				logger.Printf("Cert is revoked")
+				requestLogger(r).Printf("Cert is revoked")
 				state.writeFailureResponse(w, r, http.StatusUnauthorized, "revoked Cert")
 				return "", AuthTypeNone, fmt.Errorf("checkAuth: IP cert is revoked")
 			}
-			return clientName, AuthTypeIPCertificate, nil
+			authLevel := AuthTypeIPCertificate
+			// A session of the same user adds its methods, for requirements
+			// combining both.
+			for _, cookie := range r.Cookies() {
+				if cookie.Name != authCookieName {
+					continue
+				}
+				info, err := state.checkAuthCookie(r, cookie)
+				if err == nil && info.Username == clientName {
+					authLevel |= info.AuthType
+				}
+			}
+			setRequestLogUser(r, clientName)
+			return clientName, authLevel, nil
 
 		}
 	}
@@ -708,7 +938,7 @@ func (state *RuntimeState) checkAuth(w http.ResponseWriter, r *http.Request, req
 		if !state.Config.Base.DisableUsernameNormalization {
 			user = strings.ToLower(user)
 		}
-		valid, err := checkUserPassword(user, pass, config, state.passwordChecker, r)
+		valid, err := state.checkThrottledUserPassword(user, pass, config, r)
 		if err != nil {
 			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 			return "", AuthTypeNone, err
@@ -718,33 +948,32 @@ func (state *RuntimeState) checkAuth(w http.ResponseWriter, r *http.Request, req
 			err := errors.New("Invalid Credentials")
 			return "", AuthTypeNone, err
 		}
+		state.setBrowserSessionCookie(w, r, user)
+		setRequestLogUser(r, user)
 		return user, AuthTypePassword, nil
 	}
 
 	//Critical section
-	info, err := state.getAuthInfoFromAuthJWT(authCookie.Value)
+	info, err := state.checkAuthCookie(r, authCookie)
 	if err != nil {
-		//TODO check between internal and bad cookie error
 		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
-		err := errors.New("Invalid Cookie")
 		return "", AuthTypeNone, err
 	}
-	//check for expiration...
-	if info.ExpiresAt.Before(time.Now()) {
-		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
-		err := errors.New("Expired Cookie")
-		return "", AuthTypeNone, err
-
-	}
 	if (info.AuthType & requiredAuthType) == 0 {
 		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 		err := errors.New("Insufficeint Auth Level")
 		return "", info.AuthType, err
 	}
+	setRequestLogUser(r, info.Username)
 	return info.Username, info.AuthType, nil
 }
 
 func (state *RuntimeState) getRequiredWebUIAuthLevel() int {
+	requirement, err := state.activeIssuancePolicy().AuthRequirements.requirement(
+		authGroupWebUI)
+	if err == nil && requirement != nil {
+		return requirement.Methods()
+	}
 	AuthLevel := 0
 	for _, webUIPref := range state.Config.Base.AllowedAuthBackendsForWebUI {
 		if webUIPref == proto.AuthTypePassword {
@@ -763,6 +992,12 @@ func (state *RuntimeState) getRequiredWebUIAuthLevel() int {
 		if webUIPref == proto.AuthTypeTOTP {
 			AuthLevel |= AuthTypeTOTP
 		}
+		if webUIPref == proto.AuthTypeDuo {
+			AuthLevel |= AuthTypeDuo
+		}
+		if webUIPref == proto.AuthTypeBackupCode {
+			AuthLevel |= AuthTypeBackupCode
+		}
 	}
 	return AuthLevel
 }
@@ -775,22 +1010,22 @@ func (state *RuntimeState) secretInjectorHandler(w http.ResponseWriter, r *http.
 	// Any user with a valid cert can use this handler
 	if r.TLS == nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-		logger.Printf("We require TLS\n")
+		requestLogger(r).Printf("We require TLS\n")
 		return
 	}
 
 	if len(r.TLS.VerifiedChains) < 1 {
 		state.writeFailureResponse(w, r, http.StatusForbidden, "")
-		logger.Printf("Forbidden\n")
+		requestLogger(r).Printf("Forbidden\n")
 		return
 	}
 	clientName := r.TLS.VerifiedChains[0][0].Subject.CommonName
-	logger.Printf("Got connection from %s", clientName)
+	requestLogger(r).Printf("Got connection from %s", clientName)
 	r.ParseForm()
 	sshCAPassword, ok := r.Form["ssh_ca_password"]
 	if !ok {
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Post, missing data")
-		logger.Printf("missing ssh_ca_password")
+		requestLogger(r).Printf("missing ssh_ca_password")
 		return
 	}
 	state.Mutex.Lock()
@@ -799,51 +1034,29 @@ func (state *RuntimeState) secretInjectorHandler(w http.ResponseWriter, r *http.
 	// TODO.. make network error blocks to goroutines
 	if state.Signer != nil {
 		state.writeFailureResponse(w, r, http.StatusConflict, "Conflict post, signer already unlocked")
-		logger.Printf("Signer not null, already unlocked")
+		requestLogger(r).Printf("Signer not null, already unlocked")
 		return
 	}
 
-	decbuf := bytes.NewBuffer(state.SSHCARawFileContent)
-
-	armorBlock, err := armor.Decode(decbuf)
-	if err != nil {
-		logger.Printf("Cannot decode armored file")
-		return
-	}
 	password := []byte(sshCAPassword[0])
-	failed := false
-	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
-		// If the given passphrase isn't correct, the function will be called again, forever.
-		// This method will fail fast.
-		// Ref: https://godoc.org/golang.org/x/crypto/openpgp#PromptFunction
-		if failed {
-			return nil, errors.New("decryption failed")
-		}
-		failed = true
-		return password, nil
-	}
-	md, err := openpgp.ReadMessage(armorBlock.Body, nil, prompt, nil)
+	defer wipeBytes(password)
+	signer, err := parseCAPrivateKey(state.SSHCARawFileContent, password)
 	if err != nil {
-		logger.Printf("cannot read message")
+		requestLogger(r).Errorf("cannot unseal CA private key: %s", err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Unlocking key")
 		return
 	}
-
-	plaintextBytes, err := ioutil.ReadAll(md.UnverifiedBody)
-	if err != nil {
-		return
-	}
-
-	signer, err := getSignerFromPEMBytes(plaintextBytes)
-	if err != nil {
-		logger.Printf("Cannot parse Priave Key file")
+	if err := state.unsealNextCAKey(password); err != nil {
+		requestLogger(r).Errorf("cannot unseal CA private key: %s", err)
+		zeroizeSigner(signer)
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Unlocking key")
 		return
 	}
 
-	logger.Printf("About to generate cader %s", clientName)
-	state.caCertDer, err = generateCADer(state, signer)
+	requestLogger(r).Printf("About to generate cader %s", clientName)
+	caCertDer, caChainDer, err := generateCAChainDer(state, signer)
 	if err != nil {
-		logger.Printf("Cannot generate CA Der")
+		requestLogger(r).Errorf("Cannot generate CA Der")
 		return
 	}
 	sendMessage := false
@@ -853,8 +1066,12 @@ func (state *RuntimeState) secretInjectorHandler(w http.ResponseWriter, r *http.
 
 	// Assignmet of signer MUST be the last operation after
 	// all error checks
-	state.Signer = signer
+	state.setCAKeys(caKeys{signer: signer, certDer: caCertDer,
+		chainDer: caChainDer, publicKeys: state.KeymasterPublicKeys})
 	state.signerPublicKeyToKeymasterKeys()
+	if err := state.updateCARollover(time.Now()); err != nil {
+		requestLogger(r).Printf("CA rollover: %s", err)
+	}
 	if sendMessage {
 		state.SignerIsReady <- true
 	}
@@ -878,7 +1095,7 @@ func (state *RuntimeState) publicPathHandler(w http.ResponseWriter, r *http.Requ
 	state.Mutex.Unlock()
 	if signerIsNull {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-		logger.Printf("Signer not loaded")
+		requestLogger(r).Printf("Signer not loaded")
 		return
 	}
 
@@ -892,11 +1109,27 @@ func (state *RuntimeState) publicPathHandler(w http.ResponseWriter, r *http.Requ
 		state.writeHTMLLoginPage(w, r, profilePath, "")
 		return
 	case "x509ca":
-		pemCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: state.caCertDer}))
+		pemCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: state.currentCA().certDer}))
 
 		w.Header().Set("Content-Disposition", `attachment; filename="id_rsa-cert.pub"`)
 		w.WriteHeader(200)
 		fmt.Fprintf(w, "%s", pemCert)
+	case caPublicKeyName:
+		state.writeCAPublicKeys(w, r, false)
+	case knownHostsName:
+		state.writeCAPublicKeys(w, r, true)
+	case caFingerprintsName:
+		state.writeCAFingerprints(w, r)
+	case bootstrapShellName:
+		state.writeBootstrapScript(w, r, bootstrapShellTemplate)
+	case bootstrapPowerShellName:
+		state.writeBootstrapScript(w, r, bootstrapPowerShellTemplate)
+	case revokedKRLName:
+		state.writeRevocationFeed(w, r, false)
+	case revokedKRLSignatureName:
+		state.writeRevocationFeed(w, r, true)
+	case x509CRLName:
+		state.writeX509CRL(w, r)
 	default:
 		state.writeFailureResponse(w, r, http.StatusNotFound, "")
 		return
@@ -962,23 +1195,23 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter, r *http.Request)
 	//Check for valid method here?
 	switch r.Method {
 	case "GET":
-		logger.Debugf(3, "Got client GET connection")
+		requestLogger(r).Debugf(3, "Got client GET connection")
 		err := r.ParseForm()
 		if err != nil {
-			logger.Println(err)
+			requestLogger(r).Errorf("%s", err)
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
 			return
 		}
 	case "POST":
-		logger.Debugf(3, "Got client POST connection")
+		requestLogger(r).Debugf(3, "Got client POST connection")
 		//err := r.ParseMultipartForm(1e7)
 		err := r.ParseForm()
 		if err != nil {
-			logger.Println(err)
+			requestLogger(r).Errorf("%s", err)
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
 			return
 		}
-		logger.Debugf(2, "req =%+v", r)
+		requestLogger(r).Debugf(2, "req =%+v", r)
 	default:
 		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
 		return
@@ -991,7 +1224,7 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter, r *http.Request)
 		if val, ok := r.Form["username"]; ok {
 			if len(val) > 1 {
 				state.writeFailureResponse(w, r, http.StatusBadRequest, "Just one username allowed")
-				logger.Printf("Login with multiple usernames")
+				requestLogger(r).Printf("Login with multiple usernames")
 				return
 			}
 			username = val[0]
@@ -1000,7 +1233,7 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter, r *http.Request)
 		if val, ok := r.Form["password"]; ok {
 			if len(val) > 1 {
 				state.writeFailureResponse(w, r, http.StatusBadRequest, "Just one password allowed")
-				logger.Printf("Login with passwords")
+				requestLogger(r).Printf("Login with passwords")
 				return
 			}
 			password = val[0]
@@ -1015,32 +1248,33 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter, r *http.Request)
 	if !state.Config.Base.DisableUsernameNormalization {
 		username = strings.ToLower(username)
 	}
-	valid, err := checkUserPassword(username, password, state.Config, state.passwordChecker, r)
+	valid, err := state.checkThrottledUserPassword(username, password,
+		state.Config, r)
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		return
 	}
 	if !valid {
 		state.writeFailureResponse(w, r, http.StatusUnauthorized, "Invalid Username/Password")
-		logger.Printf("Invalid login for %s", username)
+		requestLogger(r).Warnf("Invalid login for %s", username)
 		//err := errors.New("Invalid Credentials")
 		return
 	}
 
 	// AUTHN has passed
-	logger.Debug(1, "Valid passwd AUTH login for %s", username)
+	requestLogger(r).Debug(1, "Valid passwd AUTH login for %s", username)
 	userHasU2FTokens, err := state.userHasU2FTokens(username)
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "error internal")
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		return
 	}
 
 	//
-	_, err = state.setNewAuthCookie(w, username, AuthTypePassword)
+	_, err = state.setNewAuthCookie(w, r, username, AuthTypePassword)
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "error internal")
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		return
 	}
 	eventNotifier.PublishAuthEvent(eventmon.AuthTypePassword, username)
@@ -1050,32 +1284,16 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter, r *http.Request)
 	if ok {
 		for _, acceptValue := range acceptHeader {
 			if strings.Contains(acceptValue, "text/html") {
-				logger.Debugf(1, "Got it  %+v", acceptValue)
+				requestLogger(r).Debugf(1, "Got it  %+v", acceptValue)
 				returnAcceptType = "text/html"
 			}
 		}
 	}
 
 	// Compute the cert prefs
-	var certBackends []string
-	for _, certPref := range state.Config.Base.AllowedAuthBackendsForCerts {
-		if certPref == proto.AuthTypePassword {
-			certBackends = append(certBackends, proto.AuthTypePassword)
-		}
-		if certPref == proto.AuthTypeU2F && userHasU2FTokens {
-			certBackends = append(certBackends, proto.AuthTypeU2F)
-		}
-		if certPref == proto.AuthTypeSymantecVIP && state.Config.SymantecVIP.Enabled {
-			certBackends = append(certBackends, proto.AuthTypeSymantecVIP)
-		}
-		if certPref == proto.AuthTypeTOTP && state.Config.Base.EnableLocalTOTP {
-			certBackends = append(certBackends, proto.AuthTypeTOTP)
-		}
-	}
-	// logger.Printf("current backends=%+v", certBackends)
-	if len(certBackends) == 0 {
-		certBackends = append(certBackends, proto.AuthTypeU2F)
-	}
+	certBackends := state.activeIssuancePolicy().certAuthBackends(
+		userHasU2FTokens,
+		state.checkDuoEnforcement(username, AuthTypePassword) != nil)
 
 	// TODO: The cert backend should depend also on per user preferences.
 	loginResponse := proto.LoginResponse{Message: "success",
@@ -1084,7 +1302,7 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter, r *http.Request)
 	case "text/html":
 		loginDestination := getLoginDestination(r)
 		requiredAuth := state.getRequiredWebUIAuthLevel()
-		if (requiredAuth & AuthTypePassword) != 0 {
+		if state.passwordSatisfiesWebUI() {
 			eventNotifier.PublishWebLoginEvent(username)
 			http.Redirect(w, r, loginDestination, 302)
 		} else {
@@ -1106,8 +1324,9 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter, r *http.Request)
 	default:
 		// add vippush cookie if we are using VIP
 		usesVIP := false
-		for _, certPref := range state.Config.Base.AllowedAuthBackendsForCerts {
-			if certPref == proto.AuthTypeSymantecVIP && state.Config.SymantecVIP.Enabled {
+		policy := state.activeIssuancePolicy()
+		for _, certPref := range policy.AllowedAuthBackendsForCerts {
+			if certPref == proto.AuthTypeSymantecVIP && policy.SymantecVIPEnabled {
 				usesVIP = true
 			}
 		}
@@ -1151,9 +1370,14 @@ func (state *RuntimeState) logoutHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	if authCookie != nil {
+		state.revokeCookieSession(authCookie)
 		expiration := time.Unix(0, 0)
-		updatedAuthCookie := http.Cookie{Name: authCookieName, Value: "", Expires: expiration, Path: "/", HttpOnly: true, Secure: true}
+		updatedAuthCookie := state.newAuthCookie("", expiration)
 		http.SetCookie(w, &updatedAuthCookie)
+		if _, err := r.Cookie(sessionBindingCookieName); err == nil {
+			bindingCookie := http.Cookie{Name: sessionBindingCookieName, Value: "", Expires: expiration, Path: "/", HttpOnly: true, Secure: true}
+			http.SetCookie(w, &bindingCookie)
+		}
 	}
 	//redirect to login
 	http.Redirect(w, r, "/", 302)
@@ -1223,14 +1447,14 @@ func (state *RuntimeState) usersHandler(
 	}
 	authUser, _, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		return
 	}
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
 
 	users, _, err := state.GetUsers()
 	if err != nil {
-		logger.Printf("Getting users error: %v", err)
+		requestLogger(r).Errorf("Getting users error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 
@@ -1245,7 +1469,7 @@ func (state *RuntimeState) usersHandler(
 		JSSources:    JSSources}
 	err = state.htmlTemplate.ExecuteTemplate(w, "usersPage", displayData)
 	if err != nil {
-		logger.Printf("Failed to execute %v", err)
+		requestLogger(r).Errorf("Failed to execute %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -1278,7 +1502,7 @@ func (state *RuntimeState) profileHandler(w http.ResponseWriter, r *http.Request
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
 	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		return
 	}
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
@@ -1294,9 +1518,9 @@ func (state *RuntimeState) profileHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	//find the user token
-	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
+	profile, _, fromCache, err := state.LoadUserProfileContext(r.Context(), assumedUser)
 	if err != nil {
-		logger.Printf("loading profile error: %v", err)
+		requestLogger(r).Errorf("loading profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 
@@ -1339,7 +1563,7 @@ func (state *RuntimeState) profileHandler(w http.ResponseWriter, r *http.Request
 		}
 		totpdevices = append(totpdevices, deviceData)
 	}
-	showTOTP := state.Config.Base.EnableLocalTOTP
+	showTOTP := state.activeIssuancePolicy().LocalTOTPEnabled
 
 	displayData := profilePageTemplateData{
 		Username:             assumedUser,
@@ -1353,11 +1577,15 @@ func (state *RuntimeState) profileHandler(w http.ResponseWriter, r *http.Request
 		ShowTOTP:             showTOTP,
 		RegisteredTOTPDevice: totpdevices,
 	}
-	logger.Debugf(1, "%v", displayData)
+	if state.Config.Base.BackupCodes.Enabled {
+		displayData.ShowBackupCodes = true
+		displayData.BackupCodesRemaining = profile.BackupCodes.info().Remaining
+	}
+	requestLogger(r).Debugf(1, "%v", displayData)
 
 	err = state.htmlTemplate.ExecuteTemplate(w, "userProfilePage", displayData)
 	if err != nil {
-		logger.Printf("Failed to execute %v", err)
+		requestLogger(r).Errorf("Failed to execute %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -1378,7 +1606,7 @@ func (state *RuntimeState) u2fTokenManagerHandler(w http.ResponseWriter, r *http
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
 	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -1386,11 +1614,11 @@ func (state *RuntimeState) u2fTokenManagerHandler(w http.ResponseWriter, r *http
 	// TODO: ensure is a valid method (POST)
 	err = r.ParseForm()
 	if err != nil {
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
 		return
 	}
-	logger.Debugf(3, "Form: %+v", r.Form)
+	requestLogger(r).Debugf(3, "Form: %+v", r.Form)
 
 	assumedUser := r.Form.Get("username")
 
@@ -1399,28 +1627,28 @@ func (state *RuntimeState) u2fTokenManagerHandler(w http.ResponseWriter, r *http
 
 	// Check params
 	if !hasAdminRights && assumedUser != authUser {
-		logger.Printf("bad username authUser=%s requested=%s", authUser, r.Form.Get("username"))
+		requestLogger(r).Warnf("bad username authUser=%s requested=%s", authUser, r.Form.Get("username"))
 		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 		return
 	}
 
 	tokenIndex, err := strconv.ParseInt(r.Form.Get("index"), 10, 64)
 	if err != nil {
-		logger.Printf("tokenindex is not a number")
+		requestLogger(r).Printf("tokenindex is not a number")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "tokenindex is not a number")
 		return
 	}
 
 	//Do a redirect
-	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
+	profile, _, fromCache, err := state.LoadUserProfileContext(r.Context(), assumedUser)
 	if err != nil {
-		logger.Printf("loading profile error: %v", err)
+		requestLogger(r).Errorf("loading profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 
 	}
 	if fromCache {
-		logger.Printf("DB is being cached and requesting registration aborting it")
+		requestLogger(r).Printf("DB is being cached and requesting registration aborting it")
 		http.Error(w, "db backend is offline for writes", http.StatusServiceUnavailable)
 		return
 	}
@@ -1429,7 +1657,7 @@ func (state *RuntimeState) u2fTokenManagerHandler(w http.ResponseWriter, r *http
 	_, ok := profile.U2fAuthData[tokenIndex]
 	if !ok {
 		//if tokenIndex >= len(profile.U2fAuthData) {
-		logger.Printf("bad index number")
+		requestLogger(r).Warnf("bad index number")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "bad index Value")
 		return
 
@@ -1440,7 +1668,7 @@ func (state *RuntimeState) u2fTokenManagerHandler(w http.ResponseWriter, r *http
 	case "Update":
 		tokenName := r.Form.Get("name")
 		if m, _ := regexp.MatchString("^[-/.a-zA-Z0-9_ ]+$", tokenName); !m {
-			logger.Printf("%s", tokenName)
+			requestLogger(r).Printf("%s", tokenName)
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "invalidtokenName")
 			return
 		}
@@ -1456,9 +1684,9 @@ func (state *RuntimeState) u2fTokenManagerHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	err = state.SaveUserProfile(assumedUser, profile)
+	err = state.SaveUserProfileContext(r.Context(), assumedUser, profile)
 	if err != nil {
-		logger.Printf("Saving profile error: %v", err)
+		requestLogger(r).Errorf("Saving profile error: %v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
 		return
 	}
@@ -1483,7 +1711,7 @@ const clientConfigText = `base:
 func (state *RuntimeState) serveClientConfHandler(w http.ResponseWriter, r *http.Request) {
 	//w.WriteHeader(200)
 	w.Header().Set("Content-Type", "text/yaml")
-	fmt.Fprintf(w, clientConfigText, u2fAppID)
+	fmt.Fprintf(w, clientConfigText, state.u2fAppID)
 }
 
 func (state *RuntimeState) defaultPathHandler(w http.ResponseWriter, r *http.Request) {
@@ -1513,9 +1741,14 @@ type httpLogger struct {
 
 func (l httpLogger) Log(record instrumentedwriter.LogRecord) {
 	if l.AccessLogger != nil {
-		l.AccessLogger.Printf("%s -  %s [%s] \"%s %s %s\" %d %d \"%s\"\n",
+		requestID := record.CustomRecords[logFieldRequestID]
+		if requestID == "" {
+			requestID = "-"
+		}
+		l.AccessLogger.Printf("%s -  %s [%s] \"%s %s %s\" %d %d \"%s\" %s\n",
 			record.Ip, record.Username, record.Time, record.Method,
-			record.Uri, record.Protocol, record.Status, record.Size, record.UserAgent)
+			record.Uri, record.Protocol, record.Status, record.Size, record.UserAgent,
+			requestID)
 	}
 }
 
@@ -1526,6 +1759,12 @@ func Usage() {
 	}
 	fmt.Fprintf(os.Stderr, "Usage of %s (version %s):\n", os.Args[0], displayVersion)
 	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nCommands:\n  %s: create a CA key pair and a starter configuration (run with -h for options)\n",
+		generateCACommand)
+	fmt.Fprintf(os.Stderr, "  %s: import existing CA material (run with -h for options)\n",
+		importCACommand)
+	fmt.Fprintf(os.Stderr, "  %s: send a command to the admin socket (run with -h for options)\n",
+		adminCommand)
 }
 
 func init() {
@@ -1533,6 +1772,13 @@ func init() {
 	prometheus.MustRegister(authOperationCounter)
 	prometheus.MustRegister(externalServiceDurationTotal)
 	prometheus.MustRegister(certDurationHistogram)
+	prometheus.MustRegister(certLintFindingsCounter)
+	prometheus.MustRegister(loginThrottleCounter)
+	prometheus.MustRegister(breakGlassCounter)
+	prometheus.MustRegister(rejectedUploadCounter)
+	prometheus.MustRegister(ldapReferralCounter)
+	prometheus.MustRegister(injectedFaultCounter)
+	prometheus.MustRegister(ciIssuanceCounter)
 	tricorder.RegisterMetric(
 		"keymaster/external-service-duration/LDAP",
 		tricorderLDAPExternalServiceDurationTotal,
@@ -1550,14 +1796,141 @@ func init() {
 		"Time for external Storage server to perform operation(ms)")
 }
 
+// registerAdminHandlers registers the handlers of the admin port which act
+// on state.
+func (state *RuntimeState) registerAdminHandlers(mux *http.ServeMux) {
+	mux.HandleFunc(secretInjectorPath, state.secretInjectorHandler)
+	mux.HandleFunc(trustCoveragePath, state.trustCoverageHandler)
+	mux.HandleFunc(attestationReportPath, state.attestationReportHandler)
+	mux.HandleFunc(usageAnalyticsPath, state.usageAnalyticsHandler)
+	mux.HandleFunc(sshRestrictionsReportPath,
+		state.sshRestrictionsReportHandler)
+	mux.HandleFunc(configDiffPath, state.configDiffHandler)
+	mux.HandleFunc(policyVersionsPath, state.policyVersionsHandler)
+	mux.HandleFunc(policyDiffPath, state.policyDiffHandler)
+	mux.HandleFunc(authorizationAtPath, state.authorizationAtHandler)
+	mux.HandleFunc(accessReviewPath, state.accessReviewHandler)
+	mux.HandleFunc(deadLettersPath, state.deadLettersHandler)
+	mux.HandleFunc(auditStreamDeadLettersPath,
+		state.auditStreamDeadLettersHandler)
+	mux.HandleFunc(issuanceEmailDeadLettersPath,
+		state.issuanceEmailDeadLettersHandler)
+	mux.HandleFunc(metricsHistoryPath, state.metricsHistoryHandler)
+	mux.HandleFunc(staticKeysPath, state.staticKeysHandler)
+	mux.HandleFunc(adminSessionsPath, state.adminSessionsHandler)
+}
+
+// newServiceMux returns the handlers of the service port for state.
+func (state *RuntimeState) newServiceMux() *http.ServeMux {
+	serviceMux := http.NewServeMux()
+	serviceMux.HandleFunc(certgenPath, state.certGenHandler)
+	serviceMux.HandleFunc(publicPath, state.publicPathHandler)
+	serviceMux.HandleFunc(proto.LoginPath, state.loginHandler)
+	serviceMux.HandleFunc(logoutPath, state.logoutHandler)
+	serviceMux.HandleFunc(sessionsPath, state.sessionsHandler)
+	serviceMux.HandleFunc(profilePath, state.withAuthRequirement(
+		authGroupWebUI, state.profileHandler))
+	serviceMux.HandleFunc(usersPath, state.withAuthRequirement(
+		authGroupWebUI, state.usersHandler))
+	serviceMux.HandleFunc(changeRequestsPath, state.withAuthRequirement(
+		authGroupWebUI, state.changeRequestsHandler))
+	serviceMux.HandleFunc(certApprovalsPath, state.withAuthRequirement(
+		authGroupWebUI, state.certApprovalsHandler))
+	serviceMux.HandleFunc(breakGlassPath, state.breakGlassHandler)
+	serviceMux.HandleFunc(passwordPolicyPath, state.withAuthRequirement(
+		authGroupWebUI, state.passwordPolicyHandler))
+	serviceMux.HandleFunc(certRequestPath, state.withAuthRequirement(
+		authGroupWebUI, state.certRequestHandler))
+	serviceMux.HandleFunc(ciCertPath, state.ciCertHandler)
+	serviceMux.HandleFunc(apiV1Path, state.apiV1NotFoundHandler)
+	serviceMux.HandleFunc(v1proto.OpenAPIPath, state.apiV1OpenAPIHandler)
+	serviceMux.HandleFunc(v1proto.CertificatesPath, state.apiV1CertificatesHandler)
+	serviceMux.HandleFunc(v1proto.RevokedCertificatesPath,
+		state.apiV1RevokedCertificatesHandler)
+	serviceMux.HandleFunc(v1proto.LockoutsPath, state.apiV1LockoutsHandler)
+	serviceMux.HandleFunc(approvalsCallbackPath,
+		state.approvalCallbackHandler)
+	serviceMux.HandleFunc(clientBinariesPath,
+		state.clientBinaryHandler)
+	serviceMux.HandleFunc(proto.TrustReportPath, state.trustReportHandler)
+	serviceMux.HandleFunc(proto.StaticKeysReportPath,
+		state.staticKeysReportHandler)
+	serviceMux.HandleFunc(ocspPath, state.ocspHandler)
+	serviceMux.HandleFunc(ocspRequestGETPathPrefix, state.ocspHandler)
+
+	serviceMux.HandleFunc(idpOpenIDCConfigurationDocumentPath, state.idpOpenIDCDiscoveryHandler)
+	serviceMux.HandleFunc(idpOpenIDCJWKSPath, state.idpOpenIDCJWKSHandler)
+	serviceMux.HandleFunc(idpOpenIDCAuthorizationPath, state.withAuthRequirement(authGroupWebUI, state.idpOpenIDCAuthorizationHandler))
+	serviceMux.HandleFunc(idpOpenIDCTokenPath, state.idpOpenIDCTokenHandler)
+	serviceMux.HandleFunc(idpOpenIDCUserinfoPath, state.idpOpenIDCUserinfoHandler)
+
+	staticFilesPath := filepath.Join(state.Config.Base.SharedDataDirectory, "static_files")
+	serviceMux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(staticFilesPath))))
+	customWebResourcesPath := filepath.Join(state.Config.Base.SharedDataDirectory, "customization_data", "web_resources")
+	if _, err := os.Stat(customWebResourcesPath); err == nil {
+		serviceMux.Handle("/custom_static/", http.StripPrefix("/custom_static/", http.FileServer(http.Dir(customWebResourcesPath))))
+	}
+	serviceMux.HandleFunc(u2fRegustisterRequestPath, state.u2fRegisterRequest)
+	serviceMux.HandleFunc(u2fRegisterRequesponsePath, state.u2fRegisterResponse)
+	serviceMux.HandleFunc(u2fSignRequestPath, state.u2fSignRequest)
+	serviceMux.HandleFunc(u2fSignResponsePath, state.u2fSignResponse)
+	serviceMux.HandleFunc(vipAuthPath, state.VIPAuthHandler)
+	serviceMux.HandleFunc(u2fTokenManagementPath, state.u2fTokenManagerHandler)
+	serviceMux.HandleFunc(oauth2LoginBeginPath, state.oauth2DoRedirectoToProviderHandler)
+	serviceMux.HandleFunc(redirectPath, state.oauth2RedirectPathHandler)
+	serviceMux.HandleFunc(clientConfHandlerPath, state.serveClientConfHandler)
+	serviceMux.HandleFunc(vipPushStartPath, state.vipPushStartHandler)
+	serviceMux.HandleFunc(vipPollCheckPath, state.VIPPollCheckHandler)
+	serviceMux.HandleFunc(duoPushStartPath, state.duoPushStartHandler)
+	serviceMux.HandleFunc(duoPollCheckPath, state.duoPollCheckHandler)
+	serviceMux.HandleFunc(totpGeneratNewPath, state.GenerateNewTOTP)
+	serviceMux.HandleFunc(totpValidateNewPath, state.validateNewTOTP)
+	serviceMux.HandleFunc(totpTokenManagementPath, state.totpTokenManagerHandler)
+	serviceMux.HandleFunc(totpVerifyHandlerPath, state.verifyTOTPHandler)
+	serviceMux.HandleFunc(totpAuthPath, state.TOTPAuthHandler)
+	serviceMux.HandleFunc(factorsPath, state.factorsHandler)
+	serviceMux.HandleFunc(backupCodesPath, state.backupCodesHandler)
+	serviceMux.HandleFunc(backupCodeAuthPath, state.backupCodeAuthHandler)
+
+	serviceMux.HandleFunc("/", state.defaultPathHandler)
+	return serviceMux
+}
+
 func main() {
 	flag.Usage = Usage
 	flag.Parse()
 
 	tricorder.RegisterFlags()
 	realLogger := serverlogger.New("")
-	logger = realLogger
+	applicationLogger.buffer = realLogger
+	logger = levellog.New(applicationLogger)
 
+	if flag.Arg(0) == adminCommand {
+		if err := adminClient(*configFilename, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == generateCACommand {
+		err := generateCA(*configFilename, flag.Args()[1:], os.Stdout,
+			getGenerateCAPassphrase)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == importCACommand {
+		if err := importCA(*configFilename, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *checkConfig {
+		os.Exit(runCheckConfig(*configFilename, os.Stdout))
+	}
 	if *generateConfig {
 		err := generateNewConfig(*configFilename)
 		if err != nil {
@@ -1566,17 +1939,44 @@ func main() {
 		return
 	}
 
+	var demo *demoEnvironment
+	if *demoMode {
+		var err error
+		demo, err = setupDemoEnvironment(findDemoSharedDataDirectory())
+		if err != nil {
+			logger.Fatalf("Cannot set up demo: %s", err)
+		}
+		*configFilename = demo.configFilename
+	}
+
 	// TODO(rgooch): Pass this in rather than use a global variable.
 	eventNotifier = eventnotifier.New(logger)
 	runtimeState, err := loadVerifyConfigFile(*configFilename)
 	if err != nil {
-		logger.Println(err)
+		logger.Errorf("%s", err)
+		os.Exit(1)
+	}
+	if err := runtimeState.loadRealms(); err != nil {
+		logger.Errorf("%s", err)
+		os.Exit(1)
+	}
+	if err := runtimeState.Config.Logging.setLogLevels(); err != nil {
+		logger.Errorf("%s", err)
+		os.Exit(1)
+	}
+	if err := runtimeState.setupSyslog(); err != nil {
+		logger.Errorf("%s", err)
+		os.Exit(1)
+	}
+	if err := runtimeState.setupTracing(); err != nil {
+		logger.Errorf("%s", err)
 		os.Exit(1)
 	}
 	logger.Debugf(3, "After load verify")
 
 	publicLogs := runtimeState.Config.Base.PublicLogs
 	adminDashboard := newAdminDashboard(realLogger, publicLogs)
+	adminDashboard.metricsHistory = runtimeState.metricsHistory
 
 	logBufOptions := logbuf.GetStandardOptions()
 	accessLogDirectory := filepath.Join(logBufOptions.Directory, "access")
@@ -1597,66 +1997,51 @@ func main() {
 	// Expose the registered metrics via HTTP.
 	http.Handle("/", adminDashboard)
 	http.Handle("/prometheus_metrics", promhttp.Handler()) //lint:ignore SA1019 TODO: newer prometheus handler
-	http.HandleFunc(secretInjectorPath, runtimeState.secretInjectorHandler)
+	runtimeState.logBuffer = realLogger
+	runtimeState.registerAdminHandlers(http.DefaultServeMux)
+	runtimeState.registerDebugHandlers(http.DefaultServeMux)
+	runtimeState.registerRealmAdminHandlers(http.DefaultServeMux)
+	serviceMux := runtimeState.newServiceMux()
 
-	serviceMux := http.NewServeMux()
-	serviceMux.HandleFunc(certgenPath, runtimeState.certGenHandler)
-	serviceMux.HandleFunc(publicPath, runtimeState.publicPathHandler)
-	serviceMux.HandleFunc(proto.LoginPath, runtimeState.loginHandler)
-	serviceMux.HandleFunc(logoutPath, runtimeState.logoutHandler)
-	serviceMux.HandleFunc(profilePath, runtimeState.profileHandler)
-	serviceMux.HandleFunc(usersPath, runtimeState.usersHandler)
-
-	serviceMux.HandleFunc(idpOpenIDCConfigurationDocumentPath, runtimeState.idpOpenIDCDiscoveryHandler)
-	serviceMux.HandleFunc(idpOpenIDCJWKSPath, runtimeState.idpOpenIDCJWKSHandler)
-	serviceMux.HandleFunc(idpOpenIDCAuthorizationPath, runtimeState.idpOpenIDCAuthorizationHandler)
-	serviceMux.HandleFunc(idpOpenIDCTokenPath, runtimeState.idpOpenIDCTokenHandler)
-	serviceMux.HandleFunc(idpOpenIDCUserinfoPath, runtimeState.idpOpenIDCUserinfoHandler)
-
-	staticFilesPath := filepath.Join(runtimeState.Config.Base.SharedDataDirectory, "static_files")
-	serviceMux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(staticFilesPath))))
-	customWebResourcesPath := filepath.Join(runtimeState.Config.Base.SharedDataDirectory, "customization_data", "web_resources")
-	if _, err = os.Stat(customWebResourcesPath); err == nil {
-		serviceMux.Handle("/custom_static/", http.StripPrefix("/custom_static/", http.FileServer(http.Dir(customWebResourcesPath))))
+	if err := runtimeState.setupACME(); err != nil {
+		logger.Fatalln(err)
+	}
+	runtimeState.serverTLSCertificate, err =
+		runtimeState.newServerTLSCertificate()
+	if err != nil {
+		logger.Fatalf("Cannot load server TLS certificate: %s", err)
 	}
-	serviceMux.HandleFunc(u2fRegustisterRequestPath, runtimeState.u2fRegisterRequest)
-	serviceMux.HandleFunc(u2fRegisterRequesponsePath, runtimeState.u2fRegisterResponse)
-	serviceMux.HandleFunc(u2fSignRequestPath, runtimeState.u2fSignRequest)
-	serviceMux.HandleFunc(u2fSignResponsePath, runtimeState.u2fSignResponse)
-	serviceMux.HandleFunc(vipAuthPath, runtimeState.VIPAuthHandler)
-	serviceMux.HandleFunc(u2fTokenManagementPath, runtimeState.u2fTokenManagerHandler)
-	serviceMux.HandleFunc(oauth2LoginBeginPath, runtimeState.oauth2DoRedirectoToProviderHandler)
-	serviceMux.HandleFunc(redirectPath, runtimeState.oauth2RedirectPathHandler)
-	serviceMux.HandleFunc(clientConfHandlerPath, runtimeState.serveClientConfHandler)
-	serviceMux.HandleFunc(vipPushStartPath, runtimeState.vipPushStartHandler)
-	serviceMux.HandleFunc(vipPollCheckPath, runtimeState.VIPPollCheckHandler)
-	serviceMux.HandleFunc(totpGeneratNewPath, runtimeState.GenerateNewTOTP)
-	serviceMux.HandleFunc(totpValidateNewPath, runtimeState.validateNewTOTP)
-	serviceMux.HandleFunc(totpTokenManagementPath, runtimeState.totpTokenManagerHandler)
-	serviceMux.HandleFunc(totpVerifyHandlerPath, runtimeState.verifyTOTPHandler)
-	serviceMux.HandleFunc(totpAuthPath, runtimeState.TOTPAuthHandler)
-
-	serviceMux.HandleFunc("/", runtimeState.defaultPathHandler)
-
 	cfg := &tls.Config{
+		GetCertificate:           runtimeState.serverTLSCertificate.GetCertificate,
 		ClientCAs:                runtimeState.ClientCAPool,
 		ClientAuth:               tls.VerifyClientCertIfGiven,
 		MinVersion:               tls.VersionTLS12,
 		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
 		PreferServerCipherSuites: true,
+		NextProtos:               serverNextProtos,
 		CipherSuites: []uint16{
 			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
 			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
 			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			// For ECDSA keys held in a PKCS#11 token.
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
 		},
 	}
-	logFilterHandler := NewLogFilterHandler(http.DefaultServeMux, publicLogs)
-	serviceHTTPLogger := httpLogger{AccessLogger: serviceAccessLogger}
-	adminHTTPLogger := httpLogger{AccessLogger: adminAccessLogger}
+	logFilterHandler := NewLogFilterHandler(
+		runtimeState.debugFilterHandler(http.DefaultServeMux), publicLogs)
+	serviceHTTPLogger := httpLogger{AccessLogger: runtimeState.syslogAccessLogger(
+		serviceAccessLogger, "access")}
+	adminHTTPLogger := httpLogger{AccessLogger: runtimeState.syslogAccessLogger(
+		adminAccessLogger, "access-admin")}
+	adminHandler := instrumentedwriter.NewLoggingHandler(
+		requestLogFieldsHandler(runtimeState.tracingHandler(logFilterHandler,
+			http.DefaultServeMux)),
+		adminHTTPLogger)
 	adminSrv := &http.Server{
 		Addr:         runtimeState.Config.Base.AdminAddress,
 		TLSConfig:    cfg,
-		Handler:      instrumentedwriter.NewLoggingHandler(logFilterHandler, adminHTTPLogger),
+		Handler:      adminHandler,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
@@ -1664,48 +2049,43 @@ func main() {
 	srpc.RegisterServerTlsConfig(
 		&tls.Config{ClientCAs: runtimeState.ClientCAPool},
 		true)
-	go func(msg string) {
-		err := adminSrv.ListenAndServeTLS(
-			runtimeState.Config.Base.TLSCertFilename,
-			runtimeState.Config.Base.TLSKeyFilename)
-		if err != nil {
-			panic(err)
-		}
-
-	}("done")
-
-	isReady := <-runtimeState.SignerIsReady
-	if isReady != true {
-		panic("got bad signer ready data")
-	}
-
-	if len(runtimeState.Config.Ldap.LDAPTargetURLs) > 0 && !runtimeState.Config.Ldap.DisablePasswordCache {
-		err = runtimeState.passwordChecker.UpdateStorage(runtimeState)
-		if err != nil {
-			logger.Fatalf("Cannot update password checker")
-		}
-	}
 
 	// Safari in MacOS 10.12.x required a cert to be presented by the user even
 	// when optional.
 	// Our usage shows this is less than 1% of users so we are now mandating
 	// verification on issues we will need to update clientAuth back  to tls.RequestClientCert
 	serviceTLSConfig := &tls.Config{
+		GetCertificate:           runtimeState.serverTLSCertificate.GetCertificate,
 		ClientCAs:                runtimeState.ClientCAPool,
 		ClientAuth:               tls.VerifyClientCertIfGiven,
 		MinVersion:               tls.VersionTLS12,
 		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
 		PreferServerCipherSuites: true,
+		NextProtos:               serverNextProtos,
 		CipherSuites: []uint16{
 			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
 			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
 			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			// For ECDSA keys held in a PKCS#11 token.
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
 		},
 	}
 
+	if err := runtimeState.setRealmTLSConfigs(serviceTLSConfig); err != nil {
+		logger.Fatalln(err)
+	}
+	go runtimeState.checkServerTLSCertificateLoop()
+
 	serviceSrv := &http.Server{
-		Addr:         runtimeState.Config.Base.HttpAddress,
-		Handler:      instrumentedwriter.NewLoggingHandler(serviceMux, serviceHTTPLogger),
+		Addr: runtimeState.Config.Base.HttpAddress,
+		Handler: instrumentedwriter.NewLoggingHandler(
+			requestLogFieldsHandler(runtimeState.tracingHandler(
+				runtimeState.realmHandler(NewProxySignatureHandler(serviceMux,
+					runtimeState.satelliteProxySecrets,
+					runtimeState.Config.RequireSatelliteProxySignature)),
+				serviceMux)),
+			serviceHTTPLogger),
 		TLSConfig:    serviceTLSConfig,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
@@ -1713,15 +2093,39 @@ func main() {
 	}
 
 	http.Handle(eventmon.HttpPath, eventNotifier)
-	go func() {
-		time.Sleep(time.Millisecond * 10)
-		healthserver.SetReady()
-		adminDashboard.setReady()
-	}()
-	err = serviceSrv.ListenAndServeTLS(
-		runtimeState.Config.Base.TLSCertFilename,
-		runtimeState.Config.Base.TLSKeyFilename)
+	components := lifecycle.New(componentHealthInterval, logger)
+	http.Handle(readyzPath, components)
+	err = runtimeState.registerComponents(components, adminSrv, serviceSrv)
 	if err != nil {
-		panic(err)
+		logger.Fatalln(err)
+	}
+	if address := runtimeState.Config.GRPC.Address; address != "" {
+		grpcSrv, err := runtimeState.newGRPCServer(
+			runtimeState.serverTLSCertificate.GetCertificate,
+			serviceHTTPLogger)
+		if err != nil {
+			logger.Fatalln(err)
+		}
+		err = components.Register(grpcComponentName,
+			grpcServerComponent(address, grpcSrv), "service_server")
+		if err != nil {
+			logger.Fatalln(err)
+		}
+	}
+	if err := components.Init(); err != nil {
+		logger.Fatalln(err)
+	}
+	if err := components.Start(); err != nil {
+		components.Stop()
+		logger.Fatalln(err)
+	}
+	healthserver.SetReady()
+	adminDashboard.setReady()
+	if demo != nil {
+		demo.printInstructions(os.Stdout)
+		defer demo.remove()
 	}
+	waitForShutdown(components)
+	runtimeState.closeTracing()
+	runtimeState.closeSyslog()
 }
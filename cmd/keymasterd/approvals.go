@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Symantec/keymaster/keymasterd/changerequests"
+	"github.com/Symantec/keymaster/keymasterd/chatops"
+)
+
+const approvalsCallbackPath = "/api/v0/approvals/"
+
+const approvalKindChangeRequest = "change_request"
+
+var approvalIntegrationNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+type ApprovalIntegrationConfig struct {
+	Name string `yaml:"name"`
+	// "slack" or "webhook".
+	Type       string `yaml:"type"`
+	WebhookURL string `yaml:"webhook_url" secret:"true"`
+	// The Slack signing secret or the webhook secret.
+	SecretFilename string `yaml:"secret_filename"`
+	// Maps Slack user IDs to keymaster usernames.
+	SlackUsers map[string]string `yaml:"slack_users"`
+}
+
+type ApprovalsConfig struct {
+	Integrations []ApprovalIntegrationConfig `yaml:"integrations"`
+}
+
+type approvalCallbackResponse struct {
+	Result  string `json:"result"`
+	Message string `json:"message,omitempty"`
+}
+
+func (config *ApprovalIntegrationConfig) check() error {
+	if !approvalIntegrationNameRegexp.MatchString(config.Name) {
+		return fmt.Errorf("bad name: %q", config.Name)
+	}
+	if config.Type != "slack" && config.Type != "webhook" {
+		return fmt.Errorf("%s: unknown type: %q", config.Name, config.Type)
+	}
+	if config.SecretFilename == "" {
+		return fmt.Errorf("%s: no secret_filename", config.Name)
+	}
+	if config.Type == "webhook" && len(config.SlackUsers) > 0 {
+		return fmt.Errorf("%s: slack_users for a webhook", config.Name)
+	}
+	return nil
+}
+
+// newApprovalIntegrations checks the integrations, reads their secrets and
+// returns them by name.
+func newApprovalIntegrations(config ApprovalsConfig) (
+	map[string]chatops.Integration, error) {
+	integrations := make(map[string]chatops.Integration,
+		len(config.Integrations))
+	for _, integrationConfig := range config.Integrations {
+		if err := integrationConfig.check(); err != nil {
+			return nil, fmt.Errorf("approvals: %s", err)
+		}
+		name := integrationConfig.Name
+		if _, ok := integrations[name]; ok {
+			return nil, fmt.Errorf("approvals: duplicate integration %s", name)
+		}
+		secret, err := ioutil.ReadFile(integrationConfig.SecretFilename)
+		if err != nil {
+			return nil, fmt.Errorf("approvals: %s: cannot read secret: %s",
+				name, err)
+		}
+		secret = bytes.TrimSpace(secret)
+		var integration chatops.Integration
+		if integrationConfig.Type == "slack" {
+			integration, err = chatops.NewSlack(name, chatops.SlackConfig{
+				WebhookURL:    integrationConfig.WebhookURL,
+				SigningSecret: secret,
+				UserIDs:       integrationConfig.SlackUsers,
+			})
+		} else {
+			integration, err = chatops.NewWebhook(name, chatops.WebhookConfig{
+				URL:    integrationConfig.WebhookURL,
+				Secret: secret,
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("approvals: %s: %s", name, err)
+		}
+		integrations[name] = integration
+	}
+	return integrations, nil
+}
+
+func viaSuffix(via string) string {
+	if via == "" {
+		return ""
+	}
+	return " via " + via
+}
+
+// notifyApprovers posts approval to all integrations in the background.
+func (state *RuntimeState) notifyApprovers(approval chatops.Approval) {
+	for _, integration := range state.approvalIntegrations {
+		go func(integration chatops.Integration) {
+			if err := integration.Notify(approval); err != nil {
+				logger.Errorf("Cannot notify approvers of %s %s in %s: %s",
+					approval.Kind, approval.ID, integration.Name(), err)
+			}
+		}(integration)
+	}
+}
+
+func (state *RuntimeState) notifyChangeRequestApprovers(
+	request changerequests.Request) {
+	if len(state.approvalIntegrations) < 1 {
+		return
+	}
+	id := strconv.FormatUint(request.ID, 10)
+	approval := chatops.Approval{
+		Kind:   approvalKindChangeRequest,
+		ID:     id,
+		Title:  "Issuance policy change request " + id,
+		Author: request.Author,
+		URL:    state.idpGetIssuer() + changeRequestsPath + id,
+	}
+	if request.Comment != "" {
+		approval.Details = append(approval.Details, request.Comment)
+	}
+	if info, err := state.makeChangeRequestInfo(request); err == nil {
+		for _, change := range info.FieldChanges {
+			approval.Details = append(approval.Details, fmt.Sprintf(
+				"%s: %v -> %v", change.Field, change.Before, change.After))
+		}
+	}
+	state.notifyApprovers(approval)
+}
+
+// decideChangeRequest applies the decision in callback and returns the
+// outcome to show the approver.
+func (state *RuntimeState) decideChangeRequest(callback chatops.Callback,
+	via string) (string, error) {
+	if state.changeRequests == nil {
+		return "", changerequests.ErrNotFound
+	}
+	id, err := strconv.ParseUint(callback.ID, 10, 64)
+	if err != nil {
+		return "", changerequests.ErrNotFound
+	}
+	if callback.Decision == chatops.DecisionApprove {
+		request, err := state.approveChangeRequestVia(id, callback.Username,
+			via)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Change request %d approved by %s", request.ID,
+			callback.Username), nil
+	}
+	request, err := state.changeRequests.RejectVia(id, callback.Username, via)
+	if err != nil {
+		return "", err
+	}
+	logger.Printf("Change request %d rejected by %s%s", id,
+		callback.Username, viaSuffix(via))
+	return fmt.Sprintf("Change request %d rejected by %s", request.ID,
+		callback.Username), nil
+}
+
+func (state *RuntimeState) writeApprovalCallbackResponse(
+	w http.ResponseWriter, integration chatops.Integration,
+	callback chatops.Callback, status int, result, message string) {
+	if err := integration.Respond(callback, message); err != nil {
+		logger.Errorf("Cannot respond to approver in %s: %s",
+			integration.Name(), err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(approvalCallbackResponse{result, message})
+}
+
+// approvalCallbackHandler receives the decisions of approvers from the chat
+// integration named in the path. Callbacks are authenticated by their
+// signature. Change requests must be decided by an administrator,
+// certificate requests by an approver of their rule.
+func (state *RuntimeState) approvalCallbackHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	integration, ok := state.approvalIntegrations[strings.TrimPrefix(
+		r.URL.Path, approvalsCallbackPath)]
+	if !ok {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	callback, err := integration.ParseCallback(r)
+	switch err {
+	case nil:
+	case chatops.ErrNoDecision:
+		w.WriteHeader(http.StatusOK)
+		return
+	case chatops.ErrBadSignature, chatops.ErrStaleCallback:
+		requestLogger(r).Warnf("Rejected approval callback from %s: %s",
+			integration.Name(), err)
+		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+		return
+	default:
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if callback.Username == "" || callback.Kind ==
+		approvalKindChangeRequest && !state.IsAdminUser(callback.Username) {
+		requestLogger(r).Printf("Refused decision on %s %s from %s user %q",
+			callback.Kind, callback.ID, integration.Name(), callback.UserID)
+		state.writeApprovalCallbackResponse(w, integration, callback,
+			http.StatusForbidden, "error",
+			"Only administrators may review changes")
+		return
+	}
+	via := integration.Name() + ":" + callback.UserID
+	var message string
+	switch callback.Kind {
+	case approvalKindChangeRequest:
+		message, err = state.decideChangeRequest(callback, via)
+	case approvalKindCertificate:
+		message, err = state.decideCertApproval(callback, via)
+	default:
+		state.writeApprovalCallbackResponse(w, integration, callback,
+			http.StatusBadRequest, "error", "Unknown kind: "+callback.Kind)
+		return
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case changerequests.ErrNotFound:
+			status = http.StatusNotFound
+		case changerequests.ErrNotPending, errStaleChangeRequest:
+			status = http.StatusConflict
+		case changerequests.ErrSelfApproval:
+			status = http.StatusForbidden
+		default:
+			status = certApprovalErrorStatus(err)
+		}
+		if status == http.StatusInternalServerError {
+			requestLogger(r).Errorf("%s", err)
+			err = errors.New("internal error")
+		}
+		state.writeApprovalCallbackResponse(w, integration, callback, status,
+			"error", err.Error())
+		return
+	}
+	state.writeApprovalCallbackResponse(w, integration, callback,
+		http.StatusOK, "ok", message)
+}
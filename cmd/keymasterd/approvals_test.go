@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/admincache"
+	"github.com/Symantec/keymaster/keymasterd/changerequests"
+	"github.com/Symantec/keymaster/keymasterd/chatops"
+	"github.com/Symantec/keymaster/keymasterd/policyversions"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+const testApprovalSecret = "0123456789abcdef0123456789abcdef"
+
+func TestApprovalCallbackHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "approvals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.policyVersions, err = policyversions.Open(dir + "/versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.changeRequests, err = changerequests.Open(dir + "/requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.isAdminCache = admincache.New(time.Minute)
+	state.Config.Base.AdminUsers = []string{"alice", "bob"}
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{
+		proto.AuthTypePassword}
+	state.Config.Base.AllowedAuthBackendsForCerts = []string{
+		proto.AuthTypePassword}
+	if err := state.recordConfigPolicyVersion(); err != nil {
+		t.Fatal(err)
+	}
+	notified := make(chan string, 4)
+	bot := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			notified <- string(body)
+		}))
+	defer bot.Close()
+	secretFilename := filepath.Join(dir, "secret")
+	err = ioutil.WriteFile(secretFilename, []byte(testApprovalSecret+"\n"),
+		0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.approvalIntegrations, err = newApprovalIntegrations(ApprovalsConfig{
+		Integrations: []ApprovalIntegrationConfig{{Name: "bot",
+			Type: "webhook", WebhookURL: bot.URL,
+			SecretFilename: secretFilename}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doChangeRequest(t, state, "alice", "POST", "?comment=u2f",
+		"allowed_auth_backends_for_certs: [U2F]\n", http.StatusOK)
+	select {
+	case body := <-notified:
+		if !strings.Contains(body, `"kind":"change_request","id":"1"`) {
+			t.Errorf("unexpected notification: %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("approvers not notified")
+	}
+	callback := func(integration, body, secret string, expectedStatus int) {
+		req := httptest.NewRequest("POST", approvalsCallbackPath+integration,
+			strings.NewReader(body))
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "." + body))
+		req.Header.Set("X-Keymaster-Timestamp", timestamp)
+		req.Header.Set("X-Keymaster-Signature",
+			"v1="+hex.EncodeToString(mac.Sum(nil)))
+		_, err := checkRequestHandlerCode(req, state.approvalCallbackHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatalf("%s: %s", body, err)
+		}
+	}
+	decision := func(decision, approver string) string {
+		return `{"kind":"change_request","id":"1","decision":"` + decision +
+			`","approver":"` + approver + `"}`
+	}
+	callback("bot", decision(chatops.DecisionApprove, "bob"), "forged",
+		http.StatusUnauthorized)
+	callback("other", decision(chatops.DecisionApprove, "bob"),
+		testApprovalSecret, http.StatusNotFound)
+	callback("bot", decision(chatops.DecisionApprove, "mallory"),
+		testApprovalSecret, http.StatusForbidden)
+	callback("bot", decision(chatops.DecisionApprove, "alice"),
+		testApprovalSecret, http.StatusForbidden)
+	if request, _ := state.changeRequests.Get(1); request.State !=
+		changerequests.StatePending {
+		t.Fatalf("request decided by refused callbacks: %+v", request)
+	}
+	callback("bot", decision(chatops.DecisionApprove, "bob"),
+		testApprovalSecret, http.StatusOK)
+	request, _ := state.changeRequests.Get(1)
+	if request.State != changerequests.StateApproved ||
+		request.Reviewer != "bob" || request.ReviewedVia != "bot:bob" {
+		t.Fatalf("unexpected approved request: %+v", request)
+	}
+	if len(state.Config.Base.AllowedAuthBackendsForCerts) != 1 ||
+		state.Config.Base.AllowedAuthBackendsForCerts[0] != proto.AuthTypeU2F {
+		t.Fatal("approved change not active")
+	}
+	callback("bot", decision(chatops.DecisionDeny, "bob"),
+		testApprovalSecret, http.StatusConflict)
+}
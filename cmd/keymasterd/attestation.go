@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const attestationReportPath = "/attestationReport"
+const attestationLogFilename = "issuance_attestation.log"
+
+const secondFactorAuthLevels = AuthTypeU2F | AuthTypeSymantecVIP |
+	AuthTypeTOTP | AuthTypeDuo
+
+// attestationReportResponse carries the report exactly as it was signed, so
+// that the signature can be checked against the JWKS of the instance.
+type attestationReportResponse struct {
+	Report         json.RawMessage `json:"report"`
+	ReportSHA256   string          `json:"report_sha256"`
+	KeyFingerprint string          `json:"key_fingerprint"`
+	JWS            string          `json:"jws"`
+}
+
+func authLevelToMethods(authLevel int) []string {
+	var methods []string
+	for _, method := range []struct {
+		level int
+		name  string
+	}{
+		{AuthTypePassword, proto.AuthTypePassword},
+		{AuthTypeFederated, proto.AuthTypeFederated},
+		{AuthTypeU2F, proto.AuthTypeU2F},
+		{AuthTypeSymantecVIP, proto.AuthTypeSymantecVIP},
+		{AuthTypeIPCertificate, proto.AuthTypeIPCertificate},
+		{AuthTypeTOTP, proto.AuthTypeTOTP},
+		{AuthTypeDuo, proto.AuthTypeDuo},
+		{AuthTypeBreakGlass, breakGlassAuthMethod},
+		{AuthTypeBackupCode, proto.AuthTypeBackupCode},
+	} {
+		if authLevel&method.level != 0 {
+			methods = append(methods, method.name)
+		}
+	}
+	return methods
+}
+
+func (state *RuntimeState) recordIssuanceAttestation(username string,
+	requester string, requestID string, certType string, keyType string,
+	sshCert sshCertRecord, authLevel int, issuedAt time.Time,
+	duration time.Duration) {
+	var serial string
+	if sshCert.Serial != 0 {
+		serial = strconv.FormatUint(sshCert.Serial, 10)
+	}
+	err := state.recordAuditEvent(attestation.Event{
+		Type:            attestation.EventIssued,
+		Time:            issuedAt,
+		Username:        username,
+		RequestedBy:     requester,
+		Policy:          certType,
+		AuthMethods:     authLevelToMethods(authLevel),
+		SecondFactor:    authLevel&secondFactorAuthLevels != 0,
+		Automation:      authLevel&AuthTypeIPCertificate != 0,
+		DurationSecs:    int64(duration.Seconds()),
+		KeyType:         keyType,
+		CAKey:           state.caKeyFingerprint(),
+		KeyID:           sshCert.KeyID,
+		Serial:          serial,
+		Restrictions:    sshCert.Restrictions.Restrictions,
+		RestrictionTier: sshCert.Restrictions.Tier,
+		RequestID:       requestID,
+	})
+	if err != nil {
+		logger.Errorf("cannot record issuance attestation: %s", err)
+	}
+}
+
+// caKeyFingerprint returns the SSH fingerprint of the CA key, which is
+// recorded with issued certificates for rotation planning.
+func (state *RuntimeState) caKeyFingerprint() string {
+	signer := state.currentCA().signer
+	if signer == nil {
+		return ""
+	}
+	publicKey, err := ssh.NewPublicKey(signer.Public())
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(publicKey)
+}
+
+// signAttestationReport returns the serialised report, its compact JWS by
+// keySigner and the fingerprint of keySigner.
+func signAttestationReport(report *attestation.Report,
+	keySigner crypto.Signer) ([]byte, string, string, error) {
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return nil, "", "", err
+	}
+	kid, err := getKeyFingerprint(keySigner.Public())
+	if err != nil {
+		return nil, "", "", err
+	}
+	signerOptions := (&jose.SignerOptions{}).WithType("JSON").
+		WithHeader("kid", kid)
+	signer, err := jose.NewSigner(joseSigningKey(keySigner), signerOptions)
+	if err != nil {
+		return nil, "", "", err
+	}
+	signature, err := signer.Sign(payload)
+	if err != nil {
+		return nil, "", "", err
+	}
+	jws, err := signature.CompactSerialize()
+	if err != nil {
+		return nil, "", "", err
+	}
+	return payload, jws, kid, nil
+}
+
+// attestationReportHandler is served on the admin port and returns the signed
+// issuance attestation for the quarter given as ?quarter=2026-Q3 (by default
+// the last complete quarter) as JSON or, with format=pdf, as a PDF document.
+func (state *RuntimeState) attestationReportHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	now := time.Now()
+	quarter := attestation.QuarterOf(now.UTC().AddDate(0, -3, 0))
+	if quarterString := r.URL.Query().Get("quarter"); quarterString != "" {
+		var err error
+		quarter, err = attestation.ParseQuarter(quarterString)
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "pdf" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"format must be json or pdf")
+		return
+	}
+	events, err := state.attestationLog.Events(quarter.Start(), quarter.End())
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	report := attestation.BuildReport(events, quarter, state.HostIdentity, now)
+	keySigner := state.currentCA().signer
+	if keySigner == nil {
+		requestLogger(r).Printf("Signer not loaded")
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	payload, jws, kid, err := signAttestationReport(report, keySigner)
+	if err != nil {
+		requestLogger(r).Errorf("cannot sign attestation report: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	reportHash := fmt.Sprintf("%x", sha256.Sum256(payload))
+	if format == "pdf" {
+		lines := append(report.Lines(), "", "Signature:",
+			"    key fingerprint: "+kid,
+			"    SHA-256 of JSON report: "+reportHash,
+			"    JWS ("+string(joseSignatureAlgorithm(keySigner.Public()))+
+				", without line breaks):")
+		for len(jws) > 0 {
+			length := 80
+			if length > len(jws) {
+				length = len(jws)
+			}
+			lines = append(lines, jws[:length])
+			jws = jws[length:]
+		}
+		var buffer bytes.Buffer
+		err := attestation.WritePDF(&buffer, "Keymaster issuance attestation "+
+			quarter.String(), lines)
+		if err != nil {
+			requestLogger(r).Errorf("%s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(
+			`attachment; filename="keymaster-attestation-%s.pdf"`, quarter))
+		w.Write(buffer.Bytes())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	// Not indented, so that report is byte for byte the signed payload.
+	json.NewEncoder(w).Encode(attestationReportResponse{
+		Report:         payload,
+		ReportSHA256:   reportHash,
+		KeyFingerprint: kid,
+		JWS:            jws,
+	})
+}
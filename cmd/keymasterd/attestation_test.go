@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestAttestationReportHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "attestation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.attestationLog, err = attestation.Open(filepath.Join(dir,
+		attestationLogFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.recordIssuedCert("user1", "ssh", "Ed25519",
+		AuthTypePassword|AuthTypeU2F, time.Hour)
+	state.recordIssuedCert("user2", "x509", "RSA-2048", AuthTypePassword,
+		time.Hour)
+	state.recordIssuedCert("robot", "ssh", "RSA-2048", AuthTypeIPCertificate,
+		time.Hour)
+	quarter := attestation.QuarterOf(time.Now())
+
+	req, err := http.NewRequest("GET",
+		attestationReportPath+"?quarter="+quarter.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req, state.attestationReportHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response attestationReportResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	signature, err := jose.ParseSigned(response.JWS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := signature.Verify(state.Signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != string(response.Report) {
+		t.Fatal("report does not match the signed payload")
+	}
+	var report attestation.Report
+	if err := json.Unmarshal(payload, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.TotalIssued != 3 || report.AutomationIssued != 1 ||
+		report.SecondFactorRate != 0.5 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	req, err = http.NewRequest("GET",
+		attestationReportPath+"?format=pdf&quarter="+quarter.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(req, state.attestationReportHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(rr.Body.String(), "%PDF-") {
+		t.Fatal("expected a PDF document")
+	}
+	req, err = http.NewRequest("GET", attestationReportPath+"?quarter=bad", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.attestationReportHandler,
+		http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/amqp"
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+	"github.com/Symantec/keymaster/keymasterd/deliveryqueue"
+	"github.com/Symantec/keymaster/keymasterd/kafka"
+)
+
+const auditStreamDeadLettersPath = "/auditStream/deadLetters"
+
+const (
+	auditStreamQueueDirectory    = "audit_stream_queue"
+	auditStreamBufferSize        = 1024
+	auditStreamKafkaSink         = "kafka"
+	auditStreamAMQPSink          = "amqp"
+	auditStreamAtLeastOnce       = "at_least_once"
+	auditStreamAtMostOnce        = "at_most_once"
+	defaultAuditStreamRoutingKey = "keymaster.{type}"
+)
+
+type AuditStreamTLSConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	CAFilename   string `yaml:"ca_filename"`
+	CertFilename string `yaml:"cert_filename"`
+	KeyFilename  string `yaml:"key_filename"`
+}
+
+type AuditStreamSASLConfig struct {
+	// PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512. Default: no SASL.
+	Mechanism        string `yaml:"mechanism"`
+	Username         string `yaml:"username"`
+	PasswordFilename string `yaml:"password_filename"`
+}
+
+type AuditStreamKafkaConfig struct {
+	// Host:port addresses of the brokers to discover the cluster from.
+	Brokers []string `yaml:"brokers"`
+	Topic   string   `yaml:"topic"`
+	// user (the default) keeps the events of each user in order on one
+	// partition, random spreads them over all partitions.
+	PartitionBy string `yaml:"partition_by"`
+	// all (the default), leader or none.
+	Acks string                `yaml:"acks"`
+	TLS  AuditStreamTLSConfig  `yaml:"tls"`
+	SASL AuditStreamSASLConfig `yaml:"sasl"`
+}
+
+type AuditStreamAMQPConfig struct {
+	// amqp://user@host:port/vhost or amqps:// for TLS. The URL may hold the
+	// password with amqps.
+	URL              string `yaml:"url" secret:"true"`
+	PasswordFilename string `yaml:"password_filename"`
+	CAFilename       string `yaml:"ca_filename"`
+	CertFilename     string `yaml:"cert_filename"`
+	KeyFilename      string `yaml:"key_filename"`
+	Exchange         string `yaml:"exchange"`
+	// {type} and {username} are replaced with those of the event. Default:
+	// keymaster.{type}.
+	RoutingKey string `yaml:"routing_key"`
+}
+
+// AuditStreamConfig streams issuance and revocation events to Kafka or an
+// AMQP broker.
+type AuditStreamConfig struct {
+	// at_least_once (the default) keeps events on disk until the brokers
+	// acknowledge them, retrying, so events can be duplicated. at_most_once
+	// sends them once and drops them on errors.
+	Delivery string `yaml:"delivery"`
+	// Default: audit_stream_queue in the data directory.
+	QueueDirectory      string                 `yaml:"queue_directory"`
+	MaxDeliveryAttempts int                    `yaml:"max_delivery_attempts"`
+	Kafka               AuditStreamKafkaConfig `yaml:"kafka"`
+	AMQP                AuditStreamAMQPConfig  `yaml:"amqp"`
+}
+
+func (config *AuditStreamConfig) enabled() bool {
+	return len(config.Kafka.Brokers) > 0 || config.AMQP.URL != ""
+}
+
+func (config *AuditStreamConfig) check() error {
+	switch config.Delivery {
+	case "", auditStreamAtLeastOnce, auditStreamAtMostOnce:
+	default:
+		return fmt.Errorf("audit_stream: unknown delivery: %s",
+			config.Delivery)
+	}
+	if kafkaConfig := config.Kafka; len(kafkaConfig.Brokers) > 0 {
+		if kafkaConfig.Topic == "" {
+			return errors.New("audit_stream: kafka: missing topic")
+		}
+		switch kafkaConfig.PartitionBy {
+		case "", "user", "random":
+		default:
+			return fmt.Errorf("audit_stream: kafka: unknown partition_by: %s",
+				kafkaConfig.PartitionBy)
+		}
+		acks, err := kafkaConfig.requiredAcks()
+		if err != nil {
+			return err
+		}
+		if acks == kafka.AcksNone &&
+			config.Delivery != auditStreamAtMostOnce {
+			return errors.New(
+				"audit_stream: kafka: acks none requires at_most_once delivery")
+		}
+		switch kafkaConfig.SASL.Mechanism {
+		case "", kafka.SASLPlain, kafka.SASLSCRAMSHA256, kafka.SASLSCRAMSHA512:
+		default:
+			return fmt.Errorf("audit_stream: kafka: unknown SASL mechanism: %s",
+				kafkaConfig.SASL.Mechanism)
+		}
+		if kafkaConfig.SASL.Mechanism == kafka.SASLPlain &&
+			!kafkaConfig.TLS.Enabled {
+			return errors.New("audit_stream: kafka: SASL PLAIN requires tls")
+		}
+	}
+	if config.AMQP.URL != "" {
+		parsedURL, err := url.Parse(config.AMQP.URL)
+		if err != nil {
+			return fmt.Errorf("audit_stream: amqp: %s", err)
+		}
+		if parsedURL.Scheme != "amqp" && parsedURL.Scheme != "amqps" {
+			return fmt.Errorf("audit_stream: amqp: invalid url: %s",
+				config.AMQP.URL)
+		}
+		_, urlHasPassword := parsedURL.User.Password()
+		if parsedURL.Scheme != "amqps" &&
+			(urlHasPassword || config.AMQP.PasswordFilename != "") {
+			return errors.New("audit_stream: amqp: a password requires amqps")
+		}
+	}
+	return nil
+}
+
+func (config *AuditStreamKafkaConfig) requiredAcks() (int16, error) {
+	switch config.Acks {
+	case "", "all":
+		return kafka.AcksAll, nil
+	case "leader":
+		return kafka.AcksLeader, nil
+	case "none":
+		return kafka.AcksNone, nil
+	}
+	return 0, fmt.Errorf("audit_stream: kafka: unknown acks: %s", config.Acks)
+}
+
+// auditStreamMessage is what is sent to the brokers.
+type auditStreamMessage struct {
+	attestation.Event
+	// Instance is the host identity of the keymaster which issued or
+	// revoked the certificate.
+	Instance string `json:"instance"`
+}
+
+type auditStreamSink interface {
+	publish(message *auditStreamMessage, payload []byte) error
+}
+
+type kafkaAuditStreamSink struct {
+	producer      *kafka.Producer
+	keyByUsername bool
+}
+
+func (sink *kafkaAuditStreamSink) publish(message *auditStreamMessage,
+	payload []byte) error {
+	var key []byte
+	if sink.keyByUsername && message.Username != "" {
+		key = []byte(message.Username)
+	}
+	return sink.producer.Produce(key, payload)
+}
+
+type amqpAuditStreamSink struct {
+	publisher  *amqp.Publisher
+	routingKey string
+}
+
+func (sink *amqpAuditStreamSink) publish(message *auditStreamMessage,
+	payload []byte) error {
+	routingKey := strings.NewReplacer("{type}", message.Type,
+		"{username}", message.Username).Replace(sink.routingKey)
+	return sink.publisher.Publish(routingKey, payload)
+}
+
+type auditStreamDelivery struct {
+	sink    string
+	payload []byte
+}
+
+type auditStream struct {
+	sinks map[string]auditStreamSink
+	// queue is used for at_least_once delivery, pending otherwise.
+	queue   *deliveryqueue.Queue
+	pending chan auditStreamDelivery
+}
+
+// Send implements deliveryqueue.Sender.
+func (stream *auditStream) Send(sinkName string, payload []byte) error {
+	sink, ok := stream.sinks[sinkName]
+	if !ok {
+		return fmt.Errorf("audit stream sink %s not configured", sinkName)
+	}
+	var message auditStreamMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return err
+	}
+	return sink.publish(&message, payload)
+}
+
+func (stream *auditStream) sendPending() {
+	for delivery := range stream.pending {
+		if err := stream.Send(delivery.sink, delivery.payload); err != nil {
+			logger.Errorf("Cannot stream audit event to %s: %s", delivery.sink,
+				err)
+		}
+	}
+}
+
+// enqueue schedules the delivery of message to all sinks. If stream is nil
+// it is a no-op.
+func (stream *auditStream) enqueue(message auditStreamMessage) {
+	if stream == nil {
+		return
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	for sinkName := range stream.sinks {
+		if stream.queue != nil {
+			if err := stream.queue.Enqueue(sinkName, payload); err != nil {
+				logger.Errorf("Cannot queue audit event for %s: %s", sinkName,
+					err)
+			}
+			continue
+		}
+		select {
+		case stream.pending <- auditStreamDelivery{sinkName, payload}:
+		default:
+			logger.Printf("Audit stream to %s is behind, dropped event",
+				sinkName)
+		}
+	}
+}
+
+func readAuditStreamPassword(filename string) (string, error) {
+	if filename == "" {
+		return "", nil
+	}
+	password, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(password)), nil
+}
+
+func newKafkaAuditStreamSink(config AuditStreamKafkaConfig) (
+	auditStreamSink, error) {
+	acks, err := config.requiredAcks()
+	if err != nil {
+		return nil, err
+	}
+	kafkaConfig := kafka.Config{
+		Brokers:      config.Brokers,
+		Topic:        config.Topic,
+		RequiredAcks: acks,
+	}
+	if config.TLS.Enabled {
+		kafkaConfig.TLSConfig, err = loadClientTLSConfig(config.TLS.CAFilename,
+			config.TLS.CertFilename, config.TLS.KeyFilename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.SASL.Mechanism != "" {
+		password, err := readAuditStreamPassword(config.SASL.PasswordFilename)
+		if err != nil {
+			return nil, err
+		}
+		kafkaConfig.SASL = &kafka.SASLConfig{
+			Mechanism: config.SASL.Mechanism,
+			Username:  config.SASL.Username,
+			Password:  password,
+		}
+	}
+	producer, err := kafka.NewProducer(kafkaConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaAuditStreamSink{
+		producer:      producer,
+		keyByUsername: config.PartitionBy != "random",
+	}, nil
+}
+
+func newAMQPAuditStreamSink(config AuditStreamAMQPConfig) (
+	auditStreamSink, error) {
+	password, err := readAuditStreamPassword(config.PasswordFilename)
+	if err != nil {
+		return nil, err
+	}
+	amqpConfig := amqp.Config{
+		URL:      config.URL,
+		Password: password,
+		Exchange: config.Exchange,
+	}
+	if config.CAFilename != "" || config.CertFilename != "" {
+		amqpConfig.TLSConfig, err = loadClientTLSConfig(config.CAFilename,
+			config.CertFilename, config.KeyFilename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	publisher, err := amqp.NewPublisher(amqpConfig)
+	if err != nil {
+		return nil, err
+	}
+	routingKey := config.RoutingKey
+	if routingKey == "" {
+		routingKey = defaultAuditStreamRoutingKey
+	}
+	return &amqpAuditStreamSink{publisher: publisher, routingKey: routingKey},
+		nil
+}
+
+// setupAuditStream creates the audit stream if any brokers are configured.
+// The brokers are only connected to when events are sent.
+func (state *RuntimeState) setupAuditStream() error {
+	config := state.Config.AuditStream
+	if !config.enabled() {
+		return nil
+	}
+	stream := &auditStream{sinks: make(map[string]auditStreamSink)}
+	if len(config.Kafka.Brokers) > 0 {
+		sink, err := newKafkaAuditStreamSink(config.Kafka)
+		if err != nil {
+			return fmt.Errorf("audit_stream: kafka: %s", err)
+		}
+		stream.sinks[auditStreamKafkaSink] = sink
+	}
+	if config.AMQP.URL != "" {
+		sink, err := newAMQPAuditStreamSink(config.AMQP)
+		if err != nil {
+			return fmt.Errorf("audit_stream: amqp: %s", err)
+		}
+		stream.sinks[auditStreamAMQPSink] = sink
+	}
+	if config.Delivery == auditStreamAtMostOnce {
+		stream.pending = make(chan auditStreamDelivery, auditStreamBufferSize)
+		go stream.sendPending()
+	} else {
+		if config.QueueDirectory == "" {
+			config.QueueDirectory = filepath.Join(
+				state.Config.Base.DataDirectory, auditStreamQueueDirectory)
+		}
+		queue, err := deliveryqueue.New(config.QueueDirectory, stream,
+			deliveryqueue.Params{MaxAttempts: config.MaxDeliveryAttempts},
+			logger)
+		if err != nil {
+			return err
+		}
+		stream.queue = queue
+	}
+	state.auditStream = stream
+	return nil
+}
+
+// recordAuditEvent appends event to the attestation log and streams it to
+// the brokers and syslog, if configured, to the gRPC TailAuditEvents streams
+// and to the signed webhooks.
+func (state *RuntimeState) recordAuditEvent(event attestation.Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	err := state.attestationLog.Record(event)
+	message := auditStreamMessage{
+		Event:    event,
+		Instance: state.HostIdentity,
+	}
+	state.auditStream.enqueue(message)
+	state.auditEventTail.publish(message)
+	state.syslogAuditEvent(message)
+	switch event.Type {
+	case attestation.EventIssued:
+		state.publishWebhookEvent(webhookEventCertIssued, event)
+	case attestation.EventRevoked:
+		state.publishWebhookEvent(webhookEventCertRevoked, event)
+	}
+	return err
+}
+
+// auditStreamDeadLettersHandler is served on the admin port, like
+// deadLettersHandler for the events which could not be streamed.
+func (state *RuntimeState) auditStreamDeadLettersHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.auditStream == nil || state.auditStream.queue == nil {
+		http.Error(w, "Audit stream queue not configured", http.StatusNotFound)
+		return
+	}
+	state.serveDeadLetters(w, r, state.auditStream.queue)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+)
+
+type recordingAuditStreamSink struct {
+	messages chan *auditStreamMessage
+}
+
+func (sink *recordingAuditStreamSink) publish(message *auditStreamMessage,
+	payload []byte) error {
+	sink.messages <- message
+	return nil
+}
+
+func TestAuditStreamConfigCheck(t *testing.T) {
+	for _, config := range []AuditStreamConfig{
+		{Delivery: "exactly_once"},
+		{Kafka: AuditStreamKafkaConfig{Brokers: []string{"kafka:9092"}}},
+		{Kafka: AuditStreamKafkaConfig{Brokers: []string{"kafka:9092"},
+			Topic: "audit", Acks: "none"}},
+		{Kafka: AuditStreamKafkaConfig{Brokers: []string{"kafka:9092"},
+			Topic: "audit", PartitionBy: "host"}},
+		{Kafka: AuditStreamKafkaConfig{Brokers: []string{"kafka:9092"},
+			Topic: "audit", SASL: AuditStreamSASLConfig{Mechanism: "GSSAPI"}}},
+		{Kafka: AuditStreamKafkaConfig{Brokers: []string{"kafka:9092"},
+			Topic: "audit", SASL: AuditStreamSASLConfig{Mechanism: "PLAIN"}}},
+		{AMQP: AuditStreamAMQPConfig{URL: "amqp://rabbit.example.com",
+			PasswordFilename: "/etc/keymaster/amqp.password"}},
+		{AMQP: AuditStreamAMQPConfig{URL: "https://rabbit.example.com"}},
+	} {
+		if err := config.check(); err == nil {
+			t.Errorf("invalid config accepted: %+v", config)
+		}
+	}
+	config := AuditStreamConfig{
+		Delivery: auditStreamAtMostOnce,
+		Kafka: AuditStreamKafkaConfig{Brokers: []string{"kafka:9092"},
+			Topic: "audit", Acks: "none"},
+		AMQP: AuditStreamAMQPConfig{URL: "amqps://rabbit.example.com/audit"},
+	}
+	if err := config.check(); err != nil {
+		t.Error(err)
+	}
+}
+
+func testAuditStream(t *testing.T, delivery string) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "audit_stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.Config.Base.DataDirectory = dir
+	state.HostIdentity = "keymaster-1"
+	state.Config.AuditStream = AuditStreamConfig{
+		Delivery: delivery,
+		Kafka: AuditStreamKafkaConfig{Brokers: []string{"127.0.0.1:1"},
+			Topic: "audit"},
+	}
+	if err := state.Config.AuditStream.check(); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.setupAuditStream(); err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordingAuditStreamSink{
+		messages: make(chan *auditStreamMessage, 1)}
+	state.auditStream.sinks[auditStreamKafkaSink] = sink
+	state.recordIssuanceAttestation("username", "", "req-1", "ssh", "Ed25519",
+		sshCertRecord{}, AuthTypeU2F, time.Now(), time.Hour)
+	select {
+	case message := <-sink.messages:
+		if message.Type != attestation.EventIssued ||
+			message.Username != "username" || !message.SecondFactor ||
+			message.Instance != "keymaster-1" || message.RequestID != "req-1" {
+			t.Errorf("unexpected event: %+v", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not streamed")
+	}
+}
+
+func TestAuditStreamAtLeastOnce(t *testing.T) {
+	testAuditStream(t, "")
+}
+
+func TestAuditStreamAtMostOnce(t *testing.T) {
+	testAuditStream(t, auditStreamAtMostOnce)
+}
+
+func TestAuditStreamDeadLettersHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	req, err := http.NewRequest("GET", auditStreamDeadLettersPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.auditStreamDeadLettersHandler,
+		http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
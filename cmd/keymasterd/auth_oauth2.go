@@ -20,18 +20,18 @@ func (state *RuntimeState) oauth2DoRedirectoToProviderHandler(w http.ResponseWri
 
 	if state.Config.Oauth2.Config == nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "error internal")
-		logger.Println("asking for oauth2, but it is not defined")
+		requestLogger(r).Println("asking for oauth2, but it is not defined")
 		return
 	}
 	if !state.Config.Oauth2.Enabled {
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Oauth2 is not enabled in for this system")
-		logger.Println("asking for oauth2, but it is not enabled")
+		requestLogger(r).Println("asking for oauth2, but it is not enabled")
 		return
 	}
 	cookieVal, err := genRandomString()
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "error internal")
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		return
 	}
 
@@ -41,7 +41,7 @@ func (state *RuntimeState) oauth2DoRedirectoToProviderHandler(w http.ResponseWri
 	stateString, err := genRandomString()
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "error internal")
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		return
 	}
 
@@ -85,12 +85,12 @@ func (state *RuntimeState) oauth2RedirectPathHandler(w http.ResponseWriter, r *h
 
 	if state.Config.Oauth2.Config == nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "error internal")
-		logger.Println("asking for oauth2, but it is not defined")
+		requestLogger(r).Println("asking for oauth2, but it is not defined")
 		return
 	}
 	if !state.Config.Oauth2.Enabled {
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Oauth2 is not enabled in for this system")
-		logger.Println("asking for oauth2, but it is not enabled")
+		requestLogger(r).Println("asking for oauth2, but it is not enabled")
 		return
 	}
 
@@ -98,12 +98,12 @@ func (state *RuntimeState) oauth2RedirectPathHandler(w http.ResponseWriter, r *h
 	if err != nil {
 		if err == http.ErrNoCookie {
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "Missing setup cookie!")
-			logger.Println(err)
+			requestLogger(r).Errorf("%s", err)
 			return
 		}
 		// TODO: this is probably a user error? send back to oath2 login path?
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "error internal")
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		return
 	}
 	index := redirCookie.Value
@@ -113,12 +113,12 @@ func (state *RuntimeState) oauth2RedirectPathHandler(w http.ResponseWriter, r *h
 	if !ok {
 		// clear cookie here!!!!
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid setup cookie!")
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		return
 	}
 
 	if r.URL.Query().Get("state") != pending.state {
-		logger.Printf("state does not match")
+		requestLogger(r).Printf("state does not match")
 		http.Error(w, "state did not match", http.StatusBadRequest)
 		return
 	}
@@ -127,7 +127,7 @@ func (state *RuntimeState) oauth2RedirectPathHandler(w http.ResponseWriter, r *h
 	//}
 	oauth2Token, err := state.Config.Oauth2.Config.Exchange(pending.ctx, r.URL.Query().Get("code"))
 	if err != nil {
-		logger.Printf("failed to get token: ctx: %+v", pending.ctx)
+		requestLogger(r).Errorf("failed to get token: ctx: %+v", pending.ctx)
 		http.Error(w, "Failed to exchange token: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -135,7 +135,7 @@ func (state *RuntimeState) oauth2RedirectPathHandler(w http.ResponseWriter, r *h
 	//client.Get("...")
 	body, err := httpGet(client, state.Config.Oauth2.UserinfoUrl)
 	if err != nil {
-		logger.Printf("fail to fetch %s (%s) ", state.Config.Oauth2.UserinfoUrl, err.Error())
+		requestLogger(r).Printf("fail to fetch %s (%s) ", state.Config.Oauth2.UserinfoUrl, err.Error())
 		http.Error(w, "Failed to get userinfo from url: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -149,16 +149,16 @@ func (state *RuntimeState) oauth2RedirectPathHandler(w http.ResponseWriter, r *h
 		Attributes  map[string][]string `json:"attributes"`
 	}
 
-	logger.Debugf(3, "Userinfo body:'%s'", string(body))
+	requestLogger(r).Debugf(3, "Userinfo body:'%s'", string(body))
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		logger.Printf("failed to unmarshall userinfo to fetch %s ", body)
+		requestLogger(r).Errorf("failed to unmarshall userinfo to fetch %s ", body)
 		http.Error(w, "Failed to get unmarshall userinfo: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// The Name field could also be useful
-	logger.Debugf(2, "%+v", data)
+	requestLogger(r).Debugf(2, "%+v", data)
 
 	// Check if name is there..
 
@@ -175,10 +175,10 @@ func (state *RuntimeState) oauth2RedirectPathHandler(w http.ResponseWriter, r *h
 	}
 
 	//Make new auth cookie
-	_, err = state.setNewAuthCookie(w, username, AuthTypeFederated)
+	_, err = state.setNewAuthCookie(w, r, username, AuthTypeFederated)
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "error internal")
-		logger.Println(err)
+		requestLogger(r).Errorf("%s", err)
 		return
 	}
 
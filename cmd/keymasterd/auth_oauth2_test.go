@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/Symantec/Dominator/lib/log/debuglogger"
+	"github.com/Symantec/keymaster/keymasterd/levellog"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 )
@@ -66,7 +67,7 @@ func userinfoHandler(w http.ResponseWriter, r *http.Request) {
 func init() {
 	//logger = stdlog.New(os.Stderr, "", stdlog.LstdFlags)
 	slogger := stdlog.New(os.Stderr, "", stdlog.LstdFlags)
-	logger = debuglogger.New(slogger)
+	logger = levellog.New(debuglogger.New(slogger))
 	http.HandleFunc("/userinfo", userinfoHandler)
 	http.HandleFunc("/token", tokenHandler)
 	http.HandleFunc("/", handler)
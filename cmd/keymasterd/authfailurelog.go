@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// authFailureLineFormat is the documented format of the lines of the
+// authentication failure log: the UTC time in RFC 3339, the client address,
+// the quoted username and the authentication method.
+const authFailureLineFormat = "%s keymasterd: authentication failure from %s user=%s method=%s\n"
+
+type AuthFailureLogConfig struct {
+	// Filename is the file the authentication failures are appended to, for
+	// fail2ban. Disabled if empty.
+	Filename string `yaml:"filename"`
+}
+
+func (config AuthFailureLogConfig) check() error {
+	if config.Filename == "" {
+		return nil
+	}
+	if !filepath.IsAbs(config.Filename) {
+		return fmt.Errorf("auth_failure_log: filename %s is not absolute",
+			config.Filename)
+	}
+	dir := filepath.Dir(config.Filename)
+	if fi, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("auth_failure_log: %s", err)
+	} else if !fi.IsDir() {
+		return fmt.Errorf("auth_failure_log: %s is not a directory", dir)
+	}
+	return nil
+}
+
+// authFailureLog opens its file for every line, so that it can be rotated by
+// renaming it.
+type authFailureLog struct {
+	filename string
+	mutex    sync.Mutex // Serialises the writes.
+}
+
+func (state *RuntimeState) setupAuthFailureLog() {
+	if filename := state.Config.AuthFailureLog.Filename; filename != "" {
+		state.authFailureLog = &authFailureLog{filename: filename}
+	}
+}
+
+func (l *authFailureLog) write(line string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	file, err := os.OpenFile(l.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+		0640)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func formatAuthFailure(now time.Time, address string, username string,
+	method string) string {
+	return fmt.Sprintf(authFailureLineFormat,
+		now.UTC().Format(time.RFC3339), address, strconv.Quote(username),
+		method)
+}
+
+// recordAuthFailure reports a failed authentication of username with method
+// for r to the authentication failure log and to the signed webhooks.
+func (state *RuntimeState) recordAuthFailure(r *http.Request,
+	username string, method string) {
+	if state.authFailureLog != nil {
+		line := formatAuthFailure(time.Now(), loginThrottleAddress(r),
+			username, method)
+		if err := state.authFailureLog.write(line); err != nil {
+			requestLogger(r).Errorf("Cannot write authentication failure: %s",
+				err)
+		}
+	}
+	state.publishAuthFailure(r, username, method)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// authFailureRegexp is the failregex of misc/fail2ban, with <HOST> expanded.
+var authFailureRegexp = regexp.MustCompile(`^\S+ keymasterd: authentication failure from (\S+) user="(?:[^"\\]|\\.)*" method=\S+$`)
+
+func TestAuthFailureLogConfigCheck(t *testing.T) {
+	for _, config := range []AuthFailureLogConfig{
+		{Filename: "auth-failures.log"},
+		{Filename: "/nonexistent/auth-failures.log"},
+	} {
+		if err := config.check(); err == nil {
+			t.Errorf("%+v accepted", config)
+		}
+	}
+}
+
+func TestAuthFailureLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authfailurelog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var state RuntimeState
+	state.Config.AuthFailureLog.Filename = filepath.Join(dir, "failures.log")
+	if err := state.Config.AuthFailureLog.check(); err != nil {
+		t.Fatal(err)
+	}
+	state.setupAuthFailureLog()
+	req := httptest.NewRequest("POST", "/api/v0/login", nil)
+	req.RemoteAddr = "192.0.2.1:4321"
+	state.recordAuthFailure(req, "alice", "password")
+	// Rotation by renaming.
+	os.Rename(state.Config.AuthFailureLog.Filename,
+		state.Config.AuthFailureLog.Filename+".1")
+	req.RemoteAddr = "[2001:db8::1]:4321"
+	state.recordAuthFailure(req, "bob\n"+formatAuthFailure(time.Now(),
+		"198.51.100.1", "x", "password"), "TOTP")
+	rotated, err := ioutil.ReadFile(state.Config.AuthFailureLog.Filename + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(state.Config.AuthFailureLog.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(rotated)+string(data),
+		"\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines: %q", lines)
+	}
+	for index, address := range []string{"192.0.2.1", "2001:db8::1"} {
+		match := authFailureRegexp.FindStringSubmatch(lines[index])
+		if match == nil || match[1] != address {
+			t.Errorf("%q does not match for %s", lines[index], address)
+		}
+	}
+	if !strings.HasSuffix(lines[0], ` user="alice" method=password`) {
+		t.Errorf("line: %q", lines[0])
+	}
+	if _, err := time.Parse(time.RFC3339, strings.Fields(lines[0])[0]); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Symantec/keymaster/keymasterd/authrequirement"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+// The endpoint groups which may have a requirement.
+const (
+	// Issuing certificates, on top of the other checks of the handlers.
+	authGroupCertificates = "certificates"
+	// The pages of a logged in user, but not the login steps.
+	authGroupWebUI = "web_ui"
+)
+
+// AuthRequirementsConfig maps endpoint groups to boolean combinations of
+// authentication methods, e.g. certificates: "IPCertificate OR (password AND
+// U2F)". A requirement replaces the allowed_auth_backends_for_certs or
+// allowed_auth_backends_for_webui list of the base section for its group.
+type AuthRequirementsConfig map[string]string
+
+// authRequirementMethods are the method names of requirements, listed in
+// the order offered to clients.
+var authRequirementMethods = []struct {
+	name string
+	bit  int
+}{
+	{proto.AuthTypePassword, AuthTypePassword},
+	{proto.AuthTypeFederated, AuthTypeFederated},
+	{proto.AuthTypeU2F, AuthTypeU2F},
+	{proto.AuthTypeSymantecVIP, AuthTypeSymantecVIP},
+	{proto.AuthTypeTOTP, AuthTypeTOTP},
+	{proto.AuthTypeDuo, AuthTypeDuo},
+	{proto.AuthTypeBackupCode, AuthTypeBackupCode},
+	{proto.AuthTypeIPCertificate, AuthTypeIPCertificate},
+}
+
+func parseAuthRequirement(text string) (*authrequirement.Requirement, error) {
+	methods := make(map[string]int, len(authRequirementMethods))
+	for _, method := range authRequirementMethods {
+		methods[method.name] = method.bit
+	}
+	return authrequirement.Parse(text, methods)
+}
+
+func (config AuthRequirementsConfig) check() error {
+	for group, text := range config {
+		switch group {
+		case authGroupCertificates, authGroupWebUI:
+		default:
+			return fmt.Errorf("auth_requirements: unknown endpoint group %q",
+				group)
+		}
+		if _, err := parseAuthRequirement(text); err != nil {
+			return fmt.Errorf("auth_requirements: %s: %s", group, err)
+		}
+	}
+	return nil
+}
+
+// requirement returns the requirement of group, nil if there is none.
+func (config AuthRequirementsConfig) requirement(
+	group string) (*authrequirement.Requirement, error) {
+	text := config[group]
+	if text == "" {
+		return nil, nil
+	}
+	return parseAuthRequirement(text)
+}
+
+// requirementCertAuthBackends returns the methods in requirement that clients
+// may do for certificates. Password is only listed if it is sufficient by
+// itself, as clients log in with a password in any case.
+func requirementCertAuthBackends(
+	requirement *authrequirement.Requirement) []string {
+	var backends []string
+	for _, method := range authRequirementMethods {
+		if method.bit&requirement.Methods() == 0 {
+			continue
+		}
+		if method.bit == AuthTypePassword &&
+			!requirement.Satisfied(AuthTypePassword) {
+			continue
+		}
+		backends = append(backends, method.name)
+	}
+	return backends
+}
+
+// passwordSatisfiesWebUI returns true if logging in with a password is
+// enough for the web UI, without a second factor.
+func (state *RuntimeState) passwordSatisfiesWebUI() bool {
+	requirement, err := state.activeIssuancePolicy().AuthRequirements.requirement(
+		authGroupWebUI)
+	if err != nil {
+		return false
+	}
+	if requirement != nil {
+		return requirement.Satisfied(AuthTypePassword)
+	}
+	return state.getRequiredWebUIAuthLevel()&AuthTypePassword != 0
+}
+
+// withAuthRequirement returns handler behind the requirement of group, if
+// there is one. The handler still authenticates requests itself.
+func (state *RuntimeState) withAuthRequirement(group string,
+	handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requirement, err := state.activeIssuancePolicy().AuthRequirements.requirement(
+			group)
+		if err != nil {
+			requestLogger(r).Printf("auth_requirements: %s: %s", group, err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		if requirement == nil {
+			handler(w, r)
+			return
+		}
+		if state.sendFailureToClientIfLocked(w, r) {
+			return
+		}
+		authUser, authLevel, err := state.checkAuth(w, r,
+			requirement.Methods())
+		if err != nil {
+			requestLogger(r).Debugf(1, "%v", err)
+			return
+		}
+		if !requirement.Satisfied(authLevel) {
+			requestLogger(r).Debugf(1, "%s does not satisfy %s for %s", authUser,
+				requirement, group)
+			state.writeFailureResponse(w, r, http.StatusUnauthorized,
+				"Authentication required: "+requirement.String())
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(),
+			checkedAuthKey{}, checkedAuth{authUser, authLevel})))
+	}
+}
+
+type checkedAuthKey struct{}
+
+// checkedAuth is the result of the checkAuth of withAuthRequirement, which
+// the checkAuth of the handler returns instead of checking the credentials
+// again. Passwords would be throttled and sessions started twice otherwise.
+type checkedAuth struct {
+	username  string
+	authLevel int
+}
+
+// checkedAuthOf returns the checkedAuth of r if it has a method of
+// requiredAuthType.
+func checkedAuthOf(r *http.Request, requiredAuthType int) (checkedAuth, bool) {
+	auth, ok := r.Context().Value(checkedAuthKey{}).(checkedAuth)
+	if !ok || auth.authLevel&requiredAuthType == 0 {
+		return checkedAuth{}, false
+	}
+	return auth, true
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+func TestAuthRequirementsConfigCheck(t *testing.T) {
+	valid := AuthRequirementsConfig{
+		authGroupCertificates: "IPCertificate OR (password AND U2F)",
+		authGroupWebUI:        "password and (totp or u2f)",
+	}
+	if err := valid.check(); err != nil {
+		t.Fatal(err)
+	}
+	for _, config := range []AuthRequirementsConfig{
+		{"api": "password"},
+		{authGroupWebUI: "password AND"},
+		{authGroupCertificates: "password AND api-key"},
+	} {
+		if err := config.check(); err == nil {
+			t.Errorf("%v accepted", config)
+		}
+	}
+}
+
+func TestRequirementCertAuthBackends(t *testing.T) {
+	for text, expected := range map[string][]string{
+		"password AND (U2F OR TOTP)": {proto.AuthTypeU2F, proto.AuthTypeTOTP},
+		"password OR Duo":            {proto.AuthTypePassword, proto.AuthTypeDuo},
+		"IPCertificate OR U2F":       {proto.AuthTypeU2F, proto.AuthTypeIPCertificate},
+	} {
+		requirement, err := parseAuthRequirement(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backends := requirementCertAuthBackends(requirement)
+		if !reflect.DeepEqual(backends, expected) {
+			t.Errorf("%s: got %v, expected %v", text, backends, expected)
+		}
+	}
+}
+
+func TestIsAuthLevelSufficientForCertsRequirement(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.Config.AuthRequirements = AuthRequirementsConfig{
+		authGroupCertificates: "U2F AND TOTP"}
+	for authLevel, expected := range map[int]bool{
+		AuthTypeU2F:                false,
+		AuthTypeTOTP:               false,
+		AuthTypeU2F | AuthTypeTOTP: true,
+	} {
+		if state.isAuthLevelSufficientForCerts(authLevel) != expected {
+			t.Errorf("level %d: expected %v", authLevel, expected)
+		}
+	}
+}
+
+func TestWithAuthRequirement(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{
+		proto.AuthTypePassword}
+	handler := state.withAuthRequirement(authGroupWebUI,
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	request := func(authLevel int) *http.Request {
+		req, err := http.NewRequest("GET", profilePath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookieVal, err := state.setNewAuthCookie(nil, nil, "username",
+			authLevel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		return req
+	}
+
+	// Without a requirement the handler decides.
+	if _, err := checkRequestHandlerCode(request(AuthTypePassword), handler,
+		http.StatusOK); err != nil {
+		t.Fatal(err)
+	}
+	state.Config.AuthRequirements = AuthRequirementsConfig{
+		authGroupWebUI: "password AND U2F"}
+	if _, err := checkRequestHandlerCode(request(AuthTypePassword), handler,
+		http.StatusUnauthorized); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkRequestHandlerCode(
+		request(AuthTypePassword|AuthTypeU2F), handler,
+		http.StatusOK); err != nil {
+		t.Fatal(err)
+	}
+	if state.passwordSatisfiesWebUI() {
+		t.Error("password alone satisfies password AND U2F")
+	}
+}
+
+func TestWithAuthRequirementChecksOnce(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.Config.AuthRequirements = AuthRequirementsConfig{
+		authGroupWebUI: "password"}
+	handler := state.withAuthRequirement(authGroupWebUI,
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _, err := state.checkAuth(w, r, AuthTypePassword)
+			if err != nil {
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+	req, err := http.NewRequest("GET", profilePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/html")
+	req.SetBasicAuth(validUsernameConst, validPasswordConst)
+	rr, err := checkRequestHandlerCode(req, handler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The password is checked and the session started once.
+	if cookies := rr.Result().Cookies(); len(cookies) != 1 {
+		t.Errorf("got %d cookies", len(cookies))
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+const authzHistoryFilename = "authz_history"
+const authorizationAtPath = "/authorizationAt"
+
+type authorizationGroups struct {
+	Groups []string `json:"groups"`
+	// When the snapshot used was taken, empty if there is none.
+	RecordedAt string `json:"recorded_at,omitempty"`
+}
+
+type authorizationRevocation struct {
+	Serial    string `json:"serial"`
+	Revoked   bool   `json:"revoked"`
+	RevokedAt string `json:"revoked_at,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type authorizationAtResponse struct {
+	Username      string                   `json:"username"`
+	Time          string                   `json:"time"`
+	Methods       []string                 `json:"methods"`
+	PolicyVersion policyVersionInfo        `json:"policy_version"`
+	InForceSince  string                   `json:"in_force_since"`
+	Groups        authorizationGroups      `json:"groups"`
+	Outcome       issuanceOutcome          `json:"outcome"`
+	Revocation    *authorizationRevocation `json:"revocation,omitempty"`
+	Allowed       bool                     `json:"allowed"`
+	Reasons       []string                 `json:"reasons"`
+}
+
+// recordActivePolicy records the policy version in force from now on. It
+// is called whenever that may have changed.
+func (state *RuntimeState) recordActivePolicy() {
+	version := state.activePolicyVersion()
+	if version.ID == 0 {
+		return
+	}
+	if err := state.authzHistory.RecordPolicy(version.ID,
+		time.Now()); err != nil {
+		logger.Errorf("Cannot record policy version %d: %s", version.ID, err)
+	}
+}
+
+func (state *RuntimeState) recordUserGroups(username string,
+	groups []string) {
+	if err := state.authzHistory.RecordGroups(username, groups,
+		time.Now()); err != nil {
+		logger.Errorf("Cannot record groups of %s: %s", username, err)
+	}
+}
+
+// parseAuthMethods returns the auth level of the comma separated method
+// names.
+func parseAuthMethods(text string) (int, []string, error) {
+	authLevel := AuthTypeNone
+	var names []string
+	for _, name := range strings.Split(text, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, method := range authRequirementMethods {
+			if strings.EqualFold(name, method.name) {
+				authLevel |= method.bit
+				names = append(names, method.name)
+				found = true
+			}
+		}
+		if !found {
+			return 0, nil, fmt.Errorf("unknown authentication method %q", name)
+		}
+	}
+	return authLevel, names, nil
+}
+
+// authorizationAtHandler is served on the admin port and answers whether a
+// user authenticated with the given methods would have got a certificate at
+// a past instant, and why:
+//
+//	GET /authorizationAt?user=alice&time=2026-10-01T12:00:00Z&methods=password,U2F[&serial=N]
+//
+// The policy in force and the groups of the user at that time are taken from
+// the authorization history. With serial, the state of that certificate on
+// the revocation list is reported too.
+func (state *RuntimeState) authorizationAtHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	query := r.URL.Query()
+	username := query.Get("user")
+	if username == "" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Missing user")
+		return
+	}
+	when := time.Now()
+	if text := query.Get("time"); text != "" {
+		var err error
+		when, err = time.Parse(time.RFC3339, text)
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Bad time: "+err.Error())
+			return
+		}
+	}
+	authLevel, methods, err := parseAuthMethods(query.Get("methods"))
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	policyEvent, ok := state.authzHistory.PolicyAt(when)
+	if !ok {
+		state.writeFailureResponse(w, r, http.StatusNotFound,
+			"No policy recorded as in force at "+when.Format(time.RFC3339))
+		return
+	}
+	version, ok := state.policyVersions.Get(policyEvent.PolicyVersion)
+	if !ok {
+		requestLogger(r).Printf("Missing policy version %d", policyEvent.PolicyVersion)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	policy, err := parseIssuancePolicy(version.Policy)
+	if err != nil {
+		requestLogger(r).Errorf("Cannot parse policy version %d: %s", version.ID, err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	response := authorizationAtResponse{
+		Username:      username,
+		Time:          when.UTC().Format(time.RFC3339),
+		Methods:       methods,
+		PolicyVersion: makePolicyVersionInfo(version),
+		InForceSince:  policyEvent.Time.Format(time.RFC3339),
+		Groups:        authorizationGroups{Groups: []string{}},
+		Allowed:       true,
+	}
+	if response.Methods == nil {
+		response.Methods = []string{}
+	}
+	response.Reasons = append(response.Reasons,
+		fmt.Sprintf("policy version %d (%s) in force since %s", version.ID,
+			version.Source, response.InForceSince))
+	if groupsEvent, ok := state.authzHistory.GroupsAt(username, when); ok {
+		if groupsEvent.Groups != nil {
+			response.Groups.Groups = groupsEvent.Groups
+		}
+		response.Groups.RecordedAt = groupsEvent.Time.Format(time.RFC3339)
+		response.Reasons = append(response.Reasons,
+			"groups as recorded at "+response.Groups.RecordedAt)
+	} else {
+		response.Reasons = append(response.Reasons,
+			"no groups recorded for the user by then, evaluated without groups")
+	}
+	response.Outcome = policy.evaluate(username, response.Groups.Groups)
+	deny := func(reason string) {
+		response.Allowed = false
+		response.Reasons = append(response.Reasons, "denied: "+reason)
+	}
+	if authLevel == AuthTypeNone {
+		deny("no authentication methods given")
+	}
+	if authLevel&AuthTypeIPCertificate != 0 && !response.Outcome.Automation {
+		deny("IP restricted certificates are only accepted for automation users")
+	}
+	if response.Outcome.DuoRequired &&
+		authLevel&(AuthTypeDuo|AuthTypeIPCertificate) == 0 {
+		deny("Duo approval required for members of " +
+			strings.Join(policy.DuoEnforceGroups, ", "))
+	}
+	if authLevel != AuthTypeNone && !policy.sufficientForCerts(authLevel) {
+		// U2F is always accepted without a requirement.
+		accepted := append([]string(nil), policy.AllowedAuthBackendsForCerts...)
+		if !stringsIntersect(accepted, []string{proto.AuthTypeU2F}) {
+			accepted = append(accepted, proto.AuthTypeU2F)
+		}
+		acceptedText := strings.Join(accepted, " OR ")
+		if requirement, err := policy.AuthRequirements.requirement(
+			authGroupCertificates); err == nil && requirement != nil {
+			acceptedText = requirement.String()
+		}
+		deny(strings.Join(methods, " AND ") + " does not satisfy " +
+			acceptedText)
+	}
+	if serial := query.Get("serial"); serial != "" {
+		revocation := &authorizationRevocation{Serial: serial}
+		if entry, ok := state.revokedCerts.Get(serial); ok &&
+			!entry.Time.After(when) {
+			revocation.Revoked = true
+			revocation.RevokedAt = entry.Time.Format(time.RFC3339)
+			revocation.Reason = entry.Reason
+			if authLevel&AuthTypeIPCertificate != 0 {
+				deny("certificate " + entry.Serial + " revoked at " +
+					revocation.RevokedAt)
+			}
+		}
+		response.Revocation = revocation
+	}
+	if response.Allowed {
+		response.Reasons = append(response.Reasons, "allowed")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
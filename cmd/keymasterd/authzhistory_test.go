@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/authzhistory"
+	"github.com/Symantec/keymaster/keymasterd/policyversions"
+	"github.com/Symantec/keymaster/keymasterd/revocationlist"
+)
+
+const testDuoPolicy = `allowed_auth_backends_for_certs: ["U2F"]
+duo_enabled: true
+duo_enforce_groups: ["ops"]
+`
+
+func TestAuthorizationAtHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "authzhistory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.policyVersions, err = policyversions.Open(
+		filepath.Join(dir, policyVersionsDirectory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.authzHistory, err = authzhistory.Open(
+		filepath.Join(dir, authzHistoryFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.revokedCerts, err = revocationlist.Open(
+		filepath.Join(dir, revokedCertsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwordVersion, _, err := state.policyVersions.Add(
+		[]byte("allowed_auth_backends_for_certs: [\"password\"]\n"),
+		policySourceConfig, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	duoVersion, _, err := state.policyVersions.Add([]byte(testDuoPolicy),
+		policySourceConfig, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	if err := state.authzHistory.RecordPolicy(passwordVersion.ID,
+		start); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.authzHistory.RecordGroups("alice", []string{"ops"},
+		start); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.authzHistory.RecordPolicy(duoVersion.ID,
+		start.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := state.revokedCerts.Revoke("42", "lost"); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(at time.Time, methods string, serial string,
+		expectedStatus int) authorizationAtResponse {
+		values := url.Values{"user": {"alice"},
+			"time": {at.Format(time.RFC3339)}, "methods": {methods}}
+		if serial != "" {
+			values.Set("serial", serial)
+		}
+		req, err := http.NewRequest("GET",
+			authorizationAtPath+"?"+values.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := checkRequestHandlerCode(req, state.authorizationAtHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response authorizationAtResponse
+		if expectedStatus == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return response
+	}
+
+	query(start.Add(-time.Minute), "password", "", http.StatusNotFound)
+	query(start, "bogus", "", http.StatusBadRequest)
+	response := query(start.Add(time.Hour), "password", "42", http.StatusOK)
+	if !response.Allowed || response.PolicyVersion.ID != passwordVersion.ID {
+		t.Errorf("unexpected response: %+v", response)
+	}
+	if response.Revocation == nil || response.Revocation.Revoked {
+		t.Errorf("revoked before the revocation: %+v", response.Revocation)
+	}
+	response = query(start.Add(3*time.Hour), "password", "", http.StatusOK)
+	if response.Allowed || !response.Outcome.DuoRequired ||
+		len(response.Groups.Groups) != 1 || len(response.Reasons) != 4 {
+		t.Errorf("unexpected response: %+v", response)
+	}
+	response = query(start.Add(3*time.Hour), "u2f,duo", "", http.StatusOK)
+	if !response.Allowed || response.PolicyVersion.ID != duoVersion.ID {
+		t.Errorf("unexpected response: %+v", response)
+	}
+	response = query(time.Now().Add(time.Minute), "u2f,duo", "42",
+		http.StatusOK)
+	if response.Revocation == nil || !response.Revocation.Revoked ||
+		response.Revocation.Reason != "lost" {
+		t.Errorf("unexpected revocation: %+v", response.Revocation)
+	}
+}
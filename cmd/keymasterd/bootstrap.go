@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The bootstrap scripts install the client, write its configuration and get
+// the first certificates, e.g. with
+// curl -fsSL https://keymaster.example.com/public/bootstrap.sh | sh
+const (
+	bootstrapShellName      = "bootstrap.sh"
+	bootstrapPowerShellName = "bootstrap.ps1"
+	clientBinariesPath      = "/public/client/"
+)
+
+var clientBinaryNameRegexp = regexp.MustCompile(
+	`^keymaster-(darwin|freebsd|linux|windows)-(386|amd64|arm|arm64)(\.exe)?$`)
+
+type BootstrapConfig struct {
+	// Directory with client binaries named keymaster-<os>-<arch>, with
+	// .exe for Windows, e.g. keymaster-linux-amd64. They are served under
+	// /public/client/ and installed by the bootstrap scripts.
+	ClientBinariesDirectory string `yaml:"client_binaries_directory"`
+}
+
+type clientBinary struct {
+	Name   string
+	OS     string
+	Arch   string
+	SHA256 string
+}
+
+type clientBinaryHash struct {
+	size    int64
+	modTime time.Time
+	sha256  string
+}
+
+// clientBinaryIndex lists the client binaries in a directory. Hashes are
+// computed again only for files which changed, so binaries can be replaced
+// without a restart.
+type clientBinaryIndex struct {
+	directory string
+	mutex     sync.Mutex
+	hashes    map[string]clientBinaryHash // Protected by mutex.
+}
+
+func newClientBinaryIndex(directory string) *clientBinaryIndex {
+	return &clientBinaryIndex{
+		directory: directory,
+		hashes:    make(map[string]clientBinaryHash),
+	}
+}
+
+func hashFile(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// list returns the client binaries. A nil *clientBinaryIndex has none.
+func (index *clientBinaryIndex) list() ([]clientBinary, error) {
+	if index == nil {
+		return nil, nil
+	}
+	fileInfos, err := ioutil.ReadDir(index.directory)
+	if err != nil {
+		return nil, err
+	}
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	var binaries []clientBinary
+	for _, fileInfo := range fileInfos {
+		match := clientBinaryNameRegexp.FindStringSubmatch(fileInfo.Name())
+		if match == nil || !fileInfo.Mode().IsRegular() ||
+			(match[1] == "windows") != (match[3] == ".exe") {
+			continue
+		}
+		hash, ok := index.hashes[fileInfo.Name()]
+		if !ok || hash.size != fileInfo.Size() ||
+			!hash.modTime.Equal(fileInfo.ModTime()) {
+			sum, err := hashFile(filepath.Join(index.directory,
+				fileInfo.Name()))
+			if err != nil {
+				return nil, err
+			}
+			hash = clientBinaryHash{fileInfo.Size(), fileInfo.ModTime(), sum}
+			index.hashes[fileInfo.Name()] = hash
+		}
+		binaries = append(binaries, clientBinary{
+			Name:   fileInfo.Name(),
+			OS:     match[1],
+			Arch:   match[2],
+			SHA256: hash.sha256,
+		})
+	}
+	return binaries, nil
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// powerShellQuote quotes s as a PowerShell verbatim string.
+func powerShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+var bootstrapTemplateFuncs = template.FuncMap{
+	"sh": shellQuote,
+	"ps": powerShellQuote,
+}
+
+var bootstrapShellTemplate = template.Must(template.New(
+	bootstrapShellName).Funcs(bootstrapTemplateFuncs).Parse(`#!/bin/sh
+# Installs the keymaster client for {{.URL}} and gets the first
+# certificates. Generated by keymasterd from its configuration.
+set -eu
+url={{sh .URL}}
+bindir="${KEYMASTER_BINDIR:-$HOME/.local/bin}"
+os=$(uname -s | tr '[:upper:]' '[:lower:]')
+case $(uname -m) in
+x86_64|amd64) arch=amd64 ;;
+aarch64|arm64) arch=arm64 ;;
+i?86) arch=386 ;;
+arm*) arch=arm ;;
+*) arch=$(uname -m) ;;
+esac
+sha256=
+case "$os-$arch" in
+{{- range .Binaries}}{{if ne .OS "windows"}}
+{{.OS}}-{{.Arch}}) sha256={{.SHA256}} ;;
+{{- end}}{{end}}
+esac
+if [ -n "$sha256" ]; then
+	mkdir -p "$bindir"
+	tmp=$(mktemp "$bindir/.keymaster.XXXXXX")
+	trap 'rm -f "$tmp"' EXIT
+	curl -fsSL -o "$tmp" "$url/public/client/keymaster-$os-$arch"
+	if command -v sha256sum >/dev/null 2>&1; then
+		actual=$(sha256sum "$tmp" | cut -d ' ' -f 1)
+	else
+		actual=$(shasum -a 256 "$tmp" | cut -d ' ' -f 1)
+	fi
+	if [ "$actual" != "$sha256" ]; then
+		echo "Checksum mismatch for keymaster-$os-$arch" >&2
+		exit 1
+	fi
+	chmod 755 "$tmp"
+	mv "$tmp" "$bindir/keymaster"
+	keymaster="$bindir/keymaster"
+	echo "Installed $keymaster"
+elif command -v keymaster >/dev/null 2>&1; then
+	keymaster=$(command -v keymaster)
+else
+	echo "No keymaster client for $os-$arch is available from $url" >&2
+	exit 1
+fi
+config="$HOME/.keymaster/client_config.yml"
+if [ -e "$config" ]; then
+	echo "Keeping existing $config"
+else
+	mkdir -p "$HOME/.keymaster"
+	printf '%s' {{sh .ClientConfig}} >"$config"
+	echo "Wrote $config"
+fi
+# When piped into sh the client must prompt on the terminal.
+if [ ! -t 0 ] && (: </dev/tty) 2>/dev/null; then
+	exec "$keymaster" "$@" </dev/tty
+fi
+exec "$keymaster" "$@"
+`))
+
+var bootstrapPowerShellTemplate = template.Must(template.New(
+	bootstrapPowerShellName).Funcs(bootstrapTemplateFuncs).Parse(
+	`# Installs the keymaster client for {{.URL}} and gets the first
+# certificates. Generated by keymasterd from its configuration.
+$ErrorActionPreference = 'Stop'
+$url = {{ps .URL}}
+$arch = if ($env:PROCESSOR_ARCHITECTURE -eq 'ARM64') { 'arm64' } elseif ([Environment]::Is64BitOperatingSystem) { 'amd64' } else { '386' }
+$hashes = @{
+{{- range .Binaries}}{{if eq .OS "windows"}}
+	{{ps .Arch}} = {{ps .SHA256}}
+{{- end}}{{end}}
+}
+$dir = Join-Path $env:LOCALAPPDATA 'keymaster'
+$exe = Join-Path $dir 'keymaster.exe'
+if ($hashes.ContainsKey($arch)) {
+	New-Item -ItemType Directory -Force -Path $dir | Out-Null
+	$tmp = "$exe.download"
+	Invoke-WebRequest -UseBasicParsing -Uri "$url/public/client/keymaster-windows-$arch.exe" -OutFile $tmp
+	if ((Get-FileHash -Algorithm SHA256 $tmp).Hash.ToLower() -ne $hashes[$arch]) {
+		Remove-Item $tmp
+		throw "Checksum mismatch for keymaster-windows-$arch.exe"
+	}
+	Move-Item -Force $tmp $exe
+	Write-Host "Installed $exe"
+} elseif (Get-Command keymaster -ErrorAction SilentlyContinue) {
+	$exe = (Get-Command keymaster).Source
+} else {
+	throw "No keymaster client for windows-$arch is available from $url"
+}
+$config = Join-Path $HOME '.keymaster\client_config.yml'
+if (Test-Path $config) {
+	Write-Host "Keeping existing $config"
+} else {
+	New-Item -ItemType Directory -Force -Path (Split-Path $config) | Out-Null
+	[IO.File]::WriteAllText($config, {{ps .ClientConfig}})
+	Write-Host "Wrote $config"
+}
+& $exe @args
+`))
+
+type bootstrapTemplateData struct {
+	URL          string
+	ClientConfig string
+	Binaries     []clientBinary
+}
+
+// bootstrapClientConfig returns the client configuration written by the
+// bootstrap scripts. The CA keys are pinned to the DNS fingerprint records
+// if they are published.
+func (state *RuntimeState) bootstrapClientConfig() (string, error) {
+	base := map[string]interface{}{"gen_cert_urls": state.u2fAppID}
+	dnsConfig := state.Config.DNSPublication
+	if dnsConfig.Backend != "" && dnsConfig.FingerprintName != "" {
+		base["ca_fingerprint_dns_name"] = dnsConfig.FingerprintName
+	}
+	data, err := yaml.Marshal(map[string]interface{}{"base": base})
+	return string(data), err
+}
+
+func (state *RuntimeState) writeBootstrapScript(w http.ResponseWriter,
+	r *http.Request, tmpl *template.Template) {
+	clientConfig, err := state.bootstrapClientConfig()
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	binaries, err := state.clientBinaries.list()
+	if err != nil {
+		requestLogger(r).Errorf("Cannot list client binaries: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, bootstrapTemplateData{
+		URL:          state.idpGetIssuer(),
+		ClientConfig: clientConfig,
+		Binaries:     binaries,
+	})
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buffer.Bytes())
+}
+
+// clientBinaryHandler serves the client binaries installed by the bootstrap
+// scripts.
+func (state *RuntimeState) clientBinaryHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, clientBinariesPath)
+	if state.clientBinaries == nil || !clientBinaryNameRegexp.MatchString(name) {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	file, err := os.Open(filepath.Join(state.clientBinaries.directory, name))
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil || !fileInfo.Mode().IsRegular() {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, name, fileInfo.ModTime(), file)
+}
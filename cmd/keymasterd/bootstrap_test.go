@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/client/config"
+)
+
+// A client binary which reports how it was run.
+const testClientBinary = "#!/bin/sh\necho \"client ran with $*\"\n"
+
+func TestBootstrapScripts(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	binariesDir := filepath.Join(dir, "binaries")
+	if err := os.Mkdir(binariesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	arch := runtime.GOARCH
+	for _, name := range []string{"keymaster-linux-" + arch,
+		"keymaster-windows-amd64.exe", "keymaster-windows-amd64",
+		"README"} {
+		err := ioutil.WriteFile(filepath.Join(binariesDir, name),
+			[]byte(testClientBinary), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	state.clientBinaries = newClientBinaryIndex(binariesDir)
+	state.u2fAppID = "https://keymaster.example.com"
+	state.Config.DNSPublication.Backend = "etcd"
+	state.Config.DNSPublication.FingerprintName = "_keymaster-ca.example.com"
+	sum := sha256.Sum256([]byte(testClientBinary))
+	hash := hex.EncodeToString(sum[:])
+
+	get := func(path string, handler http.HandlerFunc,
+		expectedStatus int) string {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := checkRequestHandlerCode(req, handler, expectedStatus)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		return rr.Body.String()
+	}
+	powerShell := get(publicPath+bootstrapPowerShellName,
+		state.publicPathHandler, http.StatusOK)
+	if !strings.Contains(powerShell, "'amd64' = '"+hash+"'") {
+		t.Errorf("Windows binary missing:\n%s", powerShell)
+	}
+	get(clientBinariesPath+"keymaster-linux-"+arch, state.clientBinaryHandler,
+		http.StatusOK)
+	get(clientBinariesPath+"README", state.clientBinaryHandler,
+		http.StatusNotFound)
+	get(clientBinariesPath+"../binaries/README", state.clientBinaryHandler,
+		http.StatusNotFound)
+
+	script := get(publicPath+bootstrapShellName, state.publicPathHandler,
+		http.StatusOK)
+	if !strings.Contains(script, "sha256="+hash) ||
+		strings.Contains(script, "windows") {
+		t.Fatalf("unexpected script:\n%s", script)
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("the script installs a Linux client here")
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+	// Run the script with a curl which copies the binary.
+	stubDir := filepath.Join(dir, "stubs")
+	if err := os.Mkdir(stubDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	curl := "#!/bin/sh\nwhile [ $# -gt 1 ]; do\n" +
+		"\tif [ \"$1\" = -o ]; then out=$2; fi\n\tshift\ndone\n" +
+		"cp " + shellQuote(binariesDir) + "/\"${1##*/}\" \"$out\"\n"
+	err = ioutil.WriteFile(filepath.Join(stubDir, "curl"), []byte(curl), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	homeDir := filepath.Join(dir, "home")
+	cmd := exec.Command(sh, "-s", "--", "-checkDevices")
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Env = []string{"HOME=" + homeDir,
+		"PATH=" + stubDir + ":" + os.Getenv("PATH")}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s", err, output)
+	}
+	if !strings.Contains(string(output), "client ran with -checkDevices") {
+		t.Errorf("client not run:\n%s", output)
+	}
+	clientConfig, err := config.LoadVerifyConfigFile(filepath.Join(homeDir,
+		".keymaster", "client_config.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientConfig.Base.Gen_Cert_URLS != state.u2fAppID ||
+		clientConfig.Base.CAFingerprintDNSName != "_keymaster-ca.example.com" {
+		t.Errorf("unexpected client config: %+v", clientConfig)
+	}
+}
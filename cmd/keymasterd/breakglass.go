@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/tstranex/u2f"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const breakGlassPath = "/api/v0/breakGlass/"
+const adminSocketBreakGlassPath = "/breakGlass"
+
+const breakGlassUsedCodesFilename = "break_glass_used_codes"
+
+const defaultBreakGlassMaxDurationSecs = 3600
+
+// maxBreakGlassChallenges bounds the pending U2F challenges, which anyone
+// can request while the mode is active.
+const maxBreakGlassChallenges = 1000
+
+// breakGlassDeniedAlertInterval is the least time between notifications of
+// denied attempts, which need no credentials.
+const breakGlassDeniedAlertInterval = time.Minute
+
+// breakGlassAuthMethod names break-glass authentication in the audit trail.
+const breakGlassAuthMethod = "BreakGlass"
+
+// BreakGlassConfig allows pre-enrolled users to get short lived SSH
+// certificates with a hardware token or a recovery code while the password
+// backend is down.
+type BreakGlassConfig struct {
+	Users []string `yaml:"users"`
+	// Lines of username:bcrypt hash, one per single use recovery code.
+	RecoveryCodesFilename string `yaml:"recovery_codes_filename"`
+	// Default: 1 hour.
+	MaxDurationSecs uint `yaml:"max_duration_secs"`
+	// Enable the mode once the LDAP password backend has failed its
+	// dependency checks for this long. Default: only when enabled on the
+	// admin socket.
+	AutomaticAfterSecs uint `yaml:"automatic_after_secs"`
+}
+
+type breakGlassAlert struct {
+	Type     string    `json:"type"`
+	Event    string    `json:"event"`
+	Username string    `json:"username,omitempty"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+type breakGlassChallenge struct {
+	username  string
+	challenge *u2f.Challenge
+	expiresAt time.Time
+}
+
+// breakGlassSignRequest is the U2F sign request of a challenge, with the
+// nonce to send back with the sign response.
+type breakGlassSignRequest struct {
+	Nonce string `json:"nonce"`
+	*u2f.WebSignRequest
+}
+
+// breakGlass is the state of the break-glass mode.
+type breakGlass struct {
+	config            BreakGlassConfig
+	usedCodesFilename string
+	started           time.Time
+	mutex             sync.Mutex
+	codes             map[string][]string // Hashes by username.
+	used              map[string]struct{}
+	enabledAt         time.Time // Zero unless enabled on the admin socket.
+	enabledReason     string
+	active            bool                           // As of the last update, to alert on changes.
+	challenges        map[string]breakGlassChallenge // By nonce.
+	// Last U2F counters by username and key handle, in case the profile
+	// could not be saved.
+	u2fCounters       map[string]uint32
+	lastDeniedAlert   time.Time
+	suppressedDenials uint
+}
+
+func (config *BreakGlassConfig) maxDuration() time.Duration {
+	if config.MaxDurationSecs < 1 {
+		return defaultBreakGlassMaxDurationSecs * time.Second
+	}
+	return time.Duration(config.MaxDurationSecs) * time.Second
+}
+
+// parseRecoveryCodes returns the hashes in data by username.
+func parseRecoveryCodes(data []byte, users []string) (map[string][]string,
+	error) {
+	codes := make(map[string][]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: not username:hash", lineNumber)
+		}
+		if _, err := bcrypt.Cost([]byte(fields[1])); err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+		if !stringsIntersect([]string{fields[0]}, users) {
+			return nil, fmt.Errorf("line %d: %s is not a break-glass user",
+				lineNumber, fields[0])
+		}
+		codes[fields[0]] = append(codes[fields[0]], fields[1])
+	}
+	return codes, scanner.Err()
+}
+
+// newBreakGlass reads the recovery codes and those used before. It returns
+// nil if no users are enrolled.
+func newBreakGlass(config BreakGlassConfig, dataDirectory string) (
+	*breakGlass, error) {
+	if len(config.Users) < 1 {
+		if config.RecoveryCodesFilename != "" {
+			return nil, errors.New("break_glass: recovery codes but no users")
+		}
+		return nil, nil
+	}
+	state := &breakGlass{
+		config: config,
+		usedCodesFilename: filepath.Join(dataDirectory,
+			breakGlassUsedCodesFilename),
+		started:     time.Now(),
+		used:        make(map[string]struct{}),
+		challenges:  make(map[string]breakGlassChallenge),
+		u2fCounters: make(map[string]uint32),
+	}
+	if config.RecoveryCodesFilename != "" {
+		data, err := ioutil.ReadFile(config.RecoveryCodesFilename)
+		if err != nil {
+			return nil, fmt.Errorf("break_glass: %s", err)
+		}
+		state.codes, err = parseRecoveryCodes(data, config.Users)
+		if err != nil {
+			return nil, fmt.Errorf("break_glass: %s: %s",
+				config.RecoveryCodesFilename, err)
+		}
+	}
+	data, err := ioutil.ReadFile(state.usedCodesFilename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, hash := range strings.Fields(string(data)) {
+		state.used[hash] = struct{}{}
+	}
+	return state, nil
+}
+
+// status returns whether the mode is active and why. lastPasswordCheck is
+// the time of the last successful check of the password backend, zero if
+// it is not monitored.
+func (state *breakGlass) status(now time.Time, monitored bool,
+	lastPasswordCheck time.Time) (bool, string) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if !state.enabledAt.IsZero() {
+		return true, fmt.Sprintf("enabled on the admin socket at %s (%s)",
+			state.enabledAt.Format(time.RFC3339), state.enabledReason)
+	}
+	if state.config.AutomaticAfterSecs < 1 || !monitored {
+		return false, ""
+	}
+	since := lastPasswordCheck
+	if since.IsZero() {
+		since = state.started
+	}
+	after := time.Duration(state.config.AutomaticAfterSecs) * time.Second
+	if now.Sub(since) < after {
+		return false, ""
+	}
+	return true, "password backend unavailable since " +
+		since.Format(time.RFC3339)
+}
+
+// useRecoveryCode returns true if code is an unused recovery code of
+// username and marks it as used.
+func (state *breakGlass) useRecoveryCode(username, code string) (bool,
+	error) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	for _, hash := range state.codes[username] {
+		if _, ok := state.used[hash]; ok {
+			continue
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) != nil {
+			continue
+		}
+		file, err := os.OpenFile(state.usedCodesFilename,
+			os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return false, err
+		}
+		_, err = fmt.Fprintln(file, hash)
+		if err == nil {
+			err = file.Sync()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return false, err
+		}
+		state.used[hash] = struct{}{}
+		return true, nil
+	}
+	return false, nil
+}
+
+// remainingCodes returns the number of unused recovery codes by user.
+func (state *breakGlass) remainingCodes() map[string]int {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	remaining := make(map[string]int, len(state.config.Users))
+	for _, username := range state.config.Users {
+		remaining[username] = 0
+		for _, hash := range state.codes[username] {
+			if _, ok := state.used[hash]; !ok {
+				remaining[username]++
+			}
+		}
+	}
+	return remaining
+}
+
+// addChallenge records challenge for username and returns its nonce.
+func (state *breakGlass) addChallenge(username string,
+	challenge *u2f.Challenge, now time.Time) (string, error) {
+	nonce, err := genRandomString()
+	if err != nil {
+		return "", err
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	for nonce, pending := range state.challenges {
+		if now.After(pending.expiresAt) {
+			delete(state.challenges, nonce)
+		}
+	}
+	if len(state.challenges) >= maxBreakGlassChallenges {
+		return "", errors.New("too many pending break-glass challenges")
+	}
+	state.challenges[nonce] = breakGlassChallenge{
+		username:  username,
+		challenge: challenge,
+		expiresAt: now.Add(maxAgeU2FVerifySeconds * time.Second),
+	}
+	return nonce, nil
+}
+
+// takeChallenge returns the challenge with the given nonce if it was given
+// to username and has not expired. A challenge can only be taken once.
+func (state *breakGlass) takeChallenge(nonce, username string,
+	now time.Time) *u2f.Challenge {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	pending, ok := state.challenges[nonce]
+	if !ok || pending.username != username {
+		return nil
+	}
+	delete(state.challenges, nonce)
+	if now.After(pending.expiresAt) {
+		return nil
+	}
+	return pending.challenge
+}
+
+// u2fCounter returns the highest of counter and the last counter seen for
+// the key of username.
+func (state *breakGlass) u2fCounter(username string, keyHandle []byte,
+	counter uint32) uint32 {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if last := state.u2fCounters[username+"/"+string(keyHandle)]; last >
+		counter {
+		return last
+	}
+	return counter
+}
+
+func (state *breakGlass) setU2FCounter(username string, keyHandle []byte,
+	counter uint32) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	state.u2fCounters[username+"/"+string(keyHandle)] = counter
+}
+
+// throttleDeniedAlert returns whether to notify of a denied attempt and,
+// if so, how many were not notified since the last notification.
+func (state *breakGlass) throttleDeniedAlert(now time.Time) (bool, uint) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if now.Sub(state.lastDeniedAlert) < breakGlassDeniedAlertInterval {
+		state.suppressedDenials++
+		return false, 0
+	}
+	suppressed := state.suppressedDenials
+	state.lastDeniedAlert = now
+	state.suppressedDenials = 0
+	return true, suppressed
+}
+
+func metricLogBreakGlass(event string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	breakGlassCounter.WithLabelValues(event).Inc()
+}
+
+// alertBreakGlass reports a break-glass event as loudly as possible: in the
+// log, the metrics and to the notification webhooks.
+func (state *RuntimeState) alertBreakGlass(event, username, message string) {
+	logger.Printf("BREAK-GLASS %s: %s", strings.ToUpper(event), message)
+	metricLogBreakGlass(event)
+	payload, err := json.Marshal(breakGlassAlert{
+		Type:     "break_glass",
+		Event:    event,
+		Username: username,
+		Message:  message,
+		Time:     time.Now().UTC(),
+	})
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	state.enqueueNotification(payload)
+}
+
+// alertBreakGlassDenied reports a denied attempt like alertBreakGlass, but
+// notifies the webhooks at most once per breakGlassDeniedAlertInterval with
+// the number of attempts which were only logged.
+func (state *RuntimeState) alertBreakGlassDenied(username, message string) {
+	notify, suppressed := state.breakGlass.throttleDeniedAlert(time.Now())
+	if !notify {
+		logger.Printf("BREAK-GLASS DENIED: %s", message)
+		metricLogBreakGlass("denied")
+		return
+	}
+	if suppressed > 0 {
+		message += fmt.Sprintf(
+			" (%d more denied attempts since the last notification)",
+			suppressed)
+	}
+	state.alertBreakGlass("denied", username, message)
+}
+
+// breakGlassStatus returns whether break-glass issuance is possible and why.
+func (state *RuntimeState) breakGlassStatus() (bool, string) {
+	if state.breakGlass == nil {
+		return false, ""
+	}
+	return state.breakGlass.status(time.Now(),
+		state.Config.Ldap.LDAPTargetURLs != "", lastSuccessLDAPPasswordTime)
+}
+
+// updateBreakGlass alerts when the mode becomes active or inactive. It is
+// called after each dependency check.
+func (state *RuntimeState) updateBreakGlass() {
+	if state.breakGlass == nil {
+		return
+	}
+	active, reason := state.breakGlassStatus()
+	state.breakGlass.mutex.Lock()
+	changed := active != state.breakGlass.active
+	state.breakGlass.active = active
+	state.breakGlass.mutex.Unlock()
+	if !changed {
+		return
+	}
+	if active {
+		state.alertBreakGlass("activated", "",
+			"break-glass issuance is active: "+reason)
+	} else {
+		state.alertBreakGlass("deactivated", "",
+			"break-glass issuance is no longer active")
+	}
+}
+
+// breakGlassU2FSignRequest starts authentication with the U2F tokens
+// registered by username, which may be read from the profile cache.
+func (state *RuntimeState) breakGlassU2FSignRequest(w http.ResponseWriter,
+	r *http.Request, username string) {
+	profile, ok, _, err := state.LoadUserProfileContext(r.Context(), username)
+	if err != nil {
+		requestLogger(r).Errorf("loading profile error: %v", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	var registrations []u2f.Registration
+	if ok {
+		registrations = getRegistrationArray(profile.U2fAuthData)
+	}
+	if len(registrations) < 1 {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"No U2F tokens registered")
+		return
+	}
+	challenge, err := u2f.NewChallenge(state.u2fAppID, state.u2fTrustedFacets)
+	if err != nil {
+		requestLogger(r).Errorf("u2f.NewChallenge error: %v", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	// Kept apart from the challenges of logged in users, which cannot be
+	// replaced without authenticating.
+	nonce, err := state.breakGlass.addChallenge(username, challenge,
+		time.Now())
+	if err != nil {
+		requestLogger(r).Printf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusServiceUnavailable, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(breakGlassSignRequest{
+		Nonce:          nonce,
+		WebSignRequest: challenge.SignRequest(registrations),
+	})
+}
+
+// checkBreakGlassU2F verifies the U2F sign response in JSON against the
+// challenge with the given nonce, which can only be used once, and records
+// the new counter of the token so that a cloned token is rejected.
+func (state *RuntimeState) checkBreakGlassU2F(username, nonce string,
+	response string) (bool, error) {
+	var signResp u2f.SignResponse
+	if err := json.Unmarshal([]byte(response), &signResp); err != nil {
+		return false, nil
+	}
+	challenge := state.breakGlass.takeChallenge(nonce, username, time.Now())
+	if challenge == nil {
+		return false, nil
+	}
+	profile, ok, _, err := state.LoadUserProfile(username)
+	if err != nil || !ok {
+		return false, err
+	}
+	for index, u2fReg := range profile.U2fAuthData {
+		keyHandle := u2fReg.Registration.KeyHandle
+		newCounter, err := u2fReg.Registration.Authenticate(signResp,
+			*challenge, state.breakGlass.u2fCounter(username, keyHandle,
+				u2fReg.Counter))
+		if err != nil {
+			continue
+		}
+		state.breakGlass.setU2FCounter(username, keyHandle, newCounter)
+		u2fReg.Counter = newCounter
+		profile.U2fAuthData[index] = u2fReg
+		// The counter is still checked against the one in memory if the
+		// profile storage is down too.
+		if err := state.SaveUserProfile(username, profile); err != nil {
+			logger.Printf("Cannot save U2F counter of %s: %s", username, err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// checkBreakGlassRecoveryCode uses up code if it is a recovery code of
+// username. Failures are throttled like passwords.
+func (state *RuntimeState) checkBreakGlassRecoveryCode(r *http.Request,
+	username, code string) (bool, error) {
+	throttle := state.loginThrottle
+	address := loginThrottleAddress(r)
+	if throttle != nil {
+		if delay := throttle.Delay(username, address); delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return false, r.Context().Err()
+			}
+		}
+	}
+	ok, err := state.breakGlass.useRecoveryCode(username, code)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		if throttle != nil {
+			throttle.RecordFailure(username, address, []byte(code))
+		}
+		state.recordAuthFailure(r, username, breakGlassAuthMethod)
+	}
+	return ok, nil
+}
+
+// breakGlassCertificate issues an SSH certificate to username, who proved
+// their identity with a recovery code or a U2F token.
+func (state *RuntimeState) breakGlassCertificate(w http.ResponseWriter,
+	r *http.Request, username string) {
+	// Checked first, recovery codes are used up by authenticating.
+	maxDuration := state.Config.BreakGlass.maxDuration()
+	if value := r.Form.Get("duration"); value == "" {
+		r.Form.Set("duration", maxDuration.String())
+	} else if duration, err := time.ParseDuration(value); err != nil ||
+		duration > maxDuration {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, fmt.Sprintf(
+			"Break-glass certificates are valid for at most %s", maxDuration))
+		return
+	}
+	authLevel := AuthTypeBreakGlass
+	var ok bool
+	var err error
+	if code := r.Form.Get("recovery_code"); code != "" {
+		ok, err = state.checkBreakGlassRecoveryCode(r, username, code)
+	} else if response := r.Form.Get("u2f_response"); response != "" {
+		ok, err = state.checkBreakGlassU2F(username, r.Form.Get("nonce"),
+			response)
+		authLevel |= AuthTypeU2F
+	} else {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Missing recovery_code or u2f_response")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	method := strings.Join(authLevelToMethods(authLevel), "+")
+	if !ok {
+		state.alertBreakGlassDenied(username, fmt.Sprintf(
+			"failed %s authentication of %s from %s", method, username,
+			loginThrottleAddress(r)))
+		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+		return
+	}
+	r.Form.Set("type", "ssh")
+	state.Mutex.Lock()
+	keySigner := state.Signer
+	state.Mutex.Unlock()
+	if keySigner == nil {
+		requestLogger(r).Printf("Signer not loaded")
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	state.alertBreakGlass("authenticated", username, fmt.Sprintf(
+		"%s authenticated with %s from %s, issuing an SSH certificate for %s",
+		username, method, loginThrottleAddress(r), r.Form.Get("duration")))
+	state.certGenFromParsedForm(w, r, username, authLevel, keySigner)
+}
+
+// breakGlassHandler serves emergency issuance to the break-glass users on
+// the service port while the mode is active:
+//
+//	POST /api/v0/breakGlass/u2fSignRequest  username
+//	POST /api/v0/breakGlass/certificate     username, recovery_code or
+//	                                        u2f_response and nonce,
+//	                                        pubkeyfile and optionally
+//	                                        duration
+//
+// No password or session is needed.
+func (state *RuntimeState) breakGlassHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	operation := strings.TrimPrefix(r.URL.Path, breakGlassPath)
+	if operation != "u2fSignRequest" && operation != "certificate" {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if active, _ := state.breakGlassStatus(); !active {
+		state.writeFailureResponse(w, r, http.StatusServiceUnavailable,
+			"Break-glass issuance is not active")
+		return
+	}
+	r, err := parseUploadForm(r)
+	if err != nil && err != http.ErrNotMultipart {
+		state.writeUploadFormError(w, r, err)
+		return
+	}
+	username := r.Form.Get("username")
+	if !state.Config.Base.DisableUsernameNormalization {
+		username = strings.ToLower(username)
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(username)
+	if !stringsIntersect([]string{username},
+		state.Config.BreakGlass.Users) {
+		state.alertBreakGlassDenied(username, fmt.Sprintf(
+			"%q from %s is not a break-glass user", username,
+			loginThrottleAddress(r)))
+		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+		return
+	}
+	if operation == "u2fSignRequest" {
+		state.breakGlassU2FSignRequest(w, r, username)
+		return
+	}
+	state.breakGlassCertificate(w, r, username)
+}
+
+// adminBreakGlassHandler is served on the admin socket. A GET shows the
+// status and the remaining recovery codes, a POST with an action of
+// "enable" and a reason or "disable" switches the mode on or off.
+func (state *RuntimeState) adminBreakGlassHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.breakGlass == nil {
+		state.writeFailureResponse(w, r, http.StatusNotFound,
+			"No break-glass users configured")
+		return
+	}
+	switch r.Method {
+	case "GET":
+	case "POST":
+		switch r.FormValue("action") {
+		case "enable":
+			reason := r.FormValue("reason")
+			if reason == "" {
+				state.writeFailureResponse(w, r, http.StatusBadRequest,
+					"Missing reason")
+				return
+			}
+			state.breakGlass.mutex.Lock()
+			state.breakGlass.enabledAt = time.Now()
+			state.breakGlass.enabledReason = reason
+			state.breakGlass.mutex.Unlock()
+		case "disable":
+			state.breakGlass.mutex.Lock()
+			state.breakGlass.enabledAt = time.Time{}
+			state.breakGlass.enabledReason = ""
+			state.breakGlass.mutex.Unlock()
+		default:
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Invalid action")
+			return
+		}
+		state.updateBreakGlass()
+	default:
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if active, reason := state.breakGlassStatus(); active {
+		fmt.Fprintf(w, "Break-glass issuance is active: %s\n", reason)
+	} else {
+		fmt.Fprintf(w, "Break-glass issuance is not active\n")
+	}
+	remaining := state.breakGlass.remainingCodes()
+	usernames := make([]string, 0, len(remaining))
+	for username := range remaining {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	for _, username := range usernames {
+		fmt.Fprintf(w, "%s: %d recovery codes left\n", username,
+			remaining[username])
+	}
+}
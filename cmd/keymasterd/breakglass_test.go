@@ -0,0 +1,265 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+	"github.com/tstranex/u2f"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+func testRecoveryCodes(t *testing.T, username string,
+	codes ...string) string {
+	var lines []string
+	for _, code := range codes {
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, username+":"+string(hash))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestParseRecoveryCodes(t *testing.T) {
+	data := "# break-glass codes\n" + testRecoveryCodes(t, "alice", "a", "b")
+	codes, err := parseRecoveryCodes([]byte(data), []string{"alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes["alice"]) != 2 {
+		t.Fatalf("unexpected codes: %v", codes)
+	}
+	for _, data := range []string{"alice\n", "alice:plain\n",
+		testRecoveryCodes(t, "bob", "c")} {
+		if _, err := parseRecoveryCodes([]byte(data),
+			[]string{"alice"}); err == nil {
+			t.Errorf("%q accepted", data)
+		}
+	}
+}
+
+func TestBreakGlassStatus(t *testing.T) {
+	state := &breakGlass{
+		config:  BreakGlassConfig{AutomaticAfterSecs: 300},
+		started: time.Now().Add(-time.Minute),
+	}
+	now := time.Now()
+	if active, _ := state.status(now, true, time.Time{}); active {
+		t.Error("active a minute after startup")
+	}
+	if active, _ := state.status(now.Add(5*time.Minute), true,
+		time.Time{}); !active {
+		t.Error("not active with no successful check since startup")
+	}
+	if active, _ := state.status(now, true,
+		now.Add(-10*time.Minute)); !active {
+		t.Error("not active 10 minutes after the last successful check")
+	}
+	if active, _ := state.status(now, false,
+		now.Add(-10*time.Minute)); active {
+		t.Error("active without monitoring")
+	}
+}
+
+func TestBreakGlass(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "breakglass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.attestationLog, err = attestation.Open(filepath.Join(dir,
+		attestationLogFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codesFilename := filepath.Join(dir, "recovery_codes")
+	err = ioutil.WriteFile(codesFilename,
+		[]byte(testRecoveryCodes(t, "username", "first", "second")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.BreakGlass = BreakGlassConfig{
+		Users:                 []string{"username"},
+		RecoveryCodesFilename: codesFilename,
+		MaxDurationSecs:       1800,
+	}
+	state.breakGlass, err = newBreakGlass(state.Config.BreakGlass, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestCert := func(username, code, duration string,
+		expectedStatus int) string {
+		query := url.Values{"username": {username}, "recovery_code": {code}}
+		req, err := createKeyBodyRequest("POST",
+			breakGlassPath+"certificate?"+query.Encode(),
+			testUserSSHPublicKey, duration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := checkRequestHandlerCode(req, state.breakGlassHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatalf("%s with %q: %s", username, code, err)
+		}
+		return rr.Body.String()
+	}
+	admin := func(method string, values url.Values) string {
+		req, err := http.NewRequest(method, adminSocketBreakGlassPath,
+			strings.NewReader(values.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, err := checkRequestHandlerCode(req, state.adminBreakGlassHandler,
+			http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr.Body.String()
+	}
+
+	requestCert("username", "first", "30m", http.StatusServiceUnavailable)
+	status := admin("POST", url.Values{"action": {"enable"},
+		"reason": {"LDAP outage"}})
+	if !strings.Contains(status, "is active: enabled on the admin socket") ||
+		!strings.Contains(status, "username: 2 recovery codes left") {
+		t.Fatalf("unexpected status: %s", status)
+	}
+	requestCert("other", "first", "30m", http.StatusUnauthorized)
+	requestCert("username", "wrong", "30m", http.StatusUnauthorized)
+	requestCert("username", "first", "2h", http.StatusBadRequest)
+	certText := requestCert("username", "first", "30m", http.StatusOK)
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := pubKey.(*ssh.Certificate)
+	if lifetime := time.Duration(cert.ValidBefore-cert.ValidAfter) *
+		time.Second; lifetime > 35*time.Minute {
+		t.Errorf("certificate valid for %s", lifetime)
+	}
+	requestCert("username", "first", "30m", http.StatusUnauthorized)
+	events, err := state.attestationLog.Events(time.Time{},
+		time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || len(events[0].AuthMethods) != 1 ||
+		events[0].AuthMethods[0] != breakGlassAuthMethod ||
+		events[0].SecondFactor {
+		t.Errorf("unexpected events: %+v", events)
+	}
+
+	// Used codes are remembered across restarts.
+	state.breakGlass, err = newBreakGlass(state.Config.BreakGlass, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := admin("GET", nil); !strings.Contains(status,
+		"is not active") || !strings.Contains(status,
+		"username: 1 recovery codes left") {
+		t.Fatalf("unexpected status after restart: %s", status)
+	}
+	admin("POST", url.Values{"action": {"enable"}, "reason": {"drill"}})
+	requestCert("username", "second", "10m", http.StatusOK)
+	admin("POST", url.Values{"action": {"disable"}})
+	requestCert("username", "second", "30m", http.StatusServiceUnavailable)
+}
+
+func TestAdminSocketBreakGlassRequest(t *testing.T) {
+	method, path, values, err := adminSocketRequest([]string{"break-glass",
+		"enable", "LDAP outage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != "POST" || path != adminSocketBreakGlassPath ||
+		values.Get("reason") != "LDAP outage" {
+		t.Errorf("unexpected request: %s %s %v", method, path, values)
+	}
+	for _, args := range [][]string{{"break-glass"},
+		{"break-glass", "enable"}, {"break-glass", "smash"}} {
+		if _, _, _, err := adminSocketRequest(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
+
+func TestBreakGlassChallenges(t *testing.T) {
+	state := &breakGlass{challenges: make(map[string]breakGlassChallenge),
+		u2fCounters: make(map[string]uint32)}
+	now := time.Now()
+	challenge := &u2f.Challenge{}
+	nonce, err := state.addChallenge("alice", challenge, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second challenge does not replace the first one.
+	if _, err := state.addChallenge("alice", &u2f.Challenge{}, now); err != nil {
+		t.Fatal(err)
+	}
+	if state.takeChallenge(nonce, "bob", now) != nil {
+		t.Error("challenge of alice given to bob")
+	}
+	if state.takeChallenge(nonce, "alice", now) != challenge {
+		t.Error("challenge not found")
+	}
+	if state.takeChallenge(nonce, "alice", now) != nil {
+		t.Error("challenge taken twice")
+	}
+	nonce, err = state.addChallenge("alice", challenge, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.takeChallenge(nonce, "alice",
+		now.Add(maxAgeU2FVerifySeconds*time.Second+time.Second)) != nil {
+		t.Error("expired challenge taken")
+	}
+	for len(state.challenges) < maxBreakGlassChallenges {
+		if _, err := state.addChallenge("alice", challenge, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := state.addChallenge("alice", challenge, now); err == nil {
+		t.Error("too many challenges accepted")
+	}
+	state.setU2FCounter("alice", []byte("key"), 7)
+	if counter := state.u2fCounter("alice", []byte("key"), 3); counter != 7 {
+		t.Errorf("got counter %d", counter)
+	}
+	if counter := state.u2fCounter("alice", []byte("key"), 9); counter != 9 {
+		t.Errorf("got counter %d", counter)
+	}
+}
+
+func TestBreakGlassDeniedAlertThrottle(t *testing.T) {
+	var state breakGlass
+	now := time.Now()
+	if notify, _ := state.throttleDeniedAlert(now); !notify {
+		t.Fatal("first denial not notified")
+	}
+	for i := 0; i < 3; i++ {
+		if notify, _ := state.throttleDeniedAlert(now.Add(
+			time.Second)); notify {
+			t.Fatal("denial notified within the interval")
+		}
+	}
+	notify, suppressed := state.throttleDeniedAlert(
+		now.Add(breakGlassDeniedAlertInterval))
+	if !notify || suppressed != 3 {
+		t.Errorf("got %v, %d", notify, suppressed)
+	}
+}
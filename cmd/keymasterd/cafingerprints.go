@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/cafingerprint"
+)
+
+// The fingerprints of the CA keys are published under publicPath in the
+// format of the TXT records published with dns_publication, so that the
+// records can be checked or created by hand.
+const (
+	caFingerprintsName              = "ca_fingerprints"
+	caFingerprintsRequestsPerMinute = 30
+)
+
+// addressRateLimiter limits the requests per client address in fixed
+// windows, aligned on multiples of the window so that the instances of a
+// cluster share them. A nil *addressRateLimiter allows all requests.
+type addressRateLimiter struct {
+	limit       int
+	window      time.Duration
+	mutex       sync.Mutex
+	windowStart time.Time      // Protected by mutex.
+	counts      map[string]int // Protected by mutex.
+	peerCounts  map[string]int // Protected by mutex.
+}
+
+// addressRateLimitCounts are the requests counted by an addressRateLimiter
+// in the window starting at WindowStart.
+type addressRateLimitCounts struct {
+	WindowStart time.Time
+	Counts      map[string]int
+}
+
+func newAddressRateLimiter(limit int,
+	window time.Duration) *addressRateLimiter {
+	return &addressRateLimiter{
+		limit:  limit,
+		window: window,
+		counts: make(map[string]int),
+	}
+}
+
+// allow counts a request from address and returns zero if it is allowed or
+// else how long to wait before the next window.
+func (limiter *addressRateLimiter) allow(address string,
+	now time.Time) time.Duration {
+	if limiter == nil {
+		return 0
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.startWindow(now)
+	if limiter.counts[address]+limiter.peerCounts[address] >= limiter.limit {
+		return limiter.windowStart.Add(limiter.window).Sub(now)
+	}
+	limiter.counts[address]++
+	return 0
+}
+
+// startWindow resets the counts if now is in a new window. The mutex must
+// be held.
+func (limiter *addressRateLimiter) startWindow(now time.Time) {
+	if windowStart := now.Truncate(limiter.window); !windowStart.Equal(
+		limiter.windowStart) {
+		limiter.windowStart = windowStart
+		limiter.counts = make(map[string]int)
+		limiter.peerCounts = make(map[string]int)
+	}
+}
+
+// export returns the counts of the current window, for the other instances
+// of a cluster.
+func (limiter *addressRateLimiter) export(
+	now time.Time) addressRateLimitCounts {
+	if limiter == nil {
+		return addressRateLimitCounts{}
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.startWindow(now)
+	counts := addressRateLimitCounts{WindowStart: limiter.windowStart,
+		Counts: make(map[string]int, len(limiter.counts))}
+	for address, count := range limiter.counts {
+		counts.Counts[address] = count
+	}
+	return counts
+}
+
+// setPeers replaces the counts of the other instances of a cluster, which
+// add to those of this one. Counts of other windows are ignored.
+func (limiter *addressRateLimiter) setPeers(peers []addressRateLimitCounts,
+	now time.Time) {
+	if limiter == nil {
+		return
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.startWindow(now)
+	limiter.peerCounts = make(map[string]int)
+	for _, peer := range peers {
+		if !peer.WindowStart.Equal(limiter.windowStart) {
+			continue
+		}
+		for address, count := range peer.Counts {
+			limiter.peerCounts[address] += count
+		}
+	}
+}
+
+// caFingerprintRecords returns the records of the trusted SSH CA keys and
+// of the X.509 CA.
+func (state *RuntimeState) caFingerprintRecords() ([]string, error) {
+	keys, err := state.caSSHPublicKeys()
+	if err != nil {
+		return nil, err
+	}
+	var records []string
+	for _, key := range keys {
+		record, err := cafingerprint.SSHRecord(key)
+		if err != nil {
+			logger.Debugf(1, "Not publishing fingerprint of CA key: %s", err)
+			continue
+		}
+		records = append(records, record)
+	}
+	state.Mutex.Lock()
+	caCertDer := state.caCertDer
+	state.Mutex.Unlock()
+	if len(caCertDer) > 0 {
+		caCert, err := x509.ParseCertificate(caCertDer)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, cafingerprint.X509Record(caCert))
+	}
+	return records, nil
+}
+
+// writeCAFingerprints writes the fingerprint records, one per line. The
+// endpoint needs no authentication, so requests are rate limited per
+// client address.
+func (state *RuntimeState) writeCAFingerprints(w http.ResponseWriter,
+	r *http.Request) {
+	if wait := state.caFingerprintsLimiter.allow(loginThrottleAddress(r),
+		time.Now()); wait > 0 {
+		seconds := int(wait/time.Second) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		state.writeFailureResponse(w, r, http.StatusTooManyRequests, "")
+		return
+	}
+	records, err := state.caFingerprintRecords()
+	if err != nil {
+		requestLogger(r).Errorf("Cannot get CA fingerprints: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	buffer := &bytes.Buffer{}
+	for _, record := range records {
+		fmt.Fprintln(buffer, record)
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(buffer.Bytes()))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/cafingerprint"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCAFingerprints(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.caFingerprintsLimiter = newAddressRateLimiter(1, time.Minute)
+	caKey, err := ssh.NewPublicKey(state.Signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(state.caCertDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", publicPath+caFingerprintsName, nil)
+	rr, err := checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(records) != 2 {
+		t.Fatalf("expected two records, got:\n%s", rr.Body.String())
+	}
+	if err := cafingerprint.Verify(records, []ssh.PublicKey{caKey},
+		[]*x509.Certificate{caCert}); err != nil {
+		t.Error(err)
+	}
+	req = httptest.NewRequest("GET", publicPath+caFingerprintsName, nil)
+	rr, err = checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusTooManyRequests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After")
+	}
+}
+
+func TestAddressRateLimiter(t *testing.T) {
+	limiter := newAddressRateLimiter(2, time.Minute)
+	now := time.Now()
+	for i, expectAllowed := range []bool{true, true, false} {
+		if allowed := limiter.allow("10.0.0.1", now) == 0; allowed != expectAllowed {
+			t.Errorf("request %d allowed: %v", i, allowed)
+		}
+	}
+	if wait := limiter.allow("10.0.0.2", now); wait != 0 {
+		t.Error("other address limited")
+	}
+	if wait := limiter.allow("10.0.0.1", now.Add(time.Minute)); wait != 0 {
+		t.Error("limited in the next window")
+	}
+	// Requests counted by other instances in the same window add up.
+	later := now.Add(time.Minute)
+	other := newAddressRateLimiter(2, time.Minute)
+	other.allow("10.0.0.3", later)
+	other.allow("10.0.0.3", later)
+	limiter.setPeers([]addressRateLimitCounts{other.export(later)}, later)
+	if wait := limiter.allow("10.0.0.3", later); wait == 0 {
+		t.Error("requests of other instances not counted")
+	}
+	limiter.setPeers([]addressRateLimitCounts{other.export(later)},
+		later.Add(time.Minute))
+	if wait := limiter.allow("10.0.0.3", later.Add(time.Minute)); wait != 0 {
+		t.Error("requests of other instances counted in the next window")
+	}
+	var nilLimiter *addressRateLimiter
+	if nilLimiter.allow("10.0.0.1", now) != 0 {
+		t.Error("nil limiter limited")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The CA public keys are published under publicPath for hosts and users to
+// fetch and pin, as an authorized_keys style file and as known_hosts lines.
+const (
+	caPublicKeyName = "ca.pub"
+	knownHostsName  = "known_hosts"
+)
+
+// caSSHPublicKeys returns the trusted CA keys with the signing key first.
+func (state *RuntimeState) caSSHPublicKeys() ([]ssh.PublicKey, error) {
+	state.Mutex.Lock()
+	signer := state.Signer
+	publicKeys := state.KeymasterPublicKeys
+	state.Mutex.Unlock()
+	if signer == nil {
+		return nil, errors.New("signer not loaded")
+	}
+	signerKey, err := ssh.NewPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	keys := []ssh.PublicKey{signerKey}
+	// Standby and previous CA keys are trusted too.
+	for _, publicKey := range publicKeys {
+		sshPublicKey, err := ssh.NewPublicKey(publicKey)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(sshPublicKey.Marshal(), signerKey.Marshal()) {
+			continue
+		}
+		keys = append(keys, sshPublicKey)
+	}
+	return keys, nil
+}
+
+// checkKnownHostsPattern rejects host patterns which would break the
+// known_hosts line they are put in.
+func checkKnownHostsPattern(pattern string) error {
+	if pattern == "" || strings.ContainsAny(pattern, " \t\r\n#") {
+		return fmt.Errorf("bad hosts pattern: %q", pattern)
+	}
+	return nil
+}
+
+// writeCAPublicKeys writes the CA keys, one per line, or with hosts given
+// as @cert-authority lines for the known_hosts file. Responses carry an
+// ETag so that pollers only download the keys once they change.
+func (state *RuntimeState) writeCAPublicKeys(w http.ResponseWriter,
+	r *http.Request, knownHosts bool) {
+	keys, err := state.caSSHPublicKeys()
+	if err != nil {
+		requestLogger(r).Errorf("Cannot get CA keys: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	hosts := r.URL.Query().Get("hosts")
+	if hosts == "" {
+		hosts = "*"
+	}
+	if err := checkKnownHostsPattern(hosts); err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	buffer := &bytes.Buffer{}
+	for _, key := range keys {
+		line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+		if knownHosts {
+			fmt.Fprintf(buffer, "@cert-authority %s %s keymaster %s\n", hosts,
+				line, state.HostIdentity)
+		} else {
+			fmt.Fprintf(buffer, "%s keymaster %s\n", line, state.HostIdentity)
+		}
+	}
+	data := buffer.Bytes()
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(data)))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
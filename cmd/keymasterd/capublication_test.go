@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCAPublication(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.KeymasterPublicKeys = []crypto.PublicKey{state.Signer.Public()}
+	caKey, err := ssh.NewPublicKey(state.Signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", publicPath+caPublicKeyName, nil)
+	rr, err := checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one key, got:\n%s", rr.Body.String())
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(lines[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ssh.FingerprintSHA256(publicKey) != ssh.FingerprintSHA256(caKey) {
+		t.Error("published key is not the CA key")
+	}
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	req = httptest.NewRequest("GET", publicPath+caPublicKeyName, nil)
+	req.Header.Set("If-None-Match", etag)
+	if _, err := checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusNotModified); err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest("GET",
+		publicPath+knownHostsName+"?hosts=*.example.com", nil)
+	rr, err = checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marker, hosts, pubKey, _, _, err := ssh.ParseKnownHosts(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marker != "cert-authority" || len(hosts) != 1 ||
+		hosts[0] != "*.example.com" ||
+		ssh.FingerprintSHA256(pubKey) != ssh.FingerprintSHA256(caKey) {
+		t.Errorf("unexpected known_hosts: %s", rr.Body.String())
+	}
+	if rr.Header().Get("ETag") == etag {
+		t.Error("known_hosts has the ETag of ca.pub")
+	}
+	req = httptest.NewRequest("GET",
+		publicPath+knownHostsName+"?hosts=a%20b", nil)
+	if _, err := checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusBadRequest); err != nil {
+		t.Fatal(err)
+	}
+}
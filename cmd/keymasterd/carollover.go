@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const secsBetweenCARolloverChecks = 60
+
+// caRolloverSignerDrainTime is how long requests which read the previous
+// signer before the switch have to finish signing before it is zeroized.
+const caRolloverSignerDrainTime = time.Minute
+
+// errCAKeyChanged means that a CA rollover replaced the signer a request read
+// at its start. That signer is zeroized after caRolloverSignerDrainTime, so
+// the request must not sign with it.
+var errCAKeyChanged = errors.New("CA key changed during the request")
+
+// CARolloverConfig rotates the CA key without downtime. The key in
+// NextCAFilename is published next to the one in ssh_ca_filename until
+// SwitchTime, signs from then on, and the previous key stays published for
+// the grace period so that certificates it signed remain trusted until they
+// expire.
+type CARolloverConfig struct {
+	// Same format and passphrase as ssh_ca_filename.
+	NextCAFilename string `yaml:"next_ca_filename"`
+	// Required with x509_ca_cert_filename.
+	NextX509CACertFilename string `yaml:"next_x509_ca_cert_filename"`
+	// RFC 3339, e.g. 2026-11-01T09:00:00Z.
+	SwitchTime string `yaml:"switch_time"`
+	// Default and minimum: the longest lifetime of certificates, 1 day.
+	GracePeriodSecs uint `yaml:"grace_period_secs"`
+}
+
+// caRollover is the state of the rollover. All fields are protected by the
+// mutex of the RuntimeState.
+type caRollover struct {
+	switchTime     time.Time
+	gracePeriod    time.Duration
+	nextRawData    []byte
+	next           crypto.Signer    // Nil until unsealed.
+	previous       crypto.PublicKey // The key signing before the switch.
+	previousSigner crypto.Signer    // Nil once zeroized.
+	switched       bool
+	switchedAt     time.Time
+	retired        bool // The previous key is no longer published.
+}
+
+// caKeys is a consistent view of the CA keys and certificates, which a
+// rollover replaces together.
+type caKeys struct {
+	signer     crypto.Signer // Nil while sealed.
+	certDer    []byte
+	chainDer   [][]byte
+	publicKeys []crypto.PublicKey
+}
+
+// currentCA returns the CA keys. They are only replaced with both the mutex
+// and the caMutex of the RuntimeState held, so holding either is enough to
+// read them directly.
+func (state *RuntimeState) currentCA() caKeys {
+	state.caMutex.RLock()
+	defer state.caMutex.RUnlock()
+	publicKeys := state.KeymasterPublicKeys
+	return caKeys{
+		signer:     state.Signer,
+		certDer:    state.caCertDer,
+		chainDer:   state.caChainDer,
+		publicKeys: publicKeys[:len(publicKeys):len(publicKeys)],
+	}
+}
+
+// setCAKeys replaces the signer, its certificates and the published keys. It
+// must be called with the mutex held.
+func (state *RuntimeState) setCAKeys(ca caKeys) {
+	state.caMutex.Lock()
+	defer state.caMutex.Unlock()
+	state.Signer = ca.signer
+	state.caCertDer = ca.certDer
+	state.caChainDer = ca.chainDer
+	state.KeymasterPublicKeys = ca.publicKeys
+}
+
+func (config *CARolloverConfig) check(base baseConfig) error {
+	if config.NextCAFilename == "" {
+		if config.NextX509CACertFilename != "" || config.SwitchTime != "" {
+			return errors.New("ca_rollover: next_ca_filename is required")
+		}
+		return nil
+	}
+	if _, err := config.switchTime(); err != nil {
+		return err
+	}
+	if config.GracePeriodSecs > 0 && config.gracePeriod() < maxCertDuration {
+		return fmt.Errorf("ca_rollover: grace_period_secs below the "+
+			"longest lifetime of certificates, %d",
+			int(maxCertDuration.Seconds()))
+	}
+	if base.X509CACertFilename != "" && config.NextX509CACertFilename == "" {
+		return errors.New("ca_rollover: next_x509_ca_cert_filename is " +
+			"required with x509_ca_cert_filename")
+	}
+	return nil
+}
+
+func (config *CARolloverConfig) switchTime() (time.Time, error) {
+	switchTime, err := time.Parse(time.RFC3339, config.SwitchTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ca_rollover: bad switch_time: %s", err)
+	}
+	return switchTime, nil
+}
+
+func (config *CARolloverConfig) gracePeriod() time.Duration {
+	if config.GracePeriodSecs < 1 {
+		return maxCertDuration
+	}
+	return time.Duration(config.GracePeriodSecs) * time.Second
+}
+
+// setupCARollover reads the next CA key, if a rollover is configured.
+func (state *RuntimeState) setupCARollover() error {
+	config := &state.Config.CARollover
+	if config.NextCAFilename == "" {
+		return nil
+	}
+	switchTime, err := config.switchTime()
+	if err != nil {
+		return err
+	}
+	data, err := exitsAndCanRead(config.NextCAFilename, "next CA file")
+	if err != nil {
+		return err
+	}
+	state.caRollover = &caRollover{
+		switchTime:  switchTime,
+		gracePeriod: config.gracePeriod(),
+		nextRawData: data,
+	}
+	return nil
+}
+
+// unsealNextCAKey parses the next CA key with the passphrase of the current
+// one.
+func (state *RuntimeState) unsealNextCAKey(passphrase []byte) error {
+	if state.caRollover == nil {
+		return nil
+	}
+	signer, err := parseCAPrivateKey(state.caRollover.nextRawData, passphrase)
+	if err != nil {
+		return fmt.Errorf("cannot parse next CA key: %s", err)
+	}
+	// Fail now rather than at the switch.
+	if _, _, err := state.nextCACertDer(signer); err != nil {
+		zeroizeSigner(signer)
+		return fmt.Errorf("next CA key: %s", err)
+	}
+	state.caRollover.next = signer
+	return nil
+}
+
+func (state *RuntimeState) nextCACertDer(signer crypto.Signer) ([]byte,
+	[][]byte, error) {
+	if filename := state.Config.CARollover.NextX509CACertFilename; filename != "" {
+		return loadX509CAChain(filename, signer)
+	}
+	return generateCAChainDer(state, signer)
+}
+
+// checkSSHSigner returns errCAKeyChanged if signer does not sign with the
+// current CA key. Call it right before signing.
+func (state *RuntimeState) checkSSHSigner(signer ssh.Signer) error {
+	ca := state.currentCA()
+	if ca.signer == nil {
+		return errCAKeyChanged
+	}
+	caKey, err := ssh.NewPublicKey(ca.signer.Public())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(caKey.Marshal(), signer.PublicKey().Marshal()) {
+		return errCAKeyChanged
+	}
+	return nil
+}
+
+// caKeyErrorStatus returns the HTTP status for an error of x509Issuer:
+// requests which lost a race with a CA rollover may be retried.
+func caKeyErrorStatus(err error) int {
+	if err == errCAKeyChanged {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	aKey, err := ssh.NewPublicKey(a)
+	if err != nil {
+		return false
+	}
+	bKey, err := ssh.NewPublicKey(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aKey.Marshal(), bKey.Marshal())
+}
+
+// updateCARollover publishes the next key, switches signing to it once the
+// switch time has come, zeroizes the previous signer once requests in flight
+// are done with it and stops publishing the previous key after the grace
+// period. It must be called with the mutex held, after the signer is loaded.
+func (state *RuntimeState) updateCARollover(now time.Time) error {
+	rollover := state.caRollover
+	ca := state.currentCA()
+	if rollover == nil || rollover.next == nil || ca.signer == nil {
+		return nil
+	}
+	if !rollover.switched {
+		found := false
+		for _, key := range ca.publicKeys {
+			if publicKeysEqual(key, rollover.next.Public()) {
+				found = true
+			}
+		}
+		if !found {
+			ca.publicKeys = append(ca.publicKeys, rollover.next.Public())
+			state.setCAKeys(ca)
+		}
+		if now.Before(rollover.switchTime) {
+			return nil
+		}
+		caCertDer, caChainDer, err := state.nextCACertDer(rollover.next)
+		if err != nil {
+			return err
+		}
+		rollover.previous = ca.signer.Public()
+		rollover.previousSigner = ca.signer
+		ca.signer = rollover.next
+		ca.certDer = caCertDer
+		ca.chainDer = caChainDer
+		state.setCAKeys(ca)
+		rollover.switched = true
+		rollover.switchedAt = now
+		logger.Printf("CA rollover: signing with the next CA key")
+	}
+	if rollover.previousSigner != nil &&
+		!now.Before(rollover.switchedAt.Add(caRolloverSignerDrainTime)) {
+		zeroizeSigner(rollover.previousSigner)
+		rollover.previousSigner = nil
+	}
+	if rollover.retired ||
+		now.Before(rollover.switchTime.Add(rollover.gracePeriod)) {
+		return nil
+	}
+	publicKeys := make([]crypto.PublicKey, 0, len(ca.publicKeys))
+	for _, key := range ca.publicKeys {
+		if !publicKeysEqual(key, rollover.previous) {
+			publicKeys = append(publicKeys, key)
+		}
+	}
+	ca.publicKeys = publicKeys
+	state.setCAKeys(ca)
+	rollover.retired = true
+	logger.Printf("CA rollover: grace period over, previous CA key retired")
+	return nil
+}
+
+func (state *RuntimeState) checkCARolloverLoop() {
+	for {
+		time.Sleep(secsBetweenCARolloverChecks * time.Second)
+		state.Mutex.Lock()
+		err := state.updateCARollover(time.Now())
+		retired := state.caRollover.retired
+		state.Mutex.Unlock()
+		if err != nil {
+			logger.Printf("CA rollover: %s", err)
+		}
+		if retired {
+			return
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCARolloverConfigCheck(t *testing.T) {
+	var base baseConfig
+	valid := CARolloverConfig{NextCAFilename: "next.key",
+		SwitchTime: "2026-11-01T09:00:00Z"}
+	if err := valid.check(base); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&CARolloverConfig{}).check(base); err != nil {
+		t.Fatal(err)
+	}
+	if (&CARolloverConfig{}).gracePeriod() != maxCertDuration {
+		t.Error("default grace period is not the longest certificate lifetime")
+	}
+	short := valid
+	short.GracePeriodSecs = 3600
+	if err := short.check(base); err == nil {
+		t.Error("grace period below the longest certificate lifetime accepted")
+	}
+	base.X509CACertFilename = "ca.pem"
+	for _, config := range []CARolloverConfig{
+		{SwitchTime: "2026-11-01T09:00:00Z"},
+		{NextCAFilename: "next.key", SwitchTime: "tomorrow"},
+		valid,
+	} {
+		if err := config.check(base); err == nil {
+			t.Errorf("%+v accepted", config)
+		}
+	}
+}
+
+func TestCARollover(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	_, nextKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(nextKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switchTime := time.Now().Add(time.Hour)
+	state.caRollover = &caRollover{
+		switchTime:  switchTime,
+		gracePeriod: time.Hour,
+		nextRawData: pem.EncodeToMemory(
+			&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+	}
+	if err := state.unsealNextCAKey(nil); err != nil {
+		t.Fatal(err)
+	}
+	previous := state.Signer
+	checkKeys := func(when string, signer crypto.Signer, count int) {
+		if !publicKeysEqual(state.Signer.Public(), signer.Public()) {
+			t.Errorf("%s: signing with the wrong key", when)
+		}
+		keys, err := state.caSSHPublicKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != count {
+			t.Errorf("%s: %d keys published, expected %d", when, len(keys),
+				count)
+		}
+		if !publicKeysEqual(keys[0].(ssh.CryptoPublicKey).CryptoPublicKey(),
+			state.Signer.Public()) {
+			t.Errorf("%s: signing key not published first", when)
+		}
+	}
+
+	for _, test := range []struct {
+		when   string
+		now    time.Time
+		signer crypto.Signer
+		count  int
+	}{
+		{"before the switch", switchTime.Add(-time.Minute), previous, 2},
+		{"after the switch", switchTime, nextKey, 2},
+		{"after requests in flight", switchTime.Add(caRolloverSignerDrainTime),
+			nextKey, 2},
+		{"after the grace period", switchTime.Add(time.Hour), nextKey, 1},
+	} {
+		if err := state.updateCARollover(test.now); err != nil {
+			t.Fatal(err)
+		}
+		checkKeys(test.when, test.signer, test.count)
+	}
+	if state.caRollover.previousSigner != nil {
+		t.Error("previous signer not zeroized")
+	}
+	if _, _, err := state.x509Issuer(previous); err == nil {
+		t.Error("X.509 CA certificate returned for the previous key")
+	}
+	if _, _, err := state.x509Issuer(nextKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.genNewSerializedAuthJWT("username", AuthTypeU2F,
+		"", "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSSHSigningAfterCARollover(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	_, nextKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(nextKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switchTime := time.Now()
+	state.caRollover = &caRollover{
+		switchTime:  switchTime,
+		gracePeriod: time.Hour,
+		nextRawData: pem.EncodeToMemory(
+			&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+	}
+	if err := state.unsealNextCAKey(nil); err != nil {
+		t.Fatal(err)
+	}
+	// A request which read the signer before the switch.
+	previous := state.Signer
+	if err := state.updateCARollover(switchTime); err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(proto.CertRequest{
+		PublicKey: testUserSSHPublicKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		keySigner      crypto.Signer
+		expectedStatus int
+	}{
+		{previous, http.StatusServiceUnavailable},
+		{nextKey, http.StatusOK},
+	} {
+		req, err := http.NewRequest("POST", "/certgen/username",
+			bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = checkRequestHandlerCode(req,
+			func(w http.ResponseWriter, r *http.Request) {
+				if err := parseJSONCertRequest(r); err != nil {
+					t.Fatal(err)
+				}
+				state.postAuthSSHCertHandler(w, r, "username",
+					test.keySigner, time.Hour, AuthTypeU2F)
+			}, test.expectedStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/certapprovals"
+	"github.com/Symantec/keymaster/keymasterd/chatops"
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"golang.org/x/crypto/ssh"
+)
+
+const certApprovalsPath = "/api/v0/certApprovals/"
+const certApprovalsDirectory = "cert_approvals"
+
+const approvalKindCertificate = "certificate"
+
+const defaultCertApprovalPendingSecs = 3600
+const defaultCertApprovalApprovedSecs = 900
+
+var errNotCertApprover = errors.New("not an approver of this request")
+
+// CertApprovalRuleConfig requires certificates for matching targets or
+// principals to be approved by a second person before they are issued.
+type CertApprovalRuleConfig struct {
+	Name string `yaml:"name"`
+	// Patterns as in path.Match of the users or roles certificates are
+	// requested for.
+	Targets []string `yaml:"targets"`
+	// Patterns as in path.Match of the principals of the certificate: those
+	// of the role for role certificates, else the target.
+	Principals []string `yaml:"principals"`
+	// Default: all.
+	CertTypes []string `yaml:"cert_types"`
+	// Default: the administrators.
+	Approvers      []string `yaml:"approvers"`
+	ApproverGroups []string `yaml:"approver_groups"`
+}
+
+type CertApprovalsConfig struct {
+	// How long a request may wait for approval. Default: 1 hour.
+	PendingSecs uint `yaml:"pending_secs"`
+	// How long an approval may be used. Default: 15 minutes.
+	ApprovedSecs uint                     `yaml:"approved_secs"`
+	Rules        []CertApprovalRuleConfig `yaml:"rules"`
+}
+
+func (config *CertApprovalRuleConfig) check() error {
+	if config.Name == "" {
+		return errors.New("no name")
+	}
+	if len(config.Targets) < 1 && len(config.Principals) < 1 {
+		return fmt.Errorf("%s: neither targets nor principals", config.Name)
+	}
+	for _, pattern := range append(config.Targets, config.Principals...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s: bad pattern: %q", config.Name, pattern)
+		}
+	}
+	return nil
+}
+
+func (config *CertApprovalsConfig) check() error {
+	names := make(map[string]struct{}, len(config.Rules))
+	for index, rule := range config.Rules {
+		if err := rule.check(); err != nil {
+			return fmt.Errorf("certificate_approvals: rule %d: %s", index, err)
+		}
+		if _, ok := names[rule.Name]; ok {
+			return errors.New("certificate_approvals: duplicate rule " +
+				rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+	}
+	return nil
+}
+
+func (config *CertApprovalsConfig) pendingFor() time.Duration {
+	if config.PendingSecs < 1 {
+		return defaultCertApprovalPendingSecs * time.Second
+	}
+	return time.Duration(config.PendingSecs) * time.Second
+}
+
+func (config *CertApprovalsConfig) approvedFor() time.Duration {
+	if config.ApprovedSecs < 1 {
+		return defaultCertApprovalApprovedSecs * time.Second
+	}
+	return time.Duration(config.ApprovedSecs) * time.Second
+}
+
+func (config *CertApprovalsConfig) lookupRule(name string) (
+	CertApprovalRuleConfig, bool) {
+	for _, rule := range config.Rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return CertApprovalRuleConfig{}, false
+}
+
+func matchesAnyPattern(patterns []string, names []string) bool {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if matched, _ := path.Match(pattern, name); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (config *CertApprovalRuleConfig) matches(target, certType string,
+	principals []string) bool {
+	if len(config.CertTypes) > 0 &&
+		!stringsIntersect([]string{certType}, config.CertTypes) {
+		return false
+	}
+	return matchesAnyPattern(config.Targets, []string{target}) ||
+		matchesAnyPattern(config.Principals, principals)
+}
+
+// certApprovalRule returns the first rule requiring approval of the
+// certificate requested in r for targetUser, if any.
+func (state *RuntimeState) certApprovalRule(r *http.Request,
+	targetUser, certType string) (CertApprovalRuleConfig, bool) {
+	principals := []string{targetUser}
+	if role, ok := state.lookupRole(targetUser); ok && certType == "ssh" &&
+		delegatedRequester(r) != "" {
+		principals = role.principals()
+	}
+	for _, rule := range state.Config.CertApprovals.Rules {
+		if rule.matches(targetUser, certType, principals) {
+			return rule, true
+		}
+	}
+	return CertApprovalRuleConfig{}, false
+}
+
+// mayApproveCert returns nil if approver may decide on request.
+func (state *RuntimeState) mayApproveCert(request certapprovals.Request,
+	approver string) error {
+	rule, ok := state.Config.CertApprovals.lookupRule(request.Rule)
+	if !ok || len(rule.Approvers) < 1 && len(rule.ApproverGroups) < 1 {
+		if state.IsAdminUser(approver) {
+			return nil
+		}
+		return errNotCertApprover
+	}
+	if stringsIntersect([]string{approver}, rule.Approvers) {
+		return nil
+	}
+	if len(rule.ApproverGroups) < 1 {
+		return errNotCertApprover
+	}
+	groups, err := state.getUserGroups(approver)
+	if err != nil {
+		return err
+	}
+	if stringsIntersect(groups, rule.ApproverGroups) {
+		return nil
+	}
+	return errNotCertApprover
+}
+
+// requestKeyFingerprints returns the sorted SHA256 fingerprints of the keys
+// uploaded in r for a certificate of certType, or nil if there are none,
+// such as for SSH certificates of the keys of a public key source. Keys
+// which cannot be parsed are left out, their issuance fails later.
+func requestKeyFingerprints(r *http.Request, certType string) []string {
+	var fingerprints []string
+	if certType == "ssh" {
+		userPubKeys, err := getSSHPublicKeysFromForm(r)
+		if err != nil {
+			return nil
+		}
+		for _, userPubKey := range userPubKeys {
+			pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(userPubKey))
+			if err == nil {
+				fingerprints = append(fingerprints,
+					ssh.FingerprintSHA256(pubKey))
+			}
+		}
+	} else if data, err := getPublicKeyDataFromForm(r); err == nil {
+		if block, _ := pem.Decode(data); block != nil {
+			if pub, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+				fingerprints = append(fingerprints, publicKeyFingerprint(pub))
+			}
+		}
+	}
+	sort.Strings(fingerprints)
+	return fingerprints
+}
+
+// checkCertApproval returns true if the certificate requested in r may be
+// issued, with the approval claimed for it if one was required. The caller
+// must then pass the approval to completeCertApproval once the certificate
+// was issued or not. Otherwise it has queued a request for approval, or
+// found one pending, and responded with where to follow it.
+func (state *RuntimeState) checkCertApproval(w http.ResponseWriter,
+	r *http.Request, targetUser, certType string,
+	duration time.Duration) (*certapprovals.Request, bool) {
+	rule, ok := state.certApprovalRule(r, targetUser, certType)
+	if !ok {
+		return nil, true
+	}
+	requester := delegatedRequester(r)
+	if requester == "" {
+		requester = targetUser
+	}
+	template := certapprovals.Request{
+		Requester:       requester,
+		Target:          targetUser,
+		CertType:        certType,
+		DurationSecs:    int64(duration / time.Second),
+		KeyFingerprints: requestKeyFingerprints(r, certType),
+		Rule:            rule.Name,
+	}
+	if approved, ok := state.certApprovals.Claim(template); ok {
+		requestLogger(r).Printf("Issuing %s cert to %s%s approved by %s in request %d",
+			certType, targetUser, requestedBySuffix(r), approved.Approver,
+			approved.ID)
+		return &approved, true
+	}
+	request, created, err := state.certApprovals.Create(template,
+		state.Config.CertApprovals.pendingFor())
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return nil, false
+	}
+	if created {
+		requestLogger(r).Printf("Certificate request %d for %s cert to %s%s requires approval (%s)",
+			request.ID, certType, targetUser, requestedBySuffix(r), rule.Name)
+		state.notifyCertApprovers(request)
+	}
+	url := state.idpGetIssuer() + certApprovalsPath +
+		strconv.FormatUint(request.ID, 10)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Location", url)
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w,
+		"Certificate request %d is pending approval by a second person (%s), request the certificate again once approved: %s\n",
+		request.ID, rule.Name, url)
+	return nil, false
+}
+
+// completeCertApproval uses up approval if the response to w issued the
+// certificate, and else releases it for another attempt.
+func (state *RuntimeState) completeCertApproval(w http.ResponseWriter,
+	r *http.Request, approval *certapprovals.Request) {
+	if approval == nil {
+		return
+	}
+	issued := false
+	if loggingWriter, ok := w.(*instrumentedwriter.LoggingWriter); ok {
+		issued = loggingWriter.Status() == http.StatusOK
+	}
+	if _, err := state.certApprovals.Complete(approval.ID, issued); err != nil {
+		requestLogger(r).Errorf("Cannot complete certificate request %d: %s",
+			approval.ID, err)
+	}
+}
+
+func (state *RuntimeState) notifyCertApprovers(
+	request certapprovals.Request) {
+	if len(state.approvalIntegrations) < 1 {
+		return
+	}
+	id := strconv.FormatUint(request.ID, 10)
+	details := []string{
+		fmt.Sprintf("%s certificate for %s", request.CertType,
+			request.Target),
+		fmt.Sprintf("Duration: %s",
+			time.Duration(request.DurationSecs)*time.Second),
+	}
+	if len(request.KeyFingerprints) > 0 {
+		details = append(details,
+			"Keys: "+strings.Join(request.KeyFingerprints, ", "))
+	}
+	state.notifyApprovers(chatops.Approval{
+		Kind:    approvalKindCertificate,
+		ID:      id,
+		Title:   "Certificate request " + id,
+		Author:  request.Requester,
+		Details: append(details, "Rule: "+request.Rule),
+		URL:     state.idpGetIssuer() + certApprovalsPath + id,
+	})
+}
+
+// approveCertRequestVia approves a request for approver, who decided
+// through the chat integration via if not empty.
+func (state *RuntimeState) approveCertRequestVia(id uint64, approver,
+	via string) (certapprovals.Request, error) {
+	request, err := state.certApprovals.Approve(id, approver, via,
+		state.Config.CertApprovals.approvedFor(),
+		func(request certapprovals.Request) error {
+			return state.mayApproveCert(request, approver)
+		})
+	if err != nil {
+		return request, err
+	}
+	logger.Printf("Certificate request %d approved by %s%s", request.ID,
+		approver, viaSuffix(via))
+	return request, nil
+}
+
+// rejectCertRequestVia rejects or, for the requester, withdraws a request.
+func (state *RuntimeState) rejectCertRequestVia(id uint64, approver,
+	via string) (certapprovals.Request, error) {
+	pending, ok := state.certApprovals.Get(id)
+	if !ok {
+		return pending, certapprovals.ErrNotFound
+	}
+	if pending.Requester != approver {
+		if err := state.mayApproveCert(pending, approver); err != nil {
+			return pending, err
+		}
+	}
+	request, err := state.certApprovals.Reject(id, approver, via)
+	if err != nil {
+		return request, err
+	}
+	logger.Printf("Certificate request %d rejected by %s%s", id, approver,
+		viaSuffix(via))
+	return request, nil
+}
+
+// decideCertApproval applies the decision in callback and returns the
+// outcome to show the approver.
+func (state *RuntimeState) decideCertApproval(callback chatops.Callback,
+	via string) (string, error) {
+	id, err := strconv.ParseUint(callback.ID, 10, 64)
+	if err != nil || state.certApprovals == nil {
+		return "", certapprovals.ErrNotFound
+	}
+	if callback.Decision == chatops.DecisionApprove {
+		request, err := state.approveCertRequestVia(id, callback.Username,
+			via)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Certificate request %d approved by %s",
+			request.ID, callback.Username), nil
+	}
+	request, err := state.rejectCertRequestVia(id, callback.Username, via)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Certificate request %d rejected by %s", request.ID,
+		callback.Username), nil
+}
+
+func certApprovalErrorStatus(err error) int {
+	switch err {
+	case certapprovals.ErrNotFound:
+		return http.StatusNotFound
+	case certapprovals.ErrNotPending, certapprovals.ErrExpired:
+		return http.StatusConflict
+	case certapprovals.ErrSelfApproval, errNotCertApprover:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func (state *RuntimeState) writeCertApprovalError(w http.ResponseWriter,
+	r *http.Request, err error) {
+	status := certApprovalErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, status, "")
+		return
+	}
+	state.writeFailureResponse(w, r, status, err.Error())
+}
+
+// mayViewCertRequest returns true if user made or may decide on request.
+func (state *RuntimeState) mayViewCertRequest(request certapprovals.Request,
+	user string) bool {
+	return request.Requester == user ||
+		state.mayApproveCert(request, user) == nil
+}
+
+// certApprovalsHandler serves the approval of sensitive certificates:
+//
+//	GET  /api/v0/certApprovals/             list requests made or to decide
+//	GET  /api/v0/certApprovals/<id>         show a request
+//	POST /api/v0/certApprovals/<id>/approve approve, once U2F authenticated
+//	POST /api/v0/certApprovals/<id>/reject  reject or withdraw
+//
+// Requests are made by requesting the certificate, which is issued when
+// requested again after approval.
+func (state *RuntimeState) certApprovalsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authUser, loginLevel, err := state.checkAuth(w, r,
+		state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		requestLogger(r).Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	if r.Method != "GET" && r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, certApprovalsPath),
+		"/")
+	if parts[0] == "" {
+		if r.Method != "GET" {
+			state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+			return
+		}
+		requests := []certapprovals.Request{}
+		for _, request := range state.certApprovals.List() {
+			if state.mayViewCertRequest(request, authUser) {
+				requests = append(requests, request)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(requests)
+		return
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || len(parts) > 2 {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	request, ok := state.certApprovals.Get(id)
+	if !ok || !state.mayViewCertRequest(request, authUser) {
+		state.writeCertApprovalError(w, r, certapprovals.ErrNotFound)
+		return
+	}
+	if len(parts) == 1 {
+		if r.Method != "GET" {
+			state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+			return
+		}
+	} else {
+		if r.Method != "POST" {
+			state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+			return
+		}
+		switch parts[1] {
+		case "approve":
+			if loginLevel&AuthTypeU2F == 0 {
+				state.writeFailureResponse(w, r, http.StatusForbidden,
+					"Approvers must U2F authenticate")
+				return
+			}
+			request, err = state.approveCertRequestVia(id, authUser, "")
+		case "reject":
+			request, err = state.rejectCertRequestVia(id, authUser, "")
+		default:
+			state.writeFailureResponse(w, r, http.StatusNotFound, "")
+			return
+		}
+		if err != nil {
+			state.writeCertApprovalError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(request)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/keymaster/keymasterd/certapprovals"
+	"github.com/Symantec/keymaster/keymasterd/chatops"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCertApprovalsConfigCheck(t *testing.T) {
+	good := CertApprovalsConfig{Rules: []CertApprovalRuleConfig{
+		{Name: "root", Principals: []string{"root"}},
+		{Name: "production", Targets: []string{"prod-*"},
+			ApproverGroups: []string{"sre"}},
+	}}
+	if err := good.check(); err != nil {
+		t.Fatal(err)
+	}
+	for _, config := range []CertApprovalsConfig{
+		{Rules: []CertApprovalRuleConfig{{Targets: []string{"root"}}}},
+		{Rules: []CertApprovalRuleConfig{{Name: "root"}}},
+		{Rules: []CertApprovalRuleConfig{
+			{Name: "root", Targets: []string{"[root"}}}},
+		{Rules: []CertApprovalRuleConfig{
+			{Name: "root", Targets: []string{"root"}},
+			{Name: "root", Principals: []string{"root"}}}},
+	} {
+		if err := config.check(); err == nil {
+			t.Errorf("%+v accepted", config)
+		}
+	}
+}
+
+func doCertApproval(t *testing.T, state *RuntimeState, user string,
+	authLevel int, method string, path string,
+	expectedStatus int) certapprovals.Request {
+	cookieVal, err := state.setNewAuthCookie(nil, nil, user, authLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(method, certApprovalsPath+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	rr, err := checkRequestHandlerCode(req, state.certApprovalsHandler,
+		expectedStatus)
+	if err != nil {
+		t.Fatalf("%s %s by %s: %s", method, path, user, err)
+	}
+	var request certapprovals.Request
+	if expectedStatus == http.StatusOK && path != "" {
+		if err := json.Unmarshal(rr.Body.Bytes(), &request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return request
+}
+
+func TestCertApprovals(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "certapprovals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.certApprovals, err = certapprovals.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{
+		proto.AuthTypePassword}
+	state.Config.CertApprovals.Rules = []CertApprovalRuleConfig{{
+		Name:      "production",
+		Targets:   []string{"user*"},
+		CertTypes: []string{"ssh"},
+		Approvers: []string{"bob"},
+	}}
+	requestCertForKey := func(key string, expectedStatus int) *http.Response {
+		req, err := createKeyBodyRequest("POST", certgenPath+"username",
+			key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookieVal, err := state.setNewAuthCookie(nil, nil, "username",
+			AuthTypeU2F)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr.Result()
+	}
+	requestCert := func(expectedStatus int) *http.Response {
+		return requestCertForKey(testUserSSHPublicKey, expectedStatus)
+	}
+	u2f := AuthTypePassword | AuthTypeU2F
+	response := requestCert(http.StatusAccepted)
+	if location := response.Header.Get("Location"); !strings.HasSuffix(
+		location, certApprovalsPath+"1") {
+		t.Errorf("unexpected location: %q", location)
+	}
+	requestCert(http.StatusAccepted)
+	if requests := state.certApprovals.List(); len(requests) != 1 {
+		t.Fatalf("duplicate requests queued: %+v", requests)
+	}
+
+	doCertApproval(t, state, "username", u2f, "POST", "1/approve",
+		http.StatusForbidden)
+	doCertApproval(t, state, "carol", u2f, "GET", "1",
+		http.StatusNotFound)
+	doCertApproval(t, state, "carol", u2f, "POST", "1/approve",
+		http.StatusNotFound)
+	_, err = state.decideCertApproval(chatops.Callback{ID: "1",
+		Decision: chatops.DecisionApprove, Username: "carol"}, "bot:carol")
+	if err != errNotCertApprover {
+		t.Fatalf("chat approval by carol: %v", err)
+	}
+	doCertApproval(t, state, "bob", AuthTypePassword, "POST", "1/approve",
+		http.StatusForbidden)
+	request := doCertApproval(t, state, "bob", u2f, "POST",
+		"1/approve", http.StatusOK)
+	if request.State != certapprovals.StateApproved ||
+		request.Approver != "bob" {
+		t.Fatalf("unexpected approved request: %+v", request)
+	}
+	requestCert(http.StatusOK)
+	if request, _ := state.certApprovals.Get(1); request.State !=
+		certapprovals.StateUsed {
+		t.Fatalf("approval not used: %+v", request)
+	}
+
+	// The approval was for one certificate.
+	requestCert(http.StatusAccepted)
+	request = doCertApproval(t, state, "username", AuthTypePassword, "POST",
+		"2/reject", http.StatusOK)
+	if request.State != certapprovals.StateRejected {
+		t.Fatalf("unexpected withdrawn request: %+v", request)
+	}
+	for user, expected := range map[string]int{"username": 2, "bob": 2,
+		"carol": 0} {
+		cookieVal, err := state.setNewAuthCookie(nil, nil, user,
+			AuthTypePassword|AuthTypeU2F)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("GET", certApprovalsPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		rr, err := checkRequestHandlerCode(req, state.certApprovalsHandler,
+			http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var requests []certapprovals.Request
+		if err := json.Unmarshal(rr.Body.Bytes(), &requests); err != nil {
+			t.Fatal(err)
+		}
+		if len(requests) != expected {
+			t.Errorf("%s sees %d requests, expected %d", user,
+				len(requests), expected)
+		}
+	}
+
+	// Approvals are for the keys of the request.
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ssh.NewPublicKey(otherPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestCertForKey(string(ssh.MarshalAuthorizedKey(otherKey)),
+		http.StatusAccepted)
+	request, _ = state.certApprovals.Get(3)
+	if len(request.KeyFingerprints) != 1 ||
+		request.KeyFingerprints[0] != ssh.FingerprintSHA256(otherKey) {
+		t.Fatalf("unexpected key fingerprints: %v", request.KeyFingerprints)
+	}
+	doCertApproval(t, state, "bob", u2f, "POST", "3/approve", http.StatusOK)
+	requestCert(http.StatusAccepted)
+
+	// Certificates no rule matches are issued directly.
+	state.Config.CertApprovals.Rules[0].Targets = []string{"nobody"}
+	requestCert(http.StatusOK)
+}
@@ -1,26 +1,34 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
-	"regexp"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/Symantec/keymaster/keymasterd/tracing"
 	"github.com/Symantec/keymaster/lib/authutil"
 	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/Symantec/keymaster/lib/certlint"
 	"github.com/Symantec/keymaster/lib/instrumentedwriter"
 	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
-	"golang.org/x/crypto/ssh"
 )
 
 const certgenPath = "/certgen/"
 
+// maxCertDuration is the longest lifetime users may request.
+const maxCertDuration = 24 * time.Hour
+
 func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request) {
 	var signerIsNull bool
 	var keySigner crypto.Signer
@@ -36,7 +44,7 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 	//local sanity tests
 	if signerIsNull {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-		logger.Printf("Signer not loaded")
+		requestLogger(r).Printf("Signer not loaded")
 		return
 	}
 	/*
@@ -44,61 +52,54 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
 	authUser, authLevel, err := state.checkAuth(w, r, AuthTypeAny)
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		return
 	}
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
 
-	sufficientAuthLevel := false
-	// We should do an intersection operation here
-	for _, certPref := range state.Config.Base.AllowedAuthBackendsForCerts {
-		if certPref == proto.AuthTypePassword {
-			sufficientAuthLevel = true
-		}
-		if certPref == proto.AuthTypeU2F && ((authLevel & AuthTypeU2F) == AuthTypeU2F) {
-			sufficientAuthLevel = true
-		}
-		if certPref == proto.AuthTypeSymantecVIP && ((authLevel & AuthTypeSymantecVIP) == AuthTypeSymantecVIP) {
-			sufficientAuthLevel = true
-		}
-		if certPref == proto.AuthTypeIPCertificate && ((authLevel & AuthTypeIPCertificate) == AuthTypeIPCertificate) {
-			sufficientAuthLevel = true
-		}
-	}
-	// if you have u2f you can always get the cert
-	if (authLevel & AuthTypeU2F) == AuthTypeU2F {
-		sufficientAuthLevel = true
-	}
-
-	if !sufficientAuthLevel {
-		logger.Printf("Not enough auth level for getting certs")
+	if !state.isAuthLevelSufficientForCerts(authLevel) {
+		requestLogger(r).Printf("Not enough auth level for getting certs")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Not enough auth level for getting certs")
 		return
 	}
 
 	targetUser := r.URL.Path[len(certgenPath):]
 	if authUser != targetUser {
-		state.writeFailureResponse(w, r, http.StatusForbidden, "")
-		logger.Printf("User %s asking for creds for %s", authUser, targetUser)
-		return
+		allowed, err := state.mayDelegate(r.Context(), authUser, targetUser)
+		if err != nil {
+			requestLogger(r).Errorf("%s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		if !allowed {
+			state.writeFailureResponse(w, r, http.StatusForbidden, "")
+			requestLogger(r).Printf("User %s asking for creds for %s", authUser, targetUser)
+			return
+		}
+		r = withDelegatedRequester(r, authUser)
 	}
-	logger.Debugf(3, "auth succedded for %s", authUser)
+	requestLogger(r).Debugf(3, "auth succedded for %s", authUser)
 
 	switch r.Method {
 	case "GET":
-		logger.Debugf(3, "Got client GET connection")
+		requestLogger(r).Debugf(3, "Got client GET connection")
 		err = r.ParseForm()
 		if err != nil {
-			logger.Println(err)
+			requestLogger(r).Errorf("%s", err)
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
 			return
 		}
-	case "POST":
-		logger.Debugf(3, "Got client POST connection")
-		err = r.ParseMultipartForm(1e7)
+	case "POST", "PUT":
+		requestLogger(r).Debugf(3, "Got client %s connection", r.Method)
+		if isJSONRequest(r) {
+			err = parseJSONCertRequest(r)
+		} else if r.Method == "PUT" {
+			err = parseRawCertRequest(r)
+		} else {
+			r, err = parseUploadForm(r)
+		}
 		if err != nil {
-			logger.Println(err)
-			state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
+			state.writeUploadFormError(w, r, err)
 			return
 		}
 	default:
@@ -106,18 +107,107 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	duration := time.Duration(24 * time.Hour)
+	state.certGenFromParsedForm(w, r, targetUser, authLevel, keySigner)
+}
+
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// parseJSONCertRequest fills the form of r from a proto.CertRequest body,
+// so that it is handled like a multipart upload. Browsers cannot send JSON
+// cross site without a CORS preflight, so this adds no CSRF exposure.
+func parseJSONCertRequest(r *http.Request) error {
+	var request proto.CertRequest
+	decoder := json.NewDecoder(io.LimitReader(r.Body, 1e7))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		return err
+	}
+	form := r.URL.Query()
+	set := func(name, value string) {
+		if value != "" {
+			form.Set(name, value)
+		}
+	}
+	publicKeys := request.PublicKeys
+	if request.PublicKey != "" {
+		publicKeys = append([]string{request.PublicKey}, publicKeys...)
+	}
+	set("pubkey", strings.Join(publicKeys, "\n"))
+	set("duration", request.Duration)
+	set("type", request.Type)
+	if request.AddGroups {
+		form.Set("addGroups", "true")
+	}
+	set("hostnames", strings.Join(request.Hostnames, ","))
+	set("ip_addresses", strings.Join(request.IPAddresses, ","))
+	r.Form = form
+	r.PostForm = make(url.Values)
+	return nil
+}
+
+// parseRawCertRequest takes the body of r as the public key, e.g. from
+// curl --data-binary @id_ed25519.pub, whatever its Content-Type. The other
+// parameters come from the URL.
+func parseRawCertRequest(r *http.Request) error {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1e7))
+	if err != nil {
+		return err
+	}
+	form := r.URL.Query()
+	if publicKey := strings.TrimSpace(string(body)); publicKey != "" {
+		form.Set("pubkey", publicKey)
+	}
+	r.Form = form
+	r.PostForm = make(url.Values)
+	return nil
+}
+
+// capCertDuration returns the lifetime of a short lived certificate type
+// with a lifetime of at most maxDuration. The default lifetime is cut to
+// maxDuration, but asking for more is an error.
+func capCertDuration(r *http.Request, duration time.Duration,
+	maxDuration time.Duration) (time.Duration, error) {
+	if duration <= maxDuration {
+		return duration, nil
+	}
+	if _, ok := r.Form["duration"]; ok {
+		return 0, fmt.Errorf("duration above %s", maxDuration)
+	}
+	return maxDuration, nil
+}
+
+func (state *RuntimeState) isAuthLevelSufficientForCerts(authLevel int) bool {
+	return state.activeIssuancePolicy().sufficientForCerts(authLevel)
+}
+
+// certGenFromParsedForm issues the certificate requested in an already
+// parsed form for an already authenticated and authorized targetUser.
+func (state *RuntimeState) certGenFromParsedForm(w http.ResponseWriter,
+	r *http.Request, targetUser string, authLevel int,
+	keySigner crypto.Signer) {
+	ctx, cancel := backendContext(r)
+	defer cancel()
+	r = r.WithContext(ctx)
+	if err := state.injectFault(faultTargetSigning); err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	duration := maxCertDuration
 	if formDuration, ok := r.Form["duration"]; ok {
 		stringDuration := formDuration[0]
 		newDuration, err := time.ParseDuration(stringDuration)
 		if err != nil {
-			logger.Println(err)
+			requestLogger(r).Errorf("%s", err)
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form (duration)")
 			return
 		}
 		metricLogCertDuration("unparsed", "requested", float64(newDuration.Seconds()))
 		if newDuration > duration {
-			logger.Println(err)
+			requestLogger(r).Errorf("%s", err)
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form (invalid duration)")
 			return
 		}
@@ -128,72 +218,210 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 	if val, ok := r.Form["type"]; ok {
 		certType = val[0]
 	}
-	logger.Printf("cert type =%s", certType)
+	requestLogger(r).Printf("cert type =%s", certType)
+	if err := state.checkDelegation(r, targetUser, certType, duration); err != nil {
+		requestLogger(r).Printf("Issuance of %s cert to %s%s denied: %s", certType,
+			targetUser, requestedBySuffix(r), err)
+		if err != errNoDelegationRule {
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		state.writeFailureResponse(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+	// The second factor requirements of the members of a role apply to
+	// its certificates, a role has no groups itself.
+	duoUser := targetUser
+	if _, ok := state.lookupRole(targetUser); ok && delegatedRequester(r) != "" {
+		duoUser = delegatedRequester(r)
+	}
+	if err := state.checkDuoEnforcement(duoUser, authLevel); err != nil {
+		requestLogger(r).Printf("Issuance of %s cert to %s denied: %s", certType,
+			targetUser, err)
+		state.writeFailureResponse(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+	if err := state.checkPluginPolicies(r, targetUser, certType, duration); err != nil {
+		requestLogger(r).Printf("Issuance of %s cert to %s denied: %s", certType,
+			targetUser, err)
+		state.writeFailureResponse(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+	approval, ok := state.checkCertApproval(w, r, targetUser, certType,
+		duration)
+	if !ok {
+		return
+	}
+	defer state.completeCertApproval(w, r, approval)
 
 	switch certType {
 	case "ssh":
-		state.postAuthSSHCertHandler(w, r, targetUser, keySigner, duration)
+		state.postAuthSSHCertHandler(w, r, targetUser, keySigner, duration,
+			authLevel)
 		return
 	case "x509":
-		state.postAuthX509CertHandler(w, r, targetUser, keySigner, duration, false)
+		state.postAuthX509CertHandler(w, r, targetUser, keySigner, duration,
+			authLevel, false)
 		return
 	case "x509-kubernetes":
-		state.postAuthX509CertHandler(w, r, targetUser, keySigner, duration, true)
+		state.postAuthX509CertHandler(w, r, targetUser, keySigner, duration,
+			authLevel, true)
+		return
+	case certTypeSPIFFESVID:
+		state.postAuthSPIFFESVIDHandler(w, r, targetUser, keySigner, duration,
+			authLevel)
+		return
+	case certTypeKubeconfig:
+		state.postAuthKubeconfigHandler(w, r, targetUser, keySigner, duration,
+			authLevel)
 		return
 	default:
+		if profile, ok := hostCertProfiles[certType]; ok {
+			state.postAuthHostCertHandler(w, r, targetUser, keySigner,
+				duration, authLevel, certType, profile)
+			return
+		}
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Unrecognized cert type")
 		return
 	}
 }
 
+// getSSHPublicKeysFromForm returns the distinct keys in all the uploaded
+// pubkeyfile parts, one per line, or the pasted key.
+func getSSHPublicKeysFromForm(r *http.Request) ([]string, error) {
+	files := uploadedFiles(r, "pubkeyfile")
+	if len(files) < 2 {
+		pubKeyData, err := getPublicKeyDataFromForm(r)
+		if err != nil {
+			return nil, err
+		}
+		return splitSSHPublicKeys(nil, string(pubKeyData)), nil
+	}
+	var userPubKeys []string
+	for _, data := range files {
+		userPubKeys = splitSSHPublicKeys(userPubKeys, string(data))
+	}
+	return userPubKeys, nil
+}
+
+// splitSSHPublicKeys appends the distinct non-empty lines of data to keys.
+func splitSSHPublicKeys(keys []string, data string) []string {
+	for _, line := range strings.Split(data, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		line += "\n"
+		duplicate := false
+		for _, key := range keys {
+			if key == line {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			keys = append(keys, line)
+		}
+	}
+	return keys
+}
+
+// getPublicKeyDataFromForm returns the contents of the uploaded pubkeyfile.
+// If no file was uploaded it falls back to a key pasted into the pubkey field,
+// which is what the web UI sends.
+func getPublicKeyDataFromForm(r *http.Request) ([]byte, error) {
+	files := uploadedFiles(r, "pubkeyfile")
+	if len(files) < 1 {
+		pastedKey := strings.TrimSpace(r.Form.Get("pubkey"))
+		if pastedKey == "" {
+			return nil, http.ErrMissingFile
+		}
+		return []byte(pastedKey + "\n"), nil
+	}
+	return files[0], nil
+}
+
 func (state *RuntimeState) postAuthSSHCertHandler(
 	w http.ResponseWriter, r *http.Request, targetUser string,
-	keySigner crypto.Signer, duration time.Duration) {
-	signer, err := ssh.NewSignerFromSigner(keySigner)
+	keySigner crypto.Signer, duration time.Duration, authLevel int) {
+	signer, err := certgen.NewSSHCASigner(keySigner,
+		state.Config.Base.SSHRSASignatureAlgorithm)
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-		logger.Printf("Signer failed to load")
+		requestLogger(r).Errorf("Signer failed to load")
 		return
 	}
 
 	var cert string
 	var certBytes []byte
+	var options certgen.SSHCertOptions
+	var restrictions sshRestrictions
 	switch r.Method {
 	case "GET":
-		cert, certBytes, err = certgen.GenSSHCertFileStringFromSSSDPublicKey(targetUser, signer, state.HostIdentity, duration)
+		source, err := state.getSSHPublicKeySource(
+			r.Form.Get("pubkeySource"))
 		if err != nil {
-			http.NotFound(w, r)
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				err.Error())
 			return
 		}
-	case "POST":
-		file, _, err := r.FormFile("pubkeyfile")
+		state.postAuthSSHCertBundleHandler(w, r, targetUser, signer,
+			duration, authLevel, source)
+		return
+	case "POST", "PUT":
+		userPubKeys, err := getSSHPublicKeysFromForm(r)
+		if err == nil && len(userPubKeys) < 1 {
+			err = errors.New("empty public key file")
+		}
 		if err != nil {
-			logger.Println(err)
+			requestLogger(r).Errorf("%s", err)
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "Missing public key file")
 			return
 		}
-		defer file.Close()
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(file)
-		userPubKey := buf.String()
-		//validKey, err := regexp.MatchString("^(ssh-rsa|ssh-dss|ecdsa-sha2-nistp256|ssh-ed25519) [a-zA-Z0-9/+]+=?=? .*$", userPubKey)
-		validKey, err := regexp.MatchString("^(ssh-rsa|ssh-dss|ecdsa-sha2-nistp256|ssh-ed25519) [a-zA-Z0-9/+]+=?=? ?.{0,512}\n?$", userPubKey)
+		for index, userPubKey := range userPubKeys {
+			_, err := state.Config.KeyPolicy.checkSSHPublicKey(userPubKey)
+			if err != nil {
+				if policyErr, ok := err.(*keyPolicyError); ok &&
+					len(userPubKeys) > 1 {
+					policyErr.Message = fmt.Sprintf("key %d: %s", index+1,
+						policyErr.Message)
+				}
+				state.writeKeyPolicyError(w, r, err)
+				return
+			}
+		}
+		// Users with a key per device may upload them all at once.
+		if len(userPubKeys) > 1 {
+			if len(userPubKeys) > maxSSHPublicKeysPerRequest {
+				state.writeFailureResponse(w, r, http.StatusBadRequest,
+					"Too many public keys")
+				return
+			}
+			state.signSSHCertBundle(w, r, targetUser, signer, duration,
+				authLevel, userPubKeys)
+			return
+		}
+		userPubKey := userPubKeys[0]
+
+		options, restrictions = state.userSSHCertOptions(r, targetUser)
+		err = state.setSSHCertIdentity(r, targetUser, authLevel, &options)
 		if err != nil {
-			logger.Println(err)
+			requestLogger(r).Errorf("Cannot make SSH key ID for %s: %s", targetUser, err)
 			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 			return
 		}
-		if !validKey {
-			state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid File, bad re")
-			logger.Printf("invalid file, bad re")
+		if err := state.checkSSHSigner(signer); err != nil {
+			requestLogger(r).Printf("%s", err)
+			state.writeFailureResponse(w, r, http.StatusServiceUnavailable, "")
 			return
-
 		}
-
-		cert, certBytes, err = certgen.GenSSHCertFileString(targetUser, userPubKey, signer, state.HostIdentity, duration)
+		signingSpan := startSigningSpan(r, "ssh")
+		cert, certBytes, err = certgen.GenSSHCertFileStringWithOptions(
+			targetUser, userPubKey, signer, state.HostIdentity, duration,
+			options)
+		endSpan(signingSpan, err)
 		if err != nil {
 			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-			logger.Printf("signUserPubkey Err")
+			requestLogger(r).Printf("signUserPubkey Err")
 			return
 		}
 
@@ -202,13 +430,28 @@ func (state *RuntimeState) postAuthSSHCertHandler(
 		return
 
 	}
+	err = state.lintIssuedSSHCertForPrincipals(targetUser, options.Principals,
+		cert, signer.PublicKey(), duration)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
 	eventNotifier.PublishSSH(certBytes)
 	metricLogCertDuration("ssh", "granted", float64(duration.Seconds()))
+	state.recordIssuedCertBy(targetUser, delegatedRequester(r), requestID(r),
+		"ssh", describeSSHCertKey(certBytes), sshCertRecord{
+			KeyID:        options.KeyID,
+			Serial:       options.Serial,
+			Restrictions: restrictions,
+		}, authLevel, duration)
+	state.emailIssuance(r, targetUser, "ssh", sshCertKeyFingerprint(certBytes))
+	state.noteCertifiedStaticKey(r, targetUser, certBytes)
 
 	w.Header().Set("Content-Disposition", `attachment; filename="id_rsa-cert.pub"`)
 	w.WriteHeader(200)
 	fmt.Fprintf(w, "%s", cert)
-	logger.Printf("Generated SSH Certifcate for %s", targetUser)
+	requestLogger(r).Printf("Generated SSH Certifcate for %s%s", targetUser,
+		requestedBySuffix(r))
 	go func(username string, certType string) {
 		metricsMutex.Lock()
 		defer metricsMutex.Unlock()
@@ -217,6 +460,15 @@ func (state *RuntimeState) postAuthSSHCertHandler(
 }
 
 func (state *RuntimeState) getUserGroups(username string) ([]string, error) {
+	return state.getUserGroupsContext(context.Background(), username)
+}
+
+// getUserGroupsContext is getUserGroups but gives up once ctx is done.
+func (state *RuntimeState) getUserGroupsContext(ctx context.Context,
+	username string) ([]string, error) {
+	if err := state.injectFault(faultTargetLDAP); err != nil {
+		return nil, err
+	}
 	ldapConfig := state.Config.UserInfo.Ldap
 	var timeoutSecs uint
 	timeoutSecs = 2
@@ -227,17 +479,27 @@ func (state *RuntimeState) getUserGroups(username string) ([]string, error) {
 		}
 		u, err := authutil.ParseLDAPURL(ldapUrl)
 		if err != nil {
-			logger.Printf("Failed to parse ldapurl '%s'", ldapUrl)
+			logger.Errorf("Failed to parse ldapurl '%s'", ldapUrl)
 			continue
 		}
-		groups, err := authutil.GetLDAPUserGroups(*u,
+		_, span := tracing.StartChild(ctx, spanNameLDAPGroups,
+			tracing.SpanKindClient)
+		span.SetAttribute("server.address", u.Host)
+		groups, err := authutil.GetLDAPUserGroupsWithReferralsContext(ctx, *u,
 			ldapConfig.BindUsername, ldapConfig.BindPassword,
 			timeoutSecs, nil, username,
 			ldapConfig.UserSearchBaseDNs, ldapConfig.UserSearchFilter,
-			ldapConfig.GroupSearchBaseDNs, ldapConfig.GroupSearchFilter)
+			ldapConfig.GroupSearchBaseDNs, ldapConfig.GroupSearchFilter,
+			ldapConfig.NestedGroups,
+			ldapConfig.Referrals.referralPolicy(ldapReferralBackendUserInfo))
+		endSpan(span, err)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			continue
 		}
+		state.recordUserGroups(username, groups)
 		return groups, nil
 
 	}
@@ -251,7 +513,7 @@ func (state *RuntimeState) getUserGroups(username string) ([]string, error) {
 
 func (state *RuntimeState) postAuthX509CertHandler(
 	w http.ResponseWriter, r *http.Request, targetUser string,
-	keySigner crypto.Signer, duration time.Duration,
+	keySigner crypto.Signer, duration time.Duration, authLevel int,
 	kubernetesHack bool) {
 
 	var userGroups, groups []string
@@ -259,10 +521,10 @@ func (state *RuntimeState) postAuthX509CertHandler(
 	// abort if we are not explicitly asking for groups in our cert.
 	if kubernetesHack || r.Form.Get("addGroups") == "true" {
 		var err error
-		logger.Debugf(2, "Groups needed for cert")
-		userGroups, err = state.getUserGroups(targetUser)
+		requestLogger(r).Debugf(2, "Groups needed for cert")
+		userGroups, err = state.getUserGroupsContext(r.Context(), targetUser)
 		if err != nil {
-			logger.Println(err)
+			requestLogger(r).Errorf("%s", err)
 			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 			return
 		}
@@ -275,49 +537,71 @@ func (state *RuntimeState) postAuthX509CertHandler(
 		organizations = userGroups
 	}
 	var cert string
+	var keyType, keyFingerprint string
 	switch r.Method {
-	case "POST":
-		file, _, err := r.FormFile("pubkeyfile")
+	case "POST", "PUT":
+		pubKeyData, err := getPublicKeyDataFromForm(r)
 		if err != nil {
-			logger.Println(err)
+			requestLogger(r).Errorf("%s", err)
 			state.writeFailureResponse(w, r, http.StatusBadRequest,
 				"Missing public key file")
 			return
 		}
-		defer file.Close()
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(file)
 
-		block, _ := pem.Decode(buf.Bytes())
+		block, _ := pem.Decode(pubKeyData)
 		if block == nil || block.Type != "PUBLIC KEY" {
 			state.writeFailureResponse(w, r, http.StatusBadRequest,
 				"Invalid File, Unable to decode pem")
-			logger.Printf("invalid file, unable to decode pem")
+			requestLogger(r).Warnf("invalid file, unable to decode pem")
 			return
 		}
 		userPub, err := x509.ParsePKIXPublicKey(block.Bytes)
 		if err != nil {
 			state.writeFailureResponse(w, r, http.StatusBadRequest,
 				"Cannot parse public key")
-			logger.Printf("Cannot parse public key")
+			requestLogger(r).Errorf("Cannot parse public key")
+			return
+		}
+		if err := state.Config.KeyPolicy.checkPublicKey(userPub); err != nil {
+			state.writeKeyPolicyError(w, r, err)
 			return
 		}
-		caCert, err := x509.ParseCertificate(state.caCertDer)
+		caCert, caChainPEM, err := state.x509Issuer(keySigner)
+		if err != nil {
+			state.writeFailureResponse(w, r, caKeyErrorStatus(err), "")
+			requestLogger(r).Errorf("%s", err)
+			return
+		}
+		keyType = describePublicKey(userPub)
+		keyFingerprint = publicKeyFingerprint(userPub)
+		signingSpan := startSigningSpan(r, "x509")
+		derCert, err := certgen.GenUserX509CertWithOptions(targetUser,
+			userPub, caCert, keySigner, state.KerberosRealm, duration, groups,
+			organizations, state.x509CertOptions(caCert))
+		endSpan(signingSpan, err)
 		if err != nil {
 			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-			logger.Printf("Cannot parse CA Der data")
+			requestLogger(r).Errorf("Cannot Generate x509cert")
 			return
 		}
-		derCert, err := certgen.GenUserX509Cert(targetUser, userPub, caCert,
-			keySigner, state.KerberosRealm, duration, groups, organizations)
+		err = state.lintIssuedX509Cert("x509", targetUser, derCert,
+			certlint.X509Profile{
+				CA:          caCert,
+				MaxLifetime: duration,
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+				CommonName:  targetUser,
+			})
 		if err != nil {
 			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-			logger.Printf("Cannot Generate x509cert")
+			return
+		}
+		if err := state.recordIssuedX509Cert(requestID(r), "x509", derCert); err != nil {
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 			return
 		}
 		eventNotifier.PublishX509(derCert)
 		cert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
-			Bytes: derCert}))
+			Bytes: derCert})) + string(caChainPEM)
 
 	default:
 		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
@@ -325,11 +609,15 @@ func (state *RuntimeState) postAuthX509CertHandler(
 
 	}
 	metricLogCertDuration("x509", "granted", float64(duration.Seconds()))
+	state.recordIssuedCertBy(targetUser, delegatedRequester(r), requestID(r),
+		"x509", keyType, sshCertRecord{}, authLevel, duration)
+	state.emailIssuance(r, targetUser, "x509", keyFingerprint)
 
 	w.Header().Set("Content-Disposition", `attachment; filename="userCert.pem"`)
 	w.WriteHeader(200)
 	fmt.Fprintf(w, "%s", cert)
-	logger.Printf("Generated x509 Certifcate for %s", targetUser)
+	requestLogger(r).Printf("Generated x509 Certifcate for %s%s", targetUser,
+		requestedBySuffix(r))
 	go func(username string, certType string) {
 		metricsMutex.Lock()
 		defer metricsMutex.Unlock()
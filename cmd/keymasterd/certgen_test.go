@@ -1,20 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Symantec/keymaster/lib/certgen"
 	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
 )
 
 const testSignerX509Cert = `-----BEGIN CERTIFICATE-----
@@ -142,3 +146,110 @@ func TestSuccessFullSigningX509IPCert(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestSigningFromJSONRequest(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(path string, body []byte, expectedStatus int) []byte {
+		req, err := http.NewRequest("POST", path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr.Body.Bytes()
+	}
+	body, err := json.Marshal(proto.CertRequest{
+		PublicKey: testUserSSHPublicKey,
+		Duration:  "4h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(
+		post("/certgen/username", body, http.StatusOK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		t.Fatal("not an SSH certificate")
+	}
+	if lifetime := cert.ValidBefore - cert.ValidAfter; lifetime > 4*3600+300 {
+		t.Errorf("duration not applied: %ds", lifetime)
+	}
+
+	body, err = json.Marshal(proto.CertRequest{PublicKey: testUserPEMPublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(post("/certgen/username?type=x509", body,
+		http.StatusOK))
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatal("no X.509 certificate returned")
+	}
+
+	post("/certgen/username", []byte(`{"public_key": "x", "lifetime": "1h"}`),
+		http.StatusBadRequest)
+	post("/certgen/username", []byte(`{"duration": "48h", "public_key": `+
+		`"`+testUserSSHPublicKey+`"}`), http.StatusBadRequest)
+}
+
+func TestSigningFromRawPutRequest(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(path, body string, expectedStatus int) []byte {
+		req, err := http.NewRequest("PUT", path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// What curl --data-binary sends.
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr.Body.Bytes()
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(put(
+		"/certgen/username?duration=4h", testUserSSHPublicKey+"\n",
+		http.StatusOK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		t.Fatal("not an SSH certificate")
+	}
+	if lifetime := cert.ValidBefore - cert.ValidAfter; lifetime > 4*3600+300 {
+		t.Errorf("duration not applied: %ds", lifetime)
+	}
+	block, _ := pem.Decode(put("/certgen/username?type=x509",
+		testUserPEMPublicKey, http.StatusOK))
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatal("no X.509 certificate returned")
+	}
+	put("/certgen/username", "", http.StatusBadRequest)
+	put("/certgen/username", "not a key", http.StatusBadRequest)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/Symantec/keymaster/lib/certlint"
+	"golang.org/x/crypto/ssh"
+)
+
+var errCertLintFailed = errors.New("issued certificate failed linting")
+
+// checkCertLintFindings logs and counts the findings for a certificate.
+// It returns errCertLintFailed if the certificate must not be released.
+func (state *RuntimeState) checkCertLintFindings(certType string,
+	username string, findings certlint.Findings) error {
+	if len(findings) < 1 {
+		return nil
+	}
+	metricsMutex.Lock()
+	for _, finding := range findings {
+		certLintFindingsCounter.WithLabelValues(certType, finding.Lint).Inc()
+	}
+	metricsMutex.Unlock()
+	logger.Printf("Lint findings for %s cert for %s: %s", certType, username,
+		findings)
+	if state.Config.CertLint.Enforce && findings.HasErrors() {
+		return errCertLintFailed
+	}
+	return nil
+}
+
+func (state *RuntimeState) lintIssuedSSHCert(username string,
+	certString string, caKey ssh.PublicKey, duration time.Duration) error {
+	return state.lintIssuedSSHCertForPrincipals(username, []string{username},
+		certString, caKey, duration)
+}
+
+// lintIssuedSSHCertForPrincipals lints a certificate of username valid for
+// principals.
+func (state *RuntimeState) lintIssuedSSHCertForPrincipals(username string,
+	principals []string, certString string, caKey ssh.PublicKey,
+	duration time.Duration) error {
+	if state.Config.CertLint.Disabled {
+		return nil
+	}
+	findings, err := certlint.LintSSHCertFile([]byte(certString),
+		certlint.SSHProfile{
+			CertType:    ssh.UserCert,
+			Principals:  principals,
+			MaxLifetime: duration,
+			CAKey:       caKey,
+			Extensions: append(append([]string{}, certlint.DefaultSSHExtensions...),
+				certgen.SSHGroupsExtension, certgen.SSHGroupsTruncatedExtension),
+		}, time.Now())
+	if err != nil {
+		logger.Errorf("Cannot lint ssh cert for %s: %s", username, err)
+		if state.Config.CertLint.Enforce {
+			return errCertLintFailed
+		}
+		return nil
+	}
+	return state.checkCertLintFindings("ssh", username, findings)
+}
+
+func (state *RuntimeState) lintIssuedX509Cert(certType string,
+	username string, derCert []byte, profile certlint.X509Profile) error {
+	if state.Config.CertLint.Disabled {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		logger.Errorf("Cannot lint %s cert for %s: %s", certType, username, err)
+		if state.Config.CertLint.Enforce {
+			return errCertLintFailed
+		}
+		return nil
+	}
+	return state.checkCertLintFindings(certType, username,
+		state.x509Linter.LintX509Cert(cert, profile, time.Now()))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/x509"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/Symantec/keymaster/lib/certlint"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLintIssuedCerts(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.Config.CertLint.Enforce = true
+	signer, err := ssh.NewSignerFromSigner(state.Signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _, err := certgen.GenSSHCertFileString("username",
+		testUserSSHPublicKey, signer, "host", testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.lintIssuedSSHCert("username", cert, signer.PublicKey(),
+		testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.lintIssuedSSHCert("other", cert, signer.PublicKey(),
+		testDuration); err != errCertLintFailed {
+		t.Fatal("certificate for the wrong principal should be blocked")
+	}
+	mislabeled := strings.Replace(cert, "ssh-rsa-cert-v01@openssh.com",
+		"ssh-ed25519-cert-v01@openssh.com", 1)
+	if err := state.lintIssuedSSHCert("username", mislabeled,
+		signer.PublicKey(), testDuration); err != errCertLintFailed {
+		t.Fatal("mislabeled certificate should be blocked")
+	}
+	state.Config.CertLint.Enforce = false
+	if err := state.lintIssuedSSHCert("username", mislabeled,
+		signer.PublicKey(), testDuration); err != nil {
+		t.Fatal("findings should only be flagged when not enforced")
+	}
+
+	state.Config.CertLint.Enforce = true
+	userPub, err := getPubKeyFromPem(testUserPEMPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(state.caCertDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derCert, err := certgen.GenUserX509Cert("username", userPub, caCert,
+		state.Signer, nil, testDuration, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := certlint.X509Profile{
+		CA:          caCert,
+		MaxLifetime: testDuration,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		CommonName:  "username",
+	}
+	if err := state.lintIssuedX509Cert("x509", "username", derCert,
+		profile); err != nil {
+		t.Fatal(err)
+	}
+	profile.MaxLifetime = time.Second
+	if err := state.lintIssuedX509Cert("x509", "username", derCert,
+		profile); err != errCertLintFailed {
+		t.Fatal("certificate exceeding its profile should be blocked")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+)
+
+const certRequestPath = "/certrequest/"
+
+const csrfTokenFormField = "csrf_token"
+
+// sshCertRecord describes an SSH user certificate for the issuance records.
+type sshCertRecord struct {
+	KeyID        string
+	Serial       uint64
+	Restrictions sshRestrictions
+}
+
+// Only the latest certificate of each type is remembered, and only by the
+// keymaster instance that issued it.
+type issuedCertInfo struct {
+	CertType  string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+func (state *RuntimeState) recordIssuedCert(username string, certType string,
+	keyType string, authLevel int, duration time.Duration) {
+	state.recordIssuedCertBy(username, "", "", certType, keyType,
+		sshCertRecord{}, authLevel, duration)
+}
+
+// recordIssuedCertBy records a certificate for username requested by
+// requester, who is empty unless the certificate was delegated, in the HTTP
+// request with ID requestID. sshCert is only set for SSH user certificates.
+func (state *RuntimeState) recordIssuedCertBy(username string,
+	requester string, requestID string, certType string, keyType string,
+	sshCert sshCertRecord, authLevel int, duration time.Duration) {
+	now := time.Now()
+	state.recordIssuanceAttestation(username, requester, requestID, certType,
+		keyType, sshCert, authLevel, now, duration)
+	newInfo := issuedCertInfo{
+		CertType:  certType,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(duration),
+	}
+	state.Mutex.Lock()
+	defer state.Mutex.Unlock()
+	if state.issuedCerts == nil {
+		state.issuedCerts = make(map[string][]issuedCertInfo)
+	}
+	var certs []issuedCertInfo
+	for _, info := range state.issuedCerts[username] {
+		if info.CertType != certType {
+			certs = append(certs, info)
+		}
+	}
+	state.issuedCerts[username] = append(certs, newInfo)
+}
+
+func (state *RuntimeState) getIssuedCertsDisplayInfo(username string) []issuedCertDisplayInfo {
+	state.Mutex.Lock()
+	certs := state.issuedCerts[username]
+	state.Mutex.Unlock()
+	now := time.Now()
+	var displayInfo []issuedCertDisplayInfo
+	for _, info := range certs {
+		displayInfo = append(displayInfo, issuedCertDisplayInfo{
+			CertType:  info.CertType,
+			IssuedAt:  info.IssuedAt.Format(time.RFC3339),
+			ExpiresAt: info.ExpiresAt.Format(time.RFC3339),
+			Expired:   info.ExpiresAt.Before(now),
+		})
+	}
+	return displayInfo
+}
+
+func getSessionCookieValue(r *http.Request) string {
+	authCookie, err := r.Cookie(authCookieName)
+	if err != nil {
+		return ""
+	}
+	return authCookie.Value
+}
+
+func (state *RuntimeState) writeCertRequestPage(w http.ResponseWriter,
+	r *http.Request, authUser string, authLevel int) {
+	csrfToken, err := state.genNewSerializedCSRFToken(authUser,
+		getSessionCookieValue(r))
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	displayData := certRequestPageTemplateData{
+		Title:         "Keymaster Certificate Request",
+		AuthUsername:  authUser,
+		CSRFToken:     csrfToken,
+		CanIssueCerts: state.isAuthLevelSufficientForCerts(authLevel),
+		IssuedCerts:   state.getIssuedCertsDisplayInfo(authUser),
+		StaticKeys:    state.getStaticKeysDisplayInfo(authUser),
+	}
+	err = state.htmlTemplate.ExecuteTemplate(w, "certRequestPage", displayData)
+	if err != nil {
+		requestLogger(r).Errorf("Failed to execute %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// certRequestHandler serves the web form for requesting certificates. A GET
+// renders the form and a POST issues the certificate as a download. POSTs
+// must carry the CSRF token embedded in the form.
+func (state *RuntimeState) certRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authUser, authLevel, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		requestLogger(r).Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+
+	switch r.Method {
+	case "GET":
+		state.writeCertRequestPage(w, r, authUser, authLevel)
+		return
+	case "POST":
+		r, err = parseUploadForm(r)
+		if err != nil {
+			state.writeUploadFormError(w, r, err)
+			return
+		}
+	default:
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	err = state.verifyCSRFToken(r.Form.Get(csrfTokenFormField), authUser,
+		getSessionCookieValue(r))
+	if err != nil {
+		requestLogger(r).Errorf("CSRF check failed for %s: %s", authUser, err)
+		state.writeFailureResponse(w, r, http.StatusForbidden, "Invalid CSRF token")
+		return
+	}
+	if !state.isAuthLevelSufficientForCerts(authLevel) {
+		requestLogger(r).Printf("Not enough auth level for getting certs")
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Not enough auth level for getting certs")
+		return
+	}
+	if r.Form.Get(staticKeySignaturesFormField) != "" {
+		provenRequest, err := state.certifyStaticKeysFromForm(r, authUser)
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		r = provenRequest
+	}
+	state.Mutex.Lock()
+	keySigner := state.Signer
+	state.Mutex.Unlock()
+	state.certGenFromParsedForm(w, r, authUser, authLevel, keySigner)
+}
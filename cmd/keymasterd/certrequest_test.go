@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+func createCertRequestFormRequest(pubkey, csrfToken string) (*http.Request, error) {
+	bodyBuf := &bytes.Buffer{}
+	bodyWriter := multipart.NewWriter(bodyBuf)
+	fields := map[string]string{
+		"type":             "ssh",
+		"duration":         "1h",
+		"pubkey":           pubkey,
+		csrfTokenFormField: csrfToken,
+	}
+	for name, value := range fields {
+		if err := bodyWriter.WriteField(name, value); err != nil {
+			return nil, err
+		}
+	}
+	contentType := bodyWriter.FormDataContentType()
+	bodyWriter.Close()
+	req, err := http.NewRequest("POST", certRequestPath, bodyBuf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return req, nil
+}
+
+func TestCertRequestHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{proto.AuthTypeU2F}
+	err = state.loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authCookie := http.Cookie{Name: authCookieName, Value: cookieVal}
+
+	req, err := http.NewRequest("GET", certRequestPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&authCookie)
+	_, err = checkRequestHandlerCode(req, state.certRequestHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing CSRF token
+	req, err = createCertRequestFormRequest(testUserSSHPublicKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&authCookie)
+	_, err = checkRequestHandlerCode(req, state.certRequestHandler, http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CSRF token bound to another session
+	otherToken, err := state.genNewSerializedCSRFToken("username", "othersession")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = createCertRequestFormRequest(testUserSSHPublicKey, otherToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&authCookie)
+	_, err = checkRequestHandlerCode(req, state.certRequestHandler, http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csrfToken, err := state.genNewSerializedCSRFToken("username", cookieVal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = createCertRequestFormRequest(testUserSSHPublicKey, csrfToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&authCookie)
+	_, err = checkRequestHandlerCode(req, state.certRequestHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.getIssuedCertsDisplayInfo("username")) != 1 {
+		t.Fatal("issued cert was not recorded")
+	}
+}
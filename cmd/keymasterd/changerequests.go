@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Symantec/keymaster/keymasterd/changerequests"
+	"github.com/Symantec/keymaster/keymasterd/policyversions"
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"gopkg.in/yaml.v2"
+)
+
+const changeRequestsPath = "/api/v0/changeRequests/"
+const changeRequestsDirectory = "change_requests"
+
+const maxChangeRequestCommentSize = 1 << 16
+
+var errStaleChangeRequest = errors.New(
+	"the policy changed since the request was made, submit it again")
+
+type changeRequestInfo struct {
+	changerequests.Request
+	FieldChanges []policyFieldChange `json:"field_changes"`
+	// Policy is the proposed policy in YAML.
+	Policy string `json:"policy"`
+}
+
+func (config *AppConfigFile) setIssuancePolicy(policy issuancePolicy) {
+	config.Base.AllowedAuthBackendsForCerts = policy.AllowedAuthBackendsForCerts
+	config.Base.AutomationUsers = policy.AutomationUsers
+	config.Base.AutomationUserGroups = policy.AutomationUserGroups
+	config.SymantecVIP.Enabled = policy.SymantecVIPEnabled
+	config.Base.EnableLocalTOTP = policy.LocalTOTPEnabled
+	config.Duo.Enabled = policy.DuoEnabled
+	config.Duo.EnforceGroups = policy.DuoEnforceGroups
+	config.AuthRequirements = policy.AuthRequirements
+}
+
+// checkIssuancePolicy returns an error if policy cannot be activated, as it
+// enables second factors without the configuration they need.
+func (state *RuntimeState) checkIssuancePolicy(policy issuancePolicy) error {
+	if policy.SymantecVIPEnabled && state.Config.SymantecVIP.Client == nil {
+		return errors.New("SymantecVIP is not configured")
+	}
+	if policy.DuoEnabled && state.Config.Duo.Client == nil {
+		return errors.New("Duo is not configured")
+	}
+	return policy.AuthRequirements.check()
+}
+
+// activePolicyVersion returns the version of the policy in force: that of the
+// latest approved change unless the configuration file changed since.
+func (state *RuntimeState) activePolicyVersion() policyversions.Version {
+	if state.changeRequests != nil {
+		request, ok := state.changeRequests.LatestApproved()
+		if ok && request.ConfigVersion == state.configPolicyVersion {
+			if version, ok := state.policyVersions.Get(
+				request.ProposedVersion); ok {
+				return version
+			}
+		}
+	}
+	version, _ := state.policyVersions.Get(state.configPolicyVersion)
+	return version
+}
+
+// applyApprovedPolicyChange activates the latest approved change at startup.
+// Changes to the configuration file take precedence over earlier approvals.
+func (state *RuntimeState) applyApprovedPolicyChange() error {
+	if state.changeRequests == nil {
+		return nil
+	}
+	request, ok := state.changeRequests.LatestApproved()
+	if !ok {
+		return nil
+	}
+	if request.ConfigVersion != state.configPolicyVersion {
+		logger.Printf("Configuration changed since change request %d was approved, not applying it",
+			request.ID)
+		return nil
+	}
+	version, ok := state.policyVersions.Get(request.ProposedVersion)
+	if !ok {
+		return fmt.Errorf("missing policy version %d of change request %d",
+			request.ProposedVersion, request.ID)
+	}
+	policy, err := parseIssuancePolicy(version.Policy)
+	if err != nil {
+		return fmt.Errorf("change request %d: %s", request.ID, err)
+	}
+	if err := state.checkIssuancePolicy(policy); err != nil {
+		return fmt.Errorf("change request %d: %s", request.ID, err)
+	}
+	state.Config.setIssuancePolicy(policy)
+	logger.Printf("Applied issuance policy version %d from change request %d",
+		version.ID, request.ID)
+	return nil
+}
+
+func (state *RuntimeState) makeChangeRequestInfo(
+	request changerequests.Request) (changeRequestInfo, error) {
+	info := changeRequestInfo{Request: request}
+	var policies [2]issuancePolicy
+	for index, id := range []uint64{request.BaseVersion,
+		request.ProposedVersion} {
+		version, ok := state.policyVersions.Get(id)
+		if !ok {
+			return info, fmt.Errorf("missing policy version %d", id)
+		}
+		policy, err := parseIssuancePolicy(version.Policy)
+		if err != nil {
+			return info, err
+		}
+		policies[index] = policy
+		info.Policy = string(version.Policy)
+	}
+	info.FieldChanges = policyFieldChanges(policies[0], policies[1])
+	return info, nil
+}
+
+func (state *RuntimeState) writeChangeRequest(w http.ResponseWriter,
+	r *http.Request, request changerequests.Request) {
+	info, err := state.makeChangeRequestInfo(request)
+	if err != nil {
+		requestLogger(r).Printf("Change request %d: %s", request.ID, err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func (state *RuntimeState) writeChangeRequestError(w http.ResponseWriter,
+	r *http.Request, err error) {
+	switch err {
+	case changerequests.ErrNotFound:
+		state.writeFailureResponse(w, r, http.StatusNotFound, err.Error())
+	case changerequests.ErrNotPending, errStaleChangeRequest:
+		state.writeFailureResponse(w, r, http.StatusConflict, err.Error())
+	case changerequests.ErrSelfApproval:
+		state.writeFailureResponse(w, r, http.StatusForbidden, err.Error())
+	default:
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+	}
+}
+
+// createChangeRequest stores the issuance policy in YAML in the body as a
+// proposed version and opens a request to activate it.
+func (state *RuntimeState) createChangeRequest(w http.ResponseWriter,
+	r *http.Request, authUser string) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body,
+		maxPolicyBodySize))
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	policy, err := parseIssuancePolicy(data)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid policy: "+err.Error())
+		return
+	}
+	if err := state.checkIssuancePolicy(policy); err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	data, err = yaml.Marshal(policy)
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	base := state.activePolicyVersion()
+	if fmt.Sprintf("%x", sha256.Sum256(data)) == base.SHA256 {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Proposed policy is already in force")
+		return
+	}
+	comment := r.URL.Query().Get("comment")
+	version, _, err := state.policyVersions.Add(data, policySourceProposed,
+		comment)
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	request, err := state.changeRequests.Create(authUser, comment, base.ID,
+		version.ID)
+	if err != nil {
+		state.writeChangeRequestError(w, r, err)
+		return
+	}
+	requestLogger(r).Printf("Change request %d to policy version %d created by %s",
+		request.ID, version.ID, authUser)
+	state.notifyChangeRequestApprovers(request)
+	state.writeChangeRequest(w, r, request)
+}
+
+// approveChangeRequest approves and activates a request.
+func (state *RuntimeState) approveChangeRequest(w http.ResponseWriter,
+	r *http.Request, id uint64, authUser string) {
+	request, err := state.approveChangeRequestVia(id, authUser, "")
+	if err != nil {
+		state.writeChangeRequestError(w, r, err)
+		return
+	}
+	state.writeChangeRequest(w, r, request)
+}
+
+// approveChangeRequestVia approves and activates a request for reviewer,
+// who reviewed it through the chat integration via if not empty.
+func (state *RuntimeState) approveChangeRequestVia(id uint64, reviewer,
+	via string) (changerequests.Request, error) {
+	pending, ok := state.changeRequests.Get(id)
+	if !ok {
+		return pending, changerequests.ErrNotFound
+	}
+	version, ok := state.policyVersions.Get(pending.ProposedVersion)
+	if !ok {
+		return pending, fmt.Errorf("missing policy version %d",
+			pending.ProposedVersion)
+	}
+	policy, err := parseIssuancePolicy(version.Policy)
+	if err != nil {
+		return pending, err
+	}
+	// Approvals are serialised so that each is checked against the policy
+	// in force.
+	state.Mutex.Lock()
+	base := state.activePolicyVersion()
+	request, err := state.changeRequests.ApproveVia(id, reviewer, via,
+		state.configPolicyVersion, func(request changerequests.Request) error {
+			if request.BaseVersion != base.ID {
+				return errStaleChangeRequest
+			}
+			return state.checkIssuancePolicy(policy)
+		})
+	if err == nil {
+		state.Config.setIssuancePolicy(policy)
+		state.recordActivePolicy()
+	}
+	state.Mutex.Unlock()
+	if err != nil {
+		return request, err
+	}
+	logger.Printf("Change request %d approved by %s%s, policy version %d is in force",
+		request.ID, reviewer, viaSuffix(via), version.ID)
+	return request, nil
+}
+
+// changeRequestsHandler serves the review of issuance policy changes to
+// administrators:
+//
+//	GET  /api/v0/changeRequests/            list requests
+//	POST /api/v0/changeRequests/?comment=   propose the policy in the body
+//	GET  /api/v0/changeRequests/<id>        show a request and its diff
+//	POST /api/v0/changeRequests/<id>/comment  add the body as a comment
+//	POST /api/v0/changeRequests/<id>/approve  approve and activate
+//	POST /api/v0/changeRequests/<id>/reject   reject or withdraw
+//
+// Changes require a U2F authenticated administrator and approval by another.
+func (state *RuntimeState) changeRequestsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authUser, loginLevel, err := state.checkAuth(w, r,
+		state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		requestLogger(r).Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	if !state.IsAdminUser(authUser) {
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Only administrators may review changes")
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if r.Method == "POST" && loginLevel&AuthTypeU2F == 0 {
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Administrators must U2F authenticate to review changes")
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, changeRequestsPath),
+		"/")
+	if parts[0] == "" {
+		if r.Method == "POST" {
+			state.createChangeRequest(w, r, authUser)
+			return
+		}
+		requests := []changerequests.Request{}
+		requests = append(requests, state.changeRequests.List()...)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(requests)
+		return
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || len(parts) > 2 {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	if len(parts) == 1 {
+		if r.Method != "GET" {
+			state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+			return
+		}
+		request, ok := state.changeRequests.Get(id)
+		if !ok {
+			state.writeChangeRequestError(w, r, changerequests.ErrNotFound)
+			return
+		}
+		state.writeChangeRequest(w, r, request)
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	var request changerequests.Request
+	switch parts[1] {
+	case "comment":
+		text, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body,
+			maxChangeRequestCommentSize))
+		if err != nil || len(strings.TrimSpace(string(text))) < 1 {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Missing comment")
+			return
+		}
+		request, err = state.changeRequests.AddComment(id, authUser,
+			string(text))
+		if err != nil {
+			state.writeChangeRequestError(w, r, err)
+			return
+		}
+	case "approve":
+		state.approveChangeRequest(w, r, id, authUser)
+		return
+	case "reject":
+		request, err = state.changeRequests.Reject(id, authUser)
+		if err != nil {
+			state.writeChangeRequestError(w, r, err)
+			return
+		}
+		requestLogger(r).Printf("Change request %d rejected by %s", id, authUser)
+	default:
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	state.writeChangeRequest(w, r, request)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/admincache"
+	"github.com/Symantec/keymaster/keymasterd/changerequests"
+	"github.com/Symantec/keymaster/keymasterd/policyversions"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+func doChangeRequest(t *testing.T, state *RuntimeState, user string,
+	method string, path string, body string,
+	expectedStatus int) changeRequestInfo {
+	cookieVal, err := state.setNewAuthCookie(nil, nil, user,
+		AuthTypePassword|AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(method, changeRequestsPath+path,
+		strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	rr, err := checkRequestHandlerCode(req, state.changeRequestsHandler,
+		expectedStatus)
+	if err != nil {
+		t.Fatalf("%s %s by %s: %s", method, path, user, err)
+	}
+	var info changeRequestInfo
+	if expectedStatus == http.StatusOK && path != "" {
+		if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return info
+}
+
+func TestChangeRequestsHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "changerequests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.policyVersions, err = policyversions.Open(dir + "/versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.changeRequests, err = changerequests.Open(dir + "/requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.isAdminCache = admincache.New(time.Minute)
+	state.Config.Base.AdminUsers = []string{"alice", "bob"}
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{
+		proto.AuthTypePassword}
+	state.Config.Base.AllowedAuthBackendsForCerts = []string{
+		proto.AuthTypePassword}
+	if err := state.recordConfigPolicyVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	doChangeRequest(t, state, "mallory", "GET", "", "", http.StatusForbidden)
+	doChangeRequest(t, state, "alice", "POST", "?comment=duo",
+		"duo_enabled: true\n", http.StatusBadRequest)
+	doChangeRequest(t, state, "alice", "POST", "",
+		"allowed_auth_backends_for_certs: [password]\n", http.StatusBadRequest)
+	policy := "allowed_auth_backends_for_certs: [U2F]\n"
+	doChangeRequest(t, state, "alice", "POST", "?comment=u2f", policy,
+		http.StatusOK)
+	info := doChangeRequest(t, state, "bob", "GET", "1", "", http.StatusOK)
+	if info.Author != "alice" || info.State != changerequests.StatePending {
+		t.Fatalf("unexpected request: %+v", info)
+	}
+	if len(info.FieldChanges) != 1 ||
+		info.FieldChanges[0].Field != "allowed_auth_backends_for_certs" {
+		t.Fatalf("unexpected diff: %+v", info.FieldChanges)
+	}
+	// A competing request from the same base.
+	doChangeRequest(t, state, "bob", "POST", "",
+		"allowed_auth_backends_for_certs: [password, U2F]\n", http.StatusOK)
+
+	doChangeRequest(t, state, "alice", "POST", "1/approve", "",
+		http.StatusForbidden)
+	doChangeRequest(t, state, "bob", "POST", "1/comment", "", http.StatusBadRequest)
+	info = doChangeRequest(t, state, "bob", "POST", "1/comment", "ok",
+		http.StatusOK)
+	if len(info.Comments) != 1 || info.Comments[0].Author != "bob" {
+		t.Fatalf("unexpected comments: %+v", info.Comments)
+	}
+	if !reflect.DeepEqual(state.Config.Base.AllowedAuthBackendsForCerts,
+		[]string{proto.AuthTypePassword}) {
+		t.Fatal("pending change should not be active")
+	}
+	info = doChangeRequest(t, state, "bob", "POST", "1/approve", "",
+		http.StatusOK)
+	if info.State != changerequests.StateApproved || info.Reviewer != "bob" {
+		t.Fatalf("unexpected approved request: %+v", info)
+	}
+	expected := []string{proto.AuthTypeU2F}
+	if !reflect.DeepEqual(state.Config.Base.AllowedAuthBackendsForCerts,
+		expected) {
+		t.Fatalf("approved change not active: %v",
+			state.Config.Base.AllowedAuthBackendsForCerts)
+	}
+	doChangeRequest(t, state, "bob", "POST", "1/reject", "", http.StatusConflict)
+	doChangeRequest(t, state, "alice", "POST", "2/approve", "",
+		http.StatusConflict)
+	doChangeRequest(t, state, "bob", "POST", "2/reject", "", http.StatusOK)
+	doChangeRequest(t, state, "bob", "GET", "3", "", http.StatusNotFound)
+
+	// The approval survives a restart with the same configuration file.
+	state.Config.Base.AllowedAuthBackendsForCerts = []string{
+		proto.AuthTypePassword}
+	if err := state.applyApprovedPolicyChange(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state.Config.Base.AllowedAuthBackendsForCerts,
+		expected) {
+		t.Fatal("approved change not applied at startup")
+	}
+	// But not a change to the configuration file.
+	state.Config.Base.AllowedAuthBackendsForCerts = []string{
+		proto.AuthTypeTOTP}
+	if err := state.recordConfigPolicyVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.applyApprovedPolicyChange(); err != nil {
+		t.Fatal(err)
+	}
+	if state.Config.Base.AllowedAuthBackendsForCerts[0] != proto.AuthTypeTOTP {
+		t.Fatal("configuration file should take precedence")
+	}
+	if active := state.activePolicyVersion(); active.ID !=
+		state.configPolicyVersion {
+		t.Fatalf("active version %d, expected %d", active.ID,
+			state.configPolicyVersion)
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/hostinventory"
+	"github.com/Symantec/keymaster/lib/authutil"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v2"
+)
+
+// Certificates expiring sooner than this are reported.
+const checkConfigCertExpiryWarning = 30 * 24 * time.Hour
+
+const (
+	configCheckOK   = "OK"
+	configCheckWarn = "WARN"
+	configCheckFail = "FAIL"
+)
+
+type configCheckResult struct {
+	Status  string
+	Name    string
+	Message string
+}
+
+// configReport collects the results of checkConfigFile. Checks never
+// contact other servers or change anything.
+type configReport struct {
+	results []configCheckResult
+}
+
+func (r *configReport) add(status, name, format string, args ...interface{}) {
+	r.results = append(r.results, configCheckResult{
+		Status:  status,
+		Name:    name,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *configReport) ok(name string) {
+	r.add(configCheckOK, name, "")
+}
+
+func (r *configReport) warn(name, format string, args ...interface{}) {
+	r.add(configCheckWarn, name, format, args...)
+}
+
+func (r *configReport) fail(name, format string, args ...interface{}) {
+	r.add(configCheckFail, name, format, args...)
+}
+
+// check records err as a failure of name, or name as passed.
+func (r *configReport) check(name string, err error) bool {
+	if err != nil {
+		r.fail(name, "%s", err)
+		return false
+	}
+	r.ok(name)
+	return true
+}
+
+func (r *configReport) failed() bool {
+	for _, result := range r.results {
+		if result.Status == configCheckFail {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *configReport) write(w io.Writer) {
+	var failures, warnings int
+	for _, result := range r.results {
+		if result.Message == "" {
+			fmt.Fprintf(w, "%-4s %s\n", result.Status, result.Name)
+		} else {
+			fmt.Fprintf(w, "%-4s %s: %s\n", result.Status, result.Name,
+				result.Message)
+		}
+		switch result.Status {
+		case configCheckFail:
+			failures++
+		case configCheckWarn:
+			warnings++
+		}
+	}
+	fmt.Fprintf(w, "%d checks, %d failed, %d warnings\n", len(r.results),
+		failures, warnings)
+}
+
+func readConfigCheckFile(filename string) ([]byte, error) {
+	if filename == "" {
+		return nil, errors.New("not set")
+	}
+	return ioutil.ReadFile(filename)
+}
+
+func (r *configReport) checkCertExpiry(name string, cert *x509.Certificate,
+	now time.Time) {
+	if now.After(cert.NotAfter) {
+		r.fail(name, "certificate expired at %s",
+			cert.NotAfter.Format(time.RFC3339))
+	} else if cert.NotAfter.Sub(now) < checkConfigCertExpiryWarning {
+		r.warn(name, "certificate expires at %s",
+			cert.NotAfter.Format(time.RFC3339))
+	}
+}
+
+func (r *configReport) checkTLSKeyPair(name, certFilename,
+	keyFilename string, now time.Time) {
+	certPEM, err := readConfigCheckFile(certFilename)
+	if !r.check(name+" certificate readable", err) {
+		return
+	}
+	keyPEM, err := readConfigCheckFile(keyFilename)
+	if !r.check(name+" key readable", err) {
+		return
+	}
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if !r.check(name+" key pair matches", err) {
+		return
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err == nil {
+		r.checkCertExpiry(name+" certificate", cert, now)
+	}
+}
+
+func (r *configReport) checkCA(config *AppConfigFile) {
+	caData, err := readConfigCheckFile(config.Base.SSHCAFilename)
+	if !r.check("ssh_ca_filename readable", err) {
+		return
+	}
+	signer, err := parseCAPrivateKey(caData,
+		[]byte(config.Base.SSHCAPassphrase))
+	if err == errCAPassphraseRequired {
+		r.warn("CA private key parses",
+			"encrypted, it is only checked when unsealed")
+		if config.Base.ClientCAFilename == "" {
+			r.fail("client_ca_filename",
+				"required to unseal an encrypted CA key without "+
+					"ssh_ca_passphrase")
+		}
+		return
+	}
+	if !r.check("CA private key parses", err) {
+		return
+	}
+	defer zeroizeSigner(signer)
+	if config.Base.X509CACertFilename != "" {
+		_, chain, err := loadX509CAChain(config.Base.X509CACertFilename,
+			signer)
+		if r.check("x509_ca_cert_filename matches the CA key", err) {
+			now := time.Now()
+			for _, der := range chain {
+				cert, err := x509.ParseCertificate(der)
+				if err == nil {
+					r.checkCertExpiry("X.509 CA "+cert.Subject.CommonName,
+						cert, now)
+				}
+			}
+		}
+	}
+	r.checkNextCA(config)
+}
+
+func (r *configReport) checkNextCA(config *AppConfigFile) {
+	rollover := config.CARollover
+	if rollover.NextCAFilename == "" {
+		return
+	}
+	caData, err := readConfigCheckFile(rollover.NextCAFilename)
+	if !r.check("next_ca_filename readable", err) {
+		return
+	}
+	signer, err := parseCAPrivateKey(caData,
+		[]byte(config.Base.SSHCAPassphrase))
+	if !r.check("next CA private key parses", err) {
+		return
+	}
+	defer zeroizeSigner(signer)
+	if rollover.NextX509CACertFilename != "" {
+		_, err := loadX509CACertDer(rollover.NextX509CACertFilename, signer)
+		r.check("next_x509_ca_cert_filename matches the next CA key", err)
+	}
+}
+
+func (r *configReport) checkPublicKeysFile(filename string) {
+	file, err := os.Open(filename)
+	if !r.check("keymaster_public_keys_filename readable", err) {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey(scanner.Bytes()); err != nil {
+			r.fail("keymaster_public_keys_filename", "line %d: %s",
+				lineNumber, err)
+			return
+		}
+	}
+	r.ok("keymaster_public_keys_filename keys parse")
+}
+
+func (r *configReport) checkLDAPURLs(name, urls string) {
+	for _, ldapURL := range strings.Split(urls, ",") {
+		if _, err := authutil.ParseLDAPURL(ldapURL); err != nil {
+			r.fail(name, "%s: %s", ldapURL, err)
+			return
+		}
+	}
+	r.ok(name + " valid")
+}
+
+func (r *configReport) checkSecretFile(name, filename string, minLength int) {
+	secret, err := readConfigCheckFile(filename)
+	if !r.check(name+" readable", err) {
+		return
+	}
+	if len(bytes.TrimSpace(secret)) < minLength {
+		r.fail(name, "shorter than %d bytes", minLength)
+	}
+}
+
+// checkEnvironment checks that the user running the check could write the
+// state of keymasterd and bind its ports, as is often not the case in a
+// container.
+func (r *configReport) checkEnvironment(config *AppConfigFile) {
+	dataDirectory := config.Base.DataDirectory
+	if dataDirectory == "" {
+		dataDirectory = defaultDataDirectory
+	}
+	r.check("data_directory writable", checkWritableDirectory(dataDirectory))
+	if config.Base.TemporaryDirectory != "" {
+		r.check("temporary_directory writable",
+			checkWritableDirectory(config.Base.TemporaryDirectory))
+	} else if err := checkWritableDirectory(os.TempDir()); err != nil {
+		r.warn("temporary directory", "%s, %s will be used", err,
+			filepath.Join(dataDirectory, temporaryDirectoryName))
+	}
+	if filename := config.Base.AdminSocketFilename; filename != "" {
+		r.check("admin_socket_filename directory writable",
+			checkWritableDirectory(filepath.Dir(filename)))
+	}
+	for _, address := range []struct {
+		name, address string
+	}{
+		{"http_address", config.Base.HttpAddress},
+		{"admin_address", config.Base.AdminAddress},
+	} {
+		if address.address != "" {
+			r.check(address.name+" bindable", checkBindAddress(address.address))
+		}
+	}
+}
+
+// checkPolicy reports settings which are silently ignored or which deny
+// everybody.
+func (r *configReport) checkPolicy(config *AppConfigFile) {
+	enabled := map[string]bool{
+		proto.AuthTypePassword:    true,
+		proto.AuthTypeU2F:         true,
+		proto.AuthTypeFederated:   config.Oauth2.Enabled,
+		proto.AuthTypeSymantecVIP: config.SymantecVIP.Enabled,
+		proto.AuthTypeTOTP:        config.Base.EnableLocalTOTP,
+		proto.AuthTypeDuo:         config.Duo.Enabled,
+		proto.AuthTypeBackupCode:  config.Base.BackupCodes.Enabled,
+	}
+	consistent := true
+	for _, setting := range []struct {
+		name     string
+		backends []string
+	}{
+		{"allowed_auth_backends_for_certs",
+			config.Base.AllowedAuthBackendsForCerts},
+		{"allowed_auth_backends_for_webui",
+			config.Base.AllowedAuthBackendsForWebUI},
+	} {
+		for _, backend := range setting.backends {
+			isEnabled, known := enabled[backend]
+			if !known {
+				r.fail(setting.name, "unknown backend %q", backend)
+				consistent = false
+			} else if !isEnabled {
+				r.warn(setting.name, "%s is listed but not enabled", backend)
+				consistent = false
+			}
+		}
+	}
+	hasGroups := config.UserInfo.Ldap.LDAPTargetURLs != ""
+	for _, setting := range []struct {
+		name   string
+		groups []string
+	}{
+		{"admin_groups", config.Base.AdminGroups},
+		{"automation_user_groups", config.Base.AutomationUserGroups},
+		{"duo enforce_groups", config.Duo.EnforceGroups},
+	} {
+		if len(setting.groups) > 0 && !hasGroups {
+			r.warn(setting.name,
+				"groups are set but userinfo_sources has no LDAP directory")
+			consistent = false
+		}
+	}
+	if len(config.Duo.EnforceGroups) > 0 && !config.Duo.Enabled {
+		r.warn("duo enforce_groups", "Duo is not enabled")
+		consistent = false
+	}
+	if config.Base.HideStandardLogin && !config.Oauth2.Enabled {
+		r.fail("hide_standard_login", "requires oauth2 to be enabled")
+		consistent = false
+	}
+	if config.PasswordPolicy.LDAPPolicyDN != "" &&
+		config.Ldap.LDAPTargetURLs == "" {
+		r.fail("password_policy ldap_policy_dn",
+			"requires ldap ldap_target_urls")
+		consistent = false
+	}
+	if config.TrustCoverage.MinCoverage < 0 ||
+		config.TrustCoverage.MinCoverage > 1 {
+		r.fail("trust_coverage min_coverage", "must be between 0 and 1")
+		consistent = false
+	}
+	if consistent {
+		r.ok("issuance policy consistent")
+	}
+}
+
+// checkConfigFile validates the configuration in filename without starting
+// anything.
+func checkConfigFile(filename string) *configReport {
+	report := &configReport{}
+	now := time.Now()
+	source, err := ioutil.ReadFile(filename)
+	if !report.check("configuration readable", err) {
+		return report
+	}
+	var config AppConfigFile
+	tree, err := parseAppConfigWithOverrides(source, &config, os.Environ())
+	if !report.check("configuration parses", err) {
+		return report
+	}
+	// Without the *_file keys, which are not fields.
+	if source, err := yaml.Marshal(tree); err == nil {
+		var strictConfig AppConfigFile
+		if err := yaml.UnmarshalStrict(source, &strictConfig); err != nil {
+			report.warn("configuration keys", "%s", err)
+		}
+	}
+
+	config.ACME.setDefaultFilenames(&config.Base)
+	if _, err := os.Stat(config.Base.TLSCertFilename); config.ACME.enabled() &&
+		os.IsNotExist(err) {
+		report.warn("TLS certificate", "not obtained with ACME yet")
+	} else if config.Base.TLSKeyPKCS11.ModulePath == "" {
+		report.checkTLSKeyPair("TLS", config.Base.TLSCertFilename,
+			config.Base.TLSKeyFilename, now)
+	} else {
+		certs, err := readCertificates(config.Base.TLSCertFilename)
+		if report.check("TLS certificate readable", err) {
+			report.checkCertExpiry("TLS certificate", certs[0], now)
+		}
+		report.warn("TLS key", "in a PKCS#11 token, not checked")
+	}
+	report.checkCA(&config)
+	if config.Base.ClientCAFilename != "" {
+		buffer, err := readConfigCheckFile(config.Base.ClientCAFilename)
+		if report.check("client_ca_filename readable", err) &&
+			!x509.NewCertPool().AppendCertsFromPEM(buffer) {
+			report.fail("client_ca_filename", "no certificates")
+		}
+	}
+	if config.Base.KeymasterPublicKeysFilename != "" {
+		report.checkPublicKeysFile(config.Base.KeymasterPublicKeysFilename)
+	}
+	for _, directory := range []struct {
+		name, path string
+	}{
+		{"data_directory", config.Base.DataDirectory},
+		{"customization templates", filepath.Join(
+			config.Base.SharedDataDirectory, "customization_data",
+			"templates")},
+	} {
+		fi, err := os.Stat(directory.path)
+		if err == nil && !fi.IsDir() {
+			err = errors.New("not a directory")
+		}
+		report.check(directory.name+" exists", err)
+	}
+	report.checkEnvironment(&config)
+
+	if config.Ldap.LDAPTargetURLs != "" {
+		report.checkLDAPURLs("ldap ldap_target_urls", config.Ldap.LDAPTargetURLs)
+		if config.Ldap.UserSearchFilter == "" &&
+			!strings.Contains(config.Ldap.BindPattern, "%s") {
+			report.fail("ldap bind_pattern", "must contain %%s")
+		}
+	}
+	if config.UserInfo.Ldap.LDAPTargetURLs != "" {
+		report.checkLDAPURLs("userinfo_sources ldap_target_urls",
+			config.UserInfo.Ldap.LDAPTargetURLs)
+	}
+	report.check("userinfo_sources nested_groups",
+		authutil.CheckNestedGroupsMode(config.UserInfo.Ldap.NestedGroups))
+	for _, referrals := range []struct {
+		name   string
+		config LDAPReferralConfig
+	}{
+		{"ldap referrals", config.Ldap.Referrals},
+		{"userinfo_sources referrals", config.UserInfo.Ldap.Referrals},
+	} {
+		report.check(referrals.name, authutil.CheckLDAPReferralPolicy(
+			referrals.config.referralPolicy("")))
+	}
+	if config.SSHGroupClaims.Enabled {
+		_, err := newSSHGroupClaimPolicy(&config)
+		report.check("ssh_group_claims", err)
+	}
+	if config.Base.HtpasswdFilename != "" {
+		_, err := readConfigCheckFile(config.Base.HtpasswdFilename)
+		report.check("htpasswd_filename readable", err)
+	}
+	report.check("password backends", checkPasswordBackends(&config))
+	if len(config.Radius.ServerAddresses) > 0 {
+		report.checkSecretFile("radius shared_secret_filename",
+			config.Radius.SharedSecretFilename, 1)
+	}
+	if config.SymantecVIP.Enabled {
+		report.checkTLSKeyPair("SymantecVIP", config.SymantecVIP.CertFile,
+			config.SymantecVIP.KeyFile, now)
+	}
+	if config.Duo.Enabled && (config.Duo.APIHost == "" ||
+		config.Duo.IntegrationKey == "" || config.Duo.SecretKey == "") {
+		report.fail("duo", "api_host, integration_key and secret_key are required")
+	}
+	for _, proxyConfig := range config.SatelliteProxies {
+		if proxyConfig.ProxyID == "" {
+			report.fail("satellite_proxies", "proxy with no proxy_id")
+			continue
+		}
+		report.checkSecretFile("satellite proxy "+proxyConfig.ProxyID+
+			" shared_secret_filename", proxyConfig.SharedSecretFilename, 16)
+	}
+	report.check("notifications", config.Notifications.check())
+	report.check("syslog", config.Syslog.check())
+	report.check("logging", config.Logging.check())
+	report.check("tracing", config.Tracing.check())
+	report.check("auth_failure_log", config.AuthFailureLog.check())
+	report.check("acme", config.ACME.check(config.Base))
+	for _, webhook := range config.Notifications.Webhooks {
+		report.checkSecretFile("notification webhook "+webhook.URL+
+			" secret_filename", webhook.SecretFilename, minWebhookSecretLength)
+	}
+	if config.HostInventory.Filename != "" {
+		_, err := hostinventory.Load(config.HostInventory.Filename)
+		report.check("host_inventory loads", err)
+	}
+	report.check("session_binding", config.Base.SessionBinding.check())
+	report.check("key_policy", config.KeyPolicy.check())
+	report.check("delegation", config.Delegation.check())
+	report.check("roles", checkRoles(config.Roles, config.Base.AdminUsers))
+	if len(config.CIIssuance.Providers) > 0 {
+		_, err := newCIIssuers(config.CIIssuance)
+		report.check("ci_issuance", err)
+	}
+	if directory := config.Bootstrap.ClientBinariesDirectory; directory != "" {
+		binaries, err := newClientBinaryIndex(directory).list()
+		if report.check("bootstrap client_binaries_directory", err) &&
+			len(binaries) < 1 {
+			report.warn("bootstrap client_binaries_directory",
+				"no keymaster-<os>-<arch> binaries")
+		}
+	}
+	if len(config.Approvals.Integrations) > 0 {
+		_, err := newApprovalIntegrations(config.Approvals)
+		report.check("approvals", err)
+	}
+	if config.FaultInjection.Enabled {
+		report.warn("fault_injection", "enabled, faults can be injected "+
+			"through the admin socket")
+	}
+	_, err = (&RuntimeState{Config: config}).newSSHPublicKeySources()
+	report.check("ssh_public_key_source", err)
+	if config.DNSPublication.Backend != "" {
+		hostIdentity := config.Base.HostIdentity
+		if hostIdentity == "" {
+			hostIdentity, _ = getHostIdentity()
+		}
+		_, err := dnsPublicationInstance(&config, hostIdentity)
+		if err == nil {
+			_, err = newDNSPublicationBackend(&config.DNSPublication)
+		}
+		report.check("dns_publication", err)
+	}
+	report.checkPolicy(&config)
+	return report
+}
+
+// runCheckConfig implements -checkConfig and returns the exit code.
+func runCheckConfig(filename string, w io.Writer) int {
+	report := checkConfigFile(filename)
+	report.write(w)
+	if report.failed() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/yaml.v2"
+)
+
+func TestCheckConfigFile(t *testing.T) {
+	sharedDataDirectory, err := ioutil.TempDir("", "keymaster-shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sharedDataDirectory)
+	err = os.MkdirAll(filepath.Join(sharedDataDirectory, "customization_data",
+		"templates"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := setupDemoEnvironment(sharedDataDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.remove()
+	var output bytes.Buffer
+	if code := runCheckConfig(env.configFilename, &output); code != 0 {
+		t.Fatalf("demo configuration rejected:\n%s", output.String())
+	}
+	if !strings.Contains(output.String(), "OK   TLS key pair matches") {
+		t.Fatalf("missing TLS check:\n%s", output.String())
+	}
+
+	config, err := readAppConfigFile(env.configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Ldap.LDAPTargetURLs = "ldaps://ldap.example.com:636,http://bad"
+	config.Ldap.BindPattern = "uid=nobody"
+	config.Base.AllowedAuthBackendsForCerts = []string{proto.AuthTypePassword,
+		"Kerberos"}
+	config.Base.AllowedAuthBackendsForWebUI = []string{proto.AuthTypeTOTP}
+	config.Base.AdminGroups = []string{"admins"}
+	configText, err := yaml.Marshal(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(env.configFilename, configText, 0600); err != nil {
+		t.Fatal(err)
+	}
+	output.Reset()
+	if code := runCheckConfig(env.configFilename, &output); code == 0 {
+		t.Fatalf("broken configuration accepted:\n%s", output.String())
+	}
+	for _, expected := range []string{
+		"FAIL ldap ldap_target_urls: http://bad",
+		"FAIL ldap bind_pattern",
+		`FAIL allowed_auth_backends_for_certs: unknown backend "Kerberos"`,
+		"WARN allowed_auth_backends_for_webui: TOTP is listed but not enabled",
+		"WARN admin_groups",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("missing %q in:\n%s", expected, output.String())
+		}
+	}
+
+	output.Reset()
+	if code := runCheckConfig(filepath.Join(env.directory, "missing.yml"),
+		&output); code == 0 {
+		t.Fatal("missing configuration accepted")
+	}
+}
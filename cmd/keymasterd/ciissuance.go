@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+	"github.com/Symantec/keymaster/keymasterd/citoken"
+	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CI systems exchange the OpenID Connect token of a job for a short lived
+// SSH certificate at ciCertPath + provider name.
+const ciCertPath = "/api/v0/ciCert/"
+
+const (
+	defaultCIMaxDuration = 10 * time.Minute
+	maxCIMaxDuration     = time.Hour
+	ciAuthMethod         = "ci_oidc"
+)
+
+var (
+	ciProviderNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	ciPipelineIDRegexp   = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+	ciIssuanceCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keymaster_ci_issuance_counter",
+			Help: "CI certificate requests by provider and result.",
+		},
+		[]string{"provider", "result"},
+	)
+)
+
+type CIProviderConfig struct {
+	Name     string `yaml:"name"`
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// Defaults to the jwks_uri of the OpenID configuration of the issuer.
+	JWKSURL string `yaml:"jwks_url"`
+	// The claim with the pipeline ID, e.g. pipeline_id for GitLab or run_id
+	// for GitHub Actions.
+	PipelineClaim string `yaml:"pipeline_claim"`
+	// path.Match patterns of the token subjects allowed.
+	AllowedSubjects []string `yaml:"allowed_subjects"`
+	// Added to the ci-<name>-<pipeline ID> principal.
+	Principals []string `yaml:"principals"`
+	// At least one of force_command and source_addresses is required.
+	ForceCommand    string   `yaml:"force_command"`
+	SourceAddresses []string `yaml:"source_addresses"`
+	// Default: none, not even permit-pty.
+	Extensions []string `yaml:"extensions"`
+	// Default: 600, at most 3600.
+	MaxDurationSecs uint `yaml:"max_duration_secs"`
+}
+
+type CIIssuanceConfig struct {
+	Providers []CIProviderConfig `yaml:"providers"`
+}
+
+type ciIssuer struct {
+	config   CIProviderConfig
+	verifier *citoken.Verifier
+}
+
+func (config *CIProviderConfig) maxDuration() time.Duration {
+	if config.MaxDurationSecs == 0 {
+		return defaultCIMaxDuration
+	}
+	return time.Duration(config.MaxDurationSecs) * time.Second
+}
+
+func (config *CIProviderConfig) check() error {
+	if !ciProviderNameRegexp.MatchString(config.Name) {
+		return fmt.Errorf("bad name: %q", config.Name)
+	}
+	if config.PipelineClaim == "" {
+		return errors.New("no pipeline_claim")
+	}
+	if len(config.AllowedSubjects) < 1 {
+		return errors.New("no allowed_subjects")
+	}
+	for _, pattern := range config.AllowedSubjects {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad allowed_subjects pattern: %q", pattern)
+		}
+	}
+	if config.ForceCommand == "" && len(config.SourceAddresses) < 1 {
+		return errors.New("neither force_command nor source_addresses")
+	}
+	for _, address := range config.SourceAddresses {
+		if _, _, err := net.ParseCIDR(address); err != nil &&
+			net.ParseIP(address) == nil {
+			return fmt.Errorf("bad source_addresses entry: %q", address)
+		}
+	}
+	if config.maxDuration() > maxCIMaxDuration {
+		return fmt.Errorf("max_duration_secs above %d",
+			int(maxCIMaxDuration.Seconds()))
+	}
+	return nil
+}
+
+// newCIIssuers checks the CI providers and returns them by name.
+func newCIIssuers(config CIIssuanceConfig) (map[string]*ciIssuer, error) {
+	issuers := make(map[string]*ciIssuer, len(config.Providers))
+	for _, providerConfig := range config.Providers {
+		if err := providerConfig.check(); err != nil {
+			return nil, fmt.Errorf("ci_issuance: %s", err)
+		}
+		if _, ok := issuers[providerConfig.Name]; ok {
+			return nil, fmt.Errorf("ci_issuance: duplicate provider %s",
+				providerConfig.Name)
+		}
+		verifier, err := citoken.New(citoken.Config{
+			Issuer:   providerConfig.Issuer,
+			Audience: providerConfig.Audience,
+			JWKSURL:  providerConfig.JWKSURL,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ci_issuance: %s: %s", providerConfig.Name,
+				err)
+		}
+		issuers[providerConfig.Name] = &ciIssuer{providerConfig, verifier}
+	}
+	return issuers, nil
+}
+
+func (issuer *ciIssuer) subjectAllowed(subject string) bool {
+	for _, pattern := range issuer.config.AllowedSubjects {
+		if matched, _ := path.Match(pattern, subject); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// certOptions returns the principals and restrictions of the certificate
+// of pipelineID.
+func (issuer *ciIssuer) certOptions(
+	pipelineID string) certgen.SSHCertOptions {
+	config := issuer.config
+	principal := "ci-" + config.Name + "-" + pipelineID
+	options := certgen.SSHCertOptions{
+		Principals:      append([]string{principal}, config.Principals...),
+		Extensions:      make(map[string]string),
+		CriticalOptions: make(map[string]string),
+	}
+	for _, extension := range config.Extensions {
+		options.Extensions[extension] = ""
+	}
+	if config.ForceCommand != "" {
+		options.CriticalOptions["force-command"] = config.ForceCommand
+	}
+	if len(config.SourceAddresses) > 0 {
+		options.CriticalOptions["source-address"] =
+			strings.Join(config.SourceAddresses, ",")
+	}
+	return options
+}
+
+func metricLogCIIssuance(provider, result string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	ciIssuanceCounter.WithLabelValues(provider, result).Inc()
+}
+
+func getBearerToken(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < 7 ||
+		!strings.EqualFold(authorization[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(authorization[7:])
+}
+
+func (state *RuntimeState) ciCertHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	providerName := r.URL.Path[len(ciCertPath):]
+	issuer, ok := state.ciIssuers[providerName]
+	if !ok {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	fail := func(code int, result, message string, err error) {
+		requestLogger(r).Printf("CI certificate request for %s denied: %s: %v",
+			providerName, result, err)
+		metricLogCIIssuance(providerName, result)
+		state.writeFailureResponse(w, r, code, message)
+	}
+	token := getBearerToken(r)
+	if token == "" {
+		fail(http.StatusUnauthorized, "no_token", "No bearer token",
+			errors.New("no bearer token"))
+		return
+	}
+	claims, err := issuer.verifier.Verify(token)
+	if err != nil {
+		fail(http.StatusUnauthorized, "bad_token", "Invalid CI token", err)
+		return
+	}
+	if !issuer.subjectAllowed(claims.Subject) {
+		fail(http.StatusForbidden, "subject_denied", "Subject not allowed",
+			fmt.Errorf("subject %q", claims.Subject))
+		return
+	}
+	pipelineID, ok := claims.StringClaim(issuer.config.PipelineClaim)
+	if !ok || !ciPipelineIDRegexp.MatchString(pipelineID) {
+		fail(http.StatusForbidden, "bad_pipeline", "No usable pipeline ID",
+			fmt.Errorf("%s claim %q", issuer.config.PipelineClaim, pipelineID))
+		return
+	}
+	if isJSONRequest(r) {
+		err = parseJSONCertRequest(r)
+	} else if r, err = parseUploadForm(r); err == http.ErrNotMultipart {
+		err = nil
+	}
+	if err == errUploadTooLarge {
+		fail(http.StatusRequestEntityTooLarge, "bad_request",
+			"Upload too large", err)
+		return
+	}
+	if err != nil {
+		fail(http.StatusBadRequest, "bad_request", "Error parsing form", err)
+		return
+	}
+	pubKeyData, err := getPublicKeyDataFromForm(r)
+	if err != nil {
+		fail(http.StatusBadRequest, "bad_request", "Missing public key", err)
+		return
+	}
+	userPubKeys := splitSSHPublicKeys(nil, string(pubKeyData))
+	if len(userPubKeys) != 1 {
+		fail(http.StatusBadRequest, "bad_request",
+			"Exactly one public key required",
+			fmt.Errorf("%d keys", len(userPubKeys)))
+		return
+	}
+	if _, err := state.Config.KeyPolicy.checkSSHPublicKey(
+		userPubKeys[0]); err != nil {
+		requestLogger(r).Printf("CI certificate request for %s denied: %s",
+			providerName, err)
+		metricLogCIIssuance(providerName, "key_rejected")
+		state.writeKeyPolicyError(w, r, err)
+		return
+	}
+	duration := issuer.config.maxDuration()
+	if formDuration := r.Form.Get("duration"); formDuration != "" {
+		requested, err := time.ParseDuration(formDuration)
+		if err != nil || requested <= 0 || requested > duration {
+			fail(http.StatusBadRequest, "bad_request",
+				fmt.Sprintf("Duration must be at most %s", duration), err)
+			return
+		}
+		duration = requested
+	}
+	state.Mutex.Lock()
+	keySigner := state.Signer
+	state.Mutex.Unlock()
+	if keySigner == nil {
+		fail(http.StatusInternalServerError, "error", "",
+			errors.New("signer not loaded"))
+		return
+	}
+	if err := state.injectFault(faultTargetSigning); err != nil {
+		fail(http.StatusInternalServerError, "error", "", err)
+		return
+	}
+	state.signCICert(w, r, issuer, claims, pipelineID, userPubKeys[0],
+		keySigner, duration)
+}
+
+func (state *RuntimeState) signCICert(w http.ResponseWriter, r *http.Request,
+	issuer *ciIssuer, claims *citoken.Claims, pipelineID string,
+	userPubKey string, keySigner crypto.Signer, duration time.Duration) {
+	providerName := issuer.config.Name
+	signer, err := certgen.NewSSHCASigner(keySigner,
+		state.Config.Base.SSHRSASignatureAlgorithm)
+	if err != nil {
+		requestLogger(r).Errorf("Signer failed to load: %s", err)
+		metricLogCIIssuance(providerName, "error")
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	options := issuer.certOptions(pipelineID)
+	principal := options.Principals[0]
+	err = state.setSSHCertIdentityForMethod(r, principal, ciAuthMethod,
+		&options)
+	if err != nil {
+		requestLogger(r).Errorf("Cannot set the identity of a CI certificate: %s", err)
+		metricLogCIIssuance(providerName, "error")
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if err := state.checkSSHSigner(signer); err != nil {
+		requestLogger(r).Printf("%s", err)
+		metricLogCIIssuance(providerName, "error")
+		state.writeFailureResponse(w, r, http.StatusServiceUnavailable, "")
+		return
+	}
+	signingSpan := startSigningSpan(r, "ssh")
+	cert, certBytes, err := certgen.GenSSHCertFileStringWithOptions(
+		principal, userPubKey, signer, state.HostIdentity, duration, options)
+	endSpan(signingSpan, err)
+	if err == nil {
+		err = state.lintIssuedSSHCertForPrincipals(principal,
+			options.Principals, cert, signer.PublicKey(), duration)
+	}
+	if err != nil {
+		requestLogger(r).Errorf("Cannot sign CI certificate for %s: %s", principal, err)
+		metricLogCIIssuance(providerName, "error")
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	now := time.Now()
+	err = state.recordAuditEvent(attestation.Event{
+		Type:         attestation.EventIssued,
+		Time:         now,
+		Username:     principal,
+		Policy:       "ssh",
+		AuthMethods:  []string{ciAuthMethod},
+		Automation:   true,
+		DurationSecs: int64(duration.Seconds()),
+		KeyType:      describeSSHCertKey(certBytes),
+		CAKey:        state.caKeyFingerprint(),
+		KeyID:        options.KeyID,
+		Serial:       strconv.FormatUint(options.Serial, 10),
+		CIProvider:   providerName,
+		CIPipeline:   pipelineID,
+		CISubject:    claims.Subject,
+		RequestID:    requestID(r),
+	})
+	if err != nil {
+		requestLogger(r).Errorf("cannot record issuance attestation: %s", err)
+	}
+	eventNotifier.PublishSSH(certBytes)
+	metricLogCertDuration("ssh", "granted", float64(duration.Seconds()))
+	metricLogCIIssuance(providerName, "granted")
+	requestLogger(r).Printf("Generated CI SSH certificate for %s (subject %q, "+
+		"principals %v, valid %s)", principal, claims.Subject,
+		options.Principals, duration)
+	w.Header().Set("Content-Disposition",
+		`attachment; filename="id_rsa-cert.pub"`)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", cert)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+	"github.com/Symantec/keymaster/keymasterd/citoken"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+var testCIProvider = CIProviderConfig{
+	Name:            "gitlab",
+	Audience:        "keymaster",
+	PipelineClaim:   "pipeline_id",
+	AllowedSubjects: []string{"project_path:infra/*:ref_type:branch:ref:main"},
+	Principals:      []string{"deploy"},
+	ForceCommand:    "/usr/local/bin/deploy",
+	SourceAddresses: []string{"10.1.0.0/16"},
+}
+
+func TestCIProviderConfig(t *testing.T) {
+	config := testCIProvider
+	if err := config.check(); err != nil {
+		t.Fatal(err)
+	}
+	for name, modify := range map[string]func(*CIProviderConfig){
+		"no restriction": func(c *CIProviderConfig) {
+			c.ForceCommand = ""
+			c.SourceAddresses = nil
+		},
+		"bad address":   func(c *CIProviderConfig) { c.SourceAddresses = []string{"10.1/16"} },
+		"no subjects":   func(c *CIProviderConfig) { c.AllowedSubjects = nil },
+		"long duration": func(c *CIProviderConfig) { c.MaxDurationSecs = 7200 },
+		"bad name":      func(c *CIProviderConfig) { c.Name = "Git Lab" },
+	} {
+		config := testCIProvider
+		modify(&config)
+		if err := config.check(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestCICertHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "ciissuance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.attestationLog, err = attestation.Open(filepath.Join(dir,
+		attestationLogFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksServer := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+				Keys: []jose.JSONWebKey{{Key: &tokenKey.PublicKey,
+					KeyID: "1", Algorithm: "ES256"}}})
+		}))
+	defer jwksServer.Close()
+	provider := testCIProvider
+	provider.Issuer = jwksServer.URL
+	verifier, err := citoken.New(citoken.Config{
+		Issuer:     provider.Issuer,
+		Audience:   provider.Audience,
+		JWKSURL:    jwksServer.URL + "/jwks",
+		HTTPClient: jwksServer.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.ciIssuers = map[string]*ciIssuer{"gitlab": {provider, verifier}}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256,
+		Key: jose.JSONWebKey{Key: tokenKey, KeyID: "1"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken := func(id, subject string) string {
+		now := time.Now()
+		token, err := jwt.Signed(signer).Claims(jwt.Claims{
+			Issuer:   provider.Issuer,
+			Subject:  subject,
+			Audience: jwt.Audience{"keymaster"},
+			ID:       id,
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		}).Claims(map[string]interface{}{"pipeline_id": 4711}).
+			CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	request := func(path, token, duration string,
+		expectedStatus int) *httptest.ResponseRecorder {
+		body, err := json.Marshal(proto.CertRequest{
+			PublicKey: testUserSSHPublicKey, Duration: duration})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr, err := checkRequestHandlerCode(req, state.ciCertHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr
+	}
+	mainSubject := "project_path:infra/web:ref_type:branch:ref:main"
+	token := newToken("1", mainSubject)
+	rr := request(ciCertPath+"gitlab", token, "", http.StatusOK)
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := pubKey.(*ssh.Certificate)
+	if len(cert.ValidPrincipals) != 2 ||
+		cert.ValidPrincipals[0] != "ci-gitlab-4711" ||
+		cert.ValidPrincipals[1] != "deploy" {
+		t.Errorf("principals: %v", cert.ValidPrincipals)
+	}
+	if cert.CriticalOptions["force-command"] != "/usr/local/bin/deploy" ||
+		cert.CriticalOptions["source-address"] != "10.1.0.0/16" ||
+		len(cert.Extensions) != 0 {
+		t.Errorf("permissions: %+v", cert.Permissions)
+	}
+	if lifetime := cert.ValidBefore - cert.ValidAfter; lifetime > 600 {
+		t.Errorf("valid for %d seconds", lifetime)
+	}
+	events, err := state.attestationLog.Events(time.Time{},
+		time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].CIPipeline != "4711" ||
+		events[0].CIProvider != "gitlab" || !events[0].Automation {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	if cert.KeyId != state.HostIdentity+"_ci-gitlab-4711" ||
+		events[0].KeyID != cert.KeyId ||
+		events[0].Serial != strconv.FormatUint(cert.Serial, 10) {
+		t.Errorf("key ID %q serial %d, recorded %q %q", cert.KeyId,
+			cert.Serial, events[0].KeyID, events[0].Serial)
+	}
+
+	// Replayed token.
+	request(ciCertPath+"gitlab", token, "", http.StatusUnauthorized)
+	request(ciCertPath+"gitlab", newToken("2",
+		"project_path:infra/web:ref_type:branch:ref:feature"), "",
+		http.StatusForbidden)
+	request(ciCertPath+"gitlab", newToken("3", mainSubject), "1h",
+		http.StatusBadRequest)
+	request(ciCertPath+"gitlab", "", "", http.StatusUnauthorized)
+	request(ciCertPath+"github", newToken("4", mainSubject), "",
+		http.StatusNotFound)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/cluster"
+	"github.com/Symantec/keymaster/keymasterd/lifecycle"
+	"github.com/Symantec/keymaster/keymasterd/loginthrottle"
+	"github.com/Symantec/keymaster/keymasterd/revocationlist"
+	"github.com/Symantec/keymaster/keymasterd/sessions"
+	"github.com/Symantec/keymaster/lib/certgen"
+)
+
+const (
+	clusterComponentName       = "cluster"
+	defaultClusterIntervalSecs = 15
+	// Instances which missed this many syncs are no longer members.
+	clusterTTLIntervalMultiple = 4
+	// Revocations are shared for longer than any certificate is valid.
+	clusterRevocationLifetime = 10 * 365 * 24 * time.Hour
+)
+
+// Kinds of the states each instance publishes.
+const (
+	clusterStateLoginThrottle = iota
+	clusterStateRateLimits
+)
+
+// Kinds of the records shared by all the instances.
+const (
+	clusterRecordRevocation = iota
+	clusterRecordSession
+)
+
+type ClusterConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// InstanceID defaults to the host name.
+	InstanceID   string `yaml:"instance_id"`
+	IntervalSecs uint   `yaml:"interval_secs"`
+}
+
+// clusterRateLimits are the counts of the rate limiters of an instance.
+type clusterRateLimits struct {
+	CAFingerprints addressRateLimitCounts
+}
+
+// clusterStatus is the outcome of the last sync.
+type clusterStatus struct {
+	mutex    sync.Mutex
+	lastSync time.Time // Protected by mutex.
+	lastErr  error     // Protected by mutex.
+}
+
+func (config ClusterConfig) check(storage ProfileStorageConfig) error {
+	if !config.Enabled {
+		return nil
+	}
+	if storage.StorageUrl == "" ||
+		strings.HasPrefix(storage.StorageUrl, "sqlite:") {
+		return errors.New("cluster: needs a shared profile storage_url")
+	}
+	if storage.StorageUrl == "file:" {
+		return errors.New(
+			"cluster: the profile directory must be shared, not in the data directory")
+	}
+	return nil
+}
+
+func (config ClusterConfig) interval() time.Duration {
+	if config.IntervalSecs < 1 {
+		return defaultClusterIntervalSecs * time.Second
+	}
+	return time.Duration(config.IntervalSecs) * time.Second
+}
+
+func (config ClusterConfig) instanceID() (string, error) {
+	if config.InstanceID != "" {
+		return config.InstanceID, nil
+	}
+	return os.Hostname()
+}
+
+// newSSHCertSerial returns the serial of an SSH certificate issued at t,
+// allocated by node in the cluster if there is one.
+func (state *RuntimeState) newSSHCertSerial(t time.Time) (uint64, error) {
+	if state.cluster == nil {
+		return certgen.NewSSHCertSerial(t)
+	}
+	return state.cluster.NewSSHCertSerial(t)
+}
+
+// shareRevocation makes entry known to the other instances.
+func (state *RuntimeState) shareRevocation(entry revocationlist.Entry) error {
+	if state.cluster == nil {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return state.cluster.Put(clusterRecordRevocation, entry.Serial, data,
+		entry.Time.Add(clusterRevocationLifetime))
+}
+
+// shareSession makes the session id, as recorded by this instance, known to
+// the other instances.
+func (state *RuntimeState) shareSession(id string) error {
+	if state.cluster == nil {
+		return nil
+	}
+	session, ok := state.sessions.Get(id)
+	if !ok {
+		return fmt.Errorf("unknown session %s", id)
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return state.cluster.Put(clusterRecordSession, id, data, session.Expires)
+}
+
+// lookupClusterSession adds the session id from the shared records, if
+// another instance created it.
+func (state *RuntimeState) lookupClusterSession(id string) error {
+	if state.cluster == nil {
+		return nil
+	}
+	data, ok, err := state.cluster.Get(clusterRecordSession, id, time.Now())
+	if err != nil || !ok {
+		return err
+	}
+	var session sessions.Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return err
+	}
+	_, err = state.sessions.Add(session)
+	return err
+}
+
+// syncCluster publishes the state of this instance and merges the state of
+// the others.
+func (state *RuntimeState) syncCluster(now time.Time) error {
+	throttleCounts, err := json.Marshal(state.loginThrottle.Counts())
+	if err != nil {
+		return err
+	}
+	rateLimits, err := json.Marshal(clusterRateLimits{
+		CAFingerprints: state.caFingerprintsLimiter.export(now),
+	})
+	if err != nil {
+		return err
+	}
+	view, err := state.cluster.Sync(map[int][]byte{
+		clusterStateLoginThrottle: throttleCounts,
+		clusterStateRateLimits:    rateLimits,
+	}, now)
+	if err != nil {
+		return err
+	}
+	var peerCounts []loginthrottle.Counts
+	for instanceID, data := range view.PeerStates(clusterStateLoginThrottle) {
+		var counts loginthrottle.Counts
+		if err := json.Unmarshal(data, &counts); err != nil {
+			return fmt.Errorf("login throttle of %s: %s", instanceID, err)
+		}
+		peerCounts = append(peerCounts, counts)
+	}
+	state.loginThrottle.SetPeers(peerCounts)
+	var peerLimits []addressRateLimitCounts
+	for instanceID, data := range view.PeerStates(clusterStateRateLimits) {
+		var limits clusterRateLimits
+		if err := json.Unmarshal(data, &limits); err != nil {
+			return fmt.Errorf("rate limits of %s: %s", instanceID, err)
+		}
+		peerLimits = append(peerLimits, limits.CAFingerprints)
+	}
+	state.caFingerprintsLimiter.setPeers(peerLimits, now)
+	if err := state.mergeClusterRevocations(view); err != nil {
+		return err
+	}
+	for id, data := range view.Records(clusterRecordSession) {
+		var session sessions.Session
+		if err := json.Unmarshal(data, &session); err != nil {
+			return fmt.Errorf("session %s: %s", id, err)
+		}
+		if _, err := state.sessions.Add(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeClusterRevocations adds the revocations of other instances to the
+// list and shares those only this instance knows, such as the revocations
+// from before the cluster was enabled.
+func (state *RuntimeState) mergeClusterRevocations(view *cluster.View) error {
+	records := view.Records(clusterRecordRevocation)
+	for serial, data := range records {
+		var entry revocationlist.Entry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return fmt.Errorf("revocation of %s: %s", serial, err)
+		}
+		added, err := state.revokedCerts.Add(entry)
+		if err != nil {
+			return err
+		}
+		if added {
+			logger.Printf("Revoked certificate with serial %s on another instance: %s",
+				entry.Serial, entry.Reason)
+		}
+	}
+	for _, entry := range state.revokedCerts.List() {
+		if _, ok := records[entry.Serial]; ok {
+			continue
+		}
+		if err := state.shareRevocation(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (status *clusterStatus) record(now time.Time, err error) {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	status.lastErr = err
+	if err == nil {
+		status.lastSync = now
+	}
+}
+
+// check returns the last error if the instance has not synced for longer
+// than ttl, which makes the other instances consider it gone.
+func (status *clusterStatus) check(now time.Time, ttl time.Duration) error {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	if now.Sub(status.lastSync) < ttl {
+		return nil
+	}
+	if status.lastErr != nil {
+		return fmt.Errorf("not synced since %s: %s",
+			status.lastSync.Format(time.RFC3339), status.lastErr)
+	}
+	return errors.New("not synced")
+}
+
+// clusterComponent returns the component sharing state with the other
+// instances through the profile storage, or nil if clustering is disabled.
+// The instance becomes unready when it has not synced for long enough for
+// the others to consider it gone.
+func (state *RuntimeState) clusterComponent() (lifecycle.Component, error) {
+	config := state.Config.Cluster
+	if !config.Enabled {
+		return nil, nil
+	}
+	instanceID, err := config.instanceID()
+	if err != nil {
+		return nil, fmt.Errorf("cluster: %s", err)
+	}
+	interval := config.interval()
+	ttl := clusterTTLIntervalMultiple * interval
+	state.cluster, err = cluster.New(state.profileStorage,
+		cluster.Params{InstanceID: instanceID, TTL: ttl})
+	if err != nil {
+		return nil, err
+	}
+	var status clusterStatus
+	stop := make(chan struct{})
+	return lifecycle.Funcs{
+		StartFunc: func() error {
+			now := time.Now()
+			err = state.syncCluster(now)
+			status.record(now, err)
+			if err != nil {
+				logger.Errorf("Cannot sync with the cluster: %s", err)
+			} else {
+				logger.Printf("Joined the cluster as %s, node %d", instanceID,
+					state.cluster.Node())
+			}
+			go func() {
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case now := <-ticker.C:
+						err := state.syncCluster(now)
+						status.record(now, err)
+						if err != nil {
+							logger.Errorf("Cannot sync with the cluster: %s", err)
+						}
+					case <-stop:
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		HealthCheckFunc: func() error {
+			return status.check(time.Now(), ttl)
+		},
+		StopFunc: func() error {
+			close(stop)
+			return nil
+		},
+	}, nil
+}
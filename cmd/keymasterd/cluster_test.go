@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/cluster"
+	"github.com/Symantec/keymaster/keymasterd/loginthrottle"
+	"github.com/Symantec/keymaster/keymasterd/profilestorage"
+	"github.com/Symantec/keymaster/keymasterd/revocationlist"
+	"github.com/Symantec/keymaster/keymasterd/sessions"
+)
+
+func TestClusterConfigCheck(t *testing.T) {
+	enabled := ClusterConfig{Enabled: true}
+	for _, storageURL := range []string{"postgresql://db/keymaster",
+		"file:///srv/keymaster", "s3://keymaster"} {
+		err := enabled.check(ProfileStorageConfig{StorageUrl: storageURL})
+		if err != nil {
+			t.Errorf("%s: %s", storageURL, err)
+		}
+	}
+	for _, storageURL := range []string{"", "sqlite:", "file:"} {
+		err := enabled.check(ProfileStorageConfig{StorageUrl: storageURL})
+		if err == nil {
+			t.Errorf("%q: accepted", storageURL)
+		}
+	}
+	if err := (ClusterConfig{}).check(ProfileStorageConfig{}); err != nil {
+		t.Errorf("disabled: %s", err)
+	}
+}
+
+// newClusterTestState returns the state of the instance instanceID of a
+// cluster sharing backend.
+func newClusterTestState(t *testing.T, dir string, instanceID string,
+	backend profilestorage.Backend) *RuntimeState {
+	var state RuntimeState
+	var err error
+	state.revokedCerts, err = revocationlist.Open(filepath.Join(dir,
+		instanceID+"-revoked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.sessions, err = sessions.Open(filepath.Join(dir,
+		instanceID+"-sessions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.loginThrottle = loginthrottle.New(loginthrottle.Params{})
+	state.caFingerprintsLimiter = newAddressRateLimiter(
+		caFingerprintsRequestsPerMinute, time.Minute)
+	state.profileStorage = backend
+	state.cluster, err = cluster.New(backend,
+		cluster.Params{InstanceID: instanceID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &state
+}
+
+func TestCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	backend, err := profilestorage.OpenDirectory(filepath.Join(dir, "shared"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newClusterTestState(t, dir, "a", backend)
+	b := newClusterTestState(t, dir, "b", backend)
+
+	// A revocation from before the cluster is shared by the first sync.
+	if _, err := a.revokeCert("42", "lost laptop"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		a.loginThrottle.RecordFailure("alice", "10.0.0.1", []byte("guess"))
+	}
+	for _, state := range []*RuntimeState{a, b} {
+		if err := state.syncCluster(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entry, ok := b.revokedCerts.Get("42"); !ok ||
+		entry.Reason != "lost laptop" {
+		t.Errorf("revocation not shared: %+v", entry)
+	}
+	if b.loginThrottle.Delay("alice", "10.0.0.2") == 0 {
+		t.Error("failures of the other instance not counted")
+	}
+	if a.cluster.Node() == b.cluster.Node() {
+		t.Errorf("both instances are node %d", a.cluster.Node())
+	}
+	serialA, err := a.newSSHCertSerial(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialB, err := b.newSSHCertSerial(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serialA>>24&0xFF == serialB>>24&0xFF {
+		t.Errorf("serials of the same node: %x %x", serialA, serialB)
+	}
+
+	// Sessions are valid on every instance right away, and revoked on all.
+	session, err := a.newSession(nil, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := authInfo{Username: "alice", SessionID: session.ID}
+	if err := b.checkSession(info); err != nil {
+		t.Fatalf("session of the other instance: %s", err)
+	}
+	if revoked, err := b.revokeSession(session.ID); err != nil || !revoked {
+		t.Fatalf("revoking: %v %v", revoked, err)
+	}
+	if err := a.syncCluster(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.checkSession(info); err == nil {
+		t.Error("session revoked on the other instance accepted")
+	}
+
+	// Clearing a lockout clears it on every instance.
+	b.clearLockout("alice")
+	for _, state := range []*RuntimeState{b, a, b} {
+		if err := state.syncCluster(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, state := range map[string]*RuntimeState{"a": a, "b": b} {
+		if delay := state.loginThrottle.Delay("alice", "10.0.0.2"); delay != 0 {
+			t.Errorf("%s: delay %s after clearing", name, delay)
+		}
+	}
+
+	// Revocations on any instance reach the others on their next sync.
+	for i, state := range []*RuntimeState{a, b} {
+		if _, err := state.revokeCert(fmt.Sprint(100+i), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, state := range []*RuntimeState{a, b} {
+		if err := state.syncCluster(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !a.revokedCerts.IsRevoked("101") || len(b.revokedCerts.List()) != 3 {
+		t.Errorf("revocations: %+v %+v", a.revokedCerts.List(),
+			b.revokedCerts.List())
+	}
+}
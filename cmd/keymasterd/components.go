@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/lifecycle"
+)
+
+const (
+	readyzPath                = "/readyz"
+	componentHealthInterval   = 30 * time.Second
+	serverShutdownTimeout     = 10 * time.Second
+	storageHealthCheckTimeout = 5 * time.Second
+)
+
+// serverNextProtos are the ALPN protocols of the HTTPS listeners. Serve
+// handles HTTP/2 connections, but tls.NewListener only negotiates the
+// protocols listed in the TLS configuration. The realm configurations are
+// clones and inherit them.
+var serverNextProtos = []string{"h2", "http/1.1"}
+
+// serverComponent manages an HTTPS listener, or a plain HTTP one if srv has
+// no TLS configuration. Start returns once the port is bound so that listen
+// errors are reported by Start.
+func serverComponent(srv *http.Server) lifecycle.Component {
+	return lifecycle.Funcs{
+		StartFunc: func() error {
+			listener, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				if hint := checkBindAddress(srv.Addr); hint != nil {
+					return fmt.Errorf("%s: %s", err, hint)
+				}
+				return err
+			}
+			if srv.TLSConfig != nil {
+				listener = tls.NewListener(listener, srv.TLSConfig)
+			}
+			go func() {
+				err := srv.Serve(listener)
+				if err != nil && err != http.ErrServerClosed {
+					logger.Fatalf("Serving %s: %s", srv.Addr, err)
+				}
+			}()
+			return nil
+		},
+		StopFunc: func() error {
+			ctx, cancel := context.WithTimeout(context.Background(),
+				serverShutdownTimeout)
+			defer cancel()
+			return srv.Shutdown(ctx)
+		},
+	}
+}
+
+// registerComponents registers the parts of keymasterd with their
+// dependencies. The admin server only depends on the CA keys, which start
+// right away, so that it (and /readyz) is up while the CA is still sealed and
+// the keys are only wiped once it stopped.
+func (state *RuntimeState) registerComponents(components *lifecycle.Manager,
+	adminSrv *http.Server, serviceSrv *http.Server) error {
+	register := func(name string, c lifecycle.Component,
+		dependsOn ...string) error {
+		return components.Register(name, c, dependsOn...)
+	}
+	adminDependencies := []string{"ca_key"}
+	for _, realm := range state.realms {
+		adminDependencies = append(adminDependencies,
+			realm.componentPrefix()+"ca_key")
+	}
+	err := register("admin_server", serverComponent(adminSrv),
+		adminDependencies...)
+	if err != nil {
+		return err
+	}
+	if filename := state.Config.Base.AdminSocketFilename; filename != "" {
+		err := register("admin_socket", adminSocketComponent(filename,
+			state.newAdminSocketMux()), adminDependencies...)
+		if err != nil {
+			return err
+		}
+	}
+	if err := state.registerStateComponents(components, ""); err != nil {
+		return err
+	}
+	err = register("notifications", lifecycle.Funcs{})
+	if err != nil {
+		return err
+	}
+	serviceDependencies := []string{"storage", "notifications", "plugins",
+		"signer", "password_checker"}
+	clusterComponent, err := state.clusterComponent()
+	if err != nil {
+		return err
+	}
+	if clusterComponent != nil {
+		err := register(clusterComponentName, clusterComponent, "storage")
+		if err != nil {
+			return err
+		}
+		serviceDependencies = append(serviceDependencies, clusterComponentName)
+	}
+	for _, realm := range state.realms {
+		prefix := realm.componentPrefix()
+		if err := realm.state.registerStateComponents(components,
+			prefix); err != nil {
+			return err
+		}
+		serviceDependencies = append(serviceDependencies, prefix+"signer",
+			prefix+"password_checker")
+	}
+	err = register("service_server", serverComponent(serviceSrv),
+		serviceDependencies...)
+	if err != nil {
+		return err
+	}
+	if address := state.Config.Base.HTTPRedirectAddress; address != "" &&
+		!state.acmeServesHTTPRedirect() {
+		err := register(httpRedirectComponentName,
+			serverComponent(state.newHTTPRedirectServer(address)),
+			"service_server")
+		if err != nil {
+			return err
+		}
+	}
+	if metricsHistory := state.metricsHistoryComponent(); metricsHistory != nil {
+		err = register(metricsHistoryComponentName, metricsHistory)
+		if err != nil {
+			return err
+		}
+	}
+	if accessReview := state.accessReviewComponent(); accessReview != nil {
+		err = register(accessReviewComponentName, accessReview, "storage")
+		if err != nil {
+			return err
+		}
+	}
+	// Withdrawn before the service server stops.
+	dnsPublication, err := state.dnsPublicationComponent(components)
+	if err != nil || dnsPublication == nil {
+		return err
+	}
+	return register(dnsPublicationComponentName, dnsPublication,
+		"service_server")
+}
+
+// registerStateComponents registers the components holding the database,
+// plugins, CA key and password backend of state, with prefix prepended to
+// their names. The CA key is wiped by its own component, which the signer
+// and the admin servers depend on, so that it is wiped after all of them
+// stopped.
+func (state *RuntimeState) registerStateComponents(
+	components *lifecycle.Manager, prefix string) error {
+	register := func(name string, c lifecycle.Component,
+		dependsOn ...string) error {
+		return components.Register(name, c, dependsOn...)
+	}
+	err := register(prefix+"storage", lifecycle.Funcs{
+		HealthCheckFunc: func() error {
+			if state.profileStorage == nil {
+				return errors.New("no database")
+			}
+			ctx, cancel := context.WithTimeout(context.Background(),
+				storageHealthCheckTimeout)
+			defer cancel()
+			return state.profileStorage.Ping(ctx)
+		},
+		StopFunc: func() error {
+			if state.profileStorage == nil {
+				return nil
+			}
+			return state.profileStorage.Close()
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = register(prefix+"plugins", lifecycle.Funcs{
+		HealthCheckFunc: func() error {
+			for _, client := range state.plugins {
+				if err := client.HealthCheck(); err != nil {
+					return fmt.Errorf("plugin %s: %s", client.Name(), err)
+				}
+			}
+			return nil
+		},
+		StopFunc: func() error {
+			for _, client := range state.plugins {
+				client.Close()
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = register(prefix+"ca_key", lifecycle.Funcs{
+		StopFunc: func() error {
+			state.Mutex.Lock()
+			defer state.Mutex.Unlock()
+			if ca := state.currentCA(); ca.signer != nil {
+				zeroizeSigner(ca.signer)
+				ca.signer = nil
+				state.setCAKeys(ca)
+			}
+			wipeBytes(state.SSHCARawFileContent)
+			if rollover := state.caRollover; rollover != nil {
+				if rollover.next != nil {
+					zeroizeSigner(rollover.next)
+					rollover.next = nil
+				}
+				if rollover.previousSigner != nil {
+					zeroizeSigner(rollover.previousSigner)
+					rollover.previousSigner = nil
+				}
+				wipeBytes(rollover.nextRawData)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = register(prefix+"signer", lifecycle.Funcs{
+		StartFunc: func() error {
+			if isReady := <-state.SignerIsReady; !isReady {
+				return errors.New("got bad signer ready data")
+			}
+			return nil
+		},
+		HealthCheckFunc: func() error {
+			state.Mutex.Lock()
+			defer state.Mutex.Unlock()
+			if state.Signer == nil {
+				return errors.New("signer not loaded")
+			}
+			return nil
+		},
+	}, prefix+"storage", prefix+"ca_key")
+	if err != nil {
+		return err
+	}
+	return register(prefix+"password_checker", lifecycle.Funcs{
+		StartFunc: func() error {
+			if len(state.Config.Ldap.LDAPTargetURLs) > 0 &&
+				!state.Config.Ldap.DisablePasswordCache {
+				return state.passwordChecker.UpdateStorage(state)
+			}
+			return nil
+		},
+	}, prefix+"storage", prefix+"signer", prefix+"plugins")
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM and then stops all
+// components in reverse dependency order.
+func waitForShutdown(components *lifecycle.Manager) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	logger.Printf("Received %s, shutting down\n", sig)
+	if err := components.Stop(); err != nil {
+		logger.Errorf("%s", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/lifecycle"
+)
+
+func TestRegisterComponentsOrder(t *testing.T) {
+	state := RuntimeState{}
+	components := lifecycle.New(time.Hour, logger)
+	err := state.registerComponents(components, &http.Server{},
+		&http.Server{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := components.Init(); err != nil {
+		t.Fatal(err)
+	}
+	position := make(map[string]int)
+	for i, status := range components.Status() {
+		position[status.Name] = i
+	}
+	if position["ca_key"] != 0 || position["admin_server"] != 1 {
+		t.Fatal("admin server must start first to serve /readyz")
+	}
+	// Components stop in reverse order.
+	if position["ca_key"] > position["signer"] {
+		t.Fatal("CA key must be wiped after the signer stopped")
+	}
+	if position["service_server"] != len(position)-1 {
+		t.Fatal("service server must start last")
+	}
+	if position["signer"] > position["password_checker"] {
+		t.Fatal("password checker must start after the signer")
+	}
+	if components.Ready() {
+		t.Fatal("components ready before start")
+	}
+}
+
+func TestServerComponentHTTP2(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(localhostCertPem),
+		[]byte(localhostKeyPem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	srv := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   serverNextProtos,
+		},
+	}
+	component := serverComponent(srv)
+	if err := component.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer component.Stop()
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("served %q, want HTTP/2.0", body)
+	}
+}
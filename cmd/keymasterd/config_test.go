@@ -40,3 +40,23 @@ func TestGenerateNewConfigInternal(t *testing.T) {
 	// TODO: test decrypt file
 
 }
+
+func TestCheckPasswordBackends(t *testing.T) {
+	var config AppConfigFile
+	if err := checkPasswordBackends(&config); err != nil {
+		t.Fatal(err)
+	}
+	config.Ldap.LDAPTargetURLs = "ldaps://ldap.example.com"
+	if err := checkPasswordBackends(&config); err != nil {
+		t.Fatal(err)
+	}
+	config.Radius.ServerAddresses = []string{"radius.example.com"}
+	config.PAM.ServiceName = "keymaster"
+	err := checkPasswordBackends(&config)
+	if err == nil {
+		t.Fatal("multiple password backends accepted")
+	}
+	if !strings.Contains(err.Error(), "radius, pam, ldap") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
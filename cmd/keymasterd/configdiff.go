@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+)
+
+const configDiffPath = "/configDiff"
+
+const redactedConfigValue = "<redacted>"
+
+type configDifference struct {
+	Setting  string      `json:"setting"`
+	Active   interface{} `json:"active"`
+	File     interface{} `json:"file"`
+	Redacted bool        `json:"redacted,omitempty"`
+}
+
+type configDiffResponse struct {
+	Filename    string             `json:"filename"`
+	FileError   string             `json:"file_error,omitempty"`
+	Differences []configDifference `json:"differences"`
+}
+
+// configSetting is a setting in the configuration and whether it is a
+// secret: set in a field tagged secret:"true" or within one. Secrets are
+// never shown, only whether they differ.
+type configSetting struct {
+	value  interface{}
+	secret bool
+}
+
+// flattenConfig adds the settings in value to settings, keyed by their path
+// such as "ldap.bind_password" or "roles[0].name". Values which are not read
+// from the configuration file, such as clients, are skipped.
+func flattenConfig(value reflect.Value, path string, secret bool,
+	settings map[string]configSetting) {
+	switch value.Kind() {
+	case reflect.Struct:
+		for index := 0; index < value.NumField(); index++ {
+			field := value.Type().Field(index)
+			name, ok := yamlFieldName(field)
+			if !ok {
+				continue
+			}
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			flattenConfig(value.Field(index), fieldPath,
+				secret || field.Tag.Get("secret") == "true", settings)
+		}
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.Struct {
+			settings[path] = configSetting{value.Interface(), secret}
+			return
+		}
+		for index := 0; index < value.Len(); index++ {
+			flattenConfig(value.Index(index),
+				fmt.Sprintf("%s[%d]", path, index), secret, settings)
+		}
+	case reflect.Map:
+		for _, mapKey := range value.MapKeys() {
+			flattenConfig(value.MapIndex(mapKey),
+				fmt.Sprintf("%s.%v", path, mapKey.Interface()), secret,
+				settings)
+		}
+	case reflect.Ptr, reflect.Interface, reflect.Func, reflect.Chan:
+	default:
+		settings[path] = configSetting{value.Interface(), secret}
+	}
+}
+
+// diffConfigs returns the settings which differ between active and file,
+// ordered by setting.
+func diffConfigs(active, file *AppConfigFile) []configDifference {
+	activeSettings := make(map[string]configSetting)
+	flattenConfig(reflect.ValueOf(active).Elem(), "", false, activeSettings)
+	fileSettings := make(map[string]configSetting)
+	flattenConfig(reflect.ValueOf(file).Elem(), "", false, fileSettings)
+	paths := make(map[string]struct{})
+	for path := range activeSettings {
+		paths[path] = struct{}{}
+	}
+	for path := range fileSettings {
+		paths[path] = struct{}{}
+	}
+	differences := []configDifference{}
+	for path := range paths {
+		activeSetting, inActive := activeSettings[path]
+		fileSetting, inFile := fileSettings[path]
+		if inActive && inFile &&
+			reflect.DeepEqual(activeSetting.value, fileSetting.value) {
+			continue
+		}
+		// Unset fields of list entries which were added or removed.
+		if !inActive && reflect.ValueOf(fileSetting.value).IsZero() ||
+			!inFile && reflect.ValueOf(activeSetting.value).IsZero() {
+			continue
+		}
+		difference := configDifference{
+			Setting: path,
+			Active:  activeSetting.value,
+			File:    fileSetting.value,
+		}
+		if activeSetting.secret || fileSetting.secret {
+			difference.Redacted = true
+			if inActive {
+				difference.Active = redactedConfigValue
+			}
+			if inFile {
+				difference.File = redactedConfigValue
+			}
+		}
+		differences = append(differences, difference)
+	}
+	sort.Slice(differences, func(i, j int) bool {
+		return differences[i].Setting < differences[j].Setting
+	})
+	return differences
+}
+
+// activeConfig returns the configuration in force as it would be written in
+// the configuration file: the file as loaded at startup with the issuance
+// policy and policy audit since reloaded or approved in change requests.
+func (state *RuntimeState) activeConfig() *AppConfigFile {
+	state.Mutex.Lock()
+	defer state.Mutex.Unlock()
+	config := *state.loadedConfig
+	config.setIssuancePolicy(state.Config.issuancePolicy())
+	config.PolicyAudit = state.Config.PolicyAudit
+	return &config
+}
+
+// configDiffHandler is served on the admin port and the admin socket and
+// lists the settings in force which differ from the configuration file, so
+// that changes which only take effect on restart can be spotted. Secrets
+// are redacted.
+func (state *RuntimeState) configDiffHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if state.loadedConfig == nil {
+		state.writeFailureResponse(w, r, http.StatusNotFound,
+			"Not running from a configuration file")
+		return
+	}
+	response := configDiffResponse{
+		Filename:    state.configFilename,
+		Differences: []configDifference{},
+	}
+	source, err := ioutil.ReadFile(state.configFilename)
+	if err == nil {
+		var file AppConfigFile
+		err = parseAppConfig(source, &file)
+		if err == nil {
+			response.Differences = diffConfigs(state.activeConfig(), &file)
+		}
+	}
+	if err != nil {
+		response.FileError = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(response)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Settings can be supplied outside of the configuration file, so that
+// secrets can come from Kubernetes secrets or systemd credentials:
+//
+//	bind_password_file: /run/secrets/ldap   next to a setting reads it from a file
+//	KEYMASTER_LDAP_BIND_PASSWORD=secret     sets ldap bind_password
+//	KEYMASTER_LDAP_BIND_PASSWORD_FILE=path  reads ldap bind_password from a file
+//
+// Environment variable names are the path of the setting in upper case.
+// Environment variables take precedence over the configuration file.
+// Entries of lists, such as openid_connect_idp clients, only support *_file
+// keys.
+const (
+	configEnvironmentPrefix = "KEYMASTER_"
+	configFileSuffix        = "_file"
+)
+
+// readConfigSecretFile returns the content of filename without the trailing
+// newline most tools write.
+func readConfigSecretFile(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// parseAppConfig parses the configuration in source and applies the
+// overrides from the environment and from files.
+func parseAppConfig(source []byte, config *AppConfigFile) error {
+	_, err := parseAppConfigWithOverrides(source, config, os.Environ())
+	return err
+}
+
+// parseAppConfigWithOverrides is parseAppConfig with the environment in
+// environ. It also returns the parsed YAML without the *_file keys which
+// were applied.
+func parseAppConfigWithOverrides(source []byte, config *AppConfigFile,
+	environ []string) (map[interface{}]interface{}, error) {
+	if err := yaml.Unmarshal(source, config); err != nil {
+		return nil, err
+	}
+	var tree map[interface{}]interface{}
+	if err := yaml.Unmarshal(source, &tree); err != nil {
+		return nil, err
+	}
+	environment := make(map[string]string)
+	for _, variable := range environ {
+		if !strings.HasPrefix(variable, configEnvironmentPrefix) {
+			continue
+		}
+		if index := strings.Index(variable, "="); index > 0 {
+			environment[variable[:index]] = variable[index+1:]
+		}
+	}
+	err := applyConfigOverrides(reflect.ValueOf(config).Elem(), tree,
+		configEnvironmentPrefix, environment)
+	return tree, err
+}
+
+// yamlFieldName returns the key of field, as chosen by the YAML package.
+func yamlFieldName(field reflect.StructField) (string, bool) {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "-" || field.PkgPath != "" {
+		return "", false
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, true
+}
+
+func isOverridableConfigValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return value.Type().Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// setConfigValue sets value from text. Lists are comma separated.
+func setConfigValue(value reflect.Value, text string) error {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		value.SetBool(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(text, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetFloat(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		parsed, err := strconv.ParseInt(text, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		parsed, err := strconv.ParseUint(text, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(parsed)
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		value.Set(reflect.ValueOf(list).Convert(value.Type()))
+	}
+	return nil
+}
+
+// applyConfigOverrides sets the fields of the struct value which have a
+// *_file key in tree, the YAML of the struct, or a variable starting with
+// prefix in environment. A nil environment is not consulted.
+func applyConfigOverrides(value reflect.Value, tree map[interface{}]interface{},
+	prefix string, environment map[string]string) error {
+	names := make(map[string]bool)
+	for index := 0; index < value.NumField(); index++ {
+		if name, ok := yamlFieldName(value.Type().Field(index)); ok {
+			names[name] = true
+		}
+	}
+	for index := 0; index < value.NumField(); index++ {
+		name, ok := yamlFieldName(value.Type().Field(index))
+		if !ok {
+			continue
+		}
+		fieldValue := value.Field(index)
+		variable := prefix + strings.ToUpper(name)
+		if fieldValue.Kind() == reflect.Struct {
+			subtree, _ := tree[name].(map[interface{}]interface{})
+			err := applyConfigOverrides(fieldValue, subtree, variable+"_",
+				environment)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if fieldValue.Kind() == reflect.Slice &&
+			fieldValue.Type().Elem().Kind() == reflect.Struct {
+			list, _ := tree[name].([]interface{})
+			for index := 0; index < fieldValue.Len() && index < len(list); index++ {
+				subtree, _ := list[index].(map[interface{}]interface{})
+				err := applyConfigOverrides(fieldValue.Index(index), subtree,
+					"", nil)
+				if err != nil {
+					return fmt.Errorf("%s entry %d: %s", name, index+1, err)
+				}
+			}
+			continue
+		}
+		if !isOverridableConfigValue(fieldValue) {
+			continue
+		}
+		var text, source string
+		fileKey := name + configFileSuffix
+		if filename, ok := tree[fileKey].(string); ok && !names[fileKey] {
+			secret, err := readConfigSecretFile(filename)
+			if err != nil {
+				return fmt.Errorf("%s: %s", fileKey, err)
+			}
+			delete(tree, fileKey)
+			text, source = secret, fileKey
+		}
+		if environment != nil {
+			envValue, inEnv := environment[variable]
+			envFilename, inEnvFile := environment[variable+"_FILE"]
+			if inEnv && inEnvFile {
+				return fmt.Errorf("both %s and %s_FILE are set", variable,
+					variable)
+			}
+			if inEnv {
+				text, source = envValue, variable
+			} else if inEnvFile {
+				secret, err := readConfigSecretFile(envFilename)
+				if err != nil {
+					return fmt.Errorf("%s_FILE: %s", variable, err)
+				}
+				text, source = secret, variable+"_FILE"
+			}
+		}
+		if source == "" {
+			continue
+		}
+		if err := setConfigValue(fieldValue, text); err != nil {
+			return fmt.Errorf("%s: %s", source, err)
+		}
+	}
+	return nil
+}
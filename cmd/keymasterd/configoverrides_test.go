@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseAppConfigWithOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configoverrides")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSecret := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	ldapSecret := writeSecret("ldap", "ldap-secret\n")
+	pinSecret := writeSecret("pin", "1234")
+	clientSecret := writeSecret("client", "client-secret")
+	source := []byte(`base:
+  http_address: ":443"
+  tls_key_pkcs11:
+    module_path: /usr/lib/softhsm.so
+ldap:
+  bind_password: in-the-file
+  bind_password_file: ` + ldapSecret + `
+duo:
+  secret_key: in-the-file
+openid_connect_idp:
+  clients:
+    - client_id: wiki
+      client_secret_file: ` + clientSecret + `
+`)
+	var config AppConfigFile
+	tree, err := parseAppConfigWithOverrides(source, &config, []string{
+		"KEYMASTER_BASE_HTTP_ADDRESS=:8443",
+		"KEYMASTER_BASE_TLS_KEY_PKCS11_PIN_FILE=" + pinSecret,
+		"KEYMASTER_BASE_ADMIN_USERS=alice, bob",
+		"KEYMASTER_USERINFO_SOURCES_LDAP_BIND_PASSWORD=userinfo-secret",
+		"KEYMASTER_LOGIN_THROTTLE_ENABLED=true",
+		"KEYMASTER_LOGIN_THROTTLE_MAX_DELAY_SECS=2.5",
+		"OTHER_DUO_SECRET_KEY=ignored",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Base.HttpAddress != ":8443" ||
+		config.Base.TLSKeyPKCS11.Pin != "1234" ||
+		config.Ldap.BindPassword != "ldap-secret" ||
+		config.UserInfo.Ldap.BindPassword != "userinfo-secret" ||
+		config.Duo.SecretKey != "in-the-file" ||
+		!config.LoginThrottle.Enabled ||
+		config.LoginThrottle.MaxDelaySecs != 2.5 ||
+		config.OpenIDConnectIDP.Client[0].ClientSecret != "client-secret" {
+		t.Fatalf("overrides not applied: %+v", config)
+	}
+	if !reflect.DeepEqual(config.Base.AdminUsers, []string{"alice", "bob"}) {
+		t.Fatalf("unexpected admin users: %v", config.Base.AdminUsers)
+	}
+	if _, ok := tree["ldap"].(map[interface{}]interface{})["bind_password_file"]; ok {
+		t.Fatal("applied *_file key left in the configuration")
+	}
+
+	for _, environ := range [][]string{
+		{"KEYMASTER_LDAP_BIND_PASSWORD=a",
+			"KEYMASTER_LDAP_BIND_PASSWORD_FILE=" + ldapSecret},
+		{"KEYMASTER_LDAP_BIND_PASSWORD_FILE=" + filepath.Join(dir, "missing")},
+		{"KEYMASTER_LOGIN_THROTTLE_ENABLED=maybe"},
+	} {
+		var config AppConfigFile
+		if _, err := parseAppConfigWithOverrides(source, &config,
+			environ); err == nil {
+			t.Errorf("%v: expected an error", environ)
+		}
+	}
+}
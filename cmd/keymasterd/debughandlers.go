@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// debugPathPrefix is the prefix of the troubleshooting handlers. Importing
+// net/http/pprof registers its handlers below it on http.DefaultServeMux,
+// which the admin port serves, so they are guarded by state.debugFilterHandler.
+const debugPathPrefix = "/debug/"
+
+const (
+	debugPprofPath  = "/debug/pprof/"
+	debugLogBufPath = "/debug/logbuf"
+)
+
+// debugFilterHandler requires the admin client certificate of an admin for
+// the paths below debugPathPrefix, even when the logs are public: profiles
+// and the log buffer may show secrets. Certificates issued by keymaster are
+// not enough, although they are verified by the client CAs as well.
+func (state *RuntimeState) debugFilterHandler(
+	handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, debugPathPrefix) {
+			username, ok := state.requireAdminClientCert(w, r)
+			if !ok {
+				return
+			}
+			if !state.IsAdminUser(username) {
+				requestLogger(r).Printf("Debug request %s refused to %s, "+
+					"not an admin", r.URL.Path, username)
+				state.writeFailureResponse(w, r, http.StatusForbidden,
+					"not an admin")
+				return
+			}
+			requestLogger(r).Printf("Debug request %s by %s", r.URL.Path,
+				username)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// registerDebugHandlers registers the profiling handlers, which
+// net/http/pprof only registers on http.DefaultServeMux, and the log buffer
+// handler on mux.
+func (state *RuntimeState) registerDebugHandlers(mux *http.ServeMux) {
+	if mux != http.DefaultServeMux {
+		mux.HandleFunc(debugPprofPath, pprof.Index)
+		mux.HandleFunc(debugPprofPath+"cmdline", pprof.Cmdline)
+		mux.HandleFunc(debugPprofPath+"profile", pprof.Profile)
+		mux.HandleFunc(debugPprofPath+"symbol", pprof.Symbol)
+		mux.HandleFunc(debugPprofPath+"trace", pprof.Trace)
+	}
+	mux.HandleFunc(debugLogBufPath, state.debugLogBufHandler)
+}
+
+// debugLogBufHandler writes the contents of the circular log buffer.
+func (state *RuntimeState) debugLogBufHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.logBuffer == nil {
+		http.Error(w, "No log buffer", http.StatusNotFound)
+		return
+	}
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer := bufio.NewWriter(w)
+	defer writer.Flush()
+	fmt.Fprintln(writer, "<title>keymaster log buffer</title>")
+	fmt.Fprintln(writer, "<body>")
+	state.logBuffer.WriteHtml(writer)
+	fmt.Fprintln(writer, "</body>")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/admincache"
+)
+
+type testLogBuffer string
+
+func (b testLogBuffer) WriteHtml(writer io.Writer) {
+	fmt.Fprintln(writer, string(b))
+}
+
+func TestDebugFilterHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.isAdminCache = admincache.New(time.Minute)
+	state.Config.Base.AdminUsers = []string{"ops"}
+	handler := NewLogFilterHandler(state.debugFilterHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		})), true)
+	serve := func(path string, tlsState *tls.ConnectionState) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.TLS = tlsState
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := serve("/logs", nil); code != http.StatusOK {
+		t.Fatalf("public logs: %d", code)
+	}
+	for _, path := range []string{debugPprofPath, debugLogBufPath} {
+		if code := serve(path, nil); code != http.StatusForbidden {
+			t.Fatalf("%s without certificate: %d", path, code)
+		}
+		if code := serve(path, &tls.ConnectionState{}); code !=
+			http.StatusForbidden {
+			t.Fatalf("%s with unverified certificate: %d", path, code)
+		}
+	}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "ops"}}
+	verified := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{cert}},
+	}
+	if code := serve(debugPprofPath, verified); code != http.StatusOK {
+		t.Fatalf("with verified certificate: %d", code)
+	}
+	// Users who are not admins and certificates of keymaster are refused.
+	user := &x509.Certificate{Subject: pkix.Name{CommonName: "user"}}
+	if code := serve(debugPprofPath, &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{user}},
+	}); code != http.StatusForbidden {
+		t.Fatalf("with certificate of a user: %d", code)
+	}
+	keymasterCA := &x509.Certificate{
+		PublicKey: state.currentCA().signer.Public()}
+	if code := serve(debugLogBufPath, &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{cert, keymasterCA}},
+	}); code != http.StatusForbidden {
+		t.Fatalf("with certificate of keymaster: %d", code)
+	}
+}
+
+func TestDebugHandlers(t *testing.T) {
+	state := &RuntimeState{logBuffer: testLogBuffer("Started keymasterd")}
+	mux := http.NewServeMux()
+	state.registerDebugHandlers(mux)
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+	code, body := get(debugLogBufPath)
+	if code != http.StatusOK || !strings.Contains(body, "Started keymasterd") {
+		t.Fatalf("log buffer: %d %q", code, body)
+	}
+	code, body = get(debugPprofPath)
+	if code != http.StatusOK || !strings.Contains(body, "goroutine") {
+		t.Fatalf("pprof index: %d %q", code, body)
+	}
+	code, body = get(debugPprofPath + "goroutine?debug=1")
+	if code != http.StatusOK || !strings.Contains(body, "TestDebugHandlers") {
+		t.Fatalf("goroutine profile: %d", code)
+	}
+	state.logBuffer = nil
+	if code, _ := get(debugLogBufPath); code != http.StatusNotFound {
+		t.Fatalf("without log buffer: %d", code)
+	}
+}
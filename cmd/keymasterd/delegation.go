@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+)
+
+// DelegationRuleConfig allows requesters to get certificates for the
+// targets.
+type DelegationRuleConfig struct {
+	Requesters      []string `yaml:"requesters"`
+	RequesterGroups []string `yaml:"requester_groups"`
+	// Patterns as in path.Match of the users or roles certificates may be
+	// requested for.
+	Targets []string `yaml:"targets"`
+	// Default: ssh.
+	CertTypes []string `yaml:"cert_types"`
+	// Default: the maximum duration of certificates.
+	MaxDurationSecs uint `yaml:"max_duration_secs"`
+}
+
+type DelegationConfig struct {
+	Rules []DelegationRuleConfig `yaml:"rules"`
+}
+
+type delegatedRequesterKey struct{}
+
+var errNoDelegationRule = errors.New("no delegation rule allows this request")
+
+func (config *DelegationRuleConfig) check() error {
+	if len(config.Requesters) < 1 && len(config.RequesterGroups) < 1 {
+		return errors.New("neither requesters nor requester_groups")
+	}
+	if len(config.Targets) < 1 {
+		return errors.New("no targets")
+	}
+	for _, pattern := range config.Targets {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad targets pattern: %q", pattern)
+		}
+	}
+	return nil
+}
+
+func (config *DelegationConfig) check() error {
+	for index, rule := range config.Rules {
+		if err := rule.check(); err != nil {
+			return fmt.Errorf("delegation rule %d: %s", index, err)
+		}
+	}
+	return nil
+}
+
+func (config *DelegationRuleConfig) matchesTarget(target string) bool {
+	for _, pattern := range config.Targets {
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (config *DelegationRuleConfig) allowsCert(certType string,
+	duration time.Duration) bool {
+	if config.MaxDurationSecs > 0 &&
+		duration > time.Duration(config.MaxDurationSecs)*time.Second {
+		return false
+	}
+	if len(config.CertTypes) < 1 {
+		return certType == "ssh"
+	}
+	for _, allowed := range config.CertTypes {
+		if allowed == certType {
+			return true
+		}
+	}
+	return false
+}
+
+// delegationRules returns the rules allowing requester to get certificates
+// for target. The members of a role may get certificates for it.
+func (state *RuntimeState) delegationRules(ctx context.Context,
+	requester, target string) ([]DelegationRuleConfig, error) {
+	candidates := state.Config.Delegation.Rules
+	role, isRole := state.lookupRole(target)
+	if isRole {
+		candidates = []DelegationRuleConfig{role.delegationRule()}
+	}
+	var rules []DelegationRuleConfig
+	var requesterGroups []string
+	groupsLoaded := false
+	for _, rule := range candidates {
+		if !rule.matchesTarget(target) {
+			continue
+		}
+		if stringsIntersect([]string{requester}, rule.Requesters) {
+			rules = append(rules, rule)
+			continue
+		}
+		if len(rule.RequesterGroups) < 1 {
+			continue
+		}
+		if !groupsLoaded {
+			var err error
+			requesterGroups, err = state.getUserGroupsContext(ctx, requester)
+			if err != nil {
+				return nil, err
+			}
+			groupsLoaded = true
+		}
+		if stringsIntersect(requesterGroups, rule.RequesterGroups) {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) < 1 || isRole {
+		return rules, nil
+	}
+	// Delegation cannot be used to impersonate administrators. Unlike
+	// IsAdminUser this does not fall back to a cached answer.
+	if isAdmin, err := state._IsAdminUser(target); err != nil || isAdmin {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// mayDelegate returns true if some rule allows requester to get
+// certificates for target.
+func (state *RuntimeState) mayDelegate(ctx context.Context, requester,
+	target string) (bool, error) {
+	rules, err := state.delegationRules(ctx, requester, target)
+	return len(rules) > 0, err
+}
+
+// checkDelegation returns an error if the request in r is delegated and no
+// rule allows its certificate type and duration for target.
+func (state *RuntimeState) checkDelegation(r *http.Request, target string,
+	certType string, duration time.Duration) error {
+	requester := delegatedRequester(r)
+	if requester == "" {
+		return nil
+	}
+	rules, err := state.delegationRules(r.Context(), requester, target)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if rule.allowsCert(certType, duration) {
+			return nil
+		}
+	}
+	return errNoDelegationRule
+}
+
+// withDelegatedRequester marks r as a request by requester for another user.
+func withDelegatedRequester(r *http.Request, requester string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(),
+		delegatedRequesterKey{}, requester))
+}
+
+// delegatedRequester returns who requested a certificate for another user
+// in r, or an empty string if the request is for the requester.
+func delegatedRequester(r *http.Request) string {
+	requester, _ := r.Context().Value(delegatedRequesterKey{}).(string)
+	return requester
+}
+
+func requestedBySuffix(r *http.Request) string {
+	if requester := delegatedRequester(r); requester != "" {
+		return " requested by " + requester
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/admincache"
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+)
+
+func TestDelegationConfig(t *testing.T) {
+	config := DelegationConfig{Rules: []DelegationRuleConfig{{
+		Requesters: []string{"bastion"}, Targets: []string{"deploy-*"}}}}
+	if err := config.check(); err != nil {
+		t.Fatal(err)
+	}
+	for name, rule := range map[string]DelegationRuleConfig{
+		"no requesters": {Targets: []string{"deploy"}},
+		"no targets":    {Requesters: []string{"bastion"}},
+		"bad pattern": {Requesters: []string{"bastion"},
+			Targets: []string{"deploy-["}},
+	} {
+		config := DelegationConfig{Rules: []DelegationRuleConfig{rule}}
+		if err := config.check(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestDelegatedCertGen(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "delegation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.attestationLog, err = attestation.Open(filepath.Join(dir,
+		attestationLogFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.isAdminCache = admincache.New(time.Minute)
+	state.Config.Base.AdminUsers = []string{"deploy-admin"}
+	state.Config.Delegation.Rules = []DelegationRuleConfig{{
+		Requesters:      []string{"bastion"},
+		Targets:         []string{"deploy*"},
+		MaxDurationSecs: 3600,
+	}}
+	request := func(requester, target, duration, certType string,
+		expectedStatus int) {
+		req, err := createKeyBodyRequest("POST", certgenPath+target,
+			testUserSSHPublicKey, duration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if certType != "" {
+			req.URL.RawQuery = "type=" + certType
+		}
+		cookieVal, err := state.setNewAuthCookie(nil, nil, requester,
+			AuthTypeU2F)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		_, err = checkRequestHandlerCode(req, state.certGenHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatalf("%s for %s: %s", requester, target, err)
+		}
+	}
+	request("bastion", "deploy", "1h", "", http.StatusOK)
+	request("bastion", "username", "1h", "", http.StatusForbidden)
+	request("mallory", "deploy", "1h", "", http.StatusForbidden)
+	request("bastion", "deploy", "2h", "", http.StatusForbidden)
+	request("bastion", "deploy", "1h", "x509", http.StatusForbidden)
+	request("bastion", "deploy-admin", "1h", "", http.StatusForbidden)
+
+	events, err := state.attestationLog.Events(time.Time{},
+		time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Username != "deploy" ||
+		events[0].RequestedBy != "bastion" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	demoHttpAddress  = "localhost:33443"
+	demoAdminAddress = "localhost:36920"
+	demoRSAKeySize   = 2048
+)
+
+var demoUsernames = []string{"alice", "bob"}
+
+// demoEnvironment describes a throwaway all-in-one instance.
+type demoEnvironment struct {
+	directory      string
+	configFilename string
+	passwords      map[string]string
+}
+
+func findDemoSharedDataDirectory() string {
+	candidates := []string{"/usr/share/keymasterd"}
+	if executable, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Join(
+			filepath.Dir(executable), "..", "share", "keymasterd"))
+	}
+	for _, candidate := range candidates {
+		_, err := os.Stat(filepath.Join(candidate, "customization_data",
+			"templates"))
+		if err == nil {
+			return candidate
+		}
+	}
+	return "" // Relative to the working directory, as for development.
+}
+
+func genDemoPassword() (string, error) {
+	buf := make([]byte, 9)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// setupDemoEnvironment writes a configuration with a new unencrypted CA,
+// a self signed server certificate, local users with random passwords and a
+// sample host inventory into a new temporary directory.
+func setupDemoEnvironment(sharedDataDirectory string) (*demoEnvironment, error) {
+	directory, err := ioutil.TempDir("", "keymaster-demo")
+	if err != nil {
+		return nil, err
+	}
+	env, err := writeDemoEnvironment(directory, sharedDataDirectory)
+	if err != nil {
+		os.RemoveAll(directory)
+		return nil, err
+	}
+	return env, nil
+}
+
+func writeDemoEnvironment(directory string, sharedDataDirectory string) (
+	*demoEnvironment, error) {
+	env := &demoEnvironment{
+		directory:      directory,
+		configFilename: filepath.Join(directory, "config.yml"),
+		passwords:      make(map[string]string),
+	}
+	var config AppConfigFile
+	config.Base.HttpAddress = demoHttpAddress
+	config.Base.AdminAddress = demoAdminAddress
+	config.Base.SharedDataDirectory = sharedDataDirectory
+	config.Base.DataDirectory = filepath.Join(directory, "data")
+	if err := os.MkdirAll(config.Base.DataDirectory, 0700); err != nil {
+		return nil, err
+	}
+	config.Base.SSHCAFilename = filepath.Join(directory, "ca.key")
+	err := generateArmoredEncryptedCAPrivateKey(nil, config.Base.SSHCAFilename)
+	if err != nil {
+		return nil, err
+	}
+	if err := generateCerts(directory, &config.Base, demoRSAKeySize, false); err != nil {
+		return nil, err
+	}
+	var htpasswd []string
+	for _, username := range demoUsernames {
+		password, err := genDemoPassword()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password),
+			bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		env.passwords[username] = password
+		// Same algorithm, with the prefix written by "htpasswd -B".
+		htpasswd = append(htpasswd, username+":$2y$"+
+			strings.TrimPrefix(string(hash), "$2a$"))
+	}
+	config.Base.HtpasswdFilename = filepath.Join(directory, "passfile.htpass")
+	err = ioutil.WriteFile(config.Base.HtpasswdFilename,
+		[]byte(strings.Join(htpasswd, "\n")+"\n"), 0600)
+	if err != nil {
+		return nil, err
+	}
+	// Sample policy: passwords are enough for the demo, alice administers
+	// it, and one host may request an SMTP relay certificate.
+	config.Base.AllowedAuthBackendsForCerts = []string{proto.AuthTypePassword}
+	config.Base.AllowedAuthBackendsForWebUI = []string{proto.AuthTypePassword}
+	config.Base.AdminUsers = []string{demoUsernames[0]}
+	config.HostInventory.Filename = filepath.Join(directory, "hosts.yml")
+	err = ioutil.WriteFile(config.HostInventory.Filename, []byte(
+		"hosts:\n"+
+			"  - identity: relay1\n"+
+			"    dns_names: [relay1.demo.example.com]\n"+
+			"    profiles: [x509-smtp-relay]\n"), 0644)
+	if err != nil {
+		return nil, err
+	}
+	configText, err := yaml.Marshal(&config)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(env.configFilename, configText, 0600); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func (env *demoEnvironment) printInstructions(w io.Writer) {
+	caPub, _ := ioutil.ReadFile(filepath.Join(env.directory, "ca.key.pub"))
+	fmt.Fprintf(w, `
+Keymaster demo instance (everything is deleted on exit)
+  Directory:   %s
+  Web UI:      https://%s/
+  Admin port:  https://%s/
+
+Users:
+`, env.directory, demoHttpAddress, demoAdminAddress)
+	for _, username := range demoUsernames {
+		fmt.Fprintf(w, "  %-6s %s\n", username, env.passwords[username])
+	}
+	fmt.Fprintf(w, `
+Get certificates with the client:
+  keymaster -configHost %s -rootCAFilename %s -username %s
+
+Trust the demo CA on a test SSH server (sshd_config):
+  TrustedUserCAKeys %s
+or for a single account in ~/.ssh/authorized_keys:
+  cert-authority %s
+The sample host inventory is in %s.
+`, demoHttpAddress, filepath.Join(env.directory, "server.pem"),
+		demoUsernames[0], filepath.Join(env.directory, "ca.key.pub"),
+		strings.TrimSpace(string(caPub)),
+		filepath.Join(env.directory, "hosts.yml"))
+}
+
+func (env *demoEnvironment) remove() error {
+	return os.RemoveAll(env.directory)
+}
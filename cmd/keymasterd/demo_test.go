@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/authutil"
+)
+
+func TestDemoEnvironment(t *testing.T) {
+	env, err := setupDemoEnvironment("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.remove()
+	config, err := ioutil.ReadFile(env.configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(config, []byte(demoHttpAddress)) {
+		t.Fatal("config does not use the demo address")
+	}
+	htpasswd, err := ioutil.ReadFile(env.directory + "/passfile.htpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range demoUsernames {
+		ok, err := authutil.CheckHtpasswdUserPassword(username,
+			env.passwords[username], htpasswd)
+		if err != nil || !ok {
+			t.Fatalf("password of %s does not match: %v", username, err)
+		}
+	}
+	var instructions bytes.Buffer
+	env.printInstructions(&instructions)
+	if !strings.Contains(instructions.String(), env.passwords["alice"]) ||
+		!strings.Contains(instructions.String(), "cert-authority ssh-rsa ") {
+		t.Fatalf("incomplete instructions:\n%s", instructions.String())
+	}
+	if err := env.remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(env.directory); !os.IsNotExist(err) {
+		t.Fatal("demo directory not removed")
+	}
+}
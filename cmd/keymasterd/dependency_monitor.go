@@ -102,6 +102,7 @@ func checkLDAPConfigs(config AppConfigFile, rootCAs *x509.CertPool) {
 func (state *RuntimeState) doDependencyMonitoring(secsBetweenChecks int) {
 	for {
 		checkLDAPConfigs(state.Config, nil)
+		state.updateBreakGlass()
 		time.Sleep(time.Duration(secsBetweenChecks) * time.Second)
 	}
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/dnspublish"
+	"github.com/Symantec/keymaster/keymasterd/lifecycle"
+)
+
+const (
+	dnsPublicationComponentName         = "dns_publication"
+	defaultDNSPublicationWeight         = 10
+	defaultDNSPublicationTTLSecs        = 30
+	defaultDNSPublicationIntervalSecs   = 30
+	dnsPublicationLeaseIntervalMultiple = 3
+)
+
+func (config *DNSPublicationConfig) interval() time.Duration {
+	if config.IntervalSecs < 1 {
+		return defaultDNSPublicationIntervalSecs * time.Second
+	}
+	return time.Duration(config.IntervalSecs) * time.Second
+}
+
+// dnsPublicationInstance returns the record published for this instance.
+func dnsPublicationInstance(config *AppConfigFile, hostIdentity string) (
+	dnspublish.Instance, error) {
+	dnsConfig := config.DNSPublication
+	instance := dnspublish.Instance{
+		ID:     dnsConfig.InstanceID,
+		Name:   dnsConfig.Name,
+		Target: dnsConfig.Target,
+		Port:   dnsConfig.Port,
+		Weight: dnsConfig.Weight,
+		TTL:    time.Duration(dnsConfig.TTLSecs) * time.Second,
+	}
+	if instance.Name == "" {
+		return instance, errors.New("no name")
+	}
+	if instance.ID == "" {
+		instance.ID = hostIdentity
+	}
+	if instance.Target == "" {
+		instance.Target = hostIdentity
+	}
+	if instance.ID == "" || instance.Target == "" {
+		return instance, errors.New("no instance_id or target")
+	}
+	if instance.Port == 0 {
+		instance.Port = 443
+		if config.Base.PublicPort != 0 {
+			instance.Port = config.Base.PublicPort
+		} else if config.Base.HttpAddress != "" {
+			_, port, err := net.SplitHostPort(config.Base.HttpAddress)
+			if err != nil {
+				return instance, err
+			}
+			portNumber, err := strconv.ParseUint(port, 10, 16)
+			if err != nil {
+				return instance, fmt.Errorf("bad http_address port: %s", port)
+			}
+			instance.Port = uint16(portNumber)
+		}
+	}
+	if instance.Weight == 0 {
+		instance.Weight = defaultDNSPublicationWeight
+	}
+	if instance.TTL == 0 {
+		instance.TTL = defaultDNSPublicationTTLSecs * time.Second
+	}
+	return instance, nil
+}
+
+// loadClientTLSConfig returns the TLS configuration of a client trusting the
+// CAs in caFilename, or the system roots if empty, and presenting the
+// certificate in certFilename and keyFilename, if set.
+func loadClientTLSConfig(caFilename, certFilename, keyFilename string) (
+	*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFilename != "" {
+		buffer, err := ioutil.ReadFile(caFilename)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(buffer) {
+			return nil, fmt.Errorf("no certificates in %s", caFilename)
+		}
+	}
+	if certFilename != "" || keyFilename != "" {
+		cert, err := tls.LoadX509KeyPair(certFilename, keyFilename)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func newDNSPublicationBackend(config *DNSPublicationConfig) (
+	dnspublish.Backend, error) {
+	return newDNSBackend(config.Backend, config.Route53, config.Etcd,
+		dnsPublicationLeaseIntervalMultiple*config.interval())
+}
+
+// newDNSBackend returns the DNS backend named backend. Records written to
+// etcd expire after leaseTTL unless written again.
+func newDNSBackend(backend string, route53 DNSPublicationRoute53Config,
+	etcd DNSPublicationEtcdConfig, leaseTTL time.Duration) (
+	dnspublish.Backend, error) {
+	switch backend {
+	case "route53":
+		return dnspublish.NewRoute53(dnspublish.Route53Config{
+			HostedZoneID:    route53.HostedZoneID,
+			AccessKeyID:     route53.AccessKeyID,
+			SecretAccessKey: route53.SecretAccessKey,
+		})
+	case "etcd":
+		tlsConfig, err := loadClientTLSConfig(etcd.CAFilename,
+			etcd.CertFilename, etcd.KeyFilename)
+		if err != nil {
+			return nil, err
+		}
+		return dnspublish.NewEtcd(dnspublish.EtcdConfig{
+			Endpoints: etcd.Endpoints,
+			Path:      etcd.Path,
+			LeaseTTL:  leaseTTL,
+			TLSConfig: tlsConfig,
+		})
+	}
+	return nil, fmt.Errorf("unknown backend: %s", backend)
+}
+
+// dnsPublicationComponent returns the component publishing this instance in
+// DNS, or nil if publication is disabled. Publication failures are logged
+// but do not make the instance unready, as that would only withdraw it
+// everywhere else too.
+func (state *RuntimeState) dnsPublicationComponent(
+	components *lifecycle.Manager) (lifecycle.Component, error) {
+	if state.Config.DNSPublication.Backend == "" {
+		return nil, nil
+	}
+	instance, err := dnsPublicationInstance(&state.Config, state.HostIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("dns_publication: %s", err)
+	}
+	backend, err := newDNSPublicationBackend(&state.Config.DNSPublication)
+	if err != nil {
+		return nil, fmt.Errorf("dns_publication: %s", err)
+	}
+	isReady := func() bool {
+		for _, status := range components.Status() {
+			if status.Name != dnsPublicationComponentName && !status.Healthy {
+				return false
+			}
+		}
+		return true
+	}
+	publisher := dnspublish.New(backend, instance,
+		state.Config.DNSPublication.interval(), isReady, logger)
+	if name := state.Config.DNSPublication.FingerprintName; name != "" {
+		err := publisher.AddTXT(name, instance.TTL, state.caFingerprintRecords)
+		if err != nil {
+			return nil, fmt.Errorf("dns_publication: fingerprint_name: %s",
+				err)
+		}
+	}
+	return lifecycle.Funcs{
+		StartFunc: func() error {
+			publisher.Start()
+			return nil
+		},
+		StopFunc: publisher.Stop,
+	}, nil
+}
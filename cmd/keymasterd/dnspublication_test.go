@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/dnspublish"
+	"github.com/Symantec/keymaster/keymasterd/lifecycle"
+)
+
+func TestDNSPublicationInstance(t *testing.T) {
+	var config AppConfigFile
+	if _, err := dnsPublicationInstance(&config, "km1.example.com"); err == nil {
+		t.Error("instance without a name accepted")
+	}
+	config.DNSPublication.Name = "keymaster.example.com"
+	config.Base.HttpAddress = ":8443"
+	instance, err := dnsPublicationInstance(&config, "km1.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := dnspublish.Instance{
+		ID:     "km1.example.com",
+		Name:   "keymaster.example.com",
+		Target: "km1.example.com",
+		Port:   8443,
+		Weight: defaultDNSPublicationWeight,
+		TTL:    defaultDNSPublicationTTLSecs * time.Second,
+	}
+	if instance != expected {
+		t.Errorf("instance %+v, expected %+v", instance, expected)
+	}
+	config.Base.HttpAddress = ":https"
+	if _, err := dnsPublicationInstance(&config, "km1.example.com"); err == nil {
+		t.Error("named port accepted")
+	}
+}
+
+func TestRegisterDNSPublicationComponent(t *testing.T) {
+	state := RuntimeState{HostIdentity: "km1.example.com"}
+	state.Config.DNSPublication.Backend = "nsupdate"
+	state.Config.DNSPublication.Name = "keymaster.example.com"
+	components := lifecycle.New(time.Hour, logger)
+	err := state.registerComponents(components, &http.Server{},
+		&http.Server{})
+	if err == nil {
+		t.Fatal("unknown backend accepted")
+	}
+	state.Config.DNSPublication.Backend = "etcd"
+	state.Config.DNSPublication.Etcd.Endpoints = []string{
+		"https://etcd.example.com:2379"}
+	components = lifecycle.New(time.Hour, logger)
+	err = state.registerComponents(components, &http.Server{},
+		&http.Server{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := components.Init(); err != nil {
+		t.Fatal(err)
+	}
+	statuses := components.Status()
+	if statuses[len(statuses)-1].Name != dnsPublicationComponentName {
+		t.Fatal("DNS publication must start after the service server")
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+
+	"github.com/howeyc/gopass"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/ssh"
+)
+
+// The CA private key in ssh_ca_filename is one of:
+//
+//	an RSA or EC PEM key, optionally encrypted ("ssh-keygen -m PEM")
+//	an OpenSSH private key, optionally protected by a passphrase
+//	an armored PGP message holding a PEM key, as written by -generateConfig
+//
+// The passphrase of an encrypted key is taken from ssh_ca_passphrase, which
+// is normally supplied with KEYMASTER_BASE_SSH_CA_PASSPHRASE(_FILE) or
+// ssh_ca_passphrase_file. Without it keymasterd asks for it on the terminal,
+// unless a client CA is configured and the key can be unsealed through
+// secretInjectorPath.
+const pgpCAKeyPrefix = "-----BEGIN PGP MESSAGE-----"
+
+var errCAPassphraseRequired = errors.New("CA private key is encrypted")
+
+// readCAPassphrase asks for the CA passphrase. It is replaced by tests.
+var readCAPassphrase = func() ([]byte, error) {
+	fmt.Printf("Please enter the passphrase of the CA private key:\n")
+	return gopass.GetPasswd()
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// caKeyIsEncrypted returns true if the CA private key in data needs a
+// passphrase.
+func caKeyIsEncrypted(data []byte) bool {
+	if bytes.HasPrefix(data, []byte(pgpCAKeyPrefix)) {
+		return true
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false
+	}
+	if block.Type == "OPENSSH PRIVATE KEY" {
+		return openSSHKeyIsEncrypted(block.Bytes)
+	}
+	return x509.IsEncryptedPEMBlock(block)
+}
+
+// openSSHKeyIsEncrypted reads the cipher name which follows the magic of
+// the "openssh-key-v1" format.
+func openSSHKeyIsEncrypted(data []byte) bool {
+	const magic = "openssh-key-v1\x00"
+	if !bytes.HasPrefix(data, []byte(magic)) || len(data) < len(magic)+4 {
+		return false
+	}
+	data = data[len(magic):]
+	length := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 |
+		int(data[3])
+	if length < 0 || len(data) < 4+length {
+		return false
+	}
+	return string(data[4:4+length]) != "none"
+}
+
+// parseCAPrivateKey returns the signer for the CA private key in data,
+// decrypting it with passphrase if needed. It returns
+// errCAPassphraseRequired for encrypted keys if passphrase is empty. The
+// plaintext copies of the key are wiped before it returns.
+func parseCAPrivateKey(data, passphrase []byte) (crypto.Signer, error) {
+	if bytes.HasPrefix(data, []byte(pgpCAKeyPrefix)) {
+		if len(passphrase) < 1 {
+			return nil, errCAPassphraseRequired
+		}
+		plaintext, err := decryptPGPCAKey(data, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		defer wipeBytes(plaintext)
+		return parseCAPrivateKey(plaintext, nil)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("cannot decode CA private key")
+	}
+	defer wipeBytes(block.Bytes)
+	if block.Type == "OPENSSH PRIVATE KEY" {
+		return parseOpenSSHCAKey(data, passphrase)
+	}
+	if !x509.IsEncryptedPEMBlock(block) {
+		return parsePEMCAKey(block.Type, block.Bytes)
+	}
+	if len(passphrase) < 1 {
+		return nil, errCAPassphraseRequired
+	}
+	der, err := x509.DecryptPEMBlock(block, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt CA private key: %s", err)
+	}
+	defer wipeBytes(der)
+	return parsePEMCAKey(block.Type, der)
+}
+
+func parsePEMCAKey(blockType string, der []byte) (crypto.Signer, error) {
+	switch blockType {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported CA private key type: %T", key)
+	}
+	return nil, fmt.Errorf("unsupported CA private key type: %s", blockType)
+}
+
+func parseOpenSSHCAKey(data, passphrase []byte) (crypto.Signer, error) {
+	var key interface{}
+	var err error
+	if len(passphrase) > 0 {
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+	} else {
+		key, err = ssh.ParseRawPrivateKey(data)
+	}
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return nil, errCAPassphraseRequired
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse CA private key: %s", err)
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	case *ed25519.PrivateKey:
+		return *key, nil
+	}
+	return nil, fmt.Errorf("unsupported CA private key type: %T", key)
+}
+
+// decryptPGPCAKey returns the content of the armored, symmetrically
+// encrypted PGP message in data.
+func decryptPGPCAKey(data, passphrase []byte) ([]byte, error) {
+	armorBlock, err := armor.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("cannot decode armored CA private key")
+	}
+	failed := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		// If the given passphrase isn't correct, the function will be called again, forever.
+		// This method will fail fast.
+		// Ref: https://godoc.org/golang.org/x/crypto/openpgp#PromptFunction
+		if failed {
+			return nil, errors.New("decryption failed")
+		}
+		failed = true
+		return passphrase, nil
+	}
+	md, err := openpgp.ReadMessage(armorBlock.Body, nil, prompt, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt CA private key: %s", err)
+	}
+	return ioutil.ReadAll(md.UnverifiedBody)
+}
+
+// loadCAPrivateKey returns the signer for the CA private key in data using
+// the configured passphrase, or the one typed on the terminal if the key
+// cannot be unsealed remotely. It returns errCAPassphraseRequired if the key
+// is to be unsealed through secretInjectorPath.
+func (state *RuntimeState) loadCAPrivateKey(data []byte) (crypto.Signer,
+	error) {
+	passphrase := []byte(state.Config.Base.SSHCAPassphrase)
+	// Nothing else needs the passphrase once the key is loaded.
+	state.Config.Base.SSHCAPassphrase = ""
+	defer func() { wipeBytes(passphrase) }()
+	if len(passphrase) < 1 && state.ClientCAPool == nil &&
+		caKeyIsEncrypted(data) && stdinIsTerminal() {
+		var err error
+		passphrase, err = readCAPassphrase()
+		if err != nil {
+			return nil, err
+		}
+	}
+	signer, err := parseCAPrivateKey(data, passphrase)
+	if err == errCAPassphraseRequired && state.ClientCAPool == nil {
+		return nil, errors.New("the CA private key is encrypted: set " +
+			"ssh_ca_passphrase or a client CA to unseal it")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := state.unsealNextCAKey(passphrase); err != nil {
+		zeroizeSigner(signer)
+		return nil, err
+	}
+	return signer, nil
+}
+
+func wipeBytes(data []byte) {
+	for index := range data {
+		data[index] = 0
+	}
+}
+
+func wipeBigInt(value *big.Int) {
+	if value == nil {
+		return
+	}
+	words := value.Bits()
+	for index := range words {
+		words[index] = 0
+	}
+	value.SetInt64(0)
+}
+
+// zeroizeSigner overwrites the private values of software keys. Copies made
+// internally by the crypto packages are out of reach.
+func zeroizeSigner(signer crypto.Signer) {
+	switch key := signer.(type) {
+	case *rsa.PrivateKey:
+		wipeBigInt(key.D)
+		for _, prime := range key.Primes {
+			wipeBigInt(prime)
+		}
+		wipeBigInt(key.Precomputed.Dp)
+		wipeBigInt(key.Precomputed.Dq)
+		wipeBigInt(key.Precomputed.Qinv)
+	case *ecdsa.PrivateKey:
+		wipeBigInt(key.D)
+	case ed25519.PrivateKey:
+		wipeBytes(key)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const testCAPassphrase = "correct horse"
+
+func encryptedPEMCAKey(t *testing.T, key *rsa.PrivateKey) []byte {
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY",
+		x509.MarshalPKCS1PrivateKey(key), []byte(testCAPassphrase),
+		x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(block)
+}
+
+func checkEncryptedCAKey(t *testing.T, name string, data []byte,
+	public interface{}) {
+	if !caKeyIsEncrypted(data) {
+		t.Errorf("%s: not detected as encrypted", name)
+	}
+	if _, err := parseCAPrivateKey(data, nil); err != errCAPassphraseRequired {
+		t.Errorf("%s: without passphrase: %v", name, err)
+	}
+	if _, err := parseCAPrivateKey(data, []byte("wrong")); err == nil {
+		t.Errorf("%s: wrong passphrase accepted", name)
+	}
+	signer, err := parseCAPrivateKey(data, []byte(testCAPassphrase))
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if !reflect.DeepEqual(signer.Public(), public) {
+		t.Errorf("%s: wrong key", name)
+	}
+}
+
+func TestParseEncryptedCAPrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEncryptedCAKey(t, "PEM", encryptedPEMCAKey(t, key), &key.PublicKey)
+
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, "",
+		[]byte(testCAPassphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEncryptedCAKey(t, "OpenSSH", pem.EncodeToMemory(block),
+		&key.PublicKey)
+
+	dir, err := ioutil.TempDir("", "encryptedca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "ca.key")
+	err = writeArmoredEncryptedCAPrivateKey(key, []byte(testCAPassphrase),
+		filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEncryptedCAKey(t, "PGP", data, &key.PublicKey)
+}
+
+func TestWriteNonRSACAPrivateKey(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "encryptedca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, key := range map[string]crypto.Signer{"ECDSA": ecdsaKey,
+		"Ed25519": ed25519Key} {
+		filename := filepath.Join(dir, name)
+		err := writeArmoredEncryptedCAPrivateKey(key, []byte(testCAPassphrase),
+			filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEncryptedCAKey(t, name, data, key.Public())
+	}
+}
+
+func TestParseUnencryptedCAPrivateKey(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(block)
+	if caKeyIsEncrypted(data) {
+		t.Error("unencrypted OpenSSH key detected as encrypted")
+	}
+	signer, err := parseCAPrivateKey(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(signer.Public(), public) {
+		t.Error("wrong key")
+	}
+	signer, err = parseCAPrivateKey([]byte(testSignerPrivateKey), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := signer.(*rsa.PrivateKey); !ok {
+		t.Errorf("unexpected signer %T", signer)
+	}
+}
+
+func TestLoadEncryptedCAPrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := encryptedPEMCAKey(t, key)
+	savedReadCAPassphrase := readCAPassphrase
+	defer func() { readCAPassphrase = savedReadCAPassphrase }()
+	readCAPassphrase = func() ([]byte, error) {
+		return nil, errors.New("no terminal in tests")
+	}
+	var state RuntimeState
+	if _, err := state.loadCAPrivateKey(data); err == nil {
+		t.Error("encrypted key without passphrase or client CA loaded")
+	}
+	state.ClientCAPool = x509.NewCertPool()
+	if _, err := state.loadCAPrivateKey(data); err != errCAPassphraseRequired {
+		t.Errorf("expected the key to wait for unsealing, got: %v", err)
+	}
+	state.Config.Base.SSHCAPassphrase = testCAPassphrase
+	if _, err := state.loadCAPrivateKey(data); err != nil {
+		t.Fatal(err)
+	}
+	if state.Config.Base.SSHCAPassphrase != "" {
+		t.Error("passphrase kept in the configuration")
+	}
+}
+
+func TestZeroizeSigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zeroizeSigner(key)
+	if key.D.Sign() != 0 || key.Primes[0].Sign() != 0 ||
+		key.Primes[1].Sign() != 0 || key.Precomputed.Dp.Sign() != 0 {
+		t.Error("RSA private values not wiped")
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zeroizeSigner(edKey)
+	for _, b := range edKey {
+		if b != 0 {
+			t.Fatal("Ed25519 private key not wiped")
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/faultinjection"
+	"github.com/Symantec/keymaster/lib/pwauth"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Dependencies faults can be injected into with fault_injection enabled.
+const (
+	faultTargetLDAP    = "ldap"    // Password checks and LDAP group lookups.
+	faultTargetSigning = "signing" // Certificate requests.
+	faultTargetStorage = "storage" // Writes to the profile database.
+)
+
+var faultTargets = []string{faultTargetLDAP, faultTargetSigning,
+	faultTargetStorage}
+
+const (
+	defaultFaultDuration = 10 * time.Minute
+	maxFaultDuration     = 24 * time.Hour
+)
+
+var injectedFaultCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "keymaster_injected_fault_counter",
+		Help: "Calls delayed or failed by fault injection.",
+	},
+	[]string{"target", "action"},
+)
+
+// injectFault applies the fault injected into target, if any, and returns
+// an error if the call must fail.
+func (state *RuntimeState) injectFault(target string) error {
+	injected, err := state.faultInjector.Inject(target)
+	if !injected {
+		return nil
+	}
+	action := "delay"
+	if err != nil {
+		action = "fail"
+	}
+	metricsMutex.Lock()
+	injectedFaultCounter.WithLabelValues(target, action).Inc()
+	metricsMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("%s: %s", target, err)
+	}
+	return nil
+}
+
+// faultInjectingPasswordChecker injects the ldap faults into the checks of
+// the password backend.
+type faultInjectingPasswordChecker struct {
+	pwauth.PasswordAuthenticator
+	state *RuntimeState
+}
+
+func (checker *faultInjectingPasswordChecker) PasswordAuthenticate(
+	username string, password []byte) (bool, error) {
+	if err := checker.state.injectFault(faultTargetLDAP); err != nil {
+		return false, err
+	}
+	return checker.PasswordAuthenticator.PasswordAuthenticate(username,
+		password)
+}
+
+func (checker *faultInjectingPasswordChecker) PasswordAuthenticateContext(
+	ctx context.Context, username string, password []byte) (bool, error) {
+	if err := checker.state.injectFault(faultTargetLDAP); err != nil {
+		return false, err
+	}
+	return pwauth.PasswordAuthenticateContext(ctx,
+		checker.PasswordAuthenticator, username, password)
+}
+
+// parseFault parses the parameters of inject-fault. action is "fail" or a
+// delay such as "2s".
+func parseFault(target, percent, action, duration string,
+	now time.Time) (faultinjection.Fault, error) {
+	fault := faultinjection.Fault{Target: target}
+	known := false
+	for _, faultTarget := range faultTargets {
+		known = known || target == faultTarget
+	}
+	if !known {
+		return fault, fmt.Errorf("unknown target %q, use one of %v", target,
+			faultTargets)
+	}
+	var err error
+	fault.Percent, err = strconv.ParseFloat(percent, 64)
+	if err != nil {
+		return fault, fmt.Errorf("bad percent: %s", percent)
+	}
+	if action == "fail" {
+		fault.Fail = true
+	} else if fault.Delay, err = time.ParseDuration(action); err != nil {
+		return fault, fmt.Errorf("bad action %q, use fail or a delay", action)
+	}
+	lifetime := defaultFaultDuration
+	if duration != "" {
+		lifetime, err = time.ParseDuration(duration)
+		if err != nil || lifetime <= 0 || lifetime > maxFaultDuration {
+			return fault, fmt.Errorf("bad duration: %s", duration)
+		}
+	}
+	fault.Until = now.Add(lifetime)
+	return fault, nil
+}
+
+func (state *RuntimeState) checkFaultInjectionEnabled(w http.ResponseWriter,
+	r *http.Request) bool {
+	if state.faultInjector == nil {
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"fault_injection is not enabled")
+		return false
+	}
+	return true
+}
+
+func (state *RuntimeState) adminInjectFaultHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if !state.checkFaultInjectionEnabled(w, r) {
+		return
+	}
+	fault, err := parseFault(r.FormValue("target"), r.FormValue("percent"),
+		r.FormValue("action"), r.FormValue("duration"), time.Now())
+	if err == nil {
+		err = state.faultInjector.Set(fault)
+	}
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	requestLogger(r).Printf("Injecting fault into %g%% of %s calls (delay %s, fail %v) "+
+		"until %s", fault.Percent, fault.Target, fault.Delay, fault.Fail,
+		fault.Until.Format(time.RFC3339))
+	fmt.Fprintf(w, "Injecting fault into %s until %s\n", fault.Target,
+		fault.Until.Format(time.RFC3339))
+}
+
+func (state *RuntimeState) adminClearFaultsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if !state.checkFaultInjectionEnabled(w, r) {
+		return
+	}
+	cleared := state.faultInjector.Clear(r.FormValue("target"))
+	requestLogger(r).Printf("Cleared %d injected faults", cleared)
+	fmt.Fprintf(w, "Cleared %d faults\n", cleared)
+}
+
+func (state *RuntimeState) adminListFaultsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if !state.checkFaultInjectionEnabled(w, r) {
+		return
+	}
+	faults := state.faultInjector.Faults()
+	if len(faults) < 1 {
+		fmt.Fprintln(w, "No faults injected")
+		return
+	}
+	for _, fault := range faults {
+		action := "delay " + fault.Delay.String()
+		if fault.Fail {
+			action = "fail"
+		}
+		fmt.Fprintf(w, "%s: %g%%, %s until %s\n", fault.Target,
+			fault.Percent, action, fault.Until.Format(time.RFC3339))
+	}
+}
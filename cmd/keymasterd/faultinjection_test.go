@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/faultinjection"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+func TestParseFault(t *testing.T) {
+	now := time.Now()
+	fault, err := parseFault("ldap", "25", "2s", "1h", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fault.Percent != 25 || fault.Delay != 2*time.Second || fault.Fail ||
+		!fault.Until.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected fault: %+v", fault)
+	}
+	fault, err = parseFault("storage", "100", "fail", "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fault.Fail || !fault.Until.Equal(now.Add(defaultFaultDuration)) {
+		t.Errorf("unexpected fault: %+v", fault)
+	}
+	for _, args := range [][]string{
+		{"dns", "10", "fail", ""},
+		{"ldap", "ten", "fail", ""},
+		{"ldap", "10", "crash", ""},
+		{"ldap", "10", "fail", "48h"},
+	} {
+		if _, err := parseFault(args[0], args[1], args[2], args[3],
+			now); err == nil {
+			t.Errorf("bad fault accepted: %v", args)
+		}
+	}
+	_, _, values, err := adminSocketRequest([]string{"inject-fault", "ldap",
+		"50", "2s", "5m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values.Get("action") != "2s" || values.Get("duration") != "5m" {
+		t.Errorf("unexpected values: %v", values)
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "faultinjection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.Config.Base.DataDirectory = dir
+	if err := initDB(state); err != nil {
+		t.Fatal(err)
+	}
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := state.newAdminSocketMux()
+	admin := func(method, path string, values url.Values,
+		expectedStatus int) string {
+		req := httptest.NewRequest(method, path+"?"+values.Encode(), nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != expectedStatus {
+			t.Fatalf("%s: got status %d, want %d: %s", path, rr.Code,
+				expectedStatus, rr.Body)
+		}
+		return rr.Body.String()
+	}
+	failSigning := url.Values{"target": {"signing"}, "percent": {"100"},
+		"action": {"fail"}}
+	admin("POST", adminSocketInjectFaultPath, failSigning,
+		http.StatusForbidden)
+
+	state.faultInjector = faultinjection.New()
+	admin("POST", adminSocketInjectFaultPath, failSigning, http.StatusOK)
+	if output := admin("GET", adminSocketListFaultsPath, nil,
+		http.StatusOK); !strings.HasPrefix(output, "signing: 100%, fail") {
+		t.Errorf("unexpected fault list: %s", output)
+	}
+	body, err := json.Marshal(proto.CertRequest{
+		PublicKey: testUserSSHPublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certgen := func(expectedStatus int) {
+		req := httptest.NewRequest("POST", "/certgen/username",
+			bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		if _, err := checkRequestHandlerCode(req, state.certGenHandler,
+			expectedStatus); err != nil {
+			t.Fatal(err)
+		}
+	}
+	certgen(http.StatusInternalServerError)
+	if err := state.SaveUserProfile("username", &userProfile{}); err != nil {
+		t.Fatal(err)
+	}
+	admin("POST", adminSocketInjectFaultPath, url.Values{
+		"target": {"storage"}, "percent": {"100"}, "action": {"fail"}},
+		http.StatusOK)
+	if err := state.SaveUserProfile("username", &userProfile{}); err == nil {
+		t.Error("storage fault not injected")
+	}
+	if output := admin("POST", adminSocketClearFaultsPath, nil,
+		http.StatusOK); !strings.Contains(output, "Cleared") {
+		t.Errorf("unexpected output: %s", output)
+	}
+	certgen(http.StatusOK)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v2"
+)
+
+const generateCACommand = "generate-ca"
+
+const (
+	caKeyTypeECDSA   = "ecdsa"
+	caKeyTypeEd25519 = "ed25519"
+	caKeyTypeRSA     = "rsa"
+)
+
+const caKeyBasename = "ca_key"
+
+type generateCAOptions struct {
+	KeyType       string
+	RSABits       int
+	Directory     string
+	DataDirectory string
+	HostIdentity  string
+}
+
+func getGenerateCAPassphrase() ([]byte, error) {
+	fmt.Printf("Passphrase to encrypt the CA key (empty for none)\n")
+	return getPassphrase()
+}
+
+// generateCA implements "keymasterd generate-ca". It creates a CA key pair,
+// the HTTPS key pair and a starter configuration at configFilename and
+// writes the CA public key in OpenSSH format to w. Existing files are never
+// overwritten: nothing is written if any of them exists, and each file is
+// created exclusively.
+func generateCA(configFilename string, args []string, w io.Writer,
+	getCAPassphrase func() ([]byte, error)) error {
+	var options generateCAOptions
+	flagSet := flag.NewFlagSet(generateCACommand, flag.ContinueOnError)
+	flagSet.StringVar(&options.KeyType, "type", caKeyTypeRSA,
+		"Type of the CA key: rsa, ecdsa (P-256) or ed25519")
+	flagSet.IntVar(&options.RSABits, "bits", defaultRSAKeySize,
+		"Size of RSA keys")
+	flagSet.StringVar(&options.Directory, "directory", "",
+		"Directory for the keys (default: the directory of -config)")
+	flagSet.StringVar(&options.DataDirectory, "dataDirectory",
+		"/var/lib/keymaster", "Data directory of the server")
+	flagSet.StringVar(&options.HostIdentity, "hostIdentity", "",
+		"Public hostname of the server (default: this host)")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if options.RSABits < minImportedCAKeyBits {
+		return fmt.Errorf("RSA keys need at least %d bits",
+			minImportedCAKeyBits)
+	}
+	if options.Directory == "" {
+		options.Directory = filepath.Dir(configFilename)
+	}
+	if options.HostIdentity == "" {
+		var err error
+		options.HostIdentity, err = os.Hostname()
+		if err != nil {
+			return err
+		}
+	}
+	var config AppConfigFile
+	config.Base.SSHCAFilename = filepath.Join(options.Directory, caKeyBasename)
+	config.Base.HtpasswdFilename = filepath.Join(options.Directory,
+		"passfile.htpass")
+	if err := checkFilesDoNotExist(generateCAFilenames(configFilename,
+		options.Directory, &config.Base, false)); err != nil {
+		return err
+	}
+	signer, err := generateCAKey(options.KeyType, options.RSABits)
+	if err != nil {
+		return err
+	}
+	defer zeroizeSigner(signer)
+	passphrase, err := getCAPassphrase()
+	if err != nil {
+		return err
+	}
+	defer wipeBytes(passphrase)
+	// The admin CA files are only written for an encrypted key.
+	if err := checkFilesDoNotExist(generateCAFilenames(configFilename,
+		options.Directory, &config.Base, len(passphrase) > 0)); err != nil {
+		return err
+	}
+	err = os.MkdirAll(options.Directory, os.ModeDir|0755)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(options.DataDirectory, os.ModeDir|0755)
+	if err != nil {
+		return err
+	}
+	publicKey, err := writeCAKeyPair(signer, passphrase,
+		config.Base.SSHCAFilename, options.HostIdentity)
+	if err != nil {
+		return err
+	}
+	// An encrypted key can then be unsealed with keymaster-unlocker.
+	err = generateCerts(options.Directory, &config.Base, options.RSABits,
+		len(passphrase) > 0)
+	if err != nil {
+		return err
+	}
+	config.Base.HttpAddress = ":443"
+	config.Base.AdminAddress = ":6920"
+	config.Base.HostIdentity = options.HostIdentity
+	config.Base.DataDirectory = options.DataDirectory
+	if err := writeNewFile(config.Base.HtpasswdFilename, nil, 0640); err != nil {
+		return err
+	}
+	configText, err := yaml.Marshal(&config)
+	if err != nil {
+		return err
+	}
+	if err := writeNewFile(configFilename, configText, 0640); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Wrote the CA key to %s and the configuration to %s\n",
+		config.Base.SSHCAFilename, configFilename)
+	fmt.Fprintf(w, "Add users with: htpasswd -B %s <username>\n",
+		config.Base.HtpasswdFilename)
+	fmt.Fprintf(w, "Trust the CA on SSH servers with TrustedUserCAKeys, "+
+		"the public key is:\n%s", ssh.MarshalAuthorizedKey(publicKey))
+	return nil
+}
+
+// generateCAFilenames returns the files written by generateCA.
+func generateCAFilenames(configFilename, directory string, base *baseConfig,
+	needAdminCA bool) []string {
+	return append([]string{configFilename, base.SSHCAFilename,
+		base.SSHCAFilename + ".pub", base.HtpasswdFilename},
+		generatedCertsFilenames(directory, needAdminCA)...)
+}
+
+func checkFilesDoNotExist(filenames []string) error {
+	for _, filename := range filenames {
+		if _, err := os.Lstat(filename); err == nil {
+			return fmt.Errorf("%s already exists", filename)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeNewFile writes data to filename, failing if it exists.
+func writeNewFile(filename string, data []byte, perm os.FileMode) error {
+	file, err := createNewFile(filename, perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func generateCAKey(keyType string, rsaBits int) (crypto.Signer, error) {
+	switch keyType {
+	case caKeyTypeECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case caKeyTypeEd25519:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	case caKeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, rsaBits)
+	}
+	return nil, errors.New("unsupported CA key type: " + keyType)
+}
+
+// writeCAKeyPair writes signer to filename as an OpenSSH private key,
+// encrypted with passphrase unless it is empty, and the public key to
+// filename.pub.
+func writeCAKeyPair(signer crypto.Signer, passphrase []byte, filename,
+	comment string) (ssh.PublicKey, error) {
+	publicKey, err := ssh.NewPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	var block *pem.Block
+	if len(passphrase) > 0 {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(signer, comment,
+			passphrase)
+	} else {
+		block, err = ssh.MarshalPrivateKey(signer, comment)
+	}
+	if err != nil {
+		return nil, err
+	}
+	privateKeyPEM := pem.EncodeToMemory(block)
+	defer wipeBytes(privateKeyPEM)
+	wipeBytes(block.Bytes)
+	if err := writeNewFile(filename, privateKeyPEM, 0600); err != nil {
+		return nil, err
+	}
+	err = writeNewFile(filename+".pub", ssh.MarshalAuthorizedKey(publicKey),
+		0644)
+	if err != nil {
+		return nil, err
+	}
+	return publicKey, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func generateTestCA(t *testing.T, dir string, keyType string,
+	passphrase string) (string, ssh.PublicKey) {
+	configFilename := filepath.Join(dir, "config.yml")
+	output := new(bytes.Buffer)
+	err := generateCA(configFilename, []string{"-type", keyType,
+		"-bits", "2048", "-dataDirectory", filepath.Join(dir, "data"),
+		"-hostIdentity", "keymaster.example.com"}, output,
+		func() ([]byte, error) { return []byte(passphrase), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeyData, err := ioutil.ReadFile(filepath.Join(dir, "ca_key.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(output.String(), string(publicKeyData)) {
+		t.Errorf("public key not printed: %s", output)
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(publicKeyData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return configFilename, publicKey
+}
+
+func TestGenerateCAEd25519(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generateca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	configFilename, publicKey := generateTestCA(t, dir, caKeyTypeEd25519, "")
+	if publicKey.Type() != ssh.KeyAlgoED25519 {
+		t.Errorf("unexpected key type %s", publicKey.Type())
+	}
+	state, err := loadVerifyConfigFile(configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshSigner, err := ssh.NewSignerFromSigner(state.Signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sshSigner.PublicKey(), publicKey) {
+		t.Error("loaded CA key does not match the public key")
+	}
+	err = generateCA(configFilename, nil, ioutil.Discard,
+		func() ([]byte, error) { return nil, nil })
+	if err == nil {
+		t.Error("existing configuration overwritten")
+	}
+}
+
+func TestGenerateCAEncryptedRSA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generateca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	configFilename, publicKey := generateTestCA(t, dir, caKeyTypeRSA,
+		"passphrase")
+	if publicKey.Type() != ssh.KeyAlgoRSA {
+		t.Errorf("unexpected key type %s", publicKey.Type())
+	}
+	// Sealed: waits for keymaster-unlocker with the generated admin CA.
+	state, err := loadVerifyConfigFile(configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Signer != nil || state.ClientCAPool == nil {
+		t.Fatal("encrypted CA key not sealed")
+	}
+	os.Setenv("KEYMASTER_BASE_SSH_CA_PASSPHRASE", "passphrase")
+	defer os.Unsetenv("KEYMASTER_BASE_SSH_CA_PASSPHRASE")
+	state, err = loadVerifyConfigFile(configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Signer == nil {
+		t.Fatal("CA key not unsealed with the passphrase")
+	}
+}
+
+func TestGenerateCABadOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generateca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	noPassphrase := func() ([]byte, error) { return nil, nil }
+	configFilename := filepath.Join(dir, "config.yml")
+	for _, args := range [][]string{
+		{"-type", "dsa"},
+		{"-bits", "1024"},
+	} {
+		err := generateCA(configFilename, append(args, "-dataDirectory", dir),
+			ioutil.Discard, noPassphrase)
+		if err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+	if _, err := os.Stat(configFilename); err == nil {
+		t.Error("configuration written for bad options")
+	}
+}
+
+func TestGenerateCAKeepsExistingFiles(t *testing.T) {
+	for _, existing := range []string{"passfile.htpass", "server.key",
+		"adminCA.pem"} {
+		dir, err := ioutil.TempDir("", "generateca")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir) // clean up
+		filename := filepath.Join(dir, existing)
+		if err := ioutil.WriteFile(filename, []byte("keep"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		err = generateCA(filepath.Join(dir, "config.yml"), []string{
+			"-bits", "2048", "-dataDirectory", filepath.Join(dir, "data")},
+			ioutil.Discard,
+			func() ([]byte, error) { return []byte("passphrase"), nil })
+		if err == nil {
+			t.Errorf("%s: existing file accepted", existing)
+		}
+		if data, err := ioutil.ReadFile(filename); err != nil ||
+			string(data) != "keep" {
+			t.Errorf("%s overwritten", existing)
+		}
+		fileInfos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fileInfos) != 1 {
+			t.Errorf("%s: files written: %d", existing, len(fileInfos))
+		}
+	}
+}
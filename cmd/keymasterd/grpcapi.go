@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/lifecycle"
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"github.com/Symantec/keymaster/proto/keymasterpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The gRPC API (see proto/keymasterpb) is served with mutual TLS. Each call
+// is run as the equivalent HTTP request through the handlers of the service
+// port, so that authentication, issuance policy and access logs are the
+// same for both.
+const (
+	grpcComponentName        = "grpc_server"
+	grpcAuditTailBufferSize  = 256
+	grpcMaxConcurrentStreams = 100
+)
+
+type GRPCConfig struct {
+	// Listen address, e.g. ":6921". Default: no gRPC API.
+	Address string `yaml:"address"`
+}
+
+// grpcErr returns the gRPC error for a failed response.
+func (w *bufferedResponseWriter) grpcErr() error {
+	var code codes.Code
+	switch w.status {
+	case http.StatusBadRequest, http.StatusUnsupportedMediaType,
+		http.StatusRequestEntityTooLarge:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		code = codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	default:
+		code = codes.Internal
+	}
+	return status.Error(code, w.errorMessage())
+}
+
+// grpcPeerCertificate returns the verified client certificate of the call.
+func grpcPeerCertificate(ctx context.Context) (*peer.Peer,
+	*tls.ConnectionState, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, nil, status.Error(codes.Unauthenticated, "no peer")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) < 1 {
+		return nil, nil, status.Error(codes.Unauthenticated,
+			"no client certificate")
+	}
+	return p, &tlsInfo.State, nil
+}
+
+// serveGRPCAsHTTP runs handler for the HTTP request equivalent to a gRPC
+// call, as coming from the peer of ctx over its TLS connection.
+func (state *RuntimeState) serveGRPCAsHTTP(ctx context.Context,
+	httpLogger instrumentedwriter.Logger, method, target string,
+	body []byte, handler http.HandlerFunc) (*bufferedResponseWriter, error) {
+	p, tlsState, err := grpcPeerCertificate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	r = r.WithContext(ctx)
+	r.RemoteAddr = p.Addr.String()
+	r.TLS = tlsState
+	r.Header.Set("User-Agent", "keymaster-grpc")
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := newBufferedResponseWriter()
+	instrumentedwriter.NewLoggingHandler(handler, httpLogger).ServeHTTP(w, r)
+	return w, nil
+}
+
+type grpcIssuanceServer struct {
+	keymasterpb.UnimplementedIssuanceServer
+	state      *RuntimeState
+	httpLogger instrumentedwriter.Logger
+}
+
+func (s *grpcIssuanceServer) IssueCertificate(ctx context.Context,
+	request *keymasterpb.IssueCertificateRequest) (
+	*keymasterpb.IssueCertificateResponse, error) {
+	username := request.Username
+	if username == "" {
+		_, tlsState, err := grpcPeerCertificate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		username = tlsState.VerifiedChains[0][0].Subject.CommonName
+	}
+	body, err := json.Marshal(proto.CertRequest{
+		PublicKeys:  request.PublicKeys,
+		Duration:    request.Duration,
+		Type:        request.Type,
+		AddGroups:   request.AddGroups,
+		Hostnames:   request.Hostnames,
+		IPAddresses: request.IpAddresses,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	query := make(url.Values)
+	for name, value := range request.Parameters {
+		query.Set(name, value)
+	}
+	target := certgenPath + url.PathEscape(username)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	w, err := s.state.serveGRPCAsHTTP(ctx, s.httpLogger, "POST", target, body,
+		s.state.certGenHandler)
+	if err != nil {
+		return nil, err
+	}
+	switch w.status {
+	case http.StatusOK:
+		response := &keymasterpb.IssueCertificateResponse{
+			Certificate: w.body.Bytes(),
+		}
+		_, params, err := mime.ParseMediaType(
+			w.header.Get("Content-Disposition"))
+		if err == nil {
+			response.Filename = params["filename"]
+		}
+		return response, nil
+	case http.StatusAccepted:
+		return &keymasterpb.IssueCertificateResponse{
+			ApprovalUrl: w.header.Get("Location"),
+		}, nil
+	}
+	return nil, w.grpcErr()
+}
+
+type grpcAdminServer struct {
+	keymasterpb.UnimplementedAdminServer
+	state      *RuntimeState
+	httpLogger instrumentedwriter.Logger
+}
+
+// authorize returns an error unless the caller of fullMethod is an admin
+// user with a client certificate of the admin CA. The IP restricted
+// certificates keymaster issues are not accepted, as for the admin port.
+func (s *grpcAdminServer) authorize(ctx context.Context,
+	fullMethod string) (string, error) {
+	var username string
+	w, err := s.state.serveGRPCAsHTTP(ctx, s.httpLogger, "POST",
+		"/grpc"+fullMethod, nil,
+		func(w http.ResponseWriter, r *http.Request) {
+			clientName, ok := s.state.requireAdminClientCert(w, r)
+			if !ok {
+				return
+			}
+			w.(*instrumentedwriter.LoggingWriter).SetUsername(clientName)
+			if !s.state.IsAdminUser(clientName) {
+				requestLogger(r).Printf("gRPC %s refused to %s, not an admin",
+					fullMethod, clientName)
+				s.state.writeFailureResponse(w, r, http.StatusForbidden,
+					"not an admin")
+				return
+			}
+			username = clientName
+			w.WriteHeader(http.StatusOK)
+		})
+	if err != nil {
+		return "", err
+	}
+	if username == "" {
+		return "", w.grpcErr()
+	}
+	return username, nil
+}
+
+func (s *grpcAdminServer) RevokeCertificate(ctx context.Context,
+	request *keymasterpb.RevokeCertificateRequest) (
+	*keymasterpb.RevokeCertificateResponse, error) {
+	username, err := s.authorize(ctx,
+		keymasterpb.Admin_RevokeCertificate_FullMethodName)
+	if err != nil {
+		return nil, err
+	}
+	if request.Serial == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing serial")
+	}
+	added, err := s.state.revokeCert(request.Serial, request.Reason)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if added {
+		logger.Printf("Certificate %s revoked by %s over gRPC",
+			request.Serial, username)
+	}
+	return &keymasterpb.RevokeCertificateResponse{Revoked: added}, nil
+}
+
+func (s *grpcAdminServer) ListRevokedCertificates(ctx context.Context,
+	request *keymasterpb.ListRevokedCertificatesRequest) (
+	*keymasterpb.ListRevokedCertificatesResponse, error) {
+	_, err := s.authorize(ctx,
+		keymasterpb.Admin_ListRevokedCertificates_FullMethodName)
+	if err != nil {
+		return nil, err
+	}
+	response := &keymasterpb.ListRevokedCertificatesResponse{}
+	for _, entry := range s.state.revokedCerts.List() {
+		response.Certificates = append(response.Certificates,
+			&keymasterpb.RevokedCertificate{
+				Serial: entry.Serial,
+				Time:   timestamppb.New(entry.Time),
+				Reason: entry.Reason,
+			})
+	}
+	return response, nil
+}
+
+func (s *grpcAdminServer) ClearLockout(ctx context.Context,
+	request *keymasterpb.ClearLockoutRequest) (
+	*keymasterpb.ClearLockoutResponse, error) {
+	_, err := s.authorize(ctx, keymasterpb.Admin_ClearLockout_FullMethodName)
+	if err != nil {
+		return nil, err
+	}
+	username := request.Username
+	if username == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing username")
+	}
+	if !s.state.Config.Base.DisableUsernameNormalization {
+		username = strings.ToLower(username)
+	}
+	s.state.clearLockout(username)
+	return &keymasterpb.ClearLockoutResponse{}, nil
+}
+
+func (s *grpcAdminServer) TailAuditEvents(
+	request *keymasterpb.TailAuditEventsRequest,
+	stream keymasterpb.Admin_TailAuditEventsServer) error {
+	ctx := stream.Context()
+	username, err := s.authorize(ctx,
+		keymasterpb.Admin_TailAuditEvents_FullMethodName)
+	if err != nil {
+		return err
+	}
+	types := make(map[string]struct{}, len(request.Types))
+	for _, eventType := range request.Types {
+		types[eventType] = struct{}{}
+	}
+	events := s.state.auditEventTail.subscribe()
+	defer s.state.auditEventTail.unsubscribe(events)
+	logger.Debugf(1, "%s tailing audit events over gRPC", username)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case message, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted,
+					"fell behind the audit events")
+			}
+			if _, ok := types[message.Type]; len(types) > 0 && !ok {
+				continue
+			}
+			event, err := newGRPCAuditEvent(message)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func newGRPCAuditEvent(message auditStreamMessage) (
+	*keymasterpb.AuditEvent, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	return &keymasterpb.AuditEvent{
+		Type:        message.Type,
+		Time:        timestamppb.New(message.Time),
+		Username:    message.Username,
+		RequestedBy: message.RequestedBy,
+		Policy:      message.Policy,
+		AuthMethods: message.AuthMethods,
+		Serial:      message.Serial,
+		KeyId:       message.KeyID,
+		Instance:    message.Instance,
+		Json:        data,
+	}, nil
+}
+
+// auditEventTail fans the audit events out to the TailAuditEvents
+// streams. A stream which falls behind is dropped rather than holding up
+// issuance: its channel is closed.
+type auditEventTail struct {
+	mutex       sync.Mutex
+	subscribers map[chan auditStreamMessage]struct{}
+}
+
+func (tail *auditEventTail) subscribe() chan auditStreamMessage {
+	events := make(chan auditStreamMessage, grpcAuditTailBufferSize)
+	tail.mutex.Lock()
+	defer tail.mutex.Unlock()
+	if tail.subscribers == nil {
+		tail.subscribers = make(map[chan auditStreamMessage]struct{})
+	}
+	tail.subscribers[events] = struct{}{}
+	return events
+}
+
+func (tail *auditEventTail) unsubscribe(events chan auditStreamMessage) {
+	tail.mutex.Lock()
+	defer tail.mutex.Unlock()
+	if _, ok := tail.subscribers[events]; ok {
+		delete(tail.subscribers, events)
+		close(events)
+	}
+}
+
+func (tail *auditEventTail) publish(message auditStreamMessage) {
+	tail.mutex.Lock()
+	defer tail.mutex.Unlock()
+	for events := range tail.subscribers {
+		select {
+		case events <- message:
+		default:
+			delete(tail.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// newGRPCServer returns the gRPC server, which requires client
+// certificates from the client CAs.
+func (state *RuntimeState) newGRPCServer(
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error),
+	httpLogger instrumentedwriter.Logger) (*grpc.Server, error) {
+	if state.ClientCAPool == nil {
+		return nil, errors.New("grpc: client_ca_filename is required")
+	}
+	srv := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(&tls.Config{
+			GetCertificate: getCertificate,
+			ClientCAs:      state.ClientCAPool,
+			ClientAuth:     tls.RequireAndVerifyClientCert,
+			MinVersion:     tls.VersionTLS12,
+		})),
+		grpc.MaxConcurrentStreams(grpcMaxConcurrentStreams),
+	)
+	keymasterpb.RegisterIssuanceServer(srv,
+		&grpcIssuanceServer{state: state, httpLogger: httpLogger})
+	keymasterpb.RegisterAdminServer(srv,
+		&grpcAdminServer{state: state, httpLogger: httpLogger})
+	return srv, nil
+}
+
+// grpcServerComponent serves srv on address. Streams still open after
+// serverShutdownTimeout are cut when stopping.
+func grpcServerComponent(address string, srv *grpc.Server) lifecycle.Component {
+	return lifecycle.Funcs{
+		StartFunc: func() error {
+			listener, err := net.Listen("tcp", address)
+			if err != nil {
+				if hint := checkBindAddress(address); hint != nil {
+					return fmt.Errorf("%s: %s", err, hint)
+				}
+				return err
+			}
+			go func() {
+				if err := srv.Serve(listener); err != nil {
+					logger.Fatalf("Serving gRPC on %s: %s", address, err)
+				}
+			}()
+			return nil
+		},
+		StopFunc: func() error {
+			stopped := make(chan struct{})
+			go func() {
+				srv.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-time.After(serverShutdownTimeout):
+				srv.Stop()
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/revocationlist"
+	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"github.com/Symantec/keymaster/proto/keymasterpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// newGRPCTestCertificate returns a key pair with a certificate signed by
+// the test CA: an IP restricted one for identity, or a server certificate
+// for 127.0.0.1 if identity is empty.
+func newGRPCTestCertificate(t *testing.T, identity string) tls.Certificate {
+	_, caCert, caPriv := setupX509Generator(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var derCert []byte
+	if identity == "" {
+		derCert, err = certgen.GenHostX509Cert(nil,
+			[]net.IP{net.ParseIP("127.0.0.1")}, &key.PublicKey, caCert, caPriv,
+			time.Hour, certgen.HostCertProfile{
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+	} else {
+		derCert, err = certgen.GenIPRestrictedX509Cert(identity,
+			&key.PublicKey, caCert, caPriv, []net.IPNet{{
+				IP: net.ParseIP("127.0.0.0"), Mask: net.CIDRMask(8, 32)}},
+			time.Hour, nil, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{derCert}, PrivateKey: key}
+}
+
+// newGRPCTestAdminCA returns a self-signed admin CA certificate and key.
+func newGRPCTestAdminCA(t *testing.T) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "admin CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	derCert, err := x509.CreateCertificate(rand.Reader, template, template,
+		key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// newGRPCTestAdminCertificate returns a client certificate for identity
+// signed by the admin CA.
+func newGRPCTestAdminCertificate(t *testing.T, caCert *x509.Certificate,
+	caKey crypto.Signer, identity string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: identity},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	derCert, err := x509.CreateCertificate(rand.Reader, template, caCert,
+		key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{derCert}, PrivateKey: key}
+}
+
+func dialGRPCTest(t *testing.T, address, identity string) *grpc.ClientConn {
+	if identity == "" {
+		return dialGRPCTestWithCertificate(t, address, nil)
+	}
+	cert := newGRPCTestCertificate(t, identity)
+	return dialGRPCTestWithCertificate(t, address, &cert)
+}
+
+func dialGRPCTestWithCertificate(t *testing.T, address string,
+	cert *tls.Certificate) *grpc.ClientConn {
+	_, caCert, _ := setupX509Generator(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	config := &tls.Config{RootCAs: roots}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	conn, err := grpc.NewClient(address,
+		grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestGRPCAPI(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "grpcapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.revokedCerts, err = revocationlist.Open(filepath.Join(dir,
+		revokedCertsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, caCert, _ := setupX509Generator(t)
+	adminCACert, adminCAKey := newGRPCTestAdminCA(t)
+	state.ClientCAPool = x509.NewCertPool()
+	state.ClientCAPool.AddCert(caCert)
+	state.ClientCAPool.AddCert(adminCACert)
+	state.Config.Base.AllowedAuthBackendsForCerts = append(
+		state.Config.Base.AllowedAuthBackendsForCerts,
+		proto.AuthTypeIPCertificate)
+	state.Config.Base.AutomationUsers = []string{"robot", "operator"}
+	state.Config.Base.AdminUsers = []string{"operator"}
+
+	serverCert := newGRPCTestCertificate(t, "")
+	srv, err := state.newGRPCServer(
+		func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &serverCert, nil
+		}, httpLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	defer srv.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	expectCode := func(err error, code codes.Code) {
+		t.Helper()
+		if status.Code(err) != code {
+			t.Fatalf("expected %s, got %v", code, err)
+		}
+	}
+
+	robot := dialGRPCTest(t, listener.Addr().String(), "robot")
+	defer robot.Close()
+	issuance := keymasterpb.NewIssuanceClient(robot)
+	response, err := issuance.IssueCertificate(ctx,
+		&keymasterpb.IssueCertificateRequest{
+			Type:       "x509",
+			PublicKeys: []string{testUserPEMPublicKey},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(response.Certificate)
+	if block == nil {
+		t.Fatalf("no certificate returned: %q", response.Certificate)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "robot" ||
+		response.Filename != "userCert.pem" {
+		t.Errorf("unexpected certificate for %s in %s",
+			cert.Subject.CommonName, response.Filename)
+	}
+	_, err = issuance.IssueCertificate(ctx,
+		&keymasterpb.IssueCertificateRequest{
+			Username:   "alice",
+			Type:       "x509",
+			PublicKeys: []string{testUserPEMPublicKey},
+		})
+	expectCode(err, codes.PermissionDenied)
+	_, err = issuance.IssueCertificate(ctx,
+		&keymasterpb.IssueCertificateRequest{Type: "x509"})
+	expectCode(err, codes.InvalidArgument)
+
+	_, err = keymasterpb.NewAdminClient(robot).ListRevokedCertificates(ctx,
+		&keymasterpb.ListRevokedCertificatesRequest{})
+	expectCode(err, codes.PermissionDenied)
+	// The IP restricted certificate of an admin user is not enough.
+	operatorIPCert := dialGRPCTest(t, listener.Addr().String(), "operator")
+	defer operatorIPCert.Close()
+	_, err = keymasterpb.NewAdminClient(operatorIPCert).ListRevokedCertificates(
+		ctx, &keymasterpb.ListRevokedCertificatesRequest{})
+	expectCode(err, codes.PermissionDenied)
+	adminRobotCert := newGRPCTestAdminCertificate(t, adminCACert, adminCAKey,
+		"robot")
+	adminRobot := dialGRPCTestWithCertificate(t, listener.Addr().String(),
+		&adminRobotCert)
+	defer adminRobot.Close()
+	_, err = keymasterpb.NewAdminClient(adminRobot).ListRevokedCertificates(
+		ctx, &keymasterpb.ListRevokedCertificatesRequest{})
+	expectCode(err, codes.PermissionDenied)
+
+	operatorCert := newGRPCTestAdminCertificate(t, adminCACert, adminCAKey,
+		"operator")
+	operator := dialGRPCTestWithCertificate(t, listener.Addr().String(),
+		&operatorCert)
+	defer operator.Close()
+	admin := keymasterpb.NewAdminClient(operator)
+	tailCtx, cancelTail := context.WithCancel(ctx)
+	defer cancelTail()
+	tail, err := admin.TailAuditEvents(tailCtx,
+		&keymasterpb.TailAuditEventsRequest{Types: []string{"revoked"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		state.auditEventTail.mutex.Lock()
+		subscribers := len(state.auditEventTail.subscribers)
+		state.auditEventTail.mutex.Unlock()
+		if subscribers > 0 {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal("TailAuditEvents did not subscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	serial := cert.SerialNumber.String()
+	revokeResponse, err := admin.RevokeCertificate(ctx,
+		&keymasterpb.RevokeCertificateRequest{Serial: serial,
+			Reason: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revokeResponse.Revoked {
+		t.Error("certificate not revoked")
+	}
+	event, err := tail.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "revoked" || !strings.Contains(string(event.Json),
+		`"type":"revoked"`) {
+		t.Errorf("unexpected event: %v", event)
+	}
+	listResponse, err := admin.ListRevokedCertificates(ctx,
+		&keymasterpb.ListRevokedCertificatesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listResponse.Certificates) != 1 ||
+		listResponse.Certificates[0].Serial != serial ||
+		listResponse.Certificates[0].Reason != "test" {
+		t.Errorf("unexpected revoked certificates: %v",
+			listResponse.Certificates)
+	}
+	_, err = admin.ClearLockout(ctx, &keymasterpb.ClearLockoutRequest{})
+	expectCode(err, codes.InvalidArgument)
+
+	// Clients without a certificate fail the TLS handshake.
+	stranger := dialGRPCTest(t, listener.Addr().String(), "")
+	defer stranger.Close()
+	_, err = keymasterpb.NewIssuanceClient(stranger).IssueCertificate(ctx,
+		&keymasterpb.IssueCertificateRequest{Type: "x509"})
+	expectCode(err, codes.Unavailable)
+}
+
+func TestAuditEventTailDropsSlowSubscribers(t *testing.T) {
+	var tail auditEventTail
+	events := tail.subscribe()
+	for i := 0; i <= grpcAuditTailBufferSize; i++ {
+		tail.publish(auditStreamMessage{})
+	}
+	for range events {
+	}
+	tail.unsubscribe(events)
+	if len(tail.subscribers) != 0 {
+		t.Fatal("slow subscriber not dropped")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/Symantec/keymaster/lib/certlint"
+)
+
+// id-kp-ipsecIKE intermediate from draft-ietf-ipsec-pki-req, required by
+// Windows IKEv2 clients in gateway certificates.
+var ikeIntermediateExtKeyUsage = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 8, 2, 2}
+
+// hostCertProfile describes a kind of certificate which hosts can request
+// for themselves using their host identity (IP restricted certificate).
+type hostCertProfile struct {
+	certProfile      certgen.HostCertProfile
+	allowIPAddresses bool
+	minRSABits       int
+	filename         string
+}
+
+var hostCertProfiles = map[string]hostCertProfile{
+	// Mail relays present the same certificate when receiving and when
+	// relaying to other MTAs.
+	"x509-smtp-relay": {
+		certProfile: certgen.HostCertProfile{
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth,
+				x509.ExtKeyUsageClientAuth},
+		},
+		minRSABits: 2048,
+		filename:   "relayCert.pem",
+	},
+	// IPsec gateways are commonly addressed by IP, so IP SANs are included.
+	"x509-ikev2": {
+		certProfile: certgen.HostCertProfile{
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth,
+				x509.ExtKeyUsageClientAuth},
+			UnknownExtKeyUsage: []asn1.ObjectIdentifier{
+				ikeIntermediateExtKeyUsage},
+		},
+		allowIPAddresses: true,
+		minRSABits:       2048,
+		filename:         "ikeCert.pem",
+	},
+}
+
+// checkHostPublicKey only accepts key types all common TLS stacks support.
+func checkHostPublicKey(pub interface{}, profile hostCertProfile) error {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < profile.minRSABits {
+			return fmt.Errorf("RSA key must have at least %d bits",
+				profile.minRSABits)
+		}
+	case *ecdsa.PublicKey:
+		if key.Curve.Params().BitSize < 256 {
+			return fmt.Errorf("ECDSA key must use at least P-256")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+	return nil
+}
+
+func splitFormList(value string) []string {
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// getRequestedHostNames returns the names requested in the form, defaulting
+// to all the names of the host in the inventory.
+func getRequestedHostNames(r *http.Request, dnsNames []string,
+	ipAddresses []net.IP, profile hostCertProfile) ([]string, []net.IP, error) {
+	if requested := splitFormList(r.Form.Get("hostnames")); len(requested) > 0 {
+		dnsNames = requested
+	}
+	if !profile.allowIPAddresses {
+		return dnsNames, nil, nil
+	}
+	if requested := splitFormList(r.Form.Get("ip_addresses")); len(requested) > 0 {
+		ipAddresses = nil
+		for _, address := range requested {
+			ip := net.ParseIP(address)
+			if ip == nil {
+				return nil, nil, fmt.Errorf("invalid IP address: %s", address)
+			}
+			ipAddresses = append(ipAddresses, ip)
+		}
+	}
+	return dnsNames, ipAddresses, nil
+}
+
+func (state *RuntimeState) postAuthHostCertHandler(
+	w http.ResponseWriter, r *http.Request, targetUser string,
+	keySigner crypto.Signer, duration time.Duration, authLevel int,
+	profileName string, profile hostCertProfile) {
+	// A GET takes the PEM public key from the pubkey query parameter, so that
+	// renewal jobs need no upload.
+	if r.Method != "GET" && r.Method != "POST" && r.Method != "PUT" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if (authLevel & AuthTypeIPCertificate) != AuthTypeIPCertificate {
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Host certificates require a host identity")
+		return
+	}
+	host, ok := state.lookupHost(targetUser)
+	if !ok || !host.AllowsProfile(profileName) {
+		requestLogger(r).Printf("Host %s not allowed to request %s", targetUser,
+			profileName)
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Host not allowed to request this certificate")
+		return
+	}
+	dnsNames, ipAddresses, err := getRequestedHostNames(r, host.DNSNames,
+		host.ParsedIPAddresses(), profile)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := host.CheckNames(dnsNames, ipAddresses); err != nil {
+		requestLogger(r).Printf("Host certificate request refused: %s", err)
+		state.writeFailureResponse(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+	pubKeyData, err := getPublicKeyDataFromForm(r)
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Missing public key file")
+		return
+	}
+	block, _ := pem.Decode(pubKeyData)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid File, Unable to decode pem")
+		return
+	}
+	hostPub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Cannot parse public key")
+		return
+	}
+	if err := checkHostPublicKey(hostPub, profile); err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	caCert, caChainPEM, err := state.x509Issuer(keySigner)
+	if err != nil {
+		state.writeFailureResponse(w, r, caKeyErrorStatus(err), "")
+		requestLogger(r).Errorf("%s", err)
+		return
+	}
+	signingSpan := startSigningSpan(r, profileName)
+	derCert, err := certgen.GenHostX509CertWithOptions(dnsNames, ipAddresses,
+		hostPub, caCert, keySigner, duration, profile.certProfile,
+		state.x509CertOptions(caCert))
+	endSpan(signingSpan, err)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		requestLogger(r).Errorf("Cannot Generate host cert: %s", err)
+		return
+	}
+	lintProfile := certlint.X509Profile{
+		CA:          caCert,
+		MaxLifetime: duration,
+		ExtKeyUsage: profile.certProfile.ExtKeyUsage,
+		DNSNames:    dnsNames,
+		IPAddresses: ipAddresses,
+	}
+	err = state.lintIssuedX509Cert(profileName, targetUser, derCert,
+		lintProfile)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if err := state.recordIssuedX509Cert(requestID(r), profileName, derCert); err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	eventNotifier.PublishX509(derCert)
+	metricLogCertDuration(profileName, "granted", float64(duration.Seconds()))
+	state.recordIssuedCertBy(targetUser, delegatedRequester(r), requestID(r),
+		profileName, describePublicKey(hostPub), sshCertRecord{}, authLevel,
+		duration)
+	state.emailIssuance(r, targetUser, profileName,
+		publicKeyFingerprint(hostPub))
+
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="%s"`, profile.filename))
+	w.WriteHeader(200)
+	pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: derCert})
+	w.Write(caChainPEM)
+	requestLogger(r).Printf("Generated %s Certificate for %s (%s)", profileName,
+		targetUser, strings.Join(dnsNames, ","))
+	go func(username string, certType string) {
+		metricsMutex.Lock()
+		defer metricsMutex.Unlock()
+		certGenCounter.WithLabelValues(username, certType).Inc()
+	}(targetUser, profileName)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/hostinventory"
+)
+
+const testHostInventory = `hosts:
+  - identity: relay1
+    dns_names: [mx1.example.com]
+  - identity: gateway1
+    dns_names: [vpn.example.com]
+    ip_addresses: [192.0.2.1]
+    profiles: [x509-ikev2]
+`
+
+func createHostCertRequest(pubKeyPEM []byte, hostnames string) (*http.Request, error) {
+	return createHostCertRequestForHost("relay1", pubKeyPEM, hostnames)
+}
+
+func createHostCertRequestForHost(host string, pubKeyPEM []byte,
+	hostnames string) (*http.Request, error) {
+	bodyBuf := &bytes.Buffer{}
+	bodyWriter := multipart.NewWriter(bodyBuf)
+	fileWriter, err := bodyWriter.CreateFormFile("pubkeyfile", "host.pub")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fileWriter.Write(pubKeyPEM); err != nil {
+		return nil, err
+	}
+	if hostnames != "" {
+		if err := bodyWriter.WriteField("hostnames", hostnames); err != nil {
+			return nil, err
+		}
+	}
+	contentType := bodyWriter.FormDataContentType()
+	bodyWriter.Close()
+	req, err := http.NewRequest("POST", certgenPath+host, bodyBuf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return parseUploadForm(req)
+}
+
+func setupHostCertTest(t *testing.T) (*RuntimeState, []byte, func()) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventoryFile, err := ioutil.TempFile("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		os.Remove(passwdFile.Name())
+		os.Remove(inventoryFile.Name())
+	}
+	inventoryFile.WriteString(testHostInventory)
+	inventoryFile.Close()
+	state.hostInventory, err = hostinventory.Load(inventoryFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derKey, err := x509.MarshalPKIXPublicKey(&hostKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: derKey})
+	return state, pubKeyPEM, cleanup
+}
+
+func TestPostAuthHostCertHandler(t *testing.T) {
+	state, pubKeyPEM, cleanup := setupHostCertTest(t)
+	defer cleanup()
+	profile := hostCertProfiles["x509-smtp-relay"]
+	handlerWithAuthLevel := func(authLevel int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			state.postAuthHostCertHandler(w, r, "relay1", state.Signer,
+				time.Hour, authLevel, "x509-smtp-relay", profile)
+		}
+	}
+
+	req, err := createHostCertRequest(pubKeyPEM, "mx1.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req,
+		handlerWithAuthLevel(AuthTypeIPCertificate), http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(rr.Body.Bytes())
+	if block == nil {
+		t.Fatal("no certificate returned")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.VerifyHostname("mx1.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Names not in the inventory are refused.
+	req, err = createHostCertRequest(pubKeyPEM, "evil.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req,
+		handlerWithAuthLevel(AuthTypeIPCertificate), http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A GET passes the key in the query.
+	query := url.Values{"pubkey": {string(pubKeyPEM)},
+		"hostnames": {"mx1.example.com"}}
+	req, err = http.NewRequest("GET", certgenPath+"relay1?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req,
+		handlerWithAuthLevel(AuthTypeIPCertificate), http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest("DELETE", certgenPath+"relay1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req,
+		handlerWithAuthLevel(AuthTypeIPCertificate),
+		http.StatusMethodNotAllowed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A password authenticated user is not a host.
+	req, err = createHostCertRequest(pubKeyPEM, "mx1.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req,
+		handlerWithAuthLevel(AuthTypePassword), http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostAuthHostCertHandlerIKEv2(t *testing.T) {
+	state, pubKeyPEM, cleanup := setupHostCertTest(t)
+	defer cleanup()
+	handler := func(host, profileName string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			state.postAuthHostCertHandler(w, r, host, state.Signer,
+				time.Hour, AuthTypeIPCertificate, profileName,
+				hostCertProfiles[profileName])
+		}
+	}
+	req, err := createHostCertRequestForHost("gateway1", pubKeyPEM, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req,
+		handler("gateway1", "x509-ikev2"), http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(rr.Body.Bytes())
+	if block == nil {
+		t.Fatal("no certificate returned")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "192.0.2.1" {
+		t.Fatalf("unexpected IP SANs: %v", cert.IPAddresses)
+	}
+	if len(cert.UnknownExtKeyUsage) != 1 ||
+		!cert.UnknownExtKeyUsage[0].Equal(ikeIntermediateExtKeyUsage) {
+		t.Fatalf("missing IKE intermediate EKU: %v", cert.UnknownExtKeyUsage)
+	}
+
+	// The gateway is not allowed to request relay certificates.
+	req, err = createHostCertRequestForHost("gateway1", pubKeyPEM, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req,
+		handler("gateway1", "x509-smtp-relay"), http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
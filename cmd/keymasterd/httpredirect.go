@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/acmecert"
+)
+
+const httpRedirectComponentName = "http_redirect"
+
+// httpRedirectHost returns the host to redirect a request for requestHost
+// to: the name it was requested with if keymaster is known by it, else the
+// host identity, so that the redirects cannot point elsewhere.
+func (state *RuntimeState) httpRedirectHost(requestHost string) string {
+	host := requestHost
+	if h, _, err := net.SplitHostPort(requestHost); err == nil {
+		host = h
+	}
+	if strings.EqualFold(host, state.HostIdentity) ||
+		state.realmByServerName(host) != nil {
+		return host
+	}
+	for _, domain := range state.Config.ACME.Domains {
+		if strings.EqualFold(host, domain) {
+			return host
+		}
+	}
+	return state.HostIdentity
+}
+
+// httpRedirectHandler redirects every request to the same URL on the HTTPS
+// service port.
+func (state *RuntimeState) httpRedirectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := "https://" + state.httpRedirectHost(r.Host) +
+			state.publicPortSuffix() + r.URL.RequestURI()
+		status := http.StatusMovedPermanently
+		if r.Method != "GET" && r.Method != "HEAD" {
+			// Unlike 301, keeps the method and body.
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, target, status)
+	})
+}
+
+// plainHTTPHandler is served on http_redirect_address: the ACME HTTP-01
+// challenges, if any, and redirects for everything else.
+func (state *RuntimeState) plainHTTPHandler() http.Handler {
+	if state.acmeManager != nil &&
+		state.Config.ACME.challenge() == acmecert.ChallengeHTTP01 {
+		return state.acmeManager.HTTPHandler(state.httpRedirectHandler())
+	}
+	return state.httpRedirectHandler()
+}
+
+// acmeServesHTTPRedirect returns true if the listener answering the ACME
+// HTTP-01 challenges, which is started before the components, also serves
+// the redirects.
+func (state *RuntimeState) acmeServesHTTPRedirect() bool {
+	return state.Config.Base.HTTPRedirectAddress != "" &&
+		state.Config.ACME.enabled() &&
+		state.Config.ACME.challenge() == acmecert.ChallengeHTTP01 &&
+		sameListenAddress(state.Config.ACME.httpAddress(),
+			state.Config.Base.HTTPRedirectAddress)
+}
+
+// compareListenAddresses returns whether the listen addresses a and b, in
+// host:port form, are the same, such as ":80" and "0.0.0.0:80", and whether
+// they overlap: the same, or on the same port with one listening on all
+// addresses.
+func compareListenAddresses(a, b string) (same bool, overlap bool) {
+	hostA, portA, err := net.SplitHostPort(a)
+	if err != nil {
+		return false, false
+	}
+	hostB, portB, err := net.SplitHostPort(b)
+	if err != nil || portA != portB {
+		return false, false
+	}
+	anyA, anyB := isAnyListenHost(hostA), isAnyListenHost(hostB)
+	if anyA && anyB || hostA == hostB {
+		return true, true
+	}
+	return false, anyA || anyB
+}
+
+func isAnyListenHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
+
+func sameListenAddress(a, b string) bool {
+	same, _ := compareListenAddresses(a, b)
+	return same
+}
+
+func (state *RuntimeState) newHTTPRedirectServer(address string) *http.Server {
+	return &http.Server{
+		Addr:         address,
+		Handler:      state.plainHTTPHandler(),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+}
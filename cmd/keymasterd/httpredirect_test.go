@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/keymaster/keymasterd/acmecert"
+)
+
+func TestHTTPRedirectHandler(t *testing.T) {
+	var state RuntimeState
+	state.HostIdentity = "keymaster.example.com"
+	state.Config.Base.HttpAddress = ":8443"
+	state.Config.ACME.Domains = []string{"km.example.com"}
+	for _, test := range []struct {
+		method   string
+		host     string
+		uri      string
+		status   int
+		location string
+	}{
+		{"GET", "keymaster.example.com", "/", http.StatusMovedPermanently,
+			"https://keymaster.example.com:8443/"},
+		{"GET", "keymaster.example.com:80", "/profile/?a=b",
+			http.StatusMovedPermanently,
+			"https://keymaster.example.com:8443/profile/?a=b"},
+		{"HEAD", "KM.example.com", "/", http.StatusMovedPermanently,
+			"https://KM.example.com:8443/"},
+		{"GET", "evil.example.com", "/", http.StatusMovedPermanently,
+			"https://keymaster.example.com:8443/"},
+		{"POST", "keymaster.example.com", "/api/v0/login",
+			http.StatusPermanentRedirect,
+			"https://keymaster.example.com:8443/api/v0/login"},
+	} {
+		req := httptest.NewRequest(test.method, "http://"+test.host+test.uri,
+			nil)
+		w := httptest.NewRecorder()
+		state.httpRedirectHandler().ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("%s %s%s: got status %d, want %d", test.method,
+				test.host, test.uri, w.Code, test.status)
+		}
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("%s %s%s: got %q, want %q", test.method, test.host,
+				test.uri, location, test.location)
+		}
+	}
+	state.Config.Base.PublicPort = 443
+	req := httptest.NewRequest("GET", "http://keymaster.example.com/", nil)
+	w := httptest.NewRecorder()
+	state.httpRedirectHandler().ServeHTTP(w, req)
+	if location := w.Header().Get("Location"); location !=
+		"https://keymaster.example.com/" {
+		t.Errorf("public_port 443: got %q", location)
+	}
+}
+
+func TestPlainHTTPHandlerACMEChallenges(t *testing.T) {
+	var state RuntimeState
+	state.HostIdentity = "keymaster.example.com"
+	state.Config.Base.HttpAddress = ":443"
+	state.Config.Base.DataDirectory = "/var/lib/keymaster"
+	state.Config.ACME.Domains = []string{"keymaster.example.com"}
+	state.Config.ACME.setDefaultFilenames(&state.Config.Base)
+	manager, err := state.newACMEManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.acmeManager = manager
+	// Unknown tokens are not found instead of redirected.
+	req := httptest.NewRequest("GET", "http://keymaster.example.com"+
+		acmecert.HTTPChallengePathPrefix+"token", nil)
+	w := httptest.NewRecorder()
+	state.plainHTTPHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("challenge: got status %d", w.Code)
+	}
+	req = httptest.NewRequest("GET", "http://keymaster.example.com/", nil)
+	w = httptest.NewRecorder()
+	state.plainHTTPHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusMovedPermanently ||
+		!strings.HasPrefix(w.Header().Get("Location"), "https://") {
+		t.Errorf("got status %d, location %q", w.Code,
+			w.Header().Get("Location"))
+	}
+}
+
+func TestACMEServesHTTPRedirect(t *testing.T) {
+	var state RuntimeState
+	if state.acmeServesHTTPRedirect() {
+		t.Error("true without http_redirect_address")
+	}
+	state.Config.Base.HTTPRedirectAddress = ":80"
+	if state.acmeServesHTTPRedirect() {
+		t.Error("true without ACME")
+	}
+	state.Config.ACME.Domains = []string{"keymaster.example.com"}
+	if !state.acmeServesHTTPRedirect() {
+		t.Error("false with the default ACME http_address")
+	}
+	state.Config.ACME.HTTPAddress = "0.0.0.0:80"
+	if !state.acmeServesHTTPRedirect() {
+		t.Error("false with the same ACME http_address")
+	}
+	state.Config.ACME.HTTPAddress = ":8080"
+	if state.acmeServesHTTPRedirect() {
+		t.Error("true with another ACME http_address")
+	}
+	state.Config.ACME.HTTPAddress = ""
+	state.Config.ACME.Challenge = acmecert.ChallengeDNS01
+	if state.acmeServesHTTPRedirect() {
+		t.Error("true with dns-01")
+	}
+}
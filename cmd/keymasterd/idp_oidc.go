@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
-	//"crypto"
+	"crypto"
+	"crypto/ed25519"
 	//"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -61,7 +63,7 @@ func (state *RuntimeState) idpOpenIDCDiscoveryHandler(w http.ResponseWriter, r *
 		JWKSURI:                issuer + idpOpenIDCJWKSPath,
 		ResponseTypesSupported: []string{"code"},               // We only support authorization code flow
 		SubjectTypesSupported:  []string{"pairwise", "public"}, // WHAT is THIS?
-		IDTokenSigningAlgValue: []string{"RS256"}}
+		IDTokenSigningAlgValue: state.idTokenSigningAlgorithms()}
 	// need to agree on what scopes we will support
 
 	b, err := json.Marshal(metadata)
@@ -77,6 +79,31 @@ func (state *RuntimeState) idpOpenIDCDiscoveryHandler(w http.ResponseWriter, r *
 	out.WriteTo(w)
 }
 
+// idTokenSigningAlgorithms returns the algorithms of the keys ID tokens can
+// be signed with.
+func (state *RuntimeState) idTokenSigningAlgorithms() []string {
+	var algorithms []string
+	for _, key := range state.currentCA().publicKeys {
+		algorithm := string(joseSignatureAlgorithm(key))
+		if !stringsIntersect([]string{algorithm}, algorithms) {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	if len(algorithms) < 1 {
+		algorithms = []string{string(jose.RS256)}
+	}
+	return algorithms
+}
+
+// publicJWK returns the JWK of an RSA, ECDSA or Ed25519 public key.
+func publicJWK(key crypto.PublicKey) (*gojwk.Key, error) {
+	if key, ok := key.(ed25519.PublicKey); ok {
+		return &gojwk.Key{Kty: "OKP", Crv: "Ed25519",
+			X: base64.RawURLEncoding.EncodeToString(key)}, nil
+	}
+	return gojwk.PublicKey(key)
+}
+
 type jwsKeyList struct {
 	Keys []*gojwk.Key `json:"keys"`
 }
@@ -87,8 +114,8 @@ func (state *RuntimeState) idpOpenIDCJWKSHandler(w http.ResponseWriter, r *http.
 		return
 	}
 	var currentKeys jwsKeyList
-	for _, key := range state.KeymasterPublicKeys {
-		jwkKey, err := gojwk.PublicKey(key)
+	for _, key := range state.currentCA().publicKeys {
+		jwkKey, err := publicJWK(key)
 		if err != nil {
 			log.Printf("error getting key idpOpenIDCJWKSHandler: %s", err)
 			state.writeFailureResponse(w, r, http.StatusInternalServerError, "Internal Error")
@@ -157,10 +184,10 @@ func (state *RuntimeState) idpOpenIDCAuthorizationHandler(w http.ResponseWriter,
 	// We are now at exploration stage... and will require pre-authed clients.
 	authUser, _, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
 	if err != nil {
-		logger.Debugf(1, "%v", err)
+		requestLogger(r).Debugf(1, "%v", err)
 		return
 	}
-	logger.Debugf(1, "AuthUser of idc auth: %s", authUser)
+	requestLogger(r).Debugf(1, "AuthUser of idc auth: %s", authUser)
 	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
 	// requst MUST be a GET or POST
 	if !(r.Method == "GET" || r.Method == "POST") {
@@ -172,17 +199,17 @@ func (state *RuntimeState) idpOpenIDCAuthorizationHandler(w http.ResponseWriter,
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		return
 	}
-	logger.Debugf(2, "Auth request =%+v", r)
+	requestLogger(r).Debugf(2, "Auth request =%+v", r)
 	//logger.Printf("IDC auth from=%v", r.Form)
 	if r.Form.Get("response_type") != "code" {
-		logger.Debugf(1, "Invalid response_type")
+		requestLogger(r).Debugf(1, "Invalid response_type")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Unsupported or Missing response_type for Auth Handler")
 		return
 	}
 
 	clientID := r.Form.Get("client_id")
 	if clientID == "" {
-		logger.Debugf(1, "empty client_id abourting")
+		requestLogger(r).Debugf(1, "empty client_id abourting")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Empty cleint_id for Auth Handler")
 		return
 	}
@@ -203,7 +230,7 @@ func (state *RuntimeState) idpOpenIDCAuthorizationHandler(w http.ResponseWriter,
 
 	ok, err := state.idpOpenIDCClientCanRedirect(clientID, requestRedirectURLString)
 	if err != nil {
-		logger.Printf("%v", err)
+		requestLogger(r).Printf("%v", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		return
 	}
@@ -215,7 +242,7 @@ func (state *RuntimeState) idpOpenIDCAuthorizationHandler(w http.ResponseWriter,
 	//Dont check for now
 	signerOptions := (&jose.SignerOptions{}).WithType("JWT")
 	//signerOptions.EmbedJWK = true
-	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: state.Signer}, signerOptions)
+	signer, err := jose.NewSigner(joseSigningKey(state.currentCA().signer), signerOptions)
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		return
@@ -232,7 +259,7 @@ func (state *RuntimeState) idpOpenIDCAuthorizationHandler(w http.ResponseWriter,
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "bad Nonce value...not enough entropy")
 		return
 	}
-	logger.Debugf(3, "auth request is valid, now proceeding to generate redirect")
+	requestLogger(r).Debugf(3, "auth request is valid, now proceeding to generate redirect")
 
 	raw, err := jwt.Signed(signer).Claims(codeToken).CompactSerialize()
 	if err != nil {
@@ -240,8 +267,8 @@ func (state *RuntimeState) idpOpenIDCAuthorizationHandler(w http.ResponseWriter,
 	}
 
 	redirectPath := fmt.Sprintf("%s?code=%s&state=%s", requestRedirectURLString, raw, url.QueryEscape(r.Form.Get("state")))
-	logger.Debugf(3, "auth request is valid, redirect path=%s", redirectPath)
-	logger.Printf("IDP: Successful oauth2 authorization:  user=%s redirect url=%s", authUser, requestRedirectURLString)
+	requestLogger(r).Debugf(3, "auth request is valid, redirect path=%s", redirectPath)
+	requestLogger(r).Printf("IDP: Successful oauth2 authorization:  user=%s redirect url=%s", authUser, requestRedirectURLString)
 	eventNotifier.PublishServiceProviderLoginEvent(requestRedirectURLString, authUser)
 	http.Redirect(w, r, redirectPath, 302)
 	//logger.Printf("raw jwt =%v", raw)
@@ -285,28 +312,28 @@ func (state *RuntimeState) idpOpenIDCTokenHandler(w http.ResponseWriter, r *http
 
 	// MUST be POST https://openid.net/specs/openid-connect-core-1_0.html 3.1.3.1
 	if !(r.Method == "POST") {
-		logger.Printf("invalid method")
+		requestLogger(r).Warnf("invalid method")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Method for Auth Handler")
 		return
 	}
 	err := r.ParseForm()
 	if err != nil {
-		logger.Printf("error parsing form")
+		requestLogger(r).Errorf("error parsing form")
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		return
 	}
 	if r.Form.Get("grant_type") != "authorization_code" {
-		logger.Printf("invalid grant type='%s'", r.Form.Get("grant_type"))
+		requestLogger(r).Warnf("invalid grant type='%s'", r.Form.Get("grant_type"))
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid grant type")
 		return
 	}
 	requestRedirectURLString := r.Form.Get("redirect_uri")
 	if requestRedirectURLString == "" {
-		logger.Printf("redirect_uri is empty")
+		requestLogger(r).Printf("redirect_uri is empty")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid redirect uri")
 		return
 	}
-	logger.Debugf(1, "token request =%+v", r)
+	requestLogger(r).Debugf(1, "token request =%+v", r)
 	codeString := r.Form.Get("code")
 	if codeString == "" {
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "nil code")
@@ -315,34 +342,34 @@ func (state *RuntimeState) idpOpenIDCTokenHandler(w http.ResponseWriter, r *http
 	}
 	tok, err := jwt.ParseSigned(codeString)
 	if err != nil {
-		logger.Printf("err=%s", err)
+		requestLogger(r).Printf("err=%s", err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "bad code")
 		return
 	}
-	logger.Debugf(2, "token request tok=%+v", tok)
+	requestLogger(r).Debugf(2, "token request tok=%+v", tok)
 	//out := jwt.Claims{}
 	keymasterToken := keymasterdCodeToken{}
 	//if err := tok.Claims(state.Signer.Public(), &keymasterToken); err != nil {
 	if err := state.JWTClaims(tok, &keymasterToken); err != nil {
-		logger.Printf("err=%s", err)
+		requestLogger(r).Printf("err=%s", err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "bad code")
 		return
 	}
-	logger.Debugf(3, "idc token handler out=%+v", keymasterToken)
+	requestLogger(r).Debugf(3, "idc token handler out=%+v", keymasterToken)
 
 	//now is time to extract the values..
 
 	//formClientID := r.Form.Get("clientID")
-	logger.Debugf(2, "%+v", r)
+	requestLogger(r).Debugf(2, "%+v", r)
 
 	unescapeAuthCredentials := true
 	clientID, pass, ok := r.BasicAuth()
 	if !ok {
-		logger.Debugf(1, "warn: basic auth Missing")
+		requestLogger(r).Debugf(1, "warn: basic auth Missing")
 		clientID = r.Form.Get("client_id")
 		pass = r.Form.Get("client_secret")
 		if len(clientID) < 1 || len(pass) < 1 {
-			logger.Printf("Cannot get auth credentials in auth request")
+			requestLogger(r).Errorf("Cannot get auth credentials in auth request")
 			state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 			return
 		}
@@ -362,7 +389,7 @@ func (state *RuntimeState) idpOpenIDCTokenHandler(w http.ResponseWriter, r *http
 	}
 	valid := state.idpOpenIDCValidClientSecret(clientID, pass)
 	if !valid {
-		logger.Debugf(0, "Error invalid client secret")
+		requestLogger(r).Debugf(0, "Error invalid client secret")
 		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 		return
 	}
@@ -370,20 +397,20 @@ func (state *RuntimeState) idpOpenIDCTokenHandler(w http.ResponseWriter, r *http
 	//validity checks
 	// 1. Ensure authoriation client was issued to the authenticated client
 	if clientID != keymasterToken.Subject {
-		logger.Debugf(0, "Unmatching token Value")
+		requestLogger(r).Debugf(0, "Unmatching token Value")
 		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 		return
 	}
 	// 2. verify authorization code is valid
 	// 2.a -> expiration
 	if keymasterToken.Expiration < time.Now().Unix() {
-		logger.Debugf(0, "Expired Token")
+		requestLogger(r).Debugf(0, "Expired Token")
 		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 		return
 	}
 	// verify redirect uri matches the one setup in the original request:
 	if keymasterToken.RedirectURI != requestRedirectURLString {
-		logger.Debugf(0, "Invalid Redirect Target")
+		requestLogger(r).Debugf(0, "Invalid Redirect Target")
 		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 		return
 	}
@@ -394,7 +421,8 @@ func (state *RuntimeState) idpOpenIDCTokenHandler(w http.ResponseWriter, r *http
 	}
 
 	signerOptions := (&jose.SignerOptions{}).WithType("JWT")
-	kid, err := getKeyFingerprint(state.Signer.Public())
+	keySigner := state.currentCA().signer
+	kid, err := getKeyFingerprint(keySigner.Public())
 	if err != nil {
 		log.Printf("error getting key fingerprint in idpOpenIDCTokenHandler: %s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Internal Error")
@@ -402,7 +430,7 @@ func (state *RuntimeState) idpOpenIDCTokenHandler(w http.ResponseWriter, r *http
 	}
 
 	signerOptions = signerOptions.WithHeader("kid", kid)
-	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: state.Signer}, signerOptions)
+	signer, err := jose.NewSigner(joseSigningKey(keySigner), signerOptions)
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		return
@@ -417,7 +445,7 @@ func (state *RuntimeState) idpOpenIDCTokenHandler(w http.ResponseWriter, r *http
 	if err != nil {
 		panic(err)
 	}
-	logger.Debugf(2, "raw=%s", signedIdToken)
+	requestLogger(r).Debugf(2, "raw=%s", signedIdToken)
 
 	userinfoToken := userInfoToken{Username: keymasterToken.Username, Scope: keymasterToken.Scope}
 	userinfoToken.Expiration = idToken.Expiration
@@ -462,25 +490,28 @@ func (state *RuntimeState) getUserAttributes(username string, attributes []strin
 		}
 		u, err := authutil.ParseLDAPURL(ldapUrl)
 		if err != nil {
-			logger.Printf("Failed to parse ldapurl '%s'", ldapUrl)
+			logger.Errorf("Failed to parse ldapurl '%s'", ldapUrl)
 			continue
 		}
-		attributeMap, err := authutil.GetLDAPUserAttributes(*u,
+		attributeMap, err := authutil.GetLDAPUserAttributesWithReferrals(*u,
 			ldapConfig.BindUsername, ldapConfig.BindPassword,
 			timeoutSecs, nil, username,
-			ldapConfig.UserSearchBaseDNs, ldapConfig.UserSearchFilter, attributes)
+			ldapConfig.UserSearchBaseDNs, ldapConfig.UserSearchFilter, attributes,
+			ldapConfig.Referrals.referralPolicy(ldapReferralBackendUserInfo))
 		if err != nil {
 			continue
 		}
-		userGroups, err := authutil.GetLDAPUserGroups(*u,
+		userGroups, err := authutil.GetLDAPUserGroupsWithReferrals(*u,
 			ldapConfig.BindUsername, ldapConfig.BindPassword,
 			timeoutSecs, nil, username,
 			ldapConfig.UserSearchBaseDNs, ldapConfig.UserSearchFilter,
-			ldapConfig.GroupSearchBaseDNs, ldapConfig.GroupSearchFilter)
+			ldapConfig.GroupSearchBaseDNs, ldapConfig.GroupSearchFilter,
+			ldapConfig.NestedGroups,
+			ldapConfig.Referrals.referralPolicy(ldapReferralBackendUserInfo))
 		if err != nil {
 			// TODO: We actually need to check the error, right now we are assuming
 			// the user does not exists and go with that.
-			logger.Printf("Failed get userGroups for user '%s'", username)
+			logger.Errorf("Failed get userGroups for user '%s'", username)
 		} else {
 			logger.Debugf(1, "Got groups for username %s: %s", username, userGroups)
 			attributeMap["groups"] = userGroups
@@ -508,16 +539,16 @@ type openidConnectUserInfo struct {
 func (state *RuntimeState) idpOpenIDCUserinfoHandler(w http.ResponseWriter, r *http.Request) {
 
 	if !(r.Method == "GET" || r.Method == "POST") {
-		logger.Printf("Invalid Method for Userinfo Handler")
+		requestLogger(r).Warnf("Invalid Method for Userinfo Handler")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Method for Userinfo Handler")
 		return
 	}
-	logger.Debugf(2, "userinfo request=%+v", r)
+	requestLogger(r).Debugf(2, "userinfo request=%+v", r)
 
 	var accessToken string
 	authHeader := r.Header.Get("Authorization")
 	if authHeader != "" {
-		logger.Debugf(2, "AuthHeader= %s", authHeader)
+		requestLogger(r).Debugf(2, "AuthHeader= %s", authHeader)
 		splitHeader := strings.Split(authHeader, " ")
 		if len(splitHeader) == 2 {
 			if splitHeader[0] == "Bearer" {
@@ -534,34 +565,34 @@ func (state *RuntimeState) idpOpenIDCUserinfoHandler(w http.ResponseWriter, r *h
 		}
 		accessToken = r.Form.Get("access_token")
 	}
-	logger.Debugf(1, "access_token='%s'", accessToken)
+	requestLogger(r).Debugf(1, "access_token='%s'", accessToken)
 
 	if accessToken == "" {
-		logger.Printf("access_token='%s'", accessToken)
+		requestLogger(r).Printf("access_token='%s'", accessToken)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Missing access token")
 		return
 	}
 
 	tok, err := jwt.ParseSigned(accessToken)
 	if err != nil {
-		logger.Printf("err=%s", err)
+		requestLogger(r).Printf("err=%s", err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "bad access token")
 		return
 	}
-	logger.Debugf(1, "tok=%+v", tok)
+	requestLogger(r).Debugf(1, "tok=%+v", tok)
 
 	parsedAccessToken := userInfoToken{}
 	//if err := tok.Claims(state.Signer.Public(), &parsedAccessToken); err != nil {
 	if err := state.JWTClaims(tok, &parsedAccessToken); err != nil {
-		logger.Printf("err=%s", err)
+		requestLogger(r).Printf("err=%s", err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "bad code")
 		return
 	}
-	logger.Debugf(1, "out=%+v", parsedAccessToken)
+	requestLogger(r).Debugf(1, "out=%+v", parsedAccessToken)
 
 	//now we check for validity
 	if parsedAccessToken.Expiration < time.Now().Unix() {
-		logger.Printf("expired token attempted to be used for bearer")
+		requestLogger(r).Printf("expired token attempted to be used for bearer")
 		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
 		return
 	}
@@ -579,11 +610,11 @@ func (state *RuntimeState) idpOpenIDCUserinfoHandler(w http.ResponseWriter, r *h
 	email := fmt.Sprintf("%s@%s", parsedAccessToken.Username, defaultEmailDomain)
 	userAttributeMap, err := state.getUserAttributes(parsedAccessToken.Username, []string{"mail"})
 	if err != nil {
-		logger.Printf("warn: failed to get user attributes for %s, %s", parsedAccessToken.Username, err)
+		requestLogger(r).Errorf("warn: failed to get user attributes for %s, %s", parsedAccessToken.Username, err)
 	}
 	var userGroups []string
 	if userAttributeMap != nil {
-		logger.Debugf(2, "useMa=%+v", userAttributeMap)
+		requestLogger(r).Debugf(2, "useMa=%+v", userAttributeMap)
 		mailList, ok := userAttributeMap["mail"]
 		if ok {
 			email = mailList[0]
@@ -610,13 +641,13 @@ func (state *RuntimeState) idpOpenIDCUserinfoHandler(w http.ResponseWriter, r *h
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Internal Error")
 		return
 	}
-	logger.Debugf(1, "userinfo=%+v\n b=%s", userInfo, b)
+	requestLogger(r).Debugf(1, "userinfo=%+v\n b=%s", userInfo, b)
 
 	var out bytes.Buffer
 	json.Indent(&out, b, "", "\t")
 	w.Header().Set("Content-Type", "application/json")
 	out.WriteTo(w)
 
-	logger.Printf("200 Successful userinfo request")
-	logger.Debugf(0, " Userinfo response =  %s", b)
+	requestLogger(r).Printf("200 Successful userinfo request")
+	requestLogger(r).Debugf(0, " Userinfo response =  %s", b)
 }
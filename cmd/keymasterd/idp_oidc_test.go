@@ -13,6 +13,7 @@ import (
 	//"time"
 
 	"github.com/Symantec/Dominator/lib/log/debuglogger"
+	"github.com/Symantec/keymaster/keymasterd/levellog"
 	"gopkg.in/square/go-jose.v2/jwt"
 	//"golang.org/x/net/context"
 	//"golang.org/x/oauth2"
@@ -21,7 +22,7 @@ import (
 func init() {
 	//logger = stdlog.New(os.Stderr, "", stdlog.LstdFlags)
 	slogger := stdlog.New(os.Stderr, "", stdlog.LstdFlags)
-	logger = debuglogger.New(slogger)
+	logger = levellog.New(debuglogger.New(slogger))
 	/*
 		http.HandleFunc("/userinfo", userinfoHandler)
 		http.HandleFunc("/token", tokenHandler)
@@ -104,7 +105,7 @@ func TestIDPOpenIDCAuthorizationHandlerSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 	// now we add a cookie for auth
-	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypePassword)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypePassword)
 	if err != nil {
 		t.Fatal(err)
 	}
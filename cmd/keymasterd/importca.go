@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/howeyc/gopass"
+	"golang.org/x/crypto/ssh"
+)
+
+const importCACommand = "import-ca"
+
+const (
+	importFormatOpenSSH = "openssh"
+	importFormatVault   = "vault"
+	importFormatX509    = "x509"
+)
+
+const minImportedCAKeyBits = 2048
+
+type importCAOptions struct {
+	Format        string
+	KeyFilename   string
+	CertFilename  string
+	ChainFilename string
+	Standby       bool
+}
+
+type importedCA struct {
+	PrivateKey crypto.Signer
+	// Only set for X.509 CAs, the CA certificate is first.
+	Certificates []*x509.Certificate
+}
+
+// vaultSSHCAExport is the payload of Vault's ssh/config/ca endpoint, either
+// bare or wrapped in the "data" field of an API response.
+type vaultSSHCAExport struct {
+	PrivateKey string            `json:"private_key"`
+	PublicKey  string            `json:"public_key"`
+	Data       *vaultSSHCAExport `json:"data"`
+}
+
+// importCA implements "keymasterd import-ca". The CA material is validated
+// and then registered in the configuration given by configFilename, either
+// as the active CA or as a standby CA whose public key is trusted.
+func importCA(configFilename string, args []string) error {
+	var options importCAOptions
+	flagSet := flag.NewFlagSet(importCACommand, flag.ContinueOnError)
+	flagSet.StringVar(&options.Format, "format", importFormatOpenSSH,
+		"Format of the CA material: openssh, vault or x509 (step-ca, CFSSL)")
+	flagSet.StringVar(&options.KeyFilename, "key", "",
+		"CA private key file (the JSON export for vault)")
+	flagSet.StringVar(&options.CertFilename, "cert", "",
+		"X.509 CA certificate file")
+	flagSet.StringVar(&options.ChainFilename, "chain", "",
+		"X.509 certificate chain file up to the root")
+	flagSet.BoolVar(&options.Standby, "standby", false,
+		"Register as a standby CA instead of the active CA")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	config, err := readAppConfigFile(configFilename)
+	if err != nil {
+		return err
+	}
+	ca, err := loadImportedCA(options, getImportPassphrase)
+	if err != nil {
+		return err
+	}
+	var passphrase []byte
+	if !options.Standby {
+		fmt.Printf("Passphrase to encrypt the CA key (empty for none)\n")
+		passphrase, err = getPassphrase()
+		if err != nil {
+			return err
+		}
+	}
+	return registerImportedCA(config, ca, options.Standby, passphrase)
+}
+
+func readAppConfigFile(configFilename string) (*AppConfigFile, error) {
+	source, err := ioutil.ReadFile(configFilename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config file: %s", err)
+	}
+	var config AppConfigFile
+	if err := parseAppConfig(source, &config); err != nil {
+		return nil, fmt.Errorf("cannot parse config file: %s", err)
+	}
+	return &config, nil
+}
+
+func getImportPassphrase() ([]byte, error) {
+	fmt.Printf("Please enter the passphrase of the imported key:\n")
+	return gopass.GetPasswd()
+}
+
+// loadImportedCA reads and validates the CA material. getKeyPassphrase is
+// called if the private key is encrypted.
+func loadImportedCA(options importCAOptions,
+	getKeyPassphrase func() ([]byte, error)) (*importedCA, error) {
+	if options.KeyFilename == "" {
+		return nil, errors.New("no CA key file specified")
+	}
+	keyData, err := ioutil.ReadFile(options.KeyFilename)
+	if err != nil {
+		return nil, err
+	}
+	var ca importedCA
+	var publicKeyData []byte
+	switch options.Format {
+	case importFormatOpenSSH:
+		publicKeyData, err = ioutil.ReadFile(options.KeyFilename + ".pub")
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	case importFormatVault:
+		var export vaultSSHCAExport
+		if err := json.Unmarshal(keyData, &export); err != nil {
+			return nil, fmt.Errorf("cannot parse Vault export: %s", err)
+		}
+		if export.Data != nil {
+			export = *export.Data
+		}
+		if export.PrivateKey == "" {
+			return nil, errors.New("Vault export has no private_key")
+		}
+		keyData = []byte(export.PrivateKey)
+		publicKeyData = []byte(export.PublicKey)
+	case importFormatX509:
+		if options.CertFilename == "" {
+			return nil, errors.New("no X.509 CA certificate file specified")
+		}
+	default:
+		return nil, fmt.Errorf("unknown CA format: %s", options.Format)
+	}
+	ca.PrivateKey, err = parseImportedCAKey(keyData, getKeyPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(publicKeyData) > 0 {
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey(publicKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse CA public key: %s", err)
+		}
+		cryptoKey, ok := publicKey.(ssh.CryptoPublicKey)
+		if !ok {
+			return nil, errors.New("cannot use CA public key")
+		}
+		if err := checkPublicKeyMatch(cryptoKey.CryptoPublicKey(),
+			ca.PrivateKey); err != nil {
+			return nil, err
+		}
+	}
+	if options.Format == importFormatX509 {
+		ca.Certificates, err = readCertificates(options.CertFilename)
+		if err != nil {
+			return nil, err
+		}
+		if options.ChainFilename != "" {
+			chain, err := readCertificates(options.ChainFilename)
+			if err != nil {
+				return nil, err
+			}
+			ca.Certificates = append(ca.Certificates, chain...)
+		}
+		if err := checkX509CA(ca.Certificates, ca.PrivateKey,
+			time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return &ca, nil
+}
+
+func parseImportedCAKey(keyData []byte,
+	getKeyPassphrase func() ([]byte, error)) (crypto.Signer, error) {
+	rawKey, err := ssh.ParseRawPrivateKey(keyData)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok &&
+		getKeyPassphrase != nil {
+		passphrase, err := getKeyPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		rawKey, err = ssh.ParseRawPrivateKeyWithPassphrase(keyData, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt CA key: %s", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot parse CA key: %s", err)
+	}
+	switch privateKey := rawKey.(type) {
+	case *rsa.PrivateKey:
+		if err := privateKey.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid CA key: %s", err)
+		}
+		if bits := privateKey.N.BitLen(); bits < minImportedCAKeyBits {
+			return nil, fmt.Errorf("CA key has %d bits, at least %d are required",
+				bits, minImportedCAKeyBits)
+		}
+		return privateKey, nil
+	case *ecdsa.PrivateKey:
+		switch privateKey.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+			return privateKey, nil
+		}
+		return nil, fmt.Errorf("unsupported ECDSA curve %s",
+			privateKey.Curve.Params().Name)
+	case *ed25519.PrivateKey:
+		return *privateKey, nil
+	case ed25519.PrivateKey:
+		return privateKey, nil
+	}
+	return nil, fmt.Errorf("unsupported CA key type %T", rawKey)
+}
+
+func checkPublicKeyMatch(publicKey crypto.PublicKey,
+	privateKey crypto.Signer) error {
+	wanted, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	if err != nil {
+		return err
+	}
+	got, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(wanted, got) {
+		return errors.New("public key does not match the CA private key")
+	}
+	return nil
+}
+
+func readCertificates(filename string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) < 1 {
+		return nil, fmt.Errorf("%s: no certificates found", filename)
+	}
+	return certs, nil
+}
+
+// checkX509CA checks that certs[0] is a currently valid CA certificate for
+// privateKey which chains up to a root in certs.
+func checkX509CA(certs []*x509.Certificate, privateKey crypto.Signer,
+	now time.Time) error {
+	caCert := certs[0]
+	if !caCert.BasicConstraintsValid || !caCert.IsCA {
+		return errors.New("certificate is not a CA certificate")
+	}
+	if caCert.KeyUsage != 0 && caCert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return errors.New("CA certificate does not allow certificate signing")
+	}
+	if now.Before(caCert.NotBefore) || now.After(caCert.NotAfter) {
+		return fmt.Errorf("CA certificate is only valid from %s to %s",
+			caCert.NotBefore, caCert.NotAfter)
+	}
+	if err := checkPublicKeyMatch(caCert.PublicKey, privateKey); err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	haveRoot := false
+	for _, cert := range certs {
+		if bytes.Equal(cert.RawSubject, cert.RawIssuer) &&
+			cert.CheckSignatureFrom(cert) == nil {
+			roots.AddCert(cert)
+			haveRoot = true
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+	if !haveRoot {
+		return errors.New("certificate chain does not include a root CA")
+	}
+	_, err := caCert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot verify certificate chain: %s", err)
+	}
+	return nil
+}
+
+// loadX509CACertDer returns the first certificate in filename after checking
+// that it belongs to signer and that the others are its chain.
+func loadX509CACertDer(filename string, signer crypto.Signer) ([]byte, error) {
+	der, _, err := loadX509CAChain(filename, signer)
+	return der, err
+}
+
+func registerImportedCA(config *AppConfigFile, ca *importedCA, standby bool,
+	passphrase []byte) error {
+	fingerprint, err := getKeyFingerprint(ca.PrivateKey.Public())
+	if err != nil {
+		return err
+	}
+	if standby {
+		if err := addStandbyCAPublicKey(
+			config.Base.KeymasterPublicKeysFilename, ca.PrivateKey); err != nil {
+			return err
+		}
+		fmt.Printf("Added standby CA %s to %s\n", fingerprint,
+			config.Base.KeymasterPublicKeysFilename)
+		if len(ca.Certificates) > 0 {
+			fmt.Printf("The X.509 certificate is only used once imported as the active CA\n")
+		}
+		return nil
+	}
+	if config.Base.SSHCAFilename == "" {
+		return errors.New("ssh_ca_filename is not configured")
+	}
+	if _, err := os.Stat(config.Base.SSHCAFilename); err == nil {
+		return fmt.Errorf("%s already exists, move the current CA away first",
+			config.Base.SSHCAFilename)
+	}
+	if len(ca.Certificates) > 0 {
+		filename := config.Base.X509CACertFilename
+		if filename == "" {
+			return errors.New("x509_ca_cert_filename is not configured")
+		}
+		if _, err := os.Stat(filename); err == nil {
+			return fmt.Errorf("%s already exists", filename)
+		}
+		var buffer bytes.Buffer
+		for _, cert := range ca.Certificates {
+			pem.Encode(&buffer, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+		if err := ioutil.WriteFile(filename, buffer.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	err = writeArmoredEncryptedCAPrivateKey(ca.PrivateKey, passphrase,
+		config.Base.SSHCAFilename)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported CA %s as the active CA in %s\n", fingerprint,
+		config.Base.SSHCAFilename)
+	return nil
+}
+
+func addStandbyCAPublicKey(filename string, privateKey crypto.Signer) error {
+	if filename == "" {
+		return errors.New("keymaster_public_keys_filename is not configured")
+	}
+	sshPublicKey, err := ssh.NewPublicKey(privateKey.Public())
+	if err != nil {
+		return err
+	}
+	if file, err := os.Open(filename); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err == nil && bytes.Equal(key.Marshal(), sshPublicKey.Marshal()) {
+				file.Close()
+				return fmt.Errorf("CA is already listed in %s", filename)
+			}
+		}
+		file.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(ssh.MarshalAuthorizedKey(sshPublicKey)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"golang.org/x/crypto/ssh"
+)
+
+func writeImportTestKey(t *testing.T, filename string,
+	privateKey *rsa.PrivateKey) {
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	})
+	if err := ioutil.WriteFile(filename, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeImportTestCert(t *testing.T, filename string, der []byte) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(filename, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportCAOpenSSHAndVault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFilename := filepath.Join(dir, "ca")
+	writeImportTestKey(t, keyFilename, privateKey)
+	options := importCAOptions{Format: importFormatOpenSSH,
+		KeyFilename: keyFilename}
+	if _, err := loadImportedCA(options, nil); err != nil {
+		t.Fatal(err)
+	}
+	// A mismatched .pub must be rejected.
+	otherPublicKey, err := ssh.NewPublicKey(&otherKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFilename+".pub",
+		ssh.MarshalAuthorizedKey(otherPublicKey), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadImportedCA(options, nil); err == nil {
+		t.Fatal("mismatched public key should be rejected")
+	}
+
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := ioutil.ReadFile(keyFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	export, err := json.Marshal(map[string]interface{}{
+		"data": map[string]string{
+			"private_key": string(keyPEM),
+			"public_key":  string(ssh.MarshalAuthorizedKey(publicKey)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultFilename := filepath.Join(dir, "vault.json")
+	if err := ioutil.WriteFile(vaultFilename, export, 0600); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := loadImportedCA(importCAOptions{Format: importFormatVault,
+		KeyFilename: vaultFilename}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config AppConfigFile
+	config.Base.SSHCAFilename = filepath.Join(dir, "active.key")
+	config.Base.KeymasterPublicKeysFilename = filepath.Join(dir, "keys.pub")
+	if err := registerImportedCA(&config, ca, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := registerImportedCA(&config, ca, true, nil); err == nil {
+		t.Fatal("duplicate standby CA should be rejected")
+	}
+	if err := registerImportedCA(&config, ca, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := registerImportedCA(&config, ca, false, nil); err == nil {
+		t.Fatal("existing active CA should not be overwritten")
+	}
+	activeKey, err := ioutil.ReadFile(config.Base.SSHCAFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := getSignerFromPEMBytes(activeKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPublicKeyMatch(signer.Public(), privateKey); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportCAX509(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rootKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootDer, err := certgen.GenSelfSignedCACert("root", "example", rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCert, err := x509.ParseCertificate(rootDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "intermediate"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	intermediateDer, err := x509.CreateCertificate(rand.Reader, &template,
+		rootCert, &intermediateKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFilename := filepath.Join(dir, "intermediate.key")
+	certFilename := filepath.Join(dir, "intermediate.crt")
+	chainFilename := filepath.Join(dir, "root.crt")
+	writeImportTestKey(t, keyFilename, intermediateKey)
+	writeImportTestCert(t, certFilename, intermediateDer)
+	writeImportTestCert(t, chainFilename, rootDer)
+
+	options := importCAOptions{Format: importFormatX509,
+		KeyFilename: keyFilename, CertFilename: certFilename}
+	if _, err := loadImportedCA(options, nil); err == nil {
+		t.Fatal("intermediate without chain should be rejected")
+	}
+	options.ChainFilename = chainFilename
+	ca, err := loadImportedCA(options, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ca.Certificates) != 2 {
+		t.Fatalf("expected 2 certificates, got %d", len(ca.Certificates))
+	}
+	// The root certificate does not belong to the intermediate key.
+	options.CertFilename = chainFilename
+	options.ChainFilename = ""
+	if _, err := loadImportedCA(options, nil); err == nil {
+		t.Fatal("certificate for another key should be rejected")
+	}
+
+	var config AppConfigFile
+	config.Base.SSHCAFilename = filepath.Join(dir, "active.key")
+	if err := registerImportedCA(&config, ca, false, nil); err == nil {
+		t.Fatal("missing x509_ca_cert_filename should be rejected")
+	}
+	config.Base.X509CACertFilename = filepath.Join(dir, "active.crt")
+	if err := registerImportedCA(&config, ca, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	der, err := loadX509CACertDer(config.Base.X509CACertFilename,
+		intermediateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(der) != string(intermediateDer) {
+		t.Fatal("registered certificate does not match")
+	}
+	if _, err := loadX509CACertDer(config.Base.X509CACertFilename,
+		rootKey); err == nil {
+		t.Fatal("certificate should not match the root key")
+	}
+}
+
+func TestImportCAEd25519(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFilename := filepath.Join(dir, "ca")
+	err = ioutil.WriteFile(keyFilename, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := loadImportedCA(importCAOptions{Format: importFormatOpenSSH,
+		KeyFilename: keyFilename}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ca.PrivateKey.(ed25519.PrivateKey); !ok {
+		t.Errorf("unexpected key %T", ca.PrivateKey)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/deliveryqueue"
+	"golang.org/x/crypto/ssh"
+)
+
+const issuanceEmailDeadLettersPath = "/issuanceEmail/deadLetters"
+
+const (
+	issuanceEmailQueueDirectory = "issuance_email_queue"
+	defaultIssuanceEmailSubject = "Keymaster certificate issued for {username}"
+	smtpTimeout                 = 30 * time.Second
+)
+
+type IssuanceEmailConfig struct {
+	// SMTPAddress is the host:port of the mail relay. Emails are only sent
+	// if it is set. STARTTLS is used when the relay offers it.
+	SMTPAddress string `yaml:"smtp_address"`
+	// RequireTLS fails delivery to a relay which does not offer STARTTLS
+	// instead of sending the email in the clear.
+	RequireTLS bool `yaml:"require_tls"`
+	// Username and Password are for PLAIN authentication, which net/smtp
+	// refuses without TLS unless the relay is on localhost.
+	Username string `yaml:"username"`
+	Password string `yaml:"password" secret:"true"`
+	From     string `yaml:"from"`
+	// Users are mailed at the mail attribute of their LDAP entry if
+	// userinfo_sources has LDAP, else at <username>@EmailDomain. Default:
+	// the default_email_domain of openid_connect_idp or the host name.
+	EmailDomain string `yaml:"email_domain"`
+	// Subject may contain {username} and {cert_type}.
+	Subject string `yaml:"subject"`
+	// Contact is added to the email as who to tell about certificates the
+	// user did not request.
+	Contact string `yaml:"contact"`
+	// Default: issuance_email_queue in the data directory.
+	QueueDirectory      string `yaml:"queue_directory"`
+	MaxDeliveryAttempts int    `yaml:"max_delivery_attempts"`
+}
+
+// issuanceNotice is queued for each request issuing certificates to a user
+// and rendered into an email when it is sent.
+type issuanceNotice struct {
+	Username    string `json:"username"`
+	CertType    string `json:"cert_type"`
+	RequestedBy string `json:"requested_by,omitempty"`
+	RemoteAddr  string `json:"remote_addr"`
+	// KeyFingerprints has one fingerprint for each certificate issued.
+	KeyFingerprints []string  `json:"key_fingerprints,omitempty"`
+	IssuedAt        time.Time `json:"issued_at"`
+	Instance        string    `json:"instance"`
+}
+
+// issuanceEmailSender delivers the issuance notices queued for a username.
+// The recipient is looked up at delivery, so that directory outages are
+// retried like relay outages.
+type issuanceEmailSender struct {
+	config IssuanceEmailConfig
+	lookup func(username string) (string, error)
+}
+
+func (config IssuanceEmailConfig) check() error {
+	if config.SMTPAddress == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(config.SMTPAddress); err != nil {
+		return fmt.Errorf("issuance_email: smtp_address: %s", err)
+	}
+	if _, err := mail.ParseAddress(config.From); err != nil {
+		return fmt.Errorf("issuance_email: from: %s", err)
+	}
+	if config.Username == "" && config.Password != "" {
+		return errors.New("issuance_email: password without username")
+	}
+	return nil
+}
+
+// publicKeyFingerprint returns the SHA256 fingerprint of key as printed by
+// ssh-keygen -l, so that users can compare it with their keys.
+func publicKeyFingerprint(key crypto.PublicKey) string {
+	sshKey, err := ssh.NewPublicKey(key)
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(sshKey)
+}
+
+// sshCertKeyFingerprint returns the fingerprint of the key certified by the
+// marshalled SSH certificate.
+func sshCertKeyFingerprint(certBytes []byte) string {
+	pubKey, err := ssh.ParsePublicKey(certBytes)
+	if err != nil {
+		return ""
+	}
+	if cert, ok := pubKey.(*ssh.Certificate); ok {
+		pubKey = cert.Key
+	}
+	return ssh.FingerprintSHA256(pubKey)
+}
+
+// setupIssuanceEmail opens the queue of the issuance emails if a mail relay
+// is configured.
+func (state *RuntimeState) setupIssuanceEmail() error {
+	config := state.Config.IssuanceEmail
+	if config.SMTPAddress == "" {
+		return nil
+	}
+	if config.QueueDirectory == "" {
+		config.QueueDirectory = filepath.Join(state.Config.Base.DataDirectory,
+			issuanceEmailQueueDirectory)
+	}
+	queue, err := deliveryqueue.New(config.QueueDirectory,
+		&issuanceEmailSender{config: config, lookup: state.userEmailAddress},
+		deliveryqueue.Params{MaxAttempts: config.MaxDeliveryAttempts},
+		logger)
+	if err != nil {
+		return err
+	}
+	state.issuanceEmailQueue = queue
+	return nil
+}
+
+// userEmailAddress returns the address issuance emails for username are
+// sent to.
+func (state *RuntimeState) userEmailAddress(username string) (string, error) {
+	attributes, err := state.getUserAttributes(username, []string{"mail"})
+	if err != nil {
+		return "", err
+	}
+	if mailList := attributes["mail"]; len(mailList) > 0 {
+		return mailList[0], nil
+	}
+	domain := state.Config.IssuanceEmail.EmailDomain
+	if domain == "" {
+		domain = state.HostIdentity
+		if len(state.Config.OpenIDConnectIDP.DefaultEmailDomain) > 3 {
+			domain = state.Config.OpenIDConnectIDP.DefaultEmailDomain
+		}
+	}
+	return username + "@" + domain, nil
+}
+
+// emailIssuance queues an email to the user certificates of the keys with
+// keyFingerprints were issued to for r, so that certificates requested with
+// a stolen password are noticed. A request issuing several certificates
+// sends a single email.
+func (state *RuntimeState) emailIssuance(r *http.Request, username string,
+	certType string, keyFingerprints ...string) {
+	if state.issuanceEmailQueue == nil {
+		return
+	}
+	payload, err := json.Marshal(issuanceNotice{
+		Username:        username,
+		CertType:        certType,
+		RequestedBy:     delegatedRequester(r),
+		RemoteAddr:      loginThrottleAddress(r),
+		KeyFingerprints: keyFingerprints,
+		IssuedAt:        time.Now(),
+		Instance:        state.HostIdentity,
+	})
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		return
+	}
+	if err := state.issuanceEmailQueue.Enqueue(username, payload); err != nil {
+		requestLogger(r).Errorf("Cannot queue issuance email for %s: %s", username, err)
+	}
+}
+
+func (s *issuanceEmailSender) Send(username string, payload []byte) error {
+	var notice issuanceNotice
+	if err := json.Unmarshal(payload, &notice); err != nil {
+		return err
+	}
+	recipient, err := s.lookup(username)
+	if err != nil {
+		return fmt.Errorf("cannot get the email address of %s: %s",
+			username, err)
+	}
+	message, err := notice.message(s.config, recipient)
+	if err != nil {
+		return err
+	}
+	return s.config.sendMail(recipient, message)
+}
+
+// stripLineBreaks keeps values from adding header lines.
+func stripLineBreaks(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+func (notice issuanceNotice) message(config IssuanceEmailConfig,
+	recipient string) ([]byte, error) {
+	to, err := mail.ParseAddress(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email address for %s: %s",
+			notice.Username, err)
+	}
+	subject := config.Subject
+	if subject == "" {
+		subject = defaultIssuanceEmailSubject
+	}
+	subject = strings.NewReplacer("{username}", notice.Username,
+		"{cert_type}", notice.CertType).Replace(subject)
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "From: %s\r\n", stripLineBreaks(config.From))
+	fmt.Fprintf(&buffer, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buffer, "Subject: %s\r\n",
+		mime.QEncoding.Encode("utf-8", stripLineBreaks(subject)))
+	fmt.Fprintf(&buffer, "Date: %s\r\n",
+		notice.IssuedAt.Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	if count := len(notice.KeyFingerprints); count > 1 {
+		fmt.Fprintf(&buffer,
+			"%d %s certificates were issued for %s by %s.\r\n\r\n",
+			count, notice.CertType, notice.Username, notice.Instance)
+	} else {
+		fmt.Fprintf(&buffer,
+			"A %s certificate was issued for %s by %s.\r\n\r\n",
+			notice.CertType, notice.Username, notice.Instance)
+	}
+	fmt.Fprintf(&buffer, "Issued at:       %s\r\n",
+		notice.IssuedAt.Format(time.RFC3339))
+	fmt.Fprintf(&buffer, "Requested from:  %s\r\n", notice.RemoteAddr)
+	if notice.RequestedBy != "" {
+		fmt.Fprintf(&buffer, "Requested by:    %s\r\n", notice.RequestedBy)
+	}
+	for _, keyFingerprint := range notice.KeyFingerprints {
+		if keyFingerprint != "" {
+			fmt.Fprintf(&buffer, "Key fingerprint: %s\r\n", keyFingerprint)
+		}
+	}
+	buffer.WriteString("\r\nIf you did not request this certificate your " +
+		"password may have been stolen. Change it")
+	if config.Contact != "" {
+		fmt.Fprintf(&buffer, " and contact %s", config.Contact)
+	}
+	buffer.WriteString(".\r\n")
+	return buffer.Bytes(), nil
+}
+
+// sendMail sends message through the relay, bounding the whole exchange by
+// smtpTimeout, which smtp.SendMail does not.
+func (config IssuanceEmailConfig) sendMail(recipient string,
+	message []byte) error {
+	host, _, err := net.SplitHostPort(config.SMTPAddress)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", config.SMTPAddress, smtpTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	} else if config.RequireTLS {
+		return fmt.Errorf("%s does not offer STARTTLS", config.SMTPAddress)
+	}
+	if config.Username != "" {
+		err := client.Auth(smtp.PlainAuth("", config.Username,
+			config.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(recipient)
+	if err != nil {
+		return err
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// issuanceEmailDeadLettersHandler is served on the admin port, like
+// deadLettersHandler for the issuance emails which could not be sent.
+func (state *RuntimeState) issuanceEmailDeadLettersHandler(
+	w http.ResponseWriter, r *http.Request) {
+	if state.issuanceEmailQueue == nil {
+		http.Error(w, "Issuance email not configured", http.StatusNotFound)
+		return
+	}
+	state.serveDeadLetters(w, r, state.issuanceEmailQueue)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type smtpTestMessage struct {
+	from, to, data string
+}
+
+// serveSMTPTest accepts mail on listener, without any extensions, and sends
+// each message to messages.
+func serveSMTPTest(listener net.Listener, messages chan<- smtpTestMessage) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+			reply("220 localhost ESMTP test")
+			var message smtpTestMessage
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				line = strings.TrimRight(line, "\r\n")
+				command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+				switch {
+				case command == "EHLO" || command == "HELO":
+					reply("250 localhost")
+				case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+					message.from = line[len("MAIL FROM:"):]
+					reply("250 OK")
+				case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+					message.to = line[len("RCPT TO:"):]
+					reply("250 OK")
+				case command == "DATA":
+					reply("354 go ahead")
+					var data []string
+					for {
+						line, err := reader.ReadString('\n')
+						if err != nil {
+							return
+						}
+						if line == ".\r\n" {
+							break
+						}
+						data = append(data, line)
+					}
+					message.data = strings.Join(data, "")
+					messages <- message
+					reply("250 OK")
+				case command == "QUIT":
+					reply("221 bye")
+					return
+				default:
+					reply("502 not implemented")
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestIssuanceEmail(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	messages := make(chan smtpTestMessage, 1)
+	go serveSMTPTest(listener, messages)
+	queueDir, err := ioutil.TempDir("", "issuance_email_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(queueDir)
+	state.Config.IssuanceEmail = IssuanceEmailConfig{
+		SMTPAddress:    listener.Addr().String(),
+		From:           "Keymaster <keymaster@example.com>",
+		EmailDomain:    "example.com",
+		Contact:        "security@example.com",
+		QueueDirectory: queueDir,
+	}
+	if err := state.Config.IssuanceEmail.check(); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.setupIssuanceEmail(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/certgen/username?type=ssh", nil)
+	req.RemoteAddr = "192.0.2.7:4321"
+	state.emailIssuance(req, "username", "ssh", "SHA256:abcdef")
+	select {
+	case message := <-messages:
+		if message.to != "<username@example.com>" ||
+			message.from != "<keymaster@example.com>" {
+			t.Errorf("envelope: %q %q", message.from, message.to)
+		}
+		for _, expected := range []string{
+			"To: <username@example.com>\r\n",
+			"Subject: Keymaster certificate issued for username\r\n",
+			"Requested from:  192.0.2.7\r\n",
+			"Key fingerprint: SHA256:abcdef\r\n",
+			"contact security@example.com",
+		} {
+			if !strings.Contains(message.data, expected) {
+				t.Errorf("%q not in message:\n%s", expected, message.data)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("email was not sent")
+	}
+
+	// A bundle of certificates is reported in one email.
+	state.emailIssuance(req, "username", "ssh", "SHA256:abcdef",
+		"SHA256:ghijkl")
+	select {
+	case message := <-messages:
+		for _, expected := range []string{
+			"2 ssh certificates were issued for username",
+			"Key fingerprint: SHA256:abcdef\r\n",
+			"Key fingerprint: SHA256:ghijkl\r\n",
+		} {
+			if !strings.Contains(message.data, expected) {
+				t.Errorf("%q not in message:\n%s", expected, message.data)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("email was not sent")
+	}
+	select {
+	case message := <-messages:
+		t.Fatalf("unexpected email:\n%s", message.data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The test relay does not offer STARTTLS.
+	config := state.Config.IssuanceEmail
+	config.RequireTLS = true
+	err = config.sendMail("username@example.com", []byte("Subject: test\r\n"))
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("require_tls: %v", err)
+	}
+}
+
+func TestIssuanceEmailConfigCheck(t *testing.T) {
+	for _, config := range []IssuanceEmailConfig{
+		{SMTPAddress: "mail.example.com", From: "keymaster@example.com"},
+		{SMTPAddress: "mail.example.com:25", From: "keymaster"},
+		{SMTPAddress: "mail.example.com:25", From: "keymaster@example.com",
+			Password: "secret"},
+	} {
+		if err := config.check(); err == nil {
+			t.Errorf("%+v accepted", config)
+		}
+	}
+	if err := (IssuanceEmailConfig{}).check(); err != nil {
+		t.Errorf("disabled: %s", err)
+	}
+}
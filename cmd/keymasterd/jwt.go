@@ -2,6 +2,8 @@ package main
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"errors"
 	"fmt"
@@ -24,16 +26,34 @@ func getKeyFingerprint(key crypto.PublicKey) (string, error) {
 	return fp, nil
 }
 
-func (state *RuntimeState) idpGetIssuer() string {
-	issuer := "https://" + state.HostIdentity
-	if state.Config.Base.HttpAddress != ":443" {
-		issuer = issuer + state.Config.Base.HttpAddress
+// joseSignatureAlgorithm returns the JWS algorithm for the type of key.
+func joseSignatureAlgorithm(key crypto.PublicKey) jose.SignatureAlgorithm {
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return jose.EdDSA
+	case *ecdsa.PublicKey:
+		switch key.Curve.Params().BitSize {
+		case 384:
+			return jose.ES384
+		case 521:
+			return jose.ES512
+		}
+		return jose.ES256
 	}
-	return issuer
+	return jose.RS256
+}
+
+func joseSigningKey(signer crypto.Signer) jose.SigningKey {
+	return jose.SigningKey{Algorithm: joseSignatureAlgorithm(signer.Public()),
+		Key: signer}
+}
+
+func (state *RuntimeState) idpGetIssuer() string {
+	return "https://" + state.HostIdentity + state.publicPortSuffix()
 }
 
 func (state *RuntimeState) JWTClaims(t *jwt.JSONWebToken, dest ...interface{}) (err error) {
-	for _, key := range state.KeymasterPublicKeys {
+	for _, key := range state.currentCA().publicKeys {
 		err = t.Claims(key, dest...)
 		if err == nil {
 			return nil
@@ -46,18 +66,20 @@ func (state *RuntimeState) JWTClaims(t *jwt.JSONWebToken, dest ...interface{}) (
 	return err
 }
 
-func (state *RuntimeState) genNewSerializedAuthJWT(username string, authLevel int) (string, error) {
+func (state *RuntimeState) genNewSerializedAuthJWT(username string, authLevel int, binding string,
+	sessionID string, expires time.Time) (string, error) {
 	signerOptions := (&jose.SignerOptions{}).WithType("JWT")
-	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: state.Signer}, signerOptions)
+	signer, err := jose.NewSigner(joseSigningKey(state.currentCA().signer), signerOptions)
 	if err != nil {
 		return "", err
 	}
 	issuer := state.idpGetIssuer()
 	authToken := authInfoJWT{Issuer: issuer, Subject: username,
-		Audience: []string{issuer}, AuthType: authLevel, TokenType: "keymaster_auth"}
+		Audience: []string{issuer}, AuthType: authLevel, TokenType: "keymaster_auth",
+		SessionBinding: binding, SessionID: sessionID}
 	authToken.NotBefore = time.Now().Unix()
 	authToken.IssuedAt = authToken.NotBefore
-	authToken.Expiration = authToken.IssuedAt + maxAgeSecondsAuthCookie // TODO seek the actual duration
+	authToken.Expiration = expires.Unix()
 
 	return jwt.Signed(signer).Claims(authToken).CompactSerialize()
 }
@@ -83,12 +105,14 @@ func (state *RuntimeState) getAuthInfoFromAuthJWT(serializedToken string) (rvalu
 	rvalue.Username = inboundJWT.Subject
 	rvalue.AuthType = inboundJWT.AuthType
 	rvalue.ExpiresAt = time.Unix(inboundJWT.Expiration, 0)
+	rvalue.SessionBinding = inboundJWT.SessionBinding
+	rvalue.SessionID = inboundJWT.SessionID
 	return rvalue, nil
 }
 
 func (state *RuntimeState) updateAuthJWTWithNewAuthLevel(intoken string, newAuthLevel int) (string, error) {
 	signerOptions := (&jose.SignerOptions{}).WithType("JWT")
-	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: state.Signer}, signerOptions)
+	signer, err := jose.NewSigner(joseSigningKey(state.currentCA().signer), signerOptions)
 	if err != nil {
 		return "", err
 	}
@@ -114,7 +138,7 @@ func (state *RuntimeState) updateAuthJWTWithNewAuthLevel(intoken string, newAuth
 
 func (state *RuntimeState) genNewSerializedStorageStringDataJWT(username string, dataType int, data string, expiration int64) (string, error) {
 	signerOptions := (&jose.SignerOptions{}).WithType("JWT")
-	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: state.Signer}, signerOptions)
+	signer, err := jose.NewSigner(joseSigningKey(state.currentCA().signer), signerOptions)
 	if err != nil {
 		return "", err
 	}
@@ -149,3 +173,49 @@ func (state *RuntimeState) getStorageDataFromStorageStringDataJWT(serializedToke
 	}
 	return inboundJWT, nil
 }
+
+func getSessionHash(sessionValue string) string {
+	h := sha256.Sum256([]byte(sessionValue))
+	return fmt.Sprintf("%x", h)
+}
+
+// genNewSerializedCSRFToken returns a token bound to the username and to the
+// current session cookie value (empty when there is no session cookie).
+func (state *RuntimeState) genNewSerializedCSRFToken(username string, sessionValue string) (string, error) {
+	signerOptions := (&jose.SignerOptions{}).WithType("JWT")
+	signer, err := jose.NewSigner(joseSigningKey(state.currentCA().signer), signerOptions)
+	if err != nil {
+		return "", err
+	}
+	issuer := state.idpGetIssuer()
+	csrfToken := csrfTokenJWT{Issuer: issuer, Subject: username,
+		Audience: []string{issuer}, TokenType: "keymaster_csrf",
+		SessionHash: getSessionHash(sessionValue)}
+	csrfToken.NotBefore = time.Now().Unix()
+	csrfToken.IssuedAt = csrfToken.NotBefore
+	csrfToken.Expiration = csrfToken.IssuedAt + maxAgeSecondsAuthCookie
+
+	return jwt.Signed(signer).Claims(csrfToken).CompactSerialize()
+}
+
+func (state *RuntimeState) verifyCSRFToken(serializedToken string, username string, sessionValue string) error {
+	tok, err := jwt.ParseSigned(serializedToken)
+	if err != nil {
+		return err
+	}
+	inboundJWT := csrfTokenJWT{}
+	if err := state.JWTClaims(tok, &inboundJWT); err != nil {
+		return err
+	}
+	issuer := state.idpGetIssuer()
+	now := time.Now().Unix()
+	if inboundJWT.Issuer != issuer || inboundJWT.TokenType != "keymaster_csrf" ||
+		inboundJWT.NotBefore > now || inboundJWT.Expiration < now {
+		return errors.New("invalid JWT values")
+	}
+	if inboundJWT.Subject != username ||
+		inboundJWT.SessionHash != getSessionHash(sessionValue) {
+		return errors.New("CSRF token does not match session")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAuthJWTNonRSASigners(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for algorithm, signer := range map[string]crypto.Signer{
+		"ES384": ecdsaKey, "EdDSA": ed25519Key} {
+		state.Signer = signer
+		state.KeymasterPublicKeys = []crypto.PublicKey{signer.Public()}
+		token, err := state.genNewSerializedAuthJWT("username", AuthTypeU2F,
+			"", "", time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := state.getAuthInfoFromAuthJWT(token)
+		if err != nil {
+			t.Fatalf("%s: %s", algorithm, err)
+		}
+		if info.Username != "username" || info.AuthType != AuthTypeU2F {
+			t.Errorf("%s: unexpected auth info: %+v", algorithm, info)
+		}
+		if algorithms := state.idTokenSigningAlgorithms(); len(algorithms) != 1 ||
+			algorithms[0] != algorithm {
+			t.Errorf("unexpected ID token algorithms: %v", algorithms)
+		}
+		req, err := http.NewRequest("GET", idpOpenIDCJWKSPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := checkRequestHandlerCode(req, state.idpOpenIDCJWKSHandler,
+			http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys struct {
+			Keys []struct {
+				Kty string `json:"kty"`
+			} `json:"keys"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &keys); err != nil {
+			t.Fatal(err)
+		}
+		if len(keys.Keys) != 1 || keys.Keys[0].Kty == "" {
+			t.Errorf("%s: unexpected keys: %s", algorithm, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultMinRSABits = 2048
+	minMinRSABits     = 1024
+)
+
+// Reasons for rejecting a public key, returned to clients so that they can
+// tell the user what kind of key to use instead.
+const (
+	keyRejectedUnparsable            = "unparsable"
+	keyRejectedCertificate           = "certificate"
+	keyRejectedUnsupportedType       = "unsupported_type"
+	keyRejectedDSA                   = "dsa"
+	keyRejectedRSATooShort           = "rsa_too_short"
+	keyRejectedEllipticCurveRequired = "elliptic_curve_required"
+)
+
+type KeyPolicyConfig struct {
+	// Default: 2048.
+	MinRSABits int `yaml:"min_rsa_bits"`
+	// Only accept Ed25519 and ECDSA keys, including security key backed
+	// ones.
+	RequireEllipticCurve bool `yaml:"require_elliptic_curve"`
+}
+
+// keyPolicyError explains why a public key was rejected.
+type keyPolicyError struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (err *keyPolicyError) Error() string {
+	return err.Message
+}
+
+func newKeyPolicyError(reason, format string,
+	args ...interface{}) *keyPolicyError {
+	return &keyPolicyError{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+func (config KeyPolicyConfig) check() error {
+	if config.MinRSABits != 0 && config.MinRSABits < minMinRSABits {
+		return fmt.Errorf("key_policy: min_rsa_bits must be at least %d",
+			minMinRSABits)
+	}
+	return nil
+}
+
+func (config KeyPolicyConfig) minRSABits() int {
+	if config.MinRSABits == 0 {
+		return defaultMinRSABits
+	}
+	return config.MinRSABits
+}
+
+// checkPublicKey returns a *keyPolicyError if key may not be certified.
+func (config KeyPolicyConfig) checkPublicKey(key crypto.PublicKey) error {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if config.RequireEllipticCurve {
+			return newKeyPolicyError(keyRejectedEllipticCurveRequired,
+				"RSA keys are not accepted, use an Ed25519 or ECDSA key")
+		}
+		if bits := key.N.BitLen(); bits < config.minRSABits() {
+			return newKeyPolicyError(keyRejectedRSATooShort,
+				"RSA key has %d bits, at least %d are required", bits,
+				config.minRSABits())
+		}
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return newKeyPolicyError(keyRejectedUnsupportedType,
+			"unsupported key type %T", key)
+	}
+	return nil
+}
+
+// checkSSHPublicKey parses authorizedKey, a line of an authorized_keys file,
+// and checks its key against the policy.
+func (config KeyPolicyConfig) checkSSHPublicKey(
+	authorizedKey string) (ssh.PublicKey, error) {
+	pubKey, _, _, rest, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return nil, newKeyPolicyError(keyRejectedUnparsable,
+			"cannot parse SSH public key: %s", err)
+	}
+	if len(strings.TrimSpace(string(rest))) > 0 {
+		return nil, newKeyPolicyError(keyRejectedUnparsable,
+			"more than one SSH public key on a line")
+	}
+	switch pubKey.Type() {
+	case ssh.KeyAlgoDSA:
+		return nil, newKeyPolicyError(keyRejectedDSA,
+			"DSA (ssh-dss) keys are not accepted, use an Ed25519 key")
+	case ssh.KeyAlgoRSA, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384,
+		ssh.KeyAlgoECDSA521, ssh.KeyAlgoED25519:
+	// Keys held by FIDO security keys.
+	case ssh.KeyAlgoSKECDSA256, ssh.KeyAlgoSKED25519:
+	default:
+		if _, ok := pubKey.(*ssh.Certificate); ok {
+			return nil, newKeyPolicyError(keyRejectedCertificate,
+				"a certificate was given instead of a public key")
+		}
+		return nil, newKeyPolicyError(keyRejectedUnsupportedType,
+			"unsupported SSH key type %s", pubKey.Type())
+	}
+	cryptoKey, ok := pubKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, newKeyPolicyError(keyRejectedUnsupportedType,
+			"unsupported SSH key type %s", pubKey.Type())
+	}
+	if err := config.checkPublicKey(cryptoKey.CryptoPublicKey()); err != nil {
+		return nil, err
+	}
+	return pubKey, nil
+}
+
+// writeKeyPolicyError rejects a request with a key failing the policy. JSON
+// clients get the reason as a JSON object.
+func (state *RuntimeState) writeKeyPolicyError(w http.ResponseWriter,
+	r *http.Request, err error) {
+	policyErr, ok := err.(*keyPolicyError)
+	if !ok {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	requestLogger(r).Debugf(1, "Rejected public key: %s", policyErr.Message)
+	if !isJSONRequest(r) &&
+		!strings.Contains(r.Header.Get("Accept"), "application/json") {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			fmt.Sprintf("Key rejected (%s): %s", policyErr.Reason,
+				policyErr.Message))
+		return
+	}
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+		*keyPolicyError
+	}{"key_rejected", policyErr})
+}
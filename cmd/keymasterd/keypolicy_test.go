@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestAuthorizedKey(t *testing.T, key interface{}) string {
+	sshPublic, err := ssh.NewPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(ssh.MarshalAuthorizedKey(sshPublic))
+}
+
+// testDSAAuthorizedKey returns an ssh-dss key with parameters of the right
+// sizes, which is all the parser checks.
+func testDSAAuthorizedKey() string {
+	one := big.NewInt(1)
+	wire := ssh.Marshal(struct {
+		Name       string
+		P, Q, G, Y *big.Int
+	}{ssh.KeyAlgoDSA, new(big.Int).Lsh(one, 1023), new(big.Int).Lsh(one, 159),
+		big.NewInt(2), big.NewInt(3)})
+	return ssh.KeyAlgoDSA + " " + base64.StdEncoding.EncodeToString(wire) + "\n"
+}
+
+// newTestSecurityKeyAuthorizedKeys returns an sk-ssh-ed25519 and an
+// sk-ecdsa-sha2-nistp256 key, as generated by ssh-keygen -t ed25519-sk and
+// ecdsa-sk.
+func newTestSecurityKeyAuthorizedKeys(t *testing.T) []string {
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wires := [][]byte{
+		ssh.Marshal(struct {
+			Name        string
+			KeyBytes    []byte
+			Application string
+		}{ssh.KeyAlgoSKED25519, edPublic, "ssh:"}),
+		ssh.Marshal(struct {
+			Name        string
+			ID          string
+			KeyBytes    []byte
+			Application string
+		}{ssh.KeyAlgoSKECDSA256, "nistp256",
+			elliptic.Marshal(elliptic.P256(), ecdsaKey.X, ecdsaKey.Y),
+			"ssh:"}),
+	}
+	var keys []string
+	for _, wire := range wires {
+		sshPublic, err := ssh.ParsePublicKey(wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, string(ssh.MarshalAuthorizedKey(sshPublic)))
+	}
+	return keys
+}
+
+func TestKeyPolicy(t *testing.T) {
+	shortRSA, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	securityKeys := newTestSecurityKeyAuthorizedKeys(t)
+	var policy KeyPolicyConfig
+	for _, test := range []struct {
+		key    string
+		reason string
+	}{
+		{testUserSSHPublicKey, ""},
+		{securityKeys[0], ""},
+		{securityKeys[1], ""},
+		{newTestAuthorizedKey(t, &p384.PublicKey), ""},
+		{newTestEd25519AuthorizedKey(t), ""},
+		{newTestAuthorizedKey(t, &shortRSA.PublicKey), keyRejectedRSATooShort},
+		{testDSAAuthorizedKey(), keyRejectedDSA},
+		{"ssh-ed25519 garbage", keyRejectedUnparsable},
+	} {
+		_, err := policy.checkSSHPublicKey(test.key)
+		if test.reason == "" {
+			if err != nil {
+				t.Errorf("%.30s rejected: %s", test.key, err)
+			}
+			continue
+		}
+		policyErr, ok := err.(*keyPolicyError)
+		if !ok || policyErr.Reason != test.reason {
+			t.Errorf("%.30s: got %v, want %s", test.key, err, test.reason)
+		}
+	}
+	policy.RequireEllipticCurve = true
+	_, err = policy.checkSSHPublicKey(testUserSSHPublicKey)
+	if policyErr, ok := err.(*keyPolicyError); !ok ||
+		policyErr.Reason != keyRejectedEllipticCurveRequired {
+		t.Errorf("RSA key accepted: %v", err)
+	}
+	if _, err := policy.checkSSHPublicKey(securityKeys[1]); err != nil {
+		t.Errorf("security key rejected: %s", err)
+	}
+	if err := (KeyPolicyConfig{MinRSABits: 512}).check(); err == nil {
+		t.Error("min_rsa_bits of 512 accepted")
+	}
+}
+
+func TestSigningSecurityKeys(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range newTestSecurityKeyAuthorizedKeys(t) {
+		body, err := json.Marshal(proto.CertRequest{PublicKey: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/certgen/username",
+			bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+			http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey(rr.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, ok := pubKey.(*ssh.Certificate)
+		if !ok {
+			t.Fatal("no certificate returned")
+		}
+		if keyType := strings.Fields(key)[0]; cert.Key.Type() != keyType {
+			t.Errorf("certificate for a %s key, not %s", cert.Key.Type(),
+				keyType)
+		}
+	}
+}
+
+func TestSigningRejectedKey(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(proto.CertRequest{
+		PublicKeys: []string{testUserSSHPublicKey, testDSAAuthorizedKey()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/certgen/username",
+		bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+		http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response struct {
+		Error   string `json:"error"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Error != "key_rejected" || response.Reason != keyRejectedDSA ||
+		response.Message[:6] != "key 2:" {
+		t.Errorf("unexpected response: %+v", response)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/Symantec/keymaster/lib/certlint"
+	"gopkg.in/yaml.v2"
+)
+
+// The kubeconfig certificate type returns a kubeconfig for a Kubernetes
+// cluster with an X.509 client certificate: the common name is the user and
+// the organizations are their groups, which is how the API server maps
+// client certificates to users and groups.
+const (
+	certTypeKubeconfig                   = "kubeconfig"
+	defaultKubernetesMaxCertDurationSecs = 3600
+	defaultKubernetesClientKey           = "keymaster.key"
+	// Groups with this prefix, such as system:masters, are reserved by
+	// Kubernetes and never put into certificates.
+	kubernetesReservedGroupPrefix = "system:"
+)
+
+var kubernetesClusterNameRegexp = regexp.MustCompile(
+	`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+type KubernetesClusterConfig struct {
+	Name string `yaml:"name"`
+	// The URL of the API server.
+	Server string `yaml:"server"`
+	// The PEM CA certificates of the API server. Default: the system roots.
+	CertificateAuthorityFilename string `yaml:"certificate_authority_filename"`
+	Namespace                    string `yaml:"namespace"`
+	// AllowedGroupsRE limits the groups put into certificates to those
+	// matching one of the expressions. Default: all groups.
+	AllowedGroupsRE []string `yaml:"allowed_groups_re"`
+}
+
+type KubernetesConfig struct {
+	// The first cluster is the default.
+	Clusters []KubernetesClusterConfig `yaml:"clusters"`
+	// Default: 3600, at most 86400.
+	MaxCertDurationSecs uint `yaml:"max_cert_duration_secs"`
+}
+
+type kubernetesCluster struct {
+	config                   KubernetesClusterConfig
+	certificateAuthorityData []byte
+	allowedGroups            []*regexp.Regexp
+}
+
+// The kubeconfig file format, as much of it as is written here. The data
+// fields are base64 encoded.
+type kubeconfig struct {
+	APIVersion     string                   `yaml:"apiVersion"`
+	Kind           string                   `yaml:"kind"`
+	Clusters       []kubeconfigNamedCluster `yaml:"clusters"`
+	Users          []kubeconfigNamedUser    `yaml:"users"`
+	Contexts       []kubeconfigNamedContext `yaml:"contexts"`
+	CurrentContext string                   `yaml:"current-context"`
+}
+
+type kubeconfigNamedCluster struct {
+	Name    string            `yaml:"name"`
+	Cluster kubeconfigCluster `yaml:"cluster"`
+}
+
+type kubeconfigCluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data,omitempty"`
+}
+
+type kubeconfigNamedUser struct {
+	Name string         `yaml:"name"`
+	User kubeconfigUser `yaml:"user"`
+}
+
+type kubeconfigUser struct {
+	ClientCertificateData string `yaml:"client-certificate-data"`
+	ClientKey             string `yaml:"client-key"`
+}
+
+type kubeconfigNamedContext struct {
+	Name    string            `yaml:"name"`
+	Context kubeconfigContext `yaml:"context"`
+}
+
+type kubeconfigContext struct {
+	Cluster   string `yaml:"cluster"`
+	User      string `yaml:"user"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+func (config *KubernetesConfig) maxCertDuration() time.Duration {
+	if config.MaxCertDurationSecs == 0 {
+		return defaultKubernetesMaxCertDurationSecs * time.Second
+	}
+	return time.Duration(config.MaxCertDurationSecs) * time.Second
+}
+
+func (config *KubernetesClusterConfig) check() error {
+	if !kubernetesClusterNameRegexp.MatchString(config.Name) {
+		return fmt.Errorf("bad name: %q", config.Name)
+	}
+	u, err := url.Parse(config.Server)
+	if err != nil {
+		return fmt.Errorf("%s: server: %s", config.Name, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%s: server is not an HTTPS URL: %s", config.Name,
+			config.Server)
+	}
+	return nil
+}
+
+// newKubernetesClusters checks the clusters and loads their CA certificates.
+func newKubernetesClusters(config KubernetesConfig) (
+	[]*kubernetesCluster, error) {
+	if config.maxCertDuration() > maxCertDuration {
+		return nil, fmt.Errorf("kubernetes: max_cert_duration_secs above %d",
+			int(maxCertDuration.Seconds()))
+	}
+	clusters := make([]*kubernetesCluster, 0, len(config.Clusters))
+	names := make(map[string]struct{}, len(config.Clusters))
+	for _, clusterConfig := range config.Clusters {
+		if err := clusterConfig.check(); err != nil {
+			return nil, fmt.Errorf("kubernetes: %s", err)
+		}
+		if _, ok := names[clusterConfig.Name]; ok {
+			return nil, fmt.Errorf("kubernetes: duplicate cluster %s",
+				clusterConfig.Name)
+		}
+		names[clusterConfig.Name] = struct{}{}
+		cluster := &kubernetesCluster{config: clusterConfig}
+		for _, expression := range clusterConfig.AllowedGroupsRE {
+			re, err := regexp.Compile("^(?:" + expression + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("kubernetes: %s: allowed_groups_re: %s",
+					clusterConfig.Name, err)
+			}
+			cluster.allowedGroups = append(cluster.allowedGroups, re)
+		}
+		if filename := clusterConfig.CertificateAuthorityFilename; filename != "" {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return nil, fmt.Errorf("kubernetes: %s: %s", clusterConfig.Name,
+					err)
+			}
+			if !x509.NewCertPool().AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf(
+					"kubernetes: %s: no certificates in %s",
+					clusterConfig.Name, filename)
+			}
+			cluster.certificateAuthorityData = data
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters, nil
+}
+
+// lookupKubernetesCluster returns the cluster called name, or the default
+// one if name is empty.
+func (state *RuntimeState) lookupKubernetesCluster(
+	name string) (*kubernetesCluster, bool) {
+	for _, cluster := range state.kubernetesClusters {
+		if name == "" || cluster.config.Name == name {
+			return cluster, true
+		}
+	}
+	return nil, false
+}
+
+// certGroups returns the groups of userGroups which may be organizations of
+// a certificate for the cluster: never the reserved system: groups, and
+// only those allowed by allowed_groups_re if set.
+func (cluster *kubernetesCluster) certGroups(userGroups []string) []string {
+	var groups []string
+	for _, group := range userGroups {
+		if strings.HasPrefix(group, kubernetesReservedGroupPrefix) {
+			continue
+		}
+		allowed := len(cluster.allowedGroups) < 1
+		for _, re := range cluster.allowedGroups {
+			if re.MatchString(group) {
+				allowed = true
+				break
+			}
+		}
+		if allowed {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// kubeconfigClientKey returns the path of the private key in the
+// kubeconfig, relative to the kubeconfig itself unless absolute.
+func kubeconfigClientKey(r *http.Request) string {
+	if clientKey := r.Form.Get("client_key"); clientKey != "" {
+		return clientKey
+	}
+	return defaultKubernetesClientKey
+}
+
+func newKubeconfig(cluster *kubernetesCluster, username string,
+	certPEM []byte, clientKey string) kubeconfig {
+	userName := username + "@" + cluster.config.Name
+	return kubeconfig{
+		APIVersion: "v1",
+		Kind:       "Config",
+		Clusters: []kubeconfigNamedCluster{{
+			Name: cluster.config.Name,
+			Cluster: kubeconfigCluster{
+				Server: cluster.config.Server,
+				CertificateAuthorityData: base64.StdEncoding.EncodeToString(
+					cluster.certificateAuthorityData),
+			},
+		}},
+		Users: []kubeconfigNamedUser{{
+			Name: userName,
+			User: kubeconfigUser{
+				ClientCertificateData: base64.StdEncoding.EncodeToString(
+					certPEM),
+				ClientKey: clientKey,
+			},
+		}},
+		Contexts: []kubeconfigNamedContext{{
+			Name: userName,
+			Context: kubeconfigContext{
+				Cluster:   cluster.config.Name,
+				User:      userName,
+				Namespace: cluster.config.Namespace,
+			},
+		}},
+		CurrentContext: userName,
+	}
+}
+
+// postAuthKubeconfigHandler issues a client certificate for the PEM public
+// key in the form and returns it in a kubeconfig for the cluster parameter.
+// The private key stays with the user: the kubeconfig points to it with the
+// client_key parameter.
+func (state *RuntimeState) postAuthKubeconfigHandler(
+	w http.ResponseWriter, r *http.Request, targetUser string,
+	keySigner crypto.Signer, duration time.Duration, authLevel int) {
+	if r.Method != "POST" && r.Method != "PUT" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	cluster, ok := state.lookupKubernetesCluster(r.Form.Get("cluster"))
+	if !ok {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Unknown Kubernetes cluster")
+		return
+	}
+	duration, err := capCertDuration(r, duration,
+		state.Config.Kubernetes.maxCertDuration())
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	pubKeyData, err := getPublicKeyDataFromForm(r)
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Missing public key file")
+		return
+	}
+	block, _ := pem.Decode(pubKeyData)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid File, Unable to decode pem")
+		return
+	}
+	userPub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Cannot parse public key")
+		return
+	}
+	if err := state.Config.KeyPolicy.checkPublicKey(userPub); err != nil {
+		state.writeKeyPolicyError(w, r, err)
+		return
+	}
+	// Without its groups the user would have fewer rights in the cluster
+	// than expected, so a failed lookup is an error.
+	userGroups, err := state.getUserGroupsContext(r.Context(), targetUser)
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	userGroups = cluster.certGroups(userGroups)
+	caCert, caChainPEM, err := state.x509Issuer(keySigner)
+	if err != nil {
+		state.writeFailureResponse(w, r, caKeyErrorStatus(err), "")
+		requestLogger(r).Errorf("%s", err)
+		return
+	}
+	signingSpan := startSigningSpan(r, certTypeKubeconfig)
+	derCert, err := certgen.GenUserX509CertWithOptions(targetUser, userPub,
+		caCert, keySigner, state.KerberosRealm, duration, nil, userGroups,
+		state.x509CertOptions(caCert))
+	endSpan(signingSpan, err)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		requestLogger(r).Errorf("Cannot Generate kubeconfig cert: %s", err)
+		return
+	}
+	err = state.lintIssuedX509Cert(certTypeKubeconfig, targetUser, derCert,
+		certlint.X509Profile{
+			CA:          caCert,
+			MaxLifetime: duration,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			CommonName:  targetUser,
+		})
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	err = state.recordIssuedX509Cert(requestID(r), certTypeKubeconfig, derCert)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	eventNotifier.PublishX509(derCert)
+	certPEM := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+		Bytes: derCert}), caChainPEM...)
+	data, err := yaml.Marshal(newKubeconfig(cluster, targetUser, certPEM,
+		kubeconfigClientKey(r)))
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		requestLogger(r).Errorf("Cannot marshal kubeconfig: %s", err)
+		return
+	}
+	metricLogCertDuration(certTypeKubeconfig, "granted",
+		float64(duration.Seconds()))
+	state.recordIssuedCertBy(targetUser, delegatedRequester(r), requestID(r),
+		certTypeKubeconfig, describePublicKey(userPub), sshCertRecord{},
+		authLevel, duration)
+	state.emailIssuance(r, targetUser, certTypeKubeconfig,
+		publicKeyFingerprint(userPub))
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="%s.kubeconfig"`,
+			cluster.config.Name))
+	w.WriteHeader(200)
+	w.Write(data)
+	requestLogger(r).Printf("Generated kubeconfig for %s on %s%s", targetUser,
+		cluster.config.Name, requestedBySuffix(r))
+	go func(username string, certType string) {
+		metricsMutex.Lock()
+		defer metricsMutex.Unlock()
+		certGenCounter.WithLabelValues(username, certType).Inc()
+	}(targetUser, certTypeKubeconfig)
+}
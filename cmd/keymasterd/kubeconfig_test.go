@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestKubeconfig(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	clusterCAFilename := filepath.Join(dir, "cluster-ca.pem")
+	clusterCA := []byte(testSignerX509Cert)
+	if err := ioutil.WriteFile(clusterCAFilename, clusterCA, 0600); err != nil {
+		t.Fatal(err)
+	}
+	state.kubernetesClusters, err = newKubernetesClusters(KubernetesConfig{
+		Clusters: []KubernetesClusterConfig{
+			{Name: "prod", Server: "https://prod.example.com:6443"},
+			{
+				Name:                         "staging",
+				Server:                       "https://staging.example.com:6443",
+				CertificateAuthorityFilename: clusterCAFilename,
+				Namespace:                    "dev",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(query string, expectedStatus int) []byte {
+		req, err := createKeyBodyRequest("POST",
+			"/certgen/username?type=kubeconfig"+query, testUserPEMPublicKey, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr.Body.Bytes()
+	}
+
+	var config kubeconfig
+	if err := yaml.Unmarshal(request("&cluster=staging&client_key=k.pem",
+		http.StatusOK), &config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Clusters) != 1 || len(config.Users) != 1 ||
+		len(config.Contexts) != 1 {
+		t.Fatalf("unexpected kubeconfig: %+v", config)
+	}
+	cluster := config.Clusters[0]
+	if cluster.Name != "staging" ||
+		cluster.Cluster.Server != "https://staging.example.com:6443" {
+		t.Errorf("unexpected cluster: %+v", cluster)
+	}
+	if data, _ := base64.StdEncoding.DecodeString(
+		cluster.Cluster.CertificateAuthorityData); string(data) != string(clusterCA) {
+		t.Errorf("unexpected cluster CA: %q", data)
+	}
+	user := config.Users[0]
+	if user.Name != "username@staging" || user.User.ClientKey != "k.pem" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	expectedContext := kubeconfigNamedContext{
+		Name: "username@staging",
+		Context: kubeconfigContext{
+			Cluster:   "staging",
+			User:      "username@staging",
+			Namespace: "dev",
+		},
+	}
+	if config.Contexts[0] != expectedContext ||
+		config.CurrentContext != expectedContext.Name {
+		t.Errorf("unexpected context: %+v, %s", config.Contexts[0],
+			config.CurrentContext)
+	}
+	certPEM, err := base64.StdEncoding.DecodeString(
+		user.User.ClientCertificateData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		t.Fatal("no client certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "username" {
+		t.Errorf("unexpected common name: %s", cert.Subject.CommonName)
+	}
+	if lifetime := cert.NotAfter.Sub(cert.NotBefore); lifetime != time.Hour {
+		t.Errorf("unexpected lifetime: %s", lifetime)
+	}
+
+	// The first cluster is the default and has no CA data.
+	config = kubeconfig{}
+	if err := yaml.Unmarshal(request("", http.StatusOK), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Clusters[0].Name != "prod" ||
+		config.Clusters[0].Cluster.CertificateAuthorityData != "" ||
+		config.Users[0].User.ClientKey != defaultKubernetesClientKey {
+		t.Errorf("unexpected default kubeconfig: %+v", config)
+	}
+	request("&cluster=unknown", http.StatusBadRequest)
+	request("&duration=2h", http.StatusBadRequest)
+	request("&duration=10m", http.StatusOK)
+}
+
+func TestKubernetesConfigCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	notPEMFilename := filepath.Join(dir, "not.pem")
+	if err := ioutil.WriteFile(notPEMFilename, []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	badConfigs := []KubernetesConfig{
+		{Clusters: []KubernetesClusterConfig{{Name: "a b",
+			Server: "https://example.com"}}},
+		{Clusters: []KubernetesClusterConfig{{Name: "prod",
+			Server: "http://example.com"}}},
+		{Clusters: []KubernetesClusterConfig{
+			{Name: "prod", Server: "https://a.example.com"},
+			{Name: "prod", Server: "https://b.example.com"},
+		}},
+		{Clusters: []KubernetesClusterConfig{{Name: "prod",
+			Server:                       "https://example.com",
+			CertificateAuthorityFilename: notPEMFilename}}},
+		{MaxCertDurationSecs: 2 * 86400},
+		{Clusters: []KubernetesClusterConfig{{Name: "prod",
+			Server: "https://example.com", AllowedGroupsRE: []string{"("}}}},
+	}
+	for _, config := range badConfigs {
+		if _, err := newKubernetesClusters(config); err == nil {
+			t.Errorf("no error for %+v", config)
+		}
+	}
+}
+
+func TestKubernetesCertGroups(t *testing.T) {
+	clusters, err := newKubernetesClusters(KubernetesConfig{
+		Clusters: []KubernetesClusterConfig{
+			{Name: "prod", Server: "https://prod.example.com:6443"},
+			{Name: "staging", Server: "https://staging.example.com:6443",
+				AllowedGroupsRE: []string{"k8s-.*", "ops"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userGroups := []string{"system:masters", "k8s-dev", "ops", "devops",
+		"system:nodes"}
+	if groups := clusters[0].certGroups(userGroups); !reflect.DeepEqual(groups,
+		[]string{"k8s-dev", "ops", "devops"}) {
+		t.Errorf("prod: %v", groups)
+	}
+	if groups := clusters[1].certGroups(userGroups); !reflect.DeepEqual(groups,
+		[]string{"k8s-dev", "ops"}) {
+		t.Errorf("staging: %v", groups)
+	}
+}
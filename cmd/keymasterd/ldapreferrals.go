@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/Symantec/keymaster/lib/authutil"
+)
+
+// Backend labels of ldapReferralCounter.
+const (
+	ldapReferralBackendPassword = "password"
+	ldapReferralBackendUserInfo = "userinfo"
+)
+
+// referralPolicy returns the referral handling configured by config, with
+// outcomes counted under backend.
+func (config LDAPReferralConfig) referralPolicy(
+	backend string) authutil.LDAPReferralPolicy {
+	return authutil.LDAPReferralPolicy{
+		Mode:           config.Mode,
+		MaxHops:        config.MaxHops,
+		AllowedDomains: config.AllowedDomains,
+		Observe: func(outcome string) {
+			metricsMutex.Lock()
+			defer metricsMutex.Unlock()
+			ldapReferralCounter.WithLabelValues(backend, outcome).Inc()
+		},
+	}
+}
@@ -20,9 +20,9 @@ func NewLogFilterHandler(handler http.Handler, disableFilter bool) http.Handler
 func getValidAdminRemoteUsername(w http.ResponseWriter,
 	r *http.Request) (string, error) {
 	if r.TLS != nil {
-		logger.Debugf(4, "request is TLS %+v", r.TLS)
+		requestLogger(r).Debugf(4, "request is TLS %+v", r.TLS)
 		if len(r.TLS.VerifiedChains) > 0 {
-			logger.Debugf(4, "%+v", r.TLS.VerifiedChains[0][0].Subject)
+			requestLogger(r).Debugf(4, "%+v", r.TLS.VerifiedChains[0][0].Subject)
 			clientName := r.TLS.VerifiedChains[0][0].Subject.CommonName
 			if clientName != "" {
 				clientName = r.TLS.VerifiedChains[0][0].Subject.String()
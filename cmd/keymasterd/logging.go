@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Symantec/keymaster/keymasterd/levellog"
+)
+
+const (
+	logFieldClientIP = "client_ip"
+	logFieldUser     = "user"
+)
+
+type LoggingConfig struct {
+	// Level is debug, info (the default), warn or error.
+	Level string `yaml:"level"`
+	// Modules overrides the level of modules: the source files of
+	// keymasterd, such as certgen or 2fa_u2f, and the packages it uses,
+	// such as deliveryqueue.
+	Modules map[string]string `yaml:"modules"`
+	// DebugVerbosity is the highest verbosity of the debug messages logged
+	// by the modules at the debug level. Default: 0.
+	DebugVerbosity uint8 `yaml:"debug_verbosity"`
+}
+
+func (config LoggingConfig) check() error {
+	_, _, err := config.levels()
+	return err
+}
+
+func (config LoggingConfig) levels() (levellog.Level,
+	map[string]levellog.Level, error) {
+	defaultLevel := levellog.Info
+	if config.Level != "" {
+		var err error
+		defaultLevel, err = levellog.ParseLevel(config.Level)
+		if err != nil {
+			return 0, nil, fmt.Errorf("logging: %s", err)
+		}
+	}
+	modules := make(map[string]levellog.Level, len(config.Modules))
+	for module, name := range config.Modules {
+		level, err := levellog.ParseLevel(name)
+		if err != nil {
+			return 0, nil, fmt.Errorf("logging: %s: %s", module, err)
+		}
+		modules[module] = level
+	}
+	return defaultLevel, modules, nil
+}
+
+// setLogLevels applies the levels of config to the application logger.
+func (config LoggingConfig) setLogLevels() error {
+	defaultLevel, modules, err := config.levels()
+	if err != nil {
+		return err
+	}
+	logger.SetLevels(defaultLevel, modules)
+	logger.SetDebugVerbosity(config.DebugVerbosity)
+	return nil
+}
+
+// requestLogger returns the logger adding the fields of r to its lines.
+func requestLogger(r *http.Request) *levellog.Logger {
+	return logger.WithFields(levellog.FromContext(r.Context()))
+}
+
+// setRequestLogUser adds the authenticated user to the fields of r.
+func setRequestLogUser(r *http.Request, username string) {
+	if fields := levellog.FromContext(r.Context()); fields != nil {
+		fields.Set(logFieldUser, username)
+	}
+}
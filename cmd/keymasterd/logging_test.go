@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Symantec/Dominator/lib/log/debuglogger"
+	"github.com/Symantec/keymaster/keymasterd/levellog"
+)
+
+func TestLoggingConfigCheck(t *testing.T) {
+	for _, config := range []LoggingConfig{
+		{Level: "trace"},
+		{Modules: map[string]string{"certgen": "verbose"}},
+	} {
+		if err := config.check(); err == nil {
+			t.Errorf("%+v accepted", config)
+		}
+	}
+	config := LoggingConfig{Level: "warn",
+		Modules: map[string]string{"certgen": "debug"}}
+	defaultLevel, modules, err := config.levels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaultLevel != levellog.Warn || modules["certgen"] != levellog.Debug {
+		t.Errorf("levels: %s %v", defaultLevel, modules)
+	}
+}
+
+func TestRequestLogFields(t *testing.T) {
+	savedLogger := logger
+	defer func() { logger = savedLogger }()
+	var buffer bytes.Buffer
+	logger = levellog.New(debuglogger.New(stdlog.New(&buffer, "", 0)))
+	if err := (LoggingConfig{Level: "error",
+		Modules:        map[string]string{"logging_test": "debug"},
+		DebugVerbosity: 1}).setLogLevels(); err != nil {
+		t.Fatal(err)
+	}
+	handler := requestLogFieldsHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			setRequestLogUser(r, "username")
+			requestLogger(r).Debugf(1, "handled %s", r.URL.Path)
+		}))
+	req := httptest.NewRequest("GET", "/profile/", nil)
+	req.RemoteAddr = "192.0.2.1:4321"
+	req.Header.Set(requestIDHeader, "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	expected := "level=debug module=logging_test request_id=req-1 " +
+		"client_ip=192.0.2.1 user=username msg=\"handled /profile/\"\n"
+	if buffer.String() != expected {
+		t.Errorf("logged %q, expected %q", buffer.String(), expected)
+	}
+	buffer.Reset()
+	setRequestLogUser(req, "nobody") // No fields outside the handler.
+	logger.Module("certgen").Infof("hidden")
+	if buffer.Len() > 0 {
+		t.Errorf("info logged at error level: %q", buffer.String())
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// loginThrottleAddress returns the address failures from r are counted
+// against: the peer address, or the client address signed by the satellite
+// proxy which forwarded r. Unsigned X-Forwarded-For headers are ignored.
+func loginThrottleAddress(r *http.Request) string {
+	if identity := satelliteProxyIdentity(r); identity != nil &&
+		identity.ClientAddress != "" {
+		return identity.ClientAddress
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func metricLogLoginThrottle(action string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	loginThrottleCounter.WithLabelValues(action).Inc()
+}
+
+// checkThrottledUserPassword is checkUserPassword behind the login throttle.
+// Repeated failures for a username or client address are delayed and a
+// password which recently failed for the username is rejected without
+// consulting the password backend.
+func (state *RuntimeState) checkThrottledUserPassword(username string,
+	password string, config AppConfigFile, r *http.Request) (bool, error) {
+	throttle := state.loginThrottle
+	if throttle == nil {
+		valid, err := checkUserPassword(username, password, config,
+			state.passwordChecker, r)
+		if err == nil && !valid {
+			state.recordAuthFailure(r, username, "password")
+		}
+		return valid, err
+	}
+	address := loginThrottleAddress(r)
+	if delay := throttle.Delay(username, address); delay > 0 {
+		metricLogLoginThrottle("delayed")
+		requestLogger(r).Debugf(1, "delaying login for %s from %s by %s", username,
+			address, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return false, r.Context().Err()
+		}
+	}
+	if throttle.IsKnownFailure(username, []byte(password)) {
+		metricLogLoginThrottle("rejected_cached")
+		metricLogAuthOperation(getClientType(r), "password", false)
+		throttle.RecordFailure(username, address, []byte(password))
+		state.recordAuthFailure(r, username, "password")
+		return false, nil
+	}
+	valid, err := checkUserPassword(username, password, config,
+		state.passwordChecker, r)
+	if err != nil {
+		return false, err
+	}
+	if valid {
+		throttle.RecordSuccess(username)
+	} else {
+		throttle.RecordFailure(username, address, []byte(password))
+		state.recordAuthFailure(r, username, "password")
+	}
+	return valid, nil
+}
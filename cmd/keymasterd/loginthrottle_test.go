@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/loginthrottle"
+	"github.com/Symantec/keymaster/lib/simplestorage"
+)
+
+type countingPasswordChecker struct {
+	password string
+	calls    int
+}
+
+func (c *countingPasswordChecker) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	c.calls++
+	return string(password) == c.password, nil
+}
+
+func (c *countingPasswordChecker) UpdateStorage(
+	storage simplestorage.SimpleStore) error {
+	return nil
+}
+
+func TestCheckThrottledUserPassword(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	checker := &countingPasswordChecker{password: "secret"}
+	state.passwordChecker = checker
+	state.loginThrottle = loginthrottle.New(loginthrottle.Params{
+		FreeFailures: 1,
+		BaseDelay:    time.Millisecond,
+		MaxDelay:     time.Millisecond,
+	})
+	req, err := http.NewRequest("POST", loginFormPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+	for i := 0; i < 3; i++ {
+		valid, err := state.checkThrottledUserPassword("username", "guess",
+			state.Config, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid {
+			t.Fatal("bad password accepted")
+		}
+	}
+	if checker.calls != 1 {
+		t.Fatalf("repeated failure reached the backend %d times", checker.calls)
+	}
+	valid, err := state.checkThrottledUserPassword("username", "secret",
+		state.Config, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid {
+		t.Fatal("good password rejected")
+	}
+	// A cancelled request does not wait out the delay.
+	state.loginThrottle = loginthrottle.New(loginthrottle.Params{
+		FreeFailures: 1,
+		BaseDelay:    time.Hour,
+		MaxDelay:     time.Hour,
+	})
+	state.loginThrottle.RecordFailure("username", "192.0.2.1", nil)
+	state.loginThrottle.RecordFailure("username", "192.0.2.1", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = state.checkThrottledUserPassword("username", "secret",
+		state.Config, req.WithContext(ctx))
+	if err == nil {
+		t.Fatal("cancelled request should fail")
+	}
+}
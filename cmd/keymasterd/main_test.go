@@ -21,6 +21,7 @@ import (
 
 	"github.com/Symantec/Dominator/lib/log/debuglogger"
 	"github.com/Symantec/keymaster/keymasterd/eventnotifier"
+	"github.com/Symantec/keymaster/keymasterd/levellog"
 	"github.com/Symantec/keymaster/lib/instrumentedwriter"
 	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
 )
@@ -127,7 +128,7 @@ var loginFailValues = []loginTestVector{
 
 func init() {
 	slogger := stdlog.New(os.Stderr, "", stdlog.LstdFlags)
-	logger = debuglogger.New(slogger)
+	logger = levellog.New(debuglogger.New(slogger))
 	eventNotifier = eventnotifier.New(logger)
 }
 
@@ -216,7 +217,7 @@ func setupValidRuntimeStateSigner() (*RuntimeState, *os.File, error) {
 	state.signerPublicKeyToKeymasterKeys()
 
 	//for x509
-	state.caCertDer, err = generateCADer(&state, signer)
+	state.caCertDer, _, err = generateCAChainDer(&state, signer)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -256,7 +257,7 @@ func TestSuccessFullSigningSSH(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -294,7 +295,7 @@ func TestSuccessFullSigningX509(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -332,7 +333,7 @@ func TestSuccessFullSigningX509BadLDAPNoGroups(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -370,7 +371,7 @@ func TestFailFullSigningX509GroupsBadLDAPNoGroups(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -408,7 +409,7 @@ func TestFailCertgenDurationTooLong(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -458,7 +459,7 @@ func TestFailSingingExpiredCookie(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -494,7 +495,7 @@ func TestFailSinginUnexpectedCookie(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	_, err = state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -543,7 +544,7 @@ func TestInjectingSecret(t *testing.T) {
 		t.Fatal(err)
 	}
 	/*
-		cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+		cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -594,7 +595,7 @@ func TestInjectingSecret(t *testing.T) {
 		t.Errorf("The signer should now be loaded")
 	}
 
-	cookieVal, err = state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	cookieVal, err = state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -841,7 +842,7 @@ func TestProfileHandlerTemplate(t *testing.T) {
 		t.Fatal(err)
 		//return nil, err
 	}
-	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeAny)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeAny)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -877,7 +878,7 @@ func TestU2fTokenManagerHandlerUpdateSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeAny)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeAny)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -934,7 +935,7 @@ func TestU2fTokenManagerHandlerDeleteNotAdmin(t *testing.T) {
 	state.Signer = signer
 
 	// login as user username
-	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeAny)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeAny)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1001,7 +1002,7 @@ func TestU2fTokenManagerHandlerDeleteSuccess(t *testing.T) {
 	state.Signer = signer
 	state.signerPublicKeyToKeymasterKeys()
 
-	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeAny)
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeAny)
 	if err != nil {
 		t.Fatal(err)
 	}
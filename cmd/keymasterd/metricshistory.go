@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/lifecycle"
+	"github.com/Symantec/keymaster/keymasterd/metricshistory"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const metricsHistoryPath = "/metrics/history"
+
+const (
+	defaultMetricsHistoryIntervalSecs   = 60
+	defaultMetricsHistoryRetentionHours = 24
+	metricsHistoryComponentName         = "metrics_history"
+)
+
+var defaultMetricsHistoryMetrics = []string{
+	"keymaster_certificate_issuance_counter",
+	"keymaster_auth_operation_counter",
+	"keymaster_login_throttle_counter",
+	"go_goroutines",
+	"process_resident_memory_bytes",
+}
+
+// Variable for testing.
+var metricsHistoryGatherer prometheus.Gatherer = prometheus.DefaultGatherer
+
+func (config *MetricsHistoryConfig) interval() time.Duration {
+	if config.IntervalSecs == 0 {
+		return defaultMetricsHistoryIntervalSecs * time.Second
+	}
+	return time.Duration(config.IntervalSecs) * time.Second
+}
+
+// capacity returns the number of points covering retention_hours.
+func (config *MetricsHistoryConfig) capacity() int {
+	retention := time.Duration(config.RetentionHours) * time.Hour
+	if retention == 0 {
+		retention = defaultMetricsHistoryRetentionHours * time.Hour
+	}
+	return int(retention / config.interval())
+}
+
+func (config *MetricsHistoryConfig) metrics() []string {
+	if len(config.Metrics) == 0 {
+		return defaultMetricsHistoryMetrics
+	}
+	return config.Metrics
+}
+
+// sampleMetrics returns the value of each named metric, summed over its
+// labels. Histograms and summaries are sampled as their count, and metrics
+// with no series yet are left out.
+func sampleMetrics(gatherer prometheus.Gatherer,
+	names []string) ([]metricshistory.Sample, error) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var samples []metricshistory.Sample
+	for _, family := range families {
+		if !wanted[family.GetName()] || len(family.GetMetric()) == 0 {
+			continue
+		}
+		sample := metricshistory.Sample{Name: family.GetName()}
+		for _, metric := range family.GetMetric() {
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				sample.Counter = true
+				sample.Value += metric.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				sample.Value += metric.GetGauge().GetValue()
+			case dto.MetricType_HISTOGRAM:
+				sample.Counter = true
+				sample.Value += float64(metric.GetHistogram().GetSampleCount())
+			case dto.MetricType_SUMMARY:
+				sample.Counter = true
+				sample.Value += float64(metric.GetSummary().GetSampleCount())
+			default:
+				sample.Value += metric.GetUntyped().GetValue()
+			}
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+func (state *RuntimeState) recordMetricsHistory(t time.Time) {
+	samples, err := sampleMetrics(metricsHistoryGatherer,
+		state.Config.MetricsHistory.metrics())
+	if err != nil {
+		logger.Errorf("Cannot sample metrics: %s", err)
+	}
+	state.metricsHistory.Record(t, samples)
+}
+
+// metricsHistoryComponent samples the metrics every interval_secs, or is nil
+// if the history is disabled.
+func (state *RuntimeState) metricsHistoryComponent() lifecycle.Component {
+	if state.metricsHistory == nil {
+		return nil
+	}
+	stop := make(chan struct{})
+	return lifecycle.Funcs{
+		StartFunc: func() error {
+			state.recordMetricsHistory(time.Now())
+			go func() {
+				ticker := time.NewTicker(state.Config.MetricsHistory.interval())
+				defer ticker.Stop()
+				for {
+					select {
+					case t := <-ticker.C:
+						state.recordMetricsHistory(t)
+					case <-stop:
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		StopFunc: func() error {
+			close(stop)
+			return nil
+		},
+	}
+}
+
+// metricsHistoryHandler is served on the admin port and returns the sampled
+// history over the window query parameter (default the whole retention) as
+// JSON.
+func (state *RuntimeState) metricsHistoryHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if state.metricsHistory == nil {
+		state.writeFailureResponse(w, r, http.StatusNotFound,
+			"metrics_history is not enabled")
+		return
+	}
+	var since time.Time
+	if value := r.URL.Query().Get("window"); value != "" {
+		window, err := parseUsageWindow(value)
+		if err != nil || window <= 0 {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Bad window: "+value)
+			return
+		}
+		since = time.Now().Add(-window)
+	}
+	response := struct {
+		IntervalSecs float64                 `json:"interval_secs"`
+		Series       []metricshistory.Series `json:"series"`
+	}{
+		IntervalSecs: state.Config.MetricsHistory.interval().Seconds(),
+		Series:       state.metricsHistory.Series(since),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
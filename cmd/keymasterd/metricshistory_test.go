@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/metricshistory"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricsHistory(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "test_counter", Help: "test"}, []string{"type"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "test_gauge", Help: "test"})
+	registry.MustRegister(counter, gauge)
+	oldGatherer := metricsHistoryGatherer
+	defer func() { metricsHistoryGatherer = oldGatherer }()
+	metricsHistoryGatherer = registry
+
+	var state RuntimeState
+	state.Config.MetricsHistory = MetricsHistoryConfig{Enabled: true,
+		IntervalSecs: 60, RetentionHours: 1,
+		Metrics: []string{"test_counter", "test_gauge", "test_missing"}}
+	if capacity := state.Config.MetricsHistory.capacity(); capacity != 60 {
+		t.Errorf("capacity %d", capacity)
+	}
+	state.metricsHistory = metricshistory.New(
+		state.Config.MetricsHistory.capacity())
+	now := time.Now()
+	for index := 0; index < 3; index++ {
+		counter.WithLabelValues("ssh").Add(2)
+		counter.WithLabelValues("x509").Inc()
+		gauge.Set(float64(index))
+		state.recordMetricsHistory(now.Add(time.Duration(index-3) * time.Minute))
+	}
+
+	req := httptest.NewRequest("GET", metricsHistoryPath+"?window=150s", nil)
+	rr, err := checkRequestHandlerCode(req, state.metricsHistoryHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response struct {
+		IntervalSecs float64                 `json:"interval_secs"`
+		Series       []metricshistory.Series `json:"series"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.IntervalSecs != 60 || len(response.Series) != 2 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	counterSeries := response.Series[0]
+	if counterSeries.Name != "test_counter" || !counterSeries.Counter ||
+		len(counterSeries.Points) != 2 || counterSeries.Points[1].Value != 9 {
+		t.Errorf("unexpected counter series: %+v", counterSeries)
+	}
+	if gaugeSeries := response.Series[1]; gaugeSeries.Counter ||
+		gaugeSeries.Points[1].Value != 2 {
+		t.Errorf("unexpected gauge series: %+v", gaugeSeries)
+	}
+
+	dashboard := &adminDashboardType{metricsHistory: state.metricsHistory}
+	output := &strings.Builder{}
+	dashboard.writeMetricsHistory(output)
+	if strings.Count(output.String(), "<polyline") != 2 {
+		t.Errorf("sparklines missing:\n%s", output)
+	}
+
+	state.metricsHistory = nil
+	req = httptest.NewRequest("GET", metricsHistoryPath, nil)
+	if _, err := checkRequestHandlerCode(req, state.metricsHistoryHandler,
+		http.StatusNotFound); err != nil {
+		t.Error(err)
+	}
+}
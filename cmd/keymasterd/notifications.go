@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/deliveryqueue"
+	"github.com/Symantec/keymaster/proto/eventmon"
+)
+
+const deadLettersPath = "/notifications/deadLetters"
+
+const webhookTimeout = 10 * time.Second
+
+// At most this many auth.failed events are published per interval, so that
+// password spraying does not flood the queue and the receivers.
+const (
+	maxAuthFailureEventsPerInterval = 60
+	authFailureEventInterval        = time.Minute
+)
+
+const (
+	webhookTimestampHeader = "X-Keymaster-Timestamp"
+	webhookSignatureHeader = "X-Keymaster-Signature"
+	minWebhookSecretLength = 16
+)
+
+// Types of the events sent to the signed webhooks.
+const (
+	webhookEventCertIssued  = "cert.issued"
+	webhookEventCertRevoked = "cert.revoked"
+	webhookEventAuthFailed  = "auth.failed"
+)
+
+var webhookEventTypes = map[string]bool{
+	webhookEventCertIssued:  true,
+	webhookEventCertRevoked: true,
+	webhookEventAuthFailed:  true,
+}
+
+// webhookEvent is the body posted to the signed webhooks. Data is the
+// attestation event for the certificate events and an authFailure for
+// auth.failed.
+type webhookEvent struct {
+	ID       string      `json:"id"`
+	Type     string      `json:"type"`
+	Time     time.Time   `json:"time"`
+	Instance string      `json:"instance"`
+	Data     interface{} `json:"data"`
+}
+
+type authFailure struct {
+	Username   string `json:"username"`
+	Method     string `json:"method"`
+	RemoteAddr string `json:"remote_addr"`
+	// Failures which were not published since the previous event.
+	Suppressed uint `json:"suppressed,omitempty"`
+}
+
+// authFailureEventLimiter counts the published auth.failed events in the
+// current interval and the failures suppressed beyond the limit.
+type authFailureEventLimiter struct {
+	mutex       sync.Mutex
+	windowStart time.Time
+	published   uint
+	suppressed  uint
+}
+
+// webhookSender posts payloads to their URL, signing them when the URL has
+// a secret. Signatures are made at each attempt so that their timestamp is
+// fresh.
+type webhookSender struct {
+	client  *http.Client
+	secrets map[string][]byte
+	now     func() time.Time
+}
+
+func checkWebhookURL(webhookURL string) error {
+	parsedURL, err := url.Parse(webhookURL)
+	if err != nil {
+		return err
+	}
+	if parsedURL.Scheme != "https" && parsedURL.Scheme != "http" {
+		return fmt.Errorf("invalid webhook URL: %s", webhookURL)
+	}
+	return nil
+}
+
+func (config NotificationConfig) check() error {
+	urls := make(map[string]bool)
+	for _, webhookURL := range config.WebhookURLs {
+		if err := checkWebhookURL(webhookURL); err != nil {
+			return err
+		}
+		urls[webhookURL] = true
+	}
+	for _, webhook := range config.Webhooks {
+		if err := checkWebhookURL(webhook.URL); err != nil {
+			return err
+		}
+		if urls[webhook.URL] {
+			return fmt.Errorf("webhook %s listed twice", webhook.URL)
+		}
+		urls[webhook.URL] = true
+		if webhook.SecretFilename == "" {
+			return fmt.Errorf("webhook %s: no secret_filename", webhook.URL)
+		}
+		for _, eventType := range webhook.Events {
+			if !webhookEventTypes[eventType] {
+				return fmt.Errorf("webhook %s: unknown event type: %s",
+					webhook.URL, eventType)
+			}
+		}
+	}
+	return nil
+}
+
+// wants returns true if the webhook receives the events of eventType.
+func (config NotificationWebhookConfig) wants(eventType string) bool {
+	if len(config.Events) < 1 {
+		return true
+	}
+	for _, wanted := range config.Events {
+		if wanted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// signWebhookBody returns the signature of body sent at timestamp, as for
+// the chat approval webhooks: "v1=" and the hex HMAC-SHA256 of the
+// timestamp, a period and the body.
+func signWebhookBody(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *webhookSender) Send(destination string, payload []byte) error {
+	req, err := http.NewRequest("POST", destination, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret, ok := s.secrets[destination]; ok {
+		timestamp := strconv.FormatInt(s.now().Unix(), 10)
+		req.Header.Set(webhookTimestampHeader, timestamp)
+		req.Header.Set(webhookSignatureHeader,
+			signWebhookBody(secret, timestamp, payload))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("receiver returned %s", resp.Status)
+	}
+	return nil
+}
+
+// setupNotifications opens the notification queue and subscribes it to the
+// published events if any webhooks are configured.
+func (state *RuntimeState) setupNotifications() error {
+	config := state.Config.Notifications
+	if len(config.WebhookURLs) < 1 && len(config.Webhooks) < 1 {
+		return nil
+	}
+	if err := config.check(); err != nil {
+		return fmt.Errorf("notifications: %s", err)
+	}
+	sender := &webhookSender{
+		client:  &http.Client{Timeout: webhookTimeout},
+		secrets: make(map[string][]byte),
+		now:     time.Now,
+	}
+	for _, webhook := range config.Webhooks {
+		secret, err := ioutil.ReadFile(webhook.SecretFilename)
+		if err != nil {
+			return fmt.Errorf("notifications: %s: cannot read secret: %s",
+				webhook.URL, err)
+		}
+		secret = bytes.TrimSpace(secret)
+		if len(secret) < minWebhookSecretLength {
+			return fmt.Errorf("notifications: %s: secret is too short",
+				webhook.URL)
+		}
+		sender.secrets[webhook.URL] = secret
+	}
+	if config.QueueDirectory == "" {
+		config.QueueDirectory = filepath.Join(state.Config.Base.DataDirectory,
+			"notification_queue")
+	}
+	queue, err := deliveryqueue.New(config.QueueDirectory, sender,
+		deliveryqueue.Params{MaxAttempts: config.MaxDeliveryAttempts},
+		logger)
+	if err != nil {
+		return err
+	}
+	state.notificationQueue = queue
+	if eventNotifier != nil && len(config.WebhookURLs) > 0 {
+		eventNotifier.AddSink(state.enqueueEventNotification)
+	}
+	return nil
+}
+
+func (state *RuntimeState) enqueueEventNotification(event eventmon.EventV0) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	state.enqueueNotification(payload)
+}
+
+// enqueueNotification queues payload for all the unsigned webhooks, if
+// configured.
+func (state *RuntimeState) enqueueNotification(payload []byte) {
+	if state.notificationQueue == nil {
+		return
+	}
+	for _, webhookURL := range state.Config.Notifications.WebhookURLs {
+		err := state.notificationQueue.Enqueue(webhookURL, payload)
+		if err != nil {
+			logger.Errorf("Cannot queue notification for %s: %s",
+				webhookURL, err)
+		}
+	}
+}
+
+// publishWebhookEvent queues an event of eventType for the signed webhooks
+// which want it.
+func (state *RuntimeState) publishWebhookEvent(eventType string,
+	data interface{}) {
+	if state.notificationQueue == nil {
+		return
+	}
+	var webhookURLs []string
+	for _, webhook := range state.Config.Notifications.Webhooks {
+		if webhook.wants(eventType) {
+			webhookURLs = append(webhookURLs, webhook.URL)
+		}
+	}
+	if len(webhookURLs) < 1 {
+		return
+	}
+	id, err := genRandomString()
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	payload, err := json.Marshal(webhookEvent{
+		ID:       id,
+		Type:     eventType,
+		Time:     time.Now().UTC(),
+		Instance: state.HostIdentity,
+		Data:     data,
+	})
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	for _, webhookURL := range webhookURLs {
+		err := state.notificationQueue.Enqueue(webhookURL, payload)
+		if err != nil {
+			logger.Errorf("Cannot queue notification for %s: %s",
+				webhookURL, err)
+		}
+	}
+}
+
+// publishAuthFailure reports a failed authentication of username with
+// method for r to the signed webhooks.
+func (state *RuntimeState) publishAuthFailure(r *http.Request,
+	username string, method string) {
+	if state.notificationQueue == nil {
+		return
+	}
+	publish, suppressed := state.authFailureEvents.allow(time.Now())
+	if !publish {
+		return
+	}
+	state.publishWebhookEvent(webhookEventAuthFailed, authFailure{
+		Username:   username,
+		Method:     method,
+		RemoteAddr: loginThrottleAddress(r),
+		Suppressed: suppressed,
+	})
+}
+
+// allow returns true if an event may be published at now, with the number
+// of failures suppressed since the previous one.
+func (limiter *authFailureEventLimiter) allow(now time.Time) (bool, uint) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if now.Sub(limiter.windowStart) >= authFailureEventInterval {
+		limiter.windowStart = now
+		limiter.published = 0
+	}
+	if limiter.published >= maxAuthFailureEventsPerInterval {
+		limiter.suppressed++
+		return false, 0
+	}
+	limiter.published++
+	suppressed := limiter.suppressed
+	limiter.suppressed = 0
+	return true, suppressed
+}
+
+// deadLettersHandler is served on the admin port. A GET lists the
+// notifications which could not be delivered. A POST with an id and an
+// action of "retry" or "delete" requeues or discards one of them, and needs
+// a client certificate of the admin CA.
+func (state *RuntimeState) deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if state.notificationQueue == nil {
+		http.Error(w, "Notifications not configured", http.StatusNotFound)
+		return
+	}
+	state.serveDeadLetters(w, r, state.notificationQueue)
+}
+
+func (state *RuntimeState) serveDeadLetters(w http.ResponseWriter,
+	r *http.Request, queue *deliveryqueue.Queue) {
+	setSecurityHeaders(w)
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(queue.ListDeadLetters())
+		return
+	case "POST":
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	clientName, ok := state.requireAdminClientCert(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+	id := r.Form.Get("id")
+	var err error
+	switch r.Form.Get("action") {
+	case "retry":
+		err = queue.RetryDeadLetter(id)
+	case "delete":
+		err = queue.DeleteDeadLetter(id)
+	default:
+		http.Error(w, "Invalid action", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	requestLogger(r).Printf("Dead letter %s: %s by %s", id,
+		r.Form.Get("action"), clientName)
+	fmt.Fprintf(w, "OK\n")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+	"github.com/Symantec/keymaster/proto/eventmon"
+)
+
+func TestWebhookNotificationDelivery(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	received := make(chan eventmon.EventV0, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var event eventmon.EventV0
+			if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			received <- event
+		}))
+	defer receiver.Close()
+	queueDir, err := ioutil.TempDir("", "notification_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(queueDir)
+	state.Config.Notifications.WebhookURLs = []string{receiver.URL}
+	state.Config.Notifications.QueueDirectory = queueDir
+	// Keep the shared event notifier from feeding this queue.
+	savedNotifier := eventNotifier
+	eventNotifier = nil
+	defer func() { eventNotifier = savedNotifier }()
+	if err := state.setupNotifications(); err != nil {
+		t.Fatal(err)
+	}
+	state.enqueueEventNotification(eventmon.EventV0{
+		Type:     eventmon.EventTypeWebLogin,
+		Username: "username",
+	})
+	select {
+	case event := <-received:
+		if event.Username != "username" {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification was not delivered")
+	}
+
+	req, err := http.NewRequest("GET", deadLettersPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.deadLettersHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest("POST", deadLettersPath,
+		strings.NewReader("id=unknown&action=delete"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, err = checkRequestHandlerCode(req, state.deadLettersHandler,
+		http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignedWebhookEvents(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "notification_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secret := []byte("0123456789abcdef")
+	secretFilename := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretFilename, secret, 0600); err != nil {
+		t.Fatal(err)
+	}
+	type delivery struct {
+		path  string
+		event webhookEvent
+	}
+	received := make(chan delivery, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			timestamp := r.Header.Get(webhookTimestampHeader)
+			if r.Header.Get(webhookSignatureHeader) !=
+				signWebhookBody(secret, timestamp, body) {
+				http.Error(w, "bad signature", http.StatusUnauthorized)
+				return
+			}
+			var event webhookEvent
+			if err := json.Unmarshal(body, &event); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			received <- delivery{r.URL.Path, event}
+		}))
+	defer receiver.Close()
+	state.Config.Notifications = NotificationConfig{
+		Webhooks: []NotificationWebhookConfig{
+			{URL: receiver.URL + "/siem", SecretFilename: secretFilename},
+			{URL: receiver.URL + "/chat", SecretFilename: secretFilename,
+				Events: []string{webhookEventAuthFailed}},
+		},
+		QueueDirectory: filepath.Join(dir, "queue"),
+	}
+	if err := state.setupNotifications(); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.recordAuditEvent(attestation.Event{
+		Type:     attestation.EventIssued,
+		Username: "username",
+		Policy:   "ssh",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/api/v0/login", nil)
+	state.publishAuthFailure(req, "username", "password")
+	counts := make(map[string]int)
+	for i := 0; i < 3; i++ {
+		select {
+		case delivery := <-received:
+			counts[delivery.path+" "+delivery.event.Type]++
+			if delivery.event.ID == "" ||
+				delivery.event.Instance != state.HostIdentity {
+				t.Errorf("unexpected event %+v", delivery.event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("events not delivered, got %v", counts)
+		}
+	}
+	for _, expected := range []string{"/siem cert.issued",
+		"/siem auth.failed", "/chat auth.failed"} {
+		if counts[expected] != 1 {
+			t.Errorf("%s: %d deliveries", expected, counts[expected])
+		}
+	}
+}
+
+func TestNotificationConfigCheck(t *testing.T) {
+	for _, config := range []NotificationConfig{
+		{WebhookURLs: []string{"ftp://siem.example.com/"}},
+		{Webhooks: []NotificationWebhookConfig{
+			{URL: "https://siem.example.com/"}}},
+		{Webhooks: []NotificationWebhookConfig{
+			{URL: "https://siem.example.com/", SecretFilename: "secret",
+				Events: []string{"cert.expired"}}}},
+		{WebhookURLs: []string{"https://siem.example.com/"},
+			Webhooks: []NotificationWebhookConfig{
+				{URL: "https://siem.example.com/", SecretFilename: "secret"}}},
+	} {
+		if err := config.check(); err == nil {
+			t.Errorf("%+v accepted", config)
+		} else if !strings.Contains(err.Error(), "siem.example.com") {
+			t.Errorf("error without the URL: %s", err)
+		}
+	}
+}
+
+func TestAuthFailureEventLimiter(t *testing.T) {
+	var limiter authFailureEventLimiter
+	now := time.Now()
+	for i := 0; i < maxAuthFailureEventsPerInterval; i++ {
+		if ok, _ := limiter.allow(now); !ok {
+			t.Fatalf("event %d suppressed", i)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.allow(now.Add(time.Second)); ok {
+			t.Fatal("event above the limit published")
+		}
+	}
+	ok, suppressed := limiter.allow(now.Add(authFailureEventInterval))
+	if !ok || suppressed != 3 {
+		t.Fatalf("next interval: got %v, %d suppressed", ok, suppressed)
+	}
+	if _, suppressed := limiter.allow(now.Add(authFailureEventInterval)); suppressed != 0 {
+		t.Fatalf("suppressed count reported twice: %d", suppressed)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/issuedcerts"
+	"github.com/Symantec/keymaster/lib/certgen"
+	"golang.org/x/crypto/ocsp"
+)
+
+// The OCSP responder answers for the X.509 certificates of the current CA.
+// Certificates recorded in the issuance database are good unless revoked,
+// others are unknown. Responses are signed by the CA key itself.
+const (
+	ocspPath                 = "/ocsp"
+	issuedX509CertsFilename  = "issued_x509_certs"
+	defaultOCSPValiditySecs  = 3600
+	maxOCSPRequestSize       = 4096
+	maxCachedOCSPResponses   = 4096
+	ocspRequestContentType   = "application/ocsp-request"
+	ocspResponseContentType  = "application/ocsp-response"
+	ocspRequestGETPathPrefix = ocspPath + "/"
+)
+
+// OCSPConfig configures the OCSP responder.
+type OCSPConfig struct {
+	// ResponderURL is put in the authority information access of issued
+	// X.509 certificates. Default: /ocsp on the service port.
+	ResponderURL string `yaml:"responder_url"`
+	// Responses are valid for this long. Default: 3600.
+	ValiditySecs uint `yaml:"validity_secs"`
+}
+
+func (config *OCSPConfig) check() error {
+	if config.ResponderURL == "" {
+		return nil
+	}
+	u, err := url.Parse(config.ResponderURL)
+	if err != nil {
+		return fmt.Errorf("ocsp: responder_url: %s", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("ocsp: responder_url is not an HTTP URL: %s",
+			config.ResponderURL)
+	}
+	return nil
+}
+
+func (config *OCSPConfig) validity() time.Duration {
+	secs := config.ValiditySecs
+	if secs < 1 {
+		secs = defaultOCSPValiditySecs
+	}
+	return time.Duration(secs) * time.Second
+}
+
+type cachedOCSPResponse struct {
+	response    []byte
+	thisUpdate  time.Time
+	refreshAt   time.Time
+	revocations int
+	caCertDer   []byte
+}
+
+// ocspResponseCache keeps signed responses by serial, so that a client
+// polling a certificate does not cost a signature each time.
+type ocspResponseCache struct {
+	mutex     sync.Mutex
+	responses map[string]cachedOCSPResponse
+}
+
+// canSignOCSP returns true if x/crypto/ocsp can sign with the key of caCert.
+func canSignOCSP(caCert *x509.Certificate) bool {
+	switch caCert.PublicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return true
+	}
+	return false
+}
+
+func (state *RuntimeState) ocspServers(caCert *x509.Certificate) []string {
+	if !canSignOCSP(caCert) {
+		return nil
+	}
+	if config := state.Config.OCSP; config.ResponderURL != "" {
+		return []string{config.ResponderURL}
+	}
+	return []string{"https://" + state.HostIdentity +
+		state.publicPortSuffix() + ocspPath}
+}
+
+// x509CertOptions returns the revocation extensions of the X.509
+// certificates issued by caCert.
+func (state *RuntimeState) x509CertOptions(
+	caCert *x509.Certificate) certgen.X509CertOptions {
+	return certgen.X509CertOptions{
+		CRLDistributionPoints: state.x509CRLDistributionPoints(caCert),
+		OCSPServers:           state.ocspServers(caCert),
+	}
+}
+
+// recordIssuedX509Cert records derCert, issued in the HTTP request with ID
+// requestID, in the issuance database. The certificate must not be handed
+// out if this fails, as the OCSP responder would not know it.
+func (state *RuntimeState) recordIssuedX509Cert(requestID string,
+	certType string, derCert []byte) error {
+	cert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		return err
+	}
+	err = state.issuedX509Certs.Record(issuedcerts.Entry{
+		Serial:    cert.SerialNumber.String(),
+		Subject:   cert.Subject.CommonName,
+		Type:      certType,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		RequestID: requestID,
+	})
+	if err != nil {
+		logger.Errorf("Cannot record issued %s certificate %s: %s", certType,
+			cert.SerialNumber, err)
+	}
+	return err
+}
+
+// subjectPublicKeyBits returns the bits of the public key of cert, which
+// OCSP requests identify the issuer by.
+func subjectPublicKeyBits(cert *x509.Certificate) ([]byte, error) {
+	var info struct {
+		Algorithm asn1.RawValue
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo,
+		&info); err != nil {
+		return nil, err
+	}
+	return info.PublicKey.RightAlign(), nil
+}
+
+func isOCSPRequestForIssuer(request *ocsp.Request,
+	issuer *x509.Certificate) bool {
+	if !request.HashAlgorithm.Available() {
+		return false
+	}
+	keyBits, err := subjectPublicKeyBits(issuer)
+	if err != nil {
+		return false
+	}
+	nameHash := request.HashAlgorithm.New()
+	nameHash.Write(issuer.RawSubject)
+	keyHash := request.HashAlgorithm.New()
+	keyHash.Write(keyBits)
+	return bytes.Equal(nameHash.Sum(nil), request.IssuerNameHash) &&
+		bytes.Equal(keyHash.Sum(nil), request.IssuerKeyHash)
+}
+
+// ocspResponse returns the signed response to request and when it was
+// signed, or one of the OCSP error responses and the zero time.
+func (state *RuntimeState) ocspResponse(request *ocsp.Request,
+	now time.Time) ([]byte, time.Time) {
+	state.Mutex.Lock()
+	signer := state.Signer
+	caCertDer := state.caCertDer
+	state.Mutex.Unlock()
+	if signer == nil {
+		return ocsp.TryLaterErrorResponse, time.Time{}
+	}
+	caCert, err := x509.ParseCertificate(caCertDer)
+	if err != nil {
+		logger.Errorf("Cannot parse CA certificate: %s", err)
+		return ocsp.InternalErrorErrorResponse, time.Time{}
+	}
+	if !isOCSPRequestForIssuer(request, caCert) {
+		return ocsp.UnauthorizedErrorResponse, time.Time{}
+	}
+	serial := request.SerialNumber.String()
+	revocations := len(state.revokedCerts.List())
+	validity := state.Config.OCSP.validity()
+	cache := &state.ocspResponseCache
+	cache.mutex.Lock()
+	cached, ok := cache.responses[serial]
+	cache.mutex.Unlock()
+	if ok && cached.revocations == revocations &&
+		bytes.Equal(cached.caCertDer, caCertDer) &&
+		now.Before(cached.refreshAt) {
+		return cached.response, cached.thisUpdate
+	}
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: request.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(validity),
+	}
+	refreshAt := now.Add(validity / 2)
+	// Certificates are only good until they expire, after which they are
+	// unknown like serials which were never issued.
+	if entry, ok := state.revokedCerts.Get(serial); ok {
+		template.Status = ocsp.Revoked
+		template.RevokedAt = entry.Time
+		template.RevocationReason = ocsp.Unspecified
+	} else if entry, ok := state.issuedX509Certs.Get(serial); ok &&
+		now.Before(entry.NotAfter) {
+		template.Status = ocsp.Good
+		if entry.NotAfter.Before(refreshAt) {
+			refreshAt = entry.NotAfter
+		}
+	}
+	response, err := ocsp.CreateResponse(caCert, caCert, template, signer)
+	if err != nil {
+		logger.Errorf("Cannot sign OCSP response: %s", err)
+		return ocsp.InternalErrorErrorResponse, time.Time{}
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.responses == nil || len(cache.responses) >= maxCachedOCSPResponses {
+		cache.responses = make(map[string]cachedOCSPResponse)
+	}
+	cache.responses[serial] = cachedOCSPResponse{
+		response:    response,
+		thisUpdate:  now,
+		refreshAt:   refreshAt,
+		revocations: revocations,
+		caCertDer:   caCertDer,
+	}
+	return response, now
+}
+
+// ocspHandler serves OCSP requests as in RFC 6960 appendix A: POSTed to
+// ocspPath, or base64 encoded in the path of a GET.
+func (state *RuntimeState) ocspHandler(w http.ResponseWriter,
+	r *http.Request) {
+	var requestData []byte
+	switch r.Method {
+	case "GET":
+		encoded := strings.TrimPrefix(r.URL.Path, ocspRequestGETPathPrefix)
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || encoded == r.URL.Path {
+			state.writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse,
+				time.Time{})
+			return
+		}
+		requestData = data
+	case "POST":
+		if r.Header.Get("Content-Type") != ocspRequestContentType {
+			state.writeFailureResponse(w, r, http.StatusUnsupportedMediaType,
+				"")
+			return
+		}
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body,
+			maxOCSPRequestSize))
+		if err != nil {
+			state.writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse,
+				time.Time{})
+			return
+		}
+		requestData = data
+	default:
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	request, err := ocsp.ParseRequest(requestData)
+	if err != nil {
+		requestLogger(r).Debugf(1, "bad OCSP request: %s", err)
+		state.writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse,
+			time.Time{})
+		return
+	}
+	response, thisUpdate := state.ocspResponse(request, time.Now())
+	state.writeOCSPResponse(w, response, thisUpdate)
+}
+
+// writeOCSPResponse writes response. A response signed at thisUpdate may be
+// cached by HTTP caches until it is signed again, error responses not at
+// all.
+func (state *RuntimeState) writeOCSPResponse(w http.ResponseWriter,
+	response []byte, thisUpdate time.Time) {
+	w.Header().Set("Content-Type", ocspResponseContentType)
+	maxAge := time.Until(thisUpdate.Add(state.Config.OCSP.validity() / 2))
+	if thisUpdate.IsZero() || maxAge < time.Second {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d",
+			int(maxAge.Seconds())))
+	}
+	w.Write(response)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/issuedcerts"
+	"github.com/Symantec/keymaster/keymasterd/revocationlist"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPResponder(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "ocsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.revokedCerts, err = revocationlist.Open(filepath.Join(dir,
+		revokedCertsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.issuedX509Certs, err = issuedcerts.Open(filepath.Join(dir,
+		issuedX509CertsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.HostIdentity = "keymaster.example.com"
+	state.Config.Base.PublicPort = 443
+	caCert, err := x509.ParseCertificate(state.caCertDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := createKeyBodyRequest("POST", "/certgen/username?type=x509",
+		testUserPEMPublicKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	rr, err := checkRequestHandlerCode(req, state.certGenHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(rr.Body.Bytes())
+	if block == nil {
+		t.Fatal("no certificate returned")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.OCSPServer) != 1 ||
+		cert.OCSPServer[0] != "https://keymaster.example.com/ocsp" {
+		t.Fatalf("unexpected OCSP servers: %v", cert.OCSPServer)
+	}
+	requestData, err := ocsp.CreateRequest(cert, caCert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(data []byte) []byte {
+		req, err := http.NewRequest("POST", ocspPath, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", ocspRequestContentType)
+		rr, err := checkRequestHandlerCode(req, state.ocspHandler,
+			http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr.Body.Bytes()
+	}
+	parse := func(data []byte) *ocsp.Response {
+		response, err := ocsp.ParseResponse(data, caCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	if response := parse(post(requestData)); response.Status != ocsp.Good ||
+		response.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Errorf("unexpected response: %+v", response)
+	}
+	req, err = http.NewRequest("GET", ocspPath+"/"+
+		base64.StdEncoding.EncodeToString(requestData), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(req, state.ocspHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response := parse(rr.Body.Bytes()); response.Status != ocsp.Good {
+		t.Errorf("unexpected GET response: %+v", response)
+	}
+
+	// Revocation takes effect despite the cached response.
+	_, _, err = state.revokedCerts.Revoke(cert.SerialNumber.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response := parse(post(requestData)); response.Status != ocsp.Revoked {
+		t.Errorf("unexpected response after revocation: %+v", response)
+	}
+
+	// Serials which were not issued are unknown.
+	request, err := ocsp.ParseRequest(requestData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.SerialNumber = big.NewInt(12345)
+	unknownData, err := request.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response := parse(post(unknownData)); response.Status != ocsp.Unknown {
+		t.Errorf("unexpected response for unknown serial: %+v", response)
+	}
+
+	// Expired certificates are unknown too.
+	err = state.issuedX509Certs.Record(issuedcerts.Entry{Serial: "777",
+		Type: "x509", NotBefore: time.Now().Add(-2 * time.Hour),
+		NotAfter: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.SerialNumber = big.NewInt(777)
+	expiredData, err := request.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response := parse(post(expiredData)); response.Status != ocsp.Unknown {
+		t.Errorf("unexpected response for expired certificate: %+v", response)
+	}
+
+	// Other issuers are refused.
+	request.IssuerKeyHash = []byte("not the CA key hash...")
+	otherIssuerData, err := request.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response := post(otherIssuerData); !bytes.Equal(response,
+		ocsp.UnauthorizedErrorResponse) {
+		t.Errorf("unexpected response for another issuer: %x", response)
+	}
+	if response := post([]byte("garbage")); !bytes.Equal(response,
+		ocsp.MalformedRequestErrorResponse) {
+		t.Errorf("unexpected response for garbage: %x", response)
+	}
+	req, err = http.NewRequest("POST", ocspPath, bytes.NewReader(requestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.ocspHandler,
+		http.StatusUnsupportedMediaType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Signer = nil
+	if response := post(requestData); !bytes.Equal(response,
+		ocsp.TryLaterErrorResponse) {
+		t.Errorf("unexpected response while sealed: %x", response)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+// apiV1OpenAPIDocument describes version 1 of the REST API. The servers are
+// added when it is served. Keep it in sync with lib/webapi/v1/proto: the
+// tests check the schemas against the message types.
+const apiV1OpenAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Keymaster API",
+    "version": "1",
+    "description": "Issues short lived SSH and X.509 certificates. Requests are authenticated like the other endpoints of the service port: with HTTP basic authentication with a password (and the second factors the policy requires, with the auth_cookie of a login), or with the IP restricted client certificate of an automation user over TLS. Errors are returned as an Error object with the HTTP status."
+  },
+  "paths": {
+    "/api/v1/certificates": {
+      "post": {
+        "operationId": "issueCertificate",
+        "summary": "Issue a certificate",
+        "description": "Issues a certificate for the public keys with the issuance policy of /certgen/. The certificate may need approval first, in which case the URL of the approval request is returned with status 202.",
+        "security": [{"basicAuth": []}, {"cookieAuth": []}, {}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {"$ref": "#/components/schemas/IssueCertificateRequest"}
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "The certificate was issued.",
+            "content": {
+              "application/json": {
+                "schema": {"$ref": "#/components/schemas/IssueCertificateResponse"}
+              }
+            }
+          },
+          "202": {
+            "description": "The certificate needs approval.",
+            "headers": {
+              "Location": {
+                "description": "The URL of the approval request.",
+                "schema": {"type": "string"}
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {"$ref": "#/components/schemas/IssueCertificateResponse"}
+              }
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "415": {"$ref": "#/components/responses/Error"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/v1/revokedCertificates": {
+      "get": {
+        "operationId": "listRevokedCertificates",
+        "summary": "List the revoked certificates",
+        "description": "For admins with a client certificate of the admin CA only.",
+        "security": [{}],
+        "responses": {
+          "200": {
+            "description": "The revoked certificates.",
+            "content": {
+              "application/json": {
+                "schema": {"$ref": "#/components/schemas/ListRevokedCertificatesResponse"}
+              }
+            }
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "operationId": "revokeCertificate",
+        "summary": "Revoke a certificate",
+        "description": "Adds the serial of an SSH or X.509 certificate to the revocation list. For admins with a client certificate of the admin CA only.",
+        "security": [{}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {"$ref": "#/components/schemas/RevokeCertificateRequest"}
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "The certificate is revoked.",
+            "content": {
+              "application/json": {
+                "schema": {"$ref": "#/components/schemas/RevokeCertificateResponse"}
+              }
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "415": {"$ref": "#/components/responses/Error"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/v1/lockouts/{username}": {
+      "delete": {
+        "operationId": "clearLockout",
+        "summary": "Clear the lockout of a user",
+        "description": "Forgets the failed password and TOTP checks of the user. For admins with a client certificate of the admin CA only.",
+        "security": [{}],
+        "parameters": [
+          {
+            "name": "username",
+            "in": "path",
+            "required": true,
+            "schema": {"type": "string"}
+          }
+        ],
+        "responses": {
+          "204": {"description": "The lockout is cleared."},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/v1/openapi.json": {
+      "get": {
+        "operationId": "getOpenAPIDocument",
+        "summary": "Get this document",
+        "security": [],
+        "responses": {
+          "200": {
+            "description": "The OpenAPI document of the API.",
+            "content": {
+              "application/json": {
+                "schema": {"type": "object"}
+              }
+            }
+          }
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "basicAuth": {
+        "type": "http",
+        "scheme": "basic"
+      },
+      "cookieAuth": {
+        "type": "apiKey",
+        "in": "cookie",
+        "name": "auth_cookie"
+      }
+    },
+    "responses": {
+      "Error": {
+        "description": "The request failed.",
+        "content": {
+          "application/json": {
+            "schema": {"$ref": "#/components/schemas/Error"}
+          }
+        }
+      }
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "required": ["status", "error"],
+        "properties": {
+          "status": {"type": "integer", "description": "The HTTP status."},
+          "error": {"type": "string"}
+        }
+      },
+      "IssueCertificateRequest": {
+        "type": "object",
+        "required": ["username", "public_keys"],
+        "properties": {
+          "username": {
+            "type": "string",
+            "description": "The user the certificate is for. Issuing for another user requires delegation."
+          },
+          "type": {
+            "type": "string",
+            "description": "The certificate type, e.g. ssh (the default), x509, x509-kubernetes, kubeconfig, spiffe-svid or a host certificate profile."
+          },
+          "public_keys": {
+            "type": "array",
+            "items": {"type": "string"},
+            "description": "SSH public keys in authorized_keys format, or PEM public keys for X.509 certificates."
+          },
+          "duration": {
+            "type": "string",
+            "description": "The lifetime as a Go duration, e.g. 8h."
+          },
+          "add_groups": {
+            "type": "boolean",
+            "description": "Add the groups of the user to the certificate."
+          },
+          "hostnames": {
+            "type": "array",
+            "items": {"type": "string"},
+            "description": "The host names of a host certificate."
+          },
+          "ip_addresses": {
+            "type": "array",
+            "items": {"type": "string"},
+            "description": "The IP addresses of a host certificate."
+          },
+          "parameters": {
+            "type": "object",
+            "additionalProperties": {"type": "string"},
+            "description": "Other query parameters of /certgen/, e.g. restrictions or cluster."
+          }
+        }
+      },
+      "IssueCertificateResponse": {
+        "type": "object",
+        "properties": {
+          "certificate": {
+            "type": "string",
+            "description": "The certificate, as returned by /certgen/: in authorized_keys format for SSH, PEM with its chain for X.509, a kubeconfig for the kubeconfig type."
+          },
+          "content_type": {"type": "string"},
+          "filename": {"type": "string"},
+          "approval_url": {
+            "type": "string",
+            "description": "The URL of the approval request, if the certificate needs approval."
+          }
+        }
+      },
+      "RevokeCertificateRequest": {
+        "type": "object",
+        "required": ["serial"],
+        "properties": {
+          "serial": {
+            "type": "string",
+            "description": "The serial number in decimal."
+          },
+          "reason": {"type": "string"}
+        }
+      },
+      "RevokeCertificateResponse": {
+        "type": "object",
+        "required": ["revoked"],
+        "properties": {
+          "revoked": {
+            "type": "boolean",
+            "description": "False if the certificate was already revoked."
+          }
+        }
+      },
+      "RevokedCertificate": {
+        "type": "object",
+        "required": ["serial", "time"],
+        "properties": {
+          "serial": {"type": "string"},
+          "time": {"type": "string", "format": "date-time"},
+          "reason": {"type": "string"}
+        }
+      },
+      "ListRevokedCertificatesResponse": {
+        "type": "object",
+        "required": ["certificates"],
+        "properties": {
+          "certificates": {
+            "type": "array",
+            "items": {"$ref": "#/components/schemas/RevokedCertificate"}
+          }
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/passwordpolicy"
+	"github.com/Symantec/keymaster/lib/authutil"
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+)
+
+const passwordPolicyPath = "/api/v0/passwordPolicy"
+
+const (
+	ldapPasswordPolicyRefreshInterval = 15 * time.Minute
+	ldapPasswordPolicyTimeoutSecs     = 3
+	maxPasswordPolicyCheckBodySize    = 1 << 12
+)
+
+// ldapPasswordPolicyCache holds the policy last read from the directory.
+type ldapPasswordPolicyCache struct {
+	mutex   sync.Mutex
+	policy  *passwordpolicy.Policy
+	fetched time.Time
+}
+
+type passwordPolicyCheckResponse struct {
+	Acceptable bool                       `json:"acceptable"`
+	Violations []passwordpolicy.Violation `json:"violations"`
+}
+
+func (state *RuntimeState) fetchLDAPPasswordPolicy(
+	policyDN string) (passwordpolicy.Policy, error) {
+	ldapConfig := state.Config.Ldap
+	var err error
+	for _, ldapURL := range strings.Split(ldapConfig.LDAPTargetURLs, ",") {
+		var u *url.URL
+		u, err = authutil.ParseLDAPURL(ldapURL)
+		if err != nil {
+			continue
+		}
+		var policy authutil.LDAPPasswordPolicy
+		policy, err = authutil.GetLDAPPasswordPolicy(*u,
+			ldapConfig.BindUsername, ldapConfig.BindPassword,
+			ldapPasswordPolicyTimeoutSecs, nil, policyDN)
+		if err == nil {
+			return passwordpolicy.Policy{
+				MinLength:         policy.MinLength,
+				RequireComplexity: policy.RequireComplexity,
+				HistoryLength:     policy.HistoryLength,
+			}, nil
+		}
+	}
+	return passwordpolicy.Policy{}, err
+}
+
+// passwordPolicy returns the configured policy, made stricter by the one
+// published in the directory. The directory is read at most every
+// ldapPasswordPolicyRefreshInterval, without holding up other callers, and
+// the last policy read is used while it is read or cannot be reached.
+func (state *RuntimeState) passwordPolicy() passwordpolicy.Policy {
+	config := state.Config.PasswordPolicy
+	policy := passwordpolicy.Policy{
+		MinLength:         config.MinLength,
+		RequireComplexity: config.RequireComplexity,
+		HistoryLength:     config.HistoryLength,
+	}
+	if config.LDAPPolicyDN == "" {
+		return policy
+	}
+	cache := &state.ldapPasswordPolicy
+	cache.mutex.Lock()
+	ldapPolicy := cache.policy
+	stale := time.Since(cache.fetched) > ldapPasswordPolicyRefreshInterval
+	if stale {
+		cache.fetched = time.Now()
+	}
+	cache.mutex.Unlock()
+	if stale {
+		fetchedPolicy, err := state.fetchLDAPPasswordPolicy(config.LDAPPolicyDN)
+		if err != nil {
+			logger.Errorf("Cannot read password policy from LDAP: %s", err)
+		} else {
+			ldapPolicy = &fetchedPolicy
+			cache.mutex.Lock()
+			cache.policy = ldapPolicy
+			cache.mutex.Unlock()
+		}
+	}
+	if ldapPolicy != nil {
+		policy = policy.Merge(*ldapPolicy)
+	}
+	return policy
+}
+
+// passwordPolicyHandler returns the password policy on GET. On POST it
+// checks the password form value for the authenticated user and returns the
+// rules it breaks. It only advises: keymaster does not change passwords, so
+// nothing is enforced here. A password change endpoint would have to run
+// the same check before sending the new password to the directory.
+func (state *RuntimeState) passwordPolicyHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authUser, _, err := state.checkAuth(w, r,
+		state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		requestLogger(r).Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state.passwordPolicy())
+	case "POST":
+		r.Body = http.MaxBytesReader(w, r.Body, maxPasswordPolicyCheckBodySize)
+		if err := r.ParseForm(); err != nil {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Error parsing form")
+			return
+		}
+		violations := state.passwordPolicy().Check(authUser,
+			r.PostForm.Get("password"))
+		if violations == nil {
+			violations = []passwordpolicy.Violation{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(passwordPolicyCheckResponse{
+			Acceptable: len(violations) < 1,
+			Violations: violations,
+		})
+	default:
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+	}
+}
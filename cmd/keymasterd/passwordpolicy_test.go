@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/passwordpolicy"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+func TestPasswordPolicyHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{
+		proto.AuthTypePassword}
+	state.Config.PasswordPolicy = PasswordPolicyConfig{MinLength: 8,
+		HistoryLength: 5}
+	// A policy read earlier from the directory is stricter.
+	state.Config.PasswordPolicy.LDAPPolicyDN = "DC=example,DC=com"
+	state.ldapPasswordPolicy.policy = &passwordpolicy.Policy{MinLength: 12,
+		RequireComplexity: true}
+	state.ldapPasswordPolicy.fetched = time.Now()
+
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username",
+		AuthTypePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", passwordPolicyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	rr, err := checkRequestHandlerCode(req, state.passwordPolicyHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policy passwordpolicy.Policy
+	if err := json.Unmarshal(rr.Body.Bytes(), &policy); err != nil {
+		t.Fatal(err)
+	}
+	if policy != (passwordpolicy.Policy{MinLength: 12,
+		RequireComplexity: true, HistoryLength: 5}) {
+		t.Fatalf("unexpected policy: %+v", policy)
+	}
+
+	for password, acceptable := range map[string]bool{
+		"Correct-Horse7":     true,
+		"short":              false,
+		"username-Password1": false,
+	} {
+		req, err := http.NewRequest("POST", passwordPolicyPath,
+			strings.NewReader(url.Values{"password": {password}}.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		rr, err := checkRequestHandlerCode(req, state.passwordPolicyHandler,
+			http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response passwordPolicyCheckResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Acceptable != acceptable ||
+			(len(response.Violations) == 0) != acceptable {
+			t.Errorf("%s: unexpected response %+v", password, response)
+		}
+	}
+
+	req, err = http.NewRequest("GET", passwordPolicyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkRequestHandlerCode(req, state.passwordPolicyHandler,
+		http.StatusUnauthorized); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/hostinventory"
+	"github.com/Symantec/keymaster/lib/plugin"
+	proto "github.com/Symantec/keymaster/proto/plugin"
+)
+
+// setupPlugins starts the configured plugins. An authenticator plugin
+// becomes the password backend, so no other one may be configured.
+func (state *RuntimeState) setupPlugins() error {
+	for _, pluginConfig := range state.Config.Plugins {
+		if pluginConfig.Name == "" || pluginConfig.Path == "" {
+			return errors.New("plugins need a name and a path")
+		}
+		client, err := plugin.New(pluginConfig.Name, pluginConfig.Path,
+			pluginConfig.Args, logger)
+		if err != nil {
+			return err
+		}
+		state.plugins = append(state.plugins, client)
+		if client.HasCapability(proto.CapabilityAuthenticator) {
+			if state.passwordChecker != nil {
+				return fmt.Errorf(
+					"plugin %s is an authenticator but another password backend is configured",
+					pluginConfig.Name)
+			}
+			state.passwordChecker = client
+		}
+	}
+	return nil
+}
+
+// checkPluginPolicies asks every policy plugin whether the certificate may be
+// issued. All of them have to allow it; errors deny the request.
+func (state *RuntimeState) checkPluginPolicies(r *http.Request,
+	username string, certType string, duration time.Duration) error {
+	request := &proto.PolicyRequest{
+		Username:     username,
+		CertType:     certType,
+		DurationSecs: int64(duration.Seconds()),
+		RemoteAddr:   r.RemoteAddr,
+		RequestedBy:  delegatedRequester(r),
+	}
+	for _, client := range state.plugins {
+		if !client.HasCapability(proto.CapabilityPolicy) {
+			continue
+		}
+		response, err := client.CheckPolicy(request)
+		if err != nil {
+			requestLogger(r).Printf("policy plugin %s: %s", client.Name(), err)
+			return errors.New("policy check failed")
+		}
+		if !response.GetAllow() {
+			if response.GetReason() == "" {
+				return errors.New("denied by policy")
+			}
+			return errors.New(response.GetReason())
+		}
+	}
+	return nil
+}
+
+// lookupHost looks the host up in the inventory file first and then asks the
+// inventory plugins.
+func (state *RuntimeState) lookupHost(identity string) (hostinventory.Host, bool) {
+	if host, ok := state.hostInventory.Lookup(identity); ok {
+		return host, true
+	}
+	for _, client := range state.plugins {
+		if !client.HasCapability(proto.CapabilityInventory) {
+			continue
+		}
+		response, err := client.LookupHost(identity)
+		if err != nil {
+			logger.Printf("inventory plugin %s: %s", client.Name(), err)
+			continue
+		}
+		if response.GetFound() {
+			return hostinventory.Host{
+				Identity:    identity,
+				DNSNames:    response.GetDnsNames(),
+				IPAddresses: response.GetIpAddresses(),
+				Profiles:    response.GetProfiles(),
+			}, true
+		}
+	}
+	return hostinventory.Host{}, false
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/policyversions"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/yaml.v2"
+)
+
+const policyVersionsPath = "/policyVersions"
+const policyDiffPath = "/policyDiff"
+const policyVersionsDirectory = "policy_versions"
+
+const (
+	policySourceConfig   = "config"
+	policySourceProposed = "proposed"
+)
+
+const maxPolicyBodySize = 1 << 20
+
+// issuancePolicy is the part of the configuration which decides whether a
+// user gets certificates and how they must authenticate for them. A version
+// is stored whenever a changed configuration is loaded.
+type issuancePolicy struct {
+	AllowedAuthBackendsForCerts []string `yaml:"allowed_auth_backends_for_certs"`
+	AutomationUsers             []string `yaml:"automation_users"`
+	AutomationUserGroups        []string `yaml:"automation_user_groups"`
+	SymantecVIPEnabled          bool     `yaml:"symantec_vip_enabled"`
+	LocalTOTPEnabled            bool     `yaml:"local_totp_enabled"`
+	DuoEnabled                  bool     `yaml:"duo_enabled"`
+	DuoEnforceGroups            []string `yaml:"duo_enforce_groups"`
+	// Only versions with requirements have the field.
+	AuthRequirements AuthRequirementsConfig `yaml:"auth_requirements,omitempty"`
+}
+
+// issuanceOutcome is what a policy means for one user.
+type issuanceOutcome struct {
+	// CertAuthBackends are the methods accepted for certificates.
+	CertAuthBackends []string `json:"cert_auth_backends"`
+	DuoRequired      bool     `json:"duo_required"`
+	// Automation users may get certificates with IP restricted certificates.
+	Automation bool `json:"automation"`
+}
+
+type policyVersionInfo struct {
+	ID      uint64
+	Created string
+	Source  string
+	Comment string `json:",omitempty"`
+	SHA256  string
+}
+
+type policyFieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type policyUserDiff struct {
+	Username string          `json:"username"`
+	Changed  bool            `json:"changed"`
+	Before   issuanceOutcome `json:"before"`
+	After    issuanceOutcome `json:"after"`
+	Error    string          `json:"error,omitempty"`
+}
+
+type policyDiffResponse struct {
+	From         policyVersionInfo   `json:"from"`
+	To           policyVersionInfo   `json:"to"`
+	FieldChanges []policyFieldChange `json:"field_changes"`
+	ChangedUsers int                 `json:"changed_users"`
+	Users        []policyUserDiff    `json:"users"`
+}
+
+func (config *AppConfigFile) issuancePolicy() issuancePolicy {
+	return issuancePolicy{
+		AllowedAuthBackendsForCerts: config.Base.AllowedAuthBackendsForCerts,
+		AutomationUsers:             config.Base.AutomationUsers,
+		AutomationUserGroups:        config.Base.AutomationUserGroups,
+		SymantecVIPEnabled:          config.SymantecVIP.Enabled,
+		LocalTOTPEnabled:            config.Base.EnableLocalTOTP,
+		DuoEnabled:                  config.Duo.Enabled,
+		DuoEnforceGroups:            config.Duo.EnforceGroups,
+		AuthRequirements:            config.AuthRequirements,
+	}
+}
+
+// activeIssuancePolicy returns the issuance policy in force. Reloads and
+// approved change requests replace it under state.Mutex, so request handlers
+// read it through here.
+func (state *RuntimeState) activeIssuancePolicy() issuancePolicy {
+	state.Mutex.Lock()
+	defer state.Mutex.Unlock()
+	return state.Config.issuancePolicy()
+}
+
+func stringsIntersect(left, right []string) bool {
+	for _, l := range left {
+		for _, r := range right {
+			if l == r {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// certAuthBackends returns the methods offered to a user for certificates.
+// Without any the user must use U2F, which is always sufficient.
+func (p issuancePolicy) certAuthBackends(userHasU2FTokens bool,
+	duoEnforced bool) []string {
+	// Members of Duo enforced groups must do a Duo push whatever else is
+	// allowed.
+	if duoEnforced {
+		return []string{proto.AuthTypeDuo}
+	}
+	allowedBackends := p.AllowedAuthBackendsForCerts
+	requirement, err := p.AuthRequirements.requirement(authGroupCertificates)
+	if err == nil && requirement != nil {
+		allowedBackends = requirementCertAuthBackends(requirement)
+	}
+	var certBackends []string
+	for _, certPref := range allowedBackends {
+		if certPref == proto.AuthTypePassword {
+			certBackends = append(certBackends, proto.AuthTypePassword)
+		}
+		if certPref == proto.AuthTypeU2F && userHasU2FTokens {
+			certBackends = append(certBackends, proto.AuthTypeU2F)
+		}
+		if certPref == proto.AuthTypeSymantecVIP && p.SymantecVIPEnabled {
+			certBackends = append(certBackends, proto.AuthTypeSymantecVIP)
+		}
+		if certPref == proto.AuthTypeTOTP && p.LocalTOTPEnabled {
+			certBackends = append(certBackends, proto.AuthTypeTOTP)
+		}
+		if certPref == proto.AuthTypeDuo && p.DuoEnabled {
+			certBackends = append(certBackends, proto.AuthTypeDuo)
+		}
+	}
+	if len(certBackends) == 0 {
+		certBackends = append(certBackends, proto.AuthTypeU2F)
+	}
+	return certBackends
+}
+
+// sufficientForCerts returns true if authLevel is enough for certificates.
+func (p issuancePolicy) sufficientForCerts(authLevel int) bool {
+	requirement, err := p.AuthRequirements.requirement(
+		authGroupCertificates)
+	if err != nil {
+		logger.Printf("auth_requirements: %s", err)
+		return false
+	}
+	if requirement != nil {
+		return requirement.Satisfied(authLevel)
+	}
+	// We should do an intersection operation here
+	for _, certPref := range p.AllowedAuthBackendsForCerts {
+		if certPref == proto.AuthTypePassword {
+			return true
+		}
+		if certPref == proto.AuthTypeU2F && ((authLevel & AuthTypeU2F) == AuthTypeU2F) {
+			return true
+		}
+		if certPref == proto.AuthTypeSymantecVIP && ((authLevel & AuthTypeSymantecVIP) == AuthTypeSymantecVIP) {
+			return true
+		}
+		if certPref == proto.AuthTypeIPCertificate && ((authLevel & AuthTypeIPCertificate) == AuthTypeIPCertificate) {
+			return true
+		}
+		if certPref == proto.AuthTypeDuo && ((authLevel & AuthTypeDuo) == AuthTypeDuo) {
+			return true
+		}
+	}
+	// if you have u2f you can always get the cert
+	if (authLevel & AuthTypeU2F) == AuthTypeU2F {
+		return true
+	}
+	return false
+}
+
+// evaluate returns the outcome for a user in groups, assuming they have
+// registered U2F tokens.
+func (p issuancePolicy) evaluate(username string,
+	groups []string) issuanceOutcome {
+	duoRequired := p.DuoEnabled && stringsIntersect(groups, p.DuoEnforceGroups)
+	return issuanceOutcome{
+		CertAuthBackends: p.certAuthBackends(true, duoRequired),
+		DuoRequired:      duoRequired,
+		Automation: stringsIntersect([]string{username}, p.AutomationUsers) ||
+			stringsIntersect(groups, p.AutomationUserGroups),
+	}
+}
+
+func policyFieldChanges(before, after issuancePolicy) []policyFieldChange {
+	changes := []policyFieldChange{}
+	beforeValue := reflect.ValueOf(before)
+	afterValue := reflect.ValueOf(after)
+	policyType := beforeValue.Type()
+	for index := 0; index < policyType.NumField(); index++ {
+		beforeField := beforeValue.Field(index).Interface()
+		afterField := afterValue.Field(index).Interface()
+		if reflect.DeepEqual(beforeField, afterField) {
+			continue
+		}
+		changes = append(changes, policyFieldChange{
+			Field:  strings.Split(policyType.Field(index).Tag.Get("yaml"), ",")[0],
+			Before: beforeField,
+			After:  afterField,
+		})
+	}
+	return changes
+}
+
+func makePolicyVersionInfo(version policyversions.Version) policyVersionInfo {
+	return policyVersionInfo{
+		ID:      version.ID,
+		Created: version.Created.Format(time.RFC3339),
+		Source:  version.Source,
+		Comment: version.Comment,
+		SHA256:  version.SHA256,
+	}
+}
+
+func parseIssuancePolicy(data []byte) (issuancePolicy, error) {
+	var policy issuancePolicy
+	err := yaml.UnmarshalStrict(data, &policy)
+	return policy, err
+}
+
+// recordConfigPolicyVersion stores the policy of the loaded configuration if
+// it changed.
+func (state *RuntimeState) recordConfigPolicyVersion() error {
+	data, err := yaml.Marshal(state.Config.issuancePolicy())
+	if err != nil {
+		return err
+	}
+	version, added, err := state.policyVersions.Add(data, policySourceConfig,
+		"")
+	if err != nil {
+		return err
+	}
+	if added {
+		logger.Printf("Stored issuance policy version %d", version.ID)
+	}
+	state.configPolicyVersion = version.ID
+	return nil
+}
+
+// policyVersionsHandler is served on the admin port. GET lists the stored
+// versions, POST stores the issuance policy in YAML in the body as a proposed
+// version, with an optional comment query parameter. Only admins may POST.
+func (state *RuntimeState) policyVersionsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		clientName, ok := state.requireAdmin(w, r)
+		if !ok {
+			return
+		}
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body,
+			maxPolicyBodySize))
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		policy, err := parseIssuancePolicy(data)
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Invalid policy: "+err.Error())
+			return
+		}
+		// Store the canonical form so that the same policy is detected.
+		data, err = yaml.Marshal(policy)
+		if err != nil {
+			requestLogger(r).Errorf("%s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		version, added, err := state.policyVersions.Add(data,
+			policySourceProposed, r.URL.Query().Get("comment"))
+		if err != nil {
+			requestLogger(r).Errorf("%s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		if added {
+			requestLogger(r).Printf("%s proposed issuance policy version %d",
+				clientName, version.ID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(makePolicyVersionInfo(version))
+		return
+	default:
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	versions := []policyVersionInfo{}
+	for _, version := range state.policyVersions.List() {
+		versions = append(versions, makePolicyVersionInfo(version))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+func (state *RuntimeState) getPolicyVersion(w http.ResponseWriter,
+	r *http.Request, parameter string,
+	defaultVersion policyversions.Version) (policyversions.Version,
+	issuancePolicy, bool) {
+	version := defaultVersion
+	if idString := r.URL.Query().Get(parameter); idString != "" {
+		id, err := strconv.ParseUint(idString, 10, 64)
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Bad version: "+idString)
+			return version, issuancePolicy{}, false
+		}
+		var ok bool
+		version, ok = state.policyVersions.Get(id)
+		if !ok {
+			state.writeFailureResponse(w, r, http.StatusNotFound,
+				"Unknown version: "+idString)
+			return version, issuancePolicy{}, false
+		}
+	}
+	if version.ID == 0 {
+		state.writeFailureResponse(w, r, http.StatusNotFound,
+			"No policy version to compare")
+		return version, issuancePolicy{}, false
+	}
+	policy, err := parseIssuancePolicy(version.Policy)
+	if err != nil {
+		requestLogger(r).Errorf("Cannot parse policy version %d: %s", version.ID, err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return version, issuancePolicy{}, false
+	}
+	return version, policy, true
+}
+
+// policyDiffHandler is served on the admin port and shows which issuance
+// outcomes of the representative users (or the comma separated users query
+// parameter) change between the versions from and to. By default to is the
+// latest version and from is the policy in force. Only admins may give users,
+// since their groups are looked up in the directory.
+func (state *RuntimeState) policyDiffHandler(w http.ResponseWriter,
+	r *http.Request) {
+	users := r.URL.Query().Get("users")
+	if users != "" {
+		if _, ok := state.requireAdmin(w, r); !ok {
+			return
+		}
+	}
+	versions := state.policyVersions.List()
+	var latest policyversions.Version
+	if len(versions) > 0 {
+		latest = versions[len(versions)-1]
+	}
+	current := state.activePolicyVersion()
+	fromVersion, fromPolicy, ok := state.getPolicyVersion(w, r, "from",
+		current)
+	if !ok {
+		return
+	}
+	toVersion, toPolicy, ok := state.getPolicyVersion(w, r, "to", latest)
+	if !ok {
+		return
+	}
+	state.Mutex.Lock()
+	usernames := state.Config.PolicyAudit.RepresentativeUsers
+	state.Mutex.Unlock()
+	if users != "" {
+		usernames = strings.Split(users, ",")
+	}
+	response := policyDiffResponse{
+		From:         makePolicyVersionInfo(fromVersion),
+		To:           makePolicyVersionInfo(toVersion),
+		FieldChanges: policyFieldChanges(fromPolicy, toPolicy),
+		Users:        []policyUserDiff{},
+	}
+	for _, username := range usernames {
+		username = strings.TrimSpace(username)
+		if username == "" {
+			continue
+		}
+		userDiff := policyUserDiff{Username: username}
+		groups, err := state.getUserGroups(username)
+		if err != nil {
+			userDiff.Error = err.Error()
+			response.Users = append(response.Users, userDiff)
+			continue
+		}
+		userDiff.Before = fromPolicy.evaluate(username, groups)
+		userDiff.After = toPolicy.evaluate(username, groups)
+		userDiff.Changed = !reflect.DeepEqual(userDiff.Before, userDiff.After)
+		if userDiff.Changed {
+			response.ChangedUsers++
+		}
+		response.Users = append(response.Users, userDiff)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
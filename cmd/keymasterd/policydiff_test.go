@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/admincache"
+	"github.com/Symantec/keymaster/keymasterd/policyversions"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+const testProposedPolicy = `allowed_auth_backends_for_certs: ["U2F"]
+automation_users: ["robot"]
+`
+
+// withAdminClientCert gives req a verified client certificate of username
+// from an admin CA.
+func withAdminClientCert(req *http.Request, username string) *http.Request {
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: username}}}}}
+	return req
+}
+
+func TestPolicyDiffHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "policyversions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.policyVersions, err = policyversions.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.AllowedAuthBackendsForCerts = []string{
+		proto.AuthTypePassword}
+	state.Config.PolicyAudit.RepresentativeUsers = []string{"alice", "robot"}
+	state.Config.Base.AdminUsers = []string{"ops"}
+	state.isAdminCache = admincache.New(time.Minute)
+	if err := state.recordConfigPolicyVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only admins may propose versions.
+	for _, username := range []string{"", "alice"} {
+		req, err := http.NewRequest("POST", policyVersionsPath,
+			strings.NewReader(testProposedPolicy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if username != "" {
+			req = withAdminClientCert(req, username)
+		}
+		_, err = checkRequestHandlerCode(req, state.policyVersionsHandler,
+			http.StatusForbidden)
+		if err != nil {
+			t.Fatalf("%q: %s", username, err)
+		}
+	}
+	req, err := http.NewRequest("POST", policyVersionsPath+"?comment=test",
+		strings.NewReader("bogus_field: 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(withAdminClientCert(req, "ops"),
+		state.policyVersionsHandler, http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest("POST", policyVersionsPath+"?comment=test",
+		strings.NewReader(testProposedPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(withAdminClientCert(req, "ops"),
+		state.policyVersionsHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err = http.NewRequest("GET", policyDiffPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req, state.policyDiffHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response policyDiffResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.From.ID != 1 || response.To.ID != 2 {
+		t.Fatalf("unexpected versions: %+v %+v", response.From, response.To)
+	}
+	if len(response.FieldChanges) != 2 || response.ChangedUsers != 2 {
+		t.Fatalf("unexpected diff: %+v", response)
+	}
+	for _, user := range response.Users {
+		if user.After.CertAuthBackends[0] != proto.AuthTypeU2F {
+			t.Fatalf("unexpected outcome for %s: %+v", user.Username,
+				user.After)
+		}
+		if user.After.Automation != (user.Username == "robot") {
+			t.Fatalf("unexpected automation for %s", user.Username)
+		}
+	}
+
+	req, err = http.NewRequest("GET", policyDiffPath+"?from=2&to=2&users=alice",
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.policyDiffHandler,
+		http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(withAdminClientCert(req, "ops"),
+		state.policyDiffHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response = policyDiffResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.ChangedUsers != 0 || len(response.Users) != 1 {
+		t.Fatalf("unexpected diff: %+v", response)
+	}
+	req, err = http.NewRequest("GET", policyDiffPath+"?from=9", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.policyDiffHandler,
+		http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestActiveIssuancePolicyDuringReload(t *testing.T) {
+	var state RuntimeState
+	state.Config.Base.AutomationUsers = []string{"robot"}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			policy := issuancePolicy{
+				AutomationUsers:  []string{"robot"},
+				DuoEnabled:       i%2 == 0,
+				AuthRequirements: AuthRequirementsConfig{},
+			}
+			state.Mutex.Lock()
+			state.Config.setIssuancePolicy(policy)
+			state.Mutex.Unlock()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if ok, err := state.isAutomationUser("robot"); err == nil && !ok {
+			t.Fatal("robot is not an automation user")
+		}
+		state.getRequiredWebUIAuthLevel()
+	}
+	<-done
+	if policy := state.activeIssuancePolicy(); policy.DuoEnabled {
+		t.Error("the last policy set is not in force")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/proxysign"
+)
+
+const maxProxySignatureSkew = 2 * time.Minute
+
+type satelliteProxyKey struct{}
+
+type proxySignatureType struct {
+	handler          http.Handler
+	verifier         *proxysign.Verifier
+	requireSignature bool
+}
+
+// NewProxySignatureHandler returns a handler that rejects requests claiming to
+// be forwarded by a satellite proxy unless they carry a valid signature from
+// one of the configured proxies. Unsigned requests are passed unchanged,
+// unless requireSignature is set. Verified requests carry the identity of the
+// proxy and of the client, see satelliteProxyIdentity.
+func NewProxySignatureHandler(handler http.Handler,
+	secrets map[string][]byte, requireSignature bool) http.Handler {
+	return &proxySignatureType{
+		handler:          handler,
+		verifier:         proxysign.NewVerifier(secrets, maxProxySignatureSkew),
+		requireSignature: requireSignature,
+	}
+}
+
+func (h *proxySignatureType) ServeHTTP(w http.ResponseWriter,
+	req *http.Request) {
+	if !proxysign.IsSigned(req) {
+		if h.requireSignature {
+			requestLogger(req).Warnf("Rejecting unsigned request from %s",
+				req.RemoteAddr)
+			http.Error(w, "Missing proxy signature", http.StatusUnauthorized)
+			return
+		}
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+	identity, err := h.verifier.Verify(req)
+	if err != nil {
+		requestLogger(req).Warnf("Rejecting proxied request from %s: %s",
+			req.RemoteAddr, err)
+		http.Error(w, "Invalid proxy signature", http.StatusUnauthorized)
+		return
+	}
+	requestLogger(req).Debugf(1, "request forwarded by satellite proxy %s for %s",
+		identity.ProxyID, identity.ClientAddress)
+	h.handler.ServeHTTP(w, withSatelliteProxyIdentity(req, identity))
+}
+
+// withSatelliteProxyIdentity returns a copy of req marked as forwarded by a
+// verified proxy. The TLS client certificate, if any, is the one of the proxy
+// and not of the user, so it is removed.
+func withSatelliteProxyIdentity(req *http.Request,
+	identity *proxysign.Identity) *http.Request {
+	req = req.WithContext(context.WithValue(req.Context(),
+		satelliteProxyKey{}, identity))
+	if req.TLS != nil {
+		connectionState := *req.TLS
+		connectionState.PeerCertificates = nil
+		connectionState.VerifiedChains = nil
+		req.TLS = &connectionState
+	}
+	return req
+}
+
+// satelliteProxyIdentity returns the verified identity of the proxy which
+// forwarded r, or nil if r came directly from the client.
+func satelliteProxyIdentity(r *http.Request) *proxysign.Identity {
+	identity, _ := r.Context().Value(satelliteProxyKey{}).(*proxysign.Identity)
+	return identity
+}
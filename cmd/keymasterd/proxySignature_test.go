@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/proxysign"
+)
+
+func TestProxySignatureHandler(t *testing.T) {
+	secrets := map[string][]byte{"branch1": []byte("0123456789abcdef")}
+	var got *http.Request
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	})
+	newRequest := func(sign bool) *http.Request {
+		req := httptest.NewRequest("POST", "https://keymaster/api/v0/login",
+			bytes.NewBufferString("body"))
+		req.RemoteAddr = "10.0.0.1:4242"
+		req.Header.Set("X-Forwarded-For", "192.0.2.1, 198.51.100.7")
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{}}}
+		if sign {
+			err := proxysign.SignRequest(req, "branch1", secrets["branch1"],
+				[]byte("body"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return req
+	}
+
+	handler := NewProxySignatureHandler(inner, secrets, false)
+	got = nil
+	handler.ServeHTTP(httptest.NewRecorder(), newRequest(false))
+	if got == nil || satelliteProxyIdentity(got) != nil {
+		t.Fatal("unsigned request not passed unchanged")
+	}
+	if address := loginThrottleAddress(got); address != "10.0.0.1" {
+		t.Errorf("unsigned X-Forwarded-For used: %s", address)
+	}
+	got = nil
+	req := newRequest(true)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got == nil {
+		t.Fatal("signed request rejected")
+	}
+	if identity := satelliteProxyIdentity(got); identity == nil ||
+		identity.ProxyID != "branch1" {
+		t.Fatalf("identity not passed on: %+v", identity)
+	}
+	if address := loginThrottleAddress(got); address != "198.51.100.7" {
+		t.Errorf("signed client address not used: %s", address)
+	}
+	if len(got.TLS.PeerCertificates) > 0 || len(req.TLS.PeerCertificates) < 1 {
+		t.Error("proxy certificate not removed from a copy of the request")
+	}
+	rr := httptest.NewRecorder()
+	req.Body = ioutil.NopCloser(bytes.NewBufferString("body"))
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("replayed request: got status %d", rr.Code)
+	}
+
+	handler = NewProxySignatureHandler(inner, secrets, true)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequest(false))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned request with require: got status %d", rr.Code)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const realmsPathPrefix = "/realms/"
+
+var realmNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// RealmConfig is another trust domain served by this instance, with its own
+// CA keys, authentication backends, policy and data directory.
+type RealmConfig struct {
+	Name string `yaml:"name"`
+	// ConfigFilename is the configuration of the realm, in the format of
+	// the main configuration file.
+	ConfigFilename string `yaml:"config_filename"`
+	// ServerNames select the realm by TLS SNI.
+	ServerNames []string `yaml:"server_names"`
+}
+
+type realm struct {
+	name           string
+	serverNames    []string
+	state          *RuntimeState
+	serviceHandler http.Handler
+}
+
+func checkRealms(realms []RealmConfig) error {
+	names := make(map[string]struct{}, len(realms))
+	serverNames := make(map[string]string)
+	for _, config := range realms {
+		if !realmNameRegexp.MatchString(config.Name) {
+			return fmt.Errorf("realms: invalid name: %q", config.Name)
+		}
+		if _, ok := names[config.Name]; ok {
+			return fmt.Errorf("realms: duplicate realm %s", config.Name)
+		}
+		names[config.Name] = struct{}{}
+		if config.ConfigFilename == "" {
+			return fmt.Errorf("realms: %s: missing config_filename",
+				config.Name)
+		}
+		for _, serverName := range config.ServerNames {
+			serverName = strings.ToLower(serverName)
+			if other, ok := serverNames[serverName]; ok {
+				return fmt.Errorf("realms: %s: server name %s used by %s",
+					config.Name, serverName, other)
+			}
+			serverNames[serverName] = config.Name
+		}
+	}
+	return nil
+}
+
+func (r *realm) componentPrefix() string {
+	return "realm_" + r.name + "_"
+}
+
+// loadRealms loads the configurations of the realms. Each realm gets its own
+// RuntimeState, which shares nothing with state but the ports.
+func (state *RuntimeState) loadRealms() error {
+	dataDirectories := map[string]string{
+		state.Config.Base.DataDirectory: "the default realm"}
+	for _, config := range state.Config.Realms {
+		realmState, err := loadVerifyConfigFile(config.ConfigFilename)
+		if err != nil {
+			return fmt.Errorf("realm %s: %s", config.Name, err)
+		}
+		base := realmState.Config.Base
+		if len(realmState.Config.Realms) > 0 {
+			return fmt.Errorf("realm %s: realms cannot have realms",
+				config.Name)
+		}
+		if base.HttpAddress != state.Config.Base.HttpAddress ||
+			base.PublicPort != state.Config.Base.PublicPort {
+			return fmt.Errorf(
+				"realm %s: http_address and public_port must be those of the default realm",
+				config.Name)
+		}
+		if other, ok := dataDirectories[base.DataDirectory]; ok {
+			return fmt.Errorf("realm %s: data_directory %s used by %s",
+				config.Name, base.DataDirectory, other)
+		}
+		dataDirectories[base.DataDirectory] = "realm " + config.Name
+		state.realms = append(state.realms, &realm{
+			name:        config.Name,
+			serverNames: config.ServerNames,
+			state:       realmState,
+			serviceHandler: NewProxySignatureHandler(
+				realmState.newServiceMux(), realmState.satelliteProxySecrets,
+				realmState.Config.RequireSatelliteProxySignature),
+		})
+	}
+	return nil
+}
+
+func (state *RuntimeState) realmByName(name string) *realm {
+	for _, realm := range state.realms {
+		if realm.name == name {
+			return realm
+		}
+	}
+	return nil
+}
+
+func (state *RuntimeState) realmByServerName(serverName string) *realm {
+	for _, realm := range state.realms {
+		for _, name := range realm.serverNames {
+			if strings.EqualFold(name, serverName) {
+				return realm
+			}
+		}
+	}
+	return nil
+}
+
+// setRealmTLSConfigs makes config present the certificate and verify client
+// certificates against the client CA of the realm selected by SNI. The
+// handshake of other connections uses config itself.
+func (state *RuntimeState) setRealmTLSConfigs(config *tls.Config) error {
+	if len(state.realms) < 1 {
+		return nil
+	}
+	configs := make(map[*realm]*tls.Config, len(state.realms))
+	for _, realm := range state.realms {
+		serverCert, err := realm.state.newServerTLSCertificate()
+		if err != nil {
+			return fmt.Errorf("realm %s: %s", realm.name, err)
+		}
+		realm.state.serverTLSCertificate = serverCert
+		realmConfig := config.Clone()
+		realmConfig.Certificates = nil
+		realmConfig.GetCertificate = serverCert.GetCertificate
+		realmConfig.ClientCAs = realm.state.ClientCAPool
+		configs[realm] = realmConfig
+	}
+	config.GetConfigForClient = func(
+		hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if realm := state.realmByServerName(hello.ServerName); realm != nil {
+			return configs[realm], nil
+		}
+		return nil, nil
+	}
+	return nil
+}
+
+// realmHandler passes requests to the realm selected by SNI or, if none is,
+// by a /realms/<name>/ path prefix, and other requests to defaultHandler.
+// The handshake of requests selecting the realm by path has verified client
+// certificates against the client CA of the default realm, so they are
+// verified again against that of the realm, and the absolute redirects and
+// cookie paths of the responses get the prefix.
+func (state *RuntimeState) realmHandler(
+	defaultHandler http.Handler) http.Handler {
+	if len(state.realms) < 1 {
+		return defaultHandler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			if realm := state.realmByServerName(r.TLS.ServerName); realm != nil {
+				realm.serviceHandler.ServeHTTP(w, r)
+				return
+			}
+		}
+		if strings.HasPrefix(r.URL.Path, realmsPathPrefix) {
+			name := strings.SplitN(
+				strings.TrimPrefix(r.URL.Path, realmsPathPrefix), "/", 2)[0]
+			if realm := state.realmByName(name); realm != nil {
+				prefix := realmsPathPrefix + name
+				http.StripPrefix(prefix, realm.serviceHandler).ServeHTTP(
+					&realmPrefixWriter{ResponseWriter: w, prefix: prefix},
+					realm.verifyClientCertificate(r))
+				return
+			}
+		}
+		defaultHandler.ServeHTTP(w, r)
+	})
+}
+
+// verifyClientCertificate returns req with the verified chains of its client
+// certificate replaced by those going to the client CA of the realm, which
+// are none if the certificate was not issued by it.
+func (r *realm) verifyClientCertificate(req *http.Request) *http.Request {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) < 1 {
+		return req
+	}
+	connectionState := *req.TLS
+	connectionState.VerifiedChains = nil
+	// A nil pool would verify against the system roots.
+	if r.state.ClientCAPool != nil {
+		intermediates := x509.NewCertPool()
+		for _, cert := range connectionState.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		chains, err := connectionState.PeerCertificates[0].Verify(
+			x509.VerifyOptions{
+				Roots:         r.state.ClientCAPool,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			})
+		if err == nil {
+			connectionState.VerifiedChains = chains
+		}
+	}
+	newReq := req.WithContext(req.Context())
+	newReq.TLS = &connectionState
+	return newReq
+}
+
+// realmPrefixWriter puts the path prefix of a realm in front of the absolute
+// paths of the Location and Set-Cookie headers of the responses of the realm.
+type realmPrefixWriter struct {
+	http.ResponseWriter
+	prefix      string
+	wroteHeader bool
+}
+
+func (w *realmPrefixWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		prefixHeaderPaths(w.Header(), w.prefix)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *realmPrefixWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func prefixHeaderPaths(header http.Header, prefix string) {
+	if location := header.Get("Location"); strings.HasPrefix(location, "/") &&
+		!strings.HasPrefix(location, "//") {
+		header.Set("Location", prefix+location)
+	}
+	cookies := header["Set-Cookie"]
+	for index, cookie := range cookies {
+		attributes := strings.Split(cookie, ";")
+		for attrIndex, attribute := range attributes {
+			attribute = strings.TrimSpace(attribute)
+			if len(attribute) > 5 &&
+				strings.EqualFold(attribute[:5], "path=") &&
+				strings.HasPrefix(attribute[5:], "/") {
+				attributes[attrIndex] = " Path=" + prefix + attribute[5:]
+			}
+		}
+		cookies[index] = strings.Join(attributes, ";")
+	}
+}
+
+// registerRealmAdminHandlers serves the admin handlers of each realm under
+// /realms/<name>/ on mux.
+func (state *RuntimeState) registerRealmAdminHandlers(mux *http.ServeMux) {
+	for _, realm := range state.realms {
+		realmMux := http.NewServeMux()
+		realm.state.registerAdminHandlers(realmMux)
+		prefix := realmsPathPrefix + realm.name
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, realmMux))
+	}
+}
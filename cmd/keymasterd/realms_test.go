@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckRealms(t *testing.T) {
+	realms := []RealmConfig{
+		{Name: "eu", ConfigFilename: "eu.yml",
+			ServerNames: []string{"keymaster.eu.example.com"}},
+		{Name: "us", ConfigFilename: "us.yml"},
+	}
+	if err := checkRealms(realms); err != nil {
+		t.Fatal(err)
+	}
+	for _, invalid := range [][]RealmConfig{
+		{{Name: "EU", ConfigFilename: "eu.yml"}},
+		{{Name: "eu"}},
+		{{Name: "eu", ConfigFilename: "eu.yml"},
+			{Name: "eu", ConfigFilename: "other.yml"}},
+		{realms[0], {Name: "other", ConfigFilename: "other.yml",
+			ServerNames: []string{"Keymaster.EU.example.com"}}},
+	} {
+		if err := checkRealms(invalid); err == nil {
+			t.Errorf("invalid realms accepted: %+v", invalid)
+		}
+	}
+}
+
+func writeName(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name+" "+r.URL.Path)
+	})
+}
+
+func TestRealmHandler(t *testing.T) {
+	var state RuntimeState
+	state.realms = []*realm{{name: "eu",
+		serverNames:    []string{"keymaster.eu.example.com"},
+		serviceHandler: writeName("eu")}}
+	handler := state.realmHandler(writeName("default"))
+	for _, test := range []struct {
+		serverName string
+		path       string
+		expected   string
+	}{
+		{"", "/certgen/alice", "default /certgen/alice"},
+		{"", "/realms/eu/certgen/alice", "eu /certgen/alice"},
+		{"", "/realms/us/certgen/alice", "default /realms/us/certgen/alice"},
+		{"keymaster.example.com", "/realms/eu/", "eu /"},
+		{"Keymaster.EU.example.com", "/certgen/alice", "eu /certgen/alice"},
+		// SNI takes precedence over the path.
+		{"keymaster.eu.example.com", "/realms/eu/", "eu /realms/eu/"},
+	} {
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.serverName != "" {
+			req.TLS = &tls.ConnectionState{ServerName: test.serverName}
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if body := rr.Body.String(); body != test.expected {
+			t.Errorf("%s%s: got %q, expected %q", test.serverName, test.path,
+				body, test.expected)
+		}
+	}
+}
+
+func TestSetRealmTLSConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "realms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "keymaster.eu.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"keymaster.eu.example.com"},
+	}
+	derCert, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var realmState RuntimeState
+	realmState.Config.Base.TLSCertFilename = filepath.Join(dir, "cert.pem")
+	realmState.Config.Base.TLSKeyFilename = filepath.Join(dir, "key.pem")
+	realmState.ClientCAPool = x509.NewCertPool()
+	err = ioutil.WriteFile(realmState.Config.Base.TLSCertFilename,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derCert}),
+		0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(realmState.Config.Base.TLSKeyFilename,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: derKey}),
+		0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state RuntimeState
+	state.realms = []*realm{{name: "eu",
+		serverNames: []string{"keymaster.eu.example.com"},
+		state:       &realmState}}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if err := state.setRealmTLSConfigs(config); err != nil {
+		t.Fatal(err)
+	}
+	realmConfig, err := config.GetConfigForClient(
+		&tls.ClientHelloInfo{ServerName: "keymaster.eu.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if realmConfig == nil || realmConfig.GetCertificate == nil ||
+		realmConfig.ClientCAs != realmState.ClientCAPool ||
+		realmConfig.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("unexpected realm TLS config: %+v", realmConfig)
+	}
+	realmCert, err := realmConfig.GetCertificate(nil)
+	if err != nil || realmCert.Leaf.Subject.CommonName !=
+		"keymaster.eu.example.com" {
+		t.Fatalf("unexpected realm certificate: %+v, %v", realmCert, err)
+	}
+	defaultConfig, err := config.GetConfigForClient(
+		&tls.ClientHelloInfo{ServerName: "keymaster.example.com"})
+	if err != nil || defaultConfig != nil {
+		t.Fatalf("unexpected default TLS config: %+v, %v", defaultConfig, err)
+	}
+}
+
+func TestRealmHandlerPrefixesPaths(t *testing.T) {
+	var state RuntimeState
+	state.realms = []*realm{{name: "eu", state: &RuntimeState{},
+		serviceHandler: http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				http.SetCookie(w, &http.Cookie{Name: authCookieName,
+					Value: "secret", Path: "/", HttpOnly: true, Secure: true})
+				http.Redirect(w, r, "/profile/", http.StatusFound)
+			})}}
+	handler := state.realmHandler(writeName("default"))
+	req := httptest.NewRequest("GET", "/realms/eu/api/v0/login", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if location := rr.Header().Get("Location"); location !=
+		"/realms/eu/profile/" {
+		t.Errorf("got Location %q", location)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Path != "/realms/eu/" {
+		t.Errorf("got cookies %+v", cookies)
+	}
+	// Requests selecting the realm by SNI are not rewritten.
+	req = httptest.NewRequest("GET", "/api/v0/login", nil)
+	state.realms[0].serverNames = []string{"keymaster.eu.example.com"}
+	req.TLS = &tls.ConnectionState{ServerName: "keymaster.eu.example.com"}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if location := rr.Header().Get("Location"); location != "/profile/" {
+		t.Errorf("SNI: got Location %q", location)
+	}
+}
+
+func newTestClientCA(t *testing.T, name string) (*x509.Certificate,
+	*ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func newTestClientCert(t *testing.T, ca *x509.Certificate,
+	caKey *ecdsa.PrivateKey, username string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: username},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, ca,
+		key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestRealmVerifyClientCertificate(t *testing.T) {
+	defaultCA, defaultCAKey := newTestClientCA(t, "default CA")
+	realmCA, realmCAKey := newTestClientCA(t, "eu CA")
+	realmState := &RuntimeState{ClientCAPool: x509.NewCertPool()}
+	realmState.ClientCAPool.AddCert(realmCA)
+	realm := &realm{name: "eu", state: realmState}
+	for _, test := range []struct {
+		cert     *x509.Certificate
+		verified bool
+	}{
+		{newTestClientCert(t, defaultCA, defaultCAKey, "alice"), false},
+		{newTestClientCert(t, realmCA, realmCAKey, "alice"), true},
+	} {
+		req := httptest.NewRequest("GET", "/realms/eu/certgen/alice", nil)
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{test.cert},
+			// As verified by the handshake, against the default CA.
+			VerifiedChains: [][]*x509.Certificate{{test.cert, defaultCA}},
+		}
+		newReq := realm.verifyClientCertificate(req)
+		if verified := len(newReq.TLS.VerifiedChains) > 0; verified !=
+			test.verified {
+			t.Errorf("%s: verified: %v", test.cert.Issuer.CommonName,
+				verified)
+		}
+		if len(req.TLS.VerifiedChains) != 1 {
+			t.Error("request modified")
+		}
+	}
+	realmState.ClientCAPool = nil
+	req := httptest.NewRequest("GET", "/realms/eu/", nil)
+	cert := newTestClientCert(t, realmCA, realmCAKey, "alice")
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains: [][]*x509.Certificate{{cert, defaultCA}}}
+	if newReq := realm.verifyClientCertificate(req); len(
+		newReq.TLS.VerifiedChains) > 0 {
+		t.Error("verified without a client CA")
+	}
+}
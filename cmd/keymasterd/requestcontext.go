@@ -0,0 +1,18 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// backendRequestTimeout bounds the backend work (password checks, directory
+// lookups, key sources) done for one request, so that a hung backend does not
+// hold on to the request even if the client keeps waiting.
+const backendRequestTimeout = 30 * time.Second
+
+// backendContext returns the context for the backend work done for r. It is
+// cancelled when the client goes away or after backendRequestTimeout.
+func backendContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), backendRequestTimeout)
+}
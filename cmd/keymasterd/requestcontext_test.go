@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/simplestorage"
+)
+
+// hangingPasswordChecker never answers until released.
+type hangingPasswordChecker struct {
+	release chan struct{}
+}
+
+func (checker *hangingPasswordChecker) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	<-checker.release
+	return true, nil
+}
+
+func (checker *hangingPasswordChecker) UpdateStorage(
+	storage simplestorage.SimpleStore) error {
+	return nil
+}
+
+func TestCheckUserPasswordCancelled(t *testing.T) {
+	checker := &hangingPasswordChecker{release: make(chan struct{})}
+	defer close(checker.release)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("POST", "/api/v0/login", nil).WithContext(ctx)
+	valid, err := checkUserPassword("username", "password", AppConfigFile{},
+		checker, req)
+	if valid || err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v, %v", valid, err)
+	}
+}
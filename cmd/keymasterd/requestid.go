@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/Symantec/keymaster/keymasterd/levellog"
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+)
+
+const (
+	requestIDHeader      = "X-Request-ID"
+	logFieldRequestID    = "request_id"
+	maxRequestIDLength   = 128
+	requestIDEntropySize = 16
+)
+
+// validRequestID returns true if id, received from a client or proxy, can
+// be logged and echoed as is.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+',
+			c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns the X-Request-ID of r if it is valid, else a random
+// ID.
+func newRequestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); validRequestID(id) {
+		return id
+	}
+	rb := make([]byte, requestIDEntropySize)
+	if _, err := rand.Read(rb); err != nil {
+		logger.Errorf("Cannot generate request ID: %s", err)
+		return ""
+	}
+	return hex.EncodeToString(rb)
+}
+
+// requestLogFieldsHandler gives every request an ID, returned in the
+// X-Request-ID response header and written to the access log, and adds the
+// fields logged with every line about the request to its context, for
+// requestLogger.
+func requestLogFieldsHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newRequestID(r)
+		fields := levellog.NewFields(logFieldRequestID, id,
+			logFieldClientIP, loginThrottleAddress(r))
+		if id != "" {
+			w.Header().Set(requestIDHeader, id)
+			if loggingWriter, ok := w.(*instrumentedwriter.LoggingWriter); ok {
+				loggingWriter.SetCustomLogRecord(logFieldRequestID, id)
+			}
+		}
+		handler.ServeHTTP(w,
+			r.WithContext(levellog.NewContext(r.Context(), fields)))
+	})
+}
+
+// requestID returns the ID of r, or the empty string outside of
+// requestLogFieldsHandler.
+func requestID(r *http.Request) string {
+	if fields := levellog.FromContext(r.Context()); fields != nil {
+		return fields.Get(logFieldRequestID)
+	}
+	return ""
+}
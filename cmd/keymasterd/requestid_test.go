@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/Dominator/lib/log/debuglogger"
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+)
+
+func TestRequestID(t *testing.T) {
+	var buffer bytes.Buffer
+	var handledID string
+	handler := instrumentedwriter.NewLoggingHandler(
+		requestLogFieldsHandler(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				handledID = requestID(r)
+			})),
+		httpLogger{AccessLogger: debuglogger.New(
+			stdlog.New(&buffer, "", 0))})
+	generated := regexp.MustCompile("^[0-9a-f]{32}$")
+	for _, testCase := range []struct {
+		incoming string
+		honored  bool
+	}{
+		{"", false},
+		{"0b6f2c9a-trace.1", true},
+		{"bad id\n", false},
+		{strings.Repeat("x", maxRequestIDLength+1), false},
+	} {
+		buffer.Reset()
+		req := httptest.NewRequest("GET", "/public/loginForm", nil)
+		if testCase.incoming != "" {
+			req.Header.Set(requestIDHeader, testCase.incoming)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		id := recorder.Header().Get(requestIDHeader)
+		if testCase.honored {
+			if id != testCase.incoming {
+				t.Errorf("%q not honored: %q", testCase.incoming, id)
+			}
+		} else if !generated.MatchString(id) {
+			t.Errorf("%q: bad generated ID %q", testCase.incoming, id)
+		}
+		if handledID != id {
+			t.Errorf("handler saw %q, response has %q", handledID, id)
+		}
+		if !strings.HasSuffix(buffer.String(), "\" "+id+"\n") {
+			t.Errorf("%q not in access log %q", id, buffer.String())
+		}
+	}
+	if id := requestID(httptest.NewRequest("GET", "/", nil)); id != "" {
+		t.Errorf("ID outside of the handler: %q", id)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/krl"
+	"golang.org/x/crypto/ssh"
+)
+
+// The revoked SSH certificates are published as an OpenSSH KRL under
+// publicPath, usable with the RevokedKeys option of sshd, together with a
+// detached signature by the CA key for lib/revocationcheck.
+const (
+	revokedKRLName          = "revoked.krl"
+	revokedKRLSignatureName = revokedKRLName + ".sig"
+)
+
+// revocationFeedCache holds the KRL and signature for a version of the
+// revocation list, so that both fetches of a client match.
+type revocationFeedCache struct {
+	mutex     sync.Mutex
+	version   uint64
+	signerKey string
+	krl       []byte
+	signature []byte
+}
+
+// revocationFeed returns the KRL and its signature. The KRL version is the
+// number of revocations, as the list is only ever appended to.
+func (state *RuntimeState) revocationFeed() ([]byte, []byte, error) {
+	entries := state.revokedCerts.List()
+	state.Mutex.Lock()
+	signer := state.Signer
+	publicKeys := state.KeymasterPublicKeys
+	state.Mutex.Unlock()
+	if signer == nil {
+		return nil, nil, errors.New("signer not loaded")
+	}
+	signerPublicKey, err := ssh.NewPublicKey(signer.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	signerKey := string(signerPublicKey.Marshal())
+	cache := &state.revocationFeedCache
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.krl != nil && cache.version == uint64(len(entries)) &&
+		cache.signerKey == signerKey {
+		return cache.krl, cache.signature, nil
+	}
+	var serials []uint64
+	var generated time.Time
+	for _, entry := range entries {
+		// X.509 serials may be too large for SSH certificates.
+		if serial, err := strconv.ParseUint(entry.Serial, 10, 64); err == nil {
+			serials = append(serials, serial)
+		}
+		generated = entry.Time
+	}
+	revocations := &krl.KRL{
+		Version:       uint64(len(entries)),
+		GeneratedDate: generated,
+		Comment:       "keymaster " + state.HostIdentity,
+	}
+	// Standby and previous CA keys are trusted too.
+	for _, publicKey := range publicKeys {
+		sshPublicKey, err := ssh.NewPublicKey(publicKey)
+		if err != nil {
+			continue
+		}
+		revocations.Sections = append(revocations.Sections,
+			krl.CertificateSection{CAKey: sshPublicKey, Serials: serials})
+	}
+	data := revocations.Marshal()
+	signature, err := krl.Sign(signer, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	cache.version = revocations.Version
+	cache.signerKey = signerKey
+	cache.krl = data
+	cache.signature = signature
+	return data, signature, nil
+}
+
+func (state *RuntimeState) writeRevocationFeed(w http.ResponseWriter,
+	r *http.Request, signature bool) {
+	data, signatureData, err := state.revocationFeed()
+	if err != nil {
+		requestLogger(r).Errorf("Cannot generate KRL: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if signature {
+		data = signatureData
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(data)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Symantec/keymaster/keymasterd/revocationlist"
+	"github.com/Symantec/keymaster/lib/revocationcheck"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRevocationFeed(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "revocationfeed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.revokedCerts, err = revocationlist.Open(filepath.Join(dir,
+		revokedCertsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.KeymasterPublicKeys = []crypto.PublicKey{state.Signer.Public()}
+	if _, err := state.revokeCert("42", "lost laptop"); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(state.publicPathHandler))
+	defer server.Close()
+	caKey, err := ssh.NewPublicKey(state.Signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker, err := revocationcheck.New(revocationcheck.Config{
+		KRLURL:    server.URL + publicPath + revokedKRLName,
+		SSHCAKeys: []ssh.PublicKey{caKey},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for serial, expected := range map[uint64]bool{42: true, 43: false} {
+		revoked, err := checker.IsSSHCertRevoked(
+			&ssh.Certificate{SignatureKey: caKey, Serial: serial})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != expected {
+			t.Errorf("serial %d: revoked is %v", serial, revoked)
+		}
+	}
+	if checker.KRLVersion() != 1 {
+		t.Errorf("KRL version is %d", checker.KRLVersion())
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+)
+
+const defaultRoleMaxDurationSecs = 3600
+
+var roleNameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_.-]*$`)
+
+// reservedRolePrincipals can never be principals of a role, as would the
+// administrators, so that role members do not get superuser logins.
+var reservedRolePrincipals = []string{"root"}
+
+// RoleConfig is a shared account, such as ansible or backup, which users
+// may get SSH certificates for with /certgen/<name>.
+type RoleConfig struct {
+	Name         string   `yaml:"name"`
+	AllowedUsers []string `yaml:"allowed_users"`
+	// Members of any of these groups may get certificates for the role.
+	AllowedGroups []string `yaml:"allowed_groups"`
+	// Default: the name.
+	Principals []string `yaml:"principals"`
+	// Default: 3600.
+	MaxDurationSecs uint `yaml:"max_duration_secs"`
+}
+
+func (config *RoleConfig) check() error {
+	if !roleNameRegexp.MatchString(config.Name) {
+		return fmt.Errorf("bad name: %q", config.Name)
+	}
+	if len(config.AllowedUsers) < 1 && len(config.AllowedGroups) < 1 {
+		return fmt.Errorf("%s: neither allowed_users nor allowed_groups",
+			config.Name)
+	}
+	for _, principal := range config.Principals {
+		if principal == "" {
+			return fmt.Errorf("%s: empty principal", config.Name)
+		}
+	}
+	return nil
+}
+
+func (config *RoleConfig) principals() []string {
+	if len(config.Principals) < 1 {
+		return []string{config.Name}
+	}
+	return config.Principals
+}
+
+// delegationRule returns the rule allowing the members of the role to get
+// its certificates.
+func (config *RoleConfig) delegationRule() DelegationRuleConfig {
+	maxDurationSecs := config.MaxDurationSecs
+	if maxDurationSecs == 0 {
+		maxDurationSecs = defaultRoleMaxDurationSecs
+	}
+	return DelegationRuleConfig{
+		Requesters:      config.AllowedUsers,
+		RequesterGroups: config.AllowedGroups,
+		Targets:         []string{config.Name},
+		CertTypes:       []string{"ssh"},
+		MaxDurationSecs: maxDurationSecs,
+	}
+}
+
+func checkRoles(roles []RoleConfig, adminUsers []string) error {
+	names := make(map[string]struct{}, len(roles))
+	for _, role := range roles {
+		if err := role.check(); err != nil {
+			return fmt.Errorf("roles: %s", err)
+		}
+		if _, ok := names[role.Name]; ok {
+			return fmt.Errorf("roles: duplicate role %s", role.Name)
+		}
+		if stringsIntersect([]string{role.Name}, adminUsers) {
+			return errors.New("roles: administrator " + role.Name +
+				" cannot be a role")
+		}
+		for _, principal := range role.principals() {
+			if stringsIntersect([]string{principal}, reservedRolePrincipals) ||
+				stringsIntersect([]string{principal}, adminUsers) {
+				return fmt.Errorf("roles: %s: reserved principal %s",
+					role.Name, principal)
+			}
+		}
+		names[role.Name] = struct{}{}
+	}
+	return nil
+}
+
+func (state *RuntimeState) lookupRole(name string) (RoleConfig, bool) {
+	for _, role := range state.Config.Roles {
+		if role.Name == name {
+			return role, true
+		}
+	}
+	return RoleConfig{}, false
+}
+
+// userSSHCertOptions returns the options of the SSH certificate for
+// targetUser requested in r and the restrictions applied. Certificates for
+// roles are valid for the principals of the role. Delegated certificates
+// are restricted by the tier of the requester who holds them. Use
+// setSSHCertIdentity for the key ID of each certificate.
+func (state *RuntimeState) userSSHCertOptions(r *http.Request,
+	targetUser string) (certgen.SSHCertOptions, sshRestrictions) {
+	options := certgen.SSHCertOptions{
+		Principals: []string{targetUser},
+		Extensions: certgen.DefaultSSHExtensions(),
+	}
+	requester := delegatedRequester(r)
+	holder := targetUser
+	if requester != "" {
+		holder = requester
+	}
+	restrictions := state.sshRestrictionsFor(r, holder)
+	restrictions.apply(options.Extensions)
+	if role, ok := state.lookupRole(targetUser); ok && requester != "" {
+		options.Principals = role.principals()
+	} else {
+		for name, value := range state.sshGroupClaimExtensions(targetUser) {
+			options.Extensions[name] = value
+		}
+	}
+	return options, restrictions
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/admincache"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCheckRoles(t *testing.T) {
+	roles := []RoleConfig{{Name: "ansible", AllowedGroups: []string{"ops"}}}
+	if err := checkRoles(roles, []string{"admin"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, roles := range map[string][]RoleConfig{
+		"no members": {{Name: "ansible"}},
+		"bad name":   {{Name: "An sible", AllowedUsers: []string{"alice"}}},
+		"duplicate": {{Name: "backup", AllowedUsers: []string{"alice"}},
+			{Name: "backup", AllowedUsers: []string{"bob"}}},
+		"administrator": {{Name: "admin", AllowedUsers: []string{"alice"}}},
+		"root":          {{Name: "root", AllowedUsers: []string{"alice"}}},
+		"root principal": {{Name: "ops", AllowedUsers: []string{"alice"},
+			Principals: []string{"ops", "root"}}},
+		"administrator principal": {{Name: "ops",
+			AllowedUsers: []string{"alice"}, Principals: []string{"admin"}}},
+	} {
+		if err := checkRoles(roles, []string{"admin"}); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestRoleCertGen(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.isAdminCache = admincache.New(time.Minute)
+	state.Config.Roles = []RoleConfig{{
+		Name:         "ansible",
+		AllowedUsers: []string{"alice"},
+		Principals:   []string{"ansible", "ansible-ro"},
+	}}
+	request := func(requester, duration string,
+		expectedStatus int) *ssh.Certificate {
+		req, err := createKeyBodyRequest("POST", certgenPath+"ansible",
+			testUserSSHPublicKey, duration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookieVal, err := state.setNewAuthCookie(nil, nil, requester,
+			AuthTypeU2F)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatalf("%s: %s", requester, err)
+		}
+		if expectedStatus != http.StatusOK {
+			return nil
+		}
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey(rr.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pubKey.(*ssh.Certificate)
+	}
+	cert := request("alice", "1h", http.StatusOK)
+	if len(cert.ValidPrincipals) != 2 || cert.ValidPrincipals[0] != "ansible" ||
+		cert.ValidPrincipals[1] != "ansible-ro" {
+		t.Errorf("principals: %v", cert.ValidPrincipals)
+	}
+	if cert.KeyId != state.HostIdentity+"_ansible_by_alice" {
+		t.Errorf("key ID: %s", cert.KeyId)
+	}
+	request("bob", "1h", http.StatusForbidden)
+	request("alice", "2h", http.StatusForbidden)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// keymasterd often runs in a container with a read-only root filesystem, no
+// writable /tmp and no capabilities. The checks below detect this at
+// startup (and in -checkConfig) so that the failure names the setting to
+// change instead of surfacing later as an obscure I/O error.
+
+const (
+	defaultDataDirectory     = "/var/lib/keymaster"
+	temporaryDirectoryName   = "tmp"
+	netBindServiceCapability = 10
+	defaultUnprivilegedPort  = 1024
+)
+
+// Variables for testing.
+var (
+	processStatusFilename         = "/proc/self/status"
+	unprivilegedPortStartFilename = "/proc/sys/net/ipv4/ip_unprivileged_port_start"
+)
+
+// checkWritableDirectory returns an error if no file can be created in
+// directory.
+func checkWritableDirectory(directory string) error {
+	file, err := ioutil.TempFile(directory, ".keymaster-write-check")
+	if err != nil {
+		if pathErr, ok := err.(*os.PathError); ok &&
+			pathErr.Err == syscall.EROFS {
+			return fmt.Errorf("%s is on a read-only filesystem", directory)
+		}
+		return err
+	}
+	filename := file.Name()
+	file.Close()
+	return os.Remove(filename)
+}
+
+// setupTemporaryDirectory points TMPDIR, used by the database drivers and
+// for temporary files, at temporary_directory. If that is not set and the
+// default temporary directory is not writable a directory in data_directory
+// is used.
+func setupTemporaryDirectory(config *baseConfig) error {
+	directory := config.TemporaryDirectory
+	if directory == "" {
+		if checkWritableDirectory(os.TempDir()) == nil {
+			return nil
+		}
+		directory = filepath.Join(config.DataDirectory, temporaryDirectoryName)
+		logger.Printf("%s is not writable, using %s", os.TempDir(), directory)
+		if err := os.MkdirAll(directory, 0700); err != nil {
+			return err
+		}
+	}
+	if err := checkWritableDirectory(directory); err != nil {
+		return err
+	}
+	return os.Setenv("TMPDIR", directory)
+}
+
+// effectiveCapabilities returns the effective capability set of the
+// process, or false if it is not known (i.e. not on Linux).
+func effectiveCapabilities() (uint64, bool) {
+	file, err := os.Open(processStatusFilename)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "CapEff:" {
+			caps, err := strconv.ParseUint(fields[1], 16, 64)
+			return caps, err == nil
+		}
+	}
+	return 0, false
+}
+
+// unprivilegedPortStart returns the lowest port which needs no privilege,
+// which container runtimes often lower to 0.
+func unprivilegedPortStart() int {
+	data, err := ioutil.ReadFile(unprivilegedPortStartFilename)
+	if err != nil {
+		return defaultUnprivilegedPort
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return defaultUnprivilegedPort
+	}
+	return port
+}
+
+// canBindPort reports whether the process may listen on port.
+func canBindPort(port int) bool {
+	if port == 0 || port >= unprivilegedPortStart() {
+		return true
+	}
+	if caps, ok := effectiveCapabilities(); ok {
+		return caps&(1<<netBindServiceCapability) != 0
+	}
+	return os.Geteuid() == 0
+}
+
+// checkBindAddress returns an error if the process may not listen on the
+// port of address.
+func checkBindAddress(address string) error {
+	_, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := net.LookupPort("tcp", portString)
+	if err != nil {
+		return err
+	}
+	if !canBindPort(port) {
+		return fmt.Errorf("port %d needs root or CAP_NET_BIND_SERVICE, "+
+			"listen on a port from %d and set public_port to the published one",
+			port, unprivilegedPortStart())
+	}
+	return nil
+}
+
+// publicPortSuffix returns the port to put in URLs of keymasterd: public_port
+// if set (e.g. when a container listening on a high port is published on
+// 443) or the port of http_address, and nothing for 443.
+func (state *RuntimeState) publicPortSuffix() string {
+	port := strconv.Itoa(int(state.Config.Base.PublicPort))
+	if state.Config.Base.PublicPort == 0 {
+		var err error
+		_, port, err = net.SplitHostPort(state.Config.Base.HttpAddress)
+		if err != nil {
+			return state.Config.Base.HttpAddress
+		}
+	}
+	if port == "443" {
+		return ""
+	}
+	return ":" + port
+}
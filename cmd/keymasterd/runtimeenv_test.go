@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCanBindPort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtimeenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	oldStatus := processStatusFilename
+	oldPortStart := unprivilegedPortStartFilename
+	defer func() {
+		processStatusFilename = oldStatus
+		unprivilegedPortStartFilename = oldPortStart
+	}()
+	processStatusFilename = filepath.Join(dir, "status")
+	unprivilegedPortStartFilename = filepath.Join(dir, "port_start")
+	for _, test := range []struct {
+		capEff, portStart string
+		port              int
+		canBind           bool
+	}{
+		{"0000000000000000", "1024", 8443, true},
+		{"0000000000000000", "1024", 443, false},
+		{"0000000000000400", "1024", 443, true},
+		{"0000000000000000", "0", 443, true},
+	} {
+		err := ioutil.WriteFile(processStatusFilename, []byte(
+			"Name:\tkeymasterd\nCapEff:\t"+test.capEff+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(unprivilegedPortStartFilename,
+			[]byte(test.portStart+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if canBind := canBindPort(test.port); canBind != test.canBind {
+			t.Errorf("CapEff %s, start %s, port %d: got %v", test.capEff,
+				test.portStart, test.port, canBind)
+		}
+	}
+	if err := checkBindAddress(":https"); err != nil {
+		t.Error(err)
+	}
+	if err := checkBindAddress(":8443"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetupTemporaryDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtimeenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	oldTmpDir, hadTmpDir := os.LookupEnv("TMPDIR")
+	defer func() {
+		if hadTmpDir {
+			os.Setenv("TMPDIR", oldTmpDir)
+		} else {
+			os.Unsetenv("TMPDIR")
+		}
+	}()
+	config := baseConfig{TemporaryDirectory: dir}
+	if err := setupTemporaryDirectory(&config); err != nil {
+		t.Fatal(err)
+	}
+	if os.TempDir() != dir {
+		t.Errorf("TMPDIR is %s", os.TempDir())
+	}
+	config.TemporaryDirectory = filepath.Join(dir, "missing")
+	if err := setupTemporaryDirectory(&config); err == nil {
+		t.Error("missing temporary_directory accepted")
+	}
+}
+
+func TestPublicPortSuffix(t *testing.T) {
+	for _, test := range []struct {
+		httpAddress string
+		publicPort  uint16
+		suffix      string
+	}{
+		{":443", 0, ""},
+		{":8443", 0, ":8443"},
+		{"0.0.0.0:443", 0, ""},
+		{":8443", 443, ""},
+		{":8443", 9443, ":9443"},
+	} {
+		var state RuntimeState
+		state.Config.Base.HttpAddress = test.httpAddress
+		state.Config.Base.PublicPort = test.publicPort
+		if suffix := state.publicPortSuffix(); suffix != test.suffix {
+			t.Errorf("%s, %d: got %q, want %q", test.httpAddress,
+				test.publicPort, suffix, test.suffix)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/Symantec/keymaster/lib/pkcs11signer"
+)
+
+func (state *RuntimeState) serverTLSKeyInPKCS11() bool {
+	return state.Config.Base.TLSKeyPKCS11.ModulePath != ""
+}
+
+// loadServerTLSCertificate loads the certificate chain and key used for the
+// HTTPS listeners. When tls_key_pkcs11 is configured the key stays on the
+// token and only the certificate chain is read from tls_cert_filename.
+func (state *RuntimeState) loadServerTLSCertificate() (tls.Certificate, error) {
+	if !state.serverTLSKeyInPKCS11() {
+		return tls.LoadX509KeyPair(state.Config.Base.TLSCertFilename,
+			state.Config.Base.TLSKeyFilename)
+	}
+	certPEMBlock, err := ioutil.ReadFile(state.Config.Base.TLSCertFilename)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	signer, err := pkcs11signer.New(state.Config.Base.TLSKeyPKCS11)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert, err := makeTLSCertificate(certPEMBlock, signer)
+	if err != nil {
+		signer.Close()
+		return tls.Certificate{}, err
+	}
+	return cert, nil
+}
+
+// makeTLSCertificate builds a tls.Certificate from a PEM encoded chain (leaf
+// first) and a signer holding the private key of the leaf.
+func makeTLSCertificate(certPEMBlock []byte,
+	signer crypto.Signer) (tls.Certificate, error) {
+	var cert tls.Certificate
+	for {
+		var certDERBlock *pem.Block
+		certDERBlock, certPEMBlock = pem.Decode(certPEMBlock)
+		if certDERBlock == nil {
+			break
+		}
+		if certDERBlock.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, certDERBlock.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return tls.Certificate{}, errors.New("no certificates in tls cert file")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if !reflect.DeepEqual(leaf.PublicKey, signer.Public()) {
+		return tls.Certificate{}, errors.New(
+			"tls certificate does not match the key on the token")
+	}
+	cert.Leaf = leaf
+	cert.PrivateKey = signer
+	return cert, nil
+}
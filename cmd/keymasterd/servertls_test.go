@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestMakeTLSCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	derCert, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derCert})
+	cert, err := makeTLSCertificate(certPEM, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf == nil || cert.PrivateKey != key || len(cert.Certificate) != 1 {
+		t.Fatal("incomplete certificate")
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeTLSCertificate(certPEM, otherKey); err == nil {
+		t.Fatal("mismatched key should fail")
+	}
+	if _, err := makeTLSCertificate([]byte("garbage"), key); err == nil {
+		t.Fatal("missing certificate should fail")
+	}
+}
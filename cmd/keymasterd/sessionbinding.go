@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Session cookies can be bound to the client they were issued to, so that a
+// stolen auth cookie cannot be replayed from another machine. The auth JWT
+// then carries a hash of a value only that client can present. With
+// client_secret that is a random secret kept in a second HttpOnly cookie.
+// With tls_exporter it is keying material exported from the TLS connection
+// (RFC 5705); this only suits clients which keep their connection open, such
+// as the keymaster command, since every new connection exports a different
+// value.
+//
+// Unless strict is set a binding mismatch is logged but accepted, as are
+// unbound cookies, which allows turning binding on without logging everyone
+// out.
+const (
+	sessionBindingNone         = ""
+	sessionBindingClientSecret = "client_secret"
+	sessionBindingTLSExporter  = "tls_exporter"
+
+	sessionBindingCookieName = "auth_binding"
+	sessionBindingTLSLabel   = "EXPORTER-keymaster-session-binding"
+	sessionBindingLength     = 32
+)
+
+type SessionBindingConfig struct {
+	// "" (no binding), "client_secret" or "tls_exporter".
+	Mode   string `yaml:"mode"`
+	Strict bool   `yaml:"strict"`
+}
+
+func (config SessionBindingConfig) check() error {
+	switch config.Mode {
+	case sessionBindingNone:
+		if config.Strict {
+			return errors.New("session_binding: strict needs a mode")
+		}
+	case sessionBindingClientSecret, sessionBindingTLSExporter:
+	default:
+		return fmt.Errorf("session_binding: unknown mode: %q", config.Mode)
+	}
+	return nil
+}
+
+func hashSessionBinding(value []byte) string {
+	hash := sha256.Sum256(value)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// exportTLSSessionBinding returns the keying material of the connection of r.
+func exportTLSSessionBinding(r *http.Request) ([]byte, error) {
+	if r.TLS == nil {
+		return nil, errors.New("not a TLS connection")
+	}
+	return r.TLS.ExportKeyingMaterial(sessionBindingTLSLabel, nil,
+		sessionBindingLength)
+}
+
+// newSessionBinding returns the binding to put in a new auth cookie for the
+// client of r, setting the binding cookie on w if needed. It returns "" if
+// binding is off or there is no request to bind to.
+func (state *RuntimeState) newSessionBinding(w http.ResponseWriter,
+	r *http.Request) (string, error) {
+	if r == nil {
+		return "", nil
+	}
+	switch state.Config.Base.SessionBinding.Mode {
+	case sessionBindingClientSecret:
+		secret, err := genRandomString()
+		if err != nil {
+			return "", err
+		}
+		expiration := time.Now().Add(state.Config.Base.Sessions.lifetime())
+		bindingCookie := http.Cookie{Name: sessionBindingCookieName,
+			Value: secret, Expires: expiration, Path: "/", HttpOnly: true,
+			Secure: true, SameSite: http.SameSiteStrictMode}
+		if w != nil {
+			http.SetCookie(w, &bindingCookie)
+		}
+		return hashSessionBinding([]byte(secret)), nil
+	case sessionBindingTLSExporter:
+		material, err := exportTLSSessionBinding(r)
+		if err != nil {
+			if state.Config.Base.SessionBinding.Strict {
+				return "", fmt.Errorf("cannot bind session: %s", err)
+			}
+			requestLogger(r).Errorf("Cannot bind session: %s", err)
+			return "", nil
+		}
+		return hashSessionBinding(material), nil
+	}
+	return "", nil
+}
+
+// requestSessionBinding returns the binding presented by the client of r.
+func (state *RuntimeState) requestSessionBinding(r *http.Request) (
+	string, error) {
+	switch state.Config.Base.SessionBinding.Mode {
+	case sessionBindingClientSecret:
+		cookie, err := r.Cookie(sessionBindingCookieName)
+		if err != nil {
+			return "", errors.New("no binding cookie")
+		}
+		return hashSessionBinding([]byte(cookie.Value)), nil
+	case sessionBindingTLSExporter:
+		material, err := exportTLSSessionBinding(r)
+		if err != nil {
+			return "", err
+		}
+		return hashSessionBinding(material), nil
+	}
+	return "", nil
+}
+
+// checkSessionBinding returns an error if the auth cookie for username with
+// binding must not be accepted from the client of r.
+func (state *RuntimeState) checkSessionBinding(r *http.Request,
+	username, binding string) error {
+	config := state.Config.Base.SessionBinding
+	if config.Mode == sessionBindingNone {
+		return nil
+	}
+	var err error
+	if binding == "" {
+		if !config.Strict {
+			return nil
+		}
+		err = errors.New("cookie is not bound")
+	} else {
+		var presented string
+		presented, err = state.requestSessionBinding(r)
+		if err == nil && subtle.ConstantTimeCompare([]byte(presented),
+			[]byte(binding)) != 1 {
+			err = errors.New("binding mismatch")
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	if !config.Strict {
+		requestLogger(r).Printf("Accepting auth cookie of %s from %s: %s", username,
+			r.RemoteAddr, err)
+		return nil
+	}
+	requestLogger(r).Printf("Refusing auth cookie of %s from %s: %s", username,
+		r.RemoteAddr, err)
+	return err
+}
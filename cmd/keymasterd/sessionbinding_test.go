@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestClientSecretSessionBinding(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.Config.Base.SessionBinding.Mode = sessionBindingClientSecret
+	state.Config.Base.SessionBinding.Strict = true
+
+	loginReq := httptest.NewRequest("POST", "/api/v0/login", nil)
+	recorder := httptest.NewRecorder()
+	if _, err := state.setNewAuthCookie(recorder, loginReq, "username",
+		AuthTypeU2F); err != nil {
+		t.Fatal(err)
+	}
+	var authCookie, bindingCookie *http.Cookie
+	for _, cookie := range recorder.Result().Cookies() {
+		switch cookie.Name {
+		case authCookieName:
+			authCookie = cookie
+		case sessionBindingCookieName:
+			bindingCookie = cookie
+		}
+	}
+	if authCookie == nil || bindingCookie == nil {
+		t.Fatal("auth and binding cookies not set")
+	}
+	checkCookies := func(cookies ...*http.Cookie) error {
+		req := httptest.NewRequest("GET", "/", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		_, _, err := state.checkAuth(httptest.NewRecorder(), req, AuthTypeAny)
+		return err
+	}
+	if err := checkCookies(authCookie, bindingCookie); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkCookies(authCookie); err == nil {
+		t.Error("auth cookie accepted without binding cookie")
+	}
+	otherBinding := *bindingCookie
+	otherBinding.Value = "stolen"
+	if err := checkCookies(authCookie, &otherBinding); err == nil {
+		t.Error("auth cookie accepted with wrong binding cookie")
+	}
+	unbound, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unboundCookie := &http.Cookie{Name: authCookieName, Value: unbound}
+	if err := checkCookies(unboundCookie); err == nil {
+		t.Error("unbound auth cookie accepted in strict mode")
+	}
+
+	state.Config.Base.SessionBinding.Strict = false
+	if err := checkCookies(authCookie, &otherBinding); err != nil {
+		t.Errorf("mismatch refused when not strict: %s", err)
+	}
+	if err := checkCookies(unboundCookie); err != nil {
+		t.Errorf("unbound cookie refused when not strict: %s", err)
+	}
+}
+
+func TestTLSExporterSessionBinding(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.Config.Base.SessionBinding.Mode = sessionBindingTLSExporter
+	state.Config.Base.SessionBinding.Strict = true
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/v0/login" {
+				if _, err := state.setNewAuthCookie(w, r, "username",
+					AuthTypeU2F); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+			state.checkAuth(w, r, AuthTypeAny)
+		}))
+	defer server.Close()
+	client := server.Client()
+	resp, err := client.Get(server.URL + "/api/v0/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != authCookieName {
+		t.Fatalf("unexpected cookies: %v", cookies)
+	}
+	get := func(client *http.Client) int {
+		req, err := http.NewRequest("GET", server.URL+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(cookies[0])
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := get(client); status != http.StatusOK {
+		t.Fatalf("cookie refused on the same connection: %d", status)
+	}
+	otherClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: client.Transport.(*http.Transport).TLSClientConfig}}
+	if status := get(otherClient); status != http.StatusUnauthorized {
+		t.Errorf("cookie accepted on another connection: %d", status)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/sessions"
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+)
+
+// Each auth cookie is a session recorded in the data directory: the JWT of
+// the cookie carries the ID of its session and is refused once the session
+// is revoked, by logging out, by the user from another browser or by an
+// admin. Browsers authenticating with basic auth get a session too, so that
+// they are not asked for the password again.
+const (
+	sessionsFilename              = "sessions"
+	maxSessionLifetimeSecs        = 7 * 86400
+	sessionsPath                  = "/api/v0/sessions"
+	adminSessionsPath             = "/sessions"
+	adminSocketRevokeSessionsPath = "/revokeSessions"
+)
+
+var errNoSession = errors.New("auth cookie has no session")
+
+type SessionsConfig struct {
+	// Default: 57600 (16 hours), at most 7 days.
+	LifetimeSecs uint `yaml:"lifetime_secs"`
+	// The SameSite attribute of the cookies: "lax" (the default) or
+	// "strict". Strict cookies are not sent when following a link to
+	// keymaster from another site.
+	SameSite string `yaml:"same_site"`
+}
+
+// sessionInfo is a session as reported to users and admins.
+type sessionInfo struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Current    bool      `json:"current,omitempty"`
+}
+
+func (config SessionsConfig) check() error {
+	if config.LifetimeSecs > maxSessionLifetimeSecs {
+		return fmt.Errorf("sessions: lifetime_secs above %d",
+			maxSessionLifetimeSecs)
+	}
+	switch config.SameSite {
+	case "", "lax", "strict":
+	default:
+		return fmt.Errorf("sessions: unknown same_site: %q", config.SameSite)
+	}
+	return nil
+}
+
+func (config SessionsConfig) lifetime() time.Duration {
+	if config.LifetimeSecs == 0 {
+		return maxAgeSecondsAuthCookie * time.Second
+	}
+	return time.Duration(config.LifetimeSecs) * time.Second
+}
+
+func (config SessionsConfig) sameSite() http.SameSite {
+	if config.SameSite == "strict" {
+		return http.SameSiteStrictMode
+	}
+	return http.SameSiteLaxMode
+}
+
+// newAuthCookie returns the auth cookie with value, with the attributes of
+// the configuration.
+func (state *RuntimeState) newAuthCookie(value string,
+	expires time.Time) http.Cookie {
+	return http.Cookie{Name: authCookieName, Value: value, Expires: expires,
+		Path: "/", HttpOnly: true, Secure: true,
+		SameSite: state.Config.Base.Sessions.sameSite()}
+}
+
+// newSession records a session of username logging in with r, which may be
+// nil.
+func (state *RuntimeState) newSession(r *http.Request,
+	username string) (sessions.Session, error) {
+	var remoteAddr, userAgent string
+	if r != nil {
+		remoteAddr = r.RemoteAddr
+		userAgent = r.UserAgent()
+	}
+	session, err := state.sessions.Create(username,
+		time.Now().Add(state.Config.Base.Sessions.lifetime()), remoteAddr,
+		userAgent)
+	if err != nil {
+		return session, err
+	}
+	if err := state.shareSession(session.ID); err != nil {
+		return sessions.Session{}, err
+	}
+	return session, nil
+}
+
+// revokeSession revokes the session id, on all the instances of a cluster.
+func (state *RuntimeState) revokeSession(id string) (bool, error) {
+	revoked, err := state.sessions.Revoke(id)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := state.sessions.Get(id); ok {
+		return revoked, state.shareSession(id)
+	}
+	return revoked, nil
+}
+
+// revokeUserSessions revokes the sessions of username, on all the instances
+// of a cluster, and returns how many.
+func (state *RuntimeState) revokeUserSessions(username string) (int, error) {
+	valid := state.sessions.UserSessions(username, time.Now())
+	count, err := state.sessions.RevokeUser(username)
+	if err != nil {
+		return count, err
+	}
+	for _, session := range valid {
+		if err := state.shareSession(session.ID); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// checkSession returns an error if the session of info is not valid.
+// Cookies without a session predate the session records.
+func (state *RuntimeState) checkSession(info authInfo) error {
+	if state.sessions == nil {
+		return nil
+	}
+	if info.SessionID == "" {
+		return errNoSession
+	}
+	if _, ok := state.sessions.Get(info.SessionID); !ok {
+		if err := state.lookupClusterSession(info.SessionID); err != nil {
+			logger.Errorf("Cannot look up session %s in the cluster: %s",
+				info.SessionID, err)
+		}
+	}
+	if !state.sessions.IsValid(info.SessionID, time.Now()) {
+		logger.Debugf(1, "refusing auth cookie of %s: session %s revoked",
+			info.Username, info.SessionID)
+		return errors.New("session revoked")
+	}
+	return nil
+}
+
+// revokeCookieSession revokes the session of authCookie, if any.
+func (state *RuntimeState) revokeCookieSession(authCookie *http.Cookie) {
+	info, err := state.getAuthInfoFromAuthJWT(authCookie.Value)
+	if err != nil || info.SessionID == "" || state.sessions == nil {
+		return
+	}
+	if _, err := state.revokeSession(info.SessionID); err != nil {
+		logger.Errorf("Cannot revoke session of %s: %s", info.Username, err)
+	}
+}
+
+// setBrowserSessionCookie starts a session for username after a successful
+// basic auth from a browser.
+func (state *RuntimeState) setBrowserSessionCookie(w http.ResponseWriter,
+	r *http.Request, username string) {
+	if w == nil || getPreferredAcceptType(r) != "text/html" {
+		return
+	}
+	if _, err := state.setNewAuthCookie(w, r, username,
+		AuthTypePassword); err != nil {
+		requestLogger(r).Errorf("Cannot start session of %s: %s", username, err)
+	}
+}
+
+// currentSessionID returns the ID of the session of the auth cookie of r.
+func (state *RuntimeState) currentSessionID(r *http.Request) string {
+	authCookie, err := r.Cookie(authCookieName)
+	if err != nil {
+		return ""
+	}
+	info, err := state.getAuthInfoFromAuthJWT(authCookie.Value)
+	if err != nil {
+		return ""
+	}
+	return info.SessionID
+}
+
+func (state *RuntimeState) userSessionInfos(username,
+	currentID string) []sessionInfo {
+	infos := []sessionInfo{}
+	for _, session := range state.sessions.UserSessions(username,
+		time.Now()) {
+		infos = append(infos, sessionInfo{
+			ID:         session.ID,
+			Username:   session.Username,
+			Created:    session.Created,
+			Expires:    session.Expires,
+			RemoteAddr: session.RemoteAddr,
+			UserAgent:  session.UserAgent,
+			Current:    session.ID == currentID,
+		})
+	}
+	return infos
+}
+
+// sessionsHandler lists the sessions of the user with a GET. A DELETE
+// revokes the session given by the id parameter, or all of them without it.
+func (state *RuntimeState) sessionsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if r.Method != "GET" && r.Method != "DELETE" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	authUser, _, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		requestLogger(r).Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	currentID := state.currentSessionID(r)
+	if r.Method == "DELETE" {
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			count, err := state.revokeUserSessions(authUser)
+			if err != nil {
+				requestLogger(r).Errorf("Cannot revoke sessions of %s: %s", authUser, err)
+				state.writeFailureResponse(w, r,
+					http.StatusInternalServerError, "")
+				return
+			}
+			requestLogger(r).Printf("%s revoked their %d sessions", authUser, count)
+		} else {
+			found := false
+			for _, info := range state.userSessionInfos(authUser, "") {
+				if info.ID == id {
+					found = true
+				}
+			}
+			if !found {
+				state.writeFailureResponse(w, r, http.StatusNotFound,
+					"No such session")
+				return
+			}
+			if _, err := state.revokeSession(id); err != nil {
+				requestLogger(r).Errorf("Cannot revoke session of %s: %s", authUser, err)
+				state.writeFailureResponse(w, r,
+					http.StatusInternalServerError, "")
+				return
+			}
+			requestLogger(r).Printf("%s revoked their session %s", authUser, id)
+		}
+	}
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(state.userSessionInfos(authUser, currentID))
+}
+
+// adminSessionsHandler lists the sessions of the user parameter.
+func (state *RuntimeState) adminSessionsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	username := r.URL.Query().Get("user")
+	if username == "" {
+		http.Error(w, "Missing user", http.StatusBadRequest)
+		return
+	}
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state.userSessionInfos(username, ""))
+}
+
+func (state *RuntimeState) adminRevokeSessionsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	username := r.FormValue("user")
+	if username == "" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Missing user")
+		return
+	}
+	if !state.Config.Base.DisableUsernameNormalization {
+		username = strings.ToLower(username)
+	}
+	count, err := state.revokeUserSessions(username)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError,
+			err.Error())
+		return
+	}
+	requestLogger(r).Printf("Revoked %d sessions of %s", count, username)
+	fmt.Fprintf(w, "Revoked %d sessions of %s\n", count, username)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/sessions"
+)
+
+func TestSessionsConfigCheck(t *testing.T) {
+	for _, config := range []SessionsConfig{
+		{},
+		{LifetimeSecs: 3600, SameSite: "strict"},
+		{SameSite: "lax"},
+	} {
+		if err := config.check(); err != nil {
+			t.Errorf("%+v: %s", config, err)
+		}
+	}
+	for _, config := range []SessionsConfig{
+		{LifetimeSecs: maxSessionLifetimeSecs + 1},
+		{SameSite: "none"},
+	} {
+		if err := config.check(); err == nil {
+			t.Errorf("%+v: accepted", config)
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.sessions, err = sessions.Open(filepath.Join(dir, sessionsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.Sessions.LifetimeSecs = 3600
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{"password"}
+	login := func(username string) *http.Cookie {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/v0/login", nil)
+		if _, err := state.setNewAuthCookie(rr, req, username,
+			AuthTypePassword); err != nil {
+			t.Fatal(err)
+		}
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == authCookieName {
+				return cookie
+			}
+		}
+		t.Fatal("no auth cookie")
+		return nil
+	}
+	cookie := login("username")
+	if !cookie.HttpOnly || !cookie.Secure ||
+		cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("unexpected cookie attributes: %+v", cookie)
+	}
+	if lifetime := time.Until(cookie.Expires); lifetime > time.Hour ||
+		lifetime < 59*time.Minute {
+		t.Errorf("unexpected cookie lifetime: %s", lifetime)
+	}
+	otherCookie := login("username")
+	strangerCookie := login("stranger")
+	stranger, err := state.checkAuthCookie(nil, strangerCookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, target string, cookie *http.Cookie,
+		expectedStatus int) string {
+		t.Helper()
+		req := httptest.NewRequest(method, target, nil)
+		req.AddCookie(cookie)
+		rr, err := checkRequestHandlerCode(req, state.sessionsHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr.Body.String()
+	}
+	body := request("GET", sessionsPath, cookie, http.StatusOK)
+	if strings.Count(body, `"id"`) != 2 ||
+		strings.Count(body, `"current":true`) != 1 {
+		t.Errorf("unexpected sessions: %s", body)
+	}
+	request("DELETE", sessionsPath+"?id="+stranger.SessionID, cookie,
+		http.StatusNotFound)
+	info, err := state.checkAuthCookie(nil, otherCookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = request("DELETE", sessionsPath+"?id="+info.SessionID, cookie,
+		http.StatusOK)
+	if strings.Count(body, `"id"`) != 1 {
+		t.Errorf("session not revoked: %s", body)
+	}
+	request("GET", sessionsPath, otherCookie, http.StatusUnauthorized)
+
+	// Logging out revokes the session even if the cookie was kept.
+	req := httptest.NewRequest("GET", logoutPath, nil)
+	req.AddCookie(cookie)
+	if _, err := checkRequestHandlerCode(req, state.logoutHandler,
+		http.StatusFound); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.checkAuthCookie(nil, cookie); err == nil {
+		t.Error("session still valid after logout")
+	}
+
+	// Cookies without a session are refused.
+	value, err := state.genNewSerializedAuthJWT("username", AuthTypePassword,
+		"", "", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.checkAuthCookie(nil, &http.Cookie{
+		Name: authCookieName, Value: value}); err != errNoSession {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Browsers using basic auth get a session.
+	req = httptest.NewRequest("GET", sessionsPath, nil)
+	req.SetBasicAuth("username", "password")
+	req.Header.Set("Accept", "text/html")
+	rr, err := checkRequestHandlerCode(req, state.sessionsHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var browserCookie *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == authCookieName {
+			browserCookie = cookie
+		}
+	}
+	if browserCookie == nil {
+		t.Fatal("no session for basic auth")
+	}
+	if _, err := state.checkAuthCookie(nil, browserCookie); err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest("POST", adminSocketRevokeSessionsPath,
+		strings.NewReader("user=username"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	state.adminRevokeSessionsHandler(rr, req)
+	if !strings.Contains(rr.Body.String(), "Revoked 1 sessions") {
+		t.Errorf("unexpected response: %s", rr.Body.String())
+	}
+	if _, err := state.checkAuthCookie(nil, browserCookie); err == nil {
+		t.Error("session still valid after revocation")
+	}
+	if _, err := state.checkAuthCookie(nil, strangerCookie); err != nil {
+		t.Errorf("session of another user revoked: %s", err)
+	}
+	method, path, values, err := adminSocketRequest([]string{
+		"revoke-sessions", "username"})
+	if err != nil || method != "POST" ||
+		path != adminSocketRevokeSessionsPath ||
+		values.Get("user") != "username" {
+		t.Errorf("unexpected admin request: %s %s %v %v", method, path,
+			values, err)
+	}
+}
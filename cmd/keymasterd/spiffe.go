@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/Symantec/keymaster/lib/certlint"
+)
+
+// The spiffe-svid certificate type issues X.509 SVIDs for SPIFFE based mTLS.
+// Users get spiffe://<trust domain>/user/<username>, and hosts of the
+// inventory allowed the profile, authenticated with their IP restricted
+// certificate, spiffe://<trust domain>/host/<identity>.
+const (
+	certTypeSPIFFESVID           = "spiffe-svid"
+	defaultSPIFFEMaxDurationSecs = 3600
+)
+
+type SPIFFEConfig struct {
+	// SVIDs are not issued without a trust domain.
+	TrustDomain string `yaml:"trust_domain"`
+	// Default: 3600, at most 86400.
+	MaxCertDurationSecs uint `yaml:"max_cert_duration_secs"`
+}
+
+func (config *SPIFFEConfig) maxCertDuration() time.Duration {
+	if config.MaxCertDurationSecs == 0 {
+		return defaultSPIFFEMaxDurationSecs * time.Second
+	}
+	return time.Duration(config.MaxCertDurationSecs) * time.Second
+}
+
+func (config *SPIFFEConfig) check() error {
+	if config.TrustDomain != "" {
+		if _, err := certgen.SPIFFEID(config.TrustDomain, "user"); err != nil {
+			return fmt.Errorf("spiffe: %s", err)
+		}
+	}
+	if config.maxCertDuration() > maxCertDuration {
+		return fmt.Errorf("spiffe: max_cert_duration_secs above %d",
+			int(maxCertDuration.Seconds()))
+	}
+	return nil
+}
+
+// spiffeIDPath returns the path segments of the SPIFFE ID of targetUser.
+func (state *RuntimeState) spiffeIDPath(targetUser string,
+	authLevel int) ([]string, error) {
+	if (authLevel & AuthTypeIPCertificate) != AuthTypeIPCertificate {
+		return []string{"user", targetUser}, nil
+	}
+	host, ok := state.lookupHost(targetUser)
+	if !ok {
+		return []string{"user", targetUser}, nil
+	}
+	if !host.AllowsProfile(certTypeSPIFFESVID) {
+		return nil, fmt.Errorf("host %s not allowed to request %s", targetUser,
+			certTypeSPIFFESVID)
+	}
+	return []string{"host", targetUser}, nil
+}
+
+// postAuthSPIFFESVIDHandler issues an X.509 SVID for the PEM public key in
+// the form.
+func (state *RuntimeState) postAuthSPIFFESVIDHandler(
+	w http.ResponseWriter, r *http.Request, targetUser string,
+	keySigner crypto.Signer, duration time.Duration, authLevel int) {
+	if r.Method != "POST" && r.Method != "PUT" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	config := state.Config.SPIFFE
+	if config.TrustDomain == "" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"SPIFFE issuance not configured")
+		return
+	}
+	duration, err := capCertDuration(r, duration, config.maxCertDuration())
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	idPath, err := state.spiffeIDPath(targetUser, authLevel)
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Host not allowed to request this certificate")
+		return
+	}
+	spiffeID, err := certgen.SPIFFEID(config.TrustDomain, idPath...)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	pubKeyData, err := getPublicKeyDataFromForm(r)
+	if err != nil {
+		requestLogger(r).Errorf("%s", err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Missing public key file")
+		return
+	}
+	block, _ := pem.Decode(pubKeyData)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid File, Unable to decode pem")
+		return
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Cannot parse public key")
+		return
+	}
+	if err := state.Config.KeyPolicy.checkPublicKey(pub); err != nil {
+		state.writeKeyPolicyError(w, r, err)
+		return
+	}
+	caCert, caChainPEM, err := state.x509Issuer(keySigner)
+	if err != nil {
+		state.writeFailureResponse(w, r, caKeyErrorStatus(err), "")
+		requestLogger(r).Errorf("%s", err)
+		return
+	}
+	signingSpan := startSigningSpan(r, certTypeSPIFFESVID)
+	derCert, err := certgen.GenSPIFFEX509SVID(spiffeID, targetUser, pub,
+		caCert, keySigner, duration, state.x509CertOptions(caCert))
+	endSpan(signingSpan, err)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		requestLogger(r).Errorf("Cannot Generate SVID: %s", err)
+		return
+	}
+	err = state.lintIssuedX509Cert(certTypeSPIFFESVID, targetUser, derCert,
+		certlint.X509Profile{
+			CA:          caCert,
+			MaxLifetime: duration,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth,
+				x509.ExtKeyUsageClientAuth},
+			CommonName: targetUser,
+		})
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	err = state.recordIssuedX509Cert(requestID(r), certTypeSPIFFESVID, derCert)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	eventNotifier.PublishX509(derCert)
+	metricLogCertDuration(certTypeSPIFFESVID, "granted",
+		float64(duration.Seconds()))
+	state.recordIssuedCertBy(targetUser, delegatedRequester(r), requestID(r),
+		certTypeSPIFFESVID, describePublicKey(pub), sshCertRecord{},
+		authLevel, duration)
+	state.emailIssuance(r, targetUser, certTypeSPIFFESVID,
+		publicKeyFingerprint(pub))
+
+	w.Header().Set("Content-Disposition", `attachment; filename="svid.pem"`)
+	w.WriteHeader(200)
+	pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: derCert})
+	w.Write(caChainPEM)
+	requestLogger(r).Printf("Generated SVID %s for %s%s", spiffeID, targetUser,
+		requestedBySuffix(r))
+	go func(username string, certType string) {
+		metricsMutex.Lock()
+		defer metricsMutex.Unlock()
+		certGenCounter.WithLabelValues(username, certType).Inc()
+	}(targetUser, certTypeSPIFFESVID)
+}
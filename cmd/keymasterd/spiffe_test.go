@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSPIFFESVID(t *testing.T) {
+	state, pubKeyPEM, cleanup := setupHostCertTest(t)
+	defer cleanup()
+	handler := func(targetUser string, authLevel int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			state.postAuthSPIFFESVIDHandler(w, r, targetUser, state.Signer,
+				maxCertDuration, authLevel)
+		}
+	}
+	issue := func(targetUser string, authLevel int) *x509.Certificate {
+		req, err := createHostCertRequestForHost(targetUser, pubKeyPEM, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := checkRequestHandlerCode(req, handler(targetUser, authLevel),
+			http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(rr.Body.Bytes())
+		if block == nil {
+			t.Fatal("no certificate returned")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	req, err := createHostCertRequestForHost("username", pubKeyPEM, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, handler("username", AuthTypeU2F),
+		http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state.Config.SPIFFE.TrustDomain = "example.org"
+	cert := issue("username", AuthTypeU2F)
+	if len(cert.URIs) != 1 ||
+		cert.URIs[0].String() != "spiffe://example.org/user/username" {
+		t.Errorf("unexpected URI SANs: %v", cert.URIs)
+	}
+	if lifetime := cert.NotAfter.Sub(cert.NotBefore); lifetime != time.Hour {
+		t.Errorf("unexpected lifetime: %s", lifetime)
+	}
+	cert = issue("relay1", AuthTypeIPCertificate)
+	if len(cert.URIs) != 1 ||
+		cert.URIs[0].String() != "spiffe://example.org/host/relay1" {
+		t.Errorf("unexpected URI SANs: %v", cert.URIs)
+	}
+	// Without an IP restricted certificate a host name is just a user name.
+	cert = issue("relay1", AuthTypeU2F)
+	if cert.URIs[0].String() != "spiffe://example.org/user/relay1" {
+		t.Errorf("unexpected URI SANs: %v", cert.URIs)
+	}
+
+	req, err = createHostCertRequestForHost("gateway1", pubKeyPEM, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req,
+		handler("gateway1", AuthTypeIPCertificate), http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = createHostCertRequestForHost("user@example.org", pubKeyPEM, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req,
+		handler("user@example.org", AuthTypeU2F), http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSPIFFEConfigCheck(t *testing.T) {
+	for _, config := range []SPIFFEConfig{
+		{},
+		{TrustDomain: "example.org", MaxCertDurationSecs: 600},
+	} {
+		if err := config.check(); err != nil {
+			t.Errorf("%+v: %s", config, err)
+		}
+	}
+	for _, config := range []SPIFFEConfig{
+		{TrustDomain: "Example.org"},
+		{TrustDomain: "example.org/x"},
+		{TrustDomain: "example.org", MaxCertDurationSecs: 2 * 86400},
+	} {
+		if err := config.check(); err == nil {
+			t.Errorf("no error for %+v", config)
+		}
+	}
+}
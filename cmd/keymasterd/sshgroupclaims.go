@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+)
+
+const defaultSSHGroupClaimMaxBytes = 1024
+
+// sshGroupClaimPolicy selects the groups claimed in SSH certificates.
+type sshGroupClaimPolicy struct {
+	include  []*regexp.Regexp
+	exclude  []*regexp.Regexp
+	maxBytes int
+}
+
+func compileGroupPatterns(name string, patterns []string) ([]*regexp.Regexp,
+	error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("ssh_group_claims %s: %s", name, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// newSSHGroupClaimPolicy returns nil if group claims are disabled.
+func newSSHGroupClaimPolicy(config *AppConfigFile) (*sshGroupClaimPolicy,
+	error) {
+	claimsConfig := config.SSHGroupClaims
+	if !claimsConfig.Enabled {
+		return nil, nil
+	}
+	if config.UserInfo.Ldap.LDAPTargetURLs == "" {
+		return nil, errors.New(
+			"ssh_group_claims requires an LDAP directory in userinfo_sources")
+	}
+	if claimsConfig.MaxBytes < 0 {
+		return nil, fmt.Errorf("invalid ssh_group_claims max_bytes: %d",
+			claimsConfig.MaxBytes)
+	}
+	policy := &sshGroupClaimPolicy{maxBytes: claimsConfig.MaxBytes}
+	if policy.maxBytes == 0 {
+		policy.maxBytes = defaultSSHGroupClaimMaxBytes
+	}
+	var err error
+	policy.include, err = compileGroupPatterns("include_groups",
+		claimsConfig.IncludeGroups)
+	if err != nil {
+		return nil, err
+	}
+	policy.exclude, err = compileGroupPatterns("exclude_groups",
+		claimsConfig.ExcludeGroups)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func matchesAnyGroupPattern(group string, patterns []*regexp.Regexp) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the groups matching an include pattern, or all if there
+// are none, and no exclude pattern.
+func (p *sshGroupClaimPolicy) filter(groups []string) []string {
+	var filtered []string
+	for _, group := range groups {
+		if len(p.include) > 0 && !matchesAnyGroupPattern(group, p.include) {
+			continue
+		}
+		if matchesAnyGroupPattern(group, p.exclude) {
+			continue
+		}
+		filtered = append(filtered, group)
+	}
+	return filtered
+}
+
+// sshGroupClaimExtensions returns the group claim for username, or nil if
+// claims are disabled. If the directory cannot be reached the certificate is
+// issued without a claim, which hosts treat as no memberships.
+func (state *RuntimeState) sshGroupClaimExtensions(
+	username string) map[string]string {
+	policy := state.sshGroupClaims
+	if policy == nil {
+		return nil
+	}
+	groups, err := state.getUserGroups(username)
+	if err != nil {
+		logger.Printf("Issuing ssh cert for %s without group claim: %s",
+			username, err)
+		return nil
+	}
+	extensions := certgen.SSHGroupClaimExtensions(policy.filter(groups),
+		policy.maxBytes)
+	if _, truncated := extensions[certgen.SSHGroupsTruncatedExtension]; truncated {
+		logger.Printf("Group claim for %s truncated to %d bytes", username,
+			policy.maxBytes)
+	}
+	return extensions
+}
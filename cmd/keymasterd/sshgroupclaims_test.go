@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewSSHGroupClaimPolicy(t *testing.T) {
+	var config AppConfigFile
+	policy, err := newSSHGroupClaimPolicy(&config)
+	if err != nil || policy != nil {
+		t.Fatalf("disabled claims: %v %v", policy, err)
+	}
+	config.SSHGroupClaims.Enabled = true
+	if _, err := newSSHGroupClaimPolicy(&config); err == nil {
+		t.Error("claims enabled without a userinfo directory")
+	}
+	config.UserInfo.Ldap.LDAPTargetURLs = "ldaps://ldap.example.com"
+	config.SSHGroupClaims.IncludeGroups = []string{"("}
+	if _, err := newSSHGroupClaimPolicy(&config); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+	config.SSHGroupClaims.IncludeGroups = []string{"unix-.*", "admins"}
+	config.SSHGroupClaims.ExcludeGroups = []string{"unix-secret"}
+	policy, err = newSSHGroupClaimPolicy(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.maxBytes != defaultSSHGroupClaimMaxBytes {
+		t.Errorf("max bytes is %d", policy.maxBytes)
+	}
+	filtered := policy.filter([]string{"unix-web", "unix-secret", "admins",
+		"admins-old", "mail"})
+	if !reflect.DeepEqual(filtered, []string{"unix-web", "admins"}) {
+		t.Errorf("unexpected groups %v", filtered)
+	}
+}
+
+func TestSSHGroupClaimExtensionsDisabled(t *testing.T) {
+	var state RuntimeState
+	if extensions := state.sshGroupClaimExtensions("username"); extensions != nil {
+		t.Errorf("claim issued while disabled: %v", extensions)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+)
+
+// sshKeyIDData is what ssh_key_id_template is executed with.
+type sshKeyIDData struct {
+	HostIdentity string
+	// Username is the user the certificate is for.
+	Username string
+	// Requester is the user who requested a delegated certificate, or
+	// empty.
+	Requester   string
+	RequesterIP string
+	// Time is the issue time and Timestamp the same in RFC3339 UTC.
+	Time      time.Time
+	Timestamp string
+	Serial    uint64
+	// AuthMethod lists the authentication methods of the requester, e.g.
+	// "password+U2F".
+	AuthMethod string
+}
+
+// sshKeyIDTemplate is a parsed ssh_key_id_template.
+type sshKeyIDTemplate struct {
+	template *template.Template
+}
+
+// parseSSHKeyIDTemplate parses the template text and tries it, so that
+// references to unknown fields are reported with the configuration.
+func parseSSHKeyIDTemplate(text string) (*sshKeyIDTemplate, error) {
+	tmpl, err := template.New("ssh_key_id_template").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	keyIDTemplate := &sshKeyIDTemplate{tmpl}
+	keyID, err := keyIDTemplate.execute(sshKeyIDData{
+		HostIdentity: "keymaster",
+		Username:     "username",
+		RequesterIP:  "192.0.2.1",
+		Time:         time.Now(),
+		Serial:       1,
+		AuthMethod:   "password",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if keyID == "" {
+		return nil, errors.New("ssh_key_id_template: empty key ID")
+	}
+	return keyIDTemplate, nil
+}
+
+func (t *sshKeyIDTemplate) execute(data sshKeyIDData) (string, error) {
+	data.Timestamp = data.Time.UTC().Format(time.RFC3339)
+	var buffer bytes.Buffer
+	if err := t.template.Execute(&buffer, data); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// setSSHCertIdentity sets the issue time, serial and key ID of options for
+// a certificate for targetUser requested in r. The key ID is
+// <host_identity>_<username>, with _by_<requester> for delegated
+// certificates, unless ssh_key_id_template is configured.
+func (state *RuntimeState) setSSHCertIdentity(r *http.Request,
+	targetUser string, authLevel int, options *certgen.SSHCertOptions) error {
+	return state.setSSHCertIdentityForMethod(r, targetUser,
+		strings.Join(authLevelToMethods(authLevel), "+"), options)
+}
+
+// setSSHCertIdentityForMethod is setSSHCertIdentity for requesters which
+// authenticated with authMethod, such as CI jobs.
+func (state *RuntimeState) setSSHCertIdentityForMethod(r *http.Request,
+	targetUser string, authMethod string,
+	options *certgen.SSHCertOptions) error {
+	now := time.Now()
+	serial, err := state.newSSHCertSerial(now)
+	if err != nil {
+		return err
+	}
+	options.ValidAfter = now
+	options.Serial = serial
+	requester := delegatedRequester(r)
+	if state.sshKeyIDTemplate == nil {
+		options.KeyID = state.HostIdentity + "_" + targetUser
+		if requester != "" {
+			options.KeyID += "_by_" + requester
+		}
+		return nil
+	}
+	options.KeyID, err = state.sshKeyIDTemplate.execute(sshKeyIDData{
+		HostIdentity: state.HostIdentity,
+		Username:     targetUser,
+		Requester:    requester,
+		RequesterIP:  loginThrottleAddress(r),
+		Time:         now,
+		Serial:       serial,
+		AuthMethod:   authMethod,
+	})
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseSSHKeyIDTemplate(t *testing.T) {
+	if _, err := parseSSHKeyIDTemplate("{{.Username}}@{{.Serial}}"); err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"{{.Username", "{{.Hostname}}",
+		"{{.Requester}}"} {
+		if _, err := parseSSHKeyIDTemplate(text); err == nil {
+			t.Errorf("%q accepted", text)
+		}
+	}
+}
+
+func TestSSHKeyIDTemplate(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "sshkeyid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.attestationLog, err = attestation.Open(filepath.Join(dir,
+		attestationLogFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.sshKeyIDTemplate, err = parseSSHKeyIDTemplate(
+		"{{.Username}} from {{.RequesterIP}} with {{.AuthMethod}} serial {{.Serial}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := createKeyBodyRequest("POST", certgenPath+"username",
+		testUserSSHPublicKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.7:40000"
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username",
+		AuthTypePassword|AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := pubKey.(*ssh.Certificate)
+	serial := strconv.FormatUint(cert.Serial, 10)
+	expected := "username from 192.0.2.7 with password+U2F serial " + serial
+	if cert.KeyId != expected {
+		t.Errorf("key ID %q, expected %q", cert.KeyId, expected)
+	}
+	events, err := state.attestationLog.Events(time.Time{},
+		time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].KeyID != cert.KeyId ||
+		events[0].Serial != serial {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/Symantec/keymaster/keymasterd/tracing"
+	"github.com/Symantec/keymaster/lib/authutil"
+)
+
+const defaultSSHPublicKeyAttribute = "sshPublicKey"
+
+// getLDAPUserAttributes is a variable so that tests need no directory.
+var getLDAPUserAttributes = authutil.GetLDAPUserAttributesWithReferralsContext
+
+// ldapPubKeySource is the ldap public key source.
+type ldapPubKeySource struct {
+	state *RuntimeState
+}
+
+func (source ldapPubKeySource) PublicKeys(username string) ([]string, error) {
+	return source.state.getUserPubKeysFromLDAP(context.Background(), username)
+}
+
+func (source ldapPubKeySource) PublicKeysContext(ctx context.Context,
+	username string) ([]string, error) {
+	return source.state.getUserPubKeysFromLDAP(ctx, username)
+}
+
+func (ldapConfig *UserInfoLDAPSource) sshPublicKeyAttribute() string {
+	if ldapConfig.SSHPublicKeyAttribute == "" {
+		return defaultSSHPublicKeyAttribute
+	}
+	return ldapConfig.SSHPublicKeyAttribute
+}
+
+// getUserPubKeysFromLDAP implements the ldap public key source. It returns
+// the values of the ssh_public_key_attribute of the user's entry.
+func (state *RuntimeState) getUserPubKeysFromLDAP(ctx context.Context,
+	username string) ([]string, error) {
+	ldapConfig := state.Config.UserInfo.Ldap
+	if ldapConfig.LDAPTargetURLs == "" {
+		return nil, errors.New("no userinfo LDAP source configured")
+	}
+	attribute := ldapConfig.sshPublicKeyAttribute()
+	var timeoutSecs uint
+	timeoutSecs = 2
+	for _, ldapUrl := range strings.Split(ldapConfig.LDAPTargetURLs, ",") {
+		if len(ldapUrl) < 1 {
+			continue
+		}
+		u, err := authutil.ParseLDAPURL(ldapUrl)
+		if err != nil {
+			logger.Errorf("Failed to parse ldapurl '%s'", ldapUrl)
+			continue
+		}
+		_, span := tracing.StartChild(ctx, spanNameLDAPAttrs,
+			tracing.SpanKindClient)
+		span.SetAttribute("server.address", u.Host)
+		attributeMap, err := getLDAPUserAttributes(ctx, *u,
+			ldapConfig.BindUsername, ldapConfig.BindPassword,
+			timeoutSecs, nil, username,
+			ldapConfig.UserSearchBaseDNs, ldapConfig.UserSearchFilter,
+			[]string{attribute},
+			ldapConfig.Referrals.referralPolicy(ldapReferralBackendUserInfo))
+		endSpan(span, err)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logger.Debugf(1, "Cannot get %s of %s from %s: %s", attribute,
+				username, ldapUrl, err)
+			continue
+		}
+		return attributeMap[attribute], nil
+	}
+	return nil, errors.New("error getting the SSH public keys")
+}
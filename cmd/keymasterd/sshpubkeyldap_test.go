@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/authutil"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHCertBundleFromLDAP(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.Config.UserInfo.Ldap.LDAPTargetURLs = "ldaps://ldap.example.com"
+	state.Config.UserInfo.Ldap.SSHPublicKeyAttribute = "ldapPublicKey"
+	edKey := newTestEd25519AuthorizedKey(t)
+	defer func() {
+		getLDAPUserAttributes = authutil.GetLDAPUserAttributesWithReferralsContext
+	}()
+	getLDAPUserAttributes = func(ctx context.Context, u url.URL, bindDN string,
+		bindPassword string, timeoutSecs uint, rootCAs *x509.CertPool,
+		username string, userSearchBaseDNs []string, userSearchFilter string,
+		attributes []string, referrals authutil.LDAPReferralPolicy) (
+		map[string][]string, error) {
+		if len(attributes) != 1 || attributes[0] != "ldapPublicKey" {
+			t.Errorf("unexpected attributes %v", attributes)
+		}
+		return map[string][]string{
+			"ldapPublicKey": {testUserSSHPublicKey, edKey},
+		}, nil
+	}
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authCookie := http.Cookie{Name: authCookieName, Value: cookieVal}
+	req, err := http.NewRequest("GET",
+		"/certgen/username?type=ssh&pubkeySource=ldap", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&authCookie)
+	rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 certificates, got %d", len(lines))
+	}
+	for index, line := range lines {
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, ok := pubKey.(*ssh.Certificate)
+		if !ok {
+			t.Fatalf("line %d is not a certificate", index)
+		}
+		if len(cert.ValidPrincipals) != 1 ||
+			cert.ValidPrincipals[0] != "username" {
+			t.Errorf("unexpected principals %v", cert.ValidPrincipals)
+		}
+	}
+
+	req, err = http.NewRequest("GET",
+		"/certgen/username?type=ssh&pubkeySource=nis", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&authCookie)
+	_, err = checkRequestHandlerCode(req, state.certGenHandler,
+		http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
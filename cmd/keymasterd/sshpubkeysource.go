@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/Symantec/keymaster/lib/pubkeysource"
+	"golang.org/x/crypto/ssh"
+)
+
+// A GET of an SSH certificate signs every key of the user in the configured
+// ssh_public_key_source, or in the source named by the pubkeySource
+// parameter, and returns one certificate per line.
+const (
+	pubkeySourceSSSD           = "sssd"
+	pubkeySourceLDAP           = "ldap"
+	pubkeySourceDirectory      = "directory"
+	pubkeySourceURL            = "url"
+	maxSSHPublicKeysPerRequest = 32
+)
+
+// newSSHPublicKeySources returns the public key sources by name. sssd and
+// ldap are always available, authorized_keys if enabled, and the configured
+// source is the default.
+func (state *RuntimeState) newSSHPublicKeySources() (
+	map[string]pubkeysource.Source, error) {
+	config := state.Config.SSHKeySource
+	sources := map[string]pubkeysource.Source{
+		pubkeySourceSSSD: pubkeysource.NewCommand(
+			pubkeysource.DefaultSSSDCommand, nil),
+		pubkeySourceLDAP: ldapPubKeySource{state},
+	}
+	if config.AuthorizedKeys {
+		sources[pubkeySourceAuthorizedKeys] = staticKeysPubKeySource{state}
+	}
+	switch config.Type {
+	case "", pubkeySourceSSSD:
+		sources[pubkeySourceSSSD] = pubkeysource.NewCommand(config.Command,
+			config.Args)
+	case pubkeySourceLDAP:
+	case pubkeySourceAuthorizedKeys:
+		if !config.AuthorizedKeys {
+			return nil, errors.New(
+				"the authorized_keys source is not enabled")
+		}
+	case pubkeySourceDirectory:
+		if config.Directory == "" {
+			return nil, fmt.Errorf("no directory for the %s source",
+				config.Type)
+		}
+		sources[pubkeySourceDirectory] = pubkeysource.NewDirectory(
+			config.Directory)
+	case pubkeySourceURL:
+		source, err := pubkeysource.NewURL(config.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		sources[pubkeySourceURL] = source
+	default:
+		return nil, fmt.Errorf("unknown SSH public key source: %s",
+			config.Type)
+	}
+	return sources, nil
+}
+
+// getSSHPublicKeySource returns the public key source called name, or the
+// configured one if name is empty.
+func (state *RuntimeState) getSSHPublicKeySource(name string) (
+	pubkeysource.Source, error) {
+	if name == "" {
+		name = state.Config.SSHKeySource.Type
+	}
+	if name == "" {
+		name = pubkeySourceSSSD
+	}
+	sources := state.sshPublicKeySources
+	if sources == nil {
+		var err error
+		if sources, err = state.newSSHPublicKeySources(); err != nil {
+			return nil, err
+		}
+	}
+	source, ok := sources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown pubkeySource: %s", name)
+	}
+	return source, nil
+}
+
+// parseSourceSSHPublicKeys returns the distinct keys in values accepted by
+// policy, in authorized_keys format. Values holding certificates, rejected
+// keys or garbage are skipped, as a user cannot fix the source themselves.
+func parseSourceSSHPublicKeys(policy KeyPolicyConfig, username string,
+	values []string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, line := range strings.Split(value, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			pubKey, err := policy.checkSSHPublicKey(line)
+			if err != nil {
+				logger.Printf("Skipping SSH key for %s: %s", username, err)
+				continue
+			}
+			key := string(ssh.MarshalAuthorizedKey(pubKey))
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// postAuthSSHCertBundleHandler signs all the SSH public keys of targetUser
+// in source.
+func (state *RuntimeState) postAuthSSHCertBundleHandler(
+	w http.ResponseWriter, r *http.Request, targetUser string,
+	signer ssh.Signer, duration time.Duration, authLevel int,
+	source pubkeysource.Source) {
+	values, err := pubkeysource.PublicKeysContext(r.Context(), source,
+		targetUser)
+	if err == pubkeysource.ErrUserNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		requestLogger(r).Errorf("Cannot get SSH keys of %s: %s", targetUser, err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	userPubKeys := parseSourceSSHPublicKeys(state.Config.KeyPolicy,
+		targetUser, values)
+	if len(userPubKeys) < 1 {
+		http.NotFound(w, r)
+		return
+	}
+	if len(userPubKeys) > maxSSHPublicKeysPerRequest {
+		requestLogger(r).Printf("%s has %d SSH keys, signing the first %d",
+			targetUser, len(userPubKeys), maxSSHPublicKeysPerRequest)
+		userPubKeys = userPubKeys[:maxSSHPublicKeysPerRequest]
+	}
+	state.signSSHCertBundle(w, r, targetUser, signer, duration, authLevel,
+		userPubKeys)
+}
+
+// signSSHCertBundle signs userPubKeys and writes the certificates, one per
+// line, or as for a single upload if there is only one.
+func (state *RuntimeState) signSSHCertBundle(w http.ResponseWriter,
+	r *http.Request, targetUser string, signer ssh.Signer,
+	duration time.Duration, authLevel int, userPubKeys []string) {
+	options, restrictions := state.userSSHCertOptions(r, targetUser)
+	var certs []string
+	var allCertBytes [][]byte
+	var records []sshCertRecord
+	for _, userPubKey := range userPubKeys {
+		// Each certificate has its own serial and key ID.
+		err := state.setSSHCertIdentity(r, targetUser, authLevel, &options)
+		if err != nil {
+			requestLogger(r).Errorf("Cannot make SSH key ID for %s: %s", targetUser, err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		if err := state.checkSSHSigner(signer); err != nil {
+			requestLogger(r).Printf("%s", err)
+			state.writeFailureResponse(w, r, http.StatusServiceUnavailable, "")
+			return
+		}
+		signingSpan := startSigningSpan(r, "ssh")
+		cert, certBytes, err := certgen.GenSSHCertFileStringWithOptions(
+			targetUser, userPubKey, signer, state.HostIdentity, duration,
+			options)
+		endSpan(signingSpan, err)
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			requestLogger(r).Printf("signUserPubkey Err")
+			return
+		}
+		err = state.lintIssuedSSHCertForPrincipals(targetUser,
+			options.Principals, cert, signer.PublicKey(), duration)
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		certs = append(certs, cert)
+		allCertBytes = append(allCertBytes, certBytes)
+		records = append(records, sshCertRecord{
+			KeyID:        options.KeyID,
+			Serial:       options.Serial,
+			Restrictions: restrictions,
+		})
+	}
+	// Nothing is recorded unless the whole bundle is issued.
+	var keyFingerprints []string
+	for index, certBytes := range allCertBytes {
+		eventNotifier.PublishSSH(certBytes)
+		metricLogCertDuration("ssh", "granted", float64(duration.Seconds()))
+		state.recordIssuedCertBy(targetUser, delegatedRequester(r),
+			requestID(r), "ssh", describeSSHCertKey(certBytes), records[index],
+			authLevel, duration)
+		keyFingerprints = append(keyFingerprints,
+			sshCertKeyFingerprint(certBytes))
+		state.noteCertifiedStaticKey(r, targetUser, certBytes)
+	}
+	state.emailIssuance(r, targetUser, "ssh", keyFingerprints...)
+
+	// A single certificate is returned as for uploaded keys.
+	if len(certs) == 1 {
+		w.Header().Set("Content-Disposition",
+			`attachment; filename="id_rsa-cert.pub"`)
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "%s", certs[0])
+	} else {
+		w.Header().Set("Content-Disposition",
+			`attachment; filename="ssh-certs.pub"`)
+		w.WriteHeader(200)
+		for _, cert := range certs {
+			fmt.Fprintf(w, "%s\n", cert)
+		}
+	}
+	requestLogger(r).Printf("Generated %d SSH Certificates for %s", len(certs), targetUser)
+	go func(username string, certType string, count int) {
+		metricsMutex.Lock()
+		defer metricsMutex.Unlock()
+		certGenCounter.WithLabelValues(username, certType).Add(float64(count))
+	}(targetUser, "ssh", len(certs))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestEd25519AuthorizedKey(t *testing.T) string {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(ssh.MarshalAuthorizedKey(sshPublic))
+}
+
+func TestParseSourceSSHPublicKeys(t *testing.T) {
+	edKey := newTestEd25519AuthorizedKey(t)
+	keys := parseSourceSSHPublicKeys(KeyPolicyConfig{}, "username", []string{
+		testUserSSHPublicKey,
+		strings.TrimSpace(edKey) + " laptop\n# old key\n" + testUserSSHPublicKey,
+		"not a key",
+	})
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %v", keys)
+	}
+	if keys[1] != edKey {
+		t.Errorf("key %q, expected %q", keys[1], edKey)
+	}
+}
+
+func TestSSHCertFromDirectorySource(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "sshpubkeysource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	err = ioutil.WriteFile(filepath.Join(dir, "username.pub"),
+		[]byte(testUserSSHPublicKey), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.SSHKeySource.Type = pubkeySourceDirectory
+	state.Config.SSHKeySource.Directory = dir
+	state.sshPublicKeySources, err = state.newSSHPublicKeySources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"/certgen/username?type=ssh", http.StatusOK},
+		{"/certgen/username?type=ssh&pubkeySource=url", http.StatusBadRequest},
+	} {
+		req, err := http.NewRequest("GET", test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+			test.status)
+		if err != nil {
+			t.Fatalf("%s: %s", test.path, err)
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		// A single key is returned in the format of uploads.
+		if strings.Contains(rr.Body.String(), "\n") {
+			t.Errorf("unexpected body: %s", rr.Body.String())
+		}
+		if _, _, _, _, err := ssh.ParseAuthorizedKey(rr.Body.Bytes()); err != nil {
+			t.Error(err)
+		}
+	}
+	state.Config.SSHKeySource.Type = "nis"
+	if _, err := state.newSSHPublicKeySources(); err == nil {
+		t.Error("unknown source accepted")
+	}
+}
+
+func TestSigningMultipleUploadedSSHKeys(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey := newTestEd25519AuthorizedKey(t)
+	// One file with a key per line, and one part per key.
+	for _, parts := range [][]string{
+		{testUserSSHPublicKey + "\n" + edKey + testUserSSHPublicKey},
+		{testUserSSHPublicKey, edKey},
+	} {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for _, part := range parts {
+			fileWriter, err := writer.CreateFormFile("pubkeyfile", "id.pub")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fileWriter.Write([]byte(part))
+		}
+		writer.Close()
+		req, err := http.NewRequest("POST", "/certgen/username", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+			http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected 2 certificates, got %d", len(lines))
+		}
+		for _, line := range lines {
+			pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := pubKey.(*ssh.Certificate); !ok {
+				t.Fatal("not a certificate")
+			}
+		}
+	}
+	req, err := createKeyBodyRequest("POST", "/certgen/username",
+		testUserSSHPublicKey+"\nnot a key\n", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	_, err = checkRequestHandlerCode(req, state.certGenHandler,
+		http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+)
+
+const sshRestrictionsReportPath = "/sshRestrictionsReport"
+
+// sshRestrictionExtensions maps the restrictions, named like the
+// authorized_keys options, to the permissions they withhold.
+var sshRestrictionExtensions = map[string]string{
+	"no-agent-forwarding": "permit-agent-forwarding",
+	"no-port-forwarding":  "permit-port-forwarding",
+	"no-pty":              "permit-pty",
+	"no-user-rc":          "permit-user-rc",
+	"no-x11-forwarding":   "permit-X11-forwarding",
+}
+
+// SSHRestrictionTierConfig withholds permissions from the SSH certificates
+// of its users and the members of its groups. A tier without users and
+// groups applies to everyone.
+type SSHRestrictionTierConfig struct {
+	Name         string   `yaml:"name"`
+	Users        []string `yaml:"users"`
+	Groups       []string `yaml:"groups"`
+	Restrictions []string `yaml:"restrictions"`
+}
+
+// SSHRestrictionsConfig lists the tiers in order, the first matching tier
+// applies.
+type SSHRestrictionsConfig struct {
+	Tiers []SSHRestrictionTierConfig `yaml:"tiers"`
+}
+
+// sshRestrictions are the restrictions applied to a certificate.
+type sshRestrictions struct {
+	Tier         string
+	Restrictions []string
+}
+
+func sshRestrictionNames() []string {
+	names := make([]string, 0, len(sshRestrictionExtensions))
+	for name := range sshRestrictionExtensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (config *SSHRestrictionTierConfig) matchesEveryone() bool {
+	return len(config.Users) < 1 && len(config.Groups) < 1
+}
+
+func (config *SSHRestrictionsConfig) check() error {
+	names := make(map[string]struct{}, len(config.Tiers))
+	for index, tier := range config.Tiers {
+		if tier.Name == "" {
+			return fmt.Errorf("ssh_restrictions: tier %d has no name", index+1)
+		}
+		if _, ok := names[tier.Name]; ok {
+			return errors.New("ssh_restrictions: duplicate tier " + tier.Name)
+		}
+		names[tier.Name] = struct{}{}
+		for _, restriction := range tier.Restrictions {
+			if _, ok := sshRestrictionExtensions[restriction]; !ok {
+				return fmt.Errorf("ssh_restrictions: %s: unknown restriction %q",
+					tier.Name, restriction)
+			}
+		}
+		if tier.matchesEveryone() && index < len(config.Tiers)-1 {
+			return fmt.Errorf(
+				"ssh_restrictions: %s applies to everyone but is not last",
+				tier.Name)
+		}
+	}
+	return nil
+}
+
+// allRestrictions returns every restriction of some tier, which is applied
+// when the tier of a user cannot be determined.
+func (config *SSHRestrictionsConfig) allRestrictions() []string {
+	found := make(map[string]struct{})
+	var restrictions []string
+	for _, tier := range config.Tiers {
+		for _, restriction := range tier.Restrictions {
+			if _, ok := found[restriction]; !ok {
+				found[restriction] = struct{}{}
+				restrictions = append(restrictions, restriction)
+			}
+		}
+	}
+	sort.Strings(restrictions)
+	return restrictions
+}
+
+// sshRestrictionsFor returns the restrictions for the SSH certificates held
+// by username. If the groups of username cannot be looked up all
+// restrictions apply.
+func (state *RuntimeState) sshRestrictionsFor(r *http.Request,
+	username string) sshRestrictions {
+	config := &state.Config.SSHRestrictions
+	var groups []string
+	groupsLoaded := false
+	for _, tier := range config.Tiers {
+		matches := tier.matchesEveryone() ||
+			stringsIntersect([]string{username}, tier.Users)
+		if !matches && len(tier.Groups) > 0 {
+			if !groupsLoaded {
+				var err error
+				groups, err = state.getUserGroupsContext(r.Context(), username)
+				if err != nil {
+					requestLogger(r).Errorf(
+						"Cannot get groups of %s, applying all SSH restrictions: %s",
+						username, err)
+					return sshRestrictions{
+						Restrictions: config.allRestrictions()}
+				}
+				groupsLoaded = true
+			}
+			matches = stringsIntersect(groups, tier.Groups)
+		}
+		if matches {
+			return sshRestrictions{
+				Tier:         tier.Name,
+				Restrictions: tier.Restrictions,
+			}
+		}
+	}
+	return sshRestrictions{}
+}
+
+// apply removes the withheld permissions from extensions.
+func (restrictions sshRestrictions) apply(extensions map[string]string) {
+	for _, restriction := range restrictions.Restrictions {
+		delete(extensions, sshRestrictionExtensions[restriction])
+	}
+}
+
+// sshRestrictionsReportHandler is served on the admin port and reports which
+// fraction of the SSH certificates valid now carry each restriction.
+func (state *RuntimeState) sshRestrictionsReportHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	now := time.Now()
+	events, err := state.attestationLog.Events(time.Time{}, now)
+	if err != nil {
+		requestLogger(r).Errorf("Cannot read attestation log: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	report := attestation.SummarizeRestrictions(events, sshRestrictionNames(),
+		now)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
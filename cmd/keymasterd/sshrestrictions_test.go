@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHRestrictionsConfig(t *testing.T) {
+	config := SSHRestrictionsConfig{Tiers: []SSHRestrictionTierConfig{
+		{Name: "contractors", Groups: []string{"contractors"},
+			Restrictions: []string{"no-agent-forwarding", "no-pty"}},
+		{Name: "default", Restrictions: []string{"no-agent-forwarding"}},
+	}}
+	if err := config.check(); err != nil {
+		t.Fatal(err)
+	}
+	if all := config.allRestrictions(); len(all) != 2 {
+		t.Errorf("all restrictions: %v", all)
+	}
+	for name, tiers := range map[string][]SSHRestrictionTierConfig{
+		"no name": {{Restrictions: []string{"no-pty"}}},
+		"duplicate": {{Name: "a", Users: []string{"alice"}},
+			{Name: "a", Users: []string{"bob"}}},
+		"unknown restriction": {{Name: "a",
+			Restrictions: []string{"no-agent"}}},
+		"everyone not last": {{Name: "a"},
+			{Name: "b", Users: []string{"bob"}}},
+	} {
+		config := SSHRestrictionsConfig{Tiers: tiers}
+		if err := config.check(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestSSHRestrictionTiers(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "sshrestrictions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.attestationLog, err = attestation.Open(filepath.Join(dir,
+		attestationLogFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.SSHRestrictions.Tiers = []SSHRestrictionTierConfig{
+		{Name: "contractors", Users: []string{"contractor"},
+			Restrictions: []string{"no-agent-forwarding", "no-port-forwarding"}},
+		{Name: "default", Restrictions: []string{"no-agent-forwarding"}},
+	}
+	issue := func(username string) *ssh.Certificate {
+		req, err := createKeyBodyRequest("POST", certgenPath+username,
+			testUserSSHPublicKey, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookieVal, err := state.setNewAuthCookie(nil, nil, username,
+			AuthTypeU2F)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+		rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+			http.StatusOK)
+		if err != nil {
+			t.Fatalf("%s: %s", username, err)
+		}
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey(rr.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pubKey.(*ssh.Certificate)
+	}
+	cert := issue("contractor")
+	for _, extension := range []string{"permit-agent-forwarding",
+		"permit-port-forwarding"} {
+		if _, ok := cert.Permissions.Extensions[extension]; ok {
+			t.Errorf("contractor certificate has %s", extension)
+		}
+	}
+	if _, ok := cert.Permissions.Extensions["permit-pty"]; !ok {
+		t.Error("contractor certificate has no permit-pty")
+	}
+	cert = issue("username")
+	if _, ok := cert.Permissions.Extensions["permit-agent-forwarding"]; ok {
+		t.Error("default certificate has permit-agent-forwarding")
+	}
+	if _, ok := cert.Permissions.Extensions["permit-port-forwarding"]; !ok {
+		t.Error("default certificate has no permit-port-forwarding")
+	}
+
+	req, err := http.NewRequest("GET", sshRestrictionsReportPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req,
+		state.sshRestrictionsReportHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report attestation.RestrictionReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.ActiveCertificates != 2 ||
+		report.Restrictions["no-agent-forwarding"].Fraction != 1 ||
+		report.Restrictions["no-port-forwarding"].Count != 1 ||
+		report.Tiers["contractors"] != 1 || report.Tiers["default"] != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if _, ok := report.Restrictions["no-x11-forwarding"]; !ok {
+		t.Errorf("no-x11-forwarding missing: %+v", report)
+	}
+}
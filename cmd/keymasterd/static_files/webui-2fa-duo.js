@@ -0,0 +1,44 @@
+  var duoPoller;
+
+  function singleDuoPoll() {
+      var xhr = new XMLHttpRequest();
+      xhr.onreadystatechange = function() {
+          if (this.readyState != 4) {
+              return;
+          }
+          if (this.status == 200) {
+              clearInterval(duoPoller);
+              var destination = document.getElementById("duo_login_destination").innerHTML;
+              window.location.href = destination;
+          } else if (this.status != 412) {
+              clearInterval(duoPoller);
+              document.getElementById("duo_push_status").innerHTML = "Duo push failed";
+          }
+      };
+      xhr.open("GET", "/api/v0/duoPollCheck", true);
+      xhr.send();
+  }
+
+  function startDuoPush() {
+      var xhr = new XMLHttpRequest();
+      xhr.onreadystatechange = function() {
+          if (this.readyState != 4) {
+              return;
+          }
+          var status = document.getElementById("duo_push_status");
+          if (this.status == 200) {
+              status.innerHTML = "Push sent, approve it in the Duo app";
+              duoPoller = setInterval(singleDuoPoll, 3000);
+              setTimeout(clearInterval, 120000, duoPoller);
+              singleDuoPoll();
+          } else {
+              status.innerHTML = this.responseText;
+          }
+      };
+      xhr.open("POST", "/api/v0/duoPushStart", true);
+      xhr.send();
+  }
+
+document.addEventListener('DOMContentLoaded', function () {
+      document.getElementById('start_duo_push_button').addEventListener('click', startDuoPush, false);
+});
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/statickeys"
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/Symantec/keymaster/lib/sshsig"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+// Host agents report the keys in the authorized_keys files of their host
+// (see lib/authorizedkeys). Users are offered certificates for their static
+// keys on the certificate request page, and the admin port reports the
+// progress of each host. As root on any host can add keys to the files, a
+// key is only certified with a signature by it, and keys with options are
+// refused. Once certified that way keys are renewed by the authorized_keys
+// public key source, if enabled.
+const (
+	staticKeysPath             = "/staticKeys"
+	staticKeysDirectory        = "static_keys"
+	pubkeySourceAuthorizedKeys = "authorized_keys"
+	// The signed message is the CSRF token of the certificate request page.
+	staticKeySignatureNamespace  = "keymaster-static-key"
+	staticKeySignaturesFormField = "static_key_signatures"
+)
+
+// provenStaticKeysKey is the context key of the fingerprints of the static
+// keys whose possession was proven in a request.
+type provenStaticKeysKey struct{}
+
+type staticKeyDisplayInfo struct {
+	Fingerprint string
+	PublicKey   string
+	Comments    string
+	Hosts       string
+	Options     string
+	LastLogin   string
+	Certified   bool
+}
+
+// Host agents authenticate with their IP restricted certificate, and only
+// hosts in the inventory may report keys, or any host could add keys to the
+// list offered to a user.
+func (state *RuntimeState) staticKeysReportHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	authUser, _, err := state.checkAuth(w, r, AuthTypeIPCertificate)
+	if err != nil {
+		requestLogger(r).Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	if _, ok := state.lookupHost(authUser); !ok {
+		requestLogger(r).Printf("static keys report from %s, not in the host inventory",
+			authUser)
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Unknown host")
+		return
+	}
+
+	var report proto.StaticKeysReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		requestLogger(r).Debugf(1, "bad static keys report from %s: %s", authUser, err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid static keys report")
+		return
+	}
+	if err := state.staticKeys.Report(authUser, report,
+		time.Now()); err != nil {
+		requestLogger(r).Errorf("Cannot save static keys of %s: %s", authUser, err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	requestLogger(r).Debugf(2, "static keys report from %s: %d keys", authUser,
+		len(report.Keys))
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "OK\n")
+}
+
+// staticKeysHandler reports the hosts with static keys, or all the keys of
+// the host given by the host parameter.
+func (state *RuntimeState) staticKeysHandler(w http.ResponseWriter,
+	r *http.Request) {
+	var response interface{}
+	if host := r.URL.Query().Get("host"); host != "" {
+		report, ok := state.staticKeys.Host(host)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		response = report
+	} else {
+		response = state.staticKeys.Summaries()
+	}
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// staticKeysPubKeySource returns the static keys of a user reported by the
+// host agents which were certified with a signature before and have no
+// options.
+type staticKeysPubKeySource struct {
+	state *RuntimeState
+}
+
+func (source staticKeysPubKeySource) PublicKeys(username string) (
+	[]string, error) {
+	var keys []string
+	for _, key := range source.state.staticKeys.UserKeys(username) {
+		if key.Certified != nil && len(key.Options) < 1 {
+			keys = append(keys, key.PublicKey)
+		}
+	}
+	return keys, nil
+}
+
+// staticKeysFromSignatures returns the static keys of username which made
+// the signatures of message in data, in authorized_keys format, and their
+// fingerprints. Keys with authorized_keys options are refused, as their
+// certificates would not have the restrictions.
+func (state *RuntimeState) staticKeysFromSignatures(username string,
+	data, message []byte) ([]string, map[string]struct{}, error) {
+	signatures := sshsig.Split(data)
+	if len(signatures) < 1 {
+		return nil, nil, errors.New("no SSH signatures")
+	}
+	if len(signatures) > maxSSHPublicKeysPerRequest {
+		return nil, nil, errors.New("too many SSH signatures")
+	}
+	userKeys := make(map[string]statickeys.UserKey)
+	for _, key := range state.staticKeys.UserKeys(username) {
+		userKeys[key.Fingerprint] = key
+	}
+	var keys []string
+	fingerprints := make(map[string]struct{}, len(signatures))
+	for index, signature := range signatures {
+		pubKey, err := sshsig.Verify(signature, staticKeySignatureNamespace,
+			message)
+		if err != nil {
+			return nil, nil, fmt.Errorf("signature %d: %s", index+1, err)
+		}
+		fingerprint := ssh.FingerprintSHA256(pubKey)
+		key, ok := userKeys[fingerprint]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is not a static key of %s",
+				fingerprint, username)
+		}
+		if len(key.Options) > 0 {
+			return nil, nil, fmt.Errorf(
+				"%s has authorized_keys options (%s), which a certificate would not have",
+				fingerprint, strings.Join(key.Options, ","))
+		}
+		if _, ok := fingerprints[fingerprint]; ok {
+			continue
+		}
+		fingerprints[fingerprint] = struct{}{}
+		keys = append(keys, key.PublicKey)
+	}
+	return keys, fingerprints, nil
+}
+
+// withProvenStaticKeys marks the static keys with the given fingerprints as
+// signed for in r.
+func withProvenStaticKeys(r *http.Request,
+	fingerprints map[string]struct{}) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), provenStaticKeysKey{},
+		fingerprints))
+}
+
+// noteCertifiedStaticKey records the issue of an SSH certificate for a
+// static key of username if it was signed for in r. certBytes is the
+// certificate in wire format.
+func (state *RuntimeState) noteCertifiedStaticKey(r *http.Request,
+	username string, certBytes []byte) {
+	proven, _ := r.Context().Value(provenStaticKeysKey{}).(map[string]struct{})
+	if len(proven) < 1 {
+		return
+	}
+	pubKey, err := ssh.ParsePublicKey(certBytes)
+	if err != nil {
+		return
+	}
+	if cert, ok := pubKey.(*ssh.Certificate); ok {
+		pubKey = cert.Key
+	}
+	fingerprint := ssh.FingerprintSHA256(pubKey)
+	if _, ok := proven[fingerprint]; !ok {
+		return
+	}
+	certified, err := state.staticKeys.MarkCertified(username, fingerprint,
+		time.Now())
+	if err != nil {
+		logger.Errorf("Cannot record certified static key %s of %s: %s",
+			fingerprint, username, err)
+		return
+	}
+	if certified {
+		logger.Printf("Static key %s of %s certified", fingerprint, username)
+	}
+}
+
+func (state *RuntimeState) getStaticKeysDisplayInfo(
+	username string) []staticKeyDisplayInfo {
+	var displayInfo []staticKeyDisplayInfo
+	for _, key := range state.staticKeys.UserKeys(username) {
+		info := staticKeyDisplayInfo{
+			Fingerprint: key.Fingerprint,
+			PublicKey:   key.PublicKey,
+			Comments:    strings.Join(key.Comments, ", "),
+			Hosts:       strings.Join(key.Hosts, ", "),
+			Options:     strings.Join(key.Options, ","),
+			Certified:   key.Certified != nil,
+		}
+		if key.LastLogin != nil {
+			info.LastLogin = key.LastLogin.Format(time.RFC3339)
+		}
+		displayInfo = append(displayInfo, info)
+	}
+	return displayInfo
+}
+
+// certifyStaticKeysFromForm replaces the keys to certify in the form of r
+// with the static keys which signed its CSRF token, and returns r marked as
+// having proven them.
+func (state *RuntimeState) certifyStaticKeysFromForm(r *http.Request,
+	username string) (*http.Request, error) {
+	if len(uploadedFiles(r, "pubkeyfile")) > 0 {
+		return nil, errors.New("public key files cannot be uploaded with signatures")
+	}
+	keys, fingerprints, err := state.staticKeysFromSignatures(username,
+		[]byte(r.Form.Get(staticKeySignaturesFormField)),
+		[]byte(r.Form.Get(csrfTokenFormField)))
+	if err != nil {
+		return nil, err
+	}
+	r.Form.Set("type", "ssh")
+	r.Form.Set("pubkey", strings.Join(keys, "\n"))
+	return withProvenStaticKeys(r, fingerprints), nil
+}
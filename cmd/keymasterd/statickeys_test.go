@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/Symantec/keymaster/keymasterd/statickeys"
+	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/Symantec/keymaster/lib/sshsig"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+	"mime/multipart"
+)
+
+// newIPCertRequest returns a request authenticated with an IP restricted
+// certificate for identity.
+func newIPCertRequest(t *testing.T, method, path string, body []byte,
+	identity string) *http.Request {
+	req, err := http.NewRequest(method, path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, caCert, caPriv := setupX509Generator(t)
+	derCert, err := certgen.GenIPRestrictedX509Cert(identity, userPub, caCert,
+		caPriv, []net.IPNet{{IP: net.ParseIP("127.0.0.0"),
+			Mask: net.CIDRMask(8, 32)}}, testDuration, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "127.0.0.1:12345"
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+		PeerCertificates: []*x509.Certificate{cert},
+	}
+	return req
+}
+
+func TestStaticKeysMigration(t *testing.T) {
+	state, _, cleanup := setupHostCertTest(t)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "statickeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.staticKeys, err = statickeys.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.AutomationUsers = []string{"relay1", "stranger"}
+	state.sshPublicKeySources, err = state.newSSHPublicKeySources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSigner, err := ssh.NewSignerFromKey(edPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(
+		edSigner.PublicKey())))
+	_, restrictedPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restrictedSigner, err := ssh.NewSignerFromKey(restrictedPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(proto.StaticKeysReport{
+		Hostname: "relay1.example.com",
+		Keys: []proto.StaticKey{
+			{Username: "username", PublicKey: edKey, Comment: "laptop",
+				Logins: 3},
+			{Username: "username", PublicKey: string(
+				ssh.MarshalAuthorizedKey(restrictedSigner.PublicKey())),
+				Options: []string{`command="/usr/bin/backup"`}},
+		},
+		CertificateLogins: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hosts outside of the inventory cannot report keys.
+	_, err = checkRequestHandlerCode(newIPCertRequest(t, "POST",
+		proto.StaticKeysReportPath, body, "stranger"),
+		state.staticKeysReportHandler, http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(newIPCertRequest(t, "POST",
+		proto.StaticKeysReportPath, body, "relay1"),
+		state.staticKeysReportHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	displayInfo := state.getStaticKeysDisplayInfo("username")
+	if len(displayInfo) != 2 || displayInfo[0].Hosts != "relay1" {
+		t.Fatalf("unexpected static keys: %+v", displayInfo)
+	}
+
+	// The keys are offered on the certificate request page.
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{proto.AuthTypeU2F}
+	if err := state.loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authCookie := http.Cookie{Name: authCookieName, Value: cookieVal}
+	req, err := http.NewRequest("GET", certRequestPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&authCookie)
+	rr, err := checkRequestHandlerCode(req, state.certRequestHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := rr.Body.String()
+	if !strings.Contains(page, "laptop") ||
+		!strings.Contains(page, staticKeySignatureNamespace) ||
+		!strings.Contains(page, "cannot be certified") {
+		t.Errorf("static keys missing from the page: %s", page)
+	}
+
+	csrfToken, err := state.genNewSerializedCSRFToken("username", cookieVal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certifiedKeys := func() int {
+		certified := 0
+		for _, info := range state.getStaticKeysDisplayInfo("username") {
+			if info.Certified {
+				certified++
+			}
+		}
+		return certified
+	}
+	// An upload of a static key does not prove its possession.
+	req, err = createCertRequestFormRequest(edKey, csrfToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&authCookie)
+	_, err = checkRequestHandlerCode(req, state.certRequestHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certified := certifiedKeys(); certified != 0 {
+		t.Errorf("%d keys certified without a signature", certified)
+	}
+	signCertRequest := func(signer ssh.Signer, message string,
+		expectedStatus int) {
+		signature, err := sshsig.Sign(signer, staticKeySignatureNamespace,
+			[]byte(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := createStaticKeySignaturesRequest(string(signature),
+			csrfToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&authCookie)
+		_, err = checkRequestHandlerCode(req, state.certRequestHandler,
+			expectedStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	signCertRequest(edSigner, "another token", http.StatusBadRequest)
+	signCertRequest(restrictedSigner, csrfToken, http.StatusBadRequest)
+	signCertRequest(edSigner, csrfToken, http.StatusOK)
+	if certified := certifiedKeys(); certified != 1 {
+		t.Errorf("%d keys certified, expected 1", certified)
+	}
+
+	// The authorized_keys source is off by default.
+	req, err = http.NewRequest("GET",
+		"/certgen/username?type=ssh&pubkeySource=authorized_keys", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&authCookie)
+	_, err = checkRequestHandlerCode(req, state.certGenHandler,
+		http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Once enabled it renews the certified key only.
+	state.Config.SSHKeySource.AuthorizedKeys = true
+	state.sshPublicKeySources, err = state.newSSHPublicKeySources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(req, state.certGenHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(rr.Body.String()),
+		"\n"); len(lines) != 1 {
+		t.Fatalf("expected 1 certificate, got %q", rr.Body.String())
+	}
+
+	req, err = http.NewRequest("GET", staticKeysPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(req, state.staticKeysHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summaries []statickeys.HostSummary
+	if err := json.Unmarshal(rr.Body.Bytes(), &summaries); err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].Keys != 2 ||
+		summaries[0].CertifiedKeys != 1 || summaries[0].StaticLogins != 3 {
+		t.Errorf("unexpected summaries: %+v", summaries)
+	}
+	req, err = http.NewRequest("GET", staticKeysPath+"?host=relay2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.staticKeysHandler,
+		http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createStaticKeySignaturesRequest(signatures,
+	csrfToken string) (*http.Request, error) {
+	bodyBuf := &bytes.Buffer{}
+	bodyWriter := multipart.NewWriter(bodyBuf)
+	for name, value := range map[string]string{
+		staticKeySignaturesFormField: signatures,
+		csrfTokenFormField:           csrfToken,
+	} {
+		if err := bodyWriter.WriteField(name, value); err != nil {
+			return nil, err
+		}
+	}
+	bodyWriter.Close()
+	req, err := http.NewRequest("POST", certRequestPath, bodyBuf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", bodyWriter.FormDataContentType())
+	return req, nil
+}
@@ -2,347 +2,236 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"encoding/gob"
 	"errors"
 	"fmt"
-	"os"
+	"net/url"
 	"path/filepath"
 	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/Symantec/keymaster/keymasterd/issuedcerts"
+	"github.com/Symantec/keymaster/keymasterd/objectstore"
+	"github.com/Symantec/keymaster/keymasterd/profilestorage"
+	"github.com/Symantec/keymaster/keymasterd/tracing"
 )
 
 const userProfilePrefix = "profile_"
 const userProfileSuffix = ".gob"
 const profileDBFilename = "userProfiles.sqlite3"
 const cachedDBFilename = "cachedDB.sqlite3"
+const profileDirectoryName = "profiles"
+const issuedX509CertsPrefix = "issued_x509/"
+
+// openObjectStore returns the object store of s3://bucket/prefix and
+// gs://bucket/prefix storage URLs and the prefix of its keys, ending with a
+// slash unless empty. The store is nil for other URLs.
+func openObjectStore(config ProfileStorageConfig) (objectstore.Store,
+	string, error) {
+	if !strings.HasPrefix(config.StorageUrl, "s3:") &&
+		!strings.HasPrefix(config.StorageUrl, "gs:") {
+		return nil, "", nil
+	}
+	u, err := url.Parse(config.StorageUrl)
+	if err != nil {
+		return nil, "", fmt.Errorf("Bad storage url string: %s", err)
+	}
+	if u.Host == "" {
+		return nil, "", errors.New("Bad storage url string: no bucket")
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var store objectstore.Store
+	if u.Scheme == "s3" {
+		store, err = objectstore.NewS3(objectstore.S3Config{
+			Bucket:          u.Host,
+			Region:          config.ObjectStore.Region,
+			Endpoint:        config.ObjectStore.Endpoint,
+			AccessKeyID:     config.ObjectStore.AccessKeyID,
+			SecretAccessKey: config.ObjectStore.SecretAccessKey,
+			SessionToken:    config.ObjectStore.SessionToken,
+		})
+	} else {
+		store, err = objectstore.NewGCS(objectstore.GCSConfig{
+			Bucket:          u.Host,
+			Endpoint:        config.ObjectStore.Endpoint,
+			AccessKeyID:     config.ObjectStore.AccessKeyID,
+			SecretAccessKey: config.ObjectStore.SecretAccessKey,
+		})
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return store, prefix, nil
+}
 
-func initDB(state *RuntimeState) (err error) {
-	logger.Debugf(3, "Top of initDB")
-	//open/create cache DB first
-	cacheDBFilename := filepath.Join(state.Config.Base.DataDirectory, cachedDBFilename)
-	state.cacheDB, err = initFileDBSQLite(cacheDBFilename, state.cacheDB)
+// openIssuedX509Certs opens the record of issued X.509 certificates: in the
+// object store of the profiles if there is one, so that all the instances
+// know the certificates, else in the data directory.
+func openIssuedX509Certs(config ProfileStorageConfig,
+	dataDirectory string) (*issuedcerts.Database, error) {
+	store, prefix, err := openObjectStore(config)
 	if err != nil {
-		logger.Printf("Failure on creation of cacheDB")
-		return err
+		return nil, err
 	}
+	if store != nil {
+		return issuedcerts.OpenObjectStore(store,
+			prefix+issuedX509CertsPrefix), nil
+	}
+	return issuedcerts.Open(filepath.Join(dataDirectory,
+		issuedX509CertsFilename))
+}
 
-	logger.Debugf(3, "storage=%s", state.Config.ProfileStorage.StorageUrl)
-	storageURL := state.Config.ProfileStorage.StorageUrl
+// openProfileStorage opens the backend selected by the storage URL:
+// "sqlite:" (the default) for a SQLite database in the data directory, a
+// "postgresql://" URL, "file:" for a directory, by default the profiles
+// directory in the data directory, or s3://bucket/prefix and
+// gs://bucket/prefix for an object store.
+func openProfileStorage(config ProfileStorageConfig,
+	dataDirectory string) (profilestorage.Backend, error) {
+	storageURL := config.StorageUrl
 	if storageURL == "" {
 		storageURL = "sqlite:"
 	}
 	splitString := strings.SplitN(storageURL, ":", 2)
-	if len(splitString) < 1 {
-		logger.Printf("invalid string")
-		err := errors.New("Bad storage url string")
-		return err
+	if len(splitString) < 2 {
+		return nil, errors.New("Bad storage url string")
 	}
-	state.remoteDBQueryTimeout = time.Second * 2
-	initialSleep := time.Second * 3
-	go state.BackgroundDBCopy(initialSleep)
 	switch splitString[0] {
 	case "sqlite":
 		logger.Printf("doing sqlite")
-		return initDBSQlite(state)
+		return profilestorage.OpenSQLite(
+			filepath.Join(dataDirectory, profileDBFilename))
 	case "postgresql":
 		logger.Printf("doing postgres")
-		return initDBPostgres(state)
-	default:
-		logger.Printf("invalid storage url string")
-		err := errors.New("Bad storage url string")
-		return err
-	}
-
-}
-
-func initDBPostgres(state *RuntimeState) (err error) {
-	state.dbType = "postgres"
-	state.db, err = sql.Open("postgres", state.Config.ProfileStorage.StorageUrl)
-	if err != nil {
-		return err
-	}
-	/// This should be changed to take care of DB schema
-	if true {
-		sqlStmt := `create table if not exists user_profile (id serial not null primary key, username text unique, profile_data bytea);`
-		_, err = state.db.Exec(sqlStmt)
+		return profilestorage.OpenPostgreSQL(storageURL)
+	case "file":
+		u, err := url.Parse(storageURL)
 		if err != nil {
-			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
-			return err
+			return nil, fmt.Errorf("Bad storage url string: %s", err)
 		}
-		sqlStmt = `create table if not exists expiring_signed_user_data(id serial not null primary key, username text not null, jws_data text not null, type integer not null, expiration_epoch integer not null, update_epoch integer not null, UNIQUE(username,type));`
-		_, err = state.db.Exec(sqlStmt)
-		if err != nil {
-			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
-			return err
+		dirname := u.Path
+		if dirname == "" {
+			dirname = u.Opaque
 		}
-	}
-
-	return nil
-}
-
-// This call initializes the database if it does not exist.
-func initDBSQlite(state *RuntimeState) (err error) {
-	state.dbType = "sqlite"
-	dbFilename := filepath.Join(state.Config.Base.DataDirectory, profileDBFilename)
-	state.db, err = initFileDBSQLite(dbFilename, state.db)
-	return err
-}
-
-var sqliteinitializationStatements = []string{
-	`create table if not exists user_profile (id integer not null primary key, username text unique, profile_data blob);`,
-	`create table if not exists expiring_signed_user_data(id integer not null primary key, username text not null, jws_data text not null, type integer not null, expiration_epoch integer not null, update_epoch integer no null, UNIQUE(username,type));`,
-}
-
-func initializeSQLitetables(db *sql.DB) error {
-	for _, sqlStmt := range sqliteinitializationStatements {
-		logger.Debugf(2, "initializing sqlite, statement =%q", sqlStmt)
-		_, err := db.Exec(sqlStmt)
+		if dirname == "" {
+			dirname = filepath.Join(dataDirectory, profileDirectoryName)
+		}
+		logger.Printf("doing directory %s", dirname)
+		return profilestorage.OpenDirectory(dirname)
+	case "s3", "gs":
+		store, prefix, err := openObjectStore(config)
 		if err != nil {
-			logger.Printf("%s: %q\n", err, sqlStmt)
-			return err
+			return nil, err
 		}
+		logger.Printf("doing object store %s", storageURL)
+		return profilestorage.OpenObjectStore(store, prefix), nil
+	default:
+		return nil, errors.New("Bad storage url string")
 	}
-	return nil
 }
 
-// This call initializes the database if it does not exist.
-// TODO: update  to perform auto-updates of the db.
-func initFileDBSQLite(dbFilename string, currentDB *sql.DB) (*sql.DB, error) {
-	//state.dbType = "sqlite"
-	//dbFilename := filepath.Join(state.Config.Base.DataDirectory, profileDBFilename)
-	if _, err := os.Stat(dbFilename); os.IsNotExist(err) {
-		//CREATE NEW DB
-		fileDB, err := sql.Open("sqlite3", dbFilename)
-		if err != nil {
-			logger.Printf("Failure creating new db: %s", dbFilename)
-			return nil, err
-		}
-		logger.Printf("post DB open")
-		err = initializeSQLitetables(fileDB)
+func initDB(state *RuntimeState) (err error) {
+	logger.Debugf(3, "Top of initDB")
+	//open/create cache DB first
+	if state.profileCache == nil {
+		cacheDBFilename := filepath.Join(state.Config.Base.DataDirectory, cachedDBFilename)
+		state.profileCache, err = profilestorage.OpenSQLite(cacheDBFilename)
 		if err != nil {
-			return nil, err
+			logger.Errorf("Failure on creation of cacheDB")
+			return err
 		}
-		return fileDB, nil
 	}
 
-	// try open the DB
-	if currentDB == nil {
-
-		fileDB, err := sql.Open("sqlite3", dbFilename)
-		if err != nil {
-			return nil, err
-		}
-		err = initializeSQLitetables(fileDB)
-		if err != nil {
-			return nil, err
-		}
-		return fileDB, nil
+	logger.Debugf(3, "storage=%s", state.Config.ProfileStorage.StorageUrl)
+	state.profileStorage, err = openProfileStorage(
+		state.Config.ProfileStorage, state.Config.Base.DataDirectory)
+	if err != nil {
+		logger.Warnf("invalid storage url string: %s", err)
+		return err
 	}
-	return currentDB, nil
+	state.remoteDBQueryTimeout = time.Second * 2
+	initialSleep := time.Second * 3
+	go state.BackgroundDBCopy(initialSleep)
+	return nil
 }
 
 func (state *RuntimeState) BackgroundDBCopy(initialSleep time.Duration) {
 	time.Sleep(initialSleep)
 	for {
 		logger.Debugf(0, "starting db copy")
-		err := copyDBIntoSQLite(state.db, state.cacheDB, "sqlite")
+		err := profilestorage.Copy(state.profileStorage, state.profileCache,
+			time.Now())
 		if err != nil {
 			logger.Printf("err='%s'", err)
 		} else {
 			logger.Debugf(0, "db copy success")
 		}
-		cleanupDBData(state.db)
-		cleanupDBData(state.cacheDB)
+		state.profileStorage.DeleteExpired(time.Now())
+		state.profileCache.DeleteExpired(time.Now())
 		time.Sleep(time.Second * 300)
 	}
 
 }
 
-func cleanupDBData(db *sql.DB) error {
-	if db == nil {
-		err := errors.New("nil database on cleanup")
-		return err
-	}
-	queryStr := fmt.Sprintf("DELETE from expiring_signed_user_data WHERE expiration_epoch < %d", time.Now().Unix())
-	rows, err := db.Query(queryStr)
-	if err != nil {
-		logger.Printf("err='%s'", err)
-		return err
-	}
-	defer rows.Close()
-	return nil
+type storageReadResult struct {
+	value interface{}
+	err   error
 }
 
-func copyDBIntoSQLite(source, destination *sql.DB, destinationType string) error {
-	if source == nil || destination == nil {
-		err := errors.New("nil databases")
-		return err
-	}
-	// Copy user profiles
-	rows, err := source.Query("SELECT username,profile_data FROM user_profile")
-	if err != nil {
-		logger.Printf("err='%s'", err)
-		return err
-	}
-	defer rows.Close()
-
-	queryStr := fmt.Sprintf("SELECT username, type, jws_data, expiration_epoch,update_epoch FROM expiring_signed_user_data WHERE expiration_epoch > %d", time.Now().Unix())
-	genericRows, err := source.Query(queryStr)
-	if err != nil {
-		logger.Printf("err='%s'", err)
-		return err
-	}
-	defer genericRows.Close()
-
-	tx, err := destination.Begin()
-	if err != nil {
-		logger.Printf("err='%s'", err)
-		return err
-	}
-	stmtText := saveUserProfileStmt[destinationType]
-	stmt, err := tx.Prepare(stmtText)
-	if err != nil {
-		logger.Printf("err='%s'", err)
-		return err
-	}
-	defer stmt.Close()
-
-	for rows.Next() {
-		var (
-			username     string
-			profileBytes []byte
-		)
-		if err := rows.Scan(&username, &profileBytes); err != nil {
-			logger.Printf("err='%s'", err)
-			return err
-		}
-		_, err = stmt.Exec(username, profileBytes)
-		if err != nil {
-			logger.Printf("err='%s'", err)
-			return err
-		}
-	}
-	if err := rows.Err(); err != nil {
-		logger.Printf("err='%s'", err)
-		return err
-	}
-	expiringUpsertText := saveSignedUserDataStmt[destinationType]
-	expiringUpsertStmt, err := tx.Prepare(expiringUpsertText)
-	if err != nil {
-		logger.Printf("err='%s'", err)
-		return err
-	}
-	defer expiringUpsertStmt.Close()
-	for genericRows.Next() {
-		var (
-			username        string
-			dataType        int
-			jwsData         string
-			expirationEpoch int64
-			updateEpoch     int64
-		)
-		if err := genericRows.Scan(&username, &dataType, &jwsData, &expirationEpoch, &updateEpoch); err != nil {
-			logger.Printf("err='%s'", err)
-			return err
-		}
-		//username, type, jws_data, expiration_epoch, update_epoch
-		_, err = expiringUpsertStmt.Exec(username, dataType, jwsData, expirationEpoch, updateEpoch)
-		if err != nil {
-			logger.Printf("err='%s'", err)
-			return err
-		}
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		logger.Printf("err='%s'", err)
-		return err
-	}
-	return nil
-}
-
-var getUsersStmt = map[string]string{
-	"sqlite":   "select username from user_profile order by username",
-	"postgres": "select username from user_profile order by username",
-}
-
-type getUsersData struct {
-	Names []string
-	Err   error
-}
-
-func gatherUsers(stmt *sql.Stmt) ([]string, error) {
-	rows, err := stmt.Query()
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var names []string
-	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
-			return nil, err
-		}
-		names = append(names, name)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return names, nil
-}
-
-func (state *RuntimeState) GetUsers() ([]string, bool, error) {
-	ch := make(chan getUsersData, 1)
+// readProfileStorage calls read with the profile storage, or with the local
+// cache if the storage cannot be reached or does not answer within
+// remoteDBQueryTimeout. Other errors are returned, since the cache may be
+// stale. The bool is true if the value came from the cache.
+func (state *RuntimeState) readProfileStorage(
+	read func(backend profilestorage.Backend) (interface{}, error)) (
+	interface{}, bool, error) {
+	ch := make(chan storageReadResult, 1)
 	start := time.Now()
 	go func() {
-		stmtText := getUsersStmt[state.dbType]
-		stmt, err := state.db.Prepare(stmtText)
-		if err != nil {
-			logger.Printf("Error Preparing getUsers statement primary DB: %s", err)
-			return
-		}
-		defer stmt.Close()
+		// if the remoteDBQueryTimeout == 0 this means we are actuallty trying
+		// to force the cached db. In single core systems, we need to ensure this
+		// goroutine yields to sthis sleep is necesary
 		if state.remoteDBQueryTimeout == 0 {
 			time.Sleep(10 * time.Millisecond)
 		}
-		names, dbErr := gatherUsers(stmt)
-		ch <- getUsersData{Names: names, Err: dbErr}
-		close(ch)
+		value, err := read(state.profileStorage)
+		ch <- storageReadResult{value: value, err: err}
 	}()
 	select {
-	case dbMessage := <-ch:
-		if dbMessage.Err != nil {
-			logger.Printf("Problem with db ='%s'", dbMessage.Err)
-		} else {
+	case result := <-ch:
+		if result.err == nil {
 			metricLogExternalServiceDuration("storage-read", time.Since(start))
+			return result.value, false, nil
+		}
+		logger.Printf("Problem with db ='%s'", result.err)
+		if !profilestorage.IsUnreachable(result.err) {
+			return nil, false, result.err
 		}
-		return dbMessage.Names, false, dbMessage.Err
 	case <-time.After(state.remoteDBQueryTimeout):
 		logger.Printf("GOT a timeout")
-		stmtText := getUsersStmt["sqlite"]
-		stmt, err := state.cacheDB.Prepare(stmtText)
-		if err != nil {
-			logger.Printf("Error Preparing getUsers statement cached DB: %s", err)
-			return nil, false, err
-		}
-		defer stmt.Close()
-		names, dbErr := gatherUsers(stmt)
-		if dbErr != nil {
-			logger.Printf("Problem with db = '%s'", err)
-		} else {
-			logger.Println("GOT data from db cache")
-		}
-		return names, true, dbErr
 	}
+	value, err := read(state.profileCache)
+	if err != nil {
+		logger.Printf("Problem with db = '%s'", err)
+	} else {
+		logger.Println("GOT data from db cache")
+	}
+	return value, true, err
 }
 
-var loadUserProfileStmt = map[string]string{
-	"sqlite":   "select profile_data from user_profile where username = ?",
-	"postgres": "select profile_data from user_profile where username = $1",
+func (state *RuntimeState) GetUsers() ([]string, bool, error) {
+	value, fromCache, err := state.readProfileStorage(
+		func(backend profilestorage.Backend) (interface{}, error) {
+			return backend.Usernames()
+		})
+	if err != nil {
+		return nil, fromCache, err
+	}
+	return value.([]string), fromCache, nil
 }
 
 /// Adding api to be load/save per user
@@ -350,87 +239,46 @@ var loadUserProfileStmt = map[string]string{
 // Notice: each operation load/save should be atomic.
 
 type loadUserProfileData struct {
-	//fromDB       bool
 	ProfileBytes []byte
-	Err          error
+	Found        bool
 }
 
 // If there a valid user profile returns: profile, true nil
 // If there is NO user profile returns default_object, false, nil
 // Any other case: nil, false, error
 func (state *RuntimeState) LoadUserProfile(username string) (profile *userProfile, ok bool, fromCache bool, err error) {
+	return state.LoadUserProfileContext(context.Background(), username)
+}
+
+// LoadUserProfileContext is LoadUserProfile recording a span in the trace
+// of ctx.
+func (state *RuntimeState) LoadUserProfileContext(ctx context.Context,
+	username string) (profile *userProfile, ok bool, fromCache bool, err error) {
+	_, span := tracing.StartChild(ctx, spanNameLoadProfile,
+		tracing.SpanKindClient)
+	defer func() {
+		span.SetAttribute("keymaster.from_cache", fromCache)
+		endSpan(span, err)
+	}()
 	var defaultProfile userProfile
 	defaultProfile.U2fAuthData = make(map[int64]*u2fAuthData)
 	defaultProfile.TOTPAuthData = make(map[int64]*totpAuthData)
 
-	ch := make(chan loadUserProfileData, 1)
-	start := time.Now()
-	go func(username string) { //loads profile from DB
-		var profileMessage loadUserProfileData
-
-		stmtText := loadUserProfileStmt[state.dbType]
-		stmt, err := state.db.Prepare(stmtText)
-		if err != nil {
-			logger.Printf("Error Preparing statement LoadUsers primary DB: %s", err)
-			return
-		}
-
-		defer stmt.Close()
-		// if the remoteDBQueryTimeout == 0 this means we are actuallty trying
-		// to force the cached db. In single core systems, we need to ensure this
-		// goroutine yields to sthis sleep is necesary
-		if state.remoteDBQueryTimeout == 0 {
-			time.Sleep(10 * time.Millisecond)
-		}
-		profileMessage.Err = stmt.QueryRow(username).Scan(&profileMessage.ProfileBytes)
-		ch <- profileMessage
-	}(username)
-	var profileBytes []byte
-	fromCache = false
-	select {
-	case dbMessage := <-ch:
-		err = dbMessage.Err
-		if err != nil {
-			if err.Error() == "sql: no rows in result set" {
-				logger.Printf("err='%s'", err)
-				return &defaultProfile, false, fromCache, nil
-			} else {
-				logger.Printf("Problem with db ='%s'", err)
-				return nil, false, fromCache, err
-			}
-
-		}
-		metricLogExternalServiceDuration("storage-read", time.Since(start))
-		profileBytes = dbMessage.ProfileBytes
-	case <-time.After(state.remoteDBQueryTimeout):
-		logger.Printf("GOT a timeout")
-		fromCache = true
-		// load from cache
-		stmtText := loadUserProfileStmt["sqlite"]
-		stmt, err := state.cacheDB.Prepare(stmtText)
-		if err != nil {
-			logger.Printf("Error Preparing statement LoadUsers cache DB: %s", err)
-			return nil, false, false, err
-		}
-
-		defer stmt.Close()
-		err = stmt.QueryRow(username).Scan(&profileBytes)
-		if err != nil {
-			if err.Error() == "sql: no rows in result set" {
-				logger.Printf("err='%s'", err)
-				return &defaultProfile, false, true, nil
-			} else {
-				logger.Printf("Problem with db ='%s'", err)
-				return nil, false, true, err
-			}
-
-		}
-		logger.Printf("GOT data from db cache")
-
+	value, fromCache, err := state.readProfileStorage(
+		func(backend profilestorage.Backend) (interface{}, error) {
+			profileBytes, found, err := backend.LoadProfile(username)
+			return loadUserProfileData{profileBytes, found}, err
+		})
+	if err != nil {
+		return nil, false, fromCache, err
+	}
+	profileData := value.(loadUserProfileData)
+	if !profileData.Found {
+		logger.Debugf(1, "no profile for %s", username)
+		return &defaultProfile, false, fromCache, nil
 	}
-	logger.Debugf(10, "profile bytes len=%d", len(profileBytes))
-	//gobReader := bytes.NewReader(fileBytes)
-	gobReader := bytes.NewReader(profileBytes)
+	logger.Debugf(10, "profile bytes len=%d", len(profileData.ProfileBytes))
+	gobReader := bytes.NewReader(profileData.ProfileBytes)
 	decoder := gob.NewDecoder(gobReader)
 	err = decoder.Decode(&defaultProfile)
 	if err != nil {
@@ -440,12 +288,21 @@ func (state *RuntimeState) LoadUserProfile(username string) (profile *userProfil
 	return &defaultProfile, true, fromCache, nil
 }
 
-var saveUserProfileStmt = map[string]string{
-	"sqlite":   "insert or replace into user_profile(username, profile_data) values(?, ?)",
-	"postgres": "insert into user_profile(username, profile_data) values ($1,$2) on CONFLICT(username) DO UPDATE set  profile_data = excluded.profile_data",
+func (state *RuntimeState) SaveUserProfile(username string, profile *userProfile) error {
+	return state.SaveUserProfileContext(context.Background(), username,
+		profile)
 }
 
-func (state *RuntimeState) SaveUserProfile(username string, profile *userProfile) error {
+// SaveUserProfileContext is SaveUserProfile recording a span in the trace
+// of ctx.
+func (state *RuntimeState) SaveUserProfileContext(ctx context.Context,
+	username string, profile *userProfile) (err error) {
+	_, span := tracing.StartChild(ctx, spanNameSaveProfile,
+		tracing.SpanKindClient)
+	defer func() { endSpan(span, err) }()
+	if err := state.injectFault(faultTargetStorage); err != nil {
+		return err
+	}
 	var gobBuffer bytes.Buffer
 
 	encoder := gob.NewEncoder(&gobBuffer)
@@ -454,22 +311,7 @@ func (state *RuntimeState) SaveUserProfile(username string, profile *userProfile
 	}
 
 	start := time.Now()
-	//insert into DB
-	tx, err := state.db.Begin()
-	if err != nil {
-		return err
-	}
-	stmtText := saveUserProfileStmt[state.dbType]
-	stmt, err := tx.Prepare(stmtText)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-	_, err = stmt.Exec(username, gobBuffer.Bytes())
-	if err != nil {
-		return err
-	}
-	err = tx.Commit()
+	err = state.profileStorage.SaveProfile(username, gobBuffer.Bytes())
 	if err != nil {
 		return err
 	}
@@ -477,117 +319,37 @@ func (state *RuntimeState) SaveUserProfile(username string, profile *userProfile
 	return nil
 }
 
-var deleteSignedUserDataStmt = map[string]string{
-	"sqlite":   "delete from expiring_signed_user_data where username = ? and type = ?",
-	"postgres": "delete from expiring_signed_user_data where username = $1 and type = $2",
-}
-
 func (state *RuntimeState) DeleteSigned(username string, dataType int) error {
-
-	//insert into DB
-	tx, err := state.db.Begin()
-	if err != nil {
-		return err
-	}
-	stmtText := deleteSignedUserDataStmt[state.dbType]
-	stmt, err := tx.Prepare(stmtText)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-	_, err = stmt.Exec(username, dataType)
-	if err != nil {
+	if err := state.injectFault(faultTargetStorage); err != nil {
 		return err
 	}
-	err = tx.Commit()
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return state.profileStorage.DeleteSigned(username, dataType)
 }
 
 type getSignedData struct {
 	JWSData string
-	Err     error
-}
-
-var getSignedUserDataStmt = map[string]string{
-	"sqlite":   "select jws_data from expiring_signed_user_data where username = ? and type =? and expiration_epoch > ?",
-	"postgres": "select jws_data from expiring_signed_user_data where username = $1 and type = $2 and expiration_epoch > $3",
+	Found   bool
 }
 
 func (state *RuntimeState) GetSigned(username string, dataType int) (bool, string, error) {
 	logger.Printf("top of GetSigned")
-
-	//var jwsData string
-	ch := make(chan getSignedData, 1)
-	//start := time.Now()
-	go func(username string, dataType int) { //loads profile from DB
-		var signedDataMessage getSignedData
-
-		stmtText := getSignedUserDataStmt[state.dbType]
-		stmt, err := state.db.Prepare(stmtText)
-		if err != nil {
-			logger.Printf("Error Preparing statement GetSigned Primary DB: %s", err)
-			return
-		}
-		defer stmt.Close()
-		if state.remoteDBQueryTimeout == 0 {
-			time.Sleep(10 * time.Millisecond)
-		}
-		signedDataMessage.Err = stmt.QueryRow(username, dataType, time.Now().Unix()).Scan(&signedDataMessage.JWSData)
-		ch <- signedDataMessage
-	}(username, dataType)
-
-	var jwsData string
-
-	select {
-	case dbMessage := <-ch:
-		logger.Debugf(2, "got data from db. dbMessage=%+v", dbMessage)
-		err := dbMessage.Err
-		if err != nil {
-			err = dbMessage.Err
-			if err.Error() == "sql: no rows in result set" {
-				logger.Printf("err='%s'", err)
-				return false, "", nil
-			} else {
-				logger.Printf("Problem with db ='%s'", err)
-				return false, "", err
-			}
-		}
-		jwsData = dbMessage.JWSData
-
-	case <-time.After(state.remoteDBQueryTimeout):
-		logger.Printf("GOT a timeout")
-		// load from cache
-		stmtText := getSignedUserDataStmt["sqlite"]
-		stmt, err := state.cacheDB.Prepare(stmtText)
-		if err != nil {
-			logger.Printf("Error Preparing statement GetSigned Cache DB: %s", err)
-			return false, "", err
-		}
-
-		defer stmt.Close()
-		//err = stmt.QueryRow(username).Scan(&profileBytes)
-		err = stmt.QueryRow(username, dataType, time.Now().Unix()).Scan(&jwsData)
-		if err != nil {
-			if err.Error() == "sql: no rows in result set" {
-				logger.Printf("err='%s'", err)
-				return false, "", nil
-			} else {
-				logger.Printf("Problem with db ='%s'", err)
-				return false, "", err
-			}
-
-		}
-		logger.Printf("GOT data from db cache")
-
+	value, _, err := state.readProfileStorage(
+		func(backend profilestorage.Backend) (interface{}, error) {
+			jwsData, found, err := backend.LoadSigned(username, dataType,
+				time.Now())
+			return getSignedData{jwsData, found}, err
+		})
+	if err != nil {
+		return false, "", err
+	}
+	signedData := value.(getSignedData)
+	if !signedData.Found {
+		return false, "", nil
 	}
 	logger.Printf("GOT some jwsdata data")
-	storageJWT, err := state.getStorageDataFromStorageStringDataJWT(jwsData)
+	storageJWT, err := state.getStorageDataFromStorageStringDataJWT(signedData.JWSData)
 	if err != nil {
-		logger.Debugf(2, "failed to get storage data %s data=%s", err, jwsData)
+		logger.Debugf(2, "failed to get storage data %s data=%s", err, signedData.JWSData)
 		return false, "", err
 	}
 	if storageJWT.Subject != username {
@@ -598,35 +360,23 @@ func (state *RuntimeState) GetSigned(username string, dataType int) (bool, strin
 	return true, storageJWT.Data, nil
 }
 
-var saveSignedUserDataStmt = map[string]string{
-	"sqlite":   "insert or replace into expiring_signed_user_data(username, type, jws_data, expiration_epoch, update_epoch) values(?,?, ?, ?, ?)",
-	"postgres": "insert into expiring_signed_user_data(username, type, jws_data, expiration_epoch, update_epoch) values ($1,$2,$3,$4, $5) ON CONFLICT(username,type) DO UPDATE SET  jws_data = excluded.jws_data, expiration_epoch = excluded.expiration_epoch",
-}
-
 func (state *RuntimeState) UpsertSigned(username string, dataType int, expirationEpoch int64, data string) error {
+	if err := state.injectFault(faultTargetStorage); err != nil {
+		return err
+	}
 	logger.Debugf(2, "top of UpsertSigned")
-	//expirationEpoch := expiration.Unix()
 	stringData, err := state.genNewSerializedStorageStringDataJWT(username, dataType, data, expirationEpoch)
 	if err != nil {
 		return err
 	}
 	start := time.Now()
-	//insert into DB
-	tx, err := state.db.Begin()
-	if err != nil {
-		return err
-	}
-	stmtText := saveSignedUserDataStmt[state.dbType]
-	stmt, err := tx.Prepare(stmtText)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-	_, err = stmt.Exec(username, dataType, stringData, expirationEpoch, time.Now().Unix())
-	if err != nil {
-		return err
-	}
-	err = tx.Commit()
+	err = state.profileStorage.SaveSigned(profilestorage.SignedRecord{
+		Username:        username,
+		Type:            dataType,
+		Data:            stringData,
+		ExpirationEpoch: expirationEpoch,
+		UpdateEpoch:     time.Now().Unix(),
+	})
 	if err != nil {
 		return err
 	}
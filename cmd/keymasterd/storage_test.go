@@ -1,18 +1,24 @@
 package main
 
 import (
+	"errors"
+	"io/ioutil"
 	stdlog "log"
+	"net"
 	"os"
+	"path/filepath"
 	"testing"
-	//"time"
+	"time"
 
 	"github.com/Symantec/Dominator/lib/log/debuglogger"
 	"github.com/Symantec/keymaster/keymasterd/eventnotifier"
+	"github.com/Symantec/keymaster/keymasterd/levellog"
+	"github.com/Symantec/keymaster/keymasterd/profilestorage"
 )
 
 func init() {
 	slogger := stdlog.New(os.Stderr, "", stdlog.LstdFlags)
-	logger = debuglogger.New(slogger)
+	logger = levellog.New(debuglogger.New(slogger))
 	eventNotifier = eventnotifier.New(logger)
 }
 
@@ -23,7 +29,7 @@ func TestDBCopy(t *testing.T) {
 		t.Fatal(err)
 	}
 	// copy blank db
-	err = copyDBIntoSQLite(state.db, state.cacheDB, "sqlite")
+	err = profilestorage.Copy(state.profileStorage, state.profileCache, time.Now())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -37,7 +43,7 @@ func TestDBCopy(t *testing.T) {
 		t.Fatal(err)
 	}
 	// copy the db now with one user
-	err = copyDBIntoSQLite(state.db, state.cacheDB, "sqlite")
+	err = profilestorage.Copy(state.profileStorage, state.profileCache, time.Now())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -59,7 +65,7 @@ func TestFetchFromCache(t *testing.T) {
 		t.Fatal(err)
 	}
 	// copy blank with one user...
-	err = copyDBIntoSQLite(state.db, state.cacheDB, "sqlite")
+	err = profilestorage.Copy(state.profileStorage, state.profileCache, time.Now())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -83,3 +89,136 @@ func TestFetchFromCache(t *testing.T) {
 		t.Fatal("This should have failed for invalid user")
 	}
 }
+
+// failingBackend fails to list the users with err.
+type failingBackend struct {
+	profilestorage.Backend
+	err error
+}
+
+func (backend failingBackend) Usernames() ([]string, error) {
+	return nil, backend.err
+}
+
+func TestReadProfileStorageFallback(t *testing.T) {
+	var state RuntimeState
+	if err := initDB(&state); err != nil {
+		t.Fatal(err)
+	}
+	storage := state.profileStorage
+	// Only an unreachable storage falls back to the cache.
+	state.profileStorage = failingBackend{storage,
+		&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("refused")}}
+	if _, fromCache, err := state.GetUsers(); err != nil || !fromCache {
+		t.Errorf("unreachable: %v %v", fromCache, err)
+	}
+	state.profileStorage = failingBackend{storage, errors.New("corrupt")}
+	if _, fromCache, err := state.GetUsers(); err == nil || fromCache {
+		t.Errorf("corrupt: %v %v", fromCache, err)
+	}
+}
+
+func TestOpenProfileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, storageURL := range []string{"bogus:", "bogus"} {
+		if _, err := openProfileStorage(
+			ProfileStorageConfig{StorageUrl: storageURL}, dir); err == nil {
+			t.Errorf("%s: accepted", storageURL)
+		}
+	}
+	sharedDir := filepath.Join(dir, "shared")
+	for storageURL, expectedDir := range map[string]string{
+		"file:":                   filepath.Join(dir, profileDirectoryName),
+		"file://" + sharedDir:     sharedDir,
+		"file:" + sharedDir + "2": sharedDir + "2",
+	} {
+		if _, err := openProfileStorage(
+			ProfileStorageConfig{StorageUrl: storageURL}, dir); err != nil {
+			t.Errorf("%s: %s", storageURL, err)
+		} else if _, err := os.Stat(expectedDir); err != nil {
+			t.Errorf("%s: %s", storageURL, err)
+		}
+	}
+}
+
+func TestOpenObjectStore(t *testing.T) {
+	objectStoreConfig := ObjectStoreConfig{Endpoint: "http://127.0.0.1:1",
+		AccessKeyID: "AKID", SecretAccessKey: "secret"}
+	for storageURL, expectedPrefix := range map[string]string{
+		"s3://keymaster":                    "",
+		"s3://keymaster/":                   "",
+		"s3://keymaster/prod":               "prod/",
+		"gs://keymaster/prod/profiles/":     "prod/profiles/",
+		"postgresql://localhost/keymaster":  "none",
+		"file:///var/lib/keymaster/profile": "none",
+	} {
+		config := ProfileStorageConfig{StorageUrl: storageURL,
+			ObjectStore: objectStoreConfig}
+		store, prefix, err := openObjectStore(config)
+		if err != nil {
+			t.Errorf("%s: %s", storageURL, err)
+			continue
+		}
+		if expectedPrefix == "none" {
+			if store != nil {
+				t.Errorf("%s: object store opened", storageURL)
+			}
+			continue
+		}
+		if store == nil || prefix != expectedPrefix {
+			t.Errorf("%s: %v %q", storageURL, store, prefix)
+		}
+	}
+	for _, storageURL := range []string{"s3:keymaster", "gs:///prefix"} {
+		config := ProfileStorageConfig{StorageUrl: storageURL,
+			ObjectStore: objectStoreConfig}
+		if _, err := openProfileStorage(config, ""); err == nil {
+			t.Errorf("%s: accepted", storageURL)
+		}
+	}
+	config := ProfileStorageConfig{StorageUrl: "gs://keymaster/prod"}
+	if _, err := openIssuedX509Certs(config, ""); err == nil {
+		t.Error("no credentials accepted")
+	}
+	config.ObjectStore = objectStoreConfig
+	if db, err := openIssuedX509Certs(config, ""); err != nil || db == nil {
+		t.Errorf("issued certificates: %v", err)
+	}
+}
+
+func TestDirectoryProfileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var state RuntimeState
+	state.Config.Base.DataDirectory = dir
+	state.Config.ProfileStorage.StorageUrl = "file:"
+	if err := initDB(&state); err != nil {
+		t.Fatal(err)
+	}
+	profile, ok, _, err := state.LoadUserProfile("username")
+	if err != nil || ok {
+		t.Fatalf("new profile: %v %v", ok, err)
+	}
+	profile.TOTPAuthData[1] = &totpAuthData{Name: "phone", Enabled: true}
+	if err := state.SaveUserProfile("username", profile); err != nil {
+		t.Fatal(err)
+	}
+	profile, ok, fromCache, err := state.LoadUserProfile("username")
+	if err != nil || !ok || fromCache {
+		t.Fatalf("saved profile: %v %v %v", ok, fromCache, err)
+	}
+	if data := profile.TOTPAuthData[1]; data == nil || data.Name != "phone" {
+		t.Errorf("unexpected profile: %+v", profile)
+	}
+	users, _, err := state.GetUsers()
+	if err != nil || len(users) != 1 || users[0] != "username" {
+		t.Errorf("users: %v %v", users, err)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/keymasterd/levellog"
+	"github.com/Symantec/keymaster/keymasterd/syslog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	syslogAppName              = "keymasterd"
+	defaultSyslogFacility      = "daemon"
+	defaultSyslogAuditFacility = "authpriv"
+	syslogCloseTimeout         = 5 * time.Second
+)
+
+type SyslogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Network is "" for the local syslog daemon, "udp", "tcp" or "tls".
+	Network string `yaml:"network"`
+	// Address is the host:port of a remote collector.
+	Address string `yaml:"address"`
+	// CAFilename has the CA certificates of a tls collector. Default: the
+	// system roots.
+	CAFilename string `yaml:"ca_filename"`
+	// Facility of the application and access logs. Default: daemon.
+	Facility string `yaml:"facility"`
+	// AuditFacility of the certificate events. Default: authpriv.
+	AuditFacility string `yaml:"audit_facility"`
+	// ReplaceLogBuffer stops sending the application and access logs to
+	// the log buffer.
+	ReplaceLogBuffer bool `yaml:"replace_log_buffer"`
+}
+
+// syslogLogger sends the messages of a logger to syslog once a writer is
+// set, and to the log buffer unless it replaces it. The lines of the
+// leveled application logger keep their level as severity; the Debug
+// methods, which only libraries given the buffer directly use, only go to
+// the log buffer.
+type syslogLogger struct {
+	buffer   log.DebugLogger
+	msgID    string
+	mutex    sync.Mutex
+	writer   *syslog.Writer  // Protected by mutex.
+	facility syslog.Facility // Protected by mutex.
+	replace  bool            // Protected by mutex.
+}
+
+var syslogSeverities = map[levellog.Level]syslog.Severity{
+	levellog.Debug: syslog.Debug,
+	levellog.Info:  syslog.Informational,
+	levellog.Warn:  syslog.Warning,
+	levellog.Error: syslog.Error,
+}
+
+// applicationLogger is the destination of the application logger, so that
+// the components given the logger before the configuration was loaded use
+// syslog too.
+var applicationLogger = &syslogLogger{}
+
+var syslogDroppedCounter = prometheus.NewCounterFunc(
+	prometheus.CounterOpts{
+		Name: "keymaster_syslog_dropped_messages_total",
+		Help: "Log messages dropped because the syslog queue was full",
+	},
+	func() float64 {
+		applicationLogger.mutex.Lock()
+		writer := applicationLogger.writer
+		applicationLogger.mutex.Unlock()
+		if writer == nil {
+			return 0
+		}
+		return float64(writer.Dropped())
+	},
+)
+
+func init() {
+	prometheus.MustRegister(syslogDroppedCounter)
+}
+
+func (config SyslogConfig) check() error {
+	if !config.Enabled {
+		return nil
+	}
+	switch config.Network {
+	case "":
+		if config.CAFilename != "" {
+			return errors.New("syslog: ca_filename is only for tls")
+		}
+	case "udp", "tcp", "tls":
+		if config.Address == "" {
+			return errors.New("syslog: no address")
+		}
+	default:
+		return fmt.Errorf("syslog: unknown network: %s", config.Network)
+	}
+	if _, err := config.facilities(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// facilities returns the facility of the logs and of the audit events.
+func (config SyslogConfig) facilities() ([2]syslog.Facility, error) {
+	var facilities [2]syslog.Facility
+	for index, name := range []string{config.Facility, config.AuditFacility} {
+		if name == "" {
+			name = []string{defaultSyslogFacility,
+				defaultSyslogAuditFacility}[index]
+		}
+		facility, err := syslog.ParseFacility(name)
+		if err != nil {
+			return facilities, err
+		}
+		facilities[index] = facility
+	}
+	return facilities, nil
+}
+
+// setupSyslog connects to the syslog collector, if configured, and starts
+// sending the application log to it. The access loggers are wrapped with
+// syslogAccessLogger and certificate events are sent by recordAuditEvent.
+func (state *RuntimeState) setupSyslog() error {
+	config := state.Config.Syslog
+	if !config.Enabled {
+		return nil
+	}
+	if err := config.check(); err != nil {
+		return err
+	}
+	facilities, err := config.facilities()
+	if err != nil {
+		return err
+	}
+	params := syslog.Params{
+		Network:  config.Network,
+		Address:  config.Address,
+		Hostname: state.HostIdentity,
+		AppName:  syslogAppName,
+	}
+	if config.Network == "tls" {
+		params.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if config.CAFilename != "" {
+			caData, err := ioutil.ReadFile(config.CAFilename)
+			if err != nil {
+				return fmt.Errorf("syslog: %s", err)
+			}
+			params.TLSConfig.RootCAs = x509.NewCertPool()
+			if !params.TLSConfig.RootCAs.AppendCertsFromPEM(caData) {
+				return fmt.Errorf("syslog: no certificates in %s",
+					config.CAFilename)
+			}
+		}
+	}
+	writer, err := syslog.New(params)
+	if err != nil {
+		return err
+	}
+	state.syslogWriter = writer
+	state.syslogFacility = facilities[0]
+	state.syslogAuditFacility = facilities[1]
+	applicationLogger.setWriter(writer, facilities[0], config.ReplaceLogBuffer)
+	return nil
+}
+
+// syslogAccessLogger returns the access logger sending to buffer and to
+// syslog with msgID, if configured.
+func (state *RuntimeState) syslogAccessLogger(buffer log.DebugLogger,
+	msgID string) log.DebugLogger {
+	if state.syslogWriter == nil {
+		return buffer
+	}
+	accessLogger := &syslogLogger{buffer: buffer, msgID: msgID}
+	accessLogger.setWriter(state.syslogWriter, state.syslogFacility,
+		state.Config.Syslog.ReplaceLogBuffer)
+	return accessLogger
+}
+
+// syslogAuditEvent sends message to syslog, if configured, as JSON with the
+// event type as the message ID. Unlike log lines audit events are not
+// dropped when the queue is full: this waits until the collector catches up.
+func (state *RuntimeState) syslogAuditEvent(message auditStreamMessage) {
+	if state.syslogWriter == nil {
+		return
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	err = state.syslogWriter.SendWait(state.syslogAuditFacility,
+		syslog.Notice, message.Type, string(payload))
+	if err != nil {
+		logger.Errorf("syslog: %s: %s", message.Type, err)
+	}
+}
+
+// closeSyslog sends the queued messages before exiting.
+func (state *RuntimeState) closeSyslog() {
+	if state.syslogWriter == nil {
+		return
+	}
+	if err := state.syslogWriter.Close(syslogCloseTimeout); err != nil {
+		logger.Errorf("%s", err)
+	}
+}
+
+func (l *syslogLogger) setWriter(writer *syslog.Writer,
+	facility syslog.Facility, replace bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.writer = writer
+	l.facility = facility
+	l.replace = replace
+}
+
+// send sends text to syslog and returns true if the log buffer is replaced.
+func (l *syslogLogger) send(severity syslog.Severity, text string) bool {
+	l.mutex.Lock()
+	writer, facility, replace := l.writer, l.facility, l.replace
+	l.mutex.Unlock()
+	if writer == nil {
+		return false
+	}
+	writer.Send(facility, severity, l.msgID, text)
+	return replace
+}
+
+// sendFatal sends text to syslog and waits for it to be sent, as the
+// program is about to exit.
+func (l *syslogLogger) sendFatal(text string) {
+	l.send(syslog.Critical, text)
+	l.mutex.Lock()
+	writer := l.writer
+	l.mutex.Unlock()
+	if writer != nil {
+		writer.Close(syslogCloseTimeout)
+	}
+}
+
+func (l *syslogLogger) PrintLevel(level levellog.Level, line string) {
+	if !l.send(syslogSeverities[level], line) {
+		l.buffer.Print(line)
+	}
+}
+
+func (l *syslogLogger) Debug(level uint8, v ...interface{}) {
+	l.buffer.Debug(level, v...)
+}
+
+func (l *syslogLogger) Debugf(level uint8, format string, v ...interface{}) {
+	l.buffer.Debugf(level, format, v...)
+}
+
+func (l *syslogLogger) Debugln(level uint8, v ...interface{}) {
+	l.buffer.Debugln(level, v...)
+}
+
+func (l *syslogLogger) Fatal(v ...interface{}) {
+	l.sendFatal(fmt.Sprint(v...))
+	l.buffer.Fatal(v...)
+}
+
+func (l *syslogLogger) Fatalf(format string, v ...interface{}) {
+	l.sendFatal(fmt.Sprintf(format, v...))
+	l.buffer.Fatalf(format, v...)
+}
+
+func (l *syslogLogger) Fatalln(v ...interface{}) {
+	l.sendFatal(fmt.Sprintln(v...))
+	l.buffer.Fatalln(v...)
+}
+
+func (l *syslogLogger) Panic(v ...interface{}) {
+	l.send(syslog.Critical, fmt.Sprint(v...))
+	l.buffer.Panic(v...)
+}
+
+func (l *syslogLogger) Panicf(format string, v ...interface{}) {
+	l.send(syslog.Critical, fmt.Sprintf(format, v...))
+	l.buffer.Panicf(format, v...)
+}
+
+func (l *syslogLogger) Panicln(v ...interface{}) {
+	l.send(syslog.Critical, fmt.Sprintln(v...))
+	l.buffer.Panicln(v...)
+}
+
+func (l *syslogLogger) Print(v ...interface{}) {
+	if !l.send(syslog.Informational, fmt.Sprint(v...)) {
+		l.buffer.Print(v...)
+	}
+}
+
+func (l *syslogLogger) Printf(format string, v ...interface{}) {
+	if !l.send(syslog.Informational, fmt.Sprintf(format, v...)) {
+		l.buffer.Printf(format, v...)
+	}
+}
+
+func (l *syslogLogger) Println(v ...interface{}) {
+	if !l.send(syslog.Informational, fmt.Sprintln(v...)) {
+		l.buffer.Println(v...)
+	}
+}
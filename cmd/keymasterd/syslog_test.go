@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	stdlog "log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log/debuglogger"
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+)
+
+func TestSyslogConfigCheck(t *testing.T) {
+	for _, config := range []SyslogConfig{
+		{Enabled: true, Network: "udp"},
+		{Enabled: true, Network: "sctp", Address: "logs:514"},
+		{Enabled: true, CAFilename: "ca.pem"},
+		{Enabled: true, Facility: "local9"},
+	} {
+		if err := config.check(); err == nil {
+			t.Errorf("%+v accepted", config)
+		}
+	}
+	if err := (SyslogConfig{Enabled: true, Network: "tls",
+		Address: "logs:6514", AuditFacility: "local3"}).check(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var state RuntimeState
+	state.HostIdentity = "keymaster.example.com"
+	state.Config.Syslog = SyslogConfig{Enabled: true, Network: "udp",
+		Address: conn.LocalAddr().String(), ReplaceLogBuffer: true}
+	if err := state.setupSyslog(); err != nil {
+		t.Fatal(err)
+	}
+	defer applicationLogger.setWriter(nil, 0, false)
+	defer state.closeSyslog()
+	var buffer bytes.Buffer
+	accessLogger := state.syslogAccessLogger(
+		debuglogger.New(stdlog.New(&buffer, "", 0)), "access")
+	accessLogger.Printf("GET %s", "/public/loginForm")
+	if err := state.recordAuditEvent(attestation.Event{
+		Type:     attestation.EventIssued,
+		Username: "username",
+		Policy:   "ssh",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for i := 0; i < 2; i++ {
+		data := make([]byte, 4096)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, string(data[:n]))
+	}
+	// daemon.info and authpriv.notice.
+	for index, expected := range [][]string{
+		{"<30>1 ", " keymaster.example.com keymasterd ",
+			" access - GET /public/loginForm"},
+		{"<85>1 ", ` issued - {"type":"issued",`, `"username":"username"`},
+	} {
+		for _, substring := range expected {
+			if !strings.Contains(messages[index], substring) {
+				t.Errorf("%q not in %q", substring, messages[index])
+			}
+		}
+	}
+	if buffer.Len() > 0 {
+		t.Errorf("log buffer not replaced: %q", buffer.String())
+	}
+}
@@ -91,6 +91,8 @@ type secondFactorAuthTemplateData struct {
 	ShowVIP          bool
 	ShowU2F          bool
 	ShowTOTP         bool
+	ShowDuo          bool
+	ShowBackupCode   bool
 	LoginDestination string
 }
 
@@ -150,6 +152,11 @@ const secondFactorAuthFormText = `
 	{{end}}
 	{{end}}
 
+        {{if .ShowDuo}}
+	<div id="duo_login_destination" style="display: none;">{{.LoginDestination}}</div>
+	<p> <button id="start_duo_push_button" >Send Duo Push</button> <span id="duo_push_status"></span></p>
+	{{end}}
+
         {{if .ShowTOTP}}
         <form enctype="application/x-www-form-urlencoded" action="/api/v0/TOTPAuth" method="post">
             <p>
@@ -160,6 +167,16 @@ const secondFactorAuthFormText = `
         </form>
 	{{end}}
 
+        {{if .ShowBackupCode}}
+        <form enctype="application/x-www-form-urlencoded" action="/api/v0/backupCodeAuth" method="post">
+            <p>
+            Or enter a backup code: <INPUT TYPE="text" NAME="code" SIZE=12  autocomplete="off">
+            <INPUT TYPE="hidden" NAME="login_destination" VALUE={{.LoginDestination}}>
+            <input type="submit" value="Submit" />
+            </p>
+        </form>
+	{{end}}
+
 	</div>
 	{{template "footer" . }}
 	</div>
@@ -236,6 +253,8 @@ type profilePageTemplateData struct {
 	UsersLink            bool
 	RegisteredU2FToken   []registeredU2FTokenDisplayInfo
 	RegisteredTOTPDevice []registeredTOTPTDeviceDisplayInfo
+	ShowBackupCodes      bool
+	BackupCodesRemaining int
 }
 
 //{{ .Date | formatAsDate}} {{ printf "%-20s" .Description }} {{.AmountInCents | formatAsDollars -}}
@@ -268,6 +287,7 @@ const profileHTML = `
     {{.ReadOnlyMsg}}
     <ul>
       <li><a href="/api/v0/logout" >Logout </a></li>
+      <li><a href="/certrequest/">Request Certificate</a></li>
     {{if .UsersLink}}
       <li><a href="/users/">Users</a></li>
     {{end}}
@@ -373,6 +393,20 @@ const profileHTML = `
        {{end}}
     {{end}}
     </div> <!-- end of totp div -->
+    {{if .ShowBackupCodes}}
+    <div id="backup-codes">
+       <h3>Backup codes</h3>
+       <p>{{.BackupCodesRemaining}} unused backup code(s) left.</p>
+       {{if and (not .ReadOnlyMsg) (eq .Username .AuthUsername)}}
+       <form enctype="application/x-www-form-urlencoded" action="/api/v0/backupCodes" method="post">
+           <p>
+           <input type="submit" value="Generate new backup codes" />
+           (replaces the unused ones)
+           </p>
+       </form>
+       {{end}}
+    </div> <!-- end of backup codes div -->
+    {{end}}
     {{end}}
     </div>
     {{template "footer" . }}
@@ -389,6 +423,8 @@ type newTOTPPageTemplateData struct {
 	ErrorMessage    string
 	TOTPBase64Image template.HTML
 	TOTPSecret      string
+	// The otpauth:// URI of the QR code.
+	TOTPProvisioningURI string
 }
 
 const newTOTPHTML = `
@@ -426,6 +462,9 @@ const newTOTPHTML = `
     New TOTP:
     {{.TOTPBase64Image}}
     {{ end }}
+    {{if .TOTPProvisioningURI}}
+    <p>Or enter this provisioning URI in your authenticator: <code>{{.TOTPProvisioningURI}}</code></p>
+    {{ end }}
     </div>
     <form enctype="application/x-www-form-urlencoded" action="/totp/ValidateNew/" method="post">
             <p>
@@ -440,3 +479,169 @@ const newTOTPHTML = `
 </html>
 {{end}}
 `
+
+type issuedCertDisplayInfo struct {
+	CertType  string
+	IssuedAt  string
+	ExpiresAt string
+	Expired   bool
+}
+
+type certRequestPageTemplateData struct {
+	Title         string
+	AuthUsername  string
+	JSSources     []string
+	CSRFToken     string
+	CanIssueCerts bool
+	IssuedCerts   []issuedCertDisplayInfo
+	StaticKeys    []staticKeyDisplayInfo
+}
+
+const certRequestHTML = `
+{{define "certRequestPage"}}
+<!DOCTYPE html>
+<html style="height:100%; padding:0;border:0;margin:0">
+  <head>
+    <title>{{.Title}}</title>
+    <link rel="stylesheet" type="text/css" href="//fonts.googleapis.com/css?family=Droid+Sans" />
+    <link rel="stylesheet" type="text/css" href="/custom_static/customization.css">
+    <link rel="stylesheet" type="text/css" href="/static/keymaster.css">
+  </head>
+  <body>
+    <div style="min-height:100%;position:relative;">
+    {{template "header" .}}
+    <div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
+
+    <h1>{{.Title}}</h1>
+    <ul>
+      <li><a href="/profile/">Profile</a></li>
+    </ul>
+
+    <h3>Current Certificates</h3>
+    {{if .IssuedCerts -}}
+    <table>
+        <tr>
+        <th>Type</th>
+        <th>Issued</th>
+        <th>Expires</th>
+        <th>Status</th>
+        </tr>
+        {{- range .IssuedCerts }}
+        <tr>
+        <td> {{.CertType}} </td>
+        <td> {{.IssuedAt}} </td>
+        <td> {{.ExpiresAt}} </td>
+        <td> {{if .Expired}}Expired{{else}}Valid{{end}} </td>
+        </tr>
+        {{- end}}
+    </table>
+    {{- else}}
+    No certificates have been issued to you recently.
+    {{- end}}
+
+    {{if .StaticKeys -}}
+    <h3>Static Keys</h3>
+    <p>These keys of yours were found in authorized_keys files. Get a certificate for a key to log in with it without the file.</p>
+    <table>
+        <tr>
+        <th>Fingerprint</th>
+        <th>Comment</th>
+        <th>Hosts</th>
+        <th>Last login</th>
+        <th></th>
+        </tr>
+        {{- range .StaticKeys }}
+        <tr>
+        <td> <code>{{.Fingerprint}}</code> </td>
+        <td> {{.Comments}} </td>
+        <td> {{.Hosts}} </td>
+        <td> {{.LastLogin}} </td>
+        <td>
+        {{- if .Options}}Has options <code>{{.Options}}</code>, cannot be certified
+        {{- else if .Certified}}Certified{{end}}
+        </td>
+        </tr>
+        {{- end}}
+    </table>
+    {{if .CanIssueCerts}}
+    <p>Anyone with root on a host can add keys to these files, so prove that a key is yours by signing this page's code with it, and paste the signatures of one or more keys below:</p>
+    <pre>printf %s '{{.CSRFToken}}' | ssh-keygen -Y sign -n keymaster-static-key -f ~/.ssh/id_ed25519</pre>
+    <form enctype="multipart/form-data" action="/certrequest/" method="post">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <input type="hidden" name="duration" value="24h">
+        <textarea name="static_key_signatures" rows="8" cols="72"></textarea>
+        <p><input type="submit" value="Certify" /></p>
+    </form>
+    {{end}}
+    {{- end}}
+
+    <h3>New Certificate</h3>
+    {{if .CanIssueCerts}}
+    <form enctype="multipart/form-data" action="/certrequest/" method="post">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <p>
+        Type:
+        <select name="type">
+            <option value="ssh" selected>SSH (paste your id_*.pub)</option>
+            <option value="x509">X509 (paste a PEM PUBLIC KEY)</option>
+        </select>
+        Duration:
+        <select name="duration">
+            <option value="1h">1 hour</option>
+            <option value="8h">8 hours</option>
+            <option value="16h">16 hours</option>
+            <option value="24h" selected>24 hours</option>
+        </select>
+        </p>
+        <p>Paste your public key:</p>
+        <p><textarea name="pubkey" rows="6" cols="80" autocomplete="off"></textarea></p>
+        <p>Or upload it: <input type="file" name="pubkeyfile"></p>
+        <p><input type="submit" value="Download Certificate" /></p>
+    </form>
+    {{else}}
+    <p style="color:red;">Your current authentication level is not sufficient for getting certificates. Please authenticate with a second factor from your <a href="/profile/">profile</a>.</p>
+    {{end}}
+    </div>
+    {{template "footer" . }}
+    </div>
+  </body>
+</html>
+{{end}}
+`
+
+type backupCodesPageTemplateData struct {
+	Title        string
+	AuthUsername string
+	JSSources    []string
+	Codes        []string
+}
+
+const backupCodesHTML = `
+{{define "backupCodesPage"}}
+<!DOCTYPE html>
+<html style="height:100%; padding:0;border:0;margin:0">
+  <head>
+    <title>{{.Title}}</title>
+    <link rel="stylesheet" type="text/css" href="//fonts.googleapis.com/css?family=Droid+Sans" />
+    <link rel="stylesheet" type="text/css" href="/custom_static/customization.css">
+    <link rel="stylesheet" type="text/css" href="/static/keymaster.css">
+  </head>
+  <body>
+    <div style="min-height:100%;position:relative;">
+    {{template "header" .}}
+    <div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
+    <h1>{{.Title}}</h1>
+    <p>Keep these codes in a safe place. Each code can be used once instead of your U2F token or TOTP device. They are not shown again.</p>
+    <ul>
+    {{- range .Codes}}
+      <li><code>{{.}}</code></li>
+    {{- end}}
+    </ul>
+    <p><a href="/profile/">Back to your profile</a></p>
+    </div>
+    {{template "footer" . }}
+    </div>
+  </body>
+</html>
+{{end}}
+`
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const secsBetweenTLSReloadChecks = 30
+
+var certificateExpiryDaysGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "keymaster_certificate_expiry_days",
+		Help: "Days until the server TLS certificate or the X.509 CA certificate expires",
+	},
+	[]string{"type", "realm"},
+)
+
+func init() {
+	prometheus.MustRegister(certificateExpiryDaysGauge)
+}
+
+// serverTLSCertificate is the certificate of the HTTPS and gRPC listeners,
+// which is reloaded when its files change.
+type serverTLSCertificate struct {
+	state *RuntimeState
+	mutex sync.RWMutex // Protects all below.
+	cert  *tls.Certificate
+	files []fileStamp // Of the files cert was loaded from.
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func (state *RuntimeState) serverTLSFilenames() []string {
+	if state.serverTLSKeyInPKCS11() {
+		return []string{state.Config.Base.TLSCertFilename}
+	}
+	return []string{state.Config.Base.TLSCertFilename,
+		state.Config.Base.TLSKeyFilename}
+}
+
+func statFiles(filenames []string) ([]fileStamp, error) {
+	stamps := make([]fileStamp, 0, len(filenames))
+	for _, filename := range filenames {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{fi.Size(), fi.ModTime()})
+	}
+	return stamps, nil
+}
+
+func fileStampsEqual(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].size != b[i].size || !a[i].modTime.Equal(b[i].modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// newServerTLSCertificate loads the server TLS certificate of state.
+func (state *RuntimeState) newServerTLSCertificate() (
+	*serverTLSCertificate, error) {
+	files, err := statFiles(state.serverTLSFilenames())
+	if err != nil {
+		return nil, err
+	}
+	cert, err := state.loadServerTLSCertificate()
+	if err != nil {
+		return nil, err
+	}
+	if err := setCertificateLeaf(&cert); err != nil {
+		return nil, err
+	}
+	return &serverTLSCertificate{state: state, cert: &cert, files: files}, nil
+}
+
+func setCertificateLeaf(cert *tls.Certificate) error {
+	if cert.Leaf != nil {
+		return nil
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+	return nil
+}
+
+// GetCertificate is for tls.Config.GetCertificate.
+func (c *serverTLSCertificate) GetCertificate(
+	*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cert, nil
+}
+
+func (c *serverTLSCertificate) leaf() *x509.Certificate {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cert.Leaf
+}
+
+// reload loads the certificate again if its files changed since it was last
+// loaded, and returns whether it did. On failure the certificate in use is
+// kept, and the files are not tried again until they change once more. A
+// key in PKCS#11 is kept, only the certificate chain is read again.
+func (c *serverTLSCertificate) reload() (bool, error) {
+	files, err := statFiles(c.state.serverTLSFilenames())
+	if err != nil {
+		return false, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if fileStampsEqual(files, c.files) {
+		return false, nil
+	}
+	c.files = files
+	var cert tls.Certificate
+	if c.state.serverTLSKeyInPKCS11() {
+		certPEMBlock, err := ioutil.ReadFile(
+			c.state.Config.Base.TLSCertFilename)
+		if err != nil {
+			return false, err
+		}
+		cert, err = makeTLSCertificate(certPEMBlock,
+			c.cert.PrivateKey.(crypto.Signer))
+		if err != nil {
+			return false, err
+		}
+	} else {
+		cert, err = c.state.loadServerTLSCertificate()
+		if err != nil {
+			return false, err
+		}
+	}
+	if err := setCertificateLeaf(&cert); err != nil {
+		return false, err
+	}
+	c.cert = &cert
+	return true, nil
+}
+
+// caCertificateNotAfter returns when the X.509 certificate of the CA key
+// expires, or false while the key is sealed.
+func (state *RuntimeState) caCertificateNotAfter() (time.Time, bool) {
+	state.Mutex.Lock()
+	caCertDer := state.caCertDer
+	state.Mutex.Unlock()
+	if len(caCertDer) < 1 {
+		return time.Time{}, false
+	}
+	caCert, err := x509.ParseCertificate(caCertDer)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return caCert.NotAfter, true
+}
+
+func daysUntil(t time.Time, now time.Time) float64 {
+	return t.Sub(now).Hours() / 24
+}
+
+// checkServerTLSCertificate reloads the server TLS certificate of state if it
+// changed and updates the expiry metrics of state, labelled with realmName.
+func (state *RuntimeState) checkServerTLSCertificate(realmName string,
+	now time.Time) {
+	if c := state.serverTLSCertificate; c != nil {
+		if reloaded, err := c.reload(); err != nil {
+			logger.Errorf("Cannot reload TLS certificate %s: %s",
+				state.Config.Base.TLSCertFilename, err)
+		} else if reloaded {
+			logger.Printf("Reloaded TLS certificate %s, expires at %s",
+				state.Config.Base.TLSCertFilename,
+				c.leaf().NotAfter.Format(time.RFC3339))
+		}
+		certificateExpiryDaysGauge.WithLabelValues("server_tls", realmName).
+			Set(daysUntil(c.leaf().NotAfter, now))
+	}
+	// SSH CA keys do not expire, only their X.509 certificate does.
+	if notAfter, ok := state.caCertificateNotAfter(); ok {
+		certificateExpiryDaysGauge.WithLabelValues("x509_ca", realmName).
+			Set(daysUntil(notAfter, now))
+	}
+}
+
+func (state *RuntimeState) checkServerTLSCertificateLoop() {
+	for {
+		now := time.Now()
+		state.checkServerTLSCertificate("", now)
+		for _, realm := range state.realms {
+			realm.state.checkServerTLSCertificate(realm.name, now)
+		}
+		time.Sleep(secsBetweenTLSReloadChecks * time.Second)
+	}
+}
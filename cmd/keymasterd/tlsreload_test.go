@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func writeTestServerKeyPair(t *testing.T, state *RuntimeState,
+	commonName string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		DNSNames:     []string{commonName},
+	}
+	derCert, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(state.Config.Base.TLSCertFilename,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derCert}),
+		0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(state.Config.Base.TLSKeyFilename,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: derKey}),
+		0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// touchTestFiles moves the modification times of the files forward, so that
+// rewriting them within the timestamp granularity counts as a change.
+func touchTestFiles(t *testing.T, offset time.Duration,
+	filenames ...string) {
+	modTime := time.Now().Add(offset)
+	for _, filename := range filenames {
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func certificateExpiryDays(t *testing.T, certType, realmName string) float64 {
+	var metric dto.Metric
+	err := certificateExpiryDaysGauge.WithLabelValues(certType, realmName).
+		Write(&metric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetGauge().GetValue()
+}
+
+func TestServerTLSCertificateReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsreload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var state RuntimeState
+	state.Config.Base.TLSCertFilename = filepath.Join(dir, "cert.pem")
+	state.Config.Base.TLSKeyFilename = filepath.Join(dir, "key.pem")
+	now := time.Now()
+	writeTestServerKeyPair(t, &state, "old.example.com", now.Add(48*time.Hour))
+	serverCert, err := state.newServerTLSCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.serverTLSCertificate = serverCert
+	commonName := func() string {
+		cert, err := serverCert.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Leaf.Subject.CommonName
+	}
+	if reloaded, err := serverCert.reload(); err != nil || reloaded {
+		t.Fatalf("unchanged files reloaded: %v, %v", reloaded, err)
+	}
+	state.checkServerTLSCertificate("test", now)
+	if days := certificateExpiryDays(t, "server_tls", "test"); days < 1.9 ||
+		days > 2.1 {
+		t.Fatalf("expiry in %f days", days)
+	}
+
+	writeTestServerKeyPair(t, &state, "new.example.com",
+		now.Add(240*time.Hour))
+	touchTestFiles(t, time.Minute, state.serverTLSFilenames()...)
+	state.checkServerTLSCertificate("test", now)
+	if name := commonName(); name != "new.example.com" {
+		t.Fatalf("serving %s after reload", name)
+	}
+	if days := certificateExpiryDays(t, "server_tls", "test"); days < 9.9 ||
+		days > 10.1 {
+		t.Fatalf("expiry in %f days after reload", days)
+	}
+
+	// A key pair which does not match is not used, and tried only once.
+	err = ioutil.WriteFile(state.Config.Base.TLSKeyFilename,
+		[]byte("garbage"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	touchTestFiles(t, 2*time.Minute, state.Config.Base.TLSKeyFilename)
+	if _, err := serverCert.reload(); err == nil {
+		t.Fatal("bad key reloaded")
+	}
+	if name := commonName(); name != "new.example.com" {
+		t.Fatalf("serving %s after failed reload", name)
+	}
+	if reloaded, err := serverCert.reload(); err != nil || reloaded {
+		t.Fatalf("failed files retried: %v, %v", reloaded, err)
+	}
+}
+
+func TestCACertificateExpiryMetric(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	var sealedState RuntimeState
+	if _, ok := sealedState.caCertificateNotAfter(); ok {
+		t.Fatal("CA certificate while sealed")
+	}
+	state.caCertDer, _, err = generateCAChainDer(state, state.Signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notAfter, ok := state.caCertificateNotAfter()
+	if !ok {
+		t.Fatal("no CA certificate")
+	}
+	now := time.Now()
+	state.checkServerTLSCertificate("ca", now)
+	if days := certificateExpiryDays(t, "x509_ca", "ca"); days !=
+		daysUntil(notAfter, now) || days < 365 {
+		t.Fatalf("CA expiry in %f days", days)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/levellog"
+	"github.com/Symantec/keymaster/keymasterd/tracing"
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	logFieldTraceID      = "trace_id"
+	tracingServiceName   = "keymasterd"
+	tracingCloseTimeout  = 5 * time.Second
+	defaultSampleRatio   = 1.0
+	spanNameCASign       = "ca.sign"
+	spanNameLoadProfile  = "storage.load_profile"
+	spanNameSaveProfile  = "storage.save_profile"
+	spanNameLDAPGroups   = "ldap.groups"
+	spanNameLDAPAttrs    = "ldap.attributes"
+	spanNameLDAPBind     = "ldap.bind"
+	spanNamePasswordAuth = "password.authenticate"
+)
+
+// exportingTracer is the tracer set up last, whose dropped spans are
+// counted by tracingDroppedCounter.
+var exportingTracer struct {
+	mutex  sync.Mutex
+	tracer *tracing.Tracer // Protected by mutex.
+}
+
+var tracingDroppedCounter = prometheus.NewCounterFunc(
+	prometheus.CounterOpts{
+		Name: "keymaster_tracing_dropped_spans_total",
+		Help: "Spans which the tracing collector did not accept",
+	},
+	func() float64 {
+		exportingTracer.mutex.Lock()
+		tracer := exportingTracer.tracer
+		exportingTracer.mutex.Unlock()
+		return float64(tracer.Dropped())
+	},
+)
+
+func init() {
+	prometheus.MustRegister(tracingDroppedCounter)
+}
+
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP traces URL of the collector, e.g.
+	// http://otel-collector:4318/v1/traces. Tracing is off if empty.
+	Endpoint string `yaml:"endpoint"`
+	// Headers are sent with every export, e.g. for authentication.
+	Headers map[string]string `yaml:"headers" secret:"true"`
+	// SampleRatio is the fraction of the requests traced unless the
+	// caller sent a traceparent header. Default: 1.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+func (config TracingConfig) check() error {
+	if config.Endpoint == "" {
+		if len(config.Headers) > 0 || config.SampleRatio != 0 {
+			return errors.New("tracing: endpoint required")
+		}
+		return nil
+	}
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return fmt.Errorf("tracing: endpoint: %s", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("tracing: endpoint %s is not an http(s) URL",
+			config.Endpoint)
+	}
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		return fmt.Errorf("tracing: sample_ratio %g is not between 0 and 1",
+			config.SampleRatio)
+	}
+	return nil
+}
+
+func (state *RuntimeState) setupTracing() error {
+	config := state.Config.Tracing
+	if config.Endpoint == "" {
+		return nil
+	}
+	sampleRatio := config.SampleRatio
+	if sampleRatio == 0 {
+		sampleRatio = defaultSampleRatio
+	}
+	tracer, err := tracing.New(tracing.Params{
+		Endpoint:        config.Endpoint,
+		Headers:         config.Headers,
+		ServiceName:     tracingServiceName,
+		ServiceVersion:  Version,
+		ServiceInstance: state.HostIdentity,
+		SampleRatio:     sampleRatio,
+	}, logger.Module("tracing"))
+	if err != nil {
+		return err
+	}
+	state.tracer = tracer
+	exportingTracer.mutex.Lock()
+	exportingTracer.tracer = tracer
+	exportingTracer.mutex.Unlock()
+	return nil
+}
+
+func (state *RuntimeState) closeTracing() {
+	if err := state.tracer.Close(tracingCloseTimeout); err != nil {
+		logger.Warnf("%s", err)
+	}
+}
+
+// tracingHandler records a server span for every request, named after its
+// route in mux if it has one. It must be called within
+// requestLogFieldsHandler, which it adds the trace ID to.
+func (state *RuntimeState) tracingHandler(handler http.Handler,
+	mux *http.ServeMux) http.Handler {
+	if state.tracer == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Method
+		if _, pattern := mux.Handler(r); pattern != "" {
+			name += " " + pattern
+		}
+		ctx, span := state.tracer.Start(tracing.Extract(r.Context(), r.Header),
+			name, tracing.SpanKindServer)
+		defer span.End()
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("client.address", loginThrottleAddress(r))
+		if id := requestID(r); id != "" {
+			span.SetAttribute("http.request.header.x-request-id", id)
+		}
+		if traceID := span.TraceID(); traceID != "" {
+			if fields := levellog.FromContext(ctx); fields != nil {
+				fields.Set(logFieldTraceID, traceID)
+			}
+		}
+		handler.ServeHTTP(w, r.WithContext(ctx))
+		status := http.StatusOK
+		if loggingWriter, ok := w.(*instrumentedwriter.LoggingWriter); ok &&
+			loggingWriter.Status() != 0 {
+			status = loggingWriter.Status()
+		}
+		span.SetAttribute("http.response.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(status)))
+		}
+	})
+}
+
+// startSigningSpan starts the span of signing a certificate of certType with
+// the CA key while handling r. The caller must end it.
+func startSigningSpan(r *http.Request, certType string) *tracing.Span {
+	_, span := tracing.StartChild(r.Context(), spanNameCASign,
+		tracing.SpanKindInternal)
+	span.SetAttribute("keymaster.cert_type", certType)
+	return span
+}
+
+// endSpan ends span, failed if err is not nil.
+func endSpan(span *tracing.Span, err error) {
+	span.SetError(err)
+	span.End()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/levellog"
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+)
+
+func TestTracingConfigCheck(t *testing.T) {
+	for _, config := range []TracingConfig{
+		{SampleRatio: 0.5},
+		{Endpoint: "otel-collector:4318"},
+		{Endpoint: "http://otel-collector:4318/v1/traces", SampleRatio: 1.5},
+	} {
+		if err := config.check(); err == nil {
+			t.Errorf("%+v accepted", config)
+		}
+	}
+	if err := (TracingConfig{Endpoint: "https://otel.example.com/v1/traces",
+		SampleRatio: 0.1}).check(); err != nil {
+		t.Error(err)
+	}
+}
+
+type exportedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Status       struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+func TestTracingHandler(t *testing.T) {
+	exported := make(chan []exportedSpan, 1)
+	collector := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var request struct {
+				ResourceSpans []struct {
+					ScopeSpans []struct {
+						Spans []exportedSpan `json:"spans"`
+					} `json:"scopeSpans"`
+				} `json:"resourceSpans"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Error(err)
+				return
+			}
+			exported <- request.ResourceSpans[0].ScopeSpans[0].Spans
+		}))
+	defer collector.Close()
+	var state RuntimeState
+	state.HostIdentity = "keymaster-1"
+	state.Config.Tracing.Endpoint = collector.URL + "/v1/traces"
+	if err := state.setupTracing(); err != nil {
+		t.Fatal(err)
+	}
+	var loggedTraceID string
+	mux := http.NewServeMux()
+	mux.HandleFunc(certgenPath, func(w http.ResponseWriter, r *http.Request) {
+		loggedTraceID = levellog.FromContext(r.Context()).Get(logFieldTraceID)
+		span := startSigningSpan(r, "ssh")
+		endSpan(span, nil)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	handler := instrumentedwriter.NewLoggingHandler(
+		requestLogFieldsHandler(state.tracingHandler(mux, mux)), httpLogger{})
+	req := httptest.NewRequest("POST", "/certgen/username?type=ssh", nil)
+	req.Header.Set("traceparent",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	state.closeTracing()
+	var spans []exportedSpan
+	select {
+	case spans = <-exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no spans exported")
+	}
+	if len(spans) != 2 {
+		t.Fatalf("spans: %+v", spans)
+	}
+	signing, server := spans[0], spans[1]
+	// The IDs are case insensitive hexadecimal.
+	if server.Name != "POST /certgen/" ||
+		!strings.EqualFold(server.TraceID, "0af7651916cd43dd8448eb211c80319c") ||
+		!strings.EqualFold(server.ParentSpanID, "b7ad6b7169203331") ||
+		server.Status.Code != 2 {
+		t.Errorf("server span: %+v", server)
+	}
+	if signing.Name != spanNameCASign ||
+		!strings.EqualFold(signing.ParentSpanID, server.SpanID) {
+		t.Errorf("signing span: %+v", signing)
+	}
+	if !strings.EqualFold(loggedTraceID, server.TraceID) {
+		t.Errorf("logged trace ID %q", loggedTraceID)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+const trustCoveragePath = "/trustCoverage"
+
+type trustCoverageResponse struct {
+	TotalHosts       int               `json:"total_hosts"`
+	Fingerprints     map[string]int    `json:"fingerprints"`
+	KRLVersions      map[uint64]int    `json:"krl_versions"`
+	Coverage         map[string]string `json:"coverage"`
+	ActiveCA         string            `json:"active_ca"`
+	MinCoverage      float64           `json:"min_coverage"`
+	Fingerprint      string            `json:"fingerprint,omitempty"`
+	RotationAllowed  bool              `json:"rotation_allowed"`
+	RotationBlockMsg string            `json:"rotation_block_message,omitempty"`
+}
+
+// Host agents authenticate with their IP restricted certificate.
+func (state *RuntimeState) trustReportHandler(w http.ResponseWriter, r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	authUser, _, err := state.checkAuth(w, r, AuthTypeIPCertificate)
+	if err != nil {
+		requestLogger(r).Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+
+	var report proto.TrustReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		requestLogger(r).Debugf(1, "bad trust report from %s: %s", authUser, err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid trust report")
+		return
+	}
+	if report.Hostname == "" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Missing hostname")
+		return
+	}
+	if err := state.checkTrustReportHostname(authUser,
+		report.Hostname); err != nil {
+		requestLogger(r).Printf("trust report from %s rejected: %s", authUser,
+			err)
+		state.writeFailureResponse(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+	state.trustCoverage.Report(report.Hostname, report.CAFingerprints,
+		report.KRLVersion)
+	requestLogger(r).Debugf(2, "trust report from %s for %s: %+v", authUser,
+		report.Hostname, report)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "OK\n")
+}
+
+// checkTrustReportHostname returns an error unless the host with the given
+// identity may report for hostname: one of its names in the host inventory
+// or, if it is not in the inventory, its identity.
+func (state *RuntimeState) checkTrustReportHostname(identity,
+	hostname string) error {
+	host, ok := state.lookupHost(identity)
+	if !ok {
+		if strings.EqualFold(hostname, identity) {
+			return nil
+		}
+		return fmt.Errorf("%s may only report for itself", identity)
+	}
+	return host.CheckNames([]string{hostname}, nil)
+}
+
+// checkCARotationCoverage returns an error if rotating to the CA with the
+// given fingerprint should be blocked because not enough hosts trust it yet.
+// If coverage is not enforced it only logs a warning.
+func (state *RuntimeState) checkCARotationCoverage(fingerprint string) error {
+	config := state.Config.TrustCoverage
+	coverage := state.trustCoverage.GetCoverage().FingerprintCoverage(fingerprint)
+	if coverage >= config.MinCoverage {
+		return nil
+	}
+	msg := fmt.Sprintf("CA %s is trusted by %.1f%% of hosts, below the required %.1f%%",
+		fingerprint, coverage*100, config.MinCoverage*100)
+	if !config.EnforceCoverage {
+		logger.Warnf("Warning: %s", msg)
+		return nil
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// trustCoverageHandler is served on the admin port and reports the trust
+// coverage. With a fingerprint query parameter it also reports whether
+// rotating to that CA would be allowed.
+func (state *RuntimeState) trustCoverageHandler(w http.ResponseWriter, r *http.Request) {
+	coverage := state.trustCoverage.GetCoverage()
+	response := trustCoverageResponse{
+		TotalHosts:   coverage.TotalHosts,
+		Fingerprints: coverage.Fingerprints,
+		KRLVersions:  coverage.KRLVersions,
+		Coverage:     make(map[string]string),
+		MinCoverage:  state.Config.TrustCoverage.MinCoverage,
+	}
+	for fingerprint := range coverage.Fingerprints {
+		response.Coverage[fingerprint] = fmt.Sprintf("%.1f%%",
+			coverage.FingerprintCoverage(fingerprint)*100)
+	}
+	state.Mutex.Lock()
+	signer := state.Signer
+	state.Mutex.Unlock()
+	if signer != nil {
+		activeCA, err := getKeyFingerprint(signer.Public())
+		if err != nil {
+			requestLogger(r).Errorf("%s", err)
+		}
+		response.ActiveCA = activeCA
+	}
+	if fingerprint := r.URL.Query().Get("fingerprint"); fingerprint != "" {
+		response.Fingerprint = fingerprint
+		response.RotationAllowed = true
+		if err := state.checkCARotationCoverage(fingerprint); err != nil {
+			response.RotationAllowed = false
+			response.RotationBlockMsg = err.Error()
+		}
+	}
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/hostinventory"
+	"github.com/Symantec/keymaster/keymasterd/trustcoverage"
+	"io/ioutil"
+)
+
+func TestCheckCARotationCoverage(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.trustCoverage = trustcoverage.New(time.Hour)
+	state.Config.TrustCoverage.MinCoverage = 0.75
+	state.trustCoverage.Report("host1", []string{"old", "new"}, 1)
+	state.trustCoverage.Report("host2", []string{"old"}, 1)
+
+	// Only warns when not enforced
+	if err := state.checkCARotationCoverage("new"); err != nil {
+		t.Fatal(err)
+	}
+	state.Config.TrustCoverage.EnforceCoverage = true
+	if err := state.checkCARotationCoverage("new"); err == nil {
+		t.Fatal("rotation should have been blocked")
+	}
+	if err := state.checkCARotationCoverage("old"); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", trustCoveragePath+"?fingerprint=new", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req, state.trustCoverageHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response trustCoverageResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.TotalHosts != 2 || response.RotationAllowed {
+		t.Fatalf("unexpected response %+v", response)
+	}
+}
+
+func TestCheckTrustReportHostname(t *testing.T) {
+	var state RuntimeState
+	if err := state.checkTrustReportHostname("host1.example.com",
+		"Host1.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.checkTrustReportHostname("host1.example.com",
+		"host2.example.com"); err == nil {
+		t.Fatal("report for another host accepted")
+	}
+	// An inventory entry lists the names the host may report for.
+	inventoryFile, err := ioutil.TempFile("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(inventoryFile.Name())
+	_, err = inventoryFile.WriteString(`hosts:
+  - identity: agent1
+    dns_names: [host1.example.com, host1]
+`)
+	inventoryFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.hostInventory, err = hostinventory.Load(inventoryFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.checkTrustReportHostname("agent1", "host1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, hostname := range []string{"agent1", "host2.example.com"} {
+		if err := state.checkTrustReportHostname("agent1",
+			hostname); err == nil {
+			t.Errorf("report for %s accepted", hostname)
+		}
+	}
+}
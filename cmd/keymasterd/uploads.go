@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// Public keys are small, even a full set of them.
+	maxUploadBodySize = 1 << 20
+	maxUploadFileSize = 256 << 10
+	// Form fields other than files, such as a pasted key.
+	maxUploadValueSize = 256 << 10
+	maxUploadParts     = 2*maxSSHPublicKeysPerRequest + 16
+)
+
+var errUploadTooLarge = errors.New("upload too large")
+
+type uploadedFilesKey struct{}
+
+// cappedBody is a request body which fails once more than remaining bytes
+// are read from it.
+type cappedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (body *cappedBody) Read(p []byte) (int, error) {
+	if body.remaining <= 0 {
+		// Only an error if there is more.
+		var probe [1]byte
+		n, err := body.ReadCloser.Read(probe[:])
+		if n > 0 {
+			body.exceeded = true
+			return 0, errUploadTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > body.remaining {
+		p = p[:body.remaining]
+	}
+	n, err := body.ReadCloser.Read(p)
+	body.remaining -= int64(n)
+	return n, err
+}
+
+func metricLogRejectedUpload(reason string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	rejectedUploadCounter.WithLabelValues(reason).Inc()
+}
+
+// readUploadPart returns the contents of part, at most limit bytes.
+func readUploadPart(part *multipart.Part, limit int64,
+	reason string) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(part, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		metricLogRejectedUpload(reason)
+		return nil, errUploadTooLarge
+	}
+	return data, nil
+}
+
+// parseUploadForm is like r.ParseMultipartForm, it returns
+// http.ErrNotMultipart once the URL and URL encoded forms are parsed if the
+// body is not multipart, but parses the multipart body as it is streamed.
+// Files are kept in memory and never spill to temporary files which would have
+// to be removed. They are retrieved with uploadedFiles from the returned
+// request, which is r if there was an error. Bodies, files and fields over
+// their size limit are rejected with errUploadTooLarge.
+func parseUploadForm(r *http.Request) (*http.Request, error) {
+	body := &cappedBody{ReadCloser: r.Body, remaining: maxUploadBodySize}
+	r.Body = body
+	tooLarge := func(err error) error {
+		if body.exceeded {
+			metricLogRejectedUpload("body_too_large")
+			return errUploadTooLarge
+		}
+		return err
+	}
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		if err := r.ParseForm(); err != nil {
+			return r, tooLarge(err)
+		}
+		return r, http.ErrNotMultipart
+	}
+	if params["boundary"] == "" {
+		return r, http.ErrMissingBoundary
+	}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	values := make(url.Values)
+	files := make(map[string][][]byte)
+	for parts := 0; ; parts++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return r, tooLarge(err)
+		}
+		if parts >= maxUploadParts {
+			metricLogRejectedUpload("too_many_parts")
+			return r, errUploadTooLarge
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+		var data []byte
+		if part.FileName() != "" {
+			data, err = readUploadPart(part, maxUploadFileSize, "file_too_large")
+			if err == nil {
+				files[name] = append(files[name], data)
+			}
+		} else {
+			data, err = readUploadPart(part, maxUploadValueSize,
+				"field_too_large")
+			if err == nil {
+				values.Add(name, string(data))
+			}
+		}
+		if err != nil {
+			return r, tooLarge(err)
+		}
+	}
+	// As in ParseMultipartForm the URL comes first.
+	form := r.URL.Query()
+	for name, value := range values {
+		form[name] = append(form[name], value...)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), uploadedFilesKey{},
+		files))
+	r.Form = form
+	r.PostForm = values
+	r.MultipartForm = &multipart.Form{Value: values}
+	return r, nil
+}
+
+// uploadedFiles returns the contents of the files uploaded as name. Like
+// r.FormFile it parses the form if that was not done yet.
+func uploadedFiles(r *http.Request, name string) [][]byte {
+	if r.MultipartForm == nil {
+		parsed, err := parseUploadForm(r)
+		if err != nil {
+			return nil
+		}
+		r.Form = parsed.Form
+		r.PostForm = parsed.PostForm
+		r.MultipartForm = parsed.MultipartForm
+		r = parsed
+	}
+	files, _ := r.Context().Value(uploadedFilesKey{}).(map[string][][]byte)
+	return files[name]
+}
+
+// writeUploadFormError responds to a failure of parseUploadForm.
+func (state *RuntimeState) writeUploadFormError(w http.ResponseWriter,
+	r *http.Request, err error) {
+	requestLogger(r).Errorf("%s", err)
+	if err == errUploadTooLarge {
+		state.writeFailureResponse(w, r, http.StatusRequestEntityTooLarge,
+			"Upload too large")
+		return
+	}
+	state.writeFailureResponse(w, r, http.StatusBadRequest,
+		"Error parsing form")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func createUploadRequest(t *testing.T, files map[string][]string,
+	fields map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, contents := range files {
+		for _, data := range contents {
+			fileWriter, err := writer.CreateFormFile(name, name+".pub")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := fileWriter.Write([]byte(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/upload?duration=1h", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestParseUploadForm(t *testing.T) {
+	req := createUploadRequest(t,
+		map[string][]string{"pubkeyfile": {"key1\n", "key2\n"}},
+		map[string]string{"duration": "30m"})
+	req, err := parseUploadForm(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := uploadedFiles(req, "pubkeyfile"); len(files) != 2 ||
+		string(files[1]) != "key2\n" {
+		t.Errorf("unexpected files: %q", files)
+	}
+	if durations := req.Form["duration"]; len(durations) != 2 ||
+		durations[0] != "1h" || req.PostForm.Get("duration") != "30m" {
+		t.Errorf("unexpected form: %v", req.Form)
+	}
+	keys, err := getSSHPublicKeysFromForm(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("unexpected keys: %q", keys)
+	}
+
+	req, err = http.NewRequest("POST", "/upload",
+		strings.NewReader("pubkey=key1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := parseUploadForm(req); err != http.ErrNotMultipart {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := getPublicKeyDataFromForm(req); err != nil ||
+		string(data) != "key1\n" {
+		t.Errorf("unexpected pasted key: %q, %v", data, err)
+	}
+}
+
+func TestParseUploadFormLimits(t *testing.T) {
+	tooManyFiles := make([]string, maxUploadParts+1)
+	for index := range tooManyFiles {
+		tooManyFiles[index] = "key\n"
+	}
+	for name, req := range map[string]*http.Request{
+		"file": createUploadRequest(t, map[string][]string{
+			"pubkeyfile": {strings.Repeat("k", maxUploadFileSize+1)}}, nil),
+		"field": createUploadRequest(t, nil, map[string]string{
+			"pubkey": strings.Repeat("k", maxUploadValueSize+1)}),
+		"body": createUploadRequest(t, map[string][]string{"pubkeyfile": {
+			strings.Repeat("k", maxUploadFileSize),
+			strings.Repeat("k", maxUploadFileSize),
+			strings.Repeat("k", maxUploadFileSize),
+			strings.Repeat("k", maxUploadFileSize),
+		}}, nil),
+		"parts": createUploadRequest(t,
+			map[string][]string{"pubkeyfile": tooManyFiles}, nil),
+	} {
+		if _, err := parseUploadForm(req); err != errUploadTooLarge {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+	}
+}
+
+func TestCertGenOversizedUpload(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	req, err := createKeyBodyRequest("POST", certgenPath+"username",
+		strings.Repeat(testUserSSHPublicKey+"\n", maxUploadFileSize/
+			len(testUserSSHPublicKey)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username",
+		AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	_, err = checkRequestHandlerCode(req, state.certGenHandler,
+		http.StatusRequestEntityTooLarge)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/usageanalytics"
+	"golang.org/x/crypto/ssh"
+)
+
+const usageAnalyticsPath = "/usageAnalytics"
+
+const (
+	defaultUsageAnalyticsWindow = 7 * 24 * time.Hour
+	maxUsageAnalyticsWindow     = 400 * 24 * time.Hour
+)
+
+// describePublicKey returns the algorithm and size of key, e.g. "RSA-2048",
+// "ECDSA-P-256" or "Ed25519".
+func describePublicKey(key crypto.PublicKey) string {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA-" + key.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return fmt.Sprintf("%T", key)
+}
+
+// describeSSHCertKey describes the key certified by the marshalled SSH
+// certificate certBytes.
+func describeSSHCertKey(certBytes []byte) string {
+	pubKey, err := ssh.ParsePublicKey(certBytes)
+	if err != nil {
+		return ""
+	}
+	if cert, ok := pubKey.(*ssh.Certificate); ok {
+		pubKey = cert.Key
+	}
+	if cryptoKey, ok := pubKey.(ssh.CryptoPublicKey); ok {
+		return describePublicKey(cryptoKey.CryptoPublicKey())
+	}
+	return pubKey.Type()
+}
+
+// parseUsageWindow accepts Go durations and a number of days, e.g. "30d".
+func parseUsageWindow(window string) (time.Duration, error) {
+	if strings.HasSuffix(window, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(window, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(window)
+}
+
+// usageAnalyticsHandler is served on the admin port and summarises issuance
+// over the window query parameter (default 7d) ending at end (RFC3339,
+// default now), in buckets of the granularity parameter (hour or day).
+func (state *RuntimeState) usageAnalyticsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != "GET" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	window := defaultUsageAnalyticsWindow
+	if value := r.URL.Query().Get("window"); value != "" {
+		var err error
+		window, err = parseUsageWindow(value)
+		if err != nil || window <= 0 || window > maxUsageAnalyticsWindow {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Bad window: "+value)
+			return
+		}
+	}
+	end := time.Now()
+	if value := r.URL.Query().Get("end"); value != "" {
+		var err error
+		end, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Bad end: "+value)
+			return
+		}
+	}
+	granularity := r.URL.Query().Get("granularity")
+	if granularity == "" {
+		granularity = usageanalytics.Daily
+		if window <= 48*time.Hour {
+			granularity = usageanalytics.Hourly
+		}
+	}
+	start := end.Add(-window)
+	events, err := state.attestationLog.Events(start, end)
+	if err != nil {
+		requestLogger(r).Errorf("Cannot read attestation log: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	summary, err := usageanalytics.Summarise(events, start, end, granularity)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+	"github.com/Symantec/keymaster/keymasterd/usageanalytics"
+	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDescribeSSHCertKey(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	signer, err := ssh.NewSignerFromSigner(state.Signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, certBytes, err := certgen.GenSSHCertFileString("username",
+		testUserSSHPublicKey, signer, "host", testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyType := describeSSHCertKey(certBytes); !strings.HasPrefix(keyType,
+		"RSA-") {
+		t.Fatalf("unexpected key type %q", keyType)
+	}
+	if keyType := describeSSHCertKey([]byte("junk")); keyType != "" {
+		t.Fatalf("unexpected key type %q for junk", keyType)
+	}
+}
+
+func TestUsageAnalyticsHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "usageanalytics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state.attestationLog, err = attestation.Open(filepath.Join(dir,
+		attestationLogFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.recordIssuedCert("user1", "ssh", "Ed25519",
+		AuthTypePassword|AuthTypeU2F, time.Hour)
+	state.recordIssuedCert("user1", "x509", "RSA-2048", AuthTypePassword,
+		time.Hour)
+
+	for _, query := range []string{"?window=forever", "?window=1000d",
+		"?end=yesterday", "?granularity=week"} {
+		req, err := http.NewRequest("GET", usageAnalyticsPath+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = checkRequestHandlerCode(req, state.usageAnalyticsHandler,
+			http.StatusBadRequest)
+		if err != nil {
+			t.Fatalf("%s: %s", query, err)
+		}
+	}
+	req, err := http.NewRequest("GET", usageAnalyticsPath+"?window=24h", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req, state.usageAnalyticsHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary usageanalytics.Summary
+	if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Granularity != usageanalytics.Hourly || summary.Issued != 2 ||
+		summary.UniqueUsers != 1 || summary.ByKeyType["Ed25519"] != 1 ||
+		summary.ByAuthBackend[proto.AuthTypePassword] != 2 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+}
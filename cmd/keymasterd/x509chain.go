@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignatureFrom(cert) == nil
+}
+
+// buildX509CAChain orders certs into the chain from the first one up to the
+// root or the last issuer found. All certificates must be part of the chain.
+func buildX509CAChain(certs []*x509.Certificate) ([]*x509.Certificate,
+	error) {
+	chain := certs[:1]
+	remaining := append([]*x509.Certificate(nil), certs[1:]...)
+	for {
+		current := chain[len(chain)-1]
+		if isSelfSigned(current) {
+			break
+		}
+		issuerIndex := -1
+		for index, candidate := range remaining {
+			if bytes.Equal(current.RawIssuer, candidate.RawSubject) &&
+				current.CheckSignatureFrom(candidate) == nil {
+				issuerIndex = index
+				break
+			}
+		}
+		if issuerIndex < 0 {
+			break
+		}
+		chain = append(chain, remaining[issuerIndex])
+		remaining = append(remaining[:issuerIndex],
+			remaining[issuerIndex+1:]...)
+	}
+	if len(remaining) > 0 {
+		return nil, fmt.Errorf("%q is not in the chain of %q",
+			remaining[0].Subject.String(), certs[0].Subject.String())
+	}
+	return chain, nil
+}
+
+// loadX509CAChain reads the X.509 certificate of signer, which must come
+// first in filename, and the certificates of the CAs above it when it is an
+// intermediate CA. It returns the certificate of signer and, if it is an
+// intermediate, the chain from it up to the root. The root is left out,
+// clients must already trust it.
+func loadX509CAChain(filename string, signer crypto.Signer) ([]byte,
+	[][]byte, error) {
+	certs, err := readCertificates(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkPublicKeyMatch(certs[0].PublicKey, signer); err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	chain, err := buildX509CAChain(certs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	var intermediates [][]byte
+	for _, cert := range chain {
+		if !isSelfSigned(cert) {
+			intermediates = append(intermediates, cert.Raw)
+		}
+	}
+	return chain[0].Raw, intermediates, nil
+}
+
+// x509Issuer returns the X.509 CA certificate of keySigner and the
+// intermediate CA certificates, the issuing CA first, which follow the
+// certificates it issues. It fails if a CA rollover replaced keySigner since
+// the caller read it.
+func (state *RuntimeState) x509Issuer(keySigner crypto.Signer) (
+	*x509.Certificate, []byte, error) {
+	ca := state.currentCA()
+	if ca.signer == nil ||
+		!publicKeysEqual(ca.signer.Public(), keySigner.Public()) {
+		return nil, nil, errCAKeyChanged
+	}
+	caCert, err := x509.ParseCertificate(ca.certDer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot parse CA certificate: %s", err)
+	}
+	var buffer bytes.Buffer
+	for _, der := range ca.chainDer {
+		pem.Encode(&buffer, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	return caCert, buffer.Bytes(), nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCACert(t *testing.T, commonName string, pub crypto.PublicKey,
+	parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub,
+		parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+type testCAHierarchy struct {
+	root, intermediate, issuing *x509.Certificate
+}
+
+// newTestCAHierarchy returns a root, an intermediate CA and below it the
+// issuing CA of signer.
+func newTestCAHierarchy(t *testing.T, signer crypto.Signer) testCAHierarchy {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := newTestCACert(t, "root", rootKey.Public(), nil, rootKey)
+	intermediate := newTestCACert(t, "intermediate", intermediateKey.Public(),
+		root, rootKey)
+	return testCAHierarchy{
+		root:         root,
+		intermediate: intermediate,
+		issuing: newTestCACert(t, "issuing", signer.Public(), intermediate,
+			intermediateKey),
+	}
+}
+
+func writeTestCertificates(t *testing.T, filename string,
+	certs ...*x509.Certificate) {
+	var buffer bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&buffer, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	if err := ioutil.WriteFile(filename, buffer.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadX509CAChain(t *testing.T) {
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newTestCAHierarchy(t, signer)
+	dir, err := ioutil.TempDir("", "x509chain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "ca.pem")
+
+	// The chain is built whatever the order of the issuers.
+	writeTestCertificates(t, filename, ca.issuing, ca.root, ca.intermediate)
+	der, chain, err := loadX509CAChain(filename, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(der, ca.issuing.Raw) || len(chain) != 2 ||
+		!bytes.Equal(chain[0], ca.issuing.Raw) ||
+		!bytes.Equal(chain[1], ca.intermediate.Raw) {
+		t.Errorf("unexpected chain of %d certificates", len(chain))
+	}
+	writeTestCertificates(t, filename, ca.issuing)
+	if _, chain, err := loadX509CAChain(filename, signer); err != nil {
+		t.Fatal(err)
+	} else if len(chain) != 1 {
+		t.Errorf("unexpected chain of %d certificates", len(chain))
+	}
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := newTestCACert(t, "root", rootKey.Public(), nil, rootKey)
+	writeTestCertificates(t, filename, root)
+	if _, chain, err := loadX509CAChain(filename, rootKey); err != nil {
+		t.Fatal(err)
+	} else if len(chain) != 0 {
+		t.Errorf("chain of %d certificates for a root", len(chain))
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newTestCACert(t, "other", otherKey.Public(), nil, otherKey)
+	writeTestCertificates(t, filename, ca.issuing, ca.intermediate, other)
+	if _, _, err := loadX509CAChain(filename, signer); err == nil {
+		t.Error("unrelated certificate accepted")
+	}
+	writeTestCertificates(t, filename, ca.intermediate, ca.issuing)
+	if _, _, err := loadX509CAChain(filename, signer); err == nil {
+		t.Error("certificate of another key accepted")
+	}
+}
+
+func TestX509CertificateIncludesChain(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	ca := newTestCAHierarchy(t, state.Signer)
+	state.caCertDer = ca.issuing.Raw
+	state.caChainDer = [][]byte{ca.issuing.Raw, ca.intermediate.Raw}
+
+	req, err := createKeyBodyRequest("POST", "/certgen/username?type=x509",
+		testUserPEMPublicKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	rr, err := checkRequestHandlerCode(req, state.certGenHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certs []*x509.Certificate
+	for data := rr.Body.Bytes(); ; {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) != 3 {
+		t.Fatalf("%d certificates", len(certs))
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.root)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(certs[1])
+	intermediates.AddCert(certs[2])
+	_, err = certs[0].Verify(x509.VerifyOptions{Roots: roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		t.Errorf("issued certificate does not chain to the root: %s", err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+)
+
+// The revoked X.509 certificates are published as a CRL under publicPath,
+// and issued X.509 certificates point to it.
+const (
+	x509CRLName                = "x509.crl"
+	defaultX509CRLValiditySecs = 86400
+)
+
+var errX509CRLSignNotAllowed = errors.New(
+	"X.509 CA certificate does not allow CRL signing")
+
+// X509CRLConfig configures the CRL of the X.509 CA.
+type X509CRLConfig struct {
+	// DistributionPointURL is the CRL distribution point of issued X.509
+	// user certificates. Default: the CRL under /public/ on the service port.
+	DistributionPointURL string `yaml:"distribution_point_url"`
+	// Each CRL is valid for this long and a new one is signed when half of
+	// it is over or a certificate is revoked. Default: 86400.
+	ValiditySecs uint `yaml:"validity_secs"`
+}
+
+func (config *X509CRLConfig) check() error {
+	if config.DistributionPointURL == "" {
+		return nil
+	}
+	u, err := url.Parse(config.DistributionPointURL)
+	if err != nil {
+		return fmt.Errorf("x509_crl: distribution_point_url: %s", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("x509_crl: distribution_point_url is not an HTTP URL: %s",
+			config.DistributionPointURL)
+	}
+	return nil
+}
+
+func (config *X509CRLConfig) validity() time.Duration {
+	secs := config.ValiditySecs
+	if secs < 1 {
+		secs = defaultX509CRLValiditySecs
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// x509CRLCache holds the CRL for a version of the revocation list and a CA
+// certificate.
+type x509CRLCache struct {
+	mutex      sync.Mutex
+	version    uint64
+	caCertDer  []byte
+	number     int64
+	thisUpdate time.Time
+	crl        []byte
+}
+
+func allowsCRLSigning(caCert *x509.Certificate) bool {
+	return caCert.KeyUsage&x509.KeyUsageCRLSign != 0
+}
+
+// x509CRLDistributionPoints returns the CRL distribution points of the X.509
+// certificates issued by caCert, none if it cannot sign CRLs.
+func (state *RuntimeState) x509CRLDistributionPoints(
+	caCert *x509.Certificate) []string {
+	if !allowsCRLSigning(caCert) {
+		return nil
+	}
+	if config := state.Config.X509CRL; config.DistributionPointURL != "" {
+		return []string{config.DistributionPointURL}
+	}
+	return []string{"https://" + state.HostIdentity +
+		state.publicPortSuffix() + publicPath + x509CRLName}
+}
+
+// x509CRL returns the CRL signed by the current X.509 CA.
+func (state *RuntimeState) x509CRL(now time.Time) ([]byte, error) {
+	entries := state.revokedCerts.List()
+	state.Mutex.Lock()
+	signer := state.Signer
+	caCertDer := state.caCertDer
+	state.Mutex.Unlock()
+	if signer == nil {
+		return nil, errors.New("signer not loaded")
+	}
+	caCert, err := x509.ParseCertificate(caCertDer)
+	if err != nil {
+		return nil, err
+	}
+	if !allowsCRLSigning(caCert) {
+		return nil, errX509CRLSignNotAllowed
+	}
+	validity := state.Config.X509CRL.validity()
+	cache := &state.x509CRLCache
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.crl != nil && cache.version == uint64(len(entries)) &&
+		bytes.Equal(cache.caCertDer, caCertDer) &&
+		now.Before(cache.thisUpdate.Add(validity/2)) {
+		return cache.crl, nil
+	}
+	// SSH serials are listed too: they cannot match the random 128 bit
+	// serials of X.509 certificates.
+	var revoked []x509.RevocationListEntry
+	for _, entry := range entries {
+		serial, ok := new(big.Int).SetString(entry.Serial, 10)
+		if !ok || serial.Sign() <= 0 {
+			continue
+		}
+		revoked = append(revoked, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: entry.Time,
+		})
+	}
+	// The CRL number must grow with each CRL, across restarts too.
+	number := now.Unix()
+	if number <= cache.number {
+		number = cache.number + 1
+	}
+	crl, err := certgen.GenX509CRL(caCert, signer, revoked,
+		big.NewInt(number), now, now.Add(validity))
+	if err != nil {
+		return nil, err
+	}
+	cache.version = uint64(len(entries))
+	cache.caCertDer = caCertDer
+	cache.number = number
+	cache.thisUpdate = now
+	cache.crl = crl
+	return crl, nil
+}
+
+func (state *RuntimeState) writeX509CRL(w http.ResponseWriter,
+	r *http.Request) {
+	crl, err := state.x509CRL(time.Now())
+	if err == errX509CRLSignNotAllowed {
+		state.writeFailureResponse(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		requestLogger(r).Errorf("Cannot generate X.509 CRL: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(crl)
+}
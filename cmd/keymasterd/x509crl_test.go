@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/revocationlist"
+)
+
+func TestX509CRL(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	dir, err := ioutil.TempDir("", "x509crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	state.revokedCerts, err = revocationlist.Open(filepath.Join(dir,
+		revokedCertsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.HostIdentity = "keymaster.example.com"
+	state.Config.Base.PublicPort = 443
+	caCert, err := x509.ParseCertificate(state.caCertDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Issued certificates point to the CRL.
+	cookieVal, err := state.setNewAuthCookie(nil, nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := createKeyBodyRequest("POST", "/certgen/username?type=x509",
+		testUserPEMPublicKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	rr, err := checkRequestHandlerCode(req, state.certGenHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(rr.Body.Bytes())
+	if block == nil {
+		t.Fatal("no certificate returned")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const crlURL = "https://keymaster.example.com/public/x509.crl"
+	if len(cert.CRLDistributionPoints) != 1 ||
+		cert.CRLDistributionPoints[0] != crlURL {
+		t.Fatalf("unexpected CDPs: %v", cert.CRLDistributionPoints)
+	}
+
+	getCRL := func() *x509.RevocationList {
+		req, err := http.NewRequest("GET", publicPath+x509CRLName, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := checkRequestHandlerCode(req, state.publicPathHandler,
+			http.StatusOK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crl, err := x509.ParseRevocationList(rr.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := crl.CheckSignatureFrom(caCert); err != nil {
+			t.Fatal(err)
+		}
+		return crl
+	}
+	empty := getCRL()
+	if len(empty.RevokedCertificateEntries) != 0 {
+		t.Errorf("unexpected entries: %v", empty.RevokedCertificateEntries)
+	}
+	if again := getCRL(); again.Number.Cmp(empty.Number) != 0 {
+		t.Error("CRL signed again without a revocation")
+	}
+	_, _, err = state.revokedCerts.Revoke(cert.SerialNumber.String(),
+		"laptop stolen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl := getCRL()
+	if len(crl.RevokedCertificateEntries) != 1 ||
+		crl.RevokedCertificateEntries[0].SerialNumber.Cmp(
+			cert.SerialNumber) != 0 {
+		t.Fatalf("unexpected entries: %v", crl.RevokedCertificateEntries)
+	}
+	if crl.Number.Cmp(empty.Number) <= 0 {
+		t.Errorf("CRL number %s not above %s", crl.Number, empty.Number)
+	}
+	if !crl.NextUpdate.After(time.Now().Add(23 * time.Hour)) {
+		t.Errorf("unexpected next update: %s", crl.NextUpdate)
+	}
+
+	// A CA certificate which cannot sign CRLs gets no CDP and no CRL.
+	block, _ = pem.Decode([]byte(testSignerX509Cert))
+	state.caCertDer = block.Bytes
+	caCert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if points := state.x509CRLDistributionPoints(caCert); len(points) != 0 {
+		t.Errorf("unexpected CDPs: %v", points)
+	}
+	req, err = http.NewRequest("GET", publicPath+x509CRLName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestX509CRLConfigCheck(t *testing.T) {
+	for url, valid := range map[string]bool{
+		"":                                  true,
+		"http://crl.example.com/x509.crl":   true,
+		"ldap://ldap.example.com/cn=crl":    false,
+		"crl.example.com/x509.crl":          false,
+		"https://keymaster.example.com/crl": true,
+	} {
+		config := X509CRLConfig{DistributionPointURL: url}
+		if err := config.check(); (err == nil) != valid {
+			t.Errorf("%q: %v", url, err)
+		}
+	}
+}
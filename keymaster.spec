@@ -33,6 +33,7 @@ make
 %{__install} -Dp -m0755 ~/go/bin/keymasterd %{buildroot}%{_sbindir}/keymasterd
 %{__install} -Dp -m0755 ~/go/bin/keymaster %{buildroot}%{_bindir}/keymaster
 %{__install} -Dp -m0755 ~/go/bin/keymaster-unlocker %{buildroot}%{_bindir}/keymaster-unlocker
+%{__install} -Dp -m0755 ~/go/bin/keymaster-proxy %{buildroot}%{_sbindir}/keymaster-proxy
 install -d %{buildroot}/usr/lib/systemd/system
 install -p -m 0644 misc/startup/keymaster.service %{buildroot}/usr/lib/systemd/system/keymaster.service
 install -d %{buildroot}/%{_datarootdir}/keymasterd/static_files/
@@ -40,6 +41,7 @@ install -p -m 0644 cmd/keymasterd/static_files/u2f-api.js  %{buildroot}/%{_datar
 install -p -m 0644 cmd/keymasterd/static_files/keymaster-u2f.js  %{buildroot}/%{_datarootdir}/keymasterd/static_files/keymaster-u2f.js
 install -p -m 0644 cmd/keymasterd/static_files/webui-2fa-u2f.js  %{buildroot}/%{_datarootdir}/keymasterd/static_files/webui-2fa-u2f.js
 install -p -m 0644 cmd/keymasterd/static_files/webui-2fa-symc-vip.js  %{buildroot}/%{_datarootdir}/keymasterd/static_files/webui-2fa-symc-vip.js
+install -p -m 0644 cmd/keymasterd/static_files/webui-2fa-duo.js  %{buildroot}/%{_datarootdir}/keymasterd/static_files/webui-2fa-duo.js
 install -p -m 0644 cmd/keymasterd/static_files/keymaster.css  %{buildroot}/%{_datarootdir}/keymasterd/static_files/keymaster.css
 install -p -m 0644 cmd/keymasterd/static_files/jquery-3.4.1.min.js %{buildroot}/%{_datarootdir}/keymasterd/static_files/jquery-3.4.1.min.js
 install -p -m 0644 cmd/keymasterd/static_files/favicon.ico %{buildroot}/%{_datarootdir}/keymasterd/static_files/favicon.ico
@@ -67,6 +69,7 @@ systemctl daemon-reload
 %{_sbindir}/keymasterd
 %{_bindir}/keymaster
 %{_bindir}/keymaster-unlocker
+%{_sbindir}/keymaster-proxy
 /usr/lib/systemd/system/keymaster.service
 %{_datarootdir}/keymasterd/static_files/*
 %config(noreplace) %{_datarootdir}/keymasterd/customization_data/web_resources/*
@@ -0,0 +1,69 @@
+// Package accessreview writes access review reports, which list for every
+// known user the principals they can currently obtain certificates for, as
+// input to periodic access reviews.
+package accessreview
+
+import (
+	"io"
+	"time"
+)
+
+// The kinds of grants.
+const (
+	GrantSelf       = "self"
+	GrantRole       = "role"
+	GrantDelegation = "delegation"
+	GrantBreakGlass = "break_glass"
+)
+
+// Grant is one way a user can obtain certificates.
+type Grant struct {
+	Kind string `json:"kind"`
+	// Source names the configuration which grants it, e.g. "role ansible"
+	// or "delegation rule 2".
+	Source string `json:"source,omitempty"`
+	// Principals of the certificates, or for delegations the patterns of
+	// the users they may be requested for.
+	Principals      []string `json:"principals"`
+	CertTypes       []string `json:"cert_types"`
+	MaxDurationSecs uint64   `json:"max_duration_secs"`
+}
+
+// User is the access of one user.
+type User struct {
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+	// AuthMethods may be used for certificates, DuoRequired is true if a
+	// Duo push is required whatever the method and Automation is true if
+	// IP restricted certificates are accepted.
+	AuthMethods []string `json:"auth_methods"`
+	DuoRequired bool     `json:"duo_required"`
+	Automation  bool     `json:"automation"`
+	Grants      []Grant  `json:"grants"`
+	// Error is set if the access of the user could not be determined.
+	Error string `json:"error,omitempty"`
+}
+
+// Report is the access of all known users.
+type Report struct {
+	Generated     time.Time `json:"generated"`
+	PolicyVersion uint64    `json:"policy_version"`
+	Users         []User    `json:"users"`
+}
+
+// WriteCSV writes the report with one line per grant, users with no grants
+// or an error get a line with empty grant columns.
+func (r *Report) WriteCSV(w io.Writer) error {
+	return r.writeCSV(w)
+}
+
+// WriteJSON writes the report as a JSON document.
+func (r *Report) WriteJSON(w io.Writer) error {
+	return r.writeJSON(w)
+}
+
+// Save writes the report in format ("csv" or "json") to a new file in
+// directory named after the time it was generated and returns its name.
+func (r *Report) Save(directory, format string) (string, error) {
+	return r.save(directory, format)
+}
@@ -0,0 +1,89 @@
+package accessreview
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var csvHeader = []string{"username", "groups", "auth_methods", "duo_required",
+	"automation", "grant", "source", "principals", "cert_types",
+	"max_duration_secs", "error"}
+
+// Lists are joined with a character not found in usernames or groups.
+func joinList(list []string) string {
+	return strings.Join(list, ";")
+}
+
+func (r *Report) writeCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, user := range r.Users {
+		userColumns := []string{user.Username, joinList(user.Groups),
+			joinList(user.AuthMethods), strconv.FormatBool(user.DuoRequired),
+			strconv.FormatBool(user.Automation)}
+		if len(user.Grants) < 1 {
+			record := append(userColumns, "", "", "", "", "", user.Error)
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, grant := range user.Grants {
+			record := append(append([]string(nil), userColumns...),
+				grant.Kind, grant.Source, joinList(grant.Principals),
+				joinList(grant.CertTypes),
+				strconv.FormatUint(grant.MaxDurationSecs, 10), user.Error)
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (r *Report) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func (r *Report) save(directory, format string) (string, error) {
+	write := r.writeCSV
+	switch format {
+	case "", "csv":
+		format = "csv"
+	case "json":
+		write = r.writeJSON
+	default:
+		return "", fmt.Errorf("accessreview: unknown format: %s", format)
+	}
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return "", err
+	}
+	filename := filepath.Join(directory, fmt.Sprintf("access-review-%s.%s",
+		r.Generated.UTC().Format("20060102T150405Z"), format))
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+		0600)
+	if err != nil {
+		return "", err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		os.Remove(filename)
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(filename)
+		return "", err
+	}
+	return filename, nil
+}
@@ -0,0 +1,83 @@
+package accessreview
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testReport = Report{
+	Generated:     time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+	PolicyVersion: 3,
+	Users: []User{
+		{
+			Username:    "alice",
+			Groups:      []string{"ops", "web"},
+			AuthMethods: []string{"U2F", "TOTP"},
+			Grants: []Grant{
+				{Kind: GrantSelf, Principals: []string{"alice"},
+					CertTypes: []string{"ssh", "x509"}, MaxDurationSecs: 86400},
+				{Kind: GrantRole, Source: "role ansible",
+					Principals: []string{"ansible", "deploy"},
+					CertTypes:  []string{"ssh"}, MaxDurationSecs: 3600},
+			},
+		},
+		{Username: "bob", Error: "error getting the groups"},
+	},
+}
+
+func TestWriteCSV(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	if err := testReport.WriteCSV(buffer); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(buffer).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("%d records: %q", len(records), records)
+	}
+	if records[2][1] != "ops;web" || records[2][5] != "role" ||
+		records[2][7] != "ansible;deploy" || records[2][9] != "3600" {
+		t.Errorf("unexpected role record: %q", records[2])
+	}
+	if records[3][0] != "bob" || records[3][5] != "" ||
+		records[3][10] != "error getting the groups" {
+		t.Errorf("unexpected error record: %q", records[3])
+	}
+}
+
+func TestSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accessreview")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename, err := testReport.Save(filepath.Join(dir, "reviews"), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(filename) != "access-review-20261001T120000Z.json" {
+		t.Errorf("unexpected filename: %s", filename)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Users) != 2 || len(report.Users[0].Grants) != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if _, err := testReport.Save(dir, "xml"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,101 @@
+// Package acmecert obtains and renews a TLS certificate from an ACME
+// certificate authority such as Let's Encrypt.
+//
+// The certificate and its key are written to files, so that a server
+// reloading its certificate when the files change serves the renewed
+// certificate without a restart. Domains are validated with HTTP-01
+// challenges, answered by the handler returned by HTTPHandler, or with
+// DNS-01 challenges, published as TXT records by a DNSProvider.
+package acmecert
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+const (
+	ChallengeHTTP01 = "http-01"
+	ChallengeDNS01  = "dns-01"
+)
+
+// HTTPChallengePathPrefix is the path below which HTTP-01 challenges are
+// requested, on port 80 of every domain.
+const HTTPChallengePathPrefix = "/.well-known/acme-challenge/"
+
+// DNSProvider publishes the TXT records of DNS-01 challenges. The TXT
+// backends of the dnspublish package implement it.
+type DNSProvider interface {
+	// PublishTXT replaces the TXT records of name with values. No values
+	// removes the records.
+	PublishTXT(name string, values []string, ttl time.Duration) error
+}
+
+// Params configures a Manager.
+type Params struct {
+	// DirectoryURL defaults to the production directory of Let's Encrypt.
+	DirectoryURL string
+	// Email is the contact of the account, optional.
+	Email string
+	// Domains are the names of the certificate, the first one being its
+	// subject. Wildcards require ChallengeDNS01.
+	Domains []string
+	// AccountKeyFilename holds the key of the ACME account. It is created
+	// if it does not exist.
+	AccountKeyFilename string
+	// The certificate chain and its key are written to CertFilename and
+	// KeyFilename, in PEM format.
+	CertFilename string
+	KeyFilename  string
+	// Challenge is ChallengeHTTP01 or ChallengeDNS01.
+	Challenge   string
+	DNSProvider DNSProvider // Required with ChallengeDNS01.
+	// DNSPropagationDelay is waited for after publishing a TXT record,
+	// before asking the CA to check it.
+	DNSPropagationDelay time.Duration
+	// RenewBefore is how long before its expiry the certificate is renewed.
+	// It defaults to 30 days.
+	RenewBefore time.Duration
+	HTTPClient  *http.Client
+	Logger      log.DebugLogger
+}
+
+// Manager obtains certificates for the domains of its Params.
+type Manager struct {
+	params     Params
+	mutex      sync.Mutex        // Protects below.
+	httpTokens map[string]string // Key authorizations by challenge path.
+}
+
+// New returns a Manager. It returns an error if params are incomplete.
+func New(params Params) (*Manager, error) {
+	return newManager(params)
+}
+
+// HTTPHandler answers the HTTP-01 challenges below HTTPChallengePathPrefix
+// and passes other requests to fallback, or answers them with 404 if
+// fallback is nil.
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	return m.httpHandler(fallback)
+}
+
+// RenewalDue returns true if the certificate files are missing or invalid,
+// do not cover all domains or expire within RenewBefore of now.
+func (m *Manager) RenewalDue(now time.Time) bool {
+	return m.renewalDue(now)
+}
+
+// Valid returns true if the certificate files are valid, cover all domains
+// and have not expired at now, so they can be served even if renewal fails.
+func (m *Manager) Valid(now time.Time) bool {
+	return m.valid(now)
+}
+
+// Obtain gets a new certificate from the CA and writes it and its key. The
+// files are left alone if it fails.
+func (m *Manager) Obtain(ctx context.Context) error {
+	return m.obtain(ctx)
+}
@@ -0,0 +1,315 @@
+package acmecert
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const defaultRenewBefore = 30 * 24 * time.Hour
+
+const dnsChallengeTTL = time.Minute
+
+const dnsChallengeLabel = "_acme-challenge."
+
+func newManager(params Params) (*Manager, error) {
+	if len(params.Domains) < 1 {
+		return nil, errors.New("acmecert: no domains")
+	}
+	if params.AccountKeyFilename == "" || params.CertFilename == "" ||
+		params.KeyFilename == "" {
+		return nil, errors.New("acmecert: missing filenames")
+	}
+	switch params.Challenge {
+	case ChallengeHTTP01:
+		for _, domain := range params.Domains {
+			if strings.HasPrefix(domain, "*.") {
+				return nil, fmt.Errorf(
+					"acmecert: wildcard %s requires %s", domain,
+					ChallengeDNS01)
+			}
+		}
+	case ChallengeDNS01:
+		if params.DNSProvider == nil {
+			return nil, errors.New("acmecert: no DNS provider")
+		}
+	default:
+		return nil, fmt.Errorf("acmecert: unknown challenge: %s",
+			params.Challenge)
+	}
+	if params.DirectoryURL == "" {
+		params.DirectoryURL = acme.LetsEncryptURL
+	}
+	if params.RenewBefore <= 0 {
+		params.RenewBefore = defaultRenewBefore
+	}
+	return &Manager{params: params, httpTokens: make(map[string]string)}, nil
+}
+
+func (m *Manager) httpHandler(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, HTTPChallengePathPrefix) {
+			if fallback == nil {
+				http.NotFound(w, r)
+			} else {
+				fallback.ServeHTTP(w, r)
+			}
+			return
+		}
+		m.mutex.Lock()
+		keyAuthorization, ok := m.httpTokens[r.URL.Path]
+		m.mutex.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuthorization))
+	})
+}
+
+func (m *Manager) renewalDue(now time.Time) bool {
+	return !m.valid(now.Add(m.params.RenewBefore))
+}
+
+func (m *Manager) valid(now time.Time) bool {
+	cert, err := tls.LoadX509KeyPair(m.params.CertFilename,
+		m.params.KeyFilename)
+	if err != nil {
+		return false
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if now.After(leaf.NotAfter) {
+		return false
+	}
+	for _, domain := range m.params.Domains {
+		// A certificate for *.example.com is verified for any single label.
+		if err := leaf.VerifyHostname(
+			strings.Replace(domain, "*", "acme", 1)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// loadAccountKey reads the account key, or creates one if there is none.
+func (m *Manager) loadAccountKey() (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(m.params.AccountKeyFilename)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("acmecert: no key in %s",
+				m.params.AccountKeyFilename)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKey(m.params.AccountKeyFilename, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func writeFile(filename string, data []byte, perm os.FileMode) error {
+	tmpFilename := filename + "~"
+	if err := ioutil.WriteFile(tmpFilename, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+func writeKey(filename string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writeFile(filename,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}),
+		0600)
+}
+
+func (m *Manager) newClient() (*acme.Client, error) {
+	key, err := m.loadAccountKey()
+	if err != nil {
+		return nil, err
+	}
+	return &acme.Client{
+		Key:          key,
+		DirectoryURL: m.params.DirectoryURL,
+		HTTPClient:   m.params.HTTPClient,
+		UserAgent:    "keymaster",
+	}, nil
+}
+
+func (m *Manager) obtain(ctx context.Context) error {
+	client, err := m.newClient()
+	if err != nil {
+		return err
+	}
+	account := &acme.Account{}
+	if m.params.Email != "" {
+		account.Contact = []string{"mailto:" + m.params.Email}
+	}
+	_, err = client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return fmt.Errorf("acmecert: cannot register account: %s", err)
+	}
+	order, err := client.AuthorizeOrder(ctx,
+		acme.DomainIDs(m.params.Domains...))
+	if err != nil {
+		return fmt.Errorf("acmecert: cannot create order: %s", err)
+	}
+	for _, authzURL := range order.AuthzURLs {
+		if err := m.authorize(ctx, client, authzURL); err != nil {
+			return err
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("acmecert: order not ready: %s", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader,
+		&x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: m.params.Domains[0]},
+			DNSNames: m.params.Domains,
+		}, key)
+	if err != nil {
+		return err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("acmecert: cannot finalize order: %s", err)
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return fmt.Errorf("acmecert: bad certificate: %s", err)
+	}
+	if !publicKeysEqual(leaf.PublicKey, key.Public()) {
+		return errors.New("acmecert: certificate is not for the key")
+	}
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM,
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	// Key first: a reload in between fails and is retried with the next
+	// change, while the certificate first would be served with the old key.
+	if err := writeKey(m.params.KeyFilename, key); err != nil {
+		return err
+	}
+	if err := writeFile(m.params.CertFilename, certPEM, 0644); err != nil {
+		return err
+	}
+	if m.params.Logger != nil {
+		m.params.Logger.Printf("Obtained certificate for %s, expires at %s",
+			strings.Join(m.params.Domains, ","),
+			leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	aKey, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && aKey.Equal(b)
+}
+
+// authorize answers the challenge of the authorization at authzURL, unless
+// the CA still has a valid authorization.
+func (m *Manager) authorize(ctx context.Context, client *acme.Client,
+	authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("acmecert: cannot get authorization: %s", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == m.params.Challenge {
+			challenge = c
+		}
+	}
+	domain := authz.Identifier.Value
+	if challenge == nil {
+		return fmt.Errorf("acmecert: no %s challenge for %s",
+			m.params.Challenge, domain)
+	}
+	switch m.params.Challenge {
+	case ChallengeHTTP01:
+		keyAuthorization, err := client.HTTP01ChallengeResponse(
+			challenge.Token)
+		if err != nil {
+			return err
+		}
+		path := client.HTTP01ChallengePath(challenge.Token)
+		m.mutex.Lock()
+		m.httpTokens[path] = keyAuthorization
+		m.mutex.Unlock()
+		defer func() {
+			m.mutex.Lock()
+			delete(m.httpTokens, path)
+			m.mutex.Unlock()
+		}()
+	case ChallengeDNS01:
+		value, err := client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return err
+		}
+		name := dnsChallengeLabel + domain
+		err = m.params.DNSProvider.PublishTXT(name, []string{value},
+			dnsChallengeTTL)
+		if err != nil {
+			return fmt.Errorf("acmecert: cannot publish %s: %s", name, err)
+		}
+		defer func() {
+			err := m.params.DNSProvider.PublishTXT(name, nil, dnsChallengeTTL)
+			if err != nil && m.params.Logger != nil {
+				m.params.Logger.Printf("Cannot remove %s: %s", name, err)
+			}
+		}()
+		timer := time.NewTimer(m.params.DNSPropagationDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("acmecert: cannot accept challenge for %s: %s",
+			domain, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("acmecert: %s not validated: %s", domain, err)
+	}
+	return nil
+}
@@ -0,0 +1,451 @@
+package acmecert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+	"golang.org/x/crypto/acme"
+)
+
+// testCA is a minimal ACME server with one order for one domain. It only
+// decodes the payloads of requests, without checking their signatures.
+type testCA struct {
+	t        *testing.T
+	server   *httptest.Server
+	caKey    *ecdsa.PrivateKey
+	caCert   *x509.Certificate
+	validate func(domain, token string) error
+	mutex    sync.Mutex
+	domain   string
+	status   string // Of the authorization.
+	certPEM  []byte
+}
+
+const testToken = "test-token"
+
+func newTestCA(t *testing.T, validate func(domain, token string) error) *testCA {
+	ca := &testCA{t: t, validate: validate, status: acme.StatusPending}
+	var err error
+	ca.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		ca.caKey.Public(), ca.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.caCert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	ca.server = httptest.NewServer(http.HandlerFunc(ca.serveHTTP))
+	return ca
+}
+
+func (ca *testCA) url(path string) string {
+	return ca.server.URL + path
+}
+
+func (ca *testCA) payload(r *http.Request) []byte {
+	var jws struct{ Payload string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		ca.t.Error(err)
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		ca.t.Error(err)
+	}
+	return payload
+}
+
+func (ca *testCA) writeJSON(w http.ResponseWriter, status int,
+	value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func (ca *testCA) order(status string) map[string]interface{} {
+	order := map[string]interface{}{
+		"status":         status,
+		"identifiers":    []map[string]string{{"type": "dns", "value": ca.domain}},
+		"authorizations": []string{ca.url("/authz")},
+		"finalize":       ca.url("/finalize"),
+	}
+	if status == acme.StatusValid {
+		order["certificate"] = ca.url("/cert")
+	}
+	return order
+}
+
+func (ca *testCA) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	switch r.URL.Path {
+	case "/directory":
+		ca.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"newNonce":   ca.url("/nonce"),
+			"newAccount": ca.url("/account"),
+			"newOrder":   ca.url("/order/new"),
+			"meta":       map[string]string{"termsOfService": ca.url("/tos")},
+		})
+	case "/nonce":
+		w.WriteHeader(http.StatusOK)
+	case "/account":
+		ca.payload(r)
+		w.Header().Set("Location", ca.url("/account/1"))
+		ca.writeJSON(w, http.StatusCreated, map[string]string{"status": "valid"})
+	case "/order/new":
+		var request struct {
+			Identifiers []struct{ Value string }
+		}
+		if err := json.Unmarshal(ca.payload(r), &request); err != nil ||
+			len(request.Identifiers) != 1 {
+			ca.writeJSON(w, http.StatusBadRequest, nil)
+			return
+		}
+		ca.domain = request.Identifiers[0].Value
+		w.Header().Set("Location", ca.url("/order"))
+		ca.writeJSON(w, http.StatusCreated, ca.order(acme.StatusPending))
+	case "/order":
+		ca.payload(r)
+		status := acme.StatusPending
+		if ca.certPEM != nil {
+			status = acme.StatusValid
+		} else if ca.status == acme.StatusValid {
+			status = acme.StatusReady
+		}
+		w.Header().Set("Location", ca.url("/order"))
+		ca.writeJSON(w, http.StatusOK, ca.order(status))
+	case "/authz":
+		ca.payload(r)
+		ca.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":     ca.status,
+			"identifier": map[string]string{"type": "dns", "value": ca.domain},
+			"challenges": []map[string]string{
+				{"type": "http-01", "url": ca.url("/challenge/http-01"),
+					"token": testToken, "status": "pending"},
+				{"type": "dns-01", "url": ca.url("/challenge/dns-01"),
+					"token": testToken, "status": "pending"},
+			},
+		})
+	case "/challenge/http-01", "/challenge/dns-01":
+		ca.payload(r)
+		ca.status = acme.StatusValid
+		if err := ca.validate(ca.domain, testToken); err != nil {
+			ca.t.Logf("validation failed: %s", err)
+			ca.status = acme.StatusInvalid
+		}
+		ca.writeJSON(w, http.StatusOK, map[string]string{
+			"type":   strings.TrimPrefix(r.URL.Path, "/challenge/"),
+			"url":    ca.url(r.URL.Path),
+			"token":  testToken,
+			"status": "processing",
+		})
+	case "/finalize":
+		var request struct{ CSR string }
+		if err := json.Unmarshal(ca.payload(r), &request); err != nil {
+			ca.writeJSON(w, http.StatusBadRequest, nil)
+			return
+		}
+		if err := ca.issue(request.CSR); err != nil {
+			ca.t.Error(err)
+			ca.writeJSON(w, http.StatusBadRequest, nil)
+			return
+		}
+		w.Header().Set("Location", ca.url("/order"))
+		ca.writeJSON(w, http.StatusOK, ca.order(acme.StatusValid))
+	case "/cert":
+		ca.payload(r)
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(ca.certPEM)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (ca *testCA) issue(encodedCSR string) error {
+	der, err := base64.RawURLEncoding.DecodeString(encodedCSR)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, ca.caCert,
+		csr.PublicKey, ca.caKey)
+	if err != nil {
+		return err
+	}
+	ca.certPEM = append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+			Bytes: ca.caCert.Raw})...)
+	return nil
+}
+
+func keyAuthorization(t *testing.T, accountKeyFilename,
+	token string) string {
+	data, err := ioutil.ReadFile(accountKeyFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbprint, err := acme.JWKThumbprint(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token + "." + thumbprint
+}
+
+func testParams(t *testing.T, dir string, ca *testCA) Params {
+	return Params{
+		DirectoryURL:       ca.url("/directory"),
+		Email:              "ops@example.com",
+		Domains:            []string{"keymaster.example.com"},
+		AccountKeyFilename: filepath.Join(dir, "account.key"),
+		CertFilename:       filepath.Join(dir, "cert.pem"),
+		KeyFilename:        filepath.Join(dir, "key.pem"),
+		Challenge:          ChallengeHTTP01,
+		Logger:             testlogger.New(t),
+	}
+}
+
+func checkObtained(t *testing.T, params Params, manager *Manager) {
+	cert, err := tls.LoadX509KeyPair(params.CertFilename, params.KeyFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 2 {
+		t.Fatalf("chain of %d certificates", len(cert.Certificate))
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname(params.Domains[0]); err != nil {
+		t.Fatal(err)
+	}
+	if manager.RenewalDue(time.Now()) {
+		t.Fatal("renewal due after obtaining")
+	}
+	if !manager.RenewalDue(time.Now().Add(61 * 24 * time.Hour)) {
+		t.Fatal("renewal not due 30 days before expiry")
+	}
+	if !manager.Valid(time.Now().Add(61 * 24 * time.Hour)) {
+		t.Fatal("not valid 30 days before expiry")
+	}
+	if manager.Valid(time.Now().Add(91 * 24 * time.Hour)) {
+		t.Fatal("valid after expiry")
+	}
+	fi, err := os.Stat(params.KeyFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("key mode %o", fi.Mode().Perm())
+	}
+}
+
+func TestObtainHTTP01(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acmecert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var params Params
+	var handler http.Handler
+	ca := newTestCA(t, func(domain, token string) error {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET",
+			"http://"+domain+HTTPChallengePathPrefix+token, nil))
+		want := keyAuthorization(t, params.AccountKeyFilename, token)
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			return fmt.Errorf("got %d %q", w.Code, w.Body.String())
+		}
+		return nil
+	})
+	defer ca.server.Close()
+	params = testParams(t, dir, ca)
+	manager, err := New(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler = manager.HTTPHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}))
+	if !manager.RenewalDue(time.Now()) {
+		t.Fatal("renewal not due without certificate")
+	}
+	if err := manager.Obtain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkObtained(t, params, manager)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET",
+		HTTPChallengePathPrefix+testToken, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("token still served: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusTeapot {
+		t.Fatalf("fallback not used: %d", w.Code)
+	}
+}
+
+type testDNSProvider struct {
+	mutex   sync.Mutex
+	records map[string][]string
+}
+
+func (p *testDNSProvider) PublishTXT(name string, values []string,
+	ttl time.Duration) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(values) < 1 {
+		delete(p.records, name)
+	} else {
+		p.records[name] = values
+	}
+	return nil
+}
+
+func TestObtainDNS01(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acmecert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var params Params
+	provider := &testDNSProvider{records: make(map[string][]string)}
+	ca := newTestCA(t, func(domain, token string) error {
+		sum := sha256.Sum256([]byte(
+			keyAuthorization(t, params.AccountKeyFilename, token)))
+		want := base64.RawURLEncoding.EncodeToString(sum[:])
+		provider.mutex.Lock()
+		defer provider.mutex.Unlock()
+		values := provider.records["_acme-challenge."+domain]
+		if len(values) != 1 || values[0] != want {
+			return fmt.Errorf("got %v", values)
+		}
+		return nil
+	})
+	defer ca.server.Close()
+	params = testParams(t, dir, ca)
+	params.Challenge = ChallengeDNS01
+	params.DNSProvider = provider
+	manager, err := New(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Obtain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkObtained(t, params, manager)
+	if len(provider.records) != 0 {
+		t.Fatalf("records left: %v", provider.records)
+	}
+}
+
+func TestObtainFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acmecert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, func(domain, token string) error {
+		return fmt.Errorf("unreachable")
+	})
+	defer ca.server.Close()
+	params := testParams(t, dir, ca)
+	manager, err := New(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Obtain(context.Background()); err == nil {
+		t.Fatal("obtained without validation")
+	}
+	if _, err := os.Stat(params.CertFilename); !os.IsNotExist(err) {
+		t.Fatalf("certificate written: %v", err)
+	}
+	if manager.Valid(time.Now()) {
+		t.Fatal("valid without a certificate")
+	}
+}
+
+func TestNew(t *testing.T) {
+	params := Params{
+		Domains:            []string{"*.example.com"},
+		AccountKeyFilename: "account.key",
+		CertFilename:       "cert.pem",
+		KeyFilename:        "key.pem",
+		Challenge:          ChallengeHTTP01,
+	}
+	if _, err := New(params); err == nil {
+		t.Error("wildcard accepted with HTTP-01")
+	}
+	params.Challenge = ChallengeDNS01
+	if _, err := New(params); err == nil {
+		t.Error("DNS-01 accepted without provider")
+	}
+	params.DNSProvider = &testDNSProvider{}
+	manager, err := New(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manager.params.DirectoryURL != acme.LetsEncryptURL ||
+		manager.params.RenewBefore != defaultRenewBefore {
+		t.Errorf("defaults not set: %+v", manager.params)
+	}
+	params.Challenge = "tls-alpn-01"
+	if _, err := New(params); err == nil {
+		t.Error("unknown challenge accepted")
+	}
+}
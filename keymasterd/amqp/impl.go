@@ -0,0 +1,148 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultContentType = "application/json"
+	defaultTimeout     = 10 * time.Second
+)
+
+func newPublisher(config Config) (*Publisher, error) {
+	parsedURL, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	if parsedURL.Scheme != "amqp" && parsedURL.Scheme != "amqps" {
+		return nil, fmt.Errorf("amqp: unsupported URL scheme: %s",
+			parsedURL.Scheme)
+	}
+	if parsedURL.Hostname() == "" {
+		return nil, errors.New("amqp: no host in URL")
+	}
+	uri, err := amqp091.ParseURI(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("amqp: %s", err)
+	}
+	p := &Publisher{config: config, uri: uri, password: uri.Password}
+	_, urlHasPassword := parsedURL.User.Password()
+	if config.Password != "" {
+		p.password = config.Password
+	}
+	if uri.Scheme != "amqps" && (urlHasPassword || config.Password != "") {
+		return nil, errors.New(
+			"amqp: a password without TLS would be sent in the clear, use amqps")
+	}
+	if p.config.ContentType == "" {
+		p.config.ContentType = defaultContentType
+	}
+	if p.config.Timeout <= 0 {
+		p.config.Timeout = defaultTimeout
+	}
+	return p, nil
+}
+
+func (p *Publisher) publish(routingKey string, body []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.channel == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	if err := p.publishAndConfirm(routingKey, body); err != nil {
+		p.closeConnection()
+		return err
+	}
+	return nil
+}
+
+func (p *Publisher) publishAndConfirm(routingKey string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Timeout)
+	defer cancel()
+	confirmation, err := p.channel.PublishWithDeferredConfirmWithContext(ctx,
+		p.config.Exchange, routingKey, true, false, amqp091.Publishing{
+			ContentType:  p.config.ContentType,
+			DeliveryMode: amqp091.Persistent,
+			Body:         body,
+		})
+	if err != nil {
+		return err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	// The broker returns unroutable messages before confirming them.
+	select {
+	case returned := <-p.returns:
+		return fmt.Errorf("amqp: message returned: %d %s",
+			returned.ReplyCode, returned.ReplyText)
+	default:
+	}
+	if !acked {
+		select {
+		case closeError := <-p.closed:
+			if closeError != nil {
+				return closeError
+			}
+		default:
+		}
+		return errors.New("amqp: message rejected by broker")
+	}
+	return nil
+}
+
+func (p *Publisher) connect() error {
+	conn, err := amqp091.DialConfig(p.config.URL, amqp091.Config{
+		SASL: []amqp091.Authentication{&amqp091.PlainAuth{
+			Username: p.uri.Username,
+			Password: p.password,
+		}},
+		TLSClientConfig: p.config.TLSConfig,
+		Dial:            amqp091.DefaultDial(p.config.Timeout),
+	})
+	if err != nil {
+		return err
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if err := channel.Confirm(false); err != nil {
+		conn.Close()
+		return err
+	}
+	p.conn = conn
+	p.channel = channel
+	p.returns = channel.NotifyReturn(make(chan amqp091.Return, 1))
+	p.closed = channel.NotifyClose(make(chan *amqp091.Error, 1))
+	return nil
+}
+
+func (p *Publisher) closeConnection() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	p.channel = nil
+	if errors.Is(err, amqp091.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func (p *Publisher) close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.closeConnection()
+}
@@ -0,0 +1,228 @@
+// Package attestation keeps an append only log of certificate issuance and
+// revocation events and summarises it into per quarter reports which can be
+// handed to auditors.
+package attestation
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	EventIssued  = "issued"
+	EventRevoked = "revoked"
+)
+
+// Event is a single entry in the log.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Username is the subject of the certificate.
+	Username string `json:"username"`
+	// RequestedBy is the user who requested the certificate for Username
+	// under a delegation rule.
+	RequestedBy string `json:"requested_by,omitempty"`
+	// Policy is the certificate type or host certificate profile.
+	Policy string `json:"policy"`
+	// AuthMethods are the methods the requester authenticated with.
+	AuthMethods []string `json:"auth_methods,omitempty"`
+	// SecondFactor is true if one of AuthMethods is a second factor.
+	SecondFactor bool `json:"second_factor"`
+	// Automation is true for IP restricted automation requests.
+	Automation   bool  `json:"automation,omitempty"`
+	DurationSecs int64 `json:"duration_secs,omitempty"`
+	// KeyType describes the certified key, e.g. "RSA-2048" or "Ed25519".
+	KeyType string `json:"key_type,omitempty"`
+	// CAKey is the SSH SHA256 fingerprint of the signing CA key.
+	CAKey string `json:"ca_key,omitempty"`
+	// CIProvider, CIPipeline and CISubject identify the CI job which
+	// requested a certificate with its OpenID Connect token.
+	CIProvider string `json:"ci_provider,omitempty"`
+	CIPipeline string `json:"ci_pipeline,omitempty"`
+	CISubject  string `json:"ci_subject,omitempty"`
+	// KeyID and Serial (in decimal) identify SSH user certificates in sshd
+	// logs.
+	KeyID  string `json:"key_id,omitempty"`
+	Serial string `json:"serial,omitempty"`
+	// Restrictions are the SSH permissions withheld from the certificate,
+	// e.g. "no-agent-forwarding", and RestrictionTier the policy tier which
+	// withheld them.
+	Restrictions    []string `json:"restrictions,omitempty"`
+	RestrictionTier string   `json:"restriction_tier,omitempty"`
+	// Reason is why a certificate was revoked.
+	Reason string `json:"reason,omitempty"`
+	// RequestedAt is when a revocation was requested, Time is when it took
+	// effect.
+	RequestedAt *time.Time `json:"requested_at,omitempty"`
+	// RequestID is the X-Request-ID of the HTTP request which issued the
+	// certificate.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Log is an append only event log stored as one JSON object per line.
+type Log struct {
+	filename string
+	mutex    sync.Mutex
+}
+
+// Open opens (creating it if needed) the log in filename.
+func Open(filename string) (*Log, error) {
+	return openLog(filename)
+}
+
+// Record appends event to the log. If the Time of event is zero the current
+// time is used. If l is nil, Record is a no-op.
+func (l *Log) Record(event Event) error {
+	return l.record(event)
+}
+
+// Events returns the events with start <= Time < end.
+func (l *Log) Events(start, end time.Time) ([]Event, error) {
+	return l.events(start, end)
+}
+
+// Quarter is a calendar quarter in UTC.
+type Quarter struct {
+	Year    int
+	Quarter int // 1 to 4
+}
+
+// ParseQuarter parses quarters written as "2026-Q3".
+func ParseQuarter(s string) (Quarter, error) {
+	return parseQuarter(s)
+}
+
+// QuarterOf returns the quarter containing t.
+func QuarterOf(t time.Time) Quarter {
+	t = t.UTC()
+	return Quarter{Year: t.Year(), Quarter: (int(t.Month())-1)/3 + 1}
+}
+
+// Start returns the first instant of the quarter.
+func (q Quarter) Start() time.Time {
+	return time.Date(q.Year, time.Month((q.Quarter-1)*3+1), 1, 0, 0, 0, 0,
+		time.UTC)
+}
+
+// End returns the first instant after the quarter.
+func (q Quarter) End() time.Time {
+	return q.Start().AddDate(0, 3, 0)
+}
+
+func (q Quarter) String() string {
+	return quarterString(q)
+}
+
+// RevocationStats summarises the delay between a revocation being requested
+// and taking effect.
+type RevocationStats struct {
+	Count       int     `json:"count"`
+	MinSecs     float64 `json:"min_secs"`
+	MeanSecs    float64 `json:"mean_secs"`
+	MedianSecs  float64 `json:"median_secs"`
+	P95Secs     float64 `json:"p95_secs"`
+	MaxSecs     float64 `json:"max_secs"`
+	Description string  `json:"description,omitempty"`
+}
+
+// Report is the attestation for one quarter.
+type Report struct {
+	Quarter     string    `json:"quarter"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Issuer      string    `json:"issuer"`
+	TotalIssued int       `json:"total_issued"`
+	UniqueUsers int       `json:"unique_users"`
+	// IssuedByPolicy counts issuance per certificate type or profile.
+	IssuedByPolicy map[string]int `json:"issued_by_policy"`
+	// IssuedByAuthMethod counts issuance per authentication method used.
+	IssuedByAuthMethod map[string]int `json:"issued_by_auth_method"`
+	AutomationIssued   int            `json:"automation_issued"`
+	InteractiveIssued  int            `json:"interactive_issued"`
+	SecondFactorIssued int            `json:"second_factor_issued"`
+	// SecondFactorRate is SecondFactorIssued / InteractiveIssued, or 1 if
+	// nothing was issued interactively.
+	SecondFactorRate float64         `json:"second_factor_rate"`
+	Revocations      RevocationStats `json:"revocations"`
+}
+
+// BuildReport summarises the events of quarter. issuer identifies the
+// keymaster instance.
+func BuildReport(events []Event, quarter Quarter, issuer string,
+	now time.Time) *Report {
+	return buildReport(events, quarter, issuer, now)
+}
+
+// Lines renders the report as human readable lines.
+func (r *Report) Lines() []string {
+	return r.lines()
+}
+
+// WritePDF writes a PDF document with title followed by lines.
+func WritePDF(w io.Writer, title string, lines []string) error {
+	return writePDF(w, title, lines)
+}
+
+// RotationImpact lists the certificates which would stop validating if
+// relying parties stopped trusting all CA keys but the candidate on Date.
+type RotationImpact struct {
+	Date time.Time
+	// Certificates are the issued events of the affected certificates,
+	// ordered by expiry.
+	Certificates []Event
+}
+
+// RotationReport is the result of SimulateRotation.
+type RotationReport struct {
+	CandidateCAKey string
+	// SafeRemovalDate is when the last certificate not signed by the
+	// candidate expires. It is zero if there are none.
+	SafeRemovalDate time.Time
+	Impacts         []RotationImpact
+}
+
+// SimulateRotation reports, for each of dates, which of the certificates
+// issued in events would stop validating if the CA keys other than
+// candidateCAKey (a fingerprint as in Event.CAKey) were removed on that
+// date. Events recorded without a CAKey are taken to be signed by an old
+// key. Revocations are not taken into account.
+func SimulateRotation(events []Event, candidateCAKey string,
+	dates []time.Time) *RotationReport {
+	return simulateRotation(events, candidateCAKey, dates)
+}
+
+// Lines renders the report as human readable lines.
+func (r *RotationReport) Lines() []string {
+	return r.lines()
+}
+
+// RestrictionShare counts the active certificates carrying a restriction.
+type RestrictionShare struct {
+	Count int `json:"count"`
+	// Fraction is Count over all active SSH certificates, or 0 if there
+	// are none.
+	Fraction float64 `json:"fraction"`
+}
+
+// RestrictionReport summarises the restrictions of the SSH user
+// certificates which are valid at Time.
+type RestrictionReport struct {
+	Time               time.Time                   `json:"time"`
+	ActiveCertificates int                         `json:"active_certificates"`
+	Restrictions       map[string]RestrictionShare `json:"restrictions"`
+	// Tiers counts the active certificates per restriction tier, "" for
+	// those issued without a tier.
+	Tiers map[string]int `json:"tiers"`
+}
+
+// SummarizeRestrictions reports which fraction of the SSH certificates
+// issued in events and valid at t carry each restriction. The restrictions
+// in names are reported even if no certificate carries them. Revocations are
+// not taken into account.
+func SummarizeRestrictions(events []Event, names []string,
+	t time.Time) *RestrictionReport {
+	return summarizeRestrictions(events, names, t)
+}
@@ -0,0 +1,243 @@
+package attestation
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func openLog(filename string) (*Log, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY,
+		0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	return &Log{filename: filename}, nil
+}
+
+func (l *Log) record(event Event) error {
+	if l == nil {
+		return nil
+	}
+	if event.Type != EventIssued && event.Type != EventRevoked {
+		return fmt.Errorf("attestation: unknown event type: %s", event.Type)
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Time = event.Time.UTC()
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	file, err := os.OpenFile(l.filename, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (l *Log) events(start, end time.Time) ([]Event, error) {
+	if l == nil {
+		return nil, nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	file, err := os.Open(l.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if len(scanner.Bytes()) < 1 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("attestation: %s:%d: %s", l.filename,
+				lineNumber, err)
+		}
+		if event.Time.Before(start) || !event.Time.Before(end) {
+			continue
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func parseQuarter(s string) (Quarter, error) {
+	fields := strings.Split(strings.ToUpper(strings.TrimSpace(s)), "-Q")
+	if len(fields) != 2 {
+		return Quarter{}, errors.New("quarter must be of the form YYYY-Qn")
+	}
+	year, err := strconv.Atoi(fields[0])
+	if err != nil || year < 1970 || year > 9999 {
+		return Quarter{}, fmt.Errorf("bad year in quarter: %s", s)
+	}
+	quarter, err := strconv.Atoi(fields[1])
+	if err != nil || quarter < 1 || quarter > 4 {
+		return Quarter{}, fmt.Errorf("bad quarter: %s", s)
+	}
+	return Quarter{Year: year, Quarter: quarter}, nil
+}
+
+func quarterString(q Quarter) string {
+	return fmt.Sprintf("%04d-Q%d", q.Year, q.Quarter)
+}
+
+func buildReport(events []Event, quarter Quarter, issuer string,
+	now time.Time) *Report {
+	report := &Report{
+		Quarter:            quarter.String(),
+		PeriodStart:        quarter.Start(),
+		PeriodEnd:          quarter.End(),
+		GeneratedAt:        now.UTC(),
+		Issuer:             issuer,
+		IssuedByPolicy:     make(map[string]int),
+		IssuedByAuthMethod: make(map[string]int),
+		SecondFactorRate:   1,
+	}
+	users := make(map[string]struct{})
+	var latencies []float64
+	for _, event := range events {
+		if event.Time.Before(report.PeriodStart) ||
+			!event.Time.Before(report.PeriodEnd) {
+			continue
+		}
+		switch event.Type {
+		case EventIssued:
+			report.TotalIssued++
+			users[event.Username] = struct{}{}
+			report.IssuedByPolicy[event.Policy]++
+			for _, method := range event.AuthMethods {
+				report.IssuedByAuthMethod[method]++
+			}
+			if event.Automation {
+				report.AutomationIssued++
+				continue
+			}
+			report.InteractiveIssued++
+			if event.SecondFactor {
+				report.SecondFactorIssued++
+			}
+		case EventRevoked:
+			if event.RequestedAt == nil {
+				continue
+			}
+			latency := event.Time.Sub(*event.RequestedAt).Seconds()
+			if latency < 0 {
+				latency = 0
+			}
+			latencies = append(latencies, latency)
+		}
+	}
+	report.UniqueUsers = len(users)
+	if report.InteractiveIssued > 0 {
+		report.SecondFactorRate = float64(report.SecondFactorIssued) /
+			float64(report.InteractiveIssued)
+	}
+	report.Revocations = computeRevocationStats(latencies)
+	return report
+}
+
+func computeRevocationStats(latencies []float64) RevocationStats {
+	stats := RevocationStats{Count: len(latencies)}
+	if len(latencies) < 1 {
+		stats.Description = "no revocations recorded"
+		return stats
+	}
+	sort.Float64s(latencies)
+	var sum float64
+	for _, latency := range latencies {
+		sum += latency
+	}
+	stats.MinSecs = latencies[0]
+	stats.MaxSecs = latencies[len(latencies)-1]
+	stats.MeanSecs = sum / float64(len(latencies))
+	stats.MedianSecs = percentile(latencies, 0.5)
+	stats.P95Secs = percentile(latencies, 0.95)
+	return stats
+}
+
+// percentile uses the nearest rank method on sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *Report) lines() []string {
+	lines := []string{
+		"Issuer: " + r.Issuer,
+		fmt.Sprintf("Period: %s to %s (UTC)",
+			r.PeriodStart.Format("2006-01-02"),
+			r.PeriodEnd.Add(-time.Second).Format("2006-01-02")),
+		"Generated: " + r.GeneratedAt.Format(time.RFC3339),
+		"",
+		fmt.Sprintf("Certificates issued: %d to %d users", r.TotalIssued,
+			r.UniqueUsers),
+		fmt.Sprintf("Interactive: %d, automation: %d", r.InteractiveIssued,
+			r.AutomationIssued),
+		fmt.Sprintf("Second factor enforcement: %d of %d interactive (%.2f%%)",
+			r.SecondFactorIssued, r.InteractiveIssued, r.SecondFactorRate*100),
+		"",
+		"Issued by policy:",
+	}
+	for _, policy := range sortedKeys(r.IssuedByPolicy) {
+		lines = append(lines, fmt.Sprintf("    %s: %d", policy,
+			r.IssuedByPolicy[policy]))
+	}
+	lines = append(lines, "", "Issued by authentication method:")
+	for _, method := range sortedKeys(r.IssuedByAuthMethod) {
+		lines = append(lines, fmt.Sprintf("    %s: %d", method,
+			r.IssuedByAuthMethod[method]))
+	}
+	lines = append(lines, "", "Revocation latency:")
+	stats := r.Revocations
+	if stats.Count < 1 {
+		lines = append(lines, "    "+stats.Description)
+	} else {
+		lines = append(lines,
+			fmt.Sprintf("    count: %d", stats.Count),
+			fmt.Sprintf("    min/mean/median/p95/max: %.1fs/%.1fs/%.1fs/%.1fs/%.1fs",
+				stats.MinSecs, stats.MeanSecs, stats.MedianSecs,
+				stats.P95Secs, stats.MaxSecs))
+	}
+	return lines
+}
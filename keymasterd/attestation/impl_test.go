@@ -0,0 +1,199 @@
+package attestation
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuarter(t *testing.T) {
+	quarter, err := ParseQuarter("2026-q3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quarter.String() != "2026-Q3" {
+		t.Fatalf("unexpected quarter %s", quarter)
+	}
+	if !quarter.Start().Equal(time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)) ||
+		!quarter.End().Equal(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected bounds %s %s", quarter.Start(), quarter.End())
+	}
+	if QuarterOf(time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)) !=
+		(Quarter{2026, 4}) {
+		t.Fatal("wrong quarter for October")
+	}
+	for _, bad := range []string{"2026", "2026-Q5", "2026-Q0", "x-Q1"} {
+		if _, err := ParseQuarter(bad); err == nil {
+			t.Fatalf("%s should not parse", bad)
+		}
+	}
+}
+
+func TestLogAndReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attestation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	log, err := Open(filepath.Join(dir, "events.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarter := Quarter{2026, 3}
+	inQuarter := quarter.Start().Add(time.Hour)
+	requested := inQuarter.Add(-30 * time.Second)
+	events := []Event{
+		{Type: EventIssued, Time: inQuarter, Username: "a", Policy: "ssh",
+			AuthMethods: []string{"Password", "U2F"}, SecondFactor: true},
+		{Type: EventIssued, Time: inQuarter, Username: "a", Policy: "x509",
+			AuthMethods: []string{"Password"}},
+		{Type: EventIssued, Time: inQuarter, Username: "robot",
+			Policy: "ssh", AuthMethods: []string{"IPCertificate"},
+			Automation: true},
+		{Type: EventIssued, Time: quarter.End(), Username: "b",
+			Policy: "ssh"},
+		{Type: EventRevoked, Time: inQuarter, Username: "a",
+			RequestedAt: &requested},
+	}
+	for _, event := range events {
+		if err := log.Record(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := log.Record(Event{Type: "bogus"}); err == nil {
+		t.Fatal("unknown event type should be rejected")
+	}
+	recorded, err := log.Events(quarter.Start(), quarter.End())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(recorded))
+	}
+	report := BuildReport(recorded, quarter, "test", inQuarter)
+	if report.TotalIssued != 3 || report.UniqueUsers != 2 {
+		t.Fatalf("unexpected totals: %+v", report)
+	}
+	if report.IssuedByPolicy["ssh"] != 2 || report.IssuedByPolicy["x509"] != 1 {
+		t.Fatalf("unexpected policy counts: %v", report.IssuedByPolicy)
+	}
+	if report.IssuedByAuthMethod["Password"] != 2 {
+		t.Fatalf("unexpected method counts: %v", report.IssuedByAuthMethod)
+	}
+	if report.InteractiveIssued != 2 || report.SecondFactorRate != 0.5 {
+		t.Fatalf("unexpected second factor rate: %+v", report)
+	}
+	if report.Revocations.Count != 1 || report.Revocations.MaxSecs != 30 {
+		t.Fatalf("unexpected revocation stats: %+v", report.Revocations)
+	}
+	var nilLog *Log
+	if err := nilLog.Record(events[0]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWritePDF(t *testing.T) {
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, "line (with parens) \\ and "+strings.Repeat("x", i))
+	}
+	var buffer bytes.Buffer
+	if err := WritePDF(&buffer, "Title", lines); err != nil {
+		t.Fatal(err)
+	}
+	pdf := buffer.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatal("not a PDF")
+	}
+	if !strings.Contains(pdf, `line \(with parens\) \\ and`) {
+		t.Fatal("text not escaped")
+	}
+	if strings.Count(pdf, "/Type /Page ") < 2 {
+		t.Fatal("expected several pages")
+	}
+}
+
+func TestSimulateRotation(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	day := int64(24 * time.Hour / time.Second)
+	events := []Event{
+		{Type: EventIssued, Time: start, Username: "alice", Policy: "ssh",
+			DurationSecs: day, CAKey: "SHA256:old"},
+		{Type: EventIssued, Time: start, Username: "bob", Policy: "x509",
+			DurationSecs: 7 * day},
+		{Type: EventIssued, Time: start, Username: "carol", Policy: "ssh",
+			DurationSecs: 30 * day, CAKey: "SHA256:new"},
+		{Type: EventRevoked, Time: start},
+	}
+	report := SimulateRotation(events, "SHA256:new", []time.Time{
+		start.AddDate(0, 0, 3), start.Add(time.Hour), start.AddDate(0, 0, 8)})
+	if !report.SafeRemovalDate.Equal(start.AddDate(0, 0, 7)) {
+		t.Errorf("safe removal date %s", report.SafeRemovalDate)
+	}
+	var counts []int
+	for _, impact := range report.Impacts {
+		counts = append(counts, len(impact.Certificates))
+	}
+	if len(counts) != 3 || counts[0] != 2 || counts[1] != 1 || counts[2] != 0 {
+		t.Fatalf("unexpected impacts %v", counts)
+	}
+	if report.Impacts[1].Certificates[0].Username != "bob" {
+		t.Errorf("unexpected certificate %+v", report.Impacts[1].Certificates[0])
+	}
+	text := strings.Join(report.Lines(), "\n")
+	if !strings.Contains(text, "by unknown CA key") ||
+		!strings.Contains(text, "after 2026-10-08T00:00:00Z") {
+		t.Errorf("unexpected report:\n%s", text)
+	}
+	report = SimulateRotation(events[2:], "SHA256:new", nil)
+	if !report.SafeRemovalDate.IsZero() {
+		t.Errorf("safe removal date %s", report.SafeRemovalDate)
+	}
+}
+
+func TestSummarizeRestrictions(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	hour := int64(time.Hour / time.Second)
+	events := []Event{
+		{Type: EventIssued, Time: now.Add(-time.Minute), Policy: "ssh",
+			DurationSecs: hour, RestrictionTier: "contractors",
+			Restrictions: []string{"no-agent-forwarding", "no-port-forwarding"}},
+		{Type: EventIssued, Time: now.Add(-time.Minute), Policy: "ssh",
+			DurationSecs: hour, RestrictionTier: "default",
+			Restrictions: []string{"no-agent-forwarding"}},
+		{Type: EventIssued, Time: now.Add(-time.Minute), Policy: "ssh",
+			DurationSecs: hour},
+		{Type: EventIssued, Time: now.Add(-time.Minute), Policy: "ssh",
+			DurationSecs: hour},
+		// Expired, not SSH or issued later.
+		{Type: EventIssued, Time: now.Add(-2 * time.Hour), Policy: "ssh",
+			DurationSecs: hour, Restrictions: []string{"no-pty"}},
+		{Type: EventIssued, Time: now.Add(-time.Minute), Policy: "x509",
+			DurationSecs: hour},
+		{Type: EventIssued, Time: now.Add(time.Minute), Policy: "ssh",
+			DurationSecs: hour},
+	}
+	report := SummarizeRestrictions(events,
+		[]string{"no-agent-forwarding", "no-pty"}, now)
+	if report.ActiveCertificates != 4 {
+		t.Fatalf("%d active certificates", report.ActiveCertificates)
+	}
+	agent := report.Restrictions["no-agent-forwarding"]
+	if agent.Count != 2 || agent.Fraction != 0.5 {
+		t.Errorf("no-agent-forwarding: %+v", agent)
+	}
+	if share, ok := report.Restrictions["no-pty"]; !ok || share.Count != 0 {
+		t.Errorf("no-pty: %+v", share)
+	}
+	if report.Restrictions["no-port-forwarding"].Fraction != 0.25 {
+		t.Errorf("no-port-forwarding: %+v",
+			report.Restrictions["no-port-forwarding"])
+	}
+	if report.Tiers[""] != 2 || report.Tiers["contractors"] != 1 {
+		t.Errorf("tiers: %v", report.Tiers)
+	}
+}
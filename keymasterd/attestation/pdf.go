@@ -0,0 +1,120 @@
+package attestation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A deliberately small PDF 1.4 writer: US Letter pages of monospaced text
+// using the standard Courier font, so no fonts need to be embedded.
+const (
+	pdfPageWidth    = 612
+	pdfPageHeight   = 792
+	pdfMargin       = 54
+	pdfFontSize     = 9
+	pdfTitleSize    = 14
+	pdfLeading      = 12
+	pdfMaxLineChars = 90
+)
+
+func pdfEscape(s string) string {
+	var buffer bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			buffer.WriteByte('\\')
+			buffer.WriteRune(r)
+		case r < 32 || r > 126:
+			buffer.WriteByte('?')
+		default:
+			buffer.WriteRune(r)
+		}
+	}
+	return buffer.String()
+}
+
+// wrapLines splits lines longer than pdfMaxLineChars, indenting the
+// continuations.
+func wrapLines(lines []string) []string {
+	var wrapped []string
+	for _, line := range lines {
+		for len(line) > pdfMaxLineChars {
+			wrapped = append(wrapped, line[:pdfMaxLineChars])
+			line = "    " + line[pdfMaxLineChars:]
+		}
+		wrapped = append(wrapped, line)
+	}
+	return wrapped
+}
+
+func writePDF(w io.Writer, title string, lines []string) error {
+	lines = wrapLines(lines)
+	firstPageLines := (pdfPageHeight-2*pdfMargin-2*pdfLeading)/pdfLeading - 1
+	pageLines := (pdfPageHeight - 2*pdfMargin) / pdfLeading
+	var pages [][]string
+	for first := true; first || len(lines) > 0; first = false {
+		count := pageLines
+		if first {
+			count = firstPageLines
+		}
+		if count > len(lines) {
+			count = len(lines)
+		}
+		pages = append(pages, lines[:count])
+		lines = lines[count:]
+	}
+	var objects []string
+	// Objects 1 to 3 are the catalog, page tree and font. Each page is
+	// followed by its content stream.
+	var kids []string
+	for index := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 4+2*index))
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>",
+			strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>")
+	for index, page := range pages {
+		var content bytes.Buffer
+		y := pdfPageHeight - pdfMargin
+		content.WriteString("BT\n")
+		if index == 0 {
+			fmt.Fprintf(&content, "/F1 %d Tf\n%d %d Td\n(%s) Tj\n",
+				pdfTitleSize, pdfMargin, y, pdfEscape(title))
+			fmt.Fprintf(&content, "0 %d Td\n", -2*pdfLeading)
+		} else {
+			fmt.Fprintf(&content, "%d %d Td\n", pdfMargin, y)
+		}
+		fmt.Fprintf(&content, "/F1 %d Tf\n%d TL\n", pdfFontSize, pdfLeading)
+		for _, line := range page {
+			fmt.Fprintf(&content, "(%s) '\n", pdfEscape(line))
+		}
+		fmt.Fprintf(&content, "ET\nBT\n/F1 %d Tf\n%d %d Td\n(Page %d of %d) Tj\nET\n",
+			pdfFontSize, pdfMargin, pdfMargin/2, index+1, len(pages))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+				"/Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, 5+2*index),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream",
+				content.Len(), content.String()))
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for index, object := range objects {
+		offsets[index] = buffer.Len()
+		fmt.Fprintf(&buffer, "%d 0 obj\n%s\nendobj\n", index+1, object)
+	}
+	xrefOffset := buffer.Len()
+	fmt.Fprintf(&buffer, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buffer, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buffer, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(objects)+1, xrefOffset)
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
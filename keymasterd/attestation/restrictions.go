@@ -0,0 +1,38 @@
+package attestation
+
+import (
+	"time"
+)
+
+func summarizeRestrictions(events []Event, names []string,
+	t time.Time) *RestrictionReport {
+	report := &RestrictionReport{
+		Time:         t.UTC(),
+		Restrictions: make(map[string]RestrictionShare, len(names)),
+		Tiers:        make(map[string]int),
+	}
+	counts := make(map[string]int, len(names))
+	for _, name := range names {
+		counts[name] = 0
+	}
+	for _, event := range events {
+		if event.Type != EventIssued || event.Policy != "ssh" ||
+			event.Time.After(t) || !event.expiresAt().After(t) {
+			continue
+		}
+		report.ActiveCertificates++
+		report.Tiers[event.RestrictionTier]++
+		for _, restriction := range event.Restrictions {
+			counts[restriction]++
+		}
+	}
+	for name, count := range counts {
+		share := RestrictionShare{Count: count}
+		if report.ActiveCertificates > 0 {
+			share.Fraction = float64(count) /
+				float64(report.ActiveCertificates)
+		}
+		report.Restrictions[name] = share
+	}
+	return report
+}
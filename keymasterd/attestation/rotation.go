@@ -0,0 +1,75 @@
+package attestation
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+func (event Event) expiresAt() time.Time {
+	return event.Time.Add(time.Duration(event.DurationSecs) * time.Second)
+}
+
+func simulateRotation(events []Event, candidateCAKey string,
+	dates []time.Time) *RotationReport {
+	var outstanding []Event
+	for _, event := range events {
+		if event.Type != EventIssued || event.DurationSecs < 1 {
+			continue
+		}
+		if event.CAKey != "" && event.CAKey == candidateCAKey {
+			continue
+		}
+		outstanding = append(outstanding, event)
+	}
+	sort.SliceStable(outstanding, func(i, j int) bool {
+		return outstanding[i].expiresAt().Before(outstanding[j].expiresAt())
+	})
+	report := &RotationReport{CandidateCAKey: candidateCAKey}
+	if len(outstanding) > 0 {
+		report.SafeRemovalDate = outstanding[len(outstanding)-1].expiresAt()
+	}
+	sortedDates := append([]time.Time(nil), dates...)
+	sort.Slice(sortedDates, func(i, j int) bool {
+		return sortedDates[i].Before(sortedDates[j])
+	})
+	for _, date := range sortedDates {
+		impact := RotationImpact{Date: date.UTC()}
+		for _, event := range outstanding {
+			if event.Time.After(date) || !event.expiresAt().After(date) {
+				continue
+			}
+			impact.Certificates = append(impact.Certificates, event)
+		}
+		report.Impacts = append(report.Impacts, impact)
+	}
+	return report
+}
+
+func (r *RotationReport) lines() []string {
+	lines := []string{"Candidate CA key: " + r.CandidateCAKey}
+	if r.SafeRemovalDate.IsZero() {
+		lines = append(lines,
+			"Old CA keys can be removed now: no outstanding certificates")
+	} else {
+		lines = append(lines, "Old CA keys can be removed safely after "+
+			r.SafeRemovalDate.UTC().Format(time.RFC3339))
+	}
+	for _, impact := range r.Impacts {
+		lines = append(lines, "", fmt.Sprintf(
+			"Removal on %s: %d certificates stop validating",
+			impact.Date.Format(time.RFC3339), len(impact.Certificates)))
+		for _, event := range impact.Certificates {
+			caKey := event.CAKey
+			if caKey == "" {
+				caKey = "unknown CA key"
+			}
+			lines = append(lines, fmt.Sprintf(
+				"    %s %s %s issued %s expires %s by %s", event.Username,
+				event.Policy, event.KeyType,
+				event.Time.UTC().Format(time.RFC3339),
+				event.expiresAt().UTC().Format(time.RFC3339), caKey))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,35 @@
+// Package authrequirement parses and evaluates boolean combinations of
+// authentication methods, such as "IPCertificate AND (password OR U2F)".
+// AND binds tighter than OR, keywords are case insensitive and parentheses
+// group. Methods are names mapped to bits of an authentication level, and a
+// requirement is satisfied by a level which has the bits of enough methods
+// set.
+package authrequirement
+
+// Requirement is a parsed requirement. It is immutable.
+type Requirement struct {
+	root    node
+	methods int
+}
+
+// Parse parses text, where methods maps the names of methods to their bits.
+// Method names are case insensitive.
+func Parse(text string, methods map[string]int) (*Requirement, error) {
+	return parse(text, methods)
+}
+
+// Methods returns the bits of all the methods in the requirement.
+func (r *Requirement) Methods() int {
+	return r.methods
+}
+
+// Satisfied returns true if authLevel satisfies the requirement.
+func (r *Requirement) Satisfied(authLevel int) bool {
+	return r.root.satisfied(authLevel)
+}
+
+// String returns the requirement in a canonical form, with the method names
+// as given to Parse and only the necessary parentheses.
+func (r *Requirement) String() string {
+	return r.root.String()
+}
@@ -0,0 +1,199 @@
+package authrequirement
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type node interface {
+	satisfied(authLevel int) bool
+	String() string
+}
+
+type methodNode struct {
+	name string
+	bit  int
+}
+
+type allNode []node
+
+type anyNode []node
+
+func (n methodNode) satisfied(authLevel int) bool {
+	return authLevel&n.bit != 0
+}
+
+func (n methodNode) String() string {
+	return n.name
+}
+
+func (n allNode) satisfied(authLevel int) bool {
+	for _, child := range n {
+		if !child.satisfied(authLevel) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n allNode) String() string {
+	parts := make([]string, 0, len(n))
+	for _, child := range n {
+		if _, ok := child.(anyNode); ok {
+			parts = append(parts, "("+child.String()+")")
+		} else {
+			parts = append(parts, child.String())
+		}
+	}
+	return strings.Join(parts, " AND ")
+}
+
+func (n anyNode) satisfied(authLevel int) bool {
+	for _, child := range n {
+		if child.satisfied(authLevel) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n anyNode) String() string {
+	parts := make([]string, 0, len(n))
+	for _, child := range n {
+		parts = append(parts, child.String())
+	}
+	return strings.Join(parts, " OR ")
+}
+
+func tokenize(text string) ([]string, error) {
+	var tokens []string
+	for index := 0; index < len(text); {
+		switch c := text[index]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			index++
+		case c == '(' || c == ')':
+			tokens = append(tokens, text[index:index+1])
+			index++
+		case isNameByte(c):
+			start := index
+			for index < len(text) && isNameByte(text[index]) {
+				index++
+			}
+			tokens = append(tokens, text[start:index])
+		default:
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+func isNameByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.'
+}
+
+type parser struct {
+	tokens  []string
+	methods map[string]methodNode // Keyed by lower case name.
+	bits    int
+}
+
+func parse(text string, methods map[string]int) (*Requirement, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) < 1 {
+		return nil, errors.New("empty requirement")
+	}
+	p := &parser{tokens: tokens, methods: make(map[string]methodNode)}
+	for name, bit := range methods {
+		p.methods[strings.ToLower(name)] = methodNode{name: name, bit: bit}
+	}
+	root, err := p.parseAny()
+	if err != nil {
+		return nil, err
+	}
+	if len(p.tokens) > 0 {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[0])
+	}
+	return &Requirement{root: root, methods: p.bits}, nil
+}
+
+func (p *parser) next() string {
+	if len(p.tokens) < 1 {
+		return ""
+	}
+	return p.tokens[0]
+}
+
+func (p *parser) nextIsKeyword(keyword string) bool {
+	return strings.EqualFold(p.next(), keyword)
+}
+
+func (p *parser) parseAny() (node, error) {
+	var children anyNode
+	for {
+		child, err := p.parseAll()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		if !p.nextIsKeyword("OR") {
+			break
+		}
+		p.tokens = p.tokens[1:]
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return children, nil
+}
+
+func (p *parser) parseAll() (node, error) {
+	var children allNode
+	for {
+		child, err := p.parseMethod()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		if !p.nextIsKeyword("AND") {
+			break
+		}
+		p.tokens = p.tokens[1:]
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return children, nil
+}
+
+func (p *parser) parseMethod() (node, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, errors.New("unexpected end of requirement")
+	case token == "(":
+		p.tokens = p.tokens[1:]
+		child, err := p.parseAny()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing )")
+		}
+		p.tokens = p.tokens[1:]
+		return child, nil
+	case p.nextIsKeyword("AND") || p.nextIsKeyword("OR") || token == ")":
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+	method, ok := p.methods[strings.ToLower(token)]
+	if !ok {
+		return nil, fmt.Errorf("unknown authentication method %q", token)
+	}
+	p.tokens = p.tokens[1:]
+	p.bits |= method.bit
+	return method, nil
+}
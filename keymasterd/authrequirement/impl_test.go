@@ -0,0 +1,62 @@
+package authrequirement
+
+import (
+	"testing"
+)
+
+const (
+	password = 1 << iota
+	u2f
+	totp
+	ipCertificate
+)
+
+var testMethods = map[string]int{
+	"password":      password,
+	"U2F":           u2f,
+	"TOTP":          totp,
+	"IPCertificate": ipCertificate,
+}
+
+func TestParse(t *testing.T) {
+	requirement, err := Parse(
+		"ipcertificate and (Password or u2f AND totp)", testMethods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := requirement.String(); text !=
+		"IPCertificate AND (password OR U2F AND TOTP)" {
+		t.Errorf("unexpected canonical form: %s", text)
+	}
+	if requirement.Methods() != password|u2f|totp|ipCertificate {
+		t.Errorf("unexpected methods: %b", requirement.Methods())
+	}
+	for level, expected := range map[int]bool{
+		ipCertificate | password:   true,
+		ipCertificate | u2f:        false,
+		ipCertificate | u2f | totp: true,
+		password | u2f | totp:      false,
+		0:                          false,
+	} {
+		if requirement.Satisfied(level) != expected {
+			t.Errorf("%b: expected %v", level, expected)
+		}
+	}
+	requirement, err = Parse("U2F", testMethods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !requirement.Satisfied(password|u2f) || requirement.Satisfied(password) {
+		t.Error("single method not evaluated")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{"", "password AND", "OR U2F",
+		"(password", "password)", "password U2F", "Kerberos", "password & U2F",
+		"()"} {
+		if _, err := Parse(text, testMethods); err == nil {
+			t.Errorf("%q accepted", text)
+		}
+	}
+}
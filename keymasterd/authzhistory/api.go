@@ -0,0 +1,66 @@
+// Package authzhistory records when issuance policy versions came into force
+// and the groups of users over time, so that the authorization state at a
+// past instant can be reconstructed. The history is kept in a file with one
+// JSON encoded event per line and is only ever appended to.
+package authzhistory
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// The types of events.
+const (
+	EventPolicy = "policy"
+	EventGroups = "groups"
+)
+
+// Event is one change of the authorization state.
+type Event struct {
+	Time time.Time
+	Type string
+	// For EventPolicy, the policy version which came into force.
+	PolicyVersion uint64 `json:",omitempty"`
+	// For EventGroups, the groups of Username from then on, sorted.
+	Username string   `json:",omitempty"`
+	Groups   []string `json:",omitempty"`
+}
+
+// Log is safe for concurrent use. A nil *Log records nothing.
+type Log struct {
+	mutex      sync.Mutex
+	file       *os.File
+	events     []Event          // Ordered by Time.
+	lastPolicy uint64           // Version of the latest EventPolicy.
+	lastGroups map[string]Event // Latest EventGroups by username.
+}
+
+// Open opens the log in filename, creating it if needed.
+func Open(filename string) (*Log, error) {
+	return openLog(filename)
+}
+
+// RecordPolicy records that version came into force at t, unless it was
+// already in force.
+func (l *Log) RecordPolicy(version uint64, t time.Time) error {
+	return l.recordPolicy(version, t)
+}
+
+// RecordGroups records the groups of username at t, unless they did not
+// change since the previous snapshot.
+func (l *Log) RecordGroups(username string, groups []string,
+	t time.Time) error {
+	return l.recordGroups(username, groups, t)
+}
+
+// PolicyAt returns the event of the policy version in force at t.
+func (l *Log) PolicyAt(t time.Time) (Event, bool) {
+	return l.policyAt(t)
+}
+
+// GroupsAt returns the latest snapshot of the groups of username taken at or
+// before t.
+func (l *Log) GroupsAt(username string, t time.Time) (Event, bool) {
+	return l.groupsAt(username, t)
+}
@@ -0,0 +1,138 @@
+package authzhistory
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+func openLog(filename string) (*Log, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE,
+		0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{file: file, lastGroups: make(map[string]Event)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("authzhistory: %s:%d: %s", filename,
+				lineNumber, err)
+		}
+		l.add(event)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// add keeps events ordered by time, should the clock have gone backwards.
+func (l *Log) add(event Event) {
+	index := sort.Search(len(l.events), func(index int) bool {
+		return l.events[index].Time.After(event.Time)
+	})
+	l.events = append(l.events, Event{})
+	copy(l.events[index+1:], l.events[index:])
+	l.events[index] = event
+	switch event.Type {
+	case EventPolicy:
+		l.lastPolicy = event.PolicyVersion
+	case EventGroups:
+		l.lastGroups[event.Username] = event
+	}
+}
+
+func (l *Log) write(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.add(event)
+	return nil
+}
+
+func (l *Log) recordPolicy(version uint64, t time.Time) error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if version == l.lastPolicy {
+		return nil
+	}
+	return l.write(Event{Time: t.UTC(), Type: EventPolicy,
+		PolicyVersion: version})
+}
+
+func equalGroups(left, right []string) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	for index := range left {
+		if left[index] != right[index] {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *Log) recordGroups(username string, groups []string,
+	t time.Time) error {
+	if l == nil {
+		return nil
+	}
+	sorted := append([]string(nil), groups...)
+	sort.Strings(sorted)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if last, ok := l.lastGroups[username]; ok &&
+		equalGroups(last.Groups, sorted) {
+		return nil
+	}
+	return l.write(Event{Time: t.UTC(), Type: EventGroups,
+		Username: username, Groups: sorted})
+}
+
+// latest returns the last event before or at t which matches.
+func (l *Log) latest(t time.Time, matches func(Event) bool) (Event, bool) {
+	if l == nil {
+		return Event{}, false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	index := sort.Search(len(l.events), func(index int) bool {
+		return l.events[index].Time.After(t)
+	})
+	for index--; index >= 0; index-- {
+		if matches(l.events[index]) {
+			return l.events[index], true
+		}
+	}
+	return Event{}, false
+}
+
+func (l *Log) policyAt(t time.Time) (Event, bool) {
+	return l.latest(t, func(event Event) bool {
+		return event.Type == EventPolicy
+	})
+}
+
+func (l *Log) groupsAt(username string, t time.Time) (Event, bool) {
+	return l.latest(t, func(event Event) bool {
+		return event.Type == EventGroups && event.Username == username
+	})
+}
@@ -0,0 +1,86 @@
+package authzhistory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authzhistory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "history")
+	log, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	for _, step := range []struct {
+		hours   int
+		version uint64
+		groups  []string
+	}{
+		{0, 1, []string{"web", "admin"}},
+		{1, 1, []string{"admin", "web"}}, // Unchanged, not recorded.
+		{2, 2, []string{"web"}},
+		{3, 1, nil},
+	} {
+		when := start.Add(time.Duration(step.hours) * time.Hour)
+		if err := log.RecordPolicy(step.version, when); err != nil {
+			t.Fatal(err)
+		}
+		if err := log.RecordGroups("alice", step.groups, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reopened, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []*Log{log, reopened} {
+		if len(l.events) != 6 {
+			t.Fatalf("%d events recorded", len(l.events))
+		}
+		if _, ok := l.PolicyAt(start.Add(-time.Second)); ok {
+			t.Error("policy found before the first version")
+		}
+		for hours, expected := range map[int]uint64{0: 1, 1: 1, 2: 2, 5: 1} {
+			event, ok := l.PolicyAt(start.Add(time.Duration(hours) * time.Hour))
+			if !ok || event.PolicyVersion != expected {
+				t.Errorf("at +%dh: version %d, expected %d", hours,
+					event.PolicyVersion, expected)
+			}
+		}
+		event, ok := l.GroupsAt("alice", start.Add(90*time.Minute))
+		if !ok || len(event.Groups) != 2 || event.Groups[0] != "admin" ||
+			!event.Time.Equal(start) {
+			t.Errorf("unexpected groups: %+v", event)
+		}
+		event, ok = l.GroupsAt("alice", start.Add(4*time.Hour))
+		if !ok || len(event.Groups) != 0 {
+			t.Errorf("unexpected groups: %+v", event)
+		}
+		if _, ok := l.GroupsAt("bob", start.Add(4*time.Hour)); ok {
+			t.Error("groups of unknown user found")
+		}
+	}
+	// A repeated version after a reopen is still not recorded.
+	if err := reopened.RecordPolicy(1, start.Add(5*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.events) != 6 {
+		t.Errorf("%d events recorded", len(reopened.events))
+	}
+	var nilLog *Log
+	if err := nilLog.RecordPolicy(1, start); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := nilLog.PolicyAt(start); ok {
+		t.Error("nil log has a policy")
+	}
+}
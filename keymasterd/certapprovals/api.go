@@ -0,0 +1,115 @@
+// Package certapprovals implements second person approval of certificate
+// requests. A request for a sensitive certificate is queued until another
+// user approves it, after which the requester may get the certificate once
+// before the approval expires, and only for the keys named in the request. Requests are kept in a directory, one file
+// each.
+package certapprovals
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// States of a Request.
+const (
+	StatePending  = "pending"
+	StateApproved = "approved"
+	StateRejected = "rejected"
+	// StateUsed is an approved request the certificate was issued for.
+	StateUsed = "used"
+)
+
+var (
+	ErrNotFound     = errors.New("certapprovals: no such request")
+	ErrNotPending   = errors.New("certapprovals: request is not pending")
+	ErrExpired      = errors.New("certapprovals: request expired")
+	ErrSelfApproval = errors.New(
+		"certapprovals: a request cannot be approved by its requester")
+)
+
+// Request is one certificate awaiting or given approval.
+type Request struct {
+	ID        uint64
+	Created   time.Time
+	Requester string
+	// Target is the user or role the certificate is for.
+	Target       string
+	CertType     string
+	DurationSecs int64
+	// KeyFingerprints are the sorted SHA256 fingerprints of the keys to
+	// certify, so that an approval cannot be used for other keys.
+	KeyFingerprints []string `json:",omitempty"`
+	// Rule is the name of the policy requiring approval.
+	Rule  string
+	State string
+	// Approver approved or rejected the request at Decided.
+	Approver    string    `json:",omitempty"`
+	Decided     time.Time `json:",omitempty"`
+	ReviewedVia string    `json:",omitempty"`
+	// Expires is when a pending request can no longer be approved or an
+	// approved one no longer be used.
+	Expires time.Time
+	Used    time.Time `json:",omitempty"`
+}
+
+// Store is a directory of requests. Methods are safe for concurrent use.
+type Store struct {
+	directory string
+	mutex     sync.Mutex
+	requests  []Request           // Ordered by ID.
+	claimed   map[uint64]struct{} // Approved requests being issued.
+}
+
+// Open opens the store in directory, creating it if needed.
+func Open(directory string) (*Store, error) {
+	return openStore(directory)
+}
+
+// Create stores a new pending request for the certificate described by
+// request, valid for approval for pendingFor, unless an identical request
+// is already pending, which is returned instead. created is true for a new
+// request.
+func (s *Store) Create(request Request, pendingFor time.Duration) (
+	Request, bool, error) {
+	return s.create(request, pendingFor)
+}
+
+// Get returns the request with the given ID.
+func (s *Store) Get(id uint64) (Request, bool) {
+	return s.get(id)
+}
+
+// List returns all requests, oldest first.
+func (s *Store) List() []Request {
+	return s.list()
+}
+
+// Approve marks a pending request as approved by approver, who must not be
+// its requester, through via (empty for the web API). The approval may be
+// used for approvedFor. check is called with the request before it is
+// changed and aborts the approval if it returns an error.
+func (s *Store) Approve(id uint64, approver, via string,
+	approvedFor time.Duration, check func(Request) error) (Request, error) {
+	return s.approve(id, approver, via, approvedFor, check)
+}
+
+// Reject marks a pending request as rejected by approver. Requesters may
+// reject (withdraw) their own requests.
+func (s *Store) Reject(id uint64, approver, via string) (Request, error) {
+	return s.reject(id, approver, via)
+}
+
+// Claim returns the approved, unexpired request for the certificate
+// described by request and reserves it until Complete is called, so that
+// concurrent requests cannot both use it. It returns false if there is
+// none. Longer durations than approved are not covered.
+func (s *Store) Claim(request Request) (Request, bool) {
+	return s.claim(request)
+}
+
+// Complete ends the claim of the request id, marking it as used if issued
+// is true. Otherwise the approval can be claimed again.
+func (s *Store) Complete(id uint64, issued bool) (Request, error) {
+	return s.complete(id, issued)
+}
@@ -0,0 +1,241 @@
+package certapprovals
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const requestSuffix = ".json"
+
+func openStore(directory string) (*Store, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	fileInfos, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{directory: directory}
+	for _, fileInfo := range fileInfos {
+		if !strings.HasSuffix(fileInfo.Name(), requestSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(directory, fileInfo.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var request Request
+		if err := json.Unmarshal(data, &request); err != nil {
+			return nil, fmt.Errorf("certapprovals: %s: %s", fileInfo.Name(),
+				err)
+		}
+		s.requests = append(s.requests, request)
+	}
+	sort.Slice(s.requests, func(i, j int) bool {
+		return s.requests[i].ID < s.requests[j].ID
+	})
+	return s, nil
+}
+
+// write must be called with the lock held.
+func (s *Store) write(request Request) error {
+	data, err := json.MarshalIndent(request, "", "    ")
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(s.directory,
+		fmt.Sprintf("%d%s", request.ID, requestSuffix))
+	tmpFilename := filename + "~"
+	if err := ioutil.WriteFile(tmpFilename, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+// sameCertificate returns true if a and b are for the same certificate.
+func sameCertificate(a, b Request) bool {
+	if a.Requester != b.Requester || a.Target != b.Target ||
+		a.CertType != b.CertType ||
+		len(a.KeyFingerprints) != len(b.KeyFingerprints) {
+		return false
+	}
+	for index, fingerprint := range a.KeyFingerprints {
+		if b.KeyFingerprints[index] != fingerprint {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) create(template Request, pendingFor time.Duration) (Request,
+	bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now().UTC()
+	for _, request := range s.requests {
+		if request.State == StatePending && now.Before(request.Expires) &&
+			sameCertificate(request, template) &&
+			request.DurationSecs >= template.DurationSecs {
+			return request, false, nil
+		}
+	}
+	request := Request{
+		ID:              1,
+		Created:         now,
+		Requester:       template.Requester,
+		Target:          template.Target,
+		CertType:        template.CertType,
+		DurationSecs:    template.DurationSecs,
+		KeyFingerprints: template.KeyFingerprints,
+		Rule:            template.Rule,
+		State:           StatePending,
+		Expires:         now.Add(pendingFor),
+	}
+	if len(s.requests) > 0 {
+		request.ID = s.requests[len(s.requests)-1].ID + 1
+	}
+	if err := s.write(request); err != nil {
+		return Request{}, false, err
+	}
+	s.requests = append(s.requests, request)
+	return request, true, nil
+}
+
+// index must be called with the lock held.
+func (s *Store) index(id uint64) int {
+	index := sort.Search(len(s.requests), func(i int) bool {
+		return s.requests[i].ID >= id
+	})
+	if index < len(s.requests) && s.requests[index].ID == id {
+		return index
+	}
+	return -1
+}
+
+func (s *Store) get(id uint64) (Request, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	index := s.index(id)
+	if index < 0 {
+		return Request{}, false
+	}
+	return s.requests[index], true
+}
+
+func (s *Store) list() []Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// decide applies change to a copy of a pending, unexpired request and
+// stores it.
+func (s *Store) decide(id uint64, change func(*Request) error) (Request,
+	error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	index := s.index(id)
+	if index < 0 {
+		return Request{}, ErrNotFound
+	}
+	request := s.requests[index]
+	if request.State != StatePending {
+		return Request{}, ErrNotPending
+	}
+	if !time.Now().Before(request.Expires) {
+		return Request{}, ErrExpired
+	}
+	if err := change(&request); err != nil {
+		return Request{}, err
+	}
+	if err := s.write(request); err != nil {
+		return Request{}, err
+	}
+	s.requests[index] = request
+	return request, nil
+}
+
+func (s *Store) approve(id uint64, approver, via string,
+	approvedFor time.Duration, check func(Request) error) (Request, error) {
+	return s.decide(id, func(request *Request) error {
+		if request.Requester == approver {
+			return ErrSelfApproval
+		}
+		if check != nil {
+			if err := check(*request); err != nil {
+				return err
+			}
+		}
+		now := time.Now().UTC()
+		request.State = StateApproved
+		request.Approver = approver
+		request.Decided = now
+		request.ReviewedVia = via
+		request.Expires = now.Add(approvedFor)
+		return nil
+	})
+}
+
+func (s *Store) reject(id uint64, approver, via string) (Request, error) {
+	return s.decide(id, func(request *Request) error {
+		request.State = StateRejected
+		request.Approver = approver
+		request.Decided = time.Now().UTC()
+		request.ReviewedVia = via
+		return nil
+	})
+}
+
+func (s *Store) claim(template Request) (Request, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now().UTC()
+	for _, request := range s.requests {
+		if request.State != StateApproved || !now.Before(request.Expires) ||
+			!sameCertificate(request, template) ||
+			request.DurationSecs < template.DurationSecs {
+			continue
+		}
+		if _, ok := s.claimed[request.ID]; ok {
+			continue
+		}
+		if s.claimed == nil {
+			s.claimed = make(map[uint64]struct{})
+		}
+		s.claimed[request.ID] = struct{}{}
+		return request, true
+	}
+	return Request{}, false
+}
+
+func (s *Store) complete(id uint64, issued bool) (Request, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.claimed[id]; !ok {
+		return Request{}, ErrNotFound
+	}
+	delete(s.claimed, id)
+	index := s.index(id)
+	if index < 0 {
+		return Request{}, ErrNotFound
+	}
+	request := s.requests[index]
+	if !issued {
+		return request, nil
+	}
+	// The approval may have expired while the certificate was issued,
+	// which still used it.
+	request.State = StateUsed
+	request.Used = time.Now().UTC()
+	if err := s.write(request); err != nil {
+		return Request{}, err
+	}
+	s.requests[index] = request
+	return request, nil
+}
@@ -0,0 +1,135 @@
+package certapprovals
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certapprovals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := Request{Requester: "alice", Target: "root", CertType: "ssh",
+		DurationSecs: 3600, Rule: "root"}
+	first, created, err := store.Create(template, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || first.ID != 1 || first.State != StatePending {
+		t.Fatalf("unexpected request: %+v", first)
+	}
+	// Asking again while pending does not queue another request.
+	again, created, err := store.Create(template, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || again.ID != first.ID {
+		t.Fatalf("duplicate request: %+v", again)
+	}
+	if _, ok := store.Claim(template); ok {
+		t.Fatal("pending request claimed")
+	}
+	_, err = store.Approve(first.ID, "alice", "", time.Minute, nil)
+	if err != ErrSelfApproval {
+		t.Fatalf("self approval: %v", err)
+	}
+	errNotApprover := errors.New("not an approver")
+	_, err = store.Approve(first.ID, "bob", "", time.Minute,
+		func(Request) error { return errNotApprover })
+	if err != errNotApprover {
+		t.Fatalf("failed check: %v", err)
+	}
+	approved, err := store.Approve(first.ID, "bob", "", time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approved.State != StateApproved || approved.Approver != "bob" {
+		t.Fatalf("unexpected approved request: %+v", approved)
+	}
+	if _, err := store.Reject(first.ID, "bob", ""); err != ErrNotPending {
+		t.Fatalf("decided request: %v", err)
+	}
+	longer := template
+	longer.DurationSecs = 7200
+	if _, ok := store.Claim(longer); ok {
+		t.Fatal("approval claimed for a longer duration")
+	}
+	otherKey := template
+	otherKey.KeyFingerprints = []string{"SHA256:other"}
+	if _, ok := store.Claim(otherKey); ok {
+		t.Fatal("approval claimed for another key")
+	}
+	claimed, ok := store.Claim(template)
+	if !ok || claimed.ID != first.ID {
+		t.Fatalf("unexpected claimed request: %+v", claimed)
+	}
+	if _, ok := store.Claim(template); ok {
+		t.Fatal("approval claimed twice")
+	}
+	// A failed issuance leaves the approval to be used.
+	if _, err := store.Complete(claimed.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	claimed, ok = store.Claim(template)
+	if !ok {
+		t.Fatal("approval not claimed again")
+	}
+	used, err := store.Complete(claimed.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used.ID != first.ID || used.State != StateUsed {
+		t.Fatalf("unexpected used request: %+v", used)
+	}
+	if _, ok := store.Claim(template); ok {
+		t.Fatal("approval used twice")
+	}
+	if _, err := store.Complete(claimed.ID, true); err != ErrNotFound {
+		t.Fatalf("completed twice: %v", err)
+	}
+
+	second, _, err := store.Create(template, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Reject(second.ID, "carol", "slack:U012ABC"); err != nil {
+		t.Fatal(err)
+	}
+	third, _, err := store.Create(template, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Approve(third.ID, "bob", "", time.Minute, nil)
+	if err != ErrExpired {
+		t.Fatalf("expired request: %v", err)
+	}
+	if _, err := store.Reject(99, "bob", ""); err != ErrNotFound {
+		t.Fatalf("unknown request: %v", err)
+	}
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := reopened.List()
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(requests))
+	}
+	if requests[0].State != StateUsed || requests[1].State != StateRejected ||
+		requests[1].ReviewedVia != "slack:U012ABC" {
+		t.Fatalf("unexpected reopened requests: %+v", requests)
+	}
+	if request, ok := reopened.Get(third.ID); !ok ||
+		request.State != StatePending {
+		t.Fatalf("unexpected third request: %+v", request)
+	}
+}
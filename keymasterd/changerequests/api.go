@@ -0,0 +1,131 @@
+// Package changerequests implements review of configuration changes. A
+// change is submitted by one administrator and must be approved by another
+// before it is activated. Requests are kept in a directory, one file each.
+package changerequests
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// States of a Request.
+const (
+	StatePending  = "pending"
+	StateApproved = "approved"
+	StateRejected = "rejected"
+)
+
+var (
+	ErrNotFound     = errors.New("changerequests: no such request")
+	ErrNotPending   = errors.New("changerequests: request is not pending")
+	ErrSelfApproval = errors.New(
+		"changerequests: a request cannot be approved by its author")
+)
+
+// Comment is a review comment.
+type Comment struct {
+	Author string
+	Time   time.Time
+	Text   string
+}
+
+// Request is one proposed change.
+type Request struct {
+	ID      uint64
+	Created time.Time
+	Author  string
+	Comment string `json:",omitempty"`
+	// BaseVersion is the policy version in force when the change was
+	// proposed and ProposedVersion the version it activates.
+	BaseVersion     uint64
+	ProposedVersion uint64
+	State           string
+	Comments        []Comment `json:",omitempty"`
+	// Reviewer approved or rejected the request at Decided.
+	Reviewer string    `json:",omitempty"`
+	Decided  time.Time `json:",omitempty"`
+	// ReviewedVia is the chat integration and identity the review was given
+	// with, e.g. "slack:U012ABC", empty for the web UI.
+	ReviewedVia string `json:",omitempty"`
+	// ConfigVersion is the version of the configuration file at approval.
+	ConfigVersion uint64 `json:",omitempty"`
+}
+
+// Store is a directory of requests. Methods are safe for concurrent use.
+type Store struct {
+	directory string
+	mutex     sync.Mutex
+	requests  []Request // Ordered by ID.
+}
+
+// Open opens the store in directory, creating it if needed.
+func Open(directory string) (*Store, error) {
+	return openStore(directory)
+}
+
+// Create stores a new pending request.
+func (s *Store) Create(author, comment string, baseVersion,
+	proposedVersion uint64) (Request, error) {
+	return s.create(author, comment, baseVersion, proposedVersion)
+}
+
+// Get returns the request with the given ID.
+func (s *Store) Get(id uint64) (Request, bool) {
+	return s.get(id)
+}
+
+// List returns all requests, oldest first.
+func (s *Store) List() []Request {
+	return s.list()
+}
+
+// LatestApproved returns the most recently approved request.
+func (s *Store) LatestApproved() (Request, bool) {
+	return s.latestApproved()
+}
+
+// AddComment adds a review comment to a pending request.
+func (s *Store) AddComment(id uint64, author, text string) (Request, error) {
+	return s.update(id, func(request *Request) error {
+		request.Comments = append(request.Comments, Comment{
+			Author: author,
+			Time:   time.Now().UTC(),
+			Text:   text,
+		})
+		return nil
+	})
+}
+
+// Approve marks a pending request as approved by reviewer, who must not be
+// its author. check is called with the request before it is changed and
+// aborts the approval if it returns an error; it is typically used to check
+// that the base version is still in force.
+func (s *Store) Approve(id uint64, reviewer string, configVersion uint64,
+	check func(Request) error) (Request, error) {
+	return s.approve(id, reviewer, "", configVersion, check)
+}
+
+// ApproveVia is Approve for reviews given through a chat integration,
+// recorded as via in ReviewedVia.
+func (s *Store) ApproveVia(id uint64, reviewer, via string,
+	configVersion uint64, check func(Request) error) (Request, error) {
+	return s.approve(id, reviewer, via, configVersion, check)
+}
+
+// Reject marks a pending request as rejected by reviewer. Authors may reject
+// (withdraw) their own requests.
+func (s *Store) Reject(id uint64, reviewer string) (Request, error) {
+	return s.RejectVia(id, reviewer, "")
+}
+
+// RejectVia is Reject for reviews given through a chat integration.
+func (s *Store) RejectVia(id uint64, reviewer, via string) (Request, error) {
+	return s.update(id, func(request *Request) error {
+		request.State = StateRejected
+		request.Reviewer = reviewer
+		request.Decided = time.Now().UTC()
+		request.ReviewedVia = via
+		return nil
+	})
+}
@@ -0,0 +1,178 @@
+package changerequests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const requestSuffix = ".json"
+
+func openStore(directory string) (*Store, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	fileInfos, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{directory: directory}
+	for _, fileInfo := range fileInfos {
+		if !strings.HasSuffix(fileInfo.Name(), requestSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(directory, fileInfo.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var request Request
+		if err := json.Unmarshal(data, &request); err != nil {
+			return nil, fmt.Errorf("changerequests: %s: %s", fileInfo.Name(),
+				err)
+		}
+		s.requests = append(s.requests, request)
+	}
+	sort.Slice(s.requests, func(i, j int) bool {
+		return s.requests[i].ID < s.requests[j].ID
+	})
+	return s, nil
+}
+
+// write must be called with the lock held.
+func (s *Store) write(request Request) error {
+	data, err := json.MarshalIndent(request, "", "    ")
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(s.directory,
+		fmt.Sprintf("%d%s", request.ID, requestSuffix))
+	tmpFilename := filename + "~"
+	if err := ioutil.WriteFile(tmpFilename, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+func (s *Store) create(author, comment string, baseVersion,
+	proposedVersion uint64) (Request, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	request := Request{
+		ID:              1,
+		Created:         time.Now().UTC(),
+		Author:          author,
+		Comment:         comment,
+		BaseVersion:     baseVersion,
+		ProposedVersion: proposedVersion,
+		State:           StatePending,
+	}
+	if len(s.requests) > 0 {
+		request.ID = s.requests[len(s.requests)-1].ID + 1
+	}
+	if err := s.write(request); err != nil {
+		return Request{}, err
+	}
+	s.requests = append(s.requests, request)
+	return request, nil
+}
+
+// index must be called with the lock held.
+func (s *Store) index(id uint64) int {
+	index := sort.Search(len(s.requests), func(i int) bool {
+		return s.requests[i].ID >= id
+	})
+	if index < len(s.requests) && s.requests[index].ID == id {
+		return index
+	}
+	return -1
+}
+
+func (s *Store) get(id uint64) (Request, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	index := s.index(id)
+	if index < 0 {
+		return Request{}, false
+	}
+	return copyRequest(s.requests[index]), true
+}
+
+func (s *Store) list() []Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	requests := make([]Request, 0, len(s.requests))
+	for _, request := range s.requests {
+		requests = append(requests, copyRequest(request))
+	}
+	return requests
+}
+
+func (s *Store) latestApproved() (Request, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var latest Request
+	found := false
+	for _, request := range s.requests {
+		if request.State == StateApproved &&
+			(!found || !request.Decided.Before(latest.Decided)) {
+			latest = request
+			found = true
+		}
+	}
+	return copyRequest(latest), found
+}
+
+// update applies change to a copy of a pending request and stores it.
+func (s *Store) update(id uint64, change func(*Request) error) (Request,
+	error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	index := s.index(id)
+	if index < 0 {
+		return Request{}, ErrNotFound
+	}
+	request := copyRequest(s.requests[index])
+	if request.State != StatePending {
+		return Request{}, ErrNotPending
+	}
+	if err := change(&request); err != nil {
+		return Request{}, err
+	}
+	if err := s.write(request); err != nil {
+		return Request{}, err
+	}
+	s.requests[index] = request
+	return copyRequest(request), nil
+}
+
+func (s *Store) approve(id uint64, reviewer, via string,
+	configVersion uint64, check func(Request) error) (Request, error) {
+	return s.update(id, func(request *Request) error {
+		if request.Author == reviewer {
+			return ErrSelfApproval
+		}
+		if check != nil {
+			if err := check(*request); err != nil {
+				return err
+			}
+		}
+		request.State = StateApproved
+		request.Reviewer = reviewer
+		request.Decided = time.Now().UTC()
+		request.ConfigVersion = configVersion
+		request.ReviewedVia = via
+		return nil
+	})
+}
+
+func copyRequest(request Request) Request {
+	if request.Comments != nil {
+		request.Comments = append([]Comment(nil), request.Comments...)
+	}
+	return request
+}
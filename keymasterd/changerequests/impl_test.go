@@ -0,0 +1,82 @@
+package changerequests
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "changerequests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := store.Create("alice", "enable duo", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != 1 || first.State != StatePending {
+		t.Fatalf("unexpected request: %+v", first)
+	}
+	if _, err := store.AddComment(first.ID, "bob", "looks good"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Approve(first.ID, "alice", 1, nil); err != ErrSelfApproval {
+		t.Fatalf("self approval: %v", err)
+	}
+	errStale := errors.New("stale")
+	_, err = store.Approve(first.ID, "bob", 1,
+		func(Request) error { return errStale })
+	if err != errStale {
+		t.Fatalf("failed check: %v", err)
+	}
+	if request, _ := store.Get(first.ID); request.State != StatePending {
+		t.Fatal("failed approval should leave the request pending")
+	}
+	if _, ok := store.LatestApproved(); ok {
+		t.Fatal("nothing is approved yet")
+	}
+	approved, err := store.Approve(first.ID, "bob", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approved.State != StateApproved || approved.Reviewer != "bob" {
+		t.Fatalf("unexpected approved request: %+v", approved)
+	}
+	if _, err := store.Reject(first.ID, "bob"); err != ErrNotPending {
+		t.Fatalf("decided request: %v", err)
+	}
+	second, err := store.Create("bob", "", 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RejectVia(second.ID, "alice", "slack:U012ABC"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddComment(99, "bob", "x"); err != ErrNotFound {
+		t.Fatalf("unknown request: %v", err)
+	}
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := reopened.List()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+	if len(requests[0].Comments) != 1 || requests[1].State != StateRejected ||
+		requests[1].ReviewedVia != "slack:U012ABC" {
+		t.Fatalf("unexpected reopened requests: %+v", requests)
+	}
+	latest, ok := reopened.LatestApproved()
+	if !ok || latest.ID != first.ID || latest.ConfigVersion != 1 {
+		t.Fatalf("unexpected latest approved: %+v", latest)
+	}
+}
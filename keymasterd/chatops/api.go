@@ -0,0 +1,102 @@
+// Package chatops sends requests waiting for approval to chat systems and
+// verifies the answers of the approvers, which come back as signed
+// callbacks. Slack interactive messages and a generic signed webhook for
+// other chat bots are supported.
+package chatops
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Decisions of an approver.
+const (
+	DecisionApprove = "approve"
+	DecisionDeny    = "deny"
+)
+
+// MaxClockSkew is how old a signed callback may be.
+const MaxClockSkew = 5 * time.Minute
+
+var (
+	ErrBadSignature  = errors.New("chatops: bad signature")
+	ErrStaleCallback = errors.New("chatops: callback timestamp out of range")
+	// ErrNoDecision is returned for interactions which are not a decision,
+	// such as following the link to the web UI. They only need to be
+	// acknowledged.
+	ErrNoDecision = errors.New("chatops: not a decision")
+)
+
+// Approval is a request waiting for a decision.
+type Approval struct {
+	// Kind and ID identify the request, e.g. "change_request" and "12".
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	// Details are shown one per line.
+	Details []string `json:"details,omitempty"`
+	// URL is where the request can be reviewed in the web UI.
+	URL string `json:"url,omitempty"`
+}
+
+// Callback is an approver's answer to an Approval.
+type Callback struct {
+	Kind     string
+	ID       string
+	Decision string
+	// UserID identifies the approver in the chat system. Username is the
+	// keymaster user it maps to, empty if it maps to none.
+	UserID   string
+	Username string
+	// ResponseURL is where Respond posts the outcome, if supported.
+	ResponseURL string
+}
+
+// Integration is a chat system approvers are notified in.
+type Integration interface {
+	// Name is the name of the integration in the audit trail and in the
+	// callback URL.
+	Name() string
+	// Notify posts approval for approvers to decide on.
+	Notify(approval Approval) error
+	// ParseCallback verifies and parses the callback in r.
+	ParseCallback(r *http.Request) (Callback, error)
+	// Respond tells the approver the outcome of their decision.
+	Respond(callback Callback, text string) error
+}
+
+// SlackConfig configures a Slack app. Messages are posted to an incoming
+// webhook and the interactivity request URL of the app must point to the
+// callback URL.
+type SlackConfig struct {
+	WebhookURL    string
+	SigningSecret []byte
+	// UserIDs maps Slack user IDs to keymaster usernames. Users not in the
+	// map cannot approve.
+	UserIDs    map[string]string
+	HTTPClient *http.Client
+}
+
+// NewSlack returns a Slack integration called name.
+func NewSlack(name string, config SlackConfig) (Integration, error) {
+	return newSlack(name, config, time.Now)
+}
+
+// WebhookConfig configures a generic chat bot. Approvals are posted as JSON
+// to URL and the bot posts the decisions back to the callback URL as JSON
+// with the kind, id, decision and approver (a keymaster username). Both
+// directions are signed with Secret: the X-Keymaster-Timestamp header holds
+// the Unix time and X-Keymaster-Signature is "v1=" followed by the hex
+// HMAC-SHA256 of the timestamp, a period and the body.
+type WebhookConfig struct {
+	URL        string
+	Secret     []byte
+	HTTPClient *http.Client
+}
+
+// NewWebhook returns a generic webhook integration called name.
+func NewWebhook(name string, config WebhookConfig) (Integration, error) {
+	return newWebhook(name, config, time.Now)
+}
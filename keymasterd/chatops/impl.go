@@ -0,0 +1,360 @@
+package chatops
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	slackTimestampHeader   = "X-Slack-Request-Timestamp"
+	slackSignatureHeader   = "X-Slack-Signature"
+	webhookTimestampHeader = "X-Keymaster-Timestamp"
+	webhookSignatureHeader = "X-Keymaster-Signature"
+	maxCallbackSize        = 1 << 16
+	httpTimeout            = 10 * time.Second
+	slackReviewAction      = "review"
+)
+
+func defaultClient(client *http.Client) *http.Client {
+	if client == nil {
+		return &http.Client{Timeout: httpTimeout}
+	}
+	return client
+}
+
+func checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return fmt.Errorf("chatops: invalid URL: %s", rawURL)
+	}
+	return nil
+}
+
+func hexHMAC(secret []byte, message string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// readSignedBody reads the body of r after checking its timestamp. sign
+// returns the expected signature of the body for the timestamp.
+func readSignedBody(r *http.Request, timestampHeader, signatureHeader string,
+	now time.Time, sign func(timestamp string, body []byte) string) (
+	[]byte, error) {
+	timestamp := r.Header.Get(timestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrStaleCallback
+	}
+	skew := now.Sub(time.Unix(seconds, 0))
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		return nil, ErrStaleCallback
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxCallbackSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxCallbackSize {
+		return nil, errors.New("chatops: callback too large")
+	}
+	if !hmac.Equal([]byte(r.Header.Get(signatureHeader)),
+		[]byte(sign(timestamp, body))) {
+		return nil, ErrBadSignature
+	}
+	return body, nil
+}
+
+func postJSON(client *http.Client, destination string, body []byte,
+	headers map[string]string) error {
+	req, err := http.NewRequest("POST", destination, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("chatops: %s returned %s", destination, resp.Status)
+	}
+	return nil
+}
+
+// parseValue splits the kind:id value of the buttons.
+func parseValue(value string) (string, string, error) {
+	index := strings.LastIndex(value, ":")
+	if index < 1 || index == len(value)-1 {
+		return "", "", fmt.Errorf("chatops: bad value: %q", value)
+	}
+	return value[:index], value[index+1:], nil
+}
+
+func checkDecision(decision string) error {
+	if decision != DecisionApprove && decision != DecisionDeny {
+		return fmt.Errorf("chatops: unknown decision: %q", decision)
+	}
+	return nil
+}
+
+type slackIntegration struct {
+	name   string
+	config SlackConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+func newSlack(name string, config SlackConfig,
+	now func() time.Time) (*slackIntegration, error) {
+	if err := checkURL(config.WebhookURL); err != nil {
+		return nil, err
+	}
+	if len(config.SigningSecret) < 1 {
+		return nil, errors.New("chatops: no Slack signing secret")
+	}
+	return &slackIntegration{
+		name:   name,
+		config: config,
+		client: defaultClient(config.HTTPClient),
+		now:    now,
+	}, nil
+}
+
+func (s *slackIntegration) Name() string {
+	return s.name
+}
+
+// slackEscape escapes the characters with a meaning in Slack messages.
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").
+		Replace(text)
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackElement struct {
+	Type     string     `json:"type"`
+	Text     *slackText `json:"text,omitempty"`
+	Style    string     `json:"style,omitempty"`
+	ActionID string     `json:"action_id,omitempty"`
+	Value    string     `json:"value,omitempty"`
+	URL      string     `json:"url,omitempty"`
+}
+
+type slackBlock struct {
+	Type     string         `json:"type"`
+	Text     *slackText     `json:"text,omitempty"`
+	Elements []slackElement `json:"elements,omitempty"`
+}
+
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks,omitempty"`
+	// Only used in responses.
+	ReplaceOriginal bool `json:"replace_original,omitempty"`
+}
+
+func (s *slackIntegration) message(approval Approval) slackMessage {
+	lines := []string{"*" + slackEscape(approval.Title) + "*",
+		"Requested by " + slackEscape(approval.Author)}
+	for _, detail := range approval.Details {
+		lines = append(lines, slackEscape(detail))
+	}
+	value := approval.Kind + ":" + approval.ID
+	elements := []slackElement{
+		{Type: "button", Text: &slackText{"plain_text", "Approve"},
+			Style: "primary", ActionID: DecisionApprove, Value: value},
+		{Type: "button", Text: &slackText{"plain_text", "Deny"},
+			Style: "danger", ActionID: DecisionDeny, Value: value},
+	}
+	if approval.URL != "" {
+		elements = append(elements, slackElement{Type: "button",
+			Text:     &slackText{"plain_text", "Review"},
+			ActionID: slackReviewAction, URL: approval.URL})
+	}
+	return slackMessage{
+		Text: approval.Title,
+		Blocks: []slackBlock{
+			{Type: "section",
+				Text: &slackText{"mrkdwn", strings.Join(lines, "\n")}},
+			{Type: "actions", Elements: elements},
+		},
+	}
+}
+
+func (s *slackIntegration) Notify(approval Approval) error {
+	body, err := json.Marshal(s.message(approval))
+	if err != nil {
+		return err
+	}
+	return postJSON(s.client, s.config.WebhookURL, body, nil)
+}
+
+func (s *slackIntegration) ParseCallback(r *http.Request) (Callback, error) {
+	body, err := readSignedBody(r, slackTimestampHeader, slackSignatureHeader,
+		s.now(), func(timestamp string, body []byte) string {
+			return "v0=" + hexHMAC(s.config.SigningSecret,
+				"v0:"+timestamp+":"+string(body))
+		})
+	if err != nil {
+		return Callback{}, err
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return Callback{}, err
+	}
+	var payload struct {
+		Type string `json:"type"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+		Actions []struct {
+			ActionID string `json:"action_id"`
+			Value    string `json:"value"`
+		} `json:"actions"`
+		ResponseURL string `json:"response_url"`
+	}
+	if err := json.Unmarshal([]byte(values.Get("payload")), &payload); err != nil {
+		return Callback{}, err
+	}
+	if payload.Type != "block_actions" || len(payload.Actions) != 1 {
+		return Callback{}, fmt.Errorf("chatops: unexpected Slack %s payload",
+			payload.Type)
+	}
+	action := payload.Actions[0]
+	if action.ActionID == slackReviewAction {
+		return Callback{}, ErrNoDecision
+	}
+	if err := checkDecision(action.ActionID); err != nil {
+		return Callback{}, err
+	}
+	kind, id, err := parseValue(action.Value)
+	if err != nil {
+		return Callback{}, err
+	}
+	return Callback{
+		Kind:        kind,
+		ID:          id,
+		Decision:    action.ActionID,
+		UserID:      payload.User.ID,
+		Username:    s.config.UserIDs[payload.User.ID],
+		ResponseURL: payload.ResponseURL,
+	}, nil
+}
+
+// Respond replaces the message with the buttons by text.
+func (s *slackIntegration) Respond(callback Callback, text string) error {
+	if callback.ResponseURL == "" {
+		return nil
+	}
+	if err := checkURL(callback.ResponseURL); err != nil {
+		return err
+	}
+	body, err := json.Marshal(slackMessage{Text: slackEscape(text),
+		ReplaceOriginal: true})
+	if err != nil {
+		return err
+	}
+	return postJSON(s.client, callback.ResponseURL, body, nil)
+}
+
+type webhookIntegration struct {
+	name   string
+	config WebhookConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+func newWebhook(name string, config WebhookConfig,
+	now func() time.Time) (*webhookIntegration, error) {
+	if err := checkURL(config.URL); err != nil {
+		return nil, err
+	}
+	if len(config.Secret) < 16 {
+		return nil, errors.New("chatops: webhook secret is too short")
+	}
+	return &webhookIntegration{
+		name:   name,
+		config: config,
+		client: defaultClient(config.HTTPClient),
+		now:    now,
+	}, nil
+}
+
+func (w *webhookIntegration) Name() string {
+	return w.name
+}
+
+func (w *webhookIntegration) sign(timestamp string, body []byte) string {
+	return "v1=" + hexHMAC(w.config.Secret, timestamp+"."+string(body))
+}
+
+func (w *webhookIntegration) Notify(approval Approval) error {
+	body, err := json.Marshal(approval)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(w.now().Unix(), 10)
+	return postJSON(w.client, w.config.URL, body, map[string]string{
+		webhookTimestampHeader: timestamp,
+		webhookSignatureHeader: w.sign(timestamp, body),
+	})
+}
+
+func (w *webhookIntegration) ParseCallback(r *http.Request) (Callback, error) {
+	body, err := readSignedBody(r, webhookTimestampHeader,
+		webhookSignatureHeader, w.now(), w.sign)
+	if err != nil {
+		return Callback{}, err
+	}
+	var payload struct {
+		Kind     string `json:"kind"`
+		ID       string `json:"id"`
+		Decision string `json:"decision"`
+		Approver string `json:"approver"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Callback{}, err
+	}
+	if err := checkDecision(payload.Decision); err != nil {
+		return Callback{}, err
+	}
+	if payload.Kind == "" || payload.ID == "" || payload.Approver == "" {
+		return Callback{}, errors.New("chatops: kind, id and approver are required")
+	}
+	return Callback{
+		Kind:     payload.Kind,
+		ID:       payload.ID,
+		Decision: payload.Decision,
+		UserID:   payload.Approver,
+		Username: payload.Approver,
+	}, nil
+}
+
+// Respond does nothing: the bot gets the outcome in the callback response.
+func (w *webhookIntegration) Respond(callback Callback, text string) error {
+	return nil
+}
@@ -0,0 +1,146 @@
+package chatops
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func newSlackCallback(t *testing.T, secret []byte, timestamp time.Time,
+	payload interface{}) *http.Request {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := url.Values{"payload": {string(data)}}.Encode()
+	req := httptest.NewRequest("POST", "/callback", strings.NewReader(body))
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	req.Header.Set(slackTimestampHeader, ts)
+	req.Header.Set(slackSignatureHeader,
+		"v0="+hexHMAC(secret, "v0:"+ts+":"+body))
+	return req
+}
+
+func slackPayload(actionID, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":         "block_actions",
+		"user":         map[string]string{"id": "U012ABC"},
+		"actions":      []map[string]string{{"action_id": actionID, "value": value}},
+		"response_url": "https://hooks.slack.com/actions/T0/1/x",
+	}
+}
+
+func TestSlack(t *testing.T) {
+	var posted []slackMessage
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var message slackMessage
+			if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+				t.Error(err)
+			}
+			posted = append(posted, message)
+		}))
+	defer server.Close()
+	now := time.Now()
+	slack, err := newSlack("slack", SlackConfig{
+		WebhookURL:    server.URL,
+		SigningSecret: testSecret,
+		UserIDs:       map[string]string{"U012ABC": "alice"},
+	}, func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = slack.Notify(Approval{Kind: "change_request", ID: "12",
+		Title: "Change request 12", Author: "bob",
+		Details: []string{"duo_enabled: false -> <true>"},
+		URL:     "https://keymaster.example.com/changes/12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 1 || len(posted[0].Blocks) != 2 ||
+		len(posted[0].Blocks[1].Elements) != 3 {
+		t.Fatalf("unexpected message: %+v", posted)
+	}
+	if text := posted[0].Blocks[0].Text.Text; !strings.Contains(text,
+		"&lt;true&gt;") {
+		t.Errorf("details not escaped: %s", text)
+	}
+	callback, err := slack.ParseCallback(newSlackCallback(t, testSecret, now,
+		slackPayload(DecisionApprove, "change_request:12")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback.Kind != "change_request" || callback.ID != "12" ||
+		callback.Decision != DecisionApprove || callback.Username != "alice" {
+		t.Errorf("unexpected callback: %+v", callback)
+	}
+	_, err = slack.ParseCallback(newSlackCallback(t, []byte("wrong"), now,
+		slackPayload(DecisionApprove, "change_request:12")))
+	if err != ErrBadSignature {
+		t.Errorf("forged callback: %v", err)
+	}
+	_, err = slack.ParseCallback(newSlackCallback(t, testSecret,
+		now.Add(-10*time.Minute),
+		slackPayload(DecisionApprove, "change_request:12")))
+	if err != ErrStaleCallback {
+		t.Errorf("replayed callback: %v", err)
+	}
+	_, err = slack.ParseCallback(newSlackCallback(t, testSecret, now,
+		slackPayload(slackReviewAction, "")))
+	if err != ErrNoDecision {
+		t.Errorf("review link: %v", err)
+	}
+	_, err = slack.ParseCallback(newSlackCallback(t, testSecret, now,
+		slackPayload("delete", "change_request:12")))
+	if err == nil {
+		t.Error("unknown action accepted")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	now := time.Now()
+	var webhook *webhookIntegration
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			expected := webhook.sign(r.Header.Get(webhookTimestampHeader),
+				body)
+			if r.Header.Get(webhookSignatureHeader) != expected {
+				t.Error("notification not signed")
+			}
+		}))
+	defer server.Close()
+	webhook, err := newWebhook("bot", WebhookConfig{URL: server.URL,
+		Secret: testSecret}, func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := webhook.Notify(Approval{Kind: "change_request", ID: "3",
+		Title: "Change request 3", Author: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"kind":"change_request","id":"3","decision":"deny","approver":"alice"}`
+	req := httptest.NewRequest("POST", "/callback", strings.NewReader(body))
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(webhookTimestampHeader, ts)
+	req.Header.Set(webhookSignatureHeader, webhook.sign(ts, []byte(body)))
+	callback, err := webhook.ParseCallback(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback.Decision != DecisionDeny || callback.Username != "alice" {
+		t.Errorf("unexpected callback: %+v", callback)
+	}
+	if _, err := newWebhook("bot", WebhookConfig{URL: server.URL,
+		Secret: []byte("short")}, time.Now); err == nil {
+		t.Error("short secret accepted")
+	}
+}
@@ -0,0 +1,83 @@
+// Package citoken verifies the OpenID Connect ID tokens which CI systems,
+// such as GitLab CI (id_tokens) and GitHub Actions (ACTIONS_ID_TOKEN), issue
+// to the jobs of a pipeline.
+//
+// Signing keys are fetched from the JWKS of the issuer, found through its
+// OpenID configuration unless configured, and fetched again when a token is
+// signed by an unknown key. A token with an ID (jti) is only accepted once
+// by a Verifier. The used IDs are kept in memory, so a token without an ID,
+// or presented to another keymaster or after a restart, can be replayed
+// while it is valid; MaxTokenLifetime bounds that window.
+package citoken
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// ErrReplayed is returned by Verify for a token which was already accepted.
+var ErrReplayed = errors.New("citoken: token was already used")
+
+// Config configures a Verifier for the tokens of one issuer.
+type Config struct {
+	// Issuer is the iss of the tokens, e.g. https://gitlab.example.com or
+	// https://token.actions.githubusercontent.com.
+	Issuer string
+	// Audience must be one of the aud of the tokens.
+	Audience string
+	// JWKSURL defaults to the jwks_uri of the OpenID configuration of
+	// Issuer.
+	JWKSURL string
+	// MaxTokenLifetime rejects tokens valid (from iat to exp) for longer.
+	// It defaults to DefaultMaxTokenLifetime.
+	MaxTokenLifetime time.Duration
+	// HTTPClient defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+}
+
+// DefaultMaxTokenLifetime is the default of Config.MaxTokenLifetime. CI
+// systems issue tokens valid for 5 minutes (GitHub) to the job timeout
+// (GitLab, 1 hour by default).
+const DefaultMaxTokenLifetime = 2 * time.Hour
+
+// Claims are the claims of a verified token.
+type Claims struct {
+	Subject string
+	ID      string
+	Expiry  time.Time
+	// All holds every claim of the token.
+	All map[string]interface{}
+}
+
+// Verifier is safe for concurrent use.
+type Verifier struct {
+	config Config
+	now    func() time.Time
+	mutex  sync.Mutex
+	// Protected by mutex.
+	keys        *jose.JSONWebKeySet
+	lastFetched time.Time
+	usedIDs     map[string]time.Time // Expiry by token ID.
+}
+
+// New returns a Verifier. Keys are only fetched when the first token is
+// verified.
+func New(config Config) (*Verifier, error) {
+	return newVerifier(config, time.Now)
+}
+
+// Verify checks the signature, issuer, audience and validity of token and
+// returns its claims.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	return v.verify(token)
+}
+
+// StringClaim returns the claim called name as a string. Numbers are
+// formatted without exponent.
+func (c *Claims) StringClaim(name string) (string, bool) {
+	return c.stringClaim(name)
+}
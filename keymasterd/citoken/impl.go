@@ -0,0 +1,222 @@
+package citoken
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	httpTimeout = 10 * time.Second
+	maxFetch    = 1 << 20
+	// Tokens signed by unknown keys make the keys be fetched again, but not
+	// more often than this.
+	minRefetchInterval = time.Minute
+	leeway             = 30 * time.Second
+)
+
+var allowedAlgorithms = map[string]bool{
+	string(jose.RS256): true,
+	string(jose.RS384): true,
+	string(jose.RS512): true,
+	string(jose.PS256): true,
+	string(jose.ES256): true,
+	string(jose.ES384): true,
+	string(jose.EdDSA): true,
+}
+
+type openIDConfiguration struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+func newVerifier(config Config, now func() time.Time) (*Verifier, error) {
+	if !strings.HasPrefix(config.Issuer, "https://") {
+		return nil, fmt.Errorf("citoken: issuer must be an https URL: %q",
+			config.Issuer)
+	}
+	if config.Audience == "" {
+		return nil, errors.New("citoken: no audience")
+	}
+	if config.MaxTokenLifetime <= 0 {
+		config.MaxTokenLifetime = DefaultMaxTokenLifetime
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: httpTimeout}
+	}
+	return &Verifier{
+		config:  config,
+		now:     now,
+		usedIDs: make(map[string]time.Time),
+	}, nil
+}
+
+func (v *Verifier) fetchJSON(url string, value interface{}) error {
+	resp, err := v.config.HTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxFetch)).Decode(value)
+}
+
+func (v *Verifier) fetchKeys() (*jose.JSONWebKeySet, error) {
+	jwksURL := v.config.JWKSURL
+	if jwksURL == "" {
+		var configuration openIDConfiguration
+		err := v.fetchJSON(strings.TrimSuffix(v.config.Issuer, "/")+
+			"/.well-known/openid-configuration", &configuration)
+		if err != nil {
+			return nil, err
+		}
+		if configuration.Issuer != v.config.Issuer {
+			return nil, fmt.Errorf("OpenID configuration is for issuer %q",
+				configuration.Issuer)
+		}
+		if !strings.HasPrefix(configuration.JWKSURI, "https://") {
+			return nil, fmt.Errorf("bad jwks_uri: %q", configuration.JWKSURI)
+		}
+		jwksURL = configuration.JWKSURI
+	}
+	keys := &jose.JSONWebKeySet{}
+	if err := v.fetchJSON(jwksURL, keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// getKeys returns the keys with keyID, or all keys if keyID is empty.
+func (v *Verifier) getKeys(keyID string) ([]jose.JSONWebKey, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	find := func() []jose.JSONWebKey {
+		if v.keys == nil {
+			return nil
+		}
+		if keyID == "" {
+			return v.keys.Keys
+		}
+		return v.keys.Key(keyID)
+	}
+	if keys := find(); len(keys) > 0 {
+		return keys, nil
+	}
+	if v.keys != nil && v.now().Sub(v.lastFetched) < minRefetchInterval {
+		return nil, fmt.Errorf("citoken: unknown key %q", keyID)
+	}
+	keys, err := v.fetchKeys()
+	if err != nil {
+		return nil, fmt.Errorf("citoken: cannot fetch keys of %s: %s",
+			v.config.Issuer, err)
+	}
+	v.keys = keys
+	v.lastFetched = v.now()
+	if keys := find(); len(keys) > 0 {
+		return keys, nil
+	}
+	return nil, fmt.Errorf("citoken: unknown key %q", keyID)
+}
+
+// markUsed records the token ID until the token expires and returns
+// ErrReplayed if it was already recorded.
+func (v *Verifier) markUsed(id string, expiry time.Time) error {
+	now := v.now()
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	for usedID, usedExpiry := range v.usedIDs {
+		if now.After(usedExpiry.Add(leeway)) {
+			delete(v.usedIDs, usedID)
+		}
+	}
+	if _, ok := v.usedIDs[id]; ok {
+		return ErrReplayed
+	}
+	v.usedIDs[id] = expiry
+	return nil
+}
+
+func (v *Verifier) verify(token string) (*Claims, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("citoken: %s", err)
+	}
+	if len(parsed.Headers) != 1 {
+		return nil, errors.New("citoken: not a single signature")
+	}
+	header := parsed.Headers[0]
+	if !allowedAlgorithms[header.Algorithm] {
+		return nil, fmt.Errorf("citoken: algorithm %q not allowed",
+			header.Algorithm)
+	}
+	keys, err := v.getKeys(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	var standard jwt.Claims
+	var all map[string]interface{}
+	verified := false
+	for _, key := range keys {
+		if parsed.Claims(key.Key, &standard, &all) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("citoken: bad signature")
+	}
+	now := v.now()
+	err = standard.ValidateWithLeeway(jwt.Expected{
+		Issuer:   v.config.Issuer,
+		Audience: jwt.Audience{v.config.Audience},
+		Time:     now,
+	}, leeway)
+	if err != nil {
+		return nil, fmt.Errorf("citoken: %s", err)
+	}
+	if standard.Expiry == nil {
+		return nil, errors.New("citoken: token does not expire")
+	}
+	expiry := standard.Expiry.Time()
+	issuedAt := now
+	if standard.IssuedAt != nil {
+		issuedAt = standard.IssuedAt.Time()
+	}
+	if expiry.Sub(issuedAt) > v.config.MaxTokenLifetime {
+		return nil, fmt.Errorf("citoken: token valid for more than %s",
+			v.config.MaxTokenLifetime)
+	}
+	if standard.ID != "" {
+		if err := v.markUsed(standard.ID, expiry); err != nil {
+			return nil, err
+		}
+	}
+	return &Claims{
+		Subject: standard.Subject,
+		ID:      standard.ID,
+		Expiry:  expiry,
+		All:     all,
+	}, nil
+}
+
+func (c *Claims) stringClaim(name string) (string, bool) {
+	switch value := c.All[name].(type) {
+	case string:
+		return value, value != ""
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case json.Number:
+		return value.String(), true
+	}
+	return "", false
+}
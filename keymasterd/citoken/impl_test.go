@@ -0,0 +1,134 @@
+package citoken
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type testIssuer struct {
+	server      *httptest.Server
+	key         *ecdsa.PrivateKey
+	mutex       sync.Mutex
+	jwksFetches int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: key}
+	issuer.server = httptest.NewTLSServer(http.HandlerFunc(issuer.serveHTTP))
+	return issuer
+}
+
+func (issuer *testIssuer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		json.NewEncoder(w).Encode(openIDConfiguration{
+			Issuer:  issuer.server.URL,
+			JWKSURI: issuer.server.URL + "/jwks",
+		})
+	case "/jwks":
+		issuer.mutex.Lock()
+		issuer.jwksFetches++
+		issuer.mutex.Unlock()
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &issuer.key.PublicKey, KeyID: "1", Algorithm: "ES256"}}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (issuer *testIssuer) sign(t *testing.T, key *ecdsa.PrivateKey,
+	keyID string, claims jwt.Claims, extra map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256,
+		Key: jose.JSONWebKey{Key: key, KeyID: keyID}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).Claims(extra).
+		CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+	now := time.Now()
+	verifier, err := newVerifier(Config{
+		Issuer:     issuer.server.URL,
+		Audience:   "keymaster",
+		HTTPClient: issuer.server.Client(),
+	}, func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(id string, lifetime time.Duration) jwt.Claims {
+		return jwt.Claims{
+			Issuer:   issuer.server.URL,
+			Subject:  "project_path:group/app:ref_type:branch:ref:main",
+			Audience: jwt.Audience{"keymaster"},
+			ID:       id,
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(lifetime)),
+		}
+	}
+	token := issuer.sign(t, issuer.key, "1", claims("a", 5*time.Minute),
+		map[string]interface{}{"pipeline_id": 12345})
+	verified, err := verifier.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pipeline, ok := verified.StringClaim("pipeline_id"); !ok ||
+		pipeline != "12345" {
+		t.Errorf("pipeline_id: %q", pipeline)
+	}
+	if verified.Subject != "project_path:group/app:ref_type:branch:ref:main" {
+		t.Errorf("subject: %s", verified.Subject)
+	}
+	if _, err := verifier.Verify(token); err != ErrReplayed {
+		t.Errorf("replay not detected: %v", err)
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongAudience := claims("b", time.Minute)
+	wrongAudience.Audience = jwt.Audience{"other"}
+	expired := claims("c", time.Minute)
+	expired.Expiry = jwt.NewNumericDate(now.Add(-time.Hour))
+	for name, token := range map[string]string{
+		"bad signature":  issuer.sign(t, otherKey, "1", claims("d", time.Minute), nil),
+		"wrong audience": issuer.sign(t, issuer.key, "1", wrongAudience, nil),
+		"expired":        issuer.sign(t, issuer.key, "1", expired, nil),
+		"long lifetime":  issuer.sign(t, issuer.key, "1", claims("e", 3*time.Hour), nil),
+		"unknown key":    issuer.sign(t, issuer.key, "2", claims("f", time.Minute), nil),
+	} {
+		if _, err := verifier.Verify(token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+	// The unknown key ID did not cause another fetch within a minute.
+	if issuer.jwksFetches != 1 {
+		t.Errorf("keys fetched %d times", issuer.jwksFetches)
+	}
+	if _, err := New(Config{Issuer: "http://gitlab.example.com",
+		Audience: "keymaster"}); err == nil {
+		t.Error("http issuer accepted")
+	}
+}
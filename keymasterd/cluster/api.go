@@ -0,0 +1,114 @@
+// Package cluster shares state between the instances of an active-active
+// deployment through the profile storage backend they all use. Each
+// instance periodically publishes its own state, such as its counts of
+// failed logins, and reads those of the others; records shared by all the
+// instances, such as revocations, can be written and read at any time.
+//
+// Backends have no atomic updates, so an instance only ever writes its own
+// state and records keyed so that instances do not overwrite each other.
+// The records are signed records of profilestorage with types from 1000 on,
+// which keymaster does not otherwise use.
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/profilestorage"
+)
+
+// MaxKinds is the number of kinds of states and of records. Kinds are
+// numbered from 0 and chosen by the caller.
+const MaxKinds = 100
+
+// MaxNodes is the maximum number of live instances.
+const MaxNodes = 256
+
+// Params configures a Cluster.
+type Params struct {
+	// InstanceID identifies the instance. It must be unique and stable
+	// over restarts, e.g. the host name.
+	InstanceID string
+	// TTL is how long the state of an instance is kept after it last
+	// synced, which should be a few sync intervals. Default: 1 minute.
+	TTL time.Duration
+}
+
+// Member is a live instance.
+type Member struct {
+	InstanceID string
+	Node       int
+	Started    time.Time
+	LastSeen   time.Time
+}
+
+// Cluster is safe for concurrent use.
+type Cluster struct {
+	backend       profilestorage.Backend
+	params        Params
+	started       time.Time
+	mutex         sync.Mutex
+	node          int    // Protected by mutex.
+	serialCounter uint32 // Protected by mutex.
+}
+
+// View is the state of the cluster read by Sync.
+type View struct {
+	Members []Member // Sorted by InstanceID, including this instance.
+	states  map[int]map[string][]byte
+	records map[int]map[string][]byte
+}
+
+// New returns a Cluster sharing state through backend.
+func New(backend profilestorage.Backend, params Params) (*Cluster, error) {
+	return newCluster(backend, params)
+}
+
+// Node returns the number of the instance, from 0 to MaxNodes-1, or -1
+// before the first Sync. Without atomic updates in the backend numbers are
+// not guaranteed to be unique: instances which sync at about the same time
+// can pick the same number, and keep it until a later Sync sees the other.
+// The instance with the lower ID then keeps the number.
+func (c *Cluster) Node() int {
+	return c.getNode()
+}
+
+// Sync publishes states, the state of this instance by kind, records this
+// instance as a member and returns the state of the cluster at now.
+func (c *Cluster) Sync(states map[int][]byte, now time.Time) (*View, error) {
+	return c.sync(states, now)
+}
+
+// Put writes the record key of kind, which expires at expires, replacing
+// any previous one.
+func (c *Cluster) Put(kind int, key string, data []byte,
+	expires time.Time) error {
+	return c.put(kind, key, data, expires)
+}
+
+// Get returns the record key of kind if it has not expired at now.
+func (c *Cluster) Get(kind int, key string, now time.Time) ([]byte, bool,
+	error) {
+	return c.get(kind, key, now)
+}
+
+// NewSSHCertSerial returns a serial for an SSH certificate issued at t: the
+// issue time in the upper 32 bits, then the node and a counter starting at
+// a random value. Instances with different nodes never return the same
+// serial; while two share a node (see Node) their serials are only unlikely
+// to collide. Before the first Sync it returns the random serial of
+// certgen.NewSSHCertSerial.
+func (c *Cluster) NewSSHCertSerial(t time.Time) (uint64, error) {
+	return c.newSSHCertSerial(t)
+}
+
+// PeerStates returns the states of kind published by the other instances,
+// by instance ID.
+func (v *View) PeerStates(kind int) map[string][]byte {
+	return v.states[kind]
+}
+
+// Records returns the records of kind which have not expired, by key.
+func (v *View) Records(kind int) map[string][]byte {
+	return v.records[kind]
+}
@@ -0,0 +1,231 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/profilestorage"
+	"github.com/Symantec/keymaster/lib/certgen"
+)
+
+const (
+	defaultTTL = time.Minute
+	// Types of the signed records of profilestorage.
+	typeMember     = 1000
+	typeStateBase  = 1100
+	typeRecordBase = 1200
+)
+
+type memberData struct {
+	Node    int
+	Started time.Time
+}
+
+func newCluster(backend profilestorage.Backend,
+	params Params) (*Cluster, error) {
+	if params.InstanceID == "" {
+		return nil, errors.New("cluster: no instance ID")
+	}
+	if params.TTL <= 0 {
+		params.TTL = defaultTTL
+	}
+	var counter [4]byte
+	if _, err := rand.Read(counter[:]); err != nil {
+		return nil, err
+	}
+	return &Cluster{
+		backend:       backend,
+		params:        params,
+		started:       time.Now().UTC(),
+		node:          -1,
+		serialCounter: binary.BigEndian.Uint32(counter[:]),
+	}, nil
+}
+
+func checkKind(kind int) error {
+	if kind < 0 || kind >= MaxKinds {
+		return fmt.Errorf("cluster: bad kind: %d", kind)
+	}
+	return nil
+}
+
+func (c *Cluster) getNode() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.node
+}
+
+// chooseNode keeps the node of the instance unless it is free or taken by
+// a member with a lower instance ID, and else picks the lowest free one.
+func (c *Cluster) chooseNode(peers []Member) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	used := make(map[int]bool)
+	keep := c.node >= 0
+	for _, peer := range peers {
+		used[peer.Node] = true
+		if peer.Node == c.node && peer.InstanceID < c.params.InstanceID {
+			keep = false
+		}
+	}
+	if keep {
+		return c.node, nil
+	}
+	for node := 0; node < MaxNodes; node++ {
+		if !used[node] {
+			c.node = node
+			return node, nil
+		}
+	}
+	return -1, fmt.Errorf("cluster: more than %d instances", MaxNodes)
+}
+
+func (c *Cluster) sync(states map[int][]byte, now time.Time) (*View, error) {
+	for kind := range states {
+		if err := checkKind(kind); err != nil {
+			return nil, err
+		}
+	}
+	signedRecords, err := c.backend.SignedRecords(now)
+	if err != nil {
+		return nil, err
+	}
+	view := &View{
+		states:  make(map[int]map[string][]byte),
+		records: make(map[int]map[string][]byte),
+	}
+	var peers []Member
+	for _, record := range signedRecords {
+		var kinds map[int]map[string][]byte
+		var kind int
+		switch {
+		case record.Type == typeMember:
+			if record.Username == c.params.InstanceID {
+				continue
+			}
+			var member memberData
+			if err := json.Unmarshal([]byte(record.Data), &member); err != nil {
+				return nil, fmt.Errorf("cluster: member %s: %s",
+					record.Username, err)
+			}
+			peers = append(peers, Member{
+				InstanceID: record.Username,
+				Node:       member.Node,
+				Started:    member.Started,
+				LastSeen:   time.Unix(record.UpdateEpoch, 0),
+			})
+			continue
+		case record.Type >= typeStateBase &&
+			record.Type < typeStateBase+MaxKinds:
+			if record.Username == c.params.InstanceID {
+				continue
+			}
+			kinds, kind = view.states, record.Type-typeStateBase
+		case record.Type >= typeRecordBase &&
+			record.Type < typeRecordBase+MaxKinds:
+			kinds, kind = view.records, record.Type-typeRecordBase
+		default:
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(record.Data)
+		if err != nil {
+			return nil, fmt.Errorf("cluster: record %s of type %d: %s",
+				record.Username, record.Type, err)
+		}
+		if kinds[kind] == nil {
+			kinds[kind] = make(map[string][]byte)
+		}
+		kinds[kind][record.Username] = data
+	}
+	node, err := c.chooseNode(peers)
+	if err != nil {
+		return nil, err
+	}
+	expires := now.Add(c.params.TTL).Unix()
+	memberJSON, err := json.Marshal(memberData{Node: node, Started: c.started})
+	if err != nil {
+		return nil, err
+	}
+	err = c.backend.SaveSigned(profilestorage.SignedRecord{
+		Username:        c.params.InstanceID,
+		Type:            typeMember,
+		Data:            string(memberJSON),
+		ExpirationEpoch: expires,
+		UpdateEpoch:     now.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for kind, data := range states {
+		err := c.backend.SaveSigned(profilestorage.SignedRecord{
+			Username:        c.params.InstanceID,
+			Type:            typeStateBase + kind,
+			Data:            base64.StdEncoding.EncodeToString(data),
+			ExpirationEpoch: expires,
+			UpdateEpoch:     now.Unix(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	view.Members = append(peers, Member{
+		InstanceID: c.params.InstanceID,
+		Node:       node,
+		Started:    c.started,
+		LastSeen:   now,
+	})
+	sort.Slice(view.Members, func(i, j int) bool {
+		return view.Members[i].InstanceID < view.Members[j].InstanceID
+	})
+	return view, nil
+}
+
+func (c *Cluster) put(kind int, key string, data []byte,
+	expires time.Time) error {
+	if err := checkKind(kind); err != nil {
+		return err
+	}
+	return c.backend.SaveSigned(profilestorage.SignedRecord{
+		Username:        key,
+		Type:            typeRecordBase + kind,
+		Data:            base64.StdEncoding.EncodeToString(data),
+		ExpirationEpoch: expires.Unix(),
+		UpdateEpoch:     time.Now().Unix(),
+	})
+}
+
+func (c *Cluster) get(kind int, key string, now time.Time) ([]byte, bool,
+	error) {
+	if err := checkKind(kind); err != nil {
+		return nil, false, err
+	}
+	encoded, ok, err := c.backend.LoadSigned(key, typeRecordBase+kind, now)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false, fmt.Errorf("cluster: record %s of kind %d: %s",
+			key, kind, err)
+	}
+	return data, true, nil
+}
+
+func (c *Cluster) newSSHCertSerial(t time.Time) (uint64, error) {
+	c.mutex.Lock()
+	node := c.node
+	c.serialCounter++
+	counter := c.serialCounter
+	c.mutex.Unlock()
+	if node < 0 {
+		return certgen.NewSSHCertSerial(t)
+	}
+	return uint64(t.Unix())<<32 | uint64(node)<<24 |
+		uint64(counter&0xFFFFFF), nil
+}
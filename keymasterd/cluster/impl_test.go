@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/profilestorage"
+)
+
+func TestCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	backend, err := profilestorage.OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(backend, Params{}); err == nil {
+		t.Fatal("no instance ID accepted")
+	}
+	a, err := New(backend, Params{InstanceID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(backend, Params{InstanceID: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if a.Node() != -1 {
+		t.Fatalf("node before sync: %d", a.Node())
+	}
+	// The first instance to sync gets node 0.
+	if _, err := b.Sync(map[int][]byte{0: []byte("b0")}, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Sync(map[int][]byte{0: []byte("a0")}, now); err != nil {
+		t.Fatal(err)
+	}
+	if a.Node() != 1 || b.Node() != 0 {
+		t.Fatalf("nodes: %d %d", a.Node(), b.Node())
+	}
+	// Conflicts, as when both start together, are resolved in favour of the
+	// lower instance ID.
+	a.node = 0
+	if _, err := a.Sync(map[int][]byte{0: []byte("a0")}, now); err != nil {
+		t.Fatal(err)
+	}
+	view, err := b.Sync(nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Node() != 0 || b.Node() != 1 {
+		t.Fatalf("nodes after conflict: %d %d", a.Node(), b.Node())
+	}
+	if len(view.Members) != 2 || view.Members[0].InstanceID != "a" {
+		t.Fatalf("members: %+v", view.Members)
+	}
+	states := view.PeerStates(0)
+	if len(states) != 1 || string(states["a"]) != "a0" {
+		t.Errorf("peer states: %q", states)
+	}
+	if _, err := a.Sync(map[int][]byte{MaxKinds: nil}, now); err == nil {
+		t.Error("bad kind accepted")
+	}
+
+	if err := a.Put(1, "key", []byte{0, 1}, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Put(1, "expired", []byte("x"), now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	data, ok, err := b.Get(1, "key", now)
+	if err != nil || !ok || len(data) != 2 || data[1] != 1 {
+		t.Errorf("get: %v %v %v", data, ok, err)
+	}
+	if _, ok, err := b.Get(1, "expired", now); err != nil || ok {
+		t.Errorf("expired record: %v %v", ok, err)
+	}
+	view, err = b.Sync(nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := view.Records(1); len(records) != 1 || records["key"] == nil {
+		t.Errorf("records: %q", records)
+	}
+
+	// The serials of the two instances differ in the node bits.
+	serialA, err := a.NewSSHCertSerial(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialB, err := b.NewSSHCertSerial(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serialA>>32 != uint64(now.Unix()) || serialA>>24&0xFF != 0 ||
+		serialB>>24&0xFF != 1 {
+		t.Errorf("serials: %x %x", serialA, serialB)
+	}
+	// Members which stop syncing expire.
+	view, err = b.Sync(nil, now.Add(2*defaultTTL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(view.Members) != 1 || view.PeerStates(0) != nil {
+		t.Errorf("expired members: %+v %q", view.Members, view.PeerStates(0))
+	}
+}
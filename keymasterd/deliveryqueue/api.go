@@ -0,0 +1,98 @@
+// Package deliveryqueue implements a durable queue for delivering
+// notifications to external receivers. Messages are persisted to disk until
+// they are delivered and failed deliveries are retried with exponential
+// backoff. Messages which still cannot be delivered after the maximum number
+// of attempts are kept as dead letters until they are retried or removed.
+package deliveryqueue
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+// Message is a single queued delivery.
+type Message struct {
+	ID          string
+	Destination string
+	Payload     []byte
+	Created     time.Time
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string `json:",omitempty"`
+}
+
+// Sender delivers a payload to a destination. A non-nil error means the
+// delivery should be retried later.
+type Sender interface {
+	Send(destination string, payload []byte) error
+}
+
+// ErrQueueFull is returned by Enqueue when MaxMessages are pending.
+var ErrQueueFull = errors.New("delivery queue full")
+
+// Params configures retries. Zero values select the defaults.
+type Params struct {
+	MaxAttempts    int           // Default: 12.
+	InitialBackoff time.Duration // Default: 30 seconds.
+	MaxBackoff     time.Duration // Default: 1 hour.
+	// Pending messages and dead letters together. Default: 10000.
+	MaxMessages int
+}
+
+// Queue is a persistent delivery queue. Methods are safe for concurrent use.
+type Queue struct {
+	directory string
+	sender    Sender
+	params    Params
+	logger    log.DebugLogger
+	wakeup    chan struct{}
+	mutex     sync.Mutex
+	// Protected by lock.
+	pending     map[string]*Message
+	deadLetters map[string]*Message
+}
+
+// New opens the queue stored in directory, creating it if needed, and starts
+// delivering any pending messages in the background using sender.
+func New(directory string, sender Sender, params Params,
+	logger log.DebugLogger) (*Queue, error) {
+	q, err := newQueue(directory, sender, params, logger)
+	if err != nil {
+		return nil, err
+	}
+	go q.loop()
+	return q, nil
+}
+
+// Enqueue durably stores a message for delivery to destination. The message
+// is on disk when Enqueue returns. If the queue holds MaxMessages the oldest
+// dead letter is discarded to make room, and if there is none Enqueue fails
+// with ErrQueueFull.
+func (q *Queue) Enqueue(destination string, payload []byte) error {
+	return q.enqueue(destination, payload)
+}
+
+// PendingCount returns the number of messages waiting to be delivered.
+func (q *Queue) PendingCount() int {
+	return q.pendingCount()
+}
+
+// ListDeadLetters returns the messages which could not be delivered, oldest
+// first.
+func (q *Queue) ListDeadLetters() []Message {
+	return q.listDeadLetters()
+}
+
+// RetryDeadLetter moves the dead letter with the given ID back into the
+// queue with its attempt count reset.
+func (q *Queue) RetryDeadLetter(id string) error {
+	return q.retryDeadLetter(id)
+}
+
+// DeleteDeadLetter discards the dead letter with the given ID.
+func (q *Queue) DeleteDeadLetter(id string) error {
+	return q.deleteDeadLetter(id)
+}
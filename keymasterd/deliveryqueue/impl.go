@@ -0,0 +1,314 @@
+package deliveryqueue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+const (
+	defaultMaxAttempts    = 12
+	defaultInitialBackoff = 30 * time.Second
+	defaultMaxBackoff     = time.Hour
+	defaultMaxMessages    = 10000
+
+	pendingSubdir = "pending"
+	deadSubdir    = "dead"
+)
+
+func newQueue(directory string, sender Sender, params Params,
+	logger log.DebugLogger) (*Queue, error) {
+	if params.MaxAttempts < 1 {
+		params.MaxAttempts = defaultMaxAttempts
+	}
+	if params.InitialBackoff <= 0 {
+		params.InitialBackoff = defaultInitialBackoff
+	}
+	if params.MaxBackoff <= 0 {
+		params.MaxBackoff = defaultMaxBackoff
+	}
+	if params.MaxMessages < 1 {
+		params.MaxMessages = defaultMaxMessages
+	}
+	q := &Queue{
+		directory: directory,
+		sender:    sender,
+		params:    params,
+		logger:    logger,
+		wakeup:    make(chan struct{}, 1),
+	}
+	var err error
+	q.pending, err = loadMessages(filepath.Join(directory, pendingSubdir))
+	if err != nil {
+		return nil, err
+	}
+	q.deadLetters, err = loadMessages(filepath.Join(directory, deadSubdir))
+	if err != nil {
+		return nil, err
+	}
+	if len(q.pending) > 0 {
+		logger.Printf("deliveryqueue: %d pending messages loaded",
+			len(q.pending))
+	}
+	return q, nil
+}
+
+func loadMessages(directory string) (map[string]*Message, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	messages := make(map[string]*Message)
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(directory, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var message Message
+		if err := json.Unmarshal(data, &message); err != nil {
+			return nil, fmt.Errorf("cannot decode %s: %s", file.Name(), err)
+		}
+		messages[message.ID] = &message
+	}
+	return messages, nil
+}
+
+func (q *Queue) messagePath(subdir, id string) string {
+	return filepath.Join(q.directory, subdir, id+".json")
+}
+
+// writeMessage writes the message to a temporary file and renames it so that
+// a crash never leaves a partial message behind.
+func (q *Queue) writeMessage(subdir string, message *Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	filename := q.messagePath(subdir, message.ID)
+	tmpFilename := filename + "~"
+	if err := ioutil.WriteFile(tmpFilename, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+func newMessageID(now time.Time) (string, error) {
+	randBytes := make([]byte, 8)
+	if _, err := rand.Read(randBytes); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%s", now.UnixNano(), hex.EncodeToString(randBytes)),
+		nil
+}
+
+func (q *Queue) enqueue(destination string, payload []byte) error {
+	now := time.Now()
+	id, err := newMessageID(now)
+	if err != nil {
+		return err
+	}
+	message := &Message{
+		ID:          id,
+		Destination: destination,
+		Payload:     payload,
+		Created:     now,
+		NextAttempt: now,
+	}
+	q.mutex.Lock()
+	if err := q.makeRoom(); err != nil {
+		q.mutex.Unlock()
+		return err
+	}
+	if err := q.writeMessage(pendingSubdir, message); err != nil {
+		q.mutex.Unlock()
+		return err
+	}
+	q.pending[id] = message
+	q.mutex.Unlock()
+	q.wake()
+	return nil
+}
+
+// makeRoom discards the oldest dead letter if the queue is full. It must be
+// called with the mutex held.
+func (q *Queue) makeRoom() error {
+	if len(q.pending)+len(q.deadLetters) < q.params.MaxMessages {
+		return nil
+	}
+	var oldest *Message
+	for _, message := range q.deadLetters {
+		if oldest == nil || message.Created.Before(oldest.Created) {
+			oldest = message
+		}
+	}
+	if oldest == nil {
+		return ErrQueueFull
+	}
+	q.logger.Printf("deliveryqueue: queue full, discarding dead letter %s to %s",
+		oldest.ID, oldest.Destination)
+	if err := os.Remove(q.messagePath(deadSubdir, oldest.ID)); err != nil &&
+		!os.IsNotExist(err) {
+		return err
+	}
+	delete(q.deadLetters, oldest.ID)
+	return nil
+}
+
+func (q *Queue) wake() {
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) backoff(attempts int) time.Duration {
+	backoff := q.params.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= q.params.MaxBackoff {
+			return q.params.MaxBackoff
+		}
+	}
+	return backoff
+}
+
+func (q *Queue) loop() {
+	for {
+		nextWakeup := q.deliverDue(time.Now())
+		var timer <-chan time.Time
+		if !nextWakeup.IsZero() {
+			timer = time.After(time.Until(nextWakeup))
+		}
+		select {
+		case <-q.wakeup:
+		case <-timer:
+		}
+	}
+}
+
+// deliverDue attempts delivery of all messages due at now and returns when
+// the next pending message is due, or the zero time if none is pending.
+func (q *Queue) deliverDue(now time.Time) time.Time {
+	q.mutex.Lock()
+	var due []*Message
+	for _, message := range q.pending {
+		if !message.NextAttempt.After(now) {
+			due = append(due, message)
+		}
+	}
+	q.mutex.Unlock()
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Created.Before(due[j].Created)
+	})
+	for _, message := range due {
+		q.attemptDelivery(message, now)
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var nextWakeup time.Time
+	for _, message := range q.pending {
+		if nextWakeup.IsZero() || message.NextAttempt.Before(nextWakeup) {
+			nextWakeup = message.NextAttempt
+		}
+	}
+	return nextWakeup
+}
+
+func (q *Queue) attemptDelivery(message *Message, now time.Time) {
+	err := q.sender.Send(message.Destination, message.Payload)
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if err == nil {
+		delete(q.pending, message.ID)
+		if err := os.Remove(q.messagePath(pendingSubdir, message.ID)); err != nil {
+			q.logger.Println(err)
+		}
+		return
+	}
+	message.Attempts++
+	message.LastError = err.Error()
+	if message.Attempts >= q.params.MaxAttempts {
+		q.logger.Printf("deliveryqueue: giving up on %s to %s after %d attempts: %s",
+			message.ID, message.Destination, message.Attempts, err)
+		delete(q.pending, message.ID)
+		if err := q.writeMessage(deadSubdir, message); err != nil {
+			q.logger.Println(err)
+		}
+		q.deadLetters[message.ID] = message
+		if err := os.Remove(q.messagePath(pendingSubdir, message.ID)); err != nil {
+			q.logger.Println(err)
+		}
+		return
+	}
+	message.NextAttempt = now.Add(q.backoff(message.Attempts))
+	q.logger.Debugf(1, "deliveryqueue: delivery of %s to %s failed, retry at %s: %s",
+		message.ID, message.Destination, message.NextAttempt, err)
+	if err := q.writeMessage(pendingSubdir, message); err != nil {
+		q.logger.Println(err)
+	}
+}
+
+func (q *Queue) pendingCount() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.pending)
+}
+
+func (q *Queue) listDeadLetters() []Message {
+	q.mutex.Lock()
+	messages := make([]Message, 0, len(q.deadLetters))
+	for _, message := range q.deadLetters {
+		messages = append(messages, *message)
+	}
+	q.mutex.Unlock()
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Created.Before(messages[j].Created)
+	})
+	return messages
+}
+
+func (q *Queue) retryDeadLetter(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	message, ok := q.deadLetters[id]
+	if !ok {
+		return fmt.Errorf("unknown dead letter: %s", id)
+	}
+	message.Attempts = 0
+	message.NextAttempt = time.Now()
+	if err := q.writeMessage(pendingSubdir, message); err != nil {
+		return err
+	}
+	delete(q.deadLetters, id)
+	q.pending[id] = message
+	if err := os.Remove(q.messagePath(deadSubdir, id)); err != nil {
+		q.logger.Println(err)
+	}
+	q.wake()
+	return nil
+}
+
+func (q *Queue) deleteDeadLetter(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if _, ok := q.deadLetters[id]; !ok {
+		return fmt.Errorf("unknown dead letter: %s", id)
+	}
+	delete(q.deadLetters, id)
+	return os.Remove(q.messagePath(deadSubdir, id))
+}
@@ -0,0 +1,147 @@
+package deliveryqueue
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+)
+
+type testSender struct {
+	failures  int
+	delivered []string
+}
+
+func (s *testSender) Send(destination string, payload []byte) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("receiver down")
+	}
+	s.delivered = append(s.delivered, string(payload))
+	return nil
+}
+
+func TestQueueRetriesWithBackoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deliveryqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sender := &testSender{failures: 2}
+	params := Params{MaxAttempts: 5, InitialBackoff: time.Minute}
+	q, err := newQueue(dir, sender, params, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue("dest", []byte("event1")); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	next := q.deliverDue(now)
+	if !next.Equal(now.Add(time.Minute)) {
+		t.Fatalf("unexpected first retry time: %s", next)
+	}
+	next = q.deliverDue(next)
+	if !next.Equal(now.Add(3 * time.Minute)) {
+		t.Fatalf("backoff should have doubled: %s", next)
+	}
+
+	// Pending messages survive a restart.
+	q, err = newQueue(dir, sender, params, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.PendingCount() != 1 {
+		t.Fatalf("expected 1 pending message, have %d", q.PendingCount())
+	}
+	if next := q.deliverDue(next); !next.IsZero() {
+		t.Fatalf("nothing should be pending: %s", next)
+	}
+	if len(sender.delivered) != 1 || sender.delivered[0] != "event1" {
+		t.Fatalf("unexpected deliveries: %v", sender.delivered)
+	}
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deliveryqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sender := &testSender{failures: 2}
+	params := Params{MaxAttempts: 2, InitialBackoff: time.Minute}
+	q, err := newQueue(dir, sender, params, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue("dest", []byte("event1")); err != nil {
+		t.Fatal(err)
+	}
+	next := q.deliverDue(time.Now())
+	if next = q.deliverDue(next); !next.IsZero() {
+		t.Fatal("message should have been dead lettered")
+	}
+	q, err = newQueue(dir, sender, params, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetters := q.ListDeadLetters()
+	if len(deadLetters) != 1 || deadLetters[0].Attempts != 2 ||
+		deadLetters[0].LastError == "" {
+		t.Fatalf("unexpected dead letters: %+v", deadLetters)
+	}
+	if err := q.RetryDeadLetter(deadLetters[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.ListDeadLetters()) != 0 {
+		t.Fatal("dead letter should have been requeued")
+	}
+	q.deliverDue(time.Now())
+	if len(sender.delivered) != 1 {
+		t.Fatalf("unexpected deliveries: %v", sender.delivered)
+	}
+	if err := q.DeleteDeadLetter("missing"); err == nil {
+		t.Fatal("deleting an unknown dead letter should fail")
+	}
+}
+
+func TestQueueMaxMessages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deliveryqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sender := &testSender{failures: 1}
+	params := Params{MaxAttempts: 1, MaxMessages: 2}
+	q, err := newQueue(dir, sender, params, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue("dest", []byte("dead")); err != nil {
+		t.Fatal(err)
+	}
+	q.deliverDue(time.Now())
+	sender.failures = 1000
+	if err := q.Enqueue("dest", []byte("event1")); err != nil {
+		t.Fatal(err)
+	}
+	// The dead letter makes room.
+	if err := q.Enqueue("dest", []byte("event2")); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.ListDeadLetters()) != 0 {
+		t.Fatal("dead letter not discarded")
+	}
+	if err := q.Enqueue("dest", []byte("event3")); err != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	if q.PendingCount() != 2 {
+		t.Fatalf("expected 2 pending messages, have %d", q.PendingCount())
+	}
+	if files, _ := ioutil.ReadDir(dir + "/" + deadSubdir); len(files) != 0 {
+		t.Fatal("dead letter file not removed")
+	}
+}
@@ -0,0 +1,143 @@
+// Package dnspublish publishes the keymaster instances which are ready in
+// DNS, so that clients using DNS discovery avoid instances failing
+// readiness.
+//
+// Every instance runs a Publisher which announces its own record and
+// updates it as its readiness changes. Records are kept in Amazon Route 53
+// (weighted record sets, one per instance) or in etcd for the CoreDNS etcd
+// plugin (SkyDNS messages, attached to a lease so that the records of dead
+// instances expire).
+package dnspublish
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+// Instance describes the record of one keymaster instance.
+type Instance struct {
+	// ID distinguishes the records of the instances, e.g. the host name.
+	ID string
+	// Name is the DNS name being published, e.g. keymaster.example.com or
+	// _keymaster._tcp.example.com.
+	Name string
+	// Target is the IP address or host name of the instance.
+	Target string
+	Port   uint16
+	// Weight is the relative share of clients sent to the instance while it
+	// is ready.
+	Weight uint16
+	TTL    time.Duration
+}
+
+// Backend updates the records of instances in a DNS provider.
+type Backend interface {
+	// Publish creates or updates the record of instance. Instances which are
+	// not ready are given a weight of zero or are removed, depending on the
+	// backend. It is called periodically, also when nothing changed.
+	Publish(instance Instance, ready bool) error
+	// Withdraw removes the record of instance.
+	Withdraw(instance Instance) error
+}
+
+// TXTBackend is implemented by the backends which can also publish TXT
+// records. TXT records are shared by all instances, which publish the same
+// values, so they are not withdrawn when an instance stops.
+type TXTBackend interface {
+	// PublishTXT replaces the TXT records of name with values.
+	PublishTXT(name string, values []string, ttl time.Duration) error
+}
+
+// Route53Config configures a Route 53 backend. If AccessKeyID is empty the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment
+// variables are used.
+type Route53Config struct {
+	HostedZoneID    string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Endpoint defaults to https://route53.amazonaws.com.
+	Endpoint   string
+	HTTPClient *http.Client
+}
+
+// NewRoute53 returns a Backend keeping one weighted record set per instance
+// in a Route 53 hosted zone. Target IP addresses are published as A or AAAA
+// records and host names as SRV records. Instances which are not ready keep
+// their record with a weight of zero, which Route 53 only answers with if
+// no instance is ready.
+func NewRoute53(config Route53Config) (Backend, error) {
+	return newRoute53(config)
+}
+
+// EtcdConfig configures a backend for the CoreDNS etcd plugin.
+type EtcdConfig struct {
+	// Endpoints are the URLs of the etcd v3 JSON gateway, tried in order.
+	Endpoints []string
+	// Path is the path option of the CoreDNS etcd plugin. It defaults to
+	// /skydns.
+	Path string
+	// Records are attached to a lease of LeaseTTL, so that they disappear
+	// if LeaseTTL passes without a Publish. It defaults to 90 seconds.
+	LeaseTTL  time.Duration
+	TLSConfig *tls.Config
+}
+
+// NewEtcd returns a Backend writing SkyDNS messages for the CoreDNS etcd
+// plugin. Instances which are not ready are removed.
+func NewEtcd(config EtcdConfig) (Backend, error) {
+	return newEtcd(config)
+}
+
+// Publisher keeps the record of an instance up to date.
+type Publisher struct {
+	backend    Backend
+	instance   Instance
+	interval   time.Duration
+	isReady    func() bool
+	logger     log.DebugLogger
+	started    bool
+	stopOnce   sync.Once
+	stop       chan struct{}
+	done       chan struct{}
+	txtRecords []txtRecords
+	mutex      sync.Mutex
+	lastError  error // Protected by mutex.
+}
+
+// New returns a Publisher which publishes instance with backend every
+// interval, according to isReady.
+func New(backend Backend, instance Instance, interval time.Duration,
+	isReady func() bool, logger log.DebugLogger) *Publisher {
+	return newPublisher(backend, instance, interval, isReady, logger)
+}
+
+// AddTXT makes p also publish the TXT records of name, with the values
+// returned by values at every update. If values returns an error, e.g.
+// because the values are not known yet, the records are left alone. AddTXT
+// returns an error if the backend cannot publish TXT records. It must be
+// called before Start.
+func (p *Publisher) AddTXT(name string, ttl time.Duration,
+	values func() ([]string, error)) error {
+	return p.addTXT(name, ttl, values)
+}
+
+// Start publishes the record now and then starts updating it in the
+// background.
+func (p *Publisher) Start() {
+	p.start()
+}
+
+// Stop stops updating the record and withdraws it.
+func (p *Publisher) Stop() error {
+	return p.doStop()
+}
+
+// LastError returns the error of the last update, or nil if it succeeded.
+func (p *Publisher) LastError() error {
+	return p.getLastError()
+}
@@ -0,0 +1,279 @@
+package dnspublish
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	etcdDefaultPath     = "/skydns"
+	etcdDefaultLeaseTTL = 90 * time.Second
+	etcdPriority        = 10
+)
+
+type etcdBackend struct {
+	config     EtcdConfig
+	httpClient *http.Client
+	mutex      sync.Mutex
+	// Protected by mutex.
+	leaseID     string
+	txtLeaseIDs map[string]string
+}
+
+// skyDNSMessage is the record format of the CoreDNS etcd plugin.
+type skyDNSMessage struct {
+	Host     string `json:"host"`
+	Port     uint16 `json:"port,omitempty"`
+	Priority int    `json:"priority"`
+	Weight   uint16 `json:"weight"`
+	TTL      uint32 `json:"ttl,omitempty"`
+	Text     string `json:"text,omitempty"`
+}
+
+type etcdLeaseGrantRequest struct {
+	TTL int64 `json:"TTL"`
+}
+
+type etcdLeaseGrantResponse struct {
+	ID    string `json:"ID"`
+	Error string `json:"error"`
+}
+
+type etcdLeaseRevokeRequest struct {
+	ID string `json:"ID"`
+}
+
+type etcdPutRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Lease string `json:"lease,omitempty"`
+}
+
+type etcdDeleteRangeRequest struct {
+	Key string `json:"key"`
+}
+
+type etcdErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+func newEtcd(config EtcdConfig) (*etcdBackend, error) {
+	if len(config.Endpoints) < 1 {
+		return nil, errors.New("dnspublish: no etcd endpoints")
+	}
+	for index, endpoint := range config.Endpoints {
+		if !strings.HasPrefix(endpoint, "http://") &&
+			!strings.HasPrefix(endpoint, "https://") {
+			return nil, fmt.Errorf("dnspublish: bad etcd endpoint: %s",
+				endpoint)
+		}
+		config.Endpoints[index] = strings.TrimSuffix(endpoint, "/")
+	}
+	if config.Path == "" {
+		config.Path = etcdDefaultPath
+	}
+	config.Path = "/" + strings.Trim(config.Path, "/")
+	if config.LeaseTTL <= 0 {
+		config.LeaseTTL = etcdDefaultLeaseTTL
+	}
+	return &etcdBackend{
+		config:      config,
+		txtLeaseIDs: make(map[string]string),
+		httpClient: &http.Client{
+			Timeout:   httpTimeout,
+			Transport: &http.Transport{TLSClientConfig: config.TLSConfig},
+		},
+	}, nil
+}
+
+// skyDNSKey returns the key of the record of instance, which is the path
+// followed by the labels of the name in reverse order and the instance ID.
+func skyDNSKey(path string, instance Instance) string {
+	return skyDNSNameKey(path, instance.Name, instance.ID)
+}
+
+func skyDNSNameKey(path, name, id string) string {
+	labels := strings.Split(strings.Trim(name, "."), ".")
+	for left, right := 0, len(labels)-1; left < right; left, right =
+		left+1, right-1 {
+		labels[left], labels[right] = labels[right], labels[left]
+	}
+	return path + "/" + strings.Join(labels, "/") + "/" +
+		strings.Replace(id, "/", "_", -1)
+}
+
+// call POSTs request to the gateway of the first etcd endpoint which
+// answers.
+func (b *etcdBackend) call(path string, request interface{},
+	response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, endpoint := range b.config.Endpoints {
+		err := b.callEndpoint(endpoint+path, body, response)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return errors.New("dnspublish: etcd: " + strings.Join(errs, "; "))
+}
+
+func (b *etcdBackend) callEndpoint(url string, body []byte,
+	response interface{}) error {
+	resp, err := b.httpClient.Post(url, "application/json",
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errorResponse etcdErrorResponse
+		if json.Unmarshal(data, &errorResponse) == nil {
+			if errorResponse.Message != "" {
+				return fmt.Errorf("%s: %s", url, errorResponse.Message)
+			}
+			if errorResponse.Error != "" {
+				return fmt.Errorf("%s: %s", url, errorResponse.Error)
+			}
+		}
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(data, response)
+}
+
+func (b *etcdBackend) Publish(instance Instance, ready bool) error {
+	if !ready {
+		return b.Withdraw(instance)
+	}
+	value, err := json.Marshal(skyDNSMessage{
+		Host:     strings.TrimSuffix(instance.Target, "."),
+		Port:     instance.Port,
+		Priority: etcdPriority,
+		Weight:   instance.Weight,
+		TTL:      uint32(instance.TTL / time.Second),
+	})
+	if err != nil {
+		return err
+	}
+	// Every update moves the record to a new lease, so that it expires if
+	// this instance stops updating it.
+	leaseID, err := b.grantLease()
+	if err != nil {
+		return err
+	}
+	err = b.call("/v3/kv/put", etcdPutRequest{
+		Key:   base64.StdEncoding.EncodeToString([]byte(skyDNSKey(b.config.Path, instance))),
+		Value: base64.StdEncoding.EncodeToString(value),
+		Lease: leaseID,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	oldLeaseID := b.leaseID
+	b.leaseID = leaseID
+	b.mutex.Unlock()
+	if oldLeaseID != "" {
+		// The old lease would expire anyway.
+		b.call("/v3/lease/revoke", etcdLeaseRevokeRequest{ID: oldLeaseID},
+			nil)
+	}
+	return nil
+}
+
+// grantLease returns a new lease of LeaseTTL.
+func (b *etcdBackend) grantLease() (string, error) {
+	var lease etcdLeaseGrantResponse
+	err := b.call("/v3/lease/grant",
+		etcdLeaseGrantRequest{TTL: int64(b.config.LeaseTTL / time.Second)},
+		&lease)
+	if err != nil {
+		return "", err
+	}
+	if lease.ID == "" {
+		return "", errors.New("dnspublish: etcd: no lease granted: " +
+			lease.Error)
+	}
+	return lease.ID, nil
+}
+
+// PublishTXT writes one record per value, keyed by a hash of the value so
+// that all instances write the same keys. The records are attached to a
+// lease like the instance records, so that values which are no longer
+// published expire.
+func (b *etcdBackend) PublishTXT(name string, values []string,
+	ttl time.Duration) error {
+	leaseID := ""
+	if len(values) > 0 {
+		var err error
+		if leaseID, err = b.grantLease(); err != nil {
+			return err
+		}
+	}
+	for _, value := range values {
+		message, err := json.Marshal(skyDNSMessage{
+			Priority: etcdPriority,
+			TTL:      uint32(ttl / time.Second),
+			Text:     value,
+		})
+		if err != nil {
+			return err
+		}
+		key := skyDNSNameKey(b.config.Path, name,
+			"txt-"+sha256Hex([]byte(value))[:16])
+		err = b.call("/v3/kv/put", etcdPutRequest{
+			Key:   base64.StdEncoding.EncodeToString([]byte(key)),
+			Value: base64.StdEncoding.EncodeToString(message),
+			Lease: leaseID,
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	b.mutex.Lock()
+	oldLeaseID := b.txtLeaseIDs[name]
+	b.txtLeaseIDs[name] = leaseID
+	b.mutex.Unlock()
+	if oldLeaseID != "" {
+		b.call("/v3/lease/revoke", etcdLeaseRevokeRequest{ID: oldLeaseID},
+			nil)
+	}
+	return nil
+}
+
+func (b *etcdBackend) Withdraw(instance Instance) error {
+	err := b.call("/v3/kv/deleterange", etcdDeleteRangeRequest{
+		Key: base64.StdEncoding.EncodeToString([]byte(skyDNSKey(b.config.Path, instance))),
+	}, nil)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	leaseID := b.leaseID
+	b.leaseID = ""
+	b.mutex.Unlock()
+	if leaseID != "" {
+		b.call("/v3/lease/revoke", etcdLeaseRevokeRequest{ID: leaseID}, nil)
+	}
+	return nil
+}
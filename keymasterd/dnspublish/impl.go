@@ -0,0 +1,110 @@
+package dnspublish
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+type txtRecords struct {
+	name   string
+	ttl    time.Duration
+	values func() ([]string, error)
+}
+
+func newPublisher(backend Backend, instance Instance, interval time.Duration,
+	isReady func() bool, logger log.DebugLogger) *Publisher {
+	return &Publisher{
+		backend:  backend,
+		instance: instance,
+		interval: interval,
+		isReady:  isReady,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (p *Publisher) addTXT(name string, ttl time.Duration,
+	values func() ([]string, error)) error {
+	if _, ok := p.backend.(TXTBackend); !ok {
+		return errors.New("dnspublish: backend cannot publish TXT records")
+	}
+	p.txtRecords = append(p.txtRecords,
+		txtRecords{name: name, ttl: ttl, values: values})
+	return nil
+}
+
+// publishTXT publishes the TXT records and returns the first error.
+func (p *Publisher) publishTXT() error {
+	var firstError error
+	for _, records := range p.txtRecords {
+		values, err := records.values()
+		if err != nil {
+			p.logger.Debugf(1, "Not publishing %s: %s\n", records.name, err)
+			continue
+		}
+		err = p.backend.(TXTBackend).PublishTXT(records.name, values,
+			records.ttl)
+		if err != nil && firstError == nil {
+			firstError = err
+		}
+	}
+	return firstError
+}
+
+func (p *Publisher) publish() {
+	ready := p.isReady()
+	err := p.backend.Publish(p.instance, ready)
+	if txtErr := p.publishTXT(); err == nil {
+		err = txtErr
+	}
+	p.mutex.Lock()
+	previousError := p.lastError
+	p.lastError = err
+	p.mutex.Unlock()
+	if err != nil {
+		p.logger.Printf("Cannot publish %s in DNS: %s\n", p.instance.Name, err)
+	} else if previousError != nil {
+		p.logger.Printf("Published %s in DNS again\n", p.instance.Name)
+	}
+	p.logger.Debugf(1, "Published %s for %s, ready: %v\n", p.instance.Name,
+		p.instance.ID, ready)
+}
+
+func (p *Publisher) start() {
+	p.started = true
+	p.publish()
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.publish()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (p *Publisher) doStop() error {
+	var err error
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		if p.started {
+			<-p.done
+		}
+		err = p.backend.Withdraw(p.instance)
+	})
+	return err
+}
+
+func (p *Publisher) getLastError() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.lastError
+}
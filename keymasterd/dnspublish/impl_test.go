@@ -0,0 +1,334 @@
+package dnspublish
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+)
+
+var testInstance = Instance{
+	ID:     "keymaster1",
+	Name:   "keymaster.example.com",
+	Target: "10.0.0.1",
+	Port:   443,
+	Weight: 10,
+	TTL:    30 * time.Second,
+}
+
+type testBackend struct {
+	mutex     sync.Mutex
+	published []bool
+	withdrawn bool
+	err       error
+}
+
+func (b *testBackend) Publish(instance Instance, ready bool) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.published = append(b.published, ready)
+	return b.err
+}
+
+func (b *testBackend) Withdraw(instance Instance) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.withdrawn = true
+	return nil
+}
+
+func TestPublisher(t *testing.T) {
+	backend := &testBackend{err: errors.New("unreachable")}
+	ready := false
+	publisher := New(backend, testInstance, time.Hour,
+		func() bool { return ready }, testlogger.New(t))
+	publisher.Start()
+	if publisher.LastError() == nil {
+		t.Error("no error reported")
+	}
+	backend.err = nil
+	ready = true
+	publisher.publish()
+	if err := publisher.LastError(); err != nil {
+		t.Error(err)
+	}
+	if err := publisher.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.published) != 2 || backend.published[0] ||
+		!backend.published[1] {
+		t.Errorf("published %v", backend.published)
+	}
+	if !backend.withdrawn {
+		t.Error("record not withdrawn")
+	}
+	if err := publisher.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type route53Server struct {
+	mutex   sync.Mutex
+	changes []route53Change
+}
+
+func (s *route53Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/2013-04-01/hostedzone/Z123/rrset/" ||
+		!strings.HasPrefix(r.Header.Get("Authorization"),
+			"AWS4-HMAC-SHA256 Credential=AKID/") {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<ErrorResponse><Error><Code>AccessDenied</Code>` +
+			`<Message>denied</Message></Error></ErrorResponse>`))
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	var request route53ChangeRequest
+	if err := xml.Unmarshal(body, &request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mutex.Lock()
+	s.changes = append(s.changes, request.Changes...)
+	s.mutex.Unlock()
+}
+
+func TestRoute53(t *testing.T) {
+	server := &route53Server{}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	backend, err := newRoute53(Route53Config{
+		HostedZoneID:    "/hostedzone/Z123",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		Endpoint:        httpServer.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ready := range []bool{true, true, false} {
+		if err := backend.Publish(testInstance, ready); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srvInstance := testInstance
+	srvInstance.Name = "_keymaster._tcp.example.com"
+	srvInstance.Target = "keymaster1.example.com"
+	if err := backend.Publish(srvInstance, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Withdraw(srvInstance); err != nil {
+		t.Fatal(err)
+	}
+	var summary []string
+	for _, change := range server.changes {
+		recordSet := change.ResourceRecordSet
+		summary = append(summary, change.Action+" "+recordSet.Name+" "+
+			recordSet.Type+" "+recordSet.SetIdentifier+" "+
+			recordSet.ResourceRecords[0].Value+" "+
+			strconv.Itoa(int(recordSet.Weight)))
+	}
+	expected := []string{
+		"UPSERT keymaster.example.com. A keymaster1 10.0.0.1 10",
+		// The unchanged record is not sent again.
+		"UPSERT keymaster.example.com. A keymaster1 10.0.0.1 0",
+		"DELETE keymaster.example.com. A keymaster1 10.0.0.1 0",
+		"UPSERT _keymaster._tcp.example.com. SRV keymaster1 " +
+			"0 10 443 keymaster1.example.com. 10",
+		"DELETE _keymaster._tcp.example.com. SRV keymaster1 " +
+			"0 10 443 keymaster1.example.com. 10",
+	}
+	if strings.Join(summary, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("changes:\n%s", strings.Join(summary, "\n"))
+	}
+	backend.config.AccessKeyID = "other"
+	if err := backend.Publish(testInstance, true); err == nil ||
+		!strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+type etcdServer struct {
+	mutex     sync.Mutex
+	nextLease int
+	leases    map[string]bool
+	keys      map[string]string
+	keyLeases map[string]string
+}
+
+func (s *etcdServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var request map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	decode := func(field string) string {
+		value, _ := base64.StdEncoding.DecodeString(request[field].(string))
+		return string(value)
+	}
+	switch r.URL.Path {
+	case "/v3/lease/grant":
+		s.nextLease++
+		id := strconv.Itoa(s.nextLease)
+		s.leases[id] = true
+		json.NewEncoder(w).Encode(map[string]string{"ID": id, "TTL": "90"})
+	case "/v3/lease/revoke":
+		id := request["ID"].(string)
+		delete(s.leases, id)
+		for key, lease := range s.keyLeases {
+			if lease == id {
+				delete(s.keys, key)
+				delete(s.keyLeases, key)
+			}
+		}
+		w.Write([]byte("{}"))
+	case "/v3/kv/put":
+		lease := request["lease"].(string)
+		if !s.leases[lease] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"requested lease not found"}`))
+			return
+		}
+		s.keys[decode("key")] = decode("value")
+		s.keyLeases[decode("key")] = lease
+		w.Write([]byte("{}"))
+	case "/v3/kv/deleterange":
+		delete(s.keys, decode("key"))
+		delete(s.keyLeases, decode("key"))
+		w.Write([]byte("{}"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestEtcd(t *testing.T) {
+	server := &etcdServer{
+		leases:    make(map[string]bool),
+		keys:      make(map[string]string),
+		keyLeases: make(map[string]string),
+	}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	backend, err := newEtcd(EtcdConfig{
+		// The first endpoint is down.
+		Endpoints: []string{"http://127.0.0.1:1", httpServer.URL + "/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const key = "/skydns/com/example/keymaster/keymaster1"
+	for i := 0; i < 2; i++ {
+		if err := backend.Publish(testInstance, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var message skyDNSMessage
+	if err := json.Unmarshal([]byte(server.keys[key]), &message); err != nil {
+		t.Fatal(err)
+	}
+	if message != (skyDNSMessage{Host: "10.0.0.1", Port: 443, Priority: 10,
+		Weight: 10, TTL: 30}) {
+		t.Errorf("unexpected record %+v", message)
+	}
+	if len(server.leases) != 1 || server.keyLeases[key] != "2" {
+		t.Errorf("old lease not revoked: %v %v", server.leases,
+			server.keyLeases)
+	}
+	if err := backend.Publish(testInstance, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.keys[key]; ok || len(server.leases) != 0 {
+		t.Error("record of instance which is not ready kept")
+	}
+	if _, err := newEtcd(EtcdConfig{Endpoints: []string{"etcd:2379"}}); err == nil {
+		t.Error("endpoint without scheme accepted")
+	}
+}
+
+func TestPublishTXT(t *testing.T) {
+	server := &route53Server{}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	route53, err := newRoute53(Route53Config{
+		HostedZoneID:    "Z123",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		Endpoint:        httpServer.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []string{"sshfp 4 2 00ff"}
+	publisher := New(route53, testInstance, time.Hour,
+		func() bool { return true }, testlogger.New(t))
+	err = publisher.AddTXT("_keymaster-ca.example.com", time.Minute,
+		func() ([]string, error) { return values, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher.publish()
+	publisher.publish()
+	if err := publisher.LastError(); err != nil {
+		t.Fatal(err)
+	}
+	// The instance record and the TXT record are only sent once.
+	if len(server.changes) != 2 {
+		t.Fatalf("%d changes sent", len(server.changes))
+	}
+	recordSet := server.changes[1].ResourceRecordSet
+	if recordSet.Name != "_keymaster-ca.example.com." ||
+		recordSet.Type != "TXT" || recordSet.SetIdentifier != "" ||
+		recordSet.ResourceRecords[0].Value != `"sshfp 4 2 00ff"` {
+		t.Errorf("unexpected record set: %+v", recordSet)
+	}
+
+	etcdServer := &etcdServer{
+		leases:    make(map[string]bool),
+		keys:      make(map[string]string),
+		keyLeases: make(map[string]string),
+	}
+	etcdHTTPServer := httptest.NewServer(etcdServer)
+	defer etcdHTTPServer.Close()
+	etcd, err := newEtcd(EtcdConfig{Endpoints: []string{etcdHTTPServer.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, values := range [][]string{{"a", "b"}, {"b"}} {
+		err := etcd.PublishTXT("_keymaster-ca.example.com", values,
+			time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(etcdServer.keys) != 1 {
+		t.Fatalf("unexpected keys: %v", etcdServer.keys)
+	}
+	for key, value := range etcdServer.keys {
+		var message skyDNSMessage
+		if err := json.Unmarshal([]byte(value), &message); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(key, "/skydns/com/example/_keymaster-ca/txt-") ||
+			message.Text != "b" {
+			t.Errorf("unexpected record %s: %+v", key, message)
+		}
+	}
+
+	publisher = New(&testBackend{}, testInstance, time.Hour,
+		func() bool { return true }, testlogger.New(t))
+	if err := publisher.AddTXT("_keymaster-ca.example.com", time.Minute,
+		nil); err == nil {
+		t.Error("TXT records accepted by backend without TXT support")
+	}
+}
@@ -0,0 +1,311 @@
+package dnspublish
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/awsv4"
+)
+
+const (
+	route53DefaultEndpoint = "https://route53.amazonaws.com"
+	route53Region          = "us-east-1"
+	route53Service         = "route53"
+	route53Namespace       = "https://route53.amazonaws.com/doc/2013-04-01/"
+	// Route 53 records do not expire, so unchanged records are only
+	// published again this often (in case they were edited by hand).
+	route53RepublishInterval = 15 * time.Minute
+	httpTimeout              = 10 * time.Second
+)
+
+type route53Backend struct {
+	config Route53Config
+	now    func() time.Time
+	mutex  sync.Mutex
+	// Protected by mutex.
+	published     *route53RecordSet
+	lastPublished time.Time
+	publishedTXT  map[string]route53PublishedTXT
+}
+
+type route53PublishedTXT struct {
+	recordSet route53TXTRecordSet
+	published time.Time
+}
+
+type route53ResourceRecord struct {
+	Value string `xml:"Value"`
+}
+
+type route53RecordSet struct {
+	Name            string                  `xml:"Name"`
+	Type            string                  `xml:"Type"`
+	SetIdentifier   string                  `xml:"SetIdentifier"`
+	Weight          uint16                  `xml:"Weight"`
+	TTL             int64                   `xml:"TTL"`
+	ResourceRecords []route53ResourceRecord `xml:"ResourceRecords>ResourceRecord"`
+}
+
+// route53TXTRecordSet is a record set without routing policy, which is
+// what shared records use.
+type route53TXTRecordSet struct {
+	Name            string                  `xml:"Name"`
+	Type            string                  `xml:"Type"`
+	TTL             int64                   `xml:"TTL"`
+	ResourceRecords []route53ResourceRecord `xml:"ResourceRecords>ResourceRecord"`
+}
+
+type route53TXTChange struct {
+	Action            string              `xml:"Action"`
+	ResourceRecordSet route53TXTRecordSet `xml:"ResourceRecordSet"`
+}
+
+type route53TXTChangeRequest struct {
+	XMLName xml.Name           `xml:"ChangeResourceRecordSetsRequest"`
+	Xmlns   string             `xml:"xmlns,attr"`
+	Comment string             `xml:"ChangeBatch>Comment"`
+	Changes []route53TXTChange `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53Change struct {
+	Action            string           `xml:"Action"`
+	ResourceRecordSet route53RecordSet `xml:"ResourceRecordSet"`
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"ChangeResourceRecordSetsRequest"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Comment string          `xml:"ChangeBatch>Comment"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53Error struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+func newRoute53(config Route53Config) (*route53Backend, error) {
+	if config.HostedZoneID == "" {
+		return nil, errors.New("dnspublish: no Route 53 hosted zone")
+	}
+	config.HostedZoneID = strings.TrimPrefix(config.HostedZoneID,
+		"/hostedzone/")
+	if config.AccessKeyID == "" {
+		config.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		config.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		config.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return nil, errors.New("dnspublish: no AWS credentials")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = route53DefaultEndpoint
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: httpTimeout}
+	}
+	return &route53Backend{
+		config:       config,
+		now:          time.Now,
+		publishedTXT: make(map[string]route53PublishedTXT),
+	}, nil
+}
+
+func route53RecordSetFor(instance Instance, weight uint16) route53RecordSet {
+	recordSet := route53RecordSet{
+		Name:          strings.TrimSuffix(instance.Name, ".") + ".",
+		SetIdentifier: instance.ID,
+		Weight:        weight,
+		TTL:           int64(instance.TTL / time.Second),
+	}
+	var value string
+	if ip := net.ParseIP(instance.Target); ip == nil {
+		recordSet.Type = "SRV"
+		value = fmt.Sprintf("0 %d %d %s.", instance.Weight, instance.Port,
+			strings.TrimSuffix(instance.Target, "."))
+	} else if ip.To4() != nil {
+		recordSet.Type = "A"
+		value = ip.String()
+	} else {
+		recordSet.Type = "AAAA"
+		value = ip.String()
+	}
+	recordSet.ResourceRecords = []route53ResourceRecord{{Value: value}}
+	return recordSet
+}
+
+func (b *route53Backend) Publish(instance Instance, ready bool) error {
+	var weight uint16
+	if ready {
+		weight = instance.Weight
+	}
+	recordSet := route53RecordSetFor(instance, weight)
+	now := b.now()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.published != nil && equalRoute53RecordSets(*b.published, recordSet) &&
+		now.Sub(b.lastPublished) < route53RepublishInterval {
+		return nil
+	}
+	changes := []route53Change{{Action: "UPSERT", ResourceRecordSet: recordSet}}
+	// A change of type (e.g. a new address family) leaves the old record
+	// behind unless it is deleted in the same batch.
+	if b.published != nil && b.published.Type != recordSet.Type {
+		changes = append([]route53Change{
+			{Action: "DELETE", ResourceRecordSet: *b.published}}, changes...)
+	}
+	comment := fmt.Sprintf("keymaster %s ready: %v", instance.ID, ready)
+	if err := b.change(comment, changes); err != nil {
+		return err
+	}
+	b.published = &recordSet
+	b.lastPublished = now
+	return nil
+}
+
+func (b *route53Backend) Withdraw(instance Instance) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.published == nil {
+		return nil
+	}
+	err := b.change(fmt.Sprintf("keymaster %s stopped", instance.ID),
+		[]route53Change{{Action: "DELETE", ResourceRecordSet: *b.published}})
+	if err != nil {
+		return err
+	}
+	b.published = nil
+	return nil
+}
+
+func (b *route53Backend) PublishTXT(name string, values []string,
+	ttl time.Duration) error {
+	recordSet := route53TXTRecordSet{
+		Name: strings.TrimSuffix(name, ".") + ".",
+		Type: "TXT",
+		TTL:  int64(ttl / time.Second),
+	}
+	for _, value := range values {
+		recordSet.ResourceRecords = append(recordSet.ResourceRecords,
+			route53ResourceRecord{Value: strconv.Quote(value)})
+	}
+	now := b.now()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	published, ok := b.publishedTXT[name]
+	if ok && equalRoute53TXTRecordSets(published.recordSet, recordSet) &&
+		now.Sub(published.published) < route53RepublishInterval {
+		return nil
+	}
+	change := route53TXTChange{Action: "UPSERT", ResourceRecordSet: recordSet}
+	if len(values) < 1 {
+		// Record sets cannot be empty.
+		if !ok {
+			return nil
+		}
+		change = route53TXTChange{Action: "DELETE",
+			ResourceRecordSet: published.recordSet}
+	}
+	err := b.send(route53TXTChangeRequest{
+		Xmlns:   route53Namespace,
+		Comment: "keymaster " + name,
+		Changes: []route53TXTChange{change},
+	})
+	if err != nil {
+		return err
+	}
+	if len(values) < 1 {
+		delete(b.publishedTXT, name)
+	} else {
+		b.publishedTXT[name] = route53PublishedTXT{recordSet, now}
+	}
+	return nil
+}
+
+func equalRoute53TXTRecordSets(a, b route53TXTRecordSet) bool {
+	if a.Name != b.Name || a.TTL != b.TTL ||
+		len(a.ResourceRecords) != len(b.ResourceRecords) {
+		return false
+	}
+	for index := range a.ResourceRecords {
+		if a.ResourceRecords[index] != b.ResourceRecords[index] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalRoute53RecordSets(a, b route53RecordSet) bool {
+	if a.Name != b.Name || a.Type != b.Type ||
+		a.SetIdentifier != b.SetIdentifier || a.Weight != b.Weight ||
+		a.TTL != b.TTL || len(a.ResourceRecords) != len(b.ResourceRecords) {
+		return false
+	}
+	for index := range a.ResourceRecords {
+		if a.ResourceRecords[index] != b.ResourceRecords[index] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *route53Backend) change(comment string,
+	changes []route53Change) error {
+	return b.send(route53ChangeRequest{
+		Xmlns:   route53Namespace,
+		Comment: comment,
+		Changes: changes,
+	})
+}
+
+// send POSTs request, a change request, to the hosted zone.
+func (b *route53Backend) send(request interface{}) error {
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return err
+	}
+	body = append([]byte(xml.Header), body...)
+	path := "/2013-04-01/hostedzone/" + b.config.HostedZoneID + "/rrset/"
+	req, err := http.NewRequest("POST", b.config.Endpoint+path,
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	awsv4.Sign(req, body, b.config.AccessKeyID, b.config.SecretAccessKey,
+		b.config.SessionToken, route53Region, route53Service, b.now())
+	resp, err := b.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	var apiError route53Error
+	if xml.Unmarshal(data, &apiError) == nil && apiError.Code != "" {
+		return fmt.Errorf("dnspublish: Route 53: %s: %s", apiError.Code,
+			apiError.Message)
+	}
+	return fmt.Errorf("dnspublish: Route 53: %s", resp.Status)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
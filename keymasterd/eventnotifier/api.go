@@ -13,12 +13,19 @@ type EventNotifier struct {
 	mutex  sync.Mutex
 	// Protected by lock.
 	transmitChannels map[chan<- eventmon.EventV0]chan<- eventmon.EventV0
+	sinks            []func(eventmon.EventV0)
 }
 
 func New(logger log.DebugLogger) *EventNotifier {
 	return newEventNotifier(logger)
 }
 
+// AddSink registers a function which is called with every published event
+// in addition to sending it to the connected eventmon clients.
+func (n *EventNotifier) AddSink(sink func(eventmon.EventV0)) {
+	n.addSink(sink)
+}
+
 func (n *EventNotifier) PublishAuthEvent(authType, username string) {
 	n.publishAuthEvent(authType, username)
 }
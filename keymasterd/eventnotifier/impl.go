@@ -33,16 +33,15 @@ func (n *EventNotifier) publishAuthEvent(authType, username string) {
 	n.transmitEvent(transmitData)
 }
 
-func (n *EventNotifier) publishCert(certType string, certData []byte) {
-	transmitData := eventmon.EventV0{Type: certType, CertData: certData}
+func (n *EventNotifier) addSink(sink func(eventmon.EventV0)) {
 	n.mutex.Lock()
 	defer n.mutex.Unlock()
-	for ch := range n.transmitChannels {
-		select {
-		case ch <- transmitData:
-		default:
-		}
-	}
+	n.sinks = append(n.sinks, sink)
+}
+
+func (n *EventNotifier) publishCert(certType string, certData []byte) {
+	transmitData := eventmon.EventV0{Type: certType, CertData: certData}
+	n.transmitEvent(transmitData)
 }
 
 func (n *EventNotifier) publishServiceProviderLoginEvent(url, username string) {
@@ -149,13 +148,17 @@ func (n *EventNotifier) handleConnection(rw *bufio.ReadWriter) {
 
 func (n *EventNotifier) transmitEvent(event eventmon.EventV0) {
 	n.mutex.Lock()
-	defer n.mutex.Unlock()
 	for ch := range n.transmitChannels {
 		select {
 		case ch <- event:
 		default:
 		}
 	}
+	sinks := n.sinks
+	n.mutex.Unlock()
+	for _, sink := range sinks {
+		sink(event)
+	}
 }
 
 func transmitV0(writer io.Writer, event eventmon.EventV0) error {
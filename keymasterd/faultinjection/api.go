@@ -0,0 +1,66 @@
+// Package faultinjection delays or fails a share of calls to a dependency on
+// purpose, so that operators can rehearse outages of LDAP, the CA signer or
+// storage and check how the service and its clients cope. Faults expire on
+// their own so that a forgotten rehearsal does not become an outage.
+package faultinjection
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrInjected is returned by Inject for calls chosen to fail.
+var ErrInjected = errors.New("injected fault")
+
+// Fault describes what happens to calls to Target until Until.
+type Fault struct {
+	Target string `json:"target"`
+	// Percent of the calls, from 0 to 100, which are affected.
+	Percent float64       `json:"percent"`
+	Delay   time.Duration `json:"delay"` // Added before the call (or failure).
+	Fail    bool          `json:"fail"`
+	Until   time.Time     `json:"until"`
+}
+
+type clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// Injector is safe for concurrent use. A nil *Injector never injects.
+type Injector struct {
+	clock  clock
+	mutex  sync.Mutex
+	rand   *rand.Rand
+	faults map[string]Fault
+}
+
+// New returns an Injector with no faults.
+func New() *Injector {
+	return newInjector(kSystemClock, time.Now().UnixNano())
+}
+
+// Set adds fault, replacing any fault on the same target.
+func (i *Injector) Set(fault Fault) error {
+	return i.set(fault)
+}
+
+// Clear removes the fault on target, or all faults if target is "". It
+// returns the number of faults removed.
+func (i *Injector) Clear(target string) int {
+	return i.clear(target)
+}
+
+// Faults returns the faults which have not expired, sorted by target.
+func (i *Injector) Faults() []Fault {
+	return i.getFaults()
+}
+
+// Inject applies the fault on target, if any, to a call: it reports whether
+// the call was chosen, waits for the delay and returns ErrInjected if the
+// call is to fail.
+func (i *Injector) Inject(target string) (bool, error) {
+	return i.inject(target)
+}
@@ -0,0 +1,113 @@
+package faultinjection
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+type systemClockType struct{}
+
+func (s systemClockType) Now() time.Time {
+	return time.Now()
+}
+
+func (s systemClockType) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+var (
+	kSystemClock systemClockType
+)
+
+func newInjector(clock clock, seed int64) *Injector {
+	return &Injector{
+		clock:  clock,
+		rand:   rand.New(rand.NewSource(seed)),
+		faults: make(map[string]Fault),
+	}
+}
+
+func (i *Injector) set(fault Fault) error {
+	if fault.Target == "" {
+		return errors.New("missing target")
+	}
+	if fault.Percent <= 0 || fault.Percent > 100 {
+		return errors.New("percent must be above 0 and at most 100")
+	}
+	if fault.Delay < 0 {
+		return errors.New("negative delay")
+	}
+	if fault.Delay == 0 && !fault.Fail {
+		return errors.New("fault neither delays nor fails")
+	}
+	if !fault.Until.After(i.clock.Now()) {
+		return errors.New("fault already expired")
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.faults[fault.Target] = fault
+	return nil
+}
+
+func (i *Injector) clear(target string) int {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if target == "" {
+		count := len(i.faults)
+		i.faults = make(map[string]Fault)
+		return count
+	}
+	if _, ok := i.faults[target]; !ok {
+		return 0
+	}
+	delete(i.faults, target)
+	return 1
+}
+
+func (i *Injector) getFaults() []Fault {
+	if i == nil {
+		return nil
+	}
+	now := i.clock.Now()
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	var faults []Fault
+	for target, fault := range i.faults {
+		if !fault.Until.After(now) {
+			delete(i.faults, target)
+			continue
+		}
+		faults = append(faults, fault)
+	}
+	sort.Slice(faults, func(a, b int) bool {
+		return faults[a].Target < faults[b].Target
+	})
+	return faults
+}
+
+func (i *Injector) inject(target string) (bool, error) {
+	if i == nil {
+		return false, nil
+	}
+	now := i.clock.Now()
+	i.mutex.Lock()
+	fault, ok := i.faults[target]
+	if ok && !fault.Until.After(now) {
+		delete(i.faults, target)
+		ok = false
+	}
+	chosen := ok && i.rand.Float64()*100 < fault.Percent
+	i.mutex.Unlock()
+	if !chosen {
+		return false, nil
+	}
+	if fault.Delay > 0 {
+		i.clock.Sleep(fault.Delay)
+	}
+	if fault.Fail {
+		return true, ErrInjected
+	}
+	return true, nil
+}
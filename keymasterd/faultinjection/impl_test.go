@@ -0,0 +1,86 @@
+package faultinjection
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.slept += d
+}
+
+func TestInjector(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	injector := newInjector(clock, 1)
+	until := clock.now.Add(time.Minute)
+	for _, fault := range []Fault{
+		{Target: "ldap", Percent: 0, Fail: true, Until: until},
+		{Target: "ldap", Percent: 101, Fail: true, Until: until},
+		{Target: "ldap", Percent: 50, Until: until},
+		{Target: "ldap", Percent: 50, Fail: true, Until: clock.now},
+		{Percent: 50, Fail: true, Until: until},
+	} {
+		if err := injector.Set(fault); err == nil {
+			t.Errorf("bad fault accepted: %+v", fault)
+		}
+	}
+	err := injector.Set(Fault{Target: "ldap", Percent: 25, Fail: true,
+		Until: until})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = injector.Set(Fault{Target: "signing", Percent: 100,
+		Delay: time.Second, Until: until.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := 0
+	for index := 0; index < 1000; index++ {
+		injected, err := injector.Inject("ldap")
+		if injected != (err == ErrInjected) {
+			t.Fatalf("injected %v with error %v", injected, err)
+		}
+		if err != nil {
+			failures++
+		}
+	}
+	if failures < 200 || failures > 300 {
+		t.Errorf("%d of 1000 calls failed, expected about 250", failures)
+	}
+	if injected, err := injector.Inject("signing"); !injected || err != nil ||
+		clock.slept != time.Second {
+		t.Errorf("delay not injected: %v, %v, slept %s", injected, err,
+			clock.slept)
+	}
+	if injected, _ := injector.Inject("storage"); injected {
+		t.Error("fault injected without a fault")
+	}
+	if faults := injector.Faults(); len(faults) != 2 ||
+		faults[0].Target != "ldap" {
+		t.Errorf("unexpected faults: %+v", faults)
+	}
+
+	clock.now = until
+	if injected, _ := injector.Inject("ldap"); injected {
+		t.Error("expired fault injected")
+	}
+	if faults := injector.Faults(); len(faults) != 1 {
+		t.Errorf("expired fault listed: %+v", faults)
+	}
+	if cleared := injector.Clear(""); cleared != 1 {
+		t.Errorf("cleared %d faults", cleared)
+	}
+	var nilInjector *Injector
+	if injected, err := nilInjector.Inject("ldap"); injected || err != nil {
+		t.Error("nil injector injected")
+	}
+}
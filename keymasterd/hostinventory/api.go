@@ -0,0 +1,51 @@
+// Package hostinventory provides the inventory of hosts which may request
+// host certificates and the names each of them may have certified.
+package hostinventory
+
+import (
+	"net"
+)
+
+// Host describes the names a host may have certified.
+type Host struct {
+	Identity    string   `yaml:"identity"` // User in the host's IP restricted certificate.
+	DNSNames    []string `yaml:"dns_names"`
+	IPAddresses []string `yaml:"ip_addresses"`
+	// Names of the host certificate profiles the host may request. If empty
+	// all host profiles are allowed.
+	Profiles []string `yaml:"profiles"`
+}
+
+// Inventory is a set of hosts keyed by identity.
+type Inventory struct {
+	hosts map[string]Host
+}
+
+// Load reads an inventory from the YAML file filename. The file contains a
+// list of hosts under the "hosts" key.
+func Load(filename string) (*Inventory, error) {
+	return load(filename)
+}
+
+// Lookup returns the host with the given identity. The inventory may be nil,
+// in which case no host is found.
+func (inv *Inventory) Lookup(identity string) (Host, bool) {
+	return inv.lookup(identity)
+}
+
+// AllowsProfile returns true if the host may request certificates with the
+// named profile.
+func (h Host) AllowsProfile(profile string) bool {
+	return h.allowsProfile(profile)
+}
+
+// CheckNames returns an error unless every one of dnsNames and ipAddresses
+// belongs to the host.
+func (h Host) CheckNames(dnsNames []string, ipAddresses []net.IP) error {
+	return h.checkNames(dnsNames, ipAddresses)
+}
+
+// ParsedIPAddresses returns the IP addresses of the host.
+func (h Host) ParsedIPAddresses() []net.IP {
+	return h.parsedIPAddresses()
+}
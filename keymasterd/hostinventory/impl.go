@@ -0,0 +1,103 @@
+package hostinventory
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type inventoryFile struct {
+	Hosts []Host `yaml:"hosts"`
+}
+
+func load(filename string) (*Inventory, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var file inventoryFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("cannot parse host inventory: %s", err)
+	}
+	inv := &Inventory{hosts: make(map[string]Host)}
+	for _, host := range file.Hosts {
+		if host.Identity == "" {
+			return nil, errors.New("host inventory entry with no identity")
+		}
+		if _, ok := inv.hosts[host.Identity]; ok {
+			return nil, fmt.Errorf("duplicate host inventory entry: %s",
+				host.Identity)
+		}
+		for i, name := range host.DNSNames {
+			host.DNSNames[i] = strings.ToLower(name)
+		}
+		for _, address := range host.IPAddresses {
+			if net.ParseIP(address) == nil {
+				return nil, fmt.Errorf("invalid IP address %s for host %s",
+					address, host.Identity)
+			}
+		}
+		inv.hosts[host.Identity] = host
+	}
+	return inv, nil
+}
+
+func (inv *Inventory) lookup(identity string) (Host, bool) {
+	if inv == nil {
+		return Host{}, false
+	}
+	host, ok := inv.hosts[identity]
+	return host, ok
+}
+
+func (h Host) allowsProfile(profile string) bool {
+	if len(h.Profiles) < 1 {
+		return true
+	}
+	for _, allowed := range h.Profiles {
+		if allowed == profile {
+			return true
+		}
+	}
+	return false
+}
+
+func (h Host) checkNames(dnsNames []string, ipAddresses []net.IP) error {
+	allowedNames := make(map[string]struct{})
+	for _, name := range h.DNSNames {
+		allowedNames[name] = struct{}{}
+	}
+	for _, name := range dnsNames {
+		if _, ok := allowedNames[strings.ToLower(name)]; !ok {
+			return fmt.Errorf("%s is not a name of host %s", name, h.Identity)
+		}
+	}
+	for _, address := range ipAddresses {
+		found := false
+		for _, allowed := range h.parsedIPAddresses() {
+			if allowed.Equal(address) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s is not an address of host %s", address,
+				h.Identity)
+		}
+	}
+	return nil
+}
+
+func (h Host) parsedIPAddresses() []net.IP {
+	var addresses []net.IP
+	for _, address := range h.IPAddresses {
+		if ip := net.ParseIP(address); ip != nil {
+			addresses = append(addresses, ip)
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,53 @@
+package hostinventory
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+const testInventory = `hosts:
+  - identity: relay1
+    dns_names: [MX1.example.com, mail.example.com]
+    ip_addresses: [10.0.0.1]
+    profiles: [x509-smtp-relay]
+`
+
+func TestLoadAndCheckNames(t *testing.T) {
+	file, err := ioutil.TempFile("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(testInventory); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	inv, err := Load(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, ok := inv.Lookup("relay1")
+	if !ok {
+		t.Fatal("host not found")
+	}
+	if !host.AllowsProfile("x509-smtp-relay") || host.AllowsProfile("other") {
+		t.Fatal("unexpected profile permissions")
+	}
+	err = host.CheckNames([]string{"mx1.example.com"},
+		[]net.IP{net.ParseIP("10.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := host.CheckNames([]string{"evil.example.com"}, nil); err == nil {
+		t.Fatal("foreign name should have been refused")
+	}
+	if err := host.CheckNames(nil, []net.IP{net.ParseIP("10.0.0.2")}); err == nil {
+		t.Fatal("foreign address should have been refused")
+	}
+	var nilInventory *Inventory
+	if _, ok := nilInventory.Lookup("relay1"); ok {
+		t.Fatal("nil inventory should have no hosts")
+	}
+}
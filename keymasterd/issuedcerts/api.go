@@ -0,0 +1,61 @@
+// Package issuedcerts records the X.509 certificates issued by keymaster, so
+// that the OCSP responder can tell certificates it issued from unknown ones.
+// The records are kept in a file with one JSON encoded entry per line which
+// is only ever appended to, or in an object store with one object per
+// certificate, which all the instances of an HA deployment may share.
+package issuedcerts
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/objectstore"
+)
+
+// Entry is one issued certificate. Serial is the decimal serial number.
+type Entry struct {
+	Serial    string
+	Subject   string
+	Type      string
+	NotBefore time.Time
+	NotAfter  time.Time
+	// RequestID is the X-Request-ID of the HTTP request which issued the
+	// certificate.
+	RequestID string `json:",omitempty"`
+}
+
+// Database is safe for concurrent use. A nil *Database records nothing and
+// knows no certificates.
+type Database struct {
+	mutex   sync.Mutex
+	file    *os.File
+	store   objectstore.Store
+	prefix  string
+	entries map[string]Entry
+}
+
+// Open opens the database in filename, creating it if needed.
+func Open(filename string) (*Database, error) {
+	return openDatabase(filename)
+}
+
+// OpenObjectStore opens the database kept in store under prefix, with the
+// decimal serial as the rest of the key. Entries are read from the store
+// when first needed, so that certificates issued by other instances sharing
+// the objects are known.
+func OpenObjectStore(store objectstore.Store, prefix string) *Database {
+	return &Database{store: store, prefix: prefix,
+		entries: make(map[string]Entry)}
+}
+
+// Record adds entry to the database.
+func (db *Database) Record(entry Entry) error {
+	return db.record(entry)
+}
+
+// Get returns the entry of serial, given in decimal or 0x prefixed
+// hexadecimal, if it was issued.
+func (db *Database) Get(serial string) (Entry, bool) {
+	return db.get(serial)
+}
@@ -0,0 +1,107 @@
+package issuedcerts
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+func openDatabase(filename string) (*Database, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE,
+		0600)
+	if err != nil {
+		return nil, err
+	}
+	db := &Database{file: file, entries: make(map[string]Entry)}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("issuedcerts: %s:%d: %s", filename,
+				lineNumber, err)
+		}
+		db.entries[entry.Serial] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// canonicalSerial accepts decimal or 0x prefixed hexadecimal serials.
+func canonicalSerial(serial string) (string, error) {
+	value, ok := new(big.Int).SetString(serial, 0)
+	if !ok || value.Sign() <= 0 {
+		return "", fmt.Errorf("issuedcerts: bad serial: %s", serial)
+	}
+	return value.String(), nil
+}
+
+func (db *Database) record(entry Entry) error {
+	if db == nil {
+		return nil
+	}
+	serial, err := canonicalSerial(entry.Serial)
+	if err != nil {
+		return err
+	}
+	entry.Serial = serial
+	entry.NotBefore = entry.NotBefore.UTC()
+	entry.NotAfter = entry.NotAfter.UTC()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if db.store != nil {
+		if err := db.store.Put(db.prefix+serial, data); err != nil {
+			return err
+		}
+		db.mutex.Lock()
+		defer db.mutex.Unlock()
+		db.entries[serial] = entry
+		return nil
+	}
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if _, err := db.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := db.file.Sync(); err != nil {
+		return err
+	}
+	db.entries[serial] = entry
+	return nil
+}
+
+func (db *Database) get(serial string) (Entry, bool) {
+	if db == nil {
+		return Entry{}, false
+	}
+	serial, err := canonicalSerial(serial)
+	if err != nil {
+		return Entry{}, false
+	}
+	db.mutex.Lock()
+	entry, ok := db.entries[serial]
+	db.mutex.Unlock()
+	if ok || db.store == nil {
+		return entry, ok
+	}
+	// Misses are not kept: the certificate may be recorded later by another
+	// instance.
+	data, ok, err := db.store.Get(db.prefix + serial)
+	if err != nil || !ok {
+		return Entry{}, false
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return Entry{}, false
+	}
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.entries[serial] = entry
+	return entry, true
+}
@@ -0,0 +1,91 @@
+package issuedcerts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "issuedcerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "issued")
+	db, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Record(Entry{Serial: "zero"}); err == nil {
+		t.Fatal("bad serial should be rejected")
+	}
+	notBefore := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	err = db.Record(Entry{Serial: "0x10", Subject: "alice", Type: "x509",
+		NotBefore: notBefore, NotAfter: notBefore.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := db.Get("16"); !ok || entry.Subject != "alice" ||
+		entry.Serial != "16" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if _, ok := db.Get("17"); ok {
+		t.Fatal("other serial should not have an entry")
+	}
+	reopened, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := reopened.Get("0x10"); !ok ||
+		!entry.NotAfter.Equal(notBefore.Add(time.Hour)) {
+		t.Fatalf("unexpected entry after reopening: %+v", entry)
+	}
+	var nilDB *Database
+	if _, ok := nilDB.Get("16"); ok {
+		t.Fatal("nil database should know no certificates")
+	}
+}
+
+// memoryStore is an objectstore.Store in memory.
+type memoryStore map[string][]byte
+
+func (s memoryStore) Get(key string) ([]byte, bool, error) {
+	data, ok := s[key]
+	return data, ok, nil
+}
+
+func (s memoryStore) Put(key string, data []byte) error {
+	s[key] = data
+	return nil
+}
+
+func (s memoryStore) Delete(key string) error {
+	delete(s, key)
+	return nil
+}
+
+func (s memoryStore) List(prefix string) ([]string, error) {
+	return nil, nil
+}
+
+func TestObjectStore(t *testing.T) {
+	store := memoryStore{}
+	db := OpenObjectStore(store, "issued_x509/")
+	other := OpenObjectStore(store, "issued_x509/")
+	if _, ok := other.Get("16"); ok {
+		t.Fatal("unknown serial should not have an entry")
+	}
+	if err := db.Record(Entry{Serial: "0x10", Subject: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store["issued_x509/16"]; !ok {
+		t.Fatalf("no object: %v", store)
+	}
+	// The miss above was not kept.
+	if entry, ok := other.Get("16"); !ok || entry.Subject != "alice" {
+		t.Fatalf("unexpected entry from the other instance: %+v", entry)
+	}
+}
@@ -0,0 +1,84 @@
+// Package kafka appends records to an Apache Kafka topic. It wraps the
+// writer of github.com/segmentio/kafka-go, sending one record at a time,
+// with TLS and SASL PLAIN and SCRAM authentication. Records are partitioned
+// like the Java client does, by the murmur2 hash of their key, so records
+// with the same key keep their order.
+package kafka
+
+import (
+	"crypto/tls"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// Acknowledgements required from the brokers before Produce returns.
+const (
+	// AcksAll waits for all in sync replicas.
+	AcksAll = -1
+	// AcksNone does not wait at all, records can be lost silently.
+	AcksNone = 0
+	// AcksLeader waits for the partition leader only.
+	AcksLeader = 1
+)
+
+// SASL mechanisms.
+const (
+	SASLPlain       = "PLAIN"
+	SASLSCRAMSHA256 = "SCRAM-SHA-256"
+	SASLSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+// SASLConfig configures SASL authentication to the brokers.
+type SASLConfig struct {
+	// Mechanism is one of SASLPlain, SASLSCRAMSHA256 and SASLSCRAMSHA512.
+	Mechanism string
+	Username  string
+	Password  string
+}
+
+// Config configures a Producer.
+type Config struct {
+	// Brokers are the host:port addresses used to discover the cluster,
+	// tried in order.
+	Brokers []string
+	Topic   string
+	// RequiredAcks is one of AcksAll, AcksLeader and AcksNone.
+	RequiredAcks int16
+	// TLSConfig enables TLS if not nil.
+	TLSConfig *tls.Config
+	// SASL enables SASL authentication if not nil.
+	SASL *SASLConfig
+	// ClientID defaults to "keymaster".
+	ClientID string
+	// Timeout bounds each request, including waiting for the
+	// acknowledgements. Default: 10 seconds.
+	Timeout time.Duration
+}
+
+// Producer appends records to a topic. Connections to the brokers are opened
+// when needed and kept. Methods are safe for concurrent use.
+type Producer struct {
+	config Config
+	writer *kafkago.Writer
+}
+
+// NewProducer returns a producer for config. It does not connect to the
+// brokers. SASL PLAIN sends the password in the clear and is refused
+// without TLS.
+func NewProducer(config Config) (*Producer, error) {
+	return newProducer(config)
+}
+
+// Produce appends a record with key and value to the topic and waits for
+// the acknowledgements required by the configuration. Records with a nil
+// key are spread over the partitions. Produce does not retry.
+func (p *Producer) Produce(key, value []byte) error {
+	return p.produce(key, value)
+}
+
+// Close closes the connections to the brokers. The producer must not be
+// used afterwards.
+func (p *Producer) Close() error {
+	return p.writer.Close()
+}
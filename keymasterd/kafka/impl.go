@@ -0,0 +1,99 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+const (
+	defaultClientID = "keymaster"
+	defaultTimeout  = 10 * time.Second
+)
+
+func newProducer(config Config) (*Producer, error) {
+	if len(config.Brokers) < 1 {
+		return nil, errors.New("kafka: no brokers")
+	}
+	if config.Topic == "" {
+		return nil, errors.New("kafka: no topic")
+	}
+	switch config.RequiredAcks {
+	case AcksAll, AcksNone, AcksLeader:
+	default:
+		return nil, fmt.Errorf("kafka: invalid required acks: %d",
+			config.RequiredAcks)
+	}
+	if config.ClientID == "" {
+		config.ClientID = defaultClientID
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	mechanism, err := newSASLMechanism(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Producer{
+		config: config,
+		writer: &kafkago.Writer{
+			Addr:  kafkago.TCP(config.Brokers...),
+			Topic: config.Topic,
+			// Like the default partitioner of the Java client.
+			Balancer:     &kafkago.Murmur2Balancer{},
+			RequiredAcks: kafkago.RequiredAcks(config.RequiredAcks),
+			// Retries are left to the callers.
+			MaxAttempts:  1,
+			BatchSize:    1,
+			ReadTimeout:  config.Timeout,
+			WriteTimeout: config.Timeout,
+			Transport: &kafkago.Transport{
+				ClientID:    config.ClientID,
+				TLS:         config.TLSConfig,
+				SASL:        mechanism,
+				DialTimeout: config.Timeout,
+			},
+		},
+	}, nil
+}
+
+func newSASLMechanism(config Config) (sasl.Mechanism, error) {
+	if config.SASL == nil {
+		return nil, nil
+	}
+	switch config.SASL.Mechanism {
+	case SASLPlain:
+		if config.TLSConfig == nil {
+			return nil, errors.New(
+				"kafka: SASL PLAIN without TLS would send the password in the clear")
+		}
+		return plain.Mechanism{
+			Username: config.SASL.Username,
+			Password: config.SASL.Password,
+		}, nil
+	case SASLSCRAMSHA256:
+		return scram.Mechanism(scram.SHA256, config.SASL.Username,
+			config.SASL.Password)
+	case SASLSCRAMSHA512:
+		return scram.Mechanism(scram.SHA512, config.SASL.Username,
+			config.SASL.Password)
+	}
+	return nil, fmt.Errorf("kafka: unsupported SASL mechanism: %s",
+		config.SASL.Mechanism)
+}
+
+func (p *Producer) produce(key, value []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Timeout)
+	defer cancel()
+	err := p.writer.WriteMessages(ctx, kafkago.Message{Key: key, Value: value})
+	if err != nil {
+		return fmt.Errorf("kafka: %s: %s", p.config.Topic, err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+)
+
+type producedRecord struct {
+	partition  int32
+	key, value []byte
+}
+
+// fakeTransport answers the requests of the writer instead of the brokers.
+type fakeTransport struct {
+	topic      string
+	partitions int
+	mutex      sync.Mutex
+	records    []producedRecord
+}
+
+func (f *fakeTransport) RoundTrip(ctx context.Context, addr net.Addr,
+	request kafkago.Request) (kafkago.Response, error) {
+	switch request := request.(type) {
+	case *metadata.Request:
+		response := &metadata.Response{
+			Brokers: []metadata.ResponseBroker{{NodeID: 1, Host: "127.0.0.1",
+				Port: 9092}},
+			Topics: []metadata.ResponseTopic{{Name: f.topic}},
+		}
+		for index := 0; index < f.partitions; index++ {
+			response.Topics[0].Partitions = append(
+				response.Topics[0].Partitions,
+				metadata.ResponsePartition{PartitionIndex: int32(index),
+					LeaderID: 1})
+		}
+		return response, nil
+	case *produce.Request:
+		response := &produce.Response{}
+		for _, topic := range request.Topics {
+			responseTopic := produce.ResponseTopic{Topic: topic.Topic}
+			for _, partition := range topic.Partitions {
+				if err := f.record(partition); err != nil {
+					return nil, err
+				}
+				responseTopic.Partitions = append(responseTopic.Partitions,
+					produce.ResponsePartition{Partition: partition.Partition})
+			}
+			response.Topics = append(response.Topics, responseTopic)
+		}
+		return response, nil
+	}
+	return nil, errors.New("unexpected request")
+}
+
+func (f *fakeTransport) record(partition produce.RequestPartition) error {
+	records := partition.RecordSet.Records
+	for {
+		record, err := records.ReadRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		produced := producedRecord{partition: partition.Partition}
+		if record.Key != nil {
+			if produced.key, err = protocol.ReadAll(record.Key); err != nil {
+				return err
+			}
+		}
+		if produced.value, err = protocol.ReadAll(record.Value); err != nil {
+			return err
+		}
+		f.mutex.Lock()
+		f.records = append(f.records, produced)
+		f.mutex.Unlock()
+	}
+}
+
+func (f *fakeTransport) recorded() []producedRecord {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]producedRecord(nil), f.records...)
+}
+
+func newTestProducer(t *testing.T, partitions int) (*Producer,
+	*fakeTransport) {
+	producer, err := NewProducer(Config{
+		Brokers:      []string{"127.0.0.1:9092"},
+		Topic:        "audit",
+		RequiredAcks: AcksAll,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &fakeTransport{topic: "audit", partitions: partitions}
+	producer.writer.Transport = transport
+	return producer, transport
+}
+
+func TestProduce(t *testing.T) {
+	producer, transport := newTestProducer(t, 3)
+	defer producer.Close()
+	for _, key := range []string{"alice", "bob", "alice"} {
+		if err := producer.Produce([]byte(key), []byte("event")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := producer.Produce(nil, []byte("unkeyed")); err != nil {
+		t.Fatal(err)
+	}
+	records := transport.recorded()
+	if len(records) != 4 {
+		t.Fatalf("%d records", len(records))
+	}
+	balancer := kafkago.Murmur2Balancer{}
+	for index, key := range []string{"alice", "bob"} {
+		partition := balancer.Balance(kafkago.Message{Key: []byte(key)},
+			0, 1, 2)
+		if records[index].partition != int32(partition) {
+			t.Errorf("%s: partition %d, expected %d", key,
+				records[index].partition, partition)
+		}
+	}
+	if records[2].partition != records[0].partition {
+		t.Errorf("unexpected partitions: %+v", records)
+	}
+	if string(records[0].key) != "alice" || string(records[0].value) != "event" {
+		t.Errorf("unexpected record: %+v", records[0])
+	}
+	if records[3].key != nil || string(records[3].value) != "unkeyed" {
+		t.Errorf("unexpected record: %+v", records[3])
+	}
+}
+
+func TestNewProducer(t *testing.T) {
+	config := Config{
+		Brokers:      []string{"127.0.0.1:9092"},
+		Topic:        "audit",
+		RequiredAcks: AcksLeader,
+		SASL: &SASLConfig{Mechanism: SASLPlain, Username: "keymaster",
+			Password: "secret"},
+	}
+	if _, err := NewProducer(config); err == nil {
+		t.Error("SASL PLAIN without TLS accepted")
+	}
+	config.TLSConfig = &tls.Config{}
+	if _, err := NewProducer(config); err != nil {
+		t.Error(err)
+	}
+	config.TLSConfig = nil
+	config.SASL.Mechanism = SASLSCRAMSHA256
+	if _, err := NewProducer(config); err != nil {
+		t.Error(err)
+	}
+	config.SASL.Mechanism = "GSSAPI"
+	if _, err := NewProducer(config); err == nil {
+		t.Error("unsupported mechanism accepted")
+	}
+	config.SASL = nil
+	config.RequiredAcks = 2
+	if _, err := NewProducer(config); err == nil {
+		t.Error("invalid required acks accepted")
+	}
+}
@@ -0,0 +1,204 @@
+// Package levellog implements a leveled logger writing one structured line
+// per message, in the logfmt key=value format, to a Dominator logger:
+//
+//	level=warn module=certgen user=alice client_ip=192.0.2.1 msg="..."
+//
+// The module of a message is the package which logged it or, for the package
+// which created the logger (usually main), the source file, so levels can be
+// configured per module without passing module loggers around. Fields, such as those of an HTTP request,
+// are added to every line of the loggers made with WithFields; they are read
+// when the line is written so that fields set later, such as the user once
+// authenticated, are included.
+//
+// Logger implements log.DebugLogger: Print messages are at the info level
+// and Debug messages at the debug level. Debug messages above the debug
+// verbosity, 0 unless set with SetDebugVerbosity, are dropped.
+package levellog
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+// Level is the severity of a message.
+type Level int
+
+// Levels, from the most verbose.
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+// ParseLevel returns the level called name: debug, info, warn or error.
+func ParseLevel(name string) (Level, error) {
+	return parseLevel(name)
+}
+
+func (level Level) String() string {
+	return level.string()
+}
+
+// LevelPrinter may be implemented by the destination of a Logger to receive
+// the level of each line, e.g. to map it to a syslog severity. Fatal and
+// Panic messages are still sent to the Fatal and Panic methods.
+type LevelPrinter interface {
+	PrintLevel(level Level, line string)
+}
+
+// Fields are key value pairs added to log lines. They are safe for
+// concurrent use.
+type Fields struct {
+	mutex  sync.Mutex
+	keys   []string          // Protected by mutex, in the order set.
+	values map[string]string // Protected by mutex.
+}
+
+// NewFields returns fields with the given keys and values, alternating.
+func NewFields(keyValues ...string) *Fields {
+	return newFields(keyValues)
+}
+
+// Set sets the value of key, which is added to the end of the lines if it
+// is new.
+func (f *Fields) Set(key, value string) {
+	f.set(key, value)
+}
+
+// Get returns the value of key, or the empty string.
+func (f *Fields) Get(key string) string {
+	return f.get(key)
+}
+
+// NewContext returns a copy of ctx carrying fields.
+func NewContext(ctx context.Context, fields *Fields) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// FromContext returns the fields of ctx, or nil.
+func FromContext(ctx context.Context) *Fields {
+	fields, _ := ctx.Value(fieldsKey{}).(*Fields)
+	return fields
+}
+
+// Logger is safe for concurrent use.
+type Logger struct {
+	dest         log.Logger
+	levels       *atomic.Value // Of *levels, shared with the derived loggers.
+	verbosity    *uint32       // Shared with the derived loggers.
+	module       string        // If empty, the module of the caller.
+	fields       *Fields
+	filesPackage string // The package whose modules are its files.
+}
+
+// New returns a logger writing to dest at the info level for all modules.
+// The modules of the package calling New are its source files.
+func New(dest log.Logger) *Logger {
+	return newLogger(dest)
+}
+
+// SetLevels sets the level of the modules, defaulting to defaultLevel.
+// It applies to the loggers derived from l as well.
+func (l *Logger) SetLevels(defaultLevel Level, modules map[string]Level) {
+	l.setLevels(defaultLevel, modules)
+}
+
+// SetDebugVerbosity sets the highest verbosity of the Debug messages which
+// are logged, in the modules at the debug level. It applies to the loggers
+// derived from l as well.
+func (l *Logger) SetDebugVerbosity(verbosity uint8) {
+	atomic.StoreUint32(l.verbosity, uint32(verbosity))
+}
+
+// Module returns a logger whose messages are of module name, e.g. for
+// libraries used by several modules.
+func (l *Logger) Module(name string) *Logger {
+	derived := *l
+	derived.module = name
+	return &derived
+}
+
+// WithFields returns a logger adding fields to its lines. If fields is nil
+// l is returned.
+func (l *Logger) WithFields(fields *Fields) *Logger {
+	if fields == nil {
+		return l
+	}
+	derived := *l
+	derived.fields = fields
+	return &derived
+}
+
+func (l *Logger) Debug(verbosity uint8, v ...interface{}) {
+	if l.verbose(verbosity) {
+		l.logln(Debug, l.callerModule(), false, v)
+	}
+}
+
+func (l *Logger) Debugf(verbosity uint8, format string, v ...interface{}) {
+	if l.verbose(verbosity) {
+		l.logf(Debug, l.callerModule(), format, v)
+	}
+}
+
+func (l *Logger) Debugln(verbosity uint8, v ...interface{}) {
+	if l.verbose(verbosity) {
+		l.logln(Debug, l.callerModule(), true, v)
+	}
+}
+
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.logf(Info, l.callerModule(), format, v)
+}
+
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.logf(Warn, l.callerModule(), format, v)
+}
+
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.logf(Error, l.callerModule(), format, v)
+}
+
+func (l *Logger) Print(v ...interface{}) {
+	l.logln(Info, l.callerModule(), false, v)
+}
+
+func (l *Logger) Printf(format string, v ...interface{}) {
+	l.logf(Info, l.callerModule(), format, v)
+}
+
+func (l *Logger) Println(v ...interface{}) {
+	l.logln(Info, l.callerModule(), true, v)
+}
+
+// Fatal, Fatalf and Fatalln write the message whatever the level and call
+// the same method of the destination, which exits.
+func (l *Logger) Fatal(v ...interface{}) {
+	l.dest.Fatal(l.line("fatal", l.callerModule(), sprint(false, v)))
+}
+
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	l.dest.Fatal(l.line("fatal", l.callerModule(), sprintf(format, v)))
+}
+
+func (l *Logger) Fatalln(v ...interface{}) {
+	l.dest.Fatal(l.line("fatal", l.callerModule(), sprint(true, v)))
+}
+
+// Panic, Panicf and Panicln write the message whatever the level and call
+// the same method of the destination, which panics.
+func (l *Logger) Panic(v ...interface{}) {
+	l.dest.Panic(l.line("panic", l.callerModule(), sprint(false, v)))
+}
+
+func (l *Logger) Panicf(format string, v ...interface{}) {
+	l.dest.Panic(l.line("panic", l.callerModule(), sprintf(format, v)))
+}
+
+func (l *Logger) Panicln(v ...interface{}) {
+	l.dest.Panic(l.line("panic", l.callerModule(), sprint(true, v)))
+}
@@ -0,0 +1,215 @@
+package levellog
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+type fieldsKey struct{}
+
+type levels struct {
+	defaultLevel Level
+	modules      map[string]Level
+}
+
+// modulesByPC caches the module of the callers.
+var modulesByPC sync.Map
+
+func parseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(level), nil
+		}
+	}
+	return 0, fmt.Errorf("levellog: unknown level: %s", name)
+}
+
+func (level Level) string() string {
+	if level < Debug || level > Error {
+		return strconv.Itoa(int(level))
+	}
+	return levelNames[level]
+}
+
+func newFields(keyValues []string) *Fields {
+	fields := &Fields{values: make(map[string]string)}
+	for index := 0; index+1 < len(keyValues); index += 2 {
+		fields.set(keyValues[index], keyValues[index+1])
+	}
+	return fields
+}
+
+func (f *Fields) set(key, value string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.values[key]; !ok {
+		f.keys = append(f.keys, key)
+	}
+	f.values[key] = value
+}
+
+func (f *Fields) get(key string) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.values[key]
+}
+
+func (f *Fields) appendTo(builder *strings.Builder) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, key := range f.keys {
+		appendField(builder, key, f.values[key])
+	}
+}
+
+func newLogger(dest log.Logger) *Logger {
+	l := &Logger{dest: dest, levels: &atomic.Value{}, verbosity: new(uint32)}
+	if pc, _, _, ok := runtime.Caller(2); ok {
+		if function := runtime.FuncForPC(pc); function != nil {
+			l.filesPackage = packageOf(function.Name())
+		}
+	}
+	l.setLevels(Info, nil)
+	return l
+}
+
+func (l *Logger) setLevels(defaultLevel Level, modules map[string]Level) {
+	copied := make(map[string]Level, len(modules))
+	for module, level := range modules {
+		copied[module] = level
+	}
+	l.levels.Store(&levels{defaultLevel: defaultLevel, modules: copied})
+}
+
+// callerModule returns the module of the caller of the method calling it.
+func (l *Logger) callerModule() string {
+	if l.module != "" {
+		return l.module
+	}
+	pc, file, _, ok := runtime.Caller(2)
+	if !ok {
+		return ""
+	}
+	if module, ok := modulesByPC.Load(pc); ok {
+		return module.(string)
+	}
+	module := l.moduleOf(runtime.FuncForPC(pc), file)
+	modulesByPC.Store(pc, module)
+	return module
+}
+
+// moduleOf returns the last element of the package of function or, for
+// the package which created the logger, the name of file without the
+// extension.
+func (l *Logger) moduleOf(function *runtime.Func, file string) string {
+	if function != nil {
+		pkg := packageOf(function.Name())
+		if pkg != l.filesPackage {
+			return pkg[strings.LastIndex(pkg, "/")+1:]
+		}
+	}
+	return strings.TrimSuffix(filepath.Base(file), ".go")
+}
+
+// packageOf returns the import path of the package of a function name such
+// as github.com/Symantec/keymaster/lib/x.(*T).Method.
+func packageOf(function string) string {
+	slash := strings.LastIndex(function, "/")
+	if index := strings.Index(function[slash+1:], "."); index >= 0 {
+		return function[:slash+1+index]
+	}
+	return function
+}
+
+func (l *Logger) enabled(level Level, module string) bool {
+	levels := l.levels.Load().(*levels)
+	minimum, ok := levels.modules[module]
+	if !ok {
+		minimum = levels.defaultLevel
+	}
+	return level >= minimum
+}
+
+func (l *Logger) verbose(verbosity uint8) bool {
+	return uint32(verbosity) <= atomic.LoadUint32(l.verbosity)
+}
+
+func sprint(newline bool, v []interface{}) string {
+	if newline {
+		return fmt.Sprintln(v...)
+	}
+	return fmt.Sprint(v...)
+}
+
+func sprintf(format string, v []interface{}) string {
+	return fmt.Sprintf(format, v...)
+}
+
+func (l *Logger) logf(level Level, module string, format string,
+	v []interface{}) {
+	if l.enabled(level, module) {
+		l.write(level, l.line(level.String(), module, sprintf(format, v)))
+	}
+}
+
+func (l *Logger) logln(level Level, module string, newline bool,
+	v []interface{}) {
+	if l.enabled(level, module) {
+		l.write(level, l.line(level.String(), module, sprint(newline, v)))
+	}
+}
+
+func (l *Logger) write(level Level, line string) {
+	if printer, ok := l.dest.(LevelPrinter); ok {
+		printer.PrintLevel(level, line)
+		return
+	}
+	l.dest.Print(line)
+}
+
+// line formats a message in logfmt.
+func (l *Logger) line(levelName string, module string, text string) string {
+	var builder strings.Builder
+	builder.WriteString("level=")
+	builder.WriteString(levelName)
+	if module != "" {
+		appendField(&builder, "module", module)
+	}
+	if l.fields != nil {
+		l.fields.appendTo(&builder)
+	}
+	appendField(&builder, "msg", strings.TrimRight(text, "\n"))
+	return builder.String()
+}
+
+func appendField(builder *strings.Builder, key, value string) {
+	builder.WriteByte(' ')
+	builder.WriteString(key)
+	builder.WriteByte('=')
+	if needsQuoting(value) {
+		builder.WriteString(strconv.Quote(value))
+	} else {
+		builder.WriteString(value)
+	}
+}
+
+func needsQuoting(value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, c := range value {
+		if c <= ' ' || c == '"' || c == '=' || c == '\\' || c > '~' {
+			return true
+		}
+	}
+	return false
+}
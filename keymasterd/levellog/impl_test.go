@@ -0,0 +1,101 @@
+package levellog
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// recorder keeps the printed lines and their levels.
+type recorder struct {
+	lines  []string
+	levels []Level
+}
+
+func (r *recorder) Fatal(v ...interface{})                 { r.Print(v...) }
+func (r *recorder) Fatalf(format string, v ...interface{}) { r.Printf(format, v...) }
+func (r *recorder) Fatalln(v ...interface{})               { r.Print(v...) }
+func (r *recorder) Panic(v ...interface{})                 { r.Print(v...) }
+func (r *recorder) Panicf(format string, v ...interface{}) { r.Printf(format, v...) }
+func (r *recorder) Panicln(v ...interface{})               { r.Print(v...) }
+func (r *recorder) Print(v ...interface{})                 { r.lines = append(r.lines, fmt.Sprint(v...)) }
+func (r *recorder) Printf(format string, v ...interface{}) { r.Print(fmt.Sprintf(format, v...)) }
+func (r *recorder) Println(v ...interface{})               { r.Print(v...) }
+
+type levelRecorder struct {
+	recorder
+}
+
+func (r *levelRecorder) PrintLevel(level Level, line string) {
+	r.lines = append(r.lines, line)
+	r.levels = append(r.levels, level)
+}
+
+func TestLevels(t *testing.T) {
+	dest := &recorder{}
+	logger := New(dest)
+	logger.Debugf(1, "hidden %d", 1)
+	logger.Printf("shown %d", 2)
+	logger.SetLevels(Warn, map[string]Level{"custom": Debug})
+	logger.Infof("hidden")
+	logger.Errorf("error: %s", "disk full")
+	custom := logger.Module("custom")
+	custom.Debugln(1, "hidden")
+	logger.SetDebugVerbosity(3)
+	custom.Debugln(3, "debug", "line")
+	custom.Debugln(4, "hidden")
+	logger.Module("other").Warnf("x=%d", 1)
+	expected := []string{
+		"level=info module=impl_test msg=\"shown 2\"",
+		"level=error module=impl_test msg=\"error: disk full\"",
+		"level=debug module=custom msg=\"debug line\"",
+		"level=warn module=other msg=\"x=1\"",
+	}
+	if !reflect.DeepEqual(dest.lines, expected) {
+		t.Errorf("lines:\n%q\nexpected:\n%q", dest.lines, expected)
+	}
+	logger.Fatalf("bye")
+	if last := dest.lines[len(dest.lines)-1]; last !=
+		"level=fatal module=impl_test msg=bye" {
+		t.Errorf("fatal line: %q", last)
+	}
+}
+
+func TestFields(t *testing.T) {
+	dest := &levelRecorder{}
+	fields := NewFields("request_id", "abc", "client_ip", "192.0.2.1")
+	ctx := NewContext(context.Background(), fields)
+	logger := New(dest).WithFields(FromContext(ctx))
+	fields.Set("user", "alice")
+	logger.Warnf("denied")
+	if user := FromContext(ctx).Get("user"); user != "alice" {
+		t.Errorf("user: %q", user)
+	}
+	if FromContext(context.Background()) != nil {
+		t.Error("fields in an empty context")
+	}
+	if New(dest).WithFields(nil).fields != nil {
+		t.Error("nil fields set")
+	}
+	expected := "level=warn module=impl_test request_id=abc " +
+		"client_ip=192.0.2.1 user=alice msg=denied"
+	if len(dest.lines) != 1 || dest.lines[0] != expected ||
+		dest.levels[0] != Warn {
+		t.Errorf("lines: %q %v", dest.lines, dest.levels)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, name := range []string{"debug", "INFO", "warn", "error"} {
+		level, err := ParseLevel(name)
+		if err != nil {
+			t.Error(err)
+		} else if level.String() != levelNames[level] {
+			t.Errorf("%s: %s", name, level)
+		}
+	}
+	if _, err := ParseLevel("trace"); err == nil {
+		t.Error("unknown level accepted")
+	}
+}
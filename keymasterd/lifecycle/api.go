@@ -0,0 +1,114 @@
+// Package lifecycle initializes, starts, health checks and stops the
+// components of keymasterd (storage, authenticators, notifiers, listeners...)
+// in dependency order.
+package lifecycle
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+// Component is a part of the server managed by a Manager.
+type Component interface {
+	// Init prepares the component. It must not start background work.
+	Init() error
+	// Start begins the work of the component. Start may block until the
+	// component is ready (e.g. until the CA is unsealed).
+	Start() error
+	// HealthCheck returns an error if the component is not working.
+	HealthCheck() error
+	// Stop releases the resources of the component.
+	Stop() error
+}
+
+// Funcs adapts a set of functions to a Component. Nil functions are no-ops.
+type Funcs struct {
+	InitFunc        func() error
+	StartFunc       func() error
+	HealthCheckFunc func() error
+	StopFunc        func() error
+}
+
+func (f Funcs) Init() error        { return callIfSet(f.InitFunc) }
+func (f Funcs) Start() error       { return callIfSet(f.StartFunc) }
+func (f Funcs) HealthCheck() error { return callIfSet(f.HealthCheckFunc) }
+func (f Funcs) Stop() error        { return callIfSet(f.StopFunc) }
+
+// Status describes the state and health of a component.
+type Status struct {
+	Name      string    `json:"name"`
+	DependsOn []string  `json:"depends_on,omitempty"`
+	State     string    `json:"state"`
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`
+	LastCheck time.Time `json:"last_check"`
+}
+
+// Manager runs the lifecycle of a set of components. Components are
+// initialized and started after their dependencies and stopped before them.
+type Manager struct {
+	logger              log.DebugLogger
+	healthCheckInterval time.Duration
+	mutex               sync.Mutex
+	// Protected by mutex.
+	components  []*component
+	byName      map[string]*component
+	order       []*component // Dependency order, computed by Init.
+	stopChannel chan struct{}
+}
+
+// New creates a Manager which, once started, health checks the components
+// every healthCheckInterval.
+func New(healthCheckInterval time.Duration, logger log.DebugLogger) *Manager {
+	return newManager(healthCheckInterval, logger)
+}
+
+// Register adds a component which depends on the components named in
+// dependsOn. Components must be registered before Init is called.
+func (m *Manager) Register(name string, c Component,
+	dependsOn ...string) error {
+	return m.register(name, c, dependsOn)
+}
+
+// Init initializes all components in dependency order. It fails if a
+// dependency is unknown or if the dependencies form a cycle.
+func (m *Manager) Init() error {
+	return m.init()
+}
+
+// Start starts all initialized components in dependency order and then
+// begins periodic health checks.
+func (m *Manager) Start() error {
+	return m.start()
+}
+
+// Stop stops all initialized or started components in reverse dependency
+// order. All components are stopped even if some fail; the first error is
+// returned.
+func (m *Manager) Stop() error {
+	return m.stop()
+}
+
+// CheckHealth runs the health check of all started components now.
+func (m *Manager) CheckHealth() {
+	m.checkHealth()
+}
+
+// Status returns the status of all components in dependency order.
+func (m *Manager) Status() []Status {
+	return m.status()
+}
+
+// Ready returns true if all components are started and healthy.
+func (m *Manager) Ready() bool {
+	return m.ready()
+}
+
+// ServeHTTP reports the status of all components as JSON, with a 503 status
+// code if not all of them are ready. It is meant to be served at /readyz.
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.serveHTTP(w, r)
+}
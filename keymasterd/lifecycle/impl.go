@@ -0,0 +1,280 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+const (
+	stateRegistered  = "registered"
+	stateInitialized = "initialized"
+	stateStarted     = "started"
+	stateStopped     = "stopped"
+	stateFailed      = "failed"
+)
+
+type component struct {
+	name      string
+	dependsOn []string
+	component Component
+	// Protected by Manager.mutex.
+	state     string
+	lastError error
+	lastCheck time.Time
+}
+
+type readyResponse struct {
+	Ready      bool     `json:"ready"`
+	Components []Status `json:"components"`
+}
+
+func callIfSet(f func() error) error {
+	if f == nil {
+		return nil
+	}
+	return f()
+}
+
+func newManager(healthCheckInterval time.Duration,
+	logger log.DebugLogger) *Manager {
+	return &Manager{
+		logger:              logger,
+		healthCheckInterval: healthCheckInterval,
+		byName:              make(map[string]*component),
+	}
+}
+
+func (m *Manager) register(name string, c Component,
+	dependsOn []string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.order != nil {
+		return fmt.Errorf("cannot register %s after Init", name)
+	}
+	if _, ok := m.byName[name]; ok {
+		return fmt.Errorf("component %s already registered", name)
+	}
+	entry := &component{
+		name:      name,
+		dependsOn: dependsOn,
+		component: c,
+		state:     stateRegistered,
+	}
+	m.components = append(m.components, entry)
+	m.byName[name] = entry
+	return nil
+}
+
+// sortComponents returns the components ordered so that each comes after its
+// dependencies. Ties are broken by registration order.
+func (m *Manager) sortComponents() ([]*component, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[string]int)
+	var order []*component
+	var visit func(c *component) error
+	visit = func(c *component) error {
+		switch marks[c.name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle involving %s", c.name)
+		case visited:
+			return nil
+		}
+		marks[c.name] = visiting
+		for _, name := range c.dependsOn {
+			dependency, ok := m.byName[name]
+			if !ok {
+				return fmt.Errorf("%s depends on unknown component %s",
+					c.name, name)
+			}
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		marks[c.name] = visited
+		order = append(order, c)
+		return nil
+	}
+	for _, c := range m.components {
+		if err := visit(c); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+func (m *Manager) init() error {
+	m.mutex.Lock()
+	order, err := m.sortComponents()
+	if err == nil {
+		m.order = order
+	}
+	m.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, c := range order {
+		if err := c.component.Init(); err != nil {
+			m.setState(c, stateFailed, err)
+			return fmt.Errorf("cannot initialize %s: %s", c.name, err)
+		}
+		m.setState(c, stateInitialized, nil)
+		m.logger.Debugf(1, "lifecycle: initialized %s", c.name)
+	}
+	return nil
+}
+
+func (m *Manager) start() error {
+	for _, c := range m.getOrder() {
+		if m.getState(c) != stateInitialized {
+			continue
+		}
+		if err := c.component.Start(); err != nil {
+			m.setState(c, stateFailed, err)
+			return fmt.Errorf("cannot start %s: %s", c.name, err)
+		}
+		m.setState(c, stateStarted, nil)
+		m.logger.Debugf(1, "lifecycle: started %s", c.name)
+	}
+	m.checkHealth()
+	m.mutex.Lock()
+	if m.stopChannel == nil && m.healthCheckInterval > 0 {
+		m.stopChannel = make(chan struct{})
+		go m.healthCheckLoop(m.stopChannel)
+	}
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *Manager) stop() error {
+	m.mutex.Lock()
+	if m.stopChannel != nil {
+		close(m.stopChannel)
+		m.stopChannel = nil
+	}
+	m.mutex.Unlock()
+	order := m.getOrder()
+	var firstErr error
+	for i := len(order) - 1; i >= 0; i-- {
+		c := order[i]
+		switch m.getState(c) {
+		case stateInitialized, stateStarted, stateFailed:
+		default:
+			continue
+		}
+		err := c.component.Stop()
+		if err != nil {
+			m.logger.Printf("lifecycle: error stopping %s: %s\n", c.name, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("cannot stop %s: %s", c.name, err)
+			}
+		} else {
+			m.logger.Debugf(1, "lifecycle: stopped %s", c.name)
+		}
+		m.setState(c, stateStopped, err)
+	}
+	return firstErr
+}
+
+func (m *Manager) healthCheckLoop(stopChannel <-chan struct{}) {
+	ticker := time.NewTicker(m.healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChannel:
+			return
+		case <-ticker.C:
+			m.checkHealth()
+		}
+	}
+}
+
+func (m *Manager) checkHealth() {
+	for _, c := range m.getOrder() {
+		if m.getState(c) != stateStarted {
+			continue
+		}
+		err := c.component.HealthCheck()
+		if err != nil {
+			m.logger.Debugf(0, "lifecycle: %s is unhealthy: %s", c.name, err)
+		}
+		m.mutex.Lock()
+		c.lastError = err
+		c.lastCheck = time.Now()
+		m.mutex.Unlock()
+	}
+}
+
+func (m *Manager) getOrder() []*component {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.order != nil {
+		return m.order
+	}
+	return m.components
+}
+
+func (m *Manager) getState(c *component) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return c.state
+}
+
+func (m *Manager) setState(c *component, state string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	c.state = state
+	c.lastError = err
+}
+
+func (m *Manager) status() []Status {
+	order := m.getOrder()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	statuses := make([]Status, 0, len(order))
+	for _, c := range order {
+		status := Status{
+			Name:      c.name,
+			DependsOn: c.dependsOn,
+			State:     c.state,
+			Healthy:   c.state == stateStarted && c.lastError == nil,
+			LastCheck: c.lastCheck,
+		}
+		if c.lastError != nil {
+			status.Error = c.lastError.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func (m *Manager) ready() bool {
+	for _, status := range m.status() {
+		if !status.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Manager) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	response := readyResponse{Components: m.status()}
+	response.Ready = true
+	for _, status := range response.Components {
+		if !status.Healthy {
+			response.Ready = false
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !response.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
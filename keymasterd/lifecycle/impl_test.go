@@ -0,0 +1,126 @@
+package lifecycle
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+)
+
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) component(name string, healthErr *error) Funcs {
+	return Funcs{
+		InitFunc: func() error {
+			r.events = append(r.events, "init "+name)
+			return nil
+		},
+		StartFunc: func() error {
+			r.events = append(r.events, "start "+name)
+			return nil
+		},
+		HealthCheckFunc: func() error {
+			if healthErr != nil {
+				return *healthErr
+			}
+			return nil
+		},
+		StopFunc: func() error {
+			r.events = append(r.events, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestDependencyOrder(t *testing.T) {
+	var r recorder
+	m := newManager(0, testlogger.New(t))
+	var storageErr error
+	if err := m.Register("server", r.component("server", nil),
+		"storage", "authenticator"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Register("authenticator", r.component("authenticator", nil),
+		"storage"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Register("storage", r.component("storage", &storageErr)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Register("storage", Funcs{}); err == nil {
+		t.Fatal("duplicate registration should fail")
+	}
+	if m.Ready() {
+		t.Fatal("ready before start")
+	}
+	if err := m.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Ready() {
+		t.Fatalf("not ready: %+v", m.Status())
+	}
+	storageErr = errors.New("database unreachable")
+	m.CheckHealth()
+	if m.Ready() {
+		t.Fatal("ready with unhealthy storage")
+	}
+	rr := httptest.NewRecorder()
+	m.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status %d", rr.Code)
+	}
+	if err := m.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"init storage", "init authenticator", "init server",
+		"start storage", "start authenticator", "start server",
+		"stop server", "stop authenticator", "stop storage",
+	}
+	if !reflect.DeepEqual(r.events, expected) {
+		t.Fatalf("unexpected order %v", r.events)
+	}
+}
+
+func TestDependencyErrors(t *testing.T) {
+	m := newManager(0, testlogger.New(t))
+	m.Register("a", Funcs{}, "b")
+	m.Register("b", Funcs{}, "a")
+	if err := m.Init(); err == nil {
+		t.Fatal("cycle should fail")
+	}
+	m = newManager(0, testlogger.New(t))
+	m.Register("a", Funcs{}, "missing")
+	if err := m.Init(); err == nil {
+		t.Fatal("unknown dependency should fail")
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	var r recorder
+	m := newManager(0, testlogger.New(t))
+	m.Register("storage", r.component("storage", nil))
+	failing := r.component("notifier", nil)
+	failing.StartFunc = func() error { return errors.New("no queue") }
+	m.Register("notifier", failing, "storage")
+	if err := m.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(); err == nil {
+		t.Fatal("start should fail")
+	}
+	m.Stop()
+	expected := []string{"init storage", "init notifier", "start storage",
+		"stop notifier", "stop storage"}
+	if !reflect.DeepEqual(r.events, expected) {
+		t.Fatalf("unexpected order %v", r.events)
+	}
+}
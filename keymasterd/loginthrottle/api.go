@@ -0,0 +1,118 @@
+// Package loginthrottle slows down online password guessing. It counts
+// recent failed logins per username and per client address and computes a
+// delay, doubling with every further failure, to impose on the next attempt.
+// It also remembers (as keyed hashes) the credentials which recently failed
+// so that retries with the same password can be rejected without asking the
+// password backend again.
+//
+// Instances of a cluster can share their counts of failures, so that
+// guessing is throttled the same whichever instance gets the attempts.
+package loginthrottle
+
+import (
+	"sync"
+	"time"
+)
+
+// Params configures the throttle. Zero values select the defaults.
+type Params struct {
+	// FreeFailures is the number of failures before any delay.
+	// Default: 3.
+	FreeFailures int
+	BaseDelay    time.Duration // Delay after the first counted failure. Default: 1 second.
+	MaxDelay     time.Duration // Default: 30 seconds.
+	// Window is how long failures are remembered after the last one.
+	// Default: 15 minutes.
+	Window time.Duration
+}
+
+type failureCount struct {
+	count       int
+	lastFailure time.Time
+}
+
+type credentialFailure struct {
+	username    string
+	lastFailure time.Time
+}
+
+// Count is a number of failures and the time of the last one.
+type Count struct {
+	Count       int
+	LastFailure time.Time
+}
+
+// Counts are the failures recorded by a Throttle within the window, to be
+// passed to the SetPeers method of the throttles of other instances.
+type Counts struct {
+	Users map[string]Count `json:",omitempty"`
+	Addrs map[string]Count `json:",omitempty"`
+	// Cleared holds when the failures of users were last forgotten by
+	// RecordSuccess or Clear.
+	Cleared map[string]time.Time `json:",omitempty"`
+}
+
+type clock interface {
+	Now() time.Time
+}
+
+// Throttle is safe for concurrent use. A nil *Throttle never delays.
+type Throttle struct {
+	params   Params
+	clock    clock
+	key      []byte
+	mutex    sync.Mutex
+	users    map[string]failureCount
+	addrs    map[string]failureCount
+	failures map[string]credentialFailure // Keyed by credential hash.
+	cleared  map[string]time.Time
+	// Failures recorded by other instances.
+	peerUsers map[string]failureCount
+	peerAddrs map[string]failureCount
+}
+
+// New returns a Throttle.
+func New(params Params) *Throttle {
+	return newThrottle(params, kSystemClock)
+}
+
+// Delay returns how long to wait before checking a login for username from
+// address.
+func (t *Throttle) Delay(username, address string) time.Duration {
+	return t.delay(username, address)
+}
+
+// IsKnownFailure returns true if the same username and password failed
+// within the window.
+func (t *Throttle) IsKnownFailure(username string, password []byte) bool {
+	return t.isKnownFailure(username, password)
+}
+
+// RecordFailure records a failed login.
+func (t *Throttle) RecordFailure(username, address string, password []byte) {
+	t.recordFailure(username, address, password)
+}
+
+// RecordSuccess forgets the failures of username. Failures of the address
+// are kept, so that logging into one account does not reset guessing at
+// others.
+func (t *Throttle) RecordSuccess(username string) {
+	t.recordSuccess(username)
+}
+
+// Clear forgets all failures of username, including its failed passwords.
+func (t *Throttle) Clear(username string) {
+	t.clear(username)
+}
+
+// Counts returns the failures recorded by this throttle.
+func (t *Throttle) Counts() Counts {
+	return t.counts()
+}
+
+// SetPeers replaces the failures recorded by other instances, which add to
+// those of this throttle. Failures of a user from before any instance last
+// forgot them are ignored, and forgotten by this throttle too.
+func (t *Throttle) SetPeers(peers []Counts) {
+	t.setPeers(peers)
+}
@@ -0,0 +1,276 @@
+package loginthrottle
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"time"
+)
+
+const (
+	defaultFreeFailures = 3
+	defaultBaseDelay    = time.Second
+	defaultMaxDelay     = 30 * time.Second
+	defaultWindow       = 15 * time.Minute
+	// Past this many entries expired ones are removed on every failure.
+	maxEntries = 100000
+)
+
+type systemClockType struct{}
+
+func (s systemClockType) Now() time.Time {
+	return time.Now()
+}
+
+var (
+	kSystemClock systemClockType
+)
+
+func newThrottle(params Params, clock clock) *Throttle {
+	if params.FreeFailures <= 0 {
+		params.FreeFailures = defaultFreeFailures
+	}
+	if params.BaseDelay <= 0 {
+		params.BaseDelay = defaultBaseDelay
+	}
+	if params.MaxDelay <= 0 {
+		params.MaxDelay = defaultMaxDelay
+	}
+	if params.Window <= 0 {
+		params.Window = defaultWindow
+	}
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return &Throttle{
+		params:   params,
+		clock:    clock,
+		key:      key,
+		users:    make(map[string]failureCount),
+		addrs:    make(map[string]failureCount),
+		failures: make(map[string]credentialFailure),
+		cleared:  make(map[string]time.Time),
+	}
+}
+
+func (t *Throttle) hash(username string, password []byte) string {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write(password)
+	return string(mac.Sum(nil))
+}
+
+func (t *Throttle) delayFor(failures failureCount, now time.Time) time.Duration {
+	if now.Sub(failures.lastFailure) >= t.params.Window {
+		return 0
+	}
+	excess := failures.count - t.params.FreeFailures
+	if excess <= 0 {
+		return 0
+	}
+	delay := t.params.BaseDelay
+	for i := 1; i < excess && delay < t.params.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.params.MaxDelay {
+		delay = t.params.MaxDelay
+	}
+	return delay
+}
+
+func (t *Throttle) delay(username, address string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	now := t.clock.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delay := t.delayFor(t.combine(t.users[username], t.peerUsers[username],
+		now), now)
+	addressDelay := t.delayFor(t.combine(t.addrs[address],
+		t.peerAddrs[address], now), now)
+	if addressDelay > delay {
+		delay = addressDelay
+	}
+	return delay
+}
+
+// combine adds the failures within the window of local and peer.
+func (t *Throttle) combine(local, peer failureCount,
+	now time.Time) failureCount {
+	var combined failureCount
+	for _, failures := range []failureCount{local, peer} {
+		if now.Sub(failures.lastFailure) >= t.params.Window {
+			continue
+		}
+		combined.count += failures.count
+		if failures.lastFailure.After(combined.lastFailure) {
+			combined.lastFailure = failures.lastFailure
+		}
+	}
+	return combined
+}
+
+func (t *Throttle) isKnownFailure(username string, password []byte) bool {
+	if t == nil {
+		return false
+	}
+	hash := t.hash(username, password)
+	now := t.clock.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	failure, ok := t.failures[hash]
+	return ok && now.Sub(failure.lastFailure) < t.params.Window
+}
+
+func (t *Throttle) increment(counts map[string]failureCount, key string,
+	now time.Time) {
+	failures := counts[key]
+	if now.Sub(failures.lastFailure) >= t.params.Window {
+		failures.count = 0
+	}
+	failures.count++
+	failures.lastFailure = now
+	counts[key] = failures
+}
+
+func (t *Throttle) recordFailure(username, address string, password []byte) {
+	if t == nil {
+		return
+	}
+	hash := t.hash(username, password)
+	now := t.clock.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.increment(t.users, username, now)
+	t.increment(t.addrs, address, now)
+	t.failures[hash] = credentialFailure{username: username, lastFailure: now}
+	if len(t.users)+len(t.addrs)+len(t.failures) > maxEntries {
+		t.removeExpired(now)
+	}
+}
+
+func (t *Throttle) recordSuccess(username string) {
+	if t == nil {
+		return
+	}
+	now := t.clock.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.users, username)
+	delete(t.peerUsers, username)
+	t.cleared[username] = now
+}
+
+func (t *Throttle) clear(username string) {
+	if t == nil {
+		return
+	}
+	now := t.clock.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.users, username)
+	delete(t.peerUsers, username)
+	t.cleared[username] = now
+	for hash, failure := range t.failures {
+		if failure.username == username {
+			delete(t.failures, hash)
+		}
+	}
+}
+
+func (t *Throttle) removeExpired(now time.Time) {
+	for _, counts := range []map[string]failureCount{t.users, t.addrs} {
+		for key, failures := range counts {
+			if now.Sub(failures.lastFailure) >= t.params.Window {
+				delete(counts, key)
+			}
+		}
+	}
+	for hash, failure := range t.failures {
+		if now.Sub(failure.lastFailure) >= t.params.Window {
+			delete(t.failures, hash)
+		}
+	}
+	for username, cleared := range t.cleared {
+		if now.Sub(cleared) >= t.params.Window {
+			delete(t.cleared, username)
+		}
+	}
+}
+
+func (t *Throttle) exportCounts(counts map[string]failureCount,
+	now time.Time) map[string]Count {
+	exported := make(map[string]Count)
+	for key, failures := range counts {
+		if now.Sub(failures.lastFailure) < t.params.Window {
+			exported[key] = Count{failures.count, failures.lastFailure}
+		}
+	}
+	return exported
+}
+
+func (t *Throttle) counts() Counts {
+	if t == nil {
+		return Counts{}
+	}
+	now := t.clock.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.removeExpired(now)
+	counts := Counts{
+		Users:   t.exportCounts(t.users, now),
+		Addrs:   t.exportCounts(t.addrs, now),
+		Cleared: make(map[string]time.Time),
+	}
+	for username, cleared := range t.cleared {
+		counts.Cleared[username] = cleared
+	}
+	return counts
+}
+
+func addCount(counts map[string]failureCount, key string, count Count) {
+	failures := counts[key]
+	failures.count += count.Count
+	if count.LastFailure.After(failures.lastFailure) {
+		failures.lastFailure = count.LastFailure
+	}
+	counts[key] = failures
+}
+
+func (t *Throttle) setPeers(peers []Counts) {
+	if t == nil {
+		return
+	}
+	now := t.clock.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, peer := range peers {
+		for username, cleared := range peer.Cleared {
+			if cleared.After(t.cleared[username]) &&
+				now.Sub(cleared) < t.params.Window {
+				t.cleared[username] = cleared
+			}
+		}
+	}
+	for username, cleared := range t.cleared {
+		if failures, ok := t.users[username]; ok &&
+			!failures.lastFailure.After(cleared) {
+			delete(t.users, username)
+		}
+	}
+	t.peerUsers = make(map[string]failureCount)
+	t.peerAddrs = make(map[string]failureCount)
+	for _, peer := range peers {
+		for username, count := range peer.Users {
+			if count.LastFailure.After(t.cleared[username]) {
+				addCount(t.peerUsers, username, count)
+			}
+		}
+		for address, count := range peer.Addrs {
+			addCount(t.peerAddrs, address, count)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package loginthrottle
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestThrottle(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	throttle := newThrottle(Params{FreeFailures: 2, BaseDelay: time.Second,
+		MaxDelay: 4 * time.Second, Window: time.Minute}, clock)
+	expected := []time.Duration{0, 0, 0, time.Second, 2 * time.Second,
+		4 * time.Second, 4 * time.Second}
+	for index, delay := range expected {
+		if got := throttle.Delay("alice", "10.0.0.1"); got != delay {
+			t.Fatalf("attempt %d: delay %s, expected %s", index, got, delay)
+		}
+		throttle.RecordFailure("alice", "10.0.0.1", []byte("guess"))
+	}
+	if !throttle.IsKnownFailure("alice", []byte("guess")) {
+		t.Fatal("failed password should be known")
+	}
+	if throttle.IsKnownFailure("alice", []byte("other")) {
+		t.Fatal("other password should not be known")
+	}
+	// The address is still throttled for other users.
+	if throttle.Delay("bob", "10.0.0.1") == 0 {
+		t.Fatal("address should be throttled")
+	}
+	if throttle.Delay("alice", "10.0.0.2") == 0 {
+		t.Fatal("username should be throttled")
+	}
+	throttle.RecordFailure("carol", "10.0.0.3", []byte("guess"))
+	throttle.Clear("carol")
+	if throttle.IsKnownFailure("carol", []byte("guess")) {
+		t.Fatal("cleared failure should be forgotten")
+	}
+	throttle.RecordSuccess("alice")
+	if throttle.Delay("alice", "10.0.0.2") != 0 {
+		t.Fatal("success should reset the username")
+	}
+	clock.now = clock.now.Add(time.Minute)
+	if throttle.Delay("bob", "10.0.0.1") != 0 {
+		t.Fatal("failures should expire")
+	}
+	if throttle.IsKnownFailure("alice", []byte("guess")) {
+		t.Fatal("failed password should expire")
+	}
+	var nilThrottle *Throttle
+	nilThrottle.RecordFailure("alice", "10.0.0.1", nil)
+	if nilThrottle.Delay("alice", "10.0.0.1") != 0 {
+		t.Fatal("nil throttle should not delay")
+	}
+}
+
+func TestPeers(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	params := Params{FreeFailures: 2, BaseDelay: time.Second,
+		MaxDelay: 4 * time.Second, Window: time.Minute}
+	a := newThrottle(params, clock)
+	b := newThrottle(params, clock)
+	for i := 0; i < 2; i++ {
+		a.RecordFailure("alice", "10.0.0.1", []byte("guess"))
+		b.RecordFailure("alice", "10.0.0.2", []byte("guess"))
+	}
+	if a.Delay("alice", "10.0.0.3") != 0 {
+		t.Fatal("free failures delayed")
+	}
+	a.SetPeers([]Counts{b.Counts()})
+	if got := a.Delay("alice", "10.0.0.3"); got != 2*time.Second {
+		t.Fatalf("delay with peer failures: %s", got)
+	}
+	if got := a.Delay("bob", "10.0.0.2"); got != 0 {
+		t.Fatalf("address delay with peer failures: %s", got)
+	}
+	// Clearing on one instance clears the others.
+	clock.now = clock.now.Add(time.Second)
+	b.Clear("alice")
+	a.SetPeers([]Counts{b.Counts()})
+	if got := a.Delay("alice", "10.0.0.3"); got != 0 {
+		t.Fatalf("delay after clearing on the peer: %s", got)
+	}
+	if counts := a.Counts(); len(counts.Users) != 0 ||
+		counts.Addrs["10.0.0.1"].Count != 2 {
+		t.Fatalf("counts after clearing: %+v", counts)
+	}
+	clock.now = clock.now.Add(time.Minute)
+	a.SetPeers([]Counts{b.Counts()})
+	if counts := a.Counts(); len(counts.Addrs) != 0 ||
+		len(counts.Cleared) != 0 {
+		t.Fatalf("counts after the window: %+v", counts)
+	}
+}
@@ -0,0 +1,75 @@
+// Package metricshistory keeps a short in-memory history of a few metric
+// values, for sites which do not run Prometheus but still want to see how
+// issuance and authentication evolved over the last hours. Each metric is
+// kept in a fixed size ring buffer, so memory use does not grow with uptime.
+package metricshistory
+
+import (
+	"sync"
+	"time"
+)
+
+// Point is the value of a metric at Time.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Series is the history of one metric, oldest point first. For counters
+// Value is the running total; Increases gives the change per sample.
+type Series struct {
+	Name    string  `json:"name"`
+	Counter bool    `json:"counter"`
+	Points  []Point `json:"points"`
+}
+
+// Sample is the value of a metric when it was sampled.
+type Sample struct {
+	Name    string
+	Counter bool
+	Value   float64
+}
+
+type ring struct {
+	counter bool
+	points  []Point
+	next    int
+	full    bool
+}
+
+// History is safe for concurrent use.
+type History struct {
+	capacity int
+	mutex    sync.Mutex
+	series   map[string]*ring
+}
+
+// New returns a History keeping the last capacity points of every metric.
+func New(capacity int) *History {
+	return newHistory(capacity)
+}
+
+// Record appends the samples taken at t. Once a metric has capacity points
+// its oldest one is dropped.
+func (h *History) Record(t time.Time, samples []Sample) {
+	h.record(t, samples)
+}
+
+// Series returns the points at or after since of every metric, sorted by
+// name.
+func (h *History) Series(since time.Time) []Series {
+	return h.getSeries(since)
+}
+
+// Increases returns the change of a counter between consecutive points. A
+// decrease is taken to be a counter reset, and the new value counts as the
+// increase.
+func Increases(points []Point) []Point {
+	return increases(points)
+}
+
+// Sparkline renders values as an inline SVG line chart of width by height
+// pixels, scaled to the range of the values.
+func Sparkline(points []Point, width, height int) string {
+	return sparkline(points, width, height)
+}
@@ -0,0 +1,117 @@
+package metricshistory
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+func newHistory(capacity int) *History {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &History{capacity: capacity, series: make(map[string]*ring)}
+}
+
+func (r *ring) add(point Point) {
+	if len(r.points) < cap(r.points) {
+		r.points = append(r.points, point)
+		return
+	}
+	r.points[r.next] = point
+	r.next = (r.next + 1) % len(r.points)
+	r.full = true
+}
+
+// ordered returns the points oldest first.
+func (r *ring) ordered() []Point {
+	if !r.full {
+		return r.points
+	}
+	points := make([]Point, 0, len(r.points))
+	points = append(points, r.points[r.next:]...)
+	return append(points, r.points[:r.next]...)
+}
+
+func (h *History) record(t time.Time, samples []Sample) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, sample := range samples {
+		series, ok := h.series[sample.Name]
+		if !ok {
+			series = &ring{points: make([]Point, 0, h.capacity)}
+			h.series[sample.Name] = series
+		}
+		series.counter = sample.Counter
+		series.add(Point{Time: t, Value: sample.Value})
+	}
+}
+
+func (h *History) getSeries(since time.Time) []Series {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	result := make([]Series, 0, len(h.series))
+	for name, series := range h.series {
+		var points []Point
+		for _, point := range series.ordered() {
+			if !point.Time.Before(since) {
+				points = append(points, point)
+			}
+		}
+		result = append(result, Series{Name: name, Counter: series.counter,
+			Points: points})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func increases(points []Point) []Point {
+	if len(points) < 2 {
+		return nil
+	}
+	result := make([]Point, 0, len(points)-1)
+	for index := 1; index < len(points); index++ {
+		increase := points[index].Value - points[index-1].Value
+		if increase < 0 {
+			increase = points[index].Value
+		}
+		result = append(result, Point{Time: points[index].Time,
+			Value: increase})
+	}
+	return result
+}
+
+func sparkline(points []Point, width, height int) string {
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, `<svg width="%d" height="%d" viewBox="0 0 %d %d">`,
+		width, height, width, height)
+	if len(points) > 1 {
+		min, max := points[0].Value, points[0].Value
+		for _, point := range points {
+			if point.Value < min {
+				min = point.Value
+			}
+			if point.Value > max {
+				max = point.Value
+			}
+		}
+		fmt.Fprint(buffer, `<polyline fill="none" stroke="steelblue" points="`)
+		for index, point := range points {
+			x := float64(index) * float64(width-1) / float64(len(points)-1)
+			y := float64(height-1) / 2
+			if max > min {
+				y = float64(height-1) * (max - point.Value) / (max - min)
+			}
+			if index > 0 {
+				buffer.WriteByte(' ')
+			}
+			fmt.Fprintf(buffer, "%.1f,%.1f", x, y)
+		}
+		fmt.Fprint(buffer, `"/>`)
+	}
+	fmt.Fprint(buffer, "</svg>")
+	return buffer.String()
+}
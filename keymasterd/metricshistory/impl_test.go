@@ -0,0 +1,61 @@
+package metricshistory
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	history := New(3)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for index := 0; index < 5; index++ {
+		history.Record(start.Add(time.Duration(index)*time.Minute), []Sample{
+			{Name: "issued", Counter: true, Value: float64(index * 2)},
+			{Name: "gauge", Value: float64(10 - index)},
+		})
+	}
+	series := history.Series(time.Time{})
+	if len(series) != 2 || series[0].Name != "gauge" ||
+		series[1].Name != "issued" || !series[1].Counter {
+		t.Fatalf("unexpected series: %+v", series)
+	}
+	points := series[1].Points
+	if len(points) != 3 || points[0].Value != 4 || points[2].Value != 8 ||
+		!points[0].Time.Equal(start.Add(2*time.Minute)) {
+		t.Fatalf("oldest points not dropped: %+v", points)
+	}
+	series = history.Series(start.Add(4 * time.Minute))
+	if len(series[0].Points) != 1 || series[0].Points[0].Value != 6 {
+		t.Errorf("since not applied: %+v", series[0].Points)
+	}
+}
+
+func TestIncreases(t *testing.T) {
+	var points []Point
+	for _, value := range []float64{3, 5, 5, 2, 4} {
+		points = append(points, Point{Value: value})
+	}
+	expected := []float64{2, 0, 2, 2}
+	increases := Increases(points)
+	if len(increases) != len(expected) {
+		t.Fatalf("got %d increases", len(increases))
+	}
+	for index, increase := range increases {
+		if increase.Value != expected[index] {
+			t.Errorf("increase %d: got %v, want %v", index, increase.Value,
+				expected[index])
+		}
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	points := []Point{{Value: 0}, {Value: 10}, {Value: 5}}
+	svg := Sparkline(points, 101, 21)
+	if !strings.Contains(svg, `points="0.0,20.0 50.0,0.0 100.0,10.0"`) {
+		t.Errorf("unexpected sparkline: %s", svg)
+	}
+	if svg := Sparkline(nil, 10, 10); strings.Contains(svg, "polyline") {
+		t.Errorf("line drawn without points: %s", svg)
+	}
+}
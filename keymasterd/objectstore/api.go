@@ -0,0 +1,63 @@
+// Package objectstore keeps objects in a bucket of an object store with the
+// S3 API: Amazon S3 and compatible stores such as MinIO or Ceph, and Google
+// Cloud Storage through its XML API, authenticated with the HMAC key of a
+// service account. It wraps github.com/minio/minio-go.
+package objectstore
+
+import (
+	"net/http"
+)
+
+// Store gets and puts whole objects. Keys are relative to the bucket.
+type Store interface {
+	// Get returns the object key. The bool is false if there is none.
+	Get(key string) ([]byte, bool, error)
+	// Put creates or replaces the object key.
+	Put(key string, data []byte) error
+	// Delete deletes the object key. Deleting a missing object is not an
+	// error.
+	Delete(key string) error
+	// List returns the keys starting with prefix, sorted.
+	List(prefix string) ([]string, error)
+}
+
+// S3Config configures a Store for Amazon S3 or a compatible store. If
+// AccessKeyID is empty the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables are used.
+type S3Config struct {
+	Bucket string
+	// Region defaults to us-east-1.
+	Region string
+	// Endpoint defaults to https://s3.<Region>.amazonaws.com with the
+	// bucket in the host name. With other endpoints the bucket is in the
+	// path.
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Transport defaults to that of minio-go.
+	Transport http.RoundTripper
+}
+
+// NewS3 returns a Store keeping objects in an S3 bucket.
+func NewS3(config S3Config) (Store, error) {
+	return newS3(config)
+}
+
+// GCSConfig configures a Store for Google Cloud Storage. AccessKeyID and
+// SecretAccessKey are an HMAC key of a service account allowed to read and
+// write the objects of the bucket.
+type GCSConfig struct {
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Endpoint defaults to https://storage.googleapis.com.
+	Endpoint string
+	// Transport defaults to that of minio-go.
+	Transport http.RoundTripper
+}
+
+// NewGCS returns a Store keeping objects in a Cloud Storage bucket.
+func NewGCS(config GCSConfig) (Store, error) {
+	return newGCS(config)
+}
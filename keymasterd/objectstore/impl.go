@@ -0,0 +1,168 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	requestTimeout     = 30 * time.Second
+	maxObjectSize      = 16 << 20
+	s3DefaultRegion    = "us-east-1"
+	gcsDefaultRegion   = "auto"
+	gcsDefaultEndpoint = "https://storage.googleapis.com"
+)
+
+type s3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+func newS3(config S3Config) (*s3Store, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("objectstore: no bucket")
+	}
+	if config.AccessKeyID == "" {
+		config.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		config.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		config.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if config.Region == "" {
+		config.Region = s3DefaultRegion
+	}
+	if config.Endpoint == "" {
+		// The bucket is in the host name.
+		return newStore("https://s3."+config.Region+".amazonaws.com",
+			minio.BucketLookupDNS, config.Bucket, config.Region,
+			config.AccessKeyID, config.SecretAccessKey, config.SessionToken,
+			config.Transport)
+	}
+	return newStore(config.Endpoint, minio.BucketLookupPath, config.Bucket,
+		config.Region, config.AccessKeyID, config.SecretAccessKey,
+		config.SessionToken, config.Transport)
+}
+
+func newGCS(config GCSConfig) (*s3Store, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("objectstore: no bucket")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = gcsDefaultEndpoint
+	}
+	return newStore(config.Endpoint, minio.BucketLookupPath, config.Bucket,
+		gcsDefaultRegion, config.AccessKeyID, config.SecretAccessKey, "",
+		config.Transport)
+}
+
+func newStore(endpoint string, bucketLookup minio.BucketLookupType,
+	bucket, region, accessKeyID, secretAccessKey, sessionToken string,
+	transport http.RoundTripper) (*s3Store, error) {
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, errors.New("objectstore: no credentials")
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("objectstore: %s", err)
+	}
+	if endpointURL.Scheme != "http" && endpointURL.Scheme != "https" {
+		return nil, fmt.Errorf("objectstore: invalid endpoint: %s", endpoint)
+	}
+	client, err := minio.New(endpointURL.Host, &minio.Options{
+		Creds: credentials.NewStaticV4(accessKeyID, secretAccessKey,
+			sessionToken),
+		Secure:       endpointURL.Scheme == "https",
+		Region:       region,
+		BucketLookup: bucketLookup,
+		Transport:    transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("objectstore: %s", err)
+	}
+	return &s3Store{client: client, bucket: bucket}, nil
+}
+
+// storeError returns err of operation with the S3 error code if there is
+// one.
+func storeError(operation string, err error) error {
+	if response := minio.ToErrorResponse(err); response.Code != "" {
+		return fmt.Errorf("objectstore: %s: %s: %s", operation, response.Code,
+			response.Message)
+	}
+	return fmt.Errorf("objectstore: %s: %w", operation, err)
+}
+
+func isNoSuchKey(err error) bool {
+	return minio.ToErrorResponse(err).Code == minio.NoSuchKey
+}
+
+func (s *s3Store) Get(key string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	object, err := s.client.GetObject(ctx, s.bucket, key,
+		minio.GetObjectOptions{})
+	if err != nil {
+		return nil, false, storeError("get "+key, err)
+	}
+	defer object.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(object, maxObjectSize+1))
+	if err != nil {
+		if isNoSuchKey(err) {
+			return nil, false, nil
+		}
+		return nil, false, storeError("get "+key, err)
+	}
+	if len(data) > maxObjectSize {
+		return nil, false, fmt.Errorf("objectstore: %s: too large", key)
+	}
+	return data, true, nil
+}
+
+func (s *s3Store) Put(key string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	_, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data),
+		int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		return storeError("put "+key, err)
+	}
+	return nil
+}
+
+func (s *s3Store) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	err := s.client.RemoveObject(ctx, s.bucket, key,
+		minio.RemoveObjectOptions{})
+	if err != nil && !isNoSuchKey(err) {
+		return storeError("delete "+key, err)
+	}
+	return nil
+}
+
+func (s *s3Store) List(prefix string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	var keys []string
+	for object := range s.client.ListObjects(ctx, s.bucket,
+		minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, storeError("list "+prefix, object.Err)
+		}
+		keys = append(keys, object.Key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
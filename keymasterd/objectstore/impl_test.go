@@ -0,0 +1,184 @@
+package objectstore
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// s3Server is an S3 bucket in memory which lists two keys per page.
+type s3Server struct {
+	bucket  string
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *s3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"),
+		"AWS4-HMAC-SHA256 Credential=AKID/") ||
+		r.Header.Get("X-Amz-Content-Sha256") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<Error><Code>AccessDenied</Code>` +
+			`<Message>denied</Message></Error>`))
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/"+s.bucket+"/") {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`<Error><Code>NoSuchBucket</Code></Error>`))
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+s.bucket+"/")
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch r.Method {
+	case "GET":
+		if key == "" {
+			s.list(w, r)
+			return
+		}
+		data, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<Error><Code>NoSuchKey</Code></Error>`))
+			return
+		}
+		w.Header().Set("Last-Modified",
+			time.Now().UTC().Format(http.TimeFormat))
+		w.Write(data)
+	case "PUT":
+		data, _ := ioutil.ReadAll(r.Body)
+		s.objects[key] = data
+	case "DELETE":
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *s3Server) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	after := r.URL.Query().Get("continuation-token")
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var result listBucketResult
+	if len(keys) > 2 {
+		keys = keys[:2]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, struct {
+			Key string `xml:"Key"`
+		}{key})
+	}
+	data, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		listBucketResult
+	}{listBucketResult: result})
+	w.Write(data)
+}
+
+func TestStore(t *testing.T) {
+	server := &s3Server{bucket: "keymaster", objects: map[string][]byte{}}
+	httpServer := httptest.NewTLSServer(server)
+	defer httpServer.Close()
+	transport := httpServer.Client().Transport
+	for name, newStore := range map[string]func() (Store, error){
+		"s3": func() (Store, error) {
+			return NewS3(S3Config{Bucket: "keymaster",
+				Endpoint: httpServer.URL, AccessKeyID: "AKID",
+				SecretAccessKey: "secret", Transport: transport})
+		},
+		"gcs": func() (Store, error) {
+			return NewGCS(GCSConfig{Bucket: "keymaster",
+				Endpoint: httpServer.URL, AccessKeyID: "AKID",
+				SecretAccessKey: "secret", Transport: transport})
+		},
+	} {
+		server.objects = map[string][]byte{}
+		store, err := newStore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := store.Get("profiles/alice"); err != nil || ok {
+			t.Errorf("%s: missing object: %v %v", name, ok, err)
+		}
+		keys := []string{"profiles/alice", "profiles/b%2Eb", "profiles/c d",
+			"signed/alice.1"}
+		for _, key := range keys {
+			if err := store.Put(key, []byte("data "+key)); err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+		}
+		data, ok, err := store.Get("profiles/b%2Eb")
+		if err != nil || !ok || string(data) != "data profiles/b%2Eb" {
+			t.Errorf("%s: get: %q %v %v", name, data, ok, err)
+		}
+		listed, err := store.List("profiles/")
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !reflect.DeepEqual(listed, keys[:3]) {
+			t.Errorf("%s: listed %v", name, listed)
+		}
+		if err := store.Delete("profiles/alice"); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if err := store.Delete("profiles/alice"); err != nil {
+			t.Errorf("%s: deleting a missing object: %s", name, err)
+		}
+		if _, ok, err := store.Get("profiles/alice"); err != nil || ok {
+			t.Errorf("%s: deleted object: %v %v", name, ok, err)
+		}
+	}
+	store, err := NewS3(S3Config{Bucket: "keymaster",
+		Endpoint: httpServer.URL, AccessKeyID: "other", SecretAccessKey: "x",
+		Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Get("profiles/alice"); err == nil ||
+		!strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewS3(t *testing.T) {
+	if _, err := NewS3(S3Config{AccessKeyID: "AKID",
+		SecretAccessKey: "secret"}); err == nil {
+		t.Error("no bucket accepted")
+	}
+	if _, err := NewGCS(GCSConfig{Bucket: "keymaster"}); err == nil {
+		t.Error("no credentials accepted")
+	}
+	store, err := newS3(S3Config{Bucket: "keymaster", Region: "eu-west-1",
+		AccessKeyID: "AKID", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint := store.client.EndpointURL(); endpoint.Scheme != "https" ||
+		!strings.HasSuffix(endpoint.Host, ".eu-west-1.amazonaws.com") {
+		t.Errorf("endpoint: %s", endpoint)
+	}
+}
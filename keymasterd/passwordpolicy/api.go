@@ -0,0 +1,41 @@
+// Package passwordpolicy checks new passwords against a password policy
+// before they are sent to the directory, so that users get messages saying
+// what to fix rather than a constraint violation from LDAP. The policy is
+// also published so that clients can check passwords as they are typed.
+package passwordpolicy
+
+// Violation rules.
+const (
+	RuleMinLength  = "min_length"
+	RuleComplexity = "complexity"
+	RuleUsername   = "username"
+)
+
+// Policy is a password policy. The zero value accepts any non-empty
+// password.
+type Policy struct {
+	MinLength int `json:"min_length"`
+	// RequireComplexity requires characters of three of the four classes
+	// upper case letters, lower case letters, digits and others, and that
+	// the password does not contain the username, as Active Directory does.
+	RequireComplexity bool `json:"require_complexity"`
+	// HistoryLength is the number of previous passwords which may not be
+	// reused. Only the directory can enforce it.
+	HistoryLength int `json:"history_length"`
+}
+
+// Violation is a rule which a password breaks.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Check returns the rules of p which password breaks, or nil.
+func (p Policy) Check(username, password string) []Violation {
+	return p.check(username, password)
+}
+
+// Merge returns the stricter of the settings of p and other.
+func (p Policy) Merge(other Policy) Policy {
+	return p.merge(other)
+}
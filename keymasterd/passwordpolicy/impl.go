@@ -0,0 +1,80 @@
+package passwordpolicy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Usernames shorter than this may be part of a complex password, like
+// Active Directory.
+const minUsernameCheckLength = 3
+
+func characterClasses(password string) int {
+	var upper, lower, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	var classes int
+	for _, present := range []bool{upper, lower, digit, other} {
+		if present {
+			classes++
+		}
+	}
+	return classes
+}
+
+func (p Policy) check(username, password string) []Violation {
+	var violations []Violation
+	minLength := p.MinLength
+	if minLength < 1 {
+		minLength = 1
+	}
+	if length := utf8.RuneCountInString(password); length < minLength {
+		violations = append(violations, Violation{
+			Rule: RuleMinLength,
+			Message: fmt.Sprintf(
+				"Use at least %d characters, this password has %d",
+				minLength, length),
+		})
+	}
+	if !p.RequireComplexity {
+		return violations
+	}
+	if classes := characterClasses(password); classes < 3 {
+		violations = append(violations, Violation{
+			Rule: RuleComplexity,
+			Message: fmt.Sprintf("Use characters of at least 3 of: upper case letters, lower case letters, digits and symbols, this password has %d",
+				classes),
+		})
+	}
+	if len(username) >= minUsernameCheckLength &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		violations = append(violations, Violation{
+			Rule:    RuleUsername,
+			Message: "Do not include your username",
+		})
+	}
+	return violations
+}
+
+func (p Policy) merge(other Policy) Policy {
+	if other.MinLength > p.MinLength {
+		p.MinLength = other.MinLength
+	}
+	p.RequireComplexity = p.RequireComplexity || other.RequireComplexity
+	if other.HistoryLength > p.HistoryLength {
+		p.HistoryLength = other.HistoryLength
+	}
+	return p
+}
@@ -0,0 +1,63 @@
+package passwordpolicy
+
+import (
+	"testing"
+)
+
+func violatedRules(violations []Violation) map[string]bool {
+	rules := make(map[string]bool)
+	for _, violation := range violations {
+		if violation.Message == "" {
+			panic("violation without message")
+		}
+		rules[violation.Rule] = true
+	}
+	return rules
+}
+
+func TestCheck(t *testing.T) {
+	if violations := (Policy{}).Check("user", "x"); len(violations) != 0 {
+		t.Fatalf("zero policy rejected password: %v", violations)
+	}
+	if !violatedRules((Policy{}).Check("user", ""))[RuleMinLength] {
+		t.Fatal("zero policy accepted empty password")
+	}
+	policy := Policy{MinLength: 10, RequireComplexity: true}
+	for _, test := range []struct {
+		password string
+		rules    []string
+	}{
+		{"Correct-Horse7", nil},
+		{"Short1!", []string{RuleMinLength}},
+		{"alllowercaseletters", []string{RuleComplexity}},
+		{"Ünïcödé-pässwörd", nil},
+		{"MyAlice-Password1", []string{RuleUsername}},
+		{"alice", []string{RuleMinLength, RuleComplexity, RuleUsername}},
+	} {
+		rules := violatedRules(policy.Check("alice", test.password))
+		if len(rules) != len(test.rules) {
+			t.Errorf("%s: violated %v, expected %v", test.password, rules,
+				test.rules)
+			continue
+		}
+		for _, rule := range test.rules {
+			if !rules[rule] {
+				t.Errorf("%s: violated %v, expected %v", test.password, rules,
+					test.rules)
+			}
+		}
+	}
+	// Short usernames are allowed, as in Active Directory.
+	if rules := violatedRules(policy.Check("al", "Always-Ready1")); rules[RuleUsername] {
+		t.Fatal("short username rejected")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	merged := Policy{MinLength: 12, HistoryLength: 3}.Merge(
+		Policy{MinLength: 8, RequireComplexity: true, HistoryLength: 24})
+	if merged != (Policy{MinLength: 12, RequireComplexity: true,
+		HistoryLength: 24}) {
+		t.Fatalf("unexpected merge: %+v", merged)
+	}
+}
@@ -0,0 +1,58 @@
+// Package policyversions keeps every version of the issuance policy so that
+// the effect of a change can be compared against earlier versions before it
+// is approved.
+package policyversions
+
+import (
+	"sync"
+	"time"
+)
+
+// Version is one stored version of the policy.
+type Version struct {
+	ID      uint64
+	Created time.Time
+	// Source says where the version came from, e.g. "config" for versions
+	// loaded at startup or "proposed" for versions submitted for review.
+	Source  string
+	Comment string `json:",omitempty"`
+	SHA256  string
+	Policy  []byte
+}
+
+// Store is a directory of versions. Methods are safe for concurrent use.
+type Store struct {
+	directory string
+	mutex     sync.Mutex
+	versions  []Version // Ordered by ID.
+}
+
+// Open opens the store in directory, creating it if needed.
+func Open(directory string) (*Store, error) {
+	return openStore(directory)
+}
+
+// Add stores policy as a new version unless the latest version from source is
+// identical, in which case that version is returned. Identical policies from
+// other sources do not count, so that Latest(source) is always the policy
+// last added from source. The returned bool is true if a new version was
+// stored.
+func (s *Store) Add(policy []byte, source, comment string) (Version, bool,
+	error) {
+	return s.add(policy, source, comment)
+}
+
+// Get returns the version with the given ID.
+func (s *Store) Get(id uint64) (Version, bool) {
+	return s.get(id)
+}
+
+// Latest returns the most recent version from source.
+func (s *Store) Latest(source string) (Version, bool) {
+	return s.latest(source)
+}
+
+// List returns all versions, oldest first.
+func (s *Store) List() []Version {
+	return s.list()
+}
@@ -0,0 +1,116 @@
+package policyversions
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const versionSuffix = ".json"
+
+func openStore(directory string) (*Store, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	fileInfos, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{directory: directory}
+	for _, fileInfo := range fileInfos {
+		if !strings.HasSuffix(fileInfo.Name(), versionSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(directory, fileInfo.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var version Version
+		if err := json.Unmarshal(data, &version); err != nil {
+			return nil, fmt.Errorf("policyversions: %s: %s", fileInfo.Name(),
+				err)
+		}
+		s.versions = append(s.versions, version)
+	}
+	sort.Slice(s.versions, func(i, j int) bool {
+		return s.versions[i].ID < s.versions[j].ID
+	})
+	return s, nil
+}
+
+func (s *Store) add(policy []byte, source, comment string) (Version, bool,
+	error) {
+	hash := fmt.Sprintf("%x", sha256.Sum256(policy))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if latest, ok := s.latestLocked(source); ok && latest.SHA256 == hash {
+		return latest, false, nil
+	}
+	version := Version{
+		ID:      1,
+		Created: time.Now().UTC(),
+		Source:  source,
+		Comment: comment,
+		SHA256:  hash,
+		Policy:  policy,
+	}
+	if len(s.versions) > 0 {
+		version.ID = s.versions[len(s.versions)-1].ID + 1
+	}
+	data, err := json.MarshalIndent(version, "", "    ")
+	if err != nil {
+		return Version{}, false, err
+	}
+	filename := filepath.Join(s.directory,
+		fmt.Sprintf("%d%s", version.ID, versionSuffix))
+	tmpFilename := filename + "~"
+	if err := ioutil.WriteFile(tmpFilename, data, 0600); err != nil {
+		return Version{}, false, err
+	}
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		return Version{}, false, err
+	}
+	s.versions = append(s.versions, version)
+	return version, true, nil
+}
+
+func (s *Store) get(id uint64) (Version, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	index := sort.Search(len(s.versions), func(i int) bool {
+		return s.versions[i].ID >= id
+	})
+	if index < len(s.versions) && s.versions[index].ID == id {
+		return s.versions[index], true
+	}
+	return Version{}, false
+}
+
+func (s *Store) latest(source string) (Version, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.latestLocked(source)
+}
+
+func (s *Store) latestLocked(source string) (Version, bool) {
+	for index := len(s.versions) - 1; index >= 0; index-- {
+		if s.versions[index].Source == source {
+			return s.versions[index], true
+		}
+	}
+	return Version{}, false
+}
+
+func (s *Store) list() []Version {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	versions := make([]Version, len(s.versions))
+	copy(versions, s.versions)
+	return versions
+}
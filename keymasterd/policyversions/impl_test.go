@@ -0,0 +1,95 @@
+package policyversions
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policyversions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, added, err := store.Add([]byte("a: 1\n"), "config", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !added || first.ID != 1 {
+		t.Fatalf("unexpected first version: %+v", first)
+	}
+	again, added, err := store.Add([]byte("a: 1\n"), "config", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added || again.ID != first.ID {
+		t.Fatal("identical policy should not be stored twice")
+	}
+	second, added, err := store.Add([]byte("a: 2\n"), "proposed", "bump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !added || second.ID != 2 {
+		t.Fatalf("unexpected second version: %+v", second)
+	}
+	if latest, ok := store.Latest("config"); !ok || latest.ID != 1 {
+		t.Fatalf("unexpected latest config version: %+v", latest)
+	}
+	// Versions survive a restart.
+	store, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, ok := store.Get(2)
+	if !ok || string(version.Policy) != "a: 2\n" || version.Comment != "bump" {
+		t.Fatalf("unexpected version after reopen: %+v", version)
+	}
+	if _, ok := store.Get(3); ok {
+		t.Fatal("version 3 should not exist")
+	}
+	if versions := store.List(); len(versions) != 2 {
+		t.Fatalf("expected 2 versions, got %d", len(versions))
+	}
+}
+
+func TestDeployProposed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policyversions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		policy, source string
+		id             uint64
+		added          bool
+	}{
+		{"a: 1\n", "config", 1, true},
+		{"a: 2\n", "proposed", 2, true},
+		// Deploying the proposal is a new config version.
+		{"a: 2\n", "config", 3, true},
+		{"a: 2\n", "config", 3, false},
+		// So is going back to an earlier one.
+		{"a: 1\n", "config", 4, true},
+	} {
+		version, added, err := store.Add([]byte(step.policy), step.source, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version.ID != step.id || added != step.added {
+			t.Fatalf("%+v: got %d %v", step, version.ID, added)
+		}
+		if latest, ok := store.Latest(step.source); !ok ||
+			latest.ID != step.id {
+			t.Fatalf("%+v: latest %+v", step, latest)
+		}
+	}
+}
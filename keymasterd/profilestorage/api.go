@@ -0,0 +1,92 @@
+// Package profilestorage stores the state keymaster keeps per user: the
+// profiles, holding registered U2F tokens, TOTP secrets and backup codes,
+// and expiring signed records. Profiles and records are opaque to this
+// package, which only stores them.
+//
+// Backends keep the data in SQLite, in PostgreSQL, in a directory, which
+// may be shared by several instances, e.g. over NFS, or in an object store
+// such as S3. With PostgreSQL, a shared directory or an object store all the
+// instances of an HA deployment see the same state.
+package profilestorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/objectstore"
+)
+
+// SignedRecord is an expiring record of Username. Records are identified by
+// their user and Type; the data is signed by the caller.
+type SignedRecord struct {
+	Username        string
+	Type            int
+	Data            string
+	ExpirationEpoch int64
+	UpdateEpoch     int64
+}
+
+// Backend stores profiles and signed records. Saving replaces the previous
+// profile or record. Backends are safe for concurrent use.
+type Backend interface {
+	// LoadProfile returns the profile of username. The bool is false if
+	// there is none.
+	LoadProfile(username string) ([]byte, bool, error)
+	SaveProfile(username string, profile []byte) error
+	// Usernames returns the users with a profile, sorted.
+	Usernames() ([]string, error)
+	// LoadSigned returns the data of the record of username and dataType if
+	// it has not expired at now. The bool is false if there is none.
+	LoadSigned(username string, dataType int, now time.Time) (string, bool,
+		error)
+	// SignedRecords returns the records which have not expired at now.
+	SignedRecords(now time.Time) ([]SignedRecord, error)
+	SaveSigned(record SignedRecord) error
+	DeleteSigned(username string, dataType int) error
+	// DeleteExpired deletes the records which expired before now.
+	DeleteExpired(now time.Time) error
+	// Ping checks that the backend can be reached.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// OpenSQLite opens the SQLite database in filename, creating it if needed.
+func OpenSQLite(filename string) (Backend, error) {
+	return openSQL(sqliteDialect, filename)
+}
+
+// OpenPostgreSQL opens the PostgreSQL database at url, creating the tables
+// if needed.
+func OpenPostgreSQL(url string) (Backend, error) {
+	return openSQL(postgresDialect, url)
+}
+
+// OpenDirectory opens a backend keeping one file per profile and record in
+// dirname, which is created if needed. Files are replaced atomically, so
+// several instances may share dirname; concurrent saves of the same profile
+// keep the last one.
+func OpenDirectory(dirname string) (Backend, error) {
+	return openDirectory(dirname)
+}
+
+// OpenObjectStore opens a backend keeping one object per profile and record
+// in store, with keys starting with prefix. Like with OpenDirectory several
+// instances may share the objects and concurrent saves of the same profile
+// keep the last one.
+func OpenObjectStore(store objectstore.Store, prefix string) Backend {
+	return &blobBackend{store: store, prefix: prefix}
+}
+
+// IsUnreachable returns true if err is a failure to reach a backend, such as
+// a network error or a timeout, rather than an error of the data or of the
+// request.
+func IsUnreachable(err error) bool {
+	return isUnreachable(err)
+}
+
+// Copy copies the profiles and the records which have not expired at now
+// from source into destination, e.g. to keep a local cache of a remote
+// backend.
+func Copy(source, destination Backend, now time.Time) error {
+	return copyBackend(source, destination, now)
+}
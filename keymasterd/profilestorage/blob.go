@@ -0,0 +1,196 @@
+package profilestorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/objectstore"
+)
+
+// The blob backends keep each profile under profiles/<user> and each signed
+// record under signed/<user>.<type>, with the user names escaped, in a
+// directory or in an object store.
+const (
+	profilesDirname = "profiles"
+	signedDirname   = "signed"
+	pingKey         = "ping"
+)
+
+type blobBackend struct {
+	store  objectstore.Store
+	prefix string
+}
+
+// pinger is implemented by the stores which can be checked more cheaply
+// than by getting an object.
+type pinger interface {
+	ping(ctx context.Context) error
+}
+
+// escapeUsername returns username as a file name: bytes other than ASCII
+// letters, digits, '_', '-' and '@' are written as %XX, so that names cannot
+// contain separators or start with a dot.
+func escapeUsername(username string) string {
+	var builder strings.Builder
+	for i := 0; i < len(username); i++ {
+		c := username[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' || c == '_' || c == '-' || c == '@' {
+			builder.WriteByte(c)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", c)
+		}
+	}
+	return builder.String()
+}
+
+func unescapeUsername(name string) (string, error) {
+	var builder strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			builder.WriteByte(name[i])
+			continue
+		}
+		if i+2 >= len(name) {
+			return "", fmt.Errorf("bad escaped user name: %s", name)
+		}
+		c, err := strconv.ParseUint(name[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("bad escaped user name: %s", name)
+		}
+		builder.WriteByte(byte(c))
+		i += 2
+	}
+	return builder.String(), nil
+}
+
+func (b *blobBackend) profileKey(username string) string {
+	return b.prefix + profilesDirname + "/" + escapeUsername(username)
+}
+
+func (b *blobBackend) signedKey(username string, dataType int) string {
+	return fmt.Sprintf("%s%s/%s.%d", b.prefix, signedDirname,
+		escapeUsername(username), dataType)
+}
+
+func (b *blobBackend) LoadProfile(username string) ([]byte, bool, error) {
+	return b.store.Get(b.profileKey(username))
+}
+
+func (b *blobBackend) SaveProfile(username string, profile []byte) error {
+	return b.store.Put(b.profileKey(username), profile)
+}
+
+func (b *blobBackend) Usernames() ([]string, error) {
+	prefix := b.prefix + profilesDirname + "/"
+	keys, err := b.store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	usernames := make([]string, 0, len(keys))
+	for _, key := range keys {
+		username, err := unescapeUsername(strings.TrimPrefix(key, prefix))
+		if err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, username)
+	}
+	// Escaping changes the order.
+	sort.Strings(usernames)
+	return usernames, nil
+}
+
+func (b *blobBackend) readSigned(key string) (SignedRecord, bool, error) {
+	var record SignedRecord
+	data, ok, err := b.store.Get(key)
+	if err != nil || !ok {
+		return record, ok, err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, false, fmt.Errorf("%s: %s", key, err)
+	}
+	return record, true, nil
+}
+
+func (b *blobBackend) LoadSigned(username string, dataType int,
+	now time.Time) (string, bool, error) {
+	record, ok, err := b.readSigned(b.signedKey(username, dataType))
+	if err != nil || !ok {
+		return "", false, err
+	}
+	if record.ExpirationEpoch <= now.Unix() {
+		return "", false, nil
+	}
+	return record.Data, true, nil
+}
+
+// signedRecords calls fn with the key and content of every record.
+func (b *blobBackend) signedRecords(
+	fn func(key string, record SignedRecord) error) error {
+	keys, err := b.store.List(b.prefix + signedDirname + "/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		record, ok, err := b.readSigned(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue // Deleted by another instance.
+		}
+		if err := fn(key, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *blobBackend) SignedRecords(now time.Time) ([]SignedRecord, error) {
+	var records []SignedRecord
+	err := b.signedRecords(func(key string, record SignedRecord) error {
+		if record.ExpirationEpoch > now.Unix() {
+			records = append(records, record)
+		}
+		return nil
+	})
+	return records, err
+}
+
+func (b *blobBackend) SaveSigned(record SignedRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return b.store.Put(b.signedKey(record.Username, record.Type), data)
+}
+
+func (b *blobBackend) DeleteSigned(username string, dataType int) error {
+	return b.store.Delete(b.signedKey(username, dataType))
+}
+
+func (b *blobBackend) DeleteExpired(now time.Time) error {
+	return b.signedRecords(func(key string, record SignedRecord) error {
+		if record.ExpirationEpoch >= now.Unix() {
+			return nil
+		}
+		return b.store.Delete(key)
+	})
+}
+
+func (b *blobBackend) Ping(ctx context.Context) error {
+	if store, ok := b.store.(pinger); ok {
+		return store.ping(ctx)
+	}
+	_, _, err := b.store.Get(b.prefix + pingKey)
+	return err
+}
+
+func (b *blobBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,115 @@
+package profilestorage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const tmpFilePrefix = ".tmp-"
+
+// directoryStore keeps objects as files under dirname, the slashes of the
+// keys separating directories.
+type directoryStore struct {
+	dirname string
+}
+
+func openDirectory(dirname string) (*blobBackend, error) {
+	for _, subdir := range []string{profilesDirname, signedDirname} {
+		if err := os.MkdirAll(filepath.Join(dirname, subdir), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &blobBackend{store: &directoryStore{dirname: dirname}}, nil
+}
+
+func (s *directoryStore) filename(key string) string {
+	return filepath.Join(s.dirname, filepath.FromSlash(key))
+}
+
+func (s *directoryStore) Get(key string) ([]byte, bool, error) {
+	data, err := ioutil.ReadFile(s.filename(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// Put replaces the file of key, so that readers see either the previous or
+// the new content.
+func (s *directoryStore) Put(key string, data []byte) error {
+	filename := s.filename(key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(filename), tmpFilePrefix)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), filename); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *directoryStore) Delete(key string) error {
+	err := os.Remove(s.filename(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List returns the keys of the files in the directory of prefix, without
+// the temporary files of writes in progress.
+func (s *directoryStore) List(prefix string) ([]string, error) {
+	dir, namePrefix := path.Split(prefix)
+	file, err := os.Open(s.filename(dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	names, err := file.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, name := range names {
+		if strings.HasPrefix(name, namePrefix) &&
+			!strings.HasPrefix(name, tmpFilePrefix) {
+			keys = append(keys, dir+name)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *directoryStore) ping(ctx context.Context) error {
+	_, err := os.Stat(filepath.Join(s.dirname, profilesDirname))
+	return err
+}
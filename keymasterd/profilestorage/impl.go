@@ -0,0 +1,56 @@
+package profilestorage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"time"
+)
+
+// importer is implemented by the backends which can save many profiles and
+// records at once faster than one at a time.
+type importer interface {
+	importAll(profiles map[string][]byte, records []SignedRecord) error
+}
+
+func copyBackend(source, destination Backend, now time.Time) error {
+	usernames, err := source.Usernames()
+	if err != nil {
+		return err
+	}
+	profiles := make(map[string][]byte, len(usernames))
+	for _, username := range usernames {
+		profile, ok, err := source.LoadProfile(username)
+		if err != nil {
+			return err
+		}
+		if ok {
+			profiles[username] = profile
+		}
+	}
+	records, err := source.SignedRecords(now)
+	if err != nil {
+		return err
+	}
+	if destination, ok := destination.(importer); ok {
+		return destination.importAll(profiles, records)
+	}
+	for username, profile := range profiles {
+		if err := destination.SaveProfile(username, profile); err != nil {
+			return err
+		}
+	}
+	for _, record := range records {
+		if err := destination.SaveSigned(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isUnreachable(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,259 @@
+package profilestorage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testBackend(t *testing.T, backend Backend) {
+	now := time.Unix(1700000000, 0)
+	if _, ok, err := backend.LoadProfile("alice"); err != nil || ok {
+		t.Fatalf("missing profile: %v %v", ok, err)
+	}
+	for _, username := range []string{"alice", "../bob", ".carol"} {
+		if err := backend.SaveProfile(username, []byte("v1 "+username)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backend.SaveProfile("alice", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	profile, ok, err := backend.LoadProfile("alice")
+	if err != nil || !ok || string(profile) != "v2" {
+		t.Errorf("alice: %q %v %v", profile, ok, err)
+	}
+	profile, ok, err = backend.LoadProfile("../bob")
+	if err != nil || !ok || string(profile) != "v1 ../bob" {
+		t.Errorf("../bob: %q %v %v", profile, ok, err)
+	}
+	usernames, err := backend.Usernames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"../bob", ".carol", "alice"}; !reflect.DeepEqual(
+		usernames, expected) {
+		t.Errorf("usernames: %v, expected %v", usernames, expected)
+	}
+
+	for _, record := range []SignedRecord{
+		{"alice", 1, "expired", now.Unix() - 10, now.Unix() - 100},
+		{"alice", 2, "valid", now.Unix() + 10, now.Unix()},
+		{"bob", 1, "valid", now.Unix() + 20, now.Unix()},
+	} {
+		if err := backend.SaveSigned(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, ok, err := backend.LoadSigned("alice", 2, now); err != nil ||
+		!ok || data != "valid" {
+		t.Errorf("alice 2: %q %v %v", data, ok, err)
+	}
+	if _, ok, err := backend.LoadSigned("alice", 1, now); err != nil || ok {
+		t.Errorf("expired record loaded: %v %v", ok, err)
+	}
+	records, err := backend.SignedRecords(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Errorf("unexpected records: %+v", records)
+	}
+	if err := backend.DeleteExpired(now); err != nil {
+		t.Fatal(err)
+	}
+	if records, err := backend.SignedRecords(time.Unix(0, 0)); err != nil ||
+		len(records) != 2 {
+		t.Errorf("after DeleteExpired: %+v %v", records, err)
+	}
+	if err := backend.DeleteSigned("bob", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.DeleteSigned("bob", 1); err != nil {
+		t.Errorf("deleting a missing record: %s", err)
+	}
+	if _, ok, err := backend.LoadSigned("bob", 1, now); err != nil || ok {
+		t.Errorf("deleted record loaded: %v %v", ok, err)
+	}
+	if err := backend.Ping(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profilestorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	backend, err := OpenSQLite(filepath.Join(dir, "profiles.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	testBackend(t, backend)
+}
+
+func TestDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profilestorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	backend, err := OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, backend)
+	names, err := ioutil.ReadDir(filepath.Join(dir, profilesDirname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if name.Name()[0] == '.' {
+			t.Errorf("unescaped file name: %s", name.Name())
+		}
+	}
+	// Another instance sharing the directory sees the same profiles.
+	other, err := OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile, ok, err := other.LoadProfile("alice"); err != nil || !ok ||
+		string(profile) != "v2" {
+		t.Errorf("shared profile: %q %v %v", profile, ok, err)
+	}
+}
+
+// memoryStore is an objectstore.Store in memory.
+type memoryStore struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memoryStore) Get(key string) ([]byte, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, ok := s.objects[key]
+	return data, ok, nil
+}
+
+func (s *memoryStore) Put(key string, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memoryStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memoryStore) List(prefix string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func TestObjectStore(t *testing.T) {
+	store := &memoryStore{objects: map[string][]byte{}}
+	store.Put("other/profiles/mallory", []byte("other"))
+	testBackend(t, OpenObjectStore(store, "keymaster/"))
+	if _, ok := store.objects["keymaster/profiles/alice"]; !ok {
+		t.Errorf("no profile object: %v", store.objects)
+	}
+	if usernames, err := OpenObjectStore(store, "other/").Usernames(); err != nil ||
+		!reflect.DeepEqual(usernames, []string{"mallory"}) {
+		t.Errorf("other prefix: %v %v", usernames, err)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profilestorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source, err := OpenDirectory(filepath.Join(dir, "source"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	destination, err := OpenSQLite(filepath.Join(dir, "cache.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer destination.Close()
+	now := time.Now()
+	if err := source.SaveProfile("alice", []byte("profile")); err != nil {
+		t.Fatal(err)
+	}
+	err = source.SaveSigned(SignedRecord{"alice", 1, "data",
+		now.Unix() + 60, now.Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := Copy(source, destination, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if profile, ok, err := destination.LoadProfile("alice"); err != nil ||
+		!ok || string(profile) != "profile" {
+		t.Errorf("copied profile: %q %v %v", profile, ok, err)
+	}
+	if data, ok, err := destination.LoadSigned("alice", 1, now); err != nil ||
+		!ok || data != "data" {
+		t.Errorf("copied record: %q %v %v", data, ok, err)
+	}
+}
+
+func TestEscapeUsername(t *testing.T) {
+	for _, username := range []string{"alice", "a.b/c%d", "..", "é"} {
+		escaped := escapeUsername(username)
+		unescaped, err := unescapeUsername(escaped)
+		if err != nil || unescaped != username {
+			t.Errorf("%q: %q %q %v", username, escaped, unescaped, err)
+		}
+	}
+	if _, err := unescapeUsername("bad%2"); err == nil {
+		t.Error("truncated escape accepted")
+	}
+}
+
+func TestIsUnreachable(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp",
+		Err: errors.New("connection refused")}
+	for err, expected := range map[error]bool{
+		dialErr:                         true,
+		fmt.Errorf("get: %w", dialErr):  true,
+		driver.ErrBadConn:               true,
+		context.DeadlineExceeded:        true,
+		errors.New("no such table"):     false,
+		fmt.Errorf("get: %s", "denied"): false,
+	} {
+		if IsUnreachable(err) != expected {
+			t.Errorf("%v: expected %v", err, expected)
+		}
+	}
+}
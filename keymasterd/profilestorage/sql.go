@@ -0,0 +1,208 @@
+package profilestorage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type sqlDialect struct {
+	driverName       string
+	createStatements []string
+	loadProfile      string
+	saveProfile      string
+	usernames        string
+	loadSigned       string
+	signedRecords    string
+	saveSigned       string
+	deleteSigned     string
+	deleteExpired    string
+}
+
+var sqliteDialect = &sqlDialect{
+	driverName: "sqlite3",
+	createStatements: []string{
+		`create table if not exists user_profile (id integer not null primary key, username text unique, profile_data blob);`,
+		`create table if not exists expiring_signed_user_data(id integer not null primary key, username text not null, jws_data text not null, type integer not null, expiration_epoch integer not null, update_epoch integer no null, UNIQUE(username,type));`,
+	},
+	loadProfile:   "select profile_data from user_profile where username = ?",
+	saveProfile:   "insert or replace into user_profile(username, profile_data) values(?, ?)",
+	usernames:     "select username from user_profile order by username",
+	loadSigned:    "select jws_data from expiring_signed_user_data where username = ? and type =? and expiration_epoch > ?",
+	signedRecords: "select username, type, jws_data, expiration_epoch, update_epoch from expiring_signed_user_data where expiration_epoch > ?",
+	saveSigned:    "insert or replace into expiring_signed_user_data(username, type, jws_data, expiration_epoch, update_epoch) values(?,?, ?, ?, ?)",
+	deleteSigned:  "delete from expiring_signed_user_data where username = ? and type = ?",
+	deleteExpired: "delete from expiring_signed_user_data where expiration_epoch < ?",
+}
+
+var postgresDialect = &sqlDialect{
+	driverName: "postgres",
+	createStatements: []string{
+		`create table if not exists user_profile (id serial not null primary key, username text unique, profile_data bytea);`,
+		`create table if not exists expiring_signed_user_data(id serial not null primary key, username text not null, jws_data text not null, type integer not null, expiration_epoch integer not null, update_epoch integer not null, UNIQUE(username,type));`,
+	},
+	loadProfile:   "select profile_data from user_profile where username = $1",
+	saveProfile:   "insert into user_profile(username, profile_data) values ($1,$2) on CONFLICT(username) DO UPDATE set  profile_data = excluded.profile_data",
+	usernames:     "select username from user_profile order by username",
+	loadSigned:    "select jws_data from expiring_signed_user_data where username = $1 and type = $2 and expiration_epoch > $3",
+	signedRecords: "select username, type, jws_data, expiration_epoch, update_epoch from expiring_signed_user_data where expiration_epoch > $1",
+	saveSigned:    "insert into expiring_signed_user_data(username, type, jws_data, expiration_epoch, update_epoch) values ($1,$2,$3,$4, $5) ON CONFLICT(username,type) DO UPDATE SET  jws_data = excluded.jws_data, expiration_epoch = excluded.expiration_epoch",
+	deleteSigned:  "delete from expiring_signed_user_data where username = $1 and type = $2",
+	deleteExpired: "delete from expiring_signed_user_data where expiration_epoch < $1",
+}
+
+type sqlBackend struct {
+	dialect *sqlDialect
+	db      *sql.DB
+}
+
+func openSQL(dialect *sqlDialect, dataSourceName string) (*sqlBackend, error) {
+	db, err := sql.Open(dialect.driverName, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	for _, statement := range dialect.createStatements {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &sqlBackend{dialect: dialect, db: db}, nil
+}
+
+// exec runs statement with args in a transaction.
+func (b *sqlBackend) exec(statement string, args ...interface{}) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(statement, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (b *sqlBackend) LoadProfile(username string) ([]byte, bool, error) {
+	var profile []byte
+	err := b.db.QueryRow(b.dialect.loadProfile, username).Scan(&profile)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return profile, true, nil
+}
+
+func (b *sqlBackend) SaveProfile(username string, profile []byte) error {
+	return b.exec(b.dialect.saveProfile, username, profile)
+}
+
+func (b *sqlBackend) Usernames() ([]string, error) {
+	rows, err := b.db.Query(b.dialect.usernames)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var usernames []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, username)
+	}
+	return usernames, rows.Err()
+}
+
+func (b *sqlBackend) LoadSigned(username string, dataType int,
+	now time.Time) (string, bool, error) {
+	var data string
+	err := b.db.QueryRow(b.dialect.loadSigned, username, dataType,
+		now.Unix()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return data, true, nil
+}
+
+func (b *sqlBackend) SignedRecords(now time.Time) ([]SignedRecord, error) {
+	rows, err := b.db.Query(b.dialect.signedRecords, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []SignedRecord
+	for rows.Next() {
+		var record SignedRecord
+		err := rows.Scan(&record.Username, &record.Type, &record.Data,
+			&record.ExpirationEpoch, &record.UpdateEpoch)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (b *sqlBackend) SaveSigned(record SignedRecord) error {
+	return b.exec(b.dialect.saveSigned, record.Username, record.Type,
+		record.Data, record.ExpirationEpoch, record.UpdateEpoch)
+}
+
+func (b *sqlBackend) DeleteSigned(username string, dataType int) error {
+	return b.exec(b.dialect.deleteSigned, username, dataType)
+}
+
+func (b *sqlBackend) DeleteExpired(now time.Time) error {
+	_, err := b.db.Exec(b.dialect.deleteExpired, now.Unix())
+	return err
+}
+
+func (b *sqlBackend) Ping(ctx context.Context) error {
+	return b.db.PingContext(ctx)
+}
+
+func (b *sqlBackend) Close() error {
+	return b.db.Close()
+}
+
+// importAll saves the profiles and records in a single transaction.
+func (b *sqlBackend) importAll(profiles map[string][]byte,
+	records []SignedRecord) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	profileStmt, err := tx.Prepare(b.dialect.saveProfile)
+	if err != nil {
+		return err
+	}
+	defer profileStmt.Close()
+	for username, profile := range profiles {
+		if _, err := profileStmt.Exec(username, profile); err != nil {
+			return err
+		}
+	}
+	signedStmt, err := tx.Prepare(b.dialect.saveSigned)
+	if err != nil {
+		return err
+	}
+	defer signedStmt.Close()
+	for _, record := range records {
+		_, err := signedStmt.Exec(record.Username, record.Type, record.Data,
+			record.ExpirationEpoch, record.UpdateEpoch)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,60 @@
+// Package revocationlist records the serial numbers of revoked certificates.
+// The list is kept in a file with one JSON encoded entry per line and is
+// only ever appended to.
+package revocationlist
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is one revoked certificate. Serial is the decimal serial number, as
+// SSH serials and X.509 serial numbers share the same form then.
+type Entry struct {
+	Serial string
+	Time   time.Time
+	Reason string `json:",omitempty"`
+}
+
+// List is safe for concurrent use. A nil *List revokes nothing.
+type List struct {
+	mutex   sync.Mutex
+	file    *os.File
+	entries []Entry
+	serials map[string]struct{}
+}
+
+// Open opens the list in filename, creating it if needed.
+func Open(filename string) (*List, error) {
+	return openList(filename)
+}
+
+// Revoke adds serial to the list. The returned bool is false if serial was
+// already revoked.
+func (l *List) Revoke(serial, reason string) (Entry, bool, error) {
+	return l.revoke(serial, reason)
+}
+
+// Add adds entry, revoked by another instance, to the list keeping its
+// time and reason. The returned bool is false if the serial was already
+// revoked.
+func (l *List) Add(entry Entry) (bool, error) {
+	return l.add(entry)
+}
+
+// IsRevoked returns true if serial is on the list.
+func (l *List) IsRevoked(serial string) bool {
+	return l.isRevoked(serial)
+}
+
+// Get returns the entry of serial, if it is revoked.
+func (l *List) Get(serial string) (Entry, bool) {
+	return l.get(serial)
+}
+
+// List returns the entries in the order they were added, oldest first
+// unless some were added from other instances.
+func (l *List) List() []Entry {
+	return l.list()
+}
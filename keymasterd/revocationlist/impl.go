@@ -0,0 +1,148 @@
+package revocationlist
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+func openList(filename string) (*List, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE,
+		0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &List{file: file, serials: make(map[string]struct{})}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("revocationlist: %s:%d: %s", filename,
+				lineNumber, err)
+		}
+		l.entries = append(l.entries, entry)
+		l.serials[entry.Serial] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// canonicalSerial accepts decimal or 0x prefixed hexadecimal serials.
+func canonicalSerial(serial string) (string, error) {
+	value, ok := new(big.Int).SetString(serial, 0)
+	if !ok || value.Sign() < 0 {
+		return "", fmt.Errorf("revocationlist: bad serial: %s", serial)
+	}
+	return value.String(), nil
+}
+
+func (l *List) revoke(serial, reason string) (Entry, bool, error) {
+	if l == nil {
+		return Entry{}, false, errors.New("revocationlist: no list")
+	}
+	serial, err := canonicalSerial(serial)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.serials[serial]; ok {
+		for _, entry := range l.entries {
+			if entry.Serial == serial {
+				return entry, false, nil
+			}
+		}
+	}
+	entry := Entry{Serial: serial, Time: time.Now().UTC(), Reason: reason}
+	if err := l.write(entry); err != nil {
+		return Entry{}, false, err
+	}
+	return entry, true, nil
+}
+
+// write appends entry. The mutex must be held.
+func (l *List) write(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.entries = append(l.entries, entry)
+	l.serials[entry.Serial] = struct{}{}
+	return nil
+}
+
+func (l *List) add(entry Entry) (bool, error) {
+	if l == nil {
+		return false, errors.New("revocationlist: no list")
+	}
+	serial, err := canonicalSerial(entry.Serial)
+	if err != nil {
+		return false, err
+	}
+	entry.Serial = serial
+	entry.Time = entry.Time.UTC()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.serials[serial]; ok {
+		return false, nil
+	}
+	if err := l.write(entry); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *List) isRevoked(serial string) bool {
+	if l == nil {
+		return false
+	}
+	serial, err := canonicalSerial(serial)
+	if err != nil {
+		return false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, ok := l.serials[serial]
+	return ok
+}
+
+func (l *List) get(serial string) (Entry, bool) {
+	if l == nil {
+		return Entry{}, false
+	}
+	serial, err := canonicalSerial(serial)
+	if err != nil {
+		return Entry{}, false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, entry := range l.entries {
+		if entry.Serial == serial {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+func (l *List) list() []Entry {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]Entry(nil), l.entries...)
+}
@@ -0,0 +1,74 @@
+package revocationlist
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revocationlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "revoked")
+	list, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := list.Revoke("not-a-number", ""); err == nil {
+		t.Fatal("bad serial should be rejected")
+	}
+	entry, added, err := list.Revoke("0x10", "key compromise")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !added || entry.Serial != "16" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if _, added, _ := list.Revoke("16", "again"); added {
+		t.Fatal("serial should only be revoked once")
+	}
+	if !list.IsRevoked("16") || !list.IsRevoked("0x10") {
+		t.Fatal("serial should be revoked")
+	}
+	if list.IsRevoked("17") {
+		t.Fatal("other serial should not be revoked")
+	}
+	if got, ok := list.Get("0x10"); !ok || got.Reason != "key compromise" {
+		t.Fatalf("unexpected entry: %+v", got)
+	}
+	if _, ok := list.Get("17"); ok {
+		t.Fatal("other serial should not have an entry")
+	}
+	reopened, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := reopened.List()
+	if len(entries) != 1 || entries[0].Reason != "key compromise" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	elsewhere := Entry{Serial: "0x20", Reason: "from another instance",
+		Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if added, err := reopened.Add(elsewhere); err != nil || !added {
+		t.Fatalf("adding an entry: %v %v", added, err)
+	}
+	if added, err := reopened.Add(elsewhere); err != nil || added {
+		t.Fatalf("adding an entry twice: %v %v", added, err)
+	}
+	reopened, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := reopened.Get("32"); !ok || !got.Time.Equal(elsewhere.Time) {
+		t.Fatalf("unexpected added entry: %+v", got)
+	}
+	var nilList *List
+	if nilList.IsRevoked("16") {
+		t.Fatal("nil list should revoke nothing")
+	}
+}
@@ -0,0 +1,81 @@
+// Package sessions records the sessions of logged in users, so that a
+// session can be revoked before its cookie expires. The records are kept in
+// a file with one JSON encoded session per line, the last line of a session
+// replacing the earlier ones. The file is rewritten without the expired
+// sessions when opened and whenever it has grown to twice the lines it had
+// after the last rewrite, so that neither the file nor the memory used by
+// a long running store grow with the number of logins.
+package sessions
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Session is one login. RemoteAddr and UserAgent are those of the login.
+type Session struct {
+	ID         string
+	Username   string
+	Created    time.Time
+	Expires    time.Time
+	RemoteAddr string     `json:",omitempty"`
+	UserAgent  string     `json:",omitempty"`
+	Revoked    *time.Time `json:",omitempty"`
+}
+
+// Store is safe for concurrent use. A nil *Store records nothing and
+// accepts every session.
+type Store struct {
+	mutex     sync.Mutex
+	filename  string
+	file      *os.File
+	lines     int // Lines written to file.
+	compactAt int // Rewrite file at this many lines.
+	sessions  map[string]Session
+}
+
+// Open opens the store in filename, creating it if needed.
+func Open(filename string) (*Store, error) {
+	return openStore(filename)
+}
+
+// Create records a new session of username which expires at expires.
+func (s *Store) Create(username string, expires time.Time, remoteAddr,
+	userAgent string) (Session, error) {
+	return s.create(username, expires, remoteAddr, userAgent)
+}
+
+// IsValid returns true if the session id is recorded, not revoked and not
+// expired at now.
+func (s *Store) IsValid(id string, now time.Time) bool {
+	return s.isValid(id, now)
+}
+
+// Get returns the session id, which may be revoked or expired.
+func (s *Store) Get(id string) (Session, bool) {
+	return s.get(id)
+}
+
+// Add records session, created or revoked by another instance. A revoked
+// session stays revoked. The returned bool is false if the store already
+// had the session in the same state.
+func (s *Store) Add(session Session) (bool, error) {
+	return s.add(session)
+}
+
+// Revoke revokes the session id. The returned bool is false if there was no
+// such valid session.
+func (s *Store) Revoke(id string) (bool, error) {
+	return s.revoke(id)
+}
+
+// RevokeUser revokes the valid sessions of username and returns how many.
+func (s *Store) RevokeUser(username string) (int, error) {
+	return s.revokeUser(username)
+}
+
+// UserSessions returns the valid sessions of username at now, oldest first.
+func (s *Store) UserSessions(username string, now time.Time) []Session {
+	return s.userSessions(username, now)
+}
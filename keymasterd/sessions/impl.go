@@ -0,0 +1,266 @@
+package sessions
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	idLength        = 24
+	minCompactLines = 1000
+)
+
+func openStore(filename string) (*Store, error) {
+	s := &Store{filename: filename, sessions: make(map[string]Session)}
+	file, err := os.Open(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for lineNumber := 1; scanner.Scan(); lineNumber++ {
+			var session Session
+			if err := json.Unmarshal(scanner.Bytes(), &session); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("sessions: %s:%d: %s", filename,
+					lineNumber, err)
+			}
+			s.sessions[session.ID] = session
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.compact(time.Now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compact drops the sessions expired at now and rewrites the file. The
+// mutex must be held.
+func (s *Store) compact(now time.Time) error {
+	for id, session := range s.sessions {
+		if !session.Expires.After(now) {
+			delete(s.sessions, id)
+		}
+	}
+	oldFile := s.file
+	if err := s.rewrite(); err != nil {
+		return err
+	}
+	if oldFile != nil {
+		oldFile.Close()
+	}
+	s.lines = len(s.sessions)
+	s.compactAt = 2 * s.lines
+	if s.compactAt < minCompactLines {
+		s.compactAt = minCompactLines
+	}
+	return nil
+}
+
+// rewrite replaces the file with the current sessions and opens it for
+// appending.
+func (s *Store) rewrite() error {
+	filename := s.filename
+	tmpFilename := filename + ".tmp"
+	file, err := os.OpenFile(tmpFilename,
+		os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, session := range s.sessions {
+		data, err := json.Marshal(session)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		writer.Write(append(data, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	return nil
+}
+
+func newID() (string, error) {
+	id := make([]byte, idLength)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// write records session. The mutex must be held.
+func (s *Store) write(session Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.sessions[session.ID] = session
+	s.lines++
+	if s.lines >= s.compactAt {
+		// The session is already recorded, so failing to compact is not
+		// an error of the write.
+		s.compact(time.Now())
+	}
+	return nil
+}
+
+func (s *Store) create(username string, expires time.Time, remoteAddr,
+	userAgent string) (Session, error) {
+	id, err := newID()
+	if err != nil {
+		return Session{}, err
+	}
+	session := Session{
+		ID:         id,
+		Username:   username,
+		Created:    time.Now().UTC(),
+		Expires:    expires.UTC(),
+		RemoteAddr: remoteAddr,
+		UserAgent:  userAgent,
+	}
+	if s == nil {
+		return session, nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.write(session); err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+func (session Session) isValid(now time.Time) bool {
+	return session.Revoked == nil && session.Expires.After(now)
+}
+
+func (s *Store) isValid(id string, now time.Time) bool {
+	if s == nil {
+		return true
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session, ok := s.sessions[id]
+	return ok && session.isValid(now)
+}
+
+func (s *Store) get(id string) (Session, bool) {
+	if s == nil {
+		return Session{}, false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session, ok := s.sessions[id]
+	return session, ok
+}
+
+func (s *Store) add(session Session) (bool, error) {
+	if s == nil {
+		return false, errors.New("sessions: no store")
+	}
+	if session.ID == "" {
+		return false, errors.New("sessions: no session ID")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if current, ok := s.sessions[session.ID]; ok {
+		if current.Revoked != nil || session.Revoked == nil {
+			return false, nil
+		}
+	}
+	if err := s.write(session); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// revokeSession revokes session. The mutex must be held.
+func (s *Store) revokeSession(session Session, now time.Time) error {
+	revoked := now.UTC()
+	session.Revoked = &revoked
+	return s.write(session)
+}
+
+func (s *Store) revoke(id string) (bool, error) {
+	if s == nil {
+		return false, errors.New("sessions: no store")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	session, ok := s.sessions[id]
+	if !ok || !session.isValid(now) {
+		return false, nil
+	}
+	if err := s.revokeSession(session, now); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Store) revokeUser(username string) (int, error) {
+	if s == nil {
+		return 0, errors.New("sessions: no store")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	var count int
+	for _, session := range s.sessions {
+		if session.Username != username || !session.isValid(now) {
+			continue
+		}
+		if err := s.revokeSession(session, now); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (s *Store) userSessions(username string, now time.Time) []Session {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var sessions []Session
+	for _, session := range s.sessions {
+		if session.Username == username && session.isValid(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Created.Before(sessions[j].Created)
+	})
+	return sessions
+}
@@ -0,0 +1,159 @@
+package sessions
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "sessions")
+	store, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	first, err := store.Create("alice", now.Add(time.Hour), "192.0.2.1:1234",
+		"browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Create("alice", now.Add(time.Hour), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := store.Create("bob", now.Add(time.Hour), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create("bob", now.Add(-time.Second), "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == second.ID || len(first.ID) < 32 {
+		t.Fatalf("bad session IDs: %q %q", first.ID, second.ID)
+	}
+	if !store.IsValid(first.ID, now) || store.IsValid("unknown", now) {
+		t.Fatal("unexpected validity")
+	}
+	if store.IsValid(first.ID, now.Add(2*time.Hour)) {
+		t.Fatal("expired session should not be valid")
+	}
+	if sessions := store.UserSessions("alice", now); len(sessions) != 2 ||
+		sessions[0].ID != first.ID || sessions[0].UserAgent != "browser" {
+		t.Fatalf("unexpected sessions: %+v", sessions)
+	}
+	if revoked, err := store.Revoke(first.ID); err != nil || !revoked {
+		t.Fatalf("cannot revoke session: %v", err)
+	}
+	if revoked, _ := store.Revoke(first.ID); revoked {
+		t.Fatal("session should only be revoked once")
+	}
+	if store.IsValid(first.ID, now) {
+		t.Fatal("revoked session should not be valid")
+	}
+
+	// Revocations survive reopening, expired sessions do not.
+	store, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.IsValid(first.ID, now) || !store.IsValid(second.ID, now) {
+		t.Fatal("sessions not reloaded")
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Fatalf("%d sessions kept, expected 3", lines)
+	}
+	if count, err := store.RevokeUser("alice"); err != nil || count != 1 {
+		t.Fatalf("revoked %d sessions: %v", count, err)
+	}
+	if !store.IsValid(other.ID, now) ||
+		len(store.UserSessions("alice", now)) != 0 {
+		t.Fatal("only the sessions of alice should be revoked")
+	}
+
+	// Sessions of other instances are added, and revocations applied.
+	remote := Session{ID: "remote", Username: "bob", Created: now,
+		Expires: now.Add(time.Hour)}
+	if added, err := store.Add(remote); err != nil || !added {
+		t.Fatalf("adding a session: %v %v", added, err)
+	}
+	if added, _ := store.Add(remote); added || !store.IsValid("remote", now) {
+		t.Fatal("session added twice or not valid")
+	}
+	revokedAt := now
+	remote.Revoked = &revokedAt
+	if added, err := store.Add(remote); err != nil || !added {
+		t.Fatalf("adding a revocation: %v %v", added, err)
+	}
+	remote.Revoked = nil
+	if added, _ := store.Add(remote); added || store.IsValid("remote", now) {
+		t.Fatal("revoked session restored")
+	}
+	if got, ok := store.Get("remote"); !ok || got.Revoked == nil {
+		t.Fatalf("unexpected session: %+v", got)
+	}
+
+	var nilStore *Store
+	session, err := nilStore.Create("alice", now.Add(time.Hour), "", "")
+	if err != nil || session.ID == "" {
+		t.Fatalf("nil store: %v", err)
+	}
+	if !nilStore.IsValid(session.ID, now) {
+		t.Fatal("nil store should accept sessions")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "sessions")
+	store, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, err := store.Create("alice", time.Now().Add(time.Hour), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < minCompactLines; i++ {
+		_, err := store.Create("bob", time.Now().Add(-time.Second), "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Fatalf("%d lines after compaction", lines)
+	}
+	if len(store.sessions) != 1 || !store.IsValid(live.ID, time.Now()) {
+		t.Fatalf("unexpected sessions %v", store.sessions)
+	}
+	if _, err := store.Revoke(live.ID); err != nil {
+		t.Fatal(err)
+	}
+	store, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session, ok := store.Get(live.ID); !ok || session.Revoked == nil {
+		t.Fatalf("revocation lost: %+v", session)
+	}
+}
@@ -0,0 +1,100 @@
+// Package statickeys keeps the static SSH keys reported by host agents from
+// the authorized_keys files of their hosts, and which of them have been
+// certified, to track the migration to SSH certificates. The latest report
+// of each host is kept in a directory, one file each.
+package statickeys
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+// HostReport is the latest report of a host. Host is the identity the agent
+// authenticated with, Report.Hostname the name it reported.
+type HostReport struct {
+	Host     string
+	Received time.Time
+	Report   proto.StaticKeysReport
+}
+
+// Certification records the first certificate issued for a static key.
+type Certification struct {
+	Username    string
+	Fingerprint string
+	Time        time.Time
+}
+
+// UserKey is a static key of a user, over all the hosts it was found on.
+type UserKey struct {
+	Fingerprint string
+	PublicKey   string
+	Comments    []string
+	Hosts       []string
+	Logins      uint64
+	LastLogin   *time.Time
+	// Certified is when a certificate was first issued for the key.
+	Certified *time.Time
+	// Options are the authorized_keys options of the key on any host.
+	Options []string
+}
+
+// HostSummary is the progress of the migration of a host.
+type HostSummary struct {
+	Host          string    `json:"host"`
+	Hostname      string    `json:"hostname"`
+	Received      time.Time `json:"received"`
+	Users         int       `json:"users"`
+	Keys          int       `json:"keys"`
+	CertifiedKeys int       `json:"certified_keys"`
+	// UsedKeys had logins in the logs the agent read.
+	UsedKeys          int        `json:"used_keys"`
+	StaticLogins      uint64     `json:"static_logins"`
+	CertificateLogins uint64     `json:"certificate_logins"`
+	LastStaticLogin   *time.Time `json:"last_static_login,omitempty"`
+}
+
+// Store is safe for concurrent use. A nil *Store has no keys.
+type Store struct {
+	directory string
+	mutex     sync.Mutex
+	hosts     map[string]HostReport
+	certified map[string]Certification // By username and fingerprint.
+}
+
+// Open opens the store in directory, creating it if needed.
+func Open(directory string) (*Store, error) {
+	return openStore(directory)
+}
+
+// Report replaces the report of host. Keys which do not parse are dropped
+// and fingerprints are computed again rather than trusted.
+func (s *Store) Report(host string, report proto.StaticKeysReport,
+	received time.Time) error {
+	return s.report(host, report, received)
+}
+
+// Host returns the latest report of host.
+func (s *Store) Host(host string) (HostReport, bool) {
+	return s.host(host)
+}
+
+// UserKeys returns the static keys of username on all hosts, ordered by
+// fingerprint.
+func (s *Store) UserKeys(username string) []UserKey {
+	return s.userKeys(username)
+}
+
+// MarkCertified records that a certificate was issued to username for the
+// key with fingerprint, if it is a static key of username. It returns true
+// if the key was not certified before.
+func (s *Store) MarkCertified(username, fingerprint string,
+	when time.Time) (bool, error) {
+	return s.markCertified(username, fingerprint, when)
+}
+
+// Summaries returns the progress of each host, ordered by host.
+func (s *Store) Summaries() []HostSummary {
+	return s.summaries()
+}
@@ -0,0 +1,288 @@
+package statickeys
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	hostsSubdir       = "hosts"
+	certifiedFilename = "certified.json"
+	reportSuffix      = ".json"
+)
+
+func certificationKey(username, fingerprint string) string {
+	return username + " " + fingerprint
+}
+
+func openStore(directory string) (*Store, error) {
+	hostsDirectory := filepath.Join(directory, hostsSubdir)
+	if err := os.MkdirAll(hostsDirectory, 0700); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		directory: directory,
+		hosts:     make(map[string]HostReport),
+		certified: make(map[string]Certification),
+	}
+	fileInfos, err := ioutil.ReadDir(hostsDirectory)
+	if err != nil {
+		return nil, err
+	}
+	for _, fileInfo := range fileInfos {
+		if !strings.HasSuffix(fileInfo.Name(), reportSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(hostsDirectory,
+			fileInfo.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var report HostReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, fmt.Errorf("statickeys: %s: %s", fileInfo.Name(), err)
+		}
+		s.hosts[report.Host] = report
+	}
+	data, err := ioutil.ReadFile(filepath.Join(directory, certifiedFilename))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var certifications []Certification
+		if err := json.Unmarshal(data, &certifications); err != nil {
+			return nil, fmt.Errorf("statickeys: %s: %s", certifiedFilename,
+				err)
+		}
+		for _, certification := range certifications {
+			s.certified[certificationKey(certification.Username,
+				certification.Fingerprint)] = certification
+		}
+	}
+	return s, nil
+}
+
+func writeFile(filename string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmpFilename := filename + "~"
+	if err := ioutil.WriteFile(tmpFilename, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+// checkKeys returns the keys of report which parse, with their public key
+// and fingerprint in canonical form.
+func checkKeys(keys []proto.StaticKey) []proto.StaticKey {
+	checked := make([]proto.StaticKey, 0, len(keys))
+	for _, key := range keys {
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.PublicKey))
+		if err != nil || key.Username == "" {
+			continue
+		}
+		if _, ok := pubKey.(*ssh.Certificate); ok {
+			continue
+		}
+		key.PublicKey = strings.TrimSpace(
+			string(ssh.MarshalAuthorizedKey(pubKey)))
+		key.Fingerprint = ssh.FingerprintSHA256(pubKey)
+		checked = append(checked, key)
+	}
+	return checked
+}
+
+func (s *Store) report(host string, report proto.StaticKeysReport,
+	received time.Time) error {
+	if s == nil {
+		return nil
+	}
+	report.Keys = checkKeys(report.Keys)
+	hostReport := HostReport{
+		Host:     host,
+		Received: received.UTC(),
+		Report:   report,
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err := writeFile(filepath.Join(s.directory, hostsSubdir,
+		hex.EncodeToString([]byte(host))+reportSuffix), hostReport)
+	if err != nil {
+		return err
+	}
+	s.hosts[host] = hostReport
+	return nil
+}
+
+func (s *Store) host(host string) (HostReport, bool) {
+	if s == nil {
+		return HostReport{}, false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	report, ok := s.hosts[host]
+	return report, ok
+}
+
+func appendMissing(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+func (s *Store) userKeys(username string) []UserKey {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := make(map[string]*UserKey)
+	for host, report := range s.hosts {
+		for _, staticKey := range report.Report.Keys {
+			if staticKey.Username != username {
+				continue
+			}
+			key := keys[staticKey.Fingerprint]
+			if key == nil {
+				key = &UserKey{
+					Fingerprint: staticKey.Fingerprint,
+					PublicKey:   staticKey.PublicKey,
+				}
+				if certification, ok := s.certified[certificationKey(
+					username, staticKey.Fingerprint)]; ok {
+					certified := certification.Time
+					key.Certified = &certified
+				}
+				keys[staticKey.Fingerprint] = key
+			}
+			key.Comments = appendMissing(key.Comments, staticKey.Comment)
+			key.Hosts = appendMissing(key.Hosts, host)
+			for _, option := range staticKey.Options {
+				key.Options = appendMissing(key.Options, option)
+			}
+			key.Logins += staticKey.Logins
+			if staticKey.LastLogin != nil && (key.LastLogin == nil ||
+				staticKey.LastLogin.After(*key.LastLogin)) {
+				key.LastLogin = staticKey.LastLogin
+			}
+		}
+	}
+	userKeys := make([]UserKey, 0, len(keys))
+	for _, key := range keys {
+		sort.Strings(key.Hosts)
+		userKeys = append(userKeys, *key)
+	}
+	sort.Slice(userKeys, func(i, j int) bool {
+		return userKeys[i].Fingerprint < userKeys[j].Fingerprint
+	})
+	return userKeys
+}
+
+// isStaticKey must be called with the lock held.
+func (s *Store) isStaticKey(username, fingerprint string) bool {
+	for _, report := range s.hosts {
+		for _, key := range report.Report.Keys {
+			if key.Username == username && key.Fingerprint == fingerprint {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Store) markCertified(username, fingerprint string,
+	when time.Time) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	name := certificationKey(username, fingerprint)
+	if _, ok := s.certified[name]; ok || !s.isStaticKey(username, fingerprint) {
+		return false, nil
+	}
+	certifications := make([]Certification, 0, len(s.certified)+1)
+	for _, certification := range s.certified {
+		certifications = append(certifications, certification)
+	}
+	certification := Certification{
+		Username:    username,
+		Fingerprint: fingerprint,
+		Time:        when.UTC(),
+	}
+	certifications = append(certifications, certification)
+	sort.Slice(certifications, func(i, j int) bool {
+		return certifications[i].Time.Before(certifications[j].Time)
+	})
+	err := writeFile(filepath.Join(s.directory, certifiedFilename),
+		certifications)
+	if err != nil {
+		return false, err
+	}
+	s.certified[name] = certification
+	return true, nil
+}
+
+func (s *Store) summaries() []HostSummary {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	summaries := make([]HostSummary, 0, len(s.hosts))
+	for host, report := range s.hosts {
+		summary := HostSummary{
+			Host:              host,
+			Hostname:          report.Report.Hostname,
+			Received:          report.Received,
+			CertificateLogins: report.Report.CertificateLogins,
+		}
+		users := make(map[string]struct{})
+		keys := make(map[string]struct{})
+		for _, key := range report.Report.Keys {
+			users[key.Username] = struct{}{}
+			// A key in several files of the same user is counted once.
+			name := certificationKey(key.Username, key.Fingerprint)
+			if _, ok := keys[name]; ok {
+				continue
+			}
+			keys[name] = struct{}{}
+			if _, ok := s.certified[name]; ok {
+				summary.CertifiedKeys++
+			}
+			if key.Logins > 0 {
+				summary.UsedKeys++
+				summary.StaticLogins += key.Logins
+			}
+			if key.LastLogin != nil && (summary.LastStaticLogin == nil ||
+				key.LastLogin.After(*summary.LastStaticLogin)) {
+				summary.LastStaticLogin = key.LastLogin
+			}
+		}
+		summary.Users = len(users)
+		summary.Keys = len(keys)
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Host < summaries[j].Host
+	})
+	return summaries
+}
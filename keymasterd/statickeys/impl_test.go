@@ -0,0 +1,127 @@
+package statickeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pubKey
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statickeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptopKey := newTestPublicKey(t)
+	laptop := ssh.FingerprintSHA256(laptopKey)
+	authorizedKey := strings.TrimSpace(
+		string(ssh.MarshalAuthorizedKey(laptopKey)))
+	lastLogin := time.Date(2026, 10, 14, 8, 0, 0, 0, time.UTC)
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	err = store.Report("host1.example.com", proto.StaticKeysReport{
+		Hostname: "host1",
+		Keys: []proto.StaticKey{
+			// The fingerprint of the agent is not trusted.
+			{Username: "alice", PublicKey: authorizedKey + " laptop",
+				Fingerprint: "SHA256:forged", Comment: "laptop", Logins: 2,
+				LastLogin: &lastLogin},
+			{Username: "alice", PublicKey: "not a key"},
+			{Username: "bob", PublicKey: authorizedKey},
+		},
+		CertificateLogins: 5,
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Report("host2.example.com", proto.StaticKeysReport{
+		Hostname: "host2",
+		Keys: []proto.StaticKey{
+			{Username: "alice", PublicKey: authorizedKey, Comment: "old",
+				Options: []string{`from="10.0.0.0/8"`}},
+		},
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := store.UserKeys("alice")
+	if len(keys) != 1 {
+		t.Fatalf("unexpected keys: %+v", keys)
+	}
+	if key := keys[0]; key.Fingerprint != laptop ||
+		key.PublicKey != authorizedKey || len(key.Hosts) != 2 ||
+		len(key.Comments) != 2 || key.Logins != 2 || key.Certified != nil ||
+		len(key.Options) != 1 {
+		t.Errorf("unexpected key: %+v", key)
+	}
+	if ok, err := store.MarkCertified("carol", laptop, now); err != nil || ok {
+		t.Errorf("key of another user certified: %v", err)
+	}
+	if ok, err := store.MarkCertified("alice", laptop, now); err != nil || !ok {
+		t.Fatalf("key not certified: %v", err)
+	}
+	if ok, _ := store.MarkCertified("alice", laptop, now); ok {
+		t.Error("key certified twice")
+	}
+	// Everything survives a restart.
+	store, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := store.UserKeys("alice"); len(keys) != 1 ||
+		keys[0].Certified == nil || !keys[0].Certified.Equal(now) {
+		t.Errorf("certification lost: %+v", keys)
+	}
+	summaries := store.Summaries()
+	if len(summaries) != 2 {
+		t.Fatalf("unexpected summaries: %+v", summaries)
+	}
+	if summary := summaries[0]; summary.Host != "host1.example.com" ||
+		summary.Hostname != "host1" || summary.Users != 2 ||
+		summary.Keys != 2 || summary.CertifiedKeys != 1 ||
+		summary.UsedKeys != 1 || summary.StaticLogins != 2 ||
+		summary.CertificateLogins != 5 || summary.LastStaticLogin == nil {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if report, ok := store.Host("host2.example.com"); !ok ||
+		len(report.Report.Keys) != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var store *Store
+	if err := store.Report("host", proto.StaticKeysReport{},
+		time.Now()); err != nil {
+		t.Error(err)
+	}
+	if keys := store.UserKeys("alice"); len(keys) != 0 {
+		t.Errorf("unexpected keys: %+v", keys)
+	}
+	if ok, err := store.MarkCertified("alice", "SHA256:x",
+		time.Now()); ok || err != nil {
+		t.Error("nil store certified a key")
+	}
+}
@@ -0,0 +1,118 @@
+// Package syslog sends log messages in the RFC 5424 format to the local
+// syslog daemon or to a remote collector over UDP, TCP or TLS. Messages are
+// queued in memory and sent in the background, so that a slow or absent
+// collector does not slow logging down; messages are dropped when the queue
+// is full and counted, unless they are sent with SendWait, which waits for
+// room instead. Connections are reestablished as needed.
+//
+// Over TCP and TLS messages are framed with octet counting (RFC 6587 and
+// RFC 5425), over UDP and the local socket each datagram is one message.
+package syslog
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// Severity is the severity of a message.
+type Severity int
+
+// Severities, from RFC 5424.
+const (
+	Emergency Severity = iota
+	Alert
+	Critical
+	Error
+	Warning
+	Notice
+	Informational
+	Debug
+)
+
+// Facility is the facility of a message, i.e. its source.
+type Facility int
+
+// ParseFacility returns the facility called name, such as "daemon",
+// "authpriv" or "local0".
+func ParseFacility(name string) (Facility, error) {
+	return parseFacility(name)
+}
+
+// Params configures a Writer.
+type Params struct {
+	// Network is "unixgram" for the local daemon (the default), "udp",
+	// "tcp" or "tls".
+	Network string
+	// Address is the host:port of the collector, or the socket of the
+	// local daemon. Default: /dev/log, /var/run/syslog or /var/run/log,
+	// whichever exists.
+	Address string
+	// TLSConfig is used for the tls network.
+	TLSConfig *tls.Config
+	// Hostname and AppName are sent with every message. Default: the host
+	// name and the name of the program.
+	Hostname string
+	AppName  string
+	// QueueLength is the number of messages kept while the collector is
+	// slow or unreachable. Default: 4096.
+	QueueLength int
+}
+
+type message struct {
+	facility Facility
+	severity Severity
+	msgID    string
+	text     string
+	time     time.Time
+}
+
+// Writer sends messages to a syslog collector. It is safe for concurrent
+// use.
+type Writer struct {
+	params  Params
+	pid     int
+	queue   chan message
+	closing chan struct{}
+	done    chan struct{}
+	abort   chan struct{}
+	conn    net.Conn // Only used by the sending goroutine.
+	mutex   sync.Mutex
+	dropped uint64 // Protected by mutex.
+	closed  bool   // Protected by mutex.
+}
+
+// New returns a Writer sending to the collector of params. The collector is
+// dialed in the background and need not be up yet.
+func New(params Params) (*Writer, error) {
+	return newWriter(params)
+}
+
+// Send queues a message with the given facility and severity. msgID
+// identifies the type of the message and may be empty. Send never blocks;
+// the message is dropped if the queue is full or the Writer is closed.
+func (w *Writer) Send(facility Facility, severity Severity, msgID string,
+	text string) {
+	w.send(facility, severity, msgID, text)
+}
+
+// SendWait queues a message like Send, but waits for room in the queue
+// while the collector is slow or unreachable instead of dropping it. It
+// returns an error if the Writer is closed first.
+func (w *Writer) SendWait(facility Facility, severity Severity, msgID string,
+	text string) error {
+	return w.sendWait(facility, severity, msgID, text)
+}
+
+// Dropped returns the number of messages which were dropped because the
+// queue was full.
+func (w *Writer) Dropped() uint64 {
+	return w.getDropped()
+}
+
+// Close sends the queued messages, waiting for at most timeout, and closes
+// the connection.
+func (w *Writer) Close(timeout time.Duration) error {
+	return w.close(timeout)
+}
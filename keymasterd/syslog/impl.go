@@ -0,0 +1,274 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultQueueLength = 4096
+	dialTimeout        = 10 * time.Second
+	writeTimeout       = 10 * time.Second
+	minRetryInterval   = time.Second
+	maxRetryInterval   = 30 * time.Second
+	maxHostnameLength  = 255
+	maxAppNameLength   = 48
+	maxMsgIDLength     = 32
+	timestampFormat    = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+var facilities = map[string]Facility{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20,
+	"local5": 21, "local6": 22, "local7": 23,
+}
+
+var localAddresses = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+func parseFacility(name string) (Facility, error) {
+	facility, ok := facilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("syslog: unknown facility: %s", name)
+	}
+	return facility, nil
+}
+
+func newWriter(params Params) (*Writer, error) {
+	switch params.Network {
+	case "":
+		params.Network = "unixgram"
+	case "unixgram", "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("syslog: unknown network: %s", params.Network)
+	}
+	if params.Network != "unixgram" {
+		if _, _, err := net.SplitHostPort(params.Address); err != nil {
+			return nil, fmt.Errorf("syslog: %s", err)
+		}
+	}
+	if params.Hostname == "" {
+		params.Hostname, _ = os.Hostname()
+	}
+	if params.AppName == "" {
+		params.AppName = filepath.Base(os.Args[0])
+	}
+	if params.QueueLength < 1 {
+		params.QueueLength = defaultQueueLength
+	}
+	params.Hostname = headerField(params.Hostname, maxHostnameLength)
+	params.AppName = headerField(params.AppName, maxAppNameLength)
+	w := &Writer{
+		params:  params,
+		pid:     os.Getpid(),
+		queue:   make(chan message, params.QueueLength),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		abort:   make(chan struct{}),
+	}
+	go w.loop()
+	return w, nil
+}
+
+// headerField returns value with the characters not allowed in the header
+// fields of RFC 5424 replaced, truncated to maxLength, or the nil value "-"
+// if empty.
+func headerField(value string, maxLength int) string {
+	if value == "" {
+		return "-"
+	}
+	field := []byte(value)
+	if len(field) > maxLength {
+		field = field[:maxLength]
+	}
+	for index, c := range field {
+		if c < 33 || c > 126 {
+			field[index] = '_'
+		}
+	}
+	return string(field)
+}
+
+var errClosed = errors.New("syslog: writer closed")
+
+func newMessage(facility Facility, severity Severity, msgID string,
+	text string) message {
+	return message{
+		facility: facility,
+		severity: severity,
+		msgID:    msgID,
+		text:     text,
+		time:     time.Now(),
+	}
+}
+
+func (w *Writer) send(facility Facility, severity Severity, msgID string,
+	text string) {
+	msg := newMessage(facility, severity, msgID, text)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.queue <- msg:
+	default:
+		w.dropped++
+	}
+}
+
+// sendWait waits for room without holding the mutex, so that Close is not
+// held up. The queue is never closed: the sending goroutine stops once
+// closing is closed and the queue is empty.
+func (w *Writer) sendWait(facility Facility, severity Severity, msgID string,
+	text string) error {
+	msg := newMessage(facility, severity, msgID, text)
+	select {
+	case <-w.closing:
+		return errClosed
+	default:
+	}
+	select {
+	case w.queue <- msg:
+		return nil
+	case <-w.closing:
+		return errClosed
+	}
+}
+
+func (w *Writer) getDropped() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.dropped
+}
+
+func (w *Writer) close(timeout time.Duration) error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.closing)
+	w.mutex.Unlock()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-w.done:
+		return nil
+	case <-timer.C:
+		close(w.abort)
+		return errors.New("syslog: timed out sending the queued messages")
+	}
+}
+
+// format returns msg as an RFC 5424 message.
+func (w *Writer) format(msg message) []byte {
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		int(msg.facility)*8+int(msg.severity),
+		msg.time.Format(timestampFormat), w.params.Hostname,
+		w.params.AppName, w.pid, headerField(msg.msgID, maxMsgIDLength),
+		strings.TrimRight(msg.text, "\n")))
+}
+
+// next returns the next queued message, waiting for one until the Writer is
+// closed. It returns false once the Writer is closed and the queue is empty.
+func (w *Writer) next() (message, bool) {
+	select {
+	case msg := <-w.queue:
+		return msg, true
+	case <-w.closing:
+	}
+	select {
+	case msg := <-w.queue:
+		return msg, true
+	default:
+		return message{}, false
+	}
+}
+
+// loop sends the queued messages in order, retrying each until it is sent
+// or the Writer is aborted.
+func (w *Writer) loop() {
+	defer close(w.done)
+	defer func() {
+		if w.conn != nil {
+			w.conn.Close()
+		}
+	}()
+	retryInterval := minRetryInterval
+	for {
+		msg, ok := w.next()
+		if !ok {
+			return
+		}
+		data := w.format(msg)
+		for {
+			err := w.write(data)
+			if err == nil {
+				retryInterval = minRetryInterval
+				break
+			}
+			if w.conn != nil {
+				w.conn.Close()
+				w.conn = nil
+			}
+			timer := time.NewTimer(retryInterval)
+			select {
+			case <-timer.C:
+			case <-w.abort:
+				timer.Stop()
+				return
+			}
+			retryInterval *= 2
+			if retryInterval > maxRetryInterval {
+				retryInterval = maxRetryInterval
+			}
+		}
+	}
+}
+
+func (w *Writer) dial() (net.Conn, error) {
+	switch w.params.Network {
+	case "tls":
+		dialer := &net.Dialer{Timeout: dialTimeout}
+		return tls.DialWithDialer(dialer, "tcp", w.params.Address,
+			w.params.TLSConfig)
+	case "unixgram":
+		if w.params.Address != "" {
+			return net.DialTimeout("unixgram", w.params.Address, dialTimeout)
+		}
+		var err error
+		for _, address := range localAddresses {
+			var conn net.Conn
+			conn, err = net.DialTimeout("unixgram", address, dialTimeout)
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+	return net.DialTimeout(w.params.Network, w.params.Address, dialTimeout)
+}
+
+func (w *Writer) write(data []byte) error {
+	if w.conn == nil {
+		conn, err := w.dial()
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+	if w.params.Network == "tcp" || w.params.Network == "tls" {
+		data = append([]byte(fmt.Sprintf("%d ", len(data))), data...)
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := w.conn.Write(data)
+	return err
+}
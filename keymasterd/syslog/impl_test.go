@@ -0,0 +1,231 @@
+package syslog
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var messagePattern = regexp.MustCompile(`^<(\d+)>1 \d{4}-\d\d-\d\dT\S+ ` +
+	`host keymasterd \d+ (\S+) - (.*)$`)
+
+func checkMessage(t *testing.T, data string, priority int, msgID string,
+	text string) {
+	matches := messagePattern.FindStringSubmatch(data)
+	if matches == nil {
+		t.Errorf("malformed message: %q", data)
+		return
+	}
+	if matches[1] != strconv.Itoa(priority) || matches[2] != msgID ||
+		matches[3] != text {
+		t.Errorf("unexpected message: %q", data)
+	}
+}
+
+func TestUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	w, err := New(Params{Network: "udp", Address: conn.LocalAddr().String(),
+		Hostname: "host", AppName: "keymasterd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authpriv, err := ParseFacility("authpriv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Send(authpriv, Notice, "issued", "certificate issued\n")
+	w.Send(authpriv, Error, "", "bad\nline")
+	if err := w.Close(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, string(buffer[:n]), 10*8+5, "issued",
+		"certificate issued")
+	n, _, err = conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := string(buffer[:n]); !strings.HasPrefix(data, "<83>1 ") ||
+		!strings.HasSuffix(data, " - - bad\nline") {
+		t.Errorf("unexpected message: %q", data)
+	}
+}
+
+func TestTCPReconnects(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// Read one framed message per connection.
+			reader := bufio.NewReader(conn)
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			size, _ := strconv.Atoi(strings.TrimSpace(length))
+			data := make([]byte, size)
+			if _, err := reader.Read(data); err == nil {
+				received <- string(data)
+			}
+			conn.Close()
+		}
+	}()
+	daemon, _ := ParseFacility("daemon")
+	w, err := New(Params{Network: "tcp", Address: listener.Addr().String(),
+		Hostname: "host", AppName: "keymasterd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close(time.Second)
+	w.Send(daemon, Informational, "", "first")
+	checkMessage(t, <-received, 3*8+6, "-", "first")
+	// The write to the closed connection may appear to succeed, so keep
+	// sending until the Writer reconnects.
+	timeout := time.After(10 * time.Second)
+	for {
+		w.Send(daemon, Warning, "", "again")
+		select {
+		case data := <-received:
+			checkMessage(t, data, 3*8+4, "-", "again")
+			return
+		case <-time.After(100 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("not reconnected")
+		}
+	}
+}
+
+func TestUnixgramAndDrops(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "log")
+	w, err := New(Params{Address: socket, Hostname: "host",
+		AppName: "keymasterd", QueueLength: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing listens yet, so the queue fills up.
+	for i := 0; i < 5; i++ {
+		w.Send(0, Critical, "", "message")
+	}
+	if dropped := w.Dropped(); dropped < 2 {
+		t.Errorf("%d dropped", dropped)
+	}
+	conn, err := net.ListenUnixgram("unixgram",
+		&net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := w.Close(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, string(buffer[:n]), 2, "-", "message")
+	w.Send(0, Critical, "", "after close")
+}
+
+func TestSendWait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "log")
+	w, err := New(Params{Address: socket, Hostname: "host",
+		AppName: "keymasterd", QueueLength: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing listens yet, so the last message waits for room.
+	sent := make(chan error, 1)
+	go func() {
+		for i := 0; i < 3; i++ {
+			if err := w.SendWait(0, Notice, "", "audit"); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+	select {
+	case err := <-sent:
+		t.Fatalf("did not wait for room: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	conn, err := net.ListenUnixgram("unixgram",
+		&net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < 3; i++ {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkMessage(t, string(buffer[:n]), 5, "-", "audit")
+	}
+	if dropped := w.Dropped(); dropped != 0 {
+		t.Errorf("%d dropped", dropped)
+	}
+	if err := w.SendWait(0, Notice, "", "after close"); err == nil {
+		t.Error("no error after close")
+	}
+}
+
+func TestParams(t *testing.T) {
+	if _, err := ParseFacility("local8"); err == nil {
+		t.Error("unknown facility accepted")
+	}
+	if _, err := New(Params{Network: "udp"}); err == nil {
+		t.Error("no address accepted")
+	}
+	if _, err := New(Params{Network: "sctp", Address: "host:514"}); err == nil {
+		t.Error("unknown network accepted")
+	}
+	if field := headerField("a b\x01c", 4); field != "a_b_" {
+		t.Errorf("header field: %q", field)
+	}
+}
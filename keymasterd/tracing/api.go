@@ -0,0 +1,152 @@
+// Package tracing records OpenTelemetry spans with the OpenTelemetry SDK and
+// exports them in batches to an OTLP/HTTP collector, using the JSON encoding
+// of the protocol. The trace context is carried in a context.Context and
+// propagated between processes with the W3C traceparent header.
+//
+// A nil *Tracer records nothing and the methods of a nil *Span do nothing,
+// so callers need not check whether tracing is enabled. Spans are queued in
+// memory and exported in the background; they are dropped when the queue is
+// full or the collector fails.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SpanKind is the role of a span, with the values of OTLP.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// TraceparentHeader is the W3C trace context header.
+const TraceparentHeader = "traceparent"
+
+// Params configures a Tracer.
+type Params struct {
+	// Endpoint is the URL the spans are posted to, usually ending in
+	// /v1/traces.
+	Endpoint string
+	// Headers are added to the export requests, e.g. for authentication.
+	Headers map[string]string
+	// ServiceName, ServiceVersion and ServiceInstance identify the process
+	// in the resource of the spans.
+	ServiceName     string
+	ServiceVersion  string
+	ServiceInstance string
+	// SampleRatio is the fraction of the traces which are recorded. Spans
+	// with a local parent follow its decision. Spans continuing a remote
+	// trace are not recorded if it is not sampled, and else sampled at
+	// SampleRatio too, so that callers cannot have every request traced.
+	SampleRatio float64
+	// BatchSize is the maximum number of spans per export request.
+	// Default: 512.
+	BatchSize int
+	// FlushInterval is the longest a span waits to be exported.
+	// Default: 5 seconds.
+	FlushInterval time.Duration
+	// QueueLength is the number of spans kept while exporting. Default: 2048.
+	QueueLength int
+	// Client is used for the export requests. Default: a client with a 10
+	// second timeout.
+	Client *http.Client
+}
+
+// Tracer is safe for concurrent use.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	dropped  uint64 // Updated atomically.
+}
+
+// New returns a tracer exporting to params.Endpoint. Export errors are
+// logged to logger, which becomes the error handler of OpenTelemetry.
+func New(params Params, logger log.DebugLogger) (*Tracer, error) {
+	return newTracer(params, logger)
+}
+
+// Start starts a span called name, a child of the span of ctx or of the
+// remote parent added by Extract, or else the root of a new trace. The
+// returned context carries the span. The span must be ended with End.
+func (t *Tracer) Start(ctx context.Context, name string,
+	kind SpanKind) (context.Context, *Span) {
+	return t.start(ctx, name, kind)
+}
+
+// StartChild starts a span called name, a child of the span of ctx recorded
+// by the same tracer. Without a span in ctx it returns ctx and nil, so that
+// only the work done for a traced operation is traced.
+func StartChild(ctx context.Context, name string,
+	kind SpanKind) (context.Context, *Span) {
+	return startChild(ctx, name, kind)
+}
+
+// Extract returns a copy of ctx with the remote parent span described by
+// the traceparent header of header, if it is valid.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return extract(ctx, header)
+}
+
+// Inject sets the traceparent header of header to the span of ctx, if any.
+func Inject(ctx context.Context, header http.Header) {
+	inject(ctx, header)
+}
+
+// Dropped returns the number of spans which the collector did not accept.
+func (t *Tracer) Dropped() uint64 {
+	return t.getDropped()
+}
+
+// Close exports the queued spans, waiting at most timeout, and stops the
+// tracer. Spans ended after Close are dropped.
+func (t *Tracer) Close(timeout time.Duration) error {
+	return t.close(timeout)
+}
+
+// Span is an operation in a trace. Its methods are safe for concurrent use.
+type Span struct {
+	tracer *Tracer
+	span   trace.Span
+}
+
+// SpanFromContext returns the span of ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	return spanFromContext(ctx)
+}
+
+// SetName renames s, e.g. once the route of a request is known.
+func (s *Span) SetName(name string) {
+	s.setName(name)
+}
+
+// SetAttribute sets an attribute of s. value is a string, a bool, an
+// integer or a float; other types are formatted as strings.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.setAttribute(key, value)
+}
+
+// SetError marks s as failed with the message of err, if err is not nil.
+func (s *Span) SetError(err error) {
+	s.setError(err)
+}
+
+// End ends s and queues it for export if it is sampled. Ending s again has
+// no effect.
+func (s *Span) End() {
+	s.finish()
+}
+
+// TraceID returns the hexadecimal ID of the trace of s, or the empty string
+// if s is nil or not sampled.
+func (s *Span) TraceID() string {
+	return s.traceIDString()
+}
@@ -0,0 +1,224 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultBatchSize     = 512
+	defaultFlushInterval = 5 * time.Second
+	defaultQueueLength   = 2048
+	defaultExportTimeout = 10 * time.Second
+	scopeName            = "keymaster"
+)
+
+type spanKey struct{}
+
+var propagator = propagation.TraceContext{}
+
+// countingExporter counts the spans which could not be exported. The
+// errors are logged by the SDK.
+type countingExporter struct {
+	sdktrace.SpanExporter
+	dropped *uint64
+}
+
+func (e *countingExporter) ExportSpans(ctx context.Context,
+	spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	if err != nil {
+		atomic.AddUint64(e.dropped, uint64(len(spans)))
+	}
+	return err
+}
+
+func newTracer(params Params, logger log.DebugLogger) (*Tracer, error) {
+	u, err := url.Parse(params.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("tracing: bad endpoint: %s", params.Endpoint)
+	}
+	if params.SampleRatio < 0 || params.SampleRatio > 1 {
+		return nil, fmt.Errorf("tracing: bad sample ratio: %g",
+			params.SampleRatio)
+	}
+	if params.BatchSize < 1 {
+		params.BatchSize = defaultBatchSize
+	}
+	if params.FlushInterval <= 0 {
+		params.FlushInterval = defaultFlushInterval
+	}
+	if params.QueueLength < 1 {
+		params.QueueLength = defaultQueueLength
+	}
+	if params.Client == nil {
+		params.Client = &http.Client{Timeout: defaultExportTimeout}
+	}
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(params.Endpoint),
+		otlptracehttp.WithEncoding(otlptracehttp.EncodingJSON),
+		otlptracehttp.WithHeaders(params.Headers),
+		otlptracehttp.WithHTTPClient(params.Client),
+		// Spans are dropped rather than retried.
+		otlptracehttp.WithRetry(otlptracehttp.RetryConfig{Enabled: false}))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %s", err)
+	}
+	var attributes []attribute.KeyValue
+	for key, value := range map[string]string{
+		"service.name":        params.ServiceName,
+		"service.version":     params.ServiceVersion,
+		"service.instance.id": params.ServiceInstance,
+	} {
+		if value != "" {
+			attributes = append(attributes, attribute.String(key, value))
+		}
+	}
+	if logger != nil {
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			logger.Println(err)
+		}))
+	}
+	// Callers must not be able to raise the sampling ratio by sending a
+	// sampled traceparent, so remote parents only ever lower it.
+	ratioSampler := sdktrace.TraceIDRatioBased(params.SampleRatio)
+	t := &Tracer{}
+	t.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(&countingExporter{exporter, &t.dropped},
+			sdktrace.WithMaxExportBatchSize(params.BatchSize),
+			sdktrace.WithBatchTimeout(params.FlushInterval),
+			sdktrace.WithMaxQueueSize(params.QueueLength)),
+		sdktrace.WithResource(resource.NewSchemaless(attributes...)),
+		sdktrace.WithSampler(sdktrace.ParentBased(ratioSampler,
+			sdktrace.WithRemoteParentSampled(ratioSampler))))
+	t.tracer = t.provider.Tracer(scopeName)
+	return t, nil
+}
+
+func (t *Tracer) start(ctx context.Context, name string,
+	kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	ctx, span := t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKind(kind)))
+	s := &Span{tracer: t, span: span}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func startChild(ctx context.Context, name string,
+	kind SpanKind) (context.Context, *Span) {
+	parent := spanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.start(ctx, name, kind)
+}
+
+func spanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func extract(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+func inject(ctx context.Context, header http.Header) {
+	if span := spanFromContext(ctx); span != nil {
+		propagator.Inject(trace.ContextWithSpan(context.Background(),
+			span.span), propagation.HeaderCarrier(header))
+	}
+}
+
+func (s *Span) setName(name string) {
+	if s == nil {
+		return
+	}
+	s.span.SetName(name)
+}
+
+func newKeyValue(key string, value interface{}) attribute.KeyValue {
+	switch value := value.(type) {
+	case string:
+		return attribute.String(key, value)
+	case bool:
+		return attribute.Bool(key, value)
+	case int:
+		return attribute.Int(key, value)
+	case int32:
+		return attribute.Int64(key, int64(value))
+	case int64:
+		return attribute.Int64(key, value)
+	case uint:
+		return attribute.Int64(key, int64(value))
+	case uint32:
+		return attribute.Int64(key, int64(value))
+	case float32:
+		return attribute.Float64(key, float64(value))
+	case float64:
+		return attribute.Float64(key, value)
+	}
+	return attribute.String(key, fmt.Sprint(value))
+}
+
+func (s *Span) setAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(newKeyValue(key, value))
+}
+
+func (s *Span) setError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s *Span) finish() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
+
+func (s *Span) traceIDString() string {
+	if s == nil || !s.span.SpanContext().IsSampled() {
+		return ""
+	}
+	return s.span.SpanContext().TraceID().String()
+}
+
+func (t *Tracer) getDropped() uint64 {
+	if t == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&t.dropped)
+}
+
+func (t *Tracer) close(timeout time.Duration) error {
+	if t == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return t.provider.Shutdown(ctx)
+}
@@ -0,0 +1,243 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// statusCodeError is STATUS_CODE_ERROR of OTLP.
+const statusCodeError = 2
+
+// exportedSpan is a span in the JSON encoding of OTLP, which has hexadecimal
+// IDs unlike the protobuf JSON mapping.
+type exportedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Kind         int    `json:"kind"`
+	Attributes   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			IntValue string `json:"intValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type exportRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []struct {
+				Key   string `json:"key"`
+				Value struct {
+					StringValue string `json:"stringValue"`
+				} `json:"value"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []exportedSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func TestExport(t *testing.T) {
+	requests := make(chan exportRequest, 4)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/traces" ||
+				r.Header.Get("Authorization") != "Bearer token" ||
+				r.Header.Get("Content-Type") != "application/json" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var request exportRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			requests <- request
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("{}"))
+		}))
+	defer server.Close()
+	tracer, err := New(Params{
+		Endpoint:        server.URL + "/v1/traces",
+		Headers:         map[string]string{"Authorization": "Bearer token"},
+		ServiceName:     "keymasterd",
+		ServiceInstance: "keymaster-1",
+		SampleRatio:     1,
+	}, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := make(http.Header)
+	header.Set(TraceparentHeader,
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	ctx, server1 := tracer.Start(Extract(context.Background(), header),
+		"GET /certgen/", SpanKindServer)
+	server1.SetAttribute("http.response.status_code", 200)
+	_, client := StartChild(ctx, "ldap.groups", SpanKindClient)
+	client.SetError(errors.New("timeout"))
+	client.End()
+	server1.End()
+	server1.End() // Ignored.
+	outgoing := make(http.Header)
+	Inject(ctx, outgoing)
+	if err := tracer.Close(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	var request exportRequest
+	select {
+	case request = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no spans exported")
+	}
+	resource := request.ResourceSpans[0]
+	resourceAttributes := make(map[string]string)
+	for _, attribute := range resource.Resource.Attributes {
+		resourceAttributes[attribute.Key] = attribute.Value.StringValue
+	}
+	if len(resourceAttributes) != 2 ||
+		resourceAttributes["service.name"] != "keymasterd" ||
+		resourceAttributes["service.instance.id"] != "keymaster-1" {
+		t.Errorf("resource: %v", resourceAttributes)
+	}
+	spans := resource.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("spans: %+v", spans)
+	}
+	ldap, handler := spans[0], spans[1]
+	// The IDs are exported in upper case.
+	traceID := strings.ToLower(handler.TraceID)
+	spanID := strings.ToLower(handler.SpanID)
+	if traceID != "0af7651916cd43dd8448eb211c80319c" ||
+		!strings.EqualFold(handler.ParentSpanID, "b7ad6b7169203331") ||
+		handler.Kind != int(SpanKindServer) ||
+		handler.Attributes[0].Value.IntValue != "200" {
+		t.Errorf("server span: %+v", handler)
+	}
+	if !strings.EqualFold(ldap.TraceID, traceID) ||
+		!strings.EqualFold(ldap.ParentSpanID, spanID) ||
+		ldap.Status.Code != statusCodeError || ldap.Status.Message != "timeout" {
+		t.Errorf("client span: %+v", ldap)
+	}
+	expected := "00-" + traceID + "-" + spanID + "-01"
+	if traceparent := outgoing.Get(TraceparentHeader); traceparent != expected {
+		t.Errorf("traceparent %q, expected %q", traceparent, expected)
+	}
+	if server1.TraceID() != traceID {
+		t.Errorf("trace ID: %s", server1.TraceID())
+	}
+	if tracer.Dropped() != 0 {
+		t.Errorf("%d spans dropped", tracer.Dropped())
+	}
+}
+
+func TestDropped(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+	defer server.Close()
+	tracer, err := New(Params{Endpoint: server.URL + "/v1/traces",
+		SampleRatio: 1}, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := tracer.Start(context.Background(), "x", SpanKindServer)
+	span.End()
+	tracer.Close(5 * time.Second)
+	if tracer.Dropped() != 1 {
+		t.Errorf("%d spans dropped", tracer.Dropped())
+	}
+}
+
+func TestNotSampled(t *testing.T) {
+	var nilTracer *Tracer
+	ctx, span := nilTracer.Start(context.Background(), "x", SpanKindInternal)
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("failed"))
+	span.End()
+	if _, child := StartChild(ctx, "y", SpanKindClient); child != nil {
+		t.Error("child span without a parent")
+	}
+	if SpanFromContext(ctx) != nil || span.TraceID() != "" {
+		t.Error("nil tracer recorded a span")
+	}
+	tracer, err := New(Params{Endpoint: "http://127.0.0.1:1/v1/traces"},
+		testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracer.Close(time.Second)
+	ctx, span = tracer.Start(context.Background(), "x", SpanKindServer)
+	_, child := tracer.Start(ctx, "y", SpanKindClient)
+	if span.TraceID() != "" || child.span.IsRecording() ||
+		child.span.SpanContext().TraceID() != span.span.SpanContext().TraceID() {
+		t.Error("unsampled trace recorded")
+	}
+	child.End()
+	span.End()
+
+	// A sampled remote parent does not override the ratio.
+	header := make(http.Header)
+	header.Set(TraceparentHeader,
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	_, span = tracer.Start(Extract(context.Background(), header), "x",
+		SpanKindServer)
+	if span.TraceID() != "" || span.span.SpanContext().TraceID().String() !=
+		"0af7651916cd43dd8448eb211c80319c" {
+		t.Error("sampled remote parent recorded")
+	}
+	span.End()
+}
+
+func TestExtract(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra",
+		"00-0af7651916cd43dd8448eb211c8031-b7ad6b7169203331-01",
+	} {
+		header := make(http.Header)
+		header.Set(TraceparentHeader, value)
+		ctx := Extract(context.Background(), header)
+		if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+			t.Errorf("%q accepted", value)
+		}
+	}
+	header := make(http.Header)
+	header.Set(TraceparentHeader,
+		"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00-future")
+	spanContext := trace.SpanContextFromContext(Extract(context.Background(),
+		header))
+	if !spanContext.IsValid() || spanContext.IsSampled() {
+		t.Errorf("later version: %+v", spanContext)
+	}
+}
+
+func TestNewParams(t *testing.T) {
+	for _, params := range []Params{
+		{Endpoint: "collector:4318"},
+		{Endpoint: "ftp://collector/v1/traces"},
+		{Endpoint: "http://collector/v1/traces", SampleRatio: 2},
+	} {
+		if _, err := New(params, nil); err == nil {
+			t.Errorf("%+v accepted", params)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Package trustcoverage aggregates the CA fingerprints and KRL versions that
+// hosts report they currently trust.
+package trustcoverage
+
+import (
+	"sync"
+	"time"
+)
+
+// Coverage is a snapshot of the trust reported by the fleet.
+type Coverage struct {
+	// TotalHosts is the number of hosts with a report newer than maxAge.
+	TotalHosts int
+	// Fingerprints maps each CA fingerprint to the number of hosts
+	// trusting it.
+	Fingerprints map[string]int
+	// KRLVersions maps each KRL version to the number of hosts using it.
+	KRLVersions map[uint64]int
+}
+
+// Tracker tracks the latest trust report from each host.
+type Tracker struct {
+	clock  clock
+	maxAge time.Duration
+	mu     sync.Mutex
+	hosts  map[string]hostReport
+}
+
+// New creates a new Tracker that ignores reports older than maxAge.
+func New(maxAge time.Duration) *Tracker {
+	return newForTesting(maxAge, kSystemClock)
+}
+
+// Report replaces the CA fingerprints and KRL version trusted by hostname.
+// If t is nil, Report is a no-op.
+func (t *Tracker) Report(hostname string, fingerprints []string,
+	krlVersion uint64) {
+	t.report(hostname, fingerprints, krlVersion)
+}
+
+// GetCoverage returns the coverage of all hosts with a current report.
+// If t is nil, GetCoverage returns an empty Coverage.
+func (t *Tracker) GetCoverage() Coverage {
+	return t.getCoverage()
+}
+
+// FingerprintCoverage returns the fraction (0 to 1) of reporting hosts that
+// trust fingerprint. FingerprintCoverage returns 0 if no host has reported.
+func (c Coverage) FingerprintCoverage(fingerprint string) float64 {
+	return c.fingerprintCoverage(fingerprint)
+}
@@ -0,0 +1,86 @@
+package trustcoverage
+
+import (
+	"time"
+)
+
+type clock interface {
+	Now() time.Time
+}
+
+type systemClockType struct{}
+
+func (s systemClockType) Now() time.Time {
+	return time.Now()
+}
+
+var (
+	kSystemClock systemClockType
+)
+
+type hostReport struct {
+	Fingerprints []string
+	KRLVersion   uint64
+	Ts           time.Time
+}
+
+func newForTesting(maxAge time.Duration, clock clock) *Tracker {
+	return &Tracker{
+		clock:  clock,
+		maxAge: maxAge,
+		hosts:  make(map[string]hostReport),
+	}
+}
+
+func (t *Tracker) report(hostname string, fingerprints []string,
+	krlVersion uint64) {
+	if t == nil {
+		return
+	}
+	fingerprintsCopy := make([]string, len(fingerprints))
+	copy(fingerprintsCopy, fingerprints)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hosts[hostname] = hostReport{
+		Fingerprints: fingerprintsCopy,
+		KRLVersion:   krlVersion,
+		Ts:           t.clock.Now(),
+	}
+}
+
+func (t *Tracker) getCoverage() Coverage {
+	coverage := Coverage{
+		Fingerprints: make(map[string]int),
+		KRLVersions:  make(map[uint64]int),
+	}
+	if t == nil {
+		return coverage
+	}
+	now := t.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for hostname, report := range t.hosts {
+		if now.Sub(report.Ts) >= t.maxAge {
+			delete(t.hosts, hostname)
+			continue
+		}
+		coverage.TotalHosts++
+		coverage.KRLVersions[report.KRLVersion]++
+		seen := make(map[string]struct{})
+		for _, fingerprint := range report.Fingerprints {
+			if _, ok := seen[fingerprint]; ok {
+				continue
+			}
+			seen[fingerprint] = struct{}{}
+			coverage.Fingerprints[fingerprint]++
+		}
+	}
+	return coverage
+}
+
+func (c Coverage) fingerprintCoverage(fingerprint string) float64 {
+	if c.TotalHosts == 0 {
+		return 0
+	}
+	return float64(c.Fingerprints[fingerprint]) / float64(c.TotalHosts)
+}
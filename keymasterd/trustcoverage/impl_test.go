@@ -0,0 +1,57 @@
+package trustcoverage
+
+import (
+	"testing"
+	"time"
+)
+
+type testClockType struct {
+	NowTime time.Time
+}
+
+func (t *testClockType) Now() time.Time {
+	return t.NowTime
+}
+
+func (t *testClockType) Advance(d time.Duration) {
+	t.NowTime = t.NowTime.Add(d)
+}
+
+func TestCoverage(t *testing.T) {
+	clock := &testClockType{NowTime: time.Date(2019, 8, 1, 0, 0, 0, 0, time.UTC)}
+	tracker := newForTesting(time.Hour, clock)
+	if coverage := tracker.GetCoverage(); coverage.FingerprintCoverage("a") != 0 {
+		t.Fatalf("empty tracker should have no coverage")
+	}
+	tracker.Report("host1", []string{"a", "b", "a"}, 3)
+	clock.Advance(30 * time.Minute)
+	tracker.Report("host2", []string{"a"}, 3)
+	tracker.Report("host3", []string{"b"}, 2)
+	coverage := tracker.GetCoverage()
+	if coverage.TotalHosts != 3 {
+		t.Fatalf("expected 3 hosts, got %d", coverage.TotalHosts)
+	}
+	if coverage.Fingerprints["a"] != 2 {
+		t.Fatalf("expected 2 hosts for a, got %d", coverage.Fingerprints["a"])
+	}
+	if coverage.KRLVersions[3] != 2 || coverage.KRLVersions[2] != 1 {
+		t.Fatalf("unexpected KRL versions %v", coverage.KRLVersions)
+	}
+	if value := coverage.FingerprintCoverage("b"); value < 0.66 || value > 0.67 {
+		t.Fatalf("unexpected coverage for b: %f", value)
+	}
+	// host1 report expires
+	clock.Advance(31 * time.Minute)
+	coverage = tracker.GetCoverage()
+	if coverage.TotalHosts != 2 {
+		t.Fatalf("expected 2 hosts, got %d", coverage.TotalHosts)
+	}
+	if value := coverage.FingerprintCoverage("a"); value != 0.5 {
+		t.Fatalf("unexpected coverage for a: %f", value)
+	}
+	// A new report replaces the previous one
+	tracker.Report("host3", []string{"a"}, 3)
+	if value := tracker.GetCoverage().FingerprintCoverage("a"); value != 1 {
+		t.Fatalf("unexpected coverage for a: %f", value)
+	}
+}
@@ -0,0 +1,51 @@
+// Package usageanalytics summarises certificate issuance from the
+// attestation log for capacity planning: volume over time, unique users and
+// the key types and authentication backends used.
+package usageanalytics
+
+import (
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+)
+
+// Granularities of the buckets of a Summary.
+const (
+	Hourly = "hour"
+	Daily  = "day"
+)
+
+// Bucket is the issuance in one hour or day, which begins at Start (UTC).
+type Bucket struct {
+	Start       time.Time `json:"start"`
+	Issued      int       `json:"issued"`
+	UniqueUsers int       `json:"unique_users"`
+}
+
+// Summary is the issuance between Start and End.
+type Summary struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Granularity string    `json:"granularity"`
+	Issued      int       `json:"issued"`
+	UniqueUsers int       `json:"unique_users"`
+	// Automation counts IP restricted automation requests.
+	Automation int `json:"automation"`
+	// PeakIssued is the largest Issued of any bucket.
+	PeakIssued int `json:"peak_issued"`
+	// The counts by certificate type or host profile, key type and
+	// authentication backend. Issuances with several backends count for
+	// each.
+	ByPolicy      map[string]int `json:"by_policy"`
+	ByKeyType     map[string]int `json:"by_key_type"`
+	ByAuthBackend map[string]int `json:"by_auth_backend"`
+	// Buckets cover the whole window, oldest first, including empty ones.
+	Buckets []Bucket `json:"buckets"`
+}
+
+// Summarise aggregates the issued events with start <= Time < end into
+// buckets of granularity (Hourly or Daily).
+func Summarise(events []attestation.Event, start, end time.Time,
+	granularity string) (*Summary, error) {
+	return summarise(events, start, end, granularity)
+}
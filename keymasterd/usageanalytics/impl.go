@@ -0,0 +1,97 @@
+package usageanalytics
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+)
+
+// Limits the size of a response.
+const maxBuckets = 24 * 400
+
+const unknownKeyType = "unknown"
+
+func bucketStart(t time.Time, granularity string) time.Time {
+	if granularity == Hourly {
+		return t.Truncate(time.Hour)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func nextBucket(t time.Time, granularity string) time.Time {
+	if granularity == Hourly {
+		return t.Add(time.Hour)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+func summarise(events []attestation.Event, start, end time.Time,
+	granularity string) (*Summary, error) {
+	if granularity != Hourly && granularity != Daily {
+		return nil, fmt.Errorf("usageanalytics: unknown granularity: %s",
+			granularity)
+	}
+	start = start.UTC()
+	end = end.UTC()
+	if !start.Before(end) {
+		return nil, errors.New("usageanalytics: empty window")
+	}
+	summary := &Summary{
+		Start:         start,
+		End:           end,
+		Granularity:   granularity,
+		ByPolicy:      make(map[string]int),
+		ByKeyType:     make(map[string]int),
+		ByAuthBackend: make(map[string]int),
+	}
+	bucketIndex := make(map[time.Time]int)
+	for t := bucketStart(start, granularity); t.Before(end); t = nextBucket(t,
+		granularity) {
+		if len(summary.Buckets) >= maxBuckets {
+			return nil, errors.New("usageanalytics: window too large")
+		}
+		bucketIndex[t] = len(summary.Buckets)
+		summary.Buckets = append(summary.Buckets, Bucket{Start: t})
+	}
+	users := make(map[string]struct{})
+	bucketUsers := make([]map[string]struct{}, len(summary.Buckets))
+	for _, event := range events {
+		if event.Type != attestation.EventIssued {
+			continue
+		}
+		eventTime := event.Time.UTC()
+		if eventTime.Before(start) || !eventTime.Before(end) {
+			continue
+		}
+		index := bucketIndex[bucketStart(eventTime, granularity)]
+		summary.Buckets[index].Issued++
+		if bucketUsers[index] == nil {
+			bucketUsers[index] = make(map[string]struct{})
+		}
+		bucketUsers[index][event.Username] = struct{}{}
+		users[event.Username] = struct{}{}
+		summary.Issued++
+		if event.Automation {
+			summary.Automation++
+		}
+		summary.ByPolicy[event.Policy]++
+		keyType := event.KeyType
+		if keyType == "" {
+			keyType = unknownKeyType
+		}
+		summary.ByKeyType[keyType]++
+		for _, method := range event.AuthMethods {
+			summary.ByAuthBackend[method]++
+		}
+	}
+	summary.UniqueUsers = len(users)
+	for index := range summary.Buckets {
+		summary.Buckets[index].UniqueUsers = len(bucketUsers[index])
+		if summary.Buckets[index].Issued > summary.PeakIssued {
+			summary.PeakIssued = summary.Buckets[index].Issued
+		}
+	}
+	return summary, nil
+}
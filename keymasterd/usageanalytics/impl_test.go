@@ -0,0 +1,60 @@
+package usageanalytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/attestation"
+)
+
+func TestSummarise(t *testing.T) {
+	start := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	events := []attestation.Event{
+		{Type: attestation.EventIssued, Time: start.Add(time.Minute),
+			Username: "alice", Policy: "ssh", KeyType: "Ed25519",
+			AuthMethods: []string{"password", "U2F"}},
+		{Type: attestation.EventIssued, Time: start.Add(2 * time.Minute),
+			Username: "alice", Policy: "x509", KeyType: "RSA-2048",
+			AuthMethods: []string{"password", "U2F"}},
+		{Type: attestation.EventIssued, Time: start.Add(25 * time.Hour),
+			Username: "robot", Policy: "ssh", Automation: true,
+			AuthMethods: []string{"IPCertificate"}},
+		{Type: attestation.EventRevoked, Time: start.Add(time.Hour)},
+		{Type: attestation.EventIssued, Time: start.Add(-time.Minute),
+			Username: "early"},
+	}
+	summary, err := Summarise(events, start, start.Add(48*time.Hour), Daily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Issued != 3 || summary.UniqueUsers != 2 ||
+		summary.Automation != 1 || summary.PeakIssued != 2 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	if len(summary.Buckets) != 2 || summary.Buckets[0].UniqueUsers != 1 ||
+		summary.Buckets[1].Issued != 1 {
+		t.Fatalf("unexpected buckets: %+v", summary.Buckets)
+	}
+	if summary.ByKeyType["Ed25519"] != 1 ||
+		summary.ByKeyType[unknownKeyType] != 1 {
+		t.Fatalf("unexpected key types: %v", summary.ByKeyType)
+	}
+	if summary.ByAuthBackend["U2F"] != 2 || summary.ByPolicy["ssh"] != 2 {
+		t.Fatalf("unexpected counts: %v %v", summary.ByAuthBackend,
+			summary.ByPolicy)
+	}
+	hourly, err := Summarise(events, start, start.Add(48*time.Hour), Hourly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hourly.Buckets) != 48 || hourly.Buckets[25].Issued != 1 {
+		t.Fatal("unexpected hourly buckets")
+	}
+	if _, err := Summarise(events, start, start, Daily); err == nil {
+		t.Fatal("empty window should fail")
+	}
+	if _, err := Summarise(events, start, start.Add(time.Hour),
+		"week"); err == nil {
+		t.Fatal("unknown granularity should fail")
+	}
+}
@@ -0,0 +1,67 @@
+// Package authorizedkeys inventories the static SSH keys of a host, for host
+// agents migrating it to SSH certificates. The agent runs Inventory
+// periodically and sends the result to keymaster with PostReport,
+// authenticating with its IP restricted certificate. Keymaster offers the
+// users the certification of the keys found and reports, per host, how many
+// static keys remain and how often they are still used.
+package authorizedkeys
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+// DefaultAuthorizedKeysFiles are the files sshd reads by default.
+var DefaultAuthorizedKeysFiles = []string{
+	".ssh/authorized_keys", ".ssh/authorized_keys2"}
+
+// Config selects the files Inventory reads.
+type Config struct {
+	// Hostname defaults to os.Hostname().
+	Hostname string
+	// PasswdFilename lists the users. Default: /etc/passwd.
+	PasswdFilename string
+	// AuthorizedKeysFiles are as the AuthorizedKeysFile option of sshd:
+	// %h is replaced by the home directory of the user, %u by the username
+	// and %% by %. Relative names are in the home directory. Default:
+	// DefaultAuthorizedKeysFiles.
+	AuthorizedKeysFiles []string
+	// SSHDLogFilenames are the logs where sshd logs logins, such as
+	// /var/log/auth.log or /var/log/secure, to count the logins with each
+	// key and with certificates. Missing logs are skipped.
+	SSHDLogFilenames []string
+}
+
+// Inventory returns the static keys of the users of the host. Lines of
+// authorized_keys files which trust a certificate authority are not static
+// keys and are left out. Unreadable files are skipped.
+func Inventory(config Config) (*proto.StaticKeysReport, error) {
+	return inventory(config, time.Now())
+}
+
+// ParseAuthorizedKeys returns the keys in data, the contents of the
+// authorized_keys file filename of username. Invalid lines are skipped.
+func ParseAuthorizedKeys(username, filename string,
+	data []byte) []proto.StaticKey {
+	return parseAuthorizedKeys(username, filename, data)
+}
+
+// CountLogins reads an sshd log and adds the public key logins it finds to
+// the Logins and LastLogin of the matching keys of report, and the
+// certificate logins to its CertificateLogins. now is needed for the
+// syslog timestamps, which have no year.
+func CountLogins(report *proto.StaticKeysReport, log io.Reader,
+	now time.Time) error {
+	return countLogins(report, log, now)
+}
+
+// PostReport sends report to the keymaster at baseURL (e.g.
+// https://keymaster.example.com). client must present the IP restricted
+// certificate of the host.
+func PostReport(client *http.Client, baseURL string,
+	report *proto.StaticKeysReport) error {
+	return postReport(client, baseURL, report)
+}
@@ -0,0 +1,242 @@
+package authorizedkeys
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultPasswdFilename = "/etc/passwd"
+	syslogTimeLayout      = "Jan _2 15:04:05"
+)
+
+var acceptedPublicKeyRegexp = regexp.MustCompile(
+	`Accepted publickey for (\S+) from \S+ port \d+ ssh2: (\S+) (SHA256:[A-Za-z0-9+/=]+)`)
+
+type passwdEntry struct {
+	username string
+	home     string
+}
+
+func readPasswd(filename string) ([]passwdEntry, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var entries []passwdEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 7 || fields[0] == "" || fields[5] == "" ||
+			strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		entries = append(entries, passwdEntry{fields[0], fields[5]})
+	}
+	return entries, nil
+}
+
+func expandAuthorizedKeysFile(pattern string, user passwdEntry) string {
+	var expanded strings.Builder
+	for index := 0; index < len(pattern); index++ {
+		if pattern[index] != '%' || index+1 >= len(pattern) {
+			expanded.WriteByte(pattern[index])
+			continue
+		}
+		index++
+		switch pattern[index] {
+		case 'h':
+			expanded.WriteString(user.home)
+		case 'u':
+			expanded.WriteString(user.username)
+		default:
+			expanded.WriteByte(pattern[index])
+		}
+	}
+	filename := expanded.String()
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(user.home, filename)
+	}
+	return filename
+}
+
+func inventory(config Config, now time.Time) (*proto.StaticKeysReport,
+	error) {
+	report := &proto.StaticKeysReport{Hostname: config.Hostname,
+		Keys: []proto.StaticKey{}}
+	if report.Hostname == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		report.Hostname = hostname
+	}
+	if config.PasswdFilename == "" {
+		config.PasswdFilename = defaultPasswdFilename
+	}
+	if len(config.AuthorizedKeysFiles) < 1 {
+		config.AuthorizedKeysFiles = DefaultAuthorizedKeysFiles
+	}
+	users, err := readPasswd(config.PasswdFilename)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		seen := make(map[string]struct{})
+		for _, pattern := range config.AuthorizedKeysFiles {
+			filename := expandAuthorizedKeysFile(pattern, user)
+			if _, ok := seen[filename]; ok {
+				continue
+			}
+			seen[filename] = struct{}{}
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				continue
+			}
+			report.Keys = append(report.Keys,
+				parseAuthorizedKeys(user.username, filename, data)...)
+		}
+	}
+	for _, filename := range config.SSHDLogFilenames {
+		file, err := os.Open(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		err = countLogins(report, file, now)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+	}
+	return report, nil
+}
+
+func isCertAuthority(options []string) bool {
+	for _, option := range options {
+		if strings.EqualFold(option, "cert-authority") {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAuthorizedKeys(username, filename string,
+	data []byte) []proto.StaticKey {
+	var keys []proto.StaticKey
+	for len(data) > 0 {
+		pubKey, comment, options, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			break
+		}
+		data = rest
+		if _, ok := pubKey.(*ssh.Certificate); ok || isCertAuthority(options) {
+			continue
+		}
+		keys = append(keys, proto.StaticKey{
+			Username: username,
+			Filename: filename,
+			PublicKey: strings.TrimSpace(
+				string(ssh.MarshalAuthorizedKey(pubKey))),
+			Fingerprint: ssh.FingerprintSHA256(pubKey),
+			Comment:     comment,
+			Options:     options,
+		})
+	}
+	return keys
+}
+
+// parseLogTime returns the time of a syslog line, with an RFC 3339 or a
+// traditional timestamp. The year of the latter is the one which puts the
+// line in the year before now.
+func parseLogTime(line string, now time.Time) (time.Time, bool) {
+	if index := strings.IndexByte(line, ' '); index > 0 {
+		if t, err := time.Parse(time.RFC3339, line[:index]); err == nil {
+			return t, true
+		}
+	}
+	if len(line) < len(syslogTimeLayout) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(syslogTimeLayout,
+		line[:len(syslogTimeLayout)], now.Location())
+	if err != nil {
+		return time.Time{}, false
+	}
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, true
+}
+
+func countLogins(report *proto.StaticKeysReport, log io.Reader,
+	now time.Time) error {
+	keys := make(map[string][]int)
+	for index, key := range report.Keys {
+		name := key.Username + " " + key.Fingerprint
+		keys[name] = append(keys[name], index)
+	}
+	scanner := bufio.NewScanner(log)
+	for scanner.Scan() {
+		line := scanner.Text()
+		match := acceptedPublicKeyRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		if strings.HasSuffix(match[2], "-CERT") {
+			report.CertificateLogins++
+			continue
+		}
+		indexes := keys[match[1]+" "+match[3]]
+		if len(indexes) < 1 {
+			continue
+		}
+		loginTime, haveTime := parseLogTime(line, now)
+		// A key in several files of the user is counted once.
+		key := &report.Keys[indexes[0]]
+		key.Logins++
+		if haveTime && (key.LastLogin == nil || loginTime.After(*key.LastLogin)) {
+			key.LastLogin = &loginTime
+		}
+		for _, index := range indexes[1:] {
+			report.Keys[index].Logins = key.Logins
+			report.Keys[index].LastLogin = key.LastLogin
+		}
+	}
+	return scanner.Err()
+}
+
+func postReport(client *http.Client, baseURL string,
+	report *proto.StaticKeysReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(strings.TrimSuffix(baseURL, "/")+
+		proto.StaticKeysReportPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("keymaster returned %s: %s", resp.Status,
+			strings.TrimSpace(string(message)))
+	}
+	return nil
+}
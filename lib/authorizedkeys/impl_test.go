@@ -0,0 +1,146 @@
+package authorizedkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pubKey
+}
+
+func authorizedKey(pubKey ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pubKey)))
+}
+
+func TestInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authorizedkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	aliceHome := filepath.Join(dir, "alice")
+	if err := os.MkdirAll(filepath.Join(aliceHome, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	passwd := fmt.Sprintf(
+		"alice:x:1000:1000::%s:/bin/sh\nbob:x:1001:1001::%s:/bin/sh\n",
+		aliceHome, filepath.Join(dir, "bob"))
+	passwdFilename := filepath.Join(dir, "passwd")
+	if err := ioutil.WriteFile(passwdFilename, []byte(passwd), 0600); err != nil {
+		t.Fatal(err)
+	}
+	laptopKey := newTestPublicKey(t)
+	deployKey := newTestPublicKey(t)
+	caKey := newTestPublicKey(t)
+	authorizedKeys := "# keys of alice\n" +
+		authorizedKey(laptopKey) + " alice@laptop\n" +
+		`from="10.0.0.0/8",no-pty ` + authorizedKey(deployKey) + " deploy\n" +
+		"garbage\n" +
+		"cert-authority " + authorizedKey(caKey) + " ca\n"
+	err = ioutil.WriteFile(filepath.Join(aliceHome, ".ssh", "authorized_keys"),
+		[]byte(authorizedKeys), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop := ssh.FingerprintSHA256(laptopKey)
+	log := "Oct 13 09:00:00 host sshd[1]: Accepted publickey for alice from 10.1.1.1 port 5000 ssh2: ED25519 " + laptop + "\n" +
+		"2026-10-14T08:00:00.000000+00:00 host sshd[2]: Accepted publickey for alice from 10.1.1.1 port 5001 ssh2: ED25519 " + laptop + "\n" +
+		"Oct 14 09:30:00 host sshd[3]: Accepted publickey for alice from 10.1.1.1 port 5002 ssh2: ED25519-CERT SHA256:abc ID alice (serial 7) CA RSA SHA256:def\n" +
+		"Oct 14 09:31:00 host sshd[4]: Accepted publickey for bob from 10.1.1.1 port 5003 ssh2: ED25519 " + laptop + "\n" +
+		"Oct 14 09:32:00 host sshd[5]: Accepted password for alice from 10.1.1.1 port 5004 ssh2\n"
+	logFilename := filepath.Join(dir, "auth.log")
+	if err := ioutil.WriteFile(logFilename, []byte(log), 0600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	report, err := inventory(Config{
+		Hostname:         "host1",
+		PasswdFilename:   passwdFilename,
+		SSHDLogFilenames: []string{logFilename, filepath.Join(dir, "secure")},
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Hostname != "host1" || len(report.Keys) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if key := report.Keys[0]; key.Username != "alice" ||
+		key.Fingerprint != laptop || key.Comment != "alice@laptop" ||
+		key.PublicKey != authorizedKey(laptopKey) || key.Logins != 2 ||
+		key.LastLogin == nil || !key.LastLogin.Equal(
+		time.Date(2026, 10, 14, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected key: %+v", key)
+	}
+	if key := report.Keys[1]; len(key.Options) != 2 ||
+		key.Options[0] != `from="10.0.0.0/8"` || key.Logins != 0 {
+		t.Errorf("unexpected key: %+v", key)
+	}
+	if report.CertificateLogins != 1 {
+		t.Errorf("%d certificate logins", report.CertificateLogins)
+	}
+}
+
+func TestParseLogTime(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	loginTime, ok := parseLogTime("Dec 31 23:00:00 host sshd[1]: ...", now)
+	if !ok || !loginTime.Equal(time.Date(2025, 12, 31, 23, 0, 0, 0,
+		time.UTC)) {
+		t.Errorf("unexpected time: %s", loginTime)
+	}
+	if _, ok := parseLogTime("sshd: no time", now); ok {
+		t.Error("time found in a line without")
+	}
+}
+
+func TestPostReport(t *testing.T) {
+	received := make(chan proto.StaticKeysReport, 1)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != proto.StaticKeysReportPath {
+				http.NotFound(w, r)
+				return
+			}
+			var report proto.StaticKeysReport
+			if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			received <- report
+		}))
+	defer server.Close()
+	err := PostReport(server.Client(), server.URL+"/",
+		&proto.StaticKeysReport{Hostname: "host1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := <-received; report.Hostname != "host1" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	err = PostReport(server.Client(), server.URL+"/missing",
+		&proto.StaticKeysReport{Hostname: "host1"})
+	if err == nil {
+		t.Error("error status ignored")
+	}
+}
@@ -1,7 +1,9 @@
 package authutil
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
@@ -85,7 +87,39 @@ func Argon2CompareHashAndPassword(hash string, password []byte) error {
 	//return nil
 }
 
+// PasswordCheckError is returned by CheckHtpasswdUserPassword when the
+// credentials are rejected, as opposed to errors reading the file.
+type PasswordCheckError string
+
+const (
+	ErrUserNotFound PasswordCheckError = "user not found"
+	ErrBadPassword  PasswordCheckError = "bad password"
+)
+
+func (e PasswordCheckError) Error() string {
+	return string(e)
+}
+
+// IsPasswordRejected returns true if err is a PasswordCheckError.
+func IsPasswordRejected(err error) bool {
+	_, ok := err.(PasswordCheckError)
+	return ok
+}
+
+// CheckHtpasswdUserPassword checks password against the entry for username
+// in htpasswdBytes. bcrypt ($2y$, $2a$, $2b$) and SHA-512 crypt ($6$) hashes
+// are supported. If the user does not exist or the password does not match
+// it returns false and ErrUserNotFound or ErrBadPassword respectively.
 func CheckHtpasswdUserPassword(username string, password string, htpasswdBytes []byte) (bool, error) {
+	return CheckHtpasswdUserPasswordContext(context.Background(), username,
+		password, htpasswdBytes)
+}
+
+// CheckHtpasswdUserPasswordContext is CheckHtpasswdUserPassword but returns
+// ctx.Err() without computing the hash if ctx is done, so that abandoned
+// requests do not keep the CPU busy with bcrypt.
+func CheckHtpasswdUserPasswordContext(ctx context.Context, username string,
+	password string, htpasswdBytes []byte) (bool, error) {
 	//	secrets := HtdigestFileProvider(htpasswdFilename)
 	passwords, err := htpasswd.ParseHtpasswd(htpasswdBytes)
 	if err != nil {
@@ -93,39 +127,76 @@ func CheckHtpasswdUserPassword(username string, password string, htpasswdBytes [
 	}
 	hash, ok := passwords[username]
 	if !ok {
-		return false, nil
+		return false, ErrUserNotFound
 	}
-	// only understand bcrypt
-	if !strings.HasPrefix(hash, "$2y$") {
-		err := errors.New("Can only use bcrypt for htpasswd")
+	if err := ctx.Err(); err != nil {
 		return false, err
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	if err != nil {
-		return false, nil
+	switch {
+	case strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$"):
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, ErrBadPassword
+		}
+		if err != nil {
+			return false, err
+		}
+	case strings.HasPrefix(hash, sha512CryptPrefix):
+		computedHash, err := sha512Crypt([]byte(password), hash)
+		if err != nil {
+			return false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(computedHash), []byte(hash)) != 1 {
+			return false, ErrBadPassword
+		}
+	default:
+		return false, errors.New("Can only use bcrypt or SHA-512 crypt for htpasswd")
 	}
 	return true, nil
-
 }
 
+// getLDAPConnection returns a started connection with a timeout of
+// timeoutSecs to the server in u.
 func getLDAPConnection(u url.URL, timeoutSecs uint, rootCAs *x509.CertPool) (*ldap.Conn, string, error) {
-	if u.Scheme != "ldaps" {
-		err := errors.New("Invalid ldap scheme (we only support ldaps")
+	return getLDAPConnectionContext(context.Background(), u, timeoutSecs,
+		rootCAs)
+}
+
+// getLDAPConnectionContext is getLDAPConnection but gives up connecting
+// once ctx is done.
+func getLDAPConnectionContext(ctx context.Context, u url.URL,
+	timeoutSecs uint, rootCAs *x509.CertPool) (*ldap.Conn, string, error) {
+	options, err := parseLDAPURLOptions(&u)
+	if err != nil {
 		return nil, "", err
 	}
 	//hostnamePort := server + ":636"
 	serverPort := strings.Split(u.Host, ":")
 	port := "636"
+	if options.startTLS {
+		port = "389"
+	}
 	if len(serverPort) == 2 {
 		port = serverPort[1]
 	}
 	server := serverPort[0]
 	hostnamePort := server + ":" + port
+	tlsConfig, err := options.makeTLSConfig(server, rootCAs)
+	if err != nil {
+		return nil, "", err
+	}
 
 	timeout := time.Duration(time.Duration(timeoutSecs) * time.Second)
 	start := time.Now()
-	tlsConn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", hostnamePort,
-		&tls.Config{ServerName: server, RootCAs: rootCAs})
+	var rawConn net.Conn
+	dialer := &net.Dialer{Timeout: timeout}
+	if options.startTLS {
+		rawConn, err = dialer.DialContext(ctx, "tcp", hostnamePort)
+	} else {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+		rawConn, err = tlsDialer.DialContext(ctx, "tcp", hostnamePort)
+	}
 	if err != nil {
 		errorTime := time.Since(start).Seconds() * 1000
 		log.Printf("connction failure for:%s (%s)(time(ms)=%v)", server, err.Error(), errorTime)
@@ -133,7 +204,21 @@ func getLDAPConnection(u url.URL, timeoutSecs uint, rootCAs *x509.CertPool) (*ld
 	}
 
 	// we dont close the tls connection directly  close defer to the new ldap connection
-	conn := ldap.NewConn(tlsConn, true)
+	conn := ldap.NewConn(rawConn, !options.startTLS)
+	conn.SetTimeout(timeout)
+	conn.Start()
+	if options.startTLS {
+		stop := closeOnDone(ctx, conn)
+		err := conn.StartTLS(tlsConfig)
+		if stop() {
+			return nil, "", ctx.Err()
+		}
+		if err != nil {
+			conn.Close()
+			log.Printf("StartTLS failure for:%s (%s)", server, err.Error())
+			return nil, "", err
+		}
+	}
 	return conn, server, nil
 }
 
@@ -143,15 +228,24 @@ func CheckLDAPConnection(u url.URL, timeoutSecs uint, rootCAs *x509.CertPool) er
 		return err
 	}
 	defer conn.Close()
-	timeout := time.Duration(time.Duration(timeoutSecs) * time.Second)
-	conn.SetTimeout(timeout)
-	conn.Start()
 	return nil
 }
 
 func CheckLDAPUserPassword(u url.URL, bindDN string, bindPassword string, timeoutSecs uint, rootCAs *x509.CertPool) (bool, error) {
-	timeout := time.Duration(time.Duration(timeoutSecs) * time.Second)
-	conn, server, err := getLDAPConnection(u, timeoutSecs, rootCAs)
+	return CheckLDAPUserPasswordContext(context.Background(), u, bindDN,
+		bindPassword, timeoutSecs, rootCAs)
+}
+
+// CheckLDAPUserPasswordContext is CheckLDAPUserPassword but aborts the
+// connection and bind once ctx is done, returning ctx.Err().
+func CheckLDAPUserPasswordContext(ctx context.Context, u url.URL,
+	bindDN string, bindPassword string, timeoutSecs uint,
+	rootCAs *x509.CertPool) (bool, error) {
+	// An empty password would be an unauthenticated bind, which succeeds.
+	if bindPassword == "" {
+		return false, nil
+	}
+	conn, server, err := getLDAPConnectionContext(ctx, u, timeoutSecs, rootCAs)
 	if err != nil {
 		return false, err
 	}
@@ -159,9 +253,11 @@ func CheckLDAPUserPassword(u url.URL, bindDN string, bindPassword string, timeou
 
 	//connectionTime := time.Since(start).Seconds() * 1000
 
-	conn.SetTimeout(timeout)
-	conn.Start()
+	stop := closeOnDone(ctx, conn)
 	err = conn.Bind(bindDN, bindPassword)
+	if stop() {
+		return false, ctx.Err()
+	}
 	if err != nil {
 		log.Printf("Bind failure for server:%s bindDN:'%s' (%s)", server, bindDN, err.Error())
 		if strings.Contains(err.Error(), "Invalid Credentials") {
@@ -172,20 +268,22 @@ func CheckLDAPUserPassword(u url.URL, bindDN string, bindPassword string, timeou
 	return true, nil
 }
 
+// ParseLDAPURL parses and validates an LDAP target URL. Besides ldaps URLs,
+// ldap URLs are accepted with the starttls=true option. See
+// parseLDAPURLOptions for the TLS options which may be set per URL.
 func ParseLDAPURL(ldapUrl string) (*url.URL, error) {
 	u, err := url.Parse(ldapUrl)
 	if err != nil {
 		return nil, err
 	}
-	if u.Scheme != "ldaps" {
-		err := errors.New("Invalid ldap scheme (we only support ldaps")
+	if _, err := parseLDAPURLOptions(u); err != nil {
 		return nil, err
 	}
 	//extract port if any... and if NIL then set it to 636
 	return u, nil
 }
 
-func getUserDNAndSimpleGroups(conn *ldap.Conn, UserSearchBaseDNs []string, UserSearchFilter string, username string) (string, []string, error) {
+func getUserDNAndSimpleGroups(conn ldapConnection, UserSearchBaseDNs []string, UserSearchFilter string, username string) (string, []string, error) {
 	for _, searchDN := range UserSearchBaseDNs {
 		searchRequest := ldap.NewSearchRequest(
 			searchDN,
@@ -210,7 +308,7 @@ func getUserDNAndSimpleGroups(conn *ldap.Conn, UserSearchBaseDNs []string, UserS
 	return "", nil, nil
 }
 
-func getSimpleUserAttributes(conn *ldap.Conn, UserSearchBaseDNs []string,
+func getSimpleUserAttributes(conn ldapConnection, UserSearchBaseDNs []string,
 	UserSearchFilter string, username string, attributes []string) (m map[string][]string, err error) {
 	for _, searchDN := range UserSearchBaseDNs {
 		searchRequest := ldap.NewSearchRequest(
@@ -240,7 +338,7 @@ func getSimpleUserAttributes(conn *ldap.Conn, UserSearchBaseDNs []string,
 }
 
 func extractCNFromDNString(input []string) (output []string, err error) {
-	re := regexp.MustCompile("^cn=([^,]+),.*")
+	re := regexp.MustCompile("(?i)^cn=([^,]+),.*")
 	for _, dn := range input {
 		matches := re.FindStringSubmatch(dn)
 		if len(matches) == 2 {
@@ -253,7 +351,7 @@ func extractCNFromDNString(input []string) (output []string, err error) {
 	return output, nil
 }
 
-func getUserGroupsRFC2307bis(conn *ldap.Conn, UserSearchBaseDNs []string,
+func getUserGroupsRFC2307bis(conn ldapConnection, UserSearchBaseDNs []string,
 	UserSearchFilter string, username string) ([]string, error) {
 	dn, groupDNs, err := getUserDNAndSimpleGroups(conn, UserSearchBaseDNs, UserSearchFilter, username)
 	if err != nil {
@@ -269,7 +367,35 @@ func getUserGroupsRFC2307bis(conn *ldap.Conn, UserSearchBaseDNs []string,
 	return groupCNs, nil
 }
 
-func getUserGroupsRFC2307(conn *ldap.Conn, GroupSearchBaseDNs []string,
+func getNestedUserGroups(conn ldapConnection, UserSearchBaseDNs []string,
+	UserSearchFilter string, GroupSearchBaseDNs []string, username string,
+	nestedGroupsMode string) ([]string, error) {
+	dn, directGroupDNs, err := getUserDNAndSimpleGroups(conn, UserSearchBaseDNs, UserSearchFilter, username)
+	if err != nil {
+		return nil, err
+	}
+	if dn == "" {
+		return nil, errors.New("User does not exist or too many entries returned")
+	}
+	var groupDNs []string
+	switch nestedGroupsMode {
+	case NestedGroupsInChain:
+		searchDNs := GroupSearchBaseDNs
+		if len(searchDNs) < 1 {
+			searchDNs = UserSearchBaseDNs
+		}
+		groupDNs, err = getInChainGroupDNs(conn, searchDNs, dn)
+		groupDNs = append(groupDNs, directGroupDNs...)
+	case NestedGroupsRecursive:
+		groupDNs, err = expandGroupDNs(conn, directGroupDNs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return extractCNFromDNString(groupDNs)
+}
+
+func getUserGroupsRFC2307(conn ldapConnection, GroupSearchBaseDNs []string,
 	groupSearchFilter string, username string) (userGroups []string, err error) {
 	for _, searchDN := range GroupSearchBaseDNs {
 		searchRequest := ldap.NewSearchRequest(
@@ -296,16 +422,81 @@ func GetLDAPUserGroups(u url.URL, bindDN string, bindPassword string,
 	username string,
 	UserSearchBaseDNs []string, UserSearchFilter string,
 	GroupSearchBaseDNs []string, GroupSearchFilter string) ([]string, error) {
-	timeout := time.Duration(time.Duration(timeoutSecs) * time.Second)
-	conn, _, err := getLDAPConnection(u, timeoutSecs, rootCAs)
+	return GetLDAPUserGroupsNested(u, bindDN, bindPassword, timeoutSecs,
+		rootCAs, username, UserSearchBaseDNs, UserSearchFilter,
+		GroupSearchBaseDNs, GroupSearchFilter, NestedGroupsNone)
+}
+
+// GetLDAPUserGroupsNested is like GetLDAPUserGroups but also returns the
+// groups the user is a member of through other groups, resolved according
+// to nestedGroupsMode (one of the NestedGroups* constants).
+func GetLDAPUserGroupsNested(u url.URL, bindDN string, bindPassword string,
+	timeoutSecs uint, rootCAs *x509.CertPool,
+	username string,
+	UserSearchBaseDNs []string, UserSearchFilter string,
+	GroupSearchBaseDNs []string, GroupSearchFilter string,
+	nestedGroupsMode string) ([]string, error) {
+	return GetLDAPUserGroupsWithReferrals(u, bindDN, bindPassword, timeoutSecs,
+		rootCAs, username, UserSearchBaseDNs, UserSearchFilter,
+		GroupSearchBaseDNs, GroupSearchFilter, nestedGroupsMode,
+		LDAPReferralPolicy{})
+}
+
+// GetLDAPUserGroupsWithReferrals is like GetLDAPUserGroupsNested but handles
+// referrals according to referrals.
+func GetLDAPUserGroupsWithReferrals(u url.URL, bindDN string,
+	bindPassword string, timeoutSecs uint, rootCAs *x509.CertPool,
+	username string,
+	UserSearchBaseDNs []string, UserSearchFilter string,
+	GroupSearchBaseDNs []string, GroupSearchFilter string,
+	nestedGroupsMode string, referrals LDAPReferralPolicy) ([]string, error) {
+	return GetLDAPUserGroupsWithReferralsContext(context.Background(), u,
+		bindDN, bindPassword, timeoutSecs, rootCAs, username,
+		UserSearchBaseDNs, UserSearchFilter, GroupSearchBaseDNs,
+		GroupSearchFilter, nestedGroupsMode, referrals)
+}
+
+// GetLDAPUserGroupsWithReferralsContext is GetLDAPUserGroupsWithReferrals
+// but closes the connection to the server once ctx is done, which aborts the
+// searches in progress. Connections opened to follow referrals are only
+// bounded by timeoutSecs.
+func GetLDAPUserGroupsWithReferralsContext(ctx context.Context, u url.URL,
+	bindDN string, bindPassword string, timeoutSecs uint,
+	rootCAs *x509.CertPool, username string,
+	UserSearchBaseDNs []string, UserSearchFilter string,
+	GroupSearchBaseDNs []string, GroupSearchFilter string,
+	nestedGroupsMode string, referrals LDAPReferralPolicy) ([]string, error) {
+	if err := CheckNestedGroupsMode(nestedGroupsMode); err != nil {
+		return nil, err
+	}
+	if err := CheckLDAPReferralPolicy(referrals); err != nil {
+		return nil, err
+	}
+	ldapConn, _, err := getLDAPConnectionContext(ctx, u, timeoutSecs, rootCAs)
 	if err != nil {
 		return nil, err
 	}
+	defer ldapConn.Close()
+	stop := closeOnDone(ctx, ldapConn)
+	userGroups, err := getLDAPUserGroups(ldapConn, bindDN, bindPassword,
+		newLDAPReferrals(u, timeoutSecs, rootCAs, referrals), username,
+		UserSearchBaseDNs, UserSearchFilter, GroupSearchBaseDNs,
+		GroupSearchFilter, nestedGroupsMode)
+	if stop() {
+		return nil, ctx.Err()
+	}
+	return userGroups, err
+}
+
+func getLDAPUserGroups(ldapConn ldapConnection, bindDN string,
+	bindPassword string, referrals *ldapReferrals, username string,
+	UserSearchBaseDNs []string, UserSearchFilter string,
+	GroupSearchBaseDNs []string, GroupSearchFilter string,
+	nestedGroupsMode string) ([]string, error) {
+	conn := newReferralConnection(ldapConn, referrals)
 	defer conn.Close()
 
-	conn.SetTimeout(timeout)
-	conn.Start()
-	err = conn.Bind(bindDN, bindPassword)
+	err := conn.Bind(bindDN, bindPassword)
 	if err != nil {
 		return nil, err
 	}
@@ -313,7 +504,13 @@ func GetLDAPUserGroups(u url.URL, bindDN string, bindPassword string,
 	if err != nil {
 		return nil, err
 	}
-	memberGroups, err := getUserGroupsRFC2307bis(conn, UserSearchBaseDNs, UserSearchFilter, username)
+	var memberGroups []string
+	if nestedGroupsMode == NestedGroupsNone {
+		memberGroups, err = getUserGroupsRFC2307bis(conn, UserSearchBaseDNs, UserSearchFilter, username)
+	} else {
+		memberGroups, err = getNestedUserGroups(conn, UserSearchBaseDNs,
+			UserSearchFilter, GroupSearchBaseDNs, username, nestedGroupsMode)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -336,21 +533,58 @@ func GetLDAPUserAttributes(u url.URL, bindDN string, bindPassword string,
 	username string,
 	UserSearchBaseDNs []string, UserSearchFilter string,
 	attributes []string) (map[string][]string, error) {
+	return GetLDAPUserAttributesWithReferrals(u, bindDN, bindPassword,
+		timeoutSecs, rootCAs, username, UserSearchBaseDNs, UserSearchFilter,
+		attributes, LDAPReferralPolicy{})
+}
 
-	timeout := time.Duration(time.Duration(timeoutSecs) * time.Second)
-	conn, _, err := getLDAPConnection(u, timeoutSecs, rootCAs)
+// GetLDAPUserAttributesWithReferrals is like GetLDAPUserAttributes but
+// handles referrals according to referrals.
+func GetLDAPUserAttributesWithReferrals(u url.URL, bindDN string,
+	bindPassword string, timeoutSecs uint, rootCAs *x509.CertPool,
+	username string,
+	UserSearchBaseDNs []string, UserSearchFilter string,
+	attributes []string, referrals LDAPReferralPolicy) (
+	map[string][]string, error) {
+	return GetLDAPUserAttributesWithReferralsContext(context.Background(), u,
+		bindDN, bindPassword, timeoutSecs, rootCAs, username,
+		UserSearchBaseDNs, UserSearchFilter, attributes, referrals)
+}
+
+// GetLDAPUserAttributesWithReferralsContext is
+// GetLDAPUserAttributesWithReferrals but aborts the search once ctx is done,
+// like GetLDAPUserGroupsWithReferralsContext.
+func GetLDAPUserAttributesWithReferralsContext(ctx context.Context,
+	u url.URL, bindDN string, bindPassword string, timeoutSecs uint,
+	rootCAs *x509.CertPool, username string,
+	UserSearchBaseDNs []string, UserSearchFilter string,
+	attributes []string, referrals LDAPReferralPolicy) (
+	map[string][]string, error) {
+	if err := CheckLDAPReferralPolicy(referrals); err != nil {
+		return nil, err
+	}
+	ldapConn, _, err := getLDAPConnectionContext(ctx, u, timeoutSecs, rootCAs)
 	if err != nil {
 		return nil, err
 	}
+	defer ldapConn.Close()
+	stop := closeOnDone(ctx, ldapConn)
+	conn := newReferralConnection(ldapConn,
+		newLDAPReferrals(u, timeoutSecs, rootCAs, referrals))
 	defer conn.Close()
 
-	conn.SetTimeout(timeout)
-	conn.Start()
 	err = conn.Bind(bindDN, bindPassword)
 	if err != nil {
+		if stop() {
+			return nil, ctx.Err()
+		}
 		return nil, err
 	}
 
-	return getSimpleUserAttributes(conn, UserSearchBaseDNs,
+	attributeMap, err := getSimpleUserAttributes(conn, UserSearchBaseDNs,
 		UserSearchFilter, username, attributes)
+	if stop() {
+		return nil, ctx.Err()
+	}
+	return attributeMap, err
 }
@@ -1,11 +1,14 @@
 package authutil
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/url"
+	"os"
 	"sort"
 	"testing"
 	"time"
@@ -111,6 +114,9 @@ const testHttpURL = `http://www.example.com`
 // This DB has user 'username' with password 'password'
 const userdbContent = `username:$2y$05$D4qQmZbWYqfgtGtez2EGdOkcNne40EdEznOqMvZegQypT8Jdz42Jy`
 
+// This DB has user 'username' with password 'password'
+const sha512UserDBContent = `username:$6$Jx6bMAmgWcgcsFq4$/Q0AAULKpmBIJmnBc6wztSlvOvoLF0qumQ4sO6s1rytfu07JkFQa0VLHCa54uUkU73r3Qy1..vjhCkRs1cL6l/`
+
 // This DB has user 'username' with password 'password'
 const aprUserDBContent = `username:$apr1$9gzRPctr$.5JlM3HCKcMbiwDEuvsB40`
 
@@ -250,9 +256,19 @@ func TestCheckHtpasswdUserPasswordSuccess(t *testing.T) {
 	}
 }
 
+func TestCheckHtpasswdUserPasswordContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ok, err := CheckHtpasswdUserPasswordContext(ctx, "username", "password",
+		[]byte(userdbContent))
+	if ok || err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v, %v", ok, err)
+	}
+}
+
 func TestCheckHtpasswdUserPassworFailBadPassword(t *testing.T) {
 	ok, err := CheckHtpasswdUserPassword("username", "Incorrectpassword", []byte(userdbContent))
-	if err != nil {
+	if err != ErrBadPassword {
 		t.Fatal(err)
 	}
 	if ok != false {
@@ -262,7 +278,7 @@ func TestCheckHtpasswdUserPassworFailBadPassword(t *testing.T) {
 
 func TestCheckHtpasswdUserPasswordFailUknownUsername(t *testing.T) {
 	ok, err := CheckHtpasswdUserPassword("usernameUknown", "password", []byte(userdbContent))
-	if err != nil {
+	if err != ErrUserNotFound {
 		t.Fatal(err)
 	}
 	if ok != false {
@@ -277,6 +293,23 @@ func TestCheckHtpasswdUserPasswordFailInvalidPasswordFileContent(t *testing.T) {
 	}
 }
 
+func TestCheckHtpasswdUserPasswordSHA512Crypt(t *testing.T) {
+	ok, err := CheckHtpasswdUserPassword("username", "password", []byte(sha512UserDBContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("User considerd false")
+	}
+	ok, err = CheckHtpasswdUserPassword("username", "Incorrectpassword", []byte(sha512UserDBContent))
+	if err != ErrBadPassword || ok {
+		t.Fatal("Logged in with bad password")
+	}
+	if !IsPasswordRejected(err) {
+		t.Fatal("bad password should be a rejection")
+	}
+}
+
 func TestCheckHtpasswdUserPasswordFailInvalidNonBcryptHashes(t *testing.T) {
 	_, err := CheckHtpasswdUserPassword("username", "password", []byte(aprUserDBContent))
 	if err == nil {
@@ -466,3 +499,60 @@ func TestArgon2RoundTripFailure(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestParseLDAPURLOptions(t *testing.T) {
+	for _, ldapURL := range []string{
+		"ldap://ldap.example.com?starttls=true",
+		"ldap://ldap.example.com:10389?starttls=true&min_tls_version=1.2",
+		"ldaps://ldap.example.com?ca_file=/etc/ldap/ca.pem&max_tls_version=1.2",
+	} {
+		if _, err := ParseLDAPURL(ldapURL); err != nil {
+			t.Fatalf("%s: %s", ldapURL, err)
+		}
+	}
+	for _, ldapURL := range []string{
+		"ldap://ldap.example.com?starttls=false",
+		"ldaps://ldap.example.com?starttls=true",
+		"ldaps://ldap.example.com?min_tls_version=1.4",
+		"ldaps://ldap.example.com?min_tls_version=1.3&max_tls_version=1.2",
+		"ldaps://ldap.example.com?unknown=1",
+	} {
+		if _, err := ParseLDAPURL(ldapURL); err == nil {
+			t.Fatalf("%s should have failed", ldapURL)
+		}
+	}
+}
+
+func TestLDAPURLTLSConfig(t *testing.T) {
+	caFile, err := ioutil.TempFile("", "ldapca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(caFile.Name())
+	if _, err := caFile.Write([]byte(rootCAPem)); err != nil {
+		t.Fatal(err)
+	}
+	caFile.Close()
+	u, err := ParseLDAPURL("ldap://localhost?starttls=true&min_tls_version=1.2&ca_file=" +
+		url.QueryEscape(caFile.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options, err := parseLDAPURLOptions(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := options.makeTLSConfig("localhost", x509.NewCertPool())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 || tlsConfig.MaxVersion != 0 {
+		t.Fatalf("unexpected versions %x-%x", tlsConfig.MinVersion,
+			tlsConfig.MaxVersion)
+	}
+	certPool := x509.NewCertPool()
+	certPool.AppendCertsFromPEM([]byte(rootCAPem))
+	if !tlsConfig.RootCAs.Equal(certPool) {
+		t.Fatal("ca_file was not used as the root CAs")
+	}
+}
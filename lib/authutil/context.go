@@ -0,0 +1,37 @@
+package authutil
+
+import (
+	"context"
+)
+
+// closeOnDone closes conn once ctx is done, which makes the operation in
+// progress on it fail instead of running into the connection timeout. The
+// returned function stops watching ctx and reports whether conn was closed,
+// in which case the caller must return ctx.Err() and not reuse conn.
+func closeOnDone(ctx context.Context, conn ldapConnection) func() bool {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+	stop := make(chan struct{})
+	closed := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			closed <- true
+		case <-stop:
+			// The operation may have failed because of ctx even if its
+			// cancellation lost the race with stop.
+			if ctx.Err() != nil {
+				conn.Close()
+				closed <- true
+				return
+			}
+			closed <- false
+		}
+	}()
+	return func() bool {
+		close(stop)
+		return <-closed
+	}
+}
@@ -0,0 +1,87 @@
+package authutil
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"gopkg.in/ldap.v2"
+)
+
+// Bit of pwdProperties which enables the complexity requirements.
+const adDomainPasswordComplex = 1
+
+// LDAPPasswordPolicy is the password policy published by a directory.
+type LDAPPasswordPolicy struct {
+	MinLength         int
+	RequireComplexity bool
+	HistoryLength     int
+}
+
+// parseLDAPPasswordPolicy reads the policy from an Active Directory domain
+// object or an OpenLDAP ppolicy entry.
+func parseLDAPPasswordPolicy(entry *ldap.Entry) (LDAPPasswordPolicy, error) {
+	var policy LDAPPasswordPolicy
+	found := false
+	readInt := func(target *int, attributes ...string) error {
+		for _, attribute := range attributes {
+			value := entry.GetAttributeValue(attribute)
+			if value == "" {
+				continue
+			}
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			*target = number
+			found = true
+			return nil
+		}
+		return nil
+	}
+	if err := readInt(&policy.MinLength, "minPwdLength",
+		"pwdMinLength"); err != nil {
+		return policy, err
+	}
+	if err := readInt(&policy.HistoryLength, "pwdHistoryLength",
+		"pwdInHistory"); err != nil {
+		return policy, err
+	}
+	var properties int
+	if err := readInt(&properties, "pwdProperties"); err != nil {
+		return policy, err
+	}
+	policy.RequireComplexity = properties&adDomainPasswordComplex != 0
+	if !found {
+		return policy, errors.New("entry has no password policy attributes")
+	}
+	return policy, nil
+}
+
+// GetLDAPPasswordPolicy binds as bindDN and reads the password policy from
+// policyDN: the domain DN in Active Directory or the ppolicy entry in
+// OpenLDAP.
+func GetLDAPPasswordPolicy(u url.URL, bindDN string, bindPassword string,
+	timeoutSecs uint, rootCAs *x509.CertPool,
+	policyDN string) (LDAPPasswordPolicy, error) {
+	conn, _, err := getLDAPConnection(u, timeoutSecs, rootCAs)
+	if err != nil {
+		return LDAPPasswordPolicy{}, err
+	}
+	defer conn.Close()
+	if err := conn.Bind(bindDN, bindPassword); err != nil {
+		return LDAPPasswordPolicy{}, err
+	}
+	sr, err := conn.Search(ldap.NewSearchRequest(policyDN,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+		"(objectClass=*)", []string{"minPwdLength", "pwdHistoryLength",
+			"pwdProperties", "pwdMinLength", "pwdInHistory"}, nil))
+	if err != nil {
+		return LDAPPasswordPolicy{}, err
+	}
+	if len(sr.Entries) != 1 {
+		return LDAPPasswordPolicy{}, errors.New("password policy entry not found")
+	}
+	return parseLDAPPasswordPolicy(sr.Entries[0])
+}
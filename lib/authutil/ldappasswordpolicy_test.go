@@ -0,0 +1,37 @@
+package authutil
+
+import (
+	"testing"
+
+	"gopkg.in/ldap.v2"
+)
+
+func TestParseLDAPPasswordPolicy(t *testing.T) {
+	entry := func(attributes map[string]string) *ldap.Entry {
+		entry := &ldap.Entry{DN: "DC=example,DC=com"}
+		for name, value := range attributes {
+			entry.Attributes = append(entry.Attributes,
+				&ldap.EntryAttribute{Name: name, Values: []string{value}})
+		}
+		return entry
+	}
+	policy, err := parseLDAPPasswordPolicy(entry(map[string]string{
+		"minPwdLength": "12", "pwdHistoryLength": "24", "pwdProperties": "1"}))
+	if err != nil || policy != (LDAPPasswordPolicy{MinLength: 12,
+		RequireComplexity: true, HistoryLength: 24}) {
+		t.Fatalf("Active Directory policy: %+v, %v", policy, err)
+	}
+	policy, err = parseLDAPPasswordPolicy(entry(map[string]string{
+		"pwdMinLength": "8", "pwdInHistory": "5"}))
+	if err != nil || policy != (LDAPPasswordPolicy{MinLength: 8,
+		HistoryLength: 5}) {
+		t.Fatalf("ppolicy: %+v, %v", policy, err)
+	}
+	if _, err := parseLDAPPasswordPolicy(entry(nil)); err == nil {
+		t.Fatal("entry without policy accepted")
+	}
+	if _, err := parseLDAPPasswordPolicy(entry(map[string]string{
+		"minPwdLength": "many"})); err == nil {
+		t.Fatal("bad number accepted")
+	}
+}
@@ -0,0 +1,330 @@
+package authutil
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"gopkg.in/ldap.v2"
+)
+
+const (
+	defaultLDAPPoolMaxIdleTime    = 5 * time.Minute
+	ldapPoolHealthCheckInterval   = 30 * time.Second
+	ldapPoolHealthCheckTimeoutSec = 3
+)
+
+// ldapConnection is the subset of *ldap.Conn used by the pool.
+type ldapConnection interface {
+	Bind(username, password string) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+type idleLDAPConnection struct {
+	conn      ldapConnection
+	idleSince time.Time
+}
+
+// LDAPConnectionPool keeps connections to an LDAP server open for reuse so
+// that password checks do not pay for a new TLS handshake every time. Idle
+// connections are health checked in the background and connections which
+// fail are transparently replaced.
+type LDAPConnectionPool struct {
+	dial        func(ctx context.Context) (ldapConnection, error)
+	maxIdle     int
+	maxIdleTime time.Duration
+	stop        chan struct{}
+	// Set before the pool is used.
+	newReferrals func(policy LDAPReferralPolicy) *ldapReferrals
+	referrals    *ldapReferrals
+	mutex        sync.Mutex
+	// Protected by lock.
+	idle   []idleLDAPConnection
+	closed bool
+}
+
+// NewLDAPConnectionPool returns a pool of connections to the LDAP server at
+// u. At most maxIdle unused connections are kept open.
+func NewLDAPConnectionPool(u url.URL, timeoutSecs uint,
+	rootCAs *x509.CertPool, maxIdle int) *LDAPConnectionPool {
+	dial := func(ctx context.Context) (ldapConnection, error) {
+		conn, _, err := getLDAPConnectionContext(ctx, u, timeoutSecs, rootCAs)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+	pool := newLDAPConnectionPool(dial, maxIdle, defaultLDAPPoolMaxIdleTime)
+	pool.newReferrals = func(policy LDAPReferralPolicy) *ldapReferrals {
+		return newLDAPReferrals(u, timeoutSecs, rootCAs, policy)
+	}
+	go pool.healthCheckLoop(ldapPoolHealthCheckInterval)
+	return pool
+}
+
+func newLDAPConnectionPool(dial func(ctx context.Context) (ldapConnection,
+	error), maxIdle int,
+	maxIdleTime time.Duration) *LDAPConnectionPool {
+	return &LDAPConnectionPool{
+		dial:        dial,
+		maxIdle:     maxIdle,
+		maxIdleTime: maxIdleTime,
+		stop:        make(chan struct{}),
+	}
+}
+
+// SetReferralPolicy sets how referrals from the server are handled. By
+// default they are refused. It must be called before the pool is used.
+func (p *LDAPConnectionPool) SetReferralPolicy(policy LDAPReferralPolicy) error {
+	if err := CheckLDAPReferralPolicy(policy); err != nil {
+		return err
+	}
+	p.referrals = p.newReferrals(policy)
+	return nil
+}
+
+// get returns an idle connection if one is available, else a new one. The
+// returned bool is true if the connection was reused.
+func (p *LDAPConnectionPool) get(ctx context.Context) (ldapConnection, bool,
+	error) {
+	p.mutex.Lock()
+	for len(p.idle) > 0 {
+		last := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if time.Since(last.idleSince) > p.maxIdleTime {
+			last.conn.Close()
+			continue
+		}
+		p.mutex.Unlock()
+		return last.conn, true, nil
+	}
+	p.mutex.Unlock()
+	conn, err := p.dial(ctx)
+	return conn, false, err
+}
+
+// isLDAPNetworkError returns true if err means that the connection is
+// broken rather than that the server refused the request.
+func isLDAPNetworkError(err error) bool {
+	return ldap.IsErrorWithCode(err, ldap.ErrorNetwork)
+}
+
+func (p *LDAPConnectionPool) put(conn ldapConnection) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed || len(p.idle) >= p.maxIdle {
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle,
+		idleLDAPConnection{conn: conn, idleSince: time.Now()})
+}
+
+// CheckUserPassword binds as bindDN with bindPassword. It returns false with
+// a nil error if the credentials are invalid or the password is empty. If a
+// reused connection fails with a network error the check is retried on a new
+// connection, other errors are never retried.
+func (p *LDAPConnectionPool) CheckUserPassword(bindDN string,
+	bindPassword string) (bool, error) {
+	return p.CheckUserPasswordContext(context.Background(), bindDN,
+		bindPassword)
+}
+
+// CheckUserPasswordContext is CheckUserPassword but gives up once ctx is
+// done. The connection in use is then closed rather than returned to the
+// pool.
+func (p *LDAPConnectionPool) CheckUserPasswordContext(ctx context.Context,
+	bindDN string, bindPassword string) (bool, error) {
+	// An empty password would be an unauthenticated bind, which succeeds.
+	if bindPassword == "" {
+		return false, nil
+	}
+	for {
+		conn, reused, err := p.get(ctx)
+		if err != nil {
+			return false, err
+		}
+		stop := closeOnDone(ctx, conn)
+		err = conn.Bind(bindDN, bindPassword)
+		if stop() {
+			return false, ctx.Err()
+		}
+		if err == nil {
+			p.put(conn)
+			return true, nil
+		}
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			p.put(conn)
+			return false, nil
+		}
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultReferral) {
+			p.put(conn)
+			return p.checkReferredUserPassword(bindDN, bindPassword)
+		}
+		conn.Close()
+		if reused && isLDAPNetworkError(err) {
+			continue
+		}
+		log.Printf("Bind failure for bindDN:'%s' (%s)", bindDN, err.Error())
+		return false, err
+	}
+}
+
+// checkReferredUserPassword binds at the server the bind was referred to.
+func (p *LDAPConnectionPool) checkReferredUserPassword(bindDN string,
+	bindPassword string) (bool, error) {
+	conn, err := p.referrals.bindReferred(bindDN, bindPassword, 1)
+	if err == nil {
+		conn.Close()
+		return true, nil
+	}
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return false, nil
+	}
+	log.Printf("Bind failure for bindDN:'%s' (%s)", bindDN, err.Error())
+	return false, err
+}
+
+// SearchUserDN binds as bindDN and searches userSearchBaseDNs in order for
+// the entry matching userSearchFilter, a printf pattern where %s is replaced
+// by the escaped username. It returns an empty DN if the user does not exist
+// or the filter matches more than one entry.
+func (p *LDAPConnectionPool) SearchUserDN(bindDN string, bindPassword string,
+	userSearchBaseDNs []string, userSearchFilter string,
+	username string) (string, error) {
+	return p.SearchUserDNContext(context.Background(), bindDN, bindPassword,
+		userSearchBaseDNs, userSearchFilter, username)
+}
+
+// SearchUserDNContext is SearchUserDN but gives up once ctx is done, like
+// CheckUserPasswordContext.
+func (p *LDAPConnectionPool) SearchUserDNContext(ctx context.Context,
+	bindDN string, bindPassword string, userSearchBaseDNs []string,
+	userSearchFilter string, username string) (string, error) {
+	for {
+		conn, reused, err := p.get(ctx)
+		if err != nil {
+			return "", err
+		}
+		stop := closeOnDone(ctx, conn)
+		searcher := newReferralConnection(conn, p.referrals)
+		userDN, err := searchUserDN(searcher, bindDN, bindPassword,
+			userSearchBaseDNs, userSearchFilter, username)
+		searcher.Close()
+		if stop() {
+			return "", ctx.Err()
+		}
+		if err == nil {
+			p.put(conn)
+			return userDN, nil
+		}
+		if IsLDAPReferral(err) {
+			p.put(conn)
+			return "", err
+		}
+		conn.Close()
+		if reused && isLDAPNetworkError(err) {
+			continue
+		}
+		return "", err
+	}
+}
+
+func searchUserDN(conn ldapConnection, bindDN string, bindPassword string,
+	userSearchBaseDNs []string, userSearchFilter string,
+	username string) (string, error) {
+	if err := conn.Bind(bindDN, bindPassword); err != nil {
+		return "", err
+	}
+	filter := fmt.Sprintf(userSearchFilter, ldap.EscapeFilter(username))
+	for _, searchDN := range userSearchBaseDNs {
+		sr, err := conn.Search(ldap.NewSearchRequest(searchDN,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+			filter, []string{"dn"}, nil))
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) ||
+			(err == nil && len(sr.Entries) > 1) {
+			log.Printf("Too many entries for user '%s' in %s", username,
+				searchDN)
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(sr.Entries) == 1 {
+			if sr.Entries[0].DN == "" {
+				return "", errors.New("search returned entry without DN")
+			}
+			return sr.Entries[0].DN, nil
+		}
+	}
+	return "", nil
+}
+
+// healthCheck probes the idle connections with a root DSE search and drops
+// those which fail or have been idle too long.
+func (p *LDAPConnectionPool) healthCheck() {
+	p.mutex.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mutex.Unlock()
+	var healthy []idleLDAPConnection
+	for _, entry := range idle {
+		if time.Since(entry.idleSince) > p.maxIdleTime {
+			entry.conn.Close()
+			continue
+		}
+		_, err := entry.conn.Search(ldap.NewSearchRequest("",
+			ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1,
+			ldapPoolHealthCheckTimeoutSec, false, "(objectClass=*)",
+			[]string{"1.1"}, nil))
+		if err != nil {
+			entry.conn.Close()
+			continue
+		}
+		healthy = append(healthy, entry)
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, entry := range healthy {
+		if p.closed || len(p.idle) >= p.maxIdle {
+			entry.conn.Close()
+			continue
+		}
+		p.idle = append(p.idle, entry)
+	}
+}
+
+func (p *LDAPConnectionPool) healthCheckLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.healthCheck()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Close closes all idle connections and stops the health checks.
+// Connections in use are closed when they are returned.
+func (p *LDAPConnectionPool) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.stop)
+	for _, entry := range p.idle {
+		entry.conn.Close()
+	}
+	p.idle = nil
+}
@@ -0,0 +1,197 @@
+package authutil
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/ldap.v2"
+)
+
+type fakeLDAPConnection struct {
+	broken  bool
+	closed  bool
+	bindErr error
+	entries map[string][]string // Search filter to DNs.
+}
+
+func (c *fakeLDAPConnection) Bind(username, password string) error {
+	if c.broken {
+		return ldap.NewError(ldap.ErrorNetwork, errors.New("connection closed"))
+	}
+	if c.bindErr != nil {
+		return c.bindErr
+	}
+	if password != "password" {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials,
+			errors.New("Invalid Credentials"))
+	}
+	return nil
+}
+
+func (c *fakeLDAPConnection) Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if c.broken {
+		return nil, errors.New("connection closed")
+	}
+	result := &ldap.SearchResult{}
+	for _, dn := range c.entries[searchRequest.Filter] {
+		result.Entries = append(result.Entries, &ldap.Entry{DN: dn})
+	}
+	return result, nil
+}
+
+func (c *fakeLDAPConnection) Close() {
+	c.closed = true
+}
+
+func newTestLDAPPool(maxIdleTime time.Duration) (*LDAPConnectionPool, *[]*fakeLDAPConnection) {
+	var dialed []*fakeLDAPConnection
+	dial := func(ctx context.Context) (ldapConnection, error) {
+		conn := &fakeLDAPConnection{}
+		dialed = append(dialed, conn)
+		return conn, nil
+	}
+	return newLDAPConnectionPool(dial, 2, maxIdleTime), &dialed
+}
+
+func TestLDAPConnectionPoolReuse(t *testing.T) {
+	pool, dialed := newTestLDAPPool(time.Hour)
+	defer pool.Close()
+	for i := 0; i < 3; i++ {
+		ok, err := pool.CheckUserPassword("uid=user", "password")
+		if err != nil || !ok {
+			t.Fatalf("expected valid credentials: %v, %v", ok, err)
+		}
+	}
+	ok, err := pool.CheckUserPassword("uid=user", "wrong")
+	if err != nil || ok {
+		t.Fatalf("expected invalid credentials: %v, %v", ok, err)
+	}
+	if len(*dialed) != 1 {
+		t.Fatalf("connection should have been reused, dialed %d", len(*dialed))
+	}
+}
+
+func TestLDAPConnectionPoolReconnect(t *testing.T) {
+	pool, dialed := newTestLDAPPool(time.Hour)
+	defer pool.Close()
+	if _, err := pool.CheckUserPassword("uid=user", "password"); err != nil {
+		t.Fatal(err)
+	}
+	(*dialed)[0].broken = true
+	ok, err := pool.CheckUserPassword("uid=user", "password")
+	if err != nil || !ok {
+		t.Fatalf("broken connection should have been replaced: %v, %v", ok, err)
+	}
+	if len(*dialed) != 2 || !(*dialed)[0].closed {
+		t.Fatal("broken connection was not closed and replaced")
+	}
+}
+
+func TestLDAPConnectionPoolNoRetryOnServerError(t *testing.T) {
+	pool, dialed := newTestLDAPPool(time.Hour)
+	defer pool.Close()
+	if _, err := pool.CheckUserPassword("uid=user", "password"); err != nil {
+		t.Fatal(err)
+	}
+	(*dialed)[0].bindErr = ldap.NewError(ldap.LDAPResultBusy,
+		errors.New("busy"))
+	if ok, err := pool.CheckUserPassword("uid=user", "password"); err == nil || ok {
+		t.Fatalf("server error should fail the check: %v, %v", ok, err)
+	}
+	if len(*dialed) != 1 {
+		t.Fatalf("server error was retried, dialed %d", len(*dialed))
+	}
+}
+
+func TestLDAPConnectionPoolHealthCheck(t *testing.T) {
+	pool, dialed := newTestLDAPPool(time.Hour)
+	defer pool.Close()
+	if _, err := pool.CheckUserPassword("uid=user", "password"); err != nil {
+		t.Fatal(err)
+	}
+	(*dialed)[0].broken = true
+	pool.healthCheck()
+	if !(*dialed)[0].closed || len(pool.idle) != 0 {
+		t.Fatal("unhealthy connection should have been dropped")
+	}
+}
+
+func TestLDAPConnectionPoolSearchUserDN(t *testing.T) {
+	conn := &fakeLDAPConnection{entries: map[string][]string{
+		"(sAMAccountName=user)":  {"CN=User,OU=Staff,DC=example,DC=com"},
+		"(sAMAccountName=dup)":   {"CN=Dup1,DC=example,DC=com", "CN=Dup2,DC=example,DC=com"},
+		"(sAMAccountName=a\\2a)": {"CN=Escaped,DC=example,DC=com"},
+	}}
+	pool := newLDAPConnectionPool(func(context.Context) (ldapConnection, error) {
+		return conn, nil
+	}, 2, time.Hour)
+	defer pool.Close()
+	baseDNs := []string{"DC=example,DC=com"}
+	filter := "(sAMAccountName=%s)"
+	userDN, err := pool.SearchUserDN("CN=svc", "password", baseDNs, filter, "user")
+	if err != nil || userDN != "CN=User,OU=Staff,DC=example,DC=com" {
+		t.Fatalf("unexpected result %q, %v", userDN, err)
+	}
+	for _, username := range []string{"dup", "missing", "*"} {
+		userDN, err = pool.SearchUserDN("CN=svc", "password", baseDNs, filter,
+			username)
+		if err != nil || userDN != "" {
+			t.Fatalf("%s: expected no DN, got %q, %v", username, userDN, err)
+		}
+	}
+	if _, err := pool.SearchUserDN("CN=svc", "wrong", baseDNs, filter, "user"); err == nil {
+		t.Fatal("bad service account password should fail")
+	}
+	if ok, _ := pool.CheckUserPassword("CN=User,OU=Staff,DC=example,DC=com", ""); ok {
+		t.Fatal("empty password must not authenticate")
+	}
+}
+
+// hangingLDAPConnection is a server which never answers a bind. Closing the
+// connection makes the bind fail.
+type hangingLDAPConnection struct {
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *hangingLDAPConnection) Bind(username, password string) error {
+	<-c.closed
+	return ldap.NewError(ldap.ErrorNetwork, errors.New("connection closed"))
+}
+
+func (c *hangingLDAPConnection) Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	<-c.closed
+	return nil, errors.New("connection closed")
+}
+
+func (c *hangingLDAPConnection) Close() {
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+func TestLDAPConnectionPoolContext(t *testing.T) {
+	conn := &hangingLDAPConnection{closed: make(chan struct{})}
+	pool := newLDAPConnectionPool(func(context.Context) (ldapConnection, error) {
+		return conn, nil
+	}, 2, time.Hour)
+	defer pool.Close()
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	ok, err := pool.CheckUserPasswordContext(ctx, "uid=user", "password")
+	if ok || err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v, %v", ok, err)
+	}
+	if len(pool.idle) != 0 {
+		t.Fatal("aborted connection returned to the pool")
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pool.SearchUserDNContext(cancelled, "CN=svc", "password",
+		[]string{"DC=example,DC=com"}, "(uid=%s)", "user")
+	if err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
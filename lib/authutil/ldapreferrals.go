@@ -0,0 +1,390 @@
+package authutil
+
+import (
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+
+	"gopkg.in/ldap.v2"
+)
+
+// Referral handling modes for LDAPReferralPolicy.
+const (
+	// LDAPReferralsRefuse never contacts other servers. Referrals are
+	// returned as an *LDAPReferralError.
+	LDAPReferralsRefuse = "refuse"
+	// LDAPReferralsFollow binds and searches again at the referred server.
+	LDAPReferralsFollow = "follow"
+)
+
+// Referral outcomes passed to LDAPReferralPolicy.Observe.
+const (
+	LDAPReferralFollowed       = "followed"
+	LDAPReferralRefused        = "refused"
+	LDAPReferralFailed         = "failed"
+	LDAPReferralHopLimit       = "hop_limit"
+	LDAPReferralHostNotAllowed = "host_not_allowed"
+)
+
+const (
+	defaultLDAPReferralMaxHops = 2
+	maxLDAPReferralsPerSearch  = 8
+)
+
+// LDAPReferralPolicy configures the handling of referrals, which Active
+// Directory forests return for entries held by the domain controllers of
+// other domains.
+//
+// The client library does not expose the target of a referral returned as a
+// result code, so for binds and searches the server is found from the DC
+// components of the DN, as Active Directory does. Search continuation
+// references carry an URL of which only the host and DN are used. Referred
+// servers are always contacted with the port and TLS options of the
+// configured URL.
+type LDAPReferralPolicy struct {
+	// Mode is one of the LDAPReferrals* constants. The default is to refuse.
+	Mode string
+	// MaxHops limits chains of referrals. The default is 2.
+	MaxHops int
+	// AllowedDomains lists the DNS domains of the servers which may be
+	// contacted. The default is the domain of the configured server.
+	AllowedDomains []string
+	// Observe, if not nil, is called with the outcome of each referral.
+	Observe func(outcome string)
+}
+
+// CheckLDAPReferralPolicy returns an error if policy is invalid.
+func CheckLDAPReferralPolicy(policy LDAPReferralPolicy) error {
+	switch policy.Mode {
+	case "", LDAPReferralsRefuse, LDAPReferralsFollow:
+	default:
+		return fmt.Errorf("invalid referrals mode: %s", policy.Mode)
+	}
+	if policy.MaxHops < 0 {
+		return fmt.Errorf("invalid referral max hops: %d", policy.MaxHops)
+	}
+	return nil
+}
+
+// LDAPReferralError is returned for referrals which were not followed.
+type LDAPReferralError struct {
+	// Targets are the servers, URLs or DNs referred to, if known.
+	Targets []string
+	Reason  string
+}
+
+func (e *LDAPReferralError) Error() string {
+	if len(e.Targets) < 1 {
+		return "LDAP referral: " + e.Reason
+	}
+	return fmt.Sprintf("LDAP referral to %s: %s", strings.Join(e.Targets, ", "),
+		e.Reason)
+}
+
+// IsLDAPReferral returns true if err is a referral which was not followed.
+func IsLDAPReferral(err error) bool {
+	if _, ok := err.(*LDAPReferralError); ok {
+		return true
+	}
+	return ldap.IsErrorWithCode(err, ldap.LDAPResultReferral)
+}
+
+// ldapReferrals follows referrals from the server at origin. A nil
+// *ldapReferrals refuses all referrals.
+type ldapReferrals struct {
+	policy LDAPReferralPolicy
+	origin url.URL
+	dial   func(u url.URL) (ldapConnection, error)
+}
+
+func newLDAPReferrals(u url.URL, timeoutSecs uint, rootCAs *x509.CertPool,
+	policy LDAPReferralPolicy) *ldapReferrals {
+	return &ldapReferrals{
+		policy: policy,
+		origin: u,
+		dial: func(u url.URL) (ldapConnection, error) {
+			conn, _, err := getLDAPConnection(u, timeoutSecs, rootCAs)
+			if err != nil {
+				return nil, err
+			}
+			return conn, nil
+		},
+	}
+}
+
+func (r *ldapReferrals) observe(outcome string) {
+	if r != nil && r.policy.Observe != nil {
+		r.policy.Observe(outcome)
+	}
+}
+
+func (r *ldapReferrals) following() bool {
+	return r != nil && r.policy.Mode == LDAPReferralsFollow
+}
+
+func (r *ldapReferrals) maxHops() int {
+	if r.policy.MaxHops > 0 {
+		return r.policy.MaxHops
+	}
+	return defaultLDAPReferralMaxHops
+}
+
+func (r *ldapReferrals) refuse(targets []string, reason string) error {
+	r.observe(LDAPReferralRefused)
+	return &LDAPReferralError{Targets: targets, Reason: reason}
+}
+
+func normaliseDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+func (r *ldapReferrals) hostAllowed(host string) bool {
+	domains := r.policy.AllowedDomains
+	if len(domains) < 1 {
+		originHost := r.origin.Hostname()
+		index := strings.Index(originHost, ".")
+		if index < 1 || !strings.Contains(originHost[index+1:], ".") {
+			return false
+		}
+		domains = []string{originHost[index+1:]}
+	}
+	host = normaliseDomain(host)
+	for _, domain := range domains {
+		domain = normaliseDomain(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetURL returns the configured URL with its host replaced.
+func (r *ldapReferrals) targetURL(host string) url.URL {
+	target := r.origin
+	if port := r.origin.Port(); port != "" {
+		target.Host = net.JoinHostPort(host, port)
+	} else {
+		target.Host = host
+	}
+	return target
+}
+
+// dialReferral connects to host for a referral at depth hops.
+func (r *ldapReferrals) dialReferral(host string, hops int) (ldapConnection,
+	error) {
+	if !r.following() {
+		var targets []string
+		if host != "" {
+			targets = []string{host}
+		}
+		return nil, r.refuse(targets, "following referrals is disabled")
+	}
+	if host == "" {
+		r.observe(LDAPReferralFailed)
+		return nil, &LDAPReferralError{Reason: "cannot find the referred server"}
+	}
+	if hops > r.maxHops() {
+		r.observe(LDAPReferralHopLimit)
+		return nil, &LDAPReferralError{Targets: []string{host},
+			Reason: "too many referral hops"}
+	}
+	if !r.hostAllowed(host) {
+		r.observe(LDAPReferralHostNotAllowed)
+		return nil, &LDAPReferralError{Targets: []string{host},
+			Reason: "server is not in an allowed domain"}
+	}
+	target := r.targetURL(host)
+	conn, err := r.dial(target)
+	if err != nil {
+		r.observe(LDAPReferralFailed)
+		return nil, err
+	}
+	log.Printf("Following LDAP referral from %s to %s", r.origin.Host,
+		target.Host)
+	return conn, nil
+}
+
+// bindReferred binds as dn at the server of its domain. Invalid credentials
+// are a definitive answer and count as followed.
+func (r *ldapReferrals) bindReferred(dn, password string, hops int) (
+	ldapConnection, error) {
+	conn, err := r.dialReferral(dnDomain(dn), hops)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Bind(dn, password); err != nil {
+		conn.Close()
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			r.observe(LDAPReferralFollowed)
+			return nil, err
+		}
+		r.observe(LDAPReferralFailed)
+		if IsLDAPReferral(err) {
+			return nil, &LDAPReferralError{Targets: []string{dnDomain(dn)},
+				Reason: "the referred server refers the bind again"}
+		}
+		return nil, err
+	}
+	r.observe(LDAPReferralFollowed)
+	return conn, nil
+}
+
+// dnDomain returns the DNS domain named by the DC components of dn, for
+// example "corp.example.com" for "CN=User,DC=corp,DC=example,DC=com".
+func dnDomain(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return ""
+	}
+	var labels []string
+	for _, rdn := range parsed.RDNs {
+		for _, attribute := range rdn.Attributes {
+			if strings.EqualFold(attribute.Type, "dc") {
+				labels = append(labels, attribute.Value)
+			}
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// parseLDAPReferralURL returns the host and base DN of a search continuation
+// reference.
+func parseLDAPReferralURL(referral string) (string, string, error) {
+	u, err := url.Parse(referral)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return "", "", fmt.Errorf("unsupported referral URL: %s", referral)
+	}
+	if u.Hostname() == "" {
+		return "", "", fmt.Errorf("referral URL without host: %s", referral)
+	}
+	return u.Hostname(), strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// referralConnection handles the referrals returned by the connection it
+// wraps according to its policy. Close closes the connections opened to
+// follow referrals, the wrapped connection is left to the caller.
+type referralConnection struct {
+	ldapConnection
+	original     ldapConnection
+	referrals    *ldapReferrals
+	hops         int
+	bindDN       string
+	bindPassword string
+}
+
+func newReferralConnection(conn ldapConnection,
+	referrals *ldapReferrals) *referralConnection {
+	return &referralConnection{
+		ldapConnection: conn,
+		original:       conn,
+		referrals:      referrals,
+	}
+}
+
+// Bind binds at the server of the domain of username if the server refers
+// the bind. Later searches go to that server.
+func (c *referralConnection) Bind(username, password string) error {
+	c.bindDN, c.bindPassword = username, password
+	err := c.ldapConnection.Bind(username, password)
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultReferral) {
+		return err
+	}
+	conn, err := c.referrals.bindReferred(username, password, c.hops+1)
+	if err != nil {
+		return err
+	}
+	c.Close()
+	c.ldapConnection = conn
+	return nil
+}
+
+// Search follows referrals for the whole search and continuation references
+// for parts of it, merging the entries found. When not following, a referral
+// for the whole search is refused and continuation references are ignored,
+// as Active Directory returns them for partitions which rarely hold the
+// entries searched for.
+func (c *referralConnection) Search(request *ldap.SearchRequest) (
+	*ldap.SearchResult, error) {
+	result, err := c.ldapConnection.Search(request)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultReferral) {
+		return c.searchReferred(dnDomain(request.BaseDN), request.BaseDN,
+			request)
+	}
+	if err != nil || len(result.Referrals) < 1 {
+		return result, err
+	}
+	references := result.Referrals
+	result.Referrals = nil
+	if !c.referrals.following() {
+		return result, nil
+	}
+	if len(references) > maxLDAPReferralsPerSearch {
+		log.Printf("Following only %d of %d LDAP search references",
+			maxLDAPReferralsPerSearch, len(references))
+		references = references[:maxLDAPReferralsPerSearch]
+	}
+	var lastErr error
+	for _, reference := range references {
+		host, baseDN, err := parseLDAPReferralURL(reference)
+		if err != nil {
+			c.referrals.observe(LDAPReferralFailed)
+			lastErr = err
+			continue
+		}
+		if baseDN == "" {
+			baseDN = request.BaseDN
+		}
+		referred, err := c.searchReferred(host, baseDN, request)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		result.Entries = append(result.Entries, referred.Entries...)
+	}
+	if len(result.Entries) < 1 && lastErr != nil {
+		return nil, lastErr
+	}
+	return result, nil
+}
+
+func (c *referralConnection) searchReferred(host, baseDN string,
+	request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	conn, err := c.referrals.dialReferral(host, c.hops+1)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	referred := newReferralConnection(conn, c.referrals)
+	referred.hops = c.hops + 1
+	defer referred.Close()
+	reportFailure := func(err error) error {
+		if _, ok := err.(*LDAPReferralError); !ok {
+			c.referrals.observe(LDAPReferralFailed)
+		}
+		return err
+	}
+	if err := referred.Bind(c.bindDN, c.bindPassword); err != nil {
+		return nil, reportFailure(err)
+	}
+	referredRequest := *request
+	referredRequest.BaseDN = baseDN
+	result, err := referred.Search(&referredRequest)
+	if err != nil {
+		return nil, reportFailure(err)
+	}
+	c.referrals.observe(LDAPReferralFollowed)
+	return result, nil
+}
+
+// Close closes the connection opened by Bind to follow a referral, if any.
+func (c *referralConnection) Close() {
+	if c.ldapConnection != c.original {
+		c.ldapConnection.Close()
+	}
+}
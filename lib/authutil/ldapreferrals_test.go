@@ -0,0 +1,219 @@
+package authutil
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"gopkg.in/ldap.v2"
+)
+
+// referringLDAPConnection is a server which holds the entries of one domain
+// and refers everything else.
+type referringLDAPConnection struct {
+	domain     string
+	entries    map[string][]string // Search filter to DNs.
+	references []string
+	closed     bool
+}
+
+func (c *referringLDAPConnection) Bind(username, password string) error {
+	if username != "" && dnDomain(username) != c.domain &&
+		dnDomain(username) != "example.com" {
+		return ldap.NewError(ldap.LDAPResultReferral, errors.New("RefErr"))
+	}
+	if password != "password" {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials,
+			errors.New("Invalid Credentials"))
+	}
+	return nil
+}
+
+func (c *referringLDAPConnection) Search(searchRequest *ldap.SearchRequest) (
+	*ldap.SearchResult, error) {
+	if dnDomain(searchRequest.BaseDN) != c.domain {
+		return nil, ldap.NewError(ldap.LDAPResultReferral, errors.New("RefErr"))
+	}
+	result := &ldap.SearchResult{Referrals: c.references}
+	for _, dn := range c.entries[searchRequest.Filter] {
+		result.Entries = append(result.Entries, &ldap.Entry{DN: dn})
+	}
+	return result, nil
+}
+
+func (c *referringLDAPConnection) Close() {
+	c.closed = true
+}
+
+type testLDAPForest struct {
+	servers  map[string]*referringLDAPConnection
+	dialed   []string
+	outcomes []string
+}
+
+func newTestLDAPForest() *testLDAPForest {
+	return &testLDAPForest{servers: map[string]*referringLDAPConnection{
+		"dc1.example.com": {
+			domain:     "example.com",
+			references: []string{"ldap://child.example.com/DC=child,DC=example,DC=com"},
+		},
+		"child.example.com": {
+			domain: "child.example.com",
+			entries: map[string][]string{
+				"(sAMAccountName=user)": {"CN=User,DC=child,DC=example,DC=com"},
+			},
+		},
+		"other.org": {domain: "other.org"},
+	}}
+}
+
+func (f *testLDAPForest) referrals(mode string,
+	allowedDomains ...string) *ldapReferrals {
+	origin, _ := url.Parse("ldaps://dc1.example.com:636")
+	return &ldapReferrals{
+		policy: LDAPReferralPolicy{
+			Mode:           mode,
+			AllowedDomains: allowedDomains,
+			Observe: func(outcome string) {
+				f.outcomes = append(f.outcomes, outcome)
+			},
+		},
+		origin: *origin,
+		dial: func(u url.URL) (ldapConnection, error) {
+			if u.Port() != "636" || u.Scheme != "ldaps" {
+				return nil, errors.New("wrong port or scheme: " + u.String())
+			}
+			server, ok := f.servers[u.Hostname()]
+			if !ok {
+				return nil, errors.New("unknown server " + u.Hostname())
+			}
+			f.dialed = append(f.dialed, u.Hostname())
+			return server, nil
+		},
+	}
+}
+
+func (f *testLDAPForest) checkOutcomes(t *testing.T, expected ...string) {
+	t.Helper()
+	if len(f.outcomes) != len(expected) {
+		t.Fatalf("outcomes %v, expected %v", f.outcomes, expected)
+	}
+	for index := range expected {
+		if f.outcomes[index] != expected[index] {
+			t.Fatalf("outcomes %v, expected %v", f.outcomes, expected)
+		}
+	}
+	f.outcomes = nil
+}
+
+func TestDNDomain(t *testing.T) {
+	for dn, expected := range map[string]string{
+		"CN=User,OU=Staff,DC=corp,DC=example,DC=com": "corp.example.com",
+		"uid=user,ou=people,dc=example,dc=com":       "example.com",
+		"CN=User,O=Example":                          "",
+		"not a dn":                                   "",
+	} {
+		if domain := dnDomain(dn); domain != expected {
+			t.Errorf("%s: got %q, expected %q", dn, domain, expected)
+		}
+	}
+}
+
+func TestLDAPReferralHostAllowed(t *testing.T) {
+	forest := newTestLDAPForest()
+	referrals := forest.referrals(LDAPReferralsFollow)
+	for host, expected := range map[string]bool{
+		"child.example.com":  true,
+		"CHILD.EXAMPLE.COM.": true,
+		"example.com":        true,
+		"example.com.evil":   false,
+		"badexample.com":     false,
+	} {
+		if referrals.hostAllowed(host) != expected {
+			t.Errorf("%s: expected allowed=%v", host, expected)
+		}
+	}
+	referrals = forest.referrals(LDAPReferralsFollow, "other.org")
+	if referrals.hostAllowed("child.example.com") ||
+		!referrals.hostAllowed("dc.other.org") {
+		t.Fatal("allowed domains ignored")
+	}
+	if CheckLDAPReferralPolicy(LDAPReferralPolicy{Mode: "chase"}) == nil {
+		t.Fatal("invalid mode accepted")
+	}
+}
+
+func TestLDAPConnectionPoolBindReferral(t *testing.T) {
+	forest := newTestLDAPForest()
+	pool := newLDAPConnectionPool(func(context.Context) (ldapConnection, error) {
+		return forest.servers["dc1.example.com"], nil
+	}, 2, time.Hour)
+	defer pool.Close()
+	userDN := "CN=User,DC=child,DC=example,DC=com"
+	ok, err := pool.CheckUserPassword(userDN, "password")
+	if ok || !IsLDAPReferral(err) {
+		t.Fatalf("referral should be refused by default: %v, %v", ok, err)
+	}
+	pool.referrals = forest.referrals(LDAPReferralsRefuse)
+	if _, err := pool.CheckUserPassword(userDN, "password"); !IsLDAPReferral(err) {
+		t.Fatalf("referral should be refused: %v", err)
+	}
+	forest.checkOutcomes(t, LDAPReferralRefused)
+
+	pool.referrals = forest.referrals(LDAPReferralsFollow)
+	ok, err = pool.CheckUserPassword(userDN, "password")
+	if err != nil || !ok {
+		t.Fatalf("referred bind should succeed: %v, %v", ok, err)
+	}
+	ok, err = pool.CheckUserPassword(userDN, "wrong")
+	if err != nil || ok {
+		t.Fatalf("referred bind should be invalid: %v, %v", ok, err)
+	}
+	forest.checkOutcomes(t, LDAPReferralFollowed, LDAPReferralFollowed)
+	if _, err := pool.CheckUserPassword("CN=User,DC=other,DC=org",
+		"password"); !IsLDAPReferral(err) {
+		t.Fatalf("referral to another forest should be refused: %v", err)
+	}
+	forest.checkOutcomes(t, LDAPReferralHostNotAllowed)
+	if len(forest.dialed) != 2 || forest.dialed[0] != "child.example.com" {
+		t.Fatalf("unexpected servers contacted: %v", forest.dialed)
+	}
+}
+
+func TestLDAPConnectionPoolSearchReferences(t *testing.T) {
+	forest := newTestLDAPForest()
+	pool := newLDAPConnectionPool(func(context.Context) (ldapConnection, error) {
+		return forest.servers["dc1.example.com"], nil
+	}, 2, time.Hour)
+	defer pool.Close()
+	baseDNs := []string{"DC=example,DC=com"}
+	filter := "(sAMAccountName=%s)"
+	pool.referrals = forest.referrals(LDAPReferralsRefuse)
+	userDN, err := pool.SearchUserDN("CN=svc,DC=example,DC=com", "password",
+		baseDNs, filter, "user")
+	if err != nil || userDN != "" {
+		t.Fatalf("search references should be ignored: %q, %v", userDN, err)
+	}
+	forest.checkOutcomes(t)
+
+	pool.referrals = forest.referrals(LDAPReferralsFollow)
+	userDN, err = pool.SearchUserDN("CN=svc,DC=example,DC=com", "password",
+		baseDNs, filter, "user")
+	if err != nil || userDN != "CN=User,DC=child,DC=example,DC=com" {
+		t.Fatalf("unexpected result %q, %v", userDN, err)
+	}
+	forest.checkOutcomes(t, LDAPReferralFollowed)
+
+	// A referral loop stops at the hop limit.
+	forest.servers["child.example.com"].references = []string{
+		"ldap://dc1.example.com/DC=child,DC=example,DC=com"}
+	forest.servers["dc1.example.com"].domain = "child.example.com"
+	_, err = pool.SearchUserDN("CN=svc,DC=example,DC=com", "password",
+		[]string{"DC=child,DC=example,DC=com"}, filter, "missing")
+	if !IsLDAPReferral(err) {
+		t.Fatalf("referral loop should fail: %v", err)
+	}
+	forest.checkOutcomes(t, LDAPReferralHopLimit)
+}
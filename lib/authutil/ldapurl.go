@@ -0,0 +1,108 @@
+package authutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+type ldapURLOptions struct {
+	startTLS      bool
+	caFilename    string
+	minTLSVersion uint16
+	maxTLSVersion uint16
+}
+
+var ldapTLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseLDAPURLOptions extracts the connection options from the query of u:
+//
+//	starttls=true         connect to an ldap:// URL and upgrade with StartTLS
+//	ca_file=PATH          verify the server against this PEM CA bundle only
+//	min_tls_version=1.2   lowest TLS version to negotiate
+//	max_tls_version=1.3   highest TLS version to negotiate
+//
+// Plaintext LDAP is never allowed.
+func parseLDAPURLOptions(u *url.URL) (*ldapURLOptions, error) {
+	var options ldapURLOptions
+	for name, values := range u.Query() {
+		if len(values) != 1 {
+			return nil, fmt.Errorf("ldap url option %s given more than once",
+				name)
+		}
+		value := values[0]
+		switch name {
+		case "starttls":
+			switch value {
+			case "true":
+				options.startTLS = true
+			case "false":
+			default:
+				return nil, fmt.Errorf("invalid starttls value: %s", value)
+			}
+		case "ca_file":
+			options.caFilename = value
+		case "min_tls_version", "max_tls_version":
+			version, ok := ldapTLSVersions[value]
+			if !ok {
+				return nil, fmt.Errorf("invalid %s: %s", name, value)
+			}
+			if name == "min_tls_version" {
+				options.minTLSVersion = version
+			} else {
+				options.maxTLSVersion = version
+			}
+		default:
+			return nil, fmt.Errorf("unknown ldap url option: %s", name)
+		}
+	}
+	switch u.Scheme {
+	case "ldaps":
+		if options.startTLS {
+			return nil, errors.New("starttls cannot be used with ldaps")
+		}
+	case "ldap":
+		if !options.startTLS {
+			return nil, errors.New(
+				"Invalid ldap scheme (ldap requires starttls=true)")
+		}
+	default:
+		return nil, errors.New("Invalid ldap scheme (we only support ldaps and ldap with starttls)")
+	}
+	if options.maxTLSVersion != 0 &&
+		options.minTLSVersion > options.maxTLSVersion {
+		return nil, errors.New("min_tls_version is above max_tls_version")
+	}
+	return &options, nil
+}
+
+func (options *ldapURLOptions) makeTLSConfig(serverName string,
+	rootCAs *x509.CertPool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: serverName,
+		RootCAs:    rootCAs,
+		MinVersion: options.minTLSVersion,
+		MaxVersion: options.maxTLSVersion,
+	}
+	if options.caFilename != "" {
+		pemData, err := ioutil.ReadFile(options.caFilename)
+		if err != nil {
+			return nil, err
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("no certificates in ldap ca_file %s",
+				options.caFilename)
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	return tlsConfig, nil
+}
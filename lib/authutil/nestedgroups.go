@@ -0,0 +1,105 @@
+package authutil
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/ldap.v2"
+)
+
+// Nested group resolution modes for GetLDAPUserGroupsNested.
+const (
+	// NestedGroupsNone only returns the groups the user is a direct
+	// member of.
+	NestedGroupsNone = ""
+	// NestedGroupsInChain uses the Active Directory
+	// LDAP_MATCHING_RULE_IN_CHAIN matching rule to let the server resolve
+	// all groups containing the user, directly or through other groups.
+	NestedGroupsInChain = "in_chain"
+	// NestedGroupsRecursive follows the memberOf attribute of each group
+	// up to maxNestedGroupDepth levels. It works with any directory which
+	// maintains memberOf on groups.
+	NestedGroupsRecursive = "recursive"
+)
+
+const (
+	ldapMatchingRuleInChain = "1.2.840.113556.1.4.1941"
+	maxNestedGroupDepth     = 10
+)
+
+// CheckNestedGroupsMode returns an error if mode is not one of the
+// NestedGroups* constants.
+func CheckNestedGroupsMode(mode string) error {
+	switch mode {
+	case NestedGroupsNone, NestedGroupsInChain, NestedGroupsRecursive:
+		return nil
+	}
+	return fmt.Errorf("invalid nested groups mode: %s", mode)
+}
+
+// getInChainGroupDNs returns the DNs of all groups under groupSearchBaseDNs
+// which contain userDN directly or transitively, once each even if the
+// search bases overlap.
+func getInChainGroupDNs(conn ldapConnection, groupSearchBaseDNs []string,
+	userDN string) ([]string, error) {
+	filter := fmt.Sprintf("(member:%s:=%s)", ldapMatchingRuleInChain,
+		ldap.EscapeFilter(userDN))
+	seen := make(map[string]struct{})
+	var groupDNs []string
+	for _, searchDN := range groupSearchBaseDNs {
+		sr, err := conn.Search(ldap.NewSearchRequest(searchDN,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			filter, []string{"dn"}, nil))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range sr.Entries {
+			key := strings.ToLower(entry.DN)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			groupDNs = append(groupDNs, entry.DN)
+		}
+	}
+	return groupDNs, nil
+}
+
+// expandGroupDNs returns directGroupDNs plus the DNs of all groups they are
+// nested in, found by reading the memberOf attribute of each group.
+func expandGroupDNs(conn ldapConnection, directGroupDNs []string) (
+	[]string, error) {
+	seen := make(map[string]struct{})
+	var groupDNs []string
+	current := directGroupDNs
+	for depth := 0; len(current) > 0; depth++ {
+		var next []string
+		for _, groupDN := range current {
+			// DNs are compared case insensitively, as memberOf values
+			// need not match the case of the entry.
+			key := strings.ToLower(groupDN)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			groupDNs = append(groupDNs, groupDN)
+			if depth >= maxNestedGroupDepth {
+				continue
+			}
+			sr, err := conn.Search(ldap.NewSearchRequest(groupDN,
+				ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+				"(objectClass=*)", []string{"memberOf"}, nil))
+			if err != nil {
+				if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+					continue
+				}
+				return nil, err
+			}
+			for _, entry := range sr.Entries {
+				next = append(next, entry.GetAttributeValues("memberOf")...)
+			}
+		}
+		current = next
+	}
+	return groupDNs, nil
+}
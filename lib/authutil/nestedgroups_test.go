@@ -0,0 +1,94 @@
+package authutil
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"gopkg.in/ldap.v2"
+)
+
+// fakeDirectory answers base searches with the memberOf values of groups and
+// subtree searches with the entries registered for the filter.
+type fakeDirectory struct {
+	memberOf map[string][]string
+	filters  map[string][]string
+	searches int
+}
+
+func (d *fakeDirectory) Bind(username, password string) error { return nil }
+
+func (d *fakeDirectory) Close() {}
+
+func (d *fakeDirectory) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	d.searches++
+	result := &ldap.SearchResult{}
+	if request.Scope == ldap.ScopeBaseObject {
+		parents, ok := d.memberOf[request.BaseDN]
+		if !ok {
+			return nil, ldap.NewError(ldap.LDAPResultNoSuchObject, nil)
+		}
+		entry := ldap.NewEntry(request.BaseDN,
+			map[string][]string{"memberOf": parents})
+		result.Entries = append(result.Entries, entry)
+		return result, nil
+	}
+	for _, dn := range d.filters[request.Filter] {
+		result.Entries = append(result.Entries, &ldap.Entry{DN: dn})
+	}
+	return result, nil
+}
+
+func TestExpandGroupDNs(t *testing.T) {
+	directory := &fakeDirectory{memberOf: map[string][]string{
+		"cn=team,ou=groups":  {"cn=dept,ou=groups"},
+		"cn=dept,ou=groups":  {"cn=org,ou=groups", "cn=team,ou=groups"},
+		"cn=org,ou=groups":   nil,
+		"cn=other,ou=groups": nil,
+	}}
+	groupDNs, err := expandGroupDNs(directory,
+		[]string{"cn=team,ou=groups", "cn=deleted,ou=groups"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(groupDNs)
+	expected := []string{"cn=deleted,ou=groups", "cn=dept,ou=groups",
+		"cn=org,ou=groups", "cn=team,ou=groups"}
+	if !reflect.DeepEqual(groupDNs, expected) {
+		t.Fatalf("unexpected groups %v", groupDNs)
+	}
+	// The cycle between team and dept must not cause extra lookups.
+	if directory.searches != 4 {
+		t.Fatalf("unexpected number of searches %d", directory.searches)
+	}
+}
+
+func TestGetInChainGroupDNs(t *testing.T) {
+	userDN := "CN=User (Ops),OU=Staff,DC=example,DC=com"
+	directory := &fakeDirectory{filters: map[string][]string{
+		"(member:1.2.840.113556.1.4.1941:=CN=User \\28Ops\\29,OU=Staff,DC=example,DC=com)": {
+			"CN=Ops,OU=Groups,DC=example,DC=com",
+			"CN=Engineering,OU=Groups,DC=example,DC=com",
+		},
+	}}
+	// The fake directory finds the groups under both search bases, as a
+	// directory does with nested bases.
+	groupDNs, err := getInChainGroupDNs(directory,
+		[]string{"OU=Groups,DC=example,DC=com", "DC=example,DC=com"}, userDN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groupDNs) != 2 {
+		t.Fatalf("unexpected groups %v", groupDNs)
+	}
+	groups, err := extractCNFromDNString(groupDNs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, []string{"Ops", "Engineering"}) {
+		t.Fatalf("unexpected group names %v", groups)
+	}
+	if err := CheckNestedGroupsMode("sideways"); err == nil {
+		t.Fatal("invalid mode should fail")
+	}
+}
@@ -0,0 +1,251 @@
+package authutil
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// RADIUS authentication methods for CheckRADIUSUserPassword.
+const (
+	RADIUSAuthPAP  = "pap"
+	RADIUSAuthCHAP = "chap"
+)
+
+const (
+	radiusAccessRequest   = 1
+	radiusAccessAccept    = 2
+	radiusAccessReject    = 3
+	radiusAccessChallenge = 11
+
+	radiusAttrUserName             = 1
+	radiusAttrUserPassword         = 2
+	radiusAttrCHAPPassword         = 3
+	radiusAttrNASIdentifier        = 32
+	radiusAttrCHAPChallenge        = 60
+	radiusAttrMessageAuthenticator = 80
+
+	radiusHeaderLength    = 20
+	radiusMaxPacketLength = 4096
+	radiusMaxPasswordLen  = 128
+	radiusDefaultPort     = "1812"
+)
+
+type radiusAttribute struct {
+	attrType byte
+	value    []byte
+}
+
+// RADIUSRequest holds the parameters of an Access-Request.
+type RADIUSRequest struct {
+	Server        string // host[:port], the port defaults to 1812.
+	Secret        []byte
+	Method        string // RADIUSAuthPAP or RADIUSAuthCHAP.
+	NASIdentifier string
+	Timeout       time.Duration
+	Retries       int // Additional transmissions after the first.
+}
+
+// CheckRADIUSUserPassword sends an Access-Request for username and password.
+// It returns true on Access-Accept, false on Access-Reject or
+// Access-Challenge (challenge/response, e.g. SecurID next token mode, is not
+// supported) and an error if the server could not be reached or sent an
+// invalid response.
+func CheckRADIUSUserPassword(request RADIUSRequest, username string,
+	password string) (bool, error) {
+	if len(request.Secret) < 1 {
+		return false, errors.New("radius: empty shared secret")
+	}
+	if password == "" {
+		return false, nil
+	}
+	server := request.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, radiusDefaultPort)
+	}
+	packet, requestAuthenticator, err := buildRADIUSAccessRequest(request,
+		username, password)
+	if err != nil {
+		return false, err
+	}
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	buf := make([]byte, radiusMaxPacketLength)
+	for attempt := 0; attempt <= request.Retries; attempt++ {
+		if _, err := conn.Write(packet); err != nil {
+			return false, err
+		}
+		conn.SetReadDeadline(time.Now().Add(request.Timeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					break // Retransmit.
+				}
+				return false, err
+			}
+			code, err := parseRADIUSResponse(buf[:n], packet[1],
+				requestAuthenticator, request.Secret)
+			if err != nil {
+				// Ignore stray or forged packets, as RFC 2865 requires.
+				continue
+			}
+			switch code {
+			case radiusAccessAccept:
+				return true, nil
+			case radiusAccessReject, radiusAccessChallenge:
+				return false, nil
+			}
+			return false, fmt.Errorf("radius: unexpected response code %d", code)
+		}
+	}
+	return false, fmt.Errorf("radius: no response from %s", server)
+}
+
+func buildRADIUSAccessRequest(request RADIUSRequest, username string,
+	password string) ([]byte, []byte, error) {
+	if len(username) > 253 {
+		return nil, nil, errors.New("radius: username too long")
+	}
+	if len(password) > radiusMaxPasswordLen {
+		return nil, nil, errors.New("radius: password too long")
+	}
+	random := make([]byte, 1+16+16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, nil, err
+	}
+	identifier := random[0]
+	requestAuthenticator := random[1:17]
+	attributes := []radiusAttribute{{radiusAttrUserName, []byte(username)}}
+	switch request.Method {
+	case RADIUSAuthPAP, "":
+		attributes = append(attributes, radiusAttribute{radiusAttrUserPassword,
+			radiusHidePassword([]byte(password), request.Secret,
+				requestAuthenticator)})
+	case RADIUSAuthCHAP:
+		challenge := random[17:]
+		chapID := requestAuthenticator[0]
+		hash := md5.New()
+		hash.Write([]byte{chapID})
+		hash.Write([]byte(password))
+		hash.Write(challenge)
+		attributes = append(attributes,
+			radiusAttribute{radiusAttrCHAPPassword,
+				append([]byte{chapID}, hash.Sum(nil)...)},
+			radiusAttribute{radiusAttrCHAPChallenge, challenge})
+	default:
+		return nil, nil, fmt.Errorf("radius: unknown auth method %s",
+			request.Method)
+	}
+	if request.NASIdentifier != "" {
+		attributes = append(attributes, radiusAttribute{radiusAttrNASIdentifier,
+			[]byte(request.NASIdentifier)})
+	}
+	// RFC 3579 Message-Authenticator, filled in below.
+	attributes = append(attributes,
+		radiusAttribute{radiusAttrMessageAuthenticator, make([]byte, 16)})
+	packet := encodeRADIUSPacket(radiusAccessRequest, identifier,
+		requestAuthenticator, attributes)
+	mac := hmac.New(md5.New, request.Secret)
+	mac.Write(packet)
+	copy(packet[len(packet)-16:], mac.Sum(nil))
+	return packet, requestAuthenticator, nil
+}
+
+func encodeRADIUSPacket(code byte, identifier byte, authenticator []byte,
+	attributes []radiusAttribute) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{code, identifier, 0, 0})
+	buf.Write(authenticator)
+	for _, attr := range attributes {
+		buf.Write([]byte{attr.attrType, byte(2 + len(attr.value))})
+		buf.Write(attr.value)
+	}
+	packet := buf.Bytes()
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	return packet
+}
+
+// radiusHidePassword implements the User-Password hiding of RFC 2865 5.2.
+func radiusHidePassword(password []byte, secret []byte,
+	requestAuthenticator []byte) []byte {
+	length := (len(password) + 15) / 16 * 16
+	if length == 0 {
+		length = 16
+	}
+	result := make([]byte, length)
+	copy(result, password)
+	previous := requestAuthenticator
+	for i := 0; i < length; i += 16 {
+		hash := md5.New()
+		hash.Write(secret)
+		hash.Write(previous)
+		b := hash.Sum(nil)
+		for j := 0; j < 16; j++ {
+			result[i+j] ^= b[j]
+		}
+		previous = result[i : i+16]
+	}
+	return result
+}
+
+// parseRADIUSResponse verifies the Response Authenticator (and the
+// Message-Authenticator, if present) and returns the response code.
+func parseRADIUSResponse(packet []byte, identifier byte,
+	requestAuthenticator []byte, secret []byte) (byte, error) {
+	if len(packet) < radiusHeaderLength {
+		return 0, errors.New("radius: short packet")
+	}
+	length := int(binary.BigEndian.Uint16(packet[2:4]))
+	if length < radiusHeaderLength || length > len(packet) {
+		return 0, errors.New("radius: bad packet length")
+	}
+	packet = packet[:length]
+	if packet[1] != identifier {
+		return 0, errors.New("radius: identifier mismatch")
+	}
+	hash := md5.New()
+	hash.Write(packet[:4])
+	hash.Write(requestAuthenticator)
+	hash.Write(packet[radiusHeaderLength:])
+	hash.Write(secret)
+	if !hmac.Equal(hash.Sum(nil), packet[4:radiusHeaderLength]) {
+		return 0, errors.New("radius: bad response authenticator")
+	}
+	for offset := radiusHeaderLength; offset < length; {
+		if offset+2 > length {
+			return 0, errors.New("radius: truncated attribute")
+		}
+		attrLength := int(packet[offset+1])
+		if attrLength < 2 || offset+attrLength > length {
+			return 0, errors.New("radius: bad attribute length")
+		}
+		if packet[offset] == radiusAttrMessageAuthenticator {
+			if attrLength != 18 {
+				return 0, errors.New("radius: bad Message-Authenticator")
+			}
+			check := make([]byte, length)
+			copy(check, packet)
+			copy(check[4:radiusHeaderLength], requestAuthenticator)
+			for i := offset + 2; i < offset+18; i++ {
+				check[i] = 0
+			}
+			mac := hmac.New(md5.New, secret)
+			mac.Write(check)
+			if !hmac.Equal(mac.Sum(nil), packet[offset+2:offset+18]) {
+				return 0, errors.New("radius: bad Message-Authenticator")
+			}
+		}
+		offset += attrLength
+	}
+	return packet[0], nil
+}
@@ -0,0 +1,142 @@
+package authutil
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"net"
+	"testing"
+	"time"
+)
+
+// startFakeRADIUSServer answers Access-Requests for user "user" with
+// password "password", supporting both PAP and CHAP.
+func startFakeRADIUSServer(t *testing.T, secret []byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, radiusMaxPacketLength)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			response := fakeRADIUSResponse(buf[:n], secret)
+			if response != nil {
+				conn.WriteTo(response, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func fakeRADIUSResponse(packet []byte, secret []byte) []byte {
+	if len(packet) < radiusHeaderLength || packet[0] != radiusAccessRequest {
+		return nil
+	}
+	requestAuthenticator := packet[4:radiusHeaderLength]
+	attributes := make(map[byte][]byte)
+	for offset := radiusHeaderLength; offset+2 <= len(packet); {
+		length := int(packet[offset+1])
+		if length < 2 || offset+length > len(packet) {
+			return nil
+		}
+		attributes[packet[offset]] = packet[offset+2 : offset+length]
+		offset += length
+	}
+	valid := false
+	if string(attributes[radiusAttrUserName]) == "user" {
+		if hidden, ok := attributes[radiusAttrUserPassword]; ok {
+			// Hiding is an involution when applied chunk by chunk.
+			password := make([]byte, len(hidden))
+			previous := requestAuthenticator
+			for i := 0; i < len(hidden); i += 16 {
+				hash := md5.New()
+				hash.Write(secret)
+				hash.Write(previous)
+				b := hash.Sum(nil)
+				for j := 0; j < 16; j++ {
+					password[i+j] = hidden[i+j] ^ b[j]
+				}
+				previous = hidden[i : i+16]
+			}
+			valid = string(bytes.TrimRight(password, "\x00")) == "password"
+		}
+		if chap, ok := attributes[radiusAttrCHAPPassword]; ok && len(chap) == 17 {
+			hash := md5.New()
+			hash.Write(chap[:1])
+			hash.Write([]byte("password"))
+			hash.Write(attributes[radiusAttrCHAPChallenge])
+			valid = bytes.Equal(hash.Sum(nil), chap[1:])
+		}
+	}
+	code := byte(radiusAccessReject)
+	if valid {
+		code = radiusAccessAccept
+	}
+	response := encodeRADIUSPacket(code, packet[1], requestAuthenticator,
+		[]radiusAttribute{{radiusAttrMessageAuthenticator, make([]byte, 16)}})
+	mac := hmac.New(md5.New, secret)
+	mac.Write(response)
+	copy(response[len(response)-16:], mac.Sum(nil))
+	hash := md5.New()
+	hash.Write(response)
+	hash.Write(secret)
+	copy(response[4:radiusHeaderLength], hash.Sum(nil))
+	return response
+}
+
+func TestCheckRADIUSUserPassword(t *testing.T) {
+	secret := []byte("testing123")
+	server := startFakeRADIUSServer(t, secret)
+	for _, method := range []string{RADIUSAuthPAP, RADIUSAuthCHAP} {
+		request := RADIUSRequest{
+			Server:        server,
+			Secret:        secret,
+			Method:        method,
+			NASIdentifier: "keymaster",
+			Timeout:       time.Second,
+		}
+		valid, err := CheckRADIUSUserPassword(request, "user", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !valid {
+			t.Fatalf("%s: valid password rejected", method)
+		}
+		valid, err = CheckRADIUSUserPassword(request, "user", "wrong")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid {
+			t.Fatalf("%s: invalid password accepted", method)
+		}
+	}
+	// Responses signed with another secret are dropped.
+	request := RADIUSRequest{
+		Server:  server,
+		Secret:  []byte("othersecret"),
+		Timeout: 100 * time.Millisecond,
+	}
+	if _, err := CheckRADIUSUserPassword(request, "user", "password"); err == nil {
+		t.Fatal("response with bad authenticator should have been ignored")
+	}
+}
+
+func TestRADIUSHidePasswordLength(t *testing.T) {
+	authenticator := make([]byte, 16)
+	for _, test := range []struct {
+		passwordLength int
+		hiddenLength   int
+	}{{1, 16}, {16, 16}, {17, 32}, {128, 128}} {
+		hidden := radiusHidePassword(make([]byte, test.passwordLength),
+			[]byte("secret"), authenticator)
+		if len(hidden) != test.hiddenLength {
+			t.Errorf("password length %d: got %d, want %d",
+				test.passwordLength, len(hidden), test.hiddenLength)
+		}
+	}
+}
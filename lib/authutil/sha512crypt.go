@@ -0,0 +1,158 @@
+package authutil
+
+import (
+	"crypto/sha512"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// SHA-512 based crypt(3) hashes ("$6$") as specified in
+// https://www.akkadia.org/drepper/SHA-crypt.txt
+
+const sha512CryptPrefix = "$6$"
+const sha512CryptRoundsPrefix = "rounds="
+const sha512CryptDefaultRounds = 5000
+const sha512CryptMinRounds = 1000
+const sha512CryptMaxRounds = 999999999
+const sha512CryptMaxSaltLength = 16
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// sha512CryptByteOrder is the order in which the digest bytes are encoded,
+// three at a time.
+var sha512CryptByteOrder = [][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+	{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+	{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+	{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+	{62, 20, 41},
+}
+
+// sha512Crypt returns the hash of password using the salt and rounds of
+// setting, which may be a complete hash.
+func sha512Crypt(password []byte, setting string) (string, error) {
+	if !strings.HasPrefix(setting, sha512CryptPrefix) {
+		return "", errors.New("not a SHA-512 crypt hash")
+	}
+	setting = setting[len(sha512CryptPrefix):]
+	rounds := sha512CryptDefaultRounds
+	customRounds := false
+	if strings.HasPrefix(setting, sha512CryptRoundsPrefix) {
+		fields := strings.SplitN(setting[len(sha512CryptRoundsPrefix):], "$", 2)
+		if len(fields) != 2 {
+			return "", errors.New("malformed SHA-512 crypt rounds")
+		}
+		parsedRounds, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return "", errors.New("malformed SHA-512 crypt rounds")
+		}
+		switch {
+		case parsedRounds < sha512CryptMinRounds:
+			rounds = sha512CryptMinRounds
+		case parsedRounds > sha512CryptMaxRounds:
+			rounds = sha512CryptMaxRounds
+		default:
+			rounds = int(parsedRounds)
+		}
+		customRounds = true
+		setting = fields[1]
+	}
+	salt := setting
+	if index := strings.IndexByte(salt, '$'); index >= 0 {
+		salt = salt[:index]
+	}
+	if len(salt) > sha512CryptMaxSaltLength {
+		salt = salt[:sha512CryptMaxSaltLength]
+	}
+	saltBytes := []byte(salt)
+
+	hash := sha512.New()
+	hash.Write(password)
+	hash.Write(saltBytes)
+	hash.Write(password)
+	digestB := hash.Sum(nil)
+
+	hash.Reset()
+	hash.Write(password)
+	hash.Write(saltBytes)
+	hash.Write(repeatBytes(digestB, len(password)))
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			hash.Write(digestB)
+		} else {
+			hash.Write(password)
+		}
+	}
+	digestA := hash.Sum(nil)
+
+	hash.Reset()
+	for range password {
+		hash.Write(password)
+	}
+	passwordSequence := repeatBytes(hash.Sum(nil), len(password))
+
+	hash.Reset()
+	for i := 0; i < 16+int(digestA[0]); i++ {
+		hash.Write(saltBytes)
+	}
+	saltSequence := repeatBytes(hash.Sum(nil), len(saltBytes))
+
+	digest := digestA
+	for round := 0; round < rounds; round++ {
+		hash.Reset()
+		if round&1 != 0 {
+			hash.Write(passwordSequence)
+		} else {
+			hash.Write(digest)
+		}
+		if round%3 != 0 {
+			hash.Write(saltSequence)
+		}
+		if round%7 != 0 {
+			hash.Write(passwordSequence)
+		}
+		if round&1 != 0 {
+			hash.Write(digest)
+		} else {
+			hash.Write(passwordSequence)
+		}
+		digest = hash.Sum(nil)
+	}
+
+	var result strings.Builder
+	result.WriteString(sha512CryptPrefix)
+	if customRounds {
+		result.WriteString(sha512CryptRoundsPrefix)
+		result.WriteString(strconv.Itoa(rounds))
+		result.WriteByte('$')
+	}
+	result.WriteString(salt)
+	result.WriteByte('$')
+	for _, order := range sha512CryptByteOrder {
+		writeCrypt64(&result, uint(digest[order[0]])<<16|
+			uint(digest[order[1]])<<8|uint(digest[order[2]]), 4)
+	}
+	writeCrypt64(&result, uint(digest[63]), 2)
+	return result.String(), nil
+}
+
+// repeatBytes returns the first length bytes of data repeated.
+func repeatBytes(data []byte, length int) []byte {
+	result := make([]byte, 0, length)
+	for len(result) < length {
+		remaining := length - len(result)
+		if remaining > len(data) {
+			remaining = len(data)
+		}
+		result = append(result, data[:remaining]...)
+	}
+	return result
+}
+
+func writeCrypt64(builder *strings.Builder, value uint, length int) {
+	for i := 0; i < length; i++ {
+		builder.WriteByte(cryptAlphabet[value&0x3f])
+		value >>= 6
+	}
+}
@@ -0,0 +1,36 @@
+package authutil
+
+import (
+	"testing"
+)
+
+// Test vectors from the SHA-crypt specification.
+var sha512CryptTests = []struct {
+	setting  string
+	password string
+	expected string
+}{
+	{"$6$saltstring", "Hello world!",
+		"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+	{"$6$rounds=10000$saltstringsaltstring", "Hello world!",
+		"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+	{"$6$rounds=5000$toolongsaltstring", "This is just a test",
+		"$6$rounds=5000$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0"},
+	{"$6$rounds=10$roundstoolow", "the minimum number is still observed",
+		"$6$rounds=1000$roundstoolow$kUMsbe306n21p9R.FRkW3IGn.S9NPN0x50YhH1xhLsPuWGsUSklZt58jaTfF4ZEQpyUNGc0dqbpBYYBaHHrsX."},
+}
+
+func TestSHA512Crypt(t *testing.T) {
+	for _, test := range sha512CryptTests {
+		hash, err := sha512Crypt([]byte(test.password), test.setting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != test.expected {
+			t.Errorf("%s: got %s, want %s", test.setting, hash, test.expected)
+		}
+	}
+	if _, err := sha512Crypt([]byte("x"), "$5$salt"); err == nil {
+		t.Fatal("SHA-256 crypt should not be accepted")
+	}
+}
@@ -0,0 +1,19 @@
+// Package awsv4 signs HTTP requests to AWS and S3 compatible services with
+// AWS Signature Version 4.
+package awsv4
+
+import (
+	"net/http"
+	"time"
+)
+
+// Sign adds an AWS Signature Version 4 to req, covering the host, the
+// content type and the X-Amz headers. body is the body of req, which is not
+// read. The query of req must already be in canonical form: sorted and URI
+// encoded. An empty sessionToken is not sent.
+func Sign(req *http.Request, body []byte, accessKeyID string,
+	secretAccessKey string, sessionToken string, region string,
+	service string, now time.Time) {
+	sign(req, body, accessKeyID, secretAccessKey, sessionToken, region,
+		service, now)
+}
@@ -0,0 +1,25 @@
+package awsv4
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// The get-vanilla case of the AWS Signature Version 4 test suite.
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Sign(req, nil, "AKIDEXAMPLE",
+		"wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "", "us-east-1",
+		"service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	expected := "AWS4-HMAC-SHA256 " +
+		"Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if authorization := req.Header.Get("Authorization"); authorization != expected {
+		t.Errorf("Authorization: %s", authorization)
+	}
+}
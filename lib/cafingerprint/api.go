@@ -0,0 +1,42 @@
+// Package cafingerprint formats the fingerprints of the keymaster CA keys
+// as DNS TXT records and checks trust bundles fetched over HTTP against
+// them, so that a compromised server (or a man in the middle with a
+// mis-issued TLS certificate) cannot substitute CA keys unnoticed without
+// also controlling DNS.
+//
+// Records follow the presentation format of SSHFP (RFC 4255) and TLSA
+// (RFC 6698) records, prefixed by the record type, since neither record
+// type is meant for CA keys and not every DNS provider serves them:
+//
+//	sshfp 4 2 <SHA-256 of the SSH public key blob>
+//	tlsa 2 1 1 <SHA-256 of the X.509 SubjectPublicKeyInfo>
+package cafingerprint
+
+import (
+	"crypto/x509"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHRecord returns the record of an SSH CA key.
+func SSHRecord(key ssh.PublicKey) (string, error) {
+	return sshRecord(key)
+}
+
+// X509Record returns the record of an X.509 CA certificate.
+func X509Record(cert *x509.Certificate) string {
+	return x509Record(cert)
+}
+
+// Lookup returns the records published in the TXT records of name. Other
+// TXT records are ignored.
+func Lookup(name string) ([]string, error) {
+	return lookup(name)
+}
+
+// Verify returns an error unless every key and certificate has a record in
+// records.
+func Verify(records []string, sshKeys []ssh.PublicKey,
+	certs []*x509.Certificate) error {
+	return verify(records, sshKeys, certs)
+}
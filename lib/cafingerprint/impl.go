@@ -0,0 +1,91 @@
+package cafingerprint
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshfpPrefix = "sshfp "
+	tlsaPrefix  = "tlsa "
+)
+
+// SSHFP algorithm numbers (RFC 4255, 6594 and 7479).
+var sshfpAlgorithms = map[string]int{
+	ssh.KeyAlgoRSA:      1,
+	ssh.KeyAlgoDSA:      2,
+	ssh.KeyAlgoECDSA256: 3,
+	ssh.KeyAlgoECDSA384: 3,
+	ssh.KeyAlgoECDSA521: 3,
+	ssh.KeyAlgoED25519:  4,
+}
+
+var lookupTXT = net.LookupTXT
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func sshRecord(key ssh.PublicKey) (string, error) {
+	algorithm, ok := sshfpAlgorithms[key.Type()]
+	if !ok {
+		return "", fmt.Errorf("cafingerprint: unsupported key type %s",
+			key.Type())
+	}
+	// Fingerprint type 2 is SHA-256.
+	return fmt.Sprintf("%s%d 2 %s", sshfpPrefix, algorithm,
+		sha256Hex(key.Marshal())), nil
+}
+
+func x509Record(cert *x509.Certificate) string {
+	// DANE-TA, SubjectPublicKeyInfo, SHA-256.
+	return tlsaPrefix + "2 1 1 " + sha256Hex(cert.RawSubjectPublicKeyInfo)
+}
+
+func lookup(name string) ([]string, error) {
+	values, err := lookupTXT(name)
+	if err != nil {
+		return nil, err
+	}
+	var records []string
+	for _, value := range values {
+		value = strings.ToLower(strings.Join(strings.Fields(value), " "))
+		if strings.HasPrefix(value, sshfpPrefix) ||
+			strings.HasPrefix(value, tlsaPrefix) {
+			records = append(records, value)
+		}
+	}
+	return records, nil
+}
+
+func verify(records []string, sshKeys []ssh.PublicKey,
+	certs []*x509.Certificate) error {
+	published := make(map[string]bool, len(records))
+	for _, record := range records {
+		published[record] = true
+	}
+	for _, key := range sshKeys {
+		record, err := sshRecord(key)
+		if err != nil {
+			return err
+		}
+		if !published[record] {
+			return fmt.Errorf("cafingerprint: SSH CA key %s not in DNS",
+				ssh.FingerprintSHA256(key))
+		}
+	}
+	for _, cert := range certs {
+		if record := x509Record(cert); !published[record] {
+			return fmt.Errorf("cafingerprint: X.509 CA %q not in DNS",
+				cert.Subject.CommonName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package cafingerprint
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestVerify(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Keymaster CA"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		public, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshRecord, err := SSHRecord(sshKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sshRecord, "sshfp 4 2 ") || len(sshRecord) != 74 {
+		t.Errorf("unexpected record: %s", sshRecord)
+	}
+	lookupTXT = func(name string) ([]string, error) {
+		return []string{"v=spf1 -all", strings.ToUpper(sshRecord),
+			X509Record(cert)}, nil
+	}
+	records, err := Lookup("_keymaster-ca.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("unexpected records: %v", records)
+	}
+	if err := Verify(records, []ssh.PublicKey{sshKey},
+		[]*x509.Certificate{cert}); err != nil {
+		t.Error(err)
+	}
+	if err := Verify(records[1:], []ssh.PublicKey{sshKey}, nil); err == nil {
+		t.Error("missing SSH record not detected")
+	}
+	if err := Verify(records[:1], nil,
+		[]*x509.Certificate{cert}); err == nil {
+		t.Error("missing X.509 record not detected")
+	}
+}
@@ -0,0 +1,102 @@
+package certgen
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultSSHRSASignatureAlgorithm is the signature algorithm of SSH
+// certificates signed by RSA CA keys unless another one is chosen. OpenSSH
+// 8.8 and later refuse the SHA-1 ssh-rsa signatures by default.
+const DefaultSSHRSASignatureAlgorithm = ssh.KeyAlgoRSASHA512
+
+// rsaSHA2Signer signs with a fixed SHA-2 RSA signature algorithm whatever
+// the caller asks for.
+type rsaSHA2Signer struct {
+	ssh.AlgorithmSigner
+	algorithm string
+}
+
+func (signer *rsaSHA2Signer) Sign(rand io.Reader,
+	data []byte) (*ssh.Signature, error) {
+	return signer.AlgorithmSigner.SignWithAlgorithm(rand, data,
+		signer.algorithm)
+}
+
+func (signer *rsaSHA2Signer) SignWithAlgorithm(rand io.Reader, data []byte,
+	algorithm string) (*ssh.Signature, error) {
+	return signer.Sign(rand, data)
+}
+
+// Algorithms makes the signer a MultiAlgorithmSigner, which
+// ssh.Certificate.SignCert asks for the algorithm to use.
+func (signer *rsaSHA2Signer) Algorithms() []string {
+	return []string{signer.algorithm}
+}
+
+// CheckSSHRSASignatureAlgorithm returns an error unless algorithm is a
+// SHA-2 RSA signature algorithm or empty for the default.
+func CheckSSHRSASignatureAlgorithm(algorithm string) error {
+	switch algorithm {
+	case "", ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512:
+		return nil
+	}
+	return fmt.Errorf("unsupported RSA signature algorithm %q, use %s or %s",
+		algorithm, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512)
+}
+
+// NewSSHCASigner returns the SSH signer for a CA key. Ed25519 and ECDSA
+// keys sign as usual. RSA keys sign with rsaAlgorithm, which is
+// rsa-sha2-256 or rsa-sha2-512 (the default if empty), never with SHA-1.
+func NewSSHCASigner(signer crypto.Signer,
+	rsaAlgorithm string) (ssh.Signer, error) {
+	if err := CheckSSHRSASignatureAlgorithm(rsaAlgorithm); err != nil {
+		return nil, err
+	}
+	sshSigner, err := ssh.NewSignerFromSigner(signer)
+	if err != nil {
+		return nil, err
+	}
+	switch keyType := sshSigner.PublicKey().Type(); keyType {
+	case ssh.KeyAlgoRSA:
+		if rsaAlgorithm == "" {
+			rsaAlgorithm = DefaultSSHRSASignatureAlgorithm
+		}
+		return withRSASignatureAlgorithm(sshSigner, rsaAlgorithm)
+	case ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384,
+		ssh.KeyAlgoECDSA521:
+		return sshSigner, nil
+	default:
+		return nil, fmt.Errorf("unsupported CA key type %s", keyType)
+	}
+}
+
+// withRSASignatureAlgorithm returns signer signing with algorithm.
+func withRSASignatureAlgorithm(signer ssh.Signer,
+	algorithm string) (ssh.Signer, error) {
+	if sha2Signer, ok := signer.(*rsaSHA2Signer); ok {
+		signer = sha2Signer.AlgorithmSigner
+	}
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, errors.New("RSA signer cannot sign with SHA-2")
+	}
+	return &rsaSHA2Signer{AlgorithmSigner: algorithmSigner,
+		algorithm: algorithm}, nil
+}
+
+// sshCertSigner returns signer, or for RSA signers with no algorithm chosen
+// by NewSSHCASigner one signing with DefaultSSHRSASignatureAlgorithm.
+func sshCertSigner(signer ssh.Signer) (ssh.Signer, error) {
+	if _, ok := signer.(*rsaSHA2Signer); ok {
+		return signer, nil
+	}
+	if signer.PublicKey().Type() != ssh.KeyAlgoRSA {
+		return signer, nil
+	}
+	return withRSASignatureAlgorithm(signer, DefaultSSHRSASignatureAlgorithm)
+}
@@ -0,0 +1,106 @@
+package certgen
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestNewSSHCASigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(userPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		key       crypto.Signer
+		algorithm string
+		format    string
+	}{
+		{rsaKey, "", ssh.KeyAlgoRSASHA512},
+		{rsaKey, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA256},
+		{ecdsaKey, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoECDSA384},
+		{ed25519Key, "", ssh.KeyAlgoED25519},
+	} {
+		signer, err := NewSSHCASigner(test.key, test.algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := GenSSHCert("foo", sshPub, signer, "bar", time.Hour,
+			SSHCertOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Signature.Format != test.format {
+			t.Errorf("signed with %s, expected %s", cert.Signature.Format,
+				test.format)
+		}
+		checker := ssh.CertChecker{
+			IsUserAuthority: func(auth ssh.PublicKey) bool {
+				return bytes.Equal(auth.Marshal(), signer.PublicKey().Marshal())
+			},
+		}
+		if err := checker.CheckCert("foo", cert); err != nil {
+			t.Errorf("%s: %s", test.format, err)
+		}
+	}
+	if _, err := NewSSHCASigner(rsaKey, ssh.KeyAlgoRSA); err == nil {
+		t.Error("SHA-1 signatures accepted")
+	}
+
+	// Plain RSA signers are upgraded from ssh-rsa too.
+	signer, err := ssh.NewSignerFromSigner(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := GenSSHCert("foo", sshPub, signer, "bar", time.Hour,
+		SSHCertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Signature.Format != DefaultSSHRSASignatureAlgorithm {
+		t.Errorf("signed with %s", cert.Signature.Format)
+	}
+}
+
+func TestGenSelfSignedCACertECDSA(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := GenSelfSignedCACert("some hostname", "some organization", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.CheckSignatureFrom(cert); err != nil {
+		t.Error(err)
+	}
+}
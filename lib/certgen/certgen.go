@@ -5,7 +5,10 @@ package certgen
 
 import (
 	"bytes"
+	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -18,8 +21,10 @@ import (
 	"fmt"
 	"math/big"
 	"os/exec"
+	"strings"
 	"time"
 
+	"github.com/Symantec/keymaster/lib/pubkeysource"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -39,49 +44,152 @@ func goCertToFileString(c ssh.Certificate, username string) (string, error) {
 	certBytes := c.Marshal()
 	encoded := base64.StdEncoding.EncodeToString(certBytes)
 	fileComment := "/tmp/" + username + "-cert.pub"
-	return "ssh-rsa-cert-v01@openssh.com " + encoded + " " + fileComment, nil
+	return c.Type() + " " + encoded + " " + fileComment, nil
 }
 
 // gen_user_cert a username and key, returns a short lived cert for that user
 func GenSSHCertFileString(username string, userPubKey string, signer ssh.Signer, host_identity string, duration time.Duration) (string, []byte, error) {
-	userKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(userPubKey))
-	if err != nil {
-		return "", nil, err
+	return GenSSHCertFileStringWithExtensions(username, userPubKey, signer,
+		host_identity, duration, nil)
+}
+
+// GenSSHCertFileStringWithExtensions is GenSSHCertFileString with
+// extensions added to the default permissions.
+func GenSSHCertFileStringWithExtensions(username string, userPubKey string,
+	signer ssh.Signer, host_identity string, duration time.Duration,
+	extensions map[string]string) (string, []byte, error) {
+	permissions := DefaultSSHExtensions()
+	for name, value := range extensions {
+		permissions[name] = value
 	}
-	keyIdentity := host_identity + "_" + username
+	return GenSSHCertFileStringWithOptions(username, userPubKey, signer,
+		host_identity, duration, SSHCertOptions{Extensions: permissions})
+}
 
-	currentEpoch := uint64(time.Now().Unix())
-	expireEpoch := currentEpoch + uint64(duration.Seconds())
+// DefaultSSHExtensions returns the permissions of the certificates generated
+// by GenSSHCertFileString. The values are taken from the default values used
+// by ssh-keygen.
+func DefaultSSHExtensions() map[string]string {
+	return map[string]string{
+		"permit-X11-forwarding":   "",
+		"permit-agent-forwarding": "",
+		"permit-port-forwarding":  "",
+		"permit-pty":              "",
+		"permit-user-rc":          ""}
+}
+
+// SSHCertOptions customizes the certificates generated by GenSSHCert and
+// GenSSHCertFileStringWithOptions.
+type SSHCertOptions struct {
+	// Principals default to the username.
+	Principals []string
+	// KeyID defaults to <host_identity>_<username>.
+	KeyID string
+	// ValidAfter defaults to the current time. The certificate is valid
+	// for the duration passed to the generating function from then on.
+	ValidAfter time.Time
+	// Serial defaults to the issue time in the upper 32 bits followed by
+	// 32 random bits.
+	Serial uint64
+	// Extensions are the complete permissions of the certificate.
+	Extensions      map[string]string
+	CriticalOptions map[string]string
+}
 
+// NewSSHCertSerial returns the default serial of a certificate issued at t:
+// the issue time in the upper 32 bits followed by 32 random bits.
+func NewSSHCertSerial(t time.Time) (uint64, error) {
 	nBig, err := rand.Int(rand.Reader, big.NewInt(0xFFFFFFFF))
 	if err != nil {
-		return "", nil, err
+		return 0, err
 	}
-	serial := (currentEpoch << 32) | nBig.Uint64()
+	return (uint64(t.Unix()) << 32) | nBig.Uint64(), nil
+}
 
-	// The values of the permissions are taken from the default values used
-	// by ssh-keygen
-	cert := ssh.Certificate{
+// GenSSHCert returns a user certificate for userKey signed by signer, for
+// programs that embed keymaster and want the certificate itself rather than
+// its authorized_keys format. Use DefaultSSHExtensions for the permissions
+// of the certificates keymaster issues.
+func GenSSHCert(username string, userKey ssh.PublicKey, signer ssh.Signer,
+	hostIdentity string, duration time.Duration,
+	options SSHCertOptions) (*ssh.Certificate, error) {
+	if userKey == nil {
+		return nil, errors.New("no public key")
+	}
+	if _, ok := userKey.(*ssh.Certificate); ok {
+		return nil, errors.New("cannot sign a certificate")
+	}
+	if duration < 0 {
+		return nil, errors.New("negative duration")
+	}
+	keyIdentity := options.KeyID
+	if keyIdentity == "" {
+		keyIdentity = hostIdentity + "_" + username
+	}
+	principals := options.Principals
+	if len(principals) < 1 {
+		principals = []string{username}
+	}
+	validAfter := options.ValidAfter
+	if validAfter.IsZero() {
+		validAfter = time.Now()
+	}
+	currentEpoch := uint64(validAfter.Unix())
+	expireEpoch := currentEpoch + uint64(duration.Seconds())
+
+	serial := options.Serial
+	if serial == 0 {
+		var err error
+		serial, err = NewSSHCertSerial(time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cert := &ssh.Certificate{
 		Key:             userKey,
 		CertType:        ssh.UserCert,
 		SignatureKey:    signer.PublicKey(),
-		ValidPrincipals: []string{username},
+		ValidPrincipals: principals,
 		KeyId:           keyIdentity,
 		ValidAfter:      currentEpoch,
 		ValidBefore:     expireEpoch,
 		Serial:          serial,
-		Permissions: ssh.Permissions{Extensions: map[string]string{
-			"permit-X11-forwarding":   "",
-			"permit-agent-forwarding": "",
-			"permit-port-forwarding":  "",
-			"permit-pty":              "",
-			"permit-user-rc":          ""}}}
-
-	err = cert.SignCert(bytes.NewReader(cert.Marshal()), signer)
+		Permissions: ssh.Permissions{
+			CriticalOptions: options.CriticalOptions,
+			Extensions:      options.Extensions}}
+
+	signer, err := sshCertSigner(signer)
+	if err != nil {
+		return nil, err
+	}
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// SSHCertFileString returns cert in the authorized_keys format written to
+// <username>-cert.pub files.
+func SSHCertFileString(cert *ssh.Certificate, username string) (string, error) {
+	return goCertToFileString(*cert, username)
+}
+
+// GenSSHCertFileStringWithOptions is GenSSHCertFileString with the
+// principals, key ID and permissions given in options.
+func GenSSHCertFileStringWithOptions(username string, userPubKey string,
+	signer ssh.Signer, host_identity string, duration time.Duration,
+	options SSHCertOptions) (string, []byte, error) {
+	userKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(userPubKey))
+	if err != nil {
+		return "", nil, err
+	}
+	cert, err := GenSSHCert(username, userKey, signer, host_identity,
+		duration, options)
 	if err != nil {
 		return "", nil, err
 	}
-	certString, err := goCertToFileString(cert, username)
+	certString, err := goCertToFileString(*cert, username)
 	if err != nil {
 		return "", nil, err
 	}
@@ -89,16 +197,35 @@ func GenSSHCertFileString(username string, userPubKey string, signer ssh.Signer,
 }
 
 func GenSSHCertFileStringFromSSSDPublicKey(userName string, signer ssh.Signer, hostIdentity string, duration time.Duration) (string, []byte, error) {
+	return GenSSHCertFileStringFromPublicKeySource(userName,
+		pubkeysource.NewCommand(pubkeysource.DefaultSSSDCommand, nil),
+		signer, hostIdentity, duration)
+}
 
-	userPubKey, err := GetUserPubKeyFromSSSD(userName)
+// GenSSHCertFileStringFromPublicKeySource signs the first key of userName
+// returned by source.
+func GenSSHCertFileStringFromPublicKeySource(userName string,
+	source pubkeysource.Source, signer ssh.Signer, hostIdentity string,
+	duration time.Duration) (string, []byte, error) {
+	return GenSSHCertFileStringFromPublicKeySourceContext(context.Background(),
+		userName, source, signer, hostIdentity, duration)
+}
+
+// GenSSHCertFileStringFromPublicKeySourceContext is
+// GenSSHCertFileStringFromPublicKeySource but stops looking up the keys once
+// ctx is done.
+func GenSSHCertFileStringFromPublicKeySourceContext(ctx context.Context,
+	userName string, source pubkeysource.Source, signer ssh.Signer,
+	hostIdentity string, duration time.Duration) (string, []byte, error) {
+	userPubKeys, err := pubkeysource.PublicKeysContext(ctx, source, userName)
 	if err != nil {
 		return "", nil, err
 	}
-	cert, certBytes, err := GenSSHCertFileString(userName, userPubKey, signer, hostIdentity, duration)
-	if err != nil {
-		return "", nil, err
+	if len(userPubKeys) < 1 {
+		return "", nil, pubkeysource.ErrUserNotFound
 	}
-	return cert, certBytes, err
+	return GenSSHCertFileString(userName, strings.Join(userPubKeys, "\n"),
+		signer, hostIdentity, duration)
 }
 
 /// X509 section
@@ -125,6 +252,16 @@ func GetSignerFromPEMBytes(privateKey []byte) (crypto.Signer, error) {
 		return x509.ParsePKCS1PrivateKey(block.Bytes)
 	case "EC PRIVATE KEY":
 		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("Cannot process that key")
+		}
+		return signer, nil
 	default:
 		err := errors.New("Cannot process that key")
 		return nil, err
@@ -136,8 +273,10 @@ func publicKey(priv interface{}) interface{} {
 	switch k := priv.(type) {
 	case *rsa.PrivateKey:
 		return &k.PublicKey
-	//case *ecdsa.PrivateKey:
-	//	return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
 	default:
 		return nil
 	}
@@ -167,7 +306,12 @@ func GenSelfSignedCACert(commonName string, organization string, caPriv crypto.S
 		return nil, err
 	}
 	sum := sha256.Sum256([]byte(commonName))
-	signedCN, err := caPriv.Sign(rand.Reader, sum[:], crypto.SHA256)
+	var signerOpts crypto.SignerOpts = crypto.SHA256
+	if _, ok := caPriv.(ed25519.PrivateKey); ok {
+		// Ed25519 signs the message itself.
+		signerOpts = crypto.Hash(0)
+	}
+	signedCN, err := caPriv.Sign(rand.Reader, sum[:], signerOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -182,7 +326,7 @@ func GenSelfSignedCACert(commonName string, organization string, caPriv crypto.S
 		},
 		NotBefore: notBefore,
 		NotAfter:  notAfter,
-		KeyUsage:  x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		KeyUsage:  x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		//ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 		IsCA:                  true,
@@ -283,6 +427,17 @@ func GenUserX509Cert(userName string, userPub interface{},
 	caCert *x509.Certificate, caPriv crypto.Signer,
 	kerberosRealm *string, duration time.Duration,
 	groups []string, organizations []string) ([]byte, error) {
+	return GenUserX509CertWithOptions(userName, userPub, caCert, caPriv,
+		kerberosRealm, duration, groups, organizations, X509CertOptions{})
+}
+
+// GenUserX509CertWithOptions is GenUserX509Cert with the extensions in
+// options added.
+func GenUserX509CertWithOptions(userName string, userPub interface{},
+	caCert *x509.Certificate, caPriv crypto.Signer,
+	kerberosRealm *string, duration time.Duration,
+	groups []string, organizations []string,
+	options X509CertOptions) ([]byte, error) {
 	//// Now do the actual work...
 	notBefore := time.Now()
 	notAfter := notBefore.Add(duration)
@@ -317,6 +472,8 @@ func GenUserX509Cert(userName string, userPub interface{},
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
 		UnknownExtKeyUsage:    []asn1.ObjectIdentifier{kerberosClientExtKeyUsage},
+		CRLDistributionPoints: options.CRLDistributionPoints,
+		OCSPServer:            options.OCSPServers,
 		BasicConstraintsValid: true,
 		IsCA:                  false,
 	}
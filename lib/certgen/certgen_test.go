@@ -1,7 +1,11 @@
 package certgen
 
 import (
+	"bytes"
+	"context"
 	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
@@ -12,6 +16,7 @@ import (
 	"time"
 
 	"github.com/Symantec/Dominator/lib/x509util"
+	"github.com/Symantec/keymaster/lib/pubkeysource"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -170,6 +175,108 @@ func TestGenSSHCertFileStringGenerateSuccess(t *testing.T) {
 	t.Logf("got '%s'", c)
 }
 
+func TestGenSSHCertFileStringEd25519(t *testing.T) {
+	goodSigner, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(userPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := GenSSHCertFileString("foo",
+		string(ssh.MarshalAuthorizedKey(sshPub)), goodSigner, "bar", testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file must be readable by ssh, which checks the key type.
+	if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenSSHCert(t *testing.T) {
+	signer, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(userPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validAfter := time.Now().Add(-time.Minute)
+	cert, err := GenSSHCert("foo", sshPub, signer, "bar", time.Hour,
+		SSHCertOptions{
+			Principals:      []string{"foo", "deploy"},
+			ValidAfter:      validAfter,
+			Serial:          42,
+			Extensions:      DefaultSSHExtensions(),
+			CriticalOptions: map[string]string{"force-command": "/bin/true"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.KeyId != "bar_foo" || cert.Serial != 42 ||
+		len(cert.ValidPrincipals) != 2 ||
+		cert.CriticalOptions["force-command"] != "/bin/true" {
+		t.Errorf("unexpected certificate: %+v", cert)
+	}
+	if cert.ValidAfter != uint64(validAfter.Unix()) ||
+		cert.ValidBefore != cert.ValidAfter+3600 {
+		t.Errorf("valid from %d to %d", cert.ValidAfter, cert.ValidBefore)
+	}
+	if _, ok := cert.Extensions["permit-pty"]; !ok {
+		t.Error("no default extensions")
+	}
+	checker := ssh.CertChecker{
+		SupportedCriticalOptions: []string{"force-command"},
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), signer.PublicKey().Marshal())
+		},
+	}
+	if err := checker.CheckCert("deploy", cert); err != nil {
+		t.Error(err)
+	}
+	certString, err := SSHCertFileString(cert, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.Marshal(), cert.Marshal()) {
+		t.Error("file string does not round trip")
+	}
+	if _, err := GenSSHCert("foo", cert, signer, "bar", time.Hour,
+		SSHCertOptions{}); err == nil {
+		t.Error("signed a certificate")
+	}
+}
+
+func TestNewSSHCertSerial(t *testing.T) {
+	issuedAt := time.Unix(1790000000, 0)
+	first, err := NewSSHCertSerial(issuedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewSSHCertSerial(issuedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first>>32 != uint64(issuedAt.Unix()) || first == second {
+		t.Errorf("unexpected serials %x and %x", first, second)
+	}
+}
+
 func TestGenSSHCertFileStringGenerateFailBadPublicKey(t *testing.T) {
 	username := "foo"
 	hostIdentity := "bar"
@@ -183,6 +290,29 @@ func TestGenSSHCertFileStringGenerateFailBadPublicKey(t *testing.T) {
 	}
 }
 
+func TestGenSSHCertFileStringFromPublicKeySourceContext(t *testing.T) {
+	goodSigner, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups := 0
+	source := pubkeysource.SourceFunc(func(string) ([]string, error) {
+		lookups++
+		return []string{testUserPublicKey}, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, _, err := GenSSHCertFileStringFromPublicKeySourceContext(ctx, "foo",
+		source, goodSigner, "bar", testDuration); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	_, _, err = GenSSHCertFileStringFromPublicKeySourceContext(ctx, "foo",
+		source, goodSigner, "bar", testDuration)
+	if err != context.Canceled || lookups != 1 {
+		t.Fatalf("expected cancellation before the lookup, got %v", err)
+	}
+}
+
 func TestGetUserPubKeyFromSSSD(t *testing.T) {
 	username, err := canDoSSSDTests()
 	if err != nil {
@@ -0,0 +1,32 @@
+package certgen
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"time"
+)
+
+// X509CertOptions holds optional extensions of issued X.509 certificates.
+type X509CertOptions struct {
+	// CRLDistributionPoints are the URLs where the CRL of the CA is served.
+	CRLDistributionPoints []string
+	// OCSPServers are the URLs of the OCSP responders of the CA.
+	OCSPServers []string
+}
+
+// GenX509CRL returns a DER encoded CRL of the revoked certificates, signed
+// by caPriv. caCert must allow CRL signing. number must increase with every
+// CRL of the CA.
+func GenX509CRL(caCert *x509.Certificate, caPriv crypto.Signer,
+	revoked []x509.RevocationListEntry, number *big.Int,
+	thisUpdate time.Time, nextUpdate time.Time) ([]byte, error) {
+	template := x509.RevocationList{
+		RevokedCertificateEntries: revoked,
+		Number:                    number,
+		ThisUpdate:                thisUpdate,
+		NextUpdate:                nextUpdate,
+	}
+	return x509.CreateRevocationList(rand.Reader, &template, caCert, caPriv)
+}
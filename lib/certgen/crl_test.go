@@ -0,0 +1,55 @@
+package certgen
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestGenX509CRL(t *testing.T) {
+	userPub, _, caPriv := setupX509Generator(t)
+	caDer, err := GenSelfSignedCACert("keymaster.example.com", "example",
+		caPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derCert, err := GenUserX509CertWithOptions("alice", userPub, caCert,
+		caPriv, nil, testDuration, nil, nil, X509CertOptions{
+			CRLDistributionPoints: []string{
+				"http://keymaster.example.com/public/x509.crl"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.CRLDistributionPoints) != 1 {
+		t.Fatalf("unexpected CDPs: %v", cert.CRLDistributionPoints)
+	}
+	now := time.Now().Truncate(time.Second)
+	crlDer, err := GenX509CRL(caCert, caPriv, []x509.RevocationListEntry{
+		{SerialNumber: cert.SerialNumber, RevocationTime: now},
+	}, big.NewInt(7), now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(crlDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(caCert); err != nil {
+		t.Fatal(err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 ||
+		crl.RevokedCertificateEntries[0].SerialNumber.Cmp(
+			cert.SerialNumber) != 0 || crl.Number.Int64() != 7 {
+		t.Errorf("unexpected CRL: %+v", crl)
+	}
+}
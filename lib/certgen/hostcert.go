@@ -0,0 +1,76 @@
+package certgen
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net"
+	"time"
+)
+
+// HostCertProfile describes the key usage of a host certificate.
+type HostCertProfile struct {
+	ExtKeyUsage        []x509.ExtKeyUsage
+	UnknownExtKeyUsage []asn1.ObjectIdentifier
+}
+
+// GenHostX509Cert returns an x509 cert for a host with the first of dnsNames
+// as the common name and all of dnsNames and ipAddresses as SANs. The
+// extended key usages are taken from profile.
+func GenHostX509Cert(dnsNames []string, ipAddresses []net.IP,
+	hostPub interface{}, caCert *x509.Certificate, caPriv crypto.Signer,
+	duration time.Duration, profile HostCertProfile) ([]byte, error) {
+	return GenHostX509CertWithOptions(dnsNames, ipAddresses, hostPub, caCert,
+		caPriv, duration, profile, X509CertOptions{})
+}
+
+// GenHostX509CertWithOptions is GenHostX509Cert with the extensions in
+// options added.
+func GenHostX509CertWithOptions(dnsNames []string, ipAddresses []net.IP,
+	hostPub interface{}, caCert *x509.Certificate, caPriv crypto.Signer,
+	duration time.Duration, profile HostCertProfile,
+	options X509CertOptions) ([]byte, error) {
+	if len(dnsNames) < 1 && len(ipAddresses) < 1 {
+		return nil, errors.New("host certificate needs at least one name")
+	}
+	notBefore := time.Now()
+	notAfter := notBefore.Add(duration)
+
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, err
+	}
+	var subject pkix.Name
+	if len(dnsNames) > 0 {
+		subject.CommonName = dnsNames[0]
+	} else {
+		subject.CommonName = ipAddresses[0].String()
+	}
+	// Only RSA keys encipher the TLS key exchange.
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := hostPub.(*rsa.PublicKey); ok {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               subject,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           profile.ExtKeyUsage,
+		UnknownExtKeyUsage:    profile.UnknownExtKeyUsage,
+		DNSNames:              dnsNames,
+		IPAddresses:           ipAddresses,
+		CRLDistributionPoints: options.CRLDistributionPoints,
+		OCSPServer:            options.OCSPServers,
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+	}
+	return x509.CreateCertificate(rand.Reader, &template, caCert, hostPub, caPriv)
+}
@@ -0,0 +1,63 @@
+package certgen
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"net"
+	"testing"
+)
+
+func TestGenHostX509Cert(t *testing.T) {
+	hostPub, caCert, caPriv := setupX509Generator(t)
+	profile := HostCertProfile{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth},
+	}
+	derCert, err := GenHostX509Cert(
+		[]string{"mx1.example.com", "mail.example.com"},
+		[]net.IP{net.ParseIP("10.0.0.1")},
+		hostPub, caCert, caPriv, testDuration, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _, err := derBytesCertToCertAndPem(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "mx1.example.com" {
+		t.Fatalf("unexpected common name %s", cert.Subject.CommonName)
+	}
+	if err := cert.VerifyHostname("mail.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.IPAddresses) != 1 || len(cert.ExtKeyUsage) != 2 {
+		t.Fatalf("unexpected SANs or EKUs: %v %v", cert.IPAddresses,
+			cert.ExtKeyUsage)
+	}
+	if cert.KeyUsage&x509.KeyUsageKeyEncipherment == 0 {
+		t.Errorf("RSA key usage %v", cert.KeyUsage)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derCert, err = GenHostX509Cert([]string{"mx1.example.com"}, nil,
+		&ecKey.PublicKey, caCert, caPriv, testDuration, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _, err = derBytesCertToCertAndPem(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Errorf("ECDSA key usage %v", cert.KeyUsage)
+	}
+	_, err = GenHostX509Cert(nil, nil, hostPub, caCert, caPriv, testDuration,
+		profile)
+	if err == nil {
+		t.Fatal("certificate without names should have been refused")
+	}
+}
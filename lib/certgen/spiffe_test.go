@@ -0,0 +1,62 @@
+package certgen
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestSPIFFEID(t *testing.T) {
+	id, err := SPIFFEID("example.org", "user", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.String() != "spiffe://example.org/user/alice" {
+		t.Fatalf("unexpected SPIFFE ID: %s", id)
+	}
+	badIDs := [][]string{
+		{"Example.org", "user", "alice"},
+		{"example.org"},
+		{"example.org", "user", "alice@example.org"},
+		{"example.org", "user", ".."},
+		{"example.org", "user", ""},
+	}
+	for _, badID := range badIDs {
+		if _, err := SPIFFEID(badID[0], badID[1:]...); err == nil {
+			t.Errorf("no error for %v", badID)
+		}
+	}
+}
+
+func TestGenSPIFFEX509SVID(t *testing.T) {
+	pub, caCert, caPriv := setupX509Generator(t)
+	id, err := SPIFFEID("example.org", "user", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	derCert, err := GenSPIFFEX509SVID(id, "alice", pub, caCert, caPriv,
+		testDuration, X509CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != id.String() {
+		t.Fatalf("unexpected URI SANs: %v", cert.URIs)
+	}
+	if cert.IsCA || cert.KeyUsage&(x509.KeyUsageCertSign|
+		x509.KeyUsageCRLSign) != 0 {
+		t.Fatal("an SVID must not be a CA")
+	}
+	if len(cert.DNSNames) != 0 || len(cert.EmailAddresses) != 0 {
+		t.Fatalf("unexpected SANs: %v %v", cert.DNSNames, cert.EmailAddresses)
+	}
+	if len(cert.ExtKeyUsage) != 2 {
+		t.Fatalf("unexpected EKUs: %v", cert.ExtKeyUsage)
+	}
+	if _, err := GenSPIFFEX509SVID(nil, "alice", pub, caCert, caPriv,
+		testDuration, X509CertOptions{}); err == nil {
+		t.Fatal("SVID without a SPIFFE ID should have been refused")
+	}
+}
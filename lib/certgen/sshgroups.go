@@ -0,0 +1,69 @@
+package certgen
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSH certificate extensions carrying the group memberships of the user when
+// the certificate was issued. They are covered by the CA signature, so hosts
+// trusting the CA can authorize by group without querying the directory.
+//
+// The value of SSHGroupsExtension is the sorted list of groups separated by
+// commas. If some groups did not fit SSHGroupsTruncatedExtension is present
+// too, with an empty value.
+const (
+	SSHGroupsExtension          = "groups@keymaster"
+	SSHGroupsTruncatedExtension = "groups-truncated@keymaster"
+)
+
+// SSHGroupClaimExtensions returns the extensions claiming groups, with at
+// most maxBytes of group names. Groups which are empty or contain a comma
+// cannot be represented and are skipped.
+func SSHGroupClaimExtensions(groups []string, maxBytes int) map[string]string {
+	seen := make(map[string]bool, len(groups))
+	var sorted []string
+	for _, group := range groups {
+		if group == "" || strings.Contains(group, ",") || seen[group] {
+			continue
+		}
+		seen[group] = true
+		sorted = append(sorted, group)
+	}
+	sort.Strings(sorted)
+	extensions := make(map[string]string)
+	var claimed []string
+	size := 0
+	for _, group := range sorted {
+		length := len(group)
+		if len(claimed) > 0 {
+			length++
+		}
+		if size+length > maxBytes {
+			extensions[SSHGroupsTruncatedExtension] = ""
+			break
+		}
+		claimed = append(claimed, group)
+		size += length
+	}
+	extensions[SSHGroupsExtension] = strings.Join(claimed, ",")
+	return extensions
+}
+
+// ParseSSHGroupClaim returns the groups claimed by cert and whether the list
+// is complete. ok is false if cert has no group claim, which must be treated
+// as no memberships.
+func ParseSSHGroupClaim(cert *ssh.Certificate) (groups []string,
+	complete bool, ok bool) {
+	value, ok := cert.Extensions[SSHGroupsExtension]
+	if !ok {
+		return nil, false, false
+	}
+	if value != "" {
+		groups = strings.Split(value, ",")
+	}
+	_, truncated := cert.Extensions[SSHGroupsTruncatedExtension]
+	return groups, !truncated, true
+}
@@ -0,0 +1,68 @@
+package certgen
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHGroupClaimExtensions(t *testing.T) {
+	extensions := SSHGroupClaimExtensions(
+		[]string{"web", "admins", "", "a,b", "web", "db"}, 1024)
+	if extensions[SSHGroupsExtension] != "admins,db,web" {
+		t.Errorf("unexpected claim %q", extensions[SSHGroupsExtension])
+	}
+	if _, ok := extensions[SSHGroupsTruncatedExtension]; ok {
+		t.Error("complete claim marked as truncated")
+	}
+	extensions = SSHGroupClaimExtensions([]string{"web", "admins", "db"}, 9)
+	if extensions[SSHGroupsExtension] != "admins,db" {
+		t.Errorf("unexpected truncated claim %q", extensions[SSHGroupsExtension])
+	}
+	if _, ok := extensions[SSHGroupsTruncatedExtension]; !ok {
+		t.Error("truncated claim not marked")
+	}
+}
+
+func TestParseSSHGroupClaim(t *testing.T) {
+	cert := &ssh.Certificate{}
+	if _, _, ok := ParseSSHGroupClaim(cert); ok {
+		t.Error("claim found in certificate without one")
+	}
+	cert.Extensions = SSHGroupClaimExtensions(nil, 1024)
+	groups, complete, ok := ParseSSHGroupClaim(cert)
+	if !ok || !complete || len(groups) != 0 {
+		t.Errorf("empty claim: %v %v %v", groups, complete, ok)
+	}
+	cert.Extensions = SSHGroupClaimExtensions([]string{"b", "a", "c"}, 3)
+	groups, complete, ok = ParseSSHGroupClaim(cert)
+	if !ok || complete || !reflect.DeepEqual(groups, []string{"a", "b"}) {
+		t.Errorf("truncated claim: %v %v %v", groups, complete, ok)
+	}
+}
+
+func TestGenSSHCertFileStringWithExtensions(t *testing.T) {
+	goodSigner, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, certBytes, err := GenSSHCertFileStringWithExtensions("foo",
+		testUserPublicKey, goodSigner, "bar", testDuration,
+		SSHGroupClaimExtensions([]string{"admins"}, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := ssh.ParsePublicKey(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := pubKey.(*ssh.Certificate)
+	groups, complete, ok := ParseSSHGroupClaim(cert)
+	if !ok || !complete || !reflect.DeepEqual(groups, []string{"admins"}) {
+		t.Errorf("unexpected claim: %v %v %v", groups, complete, ok)
+	}
+	if _, ok := cert.Extensions["permit-pty"]; !ok {
+		t.Error("default permissions lost")
+	}
+}
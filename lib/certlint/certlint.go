@@ -0,0 +1,102 @@
+// Package certlint checks freshly signed SSH and X.509 certificates against
+// what their profile is expected to produce, so that code or configuration
+// bugs are caught before a bad certificate reaches a user. X.509 certificates
+// are also run through the zlint lints, which do not cover SSH certificates,
+// so SSH certificates get custom lints instead. Lint names follow the zlint
+// convention: an "e_" prefix for errors and "w_" for warnings.
+package certlint
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Severity int
+
+const (
+	Warning Severity = iota
+	Error
+)
+
+// clockSkew is how far in the future a certificate may start to be valid.
+const clockSkew = 5 * time.Minute
+
+// lifetimeSlack allows for the time taken between computing and signing the
+// validity period.
+const lifetimeSlack = time.Minute
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Finding is a single failed check.
+type Finding struct {
+	Lint     string
+	Severity Severity
+	Details  string
+}
+
+func (f Finding) String() string {
+	return f.Lint + ": " + f.Details
+}
+
+type Findings []Finding
+
+// HasErrors returns true if any of the findings has Error severity.
+func (f Findings) HasErrors() bool {
+	for _, finding := range f {
+		if finding.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Findings) String() string {
+	lints := make([]string, 0, len(f))
+	for _, finding := range f {
+		lints = append(lints, finding.String())
+	}
+	return strings.Join(lints, "; ")
+}
+
+func (f *Findings) add(lint string, format string, args ...interface{}) {
+	severity := Warning
+	if strings.HasPrefix(lint, "e_") {
+		severity = Error
+	}
+	f.addSeverity(lint, severity, fmt.Sprintf(format, args...))
+}
+
+func (f *Findings) addSeverity(lint string, severity Severity,
+	details string) {
+	*f = append(*f, Finding{
+		Lint:     lint,
+		Severity: severity,
+		Details:  details,
+	})
+}
+
+func sameStrings(left, right []string) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	counts := make(map[string]int, len(left))
+	for _, s := range left {
+		counts[s]++
+	}
+	for _, s := range right {
+		if counts[s] < 1 {
+			return false
+		}
+		counts[s]--
+	}
+	return true
+}
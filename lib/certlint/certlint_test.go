@@ -0,0 +1,242 @@
+package certlint
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func hasLint(findings Findings, lint string) bool {
+	for _, finding := range findings {
+		if finding.Lint == lint {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestSSHCert(t *testing.T, caSigner ssh.Signer, now time.Time,
+	duration time.Duration) *ssh.Certificate {
+	userKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPublicKey, err := ssh.NewPublicKey(&userKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             userPublicKey,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"alice"},
+		KeyId:           "host_alice",
+		Serial:          1,
+		ValidAfter:      uint64(now.Unix()),
+		ValidBefore:     uint64(now.Add(duration).Unix()),
+		Permissions: ssh.Permissions{Extensions: map[string]string{
+			"permit-pty": ""}},
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestLintSSHCert(t *testing.T) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	profile := SSHProfile{
+		CertType:    ssh.UserCert,
+		Principals:  []string{"alice"},
+		MaxLifetime: time.Hour,
+		CAKey:       caSigner.PublicKey(),
+	}
+	cert := newTestSSHCert(t, caSigner, now, time.Hour)
+	if findings := LintSSHCert(cert, profile, now); len(findings) > 0 {
+		t.Fatalf("unexpected findings: %s", findings)
+	}
+	line := ssh.MarshalAuthorizedKey(cert)
+	findings, err := LintSSHCertFile(line, profile, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) > 0 {
+		t.Fatalf("unexpected findings: %s", findings)
+	}
+	mislabeled := strings.Replace(string(line), cert.Type(),
+		"ssh-ed25519-cert-v01@openssh.com", 1)
+	findings, err = LintSSHCertFile([]byte(mislabeled), profile, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasLint(findings, "e_ssh_cert_file_type_mismatch") {
+		t.Fatalf("type mismatch not found: %s", findings)
+	}
+
+	otherProfile := profile
+	otherProfile.Principals = []string{"bob"}
+	otherProfile.MaxLifetime = time.Minute
+	otherCAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCA, err := ssh.NewPublicKey(&otherCAKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherProfile.CAKey = otherCA
+	findings = LintSSHCert(cert, otherProfile, now)
+	for _, lint := range []string{"e_ssh_principals_mismatch",
+		"e_ssh_lifetime_exceeds_profile", "e_ssh_signature_key_mismatch"} {
+		if !hasLint(findings, lint) {
+			t.Errorf("%s not found in %s", lint, findings)
+		}
+	}
+	if !findings.HasErrors() {
+		t.Fatal("expected errors")
+	}
+
+	cert.KeyId = "tampered"
+	cert.CriticalOptions = map[string]string{"bogus": ""}
+	cert.Extensions["permit-bogus"] = ""
+	findings = LintSSHCert(cert, profile, now)
+	for _, lint := range []string{"e_ssh_signature_invalid",
+		"e_ssh_unknown_critical_option", "w_ssh_unknown_extension"} {
+		if !hasLint(findings, lint) {
+			t.Errorf("%s not found in %s", lint, findings)
+		}
+	}
+}
+
+func newTestX509Cert(t *testing.T, now time.Time,
+	subjectKeyId []byte) (*x509.Certificate, *x509.Certificate) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, &caTemplate,
+		&caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "host.example.com"},
+		NotBefore:             now,
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:              []string{"host.example.com"},
+		IPAddresses:           []net.IP{net.ParseIP("10.0.0.1")},
+		BasicConstraintsValid: true,
+		SubjectKeyId:          subjectKeyId,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, caCert,
+		&hostKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return caCert, cert
+}
+
+func TestLintX509Cert(t *testing.T) {
+	now := time.Now()
+	caCert, cert := newTestX509Cert(t, now, []byte{1, 2, 3, 4})
+	linter, err := NewX509Linter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := X509Profile{
+		CA:          caCert,
+		MaxLifetime: time.Hour,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		CommonName:  "host.example.com",
+		DNSNames:    []string{"host.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}
+	if findings := linter.LintX509Cert(cert, profile, now); len(findings) > 0 {
+		t.Fatalf("unexpected findings: %s", findings)
+	}
+	badProfile := profile
+	badProfile.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	badProfile.DNSNames = []string{"other.example.com"}
+	badProfile.MaxLifetime = time.Minute
+	badProfile.CA = cert
+	findings := linter.LintX509Cert(cert, badProfile, now)
+	for _, lint := range []string{"e_ext_key_usage_mismatch",
+		"e_san_dns_names_mismatch", "e_lifetime_exceeds_profile",
+		"e_issuer_mismatch", "e_signature_invalid"} {
+		if !hasLint(findings, lint) {
+			t.Errorf("%s not found in %s", lint, findings)
+		}
+	}
+	findings = linter.LintX509Cert(cert, profile, now.Add(2*time.Hour))
+	if !hasLint(findings, "e_expired") {
+		t.Errorf("e_expired not found in %s", findings)
+	}
+}
+
+func TestZlintSources(t *testing.T) {
+	now := time.Now()
+	caCert, cert := newTestX509Cert(t, now, nil)
+	profile := X509Profile{
+		CA:          caCert,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	const lint = "w_ext_subject_key_identifier_missing_sub_cert"
+	var linter *X509Linter
+	findings := linter.LintX509Cert(cert, profile, now)
+	if !hasLint(findings, lint) {
+		t.Fatalf("%s not found in %s", lint, findings)
+	}
+	if findings.HasErrors() {
+		t.Fatalf("unexpected errors: %s", findings)
+	}
+	linter, err := NewX509Linter([]string{"CABF_EV"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findings := linter.LintX509Cert(cert, profile, now); hasLint(
+		findings, lint) {
+		t.Fatalf("RFC 5280 lint run for other source: %s", findings)
+	}
+	if _, err := NewX509Linter([]string{"bogus"}); err == nil {
+		t.Fatal("unknown zlint source accepted")
+	}
+}
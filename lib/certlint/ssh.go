@@ -0,0 +1,188 @@
+package certlint
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultSSHCriticalOptions are the critical options understood by OpenSSH.
+var DefaultSSHCriticalOptions = []string{"force-command", "source-address",
+	"verify-required"}
+
+// DefaultSSHExtensions are the extensions understood by OpenSSH.
+var DefaultSSHExtensions = []string{"no-touch-required",
+	"permit-X11-forwarding", "permit-agent-forwarding",
+	"permit-port-forwarding", "permit-pty", "permit-user-rc"}
+
+// SSHProfile describes the certificate that should have been issued.
+type SSHProfile struct {
+	CertType    uint32 // ssh.UserCert or ssh.HostCert
+	Principals  []string
+	MaxLifetime time.Duration // Zero means no limit.
+	CAKey       ssh.PublicKey
+	// CriticalOptions and Extensions are the names allowed in the
+	// certificate. If nil the defaults above are used.
+	CriticalOptions []string
+	Extensions      []string
+}
+
+// LintSSHCert checks cert against profile at time now.
+func LintSSHCert(cert *ssh.Certificate, profile SSHProfile,
+	now time.Time) Findings {
+	var findings Findings
+	if cert.CertType != profile.CertType {
+		findings.add("e_ssh_cert_type_mismatch", "type is %d, expected %d",
+			cert.CertType, profile.CertType)
+	}
+	if len(cert.ValidPrincipals) < 1 {
+		findings.add("e_ssh_no_principals",
+			"certificate is valid for any principal")
+	} else if !sameStrings(cert.ValidPrincipals, profile.Principals) {
+		findings.add("e_ssh_principals_mismatch", "principals are %v, expected %v",
+			cert.ValidPrincipals, profile.Principals)
+	}
+	if cert.KeyId == "" {
+		findings.add("e_ssh_key_id_empty", "no key ID for audit logs")
+	}
+	if cert.Serial == 0 {
+		findings.add("w_ssh_serial_zero", "serial cannot be revoked individually")
+	}
+	lintSSHValidity(&findings, cert, profile, now)
+	lintSSHPermissions(&findings, cert, profile)
+	lintSSHKeys(&findings, cert, profile)
+	return findings
+}
+
+// LintSSHCertFile parses a certificate in authorized_keys format (as written
+// to id_*-cert.pub files), checks that the key type at the start of the line
+// matches the certificate and then lints it with LintSSHCert.
+func LintSSHCertFile(data []byte, profile SSHProfile,
+	now time.Time) (Findings, error) {
+	// Not ssh.ParseAuthorizedKey, which rejects a mismatched type outright.
+	fields := bytes.Fields(data)
+	if len(fields) < 2 {
+		return nil, errors.New("certlint: malformed certificate line")
+	}
+	der, err := base64.StdEncoding.DecodeString(string(fields[1]))
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := ssh.ParsePublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := publicKey.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("certlint: not an SSH certificate")
+	}
+	findings := LintSSHCert(cert, profile, now)
+	if string(fields[0]) != cert.Type() {
+		findings.add("e_ssh_cert_file_type_mismatch",
+			"file says %s, certificate is %s", fields[0], cert.Type())
+	}
+	return findings, nil
+}
+
+func lintSSHValidity(findings *Findings, cert *ssh.Certificate,
+	profile SSHProfile, now time.Time) {
+	if cert.ValidBefore == ssh.CertTimeInfinity {
+		findings.add("e_ssh_validity_unbounded", "certificate never expires")
+		return
+	}
+	if cert.ValidBefore <= cert.ValidAfter {
+		findings.add("e_ssh_validity_negative",
+			"valid_before %d is not after valid_after %d", cert.ValidBefore,
+			cert.ValidAfter)
+		return
+	}
+	validAfter := time.Unix(int64(cert.ValidAfter), 0)
+	validBefore := time.Unix(int64(cert.ValidBefore), 0)
+	if validAfter.After(now.Add(clockSkew)) {
+		findings.add("e_ssh_not_yet_valid", "valid from %s", validAfter)
+	}
+	if !validBefore.After(now) {
+		findings.add("e_ssh_expired", "expired at %s", validBefore)
+	}
+	lifetime := validBefore.Sub(validAfter)
+	if profile.MaxLifetime > 0 && lifetime > profile.MaxLifetime+lifetimeSlack {
+		findings.add("e_ssh_lifetime_exceeds_profile",
+			"lifetime %s exceeds %s", lifetime, profile.MaxLifetime)
+	}
+}
+
+func lintSSHPermissions(findings *Findings, cert *ssh.Certificate,
+	profile SSHProfile) {
+	criticalOptions := profile.CriticalOptions
+	if criticalOptions == nil {
+		criticalOptions = DefaultSSHCriticalOptions
+	}
+	for name := range cert.CriticalOptions {
+		if !stringInSlice(name, criticalOptions) {
+			findings.add("e_ssh_unknown_critical_option",
+				"%s would make servers reject the certificate", name)
+		}
+	}
+	extensions := profile.Extensions
+	if extensions == nil {
+		extensions = DefaultSSHExtensions
+	}
+	for name := range cert.Extensions {
+		if !stringInSlice(name, extensions) {
+			findings.add("w_ssh_unknown_extension", "%s is not expected", name)
+		}
+	}
+}
+
+func lintSSHKeys(findings *Findings, cert *ssh.Certificate,
+	profile SSHProfile) {
+	switch cert.Key.Type() {
+	case ssh.KeyAlgoDSA:
+		findings.add("e_ssh_dsa_key", "DSA keys are not accepted by OpenSSH")
+	case ssh.KeyAlgoRSA:
+		if cryptoKey, ok := cert.Key.(ssh.CryptoPublicKey); ok {
+			if rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey); ok &&
+				rsaKey.N.BitLen() < 2048 {
+				findings.add("e_ssh_rsa_key_too_small", "RSA key has %d bits",
+					rsaKey.N.BitLen())
+			}
+		}
+	}
+	if cert.SignatureKey == nil || cert.Signature == nil {
+		findings.add("e_ssh_signature_missing", "certificate is not signed")
+		return
+	}
+	if profile.CAKey != nil &&
+		!bytes.Equal(cert.SignatureKey.Marshal(), profile.CAKey.Marshal()) {
+		findings.add("e_ssh_signature_key_mismatch",
+			"signed by %s, expected %s", ssh.FingerprintSHA256(cert.SignatureKey),
+			ssh.FingerprintSHA256(profile.CAKey))
+	}
+	if err := cert.SignatureKey.Verify(sshBytesForSigning(cert),
+		cert.Signature); err != nil {
+		findings.add("e_ssh_signature_invalid", "%s", err)
+	}
+}
+
+// sshBytesForSigning returns the data covered by the certificate signature:
+// the marshaled certificate without the trailing signature.
+func sshBytesForSigning(cert *ssh.Certificate) []byte {
+	unsigned := *cert
+	unsigned.Signature = nil
+	out := unsigned.Marshal()
+	// Drop the length prefix of the empty signature.
+	return out[:len(out)-4]
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, entry := range list {
+		if entry == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package certlint
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	zx509 "github.com/zmap/zcrypto/x509"
+	"github.com/zmap/zlint/v3"
+	"github.com/zmap/zlint/v3/lint"
+)
+
+// DefaultZlintSources are the zlint lint sources run if none are configured.
+// The CA/Browser Forum and root store requirements only apply to the public
+// web PKI.
+var DefaultZlintSources = []string{string(lint.RFC5280)}
+
+var (
+	defaultX509LinterOnce sync.Once
+	defaultX509Linter     *X509Linter
+	defaultX509LinterErr  error
+)
+
+// X509Profile describes the leaf certificate that should have been issued.
+type X509Profile struct {
+	CA          *x509.Certificate
+	MaxLifetime time.Duration // Zero means no limit.
+	// ExtKeyUsage must match the extended key usages in any order.
+	ExtKeyUsage []x509.ExtKeyUsage
+	// CommonName is checked if not empty.
+	CommonName string
+	// DNSNames and IPAddresses are checked if either is not empty.
+	DNSNames    []string
+	IPAddresses []net.IP
+}
+
+// X509Linter runs the zlint lints of a set of sources and then checks the
+// certificate against its profile.
+type X509Linter struct {
+	registry lint.Registry
+}
+
+// NewX509Linter returns a linter for the zlint lint sources (like "RFC5280"
+// or "CABF_BR"), or for DefaultZlintSources if sources is empty.
+func NewX509Linter(sources []string) (*X509Linter, error) {
+	if len(sources) < 1 {
+		sources = DefaultZlintSources
+	}
+	var sourceList lint.SourceList
+	for _, name := range sources {
+		var source lint.LintSource
+		source.FromString(name)
+		if source == lint.UnknownLintSource {
+			return nil, fmt.Errorf("unknown zlint source: %q", name)
+		}
+		sourceList = append(sourceList, source)
+	}
+	registry, err := lint.GlobalRegistry().Filter(lint.FilterOptions{
+		IncludeSources: sourceList,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &X509Linter{registry: registry}, nil
+}
+
+// LintX509Cert checks cert with zlint and against profile at time now. A nil
+// linter runs the lints of DefaultZlintSources.
+func (l *X509Linter) LintX509Cert(cert *x509.Certificate, profile X509Profile,
+	now time.Time) Findings {
+	if l == nil {
+		defaultX509LinterOnce.Do(func() {
+			defaultX509Linter, defaultX509LinterErr = NewX509Linter(nil)
+		})
+		if defaultX509LinterErr != nil {
+			panic(defaultX509LinterErr)
+		}
+		l = defaultX509Linter
+	}
+	var findings Findings
+	l.lintZlint(&findings, cert)
+	lintX509Validity(&findings, cert, profile, now)
+	lintX509Usage(&findings, cert, profile)
+	lintX509Names(&findings, cert, profile)
+	lintX509Keys(&findings, cert, profile)
+	return findings
+}
+
+func (l *X509Linter) lintZlint(findings *Findings, cert *x509.Certificate) {
+	zcert, err := zx509.ParseCertificate(cert.Raw)
+	if err != nil {
+		findings.add("e_zlint_parse_failed", "%s", err)
+		return
+	}
+	results := zlint.LintCertificateEx(zcert, l.registry)
+	for _, name := range l.registry.Names() {
+		result, ok := results.Results[name]
+		if !ok {
+			continue
+		}
+		switch result.Status {
+		case lint.Warn:
+			findings.addSeverity(name, Warning, result.Details)
+		case lint.Error, lint.Fatal:
+			findings.addSeverity(name, Error, result.Details)
+		}
+	}
+}
+
+func lintX509Validity(findings *Findings, cert *x509.Certificate,
+	profile X509Profile, now time.Time) {
+	if !cert.NotAfter.After(cert.NotBefore) {
+		findings.add("e_validity_negative", "not after %s is not after %s",
+			cert.NotAfter, cert.NotBefore)
+		return
+	}
+	if cert.NotBefore.After(now.Add(clockSkew)) {
+		findings.add("e_not_yet_valid", "valid from %s", cert.NotBefore)
+	}
+	if !cert.NotAfter.After(now) {
+		findings.add("e_expired", "expired at %s", cert.NotAfter)
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	if profile.MaxLifetime > 0 && lifetime > profile.MaxLifetime+lifetimeSlack {
+		findings.add("e_lifetime_exceeds_profile", "lifetime %s exceeds %s",
+			lifetime, profile.MaxLifetime)
+	}
+	if profile.CA != nil && cert.NotAfter.After(profile.CA.NotAfter) {
+		findings.add("w_validity_exceeds_ca", "CA expires at %s",
+			profile.CA.NotAfter)
+	}
+}
+
+func lintX509Usage(findings *Findings, cert *x509.Certificate,
+	profile X509Profile) {
+	if cert.IsCA {
+		findings.add("e_leaf_is_ca", "leaf certificate has CA:TRUE")
+	}
+	if !sameExtKeyUsage(cert.ExtKeyUsage, profile.ExtKeyUsage) {
+		findings.add("e_ext_key_usage_mismatch",
+			"extended key usage is %v, expected %v", cert.ExtKeyUsage,
+			profile.ExtKeyUsage)
+	}
+}
+
+func lintX509Names(findings *Findings, cert *x509.Certificate,
+	profile X509Profile) {
+	if profile.CommonName != "" && cert.Subject.CommonName != profile.CommonName {
+		findings.add("e_subject_common_name_mismatch", "common name is %q, expected %q",
+			cert.Subject.CommonName, profile.CommonName)
+	}
+	if len(profile.DNSNames) < 1 && len(profile.IPAddresses) < 1 {
+		return
+	}
+	if !sameStrings(cert.DNSNames, profile.DNSNames) {
+		findings.add("e_san_dns_names_mismatch", "DNS names are %v, expected %v",
+			cert.DNSNames, profile.DNSNames)
+	}
+	var certIPs, profileIPs []string
+	for _, ip := range cert.IPAddresses {
+		certIPs = append(certIPs, ip.String())
+	}
+	for _, ip := range profile.IPAddresses {
+		profileIPs = append(profileIPs, ip.String())
+	}
+	if !sameStrings(certIPs, profileIPs) {
+		findings.add("e_san_ip_addresses_mismatch",
+			"IP addresses are %v, expected %v", certIPs, profileIPs)
+	}
+	commonName := cert.Subject.CommonName
+	if commonName != "" && !stringInSlice(commonName, cert.DNSNames) &&
+		!stringInSlice(commonName, certIPs) {
+		findings.add("w_common_name_not_in_san", "%q is not a SAN", commonName)
+	}
+}
+
+func lintX509Keys(findings *Findings, cert *x509.Certificate,
+	profile X509Profile) {
+	if profile.CA == nil {
+		return
+	}
+	if !bytes.Equal(cert.RawIssuer, profile.CA.RawSubject) {
+		findings.add("e_issuer_mismatch", "issuer is %q, expected %q",
+			cert.Issuer.String(), profile.CA.Subject.String())
+	}
+	if err := cert.CheckSignatureFrom(profile.CA); err != nil {
+		findings.add("e_signature_invalid", "%s", err)
+	}
+	if len(profile.CA.SubjectKeyId) > 0 &&
+		!bytes.Equal(cert.AuthorityKeyId, profile.CA.SubjectKeyId) {
+		findings.add("w_authority_key_id_mismatch",
+			"authority key ID does not match the CA subject key ID")
+	}
+}
+
+func sameExtKeyUsage(left, right []x509.ExtKeyUsage) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	for _, usage := range left {
+		found := false
+		for _, other := range right {
+			if usage == other {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -11,6 +11,14 @@ type BaseConfig struct {
 	Username      string `yaml:"username"`
 	FilePrefix    string `yaml:"file_prefix"`
 	AddGroups     bool   `yaml:"add_groups"`
+	KeyType       string `yaml:"key_type"`
+	SSHAgentOnly  bool   `yaml:"ssh_agent_only"`
+	// If set, the CA keys must be published in the TXT records of this name,
+	// e.g. _keymaster-ca.example.com.
+	CAFingerprintDNSName string `yaml:"ca_fingerprint_dns_name"`
+	// If set, the daemon does not show desktop notifications when renewing
+	// needs a second factor or a certificate is about to expire.
+	DisableDesktopNotifications bool `yaml:"disable_desktop_notifications"`
 }
 
 // AppConfigFile represents a keymaster client configuration file
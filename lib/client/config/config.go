@@ -31,6 +31,12 @@ func loadVerifyConfigFile(configFilename string) (AppConfigFile, error) {
 		err = errors.New("Invalid Config file... no place get the certs")
 		return config, err
 	}
+	switch config.Base.KeyType {
+	case "", "rsa", "ed25519":
+	default:
+		err = errors.New("Invalid Config file... key_type must be rsa or ed25519")
+		return config, err
+	}
 	// TODO: ensure all enpoints are https urls
 
 	return config, nil
@@ -19,6 +19,11 @@ const simpleValidConfigFile = `base:
 const invalidConfigFileNoGenUrls = `base:
 	    `
 
+const invalidConfigFileBadKeyType = `base:
+    gen_cert_urls: "https://localhost:33443/"
+    key_type: "dsa"
+`
+
 func createTempFileWithStringContent(prefix string, content string) (f *os.File, err error) {
 	f, err = ioutil.TempFile("", prefix)
 	if err != nil {
@@ -83,6 +88,22 @@ func TestLoadVerifyConfigFileFailNoGenCertUrls(t *testing.T) {
 	}
 }
 
+func TestLoadVerifyConfigFileFailBadKeyType(t *testing.T) {
+	tmpfile, err := createTempFileWithStringContent("test_LoadVerifyConfigFail_", invalidConfigFileBadKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name()) // clean up
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = loadVerifyConfigFile(tmpfile.Name())
+	if err == nil {
+		t.Fatal("Should have failed with unsupported key_type")
+	}
+}
+
 func TestLoadVerifyConfigFileFailNoSuchFile(t *testing.T) {
 	_, err := loadVerifyConfigFile("NonExistentFile")
 	if err == nil {
@@ -0,0 +1,26 @@
+// Package desktopnotify shows desktop notifications with notify-send on
+// Linux and other Unix systems, terminal-notifier or osascript on macOS and
+// toast notifications through PowerShell on Windows.
+package desktopnotify
+
+import (
+	"errors"
+)
+
+// ErrUnsupported is returned by Notify when no notification tool is
+// available.
+var ErrUnsupported = errors.New("desktop notifications are not supported")
+
+// Notification is a notification to show.
+type Notification struct {
+	Title   string
+	Message string
+	// Link is opened when the notification is clicked where supported and
+	// shown in the message elsewhere. It may be empty.
+	Link string
+}
+
+// Notify shows notification.
+func Notify(notification Notification) error {
+	return notify(notification)
+}
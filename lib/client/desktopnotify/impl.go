@@ -0,0 +1,59 @@
+package desktopnotify
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const appName = "keymaster"
+
+// messageWithLink appends the link to the message for tools which cannot
+// open it.
+func messageWithLink(notification Notification) string {
+	if notification.Link == "" {
+		return notification.Message
+	}
+	return notification.Message + "\n" + notification.Link
+}
+
+// appleScriptString quotes s as an AppleScript string literal.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// powerShellString quotes s as a PowerShell verbatim string literal.
+func powerShellString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func xmlEscape(s string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(s))
+	return buffer.String()
+}
+
+// toastXML returns the toast notification XML for notification. Clicking
+// the toast opens the link through its protocol handler.
+func toastXML(notification Notification) string {
+	var launch string
+	if notification.Link != "" {
+		launch = fmt.Sprintf(` activationType="protocol" launch="%s"`,
+			xmlEscape(notification.Link))
+	}
+	return fmt.Sprintf(`<toast%s><visual><binding template="ToastGeneric">`+
+		`<text>%s</text><text>%s</text></binding></visual></toast>`,
+		launch, xmlEscape(notification.Title),
+		xmlEscape(notification.Message))
+}
+
+func run(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s: %s", name, err,
+			strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package desktopnotify
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestQuoting(t *testing.T) {
+	if s := appleScriptString(`say "hi" \o/`); s != `"say \"hi\" \\o/"` {
+		t.Errorf("AppleScript: %s", s)
+	}
+	if s := powerShellString("it's"); s != "'it''s'" {
+		t.Errorf("PowerShell: %s", s)
+	}
+	toast := toastXML(Notification{Title: "Expiring <soon>",
+		Message: "Sign in & renew",
+		Link:    "https://keymaster.example.com/?a=1&b=2"})
+	var parsed struct {
+		Launch string   `xml:"launch,attr"`
+		Texts  []string `xml:"visual>binding>text"`
+	}
+	if err := xml.Unmarshal([]byte(toast), &parsed); err != nil {
+		t.Fatalf("%s: %s", toast, err)
+	}
+	if parsed.Launch != "https://keymaster.example.com/?a=1&b=2" ||
+		len(parsed.Texts) != 2 || parsed.Texts[0] != "Expiring <soon>" ||
+		parsed.Texts[1] != "Sign in & renew" {
+		t.Errorf("unexpected toast: %+v", parsed)
+	}
+	if s := messageWithLink(Notification{Message: "m"}); s != "m" {
+		t.Errorf("message without link: %q", s)
+	}
+}
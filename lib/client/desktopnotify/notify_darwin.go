@@ -0,0 +1,24 @@
+package desktopnotify
+
+import (
+	"os/exec"
+)
+
+func notify(notification Notification) error {
+	// Only terminal-notifier can open a link when clicked.
+	if path, err := exec.LookPath("terminal-notifier"); err == nil {
+		args := []string{"-title", notification.Title,
+			"-message", notification.Message, "-group", appName}
+		if notification.Link != "" {
+			args = append(args, "-open", notification.Link)
+		}
+		return run(path, args...)
+	}
+	path, err := exec.LookPath("osascript")
+	if err != nil {
+		return ErrUnsupported
+	}
+	return run(path, "-e", "display notification "+
+		appleScriptString(messageWithLink(notification))+" with title "+
+		appleScriptString(notification.Title))
+}
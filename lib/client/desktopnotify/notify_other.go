@@ -0,0 +1,17 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package desktopnotify
+
+import (
+	"os/exec"
+)
+
+func notify(notification Notification) error {
+	path, err := exec.LookPath("notify-send")
+	if err != nil {
+		return ErrUnsupported
+	}
+	return run(path, "--app-name="+appName, "--urgency=critical",
+		notification.Title, messageWithLink(notification))
+}
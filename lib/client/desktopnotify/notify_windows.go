@@ -0,0 +1,24 @@
+package desktopnotify
+
+import (
+	"os/exec"
+)
+
+// The AppUserModelID of PowerShell, which toasts are shown for.
+const powerShellAppID = `{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe`
+
+func notify(notification Notification) error {
+	path, err := exec.LookPath("powershell.exe")
+	if err != nil {
+		return ErrUnsupported
+	}
+	script := `$ErrorActionPreference = 'Stop'
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+[Windows.Data.Xml.Dom.XmlDocument, Windows.Data.Xml.Dom.XmlDocument, ContentType = WindowsRuntime] > $null
+$xml = New-Object Windows.Data.Xml.Dom.XmlDocument
+$xml.LoadXml(` + powerShellString(toastXML(notification)) + `)
+$toast = New-Object Windows.UI.Notifications.ToastNotification $xml
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier(` +
+		powerShellString(powerShellAppID) + `).Show($toast)`
+	return run(path, "-NoProfile", "-NonInteractive", "-Command", script)
+}
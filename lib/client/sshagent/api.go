@@ -0,0 +1,29 @@
+// Package sshagent adds keymaster issued keys and certificates to a running
+// ssh-agent so that they do not need to be written to disk.
+package sshagent
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoAgent is returned by Connect when no running ssh-agent can be found.
+var ErrNoAgent = errors.New("no ssh-agent found")
+
+// Connect connects to the running ssh-agent. It uses SSH_AUTH_SOCK if set. On
+// Windows it falls back to the OpenSSH agent pipe and then to Pageant.
+// The caller must close the returned io.Closer when done with the agent.
+func Connect() (agent.Agent, io.Closer, error) {
+	return connect()
+}
+
+// UpsertCertIntoAgent adds privateKey together with the SSH certificate
+// certText (in authorized_keys format) to sshAgent, replacing any keys
+// previously added with the same comment. The agent forgets the key when the
+// certificate expires.
+func UpsertCertIntoAgent(sshAgent agent.Agent, certText []byte,
+	privateKey interface{}, comment string) error {
+	return upsertCertIntoAgent(sshAgent, certText, privateKey, comment)
+}
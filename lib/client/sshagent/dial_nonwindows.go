@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package sshagent
+
+import (
+	"io"
+	"net"
+	"os"
+)
+
+func dialAgent() (io.ReadWriteCloser, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, ErrNoAgent
+	}
+	return net.Dial("unix", socket)
+}
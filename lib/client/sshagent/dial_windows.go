@@ -0,0 +1,19 @@
+package sshagent
+
+import (
+	"io"
+	"net"
+	"os"
+)
+
+const openSSHAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+func dialAgent() (io.ReadWriteCloser, error) {
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		return net.Dial("unix", socket)
+	}
+	if pipe, err := os.OpenFile(openSSHAgentPipe, os.O_RDWR, 0); err == nil {
+		return pipe, nil
+	}
+	return dialPageant()
+}
@@ -0,0 +1,56 @@
+package sshagent
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func connect() (agent.Agent, io.Closer, error) {
+	conn, err := dialAgent()
+	if err != nil {
+		return nil, nil, err
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+func upsertCertIntoAgent(sshAgent agent.Agent, certText []byte,
+	privateKey interface{}, comment string) error {
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(certText)
+	if err != nil {
+		return err
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		return errors.New("not an SSH certificate")
+	}
+	var lifetimeSecs uint32
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		now := uint64(time.Now().Unix())
+		if cert.ValidBefore <= now {
+			return errors.New("certificate has expired")
+		}
+		lifetimeSecs = uint32(cert.ValidBefore - now)
+	}
+	keys, err := sshAgent.List()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if key.Comment != comment {
+			continue
+		}
+		if err := sshAgent.Remove(key); err != nil {
+			return err
+		}
+	}
+	return sshAgent.Add(agent.AddedKey{
+		PrivateKey:   privateKey,
+		Certificate:  cert,
+		Comment:      comment,
+		LifetimeSecs: lifetimeSecs,
+	})
+}
@@ -0,0 +1,70 @@
+package sshagent
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func makeTestCert(t *testing.T, validFor time.Duration) (
+	[]byte, ed25519.PrivateKey) {
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, userKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(userPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             sshPub,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"username"},
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(validFor).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	return ssh.MarshalAuthorizedKey(cert), userKey
+}
+
+func TestUpsertCertIntoAgent(t *testing.T) {
+	keyring := agent.NewKeyring()
+	for i := 0; i < 2; i++ {
+		certText, key := makeTestCert(t, time.Hour)
+		err := UpsertCertIntoAgent(keyring, certText, key, "username@keymaster")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, err := keyring.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected the previous key to be replaced, have %d keys",
+			len(keys))
+	}
+}
+
+func TestUpsertCertIntoAgentFailExpired(t *testing.T) {
+	certText, key := makeTestCert(t, -time.Second)
+	err := UpsertCertIntoAgent(agent.NewKeyring(), certText, key, "username")
+	if err == nil {
+		t.Fatal("expired certificate should have been rejected")
+	}
+}
@@ -0,0 +1,133 @@
+package sshagent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"unsafe"
+)
+
+// Pageant does not listen on a socket; requests are passed to its window in
+// a shared memory mapping announced with a WM_COPYDATA message.
+const (
+	pageantMaxMessageLen = 8192
+	pageantCopyDataID    = 0x804e50ba
+	wmCopyData           = 0x004a
+)
+
+var (
+	user32                 = syscall.NewLazyDLL("user32.dll")
+	procFindWindowW        = user32.NewProc("FindWindowW")
+	procSendMessageW       = user32.NewProc("SendMessageW")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procGetCurrentThreadId = kernel32.NewProc("GetCurrentThreadId")
+	procRtlMoveMemory      = kernel32.NewProc("RtlMoveMemory")
+)
+
+type copyData struct {
+	dwData uintptr
+	cbData uint32
+	lpData uintptr
+}
+
+// pageantConn buffers a complete agent request, sends it to Pageant and
+// makes the response available for reading.
+type pageantConn struct {
+	request  bytes.Buffer
+	response bytes.Reader
+}
+
+func findPageantWindow() uintptr {
+	name, err := syscall.UTF16PtrFromString("Pageant")
+	if err != nil {
+		return 0
+	}
+	hwnd, _, _ := procFindWindowW.Call(uintptr(unsafe.Pointer(name)),
+		uintptr(unsafe.Pointer(name)))
+	return hwnd
+}
+
+func dialPageant() (io.ReadWriteCloser, error) {
+	if findPageantWindow() == 0 {
+		return nil, ErrNoAgent
+	}
+	return &pageantConn{}, nil
+}
+
+func (conn *pageantConn) Write(p []byte) (int, error) {
+	conn.request.Write(p)
+	data := conn.request.Bytes()
+	if len(data) < 4 || len(data) < 4+int(binary.BigEndian.Uint32(data)) {
+		return len(p), nil
+	}
+	response, err := pageantQuery(data)
+	conn.request.Reset()
+	if err != nil {
+		return 0, err
+	}
+	conn.response.Reset(response)
+	return len(p), nil
+}
+
+func (conn *pageantConn) Read(p []byte) (int, error) {
+	return conn.response.Read(p)
+}
+
+func (conn *pageantConn) Close() error {
+	return nil
+}
+
+func pageantQuery(request []byte) ([]byte, error) {
+	if len(request) > pageantMaxMessageLen {
+		return nil, errors.New("agent request too large for Pageant")
+	}
+	hwnd := findPageantWindow()
+	if hwnd == 0 {
+		return nil, ErrNoAgent
+	}
+	threadID, _, _ := procGetCurrentThreadId.Call()
+	mapName := fmt.Sprintf("PageantRequest%08x", uint32(threadID))
+	mapName16, err := syscall.UTF16PtrFromString(mapName)
+	if err != nil {
+		return nil, err
+	}
+	fileMap, err := syscall.CreateFileMapping(syscall.InvalidHandle, nil,
+		syscall.PAGE_READWRITE, 0, pageantMaxMessageLen, mapName16)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.CloseHandle(fileMap)
+	sharedMemory, err := syscall.MapViewOfFile(fileMap, syscall.FILE_MAP_WRITE,
+		0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.UnmapViewOfFile(sharedMemory)
+	procRtlMoveMemory.Call(sharedMemory, uintptr(unsafe.Pointer(&request[0])),
+		uintptr(len(request)))
+	mapNameBytes := append([]byte(mapName), 0)
+	cds := copyData{
+		dwData: pageantCopyDataID,
+		cbData: uint32(len(mapNameBytes)),
+		lpData: uintptr(unsafe.Pointer(&mapNameBytes[0])),
+	}
+	ret, _, _ := procSendMessageW.Call(hwnd, wmCopyData, 0,
+		uintptr(unsafe.Pointer(&cds)))
+	if ret == 0 {
+		return nil, errors.New("Pageant refused the request")
+	}
+	lengthBytes := make([]byte, 4)
+	procRtlMoveMemory.Call(uintptr(unsafe.Pointer(&lengthBytes[0])),
+		sharedMemory, 4)
+	length := 4 + int(binary.BigEndian.Uint32(lengthBytes))
+	if length > pageantMaxMessageLen {
+		return nil, errors.New("invalid Pageant response length")
+	}
+	response := make([]byte, length)
+	procRtlMoveMemory.Call(uintptr(unsafe.Pointer(&response[0])),
+		sharedMemory, uintptr(length))
+	return response, nil
+}
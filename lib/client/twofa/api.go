@@ -17,6 +17,8 @@ var (
 	noU2F = flag.Bool("noU2F", false, "Don't use U2F as second factor")
 	// If set, Do not use VIPAccess as second factor.
 	noVIPAccess = flag.Bool("noVIPAccess", false, "Don't use VIPAccess as second factor")
+	// If set, Do not use Duo push as second factor.
+	noDuo = flag.Bool("noDuo", false, "Don't use Duo push as second factor")
 )
 
 // GetCertFromTargetUrls gets a signed cert from the given target URLs.
@@ -34,3 +36,19 @@ func GetCertFromTargetUrls(
 		signer, userName, password, targetUrls, skipu2f, addGroups,
 		client, userAgentString, logger)
 }
+
+// GetCertsUsingSession gets a signed cert from the given target URLs without
+// logging in again, using the session cookies held by client from a previous
+// call to GetCertFromTargetUrls. It fails once the session has expired.
+func GetCertsUsingSession(
+	signer crypto.Signer,
+	userName string,
+	targetUrls []string,
+	addGroups bool,
+	client *http.Client,
+	userAgentString string,
+	logger log.DebugLogger) (sshCert []byte, x509Cert []byte, kubernetesCert []byte, err error) {
+	return getCertsUsingSession(
+		signer, userName, targetUrls, addGroups, client, userAgentString,
+		logger)
+}
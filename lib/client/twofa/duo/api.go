@@ -0,0 +1,18 @@
+// Package duo does two factor authentication with a Duo push
+package duo
+
+import (
+	"net/http"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+// DoDuoAuthenticate asks the server to send a Duo push and waits until it
+// has been approved.
+func DoDuoAuthenticate(
+	client *http.Client,
+	baseURL string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	return doDuoAuthenticate(client, baseURL, userAgentString, logger)
+}
@@ -0,0 +1,73 @@
+package duo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+const (
+	duoPushStartPath = "/api/v0/duoPushStart"
+	duoPollCheckPath = "/api/v0/duoPollCheck"
+	duoTimeout       = 120 * time.Second
+	duoPollInterval  = 3 * time.Second
+)
+
+// doDuoRequest returns the status code and the (short) body of the response.
+func doDuoRequest(client *http.Client, method string, url string,
+	userAgentString string) (int, string, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgentString)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(body)), nil
+}
+
+func doDuoAuthenticate(
+	client *http.Client,
+	baseURL string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	status, body, err := doDuoRequest(client, "POST",
+		baseURL+duoPushStartPath, userAgentString)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("cannot start Duo push: %d %s", status, body)
+	}
+	fmt.Println("Duo push sent, approve it to continue")
+	endTime := time.Now().Add(duoTimeout)
+	for time.Now().Before(endTime) {
+		status, body, err := doDuoRequest(client, "GET",
+			baseURL+duoPollCheckPath, userAgentString)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case http.StatusOK:
+			return nil
+		case http.StatusPreconditionFailed:
+			logger.Debugf(1, "Duo push not yet approved")
+		default:
+			return fmt.Errorf("Duo push failed: %d %s", status, body)
+		}
+		time.Sleep(duoPollInterval)
+	}
+	return errors.New("timed out waiting for Duo push approval")
+}
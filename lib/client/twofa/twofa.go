@@ -19,6 +19,7 @@ import (
 	"strings"
 
 	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/client/twofa/duo"
 	"github.com/Symantec/keymaster/lib/client/twofa/u2f"
 	"github.com/Symantec/keymaster/lib/client/twofa/vip"
 	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
@@ -84,6 +85,11 @@ func doCertRequest(client *http.Client, authCookies []*http.Cookie, url, filedat
 	}
 
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusAccepted {
+		// Queued for approval by a second person, the body says where.
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, errors.New(strings.TrimSpace(string(message)))
+	}
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("got error from call %s, url='%s'\n", resp.Status, url)
 	}
@@ -148,6 +154,7 @@ func getCertsFromServer(
 
 	allowVIP := false
 	allowU2F := false
+	allowDuo := false
 	for _, backend := range loginJSONResponse.CertAuthBackend {
 		if backend == proto.AuthTypePassword {
 			skip2fa = true
@@ -160,6 +167,9 @@ func getCertsFromServer(
 		if backend == proto.AuthTypeU2F {
 			allowU2F = true
 		}
+		if backend == proto.AuthTypeDuo {
+			allowDuo = true
+		}
 	}
 
 	// Dont try U2F if chosen by user
@@ -169,6 +179,9 @@ func getCertsFromServer(
 	if *noVIPAccess {
 		allowVIP = false
 	}
+	if *noDuo {
+		allowDuo = false
+	}
 
 	// on linux disable U2F is the /sys/class/hidraw is missing
 	if runtime.GOOS == "linux" && allowU2F {
@@ -209,6 +222,16 @@ func getCertsFromServer(
 			successful2fa = true
 		}
 
+		if allowDuo && !successful2fa {
+			err = duo.DoDuoAuthenticate(
+				client, baseUrl, userAgentString, logger)
+			if err != nil {
+
+				return nil, nil, nil, err
+			}
+			successful2fa = true
+		}
+
 		if !successful2fa {
 			err = errors.New("Failed to Pefrom 2FA (as requested from server)")
 			return nil, nil, nil, err
@@ -217,7 +240,22 @@ func getCertsFromServer(
 	}
 
 	logger.Debugf(1, "Authentication Phase complete")
+	return doCertRequests(signer, userName, baseUrl, loginResp.Cookies(),
+		addGroups, client, userAgentString, logger)
+}
 
+// doCertRequests requests the x509, kubernetes and ssh certificates for the
+// public key of signer. The session is taken from authCookies and from the
+// cookies held by client.
+func doCertRequests(
+	signer crypto.Signer,
+	userName string,
+	baseUrl string,
+	authCookies []*http.Cookie,
+	addGroups bool,
+	client *http.Client,
+	userAgentString string,
+	logger log.DebugLogger) (sshCert []byte, x509Cert []byte, kubernetesCert []byte, err error) {
 	//now get x509 cert
 	pubKey := signer.Public()
 	derKey, err := x509.MarshalPKIXPublicKey(pubKey)
@@ -234,7 +272,7 @@ func getCertsFromServer(
 	// TODO: urlencode the userName
 	x509Cert, err = doCertRequest(
 		client,
-		loginResp.Cookies(),
+		authCookies,
 		baseUrl+"/certgen/"+userName+"?type=x509"+urlPostfix,
 		pemKey,
 		userAgentString,
@@ -245,7 +283,7 @@ func getCertsFromServer(
 
 	kubernetesCert, err = doCertRequest(
 		client,
-		loginResp.Cookies(),
+		authCookies,
 		baseUrl+"/certgen/"+userName+"?type=x509-kubernetes",
 		pemKey,
 		userAgentString,
@@ -265,7 +303,7 @@ func getCertsFromServer(
 	sshAuthFile := string(ssh.MarshalAuthorizedKey(sshPub))
 	sshCert, err = doCertRequest(
 		client,
-		loginResp.Cookies(),
+		authCookies,
 		baseUrl+"/certgen/"+userName+"?type=ssh",
 		sshAuthFile,
 		userAgentString,
@@ -309,3 +347,26 @@ func getCertFromTargetUrls(
 
 	return sshCert, x509Cert, kubernetesCert, nil
 }
+
+func getCertsUsingSession(
+	signer crypto.Signer,
+	userName string,
+	targetUrls []string,
+	addGroups bool,
+	client *http.Client,
+	userAgentString string,
+	logger log.DebugLogger) (sshCert []byte, x509Cert []byte, kubernetesCert []byte, err error) {
+	for _, baseUrl := range targetUrls {
+		logger.Debugf(1, "renewing certs from '%s' for '%s'\n", baseUrl,
+			userName)
+		sshCert, x509Cert, kubernetesCert, err = doCertRequests(
+			signer, userName, baseUrl, nil, addGroups, client,
+			userAgentString, logger)
+		if err != nil {
+			logger.Debugln(1, err)
+			continue
+		}
+		return sshCert, x509Cert, kubernetesCert, nil
+	}
+	return nil, nil, nil, errors.New("Failed to renew certs using session")
+}
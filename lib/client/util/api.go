@@ -31,6 +31,14 @@ func GenKeyPair(
 	return genKeyPair(privateKeyPath, identity, logger)
 }
 
+// GenEd25519KeyPair is like GenKeyPair but generates an Ed25519 key, which
+// is written in the OpenSSH private key format.
+func GenEd25519KeyPair(
+	privateKeyPath string, identity string, logger log.Logger) (
+	privateKey crypto.Signer, publicKeyPath string, err error) {
+	return genEd25519KeyPair(privateKeyPath, identity, logger)
+}
+
 // GetHttpClient returns an http client instance to use given a
 // particular TLS configuration.
 func GetHttpClient(tlsConfig *tls.Config,
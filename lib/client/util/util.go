@@ -3,6 +3,7 @@ package util
 import (
 	"bytes"
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -54,24 +55,51 @@ func genKeyPair(
 		logger.Printf("Failed to save privkey")
 		return nil, "", err
 	}
+	return privateKey, pubKeyPath, writePublicKeyFile(pubKeyPath,
+		&privateKey.PublicKey, identity)
+}
 
-	// generate and write public key
-	pub, err := ssh.NewPublicKey(&privateKey.PublicKey)
+func genEd25519KeyPair(
+	privateKeyPath string, identity string, logger log.Logger) (
+	crypto.Signer, string, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		return nil, "", err
 	}
+	pubKeyPath := privateKeyPath + ".pub"
+	pemBlock, err := ssh.MarshalPrivateKey(privateKey, identity)
+	if err != nil {
+		return nil, "", err
+	}
+	err = ioutil.WriteFile(privateKeyPath, pem.EncodeToMemory(pemBlock), 0600)
+	if err != nil {
+		logger.Printf("Failed to save privkey")
+		return nil, "", err
+	}
+	return privateKey, pubKeyPath, writePublicKeyFile(pubKeyPath,
+		privateKey.Public(), identity)
+}
+
+// writePublicKeyFile writes publicKey in authorized_keys format with identity
+// as the comment.
+func writePublicKeyFile(pubKeyPath string, publicKey crypto.PublicKey,
+	identity string) error {
+	pub, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
 	marshaledPubKeyBytes := ssh.MarshalAuthorizedKey(pub)
 	marshaledPubKeyBytes = bytes.TrimRight(marshaledPubKeyBytes, "\r\n")
 	var pubKeyBuffer bytes.Buffer
 	_, err = pubKeyBuffer.Write(marshaledPubKeyBytes)
 	if err != nil {
-		return nil, "", err
+		return err
 	}
 	_, err = pubKeyBuffer.Write([]byte(" " + identity + "\n"))
 	if err != nil {
-		return nil, "", err
+		return err
 	}
-	return privateKey, pubKeyPath, ioutil.WriteFile(pubKeyPath, pubKeyBuffer.Bytes(), 0644)
+	return ioutil.WriteFile(pubKeyPath, pubKeyBuffer.Bytes(), 0644)
 }
 
 func getHttpClient(tlsConfig *tls.Config,
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"crypto/ed25519"
 	"crypto/tls"
 	"fmt"
 	"io/ioutil"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/Symantec/Dominator/lib/log/testlogger"
 	"github.com/Symantec/keymaster/lib/certgen"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestGenKeyPairSuccess(t *testing.T) {
@@ -36,6 +38,46 @@ func TestGenKeyPairSuccess(t *testing.T) {
 	//TODO: verify written signer matches our signer.
 }
 
+func TestGenEd25519KeyPairSuccess(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "test_genEd25519KeyPair_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name()) // clean up
+
+	signer, pubKeyPath, err := GenEd25519KeyPair(tmpfile.Name(), "test", testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(pubKeyPath)
+	fileBytes, err := ioutil.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedKey, err := ssh.ParseRawPrivateKey(fileBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := parsedKey.(*ed25519.PrivateKey); !ok {
+		t.Fatalf("unexpected key type %T", parsedKey)
+	}
+	pubKeyBytes, err := ioutil.ReadFile(pubKeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(pubKeyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshSigner, err := ssh.NewSignerFromSigner(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pubKey.Marshal()) != string(sshSigner.PublicKey().Marshal()) {
+		t.Fatal("written public key does not match signer")
+	}
+}
+
 func TestGenKeyPairFailNoPerms(t *testing.T) {
 	_, _, err := GenKeyPair("/proc/something", "test", testlogger.New(t))
 	if err == nil {
@@ -0,0 +1,203 @@
+// Package duo is a minimal client for the Duo Auth API v2.
+package duo
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Results of Preauth.
+const (
+	PreauthAuth   = "auth"   // The user must complete a second factor.
+	PreauthAllow  = "allow"  // The user is allowed without a second factor.
+	PreauthDeny   = "deny"   // The user is not allowed to authenticate.
+	PreauthEnroll = "enroll" // The user must enroll first.
+)
+
+// Results of AuthStatus.
+const (
+	AuthAllow   = "allow"
+	AuthDeny    = "deny"
+	AuthWaiting = "waiting"
+)
+
+const requestTimeout = 30 * time.Second
+
+type Client struct {
+	APIHost        string
+	IntegrationKey string
+	SecretKey      string
+	// PushType is shown in the push notification, e.g. "Keymaster login".
+	PushType   string
+	baseURL    string
+	httpClient *http.Client
+}
+
+type apiResponse struct {
+	Stat     string          `json:"stat"`
+	Code     int             `json:"code"`
+	Message  string          `json:"message"`
+	Detail   string          `json:"message_detail"`
+	Response json.RawMessage `json:"response"`
+}
+
+type resultResponse struct {
+	Result    string `json:"result"`
+	StatusMsg string `json:"status_msg"`
+	TxID      string `json:"txid"`
+}
+
+// New returns a Client for the Duo Auth API at apiHost (e.g.
+// api-XXXXXXXX.duosecurity.com) using the integration and secret keys of an
+// Auth API application.
+func New(apiHost, integrationKey, secretKey string) (*Client, error) {
+	if apiHost == "" || integrationKey == "" || secretKey == "" {
+		return nil, errors.New("duo: api host, integration key and secret key are required")
+	}
+	return &Client{
+		APIHost:        apiHost,
+		IntegrationKey: integrationKey,
+		SecretKey:      secretKey,
+		baseURL:        "https://" + apiHost,
+		httpClient:     &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+// Check verifies that the API host is reachable and the keys are valid.
+func (c *Client) Check() error {
+	return c.call("GET", "/auth/v2/check", nil, nil)
+}
+
+// Preauth returns whether username must (PreauthAuth), need not
+// (PreauthAllow) or cannot (PreauthDeny, PreauthEnroll) authenticate.
+func (c *Client) Preauth(username string) (string, error) {
+	var result resultResponse
+	params := url.Values{"username": {username}}
+	if err := c.call("POST", "/auth/v2/preauth", params, &result); err != nil {
+		return "", err
+	}
+	return result.Result, nil
+}
+
+// StartPush sends a push to the default device of username and returns the
+// transaction ID to pass to AuthStatus.
+func (c *Client) StartPush(username string) (string, error) {
+	params := url.Values{
+		"username": {username},
+		"factor":   {"push"},
+		"device":   {"auto"},
+		"async":    {"1"},
+	}
+	if c.PushType != "" {
+		params.Set("type", c.PushType)
+	}
+	var result resultResponse
+	if err := c.call("POST", "/auth/v2/auth", params, &result); err != nil {
+		return "", err
+	}
+	if result.TxID == "" {
+		return "", errors.New("duo: no transaction ID in response")
+	}
+	return result.TxID, nil
+}
+
+// AuthStatus returns AuthAllow, AuthDeny or AuthWaiting for the push
+// transaction txID.
+func (c *Client) AuthStatus(txID string) (string, error) {
+	var result resultResponse
+	params := url.Values{"txid": {txID}}
+	if err := c.call("GET", "/auth/v2/auth_status", params, &result); err != nil {
+		return "", err
+	}
+	return result.Result, nil
+}
+
+func (c *Client) call(method, path string, params url.Values,
+	result interface{}) error {
+	date := time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 -0000")
+	encodedParams := canonicalizeParams(params)
+	requestURL := c.baseURL + path
+	var body *strings.Reader
+	if method == "GET" {
+		if encodedParams != "" {
+			requestURL += "?" + encodedParams
+		}
+		body = strings.NewReader("")
+	} else {
+		body = strings.NewReader(encodedParams)
+	}
+	req, err := http.NewRequest(method, requestURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Date", date)
+	req.SetBasicAuth(c.IntegrationKey,
+		c.sign(date, method, path, encodedParams))
+	if method != "GET" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var response apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("duo: cannot decode response (%s): %s",
+			resp.Status, err)
+	}
+	if response.Stat != "OK" {
+		return fmt.Errorf("duo: %d %s %s", response.Code, response.Message,
+			response.Detail)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Response, result)
+}
+
+// sign computes the v2 request signature.
+func (c *Client) sign(date, method, path, encodedParams string) string {
+	canonical := strings.Join([]string{
+		date,
+		strings.ToUpper(method),
+		strings.ToLower(c.APIHost),
+		path,
+		encodedParams,
+	}, "\n")
+	mac := hmac.New(sha1.New, []byte(c.SecretKey))
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// canonicalizeParams sorts by key and encodes spaces as %20, as the
+// signature requires.
+func canonicalizeParams(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := params[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, escape(key)+"="+escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
@@ -0,0 +1,104 @@
+package duo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) (
+	*Client, *httptest.Server) {
+	server := httptest.NewTLSServer(handler)
+	client, err := New("api-test.duosecurity.com", "DIXXXXXXXXXXXXXXXXXX",
+		"secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.baseURL = server.URL
+	client.httpClient = server.Client()
+	return client, server
+}
+
+func writeDuoResponse(w http.ResponseWriter, response interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"stat":     "OK",
+		"response": response,
+	})
+}
+
+func TestCanonicalizeParams(t *testing.T) {
+	params := url.Values{
+		"username": {"jane doe"},
+		"async":    {"1"},
+		"type":     {"a+b~c"},
+	}
+	expected := "async=1&type=a%2Bb~c&username=jane%20doe"
+	if got := canonicalizeParams(params); got != expected {
+		t.Fatalf("got %s, want %s", got, expected)
+	}
+}
+
+func TestPushFlow(t *testing.T) {
+	serverKeys := &Client{APIHost: "api-test.duosecurity.com",
+		IntegrationKey: "DIXXXXXXXXXXXXXXXXXX", SecretKey: "secret"}
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		params := r.Form
+		if r.Method == "GET" {
+			params = r.URL.Query()
+		}
+		user, password, ok := r.BasicAuth()
+		expected := serverKeys.sign(r.Header.Get("Date"), r.Method, r.URL.Path,
+			canonicalizeParams(params))
+		if !ok || user != serverKeys.IntegrationKey || password != expected {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"stat":"FAIL","code":40103,"message":"Invalid signature in request credentials"}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/auth/v2/preauth":
+			writeDuoResponse(w, resultResponse{Result: PreauthAuth})
+		case "/auth/v2/auth":
+			if params.Get("factor") != "push" || params.Get("async") != "1" {
+				t.Errorf("unexpected auth params: %v", params)
+			}
+			writeDuoResponse(w, resultResponse{TxID: "tx1"})
+		case "/auth/v2/auth_status":
+			result := AuthWaiting
+			if params.Get("txid") == "tx1" {
+				result = AuthAllow
+			}
+			writeDuoResponse(w, resultResponse{Result: result})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer server.Close()
+	result, err := client.Preauth("jane doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != PreauthAuth {
+		t.Fatalf("unexpected preauth result %s", result)
+	}
+	txID, err := client.StartPush("jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := client.AuthStatus(txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != AuthAllow {
+		t.Fatalf("unexpected status %s", status)
+	}
+	client.SecretKey = "wrong"
+	if _, err := client.Preauth("jane"); err == nil {
+		t.Fatal("bad signature should fail")
+	}
+}
@@ -84,6 +84,16 @@ func (r *LoggingWriter) SetUsername(username string) {
 	r.logRecord.Username = username
 }
 
+// Username returns the username of the log record.
+func (r *LoggingWriter) Username() string {
+	return r.logRecord.Username
+}
+
+// Status returns the status code written so far, or 0.
+func (r *LoggingWriter) Status() int {
+	return r.logRecord.Status
+}
+
 // http.CloseNotifier interface
 func (r *LoggingWriter) CloseNotify() <-chan bool {
 	if w, ok := r.ResponseWriter.(http.CloseNotifier); ok {
@@ -0,0 +1,56 @@
+// Package krl reads and writes OpenSSH key revocation lists (KRLs, see
+// PROTOCOL.krl in the OpenSSH sources) which revoke certificates by serial
+// number, as loaded by the RevokedKeys option of sshd.
+package krl
+
+import (
+	"crypto"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KRL revokes the certificates with the listed serial numbers.
+type KRL struct {
+	// Version increases whenever the list changes.
+	Version       uint64
+	GeneratedDate time.Time
+	Comment       string
+	Sections      []CertificateSection
+}
+
+// CertificateSection lists the revoked certificates signed by one CA.
+type CertificateSection struct {
+	// CAKey is the CA which signed the certificates. nil means any CA.
+	CAKey   ssh.PublicKey
+	Serials []uint64
+}
+
+// Marshal returns the KRL in the OpenSSH format. Serial numbers are sorted.
+func (k *KRL) Marshal() []byte {
+	return k.marshal()
+}
+
+// Parse parses a KRL in the OpenSSH format. Sections revoking keys or key
+// IDs are not supported, as a list which is only partially understood
+// cannot be relied upon.
+func Parse(data []byte) (*KRL, error) {
+	return parse(data)
+}
+
+// IsRevoked returns true if cert is revoked by k.
+func (k *KRL) IsRevoked(cert *ssh.Certificate) bool {
+	return k.isRevoked(cert)
+}
+
+// Sign returns the detached signature of data, normally a marshaled KRL,
+// made with signer. RSA keys sign with SHA-256. The signature is in the SSH
+// wire format.
+func Sign(signer crypto.Signer, data []byte) ([]byte, error) {
+	return sign(signer, data)
+}
+
+// Verify checks that signature is a signature of data by one of caKeys.
+func Verify(caKeys []ssh.PublicKey, data, signature []byte) error {
+	return verify(caKeys, data, signature)
+}
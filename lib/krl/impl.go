@@ -0,0 +1,279 @@
+package krl
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	magic         = "SSHKRL\n\x00"
+	formatVersion = 1
+
+	sectionCertificates = 1
+
+	certSectionSerialList   = 0x20
+	certSectionSerialRange  = 0x21
+	certSectionSerialBitmap = 0x22
+
+	// Larger bitmaps are rejected rather than expanded.
+	maxBitmapBits = 1 << 20
+)
+
+func appendUint32(buffer []byte, value uint32) []byte {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], value)
+	return append(buffer, data[:]...)
+}
+
+func appendUint64(buffer []byte, value uint64) []byte {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], value)
+	return append(buffer, data[:]...)
+}
+
+func appendString(buffer []byte, value []byte) []byte {
+	return append(appendUint32(buffer, uint32(len(value))), value...)
+}
+
+func (k *KRL) marshal() []byte {
+	buffer := []byte(magic)
+	buffer = appendUint32(buffer, formatVersion)
+	buffer = appendUint64(buffer, k.Version)
+	var generated uint64
+	if !k.GeneratedDate.IsZero() {
+		generated = uint64(k.GeneratedDate.Unix())
+	}
+	buffer = appendUint64(buffer, generated)
+	buffer = appendUint64(buffer, 0) // Flags.
+	buffer = appendString(buffer, nil)
+	buffer = appendString(buffer, []byte(k.Comment))
+	for _, section := range k.Sections {
+		var data []byte
+		if section.CAKey != nil {
+			data = appendString(data, section.CAKey.Marshal())
+		} else {
+			data = appendString(data, nil)
+		}
+		data = appendString(data, nil)
+		if len(section.Serials) > 0 {
+			serials := append([]uint64{}, section.Serials...)
+			sort.Slice(serials, func(i, j int) bool {
+				return serials[i] < serials[j]
+			})
+			var list []byte
+			for _, serial := range serials {
+				list = appendUint64(list, serial)
+			}
+			data = append(data, certSectionSerialList)
+			data = appendString(data, list)
+		}
+		buffer = append(buffer, sectionCertificates)
+		buffer = appendString(buffer, data)
+	}
+	return buffer
+}
+
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) fail() {
+	if r.err == nil {
+		r.err = errors.New("krl: truncated")
+	}
+	r.data = nil
+}
+
+func (r *reader) bytes(length int) []byte {
+	if r.err != nil || length < 0 || len(r.data) < length {
+		r.fail()
+		return nil
+	}
+	value := r.data[:length]
+	r.data = r.data[length:]
+	return value
+}
+
+func (r *reader) byte() byte {
+	if value := r.bytes(1); value != nil {
+		return value[0]
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if value := r.bytes(4); value != nil {
+		return binary.BigEndian.Uint32(value)
+	}
+	return 0
+}
+
+func (r *reader) uint64() uint64 {
+	if value := r.bytes(8); value != nil {
+		return binary.BigEndian.Uint64(value)
+	}
+	return 0
+}
+
+func (r *reader) string() []byte {
+	length := r.uint32()
+	if length > uint32(len(r.data)) {
+		r.fail()
+		return nil
+	}
+	return r.bytes(int(length))
+}
+
+func parse(data []byte) (*KRL, error) {
+	r := &reader{data: data}
+	if string(r.bytes(len(magic))) != magic {
+		return nil, errors.New("krl: not a KRL")
+	}
+	if version := r.uint32(); version != formatVersion {
+		return nil, fmt.Errorf("krl: unsupported format version %d", version)
+	}
+	k := &KRL{Version: r.uint64()}
+	if generated := r.uint64(); generated > 0 {
+		k.GeneratedDate = time.Unix(int64(generated), 0)
+	}
+	r.uint64() // Flags.
+	r.string() // Reserved.
+	k.Comment = string(r.string())
+	for r.err == nil && len(r.data) > 0 {
+		sectionType := r.byte()
+		sectionData := r.string()
+		if r.err != nil {
+			break
+		}
+		if sectionType != sectionCertificates {
+			return nil, fmt.Errorf("krl: unsupported section type %d",
+				sectionType)
+		}
+		section, err := parseCertificateSection(sectionData)
+		if err != nil {
+			return nil, err
+		}
+		k.Sections = append(k.Sections, section)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return k, nil
+}
+
+func parseCertificateSection(data []byte) (CertificateSection, error) {
+	var section CertificateSection
+	r := &reader{data: data}
+	if caKey := r.string(); len(caKey) > 0 {
+		var err error
+		section.CAKey, err = ssh.ParsePublicKey(caKey)
+		if err != nil {
+			return section, fmt.Errorf("krl: bad CA key: %s", err)
+		}
+	}
+	r.string() // Reserved.
+	for r.err == nil && len(r.data) > 0 {
+		subsectionType := r.byte()
+		sr := &reader{data: r.string()}
+		if r.err != nil {
+			break
+		}
+		switch subsectionType {
+		case certSectionSerialList:
+			for sr.err == nil && len(sr.data) > 0 {
+				section.Serials = append(section.Serials, sr.uint64())
+			}
+		case certSectionSerialRange:
+			low, high := sr.uint64(), sr.uint64()
+			if sr.err == nil && (high < low || high-low >= maxBitmapBits) {
+				return section, errors.New("krl: serial range too large")
+			}
+			for index := uint64(0); sr.err == nil && index <= high-low; index++ {
+				section.Serials = append(section.Serials, low+index)
+			}
+		case certSectionSerialBitmap:
+			offset := sr.uint64()
+			bitmap := new(big.Int).SetBytes(sr.string())
+			if bitmap.BitLen() > maxBitmapBits {
+				return section, errors.New("krl: serial bitmap too large")
+			}
+			for bit := 0; bit < bitmap.BitLen(); bit++ {
+				if bitmap.Bit(bit) == 1 {
+					section.Serials = append(section.Serials,
+						offset+uint64(bit))
+				}
+			}
+		default:
+			return section, fmt.Errorf(
+				"krl: unsupported certificate section type %d", subsectionType)
+		}
+		if sr.err != nil {
+			return section, sr.err
+		}
+	}
+	return section, r.err
+}
+
+func (k *KRL) isRevoked(cert *ssh.Certificate) bool {
+	for _, section := range k.Sections {
+		if section.CAKey != nil && (cert.SignatureKey == nil ||
+			!bytes.Equal(section.CAKey.Marshal(), cert.SignatureKey.Marshal())) {
+			continue
+		}
+		for _, serial := range section.Serials {
+			if serial == cert.Serial {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sign(signer crypto.Signer, data []byte) ([]byte, error) {
+	sshSigner, err := ssh.NewSignerFromSigner(signer)
+	if err != nil {
+		return nil, err
+	}
+	var signature *ssh.Signature
+	if _, ok := signer.Public().(*rsa.PublicKey); ok {
+		algorithmSigner, ok := sshSigner.(ssh.AlgorithmSigner)
+		if !ok {
+			return nil, errors.New("krl: cannot sign with SHA-256")
+		}
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, data,
+			ssh.KeyAlgoRSASHA256)
+	} else {
+		signature, err = sshSigner.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ssh.Marshal(signature), nil
+}
+
+func verify(caKeys []ssh.PublicKey, data, signature []byte) error {
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(signature, &sig); err != nil {
+		return fmt.Errorf("krl: bad signature: %s", err)
+	}
+	if sig.Format == ssh.KeyAlgoRSA {
+		return errors.New("krl: SHA-1 signatures are not accepted")
+	}
+	for _, caKey := range caKeys {
+		if caKey.Verify(data, &sig) == nil {
+			return nil
+		}
+	}
+	return errors.New("krl: signature not made by a trusted CA key")
+}
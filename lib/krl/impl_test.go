@@ -0,0 +1,143 @@
+package krl
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestCAKey(t *testing.T) (ed25519.PrivateKey, ssh.PublicKey) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private, sshPublic
+}
+
+func TestMarshalParse(t *testing.T) {
+	_, caKey := newTestCAKey(t)
+	_, otherCAKey := newTestCAKey(t)
+	krl := &KRL{
+		Version:       3,
+		GeneratedDate: time.Unix(1500000000, 0),
+		Comment:       "test",
+		Sections: []CertificateSection{
+			{CAKey: caKey, Serials: []uint64{42, 7}},
+		},
+	}
+	parsed, err := Parse(krl.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Version != 3 || parsed.Comment != "test" ||
+		!parsed.GeneratedDate.Equal(krl.GeneratedDate) {
+		t.Errorf("unexpected header %+v", parsed)
+	}
+	if len(parsed.Sections) != 1 ||
+		!reflect.DeepEqual(parsed.Sections[0].Serials, []uint64{7, 42}) {
+		t.Fatalf("unexpected sections %+v", parsed.Sections)
+	}
+	for _, test := range []struct {
+		caKey   ssh.PublicKey
+		serial  uint64
+		revoked bool
+	}{
+		{caKey, 42, true},
+		{caKey, 43, false},
+		{otherCAKey, 42, false},
+	} {
+		cert := &ssh.Certificate{SignatureKey: test.caKey, Serial: test.serial}
+		if parsed.IsRevoked(cert) != test.revoked {
+			t.Errorf("serial %d: revoked is not %v", test.serial, test.revoked)
+		}
+	}
+}
+
+func TestParseRangesAndBitmaps(t *testing.T) {
+	var section []byte
+	section = appendString(section, nil) // Any CA.
+	section = appendString(section, nil)
+	var serialRange []byte
+	serialRange = appendUint64(serialRange, 10)
+	serialRange = appendUint64(serialRange, 12)
+	section = append(section, certSectionSerialRange)
+	section = appendString(section, serialRange)
+	var bitmap []byte
+	bitmap = appendUint64(bitmap, 100)
+	bitmap = appendString(bitmap, []byte{0x05}) // Bits 0 and 2.
+	section = append(section, certSectionSerialBitmap)
+	section = appendString(section, bitmap)
+	data := (&KRL{}).Marshal()
+	data = append(data, sectionCertificates)
+	data = appendString(data, section)
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint64{10, 11, 12, 100, 102}
+	if !reflect.DeepEqual(parsed.Sections[0].Serials, expected) {
+		t.Errorf("serials are %v, expected %v", parsed.Sections[0].Serials,
+			expected)
+	}
+	if !parsed.IsRevoked(&ssh.Certificate{Serial: 11}) {
+		t.Error("serial in range not revoked")
+	}
+}
+
+func TestParseRejectsUnsupported(t *testing.T) {
+	base := (&KRL{}).Marshal()
+	for name, data := range map[string][]byte{
+		"bad magic":     []byte("SSHKRL\n\x01"),
+		"truncated":     base[:len(base)-1],
+		"key section":   appendString(append(append([]byte{}, base...), 2), nil),
+		"trailing byte": append(append([]byte{}, base...), sectionCertificates),
+	} {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	edKey, edPublic := newTestCAKey(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublic, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := (&KRL{Version: 1}).Marshal()
+	edSignature, err := Sign(edKey, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSignature, err := Sign(rsaKey, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := []ssh.PublicKey{rsaPublic, edPublic}
+	if err := Verify(trusted, data, edSignature); err != nil {
+		t.Error(err)
+	}
+	if err := Verify(trusted, data, rsaSignature); err != nil {
+		t.Error(err)
+	}
+	if err := Verify([]ssh.PublicKey{rsaPublic}, data, edSignature); err == nil {
+		t.Error("signature by an untrusted key accepted")
+	}
+	tampered := append(append([]byte{}, data...), 0)
+	if err := Verify(trusted, tampered, rsaSignature); err == nil {
+		t.Error("signature of other data accepted")
+	}
+}
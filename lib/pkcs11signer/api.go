@@ -0,0 +1,34 @@
+// Package pkcs11signer provides crypto.Signers backed by keys stored in a
+// PKCS#11 token (HSM, smartcard or cloud KMS with a PKCS#11 module). The
+// private key never leaves the token.
+package pkcs11signer
+
+import (
+	"crypto"
+)
+
+// Config identifies the PKCS#11 module, the token and the key to use.
+type Config struct {
+	ModulePath string `yaml:"module_path"`
+	TokenLabel string `yaml:"token_label"`
+	Pin        string `yaml:"pin" secret:"true"`
+	KeyLabel   string `yaml:"key_label"`
+	KeyID      string `yaml:"key_id"` // Hex encoded CKA_ID.
+}
+
+// Signer is a crypto.Signer for a key on a PKCS#11 token.
+type Signer struct {
+	crypto.Signer
+	closer func() error
+}
+
+// New opens the token described by config and returns a Signer for the key
+// with the configured label and/or ID.
+func New(config Config) (*Signer, error) {
+	return newSigner(config)
+}
+
+// Close releases the PKCS#11 session. The Signer must not be used after.
+func (s *Signer) Close() error {
+	return s.closer()
+}
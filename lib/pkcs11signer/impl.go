@@ -0,0 +1,58 @@
+package pkcs11signer
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/ThalesIgnite/crypto11"
+)
+
+func (config Config) check() error {
+	if config.ModulePath == "" {
+		return errors.New("pkcs11: missing module_path")
+	}
+	if config.TokenLabel == "" {
+		return errors.New("pkcs11: missing token_label")
+	}
+	if config.KeyLabel == "" && config.KeyID == "" {
+		return errors.New("pkcs11: one of key_label or key_id is required")
+	}
+	return nil
+}
+
+func newSigner(config Config) (*Signer, error) {
+	if err := config.check(); err != nil {
+		return nil, err
+	}
+	var id, label []byte
+	if config.KeyID != "" {
+		var err error
+		id, err = hex.DecodeString(config.KeyID)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs11: bad key_id: %s", err)
+		}
+	}
+	if config.KeyLabel != "" {
+		label = []byte(config.KeyLabel)
+	}
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       config.ModulePath,
+		TokenLabel: config.TokenLabel,
+		Pin:        config.Pin,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: cannot open token %s: %s",
+			config.TokenLabel, err)
+	}
+	signer, err := ctx.FindKeyPair(id, label)
+	if err != nil {
+		ctx.Close()
+		return nil, fmt.Errorf("pkcs11: cannot find key: %s", err)
+	}
+	if signer == nil {
+		ctx.Close()
+		return nil, errors.New("pkcs11: key not found on token")
+	}
+	return &Signer{Signer: signer, closer: ctx.Close}, nil
+}
@@ -0,0 +1,29 @@
+package pkcs11signer
+
+import (
+	"testing"
+)
+
+func TestConfigCheck(t *testing.T) {
+	valid := Config{
+		ModulePath: "/usr/lib/softhsm/libsofthsm2.so",
+		TokenLabel: "keymaster",
+		KeyLabel:   "https",
+	}
+	if err := valid.check(); err != nil {
+		t.Fatal(err)
+	}
+	for _, config := range []Config{
+		{TokenLabel: "keymaster", KeyLabel: "https"},
+		{ModulePath: valid.ModulePath, KeyLabel: "https"},
+		{ModulePath: valid.ModulePath, TokenLabel: "keymaster"},
+	} {
+		if err := config.check(); err == nil {
+			t.Fatalf("config %+v should be invalid", config)
+		}
+	}
+	if _, err := New(Config{ModulePath: valid.ModulePath,
+		TokenLabel: "keymaster", KeyID: "not-hex"}); err == nil {
+		t.Fatal("bad key_id should fail")
+	}
+}
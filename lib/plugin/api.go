@@ -0,0 +1,114 @@
+// Package plugin runs keymasterd extensions as external processes with
+// hashicorp/go-plugin. keymasterd starts each plugin, asks it for its
+// capabilities and then calls it over the gRPC protocol defined in
+// proto/plugin. Plugins written in Go can use Serve.
+package plugin
+
+import (
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/simplestorage"
+	proto "github.com/Symantec/keymaster/proto/plugin"
+	goplugin "github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+)
+
+// Authenticator is implemented by plugins which check passwords.
+type Authenticator interface {
+	Authenticate(username string, password []byte) (bool, error)
+}
+
+// PolicyHook is implemented by plugins which approve certificate issuance.
+type PolicyHook interface {
+	CheckPolicy(request *proto.PolicyRequest) (*proto.PolicyResponse, error)
+}
+
+// InventoryProvider is implemented by plugins which know the names of hosts.
+type InventoryProvider interface {
+	LookupHost(identity string) (*proto.LookupHostResponse, error)
+}
+
+// HealthChecker may be implemented by plugins to report their health.
+type HealthChecker interface {
+	HealthCheck() error
+}
+
+// Serve serves impl, which implements one or more of Authenticator,
+// PolicyHook and InventoryProvider, until keymasterd stops the plugin and then
+// exits. It fails if the process was not started by keymasterd.
+func Serve(impl interface{}) error {
+	return serve(impl)
+}
+
+// Client is a running plugin process. The process is restarted if it dies
+// and killed if a call times out.
+type Client struct {
+	name        string
+	path        string
+	args        []string
+	callTimeout time.Duration
+	logger      log.DebugLogger
+	mutex       sync.Mutex
+	// Protected by mutex. pluginClient is nil if the process has to be
+	// (re)started before the next call.
+	pluginClient *goplugin.Client
+	cmd          *exec.Cmd
+	conn         *grpc.ClientConn
+	capabilities map[string]struct{}
+	closed       bool
+}
+
+// New starts the plugin at path with args and asks it for its capabilities.
+func New(name string, path string, args []string,
+	logger log.DebugLogger) (*Client, error) {
+	return newClient(name, path, args, logger)
+}
+
+// Name returns the configured name of the plugin.
+func (c *Client) Name() string {
+	return c.name
+}
+
+// HasCapability returns true if the plugin announced the capability (one of
+// the proto.Capability* constants) when it was started.
+func (c *Client) HasCapability(capability string) bool {
+	return c.hasCapability(capability)
+}
+
+// PasswordAuthenticate checks the password with an authenticator plugin. It
+// implements pwauth.PasswordAuthenticator.
+func (c *Client) PasswordAuthenticate(username string, password []byte) (
+	bool, error) {
+	return c.passwordAuthenticate(username, password)
+}
+
+// UpdateStorage is a no-op: plugins keep their own state.
+func (c *Client) UpdateStorage(storage simplestorage.SimpleStore) error {
+	return nil
+}
+
+// CheckPolicy asks a policy plugin whether a certificate may be issued.
+func (c *Client) CheckPolicy(request *proto.PolicyRequest) (
+	*proto.PolicyResponse, error) {
+	return c.checkPolicy(request)
+}
+
+// LookupHost asks an inventory plugin for the names of a host.
+func (c *Client) LookupHost(identity string) (*proto.LookupHostResponse,
+	error) {
+	return c.lookupHost(identity)
+}
+
+// HealthCheck returns an error if the plugin does not respond or reports
+// itself unhealthy.
+func (c *Client) HealthCheck() error {
+	return c.healthCheck()
+}
+
+// Close stops the plugin process.
+func (c *Client) Close() error {
+	return c.close()
+}
@@ -0,0 +1,420 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	proto "github.com/Symantec/keymaster/proto/plugin"
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultCallTimeout = 5 * time.Second
+
+var handshakeConfig = goplugin.HandshakeConfig{
+	ProtocolVersion:  proto.ProtocolVersion,
+	MagicCookieKey:   proto.MagicCookieKey,
+	MagicCookieValue: proto.MagicCookieValue,
+}
+
+// grpcPlugin is the go-plugin plugin served by every plugin process. On the
+// client side it only hands out the connection.
+type grpcPlugin struct {
+	goplugin.NetRPCUnsupportedPlugin
+	impl interface{}
+}
+
+func (p *grpcPlugin) GRPCServer(broker *goplugin.GRPCBroker,
+	server *grpc.Server) error {
+	proto.RegisterPluginServer(server, newPluginServer(p.impl))
+	if authenticator, ok := p.impl.(Authenticator); ok {
+		proto.RegisterAuthenticatorServer(server,
+			&authenticatorServer{impl: authenticator})
+	}
+	if hook, ok := p.impl.(PolicyHook); ok {
+		proto.RegisterPolicyHookServer(server, &policyHookServer{impl: hook})
+	}
+	if provider, ok := p.impl.(InventoryProvider); ok {
+		proto.RegisterInventoryProviderServer(server,
+			&inventoryProviderServer{impl: provider})
+	}
+	return nil
+}
+
+func (p *grpcPlugin) GRPCClient(ctx context.Context,
+	broker *goplugin.GRPCBroker, conn *grpc.ClientConn) (interface{}, error) {
+	return conn, nil
+}
+
+// lineLogger logs the output of a plugin line by line.
+type lineLogger struct {
+	name   string
+	logger log.DebugLogger
+	mutex  sync.Mutex
+	buffer []byte // Protected by mutex.
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.buffer = append(l.buffer, p...)
+	for {
+		index := bytes.IndexByte(l.buffer, '\n')
+		if index < 0 {
+			break
+		}
+		if index > 0 {
+			l.logger.Printf("plugin %s: %s\n", l.name, l.buffer[:index])
+		}
+		l.buffer = l.buffer[index+1:]
+	}
+	return len(p), nil
+}
+
+func newClient(name string, path string, args []string,
+	logger log.DebugLogger) (*Client, error) {
+	c := &Client{
+		name:        name,
+		path:        path,
+		args:        args,
+		callTimeout: defaultCallTimeout,
+		logger:      logger,
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.restart(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// restart starts a new plugin process and asks it for its capabilities. It
+// must be called with the lock held.
+func (c *Client) restart() error {
+	c.stopProcess()
+	output := &lineLogger{name: c.name, logger: c.logger}
+	c.cmd = exec.Command(c.path, c.args...)
+	c.pluginClient = goplugin.NewClient(&goplugin.ClientConfig{
+		HandshakeConfig:  handshakeConfig,
+		Plugins:          goplugin.PluginSet{proto.PluginName: &grpcPlugin{}},
+		Cmd:              c.cmd,
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolGRPC},
+		Stderr:           output,
+		SyncStdout:       output,
+		SyncStderr:       output,
+		// Only for problems of go-plugin itself: Stderr has all the output.
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Output: output,
+			Level:  hclog.Warn,
+		}),
+	})
+	protocolClient, err := c.pluginClient.Client()
+	if err != nil {
+		c.stopProcess()
+		return fmt.Errorf("plugin %s: cannot start: %s", c.name, err)
+	}
+	raw, err := protocolClient.Dispense(proto.PluginName)
+	if err != nil {
+		c.stopProcess()
+		return fmt.Errorf("plugin %s: cannot start: %s", c.name, err)
+	}
+	c.conn = raw.(*grpc.ClientConn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.callTimeout)
+	defer cancel()
+	response, err := proto.NewPluginClient(c.conn).Capabilities(ctx,
+		&proto.CapabilitiesRequest{})
+	if err != nil {
+		c.stopProcess()
+		return fmt.Errorf("plugin %s: cannot get capabilities: %s", c.name,
+			err)
+	}
+	c.capabilities = make(map[string]struct{})
+	for _, capability := range response.GetCapabilities() {
+		c.capabilities[capability] = struct{}{}
+	}
+	c.logger.Debugf(1, "plugin %s started with capabilities %v", c.name,
+		response.GetCapabilities())
+	return nil
+}
+
+// stopProcess kills the plugin process without waiting for a graceful exit,
+// since it may be hung. It must be called with the lock held.
+func (c *Client) stopProcess() {
+	if c.pluginClient == nil {
+		return
+	}
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+	c.pluginClient.Kill()
+	c.pluginClient = nil
+	c.cmd = nil
+	c.conn = nil
+}
+
+// connection returns the connection to the plugin, starting it if needed.
+func (c *Client) connection() (*grpc.ClientConn, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, errors.New("plugin closed")
+	}
+	if c.pluginClient == nil {
+		if err := c.restart(); err != nil {
+			return nil, err
+		}
+	}
+	return c.conn, nil
+}
+
+// stopConnection stops the plugin process if it still serves conn.
+func (c *Client) stopConnection(conn *grpc.ClientConn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn == conn {
+		c.stopProcess()
+	}
+}
+
+// callWithTimeout calls method and kills the plugin process if it does not
+// answer in time, so that the next call starts a new one.
+func (c *Client) callWithTimeout(conn *grpc.ClientConn,
+	method func(ctx context.Context, conn *grpc.ClientConn) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.callTimeout)
+	defer cancel()
+	err := method(ctx, conn)
+	if status.Code(err) == codes.DeadlineExceeded {
+		c.logger.Printf("plugin %s: call timed out, killing the process\n",
+			c.name)
+		c.stopConnection(conn)
+		return fmt.Errorf("plugin %s: call timed out", c.name)
+	}
+	return err
+}
+
+// call calls method, restarting the plugin once if its process died.
+func (c *Client) call(
+	method func(ctx context.Context, conn *grpc.ClientConn) error) error {
+	conn, err := c.connection()
+	if err != nil {
+		return err
+	}
+	err = c.callWithTimeout(conn, method)
+	if status.Code(err) != codes.Unavailable {
+		return err
+	}
+	c.logger.Printf("plugin %s: connection lost, restarting\n", c.name)
+	c.stopConnection(conn)
+	if conn, err = c.connection(); err != nil {
+		return err
+	}
+	return c.callWithTimeout(conn, method)
+}
+
+func (c *Client) hasCapability(capability string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, ok := c.capabilities[capability]
+	return ok
+}
+
+func (c *Client) checkCapability(capability string) error {
+	if !c.hasCapability(capability) {
+		return fmt.Errorf("plugin %s is not an %s plugin", c.name, capability)
+	}
+	return nil
+}
+
+func (c *Client) passwordAuthenticate(username string, password []byte) (
+	bool, error) {
+	if err := c.checkCapability(proto.CapabilityAuthenticator); err != nil {
+		return false, err
+	}
+	var response *proto.AuthenticateResponse
+	err := c.call(func(ctx context.Context, conn *grpc.ClientConn) error {
+		var err error
+		response, err = proto.NewAuthenticatorClient(conn).Authenticate(ctx,
+			&proto.AuthenticateRequest{
+				Username: username,
+				Password: password,
+			})
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return response.GetValid(), nil
+}
+
+func (c *Client) checkPolicy(request *proto.PolicyRequest) (
+	*proto.PolicyResponse, error) {
+	if err := c.checkCapability(proto.CapabilityPolicy); err != nil {
+		return nil, err
+	}
+	var response *proto.PolicyResponse
+	err := c.call(func(ctx context.Context, conn *grpc.ClientConn) error {
+		var err error
+		response, err = proto.NewPolicyHookClient(conn).CheckPolicy(ctx,
+			request)
+		return err
+	})
+	return response, err
+}
+
+func (c *Client) lookupHost(identity string) (*proto.LookupHostResponse,
+	error) {
+	if err := c.checkCapability(proto.CapabilityInventory); err != nil {
+		return nil, err
+	}
+	var response *proto.LookupHostResponse
+	err := c.call(func(ctx context.Context, conn *grpc.ClientConn) error {
+		var err error
+		response, err = proto.NewInventoryProviderClient(conn).LookupHost(ctx,
+			&proto.LookupHostRequest{Identity: identity})
+		return err
+	})
+	return response, err
+}
+
+func (c *Client) healthCheck() error {
+	var response *proto.HealthCheckResponse
+	err := c.call(func(ctx context.Context, conn *grpc.ClientConn) error {
+		var err error
+		response, err = proto.NewPluginClient(conn).HealthCheck(ctx,
+			&proto.HealthCheckRequest{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if response.GetError() != "" {
+		return errors.New(response.GetError())
+	}
+	return nil
+}
+
+func (c *Client) close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	c.stopProcess()
+	return nil
+}
+
+// pluginServer serves the Plugin service for an implementation.
+type pluginServer struct {
+	proto.UnimplementedPluginServer
+	impl         interface{}
+	capabilities []string
+}
+
+func newPluginServer(impl interface{}) *pluginServer {
+	server := &pluginServer{impl: impl}
+	if _, ok := impl.(Authenticator); ok {
+		server.capabilities = append(server.capabilities,
+			proto.CapabilityAuthenticator)
+	}
+	if _, ok := impl.(PolicyHook); ok {
+		server.capabilities = append(server.capabilities,
+			proto.CapabilityPolicy)
+	}
+	if _, ok := impl.(InventoryProvider); ok {
+		server.capabilities = append(server.capabilities,
+			proto.CapabilityInventory)
+	}
+	return server
+}
+
+func (s *pluginServer) Capabilities(ctx context.Context,
+	request *proto.CapabilitiesRequest) (*proto.CapabilitiesResponse, error) {
+	return &proto.CapabilitiesResponse{Capabilities: s.capabilities}, nil
+}
+
+func (s *pluginServer) HealthCheck(ctx context.Context,
+	request *proto.HealthCheckRequest) (*proto.HealthCheckResponse, error) {
+	response := &proto.HealthCheckResponse{}
+	if checker, ok := s.impl.(HealthChecker); ok {
+		if err := checker.HealthCheck(); err != nil {
+			response.Error = err.Error()
+		}
+	}
+	return response, nil
+}
+
+type authenticatorServer struct {
+	proto.UnimplementedAuthenticatorServer
+	impl Authenticator
+}
+
+func (s *authenticatorServer) Authenticate(ctx context.Context,
+	request *proto.AuthenticateRequest) (*proto.AuthenticateResponse, error) {
+	valid, err := s.impl.Authenticate(request.GetUsername(),
+		request.GetPassword())
+	if err != nil {
+		return nil, err
+	}
+	return &proto.AuthenticateResponse{Valid: valid}, nil
+}
+
+type policyHookServer struct {
+	proto.UnimplementedPolicyHookServer
+	impl PolicyHook
+}
+
+func (s *policyHookServer) CheckPolicy(ctx context.Context,
+	request *proto.PolicyRequest) (*proto.PolicyResponse, error) {
+	response, err := s.impl.CheckPolicy(request)
+	if err != nil {
+		return nil, err
+	}
+	if response == nil {
+		response = &proto.PolicyResponse{}
+	}
+	return response, nil
+}
+
+type inventoryProviderServer struct {
+	proto.UnimplementedInventoryProviderServer
+	impl InventoryProvider
+}
+
+func (s *inventoryProviderServer) LookupHost(ctx context.Context,
+	request *proto.LookupHostRequest) (*proto.LookupHostResponse, error) {
+	response, err := s.impl.LookupHost(request.GetIdentity())
+	if err != nil {
+		return nil, err
+	}
+	if response == nil {
+		response = &proto.LookupHostResponse{}
+	}
+	return response, nil
+}
+
+func serve(impl interface{}) error {
+	// goplugin.Serve exits if the cookie is missing.
+	if os.Getenv(proto.MagicCookieKey) != proto.MagicCookieValue {
+		return errors.New(
+			"this program is a keymasterd plugin and must be started by keymasterd")
+	}
+	goplugin.Serve(&goplugin.ServeConfig{
+		HandshakeConfig: handshakeConfig,
+		Plugins:         goplugin.PluginSet{proto.PluginName: &grpcPlugin{impl: impl}},
+		GRPCServer:      goplugin.DefaultGRPCServer,
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Output:     os.Stderr,
+			Level:      hclog.Info,
+			JSONFormat: true,
+		}),
+	})
+	return nil
+}
@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+	proto "github.com/Symantec/keymaster/proto/plugin"
+)
+
+const testPluginEnv = "KEYMASTER_PLUGIN_TEST"
+
+type testPlugin struct{}
+
+func (testPlugin) Authenticate(username string, password []byte) (bool, error) {
+	if username == "crash" {
+		os.Exit(1)
+	}
+	return username == "alice" && string(password) == "secret", nil
+}
+
+func (testPlugin) LookupHost(identity string) (*proto.LookupHostResponse,
+	error) {
+	if identity != "relay1" {
+		return nil, nil
+	}
+	return &proto.LookupHostResponse{
+		Found:    true,
+		DnsNames: []string{"mx1.example.com"},
+	}, nil
+}
+
+func (testPlugin) HealthCheck() error {
+	return errors.New("backend degraded")
+}
+
+// policyPlugin never answers requests for "hang".
+type policyPlugin struct{}
+
+func (policyPlugin) CheckPolicy(request *proto.PolicyRequest) (
+	*proto.PolicyResponse, error) {
+	if request.GetUsername() == "hang" {
+		select {}
+	}
+	return &proto.PolicyResponse{Allow: true}, nil
+}
+
+// The test binary doubles as the plugin when started by the client.
+func TestMain(m *testing.M) {
+	var impl interface{}
+	switch os.Getenv(testPluginEnv) {
+	case "":
+		os.Exit(m.Run())
+	case "policy":
+		impl = policyPlugin{}
+	default:
+		impl = testPlugin{}
+	}
+	if err := Serve(impl); err != nil {
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
+
+func TestPluginProcess(t *testing.T) {
+	os.Setenv(testPluginEnv, "1")
+	defer os.Unsetenv(testPluginEnv)
+	client, err := New("test", os.Args[0], nil, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if !client.HasCapability(proto.CapabilityAuthenticator) ||
+		!client.HasCapability(proto.CapabilityInventory) ||
+		client.HasCapability(proto.CapabilityPolicy) {
+		t.Fatal("unexpected capabilities")
+	}
+	valid, err := client.PasswordAuthenticate("alice", []byte("secret"))
+	if err != nil || !valid {
+		t.Fatalf("expected valid password, got %v, %v", valid, err)
+	}
+	valid, err = client.PasswordAuthenticate("alice", []byte("wrong"))
+	if err != nil || valid {
+		t.Fatalf("expected invalid password, got %v, %v", valid, err)
+	}
+	host, err := client.LookupHost("relay1")
+	if err != nil || !host.GetFound() ||
+		host.GetDnsNames()[0] != "mx1.example.com" {
+		t.Fatalf("unexpected host %+v, %v", host, err)
+	}
+	host, err = client.LookupHost("relay2")
+	if err != nil || host.GetFound() {
+		t.Fatalf("unexpected host %+v, %v", host, err)
+	}
+	if _, err := client.CheckPolicy(&proto.PolicyRequest{}); err == nil {
+		t.Fatal("policy call to a plugin without the capability should fail")
+	}
+	if err := client.HealthCheck(); err == nil {
+		t.Fatal("expected unhealthy plugin")
+	}
+	// A crashed plugin is restarted on the next call.
+	if _, err := client.PasswordAuthenticate("crash", nil); err == nil {
+		t.Fatal("call to crashing plugin should fail")
+	}
+	valid, err = client.PasswordAuthenticate("alice", []byte("secret"))
+	if err != nil || !valid {
+		t.Fatalf("plugin not restarted: %v, %v", valid, err)
+	}
+}
+
+func TestHungPluginIsKilled(t *testing.T) {
+	os.Setenv(testPluginEnv, "policy")
+	defer os.Unsetenv(testPluginEnv)
+	client, err := New("test", os.Args[0], nil, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.callTimeout = 500 * time.Millisecond
+	client.mutex.Lock()
+	cmd := client.cmd
+	client.mutex.Unlock()
+	_, err = client.CheckPolicy(&proto.PolicyRequest{Username: "hang"})
+	if err == nil {
+		t.Fatal("call to hung plugin should time out")
+	}
+	if cmd.ProcessState == nil {
+		t.Fatal("hung plugin process still running")
+	}
+	response, err := client.CheckPolicy(&proto.PolicyRequest{Username: "alice"})
+	if err != nil || !response.GetAllow() {
+		t.Fatalf("plugin not restarted: %+v, %v", response, err)
+	}
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.cmd == cmd {
+		t.Fatal("hung plugin process reused")
+	}
+}
+
+func TestServeRequiresCookie(t *testing.T) {
+	if err := Serve(testPlugin{}); err == nil {
+		t.Fatal("Serve should refuse to run without the magic cookie")
+	}
+}
@@ -0,0 +1,73 @@
+// Package proxysign signs and verifies requests that a keymaster satellite
+// proxy forwards to the central keymaster servers.
+package proxysign
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	ProxyIDHeader   = "X-Keymaster-Proxy-Id"
+	TimestampHeader = "X-Keymaster-Proxy-Timestamp"
+	NonceHeader     = "X-Keymaster-Proxy-Nonce"
+	SignatureHeader = "X-Keymaster-Proxy-Signature"
+)
+
+// MaxBodySize is the largest request body that will be signed or verified.
+const MaxBodySize = 1 << 24
+
+// SignedHeaders are the request headers covered by the signature, besides
+// the method, host, request URI, body, timestamp and nonce.
+var SignedHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	"Cookie",
+	"X-Forwarded-For",
+}
+
+// Identity is what a valid signature proves about a request.
+type Identity struct {
+	ProxyID string
+	// The peer address of the client as seen by the proxy, the last entry of
+	// X-Forwarded-For. Empty if the proxy did not set it.
+	ClientAddress string
+}
+
+// Verifier verifies proxy signatures and rejects signed requests which it
+// has already seen.
+type Verifier struct {
+	secrets map[string][]byte
+	maxSkew time.Duration
+	mutex   sync.Mutex
+	// Protected by mutex.
+	nonces      map[string]time.Time // Nonce to when it can be forgotten.
+	lastCleanup time.Time
+}
+
+// SignRequest adds the proxy signature headers to req. body must be the
+// complete request body, which is covered by the signature. All headers
+// in SignedHeaders must be set before.
+func SignRequest(req *http.Request, proxyID string, secret []byte,
+	body []byte) error {
+	return signRequest(req, proxyID, secret, body, time.Now())
+}
+
+// IsSigned returns true if req claims to have been forwarded by a proxy.
+func IsSigned(req *http.Request) bool {
+	return req.Header.Get(ProxyIDHeader) != ""
+}
+
+// NewVerifier returns a Verifier using the shared secrets keyed by proxy
+// ID. Signatures older or newer than maxSkew are rejected.
+func NewVerifier(secrets map[string][]byte, maxSkew time.Duration) *Verifier {
+	return newVerifier(secrets, maxSkew)
+}
+
+// Verify verifies the proxy signature of req and returns what it proves.
+// A request is only accepted once. Verify reads the request body and
+// replaces it so that it can be read again.
+func (v *Verifier) Verify(req *http.Request) (*Identity, error) {
+	return v.verify(req, time.Now())
+}
@@ -0,0 +1,140 @@
+package proxysign
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const nonceSize = 16
+
+func computeSignature(secret []byte, proxyID, timestamp, nonce string,
+	req *http.Request, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n%s\n", proxyID, timestamp, nonce,
+		req.Method, req.Host, req.URL.RequestURI())
+	for _, name := range SignedHeaders {
+		fmt.Fprintf(mac, "%s:%q\n", strings.ToLower(name),
+			req.Header[http.CanonicalHeaderKey(name)])
+	}
+	fmt.Fprintf(mac, "%x", bodyHash)
+	return mac.Sum(nil)
+}
+
+func signRequest(req *http.Request, proxyID string, secret []byte,
+	body []byte, now time.Time) error {
+	rawNonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, rawNonce); err != nil {
+		return err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(rawNonce)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := computeSignature(secret, proxyID, timestamp, nonce, req,
+		body)
+	req.Header.Set(ProxyIDHeader, proxyID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader,
+		base64.StdEncoding.EncodeToString(signature))
+	return nil
+}
+
+func newVerifier(secrets map[string][]byte, maxSkew time.Duration) *Verifier {
+	return &Verifier{
+		secrets: secrets,
+		maxSkew: maxSkew,
+		nonces:  make(map[string]time.Time),
+	}
+}
+
+// clientAddress returns the last entry of the X-Forwarded-For headers.
+func clientAddress(header http.Header) string {
+	values := header[http.CanonicalHeaderKey("X-Forwarded-For")]
+	if len(values) < 1 {
+		return ""
+	}
+	entries := strings.Split(values[len(values)-1], ",")
+	return strings.TrimSpace(entries[len(entries)-1])
+}
+
+func (v *Verifier) verify(req *http.Request, now time.Time) (*Identity,
+	error) {
+	proxyID := req.Header.Get(ProxyIDHeader)
+	secret, ok := v.secrets[proxyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown proxy: %s", proxyID)
+	}
+	timestamp := req.Header.Get(TimestampHeader)
+	unixTime, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid proxy signature timestamp")
+	}
+	signedAt := time.Unix(unixTime, 0)
+	skew := now.Sub(signedAt)
+	if skew > v.maxSkew || skew < -v.maxSkew {
+		return nil, errors.New("proxy signature timestamp out of range")
+	}
+	nonce := req.Header.Get(NonceHeader)
+	if rawNonce, err := base64.RawURLEncoding.DecodeString(nonce); err != nil ||
+		len(rawNonce) != nonceSize {
+		return nil, errors.New("invalid proxy signature nonce")
+	}
+	signature, err := base64.StdEncoding.DecodeString(
+		req.Header.Get(SignatureHeader))
+	if err != nil {
+		return nil, errors.New("invalid proxy signature encoding")
+	}
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(http.MaxBytesReader(nil, req.Body,
+			MaxBodySize))
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	expected := computeSignature(secret, proxyID, timestamp, nonce, req, body)
+	if !hmac.Equal(signature, expected) {
+		return nil, errors.New("invalid proxy signature")
+	}
+	// Only checked once the signature is valid, so that forged requests
+	// cannot fill the cache.
+	if !v.useNonce(proxyID+" "+nonce, signedAt.Add(v.maxSkew), now) {
+		return nil, errors.New("replayed proxy signature")
+	}
+	return &Identity{ProxyID: proxyID,
+		ClientAddress: clientAddress(req.Header)}, nil
+}
+
+// useNonce returns false if nonce was already used. Nonces are remembered
+// until their timestamp is out of range.
+func (v *Verifier) useNonce(nonce string, forgetAt time.Time,
+	now time.Time) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if now.Sub(v.lastCleanup) > v.maxSkew {
+		for nonce, forgetAt := range v.nonces {
+			if now.After(forgetAt) {
+				delete(v.nonces, nonce)
+			}
+		}
+		v.lastCleanup = now
+	}
+	if _, ok := v.nonces[nonce]; ok {
+		return false
+	}
+	v.nonces[nonce] = forgetAt
+	return true
+}
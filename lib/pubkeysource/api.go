@@ -0,0 +1,68 @@
+// Package pubkeysource obtains the authorized SSH public keys of users, to
+// be signed without the user uploading them.
+package pubkeysource
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// DefaultSSSDCommand is the command used by NewCommand if none is given.
+const DefaultSSSDCommand = "/usr/bin/sss_ssh_authorizedkeys"
+
+// ErrUserNotFound is returned by sources which know that they have no keys
+// for a user (as opposed to failing to look them up).
+var ErrUserNotFound = errors.New("pubkeysource: user not found")
+
+// Source returns the authorized keys of users.
+type Source interface {
+	// PublicKeys returns the keys of username in authorized_keys format.
+	// A value may hold several lines; blank and comment lines are allowed.
+	PublicKeys(username string) ([]string, error)
+}
+
+// ContextSource is implemented by Sources which stop looking up keys once a
+// context is done.
+type ContextSource interface {
+	// PublicKeysContext is PublicKeys but returns ctx.Err() if ctx is done
+	// before the keys are found.
+	PublicKeysContext(ctx context.Context, username string) ([]string, error)
+}
+
+// PublicKeysContext returns the keys of username from source, passing ctx
+// along if source implements ContextSource.
+func PublicKeysContext(ctx context.Context, source Source,
+	username string) ([]string, error) {
+	return publicKeysContext(ctx, source, username)
+}
+
+// SourceFunc adapts a function to a Source.
+type SourceFunc func(username string) ([]string, error)
+
+func (f SourceFunc) PublicKeys(username string) ([]string, error) {
+	return f(username)
+}
+
+// NewCommand returns a Source which runs command with args followed by the
+// username and reads the keys from its standard output, like
+// sss_ssh_authorizedkeys or an AuthorizedKeysCommand of sshd. A failing
+// command with no output is taken to mean the user has no keys. command
+// defaults to DefaultSSSDCommand.
+func NewCommand(command string, args []string) Source {
+	return newCommand(command, args)
+}
+
+// NewDirectory returns a Source which reads the keys of a user from the
+// file named after the user with a .pub suffix in directory.
+func NewDirectory(directory string) Source {
+	return directorySource(directory)
+}
+
+// NewURL returns a Source which fetches the keys of a user from
+// urlTemplate, with %s replaced by the escaped username, e.g.
+// https://keys.example.com/users/%s.keys. A 404 means the user has no
+// keys. If client is nil a client with a 10 second timeout is used.
+func NewURL(urlTemplate string, client *http.Client) (Source, error) {
+	return newURL(urlTemplate, client)
+}
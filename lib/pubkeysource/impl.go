@@ -0,0 +1,172 @@
+package pubkeysource
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	maxKeysSize = 1 << 20
+	httpTimeout = 10 * time.Second
+)
+
+// checkUsername rejects names which cannot be passed safely as a file name
+// or command argument.
+func checkUsername(username string) error {
+	if username == "" || strings.HasPrefix(username, "-") ||
+		strings.HasPrefix(username, ".") ||
+		strings.ContainsAny(username, "/\\\x00\n") {
+		return fmt.Errorf("pubkeysource: invalid username: %q", username)
+	}
+	return nil
+}
+
+func publicKeysContext(ctx context.Context, source Source,
+	username string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if source, ok := source.(ContextSource); ok {
+		return source.PublicKeysContext(ctx, username)
+	}
+	return source.PublicKeys(username)
+}
+
+type commandSource struct {
+	command string
+	args    []string
+}
+
+func newCommand(command string, args []string) *commandSource {
+	if command == "" {
+		command = DefaultSSSDCommand
+	}
+	return &commandSource{command: command, args: args}
+}
+
+func (s *commandSource) PublicKeys(username string) ([]string, error) {
+	return s.PublicKeysContext(context.Background(), username)
+}
+
+func (s *commandSource) PublicKeysContext(ctx context.Context,
+	username string) ([]string, error) {
+	if err := checkUsername(username); err != nil {
+		return nil, err
+	}
+	args := append(append([]string{}, s.args...), username)
+	cmd := exec.CommandContext(ctx, s.command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if _, ok := err.(*exec.ExitError); ok && stdout.Len() == 0 {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("pubkeysource: %s: %s: %s", s.command, err,
+			strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() > maxKeysSize {
+		return nil, fmt.Errorf("pubkeysource: %s: output too large", s.command)
+	}
+	return []string{stdout.String()}, nil
+}
+
+type directorySource string
+
+func (directory directorySource) PublicKeys(username string) (
+	[]string, error) {
+	if err := checkUsername(username); err != nil {
+		return nil, err
+	}
+	filename := filepath.Join(string(directory), username+".pub")
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxKeysSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxKeysSize {
+		return nil, fmt.Errorf("pubkeysource: %s: too large", filename)
+	}
+	return []string{string(data)}, nil
+}
+
+type urlSource struct {
+	urlTemplate string
+	client      *http.Client
+}
+
+func newURL(urlTemplate string, client *http.Client) (*urlSource, error) {
+	if strings.Count(urlTemplate, "%s") != 1 {
+		return nil, errors.New("pubkeysource: URL must contain one %s")
+	}
+	u, err := url.Parse(strings.Replace(urlTemplate, "%s", "user", 1))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("pubkeysource: unsupported URL scheme: %s",
+			u.Scheme)
+	}
+	if client == nil {
+		client = &http.Client{Timeout: httpTimeout}
+	}
+	return &urlSource{urlTemplate: urlTemplate, client: client}, nil
+}
+
+func (s *urlSource) PublicKeys(username string) ([]string, error) {
+	return s.PublicKeysContext(context.Background(), username)
+}
+
+func (s *urlSource) PublicKeysContext(ctx context.Context,
+	username string) ([]string, error) {
+	if err := checkUsername(username); err != nil {
+		return nil, err
+	}
+	keysURL := strings.Replace(s.urlTemplate, "%s", url.PathEscape(username),
+		1)
+	req, err := http.NewRequest("GET", keysURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrUserNotFound
+	default:
+		return nil, fmt.Errorf("pubkeysource: %s: %s", keysURL, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxKeysSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxKeysSize {
+		return nil, fmt.Errorf("pubkeysource: %s: too large", keysURL)
+	}
+	return []string{string(data)}, nil
+}
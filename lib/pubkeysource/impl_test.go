@@ -0,0 +1,106 @@
+package pubkeysource
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGVxqSYn1pYd3yAk6F7Bv4S7PqIx0q1W7m3g8Hk0r3aR user@host\n"
+
+func checkSource(t *testing.T, name string, source Source) {
+	keys, err := source.PublicKeys("alice")
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if len(keys) != 1 || keys[0] != testKey {
+		t.Errorf("%s: keys %q", name, keys)
+	}
+	if _, err := source.PublicKeys("bob"); err != ErrUserNotFound {
+		t.Errorf("%s: unknown user: %v", name, err)
+	}
+	for _, username := range []string{"", "../alice", "-h", ".alice"} {
+		if _, err := source.PublicKeys(username); err == nil ||
+			err == ErrUserNotFound {
+			t.Errorf("%s: username %q: %v", name, username, err)
+		}
+	}
+}
+
+func TestSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pubkeysource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+	err = ioutil.WriteFile(filepath.Join(dir, "alice.pub"), []byte(testKey),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSource(t, "directory", NewDirectory(dir))
+
+	script := filepath.Join(dir, "keys.sh")
+	err = ioutil.WriteFile(script, []byte(
+		"#!/bin/sh\n[ \"$1\" = -q ] || exit 2\n"+
+			"exec cat \""+dir+"/$2.pub\" 2>/dev/null\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSource(t, "command", NewCommand(script, []string{"-q"}))
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/users/alice.keys" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(testKey))
+		}))
+	defer server.Close()
+	source, err := NewURL(server.URL+"/users/%s.keys", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSource(t, "url", source)
+	for _, urlTemplate := range []string{server.URL, "file:///%s",
+		server.URL + "/%s/%s"} {
+		if _, err := NewURL(urlTemplate, nil); err == nil {
+			t.Errorf("URL %s accepted", urlTemplate)
+		}
+	}
+}
+
+func TestPublicKeysContext(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-unblock:
+			case <-r.Context().Done():
+			}
+		}))
+	defer server.Close()
+	defer close(unblock)
+	source, err := NewURL(server.URL+"/users/%s.keys", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(),
+		50*time.Millisecond)
+	defer cancel()
+	if _, err := PublicKeysContext(ctx, source, "alice"); err == nil {
+		t.Fatal("hanging server did not time out")
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := PublicKeysContext(cancelled, NewDirectory(os.TempDir()),
+		"alice"); err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package pwauth
 
 import (
+	"context"
+
 	"github.com/Symantec/keymaster/lib/simplestorage"
 )
 
@@ -13,3 +15,22 @@ type PasswordAuthenticator interface {
 	PasswordAuthenticate(username string, password []byte) (bool, error)
 	UpdateStorage(storage simplestorage.SimpleStore) error
 }
+
+// ContextPasswordAuthenticator is implemented by PasswordAuthenticators
+// which stop talking to their backend once a context is done.
+type ContextPasswordAuthenticator interface {
+	// PasswordAuthenticateContext is PasswordAuthenticate but returns
+	// ctx.Err() if ctx is done before the backend answers.
+	PasswordAuthenticateContext(ctx context.Context, username string,
+		password []byte) (bool, error)
+}
+
+// PasswordAuthenticateContext authenticates the user with authenticator,
+// passing ctx along if it implements ContextPasswordAuthenticator. Otherwise
+// it returns ctx.Err() once ctx is done and leaves the check to finish in
+// the background.
+func PasswordAuthenticateContext(ctx context.Context,
+	authenticator PasswordAuthenticator, username string,
+	password []byte) (bool, error) {
+	return passwordAuthenticateContext(ctx, authenticator, username, password)
+}
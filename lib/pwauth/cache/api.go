@@ -0,0 +1,56 @@
+// Package cache wraps a password authenticator with a short lived in memory
+// cache of successful verifications, so that bursts of identical logins (e.g.
+// parallel scp from many hosts) do not each cost a round trip to the backend.
+// Only keyed hashes of the credentials are kept and failed verifications are
+// never cached.
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/pwauth"
+	"github.com/Symantec/keymaster/lib/simplestorage"
+)
+
+type cacheEntry struct {
+	hash       []byte
+	expiration time.Time
+}
+
+type PasswordAuthenticator struct {
+	authenticator pwauth.PasswordAuthenticator
+	ttl           time.Duration
+	key           []byte
+	now           func() time.Time
+	mutex         sync.Mutex
+	entries       map[string]cacheEntry
+}
+
+// New returns an authenticator which remembers successful verifications by
+// authenticator for ttl.
+func New(authenticator pwauth.PasswordAuthenticator, ttl time.Duration) (
+	*PasswordAuthenticator, error) {
+	return newAuthenticator(authenticator, ttl)
+}
+
+// PasswordAuthenticate returns true without asking the wrapped
+// authenticator if the same username and password were verified less than
+// the TTL ago.
+func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	return pa.passwordAuthenticate(username, password)
+}
+
+// PasswordAuthenticateContext is PasswordAuthenticate with ctx passed on to
+// the wrapped authenticator.
+func (pa *PasswordAuthenticator) PasswordAuthenticateContext(
+	ctx context.Context, username string, password []byte) (bool, error) {
+	return pa.passwordAuthenticateContext(ctx, username, password)
+}
+
+func (pa *PasswordAuthenticator) UpdateStorage(
+	storage simplestorage.SimpleStore) error {
+	return pa.authenticator.UpdateStorage(storage)
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/simplestorage"
+)
+
+type countingAuthenticator struct {
+	passwords map[string]string
+	calls     int
+	err       error
+}
+
+func (a *countingAuthenticator) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	a.calls++
+	if a.err != nil {
+		return false, a.err
+	}
+	return a.passwords[username] == string(password), nil
+}
+
+func (a *countingAuthenticator) UpdateStorage(
+	storage simplestorage.SimpleStore) error {
+	return nil
+}
+
+func TestCache(t *testing.T) {
+	backend := &countingAuthenticator{
+		passwords: map[string]string{"alice": "secret"}}
+	pa, err := New(backend, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	pa.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		valid, err := pa.PasswordAuthenticate("alice", []byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !valid {
+			t.Fatal("valid password rejected")
+		}
+	}
+	if backend.calls != 1 {
+		t.Fatalf("expected 1 backend call, got %d", backend.calls)
+	}
+	// Wrong passwords always go to the backend and are not cached.
+	for i := 0; i < 2; i++ {
+		if valid, _ := pa.PasswordAuthenticate("alice", []byte("bad")); valid {
+			t.Fatal("bad password accepted")
+		}
+	}
+	if backend.calls != 3 {
+		t.Fatalf("expected 3 backend calls, got %d", backend.calls)
+	}
+	// The rejection removed the cached entry.
+	if valid, _ := pa.PasswordAuthenticate("alice", []byte("secret")); !valid {
+		t.Fatal("valid password rejected")
+	}
+	if backend.calls != 4 {
+		t.Fatalf("expected 4 backend calls, got %d", backend.calls)
+	}
+	now = now.Add(2 * time.Minute)
+	backend.err = errors.New("backend down")
+	if valid, err := pa.PasswordAuthenticate("alice",
+		[]byte("secret")); valid || err == nil {
+		t.Fatal("expired entry should not be used")
+	}
+	if _, err := New(backend, 0); err == nil {
+		t.Fatal("zero TTL should be rejected")
+	}
+}
+
+func TestCacheContext(t *testing.T) {
+	backend := &countingAuthenticator{
+		passwords: map[string]string{"alice": "secret"}}
+	pa, err := New(backend, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	valid, err := pa.PasswordAuthenticateContext(ctx, "alice",
+		[]byte("secret"))
+	if valid || err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v, %v", valid, err)
+	}
+	if backend.calls != 0 {
+		t.Fatal("backend asked after cancellation")
+	}
+	if _, err := pa.PasswordAuthenticateContext(context.Background(),
+		"alice", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	// Cached verifications need no backend work.
+	if valid, err := pa.PasswordAuthenticateContext(ctx, "alice",
+		[]byte("secret")); !valid || err != nil {
+		t.Fatalf("cached password not accepted: %v, %v", valid, err)
+	}
+}
@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/pwauth"
+)
+
+// maxEntries bounds the memory used when many users log in at once.
+const maxEntries = 10000
+
+func newAuthenticator(authenticator pwauth.PasswordAuthenticator,
+	ttl time.Duration) (*PasswordAuthenticator, error) {
+	if authenticator == nil {
+		return nil, errors.New("cache: no authenticator")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("cache: TTL must be positive")
+	}
+	// The key only lives in memory, so entries are useless if dumped.
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &PasswordAuthenticator{
+		authenticator: authenticator,
+		ttl:           ttl,
+		key:           key,
+		now:           time.Now,
+		entries:       make(map[string]cacheEntry),
+	}, nil
+}
+
+func (pa *PasswordAuthenticator) hash(username string,
+	password []byte) []byte {
+	mac := hmac.New(sha256.New, pa.key)
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write(password)
+	return mac.Sum(nil)
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
+	password []byte) (bool, error) {
+	return pa.passwordAuthenticateContext(context.Background(), username,
+		password)
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticateContext(
+	ctx context.Context, username string, password []byte) (bool, error) {
+	hash := pa.hash(username, password)
+	now := pa.now()
+	pa.mutex.Lock()
+	entry, ok := pa.entries[username]
+	pa.mutex.Unlock()
+	if ok && now.Before(entry.expiration) && hmac.Equal(entry.hash, hash) {
+		return true, nil
+	}
+	valid, err := pwauth.PasswordAuthenticateContext(ctx, pa.authenticator,
+		username, password)
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	if err != nil || !valid {
+		// A rejected password may mean it was just changed.
+		if err == nil {
+			delete(pa.entries, username)
+		}
+		return valid, err
+	}
+	if len(pa.entries) >= maxEntries {
+		pa.removeExpired(now)
+	}
+	if len(pa.entries) < maxEntries {
+		pa.entries[username] = cacheEntry{
+			hash:       hash,
+			expiration: now.Add(pa.ttl),
+		}
+	}
+	return true, nil
+}
+
+func (pa *PasswordAuthenticator) removeExpired(now time.Time) {
+	for username, entry := range pa.entries {
+		if !now.Before(entry.expiration) {
+			delete(pa.entries, username)
+		}
+	}
+}
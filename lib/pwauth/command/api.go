@@ -1,6 +1,8 @@
 package command
 
 import (
+	"context"
+
 	"github.com/Symantec/Dominator/lib/log"
 	"github.com/Symantec/keymaster/lib/simplestorage"
 )
@@ -33,6 +35,13 @@ func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
 	return pa.passwordAuthenticate(username, password)
 }
 
+// PasswordAuthenticateContext is PasswordAuthenticate but kills the
+// authentication command once ctx is done.
+func (pa *PasswordAuthenticator) PasswordAuthenticateContext(
+	ctx context.Context, username string, password []byte) (bool, error) {
+	return pa.passwordAuthenticateContext(ctx, username, password)
+}
+
 func (pa *PasswordAuthenticator) UpdateStorage(storage simplestorage.SimpleStore) error {
 	return nil
 }
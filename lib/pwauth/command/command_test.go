@@ -1,7 +1,12 @@
 package command
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/Symantec/Dominator/lib/log/testlogger"
 )
@@ -51,3 +56,31 @@ func TestBrokenCommand(t *testing.T) {
 		t.Fatalf("missing command did not generate error")
 	}
 }
+
+func TestCommandContextTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "hang")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nexec sleep 10\n"),
+		0755); err != nil {
+		t.Fatal(err)
+	}
+	pa, err := New(script, nil, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(),
+		50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	ok, err := pa.PasswordAuthenticateContext(ctx, "u", []byte("p"))
+	if ok || err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v, %v", ok, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("command was not killed")
+	}
+}
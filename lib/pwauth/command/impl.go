@@ -2,6 +2,7 @@ package command
 
 import (
 	"bytes"
+	"context"
 	"os/exec"
 	"syscall"
 
@@ -19,11 +20,20 @@ func newAuthenticator(command string, args []string, logger log.Logger) (
 
 func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
 	password []byte) (bool, error) {
+	return pa.passwordAuthenticateContext(context.Background(), username,
+		password)
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticateContext(
+	ctx context.Context, username string, password []byte) (bool, error) {
 	args := []string{username}
 	args = append(args, pa.args...)
-	cmd := exec.Command(pa.command, args...)
+	cmd := exec.CommandContext(ctx, pa.command, args...)
 	cmd.Stdin = bytes.NewReader(password)
 	if _, err := cmd.Output(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return false, ctxErr
+		}
 		if e, ok := err.(*exec.ExitError); ok {
 			if e.Exited() && e.Sys().(syscall.WaitStatus).ExitStatus() == 1 {
 				return false, nil
@@ -0,0 +1,35 @@
+package pwauth
+
+import (
+	"context"
+)
+
+func passwordAuthenticateContext(ctx context.Context,
+	authenticator PasswordAuthenticator, username string,
+	password []byte) (bool, error) {
+	if authenticator, ok := authenticator.(ContextPasswordAuthenticator); ok {
+		return authenticator.PasswordAuthenticateContext(ctx, username,
+			password)
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if ctx.Done() == nil {
+		return authenticator.PasswordAuthenticate(username, password)
+	}
+	type result struct {
+		valid bool
+		err   error
+	}
+	results := make(chan result, 1)
+	go func() {
+		valid, err := authenticator.PasswordAuthenticate(username, password)
+		results <- result{valid, err}
+	}()
+	select {
+	case r := <-results:
+		return r.valid, r.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
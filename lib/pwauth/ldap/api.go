@@ -1,11 +1,13 @@
 package ldap
 
 import (
+	"context"
 	"crypto/x509"
 	"net/url"
 	"time"
 
 	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/authutil"
 	"github.com/Symantec/keymaster/lib/simplestorage"
 )
 
@@ -14,9 +16,23 @@ type cacheCredentialEntry struct {
 	Hash       string
 }
 
+// UserSearch configures finding the DN of a user by searching the directory
+// with a service account, for directories where it cannot be built from a
+// bind pattern.
+type UserSearch struct {
+	BindDN       string
+	BindPassword string
+	BaseDNs      []string
+	// Filter is a printf string where %s is replaced by the escaped
+	// username, for example "(sAMAccountName=%s)".
+	Filter string
+}
+
 type PasswordAuthenticator struct {
 	ldapURL            []*url.URL
+	connectionPools    []*authutil.LDAPConnectionPool
 	bindPattern        []string
+	userSearch         *UserSearch
 	timeoutSecs        uint
 	rootCAs            *x509.CertPool
 	logger             log.DebugLogger
@@ -30,6 +46,23 @@ func New(url []string, bindPattern []string, timeoutSecs uint, rootCAs *x509.Cer
 	return newAuthenticator(url, bindPattern, timeoutSecs, rootCAs, storage, logger)
 }
 
+// NewWithUserSearch is like New but looks up the DN to bind as using
+// userSearch instead of a bind pattern.
+func NewWithUserSearch(url []string, userSearch UserSearch, timeoutSecs uint,
+	rootCAs *x509.CertPool, storage simplestorage.SimpleStore,
+	logger log.DebugLogger) (*PasswordAuthenticator, error) {
+	return newUserSearchAuthenticator(url, userSearch, timeoutSecs, rootCAs,
+		storage, logger)
+}
+
+// SetReferralPolicy sets how referrals from the LDAP servers are handled.
+// By default they are refused. It must be called before the authenticator
+// is used.
+func (pa *PasswordAuthenticator) SetReferralPolicy(
+	policy authutil.LDAPReferralPolicy) error {
+	return pa.setReferralPolicy(policy)
+}
+
 func (pa *PasswordAuthenticator) UpdateStorage(storage simplestorage.SimpleStore) error {
 	pa.storage = storage
 	return nil
@@ -44,3 +77,11 @@ func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
 	password []byte) (bool, error) {
 	return pa.passwordAuthenticate(username, password)
 }
+
+// PasswordAuthenticateContext is PasswordAuthenticate but aborts the binds
+// and searches in progress once ctx is done. The local hash db is not used
+// as a fallback in that case.
+func (pa *PasswordAuthenticator) PasswordAuthenticateContext(
+	ctx context.Context, username string, password []byte) (bool, error) {
+	return pa.passwordAuthenticateContext(ctx, username, password)
+}
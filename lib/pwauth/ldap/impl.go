@@ -1,9 +1,11 @@
 package ldap
 
 import (
+	"context"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Symantec/Dominator/lib/log"
@@ -14,6 +16,7 @@ import (
 const defaultCacheDuration = time.Hour * 96
 const passwordDataType = 1
 const browserResponseTimeoutSeconds = 7
+const maxIdleConnectionsPerServer = 4
 
 func newAuthenticator(urllist []string, bindPattern []string,
 	timeoutSecs uint, rootCAs *x509.CertPool,
@@ -29,10 +32,16 @@ func newAuthenticator(urllist []string, bindPattern []string,
 	}
 	authenticator.bindPattern = bindPattern
 	authenticator.timeoutSecs = timeoutSecs
-	if timeoutSecs*uint(len(authenticator.ldapURL)) > uint(browserResponseTimeoutSeconds) {
-		authenticator.timeoutSecs = uint(browserResponseTimeoutSeconds) / uint(len(authenticator.ldapURL))
+	// Servers are queried concurrently, so each may use the whole budget.
+	if timeoutSecs > uint(browserResponseTimeoutSeconds) {
+		authenticator.timeoutSecs = uint(browserResponseTimeoutSeconds)
 	}
 	authenticator.rootCAs = rootCAs
+	for _, url := range authenticator.ldapURL {
+		authenticator.connectionPools = append(authenticator.connectionPools,
+			authutil.NewLDAPConnectionPool(*url, authenticator.timeoutSecs,
+				rootCAs, maxIdleConnectionsPerServer))
+	}
 	authenticator.logger = logger
 	authenticator.expirationDuration = defaultCacheDuration
 	authenticator.storage = storage
@@ -40,6 +49,35 @@ func newAuthenticator(urllist []string, bindPattern []string,
 	return &authenticator, nil
 }
 
+func newUserSearchAuthenticator(urllist []string, userSearch UserSearch,
+	timeoutSecs uint, rootCAs *x509.CertPool,
+	storage simplestorage.SimpleStore, logger log.DebugLogger) (
+	*PasswordAuthenticator, error) {
+	if len(userSearch.BaseDNs) < 1 {
+		return nil, errors.New("no user search base DNs")
+	}
+	if !strings.Contains(userSearch.Filter, "%s") {
+		return nil, errors.New("user search filter must contain %s")
+	}
+	authenticator, err := newAuthenticator(urllist, nil, timeoutSecs, rootCAs,
+		storage, logger)
+	if err != nil {
+		return nil, err
+	}
+	authenticator.userSearch = &userSearch
+	return authenticator, nil
+}
+
+func (pa *PasswordAuthenticator) setReferralPolicy(
+	policy authutil.LDAPReferralPolicy) error {
+	for _, pool := range pa.connectionPools {
+		if err := pool.SetReferralPolicy(policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func convertToBindDN(username string, bind_pattern string) string {
 	return fmt.Sprintf(bind_pattern, username)
 }
@@ -79,27 +117,102 @@ func (pa *PasswordAuthenticator) updateOrDeletePasswordHash(valid bool, username
 	return nil
 }
 
-func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
-	password []byte) (valid bool, err error) {
-	valid = false
-	for _, u := range pa.ldapURL {
-		for _, bindPattern := range pa.bindPattern {
-			bindDN := convertToBindDN(username, bindPattern)
-			valid, err = authutil.CheckLDAPUserPassword(*u, bindDN, string(password), pa.timeoutSecs, pa.rootCAs)
-			if err != nil {
-				if pa.logger != nil {
-					pa.logger.Debugf(1, "Error checking LDAP user password url= %s", u)
-				}
-				continue
+// checkBackend checks the password against the i-th LDAP server. A nil error
+// means the answer is definitive.
+func (pa *PasswordAuthenticator) checkBackend(ctx context.Context, i int,
+	username string, password []byte) (bool, error) {
+	u := pa.ldapURL[i]
+	var bindDNs []string
+	if pa.userSearch != nil {
+		bindDN, err := pa.connectionPools[i].SearchUserDNContext(ctx,
+			pa.userSearch.BindDN, pa.userSearch.BindPassword,
+			pa.userSearch.BaseDNs, pa.userSearch.Filter, username)
+		if err != nil {
+			if pa.logger != nil {
+				pa.logger.Debugf(1, "Error searching LDAP user url= %s: %s", u, err)
 			}
-			err = pa.updateOrDeletePasswordHash(valid, username, password)
-			if err != nil && pa.logger != nil {
-				pa.logger.Debugf(0, "Updating local password hash for user %s", username)
+			return false, err
+		}
+		if bindDN == "" {
+			return false, nil
+		}
+		bindDNs = []string{bindDN}
+	} else {
+		for _, bindPattern := range pa.bindPattern {
+			bindDNs = append(bindDNs, convertToBindDN(username, bindPattern))
+		}
+	}
+	err := errors.New("no bind DN")
+	for _, bindDN := range bindDNs {
+		var valid bool
+		valid, err = pa.connectionPools[i].CheckUserPasswordContext(ctx,
+			bindDN, string(password))
+		if ctx.Err() != nil {
+			return false, err
+		}
+		if err != nil {
+			if pa.logger != nil {
+				pa.logger.Debugf(1, "Error checking LDAP user password url= %s", u)
 			}
-			return valid, nil
+			continue
+		}
+		return valid, nil
+	}
+	return false, err
+}
 
+// firstDefinitiveAnswer runs check for each of the numBackends backends
+// concurrently and returns the first answer without an error. ok is false if
+// all backends failed. Slower backends are left to finish in the background.
+func firstDefinitiveAnswer(numBackends int,
+	check func(i int) (bool, error)) (valid bool, ok bool) {
+	type result struct {
+		valid bool
+		err   error
+	}
+	results := make(chan result, numBackends)
+	for i := 0; i < numBackends; i++ {
+		go func(i int) {
+			valid, err := check(i)
+			results <- result{valid, err}
+		}(i)
+	}
+	for i := 0; i < numBackends; i++ {
+		r := <-results
+		if r.err == nil {
+			return r.valid, true
 		}
 	}
+	return false, false
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
+	password []byte) (bool, error) {
+	return pa.passwordAuthenticateContext(context.Background(), username,
+		password)
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticateContext(
+	ctx context.Context, username string, password []byte) (bool, error) {
+	valid, ok := firstDefinitiveAnswer(len(pa.ldapURL), func(i int) (bool, error) {
+		start := time.Now()
+		valid, err := pa.checkBackend(ctx, i, username, password)
+		if ctx.Err() == nil {
+			recordBackendResult(pa.ldapURL[i].Host, valid, err,
+				time.Since(start))
+		}
+		return valid, err
+	})
+	if ok {
+		err := pa.updateOrDeletePasswordHash(valid, username, password)
+		if err != nil && pa.logger != nil {
+			pa.logger.Debugf(0, "Updating local password hash for user %s", username)
+		}
+		return valid, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 	if pa.storage != nil {
 		if pa.logger != nil {
 			pa.logger.Printf("Failed to check password against LDAP servers, using local hash db")
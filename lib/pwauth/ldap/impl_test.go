@@ -3,6 +3,7 @@ package ldap
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"log"
 	"net"
 	//	"net/url"
@@ -320,3 +321,51 @@ func TestPasswordAuthetnicateCache(t *testing.T) {
 	}
 
 }
+
+func TestNewWithUserSearchValidation(t *testing.T) {
+	urls := []string{"ldaps://localhost:10636"}
+	userSearch := UserSearch{
+		BindDN:       "cn=svc,dc=example,dc=com",
+		BindPassword: "secret",
+		BaseDNs:      []string{"dc=example,dc=com"},
+		Filter:       "(sAMAccountName=%s)",
+	}
+	if _, err := NewWithUserSearch(urls, userSearch, 2, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	noBaseDNs := userSearch
+	noBaseDNs.BaseDNs = nil
+	if _, err := NewWithUserSearch(urls, noBaseDNs, 2, nil, nil, nil); err == nil {
+		t.Fatal("missing base DNs should fail")
+	}
+	badFilter := userSearch
+	badFilter.Filter = "(sAMAccountName=user)"
+	if _, err := NewWithUserSearch(urls, badFilter, 2, nil, nil, nil); err == nil {
+		t.Fatal("filter without username placeholder should fail")
+	}
+}
+
+func TestFirstDefinitiveAnswer(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	// A hung primary must not delay the answer from the secondary.
+	valid, ok := firstDefinitiveAnswer(3, func(i int) (bool, error) {
+		switch i {
+		case 0:
+			<-release
+			return false, nil
+		case 1:
+			return false, errors.New("connection refused")
+		}
+		return true, nil
+	})
+	if !ok || !valid {
+		t.Fatalf("expected answer from backend 2, got %v, %v", valid, ok)
+	}
+	valid, ok = firstDefinitiveAnswer(2, func(i int) (bool, error) {
+		return true, errors.New("down")
+	})
+	if ok || valid {
+		t.Fatal("all backends failed, expected no answer")
+	}
+}
@@ -0,0 +1,55 @@
+package ldap
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	backendRequestCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "keymaster_ldap_backend_requests_total",
+			Help: "LDAP password checks by server and result.",
+		},
+		[]string{"server", "result"},
+	)
+	backendRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "keymaster_ldap_backend_request_duration_seconds",
+			Help:    "Time taken by each LDAP server to answer a password check.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+		},
+		[]string{"server"},
+	)
+	backendUpGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "keymaster_ldap_backend_up",
+			Help: "1 if the last password check against the LDAP server got an answer.",
+		},
+		[]string{"server"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(backendRequestCounter)
+	prometheus.MustRegister(backendRequestDuration)
+	prometheus.MustRegister(backendUpGauge)
+}
+
+func recordBackendResult(server string, valid bool, err error,
+	duration time.Duration) {
+	result := "invalid"
+	if err != nil {
+		result = "error"
+		backendUpGauge.WithLabelValues(server).Set(0)
+	} else {
+		if valid {
+			result = "valid"
+		}
+		backendUpGauge.WithLabelValues(server).Set(1)
+		backendRequestDuration.WithLabelValues(server).Observe(
+			duration.Seconds())
+	}
+	backendRequestCounter.WithLabelValues(server, result).Inc()
+}
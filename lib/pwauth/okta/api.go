@@ -1,6 +1,8 @@
 package okta
 
 import (
+	"context"
+
 	"github.com/Symantec/Dominator/lib/log"
 	"github.com/Symantec/keymaster/lib/simplestorage"
 )
@@ -28,6 +30,13 @@ func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
 	return pa.passwordAuthenticate(username, password)
 }
 
+// PasswordAuthenticateContext is PasswordAuthenticate but abandons the
+// request to Okta once ctx is done.
+func (pa *PasswordAuthenticator) PasswordAuthenticateContext(
+	ctx context.Context, username string, password []byte) (bool, error) {
+	return pa.passwordAuthenticateContext(ctx, username, password)
+}
+
 func (pa *PasswordAuthenticator) UpdateStorage(storage simplestorage.SimpleStore) error {
 	return nil
 }
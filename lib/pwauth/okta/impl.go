@@ -2,6 +2,7 @@ package okta
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/Symantec/Dominator/lib/log"
@@ -32,6 +33,12 @@ func newPublicAuthenticator(oktaDomain string, logger log.Logger) (
 
 func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
 	password []byte) (bool, error) {
+	return pa.passwordAuthenticateContext(context.Background(), username,
+		password)
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticateContext(
+	ctx context.Context, username string, password []byte) (bool, error) {
 	loginData := loginDataType{Password: string(password), Username: username}
 	body := &bytes.Buffer{}
 	encoder := json.NewEncoder(body)
@@ -43,6 +50,7 @@ func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
 	if err != nil {
 		return false, err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
 	resp, err := http.DefaultClient.Do(req)
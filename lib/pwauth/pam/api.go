@@ -0,0 +1,34 @@
+package pam
+
+import (
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/simplestorage"
+)
+
+type PasswordAuthenticator struct {
+	serviceName string
+	logger      log.Logger
+}
+
+// New creates a new PasswordAuthenticator using the PAM stack of the local
+// system as the backend. The PAM configuration to use is given by
+// serviceName (e.g. /etc/pam.d/keymaster for "keymaster").
+// PAM support requires cgo and building with the "pam" build tag, otherwise
+// an error is returned.
+// Log messages are written to logger. A new *PasswordAuthenticator is returned.
+func New(serviceName string, logger log.Logger) (*PasswordAuthenticator, error) {
+	return newAuthenticator(serviceName, logger)
+}
+
+// PasswordAuthenticate will authenticate a user using the provided username and
+// password.
+// It returns true if the user is authenticated, else false (due to either
+// invalid username or incorrect password), and an error.
+func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	return pa.passwordAuthenticate(username, password)
+}
+
+func (pa *PasswordAuthenticator) UpdateStorage(storage simplestorage.SimpleStore) error {
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !pam || !cgo
+// +build !pam !cgo
+
+package pam
+
+import (
+	"errors"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+func newAuthenticator(serviceName string, logger log.Logger) (
+	*PasswordAuthenticator, error) {
+	return nil, errors.New("PAM support not compiled in, build with -tags pam")
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
+	password []byte) (bool, error) {
+	return false, errors.New("PAM support not compiled in")
+}
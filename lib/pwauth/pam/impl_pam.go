@@ -0,0 +1,129 @@
+//go:build pam && cgo
+// +build pam,cgo
+
+package pam
+
+/*
+#cgo LDFLAGS: -lpam
+#include <security/pam_appl.h>
+#include <stdlib.h>
+#include <string.h>
+
+struct credentials {
+	const char *username;
+	const char *password;
+};
+
+// Answer password prompts with the password and other prompts (e.g. a login
+// prompt) with the username. Anything else, such as a request for a new
+// password, fails the conversation.
+static int conversation(int num_msg, const struct pam_message **msg,
+		struct pam_response **resp, void *appdata_ptr) {
+	struct credentials *creds = appdata_ptr;
+	struct pam_response *responses;
+	int i;
+
+	if (num_msg <= 0 || num_msg > PAM_MAX_NUM_MSG)
+		return PAM_CONV_ERR;
+	responses = calloc(num_msg, sizeof(struct pam_response));
+	if (responses == NULL)
+		return PAM_BUF_ERR;
+	for (i = 0; i < num_msg; i++) {
+		const char *answer;
+
+		switch (msg[i]->msg_style) {
+		case PAM_PROMPT_ECHO_OFF:
+			answer = creds->password;
+			break;
+		case PAM_PROMPT_ECHO_ON:
+			answer = creds->username;
+			break;
+		case PAM_ERROR_MSG:
+		case PAM_TEXT_INFO:
+			continue;
+		default:
+			goto fail;
+		}
+		responses[i].resp = strdup(answer);
+		if (responses[i].resp == NULL)
+			goto fail;
+	}
+	*resp = responses;
+	return PAM_SUCCESS;
+fail:
+	for (i = 0; i < num_msg; i++)
+		free(responses[i].resp);
+	free(responses);
+	return PAM_CONV_ERR;
+}
+
+static int authenticate(const char *service, const char *username,
+		const char *password) {
+	struct credentials creds = {username, password};
+	struct pam_conv conv = {conversation, &creds};
+	pam_handle_t *handle = NULL;
+	int ret;
+
+	ret = pam_start(service, username, &conv, &handle);
+	if (ret != PAM_SUCCESS)
+		return ret;
+	ret = pam_authenticate(handle, PAM_SILENT | PAM_DISALLOW_NULL_AUTHTOK);
+	if (ret == PAM_SUCCESS)
+		ret = pam_acct_mgmt(handle, PAM_SILENT | PAM_DISALLOW_NULL_AUTHTOK);
+	pam_end(handle, ret);
+	return ret;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+func newAuthenticator(serviceName string, logger log.Logger) (
+	*PasswordAuthenticator, error) {
+	if serviceName == "" {
+		return nil, errors.New("no PAM service name specified")
+	}
+	return &PasswordAuthenticator{
+		serviceName: serviceName,
+		logger:      logger,
+	}, nil
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
+	password []byte) (bool, error) {
+	if len(password) < 1 {
+		return false, nil
+	}
+	cService := C.CString(pa.serviceName)
+	defer C.free(unsafe.Pointer(cService))
+	cUsername := C.CString(username)
+	defer C.free(unsafe.Pointer(cUsername))
+	// Copy the password by hand so that it can be wiped afterwards.
+	cPassword := (*C.char)(C.malloc(C.size_t(len(password) + 1)))
+	passwordBuffer := (*[1 << 30]byte)(unsafe.Pointer(cPassword))[: len(password)+1 : len(password)+1]
+	copy(passwordBuffer, password)
+	passwordBuffer[len(password)] = 0
+	defer func() {
+		for i := range passwordBuffer {
+			passwordBuffer[i] = 0
+		}
+		C.free(unsafe.Pointer(cPassword))
+	}()
+	switch ret := C.authenticate(cService, cUsername, cPassword); ret {
+	case C.PAM_SUCCESS:
+		return true, nil
+	case C.PAM_AUTH_ERR, C.PAM_USER_UNKNOWN, C.PAM_MAXTRIES,
+		C.PAM_CRED_INSUFFICIENT, C.PAM_ACCT_EXPIRED, C.PAM_PERM_DENIED,
+		C.PAM_NEW_AUTHTOK_REQD:
+		return false, nil
+	default:
+		return false, fmt.Errorf("PAM error: %s",
+			C.GoString(C.pam_strerror(nil, ret)))
+	}
+}
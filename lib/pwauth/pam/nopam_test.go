@@ -0,0 +1,16 @@
+//go:build !pam || !cgo
+// +build !pam !cgo
+
+package pam
+
+import (
+	"testing"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+)
+
+func TestNewWithoutPAMSupport(t *testing.T) {
+	if _, err := New("keymaster", testlogger.New(t)); err == nil {
+		t.Fatal("New should fail when PAM support is not compiled in")
+	}
+}
@@ -0,0 +1,42 @@
+package radius
+
+import (
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/simplestorage"
+)
+
+type PasswordAuthenticator struct {
+	servers       []string
+	secret        []byte
+	method        string
+	nasIdentifier string
+	timeout       time.Duration
+	logger        log.Logger
+}
+
+// New creates a new PasswordAuthenticator using RADIUS as the backend.
+// The servers (host[:port]) are tried in order until one answers. The
+// shared secret is given by secret, and method is either "pap" or "chap"
+// (the default is "pap"). nasIdentifier, if not empty, is sent as the
+// NAS-Identifier attribute. timeoutSecs is the per server response timeout.
+// Log messages are written to logger. A new *PasswordAuthenticator is returned.
+func New(servers []string, secret []byte, method string, nasIdentifier string,
+	timeoutSecs uint, logger log.Logger) (*PasswordAuthenticator, error) {
+	return newAuthenticator(servers, secret, method, nasIdentifier,
+		timeoutSecs, logger)
+}
+
+// PasswordAuthenticate will authenticate a user using the provided username and
+// password.
+// It returns true if the user is authenticated, else false (due to either
+// invalid username or incorrect password), and an error.
+func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	return pa.passwordAuthenticate(username, password)
+}
+
+func (pa *PasswordAuthenticator) UpdateStorage(storage simplestorage.SimpleStore) error {
+	return nil
+}
@@ -0,0 +1,66 @@
+package radius
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/authutil"
+)
+
+const (
+	defaultTimeoutSecs = 3
+	retriesPerServer   = 1
+)
+
+func newAuthenticator(servers []string, secret []byte, method string,
+	nasIdentifier string, timeoutSecs uint, logger log.Logger) (
+	*PasswordAuthenticator, error) {
+	if len(servers) < 1 {
+		return nil, errors.New("no RADIUS servers specified")
+	}
+	if len(secret) < 1 {
+		return nil, errors.New("empty RADIUS shared secret")
+	}
+	switch method {
+	case "":
+		method = authutil.RADIUSAuthPAP
+	case authutil.RADIUSAuthPAP, authutil.RADIUSAuthCHAP:
+	default:
+		return nil, fmt.Errorf("unknown RADIUS auth method: %s", method)
+	}
+	if timeoutSecs < 1 {
+		timeoutSecs = defaultTimeoutSecs
+	}
+	return &PasswordAuthenticator{
+		servers:       servers,
+		secret:        secret,
+		method:        method,
+		nasIdentifier: nasIdentifier,
+		timeout:       time.Duration(timeoutSecs) * time.Second,
+		logger:        logger,
+	}, nil
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
+	password []byte) (bool, error) {
+	var lastErr error
+	for _, server := range pa.servers {
+		valid, err := authutil.CheckRADIUSUserPassword(authutil.RADIUSRequest{
+			Server:        server,
+			Secret:        pa.secret,
+			Method:        pa.method,
+			NASIdentifier: pa.nasIdentifier,
+			Timeout:       pa.timeout,
+			Retries:       retriesPerServer,
+		}, username, string(password))
+		if err != nil {
+			pa.logger.Printf("RADIUS server %s: %s", server, err)
+			lastErr = err
+			continue
+		}
+		return valid, nil
+	}
+	return false, lastErr
+}
@@ -0,0 +1,40 @@
+package radius
+
+import (
+	"testing"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+)
+
+func TestNewValidation(t *testing.T) {
+	logger := testlogger.New(t)
+	if _, err := New(nil, []byte("secret"), "", "", 0, logger); err == nil {
+		t.Fatal("no servers should fail")
+	}
+	if _, err := New([]string{"localhost"}, nil, "", "", 0, logger); err == nil {
+		t.Fatal("empty secret should fail")
+	}
+	if _, err := New([]string{"localhost"}, []byte("secret"), "mschap", "",
+		0, logger); err == nil {
+		t.Fatal("unknown method should fail")
+	}
+	pa, err := New([]string{"localhost"}, []byte("secret"), "", "", 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pa.method != "pap" || pa.timeout == 0 {
+		t.Fatalf("bad defaults: %+v", pa)
+	}
+}
+
+func TestPasswordAuthenticateUnreachable(t *testing.T) {
+	pa, err := New([]string{"127.0.0.1:1"}, []byte("secret"), "", "", 1,
+		testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, _ := pa.PasswordAuthenticate("user", []byte("password"))
+	if valid {
+		t.Fatal("unreachable server should not authenticate")
+	}
+}
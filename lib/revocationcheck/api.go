@@ -0,0 +1,99 @@
+// Package revocationcheck lets relying parties, such as bastions and
+// internal proxies, check whether certificates issued by keymaster were
+// revoked.
+//
+// A Checker fetches the KRL which keymasterd publishes at
+// /public/revoked.krl and verifies its detached signature by the CA key.
+// It can also fetch an X.509 CRL and verify it against the CA certificate.
+// Lists are cached and fetched again when a check finds them older than the
+// refresh interval. Checks fail once the lists could not be refreshed for
+// longer than the maximum age, so that a blocked feed does not hide
+// revocations forever.
+package revocationcheck
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/krl"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrStale is returned by checks when the revocation list is older than
+// Config.MaxAge.
+var ErrStale = errors.New("revocationcheck: revocation list is stale")
+
+// Defaults for Config.
+const (
+	DefaultRefreshInterval = 5 * time.Minute
+	DefaultMaxAge          = time.Hour
+)
+
+type Config struct {
+	// KRLURL is the URL of the KRL, for example
+	// https://keymaster.example.com/public/revoked.krl. The signature is
+	// fetched from KRLURL + ".sig".
+	KRLURL string
+	// SSHCAKeys are the keymaster CA keys. The KRL must be signed by one of
+	// them and checked certificates must be signed by one of them.
+	SSHCAKeys []ssh.PublicKey
+	// CRLURL is the URL of an X.509 CRL, in DER or PEM form, signed by
+	// X509CA.
+	CRLURL string
+	X509CA *x509.Certificate
+	// RefreshInterval defaults to DefaultRefreshInterval.
+	RefreshInterval time.Duration
+	// MaxAge defaults to DefaultMaxAge.
+	MaxAge time.Duration
+	// If set, verified lists are kept in CacheDirectory and loaded by New,
+	// so that checks work after a restart while keymaster is unreachable.
+	CacheDirectory string
+	// HTTPClient defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+}
+
+// Checker is safe for concurrent use.
+type Checker struct {
+	config      Config
+	now         func() time.Time
+	mutex       sync.Mutex
+	krl         *krl.KRL
+	krlFetched  time.Time
+	crl         *x509.RevocationList
+	crlFetched  time.Time
+	refreshing  bool
+	nextAttempt time.Time
+	lastErr     error
+}
+
+// New returns a Checker for the feeds in config. Nothing is fetched until
+// the first check or call to Refresh.
+func New(config Config) (*Checker, error) {
+	return newChecker(config, time.Now)
+}
+
+// Refresh fetches and verifies the lists now.
+func (c *Checker) Refresh() error {
+	return c.refresh()
+}
+
+// IsSSHCertRevoked returns true if cert is on the KRL. It returns an error
+// if cert is not signed by one of the CA keys or no recent KRL is available.
+func (c *Checker) IsSSHCertRevoked(cert *ssh.Certificate) (bool, error) {
+	return c.isSSHCertRevoked(cert)
+}
+
+// IsX509CertRevoked returns true if cert is on the CRL. It returns an error
+// if cert is not issued by the CA or no recent CRL is available.
+func (c *Checker) IsX509CertRevoked(cert *x509.Certificate) (bool, error) {
+	return c.isX509CertRevoked(cert)
+}
+
+// KRLVersion returns the version of the KRL in use, for example for
+// keymaster trust reports. It is zero if no KRL was loaded.
+func (c *Checker) KRLVersion() uint64 {
+	return c.krlVersion()
+}
@@ -0,0 +1,328 @@
+package revocationcheck
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/krl"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	krlCacheName          = "revoked.krl"
+	krlSignatureCacheName = "revoked.krl.sig"
+	crlCacheName          = "revoked.crl"
+
+	signatureSuffix  = ".sig"
+	maxFeedSize      = 1 << 24
+	maxRetryInterval = 30 * time.Second
+	httpTimeout      = 10 * time.Second
+)
+
+func newChecker(config Config, now func() time.Time) (*Checker, error) {
+	if config.KRLURL == "" && config.CRLURL == "" {
+		return nil, errors.New("revocationcheck: no KRL or CRL URL")
+	}
+	if config.KRLURL != "" && len(config.SSHCAKeys) < 1 {
+		return nil, errors.New("revocationcheck: KRL without SSH CA keys")
+	}
+	if config.CRLURL != "" && config.X509CA == nil {
+		return nil, errors.New("revocationcheck: CRL without X.509 CA")
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = DefaultRefreshInterval
+	}
+	if config.MaxAge <= 0 {
+		config.MaxAge = DefaultMaxAge
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: httpTimeout}
+	}
+	c := &Checker{config: config, now: now}
+	c.loadCache()
+	return c, nil
+}
+
+// readCache returns the content and modification time of a cached list.
+func (c *Checker) readCache(name string) ([]byte, time.Time, error) {
+	filename := filepath.Join(c.config.CacheDirectory, name)
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	return data, info.ModTime(), err
+}
+
+// writeCache is best effort: a list which cannot be cached is still used.
+func (c *Checker) writeCache(name string, data []byte, fetched time.Time) {
+	if c.config.CacheDirectory == "" {
+		return
+	}
+	filename := filepath.Join(c.config.CacheDirectory, name)
+	tmpFilename := filename + "~"
+	if err := ioutil.WriteFile(tmpFilename, data, 0644); err != nil {
+		return
+	}
+	os.Chtimes(tmpFilename, fetched, fetched)
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		os.Remove(tmpFilename)
+	}
+}
+
+// loadCache loads the cached lists which verify. Their age is the time they
+// were fetched.
+func (c *Checker) loadCache() {
+	if c.config.CacheDirectory == "" {
+		return
+	}
+	if c.config.KRLURL != "" {
+		data, fetched, err := c.readCache(krlCacheName)
+		if err == nil {
+			signature, _, err := c.readCache(krlSignatureCacheName)
+			if err == nil {
+				if revocations, err := c.verifyKRL(data, signature); err == nil {
+					c.krl, c.krlFetched = revocations, fetched
+				}
+			}
+		}
+	}
+	if c.config.CRLURL != "" {
+		data, fetched, err := c.readCache(crlCacheName)
+		if err == nil {
+			if crl, err := c.verifyCRL(data); err == nil {
+				c.crl, c.crlFetched = crl, fetched
+			}
+		}
+	}
+}
+
+func (c *Checker) fetch(url string) ([]byte, error) {
+	resp, err := c.config.HTTPClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("revocationcheck: %s: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFeedSize {
+		return nil, fmt.Errorf("revocationcheck: %s: too large", url)
+	}
+	return data, nil
+}
+
+func (c *Checker) verifyKRL(data, signature []byte) (*krl.KRL, error) {
+	if err := krl.Verify(c.config.SSHCAKeys, data, signature); err != nil {
+		return nil, err
+	}
+	return krl.Parse(data)
+}
+
+func (c *Checker) verifyCRL(data []byte) (*x509.RevocationList, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := crl.CheckSignatureFrom(c.config.X509CA); err != nil {
+		return nil, fmt.Errorf("revocationcheck: CRL signature: %s", err)
+	}
+	return crl, nil
+}
+
+func (c *Checker) refreshKRL() error {
+	var data, signature []byte
+	var revocations *krl.KRL
+	var err error
+	// The KRL may change between the two fetches.
+	for attempt := 0; attempt < 2; attempt++ {
+		data, err = c.fetch(c.config.KRLURL)
+		if err != nil {
+			return err
+		}
+		signature, err = c.fetch(c.config.KRLURL + signatureSuffix)
+		if err != nil {
+			return err
+		}
+		revocations, err = c.verifyKRL(data, signature)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fetched := c.now()
+	c.mutex.Lock()
+	if c.krl != nil && revocations.Version < c.krl.Version {
+		current := c.krl.Version
+		c.mutex.Unlock()
+		return fmt.Errorf("revocationcheck: KRL version %d is older than %d",
+			revocations.Version, current)
+	}
+	c.krl, c.krlFetched = revocations, fetched
+	c.mutex.Unlock()
+	c.writeCache(krlCacheName, data, fetched)
+	c.writeCache(krlSignatureCacheName, signature, fetched)
+	return nil
+}
+
+func (c *Checker) refreshCRL() error {
+	data, err := c.fetch(c.config.CRLURL)
+	if err != nil {
+		return err
+	}
+	crl, err := c.verifyCRL(data)
+	if err != nil {
+		return err
+	}
+	fetched := c.now()
+	c.mutex.Lock()
+	if c.crl != nil && c.crl.Number != nil && crl.Number != nil &&
+		crl.Number.Cmp(c.crl.Number) < 0 {
+		current := c.crl.Number
+		c.mutex.Unlock()
+		return fmt.Errorf("revocationcheck: CRL number %s is older than %s",
+			crl.Number, current)
+	}
+	c.crl, c.crlFetched = crl, fetched
+	c.mutex.Unlock()
+	c.writeCache(crlCacheName, data, fetched)
+	return nil
+}
+
+func (c *Checker) refresh() error {
+	var errs []string
+	if c.config.KRLURL != "" {
+		if err := c.refreshKRL(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if c.config.CRLURL != "" {
+		if err := c.refreshCRL(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	var err error
+	if len(errs) > 0 {
+		err = errors.New(strings.Join(errs, "; "))
+	}
+	c.mutex.Lock()
+	c.lastErr = err
+	c.mutex.Unlock()
+	return err
+}
+
+// maybeRefresh refreshes the lists if they are due. Other checks use the
+// lists loaded while a refresh is in progress.
+func (c *Checker) maybeRefresh() {
+	now := c.now()
+	c.mutex.Lock()
+	if c.refreshing || now.Before(c.nextAttempt) {
+		c.mutex.Unlock()
+		return
+	}
+	c.refreshing = true
+	c.mutex.Unlock()
+	err := c.refresh()
+	c.mutex.Lock()
+	c.refreshing = false
+	if err != nil {
+		retryInterval := c.config.RefreshInterval
+		if retryInterval > maxRetryInterval {
+			retryInterval = maxRetryInterval
+		}
+		c.nextAttempt = now.Add(retryInterval)
+	} else {
+		c.nextAttempt = now.Add(c.config.RefreshInterval)
+	}
+	c.mutex.Unlock()
+}
+
+// checkFresh must be called with the mutex held.
+func (c *Checker) checkFresh(loaded bool, fetched time.Time) error {
+	if !loaded {
+		if c.lastErr != nil {
+			return fmt.Errorf("revocationcheck: no revocation list: %s",
+				c.lastErr)
+		}
+		return errors.New("revocationcheck: no revocation list")
+	}
+	if c.now().Sub(fetched) > c.config.MaxAge {
+		return ErrStale
+	}
+	return nil
+}
+
+func (c *Checker) isSSHCertRevoked(cert *ssh.Certificate) (bool, error) {
+	if c.config.KRLURL == "" {
+		return false, errors.New("revocationcheck: no KRL configured")
+	}
+	trusted := false
+	for _, caKey := range c.config.SSHCAKeys {
+		if cert.SignatureKey != nil &&
+			bytes.Equal(caKey.Marshal(), cert.SignatureKey.Marshal()) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return false, errors.New(
+			"revocationcheck: certificate not signed by a keymaster CA")
+	}
+	c.maybeRefresh()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.checkFresh(c.krl != nil, c.krlFetched); err != nil {
+		return false, err
+	}
+	return c.krl.IsRevoked(cert), nil
+}
+
+func (c *Checker) isX509CertRevoked(cert *x509.Certificate) (bool, error) {
+	if c.config.CRLURL == "" {
+		return false, errors.New("revocationcheck: no CRL configured")
+	}
+	if !bytes.Equal(cert.RawIssuer, c.config.X509CA.RawSubject) {
+		return false, errors.New(
+			"revocationcheck: certificate not issued by the keymaster CA")
+	}
+	c.maybeRefresh()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.checkFresh(c.crl != nil, c.crlFetched); err != nil {
+		return false, err
+	}
+	for _, entry := range c.crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *Checker) krlVersion() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.krl == nil {
+		return 0
+	}
+	return c.krl.Version
+}
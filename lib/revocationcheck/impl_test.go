@@ -0,0 +1,267 @@
+package revocationcheck
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/krl"
+	"golang.org/x/crypto/ssh"
+)
+
+type testFeed struct {
+	mutex     sync.Mutex
+	caKey     ed25519.PrivateKey
+	sshCAKey  ssh.PublicKey
+	x509CA    *x509.Certificate
+	krl       []byte
+	signature []byte
+	crl       []byte
+	down      bool
+}
+
+func newTestFeed(t *testing.T) *testFeed {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshCAKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "keymaster"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, public,
+		private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x509CA, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testFeed{caKey: private, sshCAKey: sshCAKey, x509CA: x509CA}
+}
+
+func (f *testFeed) publish(t *testing.T, version uint64, serials ...uint64) {
+	data := (&krl.KRL{
+		Version: version,
+		Sections: []krl.CertificateSection{
+			{CAKey: f.sshCAKey, Serials: serials},
+		},
+	}).Marshal()
+	signature, err := krl.Sign(f.caKey, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []x509.RevocationListEntry
+	for _, serial := range serials {
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   new(big.Int).SetUint64(serial),
+			RevocationTime: time.Now(),
+		})
+	}
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    new(big.Int).SetUint64(version),
+		ThisUpdate:                time.Now(),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: entries,
+	}, f.x509CA, f.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.krl, f.signature, f.crl = data, signature, crl
+}
+
+func (f *testFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	switch r.URL.Path {
+	case "/public/revoked.krl":
+		w.Write(f.krl)
+	case "/public/revoked.krl.sig":
+		w.Write(f.signature)
+	case "/revoked.crl":
+		w.Write(f.crl)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *testFeed) setDown(down bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.down = down
+}
+
+type testClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *testClock) advance(duration time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(duration)
+}
+
+func newTestChecker(t *testing.T, feed *testFeed, url string,
+	cacheDirectory string, clock *testClock) *Checker {
+	checker, err := newChecker(Config{
+		KRLURL:         url + "/public/revoked.krl",
+		SSHCAKeys:      []ssh.PublicKey{feed.sshCAKey},
+		CRLURL:         url + "/revoked.crl",
+		X509CA:         feed.x509CA,
+		CacheDirectory: cacheDirectory,
+	}, clock.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return checker
+}
+
+func checkSSHRevoked(t *testing.T, checker *Checker, cert *ssh.Certificate,
+	expected bool) {
+	revoked, err := checker.IsSSHCertRevoked(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked != expected {
+		t.Errorf("serial %d: revoked is %v", cert.Serial, revoked)
+	}
+}
+
+func TestChecker(t *testing.T) {
+	feed := newTestFeed(t)
+	feed.publish(t, 1, 42)
+	server := httptest.NewServer(feed)
+	defer server.Close()
+	clock := &testClock{now: time.Now()}
+	checker := newTestChecker(t, feed, server.URL, "", clock)
+	checkSSHRevoked(t, checker,
+		&ssh.Certificate{SignatureKey: feed.sshCAKey, Serial: 42}, true)
+	checkSSHRevoked(t, checker,
+		&ssh.Certificate{SignatureKey: feed.sshCAKey, Serial: 43}, false)
+	if checker.KRLVersion() != 1 {
+		t.Errorf("KRL version is %d", checker.KRLVersion())
+	}
+	otherCA := newTestFeed(t).sshCAKey
+	_, err := checker.IsSSHCertRevoked(
+		&ssh.Certificate{SignatureKey: otherCA, Serial: 42})
+	if err == nil {
+		t.Error("certificate of another CA checked")
+	}
+	x509Cert := &x509.Certificate{RawIssuer: feed.x509CA.RawSubject,
+		SerialNumber: big.NewInt(42)}
+	if revoked, err := checker.IsX509CertRevoked(x509Cert); err != nil {
+		t.Fatal(err)
+	} else if !revoked {
+		t.Error("X.509 certificate not revoked")
+	}
+
+	// New revocations are picked up after the refresh interval.
+	feed.publish(t, 2, 42, 43)
+	checkSSHRevoked(t, checker,
+		&ssh.Certificate{SignatureKey: feed.sshCAKey, Serial: 43}, false)
+	clock.advance(DefaultRefreshInterval + time.Second)
+	checkSSHRevoked(t, checker,
+		&ssh.Certificate{SignatureKey: feed.sshCAKey, Serial: 43}, true)
+
+	// Older versions are refused.
+	feed.publish(t, 1, 42)
+	if err := checker.Refresh(); err == nil {
+		t.Error("KRL rollback accepted")
+	}
+
+	// Lists are used until they are too old.
+	feed.setDown(true)
+	clock.advance(DefaultMaxAge - time.Minute)
+	checkSSHRevoked(t, checker,
+		&ssh.Certificate{SignatureKey: feed.sshCAKey, Serial: 43}, true)
+	clock.advance(2 * time.Minute)
+	_, err = checker.IsSSHCertRevoked(
+		&ssh.Certificate{SignatureKey: feed.sshCAKey, Serial: 43})
+	if err != ErrStale {
+		t.Errorf("expected ErrStale, got: %v", err)
+	}
+}
+
+func TestCheckerRejectsBadSignature(t *testing.T) {
+	feed := newTestFeed(t)
+	feed.publish(t, 1, 42)
+	forger := newTestFeed(t)
+	forger.publish(t, 2)
+	feed.krl = forger.krl
+	server := httptest.NewServer(feed)
+	defer server.Close()
+	checker := newTestChecker(t, feed, server.URL, "",
+		&testClock{now: time.Now()})
+	_, err := checker.IsSSHCertRevoked(
+		&ssh.Certificate{SignatureKey: feed.sshCAKey, Serial: 42})
+	if err == nil {
+		t.Error("unsigned KRL accepted")
+	}
+}
+
+func TestCheckerCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revocationcheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	feed := newTestFeed(t)
+	feed.publish(t, 1, 42)
+	server := httptest.NewServer(feed)
+	defer server.Close()
+	clock := &testClock{now: time.Now()}
+	if err := newTestChecker(t, feed, server.URL, dir, clock).Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	feed.setDown(true)
+	checker := newTestChecker(t, feed, server.URL, dir, clock)
+	checkSSHRevoked(t, checker,
+		&ssh.Certificate{SignatureKey: feed.sshCAKey, Serial: 42}, true)
+	if checker.KRLVersion() != 1 {
+		t.Errorf("KRL version is %d", checker.KRLVersion())
+	}
+}
+
+func TestNewCheckerConfig(t *testing.T) {
+	for name, config := range map[string]Config{
+		"empty":          {},
+		"KRL without CA": {KRLURL: "https://keymaster/public/revoked.krl"},
+		"CRL without CA": {CRLURL: "https://keymaster/revoked.crl"},
+	} {
+		if _, err := New(config); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// Package sshsig signs and verifies messages in the armored format of
+// "ssh-keygen -Y sign" (see PROTOCOL.sshsig in OpenSSH), so that the holder
+// of an SSH key can prove they have its private key:
+//
+//	ssh-keygen -Y sign -n <namespace> -f ~/.ssh/id_ed25519 < message
+package sshsig
+
+import (
+	"golang.org/x/crypto/ssh"
+)
+
+// Sign returns the armored signature of message by signer in namespace,
+// hashed with SHA-512.
+func Sign(signer ssh.Signer, namespace string, message []byte) ([]byte,
+	error) {
+	return sign(signer, namespace, message)
+}
+
+// Verify returns the public key which made the armored signature of message
+// in namespace. It returns an error if the signature is not valid.
+func Verify(armored []byte, namespace string, message []byte) (
+	ssh.PublicKey, error) {
+	return verify(armored, namespace, message)
+}
+
+// Split returns the armored signatures in data, which may be separated by
+// other text.
+func Split(data []byte) [][]byte {
+	return split(data)
+}
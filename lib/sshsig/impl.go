@@ -0,0 +1,172 @@
+package sshsig
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	magicPreamble = "SSHSIG"
+	sigVersion    = 1
+	beginArmor    = "-----BEGIN SSH SIGNATURE-----"
+	endArmor      = "-----END SSH SIGNATURE-----"
+	lineLength    = 70
+)
+
+type signatureBlob struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+type signedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha512":
+		return sha512.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("sshsig: unsupported hash: %s", algorithm)
+}
+
+func dataToSign(namespace, hashAlgorithm string, message []byte) (
+	[]byte, error) {
+	h, err := newHash(hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(message)
+	return append([]byte(magicPreamble), ssh.Marshal(signedData{
+		Namespace:     namespace,
+		HashAlgorithm: hashAlgorithm,
+		Hash:          h.Sum(nil),
+	})...), nil
+}
+
+func sign(signer ssh.Signer, namespace string, message []byte) ([]byte,
+	error) {
+	if namespace == "" {
+		return nil, errors.New("sshsig: empty namespace")
+	}
+	data, err := dataToSign(namespace, "sha512", message)
+	if err != nil {
+		return nil, err
+	}
+	var signature *ssh.Signature
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	if ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// SHA-1 RSA signatures are not accepted.
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, data,
+			ssh.KeyAlgoRSASHA512)
+	} else {
+		signature, err = signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	blob := append([]byte(magicPreamble), ssh.Marshal(signatureBlob{
+		Version:       sigVersion,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     namespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(signature),
+	})...)
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var buffer bytes.Buffer
+	buffer.WriteString(beginArmor + "\n")
+	for len(encoded) > lineLength {
+		buffer.WriteString(encoded[:lineLength] + "\n")
+		encoded = encoded[lineLength:]
+	}
+	buffer.WriteString(encoded + "\n" + endArmor + "\n")
+	return buffer.Bytes(), nil
+}
+
+func verify(armored []byte, namespace string, message []byte) (
+	ssh.PublicKey, error) {
+	text := strings.TrimSpace(string(armored))
+	if !strings.HasPrefix(text, beginArmor) ||
+		!strings.HasSuffix(text, endArmor) {
+		return nil, errors.New("sshsig: not an armored SSH signature")
+	}
+	text = strings.TrimSuffix(strings.TrimPrefix(text, beginArmor), endArmor)
+	blob, err := base64.StdEncoding.DecodeString(
+		strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return nil, fmt.Errorf("sshsig: %s", err)
+	}
+	if !bytes.HasPrefix(blob, []byte(magicPreamble)) {
+		return nil, errors.New("sshsig: bad preamble")
+	}
+	var sigBlob signatureBlob
+	if err := ssh.Unmarshal(blob[len(magicPreamble):], &sigBlob); err != nil {
+		return nil, fmt.Errorf("sshsig: %s", err)
+	}
+	if sigBlob.Version != sigVersion {
+		return nil, fmt.Errorf("sshsig: unsupported version: %d",
+			sigBlob.Version)
+	}
+	if sigBlob.Namespace != namespace {
+		return nil, fmt.Errorf("sshsig: signature for namespace %q",
+			sigBlob.Namespace)
+	}
+	pubKey, err := ssh.ParsePublicKey(sigBlob.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("sshsig: %s", err)
+	}
+	if _, ok := pubKey.(*ssh.Certificate); ok {
+		return nil, errors.New("sshsig: signatures by certificates are not supported")
+	}
+	var signature ssh.Signature
+	if err := ssh.Unmarshal(sigBlob.Signature, &signature); err != nil {
+		return nil, fmt.Errorf("sshsig: %s", err)
+	}
+	if signature.Format == ssh.KeyAlgoRSA {
+		return nil, errors.New("sshsig: SHA-1 RSA signatures are not accepted")
+	}
+	data, err := dataToSign(namespace, sigBlob.HashAlgorithm, message)
+	if err != nil {
+		return nil, err
+	}
+	if err := pubKey.Verify(data, &signature); err != nil {
+		return nil, fmt.Errorf("sshsig: %s", err)
+	}
+	return pubKey, nil
+}
+
+func split(data []byte) [][]byte {
+	var signatures [][]byte
+	text := string(data)
+	for {
+		start := strings.Index(text, beginArmor)
+		if start < 0 {
+			return signatures
+		}
+		end := strings.Index(text[start:], endArmor)
+		if end < 0 {
+			return signatures
+		}
+		end += start + len(endArmor)
+		signatures = append(signatures, []byte(text[start:end]))
+		text = text[end:]
+	}
+}
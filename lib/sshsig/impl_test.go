@@ -0,0 +1,100 @@
+package sshsig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Made with "ssh-keygen -Y sign -n keymaster-test" of "hello".
+const (
+	testPublicKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHn7uevMoU9IAKM4US6CQ5qqnzYH735F80SZSuZxTFal test"
+	testSignature = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgefu568yhT0gAozhRLoJDmqqfNg
+fvfkXzRJlK5nFMVqUAAAAOa2V5bWFzdGVyLXRlc3QAAAAAAAAABnNoYTUxMgAAAFMAAAAL
+c3NoLWVkMjU1MTkAAABAYyN0j7Gv1iEOcaj1gKQpVCPNaN9tS9aNb9Yf1CY350VgUivlRA
+BB4uZ8oKFLyvfohMLCd8O1CCsyVgL2MNZUDw==
+-----END SSH SIGNATURE-----
+`
+)
+
+func TestVerifySSHKeygenSignature(t *testing.T) {
+	expected, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := Verify([]byte(testSignature), "keymaster-test",
+		[]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pubKey.Marshal()) != string(expected.Marshal()) {
+		t.Fatal("wrong public key")
+	}
+	if _, err := Verify([]byte(testSignature), "other",
+		[]byte("hello")); err == nil {
+		t.Error("wrong namespace accepted")
+	}
+	if _, err := Verify([]byte(testSignature), "keymaster-test",
+		[]byte("hello!")); err == nil {
+		t.Error("wrong message accepted")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []interface{}{edKey, rsaKey} {
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, err := Sign(signer, "keymaster-test", []byte("message"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKey, err := Verify(signature, "keymaster-test", []byte("message"))
+		if err != nil {
+			t.Fatalf("%s: %s", signer.PublicKey().Type(), err)
+		}
+		if string(pubKey.Marshal()) != string(signer.PublicKey().Marshal()) {
+			t.Errorf("%s: wrong public key", signer.PublicKey().Type())
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := Sign(signer, "keymaster-test", []byte("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := "first:\n" + testSignature + "\nsecond:\n" + string(signature)
+	signatures := Split([]byte(data))
+	if len(signatures) != 2 {
+		t.Fatalf("got %d signatures", len(signatures))
+	}
+	if _, err := Verify(signatures[1], "keymaster-test",
+		[]byte("message")); err != nil {
+		t.Fatal(err)
+	}
+	if len(Split([]byte("no signatures"))) != 0 {
+		t.Error("signatures in plain text")
+	}
+}
@@ -1,5 +1,7 @@
 package proto
 
+import "time"
+
 const LoginPath = "/api/v0/login"
 
 const (
@@ -9,9 +11,62 @@ const (
 	AuthTypeSymantecVIP   = "SymantecVIP"
 	AuthTypeIPCertificate = "IPCertificate"
 	AuthTypeTOTP          = "TOTP"
+	AuthTypeDuo           = "Duo"
+	AuthTypeBackupCode    = "BackupCode"
 )
 
 type LoginResponse struct {
 	Message         string   `json:"message"`
 	CertAuthBackend []string `json:"auth_backend"`
 }
+
+const TrustReportPath = "/api/v0/trustReport"
+
+// TrustReport is sent by host agents to report the SSH CAs they currently
+// trust. CAFingerprints are the hex encoded SHA-256 of the SSH wire format of
+// each CA public key.
+type TrustReport struct {
+	Hostname       string   `json:"hostname"`
+	CAFingerprints []string `json:"ca_fingerprints"`
+	KRLVersion     uint64   `json:"krl_version"`
+}
+
+const StaticKeysReportPath = "/api/v0/staticKeysReport"
+
+// StaticKeysReport is sent by host agents to report the static SSH keys in
+// the authorized_keys files of the host, and how often they are still used
+// compared to SSH certificates. Login counts cover the logs the agent read.
+type StaticKeysReport struct {
+	Hostname          string      `json:"hostname"`
+	Keys              []StaticKey `json:"keys"`
+	CertificateLogins uint64      `json:"certificate_logins"`
+}
+
+// StaticKey is one line of an authorized_keys file. PublicKey is in
+// authorized_keys format without options or comment and Fingerprint is the
+// SHA256 fingerprint as logged by sshd, e.g. "SHA256:nThbg6kXUpJ...".
+type StaticKey struct {
+	Username    string     `json:"username"`
+	Filename    string     `json:"filename"`
+	PublicKey   string     `json:"public_key"`
+	Fingerprint string     `json:"fingerprint"`
+	Comment     string     `json:"comment,omitempty"`
+	Options     []string   `json:"options,omitempty"`
+	Logins      uint64     `json:"logins"`
+	LastLogin   *time.Time `json:"last_login,omitempty"`
+}
+
+// CertRequest is the JSON body of a POST to /certgen/<username>, an
+// alternative to a multipart upload of pubkeyfile. PublicKey and PublicKeys
+// hold SSH keys in authorized_keys format or PEM public keys for X.509
+// certificates. Type, Duration and AddGroups default to the query
+// parameters of the same name.
+type CertRequest struct {
+	PublicKey   string   `json:"public_key,omitempty"`
+	PublicKeys  []string `json:"public_keys,omitempty"`
+	Duration    string   `json:"duration,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	AddGroups   bool     `json:"add_groups,omitempty"`
+	Hostnames   []string `json:"hostnames,omitempty"`
+	IPAddresses []string `json:"ip_addresses,omitempty"`
+}
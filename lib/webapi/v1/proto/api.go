@@ -0,0 +1,76 @@
+// Package proto holds the paths and JSON messages of version 1 of the REST
+// API of keymasterd. The OpenAPI document at OpenAPIPath describes them;
+// fields and paths are only added within a version, never changed.
+package proto
+
+import "time"
+
+// OpenAPIPath serves the OpenAPI 3 document of the API, without
+// authentication.
+const OpenAPIPath = "/api/v1/openapi.json"
+
+// Error is the body of every failed response.
+type Error struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+const CertificatesPath = "/api/v1/certificates"
+
+// IssueCertificateRequest is POSTed to CertificatesPath, with the fields of
+// /certgen/ requests. Username is the user the certificate is for; issuing
+// for another user requires delegation. PublicKeys hold SSH keys in
+// authorized_keys format or PEM public keys for X.509 certificates.
+// Parameters are the other query parameters of /certgen/, such as
+// "restrictions" or "cluster".
+type IssueCertificateRequest struct {
+	Username    string            `json:"username"`
+	Type        string            `json:"type,omitempty"`
+	PublicKeys  []string          `json:"public_keys"`
+	Duration    string            `json:"duration,omitempty"`
+	AddGroups   bool              `json:"add_groups,omitempty"`
+	Hostnames   []string          `json:"hostnames,omitempty"`
+	IPAddresses []string          `json:"ip_addresses,omitempty"`
+	Parameters  map[string]string `json:"parameters,omitempty"`
+}
+
+// IssueCertificateResponse has the certificate when issued (status 200), or
+// the URL of the approval request when the certificate needs approval
+// (status 202).
+type IssueCertificateResponse struct {
+	Certificate string `json:"certificate,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	ApprovalURL string `json:"approval_url,omitempty"`
+}
+
+// RevokedCertificatesPath lists the revoked certificates with a GET, and
+// revokes one with a POST of a RevokeCertificateRequest. Both are for
+// admins with a client certificate of the admin CA only.
+const RevokedCertificatesPath = "/api/v1/revokedCertificates"
+
+type RevokeCertificateRequest struct {
+	Serial string `json:"serial"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// RevokeCertificateResponse reports whether the certificate was added to
+// the list, false if it was already revoked.
+type RevokeCertificateResponse struct {
+	Revoked bool `json:"revoked"`
+}
+
+type RevokedCertificate struct {
+	Serial string    `json:"serial"`
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+type ListRevokedCertificatesResponse struct {
+	Certificates []RevokedCertificate `json:"certificates"`
+}
+
+// LockoutsPath followed by a username clears the failed password and TOTP
+// checks of the user with a DELETE. For admins with a client certificate
+// of the admin CA only.
+const LockoutsPath = "/api/v1/lockouts/"
@@ -0,0 +1,821 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: keymaster.proto
+
+// The gRPC API of keymasterd, served with mutual TLS on grpc.address next
+// to the HTTP service. Clients authenticate with an IP restricted
+// certificate of an automation user, as for /certgen/ on the service port.
+//
+// Regenerate keymaster.pb.go and keymaster_grpc.pb.go after changes with:
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative keymaster.proto
+
+package keymasterpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type IssueCertificateRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The user to issue the certificate to. Default: the authenticated user.
+	Username string `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	// The type as for /certgen/: ssh (the default), x509, x509-kubernetes,
+	// kubeconfig, spiffe-svid or a host certificate profile.
+	Type       string   `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	PublicKeys []string `protobuf:"bytes,3,rep,name=public_keys,json=publicKeys,proto3" json:"public_keys,omitempty"`
+	// A Go duration, e.g. "1h". Default: the longest allowed.
+	Duration    string   `protobuf:"bytes,4,opt,name=duration,proto3" json:"duration,omitempty"`
+	AddGroups   bool     `protobuf:"varint,5,opt,name=add_groups,json=addGroups,proto3" json:"add_groups,omitempty"`
+	Hostnames   []string `protobuf:"bytes,6,rep,name=hostnames,proto3" json:"hostnames,omitempty"`
+	IpAddresses []string `protobuf:"bytes,7,rep,name=ip_addresses,json=ipAddresses,proto3" json:"ip_addresses,omitempty"`
+	// Parameters of some types, e.g. cluster and client_key for kubeconfig.
+	Parameters    map[string]string `protobuf:"bytes,8,rep,name=parameters,proto3" json:"parameters,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IssueCertificateRequest) Reset() {
+	*x = IssueCertificateRequest{}
+	mi := &file_keymaster_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IssueCertificateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IssueCertificateRequest) ProtoMessage() {}
+
+func (x *IssueCertificateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_keymaster_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IssueCertificateRequest.ProtoReflect.Descriptor instead.
+func (*IssueCertificateRequest) Descriptor() ([]byte, []int) {
+	return file_keymaster_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *IssueCertificateRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *IssueCertificateRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *IssueCertificateRequest) GetPublicKeys() []string {
+	if x != nil {
+		return x.PublicKeys
+	}
+	return nil
+}
+
+func (x *IssueCertificateRequest) GetDuration() string {
+	if x != nil {
+		return x.Duration
+	}
+	return ""
+}
+
+func (x *IssueCertificateRequest) GetAddGroups() bool {
+	if x != nil {
+		return x.AddGroups
+	}
+	return false
+}
+
+func (x *IssueCertificateRequest) GetHostnames() []string {
+	if x != nil {
+		return x.Hostnames
+	}
+	return nil
+}
+
+func (x *IssueCertificateRequest) GetIpAddresses() []string {
+	if x != nil {
+		return x.IpAddresses
+	}
+	return nil
+}
+
+func (x *IssueCertificateRequest) GetParameters() map[string]string {
+	if x != nil {
+		return x.Parameters
+	}
+	return nil
+}
+
+type IssueCertificateResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The certificate in the format /certgen/ returns for the type. Empty if
+	// the request awaits approval.
+	Certificate []byte `protobuf:"bytes,1,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	// The name /certgen/ suggests for the file, e.g. userCert.pem.
+	Filename string `protobuf:"bytes,2,opt,name=filename,proto3" json:"filename,omitempty"`
+	// The certificate request to be approved by a second person, after which
+	// the same request gets the certificate.
+	ApprovalUrl   string `protobuf:"bytes,3,opt,name=approval_url,json=approvalUrl,proto3" json:"approval_url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IssueCertificateResponse) Reset() {
+	*x = IssueCertificateResponse{}
+	mi := &file_keymaster_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IssueCertificateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IssueCertificateResponse) ProtoMessage() {}
+
+func (x *IssueCertificateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_keymaster_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IssueCertificateResponse.ProtoReflect.Descriptor instead.
+func (*IssueCertificateResponse) Descriptor() ([]byte, []int) {
+	return file_keymaster_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *IssueCertificateResponse) GetCertificate() []byte {
+	if x != nil {
+		return x.Certificate
+	}
+	return nil
+}
+
+func (x *IssueCertificateResponse) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *IssueCertificateResponse) GetApprovalUrl() string {
+	if x != nil {
+		return x.ApprovalUrl
+	}
+	return ""
+}
+
+type RevokeCertificateRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The serial in decimal.
+	Serial        string `protobuf:"bytes,1,opt,name=serial,proto3" json:"serial,omitempty"`
+	Reason        string `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RevokeCertificateRequest) Reset() {
+	*x = RevokeCertificateRequest{}
+	mi := &file_keymaster_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RevokeCertificateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RevokeCertificateRequest) ProtoMessage() {}
+
+func (x *RevokeCertificateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_keymaster_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RevokeCertificateRequest.ProtoReflect.Descriptor instead.
+func (*RevokeCertificateRequest) Descriptor() ([]byte, []int) {
+	return file_keymaster_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RevokeCertificateRequest) GetSerial() string {
+	if x != nil {
+		return x.Serial
+	}
+	return ""
+}
+
+func (x *RevokeCertificateRequest) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type RevokeCertificateResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// False if the certificate was already revoked.
+	Revoked       bool `protobuf:"varint,1,opt,name=revoked,proto3" json:"revoked,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RevokeCertificateResponse) Reset() {
+	*x = RevokeCertificateResponse{}
+	mi := &file_keymaster_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RevokeCertificateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RevokeCertificateResponse) ProtoMessage() {}
+
+func (x *RevokeCertificateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_keymaster_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RevokeCertificateResponse.ProtoReflect.Descriptor instead.
+func (*RevokeCertificateResponse) Descriptor() ([]byte, []int) {
+	return file_keymaster_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RevokeCertificateResponse) GetRevoked() bool {
+	if x != nil {
+		return x.Revoked
+	}
+	return false
+}
+
+type ListRevokedCertificatesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRevokedCertificatesRequest) Reset() {
+	*x = ListRevokedCertificatesRequest{}
+	mi := &file_keymaster_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRevokedCertificatesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRevokedCertificatesRequest) ProtoMessage() {}
+
+func (x *ListRevokedCertificatesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_keymaster_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRevokedCertificatesRequest.ProtoReflect.Descriptor instead.
+func (*ListRevokedCertificatesRequest) Descriptor() ([]byte, []int) {
+	return file_keymaster_proto_rawDescGZIP(), []int{4}
+}
+
+type RevokedCertificate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Serial        string                 `protobuf:"bytes,1,opt,name=serial,proto3" json:"serial,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+	Reason        string                 `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RevokedCertificate) Reset() {
+	*x = RevokedCertificate{}
+	mi := &file_keymaster_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RevokedCertificate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RevokedCertificate) ProtoMessage() {}
+
+func (x *RevokedCertificate) ProtoReflect() protoreflect.Message {
+	mi := &file_keymaster_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RevokedCertificate.ProtoReflect.Descriptor instead.
+func (*RevokedCertificate) Descriptor() ([]byte, []int) {
+	return file_keymaster_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RevokedCertificate) GetSerial() string {
+	if x != nil {
+		return x.Serial
+	}
+	return ""
+}
+
+func (x *RevokedCertificate) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *RevokedCertificate) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type ListRevokedCertificatesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Certificates  []*RevokedCertificate  `protobuf:"bytes,1,rep,name=certificates,proto3" json:"certificates,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRevokedCertificatesResponse) Reset() {
+	*x = ListRevokedCertificatesResponse{}
+	mi := &file_keymaster_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRevokedCertificatesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRevokedCertificatesResponse) ProtoMessage() {}
+
+func (x *ListRevokedCertificatesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_keymaster_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRevokedCertificatesResponse.ProtoReflect.Descriptor instead.
+func (*ListRevokedCertificatesResponse) Descriptor() ([]byte, []int) {
+	return file_keymaster_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListRevokedCertificatesResponse) GetCertificates() []*RevokedCertificate {
+	if x != nil {
+		return x.Certificates
+	}
+	return nil
+}
+
+type ClearLockoutRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClearLockoutRequest) Reset() {
+	*x = ClearLockoutRequest{}
+	mi := &file_keymaster_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClearLockoutRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClearLockoutRequest) ProtoMessage() {}
+
+func (x *ClearLockoutRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_keymaster_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClearLockoutRequest.ProtoReflect.Descriptor instead.
+func (*ClearLockoutRequest) Descriptor() ([]byte, []int) {
+	return file_keymaster_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ClearLockoutRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+type ClearLockoutResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClearLockoutResponse) Reset() {
+	*x = ClearLockoutResponse{}
+	mi := &file_keymaster_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClearLockoutResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClearLockoutResponse) ProtoMessage() {}
+
+func (x *ClearLockoutResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_keymaster_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClearLockoutResponse.ProtoReflect.Descriptor instead.
+func (*ClearLockoutResponse) Descriptor() ([]byte, []int) {
+	return file_keymaster_proto_rawDescGZIP(), []int{8}
+}
+
+type TailAuditEventsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Only events of these types, e.g. issued or revoked. Default: all.
+	Types         []string `protobuf:"bytes,1,rep,name=types,proto3" json:"types,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TailAuditEventsRequest) Reset() {
+	*x = TailAuditEventsRequest{}
+	mi := &file_keymaster_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TailAuditEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TailAuditEventsRequest) ProtoMessage() {}
+
+func (x *TailAuditEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_keymaster_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TailAuditEventsRequest.ProtoReflect.Descriptor instead.
+func (*TailAuditEventsRequest) Descriptor() ([]byte, []int) {
+	return file_keymaster_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *TailAuditEventsRequest) GetTypes() []string {
+	if x != nil {
+		return x.Types
+	}
+	return nil
+}
+
+// AuditEvent is an event of the attestation log, as sent to the audit
+// stream.
+type AuditEvent struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Type        string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Time        *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+	Username    string                 `protobuf:"bytes,3,opt,name=username,proto3" json:"username,omitempty"`
+	RequestedBy string                 `protobuf:"bytes,4,opt,name=requested_by,json=requestedBy,proto3" json:"requested_by,omitempty"`
+	Policy      string                 `protobuf:"bytes,5,opt,name=policy,proto3" json:"policy,omitempty"`
+	AuthMethods []string               `protobuf:"bytes,6,rep,name=auth_methods,json=authMethods,proto3" json:"auth_methods,omitempty"`
+	Serial      string                 `protobuf:"bytes,7,opt,name=serial,proto3" json:"serial,omitempty"`
+	KeyId       string                 `protobuf:"bytes,8,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	// The host identity of the keymaster which recorded the event.
+	Instance string `protobuf:"bytes,9,opt,name=instance,proto3" json:"instance,omitempty"`
+	// The whole event as the JSON sent to the audit stream.
+	Json          []byte `protobuf:"bytes,10,opt,name=json,proto3" json:"json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuditEvent) Reset() {
+	*x = AuditEvent{}
+	mi := &file_keymaster_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuditEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuditEvent) ProtoMessage() {}
+
+func (x *AuditEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_keymaster_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuditEvent.ProtoReflect.Descriptor instead.
+func (*AuditEvent) Descriptor() ([]byte, []int) {
+	return file_keymaster_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *AuditEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *AuditEvent) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetRequestedBy() string {
+	if x != nil {
+		return x.RequestedBy
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetPolicy() string {
+	if x != nil {
+		return x.Policy
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetAuthMethods() []string {
+	if x != nil {
+		return x.AuthMethods
+	}
+	return nil
+}
+
+func (x *AuditEvent) GetSerial() string {
+	if x != nil {
+		return x.Serial
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetInstance() string {
+	if x != nil {
+		return x.Instance
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetJson() []byte {
+	if x != nil {
+		return x.Json
+	}
+	return nil
+}
+
+var File_keymaster_proto protoreflect.FileDescriptor
+
+const file_keymaster_proto_rawDesc = "" +
+	"\n" +
+	"\x0fkeymaster.proto\x12\fkeymaster.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xfc\x02\n" +
+	"\x17IssueCertificateRequest\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x1f\n" +
+	"\vpublic_keys\x18\x03 \x03(\tR\n" +
+	"publicKeys\x12\x1a\n" +
+	"\bduration\x18\x04 \x01(\tR\bduration\x12\x1d\n" +
+	"\n" +
+	"add_groups\x18\x05 \x01(\bR\taddGroups\x12\x1c\n" +
+	"\thostnames\x18\x06 \x03(\tR\thostnames\x12!\n" +
+	"\fip_addresses\x18\a \x03(\tR\vipAddresses\x12U\n" +
+	"\n" +
+	"parameters\x18\b \x03(\v25.keymaster.v1.IssueCertificateRequest.ParametersEntryR\n" +
+	"parameters\x1a=\n" +
+	"\x0fParametersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"{\n" +
+	"\x18IssueCertificateResponse\x12 \n" +
+	"\vcertificate\x18\x01 \x01(\fR\vcertificate\x12\x1a\n" +
+	"\bfilename\x18\x02 \x01(\tR\bfilename\x12!\n" +
+	"\fapproval_url\x18\x03 \x01(\tR\vapprovalUrl\"J\n" +
+	"\x18RevokeCertificateRequest\x12\x16\n" +
+	"\x06serial\x18\x01 \x01(\tR\x06serial\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\"5\n" +
+	"\x19RevokeCertificateResponse\x12\x18\n" +
+	"\arevoked\x18\x01 \x01(\bR\arevoked\" \n" +
+	"\x1eListRevokedCertificatesRequest\"t\n" +
+	"\x12RevokedCertificate\x12\x16\n" +
+	"\x06serial\x18\x01 \x01(\tR\x06serial\x12.\n" +
+	"\x04time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\"g\n" +
+	"\x1fListRevokedCertificatesResponse\x12D\n" +
+	"\fcertificates\x18\x01 \x03(\v2 .keymaster.v1.RevokedCertificateR\fcertificates\"1\n" +
+	"\x13ClearLockoutRequest\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\"\x16\n" +
+	"\x14ClearLockoutResponse\".\n" +
+	"\x16TailAuditEventsRequest\x12\x14\n" +
+	"\x05types\x18\x01 \x03(\tR\x05types\"\xa9\x02\n" +
+	"\n" +
+	"AuditEvent\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12.\n" +
+	"\x04time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1a\n" +
+	"\busername\x18\x03 \x01(\tR\busername\x12!\n" +
+	"\frequested_by\x18\x04 \x01(\tR\vrequestedBy\x12\x16\n" +
+	"\x06policy\x18\x05 \x01(\tR\x06policy\x12!\n" +
+	"\fauth_methods\x18\x06 \x03(\tR\vauthMethods\x12\x16\n" +
+	"\x06serial\x18\a \x01(\tR\x06serial\x12\x15\n" +
+	"\x06key_id\x18\b \x01(\tR\x05keyId\x12\x1a\n" +
+	"\binstance\x18\t \x01(\tR\binstance\x12\x12\n" +
+	"\x04json\x18\n" +
+	" \x01(\fR\x04json2m\n" +
+	"\bIssuance\x12a\n" +
+	"\x10IssueCertificate\x12%.keymaster.v1.IssueCertificateRequest\x1a&.keymaster.v1.IssueCertificateResponse2\x91\x03\n" +
+	"\x05Admin\x12d\n" +
+	"\x11RevokeCertificate\x12&.keymaster.v1.RevokeCertificateRequest\x1a'.keymaster.v1.RevokeCertificateResponse\x12v\n" +
+	"\x17ListRevokedCertificates\x12,.keymaster.v1.ListRevokedCertificatesRequest\x1a-.keymaster.v1.ListRevokedCertificatesResponse\x12U\n" +
+	"\fClearLockout\x12!.keymaster.v1.ClearLockoutRequest\x1a\".keymaster.v1.ClearLockoutResponse\x12S\n" +
+	"\x0fTailAuditEvents\x12$.keymaster.v1.TailAuditEventsRequest\x1a\x18.keymaster.v1.AuditEvent0\x01B1Z/github.com/Symantec/keymaster/proto/keymasterpbb\x06proto3"
+
+var (
+	file_keymaster_proto_rawDescOnce sync.Once
+	file_keymaster_proto_rawDescData []byte
+)
+
+func file_keymaster_proto_rawDescGZIP() []byte {
+	file_keymaster_proto_rawDescOnce.Do(func() {
+		file_keymaster_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_keymaster_proto_rawDesc), len(file_keymaster_proto_rawDesc)))
+	})
+	return file_keymaster_proto_rawDescData
+}
+
+var file_keymaster_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_keymaster_proto_goTypes = []any{
+	(*IssueCertificateRequest)(nil),         // 0: keymaster.v1.IssueCertificateRequest
+	(*IssueCertificateResponse)(nil),        // 1: keymaster.v1.IssueCertificateResponse
+	(*RevokeCertificateRequest)(nil),        // 2: keymaster.v1.RevokeCertificateRequest
+	(*RevokeCertificateResponse)(nil),       // 3: keymaster.v1.RevokeCertificateResponse
+	(*ListRevokedCertificatesRequest)(nil),  // 4: keymaster.v1.ListRevokedCertificatesRequest
+	(*RevokedCertificate)(nil),              // 5: keymaster.v1.RevokedCertificate
+	(*ListRevokedCertificatesResponse)(nil), // 6: keymaster.v1.ListRevokedCertificatesResponse
+	(*ClearLockoutRequest)(nil),             // 7: keymaster.v1.ClearLockoutRequest
+	(*ClearLockoutResponse)(nil),            // 8: keymaster.v1.ClearLockoutResponse
+	(*TailAuditEventsRequest)(nil),          // 9: keymaster.v1.TailAuditEventsRequest
+	(*AuditEvent)(nil),                      // 10: keymaster.v1.AuditEvent
+	nil,                                     // 11: keymaster.v1.IssueCertificateRequest.ParametersEntry
+	(*timestamppb.Timestamp)(nil),           // 12: google.protobuf.Timestamp
+}
+var file_keymaster_proto_depIdxs = []int32{
+	11, // 0: keymaster.v1.IssueCertificateRequest.parameters:type_name -> keymaster.v1.IssueCertificateRequest.ParametersEntry
+	12, // 1: keymaster.v1.RevokedCertificate.time:type_name -> google.protobuf.Timestamp
+	5,  // 2: keymaster.v1.ListRevokedCertificatesResponse.certificates:type_name -> keymaster.v1.RevokedCertificate
+	12, // 3: keymaster.v1.AuditEvent.time:type_name -> google.protobuf.Timestamp
+	0,  // 4: keymaster.v1.Issuance.IssueCertificate:input_type -> keymaster.v1.IssueCertificateRequest
+	2,  // 5: keymaster.v1.Admin.RevokeCertificate:input_type -> keymaster.v1.RevokeCertificateRequest
+	4,  // 6: keymaster.v1.Admin.ListRevokedCertificates:input_type -> keymaster.v1.ListRevokedCertificatesRequest
+	7,  // 7: keymaster.v1.Admin.ClearLockout:input_type -> keymaster.v1.ClearLockoutRequest
+	9,  // 8: keymaster.v1.Admin.TailAuditEvents:input_type -> keymaster.v1.TailAuditEventsRequest
+	1,  // 9: keymaster.v1.Issuance.IssueCertificate:output_type -> keymaster.v1.IssueCertificateResponse
+	3,  // 10: keymaster.v1.Admin.RevokeCertificate:output_type -> keymaster.v1.RevokeCertificateResponse
+	6,  // 11: keymaster.v1.Admin.ListRevokedCertificates:output_type -> keymaster.v1.ListRevokedCertificatesResponse
+	8,  // 12: keymaster.v1.Admin.ClearLockout:output_type -> keymaster.v1.ClearLockoutResponse
+	10, // 13: keymaster.v1.Admin.TailAuditEvents:output_type -> keymaster.v1.AuditEvent
+	9,  // [9:14] is the sub-list for method output_type
+	4,  // [4:9] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_keymaster_proto_init() }
+func file_keymaster_proto_init() {
+	if File_keymaster_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_keymaster_proto_rawDesc), len(file_keymaster_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   2,
+		},
+		GoTypes:           file_keymaster_proto_goTypes,
+		DependencyIndexes: file_keymaster_proto_depIdxs,
+		MessageInfos:      file_keymaster_proto_msgTypes,
+	}.Build()
+	File_keymaster_proto = out.File
+	file_keymaster_proto_goTypes = nil
+	file_keymaster_proto_depIdxs = nil
+}
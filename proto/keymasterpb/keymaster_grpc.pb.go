@@ -0,0 +1,371 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: keymaster.proto
+
+// The gRPC API of keymasterd, served with mutual TLS on grpc.address next
+// to the HTTP service. Clients authenticate with an IP restricted
+// certificate of an automation user, as for /certgen/ on the service port.
+//
+// Regenerate keymaster.pb.go and keymaster_grpc.pb.go after changes with:
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative keymaster.proto
+
+package keymasterpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Issuance_IssueCertificate_FullMethodName = "/keymaster.v1.Issuance/IssueCertificate"
+)
+
+// IssuanceClient is the client API for Issuance service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Issuance issues certificates with the policy of /certgen/.
+type IssuanceClient interface {
+	// IssueCertificate issues a certificate for the public keys in the
+	// request.
+	IssueCertificate(ctx context.Context, in *IssueCertificateRequest, opts ...grpc.CallOption) (*IssueCertificateResponse, error)
+}
+
+type issuanceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewIssuanceClient(cc grpc.ClientConnInterface) IssuanceClient {
+	return &issuanceClient{cc}
+}
+
+func (c *issuanceClient) IssueCertificate(ctx context.Context, in *IssueCertificateRequest, opts ...grpc.CallOption) (*IssueCertificateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IssueCertificateResponse)
+	err := c.cc.Invoke(ctx, Issuance_IssueCertificate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// IssuanceServer is the server API for Issuance service.
+// All implementations must embed UnimplementedIssuanceServer
+// for forward compatibility.
+//
+// Issuance issues certificates with the policy of /certgen/.
+type IssuanceServer interface {
+	// IssueCertificate issues a certificate for the public keys in the
+	// request.
+	IssueCertificate(context.Context, *IssueCertificateRequest) (*IssueCertificateResponse, error)
+	mustEmbedUnimplementedIssuanceServer()
+}
+
+// UnimplementedIssuanceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedIssuanceServer struct{}
+
+func (UnimplementedIssuanceServer) IssueCertificate(context.Context, *IssueCertificateRequest) (*IssueCertificateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method IssueCertificate not implemented")
+}
+func (UnimplementedIssuanceServer) mustEmbedUnimplementedIssuanceServer() {}
+func (UnimplementedIssuanceServer) testEmbeddedByValue()                  {}
+
+// UnsafeIssuanceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to IssuanceServer will
+// result in compilation errors.
+type UnsafeIssuanceServer interface {
+	mustEmbedUnimplementedIssuanceServer()
+}
+
+func RegisterIssuanceServer(s grpc.ServiceRegistrar, srv IssuanceServer) {
+	// If the following call pancis, it indicates UnimplementedIssuanceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Issuance_ServiceDesc, srv)
+}
+
+func _Issuance_IssueCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IssueCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IssuanceServer).IssueCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Issuance_IssueCertificate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IssuanceServer).IssueCertificate(ctx, req.(*IssueCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Issuance_ServiceDesc is the grpc.ServiceDesc for Issuance service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Issuance_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "keymaster.v1.Issuance",
+	HandlerType: (*IssuanceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "IssueCertificate",
+			Handler:    _Issuance_IssueCertificate_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "keymaster.proto",
+}
+
+const (
+	Admin_RevokeCertificate_FullMethodName       = "/keymaster.v1.Admin/RevokeCertificate"
+	Admin_ListRevokedCertificates_FullMethodName = "/keymaster.v1.Admin/ListRevokedCertificates"
+	Admin_ClearLockout_FullMethodName            = "/keymaster.v1.Admin/ClearLockout"
+	Admin_TailAuditEvents_FullMethodName         = "/keymaster.v1.Admin/TailAuditEvents"
+)
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Admin is only available to admin users.
+type AdminClient interface {
+	// RevokeCertificate revokes a certificate by serial, like the revoke-cert
+	// admin command.
+	RevokeCertificate(ctx context.Context, in *RevokeCertificateRequest, opts ...grpc.CallOption) (*RevokeCertificateResponse, error)
+	ListRevokedCertificates(ctx context.Context, in *ListRevokedCertificatesRequest, opts ...grpc.CallOption) (*ListRevokedCertificatesResponse, error)
+	// ClearLockout forgets the failed password and TOTP checks of a user.
+	ClearLockout(ctx context.Context, in *ClearLockoutRequest, opts ...grpc.CallOption) (*ClearLockoutResponse, error)
+	// TailAuditEvents streams the issuance and revocation events recorded
+	// from now on, until the client cancels.
+	TailAuditEvents(ctx context.Context, in *TailAuditEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[AuditEvent], error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) RevokeCertificate(ctx context.Context, in *RevokeCertificateRequest, opts ...grpc.CallOption) (*RevokeCertificateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RevokeCertificateResponse)
+	err := c.cc.Invoke(ctx, Admin_RevokeCertificate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListRevokedCertificates(ctx context.Context, in *ListRevokedCertificatesRequest, opts ...grpc.CallOption) (*ListRevokedCertificatesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListRevokedCertificatesResponse)
+	err := c.cc.Invoke(ctx, Admin_ListRevokedCertificates_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ClearLockout(ctx context.Context, in *ClearLockoutRequest, opts ...grpc.CallOption) (*ClearLockoutResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClearLockoutResponse)
+	err := c.cc.Invoke(ctx, Admin_ClearLockout_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) TailAuditEvents(ctx context.Context, in *TailAuditEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[AuditEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Admin_ServiceDesc.Streams[0], Admin_TailAuditEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[TailAuditEventsRequest, AuditEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Admin_TailAuditEventsClient = grpc.ServerStreamingClient[AuditEvent]
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility.
+//
+// Admin is only available to admin users.
+type AdminServer interface {
+	// RevokeCertificate revokes a certificate by serial, like the revoke-cert
+	// admin command.
+	RevokeCertificate(context.Context, *RevokeCertificateRequest) (*RevokeCertificateResponse, error)
+	ListRevokedCertificates(context.Context, *ListRevokedCertificatesRequest) (*ListRevokedCertificatesResponse, error)
+	// ClearLockout forgets the failed password and TOTP checks of a user.
+	ClearLockout(context.Context, *ClearLockoutRequest) (*ClearLockoutResponse, error)
+	// TailAuditEvents streams the issuance and revocation events recorded
+	// from now on, until the client cancels.
+	TailAuditEvents(*TailAuditEventsRequest, grpc.ServerStreamingServer[AuditEvent]) error
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAdminServer struct{}
+
+func (UnimplementedAdminServer) RevokeCertificate(context.Context, *RevokeCertificateRequest) (*RevokeCertificateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeCertificate not implemented")
+}
+func (UnimplementedAdminServer) ListRevokedCertificates(context.Context, *ListRevokedCertificatesRequest) (*ListRevokedCertificatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRevokedCertificates not implemented")
+}
+func (UnimplementedAdminServer) ClearLockout(context.Context, *ClearLockoutRequest) (*ClearLockoutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClearLockout not implemented")
+}
+func (UnimplementedAdminServer) TailAuditEvents(*TailAuditEventsRequest, grpc.ServerStreamingServer[AuditEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method TailAuditEvents not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+func (UnimplementedAdminServer) testEmbeddedByValue()               {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	// If the following call pancis, it indicates UnimplementedAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_RevokeCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RevokeCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_RevokeCertificate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RevokeCertificate(ctx, req.(*RevokeCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListRevokedCertificates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRevokedCertificatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListRevokedCertificates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListRevokedCertificates_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListRevokedCertificates(ctx, req.(*ListRevokedCertificatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ClearLockout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearLockoutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ClearLockout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ClearLockout_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ClearLockout(ctx, req.(*ClearLockoutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_TailAuditEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TailAuditEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).TailAuditEvents(m, &grpc.GenericServerStream[TailAuditEventsRequest, AuditEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Admin_TailAuditEventsServer = grpc.ServerStreamingServer[AuditEvent]
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "keymaster.v1.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RevokeCertificate",
+			Handler:    _Admin_RevokeCertificate_Handler,
+		},
+		{
+			MethodName: "ListRevokedCertificates",
+			Handler:    _Admin_ListRevokedCertificates_Handler,
+		},
+		{
+			MethodName: "ClearLockout",
+			Handler:    _Admin_ClearLockout_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "TailAuditEvents",
+			Handler:       _Admin_TailAuditEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "keymaster.proto",
+}
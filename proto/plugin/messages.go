@@ -0,0 +1,23 @@
+// Package plugin defines the gRPC protocol between keymasterd and external
+// plugin processes (see plugin.proto). Plugins are started with
+// hashicorp/go-plugin, so they may be written in any language that has a
+// go-plugin gRPC server.
+package plugin
+
+const (
+	// ProtocolVersion is the go-plugin application protocol version.
+	ProtocolVersion = 1
+
+	// keymasterd sets this environment variable when starting a plugin so
+	// that plugins can refuse to run when started by hand.
+	MagicCookieKey   = "KEYMASTER_PLUGIN_MAGIC_COOKIE"
+	MagicCookieValue = "5c7a3ef1d2b64e8c9f0a1b2c3d4e5f60"
+
+	// PluginName is the name of the go-plugin plugin served by every plugin
+	// process.
+	PluginName = "keymaster"
+
+	CapabilityAuthenticator = "authenticator"
+	CapabilityPolicy        = "policy"
+	CapabilityInventory     = "inventory"
+)
@@ -0,0 +1,489 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: plugin.proto
+
+// The protocol between keymasterd and plugin processes. keymasterd starts
+// each plugin with hashicorp/go-plugin, which hands over the address of the
+// gRPC server of the plugin in a handshake on its standard output, and then
+// calls the services below. Every plugin serves Plugin, and the services of
+// the capabilities it announces.
+//
+// Regenerate plugin.pb.go and plugin_grpc.pb.go after changes with:
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative plugin.proto
+
+package plugin
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Plugin_Capabilities_FullMethodName = "/keymaster.plugin.v1.Plugin/Capabilities"
+	Plugin_HealthCheck_FullMethodName  = "/keymaster.plugin.v1.Plugin/HealthCheck"
+)
+
+// PluginClient is the client API for Plugin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PluginClient interface {
+	// Capabilities is the first call made to a plugin.
+	Capabilities(ctx context.Context, in *CapabilitiesRequest, opts ...grpc.CallOption) (*CapabilitiesResponse, error)
+	HealthCheck(ctx context.Context, in *HealthCheckRequest, opts ...grpc.CallOption) (*HealthCheckResponse, error)
+}
+
+type pluginClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPluginClient(cc grpc.ClientConnInterface) PluginClient {
+	return &pluginClient{cc}
+}
+
+func (c *pluginClient) Capabilities(ctx context.Context, in *CapabilitiesRequest, opts ...grpc.CallOption) (*CapabilitiesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CapabilitiesResponse)
+	err := c.cc.Invoke(ctx, Plugin_Capabilities_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginClient) HealthCheck(ctx context.Context, in *HealthCheckRequest, opts ...grpc.CallOption) (*HealthCheckResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HealthCheckResponse)
+	err := c.cc.Invoke(ctx, Plugin_HealthCheck_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PluginServer is the server API for Plugin service.
+// All implementations must embed UnimplementedPluginServer
+// for forward compatibility.
+type PluginServer interface {
+	// Capabilities is the first call made to a plugin.
+	Capabilities(context.Context, *CapabilitiesRequest) (*CapabilitiesResponse, error)
+	HealthCheck(context.Context, *HealthCheckRequest) (*HealthCheckResponse, error)
+	mustEmbedUnimplementedPluginServer()
+}
+
+// UnimplementedPluginServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPluginServer struct{}
+
+func (UnimplementedPluginServer) Capabilities(context.Context, *CapabilitiesRequest) (*CapabilitiesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Capabilities not implemented")
+}
+func (UnimplementedPluginServer) HealthCheck(context.Context, *HealthCheckRequest) (*HealthCheckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HealthCheck not implemented")
+}
+func (UnimplementedPluginServer) mustEmbedUnimplementedPluginServer() {}
+func (UnimplementedPluginServer) testEmbeddedByValue()                {}
+
+// UnsafePluginServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PluginServer will
+// result in compilation errors.
+type UnsafePluginServer interface {
+	mustEmbedUnimplementedPluginServer()
+}
+
+func RegisterPluginServer(s grpc.ServiceRegistrar, srv PluginServer) {
+	// If the following call pancis, it indicates UnimplementedPluginServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Plugin_ServiceDesc, srv)
+}
+
+func _Plugin_Capabilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CapabilitiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).Capabilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_Capabilities_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).Capabilities(ctx, req.(*CapabilitiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Plugin_HealthCheck_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthCheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).HealthCheck(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Plugin_HealthCheck_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).HealthCheck(ctx, req.(*HealthCheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Plugin_ServiceDesc is the grpc.ServiceDesc for Plugin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Plugin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "keymaster.plugin.v1.Plugin",
+	HandlerType: (*PluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Capabilities",
+			Handler:    _Plugin_Capabilities_Handler,
+		},
+		{
+			MethodName: "HealthCheck",
+			Handler:    _Plugin_HealthCheck_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin.proto",
+}
+
+const (
+	Authenticator_Authenticate_FullMethodName = "/keymaster.plugin.v1.Authenticator/Authenticate"
+)
+
+// AuthenticatorClient is the client API for Authenticator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Authenticator is served by plugins which check passwords.
+type AuthenticatorClient interface {
+	Authenticate(ctx context.Context, in *AuthenticateRequest, opts ...grpc.CallOption) (*AuthenticateResponse, error)
+}
+
+type authenticatorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAuthenticatorClient(cc grpc.ClientConnInterface) AuthenticatorClient {
+	return &authenticatorClient{cc}
+}
+
+func (c *authenticatorClient) Authenticate(ctx context.Context, in *AuthenticateRequest, opts ...grpc.CallOption) (*AuthenticateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AuthenticateResponse)
+	err := c.cc.Invoke(ctx, Authenticator_Authenticate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AuthenticatorServer is the server API for Authenticator service.
+// All implementations must embed UnimplementedAuthenticatorServer
+// for forward compatibility.
+//
+// Authenticator is served by plugins which check passwords.
+type AuthenticatorServer interface {
+	Authenticate(context.Context, *AuthenticateRequest) (*AuthenticateResponse, error)
+	mustEmbedUnimplementedAuthenticatorServer()
+}
+
+// UnimplementedAuthenticatorServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAuthenticatorServer struct{}
+
+func (UnimplementedAuthenticatorServer) Authenticate(context.Context, *AuthenticateRequest) (*AuthenticateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Authenticate not implemented")
+}
+func (UnimplementedAuthenticatorServer) mustEmbedUnimplementedAuthenticatorServer() {}
+func (UnimplementedAuthenticatorServer) testEmbeddedByValue()                       {}
+
+// UnsafeAuthenticatorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AuthenticatorServer will
+// result in compilation errors.
+type UnsafeAuthenticatorServer interface {
+	mustEmbedUnimplementedAuthenticatorServer()
+}
+
+func RegisterAuthenticatorServer(s grpc.ServiceRegistrar, srv AuthenticatorServer) {
+	// If the following call pancis, it indicates UnimplementedAuthenticatorServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Authenticator_ServiceDesc, srv)
+}
+
+func _Authenticator_Authenticate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuthenticateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthenticatorServer).Authenticate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Authenticator_Authenticate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthenticatorServer).Authenticate(ctx, req.(*AuthenticateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Authenticator_ServiceDesc is the grpc.ServiceDesc for Authenticator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Authenticator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "keymaster.plugin.v1.Authenticator",
+	HandlerType: (*AuthenticatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Authenticate",
+			Handler:    _Authenticator_Authenticate_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin.proto",
+}
+
+const (
+	PolicyHook_CheckPolicy_FullMethodName = "/keymaster.plugin.v1.PolicyHook/CheckPolicy"
+)
+
+// PolicyHookClient is the client API for PolicyHook service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PolicyHook is served by plugins which approve certificate issuance.
+type PolicyHookClient interface {
+	CheckPolicy(ctx context.Context, in *PolicyRequest, opts ...grpc.CallOption) (*PolicyResponse, error)
+}
+
+type policyHookClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPolicyHookClient(cc grpc.ClientConnInterface) PolicyHookClient {
+	return &policyHookClient{cc}
+}
+
+func (c *policyHookClient) CheckPolicy(ctx context.Context, in *PolicyRequest, opts ...grpc.CallOption) (*PolicyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PolicyResponse)
+	err := c.cc.Invoke(ctx, PolicyHook_CheckPolicy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PolicyHookServer is the server API for PolicyHook service.
+// All implementations must embed UnimplementedPolicyHookServer
+// for forward compatibility.
+//
+// PolicyHook is served by plugins which approve certificate issuance.
+type PolicyHookServer interface {
+	CheckPolicy(context.Context, *PolicyRequest) (*PolicyResponse, error)
+	mustEmbedUnimplementedPolicyHookServer()
+}
+
+// UnimplementedPolicyHookServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPolicyHookServer struct{}
+
+func (UnimplementedPolicyHookServer) CheckPolicy(context.Context, *PolicyRequest) (*PolicyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckPolicy not implemented")
+}
+func (UnimplementedPolicyHookServer) mustEmbedUnimplementedPolicyHookServer() {}
+func (UnimplementedPolicyHookServer) testEmbeddedByValue()                    {}
+
+// UnsafePolicyHookServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PolicyHookServer will
+// result in compilation errors.
+type UnsafePolicyHookServer interface {
+	mustEmbedUnimplementedPolicyHookServer()
+}
+
+func RegisterPolicyHookServer(s grpc.ServiceRegistrar, srv PolicyHookServer) {
+	// If the following call pancis, it indicates UnimplementedPolicyHookServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PolicyHook_ServiceDesc, srv)
+}
+
+func _PolicyHook_CheckPolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PolicyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PolicyHookServer).CheckPolicy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PolicyHook_CheckPolicy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PolicyHookServer).CheckPolicy(ctx, req.(*PolicyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PolicyHook_ServiceDesc is the grpc.ServiceDesc for PolicyHook service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PolicyHook_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "keymaster.plugin.v1.PolicyHook",
+	HandlerType: (*PolicyHookServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CheckPolicy",
+			Handler:    _PolicyHook_CheckPolicy_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin.proto",
+}
+
+const (
+	InventoryProvider_LookupHost_FullMethodName = "/keymaster.plugin.v1.InventoryProvider/LookupHost"
+)
+
+// InventoryProviderClient is the client API for InventoryProvider service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// InventoryProvider is served by plugins which know the names of hosts.
+type InventoryProviderClient interface {
+	LookupHost(ctx context.Context, in *LookupHostRequest, opts ...grpc.CallOption) (*LookupHostResponse, error)
+}
+
+type inventoryProviderClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInventoryProviderClient(cc grpc.ClientConnInterface) InventoryProviderClient {
+	return &inventoryProviderClient{cc}
+}
+
+func (c *inventoryProviderClient) LookupHost(ctx context.Context, in *LookupHostRequest, opts ...grpc.CallOption) (*LookupHostResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LookupHostResponse)
+	err := c.cc.Invoke(ctx, InventoryProvider_LookupHost_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// InventoryProviderServer is the server API for InventoryProvider service.
+// All implementations must embed UnimplementedInventoryProviderServer
+// for forward compatibility.
+//
+// InventoryProvider is served by plugins which know the names of hosts.
+type InventoryProviderServer interface {
+	LookupHost(context.Context, *LookupHostRequest) (*LookupHostResponse, error)
+	mustEmbedUnimplementedInventoryProviderServer()
+}
+
+// UnimplementedInventoryProviderServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedInventoryProviderServer struct{}
+
+func (UnimplementedInventoryProviderServer) LookupHost(context.Context, *LookupHostRequest) (*LookupHostResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LookupHost not implemented")
+}
+func (UnimplementedInventoryProviderServer) mustEmbedUnimplementedInventoryProviderServer() {}
+func (UnimplementedInventoryProviderServer) testEmbeddedByValue()                           {}
+
+// UnsafeInventoryProviderServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InventoryProviderServer will
+// result in compilation errors.
+type UnsafeInventoryProviderServer interface {
+	mustEmbedUnimplementedInventoryProviderServer()
+}
+
+func RegisterInventoryProviderServer(s grpc.ServiceRegistrar, srv InventoryProviderServer) {
+	// If the following call pancis, it indicates UnimplementedInventoryProviderServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&InventoryProvider_ServiceDesc, srv)
+}
+
+func _InventoryProvider_LookupHost_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupHostRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InventoryProviderServer).LookupHost(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: InventoryProvider_LookupHost_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InventoryProviderServer).LookupHost(ctx, req.(*LookupHostRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// InventoryProvider_ServiceDesc is the grpc.ServiceDesc for InventoryProvider service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var InventoryProvider_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "keymaster.plugin.v1.InventoryProvider",
+	HandlerType: (*InventoryProviderServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "LookupHost",
+			Handler:    _InventoryProvider_LookupHost_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin.proto",
+}